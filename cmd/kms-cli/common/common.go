@@ -181,10 +181,14 @@ func NewAuthTokenHeader(cmd *cobra.Command) map[string]string {
 	return headers
 }
 
+// GetKMSURL returns the URL of the kms server.
+func GetKMSURL(cmd *cobra.Command) (string, error) {
+	return cmdutils.GetUserSetVarFromString(cmd, kmsURLFlagName, kmsURLEnvKey, false)
+}
+
 // GetCreateKeystorePath returns path for create keystore endpoint.
 func GetCreateKeystorePath(cmd *cobra.Command) (string, error) {
-	kmsURL, err := cmdutils.GetUserSetVarFromString(cmd, kmsURLFlagName,
-		kmsURLEnvKey, false)
+	kmsURL, err := GetKMSURL(cmd)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,134 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	cmdutils "github.com/trustbloc/edge-core/pkg/utils/cmd"
+
+	"github.com/trustbloc/kms/cmd/kms-cli/common"
+	"github.com/trustbloc/kms/pkg/conformance"
+)
+
+const (
+	metricsURLFlagName  = "metrics-url"
+	metricsURLFlagUsage = "URL to the Prometheus metrics of the kms server. The metrics check is skipped if not set. " +
+		" Alternatively, this can be set with the following environment variable: " + metricsURLEnvKey
+	metricsURLEnvKey = "KMS_CLI_METRICS_URL"
+
+	controllerFlagName  = "controller"
+	controllerFlagUsage = "Controller of the key store created by the checks. Defaults to " +
+		conformance.DefaultController + "." +
+		" Alternatively, this can be set with the following environment variable: " + controllerEnvKey
+	controllerEnvKey = "KMS_CLI_CONTROLLER"
+
+	outputFlagName  = "output"
+	outputFlagUsage = "Format of the report. Possible values [text] [json]. Defaults to text." +
+		" Alternatively, this can be set with the following environment variable: " + outputEnvKey
+	outputEnvKey = "KMS_CLI_OUTPUT"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// GetCmd returns the Cobra conformance command.
+func GetCmd() *cobra.Command {
+	conformanceCmd := conformanceCmd()
+
+	createFlags(conformanceCmd)
+
+	return conformanceCmd
+}
+
+func conformanceCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "conformance",
+		Short: "check a kms server against the documented API",
+		Long: "Check a running kms server against the documented API and report pass, fail or skip per capability. " +
+			"Capabilities the server reports as disabled are skipped. Fails if any capability fails.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kmsURL, err := common.GetKMSURL(cmd)
+			if err != nil {
+				return err
+			}
+
+			output := cmdutils.GetUserSetOptionalVarFromString(cmd, outputFlagName, outputEnvKey)
+			if output == "" {
+				output = outputText
+			}
+
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("invalid output %q, must be one of [%s %s]", output, outputText, outputJSON)
+			}
+
+			httpClient, err := common.NewHTTPClient(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := conformance.Run(cmd.Context(), &conformance.Config{
+				URL:        strings.TrimSuffix(kmsURL, "/"),
+				MetricsURL: cmdutils.GetUserSetOptionalVarFromString(cmd, metricsURLFlagName, metricsURLEnvKey),
+				AuthToken:  cmdutils.GetUserSetOptionalVarFromString(cmd, common.AuthTokenFlagName, common.AuthTokenEnvKey),
+				Controller: cmdutils.GetUserSetOptionalVarFromString(cmd, controllerFlagName, controllerEnvKey),
+				HTTPClient: httpClient,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err = writeReport(cmd.OutOrStdout(), report, output); err != nil {
+				return err
+			}
+
+			if !report.OK() {
+				return fmt.Errorf("%d of %d capabilities failed", report.Failed, len(report.Results))
+			}
+
+			return nil
+		},
+	}
+}
+
+func writeReport(w io.Writer, report *conformance.Report, output string) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(report)
+	}
+
+	for _, r := range report.Results {
+		line := fmt.Sprintf("%-4s  %s", strings.ToUpper(string(r.Status)), r.Capability)
+		if r.Message != "" {
+			line += ": " + r.Message
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "passed=%d failed=%d skipped=%d\n", report.Passed, report.Failed, report.Skipped)
+
+	return err
+}
+
+func createFlags(startCmd *cobra.Command) {
+	common.AddCommonFlags(startCmd)
+
+	startCmd.Flags().StringP(metricsURLFlagName, "", "", metricsURLFlagUsage)
+	startCmd.Flags().StringP(controllerFlagName, "", "", controllerFlagUsage)
+	startCmd.Flags().StringP(outputFlagName, "", "", outputFlagUsage)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package conformance //nolint:testpackage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/conformance"
+)
+
+func TestConformanceCmd(t *testing.T) {
+	// the server is healthy, but doesn't serve any key store endpoint
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "success", "current_time": "2022-06-01T09:00:00Z"}`)
+	})
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"disabled_operations": ["createKeyStore"]}`)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("test missing url arg", func(t *testing.T) {
+		cmd := GetCmd()
+		cmd.SetArgs([]string{})
+
+		err := cmd.Execute()
+		require.EqualError(t, err,
+			"Neither url (command line flag) nor KMS_CLI_URL (environment variable) have been set.")
+	})
+
+	t.Run("test invalid output arg", func(t *testing.T) {
+		cmd := GetCmd()
+		cmd.SetArgs([]string{"--url", srv.URL, "--output", "xml"})
+
+		err := cmd.Execute()
+		require.EqualError(t, err, `invalid output "xml", must be one of [text json]`)
+	})
+
+	t.Run("test text report", func(t *testing.T) {
+		var out bytes.Buffer
+
+		cmd := GetCmd()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"--url", srv.URL + "/"})
+
+		require.NoError(t, cmd.Execute())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		require.Len(t, lines, len(conformance.Capabilities())+1)
+		require.Equal(t, "PASS  health", lines[0])
+		require.Equal(t, "SKIP  keyStore: operation createKeyStore is disabled", lines[2])
+		require.Equal(t, "passed=2 failed=0 skipped=7", lines[len(lines)-1])
+	})
+
+	t.Run("test json report of failed capabilities", func(t *testing.T) {
+		var out bytes.Buffer
+
+		cmd := GetCmd()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"--url", srv.URL, "--output", "json", "--metrics-url", srv.URL + "/metrics"})
+
+		require.EqualError(t, cmd.Execute(), "1 of 9 capabilities failed")
+
+		var report conformance.Report
+
+		require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+		require.Equal(t, srv.URL, report.URL)
+		require.Equal(t, 1, report.Failed)
+		require.Equal(t, conformance.StatusFail, report.Results[len(report.Results)-1].Status)
+	})
+}
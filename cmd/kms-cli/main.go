@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/trustbloc/edge-core/pkg/log"
 
+	"github.com/trustbloc/kms/cmd/kms-cli/conformance"
 	"github.com/trustbloc/kms/cmd/kms-cli/createkey"
 	"github.com/trustbloc/kms/cmd/kms-cli/createkeystore"
 )
@@ -42,6 +43,7 @@ func main() {
 
 	rootCmd.AddCommand(keystore)
 	rootCmd.AddCommand(key)
+	rootCmd.AddCommand(conformance.GetCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatalf("Failed to run kms-cli: %s", err.Error())
@@ -0,0 +1,46 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package main is an example of a kms-server binary with custom storage and secret lock providers.
+//
+// Run it with the custom types selected:
+//
+//	EXAMPLE_KMS_PRIMARY_KEY=<base64url-encoded 32 bytes key> customprovider start \
+//	    --database-type example --secret-lock-type example-env ...
+package main
+
+import (
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/spf13/cobra"
+
+	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
+)
+
+var logger = log.New("kms-server-example")
+
+func main() {
+	if err := registerProviders(); err != nil {
+		logger.Fatalf("Failed to register providers: %v", err)
+	}
+
+	rootCmd := &cobra.Command{
+		Use: "customprovider",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.HelpFunc()(cmd, args)
+		},
+	}
+
+	startCmd, err := startcmd.Cmd(&startcmd.HTTPServer{})
+	if err != nil {
+		logger.Fatalf(err.Error())
+	}
+
+	rootCmd.AddCommand(startCmd)
+
+	if err := rootCmd.Execute(); err != nil {
+		logger.Fatalf("Failed to run kms-server: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/local"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
+)
+
+const (
+	storageType    = "example"
+	secretLockType = "example-env"
+
+	primaryKeyEnvKey = "EXAMPLE_KMS_PRIMARY_KEY" //nolint:gosec // not hard-coded credentials
+)
+
+func registerProviders() error {
+	if err := startcmd.RegisterStorageProvider(storageType, newStorageProvider); err != nil {
+		return fmt.Errorf("register storage provider: %w", err)
+	}
+
+	if err := startcmd.RegisterSecretLock(secretLockType, newEnvSecretLock); err != nil {
+		return fmt.Errorf("register secret lock: %w", err)
+	}
+
+	return nil
+}
+
+// newStorageProvider stands in for a client of a proprietary database. A real implementation connects to url,
+// prefixes names of opened stores with prefix, and returns a provider that is safe for concurrent use.
+func newStorageProvider(url, prefix string) (storage.Provider, error) {
+	logger.Infof("Connecting to example storage at %q with prefix %q", url, prefix)
+
+	return mem.NewProvider(), nil
+}
+
+// newEnvSecretLock creates a local secret lock with a primary key read from an environment variable instead of
+// the --secret-lock-key-path file.
+func newEnvSecretLock(_ *startcmd.SecretLockParameters) (secretlock.Service, error) {
+	key := os.Getenv(primaryKeyEnvKey)
+	if key == "" {
+		return nil, fmt.Errorf("%s is not set", primaryKeyEnvKey)
+	}
+
+	secretLock, err := local.NewService(bytes.NewReader([]byte(key)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create local secret lock: %w", err)
+	}
+
+	return secretLock, nil
+}
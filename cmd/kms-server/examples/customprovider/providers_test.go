@@ -0,0 +1,108 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
+)
+
+type mockServer struct{}
+
+func (s *mockServer) ListenAndServe(string, string, string, http.Handler) error {
+	return nil
+}
+
+func (s *mockServer) ListenAndServeMTLS(string, string, string, *x509.CertPool, http.Handler) error {
+	return nil
+}
+
+func TestRegisterProviders(t *testing.T) {
+	require.NoError(t, registerProviders())
+
+	err := registerProviders()
+	require.ErrorIs(t, err, startcmd.ErrProviderRegistered)
+
+	t.Setenv(primaryKeyEnvKey, primaryKey(t))
+
+	startCmd, err := startcmd.Cmd(&mockServer{})
+	require.NoError(t, err)
+
+	startCmd.SetArgs([]string{
+		"--host", "localhost:8080",
+		"--database-type", storageType,
+		"--database-url", "example://localhost",
+		"--secret-lock-type", secretLockType,
+		"--gnap-signing-key", gnapSigningKeyFile(t),
+	})
+
+	require.NoError(t, startCmd.Execute())
+}
+
+func TestNewEnvSecretLock(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		t.Setenv(primaryKeyEnvKey, primaryKey(t))
+
+		lock, err := newEnvSecretLock(&startcmd.SecretLockParameters{})
+		require.NoError(t, err)
+
+		enc, err := lock.Encrypt("", &secretlock.EncryptRequest{Plaintext: "secret"})
+		require.NoError(t, err)
+
+		dec, err := lock.Decrypt("", &secretlock.DecryptRequest{Ciphertext: enc.Ciphertext})
+		require.NoError(t, err)
+		require.Equal(t, "secret", dec.Plaintext)
+	})
+
+	t.Run("Fail with missing key", func(t *testing.T) {
+		t.Setenv(primaryKeyEnvKey, "")
+
+		_, err := newEnvSecretLock(&startcmd.SecretLockParameters{})
+		require.EqualError(t, err, "EXAMPLE_KMS_PRIMARY_KEY is not set")
+	})
+}
+
+func primaryKey(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, sha256.Size)
+
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+
+	return base64.URLEncoding.EncodeToString(key)
+}
+
+func gnapSigningKeyFile(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "gnap-priv-key.pem")
+
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+
+	return path
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/hyperledger/aries-framework-go/pkg/common/log"
 	"github.com/spf13/cobra"
 
+	"github.com/trustbloc/kms/cmd/kms-server/reconcilecmd"
 	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
 )
 
@@ -42,6 +43,11 @@ func main() {
 	}
 
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(reconcilecmd.Cmd())
+	rootCmd.AddCommand(startcmd.RepairCmd())
+	rootCmd.AddCommand(startcmd.ReconcileEDVCmd())
+	rootCmd.AddCommand(startcmd.BackfillFingerprintsCmd())
+	rootCmd.AddCommand(startcmd.MigrateCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		logger.Fatalf("Failed to run kms-server: %v", err)
@@ -0,0 +1,139 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package reconcilecmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	tlsutil "github.com/trustbloc/edge-core/pkg/utils/tls"
+
+	"github.com/trustbloc/kms/pkg/replication"
+)
+
+const (
+	commonEnvVarUsageText = "Alternatively, this can be set with the following environment variable: "
+
+	primaryURLEnvKey    = "KMS_RECONCILE_PRIMARY_URL"
+	primaryURLFlagName  = "primary-url"
+	primaryURLFlagUsage = "The base URL of the primary kms-server. " + commonEnvVarUsageText + primaryURLEnvKey
+
+	standbyURLEnvKey    = "KMS_RECONCILE_STANDBY_URL"
+	standbyURLFlagName  = "standby-url"
+	standbyURLFlagUsage = "The base URL of the standby kms-server. " + commonEnvVarUsageText + standbyURLEnvKey
+
+	tokenEnvKey    = "KMS_REPLICATION_TOKEN" //nolint:gosec // not hard-coded credentials
+	tokenFlagName  = "replication-token"
+	tokenFlagUsage = "The replication token configured on both servers. " + commonEnvVarUsageText + tokenEnvKey
+
+	tlsCACertsEnvKey    = "KMS_TLS_CACERTS"
+	tlsCACertsFlagName  = "tls-cacerts"
+	tlsCACertsFlagUsage = "Comma-separated list of CA certs path. " + commonEnvVarUsageText + tlsCACertsEnvKey
+
+	requestTimeout = time.Minute
+)
+
+// Cmd returns the Cobra reconcile command. It compares digests of replicated stores on the primary and the standby
+// and fails if they differ.
+func Cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Compares replicated data of the primary and the standby",
+		Long:  "Compares record counts and hashes of replicated stores on the primary and the standby kms-server",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			primaryURL, err := getUserSetVar(cmd, primaryURLFlagName, primaryURLEnvKey)
+			if err != nil {
+				return err
+			}
+
+			standbyURL, err := getUserSetVar(cmd, standbyURLFlagName, standbyURLEnvKey)
+			if err != nil {
+				return err
+			}
+
+			token, err := getUserSetVar(cmd, tokenFlagName, tokenEnvKey)
+			if err != nil {
+				return err
+			}
+
+			var caCerts []string
+
+			if v, _ := getUserSetVar(cmd, tlsCACertsFlagName, tlsCACertsEnvKey); v != "" { //nolint:errcheck
+				caCerts = strings.Split(v, ",")
+			}
+
+			rootCAs, err := tlsutil.GetCertPool(true, caCerts)
+			if err != nil {
+				return fmt.Errorf("get cert pool: %w", err)
+			}
+
+			client := &http.Client{
+				Timeout: requestTimeout,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+				},
+			}
+
+			return reconcile(cmd, client, primaryURL, standbyURL, token)
+		},
+	}
+
+	cmd.Flags().String(primaryURLFlagName, "", primaryURLFlagUsage)
+	cmd.Flags().String(standbyURLFlagName, "", standbyURLFlagUsage)
+	cmd.Flags().String(tokenFlagName, "", tokenFlagUsage)
+	cmd.Flags().String(tlsCACertsFlagName, "", tlsCACertsFlagUsage)
+
+	return cmd
+}
+
+func reconcile(cmd *cobra.Command, client *http.Client, primaryURL, standbyURL, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	primary, err := replication.FetchDigest(ctx, client, primaryURL, token)
+	if err != nil {
+		return fmt.Errorf("fetch primary digest: %w", err)
+	}
+
+	standby, err := replication.FetchDigest(ctx, client, standbyURL, token)
+	if err != nil {
+		return fmt.Errorf("fetch standby digest: %w", err)
+	}
+
+	diffs := replication.Compare(primary, standby)
+
+	for _, d := range diffs {
+		cmd.Println(d.String())
+	}
+
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d replicated stores differ", len(diffs))
+	}
+
+	cmd.Println("Replicated stores are in sync")
+
+	return nil
+}
+
+func getUserSetVar(cmd *cobra.Command, flagName, envKey string) (string, error) {
+	if cmd.Flags().Changed(flagName) {
+		return cmd.Flags().GetString(flagName) //nolint:wrapcheck
+	}
+
+	if value, isSet := os.LookupEnv(envKey); isSet {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("neither %s (command line flag) nor %s (environment variable) have been set",
+		flagName, envKey)
+}
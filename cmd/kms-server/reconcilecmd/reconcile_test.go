@@ -0,0 +1,105 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package reconcilecmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/replication"
+)
+
+func digestServer(t *testing.T, d *replication.Digest) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, replication.DigestPath, r.URL.Path)
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewEncoder(w).Encode(d))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestCmd(t *testing.T) {
+	inSync := &replication.Digest{Stores: map[string]*replication.StoreDigest{
+		"kmsdb": {Count: 1, Hash: "a"},
+	}}
+
+	t.Run("Success", func(t *testing.T) {
+		primary, standby := digestServer(t, inSync), digestServer(t, inSync)
+
+		var out bytes.Buffer
+
+		cmd := Cmd()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{
+			"--" + primaryURLFlagName, primary.URL,
+			"--" + standbyURLFlagName, standby.URL,
+			"--" + tokenFlagName, "token",
+		})
+
+		require.NoError(t, cmd.Execute())
+		require.Contains(t, out.String(), "Replicated stores are in sync")
+	})
+
+	t.Run("Fail with different digests", func(t *testing.T) {
+		primary := digestServer(t, inSync)
+		standby := digestServer(t, &replication.Digest{Stores: map[string]*replication.StoreDigest{
+			"kmsdb": {Count: 0, Hash: "b"},
+		}})
+
+		var out bytes.Buffer
+
+		cmd := Cmd()
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs([]string{
+			"--" + primaryURLFlagName, primary.URL,
+			"--" + standbyURLFlagName, standby.URL,
+			"--" + tokenFlagName, "token",
+		})
+
+		require.EqualError(t, cmd.Execute(), "1 replicated stores differ")
+		require.Contains(t, out.String(), "kmsdb: primary 1 records (a), standby 0 records (b)")
+	})
+
+	t.Run("Fail with missing primary url", func(t *testing.T) {
+		cmd := Cmd()
+		cmd.SetArgs([]string{})
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "neither primary-url (command line flag) nor KMS_RECONCILE_PRIMARY_URL")
+	})
+
+	t.Run("Fail with unreachable standby", func(t *testing.T) {
+		primary := digestServer(t, inSync)
+
+		cmd := Cmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs([]string{
+			"--" + primaryURLFlagName, primary.URL,
+			"--" + standbyURLFlagName, "http://localhost:0",
+			"--" + tokenFlagName, "token",
+		})
+
+		err := cmd.Execute()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "fetch standby digest")
+	})
+}
@@ -0,0 +1,80 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+)
+
+const (
+	backfillBatchSizeFlagName  = "batch-size"
+	backfillBatchSizeFlagUsage = "Number of key stores processed between checkpoints."
+	backfillBatchSizeDefault   = 100
+)
+
+// BackfillFingerprintsCmd returns the Cobra backfill-fingerprints command. It stores fingerprints of public keys in
+// metadata of keys that were added before fingerprints were stored.
+func BackfillFingerprintsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backfill-fingerprints",
+		Short: "Stores fingerprints of existing public keys in key metadata",
+		Long: "Computes did:key fingerprints of public keys that don't have them in key metadata yet, and stores " +
+			"them. Key stores are processed in order of their IDs, and progress is saved after every batch, so an " +
+			"interrupted run resumes where it stopped. Safe to run while kms-server is running. Uses the database, " +
+			"secret lock and TLS settings of kms-server.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			batchSize, err := cmd.Flags().GetInt(backfillBatchSizeFlagName)
+			if err != nil || batchSize <= 0 {
+				return fmt.Errorf("%s (command line flag) must be a positive number", backfillBatchSizeFlagName)
+			}
+
+			// EDV-backed key stores are read from their vaults, like with reconcile-edv
+			c, err := createReconcileEDVCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := c.BackfillFingerprints(batchSize, func(report *command.BackfillReport) {
+				cmd.Printf("Processed key stores up to %s: %s\n", report.Cursor, backfillCounts(report))
+			})
+			if err != nil {
+				return fmt.Errorf("backfill fingerprints: %w", err)
+			}
+
+			cmd.Printf("Backfill complete, %d key stores processed: %s\n", report.KeyStores, backfillCounts(report))
+
+			if report.Errors > 0 {
+				return fmt.Errorf("%d errors, see the log for details", report.Errors)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int(backfillBatchSizeFlagName, backfillBatchSizeDefault, backfillBatchSizeFlagUsage)
+	cmd.Flags().String(databaseTypeFlagName, "", databaseTypeFlagUsage)
+	cmd.Flags().String(databaseURLFlagName, "", databaseURLFlagUsage)
+	cmd.Flags().String(databasePrefixFlagName, "", databasePrefixFlagUsage)
+	cmd.Flags().String(databaseTimeoutFlagName, "30s", databaseTimeoutFlagUsage)
+	cmd.Flags().String(secretLockTypeFlagName, "", secretLockTypeFlagUsage)
+	cmd.Flags().String(secretLockKeyPathFlagName, "", secretLockKeyPathFlagUsage)
+	cmd.Flags().String(secretLockAWSKeyURIFlagName, "", secretLockAWSKeyURIFlagUsage)
+	cmd.Flags().String(secretLockAWSEndpointFlagName, "", secretLockAWSEndpointFlagUsage)
+	cmd.Flags().String(tlsSystemCertPoolFlagName, "false", tlsSystemCertPoolFlagUsage)
+	cmd.Flags().String(tlsCACertsFlagName, "", tlsCACertsFlagUsage)
+
+	return cmd
+}
+
+func backfillCounts(report *command.BackfillReport) string {
+	return fmt.Sprintf("%d keys updated, %d skipped, %d unsupported, %d errors",
+		report.Updated, report.Skipped, report.Unsupported, report.Errors)
+}
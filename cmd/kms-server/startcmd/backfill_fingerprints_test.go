@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const backfillStorageType = "backfill-mem"
+
+func TestBackfillFingerprintsCmd(t *testing.T) {
+	store := mem.NewProvider()
+
+	require.NoError(t, RegisterStorageProvider(backfillStorageType, func(string, string) (storage.Provider, error) {
+		return store, nil
+	}))
+
+	args := func(extra ...string) []string {
+		return append([]string{
+			"--" + databaseTypeFlagName, backfillStorageType,
+			"--" + secretLockTypeFlagName, secretLockTypeLocalOption,
+			"--" + secretLockKeyPathFlagName, secretLockKeyFile,
+		}, extra...)
+	}
+
+	t.Run("No key stores", func(t *testing.T) {
+		out, err := executeBackfillFingerprintsCmd(args())
+		require.NoError(t, err)
+		require.Equal(t, "Backfill complete, 0 key stores processed: 0 keys updated, 0 skipped, 0 unsupported, "+
+			"0 errors\n", out)
+	})
+
+	t.Run("Key store that can't be read is an error", func(t *testing.T) {
+		keyStores, err := store.OpenStore("keystores")
+		require.NoError(t, err)
+
+		require.NoError(t, keyStores.Put("local", []byte(`{"id":"local","controller":"did:example:controller",`+
+			`"keys":{"key":{"key_type":"ED25519"}}}`), storage.Tag{Name: "controller_hash", Value: "hash"}))
+
+		out, err := executeBackfillFingerprintsCmd(args("--"+backfillBatchSizeFlagName, "1"))
+		require.EqualError(t, err, "1 errors, see the log for details")
+		require.Contains(t, out, "Processed key stores up to local: 0 keys updated, 0 skipped, 0 unsupported, 1 errors")
+	})
+
+	t.Run("Fail with invalid batch size", func(t *testing.T) {
+		_, err := executeBackfillFingerprintsCmd(args("--"+backfillBatchSizeFlagName, "0"))
+		require.EqualError(t, err, "batch-size (command line flag) must be a positive number")
+	})
+
+	t.Run("Fail with missing database type", func(t *testing.T) {
+		_, err := executeBackfillFingerprintsCmd(nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "database-type")
+	})
+}
+
+func executeBackfillFingerprintsCmd(args []string) (string, error) {
+	var out bytes.Buffer
+
+	cmd := BackfillFingerprintsCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
@@ -0,0 +1,85 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
+	"github.com/trustbloc/kms/pkg/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	newServer := func(t *testing.T, args ...string) *httptest.Server {
+		t.Helper()
+
+		params, err := startcmd.ParseParameters(append([]string{
+			"--database-type", "mem",
+			"--secret-lock-type", "local",
+			"--secret-lock-key-path", secretLockKey(t.TempDir()),
+			"--disable-auth", "true",
+		}, args...))
+		require.NoError(t, err)
+
+		kms, err := startcmd.New(params)
+		require.NoError(t, err)
+
+		t.Cleanup(kms.Close)
+
+		mux := http.NewServeMux()
+		mux.Handle("/", kms.Handler)
+		mux.Handle("/metrics", promhttp.Handler())
+
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+
+		return srv
+	}
+
+	statuses := func(report *conformance.Report) map[string]conformance.Status {
+		m := make(map[string]conformance.Status)
+
+		for _, r := range report.Results {
+			m[r.Capability] = r.Status
+		}
+
+		return m
+	}
+
+	t.Run("All capabilities pass", func(t *testing.T) {
+		srv := newServer(t)
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{
+			URL:        srv.URL,
+			MetricsURL: srv.URL + "/metrics",
+		})
+		require.NoError(t, err)
+		require.True(t, report.OK(), "%+v", report.Results)
+		require.Equal(t, len(conformance.Capabilities()), report.Passed)
+	})
+
+	t.Run("Disabled operations are skipped", func(t *testing.T) {
+		srv := newServer(t, "--disabled-operations", "rotateKey,createKeys")
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{URL: srv.URL})
+		require.NoError(t, err)
+		require.True(t, report.OK(), "%+v", report.Results)
+		require.Equal(t, 3, report.Skipped)
+
+		s := statuses(report)
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityRotation])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityBatch])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityMetrics])
+		require.Equal(t, conformance.StatusPass, s[conformance.CapabilitySignVerify])
+	})
+}
@@ -0,0 +1,136 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/trustbloc/kms/cmd/kms-server/startcmd"
+	"github.com/trustbloc/kms/pkg/controller/command"
+)
+
+// This example embeds the KMS in another service: the handler is mounted under the service's own mux and the smoke
+// test sequence runs against it.
+func ExampleNew() {
+	dir, err := os.MkdirTemp("", "kms")
+	if err != nil {
+		panic(err)
+	}
+
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	params, err := startcmd.ParseParameters([]string{
+		"--database-type", "mem",
+		"--secret-lock-type", "local",
+		"--secret-lock-key-path", secretLockKey(dir),
+		"--disable-auth", "true",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	kms, err := startcmd.New(params)
+	if err != nil {
+		panic(err)
+	}
+
+	defer kms.Close()
+
+	// key stores and keys are returned with URLs relative to --base-url, which is empty
+	mux := http.NewServeMux()
+	mux.Handle("/", kms.Handler)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	status, _ := call(srv.URL+"/healthcheck", http.MethodGet, nil, nil)
+	fmt.Println("healthcheck:", status)
+
+	var keyStore command.CreateKeyStoreResponse
+
+	status, _ = call(srv.URL+"/v1/keystores", http.MethodPost,
+		command.CreateKeyStoreRequest{Controller: "did:example:controller"}, &keyStore)
+	fmt.Println("create key store:", status)
+
+	var key command.CreateKeyResponse
+
+	status, _ = call(srv.URL+keyStore.KeyStoreURL+"/keys", http.MethodPost,
+		command.CreateKeyRequest{KeyType: "ED25519"}, &key)
+	fmt.Println("create key:", status)
+
+	var sig command.SignResponse
+
+	status, _ = call(srv.URL+key.KeyURL+"/sign", http.MethodPost,
+		command.SignRequest{Message: []byte("test message")}, &sig)
+	fmt.Println("sign:", status)
+
+	status, _ = call(srv.URL+key.KeyURL+"/verify", http.MethodPost,
+		command.VerifyRequest{Signature: sig.Signature, Message: []byte("test message")}, nil)
+	fmt.Println("verify:", status)
+
+	// Output:
+	// healthcheck: 200
+	// create key store: 200
+	// create key: 200
+	// sign: 200
+	// verify: 200
+}
+
+func call(url, method string, req, resp interface{}) (int, error) {
+	var body bytes.Buffer
+
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return 0, err
+		}
+	}
+
+	r, err := http.NewRequest(method, url, &body) //nolint:noctx
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, err
+	}
+
+	defer res.Body.Close() //nolint:errcheck
+
+	if resp != nil && res.StatusCode < http.StatusMultipleChoices {
+		if err = json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return 0, err
+		}
+	}
+
+	return res.StatusCode, nil
+}
+
+// secretLockKey writes a key of the local secret lock to the directory and returns its path.
+func secretLockKey(dir string) string {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+
+	path := filepath.Join(dir, "secret-lock.key")
+
+	if err := os.WriteFile(path, []byte(base64.URLEncoding.EncodeToString(key)), 0o600); err != nil {
+		panic(err)
+	}
+
+	return path
+}
@@ -0,0 +1,167 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/spf13/cobra"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+)
+
+const (
+	migrateSourceDatabaseTypeEnvKey    = "KMS_MIGRATE_SOURCE_DATABASE_TYPE"
+	migrateSourceDatabaseTypeFlagName  = "source-database-type"
+	migrateSourceDatabaseTypeFlagUsage = "The type of database to copy key stores from. Supported options: " +
+		"mem, couchdb, mongodb, postgres, or a type registered with RegisterStorageProvider. " +
+		commonEnvVarUsageText + migrateSourceDatabaseTypeEnvKey
+
+	migrateSourceDatabaseURLEnvKey    = "KMS_MIGRATE_SOURCE_DATABASE_URL"
+	migrateSourceDatabaseURLFlagName  = "source-database-url"
+	migrateSourceDatabaseURLFlagUsage = "The URL of the source database. " +
+		commonEnvVarUsageText + migrateSourceDatabaseURLEnvKey
+
+	migrateSourceDatabasePrefixEnvKey    = "KMS_MIGRATE_SOURCE_DATABASE_PREFIX"
+	migrateSourceDatabasePrefixFlagName  = "source-database-prefix"
+	migrateSourceDatabasePrefixFlagUsage = "The prefix of the source database. " +
+		commonEnvVarUsageText + migrateSourceDatabasePrefixEnvKey
+
+	migrateDestinationDatabaseTypeEnvKey    = "KMS_MIGRATE_DESTINATION_DATABASE_TYPE"
+	migrateDestinationDatabaseTypeFlagName  = "destination-database-type"
+	migrateDestinationDatabaseTypeFlagUsage = "The type of database to copy key stores to. Supported options: " +
+		"mem, couchdb, mongodb, postgres, or a type registered with RegisterStorageProvider. " +
+		commonEnvVarUsageText + migrateDestinationDatabaseTypeEnvKey
+
+	migrateDestinationDatabaseURLEnvKey    = "KMS_MIGRATE_DESTINATION_DATABASE_URL"
+	migrateDestinationDatabaseURLFlagName  = "destination-database-url"
+	migrateDestinationDatabaseURLFlagUsage = "The URL of the destination database. " +
+		commonEnvVarUsageText + migrateDestinationDatabaseURLEnvKey
+
+	migrateDestinationDatabasePrefixEnvKey    = "KMS_MIGRATE_DESTINATION_DATABASE_PREFIX"
+	migrateDestinationDatabasePrefixFlagName  = "destination-database-prefix"
+	migrateDestinationDatabasePrefixFlagUsage = "The prefix of the destination database. " +
+		commonEnvVarUsageText + migrateDestinationDatabasePrefixEnvKey
+
+	migrateDryRunFlagName  = "dry-run"
+	migrateDryRunFlagUsage = "Counts the records to copy without writing to the destination database."
+
+	migrateProgressIntervalFlagName  = "progress-interval"
+	migrateProgressIntervalFlagUsage = "Number of records copied between progress reports."
+)
+
+// MigrateCmd returns the Cobra migrate command. It copies key stores from one database of kms-server to another,
+// e.g. to move a deployment from MongoDB to CouchDB.
+func MigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copies key stores to another database",
+		Long: "Copies metadata and keys of key stores from the source database to the destination database, then " +
+			"checks that every copied record is found in the destination. Keys are copied as stored, encrypted " +
+			"with the secret lock, so kms-server must use the same secret lock with the destination database. " +
+			"Stop kms-server before the migration, as changes made while it runs may not be copied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dryRun, err := cmd.Flags().GetBool(migrateDryRunFlagName)
+			if err != nil {
+				return fmt.Errorf("parse dry run: %w", err)
+			}
+
+			progressInterval, err := cmd.Flags().GetInt(migrateProgressIntervalFlagName)
+			if err != nil || progressInterval <= 0 {
+				return fmt.Errorf("%s (command line flag) must be a positive number", migrateProgressIntervalFlagName)
+			}
+
+			src, dst, err := createMigrateStoreProviders(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := command.MigrateKeyStores(src, dst, &command.MigrateOptions{
+				DryRun:           dryRun,
+				ProgressInterval: progressInterval,
+				Progress: func(report *command.MigrateReport) {
+					cmd.Printf("Processed %d records of %d key stores\n", report.Records, report.KeyStores)
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("migrate key stores: %w", err)
+			}
+
+			for _, missing := range report.Missing {
+				cmd.Printf("Not found in the source: %s\n", missing)
+			}
+
+			if dryRun {
+				cmd.Printf("Dry run, %d records of %d key stores would be copied\n", report.Records, report.KeyStores)
+
+				return nil
+			}
+
+			cmd.Printf("Migration complete, %d records of %d key stores copied and verified\n",
+				report.Verified, report.KeyStores)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String(migrateSourceDatabaseTypeFlagName, "", migrateSourceDatabaseTypeFlagUsage)
+	cmd.Flags().String(migrateSourceDatabaseURLFlagName, "", migrateSourceDatabaseURLFlagUsage)
+	cmd.Flags().String(migrateSourceDatabasePrefixFlagName, "", migrateSourceDatabasePrefixFlagUsage)
+	cmd.Flags().String(migrateDestinationDatabaseTypeFlagName, "", migrateDestinationDatabaseTypeFlagUsage)
+	cmd.Flags().String(migrateDestinationDatabaseURLFlagName, "", migrateDestinationDatabaseURLFlagUsage)
+	cmd.Flags().String(migrateDestinationDatabasePrefixFlagName, "", migrateDestinationDatabasePrefixFlagUsage)
+	cmd.Flags().String(databaseTimeoutFlagName, "30s", databaseTimeoutFlagUsage)
+	cmd.Flags().Bool(migrateDryRunFlagName, false, migrateDryRunFlagUsage)
+	cmd.Flags().Int(migrateProgressIntervalFlagName, command.DefaultMigrateProgressInterval,
+		migrateProgressIntervalFlagUsage)
+
+	return cmd
+}
+
+func createMigrateStoreProviders(cmd *cobra.Command) (storage.Provider, storage.Provider, error) {
+	srcType, err := getUserSetVar(cmd, migrateSourceDatabaseTypeFlagName, migrateSourceDatabaseTypeEnvKey, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dstType, err := getUserSetVar(cmd, migrateDestinationDatabaseTypeFlagName,
+		migrateDestinationDatabaseTypeEnvKey, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srcURL := getUserSetVarOptional(cmd, migrateSourceDatabaseURLFlagName, migrateSourceDatabaseURLEnvKey)
+	srcPrefix := getUserSetVarOptional(cmd, migrateSourceDatabasePrefixFlagName, migrateSourceDatabasePrefixEnvKey)
+	dstURL := getUserSetVarOptional(cmd, migrateDestinationDatabaseURLFlagName, migrateDestinationDatabaseURLEnvKey)
+	dstPrefix := getUserSetVarOptional(cmd, migrateDestinationDatabasePrefixFlagName,
+		migrateDestinationDatabasePrefixEnvKey)
+
+	if srcType == dstType && srcURL == dstURL && srcPrefix == dstPrefix {
+		return nil, nil, errors.New("source and destination databases must differ")
+	}
+
+	databaseTimeout, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, databaseTimeoutFlagName, databaseTimeoutEnvKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse database timeout: %w", err)
+	}
+
+	src, err := createStoreProvider(srcType, srcURL, srcPrefix, databaseTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create source store provider: %w", err)
+	}
+
+	dst, err := createStoreProvider(dstType, dstURL, dstPrefix, databaseTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create destination store provider: %w", err)
+	}
+
+	return src, dst, nil
+}
@@ -0,0 +1,191 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	migrateSourceStorageType      = "migrate-src"
+	migrateDestinationStorageType = "migrate-dst"
+	migrateLossyStorageType       = "migrate-lossy"
+)
+
+func TestMigrateCmd(t *testing.T) {
+	src, dst := mem.NewProvider(), mem.NewProvider()
+
+	for name, provider := range map[string]storage.Provider{
+		migrateSourceStorageType:      src,
+		migrateDestinationStorageType: dst,
+		migrateLossyStorageType:       &lossyProvider{Provider: mem.NewProvider()},
+	} {
+		provider := provider
+
+		require.NoError(t, RegisterStorageProvider(name, func(string, string) (storage.Provider, error) {
+			return provider, nil
+		}))
+	}
+
+	keyStoreID, keyIDs := createRepairKeyStore(t, migrateSourceStorageType)
+
+	args := func(destination string, extra ...string) []string {
+		return append([]string{
+			"--" + migrateSourceDatabaseTypeFlagName, migrateSourceStorageType,
+			"--" + migrateDestinationDatabaseTypeFlagName, destination,
+		}, extra...)
+	}
+
+	t.Run("Dry run counts records without copying them", func(t *testing.T) {
+		out, err := executeMigrateCmd(args(migrateDestinationStorageType, "--"+migrateDryRunFlagName))
+		require.NoError(t, err)
+		require.Contains(t, out, "Dry run, 4 records of 1 key stores would be copied") // metadata, main key, 2 keys
+
+		keyStores, err := dst.OpenStore("keystores")
+		require.NoError(t, err)
+
+		_, err = keyStores.Get(keyStoreID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Records are copied verbatim", func(t *testing.T) {
+		out, err := executeMigrateCmd(args(migrateDestinationStorageType, "--"+migrateProgressIntervalFlagName, "1"))
+		require.NoError(t, err)
+		require.Contains(t, out, "Processed 1 records of 1 key stores")
+		require.Contains(t, out, "Processed 4 records of 1 key stores")
+		require.Contains(t, out, "Migration complete, 4 records of 1 key stores copied and verified")
+
+		requireSameRecord(t, src, dst, "keystores", keyStoreID)
+
+		for _, keyID := range keyIDs {
+			requireSameRecord(t, src, dst, localkms.Namespace, prefix.StorageKIDPrefix+keyID)
+		}
+
+		config, err := dst.GetStoreConfig("keystores")
+		require.NoError(t, err)
+		require.Contains(t, config.TagNames, "controller_hash")
+
+		// the keys are decrypted with the secret lock of the source
+		out, err = executeRepairCmd([]string{
+			"--" + databaseTypeFlagName, migrateDestinationStorageType,
+			"--" + secretLockTypeFlagName, secretLockTypeLocalOption,
+			"--" + secretLockKeyPathFlagName, secretLockKeyFile,
+			"--" + repairKeyStoreFlagName, keyStoreID,
+		})
+		require.NoError(t, err)
+		require.Contains(t, out, "Key store "+keyStoreID+" has no problems")
+
+		out, err = executeMigrateCmd(args(migrateDestinationStorageType))
+		require.NoError(t, err)
+		require.Contains(t, out, "Migration complete, 4 records of 1 key stores copied and verified")
+	})
+
+	t.Run("Fail if copied records are not found in the destination", func(t *testing.T) {
+		_, err := executeMigrateCmd(args(migrateLossyStorageType))
+		require.EqualError(t, err, "migrate key stores: verify destination: 1 of 4 copied records found")
+	})
+
+	t.Run("Missing keys are reported", func(t *testing.T) {
+		keys, err := src.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
+
+		require.NoError(t, keys.Delete(prefix.StorageKIDPrefix+keyIDs[0]))
+
+		out, err := executeMigrateCmd(args(migrateDestinationStorageType))
+		require.NoError(t, err)
+		require.Contains(t, out, "Not found in the source: key store "+keyStoreID+": key "+keyIDs[0])
+		require.Contains(t, out, "Migration complete, 3 records of 1 key stores copied and verified")
+	})
+
+	t.Run("Fail with the same source and destination", func(t *testing.T) {
+		_, err := executeMigrateCmd(args(migrateSourceStorageType))
+		require.EqualError(t, err, "source and destination databases must differ")
+	})
+
+	t.Run("Fail with missing destination database type", func(t *testing.T) {
+		_, err := executeMigrateCmd([]string{"--" + migrateSourceDatabaseTypeFlagName, migrateSourceStorageType})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), migrateDestinationDatabaseTypeFlagName)
+	})
+
+	t.Run("Fail with unsupported database type", func(t *testing.T) {
+		_, err := executeMigrateCmd(args("unknown", "--"+databaseTimeoutFlagName, "1ms"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create destination store provider")
+	})
+
+	t.Run("Fail with invalid progress interval", func(t *testing.T) {
+		_, err := executeMigrateCmd(args(migrateDestinationStorageType, "--"+migrateProgressIntervalFlagName, "0"))
+		require.EqualError(t, err, "progress-interval (command line flag) must be a positive number")
+	})
+}
+
+func requireSameRecord(t *testing.T, src, dst storage.Provider, storeName, key string) {
+	t.Helper()
+
+	get := func(p storage.Provider) ([]byte, []storage.Tag) {
+		s, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		value, err := s.Get(key)
+		require.NoError(t, err)
+
+		tags, err := s.GetTags(key)
+		require.NoError(t, err)
+
+		return value, tags
+	}
+
+	srcValue, srcTags := get(src)
+	dstValue, dstTags := get(dst)
+
+	require.Equal(t, srcValue, dstValue)
+	require.Equal(t, srcTags, dstTags)
+}
+
+func executeMigrateCmd(args []string) (string, error) {
+	var out bytes.Buffer
+
+	cmd := MigrateCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
+
+// lossyProvider is a storage provider whose stores drop batches.
+type lossyProvider struct {
+	storage.Provider
+}
+
+func (p *lossyProvider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lossyStore{Store: s}, nil
+}
+
+type lossyStore struct {
+	storage.Store
+}
+
+func (s *lossyStore) Batch([]storage.Operation) error {
+	return nil
+}
@@ -8,12 +8,24 @@ package startcmd
 
 import (
 	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/trustbloc/kms/pkg/aws/webidentity"
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/gziplimit"
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+	"github.com/trustbloc/kms/pkg/readiness"
+	"github.com/trustbloc/kms/pkg/replication"
+	"github.com/trustbloc/kms/pkg/reqlog"
+	"github.com/trustbloc/kms/pkg/secrets"
+	"github.com/trustbloc/kms/pkg/storage/retry"
 )
 
 const (
@@ -32,12 +44,15 @@ const (
 	baseURLEnvKey    = "KMS_BASE_URL"
 	baseURLFlagName  = "base-url"
 	baseURLFlagUsage = "An optional base URL value to prepend to a keystore URL. " +
+		"All key store and key URLs the server returns, and the targets of its capabilities, are built from it and " +
+		"never from request headers, so behind a proxy set it to the external URL of the server. " +
 		commonEnvVarUsageText + baseURLEnvKey
 
 	databaseTypeEnvKey    = "KMS_DATABASE_TYPE"
 	databaseTypeFlagName  = "database-type"
 	databaseTypeFlagUsage = "The type of database to use for storing keystores metadata. " +
-		"Supported options: mem, couchdb, mongodb. " + commonEnvVarUsageText + databaseTypeEnvKey
+		"Supported options: mem, couchdb, mongodb, postgres, or a type registered with RegisterStorageProvider. " +
+		commonEnvVarUsageText + databaseTypeEnvKey
 
 	databaseURLEnvKey    = "KMS_DATABASE_URL"
 	databaseURLFlagName  = "database-url"
@@ -54,6 +69,27 @@ const (
 	databaseTimeoutFlagUsage = "Total time to wait for the database to become available. Supports valid duration " +
 		"strings. Defaults to 30s. " + commonEnvVarUsageText + databaseTimeoutEnvKey
 
+	databaseRetriesEnvKey    = "KMS_DATABASE_RETRIES"
+	databaseRetriesFlagName  = "database-retries"
+	databaseRetriesFlagUsage = "The number of retries of a database operation that failed with a transient error, " +
+		"e.g. a connection reset or a primary election. Applies to MongoDB. Defaults to 3, 0 disables retries. " +
+		commonEnvVarUsageText + databaseRetriesEnvKey
+
+	databaseRetryBackoffEnvKey    = "KMS_DATABASE_RETRY_BACKOFF"
+	databaseRetryBackoffFlagName  = "database-retry-backoff"
+	databaseRetryBackoffFlagUsage = "The interval before the first retry of a database operation, doubled for each " +
+		"next retry. Defaults to 50ms. " + commonEnvVarUsageText + databaseRetryBackoffEnvKey
+
+	databaseRetryMaxBackoffEnvKey    = "KMS_DATABASE_RETRY_MAX_BACKOFF"
+	databaseRetryMaxBackoffFlagName  = "database-retry-max-backoff"
+	databaseRetryMaxBackoffFlagUsage = "The maximum interval between retries of a database operation. Defaults to 1s. " +
+		commonEnvVarUsageText + databaseRetryMaxBackoffEnvKey
+
+	readinessTimeoutEnvKey    = "KMS_READINESS_TIMEOUT"
+	readinessTimeoutFlagName  = "readiness-timeout"
+	readinessTimeoutFlagUsage = "Timeout of each dependency check of the /ready endpoint: the database, the key " +
+		"storage and the Auth server. Defaults to 2s. " + commonEnvVarUsageText + readinessTimeoutEnvKey
+
 	tlsSystemCertPoolEnvKey    = "KMS_TLS_SYSTEMCERTPOOL"
 	tlsSystemCertPoolFlagName  = "tls-systemcertpool"
 	tlsSystemCertPoolFlagUsage = "Use system certificate pool. Possible values [true] [false]. " +
@@ -80,23 +116,67 @@ const (
 
 	authServerURLEnvKey    = "KMS_AUTH_SERVER_URL"
 	authServerURLFlagName  = "auth-server-url"
-	authServerURLFlagUsage = "The URL of Auth server. " + commonEnvVarUsageText + authServerURLEnvKey
+	authServerURLFlagUsage = "The URL of Auth server. Supports dns+srv://<srv-name>[/path][?scheme=http] URLs " +
+		"resolved with DNS SRV records. " + commonEnvVarUsageText + authServerURLEnvKey
 
 	authServerTokenEnvKey    = "KMS_AUTH_SERVER_TOKEN" //nolint:gosec // not hard-coded credentials
 	authServerTokenFlagName  = "auth-server-token"     //nolint:gosec // not hard-coded credentials
 	authServerTokenFlagUsage = "A static token used to protect the GET /secrets API in Auth server. " +
 		commonEnvVarUsageText + authServerTokenEnvKey
 
+	adminTokenEnvKey    = "KMS_ADMIN_TOKEN" //nolint:gosec // not hard-coded credentials
+	adminTokenFlagName  = "admin-token"     //nolint:gosec // not hard-coded credentials
+	adminTokenFlagUsage = "The token that authorizes admin requests (\"Authorization: Admin <token>\"), e.g. listing " +
+		"key stores of a controller. Admin endpoints are not exposed if not set. " +
+		commonEnvVarUsageText + adminTokenEnvKey
+
 	enableCacheEnvKey    = "KMS_CACHE_ENABLE"
 	enableCacheFlagName  = "enable-cache"
 	enableCacheFlagUsage = "Enables caching support. Possible values: [true] [false]. Defaults to true. " +
 		commonEnvVarUsageText + enableCacheEnvKey
 
+	cacheTypeEnvKey    = "KMS_CACHE_TYPE"
+	cacheTypeFlagName  = "cache-type"
+	cacheTypeFlagUsage = "The type of the cache of stored records and Shamir secret shares if caching is enabled. " +
+		"Supported options: memory, redis. Defaults to memory. A redis cache is shared by server instances " +
+		"using the same Redis; keys of the server kms are always cached in memory. " +
+		commonEnvVarUsageText + cacheTypeEnvKey
+
+	cacheURLEnvKey    = "KMS_CACHE_URL"
+	cacheURLFlagName  = "cache-url"
+	cacheURLFlagUsage = "The URL of the redis cache: redis://[[user]:password@]host[:port][/db], or rediss:// for " +
+		"TLS. Required if the cache type is redis. " + commonEnvVarUsageText + cacheURLEnvKey
+
 	keyStoreCacheTTLEnvKey    = "KMS_KEY_STORE_CACHE_TTL"
 	keyStoreCacheTTLFlagName  = "key-store-cache-ttl"
 	keyStoreCacheTTLFlagUsage = "An optional value for key store cache TTL (time to live). Defaults to 10m if " +
 		"caching is enabled. If set to 0, key store is never cached. " + commonEnvVarUsageText + keyStoreCacheTTLEnvKey
 
+	keyStoreCacheTTLMaxEnvKey    = "KMS_KEY_STORE_CACHE_TTL_MAX"
+	keyStoreCacheTTLMaxFlagName  = "key-store-cache-ttl-max"
+	keyStoreCacheTTLMaxFlagUsage = "The maximum key store cache TTL an admin can set as an override for a key store. " +
+		"Defaults to 0 (overrides are disabled). " + commonEnvVarUsageText + keyStoreCacheTTLMaxEnvKey
+
+	keyStoreRateLimitEnvKey    = "KMS_KEY_STORE_RATE_LIMIT"
+	keyStoreRateLimitFlagName  = "key-store-rate-limit"
+	keyStoreRateLimitFlagUsage = "The maximum number of requests of a key store per second, per instance. " +
+		"Defaults to 0 (no limit). " + commonEnvVarUsageText + keyStoreRateLimitEnvKey
+
+	keyStoreRateLimitMaxEnvKey    = "KMS_KEY_STORE_RATE_LIMIT_MAX"
+	keyStoreRateLimitMaxFlagName  = "key-store-rate-limit-max"
+	keyStoreRateLimitMaxFlagUsage = "The maximum rate limit an admin can set as an override for a key store. " +
+		"Defaults to 0 (overrides are disabled). " + commonEnvVarUsageText + keyStoreRateLimitMaxEnvKey
+
+	keyStoreConcurrencyLimitEnvKey    = "KMS_KEY_STORE_CONCURRENCY_LIMIT"
+	keyStoreConcurrencyLimitFlagName  = "key-store-concurrency-limit"
+	keyStoreConcurrencyLimitFlagUsage = "The maximum number of requests of a key store served at the same time, " +
+		"per instance. Defaults to 0 (no limit). " + commonEnvVarUsageText + keyStoreConcurrencyLimitEnvKey
+
+	keyStoreConcurrencyLimitMaxEnvKey    = "KMS_KEY_STORE_CONCURRENCY_LIMIT_MAX"
+	keyStoreConcurrencyLimitMaxFlagName  = "key-store-concurrency-limit-max"
+	keyStoreConcurrencyLimitMaxFlagUsage = "The maximum concurrency limit an admin can set as an override for a key " +
+		"store. Defaults to 0 (overrides are disabled). " + commonEnvVarUsageText + keyStoreConcurrencyLimitMaxEnvKey
+
 	kmsCacheTTLEnvKey    = "KMS_KMS_CACHE_TTL"
 	kmsCacheTTLFlagName  = "kms-cache-ttl"
 	kmsCacheTTLFlagUsage = "An optional value cache TTL (time to live) for keys in server kms. Defaults to 10m if " +
@@ -107,16 +187,135 @@ const (
 	shamirSecretCacheTTLFlagUsage = "An optional value cache TTL (time to live) for keys in server kms. Defaults to 10m if " +
 		"caching is enabled. If set to 0, keys are never cached. " + commonEnvVarUsageText + shamirSecretCacheTTLEnvKey
 
+	loadShedMaxHeapEnvKey    = "KMS_LOAD_SHED_MAX_HEAP"
+	loadShedMaxHeapFlagName  = "load-shed-max-heap"
+	loadShedMaxHeapFlagUsage = "Heap usage (in bytes) above which new create requests are rejected with 503, and " +
+		"sign requests too when exceeded by 25%. Defaults to 0 (disabled). " + commonEnvVarUsageText + loadShedMaxHeapEnvKey
+
+	loadShedMaxGoroutinesEnvKey    = "KMS_LOAD_SHED_MAX_GOROUTINES"
+	loadShedMaxGoroutinesFlagName  = "load-shed-max-goroutines"
+	loadShedMaxGoroutinesFlagUsage = "Number of goroutines above which new create requests are rejected with 503, " +
+		"and sign requests too when exceeded by 25%. Defaults to 0 (disabled). " +
+		commonEnvVarUsageText + loadShedMaxGoroutinesEnvKey
+
+	loadShedSampleIntervalEnvKey    = "KMS_LOAD_SHED_SAMPLE_INTERVAL"
+	loadShedSampleIntervalFlagName  = "load-shed-sample-interval"
+	loadShedSampleIntervalFlagUsage = "How often heap usage and goroutines are sampled for load shedding. " +
+		"Defaults to 1s. " + commonEnvVarUsageText + loadShedSampleIntervalEnvKey
+
+	hubAuthTimeoutEnvKey    = "KMS_HUB_AUTH_TIMEOUT"
+	hubAuthTimeoutFlagName  = "hub-auth-timeout"
+	hubAuthTimeoutFlagUsage = "Timeout of requests to the Auth server (hub-auth), including OAuth token and GNAP " +
+		"introspection requests. Defaults to 30s. " + commonEnvVarUsageText + hubAuthTimeoutEnvKey
+
+	edvTimeoutEnvKey    = "KMS_EDV_TIMEOUT"
+	edvTimeoutFlagName  = "edv-timeout"
+	edvTimeoutFlagUsage = "Timeout of operations on EDV-backed key stores. Defaults to 30s. " +
+		commonEnvVarUsageText + edvTimeoutEnvKey
+
+	didResolverTimeoutEnvKey    = "KMS_DID_RESOLVER_TIMEOUT"
+	didResolverTimeoutFlagName  = "did-resolver-timeout"
+	didResolverTimeoutFlagUsage = "Timeout of requests to resolve did:orb DIDs. Defaults to 30s. " +
+		commonEnvVarUsageText + didResolverTimeoutEnvKey
+
+	webhookTimeoutEnvKey    = "KMS_WEBHOOK_TIMEOUT"
+	webhookTimeoutFlagName  = "webhook-timeout"
+	webhookTimeoutFlagUsage = "Timeout of requests to the SLO alert webhook. Defaults to 10s. " +
+		commonEnvVarUsageText + webhookTimeoutEnvKey
+
+	breakerFailureRateEnvKey    = "KMS_BREAKER_FAILURE_RATE"
+	breakerFailureRateFlagName  = "breaker-failure-rate"
+	breakerFailureRateFlagUsage = "Share (0-1] of failed calls to a dependency (hub-auth, EDV, DID resolver, " +
+		"webhook) at or above which its circuit breaker opens and requests that need it fail fast with 503. " +
+		"Defaults to 0.5. " + commonEnvVarUsageText + breakerFailureRateEnvKey
+
+	breakerMinCallsEnvKey    = "KMS_BREAKER_MIN_CALLS"
+	breakerMinCallsFlagName  = "breaker-min-calls"
+	breakerMinCallsFlagUsage = "Number of calls to a dependency in a window before its failure rate is evaluated. " +
+		"Defaults to 10. " + commonEnvVarUsageText + breakerMinCallsEnvKey
+
+	breakerWindowEnvKey    = "KMS_BREAKER_WINDOW"
+	breakerWindowFlagName  = "breaker-window"
+	breakerWindowFlagUsage = "Period over which calls to a dependency are counted. Defaults to 30s. " +
+		commonEnvVarUsageText + breakerWindowEnvKey
+
+	breakerOpenTimeoutEnvKey    = "KMS_BREAKER_OPEN_TIMEOUT"
+	breakerOpenTimeoutFlagName  = "breaker-open-timeout"
+	breakerOpenTimeoutFlagUsage = "How long a circuit breaker stays open before a probe call is let through to the " +
+		"dependency. Defaults to 30s. " + commonEnvVarUsageText + breakerOpenTimeoutEnvKey
+
+	cryptoCheapWorkersEnvKey    = "KMS_CRYPTO_CHEAP_WORKERS"
+	cryptoCheapWorkersFlagName  = "crypto-cheap-workers"
+	cryptoCheapWorkersFlagUsage = "Number of signature and proof operations with cheap keys (e.g. Ed25519, ECDSA) " +
+		"that run at once. Defaults to 0 (no limit). " + commonEnvVarUsageText + cryptoCheapWorkersEnvKey
+
+	cryptoExpensiveWorkersEnvKey    = "KMS_CRYPTO_EXPENSIVE_WORKERS"
+	cryptoExpensiveWorkersFlagName  = "crypto-expensive-workers"
+	cryptoExpensiveWorkersFlagUsage = "Number of signature and proof operations with expensive keys (BBS+, RSA) " +
+		"that run at once. Defaults to 0 (no limit). " + commonEnvVarUsageText + cryptoExpensiveWorkersEnvKey
+
+	cryptoExpensiveQueueSizeEnvKey    = "KMS_CRYPTO_EXPENSIVE_QUEUE_SIZE"
+	cryptoExpensiveQueueSizeFlagName  = "crypto-expensive-queue-size"
+	cryptoExpensiveQueueSizeFlagUsage = "Number of operations with expensive keys that wait for a worker; operations " +
+		"beyond it are rejected with 429. Used only if " + cryptoExpensiveWorkersFlagName + " is set. Defaults to 100. " +
+		commonEnvVarUsageText + cryptoExpensiveQueueSizeEnvKey
+
 	disableAuthEnvKey    = "KMS_AUTH_DISABLE"
 	disableAuthFlagName  = "disable-auth"
 	disableAuthFlagUsage = "Disables authorization. Possible values: [true] [false]. Defaults to false. " +
 		commonEnvVarUsageText + disableAuthEnvKey
 
+	disableEDVEnvKey    = "KMS_EDV_DISABLE"
+	disableEDVFlagName  = "disable-edv"
+	disableEDVFlagUsage = "Refuses to create key stores with keys stored on EDV (storage_mode edv). Existing EDV key " +
+		"stores are still served. Possible values: [true] [false]. Defaults to false. " +
+		commonEnvVarUsageText + disableEDVEnvKey
+
 	enableCORSEnvKey    = "KMS_CORS_ENABLE"
 	enableCORSFlagName  = "enable-cors"
 	enableCORSFlagUsage = "Enables CORS. Possible values: [true] [false]. Defaults to false. " +
 		commonEnvVarUsageText + enableCORSEnvKey
 
+	enableDryRunEnvKey    = "KMS_DRY_RUN_ENABLE"
+	enableDryRunFlagName  = "enable-dry-run"
+	enableDryRunFlagUsage = "Enables dryRun=true query parameter on key operations that returns a report of " +
+		"authorization and validation checks instead of executing the operation. " +
+		"Possible values: [true] [false]. Defaults to false. " + commonEnvVarUsageText + enableDryRunEnvKey
+
+	enableNoZCAPEnvKey    = "KMS_NO_ZCAP_KEY_STORES_ENABLE"
+	enableNoZCAPFlagName  = "enable-no-zcap-key-stores"
+	enableNoZCAPFlagUsage = "Allows creating key stores without ZCAPs (disable_zcap=true), authorized by the OAuth " +
+		"subject that created them instead of capabilities. Intended for testing. Key store requests without ZCAPs " +
+		"are refused if disabled. Possible values: [true] [false]. Defaults to false. " +
+		commonEnvVarUsageText + enableNoZCAPEnvKey
+
+	enableTestVectorsEnvKey    = "KMS_TEST_VECTORS_ENABLE"
+	enableTestVectorsFlagName  = "enable-test-vectors"
+	enableTestVectorsFlagUsage = "Serves canonical test vectors for client implementers at /devel/test-vectors. " +
+		"The vectors are made with published test keys; for development servers only, never enable it in " +
+		"production. Possible values: [true] [false]. Defaults to false. " +
+		commonEnvVarUsageText + enableTestVectorsEnvKey
+
+	enableRawDerivedKeysEnvKey    = "KMS_RAW_DERIVED_KEYS_ENABLE"
+	enableRawDerivedKeysFlagName  = "enable-raw-derived-keys"
+	enableRawDerivedKeysFlagUsage = "Allows the derive endpoint to return derived keys raw, without a recipient to " +
+		"wrap them for. A raw derived key is as sensitive as the private key it's derived with, so keep this off " +
+		"unless clients need it. Possible values: [true] [false]. Defaults to false. " +
+		commonEnvVarUsageText + enableRawDerivedKeysEnvKey
+
+	debugAuthEnvKey    = "KMS_DEBUG_AUTH"
+	debugAuthFlagName  = "debug-auth"
+	debugAuthFlagUsage = "Adds remediation hints to the Auth-Hint header of rejected capability invocations " +
+		"(e.g. which signature base lines differ or which action is missing). Hints don't include secrets, but " +
+		"describe the authorization setup, so keep this off in production. " +
+		"Possible values: [true] [false]. Defaults to false. " + commonEnvVarUsageText + debugAuthEnvKey
+
+	logFormatEnvKey    = "KMS_LOG_FORMAT"
+	logFormatFlagName  = "log-format"
+	logFormatFlagUsage = "Format of log lines. Supported options: text, json. Log lines written while serving a " +
+		"request carry the key store ID and a hash of its controller, as members of JSON lines. Defaults to text. " +
+		commonEnvVarUsageText + logFormatEnvKey
+
 	logLevelEnvKey    = "KMS_LOG_LEVEL"
 	logLevelFlagName  = "log-level"
 	logLevelFlagUsage = "Logging level. Supported options: critical, error, warning, info, debug. Defaults to info. " +
@@ -124,8 +323,8 @@ const (
 
 	secretLockTypeFlagName  = "secret-lock-type"
 	secretLockTypeEnvKey    = "KMS_SECRET_LOCK_TYPE" //nolint:gosec // not hard-coded credentials
-	secretLockTypeFlagUsage = "Type of a secret lock used to protect server KMS. Supported options: local, aws. " +
-		commonEnvVarUsageText + secretLockTypeEnvKey
+	secretLockTypeFlagUsage = "Type of a secret lock used to protect server KMS. Supported options: local, aws, " +
+		"or a type registered with RegisterSecretLock. " + commonEnvVarUsageText + secretLockTypeEnvKey
 
 	secretLockKeyPathFlagName  = "secret-lock-key-path"
 	secretLockKeyPathEnvKey    = "KMS_SECRET_LOCK_KEY_PATH" //nolint:gosec // not hard-coded credentials
@@ -152,6 +351,313 @@ const (
 	secretLockAWSEndpointFlagUsage = "The endpoint of AWS KMS service. Should be set only in test environment. " +
 		commonEnvVarUsageText + secretLockAWSEndpointEnvKey
 
+	secretLockAWSRoleARNFlagName  = "secret-lock-aws-role-arn"
+	secretLockAWSRoleARNEnvKey    = "KMS_SECRET_LOCK_AWS_ROLE_ARN" //nolint:gosec // not hard-coded credentials
+	secretLockAWSRoleARNFlagUsage = "The ARN of an AWS role assumed with the Kubernetes service account token of " +
+		"the server (workload identity federation) to obtain short-lived credentials of the aws secret lock. " +
+		"If not set, the default AWS credential chain is used. " + commonEnvVarUsageText + secretLockAWSRoleARNEnvKey
+
+	secretLockAWSTokenPathFlagName  = "secret-lock-aws-web-identity-token-path"
+	secretLockAWSTokenPathEnvKey    = "KMS_SECRET_LOCK_AWS_WEB_IDENTITY_TOKEN_PATH" //nolint:gosec // not credentials
+	secretLockAWSTokenPathFlagUsage = "The path of the service account token presented to AWS STS to assume " +
+		secretLockAWSRoleARNFlagName + ". The token is read on every refresh. Defaults to " +
+		webidentity.DefaultTokenPath + ". " + commonEnvVarUsageText + secretLockAWSTokenPathEnvKey
+
+	secretLockAWSSTSEndpointFlagName  = "secret-lock-aws-sts-endpoint"
+	secretLockAWSSTSEndpointEnvKey    = "KMS_SECRET_LOCK_AWS_STS_ENDPOINT" //nolint:gosec // not credentials
+	secretLockAWSSTSEndpointFlagUsage = "The endpoint of AWS STS used to assume " + secretLockAWSRoleARNFlagName +
+		". Defaults to the regional endpoint. " + commonEnvVarUsageText + secretLockAWSSTSEndpointEnvKey
+
+	responseSigningKeyPathEnvKey    = "KMS_RESPONSE_SIGNING_KEY"
+	responseSigningKeyPathFlagName  = "response-signing-key"
+	responseSigningKeyPathFlagUsage = "The path to the P-256 private key (PEM) used to sign export key responses. " +
+		"If not set, responses are not signed. " + commonEnvVarUsageText + responseSigningKeyPathEnvKey
+
+	responseSigningRetiredKeysEnvKey    = "KMS_RESPONSE_SIGNING_RETIRED_KEYS"
+	responseSigningRetiredKeysFlagName  = "response-signing-retired-keys"
+	responseSigningRetiredKeysFlagUsage = "Comma-separated paths to previous response signing keys (PEM, private or " +
+		"public) that are still published for verification after rotation. " +
+		commonEnvVarUsageText + responseSigningRetiredKeysEnvKey
+
+	responseSigningOverlapEnvKey    = "KMS_RESPONSE_SIGNING_OVERLAP"
+	responseSigningOverlapFlagName  = "response-signing-overlap"
+	responseSigningOverlapFlagUsage = "How long retired response signing keys stay valid after they are retired. " +
+		"Defaults to 168h. " + commonEnvVarUsageText + responseSigningOverlapEnvKey
+
+	verifyCacheTTLEnvKey    = "KMS_VERIFY_CACHE_TTL"
+	verifyCacheTTLFlagName  = "verify-cache-ttl"
+	verifyCacheTTLFlagUsage = "TTL of cached verification results for key stores created with verify cache enabled. " +
+		"Defaults to 0 (disabled). " + commonEnvVarUsageText + verifyCacheTTLEnvKey
+
+	verifyCacheSizeEnvKey    = "KMS_VERIFY_CACHE_SIZE"
+	verifyCacheSizeFlagName  = "verify-cache-size"
+	verifyCacheSizeFlagUsage = "Maximum number of cached verification results. Defaults to 100000. " +
+		commonEnvVarUsageText + verifyCacheSizeEnvKey
+
+	signNonceTTLEnvKey    = "KMS_SIGN_NONCE_TTL"
+	signNonceTTLFlagName  = "sign-nonce-ttl"
+	signNonceTTLFlagUsage = "How long signatures of sign requests with nonces are kept, so that retried requests " +
+		"return the same signature. Defaults to 5m. If set to 0, nonces are ignored. " +
+		commonEnvVarUsageText + signNonceTTLEnvKey
+
+	uploadTTLEnvKey    = "KMS_UPLOAD_TTL"
+	uploadTTLFlagName  = "upload-ttl"
+	uploadTTLFlagUsage = "How long payloads uploaded in chunks are kept until they're signed. Abandoned uploads are " +
+		"deleted once they expire. Defaults to 1h. If set to 0, chunked uploads are disabled. " +
+		commonEnvVarUsageText + uploadTTLEnvKey
+
+	keyStoreIdempotencyTTLEnvKey    = "KMS_KEYSTORE_IDEMPOTENCY_TTL"
+	keyStoreIdempotencyTTLFlagName  = "keystore-idempotency-ttl"
+	keyStoreIdempotencyTTLFlagUsage = "How long responses of key store creation requests with idempotency keys are " +
+		"kept, so that retried requests return the same key store. Defaults to 24h. If set to 0, idempotency keys " +
+		"are ignored. " + commonEnvVarUsageText + keyStoreIdempotencyTTLEnvKey
+
+	keyExpiryClockSkewEnvKey    = "KMS_KEY_EXPIRY_CLOCK_SKEW"
+	keyExpiryClockSkewFlagName  = "key-expiry-clock-skew"
+	keyExpiryClockSkewFlagUsage = "How long after its expiration time a key can still be used, to allow for clock " +
+		"skew between the server and its clients. Defaults to 30s. " + commonEnvVarUsageText + keyExpiryClockSkewEnvKey
+
+	controllerGracePeriodEnvKey    = "KMS_CONTROLLER_ROTATION_GRACE_PERIOD"
+	controllerGracePeriodFlagName  = "controller-rotation-grace-period"
+	controllerGracePeriodFlagUsage = "How long the root capability of a key store is still accepted after it was " +
+		"re-issued for a new controller of the key store. Defaults to 24h. If set to 0, the old capability is " +
+		"rejected right away. " + commonEnvVarUsageText + controllerGracePeriodEnvKey
+
+	keyRetentionPeriodEnvKey    = "KMS_KEY_RETENTION_PERIOD"
+	keyRetentionPeriodFlagName  = "key-retention-period"
+	keyRetentionPeriodFlagUsage = "How long a deleted key can be restored before its material is purged. " +
+		"Defaults to 168h (7 days). " + commonEnvVarUsageText + keyRetentionPeriodEnvKey
+
+	keyPurgeIntervalEnvKey    = "KMS_KEY_PURGE_INTERVAL"
+	keyPurgeIntervalFlagName  = "key-purge-interval"
+	keyPurgeIntervalFlagUsage = "How often deleted keys whose retention period ended are purged. Defaults to 1h. " +
+		commonEnvVarUsageText + keyPurgeIntervalEnvKey
+
+	recordPruneIntervalEnvKey    = "KMS_EXPIRED_RECORD_PRUNE_INTERVAL"
+	recordPruneIntervalFlagName  = "expired-record-prune-interval"
+	recordPruneIntervalFlagUsage = "How often expired idempotency keys, sign nonces and one-time tokens are " +
+		"pruned. Defaults to 10m. " + commonEnvVarUsageText + recordPruneIntervalEnvKey
+
+	keyUsageIntervalEnvKey    = "KMS_KEY_USAGE_INTERVAL"
+	keyUsageIntervalFlagName  = "key-usage-interval"
+	keyUsageIntervalFlagUsage = "How often the last-used time of a key is saved: later uses within the interval are " +
+		"coalesced into one write. Defaults to 1h. " + commonEnvVarUsageText + keyUsageIntervalEnvKey
+
+	disableKeyUsageEnvKey    = "KMS_KEY_USAGE_DISABLE"
+	disableKeyUsageFlagName  = "disable-key-usage-tracking"
+	disableKeyUsageFlagUsage = "Disables tracking of last-used times of keys. Possible values: [true] [false]. " +
+		"Defaults to false. " + commonEnvVarUsageText + disableKeyUsageEnvKey
+
+	keyArchiveTypeEnvKey    = "KMS_KEY_ARCHIVE_TYPE"
+	keyArchiveTypeFlagName  = "key-archive-type"
+	keyArchiveTypeFlagUsage = "The type of storage cold keys are archived to. Supported options: mem, couchdb, " +
+		"mongodb, postgres, s3. Keys aren't archived if not set. " + commonEnvVarUsageText + keyArchiveTypeEnvKey
+
+	keyArchiveURLEnvKey    = "KMS_KEY_ARCHIVE_URL"
+	keyArchiveURLFlagName  = "key-archive-url"
+	keyArchiveURLFlagUsage = "The URL of the archive database, or the name of the bucket for s3. " +
+		commonEnvVarUsageText + keyArchiveURLEnvKey
+
+	keyArchivePrefixEnvKey    = "KMS_KEY_ARCHIVE_PREFIX"
+	keyArchivePrefixFlagName  = "key-archive-prefix"
+	keyArchivePrefixFlagUsage = "An optional prefix of the archive database. " + commonEnvVarUsageText +
+		keyArchivePrefixEnvKey
+
+	keyArchiveS3RegionEnvKey    = "KMS_KEY_ARCHIVE_S3_REGION"
+	keyArchiveS3RegionFlagName  = "key-archive-s3-region"
+	keyArchiveS3RegionFlagUsage = "The region of the s3 archive bucket. " + commonEnvVarUsageText +
+		keyArchiveS3RegionEnvKey
+
+	keyArchiveS3EndpointEnvKey    = "KMS_KEY_ARCHIVE_S3_ENDPOINT"
+	keyArchiveS3EndpointFlagName  = "key-archive-s3-endpoint"
+	keyArchiveS3EndpointFlagUsage = "The endpoint of an S3-compatible object store. Defaults to AWS S3. " +
+		commonEnvVarUsageText + keyArchiveS3EndpointEnvKey
+
+	keyArchiveAfterDaysEnvKey    = "KMS_KEY_ARCHIVE_AFTER_DAYS"
+	keyArchiveAfterDaysFlagName  = "key-archive-after-days"
+	keyArchiveAfterDaysFlagUsage = "Keys not used for the number of days are archived. Defaults to 180. " +
+		commonEnvVarUsageText + keyArchiveAfterDaysEnvKey
+
+	keyArchiveIntervalEnvKey    = "KMS_KEY_ARCHIVE_INTERVAL"
+	keyArchiveIntervalFlagName  = "key-archive-interval"
+	keyArchiveIntervalFlagUsage = "How often cold keys are archived. Defaults to 24h. " + commonEnvVarUsageText +
+		keyArchiveIntervalEnvKey
+
+	usageReportTargetEnvKey    = "KMS_USAGE_REPORT_TARGET"
+	usageReportTargetFlagName  = "usage-report-target"
+	usageReportTargetFlagUsage = "Where signed daily usage reports of key stores are delivered. Supported options: " +
+		"webhook, s3. Reports require --response-signing-key and aren't generated if not set. " +
+		commonEnvVarUsageText + usageReportTargetEnvKey
+
+	usageReportURLEnvKey    = "KMS_USAGE_REPORT_URL"
+	usageReportURLFlagName  = "usage-report-url"
+	usageReportURLFlagUsage = "The URL of the usage report webhook, or the name of the bucket for s3. " +
+		commonEnvVarUsageText + usageReportURLEnvKey
+
+	usageReportS3PrefixEnvKey    = "KMS_USAGE_REPORT_S3_PREFIX"
+	usageReportS3PrefixFlagName  = "usage-report-s3-prefix"
+	usageReportS3PrefixFlagUsage = "An optional prefix of keys of usage report objects in the s3 bucket. " +
+		commonEnvVarUsageText + usageReportS3PrefixEnvKey
+
+	usageReportS3RegionEnvKey    = "KMS_USAGE_REPORT_S3_REGION"
+	usageReportS3RegionFlagName  = "usage-report-s3-region"
+	usageReportS3RegionFlagUsage = "The region of the s3 usage report bucket. " + commonEnvVarUsageText +
+		usageReportS3RegionEnvKey
+
+	usageReportS3EndpointEnvKey    = "KMS_USAGE_REPORT_S3_ENDPOINT"
+	usageReportS3EndpointFlagName  = "usage-report-s3-endpoint"
+	usageReportS3EndpointFlagUsage = "The endpoint of an S3-compatible object store for usage reports. Defaults to " +
+		"AWS S3. " + commonEnvVarUsageText + usageReportS3EndpointEnvKey
+
+	usageReportRateLimitEnvKey    = "KMS_USAGE_REPORT_RATE_LIMIT"
+	usageReportRateLimitFlagName  = "usage-report-rate-limit"
+	usageReportRateLimitFlagUsage = "The maximum number of usage reports delivered per second, including retries. " +
+		"Defaults to 10. " + commonEnvVarUsageText + usageReportRateLimitEnvKey
+
+	usageReportMaxRetriesEnvKey    = "KMS_USAGE_REPORT_MAX_RETRIES"
+	usageReportMaxRetriesFlagName  = "usage-report-max-retries"
+	usageReportMaxRetriesFlagUsage = "How many times a failed usage report delivery is retried before the report is " +
+		"saved to the dead-letter store. Defaults to 5. " + commonEnvVarUsageText + usageReportMaxRetriesEnvKey
+
+	signBatchMaxSizeEnvKey    = "KMS_SIGN_BATCH_MAX_SIZE"
+	signBatchMaxSizeFlagName  = "sign-batch-max-size"
+	signBatchMaxSizeFlagUsage = "Maximum number of messages signed in a single sign batch request. Defaults to 100. " +
+		commonEnvVarUsageText + signBatchMaxSizeEnvKey
+
+	signMultiKeyMaxMessageEnvKey    = "KMS_SIGN_MULTI_KEY_MAX_MESSAGE_SIZE"
+	signMultiKeyMaxMessageFlagName  = "sign-multi-key-max-message-size"
+	signMultiKeyMaxMessageFlagUsage = "Maximum size in bytes of a message of a multi-key sign request. " +
+		"Defaults to 65536. " + commonEnvVarUsageText + signMultiKeyMaxMessageEnvKey
+
+	signMultiKeyMaxTotalEnvKey    = "KMS_SIGN_MULTI_KEY_MAX_TOTAL_SIZE"
+	signMultiKeyMaxTotalFlagName  = "sign-multi-key-max-total-size"
+	signMultiKeyMaxTotalFlagUsage = "Maximum total size in bytes of messages of a multi-key sign request. " +
+		"Defaults to 1048576. " + commonEnvVarUsageText + signMultiKeyMaxTotalEnvKey
+
+	enableCallerNoncesEnvKey    = "KMS_CALLER_NONCES_ENABLE"
+	enableCallerNoncesFlagName  = "enable-caller-nonces"
+	enableCallerNoncesFlagUsage = "Allows encrypt requests to set the AES-GCM nonce, for interop with systems that " +
+		"manage nonces themselves. The server generates nonces if disabled. Possible values: [true] [false]. " +
+		"Defaults to false. " + commonEnvVarUsageText + enableCallerNoncesEnvKey
+
+	callerNonceReuseWindowEnvKey    = "KMS_CALLER_NONCE_REUSE_WINDOW"
+	callerNonceReuseWindowFlagName  = "caller-nonce-reuse-window"
+	callerNonceReuseWindowFlagUsage = "How long caller nonces are remembered per key, so that a nonce used twice with " +
+		"the same key is logged as a security warning. Defaults to 24h. " +
+		commonEnvVarUsageText + callerNonceReuseWindowEnvKey
+
+	requestMaxDepthEnvKey    = "KMS_REQUEST_MAX_DEPTH"
+	requestMaxDepthFlagName  = "request-max-depth"
+	requestMaxDepthFlagUsage = "Maximum nesting depth of objects and arrays in request bodies. Defaults to 32. " +
+		commonEnvVarUsageText + requestMaxDepthEnvKey
+
+	requestMaxArrayLengthEnvKey    = "KMS_REQUEST_MAX_ARRAY_LENGTH"
+	requestMaxArrayLengthFlagName  = "request-max-array-length"
+	requestMaxArrayLengthFlagUsage = "Maximum number of elements of an array in request bodies, e.g. of messages or " +
+		"recipients. Defaults to 10000. " + commonEnvVarUsageText + requestMaxArrayLengthEnvKey
+
+	requestMaxStringLengthEnvKey    = "KMS_REQUEST_MAX_STRING_LENGTH"
+	requestMaxStringLengthFlagName  = "request-max-string-length"
+	requestMaxStringLengthFlagUsage = "Maximum size in bytes of a string in request bodies, as encoded in JSON. " +
+		"Defaults to 16777216 (16 MiB). " + commonEnvVarUsageText + requestMaxStringLengthEnvKey
+
+	requestMaxCapabilitySizeEnvKey    = "KMS_REQUEST_MAX_CAPABILITY_SIZE"
+	requestMaxCapabilitySizeFlagName  = "request-max-capability-size"
+	requestMaxCapabilitySizeFlagUsage = "Maximum decompressed size in bytes of the capability of a capability " +
+		"invocation. Defaults to 1048576 (1 MiB). " + commonEnvVarUsageText + requestMaxCapabilitySizeEnvKey
+
+	rsaKeyPoolSizeEnvKey    = "KMS_RSA_KEY_POOL_SIZE"
+	rsaKeyPoolSizeFlagName  = "rsa-key-pool-size"
+	rsaKeyPoolSizeFlagUsage = "Number of RSA keys of each size (2048, 3072 and 4096 bits) generated ahead of create " +
+		"key requests. RSA keys are generated on the spot if 0. Defaults to 0. " +
+		commonEnvVarUsageText + rsaKeyPoolSizeEnvKey
+
+	didcommMediatorURLEnvKey    = "KMS_DIDCOMM_MEDIATOR_URL"
+	didcommMediatorURLFlagName  = "didcomm-mediator-url"
+	didcommMediatorURLFlagUsage = "Service endpoint of the DIDComm mediator used in out-of-band invitations for keys. " +
+		"If not set, invitations are disabled. " + commonEnvVarUsageText + didcommMediatorURLEnvKey
+
+	sloConfigPathEnvKey    = "KMS_SLO_CONFIG_PATH"
+	sloConfigPathFlagName  = "slo-config-path"
+	sloConfigPathFlagUsage = "The path to a JSON file with service level objectives that are evaluated in-process " +
+		"against metrics of the server. If not set, objectives are not evaluated. " +
+		commonEnvVarUsageText + sloConfigPathEnvKey
+
+	signCanonicalizationEnvKey    = "KMS_SIGN_CANONICALIZATION_PROFILES"
+	signCanonicalizationFlagName  = "sign-canonicalization-profiles"
+	signCanonicalizationFlagUsage = "Comma-separated canonicalization profiles (none, jcs, urdna2015) " +
+		"that sign requests can use to transform documents before signing. Defaults to none,jcs. " +
+		"If empty, canonicalization is disabled. " +
+		commonEnvVarUsageText + signCanonicalizationEnvKey
+
+	disabledOperationsEnvKey    = "KMS_DISABLED_OPERATIONS"
+	disabledOperationsFlagName  = "disabled-operations"
+	disabledOperationsFlagUsage = "Comma-separated operations (e.g. wrap,unwrap,exportKey) whose endpoints are not " +
+		"exposed; requests to them get 404 Not Found. Health check and key store creation can't be disabled. " +
+		commonEnvVarUsageText + disabledOperationsEnvKey
+
+	replicationModeEnvKey    = "KMS_REPLICATION_MODE"
+	replicationModeFlagName  = "replication-mode"
+	replicationModeFlagUsage = "Cross-region replication mode of key store data. Supported options: primary, standby. " +
+		"If not set, replication is disabled. " + commonEnvVarUsageText + replicationModeEnvKey
+
+	replicationStandbyURLEnvKey    = "KMS_REPLICATION_STANDBY_URL"
+	replicationStandbyURLFlagName  = "replication-standby-url"
+	replicationStandbyURLFlagUsage = "The URL of the standby ingestion server. Required in primary mode. " +
+		commonEnvVarUsageText + replicationStandbyURLEnvKey
+
+	replicationIngestHostEnvKey    = "KMS_REPLICATION_INGEST_HOST"
+	replicationIngestHostFlagName  = "replication-ingest-host"
+	replicationIngestHostFlagUsage = "The host to run the ingestion server on (mTLS). Required in standby mode. " +
+		commonEnvVarUsageText + replicationIngestHostEnvKey
+
+	replicationTokenEnvKey    = "KMS_REPLICATION_TOKEN" //nolint:gosec // not hard-coded credentials
+	replicationTokenFlagName  = "replication-token"
+	replicationTokenFlagUsage = "The token shared by the primary and the standby to authenticate replication " +
+		"requests. " + commonEnvVarUsageText + replicationTokenEnvKey
+
+	replicationTLSCertEnvKey    = "KMS_REPLICATION_TLS_CERT"
+	replicationTLSCertFlagName  = "replication-tls-cert"
+	replicationTLSCertFlagUsage = "The path to the client certificate used by the primary to connect to the standby. " +
+		commonEnvVarUsageText + replicationTLSCertEnvKey
+
+	replicationTLSKeyEnvKey    = "KMS_REPLICATION_TLS_KEY"
+	replicationTLSKeyFlagName  = "replication-tls-key"
+	replicationTLSKeyFlagUsage = "The path to the private key of the replication client certificate. " +
+		commonEnvVarUsageText + replicationTLSKeyEnvKey
+
+	oauthTokenURLEnvKey    = "KMS_OAUTH_TOKEN_URL" //nolint:gosec // not hard-coded credentials
+	oauthTokenURLFlagName  = "oauth-token-url"     //nolint:gosec // not hard-coded credentials
+	oauthTokenURLFlagUsage = "The token endpoint of the OAuth server the KMS gets client credentials access tokens " +
+		"from to call other services. If set, requests to Auth server are authorized with access tokens instead of " +
+		"the static Auth server token. " + commonEnvVarUsageText + oauthTokenURLEnvKey
+
+	oauthClientIDEnvKey    = "KMS_OAUTH_CLIENT_ID"
+	oauthClientIDFlagName  = "oauth-client-id"
+	oauthClientIDFlagUsage = "The OAuth client ID of the KMS. Required if the token URL is set. " +
+		commonEnvVarUsageText + oauthClientIDEnvKey
+
+	oauthClientSecretEnvKey    = "KMS_OAUTH_CLIENT_SECRET" //nolint:gosec // not hard-coded credentials
+	oauthClientSecretFlagName  = "oauth-client-secret"     //nolint:gosec // not hard-coded credentials
+	oauthClientSecretFlagUsage = "The OAuth client secret of the KMS (client_secret_basic). " +
+		commonEnvVarUsageText + oauthClientSecretEnvKey
+
+	oauthClientKeyPathEnvKey    = "KMS_OAUTH_CLIENT_KEY"
+	oauthClientKeyPathFlagName  = "oauth-client-key"
+	oauthClientKeyPathFlagUsage = "The path to the EC private key (PEM) the KMS authenticates to the OAuth server " +
+		"with (private_key_jwt). Takes precedence over the client secret. " +
+		commonEnvVarUsageText + oauthClientKeyPathEnvKey
+
+	oauthScopesEnvKey    = "KMS_OAUTH_SCOPES"
+	oauthScopesFlagName  = "oauth-scopes"
+	oauthScopesFlagUsage = "Comma-separated scopes to request access tokens with. " +
+		commonEnvVarUsageText + oauthScopesEnvKey
+
+	oauthAuthServerAudienceEnvKey    = "KMS_OAUTH_AUTH_SERVER_AUDIENCE"
+	oauthAuthServerAudienceFlagName  = "oauth-auth-server-audience"
+	oauthAuthServerAudienceFlagUsage = "The audience of access tokens for Auth server. If not set, tokens are " +
+		"requested without an audience. " + commonEnvVarUsageText + oauthAuthServerAudienceEnvKey
+
 	gnapSigningKeyPathEnvKey    = "KMS_GNAP_SIGNING_KEY"
 	gnapSigningKeyPathFlagName  = "gnap-signing-key"
 	gnapSigningKeyPathFlagUsage = "The path to the private key to use when signing GNAP introspection requests. " +
@@ -163,44 +669,312 @@ const (
 	secretLockTypeLocalOption = "local"
 )
 
-type serverParameters struct {
-	host                 string
-	metricsHost          string
-	baseURL              string
-	tlsParams            *tlsParameters
-	databaseType         string
-	databaseURL          string
-	databasePrefix       string
-	databaseTimeout      time.Duration
-	didDomain            string
-	authServerURL        string
-	authServerToken      string
-	keyStoreCacheTTL     time.Duration
-	kmsCacheTTL          time.Duration
-	shamirSecretCacheTTL time.Duration
-	enableCache          bool
-	disableAuth          bool
-	enableCORS           bool
-	logLevel             string
-	secretLockParams     *secretLockParameters
-	gnapSigningKeyPath   string
-}
-
-type tlsParameters struct {
-	systemCertPool bool
-	caCerts        []string
-	serveCertPath  string
-	serveKeyPath   string
-}
-
-type secretLockParameters struct {
-	secretLockType string
-	localKeyPath   string
-	awsKeyURI      string
-	awsEndpoint    string
-}
-
-func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:funlen
+// Parameters are the parameters of the KMS server. The start command reads them from flags and environment
+// variables; services that embed the server with New set them directly. Fields are named after the flags they are
+// read from, see the flag usage for their meaning.
+type Parameters struct {
+	// Host is a value of --host. It's only used by the start command, embedding services serve the handler on their
+	// own.
+	Host string
+	// MetricsHost is a value of --metrics-host. It's only used by the start command.
+	MetricsHost string
+	// BaseURL is a value of --base-url.
+	BaseURL string
+	// TLS are values of TLS flags.
+	TLS *TLSParameters
+	// DatabaseType is a value of --database-type.
+	DatabaseType string
+	// DatabaseURL is a value of --database-url.
+	DatabaseURL string
+	// DatabasePrefix is a value of --database-prefix.
+	DatabasePrefix string
+	// DatabaseTimeout is a value of --database-timeout.
+	DatabaseTimeout time.Duration
+	// DatabaseRetry are values of database retry flags.
+	DatabaseRetry *DatabaseRetryParameters
+	// ReadinessTimeout is a value of --readiness-timeout.
+	ReadinessTimeout time.Duration
+	// DIDDomain is a value of --did-domain.
+	DIDDomain string
+	// AuthServerURL is a value of --auth-server-url.
+	AuthServerURL string
+	// AuthServerToken is a value of --auth-server-token.
+	AuthServerToken *secrets.Secret
+	// AdminToken is a value of --admin-token. Admin endpoints aren't served without it.
+	AdminToken *secrets.Secret
+	// KeyStoreCacheTTL is a value of --key-store-cache-ttl.
+	KeyStoreCacheTTL time.Duration
+	// KeyStoreCacheTTLMax is a value of --key-store-cache-ttl-max.
+	KeyStoreCacheTTLMax time.Duration
+	// KMSCacheTTL is a value of --kms-cache-ttl.
+	KMSCacheTTL time.Duration
+	// ShamirSecretCacheTTL is a value of --shamir-secret-cache-ttl.
+	ShamirSecretCacheTTL time.Duration
+	// EnableCache is a value of --enable-cache.
+	EnableCache bool
+	// CacheType is a value of --cache-type.
+	CacheType string
+	// CacheURL is a value of --cache-url. It's a secret, as it may have the password of the cache.
+	CacheURL *secrets.Secret
+	// LoadShed are values of load shedding flags.
+	LoadShed *LoadShedParameters
+	// Dependencies are values of timeout and circuit breaker flags of dependencies.
+	Dependencies *DependencyParameters
+	// CryptoPools are values of crypto worker pool flags.
+	CryptoPools *CryptoPoolParameters
+	// VerifyCache are values of verify cache flags.
+	VerifyCache *VerifyCacheParameters
+	// KeyStoreLimits are values of key store limit flags.
+	KeyStoreLimits *KeyStoreLimitParameters
+	// SignNonceTTL is a value of --sign-nonce-ttl.
+	SignNonceTTL time.Duration
+	// UploadTTL is a value of --upload-ttl.
+	UploadTTL time.Duration
+	// SignCanonicalization is a value of --sign-canonicalization-profiles.
+	SignCanonicalization []string
+	// DisabledOperations is a value of --disabled-operations.
+	DisabledOperations []string
+	// KeyStoreIdempotencyTTL is a value of --keystore-idempotency-ttl.
+	KeyStoreIdempotencyTTL time.Duration
+	// KeyExpiryClockSkew is a value of --key-expiry-clock-skew.
+	KeyExpiryClockSkew time.Duration
+	// ControllerRotationGracePeriod is a value of --controller-rotation-grace-period.
+	ControllerRotationGracePeriod time.Duration
+	// KeyRetentionPeriod is a value of --key-retention-period.
+	KeyRetentionPeriod time.Duration
+	// KeyPurgeInterval is a value of --key-purge-interval.
+	KeyPurgeInterval time.Duration
+	// RecordPruneInterval is a value of --expired-record-prune-interval.
+	RecordPruneInterval time.Duration
+	// KeyUsageInterval is a value of --key-usage-interval.
+	KeyUsageInterval time.Duration
+	// DisableKeyUsage is a value of --disable-key-usage-tracking.
+	DisableKeyUsage bool
+	// KeyArchive are values of key archive flags. Keys aren't archived if nil.
+	KeyArchive *KeyArchiveParameters
+	// UsageReport are values of usage report flags. Usage isn't reported if nil.
+	UsageReport *UsageReportParameters
+	// SignBatchMaxSize is a value of --sign-batch-max-size.
+	SignBatchMaxSize int
+	// SignMultiKeyMaxMessageSize is a value of --sign-multi-key-max-message-size.
+	SignMultiKeyMaxMessageSize int
+	// SignMultiKeyMaxTotalSize is a value of --sign-multi-key-max-total-size.
+	SignMultiKeyMaxTotalSize int
+	// CallerNonceWindow is a value of --caller-nonce-reuse-window if --enable-caller-nonces is set. Caller nonces
+	// are refused if zero.
+	CallerNonceWindow time.Duration
+	// RequestLimits are values of request limit flags.
+	RequestLimits jsonlimit.Limits
+	// MaxCapabilitySize is a value of --request-max-capability-size.
+	MaxCapabilitySize int64
+	// RSAKeyPoolSize is a value of --rsa-key-pool-size.
+	RSAKeyPoolSize int
+	// DIDCommMediatorURL is a value of --didcomm-mediator-url.
+	DIDCommMediatorURL string
+	// SLOConfigPath is a value of --slo-config-path.
+	SLOConfigPath string
+	// DisableAuth is a value of --disable-auth.
+	DisableAuth bool
+	// DisableEDV is a value of --disable-edv.
+	DisableEDV bool
+	// EnableCORS is a value of --enable-cors.
+	EnableCORS bool
+	// EnableDryRun is a value of --enable-dry-run.
+	EnableDryRun bool
+	// EnableNoZCAP is a value of --enable-no-zcap-key-stores.
+	EnableNoZCAP bool
+	// EnableTestVectors is a value of --enable-test-vectors.
+	EnableTestVectors bool
+	// EnableRawDerivedKeys is a value of --enable-raw-derived-keys.
+	EnableRawDerivedKeys bool
+	// DebugAuth is a value of --debug-auth.
+	DebugAuth bool
+	// LogLevel is a value of --log-level. It's only used by the start command, logging is process-wide.
+	LogLevel string
+	// LogFormat is a value of --log-format. It's only used by the start command.
+	LogFormat string
+	// SecretLock are values of secret lock flags.
+	SecretLock *SecretLockParameters
+	// GNAPSigningKeyPath is a value of --gnap-signing-key.
+	GNAPSigningKeyPath string
+	// ResponseSigning are values of response signing flags.
+	ResponseSigning *ResponseSigningParameters
+	// Replication are values of replication flags.
+	Replication *ReplicationParameters
+	// OAuth are values of OAuth client flags.
+	OAuth *OAuthParameters
+}
+
+// TLSParameters are values of TLS flags.
+type TLSParameters struct {
+	// SystemCertPool is a value of --tls-systemcertpool.
+	SystemCertPool bool
+	// CACerts is a value of --tls-cacerts.
+	CACerts []string
+	// ServeCertPath is a value of --tls-serve-cert.
+	ServeCertPath string
+	// ServeKeyPath is a value of --tls-serve-key.
+	ServeKeyPath string
+}
+
+// ResponseSigningParameters are values of response signing flags.
+type ResponseSigningParameters struct {
+	// KeyPath is a value of --response-signing-key. Responses aren't signed without it.
+	KeyPath string
+	// RetiredKeyPaths is a value of --response-signing-retired-keys.
+	RetiredKeyPaths []string
+	// Overlap is a value of --response-signing-overlap.
+	Overlap time.Duration
+}
+
+// ReplicationParameters are values of replication flags.
+type ReplicationParameters struct {
+	// Mode is a value of --replication-mode. Records aren't replicated if empty.
+	Mode string
+	// StandbyURL is a value of --replication-standby-url.
+	StandbyURL string
+	// IngestHost is a value of --replication-ingest-host.
+	IngestHost string
+	// Token is a value of --replication-token.
+	Token *secrets.Secret
+	// TLSCertPath is a value of --replication-tls-cert.
+	TLSCertPath string
+	// TLSKeyPath is a value of --replication-tls-key.
+	TLSKeyPath string
+}
+
+// KeyArchiveParameters are values of key archive flags.
+type KeyArchiveParameters struct {
+	// Type is a value of --key-archive-type.
+	Type string
+	// URL is a value of --key-archive-url.
+	URL string
+	// Prefix is a value of --key-archive-prefix.
+	Prefix string
+	// S3Region is a value of --key-archive-s3-region.
+	S3Region string
+	// S3Endpoint is a value of --key-archive-s3-endpoint.
+	S3Endpoint string
+	// After is a value of --key-archive-after-days.
+	After time.Duration
+	// Interval is a value of --key-archive-interval.
+	Interval time.Duration
+}
+
+// UsageReportParameters are values of usage report flags.
+type UsageReportParameters struct {
+	// Target is a value of --usage-report-target.
+	Target string
+	// URL is a value of --usage-report-url.
+	URL string
+	// S3Prefix is a value of --usage-report-s3-prefix.
+	S3Prefix string
+	// S3Region is a value of --usage-report-s3-region.
+	S3Region string
+	// S3Endpoint is a value of --usage-report-s3-endpoint.
+	S3Endpoint string
+	// RateLimit is a value of --usage-report-rate-limit.
+	RateLimit int
+	// MaxRetries is a value of --usage-report-max-retries.
+	MaxRetries int
+}
+
+// OAuthParameters are values of OAuth client flags.
+type OAuthParameters struct {
+	// TokenURL is a value of --oauth-token-url. Access tokens aren't requested if empty.
+	TokenURL string
+	// ClientID is a value of --oauth-client-id.
+	ClientID string
+	// ClientSecret is a value of --oauth-client-secret.
+	ClientSecret *secrets.Secret
+	// ClientKeyPath is a value of --oauth-client-key.
+	ClientKeyPath string
+	// Scopes is a value of --oauth-scopes.
+	Scopes []string
+	// AuthServerAudience is a value of --oauth-auth-server-audience.
+	AuthServerAudience string
+}
+
+// DatabaseRetryParameters are values of database retry flags.
+type DatabaseRetryParameters struct {
+	// MaxRetries is a value of --database-retries.
+	MaxRetries int
+	// Backoff is a value of --database-retry-backoff.
+	Backoff time.Duration
+	// MaxBackoff is a value of --database-retry-max-backoff.
+	MaxBackoff time.Duration
+}
+
+// KeyStoreLimitParameters are values of key store limit flags.
+type KeyStoreLimitParameters struct {
+	// RateLimit is a value of --key-store-rate-limit.
+	RateLimit float64
+	// RateLimitMax is a value of --key-store-rate-limit-max.
+	RateLimitMax float64
+	// ConcurrencyLimit is a value of --key-store-concurrency-limit.
+	ConcurrencyLimit int
+	// ConcurrencyLimitMax is a value of --key-store-concurrency-limit-max.
+	ConcurrencyLimitMax int
+}
+
+// VerifyCacheParameters are values of verify cache flags.
+type VerifyCacheParameters struct {
+	// TTL is a value of --verify-cache-ttl.
+	TTL time.Duration
+	// Size is a value of --verify-cache-size.
+	Size int64
+}
+
+// LoadShedParameters are values of load shedding flags.
+type LoadShedParameters struct {
+	// MaxHeapBytes is a value of --load-shed-max-heap in bytes.
+	MaxHeapBytes uint64
+	// MaxGoroutines is a value of --load-shed-max-goroutines.
+	MaxGoroutines int
+	// SampleInterval is a value of --load-shed-sample-interval.
+	SampleInterval time.Duration
+}
+
+// DependencyParameters are values of timeout and circuit breaker flags of dependencies.
+type DependencyParameters struct {
+	// HubAuthTimeout is a value of --hub-auth-timeout.
+	HubAuthTimeout time.Duration
+	// EDVTimeout is a value of --edv-timeout.
+	EDVTimeout time.Duration
+	// DIDResolverTimeout is a value of --did-resolver-timeout.
+	DIDResolverTimeout time.Duration
+	// WebhookTimeout is a value of --webhook-timeout.
+	WebhookTimeout time.Duration
+	// Breaker are values of --breaker-* flags.
+	Breaker breaker.Config
+}
+
+// CryptoPoolParameters are values of crypto worker pool flags.
+type CryptoPoolParameters struct {
+	// CheapWorkers is a value of --crypto-cheap-workers.
+	CheapWorkers int
+	// ExpensiveWorkers is a value of --crypto-expensive-workers.
+	ExpensiveWorkers int
+	// ExpensiveQueueSize is a value of --crypto-expensive-queue-size.
+	ExpensiveQueueSize int
+}
+
+// ParseParameters parses the parameters of the start command from arguments in the command line format (e.g.
+// "--database-type", "mem") and environment variables, with the defaults of flags that are set by neither. Services
+// that embed the server start from them and pass them to New.
+func ParseParameters(args []string) (*Parameters, error) {
+	cmd := createStartCmd(nil)
+
+	createFlags(cmd)
+
+	if err := cmd.ParseFlags(args); err != nil {
+		return nil, fmt.Errorf("parse flags: %w", err)
+	}
+
+	return getParameters(cmd)
+}
+
+func getParameters(cmd *cobra.Command) (*Parameters, error) { //nolint:funlen
 	host := getUserSetVarOptional(cmd, hostFlagName, hostEnvKey)
 	metricsHost := getUserSetVarOptional(cmd, hostMetricsFlagName, hostMetricsEnvKey)
 	baseURL := getUserSetVarOptional(cmd, baseURLFlagName, baseURLEnvKey)
@@ -215,15 +989,28 @@ func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:fun
 	databaseTimeoutStr := getUserSetVarOptional(cmd, databaseTimeoutFlagName, databaseTimeoutEnvKey)
 	didDomain := getUserSetVarOptional(cmd, didDomainFlagName, didDomainEnvKey)
 	authServerURL := getUserSetVarOptional(cmd, authServerURLFlagName, authServerURLEnvKey)
-	authServerToken := getUserSetVarOptional(cmd, authServerTokenFlagName, authServerTokenEnvKey)
+	secretManager := secrets.NewManager()
+	authServerToken := getSecret(cmd, secretManager, authServerTokenFlagName, authServerTokenEnvKey)
 	keyStoreCacheTTLStr := getUserSetVarOptional(cmd, keyStoreCacheTTLFlagName, keyStoreCacheTTLEnvKey)
 	kmsCacheTTLStr := getUserSetVarOptional(cmd, kmsCacheTTLFlagName, kmsCacheTTLEnvKey)
 	shamirSecretCacheTTLStr := getUserSetVarOptional(cmd, shamirSecretCacheTTLFlagName, shamirSecretCacheTTLEnvKey)
 	enableCacheStr := getUserSetVarOptional(cmd, enableCacheFlagName, enableCacheEnvKey)
 	disableAuthStr := getUserSetVarOptional(cmd, disableAuthFlagName, disableAuthEnvKey)
+	disableEDVStr := getUserSetVarOptional(cmd, disableEDVFlagName, disableEDVEnvKey)
 	enableCORSStr := getUserSetVarOptional(cmd, enableCORSFlagName, enableCORSEnvKey)
+	enableDryRunStr := getUserSetVarOptional(cmd, enableDryRunFlagName, enableDryRunEnvKey)
+	enableNoZCAPStr := getUserSetVarOptional(cmd, enableNoZCAPFlagName, enableNoZCAPEnvKey)
+	enableTestVectorsStr := getUserSetVarOptional(cmd, enableTestVectorsFlagName, enableTestVectorsEnvKey)
+	enableRawDerivedKeysStr := getUserSetVarOptional(cmd, enableRawDerivedKeysFlagName, enableRawDerivedKeysEnvKey)
+	debugAuthStr := getUserSetVarOptional(cmd, debugAuthFlagName, debugAuthEnvKey)
 	logLevel := getUserSetVarOptional(cmd, logLevelFlagName, logLevelEnvKey)
 
+	logFormat := getUserSetVarOptional(cmd, logFormatFlagName, logFormatEnvKey)
+	if logFormat != reqlog.FormatText && logFormat != reqlog.FormatJSON {
+		return nil, fmt.Errorf("log format must be one of %s, %s: %s", reqlog.FormatText, reqlog.FormatJSON,
+			logFormat)
+	}
+
 	tlsParams, err := getTLS(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("get TLS: %w", err)
@@ -234,6 +1021,21 @@ func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:fun
 		return nil, fmt.Errorf("parse database timeout: %w", err)
 	}
 
+	databaseRetryParams, err := getDatabaseRetryParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	readinessTimeout, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, readinessTimeoutFlagName, readinessTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse readiness timeout: %w", err)
+	}
+
+	if readinessTimeout <= 0 {
+		return nil, fmt.Errorf("readiness timeout must be positive: %s", readinessTimeout)
+	}
+
 	var keyStoreCacheTTL time.Duration
 
 	if keyStoreCacheTTLStr != "" {
@@ -243,6 +1045,16 @@ func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:fun
 		}
 	}
 
+	keyStoreCacheTTLMax, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyStoreCacheTTLMaxFlagName, keyStoreCacheTTLMaxEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key store cache ttl max: %w", err)
+	}
+
+	if keyStoreCacheTTLMax < 0 {
+		return nil, fmt.Errorf("key store cache ttl max must not be negative: %s", keyStoreCacheTTLMax)
+	}
+
 	var kmsCacheTTL time.Duration
 	if kmsCacheTTLStr != "" {
 		kmsCacheTTL, err = time.ParseDuration(kmsCacheTTLStr)
@@ -264,16 +1076,213 @@ func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:fun
 		return nil, fmt.Errorf("parse enableCache: %w", err)
 	}
 
+	cacheType, cacheURL, err := getCacheType(cmd, secretManager)
+	if err != nil {
+		return nil, err
+	}
+
 	disableAuth, err := strconv.ParseBool(disableAuthStr)
 	if err != nil {
 		return nil, fmt.Errorf("parse disableAuth: %w", err)
 	}
 
+	disableEDV, err := strconv.ParseBool(disableEDVStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse disableEDV: %w", err)
+	}
+
 	enableCORS, err := strconv.ParseBool(enableCORSStr)
 	if err != nil {
 		return nil, fmt.Errorf("parse enableCORS: %w", err)
 	}
 
+	enableDryRun, err := strconv.ParseBool(enableDryRunStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse enableDryRun: %w", err)
+	}
+
+	enableNoZCAP, err := strconv.ParseBool(enableNoZCAPStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse enableNoZCAPKeyStores: %w", err)
+	}
+
+	enableTestVectors, err := strconv.ParseBool(enableTestVectorsStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse enableTestVectors: %w", err)
+	}
+
+	enableRawDerivedKeys, err := strconv.ParseBool(enableRawDerivedKeysStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse enableRawDerivedKeys: %w", err)
+	}
+
+	debugAuth, err := strconv.ParseBool(debugAuthStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse debugAuth: %w", err)
+	}
+
+	loadShedParams, err := getLoadShedParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencyParams, err := getDependencyParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	cryptoPoolParams, err := getCryptoPoolParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyCacheParams, err := getVerifyCacheParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	keyStoreLimitParams, err := getKeyStoreLimitParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	signNonceTTL, err := time.ParseDuration(getUserSetVarOptional(cmd, signNonceTTLFlagName, signNonceTTLEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse sign nonce ttl: %w", err)
+	}
+
+	uploadTTL, err := time.ParseDuration(getUserSetVarOptional(cmd, uploadTTLFlagName, uploadTTLEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse upload ttl: %w", err)
+	}
+
+	keyStoreIdemTTL, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyStoreIdempotencyTTLFlagName, keyStoreIdempotencyTTLEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key store idempotency ttl: %w", err)
+	}
+
+	keyExpiryClockSkew, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyExpiryClockSkewFlagName, keyExpiryClockSkewEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key expiry clock skew: %w", err)
+	}
+
+	controllerGrace, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, controllerGracePeriodFlagName, controllerGracePeriodEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse controller rotation grace period: %w", err)
+	}
+
+	keyRetentionPeriod, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyRetentionPeriodFlagName, keyRetentionPeriodEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key retention period: %w", err)
+	}
+
+	if keyRetentionPeriod <= 0 {
+		return nil, fmt.Errorf("key retention period must be positive: %s", keyRetentionPeriod)
+	}
+
+	keyPurgeInterval, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyPurgeIntervalFlagName, keyPurgeIntervalEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key purge interval: %w", err)
+	}
+
+	if keyPurgeInterval <= 0 {
+		return nil, fmt.Errorf("key purge interval must be positive: %s", keyPurgeInterval)
+	}
+
+	recordPruneInterval, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, recordPruneIntervalFlagName, recordPruneIntervalEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse expired record prune interval: %w", err)
+	}
+
+	if recordPruneInterval <= 0 {
+		return nil, fmt.Errorf("expired record prune interval must be positive: %s", recordPruneInterval)
+	}
+
+	keyUsageInterval, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, keyUsageIntervalFlagName, keyUsageIntervalEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key usage interval: %w", err)
+	}
+
+	if keyUsageInterval <= 0 {
+		return nil, fmt.Errorf("key usage interval must be positive: %s", keyUsageInterval)
+	}
+
+	disableKeyUsage, err := strconv.ParseBool(
+		getUserSetVarOptional(cmd, disableKeyUsageFlagName, disableKeyUsageEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse disable key usage tracking: %w", err)
+	}
+
+	signBatchMaxSize, err := strconv.Atoi(getUserSetVarOptional(cmd, signBatchMaxSizeFlagName, signBatchMaxSizeEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse sign batch max size: %w", err)
+	}
+
+	if signBatchMaxSize <= 0 {
+		return nil, fmt.Errorf("sign batch max size must be positive: %d", signBatchMaxSize)
+	}
+
+	signMultiKeyMaxMsg, signMultiKeyMaxTotal, err := getSignMultiKeyLimits(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	callerNonceWindow, err := getCallerNonceWindow(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	requestLimits, err := getRequestLimits(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	maxCapabilitySize, err := strconv.ParseInt(getUserSetVarOptional(cmd, requestMaxCapabilitySizeFlagName,
+		requestMaxCapabilitySizeEnvKey), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse request max capability size: %w", err)
+	}
+
+	if maxCapabilitySize <= 0 {
+		return nil, fmt.Errorf("request max capability size must be positive: %d", maxCapabilitySize)
+	}
+
+	rsaKeyPoolSize, err := strconv.Atoi(getUserSetVarOptional(cmd, rsaKeyPoolSizeFlagName, rsaKeyPoolSizeEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse rsa key pool size: %w", err)
+	}
+
+	if rsaKeyPoolSize < 0 {
+		return nil, fmt.Errorf("rsa key pool size must not be negative: %d", rsaKeyPoolSize)
+	}
+
+	didcommMediatorURL := getUserSetVarOptional(cmd, didcommMediatorURLFlagName, didcommMediatorURLEnvKey)
+
+	if didcommMediatorURL != "" {
+		if u, parseErr := url.Parse(didcommMediatorURL); parseErr != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("DIDComm mediator url must be an absolute URL: %s", didcommMediatorURL)
+		}
+	}
+
+	var signCanonicalization []string
+
+	if profiles := getUserSetVarOptional(cmd, signCanonicalizationFlagName, signCanonicalizationEnvKey); profiles != "" {
+		signCanonicalization = strings.Split(profiles, ",")
+	}
+
+	var disabledOperations []string
+
+	if operations := getUserSetVarOptional(cmd, disabledOperationsFlagName, disabledOperationsEnvKey); operations != "" {
+		disabledOperations = strings.Split(operations, ",")
+	}
+
 	secretLockParams, err := getSecretLockParameters(cmd)
 	if err != nil {
 		return nil, err
@@ -284,27 +1293,96 @@ func getParameters(cmd *cobra.Command) (*serverParameters, error) { //nolint:fun
 		return nil, fmt.Errorf("get GNAP signing key path: %w", err)
 	}
 
-	return &serverParameters{
-		host:                 host,
-		metricsHost:          metricsHost,
-		baseURL:              baseURL,
-		tlsParams:            tlsParams,
-		databaseType:         databaseType,
-		databaseURL:          databaseURL,
-		databasePrefix:       databasePrefix,
-		databaseTimeout:      databaseTimeout,
-		didDomain:            didDomain,
-		authServerURL:        authServerURL,
-		authServerToken:      authServerToken,
-		keyStoreCacheTTL:     keyStoreCacheTTL,
-		kmsCacheTTL:          kmsCacheTTL,
-		shamirSecretCacheTTL: shamirSecretCacheTTL,
-		enableCache:          enableCache,
-		disableAuth:          disableAuth,
-		enableCORS:           enableCORS,
-		logLevel:             logLevel,
-		secretLockParams:     secretLockParams,
-		gnapSigningKeyPath:   gnapSigningKeyPath,
+	respSigningParams, err := getResponseSigningParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	replicationParams, err := getReplicationParameters(cmd, tlsParams, secretManager)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthParams, err := getOAuthParameters(cmd, secretManager)
+	if err != nil {
+		return nil, err
+	}
+
+	keyArchiveParams, err := getKeyArchiveParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	usageReportParams, err := getUsageReportParameters(cmd, respSigningParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Parameters{
+		Host:                          host,
+		MetricsHost:                   metricsHost,
+		BaseURL:                       baseURL,
+		TLS:                           tlsParams,
+		DatabaseType:                  databaseType,
+		DatabaseURL:                   databaseURL,
+		DatabasePrefix:                databasePrefix,
+		DatabaseTimeout:               databaseTimeout,
+		DatabaseRetry:                 databaseRetryParams,
+		ReadinessTimeout:              readinessTimeout,
+		DIDDomain:                     didDomain,
+		AuthServerURL:                 authServerURL,
+		AuthServerToken:               authServerToken,
+		AdminToken:                    getSecret(cmd, secretManager, adminTokenFlagName, adminTokenEnvKey),
+		KeyStoreCacheTTL:              keyStoreCacheTTL,
+		KeyStoreCacheTTLMax:           keyStoreCacheTTLMax,
+		KMSCacheTTL:                   kmsCacheTTL,
+		ShamirSecretCacheTTL:          shamirSecretCacheTTL,
+		EnableCache:                   enableCache,
+		CacheType:                     cacheType,
+		CacheURL:                      cacheURL,
+		LoadShed:                      loadShedParams,
+		Dependencies:                  dependencyParams,
+		CryptoPools:                   cryptoPoolParams,
+		VerifyCache:                   verifyCacheParams,
+		KeyStoreLimits:                keyStoreLimitParams,
+		SignNonceTTL:                  signNonceTTL,
+		UploadTTL:                     uploadTTL,
+		SignCanonicalization:          signCanonicalization,
+		DisabledOperations:            disabledOperations,
+		KeyStoreIdempotencyTTL:        keyStoreIdemTTL,
+		KeyExpiryClockSkew:            keyExpiryClockSkew,
+		ControllerRotationGracePeriod: controllerGrace,
+		KeyRetentionPeriod:            keyRetentionPeriod,
+		KeyPurgeInterval:              keyPurgeInterval,
+		RecordPruneInterval:           recordPruneInterval,
+		KeyUsageInterval:              keyUsageInterval,
+		DisableKeyUsage:               disableKeyUsage,
+		KeyArchive:                    keyArchiveParams,
+		UsageReport:                   usageReportParams,
+		SignBatchMaxSize:              signBatchMaxSize,
+		SignMultiKeyMaxMessageSize:    signMultiKeyMaxMsg,
+		SignMultiKeyMaxTotalSize:      signMultiKeyMaxTotal,
+		CallerNonceWindow:             callerNonceWindow,
+		RequestLimits:                 requestLimits,
+		MaxCapabilitySize:             maxCapabilitySize,
+		RSAKeyPoolSize:                rsaKeyPoolSize,
+		DIDCommMediatorURL:            didcommMediatorURL,
+		SLOConfigPath:                 getUserSetVarOptional(cmd, sloConfigPathFlagName, sloConfigPathEnvKey),
+		DisableAuth:                   disableAuth,
+		DisableEDV:                    disableEDV,
+		EnableCORS:                    enableCORS,
+		EnableDryRun:                  enableDryRun,
+		EnableNoZCAP:                  enableNoZCAP,
+		EnableTestVectors:             enableTestVectors,
+		EnableRawDerivedKeys:          enableRawDerivedKeys,
+		DebugAuth:                     debugAuth,
+		LogLevel:                      logLevel,
+		LogFormat:                     logFormat,
+		SecretLock:                    secretLockParams,
+		GNAPSigningKeyPath:            gnapSigningKeyPath,
+		ResponseSigning:               respSigningParams,
+		Replication:                   replicationParams,
+		OAuth:                         oauthParams,
 	}, nil
 }
 
@@ -333,7 +1411,37 @@ func getUserSetVar(cmd *cobra.Command, flagName, envKey string, isOptional bool)
 		flagName, envKey)
 }
 
-func getTLS(cmd *cobra.Command) (*tlsParameters, error) {
+// getSecret returns a secret set by the flag or moved from the environment variable. Values of flags stay visible in
+// the process arguments, so sensitive values should be set with environment variables.
+func getSecret(cmd *cobra.Command, manager *secrets.Manager, flagName, envKey string) *secrets.Secret {
+	if cmd.Flags().Changed(flagName) {
+		val, _ := cmd.Flags().GetString(flagName) //nolint:errcheck // flag is defined as a string
+
+		return secrets.New([]byte(val))
+	}
+
+	return manager.FromEnv(envKey)
+}
+
+func getCacheType(cmd *cobra.Command, secretManager *secrets.Manager) (string, *secrets.Secret, error) {
+	cacheType := getUserSetVarOptional(cmd, cacheTypeFlagName, cacheTypeEnvKey)
+	cacheURL := getSecret(cmd, secretManager, cacheURLFlagName, cacheURLEnvKey)
+
+	switch cacheType {
+	case cacheTypeMemoryOption:
+	case cacheTypeRedisOption:
+		if cacheURL.IsEmpty() {
+			return "", nil, fmt.Errorf("%s is required if the cache type is %s", cacheURLFlagName, cacheTypeRedisOption)
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported cache type %q: must be one of %s, %s", cacheType,
+			cacheTypeMemoryOption, cacheTypeRedisOption)
+	}
+
+	return cacheType, cacheURL, nil
+}
+
+func getTLS(cmd *cobra.Command) (*TLSParameters, error) {
 	tlsSystemCertPoolStr := getUserSetVarOptional(cmd, tlsSystemCertPoolFlagName, tlsSystemCertPoolEnvKey)
 	tlsCACerts := getUserSetVarOptional(cmd, tlsCACertsFlagName, tlsCACertsEnvKey)
 	tlsServeCertPath := getUserSetVarOptional(cmd, tlsServeCertPathFlagName, tlsServeCertPathEnvKey)
@@ -349,15 +1457,15 @@ func getTLS(cmd *cobra.Command) (*tlsParameters, error) {
 		caCerts = strings.Split(tlsCACerts, ",")
 	}
 
-	return &tlsParameters{
-		systemCertPool: tlsSystemCertPool,
-		caCerts:        caCerts,
-		serveCertPath:  tlsServeCertPath,
-		serveKeyPath:   tlsServeKeyPath,
+	return &TLSParameters{
+		SystemCertPool: tlsSystemCertPool,
+		CACerts:        caCerts,
+		ServeCertPath:  tlsServeCertPath,
+		ServeKeyPath:   tlsServeKeyPath,
 	}, nil
 }
 
-func getSecretLockParameters(cmd *cobra.Command) (*secretLockParameters, error) {
+func getSecretLockParameters(cmd *cobra.Command) (*SecretLockParameters, error) {
 	secretLockType, err := getUserSetVar(cmd, secretLockTypeFlagName, secretLockTypeEnvKey, false)
 	if err != nil {
 		return nil, err
@@ -368,7 +1476,7 @@ func getSecretLockParameters(cmd *cobra.Command) (*secretLockParameters, error)
 		return nil, err
 	}
 
-	isAWS := secretLockType == secretLockTypeAWSOption
+	isAWS := strings.EqualFold(secretLockType, secretLockTypeAWSOption)
 
 	keyURI, err := getUserSetVar(cmd, secretLockAWSKeyURIFlagName, secretLockAWSKeyURIEnvKey, !isAWS)
 	if err != nil {
@@ -380,11 +1488,513 @@ func getSecretLockParameters(cmd *cobra.Command) (*secretLockParameters, error)
 		return nil, err
 	}
 
-	return &secretLockParameters{
-		secretLockType: secretLockType,
-		localKeyPath:   localKeyPath,
-		awsKeyURI:      keyURI,
-		awsEndpoint:    awsEndpoint,
+	return &SecretLockParameters{
+		Type:         secretLockType,
+		LocalKeyPath: localKeyPath,
+		AWSKeyURI:    keyURI,
+		AWSEndpoint:  awsEndpoint,
+		AWSRoleARN:   getUserSetVarOptional(cmd, secretLockAWSRoleARNFlagName, secretLockAWSRoleARNEnvKey),
+		AWSWebIdentityTokenPath: getUserSetVarOptional(cmd, secretLockAWSTokenPathFlagName,
+			secretLockAWSTokenPathEnvKey),
+		AWSSTSEndpoint: getUserSetVarOptional(cmd, secretLockAWSSTSEndpointFlagName,
+			secretLockAWSSTSEndpointEnvKey),
+	}, nil
+}
+
+func getResponseSigningParameters(cmd *cobra.Command) (*ResponseSigningParameters, error) {
+	keyPath := getUserSetVarOptional(cmd, responseSigningKeyPathFlagName, responseSigningKeyPathEnvKey)
+	retiredKeysStr := getUserSetVarOptional(cmd, responseSigningRetiredKeysFlagName, responseSigningRetiredKeysEnvKey)
+	overlapStr := getUserSetVarOptional(cmd, responseSigningOverlapFlagName, responseSigningOverlapEnvKey)
+
+	overlap, err := time.ParseDuration(overlapStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse response signing overlap: %w", err)
+	}
+
+	var retiredKeyPaths []string
+
+	if retiredKeysStr != "" {
+		retiredKeyPaths = strings.Split(retiredKeysStr, ",")
+	}
+
+	return &ResponseSigningParameters{
+		KeyPath:         keyPath,
+		RetiredKeyPaths: retiredKeyPaths,
+		Overlap:         overlap,
+	}, nil
+}
+
+func getReplicationParameters(
+	cmd *cobra.Command, tlsParams *TLSParameters, secretManager *secrets.Manager,
+) (*ReplicationParameters, error) {
+	params := &ReplicationParameters{
+		Mode:        getUserSetVarOptional(cmd, replicationModeFlagName, replicationModeEnvKey),
+		StandbyURL:  getUserSetVarOptional(cmd, replicationStandbyURLFlagName, replicationStandbyURLEnvKey),
+		IngestHost:  getUserSetVarOptional(cmd, replicationIngestHostFlagName, replicationIngestHostEnvKey),
+		Token:       getSecret(cmd, secretManager, replicationTokenFlagName, replicationTokenEnvKey),
+		TLSCertPath: getUserSetVarOptional(cmd, replicationTLSCertFlagName, replicationTLSCertEnvKey),
+		TLSKeyPath:  getUserSetVarOptional(cmd, replicationTLSKeyFlagName, replicationTLSKeyEnvKey),
+	}
+
+	switch params.Mode {
+	case "":
+		return params, nil
+	case replication.ModePrimary:
+		if params.StandbyURL == "" {
+			return nil, fmt.Errorf("%s is required in primary replication mode", replicationStandbyURLFlagName)
+		}
+	case replication.ModeStandby:
+		if params.IngestHost == "" {
+			return nil, fmt.Errorf("%s is required in standby replication mode", replicationIngestHostFlagName)
+		}
+
+		if tlsParams.ServeCertPath == "" || tlsParams.ServeKeyPath == "" {
+			return nil, fmt.Errorf("%s and %s are required in standby replication mode",
+				tlsServeCertPathFlagName, tlsServeKeyPathFlagName)
+		}
+	default:
+		return nil, fmt.Errorf("invalid replication mode: %s", params.Mode)
+	}
+
+	if params.Token.IsEmpty() {
+		return nil, fmt.Errorf("%s is required for replication", replicationTokenFlagName)
+	}
+
+	return params, nil
+}
+
+const day = 24 * time.Hour
+
+// getKeyArchiveParameters returns nil if the key archive type isn't set.
+func getKeyArchiveParameters(cmd *cobra.Command) (*KeyArchiveParameters, error) {
+	typ := getUserSetVarOptional(cmd, keyArchiveTypeFlagName, keyArchiveTypeEnvKey)
+	if typ == "" {
+		return nil, nil
+	}
+
+	params := &KeyArchiveParameters{
+		Type:       typ,
+		URL:        getUserSetVarOptional(cmd, keyArchiveURLFlagName, keyArchiveURLEnvKey),
+		Prefix:     getUserSetVarOptional(cmd, keyArchivePrefixFlagName, keyArchivePrefixEnvKey),
+		S3Region:   getUserSetVarOptional(cmd, keyArchiveS3RegionFlagName, keyArchiveS3RegionEnvKey),
+		S3Endpoint: getUserSetVarOptional(cmd, keyArchiveS3EndpointFlagName, keyArchiveS3EndpointEnvKey),
+	}
+
+	if strings.EqualFold(typ, keyArchiveTypeS3Option) && params.URL == "" {
+		return nil, fmt.Errorf("%s is required for s3 key archive", keyArchiveURLFlagName)
+	}
+
+	days, err := strconv.Atoi(getUserSetVarOptional(cmd, keyArchiveAfterDaysFlagName, keyArchiveAfterDaysEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key archive after days: %w", err)
+	}
+
+	if days <= 0 {
+		return nil, fmt.Errorf("key archive after days must be positive: %d", days)
+	}
+
+	params.After = time.Duration(days) * day
+
+	params.Interval, err = time.ParseDuration(
+		getUserSetVarOptional(cmd, keyArchiveIntervalFlagName, keyArchiveIntervalEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key archive interval: %w", err)
+	}
+
+	if params.Interval <= 0 {
+		return nil, fmt.Errorf("key archive interval must be positive: %s", params.Interval)
+	}
+
+	return params, nil
+}
+
+// getUsageReportParameters returns nil if the usage report target isn't set.
+func getUsageReportParameters(cmd *cobra.Command,
+	respSigning *ResponseSigningParameters) (*UsageReportParameters, error) {
+	target := getUserSetVarOptional(cmd, usageReportTargetFlagName, usageReportTargetEnvKey)
+	if target == "" {
+		return nil, nil
+	}
+
+	if !strings.EqualFold(target, usageReportTargetWebhookOption) &&
+		!strings.EqualFold(target, usageReportTargetS3Option) {
+		return nil, fmt.Errorf("unsupported usage report target: %s", target)
+	}
+
+	// reports are signed with the server identity key
+	if respSigning == nil || respSigning.KeyPath == "" {
+		return nil, fmt.Errorf("%s is required for usage reports", responseSigningKeyPathFlagName)
+	}
+
+	params := &UsageReportParameters{
+		Target:     target,
+		URL:        getUserSetVarOptional(cmd, usageReportURLFlagName, usageReportURLEnvKey),
+		S3Prefix:   getUserSetVarOptional(cmd, usageReportS3PrefixFlagName, usageReportS3PrefixEnvKey),
+		S3Region:   getUserSetVarOptional(cmd, usageReportS3RegionFlagName, usageReportS3RegionEnvKey),
+		S3Endpoint: getUserSetVarOptional(cmd, usageReportS3EndpointFlagName, usageReportS3EndpointEnvKey),
+	}
+
+	if params.URL == "" {
+		return nil, fmt.Errorf("%s is required for usage reports", usageReportURLFlagName)
+	}
+
+	var err error
+
+	params.RateLimit, err = strconv.Atoi(
+		getUserSetVarOptional(cmd, usageReportRateLimitFlagName, usageReportRateLimitEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse usage report rate limit: %w", err)
+	}
+
+	if params.RateLimit <= 0 {
+		return nil, fmt.Errorf("usage report rate limit must be positive: %d", params.RateLimit)
+	}
+
+	params.MaxRetries, err = strconv.Atoi(
+		getUserSetVarOptional(cmd, usageReportMaxRetriesFlagName, usageReportMaxRetriesEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse usage report max retries: %w", err)
+	}
+
+	if params.MaxRetries <= 0 {
+		return nil, fmt.Errorf("usage report max retries must be positive: %d", params.MaxRetries)
+	}
+
+	return params, nil
+}
+
+func getOAuthParameters(cmd *cobra.Command, secretManager *secrets.Manager) (*OAuthParameters, error) {
+	params := &OAuthParameters{
+		TokenURL:           getUserSetVarOptional(cmd, oauthTokenURLFlagName, oauthTokenURLEnvKey),
+		ClientID:           getUserSetVarOptional(cmd, oauthClientIDFlagName, oauthClientIDEnvKey),
+		ClientSecret:       getSecret(cmd, secretManager, oauthClientSecretFlagName, oauthClientSecretEnvKey),
+		ClientKeyPath:      getUserSetVarOptional(cmd, oauthClientKeyPathFlagName, oauthClientKeyPathEnvKey),
+		AuthServerAudience: getUserSetVarOptional(cmd, oauthAuthServerAudienceFlagName, oauthAuthServerAudienceEnvKey),
+	}
+
+	if scopes := getUserSetVarOptional(cmd, oauthScopesFlagName, oauthScopesEnvKey); scopes != "" {
+		params.Scopes = strings.Split(scopes, ",")
+	}
+
+	if params.TokenURL == "" {
+		return params, nil
+	}
+
+	if params.ClientID == "" {
+		return nil, fmt.Errorf("%s is required with %s", oauthClientIDFlagName, oauthTokenURLFlagName)
+	}
+
+	if params.ClientSecret.IsEmpty() && params.ClientKeyPath == "" {
+		return nil, fmt.Errorf("%s or %s is required with %s",
+			oauthClientSecretFlagName, oauthClientKeyPathFlagName, oauthTokenURLFlagName)
+	}
+
+	return params, nil
+}
+
+func getLoadShedParameters(cmd *cobra.Command) (*LoadShedParameters, error) {
+	maxHeapStr := getUserSetVarOptional(cmd, loadShedMaxHeapFlagName, loadShedMaxHeapEnvKey)
+	maxGoroutinesStr := getUserSetVarOptional(cmd, loadShedMaxGoroutinesFlagName, loadShedMaxGoroutinesEnvKey)
+	sampleIntervalStr := getUserSetVarOptional(cmd, loadShedSampleIntervalFlagName, loadShedSampleIntervalEnvKey)
+
+	maxHeapBytes, err := strconv.ParseUint(maxHeapStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse load shed max heap: %w", err)
+	}
+
+	maxGoroutines, err := strconv.Atoi(maxGoroutinesStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse load shed max goroutines: %w", err)
+	}
+
+	sampleInterval, err := time.ParseDuration(sampleIntervalStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse load shed sample interval: %w", err)
+	}
+
+	return &LoadShedParameters{
+		MaxHeapBytes:   maxHeapBytes,
+		MaxGoroutines:  maxGoroutines,
+		SampleInterval: sampleInterval,
+	}, nil
+}
+
+func getDependencyParameters(cmd *cobra.Command) (*DependencyParameters, error) { //nolint:funlen
+	hubAuthTimeout, err := time.ParseDuration(getUserSetVarOptional(cmd, hubAuthTimeoutFlagName,
+		hubAuthTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse hub-auth timeout: %w", err)
+	}
+
+	edvTimeout, err := time.ParseDuration(getUserSetVarOptional(cmd, edvTimeoutFlagName, edvTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse edv timeout: %w", err)
+	}
+
+	didResolverTimeout, err := time.ParseDuration(getUserSetVarOptional(cmd, didResolverTimeoutFlagName,
+		didResolverTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse did resolver timeout: %w", err)
+	}
+
+	webhookTimeout, err := time.ParseDuration(getUserSetVarOptional(cmd, webhookTimeoutFlagName,
+		webhookTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse webhook timeout: %w", err)
+	}
+
+	failureRate, err := strconv.ParseFloat(getUserSetVarOptional(cmd, breakerFailureRateFlagName,
+		breakerFailureRateEnvKey), 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse breaker failure rate: %w", err)
+	}
+
+	if failureRate <= 0 || failureRate > 1 {
+		return nil, fmt.Errorf("%s must be greater than 0 and at most 1", breakerFailureRateFlagName)
+	}
+
+	minCalls, err := strconv.Atoi(getUserSetVarOptional(cmd, breakerMinCallsFlagName, breakerMinCallsEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse breaker min calls: %w", err)
+	}
+
+	window, err := time.ParseDuration(getUserSetVarOptional(cmd, breakerWindowFlagName, breakerWindowEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse breaker window: %w", err)
+	}
+
+	openTimeout, err := time.ParseDuration(getUserSetVarOptional(cmd, breakerOpenTimeoutFlagName,
+		breakerOpenTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse breaker open timeout: %w", err)
+	}
+
+	return &DependencyParameters{
+		HubAuthTimeout:     hubAuthTimeout,
+		EDVTimeout:         edvTimeout,
+		DIDResolverTimeout: didResolverTimeout,
+		WebhookTimeout:     webhookTimeout,
+		Breaker: breaker.Config{
+			FailureRate: failureRate,
+			MinCalls:    minCalls,
+			Window:      window,
+			OpenTimeout: openTimeout,
+		},
+	}, nil
+}
+
+func getCryptoPoolParameters(cmd *cobra.Command) (*CryptoPoolParameters, error) {
+	cheapWorkersStr := getUserSetVarOptional(cmd, cryptoCheapWorkersFlagName, cryptoCheapWorkersEnvKey)
+	expensiveWorkersStr := getUserSetVarOptional(cmd, cryptoExpensiveWorkersFlagName, cryptoExpensiveWorkersEnvKey)
+	expensiveQueueSizeStr := getUserSetVarOptional(cmd, cryptoExpensiveQueueSizeFlagName,
+		cryptoExpensiveQueueSizeEnvKey)
+
+	cheapWorkers, err := strconv.Atoi(cheapWorkersStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse crypto cheap workers: %w", err)
+	}
+
+	expensiveWorkers, err := strconv.Atoi(expensiveWorkersStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse crypto expensive workers: %w", err)
+	}
+
+	expensiveQueueSize, err := strconv.Atoi(expensiveQueueSizeStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse crypto expensive queue size: %w", err)
+	}
+
+	if cheapWorkers < 0 || expensiveWorkers < 0 || expensiveQueueSize < 0 {
+		return nil, fmt.Errorf("crypto workers and queue size must not be negative: %d, %d, %d",
+			cheapWorkers, expensiveWorkers, expensiveQueueSize)
+	}
+
+	return &CryptoPoolParameters{
+		CheapWorkers:       cheapWorkers,
+		ExpensiveWorkers:   expensiveWorkers,
+		ExpensiveQueueSize: expensiveQueueSize,
+	}, nil
+}
+
+func getSignMultiKeyLimits(cmd *cobra.Command) (int, int, error) {
+	maxMessage, err := strconv.Atoi(getUserSetVarOptional(cmd, signMultiKeyMaxMessageFlagName,
+		signMultiKeyMaxMessageEnvKey))
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse sign multi-key max message size: %w", err)
+	}
+
+	maxTotal, err := strconv.Atoi(getUserSetVarOptional(cmd, signMultiKeyMaxTotalFlagName,
+		signMultiKeyMaxTotalEnvKey))
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse sign multi-key max total size: %w", err)
+	}
+
+	if maxMessage <= 0 || maxTotal <= 0 {
+		return 0, 0, fmt.Errorf("sign multi-key limits must be positive: %d, %d", maxMessage, maxTotal)
+	}
+
+	return maxMessage, maxTotal, nil
+}
+
+// getCallerNonceWindow returns the reuse window of caller nonces, or zero if caller nonces aren't enabled.
+func getCallerNonceWindow(cmd *cobra.Command) (time.Duration, error) {
+	enabled, err := strconv.ParseBool(getUserSetVarOptional(cmd, enableCallerNoncesFlagName,
+		enableCallerNoncesEnvKey))
+	if err != nil {
+		return 0, fmt.Errorf("parse enableCallerNonces: %w", err)
+	}
+
+	if !enabled {
+		return 0, nil
+	}
+
+	window, err := time.ParseDuration(getUserSetVarOptional(cmd, callerNonceReuseWindowFlagName,
+		callerNonceReuseWindowEnvKey))
+	if err != nil {
+		return 0, fmt.Errorf("parse caller nonce reuse window: %w", err)
+	}
+
+	if window <= 0 {
+		return 0, fmt.Errorf("caller nonce reuse window must be positive: %s", window)
+	}
+
+	return window, nil
+}
+
+func getRequestLimits(cmd *cobra.Command) (jsonlimit.Limits, error) {
+	maxDepth, err := strconv.Atoi(getUserSetVarOptional(cmd, requestMaxDepthFlagName, requestMaxDepthEnvKey))
+	if err != nil {
+		return jsonlimit.Limits{}, fmt.Errorf("parse request max depth: %w", err)
+	}
+
+	maxArrayLength, err := strconv.Atoi(getUserSetVarOptional(cmd, requestMaxArrayLengthFlagName,
+		requestMaxArrayLengthEnvKey))
+	if err != nil {
+		return jsonlimit.Limits{}, fmt.Errorf("parse request max array length: %w", err)
+	}
+
+	maxStringLength, err := strconv.Atoi(getUserSetVarOptional(cmd, requestMaxStringLengthFlagName,
+		requestMaxStringLengthEnvKey))
+	if err != nil {
+		return jsonlimit.Limits{}, fmt.Errorf("parse request max string length: %w", err)
+	}
+
+	if maxDepth <= 0 || maxArrayLength <= 0 || maxStringLength <= 0 {
+		return jsonlimit.Limits{}, fmt.Errorf("request limits must be positive: %d, %d, %d",
+			maxDepth, maxArrayLength, maxStringLength)
+	}
+
+	return jsonlimit.Limits{
+		MaxDepth:        maxDepth,
+		MaxArrayLength:  maxArrayLength,
+		MaxStringLength: maxStringLength,
+	}, nil
+}
+
+func getDatabaseRetryParameters(cmd *cobra.Command) (*DatabaseRetryParameters, error) {
+	maxRetries, err := strconv.Atoi(getUserSetVarOptional(cmd, databaseRetriesFlagName, databaseRetriesEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse database retries: %w", err)
+	}
+
+	if maxRetries < 0 {
+		return nil, fmt.Errorf("database retries must not be negative: %d", maxRetries)
+	}
+
+	backoff, err := time.ParseDuration(getUserSetVarOptional(cmd, databaseRetryBackoffFlagName,
+		databaseRetryBackoffEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse database retry backoff: %w", err)
+	}
+
+	maxBackoff, err := time.ParseDuration(getUserSetVarOptional(cmd, databaseRetryMaxBackoffFlagName,
+		databaseRetryMaxBackoffEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse database retry max backoff: %w", err)
+	}
+
+	if backoff <= 0 || maxBackoff < backoff {
+		return nil, fmt.Errorf("database retry backoff must be positive and not exceed the max backoff: %s, %s",
+			backoff, maxBackoff)
+	}
+
+	return &DatabaseRetryParameters{
+		MaxRetries: maxRetries,
+		Backoff:    backoff,
+		MaxBackoff: maxBackoff,
+	}, nil
+}
+
+func getVerifyCacheParameters(cmd *cobra.Command) (*VerifyCacheParameters, error) {
+	ttlStr := getUserSetVarOptional(cmd, verifyCacheTTLFlagName, verifyCacheTTLEnvKey)
+	sizeStr := getUserSetVarOptional(cmd, verifyCacheSizeFlagName, verifyCacheSizeEnvKey)
+
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil {
+		return nil, fmt.Errorf("parse verify cache ttl: %w", err)
+	}
+
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse verify cache size: %w", err)
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("verify cache size must be positive: %d", size)
+	}
+
+	return &VerifyCacheParameters{
+		TTL:  ttl,
+		Size: size,
+	}, nil
+}
+
+func getKeyStoreLimitParameters(cmd *cobra.Command) (*KeyStoreLimitParameters, error) {
+	rateLimit, err := strconv.ParseFloat(
+		getUserSetVarOptional(cmd, keyStoreRateLimitFlagName, keyStoreRateLimitEnvKey), 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse key store rate limit: %w", err)
+	}
+
+	if !(rateLimit >= 0) || math.IsInf(rateLimit, 0) {
+		return nil, fmt.Errorf("key store rate limit must be a non-negative number: %g", rateLimit)
+	}
+
+	rateLimitMax, err := strconv.ParseFloat(
+		getUserSetVarOptional(cmd, keyStoreRateLimitMaxFlagName, keyStoreRateLimitMaxEnvKey), 64)
+	if err != nil {
+		return nil, fmt.Errorf("parse key store rate limit max: %w", err)
+	}
+
+	if !(rateLimitMax >= 0) || math.IsInf(rateLimitMax, 0) {
+		return nil, fmt.Errorf("key store rate limit max must be a non-negative number: %g", rateLimitMax)
+	}
+
+	concurrencyLimit, err := strconv.Atoi(
+		getUserSetVarOptional(cmd, keyStoreConcurrencyLimitFlagName, keyStoreConcurrencyLimitEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key store concurrency limit: %w", err)
+	}
+
+	if concurrencyLimit < 0 {
+		return nil, fmt.Errorf("key store concurrency limit must not be negative: %d", concurrencyLimit)
+	}
+
+	concurrencyLimitMax, err := strconv.Atoi(
+		getUserSetVarOptional(cmd, keyStoreConcurrencyLimitMaxFlagName, keyStoreConcurrencyLimitMaxEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse key store concurrency limit max: %w", err)
+	}
+
+	if concurrencyLimitMax < 0 {
+		return nil, fmt.Errorf("key store concurrency limit max must not be negative: %d", concurrencyLimitMax)
+	}
+
+	return &KeyStoreLimitParameters{
+		RateLimit:           rateLimit,
+		RateLimitMax:        rateLimitMax,
+		ConcurrencyLimit:    concurrencyLimit,
+		ConcurrencyLimitMax: concurrencyLimitMax,
 	}, nil
 }
 
@@ -396,6 +2006,12 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().String(databaseURLFlagName, "", databaseURLFlagUsage)
 	startCmd.Flags().String(databasePrefixFlagName, "", databasePrefixFlagUsage)
 	startCmd.Flags().String(databaseTimeoutFlagName, "30s", databaseTimeoutFlagUsage)
+	startCmd.Flags().String(databaseRetriesFlagName, strconv.Itoa(retry.DefaultMaxRetries), databaseRetriesFlagUsage)
+	startCmd.Flags().String(databaseRetryBackoffFlagName, retry.DefaultInitialInterval.String(),
+		databaseRetryBackoffFlagUsage)
+	startCmd.Flags().String(databaseRetryMaxBackoffFlagName, retry.DefaultMaxInterval.String(),
+		databaseRetryMaxBackoffFlagUsage)
+	startCmd.Flags().String(readinessTimeoutFlagName, readiness.DefaultTimeout.String(), readinessTimeoutFlagUsage)
 	startCmd.Flags().String(tlsSystemCertPoolFlagName, "false", tlsSystemCertPoolFlagUsage)
 	startCmd.Flags().String(tlsCACertsFlagName, "", tlsCACertsFlagUsage)
 	startCmd.Flags().String(tlsServeCertPathFlagName, "", tlsServeCertPathFlagUsage)
@@ -403,18 +2019,110 @@ func createFlags(startCmd *cobra.Command) {
 	startCmd.Flags().String(didDomainFlagName, "", didDomainFlagUsage)
 	startCmd.Flags().String(authServerURLFlagName, "", authServerURLFlagUsage)
 	startCmd.Flags().String(authServerTokenFlagName, "", authServerTokenFlagUsage)
+	startCmd.Flags().String(adminTokenFlagName, "", adminTokenFlagUsage)
 	startCmd.Flags().String(keyStoreCacheTTLFlagName, "10m", keyStoreCacheTTLFlagUsage)
+	startCmd.Flags().String(keyStoreCacheTTLMaxFlagName, "0s", keyStoreCacheTTLMaxFlagUsage)
+	startCmd.Flags().String(keyStoreRateLimitFlagName, "0", keyStoreRateLimitFlagUsage)
+	startCmd.Flags().String(keyStoreRateLimitMaxFlagName, "0", keyStoreRateLimitMaxFlagUsage)
+	startCmd.Flags().String(keyStoreConcurrencyLimitFlagName, "0", keyStoreConcurrencyLimitFlagUsage)
+	startCmd.Flags().String(keyStoreConcurrencyLimitMaxFlagName, "0", keyStoreConcurrencyLimitMaxFlagUsage)
 	startCmd.Flags().String(kmsCacheTTLFlagName, "10m", kmsCacheTTLFlagUsage)
 	startCmd.Flags().String(shamirSecretCacheTTLFlagName, "10m", shamirSecretCacheTTLFlagUsage)
 	startCmd.Flags().String(enableCacheFlagName, "true", enableCacheFlagUsage)
+	startCmd.Flags().String(cacheTypeFlagName, cacheTypeMemoryOption, cacheTypeFlagUsage)
+	startCmd.Flags().String(cacheURLFlagName, "", cacheURLFlagUsage)
+	startCmd.Flags().String(loadShedMaxHeapFlagName, "0", loadShedMaxHeapFlagUsage)
+	startCmd.Flags().String(loadShedMaxGoroutinesFlagName, "0", loadShedMaxGoroutinesFlagUsage)
+	startCmd.Flags().String(loadShedSampleIntervalFlagName, "1s", loadShedSampleIntervalFlagUsage)
+	startCmd.Flags().String(hubAuthTimeoutFlagName, "30s", hubAuthTimeoutFlagUsage)
+	startCmd.Flags().String(edvTimeoutFlagName, "30s", edvTimeoutFlagUsage)
+	startCmd.Flags().String(didResolverTimeoutFlagName, "30s", didResolverTimeoutFlagUsage)
+	startCmd.Flags().String(webhookTimeoutFlagName, "10s", webhookTimeoutFlagUsage)
+	startCmd.Flags().String(breakerFailureRateFlagName, "0.5", breakerFailureRateFlagUsage)
+	startCmd.Flags().String(breakerMinCallsFlagName, "10", breakerMinCallsFlagUsage)
+	startCmd.Flags().String(breakerWindowFlagName, "30s", breakerWindowFlagUsage)
+	startCmd.Flags().String(breakerOpenTimeoutFlagName, "30s", breakerOpenTimeoutFlagUsage)
+	startCmd.Flags().String(cryptoCheapWorkersFlagName, "0", cryptoCheapWorkersFlagUsage)
+	startCmd.Flags().String(cryptoExpensiveWorkersFlagName, "0", cryptoExpensiveWorkersFlagUsage)
+	startCmd.Flags().String(cryptoExpensiveQueueSizeFlagName, "100", cryptoExpensiveQueueSizeFlagUsage)
 	startCmd.Flags().String(disableAuthFlagName, "false", disableAuthFlagUsage)
+	startCmd.Flags().String(disableEDVFlagName, "false", disableEDVFlagUsage)
 	startCmd.Flags().String(enableCORSFlagName, "false", enableCORSFlagUsage)
+	startCmd.Flags().String(enableDryRunFlagName, "false", enableDryRunFlagUsage)
+	startCmd.Flags().String(enableNoZCAPFlagName, "false", enableNoZCAPFlagUsage)
+	startCmd.Flags().String(enableTestVectorsFlagName, "false", enableTestVectorsFlagUsage)
+	startCmd.Flags().String(enableRawDerivedKeysFlagName, "false", enableRawDerivedKeysFlagUsage)
+	startCmd.Flags().String(debugAuthFlagName, "false", debugAuthFlagUsage)
 	startCmd.Flags().String(logLevelFlagName, "info", logLevelFlagUsage)
+	startCmd.Flags().String(logFormatFlagName, reqlog.FormatText, logFormatFlagUsage)
 	startCmd.Flags().String(secretLockTypeFlagName, "", secretLockTypeFlagUsage)
 	startCmd.Flags().String(secretLockKeyPathFlagName, "", secretLockKeyPathFlagUsage)
 	startCmd.Flags().String(secretLockAWSKeyURIFlagName, "", secretLockAWSKeyURIFlagUsage)
 	startCmd.Flags().String(secretLockAWSAccessKeyFlagName, "", secretLockAWSAccessKeyFlagUsage)
 	startCmd.Flags().String(secretLockAWSSecretKeyFlagName, "", secretLockAWSSecretKeyFlagUsage)
 	startCmd.Flags().String(secretLockAWSEndpointFlagName, "", secretLockAWSEndpointFlagUsage)
+	startCmd.Flags().String(secretLockAWSRoleARNFlagName, "", secretLockAWSRoleARNFlagUsage)
+	startCmd.Flags().String(secretLockAWSTokenPathFlagName, webidentity.DefaultTokenPath,
+		secretLockAWSTokenPathFlagUsage)
+	startCmd.Flags().String(secretLockAWSSTSEndpointFlagName, "", secretLockAWSSTSEndpointFlagUsage)
 	startCmd.Flags().String(gnapSigningKeyPathFlagName, "", gnapSigningKeyPathFlagUsage)
+	startCmd.Flags().String(responseSigningKeyPathFlagName, "", responseSigningKeyPathFlagUsage)
+	startCmd.Flags().String(responseSigningRetiredKeysFlagName, "", responseSigningRetiredKeysFlagUsage)
+	startCmd.Flags().String(responseSigningOverlapFlagName, "168h", responseSigningOverlapFlagUsage)
+	startCmd.Flags().String(verifyCacheTTLFlagName, "0s", verifyCacheTTLFlagUsage)
+	startCmd.Flags().String(verifyCacheSizeFlagName, "100000", verifyCacheSizeFlagUsage)
+	startCmd.Flags().String(signNonceTTLFlagName, "5m", signNonceTTLFlagUsage)
+	startCmd.Flags().String(uploadTTLFlagName, "1h", uploadTTLFlagUsage)
+	startCmd.Flags().String(signCanonicalizationFlagName, "none,jcs", signCanonicalizationFlagUsage)
+	startCmd.Flags().String(disabledOperationsFlagName, "", disabledOperationsFlagUsage)
+	startCmd.Flags().String(keyStoreIdempotencyTTLFlagName, "24h", keyStoreIdempotencyTTLFlagUsage)
+	startCmd.Flags().String(keyExpiryClockSkewFlagName, "30s", keyExpiryClockSkewFlagUsage)
+	startCmd.Flags().String(controllerGracePeriodFlagName, "24h", controllerGracePeriodFlagUsage)
+	startCmd.Flags().String(keyRetentionPeriodFlagName, "168h", keyRetentionPeriodFlagUsage)
+	startCmd.Flags().String(keyPurgeIntervalFlagName, "1h", keyPurgeIntervalFlagUsage)
+	startCmd.Flags().String(recordPruneIntervalFlagName, "10m", recordPruneIntervalFlagUsage)
+	startCmd.Flags().String(keyUsageIntervalFlagName, "1h", keyUsageIntervalFlagUsage)
+	startCmd.Flags().String(disableKeyUsageFlagName, "false", disableKeyUsageFlagUsage)
+	startCmd.Flags().String(keyArchiveTypeFlagName, "", keyArchiveTypeFlagUsage)
+	startCmd.Flags().String(keyArchiveURLFlagName, "", keyArchiveURLFlagUsage)
+	startCmd.Flags().String(keyArchivePrefixFlagName, "", keyArchivePrefixFlagUsage)
+	startCmd.Flags().String(keyArchiveS3RegionFlagName, "", keyArchiveS3RegionFlagUsage)
+	startCmd.Flags().String(keyArchiveS3EndpointFlagName, "", keyArchiveS3EndpointFlagUsage)
+	startCmd.Flags().String(keyArchiveAfterDaysFlagName, "180", keyArchiveAfterDaysFlagUsage)
+	startCmd.Flags().String(keyArchiveIntervalFlagName, "24h", keyArchiveIntervalFlagUsage)
+	startCmd.Flags().String(usageReportTargetFlagName, "", usageReportTargetFlagUsage)
+	startCmd.Flags().String(usageReportURLFlagName, "", usageReportURLFlagUsage)
+	startCmd.Flags().String(usageReportS3PrefixFlagName, "", usageReportS3PrefixFlagUsage)
+	startCmd.Flags().String(usageReportS3RegionFlagName, "", usageReportS3RegionFlagUsage)
+	startCmd.Flags().String(usageReportS3EndpointFlagName, "", usageReportS3EndpointFlagUsage)
+	startCmd.Flags().String(usageReportRateLimitFlagName, "10", usageReportRateLimitFlagUsage)
+	startCmd.Flags().String(usageReportMaxRetriesFlagName, "5", usageReportMaxRetriesFlagUsage)
+	startCmd.Flags().String(signBatchMaxSizeFlagName, "100", signBatchMaxSizeFlagUsage)
+	startCmd.Flags().String(signMultiKeyMaxMessageFlagName, "65536", signMultiKeyMaxMessageFlagUsage)
+	startCmd.Flags().String(signMultiKeyMaxTotalFlagName, "1048576", signMultiKeyMaxTotalFlagUsage)
+	startCmd.Flags().String(enableCallerNoncesFlagName, "false", enableCallerNoncesFlagUsage)
+	startCmd.Flags().String(callerNonceReuseWindowFlagName, "24h", callerNonceReuseWindowFlagUsage)
+	startCmd.Flags().String(requestMaxDepthFlagName, strconv.Itoa(jsonlimit.DefaultMaxDepth),
+		requestMaxDepthFlagUsage)
+	startCmd.Flags().String(requestMaxArrayLengthFlagName, strconv.Itoa(jsonlimit.DefaultMaxArrayLength),
+		requestMaxArrayLengthFlagUsage)
+	startCmd.Flags().String(requestMaxStringLengthFlagName, strconv.Itoa(jsonlimit.DefaultMaxStringLength),
+		requestMaxStringLengthFlagUsage)
+	startCmd.Flags().String(requestMaxCapabilitySizeFlagName, strconv.Itoa(gziplimit.DefaultMaxSize),
+		requestMaxCapabilitySizeFlagUsage)
+	startCmd.Flags().String(rsaKeyPoolSizeFlagName, "0", rsaKeyPoolSizeFlagUsage)
+	startCmd.Flags().String(didcommMediatorURLFlagName, "", didcommMediatorURLFlagUsage)
+	startCmd.Flags().String(sloConfigPathFlagName, "", sloConfigPathFlagUsage)
+	startCmd.Flags().String(replicationModeFlagName, "", replicationModeFlagUsage)
+	startCmd.Flags().String(replicationStandbyURLFlagName, "", replicationStandbyURLFlagUsage)
+	startCmd.Flags().String(replicationIngestHostFlagName, "", replicationIngestHostFlagUsage)
+	startCmd.Flags().String(replicationTokenFlagName, "", replicationTokenFlagUsage)
+	startCmd.Flags().String(replicationTLSCertFlagName, "", replicationTLSCertFlagUsage)
+	startCmd.Flags().String(replicationTLSKeyFlagName, "", replicationTLSKeyFlagUsage)
+	startCmd.Flags().String(oauthTokenURLFlagName, "", oauthTokenURLFlagUsage)
+	startCmd.Flags().String(oauthClientIDFlagName, "", oauthClientIDFlagUsage)
+	startCmd.Flags().String(oauthClientSecretFlagName, "", oauthClientSecretFlagUsage)
+	startCmd.Flags().String(oauthClientKeyPathFlagName, "", oauthClientKeyPathFlagUsage)
+	startCmd.Flags().String(oauthScopesFlagName, "", oauthScopesFlagUsage)
+	startCmd.Flags().String(oauthAuthServerAudienceFlagName, "", oauthAuthServerAudienceFlagUsage)
 }
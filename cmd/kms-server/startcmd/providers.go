@@ -0,0 +1,348 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hyperledger/aries-framework-go-ext/component/storage/couchdb"
+	"github.com/hyperledger/aries-framework-go-ext/component/storage/mongodb"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/local"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	_ "github.com/lib/pq" // registers the database/sql driver of postgres storage
+	"go.mongodb.org/mongo-driver/mongo"
+
+	awssecretlock "github.com/trustbloc/kms/pkg/secretlock/aws"
+	"github.com/trustbloc/kms/pkg/secrets"
+	storagemetrics "github.com/trustbloc/kms/pkg/storage/metrics"
+	"github.com/trustbloc/kms/pkg/storage/postgres"
+	"github.com/trustbloc/kms/pkg/storage/retry"
+)
+
+var (
+	// ErrProviderRegistered is returned when a provider with the same name is already registered.
+	ErrProviderRegistered = errors.New("provider already registered")
+	// ErrProviderNotSupported is returned when no provider is registered under the requested name.
+	ErrProviderNotSupported = errors.New("provider not supported")
+)
+
+// StorageProviderFactory creates a storage provider for --database-type. It receives --database-url and
+// --database-prefix values.
+//
+// The factory is called on server start, before any requests are served. If it fails, the server retries it every
+// second until --database-timeout expires, so the factory must not leave resources open when returning an error, and
+// should bound its own connection attempts, as there is no context to cancel them.
+//
+// The returned provider and its stores are used by concurrent requests and must be safe for concurrent use. Stores
+// must return storage.ErrDataNotFound (possibly wrapped) from Get if there is no value for the key, the server relies
+// on it to tell missing key stores and keys from failures.
+type StorageProviderFactory func(url, prefix string) (storage.Provider, error)
+
+// SecretLockParameters are values of secret lock flags passed to SecretLockFactory. Custom secret locks that need
+// other configuration are expected to read it on their own (e.g. from environment variables).
+type SecretLockParameters struct {
+	// Type is a value of --secret-lock-type.
+	Type string
+	// LocalKeyPath is a value of --secret-lock-key-path.
+	LocalKeyPath string
+	// AWSKeyURI is a value of --secret-lock-aws-key-uri.
+	AWSKeyURI string
+	// AWSEndpoint is a value of --secret-lock-aws-endpoint.
+	AWSEndpoint string
+	// AWSRoleARN is a value of --secret-lock-aws-role-arn.
+	AWSRoleARN string
+	// AWSWebIdentityTokenPath is a value of --secret-lock-aws-web-identity-token-path.
+	AWSWebIdentityTokenPath string
+	// AWSSTSEndpoint is a value of --secret-lock-aws-sts-endpoint.
+	AWSSTSEndpoint string
+}
+
+// SecretLockFactory creates a secret lock for --secret-lock-type. The secret lock protects keys of the server KMS
+// (e.g. keys used to wrap key store data), so it is called once on server start.
+//
+// The returned secret lock is used by concurrent requests and must be safe for concurrent use. Encrypt and Decrypt
+// must be able to decrypt data encrypted by previous instances created with the same configuration, otherwise keys
+// stored before a restart are lost.
+type SecretLockFactory func(params *SecretLockParameters) (secretlock.Service, error)
+
+var (
+	providersMutex sync.RWMutex //nolint:gochecknoglobals
+
+	storageProviderFactories = map[string]StorageProviderFactory{ //nolint:gochecknoglobals
+		storageTypeMemOption:      createMemStorageProvider,
+		storageTypeCouchDBOption:  createCouchDBStorageProvider,
+		storageTypeMongoDBOption:  createMongoDBStorageProvider,
+		storageTypePostgresOption: createPostgresStorageProvider,
+	}
+
+	secretLockFactories = map[string]SecretLockFactory{ //nolint:gochecknoglobals
+		secretLockTypeLocalOption: createLocalSecretLock,
+		secretLockTypeAWSOption:   createAwsSecretLock,
+	}
+)
+
+// RegisterStorageProvider registers a storage provider factory under the name to be used as --database-type.
+// Names are case-insensitive. Built-in providers (mem, couchdb, mongodb, postgres) can't be replaced. Register
+// providers before calling Cmd.
+func RegisterStorageProvider(name string, factory StorageProviderFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("storage provider name and factory must be non-empty")
+	}
+
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	name = strings.ToLower(name)
+
+	if _, ok := storageProviderFactories[name]; ok {
+		return fmt.Errorf("%w: storage provider %s", ErrProviderRegistered, name)
+	}
+
+	storageProviderFactories[name] = factory
+
+	return nil
+}
+
+// RegisterSecretLock registers a secret lock factory under the name to be used as --secret-lock-type.
+// Names are case-insensitive. Built-in secret locks (local, aws) can't be replaced. Register secret locks
+// before calling Cmd.
+func RegisterSecretLock(name string, factory SecretLockFactory) error {
+	if name == "" || factory == nil {
+		return errors.New("secret lock name and factory must be non-empty")
+	}
+
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	name = strings.ToLower(name)
+
+	if _, ok := secretLockFactories[name]; ok {
+		return fmt.Errorf("%w: secret lock %s", ErrProviderRegistered, name)
+	}
+
+	secretLockFactories[name] = factory
+
+	return nil
+}
+
+func getStorageProviderFactory(name string) (StorageProviderFactory, error) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	factory, ok := storageProviderFactories[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: database type %s", ErrProviderNotSupported, name)
+	}
+
+	return factory, nil
+}
+
+func getSecretLockFactory(name string) (SecretLockFactory, error) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	factory, ok := secretLockFactories[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w: secret lock type %s", ErrProviderNotSupported, name)
+	}
+
+	return factory, nil
+}
+
+func createMemStorageProvider(string, string) (storage.Provider, error) { //nolint:unparam
+	return mem.NewProvider(), nil
+}
+
+// couchDBNamePattern matches CouchDB database names. Names of databases are the lowercased prefix and store name.
+var couchDBNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_$()+/-]*$`) //nolint:gochecknoglobals
+
+func createCouchDBStorageProvider(dbURL, prefix string) (storage.Provider, error) {
+	if prefix != "" && !couchDBNamePattern.MatchString(strings.ToLower(prefix)) {
+		return nil, backoff.Permanent(fmt.Errorf("invalid couchdb database prefix %q: must start with a letter and "+
+			"contain only letters, digits and any of _$()+/-", prefix))
+	}
+
+	// the provider pings the server, so an unreachable server fails the start rather than the first request
+	couchDBProvider, err := couchdb.NewProvider(dbURL, couchdb.WithDBPrefix(prefix))
+	if err != nil {
+		// errors of the ping name the URL, including the password
+		redacted := redactURL(dbURL)
+
+		return nil, fmt.Errorf("couchdb at %s is unreachable: %s", redacted,
+			strings.ReplaceAll(err.Error(), dbURL, redacted))
+	}
+
+	return storagemetrics.Wrap(couchDBProvider, "CouchDB"), nil
+}
+
+// redactURL returns the URL with the password, if any, replaced by "xxxxx".
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+
+	return u.Redacted()
+}
+
+// redactSecretURL returns the URL kept in the secret with the password redacted, or an empty string if the URL is
+// invalid, so that it can be logged.
+func redactSecretURL(s *secrets.Secret) string {
+	var redacted string
+
+	s.Use(func(value []byte) {
+		if u, err := url.Parse(string(value)); err == nil {
+			redacted = u.Redacted()
+		}
+	})
+
+	return redacted
+}
+
+func createMongoDBStorageProvider(url, prefix string) (storage.Provider, error) {
+	mongoDBProvider, err := mongodb.NewProvider(url, mongodb.WithDBPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	return storagemetrics.Wrap(mongoDBProvider, "MongoDB"), nil
+}
+
+// mongoDBTransientErrorCodes are codes of MongoDB server errors that go away on retry, e.g. while a new primary of the
+// replica set is elected.
+var mongoDBTransientErrorCodes = []int{ //nolint:gochecknoglobals // read-only list
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isMongoDBTransientError reports whether the error of a MongoDB operation is transient: a network error, a timeout
+// or a server error that is labeled retryable or has a code of a replica set state change.
+func isMongoDBTransientError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+
+	if serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError") {
+		return true
+	}
+
+	for _, code := range mongoDBTransientErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrapDatabaseRetry wraps the store provider so that operations failed with transient errors are retried. Only
+// MongoDB errors are classified, stores of other database types are returned as is.
+func wrapDatabaseRetry(store storage.Provider, databaseType string,
+	params *DatabaseRetryParameters) storage.Provider {
+	if databaseType != storageTypeMongoDBOption || params == nil || params.MaxRetries == 0 {
+		return store
+	}
+
+	return retry.Wrap(store, "MongoDB", isMongoDBTransientError,
+		retry.WithMaxRetries(params.MaxRetries),
+		retry.WithBackoff(params.Backoff, params.MaxBackoff),
+	)
+}
+
+func createAwsSecretLock(params *SecretLockParameters) (secretlock.Service, error) {
+	primaryKeyLock, err := awssecretlock.New(
+		params.AWSKeyURI,
+
+		&awsProvider{
+			awsEndpoint: params.AWSEndpoint,
+			roleARN:     params.AWSRoleARN,
+			tokenPath:   params.AWSWebIdentityTokenPath,
+			stsEndpoint: params.AWSSTSEndpoint,
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create aws secret lock failed: %w", err)
+	}
+
+	return primaryKeyLock, nil
+}
+
+func createLocalSecretLock(params *SecretLockParameters) (secretlock.Service, error) {
+	if params.LocalKeyPath == "" {
+		return nil, fmt.Errorf("no key defined for local secret lock")
+	}
+
+	primaryKeyReader, err := local.MasterKeyFromPath(params.LocalKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLock, err := local.NewService(primaryKeyReader, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretLock, nil
+}
+
+// postgresTimeout bounds connection attempts of a single try of createPostgresStorageProvider.
+const postgresTimeout = 10 * time.Second
+
+func createPostgresStorageProvider(dbURL, prefix string) (storage.Provider, error) {
+	db, err := sql.Open(postgres.DriverName, dbURL)
+	if err != nil {
+		return nil, backoff.Permanent(fmt.Errorf("invalid postgres url %s: %w", redactURL(dbURL), err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close() // nolint: errcheck,gosec
+
+		redacted := redactURL(dbURL)
+
+		return nil, fmt.Errorf("postgres at %s is unreachable: %s", redacted,
+			strings.ReplaceAll(err.Error(), dbURL, redacted))
+	}
+
+	postgresProvider, err := postgres.NewProvider(db, postgres.WithDBPrefix(prefix),
+		postgres.WithTimeout(postgresTimeout))
+	if err != nil {
+		db.Close() // nolint: errcheck,gosec
+
+		return nil, err
+	}
+
+	return storagemetrics.Wrap(postgresProvider, "PostgreSQL"), nil
+}
@@ -0,0 +1,183 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/spf13/cobra"
+	tlsutil "github.com/trustbloc/edge-core/pkg/utils/tls"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+	"github.com/trustbloc/kms/pkg/discovery"
+	zcapsvc "github.com/trustbloc/kms/pkg/zcapld"
+)
+
+const (
+	reconcileAllFlagName  = "all"
+	reconcileAllFlagUsage = "Checks all EDV-backed key stores, in order of their IDs. Can't be combined with --" +
+		repairKeyStoreFlagName + "."
+
+	reconcileResumeAfterFlagName  = "resume-after"
+	reconcileResumeAfterFlagUsage = "With --" + reconcileAllFlagName + ", skips key stores with IDs up to and " +
+		"including this one. Used to resume an interrupted run."
+
+	reconcileEDVRateFlagName  = "edv-rate"
+	reconcileEDVRateFlagUsage = "Maximum number of requests per second to EDV. 0 means no limit."
+	reconcileEDVRateDefault   = 10
+)
+
+// ReconcileEDVCmd returns the Cobra reconcile-edv command. It checks that the vaults of EDV-backed key stores have
+// documents for all the keys of the key stores, without modifying anything.
+func ReconcileEDVCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reconcile-edv",
+		Short: "Checks the vaults of EDV-backed key stores",
+		Long: "Reports keys of EDV-backed key stores whose documents are missing in the vault or can't be read " +
+			"from it. Nothing is modified. Uses the database and secret lock settings of kms-server.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyStoreID, err := cmd.Flags().GetString(repairKeyStoreFlagName)
+			if err != nil {
+				return fmt.Errorf("parse keystore: %w", err)
+			}
+
+			all, err := cmd.Flags().GetBool(reconcileAllFlagName)
+			if err != nil {
+				return fmt.Errorf("parse all: %w", err)
+			}
+
+			if (keyStoreID == "") == !all {
+				return fmt.Errorf("either %s or %s (command line flags) must be set",
+					repairKeyStoreFlagName, reconcileAllFlagName)
+			}
+
+			resumeAfter, err := cmd.Flags().GetString(reconcileResumeAfterFlagName)
+			if err != nil {
+				return fmt.Errorf("parse resume after: %w", err)
+			}
+
+			rate, err := cmd.Flags().GetInt(reconcileEDVRateFlagName)
+			if err != nil || rate < 0 {
+				return fmt.Errorf("%s (command line flag) must be a non-negative number", reconcileEDVRateFlagName)
+			}
+
+			c, err := createReconcileEDVCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			keyStoreIDs := []string{keyStoreID}
+
+			if all {
+				keyStoreIDs, err = c.EDVKeyStoreIDs()
+				if err != nil {
+					return fmt.Errorf("list edv key stores: %w", err)
+				}
+			}
+
+			wait, stop := rateLimiter(rate)
+			defer stop()
+
+			return reconcileEDV(cmd, c, keyStoreIDs, resumeAfter, wait)
+		},
+	}
+
+	cmd.Flags().String(repairKeyStoreFlagName, "", "The ID of the EDV-backed key store to check.")
+	cmd.Flags().Bool(reconcileAllFlagName, false, reconcileAllFlagUsage)
+	cmd.Flags().String(reconcileResumeAfterFlagName, "", reconcileResumeAfterFlagUsage)
+	cmd.Flags().Int(reconcileEDVRateFlagName, reconcileEDVRateDefault, reconcileEDVRateFlagUsage)
+	cmd.Flags().String(databaseTypeFlagName, "", databaseTypeFlagUsage)
+	cmd.Flags().String(databaseURLFlagName, "", databaseURLFlagUsage)
+	cmd.Flags().String(databasePrefixFlagName, "", databasePrefixFlagUsage)
+	cmd.Flags().String(databaseTimeoutFlagName, "30s", databaseTimeoutFlagUsage)
+	cmd.Flags().String(secretLockTypeFlagName, "", secretLockTypeFlagUsage)
+	cmd.Flags().String(secretLockKeyPathFlagName, "", secretLockKeyPathFlagUsage)
+	cmd.Flags().String(secretLockAWSKeyURIFlagName, "", secretLockAWSKeyURIFlagUsage)
+	cmd.Flags().String(secretLockAWSEndpointFlagName, "", secretLockAWSEndpointFlagUsage)
+	cmd.Flags().String(tlsSystemCertPoolFlagName, "false", tlsSystemCertPoolFlagUsage)
+	cmd.Flags().String(tlsCACertsFlagName, "", tlsCACertsFlagUsage)
+
+	return cmd
+}
+
+func createReconcileEDVCommand(cmd *cobra.Command) (*command.Command, error) {
+	tlsParams, err := getTLS(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	rootCAs, err := tlsutil.GetCertPool(tlsParams.SystemCertPool, tlsParams.CACerts)
+	if err != nil {
+		return nil, fmt.Errorf("get cert pool: %w", err)
+	}
+
+	config, err := createOfflineConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests to EDV are signed with the capabilities saved in the key store metadata, no JSON-LD is processed
+	zcapService, err := zcapsvc.New(config.KMS, config.Crypto, config.StorageProvider, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create zcap service: %w", err)
+	}
+
+	config.HeaderSigner = zcapService
+	config.TLSConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	config.EDVRecipientKeyType = kms.NISTP256ECDHKW
+	config.EDVMACKeyType = kms.HMACSHA256Tag256
+	config.URLResolver = discovery.NewRegistry(nil)
+
+	return command.New(config) //nolint:wrapcheck
+}
+
+// rateLimiter returns a function that blocks so that it returns at most rate times per second, and a function that
+// releases the resources of the limiter.
+func rateLimiter(rate int) (func(), func()) {
+	if rate == 0 {
+		return func() {}, func() {}
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+
+	return func() { <-ticker.C }, ticker.Stop
+}
+
+func reconcileEDV(cmd *cobra.Command, c *command.Command, keyStoreIDs []string, resumeAfter string,
+	wait func()) error {
+	var found int
+
+	for _, keyStoreID := range keyStoreIDs {
+		if resumeAfter != "" && keyStoreID <= resumeAfter {
+			continue
+		}
+
+		report, err := c.ReconcileEDV(keyStoreID, wait)
+		if err != nil {
+			return fmt.Errorf("reconcile key store %s (rerun with --%s to skip it): %w",
+				keyStoreID, reconcileResumeAfterFlagName, err)
+		}
+
+		for i := range report.Findings {
+			cmd.Printf("key store %s: %s\n", keyStoreID, report.Findings[i].String())
+		}
+
+		cmd.Printf("Reconciled key store %s: %d keys checked, %d problems\n",
+			keyStoreID, report.Checked, len(report.Findings))
+
+		found += len(report.Findings)
+	}
+
+	if found > 0 {
+		return fmt.Errorf("%d problems found", found)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const reconcileEDVStorageType = "reconcile-edv-mem"
+
+func TestReconcileEDVCmd(t *testing.T) {
+	store := mem.NewProvider()
+
+	require.NoError(t, RegisterStorageProvider(reconcileEDVStorageType, func(string, string) (storage.Provider, error) {
+		return store, nil
+	}))
+
+	args := func(extra ...string) []string {
+		return append([]string{
+			"--" + databaseTypeFlagName, reconcileEDVStorageType,
+			"--" + secretLockTypeFlagName, secretLockTypeLocalOption,
+			"--" + secretLockKeyPathFlagName, secretLockKeyFile,
+		}, extra...)
+	}
+
+	t.Run("No EDV-backed key stores", func(t *testing.T) {
+		out, err := executeReconcileEDVCmd(args("--" + reconcileAllFlagName))
+		require.NoError(t, err)
+		require.Empty(t, out)
+	})
+
+	t.Run("Fail with key store that is not EDV-backed", func(t *testing.T) {
+		keyStores, err := store.OpenStore("keystores")
+		require.NoError(t, err)
+
+		require.NoError(t, keyStores.Put("local", []byte(`{"id":"local","controller":"did:example:controller"}`)))
+
+		_, err = executeReconcileEDVCmd(args("--"+repairKeyStoreFlagName, "local"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "reconcile key store local (rerun with --resume-after to skip it)")
+		require.Contains(t, err.Error(), "key store local is not EDV-backed")
+	})
+
+	t.Run("Skip key stores with --resume-after", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args("--"+repairKeyStoreFlagName, "local",
+			"--"+reconcileResumeAfterFlagName, "local"))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail with unknown key store", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args("--"+repairKeyStoreFlagName, "unknown"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key store unknown")
+	})
+
+	t.Run("Fail with neither key store nor all", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args())
+		require.EqualError(t, err, "either keystore or all (command line flags) must be set")
+	})
+
+	t.Run("Fail with both key store and all", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args("--"+repairKeyStoreFlagName, "local", "--"+reconcileAllFlagName))
+		require.EqualError(t, err, "either keystore or all (command line flags) must be set")
+	})
+
+	t.Run("Fail with negative EDV rate", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args("--"+reconcileAllFlagName, "--"+reconcileEDVRateFlagName, "-1"))
+		require.EqualError(t, err, "edv-rate (command line flag) must be a non-negative number")
+	})
+
+	t.Run("Fail with invalid TLS system cert pool", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd(args("--"+reconcileAllFlagName, "--"+tlsSystemCertPoolFlagName, "invalid"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "parse cert pool")
+	})
+
+	t.Run("Fail with missing database type", func(t *testing.T) {
+		_, err := executeReconcileEDVCmd([]string{"--" + reconcileAllFlagName})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "database-type")
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	wait, stop := rateLimiter(100)
+	defer stop()
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		wait()
+	}
+
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	wait, stop = rateLimiter(0)
+	defer stop()
+
+	wait()
+}
+
+func executeReconcileEDVCmd(args []string) (string, error) {
+	var out bytes.Buffer
+
+	cmd := ReconcileEDVCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
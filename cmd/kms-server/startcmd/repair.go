@@ -0,0 +1,162 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/spf13/cobra"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+	"github.com/trustbloc/kms/pkg/metrics"
+)
+
+const (
+	repairKeyStoreFlagName  = "keystore"
+	repairKeyStoreFlagUsage = "The ID of the key store to check."
+
+	repairFixFlagName  = "fix"
+	repairFixFlagUsage = "Repairs the problems that can be fixed: rebuilds the key list and aliases, and moves key " +
+		"records that are not valid or can't be decrypted to the " + command.QuarantineStore + " store. " +
+		"Without it, problems are only reported."
+)
+
+// RepairCmd returns the Cobra repair command. It checks the records of a key store in the database of kms-server
+// and, with --fix, repairs what it can.
+func RepairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Checks and repairs records of a key store",
+		Long: "Checks the metadata, server keys and key records of a key store, and their consistency. Dry run " +
+			"unless --fix is set. Uses the database and secret lock settings of kms-server.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyStoreID, err := cmd.Flags().GetString(repairKeyStoreFlagName)
+			if err != nil || keyStoreID == "" {
+				return fmt.Errorf("%s (command line flag) has not been set", repairKeyStoreFlagName)
+			}
+
+			fix, err := cmd.Flags().GetBool(repairFixFlagName)
+			if err != nil {
+				return fmt.Errorf("parse fix: %w", err)
+			}
+
+			c, err := createRepairCommand(cmd)
+			if err != nil {
+				return err
+			}
+
+			report, err := c.RepairKeyStore(keyStoreID, fix)
+			if err != nil {
+				return fmt.Errorf("repair key store: %w", err)
+			}
+
+			return printRepairReport(cmd, report)
+		},
+	}
+
+	cmd.Flags().String(repairKeyStoreFlagName, "", repairKeyStoreFlagUsage)
+	cmd.Flags().Bool(repairFixFlagName, false, repairFixFlagUsage)
+	cmd.Flags().String(databaseTypeFlagName, "", databaseTypeFlagUsage)
+	cmd.Flags().String(databaseURLFlagName, "", databaseURLFlagUsage)
+	cmd.Flags().String(databasePrefixFlagName, "", databasePrefixFlagUsage)
+	cmd.Flags().String(databaseTimeoutFlagName, "30s", databaseTimeoutFlagUsage)
+	cmd.Flags().String(secretLockTypeFlagName, "", secretLockTypeFlagUsage)
+	cmd.Flags().String(secretLockKeyPathFlagName, "", secretLockKeyPathFlagUsage)
+	cmd.Flags().String(secretLockAWSKeyURIFlagName, "", secretLockAWSKeyURIFlagUsage)
+	cmd.Flags().String(secretLockAWSEndpointFlagName, "", secretLockAWSEndpointFlagUsage)
+
+	return cmd
+}
+
+func createRepairCommand(cmd *cobra.Command) (*command.Command, error) {
+	config, err := createOfflineConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return command.New(config) //nolint:wrapcheck
+}
+
+// createOfflineConfig returns a configuration of the command that works on the database of kms-server directly,
+// without the server running.
+func createOfflineConfig(cmd *cobra.Command) (*command.Config, error) {
+	databaseType, err := getUserSetVar(cmd, databaseTypeFlagName, databaseTypeEnvKey, false)
+	if err != nil {
+		return nil, err
+	}
+
+	databaseTimeout, err := time.ParseDuration(
+		getUserSetVarOptional(cmd, databaseTimeoutFlagName, databaseTimeoutEnvKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse database timeout: %w", err)
+	}
+
+	secretLockParams, err := getSecretLockParameters(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := createStoreProvider(
+		databaseType,
+		getUserSetVarOptional(cmd, databaseURLFlagName, databaseURLEnvKey),
+		getUserSetVarOptional(cmd, databasePrefixFlagName, databasePrefixEnvKey),
+		databaseTimeout,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create store provider: %w", err)
+	}
+
+	kmsService, err := createKMS(store, secretLockParams)
+	if err != nil {
+		return nil, fmt.Errorf("create kms: %w", err)
+	}
+
+	cryptoService, err := tinkcrypto.New()
+	if err != nil {
+		return nil, fmt.Errorf("create tink crypto: %w", err)
+	}
+
+	return &command.Config{
+		StorageProvider:    store,
+		KeyStorageProvider: store,
+		KMS:                kmsService,
+		Crypto:             cryptoService,
+		KeyStoreCreator:    &keyStoreCreator{},
+		MainKeyType:        kms.AES256GCMType,
+		MetricsProvider:    metrics.Get(),
+	}, nil
+}
+
+func printRepairReport(cmd *cobra.Command, report *command.RepairReport) error {
+	for i := range report.Findings {
+		cmd.Println(report.Findings[i].String())
+	}
+
+	found, unfixable := len(report.Findings), report.Unfixable()
+
+	switch {
+	case found == 0:
+		cmd.Printf("Key store %s has no problems\n", report.KeyStoreID)
+
+		return nil
+	case report.Fixed:
+		cmd.Printf("Fixed %d problems, key store sequence is %d\n", found-unfixable, report.Sequence)
+	case found > unfixable:
+		cmd.Printf("%d problems can be fixed with --%s\n", found-unfixable, repairFixFlagName)
+
+		return fmt.Errorf("%d problems found", found)
+	}
+
+	if unfixable > 0 {
+		return fmt.Errorf("%d problems can't be fixed", unfixable)
+	}
+
+	return nil
+}
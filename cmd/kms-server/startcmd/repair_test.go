@@ -0,0 +1,174 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+)
+
+const repairStorageType = "repair-mem"
+
+func TestRepairCmd(t *testing.T) {
+	store := mem.NewProvider()
+
+	require.NoError(t, RegisterStorageProvider(repairStorageType, func(string, string) (storage.Provider, error) {
+		return store, nil
+	}))
+
+	keyStoreID, keyIDs := createRepairKeyStore(t, repairStorageType)
+
+	args := func(extra ...string) []string {
+		return append([]string{
+			"--" + databaseTypeFlagName, repairStorageType,
+			"--" + secretLockTypeFlagName, secretLockTypeLocalOption,
+			"--" + secretLockKeyPathFlagName, secretLockKeyFile,
+		}, extra...)
+	}
+
+	t.Run("Key store has no problems", func(t *testing.T) {
+		out, err := executeRepairCmd(args("--"+repairKeyStoreFlagName, keyStoreID))
+		require.NoError(t, err)
+		require.Contains(t, out, "Key store "+keyStoreID+" has no problems")
+	})
+
+	t.Run("Corrupted key is reported and quarantined with --fix", func(t *testing.T) {
+		keys, err := store.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
+
+		keys, err = prefix.NewPrefixStoreWrapper(keys, prefix.StorageKIDPrefix)
+		require.NoError(t, err)
+
+		require.NoError(t, keys.Put(keyIDs[0], []byte(`{"encryptedKeyset":`)))
+
+		out, err := executeRepairCmd(args("--"+repairKeyStoreFlagName, keyStoreID))
+		require.EqualError(t, err, "1 problems found")
+		require.Contains(t, out, "key "+keyIDs[0]+": record is not an encrypted keyset")
+		require.Contains(t, out, "(fix: quarantine)")
+		require.Contains(t, out, "1 problems can be fixed with --fix")
+
+		out, err = executeRepairCmd(args("--"+repairKeyStoreFlagName, keyStoreID, "--"+repairFixFlagName))
+		require.NoError(t, err)
+		require.Contains(t, out, "Fixed 1 problems")
+
+		quarantine, err := store.OpenStore(command.QuarantineStore)
+		require.NoError(t, err)
+
+		_, err = quarantine.Get(keyStoreID + "/" + keyIDs[0])
+		require.NoError(t, err)
+
+		out, err = executeRepairCmd(args("--"+repairKeyStoreFlagName, keyStoreID))
+		require.NoError(t, err)
+		require.Contains(t, out, "has no problems")
+	})
+
+	t.Run("Corrupted metadata can't be fixed", func(t *testing.T) {
+		keyStores, err := store.OpenStore("keystores")
+		require.NoError(t, err)
+
+		require.NoError(t, keyStores.Put("corrupted", []byte(`{`)))
+
+		out, err := executeRepairCmd(args("--"+repairKeyStoreFlagName, "corrupted", "--"+repairFixFlagName))
+		require.EqualError(t, err, "1 problems can't be fixed")
+		require.Contains(t, out, "metadata: can't be parsed")
+	})
+
+	t.Run("Fail with unknown key store", func(t *testing.T) {
+		_, err := executeRepairCmd(args("--"+repairKeyStoreFlagName, "unknown"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key store unknown")
+	})
+
+	t.Run("Fail with missing key store", func(t *testing.T) {
+		_, err := executeRepairCmd(args())
+		require.EqualError(t, err, "keystore (command line flag) has not been set")
+	})
+
+	t.Run("Fail with missing database type", func(t *testing.T) {
+		_, err := executeRepairCmd([]string{"--" + repairKeyStoreFlagName, keyStoreID})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "database-type")
+	})
+}
+
+// createRepairKeyStore creates a key store with two keys in the database of the type the way kms-server does.
+func createRepairKeyStore(t *testing.T, storageType string) (string, []string) {
+	t.Helper()
+
+	cmd := RepairCmd()
+	require.NoError(t, cmd.ParseFlags([]string{
+		"--" + databaseTypeFlagName, storageType,
+		"--" + secretLockTypeFlagName, secretLockTypeLocalOption,
+		"--" + secretLockKeyPathFlagName, secretLockKeyFile,
+	}))
+
+	c, err := createRepairCommand(cmd)
+	require.NoError(t, err)
+
+	var (
+		buf    bytes.Buffer
+		ksResp command.CreateKeyStoreResponse
+	)
+
+	require.NoError(t, c.CreateKeyStore(&buf, wrapRequest(t, "",
+		command.CreateKeyStoreRequest{Controller: "did:example:controller"})))
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &ksResp))
+
+	keyStoreID := ksResp.KeyStoreURL[strings.LastIndex(ksResp.KeyStoreURL, "/")+1:]
+
+	var keyIDs []string
+
+	for i := 0; i < 2; i++ {
+		var keyResp command.CreateKeyResponse
+
+		buf.Reset()
+		require.NoError(t, c.CreateKey(&buf, wrapRequest(t, keyStoreID,
+			command.CreateKeyRequest{KeyType: kms.ED25519Type})))
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &keyResp))
+
+		keyIDs = append(keyIDs, keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:])
+	}
+
+	return keyStoreID, keyIDs
+}
+
+func executeRepairCmd(args []string) (string, error) {
+	var out bytes.Buffer
+
+	cmd := RepairCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+
+	return out.String(), err
+}
+
+func wrapRequest(t *testing.T, keyStoreID string, req interface{}) io.Reader {
+	t.Helper()
+
+	b, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	wr, err := json.Marshal(command.WrappedRequest{KeyStoreID: keyStoreID, Request: b})
+	require.NoError(t, err)
+
+	return bytes.NewReader(wr)
+}
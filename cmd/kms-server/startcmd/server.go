@@ -0,0 +1,796 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/rs/cors"
+	"github.com/trustbloc/auth/component/gnap/rs"
+	"github.com/trustbloc/auth/spi/gnap/proof/httpsig"
+	tlsutil "github.com/trustbloc/edge-core/pkg/utils/tls"
+
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/controller/command"
+	"github.com/trustbloc/kms/pkg/controller/mw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/adminmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/gnapmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/nozcapmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/oauthmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/tokenmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/zcapmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+	"github.com/trustbloc/kms/pkg/controller/rest"
+	"github.com/trustbloc/kms/pkg/discovery"
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/idempotency"
+	"github.com/trustbloc/kms/pkg/keyusage"
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+	kmscache "github.com/trustbloc/kms/pkg/kms/cache"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/metrics"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+	"github.com/trustbloc/kms/pkg/readiness"
+	"github.com/trustbloc/kms/pkg/replication"
+	"github.com/trustbloc/kms/pkg/respsign"
+	shamirprovider "github.com/trustbloc/kms/pkg/shamir"
+	shamircache "github.com/trustbloc/kms/pkg/shamir/cache"
+	"github.com/trustbloc/kms/pkg/signnonce"
+	"github.com/trustbloc/kms/pkg/slo"
+	"github.com/trustbloc/kms/pkg/storage/archive"
+	"github.com/trustbloc/kms/pkg/storage/cache"
+	"github.com/trustbloc/kms/pkg/storage/cache/redis"
+	"github.com/trustbloc/kms/pkg/upload"
+	"github.com/trustbloc/kms/pkg/usagereport"
+	zcapsvc "github.com/trustbloc/kms/pkg/zcapld"
+)
+
+// Server is the KMS server built from Parameters, for services that embed the KMS in their own binary instead of
+// running kms-server. The start command is a thin wrapper that serves it.
+type Server struct {
+	// Handler serves the KMS API with the middlewares configured by the parameters. Embedding services mount it under
+	// their own mux, at the root of the base URL.
+	Handler http.Handler
+	// Command is the service behind Handler.
+	Command *command.Command
+
+	rootCAs  *x509.CertPool
+	ingester *replication.Ingester // standby only
+	stop     []func()
+}
+
+// New creates the KMS server from the parameters. It doesn't listen: the handler is served by the caller, and so are
+// metrics (--metrics-host) and, on a replication standby, ingestion of records, which are served by the start
+// command only. Logging is process-wide and left to the caller. Background jobs (e.g. purging of deleted keys) are
+// started here; stop them with Close.
+func New(params *Parameters) (*Server, error) { //nolint:funlen,gocyclo,cyclop
+	// the background jobs tick at the intervals, parsed parameters are positive
+	if params.KeyPurgeInterval <= 0 || params.RecordPruneInterval <= 0 {
+		return nil, errors.New("key purge and expired record prune intervals must be positive")
+	}
+
+	params = withDefaults(params)
+
+	rootCAs, err := tlsutil.GetCertPool(params.TLS.SystemCertPool, params.TLS.CACerts)
+	if err != nil {
+		return nil, fmt.Errorf("get cert pool: %w", err)
+	}
+
+	s := &Server{rootCAs: rootCAs}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	breakers := createBreakers(params.Dependencies.Breaker)
+
+	hubAuthHTTPClient := breaker.Client(breakers[breaker.DependencyHubAuth],
+		&http.Transport{TLSClientConfig: tlsConfig}, params.Dependencies.HubAuthTimeout)
+
+	store, err := createStoreProvider(
+		params.DatabaseType,
+		params.DatabaseURL,
+		params.DatabasePrefix,
+		params.DatabaseTimeout,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create store provider: %w", err)
+	}
+
+	store = wrapDatabaseRetry(store, params.DatabaseType, params.DatabaseRetry)
+
+	clk := clock.Real()
+
+	store, replicationIndex, err := s.setupReplication(params.Replication, store, rootCAs, clk)
+	if err != nil {
+		return nil, fmt.Errorf("setup replication: %w", err)
+	}
+
+	// the key storage recalls archived keys, including on a standby that replicates stubs of archived keys
+	keyStorage := store
+
+	var keyArchive *archive.Provider
+
+	if params.KeyArchive != nil {
+		keyArchive, err = createKeyArchive(params.KeyArchive, store, clk, params.DatabaseTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("create key archive: %w", err)
+		}
+
+		keyStorage = keyArchive
+	}
+
+	var (
+		storageProvider     storage.Provider
+		cacheProvider       *cache.Provider
+		kmsCacheProvider    *kmscache.Provider
+		shamirCacheProvider *shamircache.Provider
+	)
+
+	if params.EnableCache {
+		c, err := ristretto.NewCache(&ristretto.Config{
+			NumCounters: 1e7, // TODO: make these values configurable
+			MaxCost:     1 << 30,
+			BufferItems: 64,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create ristretto cache: %w", err)
+		}
+
+		// key handles of the server kms can't be serialized, so they are always cached in memory
+		kmsCacheProvider = &kmscache.Provider{Cache: c}
+
+		sharedCache, err := s.createCache(params, c, clk)
+		if err != nil {
+			return nil, err
+		}
+
+		cacheProvider = &cache.Provider{Cache: sharedCache}
+		storageProvider = cacheProvider.Wrap(store)
+		shamirCacheProvider = &shamircache.Provider{Cache: sharedCache}
+
+	} else {
+		storageProvider = store
+	}
+
+	kmsService, err := createKMS(storageProvider, params.SecretLock)
+	if err != nil {
+		return nil, fmt.Errorf("create kms: %w", err)
+	}
+
+	if kmsCacheProvider != nil && params.KMSCacheTTL >= 0 {
+		kmsService, err = kmsCacheProvider.WrapKMS(kmsService, params.KMSCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("wrap kms: %w", err)
+		}
+	}
+
+	cryptoService, err := tinkcrypto.New()
+	if err != nil {
+		return nil, fmt.Errorf("create tink crypto: %w", err)
+	}
+
+	vdrResolver, err := createVDR(params.DIDDomain, breaker.Client(breakers[breaker.DependencyDIDResolver],
+		&http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}, params.Dependencies.DIDResolverTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("create vdr resolver: %w", err)
+	}
+
+	documentLoader, err := createJSONLDDocumentLoader(storageProvider)
+	if err != nil {
+		return nil, fmt.Errorf("create document loader: %w", err)
+	}
+
+	zcapService, err := zcapsvc.New(kmsService, cryptoService, storageProvider, documentLoader, zcapsvc.WithClock(clk))
+	if err != nil {
+		return nil, fmt.Errorf("create zcap service: %w", err)
+	}
+
+	baseKeyStoreURL := params.BaseURL + rest.KeyStorePath
+
+	authServerURL := params.AuthServerURL
+
+	var authServerEndpoint *discovery.Endpoint
+
+	if discovery.IsSRV(authServerURL) {
+		authServerEndpoint, err = discovery.NewEndpoint(authServerURL, nil, discovery.WithClock(clk))
+		if err != nil {
+			return nil, fmt.Errorf("resolve auth server url: %w", err)
+		}
+
+		authServerURL = authServerEndpoint.URL()
+	}
+
+	var shamirProvider shamirprovider.Provider
+
+	authServerHTTPClient, err := createAuthServerHTTPClient(params.OAuth, hubAuthHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthEnabled := params.OAuth.TokenURL != ""
+	authServerToken := params.AuthServerToken
+
+	if oauthEnabled {
+		authServerToken = nil // access tokens replace the static token
+	}
+
+	if authServerURL != "" && (oauthEnabled || !authServerToken.IsEmpty()) {
+		config := &shamirprovider.ProviderConfig{
+			HTTPClient:      authServerHTTPClient,
+			AuthServerURL:   authServerURL,
+			AuthServerToken: authServerToken,
+		}
+
+		if authServerEndpoint != nil {
+			config.AuthServerEndpoint = authServerEndpoint
+		}
+
+		shamirProvider = shamirprovider.CreateProvider(config)
+	}
+
+	if shamirCacheProvider != nil && shamirProvider != nil && params.ShamirSecretCacheTTL >= 0 {
+		shamirProvider = shamirCacheProvider.Wrap(shamirProvider, params.ShamirSecretCacheTTL)
+	}
+
+	config := &command.Config{
+		StorageProvider:               storageProvider,
+		KeyStorageProvider:            keyStorage,
+		KeyArchive:                    keyArchive,
+		KMS:                           kmsService,
+		Crypto:                        cryptoService,
+		VDRResolver:                   vdrResolver,
+		DocumentLoader:                documentLoader,
+		KeyStoreCreator:               &keyStoreCreator{},
+		ShamirSecretLockCreator:       &shamirSecretLockCreator{},
+		CryptBoxCreator:               &cryptoBoxCreator{},
+		ZCAPService:                   zcapService,
+		EnableZCAPs:                   !params.DisableAuth,
+		EnableNoZCAPKeyStores:         params.EnableNoZCAP,
+		EnableRawDerivedKeys:          params.EnableRawDerivedKeys,
+		HeaderSigner:                  zcapService,
+		TLSConfig:                     tlsConfig,
+		BaseKeyStoreURL:               baseKeyStoreURL,
+		ShamirProvider:                shamirProvider,
+		MainKeyType:                   kms.AES256GCMType,
+		EDVRecipientKeyType:           kms.NISTP256ECDHKW,
+		EDVMACKeyType:                 kms.HMACSHA256Tag256,
+		KeyStoreCacheTTL:              params.KeyStoreCacheTTL,
+		MaxKeyStoreCacheTTL:           params.KeyStoreCacheTTLMax,
+		KeyStoreRateLimit:             params.KeyStoreLimits.RateLimit,
+		MaxKeyStoreRateLimit:          params.KeyStoreLimits.RateLimitMax,
+		KeyStoreConcurrencyLimit:      params.KeyStoreLimits.ConcurrencyLimit,
+		MaxKeyStoreConcurrencyLimit:   params.KeyStoreLimits.ConcurrencyLimitMax,
+		MaxSignBatchSize:              params.SignBatchMaxSize,
+		MaxSignMultiKeyMessageSize:    params.SignMultiKeyMaxMessageSize,
+		MaxSignMultiKeyTotalSize:      params.SignMultiKeyMaxTotalSize,
+		RequestLimits:                 params.RequestLimits,
+		KeyExpiryClockSkew:            params.KeyExpiryClockSkew,
+		ControllerRotationGracePeriod: params.ControllerRotationGracePeriod,
+		KeyRetentionPeriod:            params.KeyRetentionPeriod,
+		DIDCommMediatorURL:            params.DIDCommMediatorURL,
+		MetricsProvider:               metrics.Get(),
+		Clock:                         clk,
+		URLResolver:                   discovery.NewRegistry(nil, discovery.WithClock(clk)),
+		CryptoPools:                   createCryptoPools(params.CryptoPools, clk),
+		EDVBreaker:                    breakers[breaker.DependencyEDV],
+		EDVTimeout:                    params.Dependencies.EDVTimeout,
+	}
+
+	if params.DisableEDV {
+		// without key types of EDV keys, EDV key stores can't be created
+		config.EDVRecipientKeyType, config.EDVMACKeyType = "", ""
+	}
+
+	if params.RSAKeyPoolSize > 0 {
+		config.RSAKeyPool = rsapss.NewPool(params.RSAKeyPoolSize)
+		config.RSAKeyPool.Start()
+	}
+
+	if cacheProvider != nil {
+		config.CacheProvider = &cacheProviderWithTTL{Provider: cacheProvider}
+	}
+
+	config.VerifyCache, err = createVerifyCache(params.VerifyCache)
+	if err != nil {
+		return nil, fmt.Errorf("create verify cache: %w", err)
+	}
+
+	recordPruner := expiry.NewPruner(params.RecordPruneInterval)
+	recordOpts := recordOptions(params.DatabaseType, recordPruner)
+
+	// one-time tokens are read from the store directly, so a token consumed on one instance is seen on others
+	config.OneTimeTokens, err = onetimetoken.New(store, clk, recordOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("create one-time token store: %w", err)
+	}
+
+	if params.SignNonceTTL > 0 {
+		config.SignNonces, err = signnonce.New(store, clk, params.SignNonceTTL, recordOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create sign nonce store: %w", err)
+		}
+	}
+
+	if params.UploadTTL > 0 {
+		config.Uploads, err = upload.New(store, clk, params.UploadTTL, recordOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create upload store: %w", err)
+		}
+	}
+
+	if params.CallerNonceWindow > 0 {
+		config.CallerNonces = aesgcm.NewReuseDetector(clk, params.CallerNonceWindow)
+	}
+
+	if !params.DisableKeyUsage {
+		config.KeyUsage, err = keyusage.New(store, clk, params.KeyUsageInterval)
+		if err != nil {
+			return nil, fmt.Errorf("create key usage tracker: %w", err)
+		}
+	}
+
+	if params.KeyStoreIdempotencyTTL > 0 {
+		config.IdempotencyKeys, err = idempotency.New(store, clk, params.KeyStoreIdempotencyTTL, recordOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("create idempotency key store: %w", err)
+		}
+	}
+
+	// RDF canonicalization is CPU-heavy, so profiles are enabled explicitly
+	if len(params.SignCanonicalization) > 0 {
+		config.Canonicalizer, err = canonicalization.New(params.SignCanonicalization, documentLoader)
+		if err != nil {
+			return nil, fmt.Errorf("create canonicalizer: %w", err)
+		}
+	}
+
+	cmd, err := command.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("create command: %w", err)
+	}
+
+	router := mux.NewRouter()
+
+	// probes are answered without credentials and aren't subject to load shedding
+	router.Handle(readiness.Path, createReadinessChecker(params.ReadinessTimeout, store, keyStorage,
+		authServerURL, authServerEndpoint, hubAuthHTTPClient).Handler()).Methods(http.MethodGet)
+
+	zcapConfig := &zcapmw.ZCAPConfig{
+		AuthService:          zcapService,
+		JSONLDLoader:         documentLoader,
+		Logger:               logger,
+		VDRResolver:          vdrResolver,
+		BaseResourceURL:      baseKeyStoreURL,
+		ResourceIDQueryParam: rest.KeyStoreVarName,
+		DebugAuth:            params.DebugAuth,
+		MaxCapabilitySize:    params.MaxCapabilitySize,
+	}
+
+	var (
+		privateJWK, publicJWK *jwk.JWK
+		gnapRSClient          *rs.Client
+	)
+
+	if !params.DisableAuth {
+		privateJWK, publicJWK, err = createGNAPSigningJWK(params.GNAPSigningKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("create gnap signing jwk: %w", err)
+		}
+
+		gnapRSClient, err = rs.NewClient(
+			&httpsig.Signer{SigningKey: privateJWK},
+			hubAuthHTTPClient,
+			authServerURL, // GNAP client does not support re-resolution, so the URL resolved at startup is used
+		)
+	}
+
+	loadShedder := createLoadShedder(params.LoadShed)
+	if loadShedder != nil {
+		s.stop = append(s.stop, loadShedder.Stop)
+	}
+
+	keyStoreLimiter := createKeyStoreLimiter(params.KeyStoreLimits, cmd, clk)
+
+	respSigner, err := createResponseSigner(params.ResponseSigning, store, clk)
+	if err != nil {
+		return nil, fmt.Errorf("create response signer: %w", err)
+	}
+
+	if respSigner != nil {
+		router.Handle(respsign.WellKnownPath, respSigner.KeySetHandler()).Methods(http.MethodGet)
+	}
+
+	if replicationIndex != nil {
+		router.Handle(replication.DigestPath,
+			replicationIndex.Handler(params.Replication.Token, replication.DefaultStores()),
+		).Methods(http.MethodGet)
+	}
+
+	readOnly := params.Replication != nil && params.Replication.Mode == replication.ModeStandby
+
+	var usageCounter *usagereport.Counter
+
+	// the standby is read-only, so deleted keys and expired records are purged, and cold keys archived, on the
+	// primary only
+	if !readOnly {
+		purger := command.NewKeyPurger(cmd, params.KeyPurgeInterval)
+		purger.Start()
+		recordPruner.Start()
+
+		s.stop = append(s.stop, purger.Stop, recordPruner.Stop)
+
+		if params.KeyArchive != nil {
+			archiver := command.NewKeyArchiver(cmd, params.KeyArchive.After, params.KeyArchive.Interval)
+			archiver.Start()
+
+			s.stop = append(s.stop, archiver.Stop)
+		}
+
+		if params.UsageReport != nil {
+			usageCounter, err = s.setupUsageReports(params.UsageReport, store, cmd, respSigner, clk,
+				breaker.Client(breakers[breaker.DependencyWebhook], &http.Transport{TLSClientConfig: tlsConfig},
+					params.Dependencies.WebhookTimeout))
+			if err != nil {
+				return nil, fmt.Errorf("setup usage reports: %w", err)
+			}
+		}
+	}
+
+	if params.EnableTestVectors {
+		logger.Warnf("Test vectors are served at %s; this server is for development only", rest.TestVectorsPath)
+	}
+
+	op := rest.New(cmd, rest.WithClock(clk), rest.WithNoZCAPKeyStores(params.EnableNoZCAP),
+		rest.WithTestVectors(params.EnableTestVectors), rest.WithDisabledOperations(params.DisabledOperations))
+	handlers := op.GetRESTHandlers()
+
+	disabled, err := disabledOperations(params.DisabledOperations, handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range handlers {
+		if disabled[h.Action()] {
+			// mux answers 405 if other methods of the path are routed, so disabled routes are handled explicitly
+			router.Handle(h.Path(), http.NotFoundHandler()).Methods(h.Method())
+
+			continue
+		}
+
+		var handler http.Handler = h.Handler()
+
+		// only requests that reach the handler are counted, not those refused by middleware or dry runs
+		if usageCounter != nil {
+			handler = usageCounter.Middleware(h.Action(), rest.KeyStoreVarName)(handler)
+		}
+
+		// limits apply to requests that passed auth, so that others can't use up limits of a key store; admin
+		// requests, e.g. to raise the limits, are never limited
+		if keyStoreLimiter != nil && !h.Auth().HasFlag(rest.AuthAdmin) {
+			handler = keyStoreLimiter.Middleware(handler)
+		}
+
+		dryRun := params.EnableDryRun && h.Auth().HasFlag(rest.AuthZCAP)
+
+		if dryRun {
+			handler = dryrun.Terminate(op.Validate(h.Action()))(handler)
+		}
+
+		if h.Auth().HasFlag(rest.AuthAdmin) {
+			// admin endpoints require the admin token even if auth is disabled
+			if params.AdminToken.IsEmpty() {
+				continue
+			}
+
+			handler = authmw.Wrap(&adminmw.Middleware{Token: params.AdminToken})(handler)
+		} else if !params.DisableAuth && !h.Auth().HasFlag(rest.AuthNone) {
+			middlewares := make([]authmw.Middleware, 0)
+
+			if h.Auth().HasFlag(rest.AuthOAuth2) {
+				middlewares = append(middlewares, &oauthmw.Middleware{})
+			}
+
+			if h.Auth().HasFlag(rest.AuthZCAP) {
+				middlewares = append(middlewares, &zcapmw.Middleware{Config: zcapConfig, Action: h.Action()})
+
+				// without the flag, bearer tokens are never accepted for key store operations
+				if params.EnableNoZCAP {
+					middlewares = append(middlewares, &nozcapmw.Middleware{
+						KeyStores:       cmd,
+						KeyStoreVarName: rest.KeyStoreVarName,
+					})
+				}
+			}
+
+			if h.Auth().HasFlag(rest.AuthGNAP) {
+				middlewares = append(middlewares, &gnapmw.Middleware{Client: gnapRSClient, RSPubKey: publicJWK})
+			}
+
+			if h.Auth().HasFlag(rest.AuthToken) {
+				middlewares = append(middlewares, &tokenmw.Middleware{
+					Store:           config.OneTimeTokens,
+					Action:          h.Action(),
+					KeyStoreVarName: rest.KeyStoreVarName,
+					KeyVarName:      rest.KeyVarName,
+				})
+			}
+
+			handler = authmw.Wrap(middlewares...)(handler)
+		}
+
+		if respSigner != nil && h.Action() == command.ActionExportKey {
+			handler = respSigner.Middleware(handler)
+		}
+
+		if dryRun {
+			handler = dryrun.Middleware(h.Action())(handler)
+		}
+
+		if readOnly && isWriteAction(h.Action()) {
+			handler = standbyHandler()
+		}
+
+		if loadShedder != nil {
+			handler = loadShedder.Middleware(loadShedPriority(h.Action()))(handler)
+		}
+
+		router.Handle(h.Path(), handler).Methods(h.Method())
+	}
+
+	// log lines of key store requests carry the key store and its controller
+	router.Use(mw.LogContext(cmd, rest.KeyStoreVarName))
+
+	var handler http.Handler = router
+
+	if params.EnableCORS {
+		handler = cors.New(
+			cors.Options{
+				AllowedMethods: []string{
+					http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+					http.MethodOptions,
+				},
+				AllowedHeaders: []string{"*"},
+				MaxAge:         60,
+			},
+		).Handler(router)
+	}
+
+	// error rate objectives are evaluated against HTTP metrics, so they are recorded even if metrics aren't exposed
+	if params.MetricsHost != "" || params.SLOConfigPath != "" {
+		router.Use(mw.PrometheusMiddleware)
+	}
+
+	if params.SLOConfigPath != "" {
+		sloConfig, sloErr := slo.LoadConfig(params.SLOConfigPath)
+		if sloErr != nil {
+			return nil, sloErr
+		}
+
+		if sloConfig.WebhookURL != "" {
+			sloConfig.HTTPClient = breaker.Client(breakers[breaker.DependencyWebhook],
+				&http.Transport{TLSClientConfig: tlsConfig}, params.Dependencies.WebhookTimeout)
+		}
+
+		evaluator := slo.New(sloConfig)
+		evaluator.Start()
+
+		s.stop = append(s.stop, evaluator.Stop)
+	}
+
+	s.Handler = handler
+	s.Command = cmd
+
+	return s, nil
+}
+
+// Close stops the background jobs of the server. The storage is left open, since the caller may share it.
+func (s *Server) Close() {
+	for _, stop := range s.stop {
+		stop()
+	}
+}
+
+// withDefaults returns a copy of the parameters with empty groups of optional parameters set, so that embedding
+// services only set what they use.
+func withDefaults(params *Parameters) *Parameters {
+	p := *params
+
+	if p.TLS == nil {
+		p.TLS = &TLSParameters{}
+	}
+
+	if p.Dependencies == nil {
+		p.Dependencies = &DependencyParameters{}
+	}
+
+	if p.OAuth == nil {
+		p.OAuth = &OAuthParameters{}
+	}
+
+	if p.SecretLock == nil {
+		p.SecretLock = &SecretLockParameters{}
+	}
+
+	if p.KeyStoreLimits == nil {
+		p.KeyStoreLimits = &KeyStoreLimitParameters{}
+	}
+
+	return &p
+}
+
+// setupReplication wraps the store to publish records to the standby on the primary, or creates the ingester of
+// records from the primary on the standby.
+func (s *Server) setupReplication(params *ReplicationParameters, store storage.Provider, rootCAs *x509.CertPool,
+	clk clock.Clock) (storage.Provider, *replication.Index, error) {
+	if params == nil || params.Mode == "" {
+		return store, nil, nil
+	}
+
+	index, err := replication.NewIndex(store)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create replication index: %w", err)
+	}
+
+	if params.Mode == replication.ModeStandby {
+		s.ingester = replication.NewIngester(replication.IngesterConfig{
+			Provider: store,
+			Index:    index,
+			Token:    params.Token,
+			Clock:    clk,
+		})
+
+		return store, index, nil
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if params.TLSCertPath != "" && params.TLSKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(params.TLSCertPath, params.TLSKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load replication client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	publisher := replication.NewPublisher(replication.PublisherConfig{
+		StandbyURL: params.StandbyURL,
+		Token:      params.Token,
+		HTTPClient: &http.Client{
+			Timeout: time.Minute,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		Index: index,
+		Clock: clk,
+	})
+
+	publisher.Start()
+
+	s.stop = append(s.stop, publisher.Stop)
+
+	return publisher.Wrap(store), index, nil
+}
+
+// setupUsageReports creates the counter of operations of key stores, and starts the generator of usage reports. Every
+// instance counts operations, reports are generated by the instance that holds the lease.
+func (s *Server) setupUsageReports(params *UsageReportParameters, store storage.Provider, cmd *command.Command,
+	signer *respsign.Signer, clk clock.Clock, webhookClient *http.Client) (*usagereport.Counter, error) {
+	if signer == nil {
+		return nil, errors.New("usage reports require a response signing key")
+	}
+
+	instanceID, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	counter, err := usagereport.NewCounter(store, clk, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("create usage counter: %w", err)
+	}
+
+	target, err := createUsageReportTarget(params, webhookClient)
+	if err != nil {
+		return nil, err
+	}
+
+	generator, err := usagereport.New(&usagereport.Config{
+		Provider:     store,
+		Counter:      counter,
+		StorageUsage: cmd,
+		Signer:       signer,
+		Target:       target,
+		Clock:        clk,
+		InstanceID:   instanceID,
+		RateLimit:    params.RateLimit,
+		MaxRetries:   params.MaxRetries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create usage report generator: %w", err)
+	}
+
+	generator.Start()
+
+	s.stop = append(s.stop, generator.Stop)
+
+	return counter, nil
+}
+
+// createReadinessChecker returns the checker of the /ready endpoint. It checks the stores of key store metadata and
+// of keys, read uncached, and the Auth server if it's configured.
+func createReadinessChecker(timeout time.Duration, store, keyStorage storage.Provider, authServerURL string,
+	authServerEndpoint *discovery.Endpoint, client *http.Client) *readiness.Checker {
+	var opts []readiness.Option
+
+	if timeout > 0 {
+		opts = append(opts, readiness.WithTimeout(timeout))
+	}
+
+	checker := readiness.New(opts...)
+
+	checker.Add("database", readiness.StoreCheck(store, "keystores"))
+	checker.Add("key_storage", readiness.StoreCheck(keyStorage, localkms.Namespace))
+
+	if authServerURL != "" {
+		checker.Add("auth_server", readiness.HTTPCheck(client, func() string {
+			if authServerEndpoint != nil {
+				return authServerEndpoint.URL() + rest.HealthCheckPath
+			}
+
+			return authServerURL + rest.HealthCheckPath
+		}))
+	}
+
+	return checker
+}
+
+// createCache returns the cache of stored records and Shamir secret shares: the in-memory cache, or a Redis cache
+// shared by server instances. Redis being unavailable doesn't fail the start: the cache falls through to origin until
+// Redis is reachable.
+func (s *Server) createCache(params *Parameters, memCache cache.Cache, clk clock.Clock) (cache.Cache, error) {
+	if params.CacheType != cacheTypeRedisOption {
+		return memCache, nil
+	}
+
+	c, err := redis.New(params.CacheURL,
+		redis.WithKeyPrefix(params.DatabasePrefix+"kms_cache:"),
+		redis.WithTLSConfig(&tls.Config{RootCAs: s.rootCAs, MinVersion: tls.VersionTLS12}),
+		redis.WithClock(clk),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create redis cache %s: %w", redactSecretURL(params.CacheURL), err)
+	}
+
+	if err = c.Ping(); err != nil {
+		logger.Warnf("redis cache %s is unavailable, reads fall through to origin: %v",
+			redactSecretURL(params.CacheURL), err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package startcmd //nolint:testpackage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/command"
+	"github.com/trustbloc/kms/pkg/controller/mw"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		params, err := ParseParameters(requiredArgs(storageTypeMemOption))
+		require.NoError(t, err)
+
+		s, err := New(params)
+		require.NoError(t, err)
+		require.NotNil(t, s.Command)
+
+		defer s.Close()
+
+		rr := httptest.NewRecorder()
+
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthcheck", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Readiness probe", func(t *testing.T) {
+		authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/healthcheck" {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer authServer.Close()
+
+		params, err := ParseParameters(requiredArgs(storageTypeMemOption))
+		require.NoError(t, err)
+
+		s, err := New(params)
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		// answered without credentials
+		rr := httptest.NewRecorder()
+
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.JSONEq(t, `{"status":"ready","dependencies":{"database":{"status":"ok"},"key_storage":{"status":"ok"}}}`,
+			rr.Body.String())
+
+		params.AuthServerURL = authServer.URL
+
+		s, err = New(params)
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		rr = httptest.NewRecorder()
+
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Contains(t, rr.Body.String(), `"auth_server":{"status":"fail","error":"unexpected status 503"}`)
+	})
+
+	t.Run("Refuses EDV key stores if EDV is disabled", func(t *testing.T) {
+		params, err := ParseParameters(append(requiredArgs(storageTypeMemOption),
+			"--"+disableAuthFlagName, "true", "--"+disableEDVFlagName, "true"))
+		require.NoError(t, err)
+
+		s, err := New(params)
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		rr := httptest.NewRecorder()
+
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores", strings.NewReader(
+			`{"controller":"did:example:test","storage_mode":"edv",`+
+				`"edv":{"vault_url":"https://edv.example.com/encrypted-data-vaults/vault-id"}}`)))
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		require.Contains(t, rr.Body.String(), "storage_mode edv is not supported by the server")
+	})
+
+	t.Run("Limits requests of a key store", func(t *testing.T) {
+		params, err := ParseParameters(append(requiredArgs(storageTypeMemOption),
+			"--"+disableAuthFlagName, "true", "--"+keyStoreRateLimitFlagName, "1"))
+		require.NoError(t, err)
+
+		s, err := New(params)
+		require.NoError(t, err)
+
+		defer s.Close()
+
+		rr := httptest.NewRecorder()
+
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores",
+			strings.NewReader(`{"controller":"did:example:test"}`)))
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		var resp command.CreateKeyStoreResponse
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		keyStoreURL := resp.KeyStoreURL
+
+		keyStorePath := keyStoreURL[strings.Index(keyStoreURL, "/v1/keystores/"):]
+
+		rr = httptest.NewRecorder()
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, keyStorePath, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		rr = httptest.NewRecorder()
+		s.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, keyStorePath, nil))
+		require.Equal(t, http.StatusTooManyRequests, rr.Code)
+		require.Contains(t, rr.Body.String(), mw.RateLimitedCode)
+	})
+
+	t.Run("Success without optional parameter groups", func(t *testing.T) {
+		params, err := ParseParameters(requiredArgs(storageTypeMemOption))
+		require.NoError(t, err)
+
+		params.TLS, params.Dependencies, params.OAuth = nil, nil, nil
+		params.LoadShed, params.CryptoPools, params.VerifyCache = nil, nil, nil
+		params.ResponseSigning, params.Replication, params.KeyStoreLimits = nil, nil, nil
+
+		s, err := New(params)
+		require.NoError(t, err)
+
+		s.Close()
+
+		// the parameters of the caller are left as is
+		require.Nil(t, params.TLS)
+	})
+
+	t.Run("Fail with zero intervals", func(t *testing.T) {
+		params, err := ParseParameters(requiredArgs(storageTypeMemOption))
+		require.NoError(t, err)
+
+		params.KeyPurgeInterval = 0
+
+		_, err = New(params)
+		require.EqualError(t, err, "key purge and expired record prune intervals must be positive")
+	})
+
+	t.Run("Fail with missing secret lock", func(t *testing.T) {
+		params, err := ParseParameters(requiredArgs(storageTypeMemOption))
+		require.NoError(t, err)
+
+		params.SecretLock = nil
+
+		_, err = New(params)
+		require.ErrorIs(t, err, ErrProviderNotSupported)
+	})
+
+	t.Run("Fail with invalid flags", func(t *testing.T) {
+		_, err := ParseParameters([]string{"--unknown"})
+		require.Error(t, err)
+	})
+}
@@ -7,79 +7,84 @@ SPDX-License-Identifier: Apache-2.0
 package startcmd
 
 import (
+	"crypto/ecdsa"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	awskms "github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/dgraph-io/ristretto"
 	"github.com/google/tink/go/core/registry"
 	tinkawskms "github.com/google/tink/go/integration/awskms"
 	"github.com/gorilla/mux"
-	"github.com/hyperledger/aries-framework-go-ext/component/storage/couchdb"
-	"github.com/hyperledger/aries-framework-go-ext/component/storage/mongodb"
 	"github.com/hyperledger/aries-framework-go-ext/component/vdr/orb"
-	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	"github.com/hyperledger/aries-framework-go/pkg/common/log"
-	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
-	"github.com/hyperledger/aries-framework-go/pkg/secretlock/local"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock/local/masterlock/hkdf"
 	ldstore "github.com/hyperledger/aries-framework-go/pkg/store/ld"
 	"github.com/hyperledger/aries-framework-go/pkg/vdr"
 	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
 	logspi "github.com/hyperledger/aries-framework-go/spi/log"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
-	"github.com/lafriks/go-shamir"
 	jsonld "github.com/piprate/json-gold/ld"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/rs/cors"
 	"github.com/spf13/cobra"
 	"github.com/square/go-jose/v3"
-	"github.com/trustbloc/auth/component/gnap/rs"
-	"github.com/trustbloc/auth/spi/gnap/proof/httpsig"
-	tlsutil "github.com/trustbloc/edge-core/pkg/utils/tls"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/aws/webidentity"
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/clientcredentials"
+	"github.com/trustbloc/kms/pkg/clock"
 	"github.com/trustbloc/kms/pkg/controller/command"
 	"github.com/trustbloc/kms/pkg/controller/mw"
-	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
-	"github.com/trustbloc/kms/pkg/controller/mw/authmw/gnapmw"
-	"github.com/trustbloc/kms/pkg/controller/mw/authmw/oauthmw"
-	"github.com/trustbloc/kms/pkg/controller/mw/authmw/zcapmw"
 	"github.com/trustbloc/kms/pkg/controller/rest"
-	kmscache "github.com/trustbloc/kms/pkg/kms/cache"
+	"github.com/trustbloc/kms/pkg/cryptopool"
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+	"github.com/trustbloc/kms/pkg/kms/subkey"
 	"github.com/trustbloc/kms/pkg/metrics"
-	awssecretlock "github.com/trustbloc/kms/pkg/secretlock/aws"
-	shamirprovider "github.com/trustbloc/kms/pkg/shamir"
-	shamircache "github.com/trustbloc/kms/pkg/shamir/cache"
+	"github.com/trustbloc/kms/pkg/replication"
+	"github.com/trustbloc/kms/pkg/reqlog"
+	"github.com/trustbloc/kms/pkg/respsign"
+	"github.com/trustbloc/kms/pkg/secretshare"
+	"github.com/trustbloc/kms/pkg/storage/archive"
 	"github.com/trustbloc/kms/pkg/storage/cache"
-	storagemetrics "github.com/trustbloc/kms/pkg/storage/metrics"
-	zcapsvc "github.com/trustbloc/kms/pkg/zcapld"
+	"github.com/trustbloc/kms/pkg/usagereport"
+	"github.com/trustbloc/kms/pkg/verifycache"
 )
 
 const (
 	keystoreLocalPrimaryKeyURI = "local-lock://keystorekms"
+	instanceIDSize             = 16
 )
 
 var logger = log.New("kms-server")
 
 type server interface {
 	ListenAndServe(host, certFile, keyFile string, router http.Handler) error
+	ListenAndServeMTLS(host, certFile, keyFile string, clientCAs *x509.CertPool, router http.Handler) error
 }
 
 // HTTPServer is an actual server implementation.
@@ -94,6 +99,22 @@ func (s *HTTPServer) ListenAndServe(host, certFile, keyFile string, router http.
 	return http.ListenAndServe(host, router) //nolint: wrapcheck
 }
 
+// ListenAndServeMTLS starts the server that requires clients to present a certificate signed by one of clientCAs.
+func (s *HTTPServer) ListenAndServeMTLS(host, certFile, keyFile string, clientCAs *x509.CertPool,
+	router http.Handler) error {
+	srv := &http.Server{
+		Addr:    host,
+		Handler: router,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	return srv.ListenAndServeTLS(certFile, keyFile) //nolint: wrapcheck
+}
+
 // Cmd returns the Cobra start command.
 func Cmd(srv server) (*cobra.Command, error) {
 	startCmd := createStartCmd(srv)
@@ -119,286 +140,161 @@ func createStartCmd(srv server) *cobra.Command {
 	}
 }
 
-func startServer(srv server, params *serverParameters) error { //nolint:funlen
-	setLogLevel(params.logLevel)
-
-	rootCAs, err := tlsutil.GetCertPool(params.tlsParams.systemCertPool, params.tlsParams.caCerts)
-	if err != nil {
-		return fmt.Errorf("get cert pool: %w", err)
+func startServer(srv server, params *Parameters) error {
+	// the provider is only installed if nothing was logged before
+	if params.LogFormat == reqlog.FormatJSON {
+		log.Initialize(reqlog.NewJSONProvider(os.Stdout))
 	}
 
-	tlsConfig := &tls.Config{
-		RootCAs:    rootCAs,
-		MinVersion: tls.VersionTLS12,
-	}
+	setLogLevel(params.LogLevel)
 
-	httpClient := &http.Client{
-		Timeout: time.Minute,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
-
-	store, err := createStoreProvider(
-		params.databaseType,
-		params.databaseURL,
-		params.databasePrefix,
-		params.databaseTimeout,
-	)
+	s, err := New(params)
 	if err != nil {
-		return fmt.Errorf("create store provider: %w", err)
-	}
-
-	var (
-		storageProvider     storage.Provider
-		cacheProvider       *cache.Provider
-		kmsCacheProvider    *kmscache.Provider
-		shamirCacheProvider *shamircache.Provider
-	)
-
-	if params.enableCache {
-		c, err := ristretto.NewCache(&ristretto.Config{
-			NumCounters: 1e7, // TODO: make these values configurable
-			MaxCost:     1 << 30,
-			BufferItems: 64,
-		})
-		if err != nil {
-			return fmt.Errorf("create ristretto cache: %w", err)
-		}
-
-		cacheProvider = &cache.Provider{Cache: c}
-		storageProvider = cacheProvider.Wrap(store)
-		kmsCacheProvider = &kmscache.Provider{Cache: c}
-		shamirCacheProvider = &shamircache.Provider{Cache: c}
-
-	} else {
-		storageProvider = store
+		return err
 	}
 
-	kmsService, err := createKMS(storageProvider, params.secretLockParams)
-	if err != nil {
-		return fmt.Errorf("create kms: %w", err)
+	if s.ingester != nil {
+		// serve cert and key are required in standby mode, see getReplicationParameters
+		go startIngestion(srv, params.Replication.IngestHost, params.TLS.ServeCertPath, params.TLS.ServeKeyPath,
+			s.rootCAs, s.ingester)
 	}
 
-	if kmsCacheProvider != nil && params.kmsCacheTTL >= 0 {
-		kmsService, err = kmsCacheProvider.WrapKMS(kmsService, params.kmsCacheTTL)
-		if err != nil {
-			return fmt.Errorf("wrap kms: %w", err)
-		}
+	if params.MetricsHost != "" {
+		go startMetrics(srv, params.MetricsHost)
 	}
 
-	cryptoService, err := tinkcrypto.New()
-	if err != nil {
-		return fmt.Errorf("create tink crypto: %w", err)
-	}
+	logger.Infof("Starting kms-server on host [%s]", params.Host)
 
-	vdrResolver, err := createVDR(params.didDomain, tlsConfig)
-	if err != nil {
-		return fmt.Errorf("create vdr resolver: %w", err)
-	}
+	return srv.ListenAndServe(
+		params.Host,
+		params.TLS.ServeCertPath,
+		params.TLS.ServeKeyPath,
+		s.Handler,
+	)
+}
 
-	documentLoader, err := createJSONLDDocumentLoader(storageProvider)
+func setLogLevel(level string) {
+	logLevel, err := log.ParseLevel(level)
 	if err != nil {
-		return fmt.Errorf("create document loader: %w", err)
-	}
+		logger.Warnf("%s is not a valid logging level. It must be one of the following: "+
+			"critical, error, warning, info, debug. Defaulting to info.", level)
 
-	zcapService, err := zcapsvc.New(kmsService, cryptoService, storageProvider, documentLoader)
-	if err != nil {
-		return fmt.Errorf("create zcap service: %w", err)
+		logLevel = logspi.INFO
 	}
 
-	baseKeyStoreURL := params.baseURL + rest.KeyStorePath
-
-	var shamirProvider shamirprovider.Provider
+	log.SetLevel("", logLevel)
+}
 
-	if params.authServerURL != "" && params.authServerToken != "" {
-		shamirProvider = shamirprovider.CreateProvider(&shamirprovider.ProviderConfig{
-			HTTPClient:      httpClient,
-			AuthServerURL:   params.authServerURL,
-			AuthServerToken: params.authServerToken,
-		})
-	}
+const (
+	storageTypeMemOption      = "mem"
+	storageTypeCouchDBOption  = "couchdb"
+	storageTypeMongoDBOption  = "mongodb"
+	storageTypePostgresOption = "postgres"
 
-	if shamirCacheProvider != nil && shamirProvider != nil && params.shamirSecretCacheTTL >= 0 {
-		shamirProvider = shamirCacheProvider.Wrap(shamirProvider, params.shamirSecretCacheTTL)
-	}
+	keyArchiveTypeS3Option = "s3"
 
-	config := &command.Config{
-		StorageProvider:         storageProvider,
-		KeyStorageProvider:      store,
-		KMS:                     kmsService,
-		Crypto:                  cryptoService,
-		VDRResolver:             vdrResolver,
-		DocumentLoader:          documentLoader,
-		KeyStoreCreator:         &keyStoreCreator{},
-		ShamirSecretLockCreator: &shamirSecretLockCreator{},
-		CryptBoxCreator:         &cryptoBoxCreator{},
-		ZCAPService:             zcapService,
-		EnableZCAPs:             !params.disableAuth,
-		HeaderSigner:            zcapService,
-		TLSConfig:               tlsConfig,
-		BaseKeyStoreURL:         baseKeyStoreURL,
-		ShamirProvider:          shamirProvider,
-		MainKeyType:             kms.AES256GCMType,
-		EDVRecipientKeyType:     kms.NISTP256ECDHKW,
-		EDVMACKeyType:           kms.HMACSHA256Tag256,
-		KeyStoreCacheTTL:        params.keyStoreCacheTTL,
-		MetricsProvider:         metrics.Get(),
-	}
+	cacheTypeMemoryOption = "memory"
+	cacheTypeRedisOption  = "redis"
 
-	if cacheProvider != nil {
-		config.CacheProvider = &cacheProviderWithTTL{Provider: cacheProvider}
-	}
+	usageReportTargetWebhookOption = "webhook"
+	usageReportTargetS3Option      = "s3"
+)
 
-	cmd, err := command.New(config)
+func createStoreProvider(typ, url, prefix string, timeout time.Duration) (storage.Provider, error) {
+	createProvider, err := getStorageProviderFactory(typ)
 	if err != nil {
-		return fmt.Errorf("create command: %w", err)
+		return nil, err
 	}
 
-	router := mux.NewRouter()
+	var store storage.Provider
 
-	zcapConfig := &zcapmw.ZCAPConfig{
-		AuthService:          zcapService,
-		JSONLDLoader:         documentLoader,
-		Logger:               logger,
-		VDRResolver:          vdrResolver,
-		BaseResourceURL:      baseKeyStoreURL,
-		ResourceIDQueryParam: rest.KeyStoreVarName,
-	}
+	return store, backoff.RetryNotify(
+		func() error {
+			store, err = createProvider(url, prefix)
 
-	var (
-		privateJWK, publicJWK *jwk.JWK
-		gnapRSClient          *rs.Client
+			return err
+		},
+		backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), uint64(timeout.Seconds())),
+		func(retryErr error, t time.Duration) {
+			logger.Warnf("Failed to connect to storage, will sleep for %s before trying again: %v", t, retryErr)
+		},
 	)
+}
 
-	if !params.disableAuth {
-		privateJWK, publicJWK, err = createGNAPSigningJWK(params.gnapSigningKeyPath)
+// createKeyArchive returns the archive of keys of the key storage. Keys are archived to a bucket of an S3-compatible
+// object store, or to a database of the supported types.
+func createKeyArchive(params *KeyArchiveParameters, keyStorage storage.Provider, clk clock.Clock,
+	timeout time.Duration) (*archive.Provider, error) {
+	var target archive.Target
+
+	if strings.EqualFold(params.Type, keyArchiveTypeS3Option) {
+		sess, err := session.NewSession(&aws.Config{
+			Endpoint: aws.String(params.S3Endpoint),
+			Region:   aws.String(params.S3Region),
+			// S3-compatible stores are usually addressed by path rather than by bucket subdomain
+			S3ForcePathStyle: aws.Bool(params.S3Endpoint != ""),
+		})
 		if err != nil {
-			return fmt.Errorf("create gnap signing jwk: %w", err)
+			return nil, fmt.Errorf("create s3 session: %w", err)
 		}
 
-		gnapRSClient, err = rs.NewClient(
-			&httpsig.Signer{SigningKey: privateJWK},
-			httpClient,
-			params.authServerURL,
-		)
-	}
-
-	for _, h := range rest.New(cmd).GetRESTHandlers() {
-		var handler http.Handler = h.Handler()
-
-		if !params.disableAuth && !h.Auth().HasFlag(rest.AuthNone) {
-			middlewares := make([]authmw.Middleware, 0)
-
-			if h.Auth().HasFlag(rest.AuthOAuth2) {
-				middlewares = append(middlewares, &oauthmw.Middleware{})
-			}
-
-			if h.Auth().HasFlag(rest.AuthZCAP) {
-				middlewares = append(middlewares, &zcapmw.Middleware{Config: zcapConfig, Action: h.Action()})
-			}
-
-			if h.Auth().HasFlag(rest.AuthGNAP) {
-				middlewares = append(middlewares, &gnapmw.Middleware{Client: gnapRSClient, RSPubKey: publicJWK})
-			}
-
-			handler = authmw.Wrap(middlewares...)(handler)
+		target = archive.NewS3Target(s3.New(sess), params.URL)
+	} else {
+		store, err := createStoreProvider(params.Type, params.URL, params.Prefix, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("create archive store provider: %w", err)
 		}
 
-		router.Handle(h.Path(), handler).Methods(h.Method())
+		target = archive.NewStorageTarget(store)
 	}
 
-	var handler http.Handler = router
+	return archive.New(keyStorage, target, archive.WithClock(clk), archive.WithMetrics(metrics.Get())), nil
+}
 
-	if params.enableCORS {
-		handler = cors.New(
-			cors.Options{
-				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodOptions},
-				AllowedHeaders: []string{"*"},
-				MaxAge:         60,
-			},
-		).Handler(router)
+// createUsageReportTarget returns the target usage reports are delivered to: a webhook, or a bucket of an
+// S3-compatible object store.
+func createUsageReportTarget(params *UsageReportParameters, webhookClient *http.Client) (usagereport.Target, error) {
+	if strings.EqualFold(params.Target, usageReportTargetWebhookOption) {
+		return usagereport.NewWebhookTarget(params.URL, webhookClient), nil
 	}
 
-	if params.metricsHost != "" {
-		router.Use(mw.PrometheusMiddleware)
-
-		go startMetrics(srv, params.metricsHost)
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(params.S3Endpoint),
+		Region:           aws.String(params.S3Region),
+		S3ForcePathStyle: aws.Bool(params.S3Endpoint != ""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 session: %w", err)
 	}
 
-	logger.Infof("Starting kms-server on host [%s]", params.host)
-
-	return srv.ListenAndServe(
-		params.host,
-		params.tlsParams.serveCertPath,
-		params.tlsParams.serveKeyPath,
-		handler,
-	)
+	return usagereport.NewS3Target(s3.New(sess), params.URL, params.S3Prefix), nil
 }
 
-func setLogLevel(level string) {
-	logLevel, err := log.ParseLevel(level)
-	if err != nil {
-		logger.Warnf("%s is not a valid logging level. It must be one of the following: "+
-			"critical, error, warning, info, debug. Defaulting to info.", level)
+// newInstanceID returns a random ID of the server instance, e.g. to tell holders of leases apart.
+func newInstanceID() (string, error) {
+	b := make([]byte, instanceIDSize)
 
-		logLevel = logspi.INFO
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate instance id: %w", err)
 	}
 
-	log.SetLevel("", logLevel)
+	return hex.EncodeToString(b), nil
 }
 
-const (
-	storageTypeMemOption     = "mem"
-	storageTypeCouchDBOption = "couchdb"
-	storageTypeMongoDBOption = "mongodb"
-)
-
-func createStoreProvider(typ, url, prefix string, timeout time.Duration) (storage.Provider, error) {
-	var createProvider func(url, prefix string) (storage.Provider, error)
-
-	switch {
-	case strings.EqualFold(typ, storageTypeMemOption):
-		createProvider = func(string, string) (storage.Provider, error) { //nolint:unparam
-			return mem.NewProvider(), nil
-		}
-	case strings.EqualFold(typ, storageTypeCouchDBOption):
-		createProvider = func(url, prefix string) (storage.Provider, error) {
-			couchDBProvider, err := couchdb.NewProvider(url, couchdb.WithDBPrefix(prefix))
-			if err != nil {
-				return nil, err
-			}
-
-			return storagemetrics.Wrap(couchDBProvider, "CouchDB"), nil
-		}
-	case strings.EqualFold(typ, storageTypeMongoDBOption):
-		createProvider = func(url, prefix string) (storage.Provider, error) {
-			mongoDBProvider, err := mongodb.NewProvider(url, mongodb.WithDBPrefix(prefix))
-			if err != nil {
-				return nil, err
-			}
+// recordOptions returns options of expiring record stores for the database type. Expired records are found with a
+// range query on MongoDB and PostgreSQL, and in memory with mem. CouchDB doesn't support range queries, so records
+// are scanned.
+func recordOptions(databaseType string, pruner *expiry.Pruner) []expiry.Option {
+	opts := []expiry.Option{expiry.WithPruner(pruner), expiry.WithMetrics(metrics.Get())}
 
-			return storagemetrics.Wrap(mongoDBProvider, "MongoDB"), nil
-		}
-	default:
-		return nil, fmt.Errorf("not supported database type: %s", typ)
+	switch strings.ToLower(databaseType) {
+	case storageTypeMongoDBOption, storageTypePostgresOption:
+		opts = append(opts, expiry.WithMode(expiry.ModeRange))
+	case storageTypeMemOption:
+		opts = append(opts, expiry.WithMode(expiry.ModeHeap))
 	}
 
-	var store storage.Provider
-
-	var err error
-
-	return store, backoff.RetryNotify(
-		func() error {
-			store, err = createProvider(url, prefix)
-
-			return err
-		},
-		backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), uint64(timeout.Seconds())),
-		func(retryErr error, t time.Duration) {
-			logger.Warnf("Failed to connect to storage, will sleep for %s before trying again: %v", t, retryErr)
-		},
-	)
+	return opts
 }
 
 type kmsProvider struct {
@@ -414,7 +310,7 @@ func (p kmsProvider) SecretLock() secretlock.Service {
 	return p.secretLock
 }
 
-func createKMS(store storage.Provider, secretLockParams *secretLockParameters) (kms.KeyManager, error) {
+func createKMS(store storage.Provider, secretLockParams *SecretLockParameters) (kms.KeyManager, error) {
 	secretLock, primaryKeyURI, err := createSecretLock(secretLockParams)
 	if err != nil {
 		return nil, fmt.Errorf("create kms secretlock: %w", err)
@@ -426,8 +322,8 @@ func createKMS(store storage.Provider, secretLockParams *secretLockParameters) (
 	})
 }
 
-func createVDR(didDomain string, tlsConfig *tls.Config) (zcapld.VDRResolver, error) {
-	orbVDR, err := orb.New(nil, orb.WithDomain(didDomain), orb.WithTLSConfig(tlsConfig))
+func createVDR(didDomain string, httpClient *http.Client) (zcapld.VDRResolver, error) {
+	orbVDR, err := orb.New(nil, orb.WithDomain(didDomain), orb.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, fmt.Errorf("create orb: %w", err)
 	}
@@ -438,53 +334,15 @@ func createVDR(didDomain string, tlsConfig *tls.Config) (zcapld.VDRResolver, err
 	), nil
 }
 
-func createSecretLock(parameters *secretLockParameters) (secretlock.Service, string, error) {
-	if parameters.secretLockType == secretLockTypeAWSOption {
-		secretLock, err := createAwsSecretLock(parameters)
-
-		return secretLock, keystoreLocalPrimaryKeyURI /*parameters.awsKeyURI*/, err
-	}
-
-	if parameters.secretLockType == secretLockTypeLocalOption {
-		secretLock, err := createLocalSecretLock(parameters.localKeyPath)
-
-		return secretLock, keystoreLocalPrimaryKeyURI, err
-	}
-
-	return nil, "", fmt.Errorf("invalid secret lock key type: %s", parameters.secretLockType)
-}
-
-func createAwsSecretLock(parameters *secretLockParameters) (secretlock.Service, error) {
-	primaryKeyLock, err := awssecretlock.New(
-		parameters.awsKeyURI,
-
-		&awsProvider{
-			awsEndpoint: parameters.awsEndpoint,
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("create aws secret lock failed: %w", err)
-	}
-
-	return primaryKeyLock, nil
-}
-
-func createLocalSecretLock(keyPath string) (secretlock.Service, error) {
-	if keyPath == "" {
-		return nil, fmt.Errorf("no key defined for local secret lock")
-	}
-
-	primaryKeyReader, err := local.MasterKeyFromPath(keyPath)
+func createSecretLock(parameters *SecretLockParameters) (secretlock.Service, string, error) {
+	createLock, err := getSecretLockFactory(parameters.Type)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	secretLock, err := local.NewService(primaryKeyReader, nil)
-	if err != nil {
-		return nil, err
-	}
+	secretLock, err := createLock(parameters)
 
-	return secretLock, nil
+	return secretLock, keystoreLocalPrimaryKeyURI, err
 }
 
 type ldStoreProvider struct {
@@ -524,20 +382,45 @@ func createJSONLDDocumentLoader(store storage.Provider) (jsonld.DocumentLoader,
 	return documentLoader, nil
 }
 
-func createGNAPSigningJWK(keyFilePath string) (*jwk.JWK, *jwk.JWK, error) {
-	b, err := ioutil.ReadFile(keyFilePath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("read file: %w", err)
+// createAuthServerHTTPClient returns an HTTP client that authorizes requests to Auth server with client credentials
+// access tokens if the OAuth token URL is configured, or the HTTP client as is otherwise.
+func createAuthServerHTTPClient(params *OAuthParameters, httpClient *http.Client) (*http.Client, error) {
+	if params.TokenURL == "" {
+		return httpClient, nil
 	}
 
-	block, _ := pem.Decode(b)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return nil, nil, fmt.Errorf("invalid pem")
+	config := &clientcredentials.Config{
+		TokenURL:     params.TokenURL,
+		ClientID:     params.ClientID,
+		ClientSecret: params.ClientSecret,
+		Scopes:       params.Scopes,
+		HTTPClient:   httpClient,
 	}
 
-	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if params.ClientKeyPath != "" {
+		key, err := readECPrivateKey(params.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("read oauth client key: %w", err)
+		}
+
+		config.PrivateKey = key
+	}
+
+	tokens, err := clientcredentials.New(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("parse private key: %w", err)
+		return nil, fmt.Errorf("create oauth token source: %w", err)
+	}
+
+	return &http.Client{
+		Timeout:   httpClient.Timeout,
+		Transport: tokens.Transport(httpClient.Transport, params.AuthServerAudience),
+	}, nil
+}
+
+func createGNAPSigningJWK(keyFilePath string) (*jwk.JWK, *jwk.JWK, error) {
+	key, err := readECPrivateKey(keyFilePath)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// TODO: make key type configurable
@@ -562,20 +445,160 @@ func createGNAPSigningJWK(keyFilePath string) (*jwk.JWK, *jwk.JWK, error) {
 type keyStoreCreator struct{}
 
 func (c *keyStoreCreator) Create(keyURI string, provider kms.Provider) (kms.KeyManager, error) {
-	return localkms.New(keyURI, provider)
+	km, err := localkms.New(keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKM, err := secp256k1.Wrap(km, keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKM, err := rsapss.Wrap(ecKM, keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return subkey.Wrap(rsaKM, keyURI, provider)
+}
+
+// readECPrivateKey reads an EC private key from PEM file.
+func readECPrivateKey(keyFilePath string) (*ecdsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid pem")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	return key, nil
+}
+
+// readECPublicKey reads a public key from PEM file with either EC private key or PKIX public key.
+func readECPublicKey(keyFilePath string) (*ecdsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %w", err)
+		}
+
+		return &key.PublicKey, nil
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an ecdsa public key")
+		}
+
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("invalid pem type: %s", block.Type)
+	}
+}
+
+// createResponseSigner returns nil if response signing key is not configured.
+func createResponseSigner(params *ResponseSigningParameters, provider storage.Provider,
+	clk clock.Clock) (*respsign.Signer, error) {
+	if params == nil || params.KeyPath == "" {
+		return nil, nil
+	}
+
+	key, err := readECPrivateKey(params.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("read response signing key: %w", err)
+	}
+
+	retiredKeys := make([]*ecdsa.PublicKey, 0, len(params.RetiredKeyPaths))
+
+	for _, path := range params.RetiredKeyPaths {
+		pub, err := readECPublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("read retired response signing key: %w", err)
+		}
+
+		retiredKeys = append(retiredKeys, pub)
+	}
+
+	var retired []*respsign.RetiredKey
+
+	if len(retiredKeys) > 0 {
+		store, err := provider.OpenStore(respsign.RetiredKeysStoreName)
+		if err != nil {
+			return nil, fmt.Errorf("open retired response signing key store: %w", err)
+		}
+
+		retired, err = respsign.RetireKeys(store, clk, params.Overlap, retiredKeys...)
+		if err != nil {
+			return nil, fmt.Errorf("retire response signing keys: %w", err)
+		}
+	}
+
+	signer, err := respsign.NewSigner(key, respsign.WithRetiredKeys(retired...), respsign.WithClock(clk))
+	if err != nil {
+		return nil, fmt.Errorf("new response signer: %w", err)
+	}
+
+	return signer, nil
 }
 
 type awsProvider struct {
 	awsEndpoint string
+	roleARN     string
+	tokenPath   string
+	stsEndpoint string
 }
 
-// NewSession creates a new AWS session with given credentials.
+// NewSession creates a new AWS session with given credentials. With a role ARN, credentials are obtained by assuming
+// the role with the service account token, otherwise with the default credential chain.
 func (a *awsProvider) NewSession(region string) (*session.Session, error) {
-	return session.NewSession(&aws.Config{
+	config := &aws.Config{
 		Endpoint:                      &a.awsEndpoint,
 		Region:                        aws.String(region),
 		CredentialsChainVerboseErrors: aws.Bool(true),
-	})
+	}
+
+	if a.roleARN != "" {
+		// AssumeRoleWithWebIdentity requests aren't signed, the token authenticates them
+		stsSession, err := session.NewSession(&aws.Config{
+			Endpoint:    aws.String(a.stsEndpoint),
+			Region:      aws.String(region),
+			Credentials: credentials.AnonymousCredentials,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create sts session: %w", err)
+		}
+
+		config.Credentials = webidentity.New(sts.New(stsSession), webidentity.Config{
+			RoleARN:   a.roleARN,
+			TokenPath: a.tokenPath,
+		}).Credentials()
+	}
+
+	return session.NewSession(config)
 }
 
 // NewClient returns tink KMSClient that.
@@ -583,6 +606,163 @@ func (a *awsProvider) NewClient(uriPrefix string, sess *session.Session) (regist
 	return tinkawskms.NewClientWithKMS(uriPrefix, awskms.New(sess))
 }
 
+// createVerifyCache returns nil if verify cache TTL is not set. Verify cache has its own bounded ristretto cache,
+// so cached results don't evict keys and key stores.
+func createVerifyCache(params *VerifyCacheParameters) (*verifycache.VerifyCache, error) {
+	if params == nil || params.TTL <= 0 {
+		return nil, nil
+	}
+
+	c, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: params.Size * 10, //nolint:gomnd // recommended 10x of max items
+		MaxCost:     params.Size,
+		BufferItems: 64, //nolint:gomnd
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create ristretto cache: %w", err)
+	}
+
+	return verifycache.New(c, params.TTL), nil
+}
+
+// createCryptoPools returns nil if the number of workers is limited for neither cost class.
+func createCryptoPools(params *CryptoPoolParameters, clk clock.Clock) *cryptopool.Pools {
+	if params == nil || (params.CheapWorkers == 0 && params.ExpensiveWorkers == 0) {
+		return nil
+	}
+
+	return cryptopool.New(cryptopool.Config{
+		CheapWorkers:       params.CheapWorkers,
+		ExpensiveWorkers:   params.ExpensiveWorkers,
+		ExpensiveQueueSize: params.ExpensiveQueueSize,
+		Clock:              clk,
+	})
+}
+
+// createBreakers returns a circuit breaker of each outbound dependency. Breakers share the config, timeouts are set
+// per dependency on its client.
+func createBreakers(config breaker.Config) map[string]*breaker.Breaker {
+	breakers := make(map[string]*breaker.Breaker)
+
+	for _, dependency := range []string{
+		breaker.DependencyHubAuth, breaker.DependencyEDV, breaker.DependencyDIDResolver, breaker.DependencyWebhook,
+	} {
+		breakers[dependency] = breaker.New(dependency, config, breaker.WithMetrics(metrics.Get()))
+	}
+
+	return breakers
+}
+
+// createLoadShedder returns nil if no load shedding threshold is set.
+func createLoadShedder(params *LoadShedParameters) *mw.LoadShedder {
+	if params == nil || (params.MaxHeapBytes == 0 && params.MaxGoroutines == 0) {
+		return nil
+	}
+
+	loadShedder := mw.NewLoadShedder(mw.LoadShedConfig{
+		MaxHeapBytes:   params.MaxHeapBytes,
+		MaxGoroutines:  params.MaxGoroutines,
+		SampleInterval: params.SampleInterval,
+	})
+
+	loadShedder.Start()
+
+	return loadShedder
+}
+
+// createKeyStoreLimiter returns nil if key stores can't have limits: no default limits are set and overrides are
+// disabled.
+func createKeyStoreLimiter(params *KeyStoreLimitParameters, limits *command.Command,
+	clk clock.Clock) *mw.KeyStoreLimiter {
+	if *params == (KeyStoreLimitParameters{}) {
+		return nil
+	}
+
+	return mw.NewKeyStoreLimiter(mw.KeyStoreLimitConfig{
+		Limits:          limits,
+		KeyStoreVarName: rest.KeyStoreVarName,
+		Clock:           clk,
+	})
+}
+
+// loadShedPriority returns a priority of the action under load: creates are shed first, then signs. Other
+// operations, as well as health check, are always served.
+func loadShedPriority(action string) mw.Priority {
+	switch action {
+	case command.ActionCreateDID, command.ActionCreateKeyStore, command.ActionCreateKey, command.ActionCreateKeys,
+		command.ActionImportKey, command.ActionRotateKey, command.ActionDeriveSubkey:
+		return mw.PriorityCreate
+	case command.ActionSign, command.ActionSignBatch, command.ActionSignMulti, command.ActionSignMultiKey,
+		command.ActionSignJWT, command.ActionSignUpload:
+		return mw.PrioritySign
+	default:
+		return mw.PriorityEssential
+	}
+}
+
+// startIngestion serves the ingester of records replicated from the primary on the standby, requiring client
+// certificates signed by the client CAs. It blocks until the server stops, and exits the process if it fails.
+func startIngestion(srv server, host, certFile, keyFile string, clientCAs *x509.CertPool,
+	ingester *replication.Ingester) {
+	router := mux.NewRouter()
+	router.Handle(replication.RecordsPath, ingester).Methods(http.MethodPost)
+
+	logger.Infof("Starting replication ingestion on host [%s]", host)
+
+	if err := srv.ListenAndServeMTLS(host, certFile, keyFile, clientCAs, router); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
+
+// isWriteAction returns true if the action modifies key store data. Such actions are rejected on the standby,
+// which is read-only until failover.
+func isWriteAction(action string) bool {
+	switch action {
+	case command.ActionCreateDID, command.ActionCreateKeyStore, command.ActionDeleteKeyStore, command.ActionCreateKey,
+		command.ActionCreateKeys, command.ActionImportKey, command.ActionRotateKey, command.ActionUpdateKey,
+		command.ActionSetKeyState, command.ActionDeleteKey, command.ActionRestoreKey, command.ActionCreateToken,
+		command.ActionStoreCapability, command.ActionUpdateKeyStore, command.ActionSetOverrides,
+		command.ActionDeriveSubkey, command.ActionSignUpload:
+		return true
+	default:
+		return false
+	}
+}
+
+// disabledOperations validates operations disabled with --disabled-operations against actions of the routes.
+func disabledOperations(operations []string, handlers []rest.Handler) (map[string]bool, error) {
+	routed := make(map[string]bool)
+
+	for _, h := range handlers {
+		routed[h.Action()] = true
+	}
+
+	disabled := make(map[string]bool)
+
+	for _, operation := range operations {
+		operation = strings.TrimSpace(operation)
+
+		if operation == command.ActionCreateKeyStore {
+			return nil, fmt.Errorf("operation %s can't be disabled", operation)
+		}
+
+		// the health check has no action, so an empty name doesn't disable it
+		if operation == "" || !routed[operation] {
+			return nil, fmt.Errorf("unknown operation to disable: %q", operation)
+		}
+
+		disabled[operation] = true
+	}
+
+	return disabled, nil
+}
+
+func standbyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "kms-server is a read-only replication standby", http.StatusServiceUnavailable)
+	})
+}
+
 func startMetrics(srv server, metricsHost string) {
 	metricsRouter := mux.NewRouter()
 
@@ -606,15 +786,29 @@ func startMetrics(srv server, metricsHost string) {
 type cryptoBoxCreator struct{}
 
 func (c *cryptoBoxCreator) Create(km kms.KeyManager) (command.CryptoBox, error) {
+	// the crypto box requires the local KMS itself
+	if w, ok := km.(*rsapss.KeyManager); ok {
+		km = w.KeyManager
+	}
+
+	if w, ok := km.(*secp256k1.KeyManager); ok {
+		km = w.KeyManager
+	}
+
 	return localkms.NewCryptoBox(km)
 }
 
 type shamirSecretLockCreator struct{}
 
-func (c *shamirSecretLockCreator) Create(secretShares [][]byte) (secretlock.Service, error) {
-	combined, err := shamir.Combine(secretShares...)
+func (c *shamirSecretLockCreator) Create(scheme string, secretShares [][]byte) (secretlock.Service, error) {
+	combiner, err := secretshare.NewCombiner(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("new combiner: %w", err)
+	}
+
+	combined, err := combiner.Combine(secretShares)
 	if err != nil {
-		return nil, fmt.Errorf("shamir combine: %w", err)
+		return nil, fmt.Errorf("combine secret shares: %w", err)
 	}
 
 	lock, err := hkdf.NewMasterLock(string(combined), sha256.New, nil)
@@ -629,6 +823,7 @@ type cacheProviderWithTTL struct {
 	Provider *cache.Provider
 }
 
-func (p *cacheProviderWithTTL) Wrap(storageProvider storage.Provider, ttl time.Duration) storage.Provider {
-	return p.Provider.Wrap(storageProvider, cache.WithCacheTTL(ttl))
+func (p *cacheProviderWithTTL) Wrap(storageProvider storage.Provider, keyStoreID string,
+	ttl time.Duration) storage.Provider {
+	return p.Provider.Wrap(storageProvider, cache.WithCacheTTL(ttl), cache.WithNamespace(keyStoreID))
 }
@@ -45,7 +45,7 @@ type Service struct {
 var kmsKeyTypes = map[string]arieskms.KeyType{
 	"ECDSA_SHA_256": arieskms.ECDSAP256DER,
 	"ECDSA_SHA_384": arieskms.ECDSAP384DER,
-	"ECDSA_SHA_521": arieskms.ECDSAP521DER,
+	"ECDSA_SHA_512": arieskms.ECDSAP521DER,
 }
 
 // New return aws service.
@@ -172,6 +172,37 @@ func TestPubKeyBytes(t *testing.T) {
 		require.Contains(t, string(keyType), "ECDSAP256DER")
 	})
 
+	t.Run("success with P-384 and P-521 keys", func(t *testing.T) {
+		endpoint := localhost
+		awsSession, err := session.NewSession(&aws.Config{
+			Endpoint:                      &endpoint,
+			Region:                        aws.String("ca"),
+			CredentialsChainVerboseErrors: aws.Bool(true),
+		})
+		require.NoError(t, err)
+
+		svc := New(awsSession, &mockMetrics{}, "")
+
+		for signingAlgo, expected := range map[string]string{
+			"ECDSA_SHA_384": "ECDSAP384DER",
+			"ECDSA_SHA_512": "ECDSAP521DER",
+		} {
+			signingAlgo := signingAlgo
+
+			svc.client = &mockAWSClient{getPublicKeyFunc: func(input *kms.GetPublicKeyInput) (*kms.GetPublicKeyOutput, error) {
+				return &kms.GetPublicKeyOutput{
+					PublicKey:         []byte("publickey"),
+					SigningAlgorithms: []*string{&signingAlgo},
+				}, nil
+			}}
+
+			_, keyType, err := svc.ExportPubKeyBytes(
+				"aws-kms://arn:aws:kms:ca-central-1:111122223333:key/800d5768-3fd7-4edd-a4b8-4c81c3e4c147")
+			require.NoError(t, err)
+			require.Equal(t, expected, string(keyType))
+		}
+	})
+
 	t.Run("failed to export public key", func(t *testing.T) {
 		endpoint := localhost
 		awsSession, err := session.NewSession(&aws.Config{
@@ -0,0 +1,247 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package webidentity obtains short-lived AWS credentials with OIDC workload identity federation: the Kubernetes
+// service account token of the server is exchanged at STS (AssumeRoleWithWebIdentity) for credentials of a role, so
+// no long-lived cloud keys are deployed with the server.
+//
+// Credentials are cached and refreshed before they expire. If a refresh fails, the cached credentials are used for as
+// long as they're valid, so a transient STS outage doesn't fail operations.
+package webidentity
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// ProviderName is the name of the provider in credential values.
+const ProviderName = "WebIdentityProvider"
+
+// DefaultTokenPath is the path the service account token is mounted at in Kubernetes pods.
+const DefaultTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec // not a credential
+
+const (
+	defaultSessionName   = "kms-server"
+	defaultRefreshWindow = 5 * time.Minute
+	defaultRetryInterval = 30 * time.Second
+)
+
+var logger = log.New("webidentity")
+
+// ErrExpired is returned when neither new nor cached credentials are valid.
+var ErrExpired = errors.New("web identity credentials expired")
+
+type stsClient interface {
+	AssumeRoleWithWebIdentityRequest(input *sts.AssumeRoleWithWebIdentityInput) (*request.Request,
+		*sts.AssumeRoleWithWebIdentityOutput)
+}
+
+// Config configures a Provider.
+type Config struct {
+	// RoleARN is the role to assume.
+	RoleARN string
+	// TokenPath is the path of the service account token. The token is read on every refresh, so a rotated token is
+	// picked up. Defaults to DefaultTokenPath.
+	TokenPath string
+	// SessionName identifies the role session in audit logs. Defaults to kms-server.
+	SessionName string
+	// Duration is the requested lifetime of credentials. Defaults to the maximum session duration of the role.
+	Duration time.Duration
+	// RefreshWindow is how long before expiry credentials are refreshed. Defaults to 5m.
+	RefreshWindow time.Duration
+	// RetryInterval is how long a failed refresh isn't retried while the cached credentials are valid. Defaults to
+	// 30s.
+	RetryInterval time.Duration
+}
+
+// Option configures a Provider.
+type Option func(p *Provider)
+
+// WithClock sets the clock of the provider. Defaults to the real clock.
+func WithClock(clk clock.Clock) Option {
+	return func(p *Provider) {
+		p.clock = clk
+	}
+}
+
+// Provider is an AWS credentials provider that assumes a role with a web identity token. It's safe for concurrent
+// use.
+type Provider struct {
+	client stsClient
+	config Config
+	clock  clock.Clock
+
+	mutex     sync.Mutex
+	value     credentials.Value
+	expiresAt time.Time // by the clock of the provider, corrected for skew of the clock of STS
+	retryAt   time.Time
+}
+
+// New returns a provider that assumes the role with the STS client.
+func New(client stsClient, config Config, opts ...Option) *Provider {
+	if config.TokenPath == "" {
+		config.TokenPath = DefaultTokenPath
+	}
+
+	if config.SessionName == "" {
+		config.SessionName = defaultSessionName
+	}
+
+	if config.RefreshWindow <= 0 {
+		config.RefreshWindow = defaultRefreshWindow
+	}
+
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRetryInterval
+	}
+
+	p := &Provider{client: client, config: config, clock: clock.Real()}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Credentials returns credentials that are retrieved from the provider.
+func (p *Provider) Credentials() *credentials.Credentials {
+	return credentials.NewCredentials(p)
+}
+
+// IsExpired returns true if the credentials are due for refresh: they expire within the refresh window and the last
+// failed refresh, if any, is old enough to retry.
+func (p *Provider) IsExpired() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.refreshDue(p.clock.Now())
+}
+
+// ExpiresAt returns when the cached credentials expire.
+func (p *Provider) ExpiresAt() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.expiresAt
+}
+
+// Retrieve returns the cached credentials, refreshing them if they're due. If the refresh fails, the cached
+// credentials are returned as long as they're valid.
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.clock.Now()
+
+	if !p.refreshDue(now) {
+		return p.value, nil
+	}
+
+	value, expiresAt, err := p.assumeRole(now)
+	if err == nil {
+		p.value, p.expiresAt, p.retryAt = value, expiresAt, time.Time{}
+
+		logger.Debugf("assumed role %s, credentials expire at %s", p.config.RoleARN, expiresAt)
+
+		return p.value, nil
+	}
+
+	if now.Before(p.expiresAt) {
+		p.retryAt = now.Add(p.config.RetryInterval)
+
+		logger.Warnf("refresh credentials of role %s, cached credentials expire at %s: %s",
+			p.config.RoleARN, p.expiresAt, err)
+
+		return p.value, nil
+	}
+
+	return credentials.Value{ProviderName: ProviderName}, fmt.Errorf("%w: %s", ErrExpired, err)
+}
+
+// refreshDue must be called with the mutex held.
+func (p *Provider) refreshDue(now time.Time) bool {
+	if now.Before(p.retryAt) && now.Before(p.expiresAt) {
+		return false
+	}
+
+	return !now.Before(p.expiresAt.Add(-p.config.RefreshWindow))
+}
+
+func (p *Provider) assumeRole(now time.Time) (credentials.Value, time.Time, error) {
+	token, err := os.ReadFile(p.config.TokenPath)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("read web identity token: %w", err)
+	}
+
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.config.RoleARN),
+		RoleSessionName:  aws.String(p.config.SessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	}
+
+	if p.config.Duration > 0 {
+		input.DurationSeconds = aws.Int64(int64(p.config.Duration / time.Second))
+	}
+
+	req, out := p.client.AssumeRoleWithWebIdentityRequest(input)
+
+	if err = req.Send(); err != nil {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("assume role with web identity: %w", err)
+	}
+
+	c := out.Credentials
+	if c == nil || c.AccessKeyId == nil || c.SecretAccessKey == nil || c.Expiration == nil {
+		return credentials.Value{}, time.Time{}, errors.New("assume role with web identity: no credentials")
+	}
+
+	expiresAt := c.Expiration.Add(-clockSkew(req.HTTPResponse, now))
+	if !expiresAt.After(now) {
+		return credentials.Value{}, time.Time{}, fmt.Errorf("assume role with web identity: credentials expired "+
+			"at %s", expiresAt)
+	}
+
+	return credentials.Value{
+		AccessKeyID:     *c.AccessKeyId,
+		SecretAccessKey: *c.SecretAccessKey,
+		SessionToken:    aws.StringValue(c.SessionToken),
+		ProviderName:    ProviderName,
+	}, expiresAt, nil
+}
+
+// clockSkew returns how far the clock of STS is ahead of the local clock by the Date header of the response, so that
+// expiry of credentials is evaluated by the local clock. The Date header has a second precision, smaller differences
+// aren't skew.
+func clockSkew(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+
+	skew := date.Sub(now)
+	if skew > -time.Second && skew < time.Second {
+		return 0
+	}
+
+	return skew
+}
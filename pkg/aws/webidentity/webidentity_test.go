@@ -0,0 +1,291 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package webidentity_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/aws/webidentity"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+const roleARN = "arn:aws:iam::123456789012:role/kms"
+
+func TestProvider(t *testing.T) {
+	t.Run("Caches and refreshes credentials before expiry", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{RefreshWindow: 5 * time.Minute})
+
+		value, err := env.provider.Credentials().Get()
+		require.NoError(t, err)
+		require.Equal(t, "AKID1", value.AccessKeyID)
+		require.Equal(t, "secret1", value.SecretAccessKey)
+		require.Equal(t, "session1", value.SessionToken)
+		require.Equal(t, webidentity.ProviderName, value.ProviderName)
+		require.Equal(t, env.clock.Now().Add(time.Hour), env.provider.ExpiresAt())
+
+		req := env.sts.lastRequest()
+		require.Equal(t, "AssumeRoleWithWebIdentity", req.Get("Action"))
+		require.Equal(t, roleARN, req.Get("RoleArn"))
+		require.Equal(t, "token1", req.Get("WebIdentityToken"))
+		require.Equal(t, "kms-server", req.Get("RoleSessionName"))
+
+		env.clock.Advance(54 * time.Minute)
+		require.False(t, env.provider.IsExpired())
+
+		value, err = env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, "AKID1", value.AccessKeyID)
+		require.Equal(t, 1, env.sts.calls())
+
+		// a rotated token is read on refresh
+		env.writeToken(t, "token2")
+		env.clock.Advance(time.Minute)
+		require.True(t, env.provider.IsExpired())
+
+		value, err = env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, "AKID2", value.AccessKeyID)
+		require.Equal(t, "token2", env.sts.lastRequest().Get("WebIdentityToken"))
+		require.False(t, env.provider.IsExpired())
+	})
+
+	t.Run("Refresh failure keeps valid cached credentials", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{RefreshWindow: 10 * time.Minute, RetryInterval: time.Minute})
+
+		_, err := env.provider.Retrieve()
+		require.NoError(t, err)
+
+		env.sts.fail(true)
+		env.clock.Advance(50 * time.Minute)
+		require.True(t, env.provider.IsExpired())
+
+		value, err := env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, "AKID1", value.AccessKeyID)
+		require.Equal(t, 2, env.sts.calls())
+
+		// the failed refresh isn't retried for the retry interval
+		require.False(t, env.provider.IsExpired())
+		env.clock.Advance(time.Minute)
+		require.True(t, env.provider.IsExpired())
+
+		// once the cached credentials expire, operations fail
+		env.clock.Advance(9 * time.Minute)
+
+		_, err = env.provider.Retrieve()
+		require.ErrorIs(t, err, webidentity.ErrExpired)
+		require.Contains(t, err.Error(), "InvalidIdentityToken")
+
+		// and recover with the next successful refresh, the fourth call
+		env.sts.fail(false)
+
+		value, err = env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, "AKID4", value.AccessKeyID)
+	})
+
+	t.Run("Expiry is corrected for clock skew of STS", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{})
+
+		// the local clock is 10m behind STS, credentials that STS issued for 1h expire in 1h by the local clock
+		env.sts.setSkew(10 * time.Minute)
+
+		_, err := env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, env.clock.Now().Add(time.Hour), env.provider.ExpiresAt())
+
+		// the local clock is ahead of STS
+		env.sts.setSkew(-20 * time.Minute)
+		env.clock.Advance(56 * time.Minute)
+
+		_, err = env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, env.clock.Now().Add(time.Hour), env.provider.ExpiresAt())
+	})
+
+	t.Run("Fail with credentials expired by the local clock", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{})
+		env.sts.setLifetime(-time.Minute)
+
+		_, err := env.provider.Retrieve()
+		require.ErrorIs(t, err, webidentity.ErrExpired)
+		require.Contains(t, err.Error(), "credentials expired at")
+	})
+
+	t.Run("Fail without token", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{})
+		require.NoError(t, os.Remove(env.tokenPath))
+
+		_, err := env.provider.Retrieve()
+		require.ErrorIs(t, err, webidentity.ErrExpired)
+		require.Contains(t, err.Error(), "read web identity token")
+		require.Equal(t, 0, env.sts.calls())
+	})
+
+	t.Run("Requests session duration", func(t *testing.T) {
+		env := newEnv(t, webidentity.Config{Duration: 15 * time.Minute, SessionName: "test"})
+
+		_, err := env.provider.Retrieve()
+		require.NoError(t, err)
+		require.Equal(t, "900", env.sts.lastRequest().Get("DurationSeconds"))
+		require.Equal(t, "test", env.sts.lastRequest().Get("RoleSessionName"))
+	})
+}
+
+type env struct {
+	provider  *webidentity.Provider
+	clock     *testutil.FakeClock
+	sts       *stubSTS
+	tokenPath string
+}
+
+func newEnv(t *testing.T, config webidentity.Config) *env {
+	t.Helper()
+
+	e := &env{
+		clock:     testutil.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)),
+		tokenPath: filepath.Join(t.TempDir(), "token"),
+	}
+
+	e.writeToken(t, "token1")
+
+	e.sts = &stubSTS{clock: e.clock, lifetime: time.Hour}
+
+	srv := httptest.NewServer(e.sts)
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.AnonymousCredentials,
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+
+	config.RoleARN = roleARN
+	config.TokenPath = e.tokenPath
+
+	e.provider = webidentity.New(sts.New(sess), config, webidentity.WithClock(e.clock))
+
+	return e
+}
+
+func (e *env) writeToken(t *testing.T, token string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(e.tokenPath, []byte(token+"\n"), 0o600))
+}
+
+// stubSTS issues credentials numbered by call, valid for lifetime by its clock, which is skewed from the clock of
+// the provider.
+type stubSTS struct {
+	clock *testutil.FakeClock
+
+	mutex    sync.Mutex
+	requests []map[string][]string
+	skew     time.Duration
+	lifetime time.Duration
+	failing  bool
+}
+
+func (s *stubSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	s.requests = append(s.requests, r.PostForm)
+
+	now := s.clock.Now().Add(s.skew)
+
+	w.Header().Set("Date", now.Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "text/xml")
+
+	if s.failing {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>InvalidIdentityToken</Code>`+
+			`<Message>token is invalid</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+
+		return
+	}
+
+	n := len(s.requests)
+
+	fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>AKID%d</AccessKeyId>
+      <SecretAccessKey>secret%d</SecretAccessKey>
+      <SessionToken>session%d</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+  <ResponseMetadata><RequestId>%d</RequestId></ResponseMetadata>
+</AssumeRoleWithWebIdentityResponse>`, n, n, n, now.Add(s.lifetime).Format(time.RFC3339), n)
+}
+
+func (s *stubSTS) fail(failing bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failing = failing
+}
+
+func (s *stubSTS) setSkew(skew time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.skew = skew
+}
+
+func (s *stubSTS) setLifetime(lifetime time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lifetime = lifetime
+}
+
+func (s *stubSTS) calls() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.requests)
+}
+
+func (s *stubSTS) lastRequest() valuesGetter {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return valuesGetter(s.requests[len(s.requests)-1])
+}
+
+type valuesGetter map[string][]string
+
+func (v valuesGetter) Get(key string) string {
+	if len(v[key]) == 0 {
+		return ""
+	}
+
+	return v[key][0]
+}
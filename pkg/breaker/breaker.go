@@ -0,0 +1,380 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package breaker isolates outbound dependencies (hub-auth, EDV, DID resolver, webhooks) with circuit breakers, so
+// that a slow or failing dependency fails fast instead of tying up goroutines of requests that wait for it. A breaker
+// opens when the failure rate of calls in a window exceeds a threshold, rejects calls while open, and lets a few probe
+// calls through once the open timeout elapses (half-open) to decide whether to close again.
+package breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// Dependencies with a breaker. Breaker states are reported per dependency.
+const (
+	DependencyHubAuth     = "hub-auth"
+	DependencyEDV         = "edv"
+	DependencyDIDResolver = "did-resolver"
+	DependencyWebhook     = "webhook"
+)
+
+// Code is the error code of responses to requests that failed fast because the breaker of a dependency is open.
+const Code = "DEPENDENCY_UNAVAILABLE"
+
+const (
+	defaultFailureRate   = 0.5
+	defaultMinCalls      = 10
+	defaultWindow        = 30 * time.Second
+	defaultOpenTimeout   = 30 * time.Second
+	defaultHalfOpenCalls = 1
+)
+
+var logger = log.New("breaker")
+
+// ErrOpen is returned instead of calling the dependency while the breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned instead of calling the dependency while the breaker is open. It's reported to clients with
+// status 503 and the DEPENDENCY_UNAVAILABLE code.
+type OpenError struct {
+	Dependency string
+	// RetryAfter is how long the breaker stays open before it lets probe calls through.
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("dependency %s is unavailable: %s", e.Dependency, ErrOpen)
+}
+
+// Is matches ErrOpen.
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen //nolint:errorlint,goerr113
+}
+
+// StatusCode returns 503.
+func (e *OpenError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// WriteResponse writes the 503 response of a request that failed fast, for handlers outside of the REST API, e.g.
+// auth middleware.
+func WriteResponse(w http.ResponseWriter, err *OpenError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	if encodeErr := json.NewEncoder(w).Encode(errorResponse{Message: err.Error(), Code: Code}); encodeErr != nil {
+		logger.Errorf("write dependency unavailable response: %s", encodeErr)
+	}
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// State is a state of a breaker. States are reported as a gauge with the values of the constants.
+type State int
+
+const (
+	// StateClosed lets all calls through and counts failures.
+	StateClosed State = iota
+	// StateHalfOpen lets a limited number of probe calls through.
+	StateHalfOpen
+	// StateOpen rejects all calls.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Outcome is the outcome of a call through a breaker.
+type Outcome int
+
+const (
+	// Success is a call the dependency handled, including calls it rejected as invalid.
+	Success Outcome = iota
+	// Failure is a call that failed or timed out because of the dependency.
+	Failure
+	// Ignored is a call that neither succeeded nor failed because of the dependency, e.g. it was canceled by the
+	// caller. It's not counted.
+	Ignored
+)
+
+// Config configures a breaker. Zero values are replaced with defaults.
+type Config struct {
+	// FailureRate is the share of failed calls in a window at or above which the breaker opens. Defaults to 0.5.
+	FailureRate float64
+	// MinCalls is the number of calls in a window before the failure rate is evaluated. Defaults to 10.
+	MinCalls int
+	// Window is the period over which calls are counted while closed. Defaults to 30s.
+	Window time.Duration
+	// OpenTimeout is how long the breaker stays open before it lets probe calls through. Defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of successful probe calls that close the breaker. Defaults to 1.
+	HalfOpenCalls int
+}
+
+type metricsProvider interface {
+	DependencyBreakerState(dependency string, state int)
+}
+
+// Option configures a Breaker.
+type Option func(b *Breaker)
+
+// WithMetrics sets the provider of breaker state gauges.
+func WithMetrics(m metricsProvider) Option {
+	return func(b *Breaker) {
+		b.metrics = m
+	}
+}
+
+// WithClock sets the clock of the breaker. Defaults to the real clock.
+func WithClock(clk clock.Clock) Option {
+	return func(b *Breaker) {
+		b.clock = clk
+	}
+}
+
+// Breaker is a circuit breaker of an outbound dependency. It's safe for concurrent use.
+type Breaker struct {
+	dependency string
+	config     Config
+	clock      clock.Clock
+	metrics    metricsProvider
+
+	mutex       sync.Mutex
+	state       State
+	windowStart time.Time
+	calls       int
+	failures    int
+	openedAt    time.Time
+	probes      int // probe calls in flight while half-open
+	successes   int // successful probe calls while half-open
+}
+
+// New returns a closed breaker of the dependency.
+func New(dependency string, config Config, opts ...Option) *Breaker {
+	if config.FailureRate <= 0 || config.FailureRate > 1 {
+		config.FailureRate = defaultFailureRate
+	}
+
+	if config.MinCalls <= 0 {
+		config.MinCalls = defaultMinCalls
+	}
+
+	if config.Window <= 0 {
+		config.Window = defaultWindow
+	}
+
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = defaultOpenTimeout
+	}
+
+	if config.HalfOpenCalls <= 0 {
+		config.HalfOpenCalls = defaultHalfOpenCalls
+	}
+
+	b := &Breaker{
+		dependency: dependency,
+		config:     config,
+		clock:      clock.Real(),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.windowStart = b.clock.Now()
+
+	if b.metrics != nil {
+		b.metrics.DependencyBreakerState(dependency, int(StateClosed))
+	}
+
+	return b
+}
+
+// Dependency returns the name of the dependency of the breaker.
+func (b *Breaker) Dependency() string {
+	return b.dependency
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.halfOpenIfDue()
+
+	return b.state
+}
+
+// Allow returns an *OpenError if the call must fail fast. Otherwise the returned function must be called with the
+// outcome of the call once it completes.
+func (b *Breaker) Allow() (func(Outcome), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.halfOpenIfDue()
+
+	switch b.state {
+	case StateOpen:
+		return nil, b.openError()
+	case StateHalfOpen:
+		if b.probes+b.successes >= b.config.HalfOpenCalls {
+			return nil, b.openError()
+		}
+
+		b.probes++
+
+		return b.doneFunc(true), nil
+	default:
+		return b.doneFunc(false), nil
+	}
+}
+
+// Do calls op unless the breaker is open. Any error of op is a failure.
+func (b *Breaker) Do(op func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+
+	err = op()
+
+	if err != nil {
+		done(Failure)
+	} else {
+		done(Success)
+	}
+
+	return err
+}
+
+func (b *Breaker) doneFunc(probe bool) func(Outcome) {
+	var once sync.Once
+
+	return func(outcome Outcome) {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			if probe {
+				b.probeDone(outcome)
+			} else {
+				b.callDone(outcome)
+			}
+		})
+	}
+}
+
+func (b *Breaker) callDone(outcome Outcome) {
+	// a call allowed while closed may complete after the breaker opened, it's no longer counted then
+	if b.state != StateClosed || outcome == Ignored {
+		return
+	}
+
+	now := b.clock.Now()
+
+	if now.Sub(b.windowStart) >= b.config.Window {
+		b.windowStart = now
+		b.calls, b.failures = 0, 0
+	}
+
+	b.calls++
+
+	if outcome == Failure {
+		b.failures++
+	}
+
+	if b.calls >= b.config.MinCalls && float64(b.failures) >= b.config.FailureRate*float64(b.calls) {
+		b.transition(StateOpen, fmt.Sprintf("%d of %d calls failed", b.failures, b.calls))
+	}
+}
+
+func (b *Breaker) probeDone(outcome Outcome) {
+	if b.state != StateHalfOpen {
+		return
+	}
+
+	b.probes--
+
+	switch outcome {
+	case Failure:
+		b.transition(StateOpen, "probe call failed")
+	case Success:
+		b.successes++
+
+		if b.successes >= b.config.HalfOpenCalls {
+			b.transition(StateClosed, fmt.Sprintf("%d probe calls succeeded", b.successes))
+		}
+	case Ignored:
+	}
+}
+
+// halfOpenIfDue lets probe calls through once the open timeout elapsed. The mutex must be held.
+func (b *Breaker) halfOpenIfDue() {
+	if b.state == StateOpen && b.clock.Now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.transition(StateHalfOpen, "open timeout elapsed")
+	}
+}
+
+// transition changes the state, resets the counters and reports the change. The mutex must be held.
+func (b *Breaker) transition(state State, reason string) {
+	from := b.state
+	now := b.clock.Now()
+
+	b.state = state
+	b.windowStart = now
+	b.calls, b.failures = 0, 0
+	b.probes, b.successes = 0, 0
+
+	if state == StateOpen {
+		b.openedAt = now
+	}
+
+	if state == StateClosed {
+		logger.Infof("circuit breaker of %s: %s -> %s (%s)", b.dependency, from, state, reason)
+	} else {
+		logger.Warnf("circuit breaker of %s: %s -> %s (%s)", b.dependency, from, state, reason)
+	}
+
+	if b.metrics != nil {
+		b.metrics.DependencyBreakerState(b.dependency, int(state))
+	}
+}
+
+// openError returns the error of a rejected call. The mutex must be held.
+func (b *Breaker) openError() *OpenError {
+	retryAfter := b.config.OpenTimeout - b.clock.Now().Sub(b.openedAt)
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+
+	return &OpenError{Dependency: b.dependency, RetryAfter: retryAfter}
+}
@@ -0,0 +1,367 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package breaker_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+func TestBreaker(t *testing.T) {
+	config := breaker.Config{FailureRate: 0.5, MinCalls: 4, Window: time.Minute, OpenTimeout: 10 * time.Second}
+
+	t.Run("Opens at failure rate and closes after probe", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		metrics := &stateMetrics{}
+		b := breaker.New(breaker.DependencyHubAuth, config, breaker.WithClock(clk), breaker.WithMetrics(metrics))
+
+		require.Equal(t, breaker.StateClosed, b.State())
+
+		// below the minimum number of calls the rate isn't evaluated
+		for i := 0; i < 3; i++ {
+			require.Error(t, b.Do(failing))
+		}
+
+		require.Equal(t, breaker.StateClosed, b.State())
+
+		require.NoError(t, b.Do(succeeding))
+		require.Equal(t, breaker.StateOpen, b.State())
+
+		err := b.Do(succeeding)
+		require.ErrorIs(t, err, breaker.ErrOpen)
+		require.EqualError(t, err, "dependency hub-auth is unavailable: circuit breaker is open")
+
+		var openErr *breaker.OpenError
+
+		require.True(t, errors.As(err, &openErr))
+		require.Equal(t, 10*time.Second, openErr.RetryAfter)
+		require.Equal(t, http.StatusServiceUnavailable, openErr.StatusCode())
+
+		clk.Advance(10 * time.Second)
+		require.Equal(t, breaker.StateHalfOpen, b.State())
+
+		// one probe call at a time
+		done, err := b.Allow()
+		require.NoError(t, err)
+
+		_, err = b.Allow()
+		require.ErrorIs(t, err, breaker.ErrOpen)
+
+		done(breaker.Success)
+		require.Equal(t, breaker.StateClosed, b.State())
+
+		require.Equal(t, []int{
+			int(breaker.StateClosed), int(breaker.StateOpen), int(breaker.StateHalfOpen), int(breaker.StateClosed),
+		}, metrics.get(breaker.DependencyHubAuth))
+	})
+
+	t.Run("Failed probe opens again", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		b := breaker.New(breaker.DependencyEDV, config, breaker.WithClock(clk))
+
+		for i := 0; i < 4; i++ {
+			require.Error(t, b.Do(failing))
+		}
+
+		clk.Advance(10 * time.Second)
+		require.Error(t, b.Do(failing))
+		require.Equal(t, breaker.StateOpen, b.State())
+
+		clk.Advance(5 * time.Second)
+
+		var openErr *breaker.OpenError
+
+		require.True(t, errors.As(b.Do(succeeding), &openErr))
+		require.Equal(t, 5*time.Second, openErr.RetryAfter)
+	})
+
+	t.Run("Ignored probe frees the slot", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		b := breaker.New(breaker.DependencyEDV, config, breaker.WithClock(clk))
+
+		for i := 0; i < 4; i++ {
+			require.Error(t, b.Do(failing))
+		}
+
+		clk.Advance(10 * time.Second)
+
+		done, err := b.Allow()
+		require.NoError(t, err)
+
+		done(breaker.Ignored)
+		require.Equal(t, breaker.StateHalfOpen, b.State())
+
+		require.NoError(t, b.Do(succeeding))
+		require.Equal(t, breaker.StateClosed, b.State())
+	})
+
+	t.Run("Counts are reset every window", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		b := breaker.New(breaker.DependencyWebhook, config, breaker.WithClock(clk))
+
+		for i := 0; i < 3; i++ {
+			require.Error(t, b.Do(failing))
+		}
+
+		clk.Advance(time.Minute)
+
+		require.Error(t, b.Do(failing))
+		require.Equal(t, breaker.StateClosed, b.State())
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		b := breaker.New(breaker.DependencyDIDResolver, breaker.Config{})
+
+		for i := 0; i < 9; i++ {
+			require.Error(t, b.Do(failing))
+		}
+
+		require.Equal(t, breaker.StateClosed, b.State())
+		require.Error(t, b.Do(failing))
+		require.Equal(t, breaker.StateOpen, b.State())
+		require.Equal(t, breaker.DependencyDIDResolver, b.Dependency())
+	})
+}
+
+func TestState_String(t *testing.T) {
+	require.Equal(t, "closed", breaker.StateClosed.String())
+	require.Equal(t, "half-open", breaker.StateHalfOpen.String())
+	require.Equal(t, "open", breaker.StateOpen.String())
+	require.Equal(t, "State(7)", breaker.State(7).String())
+}
+
+func TestTransport(t *testing.T) {
+	var healthy atomic.Value
+
+	healthy.Store(true)
+
+	var calls int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case healthy.Load().(bool):
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	clk := testutil.NewFakeClock(time.Now())
+	b := breaker.New(breaker.DependencyHubAuth, breaker.Config{MinCalls: 2, OpenTimeout: time.Minute},
+		breaker.WithClock(clk))
+	client := breaker.Client(b, &http.Transport{}, time.Second)
+
+	get := func(path string) (int, error) {
+		resp, err := client.Get(srv.URL + path) //nolint:noctx
+		if err != nil {
+			return 0, err
+		}
+
+		require.NoError(t, resp.Body.Close())
+
+		return resp.StatusCode, nil
+	}
+
+	// 4xx responses are handled by the dependency
+	for i := 0; i < 3; i++ {
+		status, err := get("/missing")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, status)
+	}
+
+	require.Equal(t, breaker.StateClosed, b.State())
+
+	healthy.Store(false)
+
+	// 3 of 6 calls failed
+	for i := 0; i < 3; i++ {
+		status, err := get("/")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadGateway, status)
+	}
+
+	require.Equal(t, breaker.StateOpen, b.State())
+
+	// an open breaker fails fast without calling the server
+	served := atomic.LoadInt32(&calls)
+	start := time.Now()
+
+	_, err := get("/")
+	require.ErrorIs(t, err, breaker.ErrOpen)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	require.Equal(t, served, atomic.LoadInt32(&calls))
+
+	healthy.Store(true)
+	clk.Advance(time.Minute)
+
+	status, err := get("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, breaker.StateClosed, b.State())
+}
+
+func TestTransport_Timeout(t *testing.T) {
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	b := breaker.New(breaker.DependencyDIDResolver, breaker.Config{MinCalls: 2})
+	client := breaker.Client(b, nil, 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Get(srv.URL) //nolint:noctx,bodyclose
+		require.Error(t, err)
+		require.NotErrorIs(t, err, breaker.ErrOpen)
+	}
+
+	require.Equal(t, breaker.StateOpen, b.State())
+
+	start := time.Now()
+
+	_, err := client.Get(srv.URL) //nolint:noctx,bodyclose
+	require.ErrorIs(t, err, breaker.ErrOpen)
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestWrapProvider(t *testing.T) {
+	t.Run("Not found is not a failure", func(t *testing.T) {
+		b := breaker.New(breaker.DependencyEDV, breaker.Config{MinCalls: 1})
+
+		s, err := breaker.WrapProvider(mem.NewProvider(), b, time.Second).OpenStore("test")
+		require.NoError(t, err)
+
+		require.NoError(t, s.Put("key", []byte("value"), storage.Tag{Name: "tag"}))
+
+		value, err := s.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+
+		tags, err := s.GetTags("key")
+		require.NoError(t, err)
+		require.Len(t, tags, 1)
+
+		values, err := s.GetBulk("key")
+		require.NoError(t, err)
+		require.Len(t, values, 1)
+
+		it, err := s.Query("tag")
+		require.NoError(t, err)
+		require.NoError(t, it.Close())
+
+		require.NoError(t, s.Batch([]storage.Operation{{Key: "other", Value: []byte("value")}}))
+		require.NoError(t, s.Delete("other"))
+		require.NoError(t, s.Flush())
+
+		_, err = s.Get("missing")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Equal(t, breaker.StateClosed, b.State())
+	})
+
+	t.Run("Slow store times out and opens the breaker", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		b := breaker.New(breaker.DependencyEDV, breaker.Config{MinCalls: 2})
+		p := &slowProvider{Provider: mem.NewProvider(), release: release}
+
+		s, err := breaker.WrapProvider(p, b, 20*time.Millisecond).OpenStore("test")
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err = s.Get("key")
+			require.ErrorIs(t, err, breaker.ErrTimeout)
+		}
+
+		require.Equal(t, breaker.StateOpen, b.State())
+
+		start := time.Now()
+
+		err = s.Put("key", []byte("value"))
+		require.ErrorIs(t, err, breaker.ErrOpen)
+		require.Less(t, time.Since(start), 20*time.Millisecond)
+	})
+}
+
+func failing() error {
+	return errors.New("dependency failed")
+}
+
+func succeeding() error {
+	return nil
+}
+
+type stateMetrics struct {
+	mutex  sync.Mutex
+	states map[string][]int
+}
+
+func (m *stateMetrics) DependencyBreakerState(dependency string, state int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.states == nil {
+		m.states = make(map[string][]int)
+	}
+
+	m.states[dependency] = append(m.states[dependency], state)
+}
+
+func (m *stateMetrics) get(dependency string) []int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.states[dependency]
+}
+
+type slowProvider struct {
+	storage.Provider
+	release chan struct{}
+}
+
+func (p *slowProvider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &slowStore{Store: s, release: p.release}, nil
+}
+
+type slowStore struct {
+	storage.Store
+	release chan struct{}
+}
+
+func (s *slowStore) Get(key string) ([]byte, error) {
+	<-s.release
+
+	return s.Store.Get(key)
+}
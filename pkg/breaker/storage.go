@@ -0,0 +1,180 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// ErrTimeout is returned when a store operation doesn't complete within the timeout of the dependency.
+var ErrTimeout = errors.New("dependency timed out")
+
+// WrapProvider wraps the stores of a storage provider of a remote dependency (e.g. the EDV REST provider, whose HTTP
+// client can't be replaced) with the breaker. Store operations that take longer than the timeout return ErrTimeout;
+// the operation itself can't be canceled and completes in the background. Errors other than ErrDataNotFound are
+// failures. A zero timeout disables the timeout.
+func WrapProvider(p storage.Provider, b *Breaker, timeout time.Duration) storage.Provider {
+	return &provider{Provider: p, breaker: b, timeout: timeout}
+}
+
+type provider struct {
+	storage.Provider
+	breaker *Breaker
+	timeout time.Duration
+}
+
+func (p *provider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{Store: s, provider: p}, nil
+}
+
+type store struct {
+	storage.Store
+	provider *provider
+}
+
+func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
+	_, err := s.provider.call(func() (interface{}, error) {
+		return nil, s.Store.Put(key, value, tags...)
+	})
+
+	return err
+}
+
+func (s *store) Get(key string) ([]byte, error) {
+	value, err := s.provider.call(func() (interface{}, error) {
+		return s.Store.Get(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := value.([]byte) // nil if the store returned nil
+
+	return v, nil
+}
+
+func (s *store) GetTags(key string) ([]storage.Tag, error) {
+	tags, err := s.provider.call(func() (interface{}, error) {
+		return s.Store.GetTags(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := tags.([]storage.Tag)
+
+	return v, nil
+}
+
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	values, err := s.provider.call(func() (interface{}, error) {
+		return s.Store.GetBulk(keys...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := values.([][]byte)
+
+	return v, nil
+}
+
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	iterator, err := s.provider.call(func() (interface{}, error) {
+		return s.Store.Query(expression, options...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v, _ := iterator.(storage.Iterator)
+
+	return v, nil
+}
+
+func (s *store) Delete(key string) error {
+	_, err := s.provider.call(func() (interface{}, error) {
+		return nil, s.Store.Delete(key)
+	})
+
+	return err
+}
+
+func (s *store) Batch(operations []storage.Operation) error {
+	_, err := s.provider.call(func() (interface{}, error) {
+		return nil, s.Store.Batch(operations)
+	})
+
+	return err
+}
+
+func (s *store) Flush() error {
+	_, err := s.provider.call(func() (interface{}, error) {
+		return nil, s.Store.Flush()
+	})
+
+	return err
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// call runs the store operation through the breaker, waiting for it up to the timeout.
+func (p *provider) call(op func() (interface{}, error)) (interface{}, error) {
+	done, err := p.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	var r result
+
+	if p.timeout <= 0 {
+		r.value, r.err = op()
+	} else {
+		r = p.callWithTimeout(op)
+	}
+
+	if r.err == nil || errors.Is(r.err, storage.ErrDataNotFound) {
+		done(Success)
+	} else {
+		done(Failure)
+	}
+
+	return r.value, r.err
+}
+
+func (p *provider) callWithTimeout(op func() (interface{}, error)) result {
+	// buffered, so that an operation that completes after the timeout doesn't block
+	results := make(chan result, 1)
+
+	go func() {
+		value, err := op()
+
+		results <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r
+	case <-timer.C:
+		return result{err: fmt.Errorf("%w: %s after %s", ErrTimeout, p.breaker.Dependency(), p.timeout)}
+	}
+}
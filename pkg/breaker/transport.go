@@ -0,0 +1,63 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Transport wraps the base transport with the breaker. Transport errors (including timeouts of the client) and
+// 5xx responses are failures; other responses are successes, the dependency handled the request.
+func Transport(b *Breaker, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{breaker: b, base: base}
+}
+
+// Client returns an HTTP client of the dependency with the timeout and a transport wrapped with the breaker. Each
+// client has its own transport, so connections to a slow dependency don't hold up other dependencies. A nil base is
+// a copy of the default transport.
+func Client(b *Breaker, base *http.Transport, timeout time.Duration) *http.Client {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: Transport(b, base),
+	}
+}
+
+type transport struct {
+	breaker *Breaker
+	base    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		done(Ignored)
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		done(Failure)
+	default:
+		done(Success)
+	}
+
+	return resp, err
+}
@@ -0,0 +1,91 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package canonicalization
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
+	"github.com/piprate/json-gold/ld"
+)
+
+// Canonicalization profiles.
+const (
+	// None leaves the document as supplied.
+	None = "none"
+	// JCS is the JSON Canonicalization Scheme (RFC 8785).
+	JCS = "jcs"
+	// URDNA2015 is the RDF Dataset Normalization algorithm used by Linked Data proofs.
+	URDNA2015 = "urdna2015"
+)
+
+var (
+	// ErrProfileDisabled is returned when the profile is unknown or not enabled.
+	ErrProfileDisabled = errors.New("canonicalization profile is not enabled")
+	// ErrInvalidDocument is returned when the document can't be canonicalized with the profile.
+	ErrInvalidDocument = errors.New("invalid document")
+)
+
+// Canonicalizer transforms JSON documents with enabled profiles.
+type Canonicalizer struct {
+	profiles       map[string]struct{}
+	documentLoader ld.DocumentLoader
+}
+
+// New returns a new Canonicalizer with the profiles enabled. The document loader resolves JSON-LD contexts for
+// URDNA2015.
+func New(profiles []string, documentLoader ld.DocumentLoader) (*Canonicalizer, error) {
+	enabled := make(map[string]struct{}, len(profiles))
+
+	for _, p := range profiles {
+		switch p {
+		case None, JCS, URDNA2015:
+			enabled[p] = struct{}{}
+		default:
+			return nil, fmt.Errorf("unknown canonicalization profile %q", p)
+		}
+	}
+
+	return &Canonicalizer{profiles: enabled, documentLoader: documentLoader}, nil
+}
+
+// Canonicalize returns the document transformed with the profile.
+func (c *Canonicalizer) Canonicalize(profile string, doc []byte) ([]byte, error) {
+	if _, ok := c.profiles[profile]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrProfileDisabled, profile)
+	}
+
+	if !json.Valid(doc) {
+		return nil, fmt.Errorf("%w: not a JSON value", ErrInvalidDocument)
+	}
+
+	switch profile {
+	case JCS:
+		return canonicalizeJCS(doc)
+	case URDNA2015:
+		return c.canonicalizeURDNA2015(doc)
+	default:
+		return doc, nil
+	}
+}
+
+func (c *Canonicalizer) canonicalizeURDNA2015(doc []byte) ([]byte, error) {
+	var m map[string]interface{}
+
+	if err := json.Unmarshal(doc, &m); err != nil {
+		return nil, fmt.Errorf("%w: not a JSON-LD document: %s", ErrInvalidDocument, err.Error())
+	}
+
+	canonical, err := jsonld.Default().GetCanonicalDocument(m, jsonld.WithDocumentLoader(c.documentLoader))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err.Error())
+	}
+
+	return canonical, nil
+}
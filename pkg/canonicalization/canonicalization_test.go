@@ -0,0 +1,254 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package canonicalization_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
+	ldstore "github.com/hyperledger/aries-framework-go/pkg/store/ld"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/canonicalization"
+)
+
+func TestNew(t *testing.T) {
+	_, err := canonicalization.New([]string{canonicalization.JCS, "c14n"}, nil)
+	require.EqualError(t, err, `unknown canonicalization profile "c14n"`)
+}
+
+func TestCanonicalizer_Canonicalize(t *testing.T) {
+	c := newCanonicalizer(t, canonicalization.None, canonicalization.JCS, canonicalization.URDNA2015)
+
+	t.Run("None", func(t *testing.T) {
+		doc := []byte(`{ "b": 1, "a": 2 }`)
+
+		result, err := c.Canonicalize(canonicalization.None, doc)
+		require.NoError(t, err)
+		require.Equal(t, doc, result)
+	})
+
+	t.Run("Disabled profile", func(t *testing.T) {
+		c := newCanonicalizer(t, canonicalization.JCS)
+
+		_, err := c.Canonicalize(canonicalization.URDNA2015, []byte(`{}`))
+		require.ErrorIs(t, err, canonicalization.ErrProfileDisabled)
+
+		_, err = c.Canonicalize("", []byte(`{}`))
+		require.ErrorIs(t, err, canonicalization.ErrProfileDisabled)
+	})
+
+	t.Run("Not a JSON document", func(t *testing.T) {
+		_, err := c.Canonicalize(canonicalization.None, []byte(`{"a":`))
+		require.ErrorIs(t, err, canonicalization.ErrInvalidDocument)
+	})
+}
+
+// Test vectors are from RFC 8785.
+func TestCanonicalizer_CanonicalizeJCS(t *testing.T) {
+	c := newCanonicalizer(t, canonicalization.JCS)
+
+	tests := []struct {
+		name     string
+		doc      string
+		expected string
+	}{
+		{
+			name: "Sample (section 3.2.2)",
+			doc: `{
+				"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+				"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+				"literals": [null, true, false]
+			}`,
+			expected: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],` +
+				`"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			name: "Sorting (section 3.2.3)",
+			doc: `{
+				"\u20ac": "Euro Sign",
+				"\r": "Carriage Return",
+				"\ufb33": "Hebrew Letter Dalet With Dagesh",
+				"1": "One",
+				"\ud83d\ude00": "Emoji: Grinning Face",
+				"\u0080": "Control",
+				"\u00f6": "Latin Small Letter O With Diaeresis"
+			}`,
+			expected: "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\"," +
+				"\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\"," +
+				"\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}",
+		},
+		{
+			name:     "Nested values",
+			doc:      `[{"b": [], "a": {}}, "\u2028<>&", -0]`,
+			expected: "[{\"a\":{},\"b\":[]},\"\u2028<>&\",0]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := c.Canonicalize(canonicalization.JCS, []byte(tt.doc))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(result))
+		})
+	}
+
+	t.Run("Numbers (appendix B)", func(t *testing.T) {
+		numbers := []struct {
+			bits     uint64
+			expected string
+		}{
+			{0x0000000000000000, "0"},
+			{0x8000000000000000, "0"},
+			{0x0000000000000001, "5e-324"},
+			{0x8000000000000001, "-5e-324"},
+			{0x7fefffffffffffff, "1.7976931348623157e+308"},
+			{0xffefffffffffffff, "-1.7976931348623157e+308"},
+			{0x4340000000000000, "9007199254740992"},
+			{0xc340000000000000, "-9007199254740992"},
+			{0x4430000000000000, "295147905179352830000"},
+			{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+			{0x44b52d02c7e14af6, "1e+23"},
+			{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+			{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+			{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+			{0x444b1ae4d6e2ef50, "1e+21"},
+			{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+			{0x3eb0c6f7a0b5ed8d, "0.000001"},
+			{0x41b3de4355555553, "333333333.3333332"},
+			{0x41b3de4355555554, "333333333.33333325"},
+			{0x41b3de4355555555, "333333333.3333333"},
+			{0x41b3de4355555556, "333333333.3333334"},
+			{0x41b3de4355555557, "333333333.33333343"},
+			{0xbecbf647612f3696, "-0.0000033333333333333333"},
+			{0x43143ff3c1cb0959, "1424953923781206.2"},
+		}
+
+		for _, n := range numbers {
+			doc := strconv.FormatFloat(math.Float64frombits(n.bits), 'g', -1, 64)
+
+			result, err := c.Canonicalize(canonicalization.JCS, []byte(doc))
+			require.NoError(t, err)
+			require.Equal(t, n.expected, string(result), "%016x", n.bits)
+		}
+	})
+
+	t.Run("Invalid document", func(t *testing.T) {
+		for _, doc := range []string{`{"a": 1, "a": 2}`, `1e400`} {
+			_, err := c.Canonicalize(canonicalization.JCS, []byte(doc))
+			require.ErrorIs(t, err, canonicalization.ErrInvalidDocument, doc)
+		}
+	})
+}
+
+func TestCanonicalizer_CanonicalizeURDNA2015(t *testing.T) {
+	c := newCanonicalizer(t, canonicalization.URDNA2015)
+
+	t.Run("Linked Data proof options", func(t *testing.T) {
+		doc := `{
+		  "@context": "https://w3id.org/security/v2",
+		  "created": "2020-04-08T04:00:22Z",
+		  "proofPurpose": "assertionMethod",
+		  "type": "Ed25519Signature2018",
+		  "verificationMethod": "did:elem:EiBJJPdo-ONF0jxqt8mZYEj9Z7FbdC87m2xvN0_HAbcoEg#xqc3gS1gz1vch7R3RvNebWMjLvBOY-n_14feCYRPsUo"
+		}`
+
+		//nolint:lll
+		expected := `_:c14n0 <http://purl.org/dc/terms/created> "2020-04-08T04:00:22Z"^^<http://www.w3.org/2001/XMLSchema#dateTime> .
+_:c14n0 <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <https://w3id.org/security#Ed25519Signature2018> .
+_:c14n0 <https://w3id.org/security#proofPurpose> <https://w3id.org/security#assertionMethod> .
+_:c14n0 <https://w3id.org/security#verificationMethod> <did:elem:EiBJJPdo-ONF0jxqt8mZYEj9Z7FbdC87m2xvN0_HAbcoEg#xqc3gS1gz1vch7R3RvNebWMjLvBOY-n_14feCYRPsUo> .
+`
+
+		result, err := c.Canonicalize(canonicalization.URDNA2015, []byte(doc))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(result))
+	})
+
+	t.Run("Blank nodes are relabeled independent of input order", func(t *testing.T) {
+		doc1 := `{"@context": {"@vocab": "http://schema.org/"}, "knows": [{"name": "Bob"}, {"name": "Alice"}]}`
+		doc2 := `{"@context": {"@vocab": "http://schema.org/"}, "knows": [{"name": "Alice"}, {"name": "Bob"}]}`
+
+		result1, err := c.Canonicalize(canonicalization.URDNA2015, []byte(doc1))
+		require.NoError(t, err)
+
+		result2, err := c.Canonicalize(canonicalization.URDNA2015, []byte(doc2))
+		require.NoError(t, err)
+
+		require.Equal(t, string(result1), string(result2))
+	})
+
+	t.Run("Not a JSON-LD document", func(t *testing.T) {
+		_, err := c.Canonicalize(canonicalization.URDNA2015, []byte(`["a"]`))
+		require.ErrorIs(t, err, canonicalization.ErrInvalidDocument)
+
+		_, err = c.Canonicalize(canonicalization.URDNA2015, []byte(`{"@context": 1}`))
+		require.ErrorIs(t, err, canonicalization.ErrInvalidDocument)
+	})
+}
+
+func TestMarshalJCS(t *testing.T) {
+	t.Run("Equal values are serialized to the same bytes", func(t *testing.T) {
+		v := struct {
+			Z     string                 `json:"z"`
+			A     []int                  `json:"a"`
+			Extra map[string]interface{} `json:"extra"`
+		}{
+			Z:     "<tag> & \u2028",
+			A:     []int{3, 1, 2},
+			Extra: map[string]interface{}{"y": 1.50, "b": true},
+		}
+
+		b, err := canonicalization.MarshalJCS(v)
+		require.NoError(t, err)
+		require.Equal(t, "{\"a\":[3,1,2],\"extra\":{\"b\":true,\"y\":1.5},\"z\":\"<tag> & \u2028\"}", string(b))
+	})
+
+	t.Run("Fail to marshal", func(t *testing.T) {
+		_, err := canonicalization.MarshalJCS(make(chan int))
+		require.EqualError(t, err, "marshal: json: unsupported type: chan int")
+	})
+}
+
+func newCanonicalizer(t *testing.T, profiles ...string) *canonicalization.Canonicalizer {
+	t.Helper()
+
+	provider := mem.NewProvider()
+
+	contextStore, err := ldstore.NewContextStore(provider)
+	require.NoError(t, err)
+
+	remoteProviderStore, err := ldstore.NewRemoteProviderStore(provider)
+	require.NoError(t, err)
+
+	loader, err := ld.NewDocumentLoader(&ldStoreProvider{
+		ContextStore:        contextStore,
+		RemoteProviderStore: remoteProviderStore,
+	})
+	require.NoError(t, err)
+
+	c, err := canonicalization.New(profiles, loader)
+	require.NoError(t, err)
+
+	return c
+}
+
+type ldStoreProvider struct {
+	ContextStore        ldstore.ContextStore
+	RemoteProviderStore ldstore.RemoteProviderStore
+}
+
+func (p *ldStoreProvider) JSONLDContextStore() ldstore.ContextStore {
+	return p.ContextStore
+}
+
+func (p *ldStoreProvider) JSONLDRemoteProviderStore() ldstore.RemoteProviderStore {
+	return p.RemoteProviderStore
+}
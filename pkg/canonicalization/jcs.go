@@ -0,0 +1,230 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package canonicalization
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// MarshalJCS returns the JSON encoding of v in canonical form (RFC 8785), so that equal values are always serialized
+// to the same bytes regardless of struct field or map key order.
+func MarshalJCS(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+
+	return canonicalizeJCS(b)
+}
+
+// canonicalizeJCS serializes the JSON document as defined by RFC 8785: no whitespace, object members sorted by
+// UTF-16 code units of their names, numbers serialized like ECMAScript and strings escaped minimally.
+func canonicalizeJCS(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+
+	if err := writeValue(&buf, dec); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err.Error())
+	}
+
+	if _, err := dec.Token(); err != io.EOF { //nolint:errorlint // io.EOF is not wrapped by the decoder
+		return nil, fmt.Errorf("%w: unexpected data after the top-level value", ErrInvalidDocument)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeValue(buf *bytes.Buffer, dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '[' {
+			return writeArray(buf, dec)
+		}
+
+		return writeObject(buf, dec)
+	case string:
+		writeString(buf, v)
+	case json.Number:
+		return writeNumber(buf, v)
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	default:
+		buf.WriteString("null")
+	}
+
+	return nil
+}
+
+func writeArray(buf *bytes.Buffer, dec *json.Decoder) error {
+	buf.WriteByte('[')
+
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		if err := writeValue(buf, dec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	buf.WriteByte(']')
+
+	return nil
+}
+
+func writeObject(buf *bytes.Buffer, dec *json.Decoder) error {
+	var names []string
+
+	members := make(map[string][]byte)
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		name, _ := tok.(string) //nolint:errcheck // object keys are always strings
+
+		if _, ok := members[name]; ok {
+			return fmt.Errorf("duplicate member %q", name)
+		}
+
+		var member bytes.Buffer
+
+		if err = writeValue(&member, dec); err != nil {
+			return err
+		}
+
+		names = append(names, name)
+		members[name] = member.Bytes()
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return lessUTF16(names[i], names[j])
+	})
+
+	buf.WriteByte('{')
+
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		writeString(buf, name)
+		buf.WriteByte(':')
+		buf.Write(members[name])
+	}
+
+	buf.WriteByte('}')
+
+	return nil
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+
+	return len(ua) < len(ub)
+}
+
+// writeNumber serializes the number as ECMAScript Number.prototype.toString does for IEEE 754 doubles.
+func writeNumber(buf *bytes.Buffer, n json.Number) error {
+	f, err := strconv.ParseFloat(n.String(), 64)
+	if err != nil {
+		return fmt.Errorf("number %s: %w", n, err)
+	}
+
+	if f == 0 { // covers -0
+		buf.WriteByte('0')
+
+		return nil
+	}
+
+	format := byte('f')
+
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+
+	s := strconv.FormatFloat(f, format, -1, 64)
+
+	if format == 'e' {
+		// ECMAScript doesn't pad the exponent: 1e-07 -> 1e-7
+		if n := len(s); n >= 4 && s[n-4] == 'e' && s[n-3] == '-' && s[n-2] == '0' {
+			s = s[:n-2] + s[n-1:]
+		}
+	}
+
+	buf.WriteString(s)
+
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[r>>4])
+				buf.WriteByte(hex[r&0xf])
+
+				continue
+			}
+
+			buf.WriteRune(r)
+		}
+	}
+
+	buf.WriteByte('"')
+}
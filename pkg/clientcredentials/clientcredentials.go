@@ -0,0 +1,443 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package clientcredentials obtains OAuth 2.0 access tokens with the client credentials grant (RFC 6749, section
+// 4.4), so that the KMS can authenticate its calls to other services with short-lived tokens instead of static
+// secrets. Tokens are cached per audience and refreshed shortly before they expire.
+package clientcredentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/square/go-jose/v3"
+	"github.com/square/go-jose/v3/jwt"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const (
+	// DefaultExpiryLeeway is how long before expiry a cached token is refreshed.
+	DefaultExpiryLeeway = 30 * time.Second
+
+	// DefaultMaxRetries is how many times a failed token request is retried.
+	DefaultMaxRetries = 3
+
+	// DefaultRetryDelay is the delay before the first retry. The delay doubles with every retry.
+	DefaultRetryDelay = 500 * time.Millisecond
+
+	// defaultExpiresIn is the lifetime assumed for tokens issued without expires_in.
+	defaultExpiresIn = time.Minute
+
+	// assertionLifetime is the lifetime of private_key_jwt client assertions.
+	assertionLifetime = time.Minute
+
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" //nolint:gosec // not credentials
+	jtiSize             = 16
+)
+
+var logger = log.New("clientcredentials")
+
+// Error is an error response of the token endpoint (RFC 6749, section 5.2).
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("token endpoint returned %d", e.StatusCode)
+
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+
+	return msg
+}
+
+// temporary reports whether the request may succeed if retried.
+func (e *Error) temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Config configures TokenSource. The client authenticates with the private key (private_key_jwt) if it is set, or
+// with the client secret (client_secret_basic) otherwise.
+type Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret *secrets.Secret
+	PrivateKey   *ecdsa.PrivateKey
+	Scopes       []string
+	HTTPClient   httpClient
+}
+
+// Option configures TokenSource.
+type Option func(o *options)
+
+type options struct {
+	clock        clock.Clock
+	expiryLeeway time.Duration
+	maxRetries   int
+	retryDelay   time.Duration
+}
+
+// WithClock sets the clock used to expire cached tokens.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithExpiryLeeway sets how long before expiry a cached token is refreshed.
+func WithExpiryLeeway(d time.Duration) Option {
+	return func(o *options) {
+		o.expiryLeeway = d
+	}
+}
+
+// WithRetry sets how many times a failed token request is retried and the delay before the first retry.
+func WithRetry(maxRetries int, delay time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		o.retryDelay = delay
+	}
+}
+
+type token struct {
+	mutex       sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+// TokenSource obtains access tokens from the token endpoint and caches them per audience. Concurrent requests for
+// the token of an audience share a single token request.
+type TokenSource struct {
+	config  Config
+	signer  jose.Signer
+	opts    *options
+	mutex   sync.Mutex
+	entries map[string]*token
+}
+
+// New returns a new TokenSource.
+func New(config *Config, opts ...Option) (*TokenSource, error) {
+	if config.TokenURL == "" {
+		return nil, errors.New("token url is required")
+	}
+
+	if config.ClientID == "" {
+		return nil, errors.New("client id is required")
+	}
+
+	o := &options{
+		clock:        clock.Real(),
+		expiryLeeway: DefaultExpiryLeeway,
+		maxRetries:   DefaultMaxRetries,
+		retryDelay:   DefaultRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s := &TokenSource{
+		config:  *config,
+		opts:    o,
+		entries: make(map[string]*token),
+	}
+
+	if config.PrivateKey != nil {
+		signer, err := newSigner(config.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		s.signer = signer
+	} else if config.ClientSecret.IsEmpty() {
+		return nil, errors.New("client secret or private key is required")
+	}
+
+	if s.config.HTTPClient == nil {
+		s.config.HTTPClient = http.DefaultClient
+	}
+
+	return s, nil
+}
+
+// Token returns an access token for the audience. An empty audience requests a token without the audience
+// parameter. If the token can't be refreshed, the cached token is returned as long as it hasn't expired.
+func (s *TokenSource) Token(ctx context.Context, audience string) (string, error) {
+	t := s.entry(audience)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := s.opts.clock.Now()
+
+	if t.accessToken != "" && now.Before(t.expiry.Add(-s.opts.expiryLeeway)) {
+		return t.accessToken, nil
+	}
+
+	accessToken, expiresIn, err := s.fetchWithRetry(ctx, audience)
+	if err != nil {
+		if t.accessToken != "" && now.Before(t.expiry) {
+			logger.Warnf("Failed to refresh access token for audience %q, using the cached token until it expires at "+
+				"%s: %v", audience, t.expiry.Format(time.RFC3339), err)
+
+			return t.accessToken, nil
+		}
+
+		return "", fmt.Errorf("request token: %w", err)
+	}
+
+	t.accessToken = accessToken
+	t.expiry = now.Add(expiresIn)
+
+	return accessToken, nil
+}
+
+// Invalidate drops the cached token of the audience if it is the given one, e.g. when a service rejected it before
+// its expiry. The next Token call obtains a new token.
+func (s *TokenSource) Invalidate(audience, accessToken string) {
+	t := s.entry(audience)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.accessToken == accessToken {
+		t.accessToken = ""
+	}
+}
+
+// Transport returns a RoundTripper that authorizes requests with access tokens for the audience. If the service
+// responds with 401 Unauthorized, the token is invalidated, so that the next request uses a new one.
+func (s *TokenSource) Transport(base http.RoundTripper, audience string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{base: base, tokens: s, audience: audience}
+}
+
+func (s *TokenSource) entry(audience string) *token {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.entries[audience]
+	if !ok {
+		t = &token{}
+		s.entries[audience] = t
+	}
+
+	return t
+}
+
+func (s *TokenSource) fetchWithRetry(ctx context.Context, audience string) (string, time.Duration, error) {
+	delay := s.opts.retryDelay
+
+	for attempt := 0; ; attempt++ {
+		accessToken, expiresIn, err := s.fetch(ctx, audience)
+		if err == nil {
+			return accessToken, expiresIn, nil
+		}
+
+		var tokenErr *Error
+
+		if attempt >= s.opts.maxRetries || errors.As(err, &tokenErr) && !tokenErr.temporary() {
+			return "", 0, err
+		}
+
+		logger.Warnf("Failed to get access token for audience %q, will retry in %s: %v", audience, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return "", 0, fmt.Errorf("%w (last error: %v)", ctx.Err(), err) //nolint:errorlint
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (s *TokenSource) fetch(ctx context.Context, audience string) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+
+	if s.signer != nil {
+		assertion, err := s.clientAssertion()
+		if err != nil {
+			return "", 0, err
+		}
+
+		form.Set("client_id", s.config.ClientID)
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if s.signer == nil {
+		// header values are strings, the secret can't be kept out of the heap once the request is built
+		s.config.ClientSecret.Use(func(secret []byte) {
+			req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(string(secret)))
+		})
+	}
+
+	resp, err := s.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("http do: %w", err)
+	}
+
+	defer resp.Body.Close() // nolint: errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		tokenErr := &Error{}
+		_ = json.Unmarshal(body, tokenErr) //nolint:errcheck // the error body is optional
+		tokenErr.StatusCode = resp.StatusCode
+
+		return "", 0, tokenErr
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err = json.Unmarshal(body, &tokenResp); err != nil {
+		return "", 0, fmt.Errorf("unmarshal token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", 0, errors.New("token response has no access token")
+	}
+
+	if !strings.EqualFold(tokenResp.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", tokenResp.TokenType)
+	}
+
+	expiresIn := defaultExpiresIn
+
+	if tokenResp.ExpiresIn > 0 {
+		expiresIn = time.Duration(tokenResp.ExpiresIn) * time.Second
+	}
+
+	return tokenResp.AccessToken, expiresIn, nil
+}
+
+// clientAssertion returns a JWT that authenticates the client to the token endpoint (RFC 7523, section 2.2).
+func (s *TokenSource) clientAssertion() (string, error) {
+	jti := make([]byte, jtiSize)
+
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("generate jti: %w", err)
+	}
+
+	now := s.opts.clock.Now()
+
+	assertion, err := jwt.Signed(s.signer).Claims(&jwt.Claims{
+		Issuer:   s.config.ClientID,
+		Subject:  s.config.ClientID,
+		Audience: jwt.Audience{s.config.TokenURL},
+		ID:       base64.RawURLEncoding.EncodeToString(jti),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(assertionLifetime)),
+	}).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("sign client assertion: %w", err)
+	}
+
+	return assertion, nil
+}
+
+func newSigner(key *ecdsa.PrivateKey) (jose.Signer, error) {
+	var alg jose.SignatureAlgorithm
+
+	switch key.Curve {
+	case elliptic.P256():
+		alg = jose.ES256
+	case elliptic.P384():
+		alg = jose.ES384
+	case elliptic.P521():
+		alg = jose.ES512
+	default:
+		return nil, fmt.Errorf("unsupported private key curve %s", key.Curve.Params().Name)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, fmt.Errorf("new signer: %w", err)
+	}
+
+	return signer, nil
+}
+
+type transport struct {
+	base     http.RoundTripper
+	tokens   *TokenSource
+	audience string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	accessToken, err := t.tokens.Token(req.Context(), t.audience)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		t.tokens.Invalidate(t.audience, accessToken)
+	}
+
+	return resp, nil
+}
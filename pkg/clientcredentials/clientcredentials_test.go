@@ -0,0 +1,450 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package clientcredentials_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/square/go-jose/v3/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/clientcredentials"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+var start = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTokenSource_Token(t *testing.T) {
+	t.Run("Token is cached until it nears expiry", func(t *testing.T) {
+		srv := newTokenServer(t)
+		tokens, clk := newTokenSource(t, srv.URL)
+
+		token, err := tokens.Token(context.Background(), "https://auth")
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token)
+
+		clk.Advance(time.Hour - clientcredentials.DefaultExpiryLeeway - time.Second)
+
+		token, err = tokens.Token(context.Background(), "https://auth")
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token)
+		require.EqualValues(t, 1, srv.requests())
+
+		clk.Advance(time.Second)
+
+		token, err = tokens.Token(context.Background(), "https://auth")
+		require.NoError(t, err)
+		require.Equal(t, "token-2", token)
+		require.EqualValues(t, 2, srv.requests())
+	})
+
+	t.Run("Tokens are cached per audience", func(t *testing.T) {
+		srv := newTokenServer(t)
+		tokens, _ := newTokenSource(t, srv.URL)
+
+		for i := 0; i < 3; i++ {
+			token, err := tokens.Token(context.Background(), "https://auth")
+			require.NoError(t, err)
+			require.Equal(t, "https://auth", srv.audienceOf(token))
+
+			token, err = tokens.Token(context.Background(), "https://registrar")
+			require.NoError(t, err)
+			require.Equal(t, "https://registrar", srv.audienceOf(token))
+		}
+
+		require.EqualValues(t, 2, srv.requests())
+	})
+
+	t.Run("Client authenticates with client secret", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.check = func(r *http.Request) {
+			id, secret, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "kms", id)
+			require.Equal(t, "secret", secret)
+			require.Equal(t, "client_credentials", r.PostFormValue("grant_type"))
+			require.Equal(t, "secrets registrar", r.PostFormValue("scope"))
+			require.Empty(t, r.PostFormValue("audience"))
+		}
+
+		tokens, err := clientcredentials.New(&clientcredentials.Config{
+			TokenURL:     srv.URL,
+			ClientID:     "kms",
+			ClientSecret: secrets.New([]byte("secret")),
+			Scopes:       []string{"secrets", "registrar"},
+		})
+		require.NoError(t, err)
+
+		_, err = tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+	})
+
+	t.Run("Client authenticates with private key JWT", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		srv := newTokenServer(t)
+		srv.check = func(r *http.Request) {
+			_, _, ok := r.BasicAuth()
+			require.False(t, ok)
+			require.Equal(t, "kms", r.PostFormValue("client_id"))
+			require.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+				r.PostFormValue("client_assertion_type"))
+
+			assertion, e := jwt.ParseSigned(r.PostFormValue("client_assertion"))
+			require.NoError(t, e)
+
+			var claims jwt.Claims
+
+			require.NoError(t, assertion.Claims(&key.PublicKey, &claims))
+			require.Equal(t, "kms", claims.Issuer)
+			require.Equal(t, "kms", claims.Subject)
+			require.NotEmpty(t, claims.ID)
+			require.NoError(t, claims.ValidateWithLeeway(jwt.Expected{Audience: jwt.Audience{srv.URL}, Time: start}, 0))
+		}
+
+		tokens, err := clientcredentials.New(&clientcredentials.Config{
+			TokenURL:   srv.URL,
+			ClientID:   "kms",
+			PrivateKey: key,
+		}, clientcredentials.WithClock(testutil.NewFakeClock(start)))
+		require.NoError(t, err)
+
+		_, err = tokens.Token(context.Background(), "https://auth")
+		require.NoError(t, err)
+	})
+
+	t.Run("Token without expires_in is cached for a minute", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.expiresIn = 0
+
+		tokens, clk := newTokenSource(t, srv.URL, clientcredentials.WithExpiryLeeway(0))
+
+		_, err := tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+
+		_, err = tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+		require.EqualValues(t, 2, srv.requests())
+	})
+
+	t.Run("Concurrent refreshes share a token request", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.delay = 50 * time.Millisecond
+
+		tokens, clk := newTokenSource(t, srv.URL)
+
+		for round := 1; round <= 2; round++ {
+			var wg sync.WaitGroup
+
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					token, err := tokens.Token(context.Background(), "https://auth")
+					require.NoError(t, err)
+					require.Equal(t, fmt.Sprintf("token-%d", round), token)
+				}()
+			}
+
+			wg.Wait()
+
+			require.EqualValues(t, round, srv.requests())
+
+			clk.Advance(time.Hour)
+		}
+	})
+
+	t.Run("Temporary failures are retried", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.fail(2, http.StatusServiceUnavailable)
+
+		tokens, _ := newTokenSource(t, srv.URL)
+
+		token, err := tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+		require.Equal(t, "token-3", token)
+	})
+
+	t.Run("Retries give up after the max retries", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.fail(10, http.StatusTooManyRequests)
+
+		tokens, _ := newTokenSource(t, srv.URL)
+
+		_, err := tokens.Token(context.Background(), "")
+		require.EqualError(t, err, "request token: token endpoint returned 429: slow_down: try later")
+		require.EqualValues(t, 3, srv.requests())
+	})
+
+	t.Run("Rejected client is not retried", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.fail(10, http.StatusUnauthorized)
+
+		tokens, _ := newTokenSource(t, srv.URL)
+
+		_, err := tokens.Token(context.Background(), "")
+		require.EqualValues(t, 1, srv.requests())
+
+		var tokenErr *clientcredentials.Error
+
+		require.True(t, errors.As(err, &tokenErr))
+		require.Equal(t, http.StatusUnauthorized, tokenErr.StatusCode)
+		require.Equal(t, "invalid_client", tokenErr.Code)
+	})
+
+	t.Run("Retries stop when the context is done", func(t *testing.T) {
+		srv := newTokenServer(t)
+		srv.fail(10, http.StatusBadGateway)
+
+		tokens, err := clientcredentials.New(&clientcredentials.Config{
+			TokenURL:     srv.URL,
+			ClientID:     "kms",
+			ClientSecret: secrets.New([]byte("secret")),
+		}, clientcredentials.WithRetry(3, time.Hour))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = tokens.Token(ctx, "")
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("Cached token is used while refresh fails", func(t *testing.T) {
+		srv := newTokenServer(t)
+		tokens, clk := newTokenSource(t, srv.URL)
+
+		_, err := tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+
+		srv.fail(100, http.StatusInternalServerError)
+
+		clk.Advance(time.Hour - time.Second)
+
+		token, err := tokens.Token(context.Background(), "")
+		require.NoError(t, err)
+		require.Equal(t, "token-1", token)
+
+		clk.Advance(time.Second)
+
+		_, err = tokens.Token(context.Background(), "")
+		require.Error(t, err)
+	})
+
+	t.Run("Invalid token responses", func(t *testing.T) {
+		for _, tc := range []struct {
+			body string
+			err  string
+		}{
+			{body: `{`, err: "request token: unmarshal token response: unexpected end of JSON input"},
+			{body: `{"token_type":"Bearer"}`, err: "request token: token response has no access token"},
+			{body: `{"access_token":"t","token_type":"mac"}`, err: `request token: unsupported token type "mac"`},
+		} {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = fmt.Fprint(w, tc.body)
+			}))
+
+			tokens, _ := newTokenSource(t, srv.URL)
+
+			_, err := tokens.Token(context.Background(), "")
+			require.EqualError(t, err, tc.err)
+
+			srv.Close()
+		}
+	})
+}
+
+func TestTokenSource_Transport(t *testing.T) {
+	srv := newTokenServer(t)
+	tokens, _ := newTokenSource(t, srv.URL)
+
+	var unauthorized int32
+
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "https://auth", srv.audienceOf(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+
+		if atomic.CompareAndSwapInt32(&unauthorized, 1, 0) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer service.Close()
+
+	client := &http.Client{Transport: tokens.Transport(nil, "https://auth")}
+
+	get := func(status int) {
+		t.Helper()
+
+		resp, err := client.Get(service.URL) //nolint:noctx
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, status, resp.StatusCode)
+	}
+
+	get(http.StatusOK)
+	get(http.StatusOK)
+	require.EqualValues(t, 1, srv.requests())
+
+	// the rejected token is replaced
+	atomic.StoreInt32(&unauthorized, 1)
+	get(http.StatusUnauthorized)
+	get(http.StatusOK)
+	require.EqualValues(t, 2, srv.requests())
+
+	srv.fail(10, http.StatusBadRequest)
+
+	tokens.Invalidate("https://auth", "token-2")
+
+	_, err := client.Get(service.URL) //nolint:noctx,bodyclose
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "token endpoint returned 400: invalid_client")
+}
+
+func TestNew(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		config *clientcredentials.Config
+		err    string
+	}{
+		{config: &clientcredentials.Config{ClientID: "kms"}, err: "token url is required"},
+		{config: &clientcredentials.Config{TokenURL: "https://idp/token"}, err: "client id is required"},
+		{
+			config: &clientcredentials.Config{TokenURL: "https://idp/token", ClientID: "kms"},
+			err:    "client secret or private key is required",
+		},
+		{
+			config: &clientcredentials.Config{TokenURL: "https://idp/token", ClientID: "kms", PrivateKey: key},
+			err:    "unsupported private key curve P-224",
+		},
+	} {
+		_, err = clientcredentials.New(tc.config)
+		require.EqualError(t, err, tc.err)
+	}
+}
+
+func newTokenSource(
+	t *testing.T, tokenURL string, opts ...clientcredentials.Option,
+) (*clientcredentials.TokenSource, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(start)
+
+	tokens, err := clientcredentials.New(&clientcredentials.Config{
+		TokenURL:     tokenURL,
+		ClientID:     "kms",
+		ClientSecret: secrets.New([]byte("secret")),
+	}, append([]clientcredentials.Option{
+		clientcredentials.WithClock(clk),
+		clientcredentials.WithRetry(2, time.Millisecond),
+	}, opts...)...)
+	require.NoError(t, err)
+
+	return tokens, clk
+}
+
+// tokenServer is a stub token endpoint that issues numbered tokens valid for an hour.
+type tokenServer struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	count      int32
+	issued     map[string]string
+	expiresIn  int
+	delay      time.Duration
+	failures   int
+	failStatus int
+	check      func(r *http.Request)
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	t.Helper()
+
+	s := &tokenServer{issued: map[string]string{}, expiresIn: int(time.Hour.Seconds())}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *tokenServer) serve(w http.ResponseWriter, r *http.Request) {
+	n := atomic.AddInt32(&s.count, 1)
+
+	if s.check != nil {
+		s.check(r)
+	}
+
+	time.Sleep(s.delay)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.failures > 0 {
+		s.failures--
+
+		code := "invalid_client"
+		if s.failStatus == http.StatusTooManyRequests {
+			code = "slow_down"
+		}
+
+		w.WriteHeader(s.failStatus)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": "try later"})
+
+		return
+	}
+
+	token := fmt.Sprintf("token-%d", n)
+	s.issued[token] = r.PostFormValue("audience")
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   s.expiresIn,
+	})
+}
+
+func (s *tokenServer) requests() int32 {
+	return atomic.LoadInt32(&s.count)
+}
+
+// audienceOf returns the audience the token was issued for.
+func (s *tokenServer) audienceOf(token string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.issued[token]
+}
+
+// fail makes the next n requests fail with the status.
+func (s *tokenServer) fail(n, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failures = n
+	s.failStatus = status
+}
@@ -0,0 +1,24 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	now := clock.Real().Now()
+
+	require.False(t, now.Before(before))
+	require.False(t, now.After(time.Now()))
+}
@@ -0,0 +1,636 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package conformance checks a running KMS server against the documented API, so that a fork or an older version can
+// be verified before integrating with it. Each capability is a short sequence of requests that passes, fails or is
+// skipped, e.g. because the server reports its operations as disabled.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/controller/command"
+	"github.com/trustbloc/kms/pkg/controller/rest"
+)
+
+// Capabilities checked by the suite, in the order they are run.
+const (
+	CapabilityHealth     = "health"
+	CapabilityInfo       = "info"
+	CapabilityKeyStore   = "keyStore"
+	CapabilityKeys       = "keys"
+	CapabilitySignVerify = "signVerify"
+	CapabilityBatch      = "batch"
+	CapabilityRotation   = "rotation"
+	CapabilityDelete     = "delete"
+	CapabilityMetrics    = "metrics"
+)
+
+// DefaultController is the controller of the key store created by the suite.
+const DefaultController = "did:example:conformance"
+
+const maxErrorBody = 256
+
+// Status is the outcome of a capability check.
+type Status string
+
+// Statuses of capability checks.
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of a capability check.
+type Result struct {
+	Capability string `json:"capability"`
+	Status     Status `json:"status"`
+	// Message is the reason of a failure or a skip.
+	Message    string `json:"message,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of the suite. It is written as JSON for CI gates.
+type Report struct {
+	URL     string   `json:"url"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Results []Result `json:"results"`
+}
+
+// OK returns true if no capability failed.
+func (r *Report) OK() bool {
+	return r.Failed == 0
+}
+
+// Config configures the suite.
+type Config struct {
+	// URL is the base URL of the server, e.g. https://kms.example.com.
+	URL string
+	// MetricsURL is the URL of the Prometheus metrics of the server. The metrics capability is skipped without it.
+	MetricsURL string
+	// AuthToken is sent as a bearer token with key store requests. The server must have auth disabled, or allow key
+	// stores without ZCAPs authorized by the token.
+	AuthToken string
+	// Controller is the controller of the key store created by the suite. Defaults to DefaultController.
+	Controller string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Clock measures durations of the checks. Defaults to system time.
+	Clock clock.Clock
+}
+
+// capability is a check of a capability. It is skipped if any of its actions is disabled on the server, or if any
+// capability it needs didn't pass.
+type capability struct {
+	name    string
+	actions []string
+	needs   []string
+	run     func(ctx context.Context, s *suite) error
+}
+
+var capabilities = []capability{ //nolint:gochecknoglobals
+	{name: CapabilityHealth, run: checkHealth},
+	{name: CapabilityInfo, run: checkInfo},
+	{
+		name:    CapabilityKeyStore,
+		actions: []string{command.ActionCreateKeyStore, command.ActionGetKeyStore},
+		needs:   []string{CapabilityHealth},
+		run:     checkKeyStore,
+	},
+	{
+		name:    CapabilityKeys,
+		actions: []string{command.ActionCreateKey, command.ActionGetKey},
+		needs:   []string{CapabilityKeyStore},
+		run:     checkKeys,
+	},
+	{
+		name:    CapabilitySignVerify,
+		actions: []string{command.ActionCreateKey, command.ActionSign, command.ActionVerify},
+		needs:   []string{CapabilityKeys},
+		run:     checkSignVerify,
+	},
+	{
+		name:    CapabilityBatch,
+		actions: []string{command.ActionCreateKeys, command.ActionSignBatch, command.ActionVerify},
+		needs:   []string{CapabilityKeyStore},
+		run:     checkBatch,
+	},
+	{
+		name:  CapabilityRotation,
+		needs: []string{CapabilityKeys},
+		run:   checkRotation,
+		actions: []string{
+			command.ActionCreateKey, command.ActionRotateKey, command.ActionSign, command.ActionVerify,
+		},
+	},
+	{
+		name:  CapabilityDelete,
+		needs: []string{CapabilityKeys},
+		run:   checkDelete,
+		actions: []string{
+			command.ActionCreateKey, command.ActionDeleteKey, command.ActionRestoreKey, command.ActionGetKey,
+		},
+	},
+	{name: CapabilityMetrics, run: checkMetrics},
+}
+
+// Capabilities returns names of the capabilities checked by the suite, in the order they are run.
+func Capabilities() []string {
+	names := make([]string, 0, len(capabilities))
+
+	for _, c := range capabilities {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+type suite struct {
+	cfg         *Config
+	client      *http.Client
+	clock       clock.Clock
+	disabled    map[string]bool
+	noZCAP      bool
+	keyStoreURL string
+}
+
+// Run runs the suite against the server and returns the report. Failures are reported per capability, Run fails
+// only if the context is done.
+func Run(ctx context.Context, cfg *Config) (*Report, error) {
+	s := &suite{
+		cfg:      cfg,
+		client:   cfg.HTTPClient,
+		clock:    cfg.Clock,
+		disabled: make(map[string]bool),
+	}
+
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+
+	if s.clock == nil {
+		s.clock = clock.Real()
+	}
+
+	report := &Report{URL: cfg.URL}
+	passed := make(map[string]bool)
+
+	for i := range capabilities {
+		c := &capabilities[i]
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result := s.check(ctx, c, passed)
+
+		switch result.Status {
+		case StatusPass:
+			passed[c.name] = true
+			report.Passed++
+		case StatusFail:
+			report.Failed++
+		case StatusSkip:
+			report.Skipped++
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	s.cleanup(ctx)
+
+	return report, nil
+}
+
+func (s *suite) check(ctx context.Context, c *capability, passed map[string]bool) Result {
+	for _, name := range c.needs {
+		if !passed[name] {
+			return Result{Capability: c.name, Status: StatusSkip, Message: "requires " + name}
+		}
+	}
+
+	for _, action := range c.actions {
+		if s.disabled[action] {
+			return Result{Capability: c.name, Status: StatusSkip, Message: "operation " + action + " is disabled"}
+		}
+	}
+
+	start := s.clock.Now()
+	err := c.run(ctx, s)
+	result := Result{Capability: c.name, Status: StatusPass, DurationMS: s.clock.Now().Sub(start).Milliseconds()}
+
+	var skip *skipError
+
+	switch {
+	case errors.As(err, &skip):
+		result.Status, result.Message = StatusSkip, skip.reason
+	case err != nil:
+		result.Status, result.Message = StatusFail, err.Error()
+	}
+
+	return result
+}
+
+// cleanup deletes the key store created by the suite. Servers that can't delete key stores keep it.
+func (s *suite) cleanup(ctx context.Context) {
+	if s.keyStoreURL == "" || s.disabled[command.ActionDeleteKeyStore] {
+		return
+	}
+
+	_ = s.call(ctx, http.MethodDelete, s.keyStoreURL, nil, nil, http.StatusNoContent) //nolint:errcheck
+}
+
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return e.reason
+}
+
+func checkHealth(ctx context.Context, s *suite) error {
+	var resp struct {
+		Status      string    `json:"status"`
+		CurrentTime time.Time `json:"current_time"`
+	}
+
+	// the health check must not require auth, load balancers probe it without credentials
+	if err := s.do(ctx, http.MethodGet, s.cfg.URL+rest.HealthCheckPath, nil, &resp, false, http.StatusOK); err != nil {
+		return err
+	}
+
+	if resp.Status != "success" {
+		return fmt.Errorf("health check status %q, want \"success\"", resp.Status)
+	}
+
+	if resp.CurrentTime.IsZero() {
+		return errors.New("health check has no current_time")
+	}
+
+	return nil
+}
+
+func checkInfo(ctx context.Context, s *suite) error {
+	var resp struct {
+		DisabledOperations []string `json:"disabled_operations"`
+		NoZCAPKeyStores    bool     `json:"no_zcap_key_stores"`
+	}
+
+	err := s.do(ctx, http.MethodGet, s.cfg.URL+rest.InfoPath, nil, &resp, false, http.StatusOK)
+
+	var statusErr *statusError
+
+	// servers before /info don't report capabilities, all of them are checked
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		return &skipError{reason: "server doesn't report its capabilities, all of them are checked"}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, operation := range resp.DisabledOperations {
+		s.disabled[operation] = true
+	}
+
+	s.noZCAP = resp.NoZCAPKeyStores
+
+	return nil
+}
+
+func checkKeyStore(ctx context.Context, s *suite) error {
+	controller := s.cfg.Controller
+	if controller == "" {
+		controller = DefaultController
+	}
+
+	var resp command.CreateKeyStoreResponse
+
+	err := s.call(ctx, http.MethodPost, s.cfg.URL+rest.KeyStorePath,
+		command.CreateKeyStoreRequest{Controller: controller, DisableZCAP: s.noZCAP}, &resp, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("create key store: %w", err)
+	}
+
+	if resp.KeyStoreURL == "" {
+		return errors.New("create key store: no key_store_url")
+	}
+
+	s.keyStoreURL = s.resolve(resp.KeyStoreURL)
+
+	if err = s.call(ctx, http.MethodGet, s.keyStoreURL, nil, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("get key store: %w", err)
+	}
+
+	return nil
+}
+
+func checkKeys(ctx context.Context, s *suite) error {
+	keyURL, err := s.createKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.call(ctx, http.MethodGet, keyURL, nil, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("get key: %w", err)
+	}
+
+	return nil
+}
+
+func checkSignVerify(ctx context.Context, s *suite) error {
+	keyURL, err := s.createKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg := []byte("conformance message")
+
+	sig, err := s.sign(ctx, keyURL, msg)
+	if err != nil {
+		return err
+	}
+
+	if err = s.verify(ctx, keyURL, sig, msg); err != nil {
+		return err
+	}
+
+	// an invalid signature must be rejected
+	if err = s.verify(ctx, keyURL, sig, []byte("other message")); err == nil {
+		return errors.New("verify: signature of another message was accepted")
+	}
+
+	return nil
+}
+
+func checkBatch(ctx context.Context, s *suite) error {
+	var keys command.CreateKeysResponse
+
+	err := s.call(ctx, http.MethodPost, s.keyStoreURL+"/keys/batch", command.CreateKeysRequest{
+		Keys: []command.CreateKeyRequest{{KeyType: "ED25519"}, {KeyType: "ECDSAP256IEEEP1363"}},
+	}, &keys, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("create keys: %w", err)
+	}
+
+	if len(keys.Keys) != 2 { //nolint:gomnd
+		return fmt.Errorf("create keys: got %d keys, want 2", len(keys.Keys))
+	}
+
+	keyURL := s.resolve(keys.Keys[0].KeyURL)
+	msgs := [][]byte{[]byte("first message"), []byte("second message")}
+
+	var sigs command.SignBatchResponse
+
+	err = s.call(ctx, http.MethodPost, keyURL+"/sign/batch", command.SignBatchRequest{Messages: msgs}, &sigs,
+		http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("sign batch: %w", err)
+	}
+
+	if len(sigs.Signatures) != len(msgs) {
+		return fmt.Errorf("sign batch: got %d signatures, want %d", len(sigs.Signatures), len(msgs))
+	}
+
+	for i, sig := range sigs.Signatures {
+		if err = s.verify(ctx, keyURL, sig, msgs[i]); err != nil {
+			return fmt.Errorf("signature %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func checkRotation(ctx context.Context, s *suite) error {
+	keyURL, err := s.createKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg := []byte("conformance message")
+
+	before, err := s.sign(ctx, keyURL, msg)
+	if err != nil {
+		return err
+	}
+
+	var resp command.RotateKeyResponse
+
+	err = s.call(ctx, http.MethodPost, keyURL+"/rotate", command.RotateKeyRequest{KeyType: "ED25519"}, &resp,
+		http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("rotate key: %w", err)
+	}
+
+	rotatedURL := s.resolve(resp.KeyURL)
+	if rotatedURL == keyURL {
+		return errors.New("rotate key: key URL didn't change")
+	}
+
+	// the rotated keyset keeps previous keys
+	if err = s.verify(ctx, rotatedURL, before, msg); err != nil {
+		return fmt.Errorf("signature made before rotation: %w", err)
+	}
+
+	after, err := s.sign(ctx, rotatedURL, msg)
+	if err != nil {
+		return err
+	}
+
+	return s.verify(ctx, rotatedURL, after, msg)
+}
+
+func checkDelete(ctx context.Context, s *suite) error {
+	keyURL, err := s.createKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = s.call(ctx, http.MethodDelete, keyURL, nil, nil, http.StatusNoContent); err != nil {
+		return fmt.Errorf("delete key: %w", err)
+	}
+
+	if err = s.call(ctx, http.MethodGet, keyURL, nil, nil, http.StatusNotFound); err != nil {
+		return fmt.Errorf("get deleted key: %w", err)
+	}
+
+	if err = s.call(ctx, http.MethodPost, keyURL+"/restore", nil, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("restore key: %w", err)
+	}
+
+	if err = s.call(ctx, http.MethodGet, keyURL, nil, nil, http.StatusOK); err != nil {
+		return fmt.Errorf("get restored key: %w", err)
+	}
+
+	return nil
+}
+
+// checkMetrics checks that the server exports its metrics. It runs last, so that the suite's requests are counted.
+func checkMetrics(ctx context.Context, s *suite) error {
+	if s.cfg.MetricsURL == "" {
+		return &skipError{reason: "metrics URL is not set"}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.MetricsURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("get metrics: %w", err)
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read metrics: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get metrics: status %d", resp.StatusCode)
+	}
+
+	for _, metric := range []string{"kms_crypto_sign_seconds", "kms_key_store_resolve_seconds"} {
+		if !bytes.Contains(body, []byte(metric)) {
+			return fmt.Errorf("metric %s is missing", metric)
+		}
+	}
+
+	return nil
+}
+
+func (s *suite) createKey(ctx context.Context) (string, error) {
+	var resp command.CreateKeyResponse
+
+	err := s.call(ctx, http.MethodPost, s.keyStoreURL+"/keys", command.CreateKeyRequest{KeyType: "ED25519"}, &resp,
+		http.StatusOK)
+	if err != nil {
+		return "", fmt.Errorf("create key: %w", err)
+	}
+
+	if resp.KeyURL == "" {
+		return "", errors.New("create key: no key_url")
+	}
+
+	return s.resolve(resp.KeyURL), nil
+}
+
+func (s *suite) sign(ctx context.Context, keyURL string, msg []byte) ([]byte, error) {
+	var resp command.SignResponse
+
+	if err := s.call(ctx, http.MethodPost, keyURL+"/sign", command.SignRequest{Message: msg}, &resp,
+		http.StatusOK); err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	if len(resp.Signature) == 0 {
+		return nil, errors.New("sign: no signature")
+	}
+
+	return resp.Signature, nil
+}
+
+func (s *suite) verify(ctx context.Context, keyURL string, sig, msg []byte) error {
+	err := s.call(ctx, http.MethodPost, keyURL+"/verify", command.VerifyRequest{Signature: sig, Message: msg}, nil,
+		http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	return nil
+}
+
+// resolve returns the absolute URL of a key store or key URL, which the server returns relative to its base URL if
+// it has none.
+func (s *suite) resolve(u string) string {
+	if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+		return u
+	}
+
+	return s.cfg.URL + u
+}
+
+// call sends an authorized request.
+func (s *suite) call(ctx context.Context, method, u string, req, resp interface{}, status int) error {
+	return s.do(ctx, method, u, req, resp, true, status)
+}
+
+type statusError struct {
+	status int
+	want   int
+	body   []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d, want %d: %s", e.status, e.want, e.body)
+}
+
+func (s *suite) do(ctx context.Context, method, u string, req, resp interface{}, auth bool, status int) error {
+	var body io.Reader
+
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	if req != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	if auth && s.cfg.AuthToken != "" {
+		r.Header.Set("Authorization", "Bearer "+s.cfg.AuthToken)
+	}
+
+	res, err := s.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+
+	defer res.Body.Close() //nolint:errcheck
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if res.StatusCode != status {
+		if len(b) > maxErrorBody {
+			b = b[:maxErrorBody]
+		}
+
+		return &statusError{status: res.StatusCode, want: status, body: bytes.TrimSpace(b)}
+	}
+
+	if resp != nil {
+		if err = json.Unmarshal(b, resp); err != nil {
+			return fmt.Errorf("unmarshal response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package conformance_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/conformance"
+)
+
+func TestRun(t *testing.T) {
+	statuses := func(report *conformance.Report) map[string]conformance.Status {
+		m := make(map[string]conformance.Status)
+
+		for _, r := range report.Results {
+			m[r.Capability] = r.Status
+		}
+
+		return m
+	}
+
+	t.Run("Unhealthy server fails and dependent capabilities are skipped", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{URL: srv.URL})
+		require.NoError(t, err)
+		require.False(t, report.OK())
+		require.Equal(t, 1, report.Failed)
+		require.Len(t, report.Results, len(conformance.Capabilities()))
+
+		s := statuses(report)
+		require.Equal(t, conformance.StatusFail, s[conformance.CapabilityHealth])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityInfo])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityKeyStore])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilitySignVerify])
+
+		require.Equal(t, "status 404, want 200: 404 page not found", report.Results[0].Message)
+		require.Equal(t, "requires "+conformance.CapabilityHealth, report.Results[2].Message)
+	})
+
+	t.Run("Health check semantics", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status": "degraded"}`)
+		}))
+		defer srv.Close()
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{URL: srv.URL})
+		require.NoError(t, err)
+		require.Equal(t, conformance.StatusFail, report.Results[0].Status)
+		require.Equal(t, `health check status "degraded", want "success"`, report.Results[0].Message)
+	})
+
+	t.Run("Disabled key store operations are skipped", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status": "success", "current_time": "2022-06-01T09:00:00Z"}`)
+		})
+		mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"disabled_operations": ["getKeyStore"]}`)
+		})
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "kms_crypto_sign_seconds_count 0\n")
+		})
+
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{
+			URL:        srv.URL,
+			MetricsURL: srv.URL + "/metrics",
+		})
+		require.NoError(t, err)
+
+		s := statuses(report)
+		require.Equal(t, conformance.StatusPass, s[conformance.CapabilityHealth])
+		require.Equal(t, conformance.StatusPass, s[conformance.CapabilityInfo])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityKeyStore])
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityBatch])
+		require.Equal(t, conformance.StatusFail, s[conformance.CapabilityMetrics])
+		require.Equal(t, "operation getKeyStore is disabled", report.Results[2].Message)
+		require.Equal(t, "metric kms_key_store_resolve_seconds is missing", report.Results[8].Message)
+	})
+
+	t.Run("Servers without info are checked for all capabilities", func(t *testing.T) {
+		var auth []string
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+			auth = append(auth, r.Header.Get("Authorization"))
+
+			fmt.Fprint(w, `{"status": "success", "current_time": "2022-06-01T09:00:00Z"}`)
+		})
+		mux.HandleFunc("/v1/keystores", func(w http.ResponseWriter, r *http.Request) {
+			auth = append(auth, r.Header.Get("Authorization"))
+
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		})
+
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		report, err := conformance.Run(context.Background(), &conformance.Config{URL: srv.URL, AuthToken: "token"})
+		require.NoError(t, err)
+
+		s := statuses(report)
+		require.Equal(t, conformance.StatusSkip, s[conformance.CapabilityInfo])
+		require.Equal(t, conformance.StatusFail, s[conformance.CapabilityKeyStore])
+		require.Equal(t, "create key store: status 401, want 200: unauthorized", report.Results[2].Message)
+
+		// the health check is probed without credentials
+		require.Equal(t, []string{"", "Bearer token"}, auth)
+	})
+
+	t.Run("Context done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := conformance.Run(ctx, &conformance.Config{URL: "http://localhost"})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
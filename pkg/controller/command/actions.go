@@ -10,11 +10,28 @@ package command
 const (
 	ActionCreateDID       = "createDID"
 	ActionCreateKeyStore  = "createKeyStore"
+	ActionGetKeyStore     = "getKeyStore"
+	ActionListKeyStores   = "listKeyStores"        // admin action, not allowed by key store capabilities
+	ActionSetOverrides    = "setKeyStoreOverrides" // admin action, not allowed by key store capabilities
+	ActionDeleteKeyStore  = "deleteKeyStore"
+	ActionUpdateKeyStore  = "updateKeyStore"
 	ActionCreateKey       = "createKey"
+	ActionCreateKeys      = "createKeys"
 	ActionImportKey       = "importKey"
+	ActionGetKey          = "getKey"
+	ActionListKeys        = "listKeys"
 	ActionExportKey       = "exportKey"
 	ActionRotateKey       = "rotateKey"
+	ActionUpdateKey       = "updateKey"
+	ActionSetKeyState     = "setKeyState"
+	ActionDeleteKey       = "deleteKey"
+	ActionRestoreKey      = "restoreKey"
+	ActionCreateToken     = "createToken"
+	ActionInvitation      = "createInvitation"
 	ActionSign            = "sign"
+	ActionSignBatch       = "signBatch"
+	ActionSignMultiKey    = "signMultiKey"
+	ActionSignJWT         = "signJWT"
 	ActionVerify          = "verify"
 	ActionEncrypt         = "encrypt"
 	ActionDecrypt         = "decrypt"
@@ -29,6 +46,11 @@ const (
 	ActionSealOpen        = "sealOpen"
 	ActionWrap            = "wrap"
 	ActionUnwrap          = "unwrap"
+	ActionEncryptJWE      = "encryptJWE"
+	ActionDecryptJWE      = "decryptJWE"
+	ActionDeriveKey       = "deriveKey"
+	ActionDeriveSubkey    = "deriveSubkey"
+	ActionSignUpload      = "signUpload"
 	ActionStoreCapability = "updateEDVCapability"
 )
 
@@ -38,6 +60,9 @@ func allActions() []string {
 		ActionExportKey,
 		ActionImportKey,
 		ActionRotateKey,
+		ActionDeleteKey,
+		ActionRestoreKey,
+		ActionCreateToken,
 		ActionSign,
 		ActionVerify,
 		ActionComputeMac,
@@ -54,5 +79,22 @@ func allActions() []string {
 		ActionWrap,
 		ActionUnwrap,
 		ActionStoreCapability,
+		ActionDeleteKeyStore,
+		ActionGetKeyStore,
+		ActionGetKey,
+		ActionCreateKeys,
+		ActionSignBatch,
+		ActionSignMultiKey,
+		ActionUpdateKey,
+		ActionInvitation,
+		ActionSetKeyState,
+		ActionListKeys,
+		ActionUpdateKeyStore,
+		ActionSignJWT,
+		ActionEncryptJWE,
+		ActionDecryptJWE,
+		ActionDeriveKey,
+		ActionDeriveSubkey,
+		ActionSignUpload,
 	}
 }
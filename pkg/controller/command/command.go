@@ -7,13 +7,14 @@ SPDX-License-Identifier: Apache-2.0
 package command
 
 //nolint:lll
-//go:generate mockgen -destination gomocks_test.go -self_package mocks -package command_test -source=command.go -mock_names zcapService=MockZCAPService,headerSigner=MockHeaderSigner,keyStoreCreator=MockKeyStoreCreator,cryptoBoxCreator=MockCryptoBoxCreator,shamirSecretLockCreator=MockShamirSecretLockCreator,metricsProvider=MockMetricsProvider,cacheProvider=MockCacheProvider,shamirProvider=MockShamirProvider
+//go:generate mockgen -destination gomocks_test.go -self_package mocks -package command_test -source=command.go -mock_names zcapService=MockZCAPService,headerSigner=MockHeaderSigner,keyStoreCreator=MockKeyStoreCreator,cryptoBoxCreator=MockCryptoBoxCreator,shamirSecretLockCreator=MockShamirSecretLockCreator,metricsProvider=MockMetricsProvider,cacheProvider=MockCacheProvider,shamirProvider=MockShamirProvider,urlResolver=MockURLResolver
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,16 +22,37 @@ import (
 	"time"
 
 	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/piprate/json-gold/ld"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/clock"
 	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/cryptopool"
+	"github.com/trustbloc/kms/pkg/idempotency"
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+	"github.com/trustbloc/kms/pkg/keyusage"
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+	"github.com/trustbloc/kms/pkg/kms/ecdsahash"
+	"github.com/trustbloc/kms/pkg/kms/prehash"
+	"github.com/trustbloc/kms/pkg/kms/rfc6979"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+	"github.com/trustbloc/kms/pkg/reqlog"
 	"github.com/trustbloc/kms/pkg/secretlock/key"
+	"github.com/trustbloc/kms/pkg/signnonce"
+	"github.com/trustbloc/kms/pkg/storage/archive"
 	"github.com/trustbloc/kms/pkg/storage/metrics"
+	"github.com/trustbloc/kms/pkg/upload"
+	"github.com/trustbloc/kms/pkg/verifycache"
 )
 
 type zcapService interface {
@@ -39,6 +61,8 @@ type zcapService interface {
 	KMS() kms.KeyManager
 	Crypto() crypto.Crypto
 	Resolve(string) (*zcapld.Capability, error)
+	Delete(uri string) error
+	Supersede(uri string, until time.Time) error
 }
 
 // headerSigner computes a signature on the request and returns a header with the signature.
@@ -66,17 +90,23 @@ type shamirProvider interface {
 }
 
 type shamirSecretLockCreator interface {
-	Create(secretShares [][]byte) (secretlock.Service, error)
+	Create(scheme string, secretShares [][]byte) (secretlock.Service, error)
 }
 
 type metricsProvider interface {
 	CryptoSignTime(value time.Duration)
+	CryptoCanonicalizeTime(profile string, value time.Duration)
 	KeyStoreResolveTime(value time.Duration)
 	KeyStoreGetKeyTime(value time.Duration)
+	RequestLimitRejection(limit string)
+}
+
+type urlResolver interface {
+	Resolve(rawURL string) (string, error)
 }
 
 type cacheProvider interface {
-	Wrap(storageProvider storage.Provider, ttl time.Duration) storage.Provider
+	Wrap(storageProvider storage.Provider, keyStoreID string, ttl time.Duration) storage.Provider
 }
 
 // Config is a configuration for Command.
@@ -97,16 +127,90 @@ type Config struct {
 	BaseKeyStoreURL         string
 	ShamirProvider          shamirProvider
 	MainKeyType             kms.KeyType
-	EDVRecipientKeyType     kms.KeyType
-	EDVMACKeyType           kms.KeyType
-	MetricsProvider         metricsProvider
-	CacheProvider           cacheProvider
-	KeyStoreCacheTTL        time.Duration
+	// EDVRecipientKeyType and EDVMACKeyType are key types of keys that protect keys of EDV key stores. EDV key stores
+	// can't be created if either is empty.
+	EDVRecipientKeyType kms.KeyType
+	EDVMACKeyType       kms.KeyType
+	MetricsProvider     metricsProvider
+	CacheProvider       cacheProvider
+	KeyStoreCacheTTL    time.Duration
+	// MaxKeyStoreCacheTTL bounds cache TTL overrides of key stores. Overrides are ignored if zero.
+	MaxKeyStoreCacheTTL time.Duration
+	URLResolver         urlResolver // resolves service discovery URLs (e.g. dns+srv) of EDV
+	Clock               clock.Clock // defaults to system time
+	// VerifyCache caches verification results of key stores that opt in. Disabled if nil.
+	VerifyCache *verifycache.VerifyCache
+	// OneTimeTokens mints single-use tokens for operations on keys. Minting is disabled if nil.
+	OneTimeTokens *onetimetoken.Store
+	// SignNonces keeps signatures of sign requests with nonces. Nonces are ignored if nil.
+	SignNonces *signnonce.Store
+	// CallerNonces allows encrypt requests to set the nonce and detects its reuse. The server generates nonces if nil.
+	CallerNonces *aesgcm.ReuseDetector
+	// Canonicalizer transforms documents of sign requests with canonicalization profiles. Disabled if nil.
+	Canonicalizer *canonicalization.Canonicalizer
+	// MaxSignBatchSize is the maximum number of messages in a sign batch. Defaults to DefaultMaxSignBatchSize.
+	MaxSignBatchSize int
+	// MaxSignMultiKeyMessageSize is the maximum size in bytes of a message of a multi-key sign request. Defaults to
+	// DefaultMaxSignMultiKeyMessageSize.
+	MaxSignMultiKeyMessageSize int
+	// MaxSignMultiKeyTotalSize is the maximum total size in bytes of messages of a multi-key sign request. Defaults
+	// to DefaultMaxSignMultiKeyTotalSize.
+	MaxSignMultiKeyTotalSize int
+	// KeyExpiryClockSkew is how long after its expiration time a key can still be used, to allow for clock skew
+	// between clients and the server.
+	KeyExpiryClockSkew time.Duration
+	// ControllerRotationGracePeriod is how long the root capability of a key store is still accepted after it was
+	// re-issued for a new controller.
+	ControllerRotationGracePeriod time.Duration
+	// IdempotencyKeys keeps responses of key store creation requests with idempotency keys. Idempotency keys are
+	// ignored if nil.
+	IdempotencyKeys *idempotency.Store
+	// DIDCommMediatorURL is the service endpoint of DIDComm out-of-band invitations. Invitations are disabled if empty.
+	DIDCommMediatorURL string
+	// KeyRetentionPeriod is how long a deleted key can be restored before it is purged. Defaults to
+	// DefaultKeyRetentionPeriod.
+	KeyRetentionPeriod time.Duration
+	// KeyUsage records when keys were last used. Usage isn't tracked if nil.
+	KeyUsage *keyusage.Tracker
+	// CryptoPools run signature and proof operations in worker pools per cost class of their keys. Operations run
+	// directly if nil.
+	CryptoPools *cryptopool.Pools
+	// EnableNoZCAPKeyStores allows creating key stores without ZCAPs, authorized by the OAuth subject that created
+	// them. Requests for such key stores are refused if false.
+	EnableNoZCAPKeyStores bool
+	// RequestLimits limit the nesting depth, array lengths and string sizes of request bodies. Defaults to
+	// jsonlimit.DefaultLimits() if zero.
+	RequestLimits jsonlimit.Limits
+	// RSAKeyPool generates RSA keys ahead of create key requests. Keys are generated on the spot if nil.
+	RSAKeyPool *rsapss.Pool
+	// EnableRawDerivedKeys allows DeriveKey to return derived keys unwrapped. Derived keys must be wrapped for a
+	// recipient if false.
+	EnableRawDerivedKeys bool
+	// EDVBreaker fails operations on EDV key stores fast while EDV is failing. Operations always reach EDV if nil.
+	EDVBreaker *breaker.Breaker
+	// EDVTimeout is how long an operation on an EDV key store waits for EDV. No timeout if zero.
+	EDVTimeout time.Duration
+	// KeyStoreRateLimit is the default maximum number of requests of a key store per second, returned by
+	// KeyStoreLimits for the middleware that enforces it. No limit if zero.
+	KeyStoreRateLimit float64
+	// KeyStoreConcurrencyLimit is the default maximum number of requests of a key store served at the same time,
+	// returned by KeyStoreLimits. No limit if zero.
+	KeyStoreConcurrencyLimit int
+	// MaxKeyStoreRateLimit bounds rate limit overrides of key stores. Overrides are ignored if zero.
+	MaxKeyStoreRateLimit float64
+	// MaxKeyStoreConcurrencyLimit bounds concurrency limit overrides of key stores. Overrides are ignored if zero.
+	MaxKeyStoreConcurrencyLimit int
+	// KeyArchive moves cold keys to archive storage, see ArchiveColdKeys. It must be the KeyStorageProvider, so that
+	// archived keys are recalled when they are used. Keys aren't archived if nil.
+	KeyArchive *archive.Provider
+	// Uploads keeps payloads uploaded in chunks to be signed. Chunked uploads are disabled if nil.
+	Uploads *upload.Store
 }
 
 // Command is a controller for commands.
 type Command struct {
 	store               storage.Store
+	storageProvider     storage.Provider // server's storage provider, also used by the server's key manager
 	keyStorageProvider  storage.Provider
 	kms                 kms.KeyManager // server's key manager
 	crypto              crypto.Crypto
@@ -126,9 +230,40 @@ type Command struct {
 	edvMACKeyType       kms.KeyType
 	cacheProvider       cacheProvider
 	keyStoreCacheTTL    time.Duration
+	maxKeyStoreCacheTTL time.Duration
+	keyStoreLimits      keyStoreLimits
 	metrics             metricsProvider
+	urlResolver         urlResolver
+	clock               clock.Clock
+	verifyCache         *verifycache.VerifyCache
+	oneTimeTokens       *onetimetoken.Store
+	signNonces          *signnonce.Store
+	callerNonces        *aesgcm.ReuseDetector
+	canonicalizer       *canonicalization.Canonicalizer
+	maxSignBatchSize    int
+	maxMultiKeyMessage  int
+	maxMultiKeyTotal    int
+	didcommMediatorURL  string
+	idempotencyKeys     *idempotency.Store
+	keyExpiryClockSkew  time.Duration
+	controllerGrace     time.Duration
+	keyRetentionPeriod  time.Duration
+	keyUsage            *keyusage.Tracker
+	cryptoPools         *cryptopool.Pools
+	enableNoZCAP        bool
+	requestLimits       jsonlimit.Limits
+	rsaKeyPool          *rsapss.Pool
+	enableRawDerived    bool
+	edvBreaker          *breaker.Breaker
+	edvTimeout          time.Duration
+	keyArchive          *archive.Provider
+	uploads             *upload.Store
+	keyStoreLocks       keyStoreLocks
 }
 
+// keyStoreTagNames are the tags of key store records the key store db is configured to query by.
+var keyStoreTagNames = []string{controllerTagName, deletedKeysTagName, overridesTagName} //nolint:gochecknoglobals
+
 // New returns a new instance of Command.
 func New(c *Config) (*Command, error) {
 	store, err := c.StorageProvider.OpenStore(keyStores)
@@ -136,8 +271,51 @@ func New(c *Config) (*Command, error) {
 		return nil, fmt.Errorf("open key store db: %w", err)
 	}
 
+	err = c.StorageProvider.SetStoreConfig(keyStores, storage.StoreConfiguration{TagNames: keyStoreTagNames})
+	if err != nil {
+		return nil, fmt.Errorf("set key store db config: %w", err)
+	}
+
+	clk := c.Clock
+	if clk == nil {
+		clk = clock.Real()
+	}
+
+	maxSignBatchSize := c.MaxSignBatchSize
+	if maxSignBatchSize <= 0 {
+		maxSignBatchSize = DefaultMaxSignBatchSize
+	}
+
+	maxMultiKeyMessage := c.MaxSignMultiKeyMessageSize
+	if maxMultiKeyMessage <= 0 {
+		maxMultiKeyMessage = DefaultMaxSignMultiKeyMessageSize
+	}
+
+	maxMultiKeyTotal := c.MaxSignMultiKeyTotalSize
+	if maxMultiKeyTotal <= 0 {
+		maxMultiKeyTotal = DefaultMaxSignMultiKeyTotalSize
+	}
+
+	keyRetentionPeriod := c.KeyRetentionPeriod
+	if keyRetentionPeriod <= 0 {
+		keyRetentionPeriod = DefaultKeyRetentionPeriod
+	}
+
+	requestLimits := c.RequestLimits
+	if requestLimits == (jsonlimit.Limits{}) {
+		requestLimits = jsonlimit.DefaultLimits()
+	}
+
+	limits := keyStoreLimits{
+		rateLimit:           c.KeyStoreRateLimit,
+		concurrencyLimit:    c.KeyStoreConcurrencyLimit,
+		maxRateLimit:        c.MaxKeyStoreRateLimit,
+		maxConcurrencyLimit: c.MaxKeyStoreConcurrencyLimit,
+	}
+
 	return &Command{
 		store:               store,
+		storageProvider:     c.StorageProvider,
 		keyStorageProvider:  c.KeyStorageProvider,
 		kms:                 c.KMS,
 		crypto:              c.Crypto,
@@ -157,7 +335,34 @@ func New(c *Config) (*Command, error) {
 		edvMACKeyType:       c.EDVMACKeyType,
 		cacheProvider:       c.CacheProvider,
 		keyStoreCacheTTL:    c.KeyStoreCacheTTL,
+		maxKeyStoreCacheTTL: c.MaxKeyStoreCacheTTL,
+		keyStoreLimits:      limits,
 		metrics:             c.MetricsProvider,
+		urlResolver:         c.URLResolver,
+		clock:               clk,
+		verifyCache:         c.VerifyCache,
+		oneTimeTokens:       c.OneTimeTokens,
+		signNonces:          c.SignNonces,
+		callerNonces:        c.CallerNonces,
+		canonicalizer:       c.Canonicalizer,
+		maxSignBatchSize:    maxSignBatchSize,
+		maxMultiKeyMessage:  maxMultiKeyMessage,
+		maxMultiKeyTotal:    maxMultiKeyTotal,
+		didcommMediatorURL:  c.DIDCommMediatorURL,
+		idempotencyKeys:     c.IdempotencyKeys,
+		keyExpiryClockSkew:  c.KeyExpiryClockSkew,
+		controllerGrace:     c.ControllerRotationGracePeriod,
+		keyRetentionPeriod:  keyRetentionPeriod,
+		keyUsage:            c.KeyUsage,
+		cryptoPools:         c.CryptoPools,
+		enableNoZCAP:        c.EnableNoZCAPKeyStores,
+		requestLimits:       requestLimits,
+		rsaKeyPool:          c.RSAKeyPool,
+		enableRawDerived:    c.EnableRawDerivedKeys,
+		edvBreaker:          c.EDVBreaker,
+		edvTimeout:          c.EDVTimeout,
+		keyArchive:          c.KeyArchive,
+		uploads:             c.Uploads,
 	}, nil
 }
 
@@ -175,178 +380,573 @@ func (c *Command) CreateDID(w io.Writer, _ io.Reader) error {
 func (c *Command) CreateKey(w io.Writer, r io.Reader) error {
 	var req CreateKeyRequest
 
-	wr, err := unwrapRequest(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
 
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if req.Alias != "" {
+		if err = validateAlias(req.Alias); err != nil {
+			return err
+		}
+	}
+
+	if err = c.validateExpiresAt(req.ExpiresAt); err != nil {
+		return err
+	}
+
+	if err = validateKeyPurposes(req.Purposes); err != nil {
+		return err
+	}
+
+	if err = validateKeySize(req.KeyType, req.KeySize); err != nil {
+		return err
+	}
+
+	req.KeyType = keyTypeOfSize(req.KeyType, req.KeySize)
+
+	if err = validateKeyHash(req.KeyType, req.Hash); err != nil {
+		return err
+	}
+
+	ks, meta, storageProvider, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
 	if err != nil {
 		return fmt.Errorf("resolve key store: %w", err)
 	}
 
-	kid, _, err := ks.Create(req.KeyType)
+	if err = checkKeyStorage(req.KeyType, meta); err != nil {
+		return err
+	}
+
+	// fails early without creating a key, the alias is checked again when the key is added to the key store
+	if err = c.checkAliases(wr.KeyStoreID, map[string]string{req.Alias: ""})(meta); err != nil {
+		return err
+	}
+
+	kid, err := c.createKey(ks, req.KeyType, req.KeySize)
 	if err != nil {
 		return fmt.Errorf("create key: %w", err)
 	}
 
-	pub, _, err := ks.ExportPubKeyBytes(kid)
+	pub, err := exportPubKeyBytes(ks, kid)
 	if err != nil {
-		if !strings.Contains(err.Error(), "failed to get public keyset handle") {
-			return fmt.Errorf("export public key bytes: %w", err)
+		return err
+	}
+
+	seq, err := c.incrementSequenceChecked(wr.KeyStoreID,
+		c.checkAliases(wr.KeyStoreID, map[string]string{req.Alias: kid}),
+		addKeyID(kid, req.KeyType, c.clock.Now().UTC()), setKeyFingerprint(kid, pub), setKeyExpiry(kid, req.ExpiresAt),
+		setKeyPurposes(kid, req.Purposes), setKeyHash(kid, req.KeyType, req.Hash), setKeyAlias(kid, req.Alias))
+	if err != nil {
+		// the key isn't in the key store metadata, e.g. its alias was taken by a concurrent request or the metadata
+		// couldn't be saved, so it's deleted rather than left unreachable
+		if deleteErr := deleteKeys(storageProvider, kid); deleteErr != nil {
+			return fmt.Errorf("increment sequence: %w (delete created key: %s)", err, deleteErr.Error())
 		}
+
+		return fmt.Errorf("increment sequence: %w", err)
 	}
 
 	return json.NewEncoder(w).Encode(CreateKeyResponse{
-		KeyURL:    fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
-		PublicKey: pub,
+		KeyURL:        fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+		PublicKey:     pub,
+		Sequence:      seq,
+		SchemaVersion: SchemaVersion,
 	})
 }
 
-// ExportKey exports a key.
+// ExportKey exports a public key as raw bytes or, if requested, as a JWK or did:key.
 func (c *Command) ExportKey(w io.Writer, r io.Reader) error {
-	wr, err := unwrapRequest(nil, r)
+	wr, err := c.unwrapRequest(nil, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
 
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err = validateExportFormat(wr.Format, wr.Fields); err != nil {
+		return fmt.Errorf("validate fields: %w", err)
+	}
+
+	ks, err := c.resolveKeyStoreForKey(wr)
 	if err != nil {
 		return fmt.Errorf("resolve key store: %w", err)
 	}
 
 	b, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
 	if err != nil {
-		return fmt.Errorf("export public key bytes: %w", err)
+		return fmt.Errorf("export public key bytes: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	switch wr.Format {
+	case ExportFormatJWK:
+		return encodeJWK(w, b, kt, wr.keyHash, fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID,
+			wr.KeyID))
+	case ExportFormatDID:
+		return encodeDIDKey(w, b, kt)
 	}
 
-	return json.NewEncoder(w).Encode(ExportKeyResponse{PublicKey: b, KeyType: string(kt)})
+	return encodeFields(w, ExportKeyResponse{PublicKey: b, KeyType: string(kt)}, wr.Fields)
 }
 
-// ImportKey imports a key.
-func (c *Command) ImportKey(w io.Writer, r io.Reader) error {
-	var req ImportKeyRequest
+// RotateKey rotate key.
+func (c *Command) RotateKey(w io.Writer, r io.Reader) error {
+	var req RotateKeyRequest
 
-	wr, err := unwrapRequest(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
 
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err = c.validateExpiresAt(req.ExpiresAt); err != nil {
+		return err
+	}
+
+	ks, meta, _, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
 	if err != nil {
 		return fmt.Errorf("resolve key store: %w", err)
 	}
 
-	var privateKey interface{}
+	if err = checkKeyStorage(req.KeyType, meta); err != nil {
+		return err
+	}
 
-	switch req.KeyType { //nolint:exhaustive
-	case
-		kms.ED25519Type,
-		kms.ECDSAP256TypeDER,
-		kms.ECDSAP384TypeDER,
-		kms.ECDSAP521TypeDER,
-		kms.ECDSAP256TypeIEEEP1363,
-		kms.ECDSAP384TypeIEEEP1363,
-		kms.ECDSAP521TypeIEEEP1363:
-		privateKey, err = x509.ParsePKCS8PrivateKey(req.Key)
-		if err != nil {
-			return fmt.Errorf("parse private key: %w", err)
-		}
-	default:
-		return fmt.Errorf("not supported key type: %s", req.KeyType)
+	if err = checkRotatedKeyHash(meta, wr.KeyID, req.KeyType); err != nil {
+		return err
 	}
 
-	var opts []kms.PrivateKeyOpts
+	// the rotated keyset keeps previous keys, so signatures made before rotation can be verified with the new key ID
+	kid, _, err := ks.Rotate(req.KeyType, wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("rotate key: %w", err)
+	}
 
-	if req.KeyID != "" {
-		opts = append(opts, kms.WithKeyID(req.KeyID))
+	pub, err := exportPubKeyBytes(ks, kid)
+	if err != nil {
+		return err
 	}
 
-	kid, _, err := ks.ImportPrivateKey(privateKey, req.KeyType, opts...)
+	seq, err := c.incrementSequence(wr.KeyStoreID, moveKeyAlias(wr.KeyID, kid), moveVerificationMethods(wr.KeyID, kid),
+		addKeyID(kid, req.KeyType, c.clock.Now().UTC()), setKeyFingerprint(kid, pub), setKeyExpiry(kid, req.ExpiresAt),
+		copyKeyPurposes(wr.KeyID, kid), setKeyHash(kid, req.KeyType, meta.Keys[wr.KeyID].Hash), removeKeyID(wr.KeyID))
 	if err != nil {
-		return fmt.Errorf("import private key: %w", err)
+		return fmt.Errorf("increment sequence: %w", err)
 	}
 
-	return json.NewEncoder(w).Encode(ImportKeyResponse{
-		KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+	return json.NewEncoder(w).Encode(RotateKeyResponse{
+		KeyURL:    fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+		PublicKey: pub,
+		Sequence:  seq,
 	})
 }
 
-// RotateKey rotate key.
-func (c *Command) RotateKey(w io.Writer, r io.Reader) error {
-	var req RotateKeyRequest
+// exportPubKeyBytes exports public key bytes of the primary key in the keyset. Returns nil for symmetric keys.
+func exportPubKeyBytes(ks kms.KeyManager, kid string) ([]byte, error) {
+	pub, _, err := ks.ExportPubKeyBytes(kid)
+	if err != nil {
+		if !strings.Contains(err.Error(), "failed to get public keyset handle") {
+			return nil, fmt.Errorf("export public key bytes: %w", err)
+		}
+	}
 
-	wr, err := unwrapRequest(&req, r)
+	return pub, nil
+}
+
+// Sign signs a message.
+func (c *Command) Sign(w io.Writer, r io.Reader) error {
+	var req SignRequest
+
+	wr, err := c.unwrapRequest(&req, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
 
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
-	if err != nil {
-		return fmt.Errorf("resolve key store: %w", err)
+	if err = checkSignatureEncoding(req.ResponseEncoding); err != nil {
+		return err
 	}
 
-	kid, _, err := ks.Rotate(req.KeyType, wr.KeyID)
+	if err = req.checkPrehashed(); err != nil {
+		return err
+	}
+
+	message := req.Message
+
+	if len(req.Messages) > 0 {
+		if len(req.Message) > 0 || len(req.Document) > 0 || req.Canonicalization != "" {
+			return fmt.Errorf("%w: messages can't be combined with message or document", errors.ErrValidation)
+		}
+
+		if req.Deterministic {
+			return fmt.Errorf("%w: messages can't be signed deterministically", errors.ErrValidation)
+		}
+
+		// a nonce is bound to all the messages
+		if message, err = json.Marshal(req.Messages); err != nil {
+			return fmt.Errorf("marshal messages: %w", err)
+		}
+	}
+
+	if req.Canonicalization != "" {
+		if c.canonicalizer == nil {
+			return fmt.Errorf("%w: canonicalization is disabled", errors.ErrValidation)
+		}
+
+		if len(req.Document) == 0 {
+			return fmt.Errorf("%w: document is required with canonicalization", errors.ErrValidation)
+		}
+
+		// a nonce is bound to both the profile and the document
+		message = append([]byte(req.Canonicalization+":"), req.Document...)
+	} else if len(req.Document) > 0 {
+		return fmt.Errorf("%w: canonicalization is required with document", errors.ErrValidation)
+	}
+
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeSign, wr)
 	if err != nil {
-		return fmt.Errorf("rotate key: %w", err)
+		return err
 	}
 
-	return json.NewEncoder(w).Encode(RotateKeyResponse{
-		KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
-	})
-}
+	signData := c.signer(wr)
 
-// Sign signs a message.
-func (c *Command) Sign(w io.Writer, r io.Reader) error {
-	var req SignRequest
+	if req.Deterministic {
+		if signData, err = c.deterministicSign(wr); err != nil {
+			return err
+		}
+
+		// a nonce is bound to the mode, a retry can't change it
+		message = append([]byte("deterministic:"), message...)
+	}
+
+	resp := SignResponse{
+		Canonicalization: req.Canonicalization,
+		Deterministic:    req.Deterministic,
+		Encoding:         req.ResponseEncoding,
+	}
+
+	if wr.Details {
+		if resp.Details, err = signatureDetails(kh, req.ResponseEncoding); err != nil {
+			return err
+		}
+
+		resp.Details.hashed(wr.keyHash)
+	}
+
+	if req.Prehashed {
+		if err = checkDefaultKeyHash(wr, "prehashed signatures"); err != nil {
+			return err
+		}
+
+		algorithm, algErr := prehashAlgorithm(wr, kh, req.Message)
+		if algErr != nil {
+			return algErr
+		}
+
+		signData = prehash.SignKeyset
+		message = append([]byte("prehashed:"), message...)
+
+		resp.Prehashed = true
+		resp.Algorithm = string(algorithm)
+		resp.Verification = algorithm.Verification()
+
+		if resp.Details != nil {
+			resp.Details.prehashed(algorithm)
+		}
+	}
+
+	sign := func() ([]byte, error) {
+		data := req.Message
+
+		if req.Canonicalization != "" {
+			digest, canonicalizeErr := c.canonicalize(req.Canonicalization, req.Document)
+			if canonicalizeErr != nil {
+				return nil, canonicalizeErr
+			}
+
+			data = digest
+		}
+
+		signStartTime := c.clock.Now()
+
+		var signature []byte
+
+		signErr := c.runCrypto(wr, func() error {
+			var opErr error
+
+			if len(req.Messages) > 0 {
+				signature, opErr = c.crypto.SignMulti(req.Messages, kh)
+			} else {
+				signature, opErr = signData(data, kh)
+			}
+
+			return opErr
+		})
+		if stderrors.Is(signErr, rfc6979.ErrNotECDSAKey) {
+			return nil, fmt.Errorf("%w: key %s can't sign deterministically: %s", errors.ErrUnprocessableEntity,
+				wr.KeyID, signErr)
+		}
+
+		if signErr != nil {
+			return nil, fmt.Errorf("sign: %w", signErr)
+		}
+
+		c.metrics.CryptoSignTime(c.clock.Now().Sub(signStartTime))
+
+		return signature, nil
+	}
+
+	if req.Nonce == "" || c.signNonces == nil {
+		signature, signErr := sign()
+		if signErr != nil {
+			return signErr
+		}
+
+		resp.Signature = signature
+
+		return json.NewEncoder(w).Encode(resp)
+	}
+
+	// a retried request returns the saved signature, it's neither signed nor counted again
+	signature, replayed, err := c.signNonces.Sign(wr.KeyStoreID, wr.KeyID, req.Nonce, message, sign)
+	if stderrors.Is(err, signnonce.ErrMismatch) {
+		return fmt.Errorf("%w: %s", errors.ErrUnprocessableEntity, err.Error())
+	}
 
-	kh, err := c.getKeyHandle(&req, r)
 	if err != nil {
 		return err
 	}
 
-	signStartTime := time.Now()
+	// usage reports skip responses with the header
+	if h, ok := w.(http.ResponseWriter); ok && replayed {
+		h.Header().Set(signnonce.ReplayedHeader, "true")
+	}
+
+	resp.Signature = signature
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// deterministicSign returns the function that signs with RFC 6979 nonces with the key of the request. The crypto
+// already signs deterministically with secp256k1 keys, NIST ECDSA keys are signed from the keyset.
+func (c *Command) deterministicSign(wr *WrappedRequest) (func([]byte, interface{}) ([]byte, error), error) {
+	if wr.keyHash != "" {
+		hash := wr.keyHash
+
+		return func(msg []byte, kh interface{}) ([]byte, error) {
+			return ecdsahash.SignKeyset(msg, kh, hash, true)
+		}, nil
+	}
+
+	switch wr.keyType {
+	case kms.ECDSAP256TypeDER, kms.ECDSAP384TypeDER, kms.ECDSAP521TypeDER,
+		kms.ECDSAP256TypeIEEEP1363, kms.ECDSAP384TypeIEEEP1363, kms.ECDSAP521TypeIEEEP1363:
+		return rfc6979.SignKeyset, nil
+	case secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363:
+		return c.crypto.Sign, nil
+	case "":
+		// key types aren't recorded in the metadata of keys created by older versions, the keyset is checked instead
+		return rfc6979.SignKeyset, nil
+	default:
+		return nil, fmt.Errorf("%w: key %s of type %s can't sign deterministically, an ecdsa key is required",
+			errors.ErrUnprocessableEntity, wr.KeyID, wr.keyType)
+	}
+}
+
+// canonicalize transforms the document with the profile and returns a SHA-256 digest of the result.
+func (c *Command) canonicalize(profile string, doc []byte) ([]byte, error) {
+	startTime := c.clock.Now()
+
+	canonical, err := c.canonicalizer.Canonicalize(profile, doc)
+	if stderrors.Is(err, canonicalization.ErrProfileDisabled) || stderrors.Is(err, canonicalization.ErrInvalidDocument) {
+		return nil, fmt.Errorf("%w: %s", errors.ErrValidation, err.Error())
+	}
 
-	signature, err := c.crypto.Sign(req.Message, kh)
 	if err != nil {
-		return fmt.Errorf("sign: %w", err)
+		return nil, fmt.Errorf("canonicalize: %w", err)
 	}
 
-	c.metrics.CryptoSignTime(time.Since(signStartTime))
+	c.metrics.CryptoCanonicalizeTime(profile, c.clock.Now().Sub(startTime))
 
-	return json.NewEncoder(w).Encode(SignResponse{Signature: signature})
+	digest := sha256.Sum256(canonical)
+
+	return digest[:], nil
 }
 
-// Verify verifies a signature.
-func (c *Command) Verify(_ io.Writer, r io.Reader) error {
+// runCrypto runs the crypto operation with the key of the request in the worker pool of the cost class of the key. It
+// fails with ErrTooManyRequests if the pool can't queue the operation.
+func (c *Command) runCrypto(wr *WrappedRequest, op func() error) error {
+	if c.cryptoPools == nil {
+		return op()
+	}
+
+	err := c.cryptoPools.Do(wr.keyType, op)
+	if stderrors.Is(err, cryptopool.ErrQueueFull) {
+		return fmt.Errorf("%w: %s", errors.ErrTooManyRequests, err.Error())
+	}
+
+	return err
+}
+
+// Verify verifies a signature. With a public key in the request, the signature is verified with it instead of a key
+// of the key store, and the response tells whether it verified.
+func (c *Command) Verify(w io.Writer, r io.Reader) error {
 	var req VerifyRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = req.decodeSignature(); err != nil {
+		return err
+	}
+
+	if req.hasPublicKey() {
+		return c.verifyWithPublicKey(w, wr, &req)
+	}
+
+	if len(req.Messages) > 0 {
+		return c.verifyMessages(w, wr, &req)
+	}
+
+	keyVersion, err := c.verifyCacheKeyVersion(wr)
+	if err != nil {
+		return err
+	}
+
+	// the key is resolved before the cache is checked, so that cached results are only returned to callers
+	// authorized to use the key
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeVerify, wr)
 	if err != nil {
 		return err
 	}
 
+	// a cached result has no details of the verification
+	if keyVersion != "" && !wr.Details {
+		if valid, ok := c.verifyCache.Get(keyVersion, req.Message, req.Signature); ok {
+			c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
+			if !valid {
+				return fmt.Errorf("verify: %w", verifycache.ErrInvalidSignature)
+			}
+
+			return nil
+		}
+	}
+
 	pub, err := kh.(*keyset.Handle).Public()
 	if err != nil {
 		return fmt.Errorf("verify: %w", err)
 	}
 
-	if err = c.crypto.Verify(req.Signature, req.Message, pub); err != nil {
+	var invalid error
+
+	err = c.runCrypto(wr, func() error {
+		invalid = c.verifySignature(wr, req.Signature, req.Message, pub)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = invalid
+
+	if keyVersion != "" {
+		c.verifyCache.Set(keyVersion, req.Message, req.Signature, err == nil)
+	}
+
+	if err != nil {
 		return fmt.Errorf("verify: %w", err)
 	}
 
-	return nil
+	return c.writeVerifyDetails(w, wr, pub, req.SignatureEncoding)
+}
+
+// writeVerifyDetails writes a response with details of the verification with the key handle, if requested.
+// Responses of verifications with keys of the key store have no body otherwise.
+func (c *Command) writeVerifyDetails(w io.Writer, wr *WrappedRequest, kh interface{}, encoding string) error {
+	if !wr.Details {
+		return nil
+	}
+
+	details, err := signatureDetails(kh, encoding)
+	if err != nil {
+		return err
+	}
+
+	details.hashed(wr.keyHash)
+
+	return json.NewEncoder(w).Encode(VerifyResponse{Verified: true, Details: details})
+}
+
+// verifyMessages verifies a BBS+ signature of messages. Results aren't cached.
+func (c *Command) verifyMessages(w io.Writer, wr *WrappedRequest, req *VerifyRequest) error {
+	if len(req.Message) > 0 {
+		return fmt.Errorf("%w: messages can't be combined with message", errors.ErrValidation)
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeVerify, wr)
+	if err != nil {
+		return err
+	}
+
+	pub, err := publicKeyHandle(kh)
+	if err != nil {
+		return err
+	}
+
+	if err = c.runCrypto(wr, func() error {
+		return c.crypto.VerifyMulti(req.Messages, req.Signature, pub)
+	}); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	return c.writeVerifyDetails(w, wr, pub, req.SignatureEncoding)
+}
+
+// verifyCacheKeyVersion returns a version of the key used in verify cache keys, or an empty string if the
+// verify cache is disabled for the key store.
+func (c *Command) verifyCacheKeyVersion(wr *WrappedRequest) (string, error) {
+	if c.verifyCache == nil {
+		return "", nil
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return "", fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if !meta.VerifyCache {
+		return "", nil
+	}
+
+	wr.KeyID = meta.keyID(wr.KeyID)
+
+	// sequence changes on every mutation of the key store (e.g. key rotation), invalidating cached results
+	return fmt.Sprintf("%s/%s@%d", wr.KeyStoreID, wr.KeyID, meta.Sequence), nil
 }
 
-// Encrypt encrypts a message.
+// Encrypt encrypts a message. The nonce is generated by the server and returned with the ciphertext, unless caller
+// nonces are enabled and the request sets one.
 func (c *Command) Encrypt(w io.Writer, r io.Reader) error {
 	var req EncryptRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if req.Nonce != nil && c.callerNonces == nil {
+		return fmt.Errorf("%w: caller nonces are not enabled, the server generates the nonce", errors.ErrValidation)
+	}
+
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeEncrypt, wr)
 	if err != nil {
 		return err
 	}
 
+	if req.Nonce != nil {
+		return c.encryptWithCallerNonce(w, wr, &req, kh)
+	}
+
 	cipher, nonce, err := c.crypto.Encrypt(req.Message, req.AssociatedData, kh)
 	if err != nil {
 		return fmt.Errorf("encrypt: %w", err)
@@ -362,14 +962,23 @@ func (c *Command) Encrypt(w io.Writer, r io.Reader) error {
 func (c *Command) Decrypt(w io.Writer, r io.Reader) error {
 	var req DecryptRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeDecrypt, wr)
 	if err != nil {
 		return err
 	}
 
+	if err = validateNonce(wr.keyType, req.Nonce); err != nil {
+		return err
+	}
+
 	plain, err := c.crypto.Decrypt(req.Ciphertext, req.AssociatedData, req.Nonce, kh)
 	if err != nil {
-		return fmt.Errorf("decrypt: %w", err)
+		return fmt.Errorf("decrypt: %w", c.decryptionFailed(wr, err))
 	}
 
 	return json.NewEncoder(w).Encode(DecryptResponse{Plaintext: plain})
@@ -379,7 +988,7 @@ func (c *Command) Decrypt(w io.Writer, r io.Reader) error {
 func (c *Command) ComputeMAC(w io.Writer, r io.Reader) error {
 	var req ComputeMACRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	kh, err := c.getActiveKeyHandle(KeyPurposeComputeMAC, &req, r)
 	if err != nil {
 		return err
 	}
@@ -396,7 +1005,7 @@ func (c *Command) ComputeMAC(w io.Writer, r io.Reader) error {
 func (c *Command) VerifyMAC(_ io.Writer, r io.Reader) error {
 	var req VerifyMACRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	kh, err := c.getKeyHandle(KeyPurposeVerifyMAC, &req, r)
 	if err != nil {
 		return err
 	}
@@ -412,12 +1021,23 @@ func (c *Command) VerifyMAC(_ io.Writer, r io.Reader) error {
 func (c *Command) SignMulti(w io.Writer, r io.Reader) error {
 	var req SignMultiRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeSign, wr)
 	if err != nil {
 		return err
 	}
 
-	signature, err := c.crypto.SignMulti(req.Messages, kh)
+	var signature []byte
+
+	err = c.runCrypto(wr, func() error {
+		signature, err = c.crypto.SignMulti(req.Messages, kh)
+
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("sign multi: %w", err)
 	}
@@ -429,12 +1049,24 @@ func (c *Command) SignMulti(w io.Writer, r io.Reader) error {
 func (c *Command) VerifyMulti(_ io.Writer, r io.Reader) error {
 	var req VerifyMultiRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeVerify, wr)
 	if err != nil {
 		return err
 	}
 
-	if err = c.crypto.VerifyMulti(req.Messages, req.Signature, kh); err != nil {
+	pub, err := publicKeyHandle(kh)
+	if err != nil {
+		return err
+	}
+
+	if err = c.runCrypto(wr, func() error {
+		return c.crypto.VerifyMulti(req.Messages, req.Signature, pub)
+	}); err != nil {
 		return fmt.Errorf("verify multi: %w", err)
 	}
 
@@ -445,12 +1077,32 @@ func (c *Command) VerifyMulti(_ io.Writer, r io.Reader) error {
 func (c *Command) DeriveProof(w io.Writer, r io.Reader) error {
 	var req DeriveProofRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
 	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateRevealedIndexes(req.RevealedIndexes, len(req.Messages)); err != nil {
 		return err
 	}
 
-	proof, err := c.crypto.DeriveProof(req.Messages, req.Signature, req.Nonce, req.RevealedIndexes, kh)
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeDeriveProof, wr)
+	if err != nil {
+		return err
+	}
+
+	pub, err := publicKeyHandle(kh)
+	if err != nil {
+		return err
+	}
+
+	var proof []byte
+
+	err = c.runCrypto(wr, func() error {
+		proof, err = c.crypto.DeriveProof(req.Messages, req.Signature, req.Nonce, req.RevealedIndexes, pub)
+
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("derive proof: %w", err)
 	}
@@ -458,16 +1110,48 @@ func (c *Command) DeriveProof(w io.Writer, r io.Reader) error {
 	return json.NewEncoder(w).Encode(DeriveProofResponse{Proof: proof})
 }
 
+// validateRevealedIndexes fails with ErrUnprocessableEntity if an index is out of range of the messages or repeated.
+func validateRevealedIndexes(indexes []int, messages int) error {
+	seen := make(map[int]struct{}, len(indexes))
+
+	for _, i := range indexes {
+		if i < 0 || i >= messages {
+			return fmt.Errorf("%w: revealed index %d is out of range of %d messages", errors.ErrUnprocessableEntity,
+				i, messages)
+		}
+
+		if _, ok := seen[i]; ok {
+			return fmt.Errorf("%w: revealed index %d is duplicated", errors.ErrUnprocessableEntity, i)
+		}
+
+		seen[i] = struct{}{}
+	}
+
+	return nil
+}
+
 // VerifyProof verifies a BBS+ signature proof for revealed messages.
 func (c *Command) VerifyProof(_ io.Writer, r io.Reader) error {
 	var req VerifyProofRequest
 
-	kh, err := c.getKeyHandle(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeVerify, wr)
+	if err != nil {
+		return err
+	}
+
+	pub, err := publicKeyHandle(kh)
 	if err != nil {
 		return err
 	}
 
-	if err = c.crypto.VerifyProof(req.Messages, req.Proof, req.Nonce, kh); err != nil {
+	if err = c.runCrypto(wr, func() error {
+		return c.crypto.VerifyProof(req.Messages, req.Proof, req.Nonce, pub)
+	}); err != nil {
 		return fmt.Errorf("verify proof: %w", err)
 	}
 
@@ -476,9 +1160,14 @@ func (c *Command) VerifyProof(_ io.Writer, r io.Reader) error {
 
 // easy seals a payload.
 func (c *Command) easy(w io.Writer, wr *WrappedRequest, req *EasyRequest) error {
-	cryptoBox, err := c.getCryptoBox(wr.KeyStoreID, wr.User, wr.SecretShare)
+	ks, err := c.resolveKeyStoreForActiveKey(wr, KeyPurposeWrap)
 	if err != nil {
-		return err
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	cryptoBox, err := c.cryptoBox.Create(ks)
+	if err != nil {
+		return fmt.Errorf("create crypto box: %w", err)
 	}
 
 	ciphertext, err := cryptoBox.Easy(req.Payload, req.Nonce, req.TheirPub, wr.KeyID)
@@ -486,6 +1175,8 @@ func (c *Command) easy(w io.Writer, wr *WrappedRequest, req *EasyRequest) error
 		return fmt.Errorf("easy: %w", err)
 	}
 
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
 	return json.NewEncoder(w).Encode(EasyResponse{Ciphertext: ciphertext})
 }
 
@@ -513,7 +1204,7 @@ func (c *Command) sealOpen(w io.Writer, req *SealOpenRequest, cryptoBox CryptoBo
 func (c *Command) WrapKey(w io.Writer, r io.Reader) error {
 	var req WrapKeyRequest
 
-	wr, err := unwrapRequest(nil, r)
+	wr, err := c.unwrapRequest(nil, r)
 	if err != nil {
 		return fmt.Errorf("unwrap wrap request: %w", err)
 	}
@@ -536,10 +1227,23 @@ func (c *Command) WrapKey(w io.Writer, r io.Reader) error {
 		return nil
 	}
 
+	if req.RecipientPubKey == nil {
+		return fmt.Errorf("%w: recipient_pub_key is required", errors.ErrValidation)
+	}
+
+	if req.SenderKID != "" {
+		if wr.KeyID != "" && wr.KeyID != req.SenderKID {
+			return fmt.Errorf("%w: sender_kid %s doesn't match key %s of the request", errors.ErrValidation,
+				req.SenderKID, wr.KeyID)
+		}
+
+		wr.KeyID = req.SenderKID
+	}
+
 	var opts []crypto.WrapKeyOpts
 
 	if wr.KeyID != "" {
-		ks, resolveErr := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+		ks, resolveErr := c.resolveKeyStoreForActiveKey(wr, KeyPurposeWrap)
 		if resolveErr != nil {
 			return fmt.Errorf("resolve key store: %w", resolveErr)
 		}
@@ -549,6 +1253,8 @@ func (c *Command) WrapKey(w io.Writer, r io.Reader) error {
 			return fmt.Errorf("get key %s: %w", wr.KeyID, getErr)
 		}
 
+		c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
 		opts = append(opts, crypto.WithSender(kh))
 
 		if req.Tag != nil {
@@ -568,7 +1274,7 @@ func (c *Command) WrapKey(w io.Writer, r io.Reader) error {
 func (c *Command) UnwrapKey(w io.Writer, r io.Reader) error {
 	var req UnwrapKeyRequest
 
-	wr, err := unwrapRequest(nil, r)
+	wr, err := c.unwrapRequest(nil, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
@@ -604,7 +1310,7 @@ func (c *Command) UnwrapKey(w io.Writer, r io.Reader) error {
 		return fmt.Errorf("unwrapKey cryptobox request invalid: %w", err)
 	}
 
-	kh, err := c.getKeyHandleFromRequest(wr)
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeUnwrap, wr)
 	if err != nil {
 		return err
 	}
@@ -627,43 +1333,70 @@ func (c *Command) UnwrapKey(w io.Writer, r io.Reader) error {
 	return json.NewEncoder(w).Encode(UnwrapKeyResponse{Key: k})
 }
 
-func (c *Command) getKeyHandle(req interface{}, r io.Reader) (interface{}, error) {
-	wr, err := unwrapRequest(req, r)
+// publicKeyHandle returns the public keyset handle of the key. BBS+ verification and proof derivation take the public
+// key; the private keyset handle isn't a verifier primitive.
+func publicKeyHandle(kh interface{}) (interface{}, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok || h == nil {
+		return kh, nil
+	}
+
+	pub, err := h.Public()
 	if err != nil {
-		return nil, fmt.Errorf("unwrap request: %w", err)
+		return nil, fmt.Errorf("get public keyset handle: %w", err)
 	}
 
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	return pub, nil
+}
+
+func (c *Command) getKeyHandle(purpose KeyPurpose, req interface{}, r io.Reader) (interface{}, error) {
+	wr, err := c.unwrapRequest(req, r)
 	if err != nil {
-		return nil, fmt.Errorf("resolve key store: %w", err)
+		return nil, fmt.Errorf("unwrap request: %w", err)
 	}
 
-	getStartTime := time.Now()
+	return c.getKeyHandleFromRequest(purpose, wr)
+}
 
-	kh, err := ks.Get(wr.KeyID)
+// getActiveKeyHandle is like getKeyHandle, but fails with KeyDisabledError if the key is disabled.
+func (c *Command) getActiveKeyHandle(purpose KeyPurpose, req interface{}, r io.Reader) (interface{}, error) {
+	wr, err := c.unwrapRequest(req, r)
 	if err != nil {
-		return nil, fmt.Errorf("get key: %w", err)
+		return nil, fmt.Errorf("unwrap request: %w", err)
 	}
 
-	c.metrics.KeyStoreGetKeyTime(time.Since(getStartTime))
+	return c.getActiveKeyHandleFromRequest(purpose, wr)
+}
 
-	return kh, nil
+// getKeyHandleFromRequest returns the key of the request if the key is allowed for the purpose. A key alias in the
+// request is replaced with the key ID.
+func (c *Command) getKeyHandleFromRequest(purpose KeyPurpose, wr *WrappedRequest) (interface{}, error) {
+	return c.resolveKeyHandle(wr, purpose, c.resolveKeyStoreForPurpose)
 }
 
-func (c *Command) getKeyHandleFromRequest(wr *WrappedRequest) (interface{}, error) {
-	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+// getActiveKeyHandleFromRequest is like getKeyHandleFromRequest, but fails with KeyDisabledError if the key is
+// disabled.
+func (c *Command) getActiveKeyHandleFromRequest(purpose KeyPurpose, wr *WrappedRequest) (interface{}, error) {
+	return c.resolveKeyHandle(wr, purpose, c.resolveKeyStoreForActiveKey)
+}
+
+func (c *Command) resolveKeyHandle(wr *WrappedRequest, purpose KeyPurpose,
+	resolve func(wr *WrappedRequest, purpose KeyPurpose) (kms.KeyManager, error)) (interface{}, error) {
+	ks, err := resolve(wr, purpose)
 	if err != nil {
 		return nil, fmt.Errorf("resolve key store: %w", err)
 	}
 
-	getStartTime := time.Now()
+	getStartTime := c.clock.Now()
 
 	kh, err := ks.Get(wr.KeyID)
 	if err != nil {
-		return nil, fmt.Errorf("get key: %w", err)
+		return nil, fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
 	}
 
-	c.metrics.KeyStoreGetKeyTime(time.Since(getStartTime))
+	c.metrics.KeyStoreGetKeyTime(c.clock.Now().Sub(getStartTime))
+
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
 
 	return kh, nil
 }
@@ -682,13 +1415,25 @@ func (c *Command) getCryptoBox(keyStoreID, user string, secretShare []byte) (Cry
 	return cryptoBox, nil
 }
 
-func unwrapRequest(req interface{}, r io.Reader) (*WrappedRequest, error) {
+// unwrapRequest decodes the wrapped request and the request into req, if not nil. Requests that exceed the request
+// limits are rejected before they're decoded, also if req is nil and the request is decoded by the caller.
+func (c *Command) unwrapRequest(req interface{}, r io.Reader) (*WrappedRequest, error) {
 	var wr WrappedRequest
 
 	if err := json.NewDecoder(r).Decode(&wr); err != nil {
 		return nil, fmt.Errorf("%w: decode wrapped request", errors.ErrInternal)
 	}
 
+	if err := jsonlimit.Check(wr.Request, c.requestLimits); err != nil {
+		var limitErr *jsonlimit.LimitError
+
+		if stderrors.As(err, &limitErr) {
+			c.metrics.RequestLimitRejection(limitErr.Limit)
+		}
+
+		return nil, fmt.Errorf("%w: request body: %s", errors.ErrBadRequest, err)
+	}
+
 	if req != nil {
 		if err := json.Unmarshal(wr.Request, req); err != nil {
 			return nil, fmt.Errorf("%w: decode request", errors.ErrInternal)
@@ -699,62 +1444,161 @@ func unwrapRequest(req interface{}, r io.Reader) (*WrappedRequest, error) {
 }
 
 func (c *Command) resolveKeyStore(keyStoreID, user string, secretShare []byte) (kms.KeyManager, error) {
-	startTime := time.Now()
-	defer func() { c.metrics.KeyStoreResolveTime(time.Since(startTime)) }()
+	ks, _, err := c.resolveKeyStoreWithStorage(keyStoreID, user, secretShare)
+
+	return ks, err
+}
 
-	b, err := c.store.Get(keyStoreID)
+// resolveKeyStoreForKey resolves the key store of the request and replaces a key alias or DID URL in the request, if
+// any, with the key ID. It fails with UnknownDIDURLError if the DID URL isn't registered for a key of the key store.
+func (c *Command) resolveKeyStoreForKey(wr *WrappedRequest) (kms.KeyManager, error) {
+	ks, meta, _, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
 	if err != nil {
-		return nil, fmt.Errorf("get key store meta: %w", err)
+		return nil, err
 	}
 
-	var meta keyStoreMeta
+	if wr.KeyID, err = meta.resolveKeyRef(wr.KeyID); err != nil {
+		return nil, err
+	}
+
+	wr.keyType, wr.keyHash = meta.Keys[wr.KeyID].KeyType, meta.Keys[wr.KeyID].Hash
+
+	return ks, nil
+}
 
-	if err = json.Unmarshal(b, &meta); err != nil {
-		return nil, fmt.Errorf("unmarshal key store meta: %w", err)
+// resolveKeyStoreForPurpose is like resolveKeyStoreForKey, but fails with KeyPurposeError if the key isn't allowed for
+// the purpose.
+func (c *Command) resolveKeyStoreForPurpose(wr *WrappedRequest, purpose KeyPurpose) (kms.KeyManager, error) {
+	ks, meta, _, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return nil, err
 	}
 
-	var storageProvider storage.Provider
+	if wr.KeyID, err = meta.resolveKeyRef(wr.KeyID); err != nil {
+		return nil, err
+	}
 
-	if meta.EDV.VaultURL != "" {
-		storageProvider, err = c.resolveEDVProvider(meta.EDV.VaultURL, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID,
-			meta.EDV.Capability)
-		if err != nil {
-			return nil, fmt.Errorf("resolve edv provider: %w", err)
-		}
+	wr.keyType, wr.keyHash = meta.Keys[wr.KeyID].KeyType, meta.Keys[wr.KeyID].Hash
 
-		storageProvider = metrics.Wrap(storageProvider, "EDV")
-	} else {
-		storageProvider = c.keyStorageProvider
+	if err = c.checkKeyPurpose(wr.KeyStoreID, wr.KeyID, purpose, meta); err != nil {
+		return nil, err
 	}
 
-	if c.cacheProvider != nil && c.keyStoreCacheTTL > 0 {
-		storageProvider = c.cacheProvider.Wrap(storageProvider, c.keyStoreCacheTTL)
+	return ks, nil
+}
+
+// resolveKeyStoreWithStorage resolves the key store and returns it along with the storage provider of its keys.
+func (c *Command) resolveKeyStoreWithStorage(keyStoreID, user string,
+	secretShare []byte) (kms.KeyManager, storage.Provider, error) {
+	ks, _, storageProvider, err := c.resolveKeyStoreWithMeta(keyStoreID, user, secretShare)
+
+	return ks, storageProvider, err
+}
+
+// resolveKeyStoreWithMeta resolves the key store and returns it along with its metadata and the storage provider of
+// its keys.
+func (c *Command) resolveKeyStoreWithMeta(keyStoreID, user string,
+	secretShare []byte) (kms.KeyManager, *keyStoreMeta, storage.Provider, error) {
+	startTime := c.clock.Now()
+	defer func() { c.metrics.KeyStoreResolveTime(c.clock.Now().Sub(startTime)) }()
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	storageProvider, err := c.keyStorage(meta)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	var secretLock secretlock.Service
 
 	if c.shamirProvider != nil {
-		secretLock, err = c.createShamirSecretLock(user, secretShare)
+		secretLock, err = c.createShamirSecretLock(meta.SecretShareScheme, user, secretShare)
 		if err != nil {
-			return nil, fmt.Errorf("create shamir secret lock: %w", err)
+			return nil, nil, nil, fmt.Errorf("create shamir secret lock: %w", err)
 		}
 	} else {
 		secretLock = key.NewLock(&keyLockProvider{
 			kms:    c.kms,
 			crypto: c.crypto,
-		})
-	}
-
-	keyID := meta.MainKeyID
-
-	if keyID == "" {
-		keyID = "noop"
+		}).WithLogFields(logFields(meta))
 	}
 
-	return c.keyStoreCreator.Create(localKeyURIPrefix+keyID, &keyStoreProvider{
+	ks, err := c.keyStoreCreator.Create(localKeyURIPrefix+mainKeyIDOrNoop(meta.MainKeyID), &keyStoreProvider{
 		storageProvider: storageProvider,
 		secretLock:      secretLock,
 	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return hideDeletedKeys(ks, meta), meta, storageProvider, nil
+}
+
+// ephemeralKeyStore returns a key store kept in memory for the duration of a request, e.g. to get handles of public
+// keys that aren't stored in the KMS. It's created with the user's key manager creator, so that it supports the same
+// key types as the key stores of the server.
+func (c *Command) ephemeralKeyStore() (kms.KeyManager, error) {
+	ks, err := c.keyStoreCreator.Create(localKeyURIPrefix+mainKeyIDOrNoop(""), &keyStoreProvider{
+		storageProvider: mem.NewProvider(),
+		secretLock:      &noop.NoLock{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create key store: %w", err)
+	}
+
+	return ks, nil
+}
+
+// KeyStoreController returns the controller of the key store, e.g. to add its hash to log lines of a request.
+func (c *Command) KeyStoreController(keyStoreID string) (string, error) {
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return "", err
+	}
+
+	return meta.Controller, nil
+}
+
+// logFields returns the fields of log lines written while the key store is used.
+func logFields(meta *keyStoreMeta) reqlog.Fields {
+	return reqlog.Fields{KeyStoreID: meta.ID, Controller: reqlog.HashController(meta.Controller)}
+}
+
+// keyStorage returns the storage provider of keys of the key store: the user's vault for EDV-backed key stores, or
+// the server's key storage.
+func (c *Command) keyStorage(meta *keyStoreMeta) (storage.Provider, error) {
+	var storageProvider storage.Provider
+
+	if meta.EDV.VaultURL != "" {
+		edvProvider, err := c.resolveEDVProvider(meta.EDV.VaultURL, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID,
+			meta.EDV.Capability)
+		if err != nil {
+			return nil, fmt.Errorf("resolve edv provider: %w", err)
+		}
+
+		storageProvider = metrics.Wrap(edvProvider, "EDV").WithLogFields(logFields(meta))
+	} else {
+		storageProvider = c.keyStorageProvider
+	}
+
+	if ttl := c.keyStoreCacheTTLOf(meta); c.cacheProvider != nil && ttl > 0 {
+		storageProvider = c.cacheProvider.Wrap(storageProvider, meta.ID, ttl)
+	}
+
+	return storageProvider, nil
+}
+
+// mainKeyIDOrNoop returns the ID of the server key that protects keys of the key store. Key stores protected with
+// Shamir secret shares have no main key.
+func mainKeyIDOrNoop(mainKeyID string) string {
+	if mainKeyID == "" {
+		return "noop"
+	}
+
+	return mainKeyID
 }
 
 func (c *Command) resolveEDVProvider(vaultURL, recKeyID, macKeyID string, capability []byte) (storage.Provider, error) {
@@ -0,0 +1,71 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
+
+// aesGCMKeyTypes are AES-GCM key types by size in bits.
+var aesGCMKeyTypes = map[int]kms.KeyType{ //nolint:gochecknoglobals
+	128: kms.AES128GCMType,
+	256: kms.AES256GCMType,
+}
+
+func isAESGCMKeyType(kt kms.KeyType) bool {
+	return kt == kms.AES128GCMType || kt == kms.AES256GCMType
+}
+
+// keyTypeOfSize returns the key type of the requested size. The size of AES-GCM keys selects the key type, e.g. an
+// AES256GCM key with a key_size of 128 is an AES128GCM key.
+func keyTypeOfSize(kt kms.KeyType, size int) kms.KeyType {
+	if isAESGCMKeyType(kt) && size != 0 {
+		return aesGCMKeyTypes[size]
+	}
+
+	return kt
+}
+
+// encryptWithCallerNonce encrypts the message of the request under the nonce of the request. Caller nonces are only
+// accepted for AES-GCM keys, and a nonce used twice with the key within the reuse window is logged as a security
+// warning: GCM loses confidentiality and authenticity under a reused nonce.
+func (c *Command) encryptWithCallerNonce(w io.Writer, wr *WrappedRequest, req *EncryptRequest,
+	kh interface{}) error {
+	cipher, err := aesgcm.EncryptKeyset(req.Message, req.AssociatedData, req.Nonce, kh)
+	if stderrors.Is(err, aesgcm.ErrUnsupportedKey) {
+		return fmt.Errorf("%w: key %s of type %s can't encrypt with a caller nonce, an AES-GCM key is required",
+			errors.ErrUnprocessableEntity, wr.KeyID, wr.keyType)
+	}
+
+	if stderrors.Is(err, aesgcm.ErrNonceSize) {
+		return fmt.Errorf("%w: %s", errors.ErrValidation, err.Error())
+	}
+
+	if err != nil {
+		return fmt.Errorf("encrypt: %w", err)
+	}
+
+	if c.callerNonces.Seen(wr.KeyStoreID, wr.KeyID, req.Nonce) {
+		logger.Ctx(reqlog.WithKeyStore(context.Background(), wr.KeyStoreID, c.KeyStoreController)).Warnf(
+			"SECURITY: nonce reused with AES-GCM key %s of key store %s", wr.KeyID, wr.KeyStoreID)
+	}
+
+	return json.NewEncoder(w).Encode(EncryptResponse{
+		Ciphertext: cipher,
+		Nonce:      req.Nonce,
+	})
+}
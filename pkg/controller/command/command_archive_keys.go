@@ -0,0 +1,153 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+)
+
+// ArchiveColdKeys moves keys that weren't used for the period to archive storage. A key is archived if its last use,
+// or its creation if it wasn't used since usage tracking started, is older than the period. It returns the number of
+// archived keys. A key store that fails to be archived doesn't stop archival of others; the last error is returned.
+//
+// Keys of EDV-backed key stores are stored in users' vaults and are never archived, nor are deleted keys that wait
+// to be purged.
+func (c *Command) ArchiveColdKeys(period time.Duration) (int, error) {
+	if c.keyArchive == nil {
+		return 0, nil
+	}
+
+	keyStoreIDs, err := c.keyStoreIDs(func(meta *keyStoreMeta) bool {
+		return meta.EDV.VaultURL == ""
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := c.clock.Now().Add(-period)
+
+	var (
+		archived   int
+		archiveErr error
+	)
+
+	for _, keyStoreID := range keyStoreIDs {
+		n, err := c.archiveColdKeys(keyStoreID, cutoff)
+		archived += n
+
+		if err != nil {
+			archiveErr = fmt.Errorf("archive keys of key store %s: %w", keyStoreID, err)
+		}
+	}
+
+	return archived, archiveErr
+}
+
+// archiveColdKeys archives keys of the key store last used before the cutoff.
+func (c *Command) archiveColdKeys(keyStoreID string, cutoff time.Time) (int, error) {
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return 0, err
+	}
+
+	lastUsed := map[string]time.Time{}
+
+	if c.keyUsage != nil {
+		if lastUsed, err = c.keyUsage.LastUsedOfKeyStore(keyStoreID); err != nil {
+			return 0, fmt.Errorf("get last-used times: %w", err)
+		}
+	}
+
+	deleted := meta.deletedKeyIDs()
+
+	var archived int
+
+	for _, keyID := range meta.KeyIDs {
+		if deleted[keyID] {
+			continue
+		}
+
+		used, ok := lastUsed[keyID]
+		if !ok {
+			used = meta.Keys[keyID].CreatedAt
+		}
+
+		if used.After(cutoff) {
+			continue
+		}
+
+		// localkms stores keysets under prefixed IDs
+		moved, err := c.keyArchive.Archive(localkms.Namespace, prefix.StorageKIDPrefix+keyID)
+		if err != nil {
+			return archived, fmt.Errorf("archive key %s: %w", keyID, err)
+		}
+
+		if moved {
+			logger.Infof("Archived key %s/%s/keys/%s last used at %s", c.baseKeyStoreURL, keyStoreID, keyID,
+				used.Format(time.RFC3339))
+
+			archived++
+		}
+	}
+
+	return archived, nil
+}
+
+// KeyArchiver moves cold keys to archive storage in the background.
+type KeyArchiver struct {
+	cmd      *Command
+	period   time.Duration
+	interval time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewKeyArchiver returns a new KeyArchiver that archives keys of the command not used for the period every interval.
+func NewKeyArchiver(cmd *Command, period, interval time.Duration) *KeyArchiver {
+	return &KeyArchiver{
+		cmd:      cmd,
+		period:   period,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts archiving keys in the background until Stop is called.
+func (a *KeyArchiver) Start() {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.Archive()
+			case <-a.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops archiving keys.
+func (a *KeyArchiver) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.done)
+	})
+}
+
+// Archive archives cold keys once. Failures are logged, and the keys are archived on the next run.
+func (a *KeyArchiver) Archive() {
+	if _, err := a.cmd.ArchiveColdKeys(a.period); err != nil {
+		logger.Errorf("archive cold keys: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/didkey"
+	"github.com/trustbloc/kms/pkg/secretlock/key"
+)
+
+// fingerprintBackfillCheckpointID is the ID of the record with the progress of BackfillFingerprints. It's saved in
+// the store of key store metadata without the controller tag, so it's never found as a key store.
+const fingerprintBackfillCheckpointID = "fingerprint_backfill_checkpoint"
+
+// fingerprintBackfillAttempts is the number of times fingerprints of a key store are computed when its metadata is
+// changed concurrently, e.g. by a running server.
+const fingerprintBackfillAttempts = 3
+
+var errKeyStoreChanged = stderrors.New("key store metadata changed concurrently")
+
+// BackfillReport is a result of BackfillFingerprints. Counts are of the current run, a resumed run doesn't include
+// key stores processed before the checkpoint.
+type BackfillReport struct {
+	// Cursor is the ID of the last key store of the last completed batch.
+	Cursor    string `json:"cursor"`
+	KeyStores int    `json:"key_stores"`
+	// Updated is the number of keys whose fingerprints were stored.
+	Updated int `json:"updated"`
+	// Skipped is the number of keys that already had fingerprints.
+	Skipped int `json:"skipped"`
+	// Unsupported is the number of keys of key types without a did:key representation, e.g. symmetric keys.
+	Unsupported int `json:"unsupported"`
+	// Errors is the number of keys whose fingerprints couldn't be computed or stored, plus key stores that couldn't
+	// be read at all. Errors are logged.
+	Errors int `json:"errors"`
+}
+
+type fingerprintBackfillCheckpoint struct {
+	Cursor string `json:"cursor"`
+}
+
+// BackfillFingerprints stores fingerprints of public keys in metadata of keys added before fingerprints were stored.
+// Key stores are processed in order of their IDs, in batches of batchSize. After every batch, the ID of its last key
+// store is saved as a checkpoint and progress is called with the report so far. A run resumes after the checkpoint
+// of an interrupted run, and removes the checkpoint when all key stores are processed. Keys that already have
+// fingerprints are skipped, so the batch that was interrupted is not rewritten.
+//
+// It's safe to run while the server is running. Keys are read with the server's secret lock, and fingerprints are
+// saved only if the key store metadata wasn't changed since it was read; otherwise they are computed again. Key
+// stores protected with secret shares of users can't be read and are reported as errors. Like ListKeyStores, key
+// stores are found by the controller tag.
+func (c *Command) BackfillFingerprints(batchSize int, progress func(report *BackfillReport)) (*BackfillReport, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("%w: batch size must be positive", errors.ErrValidation)
+	}
+
+	cursor, err := c.getFingerprintBackfillCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	keyStoreIDs, err := c.keyStoreIDs(func(*keyStoreMeta) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BackfillReport{Cursor: cursor}
+
+	start := sort.Search(len(keyStoreIDs), func(i int) bool { return keyStoreIDs[i] > cursor })
+
+	for ; start < len(keyStoreIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(keyStoreIDs) {
+			end = len(keyStoreIDs)
+		}
+
+		for _, keyStoreID := range keyStoreIDs[start:end] {
+			c.backfillKeyStore(keyStoreID, report)
+		}
+
+		report.Cursor = keyStoreIDs[end-1]
+
+		if err = c.saveFingerprintBackfillCheckpoint(report.Cursor); err != nil {
+			return report, err
+		}
+
+		progress(report)
+	}
+
+	if err = c.store.Delete(fingerprintBackfillCheckpointID); err != nil {
+		return report, fmt.Errorf("delete checkpoint: %w", err)
+	}
+
+	return report, nil
+}
+
+// backfillKeyStore stores fingerprints of keys of the key store, and adds the result to the report.
+func (c *Command) backfillKeyStore(keyStoreID string, report *BackfillReport) {
+	report.KeyStores++
+
+	for attempt := 1; ; attempt++ {
+		result, err := c.backfillKeyStoreOnce(keyStoreID)
+		if stderrors.Is(err, errKeyStoreChanged) && attempt < fingerprintBackfillAttempts {
+			continue
+		}
+
+		if err != nil {
+			logger.Warnf("Failed to backfill fingerprints of key store %s: %v", keyStoreID, err)
+
+			// keys that couldn't be saved are errors, or the key store itself if it couldn't be read
+			result.Errors += len(result.pending)
+			if len(result.pending) == 0 {
+				result.Errors++
+			}
+		}
+
+		report.Updated += result.Updated
+		report.Skipped += result.Skipped
+		report.Unsupported += result.Unsupported
+		report.Errors += result.Errors
+
+		return
+	}
+}
+
+type keyStoreBackfill struct {
+	BackfillReport
+	// pending are fingerprints computed, but not saved yet.
+	pending map[string]string
+}
+
+func (c *Command) backfillKeyStoreOnce(keyStoreID string) (*keyStoreBackfill, error) {
+	result := &keyStoreBackfill{}
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return result, err
+	}
+
+	// fields of a newer version would be dropped when the metadata is saved
+	if err = meta.checkSchemaVersion(); err != nil {
+		return result, err
+	}
+
+	var keyIDs []string
+
+	for keyID, km := range meta.Keys {
+		if km.Fingerprint != "" {
+			result.Skipped++
+
+			continue
+		}
+
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	if len(keyIDs) == 0 {
+		return result, nil
+	}
+
+	sort.Strings(keyIDs)
+
+	storageProvider, err := c.keyStorage(meta)
+	if err != nil {
+		return result, err
+	}
+
+	// deleted keys are not hidden, they get fingerprints too in case they are restored
+	ks, err := c.keyStoreCreator.Create(localKeyURIPrefix+mainKeyIDOrNoop(meta.MainKeyID), &keyStoreProvider{
+		storageProvider: storageProvider,
+		secretLock:      key.NewLock(&keyLockProvider{kms: c.kms, crypto: c.crypto}),
+	})
+	if err != nil {
+		return result, fmt.Errorf("create key store: %w", err)
+	}
+
+	result.pending = make(map[string]string, len(keyIDs))
+
+	for _, keyID := range keyIDs {
+		pub, err := exportPubKeyBytes(ks, keyID)
+		if err != nil {
+			logger.Warnf("Failed to backfill fingerprint of key %s of key store %s: %v", keyID, keyStoreID, err)
+
+			result.Errors++
+
+			continue
+		}
+
+		fingerprint, err := publicKeyFingerprint(pub, meta.Keys[keyID].KeyType)
+		if err != nil {
+			logger.Warnf("Failed to backfill fingerprint of key %s of key store %s: %v", keyID, keyStoreID, err)
+
+			result.Errors++
+
+			continue
+		}
+
+		if fingerprint == "" {
+			result.Unsupported++
+
+			continue
+		}
+
+		result.pending[keyID] = fingerprint
+	}
+
+	if len(result.pending) == 0 {
+		return result, nil
+	}
+
+	_, err = c.incrementSequenceChecked(keyStoreID, func(m *keyStoreMeta) error {
+		if m.Sequence != meta.Sequence {
+			return errKeyStoreChanged
+		}
+
+		return nil
+	}, setKeyFingerprints(result.pending))
+	if err != nil {
+		return result, err
+	}
+
+	result.Updated, result.pending = len(result.pending), nil
+
+	return result, nil
+}
+
+func (c *Command) getFingerprintBackfillCheckpoint() (string, error) {
+	b, err := c.store.Get(fingerprintBackfillCheckpointID)
+	if stderrors.Is(err, storage.ErrDataNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get checkpoint: %w", err)
+	}
+
+	var checkpoint fingerprintBackfillCheckpoint
+
+	if err = json.Unmarshal(b, &checkpoint); err != nil {
+		return "", fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+
+	return checkpoint.Cursor, nil
+}
+
+func (c *Command) saveFingerprintBackfillCheckpoint(cursor string) error {
+	b, err := json.Marshal(&fingerprintBackfillCheckpoint{Cursor: cursor})
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+
+	if err = c.store.Put(fingerprintBackfillCheckpointID, b); err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// publicKeyFingerprint returns the multibase fingerprint of the public key, as used in its did:key, or an empty
+// string if keys of the key type have no did:key representation.
+func publicKeyFingerprint(pub []byte, kt kms.KeyType) (string, error) {
+	// symmetric keys have no public key
+	if len(pub) == 0 {
+		return "", nil
+	}
+
+	didKey, err := didkey.FromPublicKey(pub, kt)
+	if stderrors.Is(err, didkey.ErrUnsupportedKeyType) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("fingerprint: %w", err)
+	}
+
+	return didKey.Fingerprint, nil
+}
+
+// setKeyFingerprint stores the fingerprint of the public key of a key that is added to the key store. A fingerprint
+// that can't be computed is not stored; the backfill reports it.
+func setKeyFingerprint(keyID string, pub []byte) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok {
+			return
+		}
+
+		km.Fingerprint, _ = publicKeyFingerprint(pub, km.KeyType) //nolint:errcheck
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// setKeyFingerprints stores fingerprints of keys, by key ID, that don't have them yet.
+func setKeyFingerprints(fingerprints map[string]string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		for keyID, fingerprint := range fingerprints {
+			km, ok := meta.Keys[keyID]
+			if !ok || km.Fingerprint != "" {
+				continue
+			}
+
+			km.Fingerprint = fingerprint
+
+			meta.Keys[keyID] = km
+		}
+	}
+}
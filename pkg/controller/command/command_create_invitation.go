@@ -0,0 +1,180 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/rs/xid"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const (
+	invitationMsgType   = "https://didcomm.org/out-of-band/1.0/invitation"
+	didExchangeProtocol = "https://didcomm.org/didexchange/1.0"
+	didCommServiceType  = "did-communication"
+	nonceSize           = 24
+)
+
+// invitation is a DIDComm out-of-band invitation (Aries RFC 0434).
+type invitation struct {
+	ID        string           `json:"@id"`
+	Type      string           `json:"@type"`
+	Label     string           `json:"label,omitempty"`
+	Protocols []string         `json:"handshake_protocols"`
+	Services  []didCommService `json:"services"`
+	Requests  []attachment     `json:"request~attach"`
+}
+
+type didCommService struct {
+	ID              string   `json:"id"`
+	Type            string   `json:"type"`
+	RecipientKeys   []string `json:"recipientKeys"`
+	ServiceEndpoint string   `json:"serviceEndpoint"`
+}
+
+// attachment is a DIDComm attachment (Aries RFC 0017) with data embedded as JSON.
+type attachment struct {
+	ID       string         `json:"@id"`
+	MimeType string         `json:"mime-type"`
+	Data     attachmentData `json:"data"`
+}
+
+type attachmentData struct {
+	JSON interface{} `json:"json"`
+}
+
+// sealedCapability is a capability sealed with the key for the recipient's X25519 public key.
+type sealedCapability struct {
+	Ciphertext []byte `json:"ciphertext"`
+	Nonce      []byte `json:"nonce"`
+	SenderKey  string `json:"sender_key"` // did:key of the key the capability is sealed with
+}
+
+// CreateInvitation packages the public key, and optionally a capability sealed for the recipient, as attachments of
+// a DIDComm out-of-band invitation with the configured mediator as the service endpoint.
+func (c *Command) CreateInvitation(w io.Writer, r io.Reader) error {
+	var req CreateInvitationRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if c.didcommMediatorURL == "" {
+		return fmt.Errorf("%w: DIDComm invitations are not enabled", errors.ErrBadRequest)
+	}
+
+	if len(req.Capability) > 0 && len(req.TheirPub) == 0 {
+		return fmt.Errorf("%w: their_pub is required to attach a capability", errors.ErrValidation)
+	}
+
+	var purpose KeyPurpose
+
+	// the key seals an attached capability like wrap does
+	if len(req.Capability) > 0 {
+		purpose = KeyPurposeWrap
+	}
+
+	ks, err := c.resolveKeyStoreForActiveKey(wr, purpose)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	pub, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("export public key bytes: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	if kt != kms.ED25519Type {
+		return fmt.Errorf("%w: invitations need a key of type %s", errors.ErrValidation, kms.ED25519Type)
+	}
+
+	didKey, err := newDIDKey(pub, kt)
+	if err != nil {
+		return err
+	}
+
+	inv := invitation{
+		ID:        xid.New().String(),
+		Type:      invitationMsgType,
+		Label:     req.Label,
+		Protocols: []string{didExchangeProtocol},
+		Services: []didCommService{{
+			ID:              "#inline",
+			Type:            didCommServiceType,
+			RecipientKeys:   []string{didKey.DID},
+			ServiceEndpoint: c.didcommMediatorURL,
+		}},
+		Requests: []attachment{{
+			ID:       "public-key",
+			MimeType: "application/json",
+			Data:     attachmentData{JSON: didKey},
+		}},
+	}
+
+	if len(req.Capability) > 0 {
+		sealed, sealErr := c.sealCapability(ks, wr.KeyID, &req)
+		if sealErr != nil {
+			return sealErr
+		}
+
+		sealed.SenderKey = didKey.DID
+
+		inv.Requests = append(inv.Requests, attachment{
+			ID:       "capability",
+			MimeType: "application/json",
+			Data:     attachmentData{JSON: sealed},
+		})
+	}
+
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return fmt.Errorf("marshal invitation: %w", err)
+	}
+
+	u, err := url.Parse(c.didcommMediatorURL)
+	if err != nil {
+		return fmt.Errorf("parse mediator url: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("oob", base64.URLEncoding.EncodeToString(b))
+	u.RawQuery = q.Encode()
+
+	return json.NewEncoder(w).Encode(CreateInvitationResponse{Invitation: b, InvitationURL: u.String()})
+}
+
+// sealCapability seals the capability with the key, so that only the recipient can open it and can check it comes
+// from the key.
+func (c *Command) sealCapability(ks kms.KeyManager, keyID string, req *CreateInvitationRequest) (*sealedCapability,
+	error) {
+	cryptoBox, err := c.cryptoBox.Create(ks)
+	if err != nil {
+		return nil, fmt.Errorf("create crypto box: %w", err)
+	}
+
+	nonce := make([]byte, nonceSize)
+
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	ciphertext, err := cryptoBox.Easy(req.Capability, nonce, req.TheirPub, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("easy: %w", err)
+	}
+
+	return &sealedCapability{Ciphertext: ciphertext, Nonce: nonce}, nil
+}
@@ -8,7 +8,10 @@ package command
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -24,7 +27,9 @@ import (
 	"github.com/rs/xid"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/breaker"
 	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/idempotency"
 	"github.com/trustbloc/kms/pkg/secretlock/key"
 	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
 )
@@ -36,11 +41,52 @@ const (
 
 // keyStoreMeta is metadata about user's key store saved in the underlying storage.
 type keyStoreMeta struct {
-	ID         string        `json:"id"`
-	Controller string        `json:"controller"`
-	MainKeyID  string        `json:"main_key_id"`
-	EDV        edvParameters `json:"edv,omitempty"`
-	CreatedAt  time.Time     `json:"created_at"`
+	ID                string        `json:"id"`
+	Controller        string        `json:"controller"`
+	MainKeyID         string        `json:"main_key_id"`
+	EDV               edvParameters `json:"edv,omitempty"`
+	SecretShareScheme string        `json:"secret_share_scheme,omitempty"`
+	VerifyCache       bool          `json:"verify_cache,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	// Sequence is a monotonic number incremented on every mutating operation on the key store.
+	Sequence uint64 `json:"sequence"`
+	// KeyIDs lists keys of the key store, so that they can be deleted along with the key store.
+	KeyIDs []string `json:"key_ids,omitempty"`
+	// Keys holds metadata of the listed keys that isn't kept in the keysets.
+	Keys map[string]keyMeta `json:"keys,omitempty"`
+	// Aliases maps human-readable key aliases to key IDs. An alias is unique within the key store.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// VerificationMethods maps DID URLs of verification methods to key IDs, so that keys can be referenced by the DID
+	// URLs callers know them by. A DID URL is unique across key stores, see claimVerificationMethods.
+	VerificationMethods map[string]string `json:"verification_methods,omitempty"`
+	// Overrides of server settings for the key store, set by an admin.
+	Overrides *keyStoreOverrides `json:"overrides,omitempty"`
+	// NoZCAP key stores have no root capability. They are authorized by the OAuth subject that created them, see
+	// Creator.
+	NoZCAP  bool   `json:"no_zcap,omitempty"`
+	Creator string `json:"creator,omitempty"`
+	// SchemaVersion is the version of the metadata format, set on every save. See SchemaVersion.
+	SchemaVersion int `json:"schema_version,omitempty"`
+}
+
+type keyMeta struct {
+	KeyType   kms.KeyType  `json:"key_type"`
+	CreatedAt time.Time    `json:"created_at"`
+	State     KeyState     `json:"state,omitempty"` // empty for active keys
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Purposes  []KeyPurpose `json:"purposes,omitempty"` // empty for keys allowed for all operations
+	Origin    KeyOrigin    `json:"origin,omitempty"`   // empty for keys generated by the key store
+	// Hash is the hash of messages signed with the key, empty if it's the hash of the key type. See ecdsahash.
+	Hash      string     `json:"hash,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// DeletedAlias is the alias of a deleted key. A deleted key releases its alias, and gets it back on restore if
+	// the alias wasn't taken by another key.
+	DeletedAlias string `json:"deleted_alias,omitempty"`
+	// Fingerprint is the multibase fingerprint of the public key, as used in its did:key. Empty for keys without a
+	// did:key representation, and for keys added before fingerprints were stored until they are backfilled.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// SchemaVersion is the version of the key metadata format, set when the key is added. See SchemaVersion.
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 type edvParameters struct {
@@ -50,11 +96,15 @@ type edvParameters struct {
 	Capability     []byte `json:"capability"`
 }
 
-// CreateKeyStore creates a new key store.
-func (c *Command) CreateKeyStore(w io.Writer, r io.Reader) error { //nolint:funlen
+// maxIdempotencyKeyLength is the maximum length of an idempotency key of key store creation.
+const maxIdempotencyKeyLength = 255
+
+// CreateKeyStore creates a new key store. A retried request with the same idempotency key (or, with dedupe, the same
+// content) from the same controller returns the key store created by the first request.
+func (c *Command) CreateKeyStore(w io.Writer, r io.Reader) error {
 	var req CreateKeyStoreRequest
 
-	wr, err := unwrapRequest(&req, r)
+	wr, err := c.unwrapRequest(&req, r)
 	if err != nil {
 		return fmt.Errorf("unwrap request: %w", err)
 	}
@@ -63,36 +113,88 @@ func (c *Command) CreateKeyStore(w io.Writer, r io.Reader) error { //nolint:funl
 		return fmt.Errorf("validate request: %w", err)
 	}
 
+	if req.storageMode() == StorageModeEDV && !c.edvSupported() {
+		return fmt.Errorf("%w: storage_mode %s is not supported by the server", errors.ErrValidation, StorageModeEDV)
+	}
+
+	if err = c.checkNoZCAP(wr, &req); err != nil {
+		return err
+	}
+
+	if len(wr.IdempotencyKey) > maxIdempotencyKeyLength {
+		return fmt.Errorf("%w: idempotency key must be at most %d characters", errors.ErrValidation,
+			maxIdempotencyKeyLength)
+	}
+
+	idempotencyKey := wr.IdempotencyKey
+
+	if idempotencyKey == "" && req.Dedupe {
+		// a dedupe request is identified by its content
+		sum := sha256.Sum256(wr.Request)
+		idempotencyKey = "dedupe:" + hex.EncodeToString(sum[:])
+	}
+
+	create := func() ([]byte, error) {
+		return c.createKeyStore(wr, &req)
+	}
+
+	var b []byte
+
+	if idempotencyKey == "" || c.idempotencyKeys == nil {
+		b, err = create()
+	} else {
+		// a retried request returns the key store created by the first one instead of creating another
+		b, _, err = c.idempotencyKeys.Do(req.Controller, idempotencyKey, wr.Request, create)
+	}
+
+	switch {
+	case stderrors.Is(err, idempotency.ErrMismatch):
+		return fmt.Errorf("%w: %s", errors.ErrUnprocessableEntity, err.Error())
+	case stderrors.Is(err, idempotency.ErrInProgress):
+		return fmt.Errorf("%w: %s", errors.ErrConflict, err.Error())
+	case err != nil:
+		return err
+	}
+
+	_, err = w.Write(b)
+
+	return err
+}
+
+// createKeyStore creates a new key store and returns the encoded CreateKeyStoreResponse.
+func (c *Command) createKeyStore(wr *WrappedRequest, req *CreateKeyStoreRequest) ([]byte, error) { //nolint:funlen
 	var (
+		err             error
 		mainKeyID       string
 		edvParams       edvParameters
 		storageProvider storage.Provider
+		keyStoreID      = xid.New().String()
 	)
 
 	if req.EDV != nil { // use EDV for storing user's operational keys
 		storageProvider, edvParams, err = c.prepareEDVProvider(req.EDV.VaultURL, req.EDV.Capability)
 		if err != nil {
-			return fmt.Errorf("prepare edv provider: %w", err)
+			return nil, fmt.Errorf("prepare edv provider: %w", err)
 		}
 	} else {
 		storageProvider = c.keyStorageProvider
 	}
 
 	if c.cacheProvider != nil && c.keyStoreCacheTTL > 0 {
-		storageProvider = c.cacheProvider.Wrap(storageProvider, c.keyStoreCacheTTL)
+		storageProvider = c.cacheProvider.Wrap(storageProvider, keyStoreID, c.keyStoreCacheTTL)
 	}
 
 	var secretLock secretlock.Service
 
 	if c.shamirProvider != nil { // shamir secret sharing lock
-		secretLock, err = c.createShamirSecretLock(wr.User, wr.SecretShare)
+		secretLock, err = c.createShamirSecretLock(req.SecretShareScheme, wr.User, wr.SecretShare)
 		if err != nil {
-			return fmt.Errorf("create shamir secret lock: %w", err)
+			return nil, fmt.Errorf("create shamir secret lock: %w", err)
 		}
 	} else { // key-based secret lock
 		mainKeyID, _, err = c.kms.Create(c.mainKeyType)
 		if err != nil {
-			return fmt.Errorf("create main key: %w", err)
+			return nil, fmt.Errorf("create main key: %w", err)
 		}
 
 		secretLock = key.NewLock(&keyLockProvider{
@@ -102,11 +204,18 @@ func (c *Command) CreateKeyStore(w io.Writer, r io.Reader) error { //nolint:funl
 	}
 
 	meta := &keyStoreMeta{
-		ID:         xid.New().String(),
-		Controller: req.Controller,
-		MainKeyID:  mainKeyID,
-		EDV:        edvParams,
-		CreatedAt:  time.Now().UTC(),
+		ID:                keyStoreID,
+		Controller:        req.Controller,
+		MainKeyID:         mainKeyID,
+		EDV:               edvParams,
+		SecretShareScheme: req.SecretShareScheme,
+		VerifyCache:       req.VerifyCache,
+		CreatedAt:         c.clock.Now().UTC(),
+	}
+
+	if req.DisableZCAP {
+		meta.NoZCAP = true
+		meta.Creator = wr.User
 	}
 
 	if mainKeyID == "" {
@@ -118,30 +227,37 @@ func (c *Command) CreateKeyStore(w io.Writer, r io.Reader) error { //nolint:funl
 		secretLock:      secretLock,
 	})
 	if err != nil {
-		return fmt.Errorf("create key store: %w", err)
+		return nil, fmt.Errorf("create key store: %w", err)
 	}
 
 	keyStoreURL := c.baseKeyStoreURL + "/" + meta.ID
 
 	var rootCapability []byte
 
-	if c.enableZCAPs {
+	if c.enableZCAPs && !meta.NoZCAP {
 		rootCapability, err = c.newCompressedZCAP(context.Background(), keyStoreURL, req.Controller)
 		if err != nil {
-			return fmt.Errorf("new compressed zcap: %w", err)
+			return nil, fmt.Errorf("new compressed zcap: %w", err)
 		}
 	}
 
 	if err = c.save(meta); err != nil {
-		return fmt.Errorf("save key store metadata: %w", err)
+		return nil, fmt.Errorf("save key store metadata: %w", err)
 	}
 
-	return json.NewEncoder(w).Encode(CreateKeyStoreResponse{
-		KeyStoreURL: keyStoreURL,
-		Capability:  rootCapability,
+	return json.Marshal(CreateKeyStoreResponse{
+		KeyStoreURL:   keyStoreURL,
+		Capability:    rootCapability,
+		SchemaVersion: SchemaVersion,
 	})
 }
 
+// edvSupported reports whether the server can create EDV key stores: it needs key types of the recipient and MAC keys
+// of EDV key stores.
+func (c *Command) edvSupported() bool {
+	return c.edvRecipientKeyType != "" && c.edvMACKeyType != ""
+}
+
 func (c *Command) prepareEDVProvider(vaultURL string, capability []byte) (storage.Provider, edvParameters, error) {
 	recKID, pub, err := c.createRecipientKey()
 	if err != nil {
@@ -195,12 +311,7 @@ func (c *Command) createMACKey() (string, interface{}, error) {
 }
 
 func (c *Command) newCompressedZCAP(ctx context.Context, resource, controller string) ([]byte, error) {
-	capability, err := c.zcap.NewCapability(ctx,
-		zcapld.WithInvocationTarget(resource, "urn:kms:keystore"),
-		zcapld.WithInvoker(controller),
-		zcapld.WithID(resource),
-		zcapld.WithAllowedActions(allActions()...),
-	)
+	capability, err := c.zcap.NewCapability(ctx, rootCapabilityOptions(resource, controller)...)
 	if err != nil {
 		return nil, fmt.Errorf("create zcap: %w", err)
 	}
@@ -213,6 +324,16 @@ func (c *Command) newCompressedZCAP(ctx context.Context, resource, controller st
 	return compressed, nil
 }
 
+// rootCapabilityOptions are the options of the root capability of the key store resource for the controller.
+func rootCapabilityOptions(resource, controller string) []zcapld.CapabilityOption {
+	return []zcapld.CapabilityOption{
+		zcapld.WithInvocationTarget(resource, "urn:kms:keystore"),
+		zcapld.WithInvoker(controller),
+		zcapld.WithID(resource),
+		zcapld.WithAllowedActions(allActions()...),
+	}
+}
+
 const (
 	encAlg  = jose.A256GCM
 	encType = "EDVEncryptedDocument"
@@ -234,12 +355,19 @@ func (c *Command) createEDVStorageProvider(vaultURL string, recipientPubKey *cry
 		edv.WithDeterministicDocumentIDs(),
 	)
 
+	if c.urlResolver != nil {
+		vaultURL, err = c.urlResolver.Resolve(vaultURL)
+		if err != nil {
+			return nil, fmt.Errorf("resolve vault url: %w", err)
+		}
+	}
+
 	s := strings.Split(vaultURL, "/")
 
 	edvServerURL := strings.Join(s[:len(s)-1], "/")
 	vaultID := s[len(s)-1]
 
-	return edv.NewRESTProvider(
+	var provider storage.Provider = edv.NewRESTProvider(
 		edvServerURL,
 		vaultID,
 		encryptedFormatter,
@@ -247,10 +375,17 @@ func (c *Command) createEDVStorageProvider(vaultURL string, recipientPubKey *cry
 		edv.WithHeaders(func(req *http.Request) (*http.Header, error) {
 			return c.headerSigner.SignHeader(req, capability)
 		}),
-	), nil
+	)
+
+	// the HTTP client of the EDV provider can't be replaced, so the breaker and the timeout wrap its stores
+	if c.edvBreaker != nil {
+		provider = breaker.WrapProvider(provider, c.edvBreaker, c.edvTimeout)
+	}
+
+	return provider, nil
 }
 
-func (c *Command) createShamirSecretLock(user string, secretShare []byte) (secretlock.Service, error) {
+func (c *Command) createShamirSecretLock(scheme, user string, secretShare []byte) (secretlock.Service, error) {
 	if user == "" {
 		return nil, fmt.Errorf("%w: empty user", errors.ErrValidation)
 	}
@@ -264,7 +399,7 @@ func (c *Command) createShamirSecretLock(user string, secretShare []byte) (secre
 		return nil, fmt.Errorf("fetch secret share: %w", err)
 	}
 
-	secretLock, err := c.shamirLock.Create([][]byte{secretShare, share})
+	secretLock, err := c.shamirLock.Create(scheme, [][]byte{secretShare, share})
 	if err != nil {
 		return nil, fmt.Errorf("create shamir lock: %w", err)
 	}
@@ -273,12 +408,14 @@ func (c *Command) createShamirSecretLock(user string, secretShare []byte) (secre
 }
 
 func (c *Command) save(meta *keyStoreMeta) error {
+	meta.SchemaVersion = SchemaVersion
+
 	b, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
 
-	err = c.store.Put(meta.ID, b)
+	err = c.store.Put(meta.ID, b, keyStoreTags(meta)...)
 	if err != nil {
 		return fmt.Errorf("put: %w", err)
 	}
@@ -286,6 +423,106 @@ func (c *Command) save(meta *keyStoreMeta) error {
 	return nil
 }
 
+func (c *Command) getKeyStoreMeta(keyStoreID string) (*keyStoreMeta, error) {
+	b, err := c.store.Get(keyStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("get key store meta: %w", err)
+	}
+
+	var meta keyStoreMeta
+
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("unmarshal key store meta: %w", err)
+	}
+
+	if err = meta.checkSchemaVersion(); err != nil {
+		return nil, err
+	}
+
+	if meta.SchemaVersion == 0 {
+		c.backfillKeyStoreTags(&meta)
+	}
+
+	return &meta, nil
+}
+
+// incrementSequence increments the sequence number of the key store, applies updates to its metadata and returns
+// the new sequence number.
+func (c *Command) incrementSequence(keyStoreID string, updates ...func(meta *keyStoreMeta)) (uint64, error) {
+	return c.incrementSequenceChecked(keyStoreID, nil, updates...)
+}
+
+// incrementSequenceChecked is like incrementSequence, but the metadata is first passed to check and nothing is
+// updated if the check fails. The metadata is saved as the next revision of the key store, so a concurrent update
+// from another instance fails with a conflict error.
+func (c *Command) incrementSequenceChecked(keyStoreID string, check func(meta *keyStoreMeta) error,
+	updates ...func(meta *keyStoreMeta)) (uint64, error) {
+	defer c.keyStoreLocks.lock(keyStoreID)()
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return 0, err
+	}
+
+	if check != nil {
+		if err = check(meta); err != nil {
+			return 0, err
+		}
+	}
+
+	meta.Sequence++
+
+	for _, update := range updates {
+		update(meta)
+	}
+
+	if err = c.saveRevision(meta); err != nil {
+		return 0, fmt.Errorf("save key store metadata: %w", err)
+	}
+
+	return meta.Sequence, nil
+}
+
+// addKeyID adds the key to the list of keys of the key store.
+func addKeyID(keyID string, keyType kms.KeyType, createdAt time.Time) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		if meta.Keys == nil {
+			meta.Keys = make(map[string]keyMeta)
+		}
+
+		meta.Keys[keyID] = keyMeta{KeyType: keyType, CreatedAt: createdAt, SchemaVersion: SchemaVersion}
+
+		for _, id := range meta.KeyIDs {
+			if id == keyID {
+				return
+			}
+		}
+
+		meta.KeyIDs = append(meta.KeyIDs, keyID)
+	}
+}
+
+// removeKeyID removes the key, its alias and verification methods from the list of keys of the key store.
+func removeKeyID(keyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		delete(meta.Keys, keyID)
+
+		if alias := meta.aliasOf(keyID); alias != "" {
+			delete(meta.Aliases, alias)
+		}
+
+		removeVerificationMethods(keyID)(meta)
+
+		for i, id := range meta.KeyIDs {
+			if id == keyID {
+				meta.KeyIDs = append(meta.KeyIDs[:i], meta.KeyIDs[i+1:]...)
+
+				return
+			}
+		}
+	}
+}
+
 type keyStoreProvider struct {
 	storageProvider storage.Provider
 	secretLock      secretlock.Service
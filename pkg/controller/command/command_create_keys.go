@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// maxBatchKeys is the maximum number of keys created in a single request.
+const maxBatchKeys = 20
+
+// CreateKeys creates a batch of keys. Keys are created all or nothing: if any key fails, keys created by the request
+// are deleted.
+func (c *Command) CreateKeys(w io.Writer, r io.Reader) error {
+	var req CreateKeysRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if len(req.Keys) == 0 || len(req.Keys) > maxBatchKeys {
+		return fmt.Errorf("%w: number of keys must be from 1 to %d", errors.ErrValidation, maxBatchKeys)
+	}
+
+	aliases, err := batchAliases(req.Keys)
+	if err != nil {
+		return err
+	}
+
+	for i, k := range req.Keys {
+		if err = c.validateExpiresAt(k.ExpiresAt); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+
+		if err = validateKeyPurposes(k.Purposes); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+
+		if err = validateKeySize(k.KeyType, k.KeySize); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+
+		req.Keys[i].KeyType = keyTypeOfSize(k.KeyType, k.KeySize)
+
+		if err = validateKeyHash(req.Keys[i].KeyType, k.Hash); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+
+	ks, meta, storageProvider, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	for i, k := range req.Keys {
+		if err = checkKeyStorage(k.KeyType, meta); err != nil {
+			return fmt.Errorf("key %d: %w", i, err)
+		}
+	}
+
+	// fails early without creating keys, aliases are checked again when the keys are added to the key store
+	if err = c.checkAliases(wr.KeyStoreID, aliases)(meta); err != nil {
+		return err
+	}
+
+	var (
+		keyIDs  []string
+		keys    = make([]CreatedKey, len(req.Keys))
+		updates = make([]func(meta *keyStoreMeta), 0, 6*len(req.Keys))
+	)
+
+	// deletes keys created by the request, so that a failed request doesn't leave part of the keys
+	rollback := func(err error) error {
+		if deleteErr := deleteKeys(storageProvider, keyIDs...); deleteErr != nil {
+			return fmt.Errorf("%w (delete created keys: %s)", err, deleteErr.Error())
+		}
+
+		return err
+	}
+
+	createdAt := c.clock.Now().UTC()
+
+	for i, k := range req.Keys {
+		kid, createErr := c.createKey(ks, k.KeyType, k.KeySize)
+		if createErr != nil {
+			return rollback(fmt.Errorf("create key %d: %w", i, createErr))
+		}
+
+		keyIDs = append(keyIDs, kid)
+
+		pub, exportErr := exportPubKeyBytes(ks, kid)
+		if exportErr != nil {
+			return rollback(fmt.Errorf("key %d: %w", i, exportErr))
+		}
+
+		keys[i] = CreatedKey{
+			KeyURL:    fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+			PublicKey: pub,
+		}
+		updates = append(updates, addKeyID(kid, k.KeyType, createdAt), setKeyFingerprint(kid, pub),
+			setKeyExpiry(kid, k.ExpiresAt), setKeyPurposes(kid, k.Purposes), setKeyHash(kid, k.KeyType, k.Hash),
+			setKeyAlias(kid, k.Alias))
+
+		if k.Alias != "" {
+			aliases[k.Alias] = kid
+		}
+	}
+
+	seq, err := c.incrementSequenceChecked(wr.KeyStoreID, c.checkAliases(wr.KeyStoreID, aliases), updates...)
+	if err != nil {
+		return rollback(fmt.Errorf("increment sequence: %w", err))
+	}
+
+	return json.NewEncoder(w).Encode(CreateKeysResponse{Keys: keys, Sequence: seq, SchemaVersion: SchemaVersion})
+}
+
+// batchAliases validates aliases of the keys and returns them mapped to empty key IDs.
+func batchAliases(keys []CreateKeyRequest) (map[string]string, error) {
+	aliases := make(map[string]string, len(keys))
+
+	for i, k := range keys {
+		if k.Alias == "" {
+			continue
+		}
+
+		if err := validateAlias(k.Alias); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+
+		if _, ok := aliases[k.Alias]; ok {
+			return nil, fmt.Errorf("%w: key %d: duplicate alias %q", errors.ErrValidation, i, k.Alias)
+		}
+
+		aliases[k.Alias] = ""
+	}
+
+	return aliases, nil
+}
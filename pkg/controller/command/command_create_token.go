@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const maxTokenTTL = 24 * time.Hour
+
+// Actions that can be authorized with a one-time token.
+var tokenActions = map[string]struct{}{ //nolint:gochecknoglobals
+	ActionVerify:    {},
+	ActionExportKey: {},
+}
+
+// IsTokenAction returns true if the action can be authorized with a one-time token.
+func IsTokenAction(action string) bool {
+	_, ok := tokenActions[action]
+
+	return ok
+}
+
+// CreateToken mints a one-time token that authorizes a single operation on the key for a third party, without
+// delegating a capability.
+func (c *Command) CreateToken(w io.Writer, r io.Reader) error {
+	var req CreateTokenRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if c.oneTimeTokens == nil {
+		return fmt.Errorf("%w: one-time tokens are not enabled", errors.ErrBadRequest)
+	}
+
+	if !IsTokenAction(req.Action) {
+		return fmt.Errorf("%w: action %q can't be authorized with a one-time token", errors.ErrValidation, req.Action)
+	}
+
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+
+	if ttl <= 0 || ttl > maxTokenTTL {
+		return fmt.Errorf("%w: expires_in must be between 1 and %d seconds", errors.ErrValidation,
+			int64(maxTokenTTL.Seconds()))
+	}
+
+	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if _, err = ks.Get(wr.KeyID); err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	secret, token, err := c.oneTimeTokens.Mint(wr.KeyStoreID, wr.KeyID, req.Action, ttl)
+	if err != nil {
+		return fmt.Errorf("mint token: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(CreateTokenResponse{
+		Token:     secret,
+		ID:        token.ID,
+		ExpiresAt: token.ExpiresAt,
+	})
+}
@@ -0,0 +1,156 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const (
+	curve25519KeySize  = 32
+	cryptoBoxNonceSize = 24
+)
+
+// Easy seals a payload for their_pub with the key of the request, like the DIDComm v1 (legacy) packer does. Unlike
+// the same operation on WrapKey, the key must be an ED25519 key; its Curve25519 counterpart is used for sealing.
+func (c *Command) Easy(w io.Writer, r io.Reader) error {
+	var req EasyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateCryptoBoxParams(req.Nonce, req.TheirPub); err != nil {
+		return err
+	}
+
+	cryptoBox, _, err := c.resolveCryptoBox(wr, KeyPurposeWrap, c.resolveKeyStoreForActiveKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := cryptoBox.Easy(req.Payload, req.Nonce, req.TheirPub, wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("easy: %w", err)
+	}
+
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
+	return json.NewEncoder(w).Encode(EasyResponse{Ciphertext: ciphertext})
+}
+
+// EasyOpen unseals a ciphertext sealed with Easy by their_pub for the ED25519 key of the request. my_pub defaults to
+// the public key of the key.
+func (c *Command) EasyOpen(w io.Writer, r io.Reader) error {
+	var req EasyOpenRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateCryptoBoxParams(req.Nonce, req.TheirPub); err != nil {
+		return err
+	}
+
+	cryptoBox, pub, err := c.resolveCryptoBox(wr, KeyPurposeUnwrap, c.resolveKeyStoreForPurpose)
+	if err != nil {
+		return err
+	}
+
+	if req.MyPub, err = checkMyPub(req.MyPub, pub, wr.KeyID); err != nil {
+		return err
+	}
+
+	return c.easyOpen(w, &req, cryptoBox)
+}
+
+// SealOpen decrypts a ciphertext encrypted with Seal for the ED25519 key of the request. my_pub defaults to the public
+// key of the key.
+func (c *Command) SealOpen(w io.Writer, r io.Reader) error {
+	var req SealOpenRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	cryptoBox, pub, err := c.resolveCryptoBox(wr, KeyPurposeUnwrap, c.resolveKeyStoreForPurpose)
+	if err != nil {
+		return err
+	}
+
+	if req.MyPub, err = checkMyPub(req.MyPub, pub, wr.KeyID); err != nil {
+		return err
+	}
+
+	return c.sealOpen(w, &req, cryptoBox)
+}
+
+// resolveCryptoBox resolves the key store of the request for the purpose and returns a crypto box over it, along with
+// the public key of the key of the request. It fails with ErrUnprocessableEntity if the key is not an ED25519 key.
+func (c *Command) resolveCryptoBox(wr *WrappedRequest, purpose KeyPurpose,
+	resolve func(wr *WrappedRequest, purpose KeyPurpose) (kms.KeyManager, error)) (CryptoBox, []byte, error) {
+	ks, err := resolve(wr, purpose)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve key store: %w", err)
+	}
+
+	pub, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("export public key bytes: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	if kt != kms.ED25519Type {
+		return nil, nil, fmt.Errorf("%w: crypto box requires an %s key, key %s is %s", errors.ErrUnprocessableEntity,
+			kms.ED25519Type, wr.KeyID, kt)
+	}
+
+	cryptoBox, err := c.cryptoBox.Create(ks)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create crypto box: %w", err)
+	}
+
+	return cryptoBox, pub, nil
+}
+
+// validateCryptoBoxParams fails with ErrValidation unless the nonce and the public key of the peer have the sizes
+// NaCl box requires; the crypto box would silently truncate or pad them.
+func validateCryptoBoxParams(nonce, theirPub []byte) error {
+	if len(nonce) != cryptoBoxNonceSize {
+		return fmt.Errorf("%w: nonce must be %d bytes", errors.ErrValidation, cryptoBoxNonceSize)
+	}
+
+	if len(theirPub) != curve25519KeySize {
+		return fmt.Errorf("%w: their_pub must be a %d-byte Curve25519 public key", errors.ErrValidation,
+			curve25519KeySize)
+	}
+
+	return nil
+}
+
+// checkMyPub returns the public key of the key of the request if my_pub is omitted. The crypto box selects the
+// private key by my_pub, so a my_pub of another key of the key store is rejected.
+func checkMyPub(myPub, pub []byte, keyID string) ([]byte, error) {
+	if len(myPub) == 0 {
+		return pub, nil
+	}
+
+	if !bytes.Equal(myPub, pub) {
+		return nil, fmt.Errorf("%w: my_pub doesn't match key %s of the request", errors.ErrValidation, keyID)
+	}
+
+	return myPub, nil
+}
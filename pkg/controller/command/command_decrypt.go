@@ -0,0 +1,70 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+)
+
+// DecryptionFailedCode is an error code returned in the body of a decrypt request whose ciphertext, nonce or
+// associated data don't authenticate with the key (e.g. the ciphertext was tampered with).
+const DecryptionFailedCode = "DECRYPTION_FAILED"
+
+// DecryptionFailedError is returned when the ciphertext can't be decrypted with the key.
+type DecryptionFailedError struct {
+	KeyURL string
+}
+
+func (e *DecryptionFailedError) Error() string {
+	return fmt.Sprintf("%s: ciphertext can't be decrypted with key %s", errors.ErrBadRequest.Error(), e.KeyURL)
+}
+
+// Unwrap returns ErrBadRequest, so that the error is reported with 400 status.
+func (e *DecryptionFailedError) Unwrap() error {
+	return errors.ErrBadRequest
+}
+
+// decryptionFailed returns DecryptionFailedError if the crypto service failed to authenticate the ciphertext, or
+// the error as is otherwise. Tink reports all authentication failures with the same message.
+func (c *Command) decryptionFailed(wr *WrappedRequest, err error) error {
+	if !strings.Contains(err.Error(), "decryption failed") {
+		return err
+	}
+
+	return &DecryptionFailedError{
+		KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, wr.KeyID),
+	}
+}
+
+// nonceSizes are the sizes in bytes of the nonces of AEAD key types. XChaCha20Poly1305 keys take extended 24-byte
+// nonces, so that random nonces can't collide.
+var nonceSizes = map[kms.KeyType]int{ //nolint:gochecknoglobals
+	kms.AES128GCMType:         aesgcm.NonceSize,
+	kms.AES256GCMType:         aesgcm.NonceSize,
+	kms.AES256GCMNoPrefixType: aesgcm.NonceSize,
+	kms.ChaCha20Poly1305Type:  chacha20poly1305.NonceSize,
+	kms.XChaCha20Poly1305Type: chacha20poly1305.NonceSizeX,
+}
+
+// validateNonce fails with ErrValidation if the nonce doesn't have the size of nonces of the key type, rather than
+// reporting a nonce of another AEAD as a ciphertext that doesn't authenticate. Key types that aren't recorded in the
+// metadata of keys created by older versions aren't checked.
+func validateNonce(kt kms.KeyType, nonce []byte) error {
+	size, ok := nonceSizes[kt]
+	if !ok || len(nonce) == size {
+		return nil
+	}
+
+	return fmt.Errorf("%w: nonce of %s keys must be %d bytes", errors.ErrValidation, kt, size)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// DeleteKey deletes a key from the key store. The key is hidden, but its material is kept for the retention period,
+// so that the key can be restored with RestoreKey. The material is deleted by PurgeDeletedKeys afterwards.
+func (c *Command) DeleteKey(_ io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	// keys of all key stores share the same store, a key that can be read with the key store's secret lock
+	// belongs to the key store
+	if _, err = ks.Get(wr.KeyID); err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	if _, err = c.incrementSequence(wr.KeyStoreID, setKeyDeleted(wr.KeyID, c.clock.Now().UTC())); err != nil {
+		return fmt.Errorf("increment sequence: %w", err)
+	}
+
+	return nil
+}
+
+// keyNotFound returns a not found error if the key doesn't exist, so that requests for missing (e.g. deleted) keys
+// are reported with 404 status. Other errors are returned as is.
+func keyNotFound(keyID string, err error) error {
+	if stderrors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("%w: key %s", errors.ErrNotFound, keyID)
+	}
+
+	return err
+}
@@ -0,0 +1,118 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// DeleteKeyStore deletes the key store along with its keys and root capability. Only the controller of the key
+// store can delete it. Keys of an EDV-backed key store are left in the user's vault, but the server's recipient and
+// MAC keys are deleted, so that the vault is no longer accessible to the server.
+func (c *Command) DeleteKeyStore(_ io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	defer c.keyStoreLocks.lock(wr.KeyStoreID)()
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	if c.enableZCAPs && wr.Caller != meta.owner() {
+		return fmt.Errorf("%w: only the controller can delete the key store", errors.ErrForbidden)
+	}
+
+	// the deletion is claimed as the next revision of the key store, so keys can't be added while it is deleted
+	if err = c.claimDeletion(meta); err != nil {
+		return err
+	}
+
+	if meta.EDV.VaultURL == "" {
+		storageProvider := c.keyStorageProvider
+
+		if ttl := c.keyStoreCacheTTLOf(meta); c.cacheProvider != nil && ttl > 0 {
+			storageProvider = c.cacheProvider.Wrap(storageProvider, meta.ID, ttl)
+		}
+
+		if err = deleteKeys(storageProvider, meta.KeyIDs...); err != nil {
+			return fmt.Errorf("delete keys: %w", err)
+		}
+	}
+
+	var serverKeyIDs []string
+
+	for _, kid := range []string{meta.MainKeyID, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID} {
+		if kid != "" {
+			serverKeyIDs = append(serverKeyIDs, kid)
+		}
+	}
+
+	// server's key manager may keep cached keys until they expire
+	if err = deleteKeys(c.storageProvider, serverKeyIDs...); err != nil {
+		return fmt.Errorf("delete server keys: %w", err)
+	}
+
+	if c.enableZCAPs && !meta.NoZCAP {
+		err = c.zcap.Delete(c.baseKeyStoreURL + "/" + meta.ID)
+		if err != nil && !stderrors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("delete root capability: %w", err)
+		}
+	}
+
+	if err = c.store.Delete(meta.ID); err != nil {
+		return fmt.Errorf("delete key store metadata: %w", err)
+	}
+
+	return nil
+}
+
+// deleteKeys deletes keysets from the local KMS storage of the provider. Keys that don't exist are skipped.
+func deleteKeys(provider storage.Provider, keyIDs ...string) error {
+	if len(keyIDs) == 0 {
+		return nil
+	}
+
+	store, err := provider.OpenStore(localkms.Namespace)
+	if err != nil {
+		return fmt.Errorf("open key store: %w", err)
+	}
+
+	// localkms stores keysets under prefixed IDs
+	store, err = prefix.NewPrefixStoreWrapper(store, prefix.StorageKIDPrefix)
+	if err != nil {
+		return fmt.Errorf("wrap key store: %w", err)
+	}
+
+	for _, kid := range keyIDs {
+		if err = store.Delete(kid); err != nil && !stderrors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("delete key %s: %w", kid, err)
+		}
+	}
+
+	return nil
+}
+
+// keyStoreNotFound returns a not found error if the key store doesn't exist. Other errors are returned as is.
+func keyStoreNotFound(keyStoreID string, err error) error {
+	if stderrors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("%w: key store %s", errors.ErrNotFound, keyStoreID)
+	}
+
+	return err
+}
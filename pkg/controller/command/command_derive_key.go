@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/x25519"
+)
+
+const (
+	// KDFHKDFSHA256 is the only supported key derivation function: HKDF (RFC 5869) with SHA-256.
+	KDFHKDFSHA256 = "HKDF-SHA256"
+
+	// DefaultDerivedKeyLength is the length in bytes of derived keys if the request doesn't set one.
+	DefaultDerivedKeyLength = 32
+	// MinDerivedKeyLength is the minimum length in bytes of derived keys.
+	MinDerivedKeyLength = 16
+	// MaxDerivedKeyLength is the maximum length in bytes of derived keys.
+	MaxDerivedKeyLength = 64
+)
+
+// DeriveKey derives a key from the X25519 shared secret of the key and a peer public key with HKDF-SHA256, so that
+// ECIES-style flows don't need the private key. The derived key is wrapped for the recipient of the request
+// (ECDH-ES), or returned raw if the server allows it: a raw key can be derived again by anyone allowed to call the
+// endpoint, and is as sensitive as the private key for that peer.
+func (c *Command) DeriveKey(w io.Writer, r io.Reader) error {
+	var req DeriveKeyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	peerPub, err := parsePeerPublicKey(req.PeerPublicKey)
+	if err != nil {
+		return err
+	}
+
+	length, err := validateDeriveKDF(&req.KDF)
+	if err != nil {
+		return err
+	}
+
+	if req.Recipient == nil && !c.enableRawDerived {
+		return fmt.Errorf("%w: derived keys are only returned wrapped for a recipient on this server",
+			errors.ErrForbidden)
+	}
+
+	if req.Recipient != nil && len(req.Recipient.X) == 0 {
+		return fmt.Errorf("%w: recipient must have an x coordinate", errors.ErrValidation)
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeDeriveKey, wr)
+	if err != nil {
+		return err
+	}
+
+	// key types aren't recorded in the metadata of keys created by older versions
+	if wr.keyType != "" && wr.keyType != kms.X25519ECDHKWType {
+		return fmt.Errorf("%w: key %s of type %s can't derive keys, supported: %s", errors.ErrUnprocessableEntity,
+			wr.KeyID, wr.keyType, kms.X25519ECDHKWType)
+	}
+
+	priv, err := x25519.PrivateKey(kh)
+	if err != nil {
+		return fmt.Errorf("%w: key %s can't derive keys: %s", errors.ErrUnprocessableEntity, wr.KeyID, err)
+	}
+
+	var key []byte
+
+	if err = c.runCrypto(wr, func() error {
+		var deriveErr error
+
+		key, deriveErr = x25519.DeriveKey(priv, peerPub, req.KDF.Salt, req.KDF.Info, length)
+
+		return deriveErr
+	}); err != nil {
+		if stderrors.Is(err, x25519.ErrInvalidPublicKey) {
+			return fmt.Errorf("%w: peer_public_key: %s", errors.ErrValidation, err)
+		}
+
+		return fmt.Errorf("derive key: %w", err)
+	}
+
+	if req.Recipient == nil {
+		return json.NewEncoder(w).Encode(DeriveKeyResponse{Key: key})
+	}
+
+	wk, err := c.crypto.WrapKey(key, nil, nil, req.Recipient)
+	if err != nil {
+		return fmt.Errorf("%w: wrap derived key for recipient: %s", errors.ErrValidation, err)
+	}
+
+	return json.NewEncoder(w).Encode(DeriveKeyResponse{WrappedKey: wk})
+}
+
+// parsePeerPublicKey returns the raw X25519 public key of a peer public JWK.
+func parsePeerPublicKey(b json.RawMessage) ([]byte, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return nil, fmt.Errorf("%w: peer_public_key is required", errors.ErrValidation)
+	}
+
+	var j jwk.JWK
+
+	if err := j.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("%w: unmarshal peer_public_key: %s", errors.ErrValidation, err)
+	}
+
+	pub, ok := j.Key.([]byte)
+	if !ok || j.Kty != "OKP" || j.Crv != "X25519" {
+		return nil, fmt.Errorf("%w: peer_public_key must be an OKP JWK on curve X25519", errors.ErrUnprocessableEntity)
+	}
+
+	return pub, nil
+}
+
+// validateDeriveKDF validates the KDF parameters of a derive request and returns the length of the derived key.
+// Lengths are multiples of 8 bytes, so that derived keys can be wrapped with AES key wrap for EC recipients.
+func validateDeriveKDF(kdf *DeriveKeyKDF) (int, error) {
+	if kdf.Alg != "" && kdf.Alg != KDFHKDFSHA256 {
+		return 0, fmt.Errorf("%w: kdf alg %s is not supported, supported: %s", errors.ErrUnprocessableEntity,
+			kdf.Alg, KDFHKDFSHA256)
+	}
+
+	if kdf.Length == 0 {
+		return DefaultDerivedKeyLength, nil
+	}
+
+	if kdf.Length < MinDerivedKeyLength || kdf.Length > MaxDerivedKeyLength || kdf.Length%8 != 0 {
+		return 0, fmt.Errorf("%w: kdf length must be a multiple of 8 between %d and %d", errors.ErrValidation,
+			MinDerivedKeyLength, MaxDerivedKeyLength)
+	}
+
+	return kdf.Length, nil
+}
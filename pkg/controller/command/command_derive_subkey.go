@@ -0,0 +1,154 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/subkey"
+)
+
+// DeriveSubkey derives a new key of the key store from an HMAC key with HKDF, salt and info, e.g. per-tenant index
+// keys from a master key, and returns the URL of the new key. Neither key leaves the KMS. The same key, salt and info
+// always derive the same key material, but every request adds a new key to the key store.
+func (c *Command) DeriveSubkey(w io.Writer, r io.Reader) error {
+	var req DeriveSubkeyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateDeriveSubkey(&req); err != nil {
+		return err
+	}
+
+	ks, meta, storageProvider, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	// derived keys are written like RSA-PSS keys, to the store of the local KMS
+	if meta.EDV.VaultURL != "" {
+		return fmt.Errorf("%w: derived keys can't be stored in EDV-backed key stores", errors.ErrValidation)
+	}
+
+	if wr.KeyID, err = meta.resolveKeyRef(wr.KeyID); err != nil {
+		return err
+	}
+
+	wr.keyType = meta.Keys[wr.KeyID].KeyType
+
+	if err = c.checkKeyPurpose(wr.KeyStoreID, wr.KeyID, KeyPurposeDeriveKey, meta); err != nil {
+		return err
+	}
+
+	if err = c.checkKeyActive(wr.KeyStoreID, wr.KeyID, meta); err != nil {
+		return err
+	}
+
+	// fails early without deriving a key, the alias is checked again when the key is added to the key store
+	if err = c.checkAliases(wr.KeyStoreID, map[string]string{req.Alias: ""})(meta); err != nil {
+		return err
+	}
+
+	// key types aren't recorded in the metadata of keys created by older versions
+	if wr.keyType != "" && wr.keyType != kms.HMACSHA256Tag256Type {
+		return fmt.Errorf("%w: key %s of type %s can't derive keys, supported: %s", errors.ErrUnprocessableEntity,
+			wr.KeyID, wr.keyType, kms.HMACSHA256Tag256Type)
+	}
+
+	kh, err := ks.Get(wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
+	var key *subkey.Key
+
+	if err = c.runCrypto(wr, func() error {
+		var deriveErr error
+
+		key, deriveErr = subkey.Derive(kh, req.Salt, req.Info, req.KeyType)
+
+		return deriveErr
+	}); err != nil {
+		if stderrors.Is(err, subkey.ErrNotHMACKey) {
+			return fmt.Errorf("%w: key %s can't derive keys: %s", errors.ErrUnprocessableEntity, wr.KeyID, err)
+		}
+
+		return fmt.Errorf("derive key: %w", err)
+	}
+
+	kid, _, err := ks.ImportPrivateKey(key, req.KeyType)
+	if err != nil {
+		return fmt.Errorf("store derived key: %w", err)
+	}
+
+	seq, err := c.incrementSequenceChecked(wr.KeyStoreID,
+		c.checkAliases(wr.KeyStoreID, map[string]string{req.Alias: kid}),
+		addKeyID(kid, req.KeyType, c.clock.Now().UTC()), setKeyOrigin(kid, KeyOriginDerived),
+		setKeyAlias(kid, req.Alias))
+	if err != nil {
+		var conflictErr *AliasConflictError
+
+		// the alias was taken by a concurrent request, the key isn't kept without it
+		if stderrors.As(err, &conflictErr) {
+			if deleteErr := deleteKeys(storageProvider, kid); deleteErr != nil {
+				return fmt.Errorf("%w (delete derived key: %s)", err, deleteErr.Error())
+			}
+		}
+
+		return fmt.Errorf("increment sequence: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(DeriveSubkeyResponse{
+		KeyURL:        fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+		Sequence:      seq,
+		SchemaVersion: SchemaVersion,
+	})
+}
+
+// derivedKeyTypes returns the key types of derived keys, for error messages.
+func derivedKeyTypes() string {
+	types := make([]string, 0, len(subkey.KeyTypes))
+
+	for kt := range subkey.KeyTypes {
+		types = append(types, string(kt))
+	}
+
+	sort.Strings(types)
+
+	return strings.Join(types, ", ")
+}
+
+// validateDeriveSubkey checks the request without the key store, so that dry runs check the same.
+func validateDeriveSubkey(req *DeriveSubkeyRequest) error {
+	if req.KeyType == "" {
+		return fmt.Errorf("%w: key_type is required", errors.ErrValidation)
+	}
+
+	if _, ok := subkey.KeyTypes[req.KeyType]; !ok {
+		return fmt.Errorf("%w: keys of type %s can't be derived, supported: %s", errors.ErrUnprocessableEntity,
+			req.KeyType, derivedKeyTypes())
+	}
+
+	if req.Alias != "" {
+		return validateAlias(req.Alias)
+	}
+
+	return nil
+}
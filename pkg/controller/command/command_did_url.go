@@ -0,0 +1,253 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const (
+	// UnknownDIDURLCode is an error code returned in the body of a request for a DID URL that isn't registered for a
+	// key of the key store.
+	UnknownDIDURLCode = "UNKNOWN_DID_URL"
+	// VerificationMethodConflictCode is an error code returned in the body of a request that registers a DID URL
+	// already registered for another key.
+	VerificationMethodConflictCode = "VERIFICATION_METHOD_CONFLICT"
+
+	// didURLClaimPrefix prefixes IDs of the records that claim DID URLs for key stores, see claimVerificationMethods.
+	didURLClaimPrefix = "did_url_"
+)
+
+// didURLPattern matches DID URLs of verification methods: a DID with a fragment, but without path or query, so that
+// the DID URL can be used as a key ID in request paths (with '#' escaped).
+var didURLPattern = regexp.MustCompile(`^did:[a-z0-9]+:[^\s/?#]+#[^\s/?#]+$`)
+
+// UnknownDIDURLError is returned when a request references a key by a DID URL that isn't registered for a key of the
+// key store.
+type UnknownDIDURLError struct {
+	DIDURL string
+}
+
+func (e *UnknownDIDURLError) Error() string {
+	return fmt.Sprintf("%s: DID URL %s isn't registered for a key of the key store", errors.ErrNotFound.Error(),
+		e.DIDURL)
+}
+
+// Unwrap returns ErrNotFound, so that the error is reported with 404 status.
+func (e *UnknownDIDURLError) Unwrap() error {
+	return errors.ErrNotFound
+}
+
+// VerificationMethodConflictError is returned when a DID URL is registered for a key while it is registered for
+// another key, either of the same or of another key store.
+type VerificationMethodConflictError struct {
+	DIDURL string
+	KeyURL string // URL of the key that uses the DID URL, empty if the key is of another key store
+}
+
+func (e *VerificationMethodConflictError) Error() string {
+	if e.KeyURL == "" {
+		return fmt.Sprintf("%s: DID URL %s is registered by another key store", errors.ErrConflict.Error(), e.DIDURL)
+	}
+
+	return fmt.Sprintf("%s: DID URL %s is registered for key %s", errors.ErrConflict.Error(), e.DIDURL, e.KeyURL)
+}
+
+// Unwrap returns ErrConflict, so that the error is reported with 409 status.
+func (e *VerificationMethodConflictError) Unwrap() error {
+	return errors.ErrConflict
+}
+
+// didURLClaim is a record that claims a DID URL for a key store. Verification methods are registered in the
+// metadata of their key store; claims only keep DID URLs unique across key stores.
+type didURLClaim struct {
+	KeyStoreID string `json:"key_store_id"`
+}
+
+// isDIDURL reports whether the key reference is a DID URL rather than a key ID or alias.
+func isDIDURL(ref string) bool {
+	return strings.HasPrefix(ref, "did:")
+}
+
+func validateVerificationMethods(didURLs []string) error {
+	seen := make(map[string]struct{}, len(didURLs))
+
+	for _, didURL := range didURLs {
+		if !didURLPattern.MatchString(didURL) {
+			return fmt.Errorf("%w: verification method %q must be a DID URL with a fragment, without path or query",
+				errors.ErrValidation, didURL)
+		}
+
+		if _, ok := seen[didURL]; ok {
+			return fmt.Errorf("%w: duplicate verification method %q", errors.ErrValidation, didURL)
+		}
+
+		seen[didURL] = struct{}{}
+	}
+
+	return nil
+}
+
+// claimVerificationMethods returns a check that fails with VerificationMethodConflictError if any of the DID URLs is
+// registered for another key of the key store, or by another key store. DID URLs that pass the check are claimed for
+// the key store. The check runs under the sequence lock, so claims of concurrent requests don't interleave; a claim
+// left by an update that failed to save is ignored, as the claiming key store doesn't list the DID URL.
+func (c *Command) claimVerificationMethods(keyStoreID, keyID string,
+	didURLs []string) func(meta *keyStoreMeta) error {
+	return func(meta *keyStoreMeta) error {
+		for _, didURL := range didURLs {
+			if existing, ok := meta.VerificationMethods[didURL]; ok && existing != keyID {
+				return &VerificationMethodConflictError{
+					DIDURL: didURL,
+					KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, existing),
+				}
+			}
+
+			claimant, err := c.didURLClaimant(didURL)
+			if err != nil {
+				return err
+			}
+
+			if claimant != "" && claimant != keyStoreID {
+				return &VerificationMethodConflictError{DIDURL: didURL}
+			}
+		}
+
+		b, err := json.Marshal(didURLClaim{KeyStoreID: keyStoreID})
+		if err != nil {
+			return fmt.Errorf("marshal DID URL claim: %w", err)
+		}
+
+		for _, didURL := range didURLs {
+			if err = c.store.Put(didURLClaimID(didURL), b); err != nil {
+				return fmt.Errorf("claim DID URL: %w", err)
+			}
+		}
+
+		return nil
+	}
+}
+
+// didURLClaimant returns ID of the key store that registered the DID URL, or an empty string if no key store did.
+func (c *Command) didURLClaimant(didURL string) (string, error) {
+	b, err := c.store.Get(didURLClaimID(didURL))
+	if stderrors.Is(err, storage.ErrDataNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get DID URL claim: %w", err)
+	}
+
+	var claim didURLClaim
+
+	if err = json.Unmarshal(b, &claim); err != nil {
+		return "", fmt.Errorf("unmarshal DID URL claim: %w", err)
+	}
+
+	meta, err := c.getKeyStoreMeta(claim.KeyStoreID)
+	if stderrors.Is(err, storage.ErrDataNotFound) {
+		return "", nil // the key store was deleted
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get claimant key store: %w", err)
+	}
+
+	if _, ok := meta.VerificationMethods[didURL]; !ok {
+		return "", nil // the DID URL was removed from its key, or the update that claimed it failed
+	}
+
+	return claim.KeyStoreID, nil
+}
+
+// didURLClaimID returns ID of the claim record of the DID URL. DID URLs are hashed, as they may be longer than IDs
+// that storage providers accept.
+func didURLClaimID(didURL string) string {
+	sum := sha256.Sum256([]byte(didURL))
+
+	return didURLClaimPrefix + hex.EncodeToString(sum[:])
+}
+
+// setVerificationMethods replaces DID URLs registered for the key. An empty list removes them.
+func setVerificationMethods(keyID string, didURLs []string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		removeVerificationMethods(keyID)(meta)
+
+		if len(didURLs) == 0 {
+			return
+		}
+
+		if meta.VerificationMethods == nil {
+			meta.VerificationMethods = make(map[string]string)
+		}
+
+		for _, didURL := range didURLs {
+			meta.VerificationMethods[didURL] = keyID
+		}
+	}
+}
+
+// removeVerificationMethods removes DID URLs registered for the key.
+func removeVerificationMethods(keyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		for didURL, id := range meta.VerificationMethods {
+			if id == keyID {
+				delete(meta.VerificationMethods, didURL)
+			}
+		}
+	}
+}
+
+// moveVerificationMethods moves DID URLs registered for the key to another key (e.g. to the new key on rotation).
+func moveVerificationMethods(fromKeyID, toKeyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		for didURL, id := range meta.VerificationMethods {
+			if id == fromKeyID {
+				meta.VerificationMethods[didURL] = toKeyID
+			}
+		}
+	}
+}
+
+// verificationMethodsOf returns sorted DID URLs registered for the key.
+func (m *keyStoreMeta) verificationMethodsOf(keyID string) []string {
+	var didURLs []string
+
+	for didURL, id := range m.VerificationMethods {
+		if id == keyID {
+			didURLs = append(didURLs, didURL)
+		}
+	}
+
+	sort.Strings(didURLs)
+
+	return didURLs
+}
+
+// resolveKeyRef returns ID of the key referenced by its ID, alias or registered DID URL. It fails with
+// UnknownDIDURLError if the reference is a DID URL that isn't registered for a key of the key store.
+func (m *keyStoreMeta) resolveKeyRef(ref string) (string, error) {
+	if isDIDURL(ref) {
+		if _, ok := m.VerificationMethods[ref]; !ok {
+			return "", &UnknownDIDURLError{DIDURL: ref}
+		}
+	}
+
+	return m.keyID(ref), nil
+}
@@ -0,0 +1,292 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// dryRunRequest returns an empty request for the action, whether the action operates on an existing key and
+// whether dry run is supported for the action.
+func dryRunRequest(action string) (interface{}, bool, bool) { //nolint:gocyclo,cyclop
+	switch action {
+	case ActionGetKeyStore, ActionDeleteKeyStore:
+		return nil, false, true
+	case ActionUpdateKeyStore:
+		return &UpdateKeyStoreRequest{}, false, true
+	case ActionCreateKey:
+		return &CreateKeyRequest{}, false, true
+	case ActionCreateKeys:
+		return &CreateKeysRequest{}, false, true
+	case ActionListKeys:
+		return &ListKeysRequest{}, false, true
+	case ActionGetKey, ActionRestoreKey:
+		return nil, true, true
+	case ActionCreateToken:
+		return &CreateTokenRequest{}, true, true
+	case ActionInvitation:
+		return &CreateInvitationRequest{}, true, true
+	case ActionImportKey:
+		return &ImportKeyRequest{}, false, true
+	case ActionExportKey:
+		return nil, true, true
+	case ActionRotateKey:
+		return &RotateKeyRequest{}, true, true
+	case ActionUpdateKey:
+		return &UpdateKeyRequest{}, true, true
+	case ActionSetKeyState:
+		return &SetKeyStateRequest{}, true, true
+	case ActionDeleteKey:
+		return nil, true, true
+	case ActionSign:
+		return &SignRequest{}, true, true
+	case ActionSignBatch:
+		return &SignBatchRequest{}, true, true
+	case ActionSignMultiKey:
+		return &SignMultiKeyRequest{}, false, true // keys are in the items
+	case ActionSignJWT:
+		return &SignJWTRequest{}, true, true
+	case ActionSignUpload:
+		return nil, true, true // shared by the upload routes, only the key is checked
+	case ActionVerify:
+		return &VerifyRequest{}, true, true
+	case ActionEncrypt:
+		return &EncryptRequest{}, true, true
+	case ActionDecrypt:
+		return &DecryptRequest{}, true, true
+	case ActionComputeMac:
+		return &ComputeMACRequest{}, true, true
+	case ActionVerifyMAC:
+		return &VerifyMACRequest{}, true, true
+	case ActionSignMulti:
+		return &SignMultiRequest{}, true, true
+	case ActionVerifyMulti:
+		return &VerifyMultiRequest{}, true, true
+	case ActionDeriveProof:
+		return &DeriveProofRequest{}, true, true
+	case ActionVerifyProof:
+		return &VerifyProofRequest{}, true, true
+	case ActionWrap:
+		return &WrapKeyRequest{}, false, true
+	case ActionUnwrap:
+		return &UnwrapKeyRequest{}, true, true
+	case ActionEncryptJWE:
+		return &EncryptJWERequest{}, false, true // encrypted to the public keys of the recipients
+	case ActionDecryptJWE:
+		return &DecryptJWERequest{}, true, true
+	case ActionDeriveKey:
+		return nil, true, true // only the key is checked
+	case ActionDeriveSubkey:
+		return &DeriveSubkeyRequest{}, true, true
+	case ActionEasy:
+		return &EasyRequest{}, true, true
+	case ActionEasyOpen:
+		return &EasyOpenRequest{}, true, true
+	case ActionSealOpen:
+		return &SealOpenRequest{}, true, true
+	default:
+		return nil, false, false
+	}
+}
+
+// Validate validates a key operation request without executing it: the request is decoded and checked, and the key
+// store must exist. Neither key material nor secret shares are accessed, and no state is changed.
+func (c *Command) Validate(action string, r io.Reader) error {
+	req, needsKey, ok := dryRunRequest(action)
+	if !ok {
+		return fmt.Errorf("%w: dry run is not supported for action %s", errors.ErrValidation, action)
+	}
+
+	wr, err := c.unwrapRequest(req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	// signatures verified with a public key of the request don't need a key of the key store
+	if rq, isVerify := req.(*VerifyRequest); isVerify && rq.hasPublicKey() {
+		if _, err = c.requestPublicKeyHandle(rq); err != nil {
+			return err
+		}
+
+		needsKey = false
+	}
+
+	if needsKey && wr.KeyID == "" {
+		return fmt.Errorf("%w: key id must be non-empty", errors.ErrValidation)
+	}
+
+	if err = c.validateDryRun(action, req, wr); err != nil {
+		return err
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", err)
+	}
+
+	// a restored key is a deleted one
+	if needsKey && action != ActionRestoreKey && meta.keyDeletedAt(meta.keyID(wr.KeyID)) != nil {
+		return fmt.Errorf("%w: key %s", errors.ErrNotFound, wr.KeyID)
+	}
+
+	if rq, ok := req.(*SignMultiKeyRequest); ok {
+		for _, item := range rq.Items {
+			keyID := meta.keyID(item.KeyID)
+
+			if err = c.checkKeyPurpose(wr.KeyStoreID, keyID, KeyPurposeSign, meta); err != nil {
+				return err
+			}
+
+			if err = c.checkKeyActive(wr.KeyStoreID, keyID, meta); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = c.checkKeyPurpose(wr.KeyStoreID, meta.keyID(wr.KeyID), actionPurpose(action), meta); err != nil {
+		return err
+	}
+
+	if needsActiveKey(action) {
+		return c.checkKeyActive(wr.KeyStoreID, meta.keyID(wr.KeyID), meta)
+	}
+
+	return nil
+}
+
+// validateDryRun checks the decoded request of the action the way the action does before it resolves the key
+// store.
+func (c *Command) validateDryRun(action string, req interface{}, wr *WrappedRequest) error { //nolint:gocyclo,cyclop
+	var err error
+
+	switch rq := req.(type) {
+	case *UpdateKeyStoreRequest:
+		if err = rq.Validate(); err != nil {
+			return fmt.Errorf("validate request: %w", err)
+		}
+	case *CreateKeyRequest:
+		if err = c.validateDryRunCreateKey(rq); err != nil {
+			return err
+		}
+	case *CreateKeysRequest:
+		for i := range rq.Keys {
+			if err = c.validateDryRunCreateKey(&rq.Keys[i]); err != nil {
+				return fmt.Errorf("key %d: %w", i, err)
+			}
+		}
+	case *RotateKeyRequest:
+		if err = c.validateExpiresAt(rq.ExpiresAt); err != nil {
+			return err
+		}
+	case *UpdateKeyRequest:
+		if rq.Alias != "" {
+			if err = validateAlias(rq.Alias); err != nil {
+				return err
+			}
+		}
+	case *SetKeyStateRequest:
+		if err = validateKeyState(rq.State); err != nil {
+			return err
+		}
+	case *ImportKeyRequest:
+		if err = checkImportKeyType(rq.KeyType); err != nil {
+			return err
+		}
+
+		if _, err = parsePrivateKey(rq); err != nil {
+			return fmt.Errorf("parse private key: %w", err)
+		}
+	case *SignRequest:
+		if err = checkSignatureEncoding(rq.ResponseEncoding); err != nil {
+			return err
+		}
+
+		if err = rq.checkPrehashed(); err != nil {
+			return err
+		}
+	case *VerifyRequest:
+		if err = rq.decodeSignature(); err != nil {
+			return err
+		}
+	case *SignBatchRequest:
+		if len(rq.Messages) == 0 || len(rq.Messages) > c.maxSignBatchSize {
+			return fmt.Errorf("%w: number of messages must be from 1 to %d", errors.ErrValidation, c.maxSignBatchSize)
+		}
+	case *SignMultiKeyRequest:
+		if err = c.validateSignMultiKeyRequest(rq); err != nil {
+			return err
+		}
+	case *EncryptJWERequest:
+		if err = validateJWEAlgorithms(rq.Alg, rq.Enc); err != nil {
+			return err
+		}
+
+		if err = validateJWERecipients(rq.Recipients, rq.Serialization); err != nil {
+			return err
+		}
+	case *EasyRequest:
+		if err = validateCryptoBoxParams(rq.Nonce, rq.TheirPub); err != nil {
+			return err
+		}
+	case *EasyOpenRequest:
+		if err = validateCryptoBoxParams(rq.Nonce, rq.TheirPub); err != nil {
+			return err
+		}
+	case *DeriveSubkeyRequest:
+		if err = validateDeriveSubkey(rq); err != nil {
+			return err
+		}
+	case *ListKeysRequest:
+		return validateFields(wr.Fields, keyInfoFields...)
+	case nil:
+		switch action {
+		case ActionExportKey:
+			if err = validateExportFormat(wr.Format, wr.Fields); err != nil {
+				return fmt.Errorf("validate fields: %w", err)
+			}
+		case ActionGetKey:
+			return validateFields(wr.Fields, getKeyFields...)
+		case ActionGetKeyStore:
+			return validateFields(wr.Fields, getKeyStoreFields...)
+		}
+	}
+
+	return nil
+}
+
+func (c *Command) validateDryRunCreateKey(rq *CreateKeyRequest) error {
+	if rq.KeyType == "" {
+		return fmt.Errorf("%w: key type must be non-empty", errors.ErrValidation)
+	}
+
+	if rq.Alias != "" {
+		if err := validateAlias(rq.Alias); err != nil {
+			return err
+		}
+	}
+
+	if err := c.validateExpiresAt(rq.ExpiresAt); err != nil {
+		return err
+	}
+
+	return validateKeyPurposes(rq.Purposes)
+}
+
+// needsActiveKey returns whether the action creates new artifacts with the key, so it fails with a disabled or
+// expired key.
+func needsActiveKey(action string) bool {
+	switch action {
+	case ActionSign, ActionSignBatch, ActionSignMulti, ActionSignJWT, ActionSignUpload, ActionEncrypt, ActionComputeMac,
+		ActionWrap, ActionEasy, ActionInvitation:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+//go:build go1.18
+
+package command_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/trustbloc/kms/pkg/controller/command"
+	kmserrors "github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+)
+
+// FuzzSignRequest checks that the sign handler doesn't panic on arbitrary request bodies, and rejects bodies that
+// exceed the request limits with 400 Bad Request.
+func FuzzSignRequest(f *testing.F) {
+	for _, seed := range []string{
+		`{"message": "dGVzdCBtZXNzYWdl"}`,
+		`{"message": "dGVzdA==", "nonce": "n1"}`,
+		`{"document": {"b": 1, "a": [true, null]}, "canonicalization": "jcs"}`,
+		`{"messages": ["MQ==", "Mg=="]}`,
+		`{"document": [[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]}`,
+		`{"message": 1}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, req []byte) {
+		env, keyStoreID, keyID := newFuzzEnv(t)
+
+		err := env.Sign(io.Discard, wrapRawKeyStoreRequest(t, keyStoreID, keyID, string(req)))
+		checkLimitError(t, req, err)
+	})
+}
+
+// FuzzSignBatchRequest checks that the sign batch handler doesn't panic on arbitrary request bodies, and rejects
+// bodies that exceed the request limits with 400 Bad Request.
+func FuzzSignBatchRequest(f *testing.F) {
+	for _, seed := range []string{
+		`{"messages": ["bWVzc2FnZSAx", "bWVzc2FnZSAy"]}`,
+		`{"messages": []}`,
+		`{"messages": [` + strings.Repeat(`"MQ==", `, 20) + `"MQ=="]}`,
+		`{"messages": [[["MQ=="]]]}`,
+		`{"messages": null}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, req []byte) {
+		env, keyStoreID, keyID := newFuzzEnv(t)
+
+		err := env.SignBatch(io.Discard, wrapRawKeyStoreRequest(t, keyStoreID, keyID, string(req)))
+		checkLimitError(t, req, err)
+	})
+}
+
+// fuzzLimits are lower than the defaults, so that the fuzzer reaches them.
+var fuzzLimits = jsonlimit.Limits{MaxDepth: 8, MaxArrayLength: 16, MaxStringLength: 256} //nolint:gochecknoglobals
+
+func newFuzzEnv(t *testing.T) (*Command, string, string) {
+	t.Helper()
+
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoCanonicalizeTime(gomock.Any(), gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().RequestLimitRejection(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics), withRequestLimits(fuzzLimits))
+
+	var keyStoreResp CreateKeyStoreResponse
+
+	err := env.cmd.CreateKeyStore(encodeResponse(t, &keyStoreResp), wrapKeyStoreRequest(t, "", "",
+		CreateKeyStoreRequest{Controller: "did:example:controller"}))
+	require.NoError(t, err)
+
+	keyStoreID := strings.TrimPrefix(keyStoreResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+	var keyResp CreateKeyResponse
+
+	err = env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+		CreateKeyRequest{KeyType: kms.ED25519Type}))
+	require.NoError(t, err)
+
+	return env.cmd, keyStoreID, keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:]
+}
+
+func checkLimitError(t *testing.T, req []byte, err error) {
+	t.Helper()
+
+	var limitErr *jsonlimit.LimitError
+
+	if !errors.As(jsonlimit.Check(req, fuzzLimits), &limitErr) {
+		return
+	}
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), limitErr.Error())
+	require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+}
@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GetKey returns metadata of the key and, if the key is asymmetric, its public key. Private key material is never
+// returned.
+func (c *Command) GetKey(w io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateFields(wr.Fields, getKeyFields...); err != nil {
+		return err
+	}
+
+	ks, meta, _, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if wr.KeyID, err = meta.resolveKeyRef(wr.KeyID); err != nil {
+		return err
+	}
+
+	if _, err = ks.Get(wr.KeyID); err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	resp := GetKeyResponse{
+		Alias:               meta.aliasOf(wr.KeyID),
+		VerificationMethods: meta.verificationMethodsOf(wr.KeyID),
+		State:               meta.keyState(wr.KeyID),
+		Purposes:            meta.keyPurposes(wr.KeyID),
+		Origin:              meta.keyOrigin(wr.KeyID),
+		Hash:                meta.Keys[wr.KeyID].Hash,
+	}
+
+	// keys created before the key store started to track its keys have no metadata, or only the state
+	if km, ok := meta.Keys[wr.KeyID]; ok && !km.CreatedAt.IsZero() {
+		createdAt := km.CreatedAt
+
+		resp.KeyType = string(km.KeyType)
+		resp.CreatedAt = &createdAt
+		resp.ExpiresAt = km.ExpiresAt
+		resp.SchemaVersion = km.SchemaVersion
+	}
+
+	if resp.LastUsedAt, err = c.keyLastUsed(wr.KeyStoreID, wr.KeyID); err != nil {
+		return err
+	}
+
+	pub, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
+	if err != nil && !strings.Contains(err.Error(), "failed to get public keyset handle") {
+		return fmt.Errorf("export public key bytes: %w", err)
+	}
+
+	if err == nil {
+		resp.PublicKey = pub
+		resp.Exportable = true
+
+		if resp.KeyType == "" {
+			resp.KeyType = string(kt)
+		}
+	}
+
+	return encodeFields(w, resp, wr.Fields)
+}
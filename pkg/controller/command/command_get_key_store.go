@@ -0,0 +1,53 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// Storage types of the key store.
+const (
+	StorageTypeLocal = "local"
+	StorageTypeEDV   = "edv"
+)
+
+// GetKeyStore returns metadata of the key store.
+func (c *Command) GetKeyStore(w io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateFields(wr.Fields, getKeyStoreFields...); err != nil {
+		return err
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	return encodeFields(w, GetKeyStoreResponse{
+		Controller:    meta.Controller,
+		CreatedAt:     meta.CreatedAt,
+		StorageType:   meta.storageType(),
+		KeyCount:      len(meta.KeyIDs),
+		Sequence:      meta.Sequence,
+		SchemaVersion: meta.SchemaVersion,
+	}, wr.Fields)
+}
+
+// storageType returns the type of storage of user's keys.
+func (m *keyStoreMeta) storageType() string {
+	if m.EDV.VaultURL != "" {
+		return StorageTypeEDV
+	}
+
+	return StorageTypeLocal
+}
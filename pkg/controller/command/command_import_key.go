@@ -0,0 +1,344 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/primitive/bbs12381g2pub"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/multiformats/go-multibase"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+)
+
+// importableKeyTypes are types of private keys that can be imported.
+var importableKeyTypes = []kms.KeyType{ //nolint:gochecknoglobals
+	kms.ED25519Type,
+	kms.ECDSAP256TypeDER,
+	kms.ECDSAP384TypeDER,
+	kms.ECDSAP521TypeDER,
+	kms.ECDSAP256TypeIEEEP1363,
+	kms.ECDSAP384TypeIEEEP1363,
+	kms.ECDSAP521TypeIEEEP1363,
+	kms.BLS12381G2Type,
+	secp256k1.KeyTypeDER,
+	secp256k1.KeyTypeIEEEP1363,
+}
+
+// KeyOrigin is where the key material comes from.
+type KeyOrigin string
+
+// Origins of keys that were not generated by the key store. Keys generated by the key store, including keys rotated
+// from imported or derived keys, have no origin.
+const (
+	KeyOriginImported KeyOrigin = "imported" // imported with ImportKey
+	KeyOriginDerived  KeyOrigin = "derived"  // derived from another key of the key store with DeriveSubkey
+)
+
+// bls12381Order is the order r of the BLS12-381 groups; a BLS12-381 private key is a scalar in [1, r-1].
+var bls12381Order, _ = new(big.Int).SetString( //nolint:gochecknoglobals
+	"73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+
+// ImportKey imports a private key. The key is stored the same way as keys created by the key store, and is marked
+// imported in its metadata.
+func (c *Command) ImportKey(w io.Writer, r io.Reader) error {
+	var req ImportKeyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	ks, err := c.resolveKeyStore(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if err = checkImportKeyType(req.KeyType); err != nil {
+		return err
+	}
+
+	privateKey, err := parsePrivateKey(&req)
+	if err != nil {
+		return fmt.Errorf("parse private key: %w", err)
+	}
+
+	if err = checkExpectedPublicKey(privateKey, &req); err != nil {
+		return err
+	}
+
+	var opts []kms.PrivateKeyOpts
+
+	if req.KeyID != "" {
+		opts = append(opts, kms.WithKeyID(req.KeyID))
+	}
+
+	kid, _, err := ks.ImportPrivateKey(privateKey, req.KeyType, opts...)
+	if err != nil {
+		return fmt.Errorf("import private key: %w", err)
+	}
+
+	pub, err := exportPubKeyBytes(ks, kid)
+	if err != nil {
+		return err
+	}
+
+	seq, err := c.incrementSequence(wr.KeyStoreID, addKeyID(kid, req.KeyType, c.clock.Now().UTC()),
+		setKeyFingerprint(kid, pub), setKeyOrigin(kid, KeyOriginImported))
+	if err != nil {
+		return fmt.Errorf("increment sequence: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(ImportKeyResponse{
+		KeyURL:        fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, kid),
+		PublicKey:     pub,
+		Sequence:      seq,
+		SchemaVersion: SchemaVersion,
+	})
+}
+
+func checkImportKeyType(kt kms.KeyType) error {
+	for _, t := range importableKeyTypes {
+		if kt == t {
+			return nil
+		}
+	}
+
+	types := make([]string, len(importableKeyTypes))
+
+	for i, t := range importableKeyTypes {
+		types[i] = string(t)
+	}
+
+	return fmt.Errorf("%w: not supported key type: %s, importable key types: %s",
+		errors.ErrUnprocessableEntity, kt, strings.Join(types, ", "))
+}
+
+// parsePrivateKey parses the key of the request and checks it matches the declared key type.
+func parsePrivateKey(req *ImportKeyRequest) (interface{}, error) {
+	var (
+		privateKey interface{}
+		err        error
+	)
+
+	switch {
+	case len(req.JWK) > 0 && len(req.Key) > 0:
+		return nil, fmt.Errorf("%w: key and jwk are mutually exclusive", errors.ErrValidation)
+	case req.KeyMultibase != "" && (len(req.JWK) > 0 || len(req.Key) > 0):
+		return nil, fmt.Errorf("%w: key_multibase is mutually exclusive with key and jwk", errors.ErrValidation)
+	case len(req.JWK) > 0:
+		privateKey, err = parseJWK(req.JWK)
+	case req.KeyMultibase != "":
+		privateKey, err = parseKeyMultibase(req.KeyMultibase, req.KeyType)
+	default:
+		privateKey, err = parseKeyBytes(req.Key, req.KeyType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !keyMatchesType(privateKey, req.KeyType) {
+		return nil, fmt.Errorf("%w: key does not match key type %s", errors.ErrValidation, req.KeyType)
+	}
+
+	return privateKey, nil
+}
+
+func parseJWK(b []byte) (interface{}, error) {
+	var j jwk.JWK
+
+	if err := j.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("%w: unmarshal jwk: %s", errors.ErrValidation, err)
+	}
+
+	switch j.Key.(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey:
+		return j.Key, nil
+	default:
+		return nil, fmt.Errorf("%w: jwk is not an Ed25519 or ECDSA private key", errors.ErrValidation)
+	}
+}
+
+// parseKeyMultibase parses a multibase-encoded raw private key of the given type.
+func parseKeyMultibase(s string, kt kms.KeyType) (interface{}, error) {
+	_, b, err := multibase.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode key_multibase: %s", errors.ErrValidation, err)
+	}
+
+	return parseKeyBytes(b, kt)
+}
+
+// parseKeyBytes parses a PKCS #8 private key or a raw private key of the given type: an Ed25519 seed or private key,
+// a big-endian ECDSA private scalar or a big-endian BLS12-381 private scalar.
+func parseKeyBytes(b []byte, kt kms.KeyType) (interface{}, error) {
+	if privateKey, err := x509.ParsePKCS8PrivateKey(b); err == nil {
+		return privateKey, nil
+	}
+
+	if kt == kms.BLS12381G2Type {
+		return parseBBSKey(b)
+	}
+
+	if kt == kms.ED25519Type {
+		switch len(b) {
+		case ed25519.SeedSize:
+			return ed25519.NewKeyFromSeed(b), nil
+		case ed25519.PrivateKeySize:
+			return ed25519.PrivateKey(b), nil
+		}
+	} else if curve := ecdsaCurve(kt); curve != nil && len(b) == (curve.Params().BitSize+7)/8 {
+		d := new(big.Int).SetBytes(b)
+
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, fmt.Errorf("%w: invalid ECDSA private key", errors.ErrValidation)
+		}
+
+		privateKey := &ecdsa.PrivateKey{D: d}
+		privateKey.Curve = curve
+		privateKey.X, privateKey.Y = curve.ScalarBaseMult(b)
+
+		return privateKey, nil
+	}
+
+	return nil, fmt.Errorf("%w: key is neither a PKCS #8 nor a raw %s private key", errors.ErrValidation, kt)
+}
+
+// parseBBSKey parses a raw BLS12-381 private key. bbs12381g2pub reduces scalars modulo the group order, so keys out
+// of range are rejected here rather than silently turned into other keys.
+func parseBBSKey(b []byte) (*bbs12381g2pub.PrivateKey, error) {
+	const scalarSize = 32
+
+	if len(b) != scalarSize {
+		return nil, fmt.Errorf("%w: invalid BLS12381G2 private key size %d", errors.ErrValidation, len(b))
+	}
+
+	d := new(big.Int).SetBytes(b)
+
+	if d.Sign() == 0 || d.Cmp(bls12381Order) >= 0 {
+		return nil, fmt.Errorf("%w: BLS12381G2 private key is out of range", errors.ErrValidation)
+	}
+
+	privateKey, err := bbs12381g2pub.UnmarshalPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid BLS12381G2 private key: %s", errors.ErrValidation, err)
+	}
+
+	return privateKey, nil
+}
+
+// checkExpectedPublicKey checks the public key of the private key matches the expected public key of the request,
+// if any.
+func checkExpectedPublicKey(privateKey interface{}, req *ImportKeyRequest) error {
+	if len(req.ExpectedPublicKey) == 0 {
+		return nil
+	}
+
+	pub, err := publicKeyBytes(privateKey, req.KeyType)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(pub, req.ExpectedPublicKey) {
+		return fmt.Errorf("%w: public key of the private key does not match expected public key", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// publicKeyBytes returns the public key of the private key in the format the key store exports public keys of the
+// key type.
+func publicKeyBytes(privateKey interface{}, kt kms.KeyType) ([]byte, error) {
+	switch k := privateKey.(type) {
+	case ed25519.PrivateKey:
+		return []byte(k.Public().(ed25519.PublicKey)), nil //nolint:forcetypeassert
+	case *ecdsa.PrivateKey:
+		return ecdsaPublicKeyBytes(&k.PublicKey, kt)
+	case *bbs12381g2pub.PrivateKey:
+		b, err := k.PublicKey().Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("marshal public key: %w", err)
+		}
+
+		return b, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported private key", errors.ErrValidation)
+	}
+}
+
+// setKeyOrigin sets the origin of the key. It must follow addKeyID, which replaces metadata of the key.
+func setKeyOrigin(keyID string, origin KeyOrigin) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok {
+			return
+		}
+
+		km.Origin = origin
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// keyOrigin returns the origin of the key, or an empty origin if the key was generated by the key store.
+func (m *keyStoreMeta) keyOrigin(keyID string) KeyOrigin {
+	if km, ok := m.Keys[keyID]; ok {
+		return km.Origin
+	}
+
+	return ""
+}
+
+// keyMatchesType checks the private key is of the key type and its public key is derived from the private part.
+func keyMatchesType(privateKey interface{}, kt kms.KeyType) bool {
+	switch k := privateKey.(type) {
+	case ed25519.PrivateKey:
+		return kt == kms.ED25519Type && len(k) == ed25519.PrivateKeySize &&
+			ed25519.NewKeyFromSeed(k.Seed()).Equal(k)
+	case *ecdsa.PrivateKey:
+		curve := ecdsaCurve(kt)
+		if curve == nil || k.Curve == nil || k.Curve.Params().Name != curve.Params().Name || k.D == nil {
+			return false
+		}
+
+		x, y := curve.ScalarBaseMult(k.D.Bytes())
+
+		return x.Cmp(k.X) == 0 && y.Cmp(k.Y) == 0
+	case *bbs12381g2pub.PrivateKey:
+		return kt == kms.BLS12381G2Type
+	default:
+		return false
+	}
+}
+
+func ecdsaCurve(kt kms.KeyType) elliptic.Curve {
+	switch kt { //nolint:exhaustive
+	case kms.ECDSAP256TypeDER, kms.ECDSAP256TypeIEEEP1363:
+		return elliptic.P256()
+	case kms.ECDSAP384TypeDER, kms.ECDSAP384TypeIEEEP1363:
+		return elliptic.P384()
+	case kms.ECDSAP521TypeDER, kms.ECDSAP521TypeIEEEP1363:
+		return elliptic.P521()
+	case secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363:
+		return secp256k1.Curve()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,252 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/kid/resolver"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const (
+	// JWEAlgECDHESA256KW is the only supported JWE key management algorithm: ECDH-ES key agreement with the
+	// recipient key, and AES-256 key wrap of the content encryption key.
+	JWEAlgECDHESA256KW = "ECDH-ES+A256KW"
+	// JWEEncA256GCM is the only supported JWE content encryption algorithm.
+	JWEEncA256GCM = "A256GCM"
+
+	// JWESerializationJSON is the JSON serialization of a JWE (RFC 7516, section 7.2).
+	JWESerializationJSON = "json"
+	// JWESerializationCompact is the compact serialization of a JWE (RFC 7516, section 7.1).
+	JWESerializationCompact = "compact"
+)
+
+// EncryptJWE encrypts a plaintext as a JWE for the recipient public keys. Content is encrypted with A256GCM, and the
+// content encryption key is wrapped for each recipient with ECDH-ES+A256KW (anoncrypt).
+func (c *Command) EncryptJWE(w io.Writer, r io.Reader) error {
+	var req EncryptJWERequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateJWEAlgorithms(req.Alg, req.Enc); err != nil {
+		return err
+	}
+
+	if err = validateJWERecipients(req.Recipients, req.Serialization); err != nil {
+		return err
+	}
+
+	typ := "JOSE+JSON"
+	if req.Serialization == JWESerializationCompact {
+		typ = "JOSE"
+	}
+
+	encrypter, err := jose.NewJWEEncrypt(jose.A256GCM, typ, "", "", nil, req.Recipients, c.crypto)
+	if err != nil {
+		return fmt.Errorf("create jwe encrypter: %w", err)
+	}
+
+	var jwe *jose.JSONWebEncryption
+
+	if err = c.runCrypto(wr, func() error {
+		var encErr error
+
+		jwe, encErr = encrypter.Encrypt(req.Plaintext)
+
+		return encErr
+	}); err != nil {
+		return fmt.Errorf("encrypt jwe: %w", err)
+	}
+
+	var serialized string
+
+	if req.Serialization == JWESerializationCompact {
+		serialized, err = jwe.CompactSerialize(json.Marshal)
+	} else {
+		serialized, err = jwe.FullSerialize(json.Marshal)
+	}
+
+	if err != nil {
+		return fmt.Errorf("serialize jwe: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(EncryptJWEResponse{JWE: serialized})
+}
+
+// DecryptJWE decrypts a JWE in compact or JSON serialization with the key as the recipient key, so that the private
+// key never leaves the server.
+func (c *Command) DecryptJWE(w io.Writer, r io.Reader) error {
+	var req DecryptJWERequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	jwe, err := jose.Deserialize(strings.TrimSpace(req.JWE))
+	if err != nil {
+		return fmt.Errorf("%w: invalid jwe: %s", errors.ErrValidation, err.Error())
+	}
+
+	alg, enc := jweAlgorithms(jwe)
+
+	if alg == "" || enc == "" {
+		return fmt.Errorf("%w: jwe must have alg and enc headers", errors.ErrValidation)
+	}
+
+	if err = validateJWEAlgorithms(alg, enc); err != nil {
+		return err
+	}
+
+	kh, err := c.getKeyHandleFromRequest(KeyPurposeUnwrap, wr)
+	if err != nil {
+		return err
+	}
+
+	// key types aren't recorded in the metadata of keys created by older versions
+	switch wr.keyType {
+	case "", kms.NISTP256ECDHKWType, kms.NISTP384ECDHKWType, kms.NISTP521ECDHKWType:
+	default:
+		return fmt.Errorf("%w: key %s of type %s can't decrypt a JWE, supported: %s, %s, %s",
+			errors.ErrUnprocessableEntity, wr.KeyID, wr.keyType, kms.NISTP256ECDHKWType, kms.NISTP384ECDHKWType,
+			kms.NISTP521ECDHKWType)
+	}
+
+	recipient := &recipientKey{keyID: wr.KeyID, kh: kh}
+	decrypter := jose.NewJWEDecrypt([]resolver.KIDResolver{recipient}, c.crypto, recipient)
+
+	var plaintext []byte
+
+	if err = c.runCrypto(wr, func() error {
+		var decErr error
+
+		plaintext, decErr = decrypter.Decrypt(jwe)
+
+		return decErr
+	}); err != nil {
+		if stderrors.Is(err, errors.ErrTooManyRequests) {
+			return fmt.Errorf("decrypt jwe: %w", err)
+		}
+
+		return fmt.Errorf("decrypt jwe: %w", &DecryptionFailedError{
+			KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, wr.KeyID),
+		})
+	}
+
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
+	return json.NewEncoder(w).Encode(DecryptJWEResponse{Plaintext: plaintext})
+}
+
+// validateJWEAlgorithms fails with ErrUnprocessableEntity unless the algorithms are supported. Empty algorithms stand
+// for the defaults.
+func validateJWEAlgorithms(alg, enc string) error {
+	if alg != "" && alg != JWEAlgECDHESA256KW {
+		return fmt.Errorf("%w: alg %s is not supported, supported: %s", errors.ErrUnprocessableEntity, alg,
+			JWEAlgECDHESA256KW)
+	}
+
+	if enc != "" && enc != JWEEncA256GCM {
+		return fmt.Errorf("%w: enc %s is not supported, supported: %s", errors.ErrUnprocessableEntity, enc,
+			JWEEncA256GCM)
+	}
+
+	return nil
+}
+
+// validateJWERecipients checks that the recipient keys are NIST P curve keys that ECDH-ES+A256KW can wrap for, and
+// that the serialization can hold them.
+func validateJWERecipients(recipients []*crypto.PublicKey, serialization string) error {
+	switch serialization {
+	case "", JWESerializationJSON:
+	case JWESerializationCompact:
+		if len(recipients) > 1 {
+			return fmt.Errorf("%w: compact serialization allows a single recipient", errors.ErrValidation)
+		}
+	default:
+		return fmt.Errorf("%w: serialization %s is not supported, supported: %s, %s", errors.ErrUnprocessableEntity,
+			serialization, JWESerializationJSON, JWESerializationCompact)
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("%w: recipients must be non-empty", errors.ErrValidation)
+	}
+
+	for i, key := range recipients {
+		if key == nil || len(key.X) == 0 || len(key.Y) == 0 {
+			return fmt.Errorf("%w: recipient %d must have x and y coordinates", errors.ErrValidation, i)
+		}
+
+		if key.Type != "EC" || !isJWECurve(key.Curve) {
+			return fmt.Errorf("%w: recipient %d: %s key on curve %s is not supported with %s, supported: "+
+				"EC keys on P-256, P-384 and P-521 curves", errors.ErrUnprocessableEntity, i, key.Type, key.Curve,
+				JWEAlgECDHESA256KW)
+		}
+	}
+
+	return nil
+}
+
+func isJWECurve(curve string) bool {
+	switch curve {
+	case "NIST_P256", "P-256", "NIST_P384", "P-384", "NIST_P521", "P-521":
+		return true
+	default:
+		return false
+	}
+}
+
+// jweAlgorithms returns the alg and enc headers of the JWE. alg is in the protected headers for a single recipient,
+// or in the recipient headers otherwise; it's returned empty if the recipients use different algorithms.
+func jweAlgorithms(jwe *jose.JSONWebEncryption) (string, string) {
+	enc, _ := jwe.ProtectedHeaders.Encryption() // empty enc is reported by the caller
+
+	alg, ok := jwe.ProtectedHeaders.Algorithm()
+	if ok {
+		return alg, enc
+	}
+
+	for _, rec := range jwe.Recipients {
+		if rec.Header == nil || rec.Header.Alg == "" || (alg != "" && alg != rec.Header.Alg) {
+			return "", enc
+		}
+
+		alg = rec.Header.Alg
+	}
+
+	return alg, enc
+}
+
+// recipientKey makes JWEDecrypt unwrap the content encryption key with the key of the request, whatever kid the JWE
+// has for its recipients: recipients wrapped for other keys just fail to unwrap. Only Get of kms.KeyManager is used.
+type recipientKey struct {
+	kms.KeyManager
+	keyID string
+	kh    interface{}
+}
+
+// Get returns the key of the request.
+func (k *recipientKey) Get(string) (interface{}, error) {
+	return k.kh, nil
+}
+
+// Resolve resolves did:key and DID URL kids to the key of the request.
+func (k *recipientKey) Resolve(string) (*crypto.PublicKey, error) {
+	return &crypto.PublicKey{KID: k.keyID}, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// KeyExpiredCode is an error code returned in the body of a request rejected because the key expired.
+const KeyExpiredCode = "KEY_EXPIRED"
+
+// KeyExpiredError is returned when an operation that needs an active key is requested with an expired key.
+type KeyExpiredError struct {
+	KeyURL    string
+	ExpiresAt time.Time
+}
+
+func (e *KeyExpiredError) Error() string {
+	return fmt.Sprintf("%s: key %s expired at %s", errors.ErrConflict.Error(), e.KeyURL,
+		e.ExpiresAt.Format(time.RFC3339))
+}
+
+// Unwrap returns ErrConflict, so that the error is reported with 409 status.
+func (e *KeyExpiredError) Unwrap() error {
+	return errors.ErrConflict
+}
+
+// validateExpiresAt checks that the optional expiration time of a new key is in the future.
+func (c *Command) validateExpiresAt(expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(c.clock.Now()) {
+		return fmt.Errorf("%w: expires_at must be in the future", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// checkKeyNotExpired fails with KeyExpiredError if the key expired more than the allowed clock skew ago.
+func (c *Command) checkKeyNotExpired(keyStoreID, keyID string, meta *keyStoreMeta) error {
+	expiresAt := meta.keyExpiry(keyID)
+
+	if expiresAt != nil && !c.clock.Now().Before(expiresAt.Add(c.keyExpiryClockSkew)) {
+		return &KeyExpiredError{
+			KeyURL:    fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, keyID),
+			ExpiresAt: *expiresAt,
+		}
+	}
+
+	return nil
+}
+
+// setKeyExpiry sets the expiration time of the key. It must follow addKeyID, which replaces metadata of the key.
+func setKeyExpiry(keyID string, expiresAt *time.Time) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok || expiresAt == nil {
+			return
+		}
+
+		t := expiresAt.UTC()
+		km.ExpiresAt = &t
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// keyExpiry returns the expiration time of the key, or nil if the key doesn't expire.
+func (m *keyStoreMeta) keyExpiry(keyID string) *time.Time {
+	if km, ok := m.Keys[keyID]; ok {
+		return km.ExpiresAt
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/didkey"
+)
+
+// GetKeyFingerprint returns the multibase fingerprint of the public key, as used in its did:key, and its JWK
+// thumbprint, so that clients don't need to export the key and derive them.
+func (c *Command) GetKeyFingerprint(w io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	ks, err := c.resolveKeyStoreForKey(wr)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	pub, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("export public key bytes: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	fingerprint, err := keyFingerprint(pub, kt)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(fingerprint)
+}
+
+func keyFingerprint(pub []byte, kt kms.KeyType) (*GetKeyFingerprintResponse, error) {
+	didKey, err := didkey.FromPublicKey(pub, kt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key of type %s has no fingerprint: %s", errors.ErrBadRequest, kt, err)
+	}
+
+	thumbprint, err := jwkThumbprint(pub, kt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GetKeyFingerprintResponse{
+		Fingerprint:   didKey.Fingerprint,
+		DID:           didKey.DID,
+		JWKThumbprint: thumbprint,
+	}, nil
+}
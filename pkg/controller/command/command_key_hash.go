@@ -0,0 +1,109 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/ecdsahash"
+)
+
+// validateKeyHash validates the hash of a create key request. Only NIST ECDSA keys have a selectable hash, and only
+// hashes at least as strong as the curve are allowed.
+func validateKeyHash(kt kms.KeyType, hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	if err := ecdsahash.Check(kt, hash); err != nil {
+		return fmt.Errorf("%w: %s", errors.ErrValidation, err.Error())
+	}
+
+	return nil
+}
+
+// setKeyHash records the hash of the key. The hash of the key type isn't recorded, so that keys created with it work
+// exactly like keys created without a hash.
+func setKeyHash(keyID string, kt kms.KeyType, hash string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok || hash == "" || ecdsahash.IsDefault(kt, hash) {
+			return
+		}
+
+		km.Hash = hash
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// checkRotatedKeyHash fails if the rotated key has a hash that keys of the new key type can't sign with. A rotated
+// key keeps its hash, like its purposes.
+func checkRotatedKeyHash(meta *keyStoreMeta, keyID string, kt kms.KeyType) error {
+	hash := meta.Keys[keyID].Hash
+	if hash == "" {
+		return nil
+	}
+
+	if err := ecdsahash.Check(kt, hash); err != nil {
+		return fmt.Errorf("%w: key %s signs with %s: %s", errors.ErrValidation, keyID, hash, err.Error())
+	}
+
+	return nil
+}
+
+// signer returns the function that signs messages with the key of the request: the crypto, or ecdsahash for keys
+// that don't sign with the hash of their key type.
+func (c *Command) signer(wr *WrappedRequest) func([]byte, interface{}) ([]byte, error) {
+	if wr.keyHash == "" {
+		return c.crypto.Sign
+	}
+
+	hash := wr.keyHash
+
+	return func(msg []byte, kh interface{}) ([]byte, error) {
+		return ecdsahash.SignKeyset(msg, kh, hash, false)
+	}
+}
+
+// verifier returns the function that verifies signatures with the key of the request, see signer.
+func (c *Command) verifier(wr *WrappedRequest) func([]byte, []byte, interface{}) error {
+	if wr.keyHash == "" {
+		return c.crypto.Verify
+	}
+
+	hash := wr.keyHash
+
+	return func(sig, msg []byte, kh interface{}) error {
+		return ecdsahash.VerifyKeyset(sig, msg, kh, hash)
+	}
+}
+
+// checkDefaultKeyHash fails with ErrUnprocessableEntity if the key of the request doesn't sign with the hash of its
+// key type, for operations whose format fixes the hash, e.g. JWS algorithms or prehashed digests.
+func checkDefaultKeyHash(wr *WrappedRequest, operation string) error {
+	if wr.keyHash == "" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: key %s signs with %s, it can't be used for %s", errors.ErrUnprocessableEntity, wr.KeyID,
+		wr.keyHash, operation)
+}
+
+// hashed updates the details for a key that doesn't sign with the hash of its key type. There is no JWS or COSE
+// algorithm for the pairing of the curve and the hash.
+func (d *SignatureDetails) hashed(hash string) {
+	if hash == "" {
+		return
+	}
+
+	d.Hash = hash
+	d.JOSEAlgorithm, d.COSEAlgorithm = "", 0
+}
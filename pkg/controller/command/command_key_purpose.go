@@ -0,0 +1,142 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// KeyPurpose is an operation a key can be used for.
+type KeyPurpose string
+
+// Key purposes. A key created with purposes can't be used for other operations; a key without purposes can be used
+// for all operations.
+const (
+	KeyPurposeSign        KeyPurpose = "sign"        // sign, signBatch, signMulti
+	KeyPurposeVerify      KeyPurpose = "verify"      // verify, verifyMulti, verifyProof
+	KeyPurposeDeriveProof KeyPurpose = "deriveProof" // deriveProof
+	KeyPurposeEncrypt     KeyPurpose = "encrypt"     // encrypt
+	KeyPurposeDecrypt     KeyPurpose = "decrypt"     // decrypt
+	KeyPurposeComputeMAC  KeyPurpose = "computeMAC"  // computeMAC
+	KeyPurposeVerifyMAC   KeyPurpose = "verifyMAC"   // verifyMAC
+	KeyPurposeWrap        KeyPurpose = "wrap"        // wrap, including sealing with the key and invitation capabilities
+	KeyPurposeUnwrap      KeyPurpose = "unwrap"      // unwrap, including decrypting JWEs
+	KeyPurposeDeriveKey   KeyPurpose = "deriveKey"   // deriveKey
+
+	// KeyPurposeCode is an error code returned in the body of a request rejected because of the key purposes.
+	KeyPurposeCode = "KEY_PURPOSE_NOT_ALLOWED"
+)
+
+// KeyPurposeError is returned when a key is used for an operation that is not one of its purposes.
+type KeyPurposeError struct {
+	KeyURL  string
+	Purpose KeyPurpose
+}
+
+func (e *KeyPurposeError) Error() string {
+	return fmt.Sprintf("%s: key %s is not allowed for purpose %q", errors.ErrForbidden.Error(), e.KeyURL, e.Purpose)
+}
+
+// Unwrap returns ErrForbidden, so that the error is reported with 403 status.
+func (e *KeyPurposeError) Unwrap() error {
+	return errors.ErrForbidden
+}
+
+func validateKeyPurposes(purposes []KeyPurpose) error {
+	for _, p := range purposes {
+		switch p {
+		case KeyPurposeSign, KeyPurposeVerify, KeyPurposeDeriveProof, KeyPurposeEncrypt, KeyPurposeDecrypt,
+			KeyPurposeComputeMAC, KeyPurposeVerifyMAC, KeyPurposeWrap, KeyPurposeUnwrap, KeyPurposeDeriveKey:
+		default:
+			return fmt.Errorf("%w: unknown key purpose %q", errors.ErrValidation, p)
+		}
+	}
+
+	return nil
+}
+
+// actionPurpose returns the key purpose the action needs, or an empty purpose if the action doesn't use the key.
+func actionPurpose(action string) KeyPurpose {
+	switch action {
+	case ActionSign, ActionSignBatch, ActionSignMulti, ActionSignJWT, ActionSignUpload:
+		return KeyPurposeSign
+	case ActionVerify, ActionVerifyMulti, ActionVerifyProof:
+		return KeyPurposeVerify
+	case ActionDeriveProof:
+		return KeyPurposeDeriveProof
+	case ActionEncrypt:
+		return KeyPurposeEncrypt
+	case ActionDecrypt:
+		return KeyPurposeDecrypt
+	case ActionComputeMac:
+		return KeyPurposeComputeMAC
+	case ActionVerifyMAC:
+		return KeyPurposeVerifyMAC
+	case ActionWrap, ActionEasy:
+		return KeyPurposeWrap
+	case ActionUnwrap, ActionEasyOpen, ActionSealOpen, ActionDecryptJWE:
+		return KeyPurposeUnwrap
+	case ActionDeriveKey, ActionDeriveSubkey:
+		return KeyPurposeDeriveKey
+	default:
+		return ""
+	}
+}
+
+// checkKeyPurpose fails with KeyPurposeError if the key has purposes and the purpose is not one of them. An empty
+// purpose is allowed for all keys.
+func (c *Command) checkKeyPurpose(keyStoreID, keyID string, purpose KeyPurpose, meta *keyStoreMeta) error {
+	purposes := meta.keyPurposes(keyID)
+
+	if purpose == "" || len(purposes) == 0 {
+		return nil
+	}
+
+	for _, p := range purposes {
+		if p == purpose {
+			return nil
+		}
+	}
+
+	return &KeyPurposeError{
+		KeyURL:  fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, keyID),
+		Purpose: purpose,
+	}
+}
+
+// setKeyPurposes sets purposes of the key. It must follow addKeyID, which replaces metadata of the key.
+func setKeyPurposes(keyID string, purposes []KeyPurpose) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok || len(purposes) == 0 {
+			return
+		}
+
+		km.Purposes = purposes
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// copyKeyPurposes copies purposes of a rotated key to the new key, so that rotation doesn't lift the restrictions.
+// It must follow addKeyID of the new key and precede removeKeyID of the rotated key.
+func copyKeyPurposes(fromKeyID, toKeyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		setKeyPurposes(toKeyID, meta.keyPurposes(fromKeyID))(meta)
+	}
+}
+
+// keyPurposes returns purposes of the key, or nil if the key can be used for all operations.
+func (m *keyStoreMeta) keyPurposes(keyID string) []KeyPurpose {
+	if km, ok := m.Keys[keyID]; ok {
+		return km.Purposes
+	}
+
+	return nil
+}
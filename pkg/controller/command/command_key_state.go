@@ -0,0 +1,150 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// KeyState is a state of a key.
+type KeyState string
+
+const (
+	// KeyStateActive keys can be used for all operations. Keys are active unless disabled.
+	KeyStateActive KeyState = "active"
+	// KeyStateDisabled keys can't create new signatures, ciphertexts, MACs or wrapped keys, but can still verify,
+	// decrypt and unwrap, so that existing artifacts remain checkable.
+	KeyStateDisabled KeyState = "disabled"
+
+	// KeyDisabledCode is an error code returned in the body of a request rejected because the key is disabled.
+	KeyDisabledCode = "KEY_DISABLED"
+)
+
+// KeyDisabledError is returned when an operation that needs an active key is requested with a disabled key.
+type KeyDisabledError struct {
+	KeyURL string
+}
+
+func (e *KeyDisabledError) Error() string {
+	return fmt.Sprintf("%s: key %s is disabled", errors.ErrConflict.Error(), e.KeyURL)
+}
+
+// Unwrap returns ErrConflict, so that the error is reported with 409 status.
+func (e *KeyDisabledError) Unwrap() error {
+	return errors.ErrConflict
+}
+
+// SetKeyState disables or re-enables a key.
+func (c *Command) SetKeyState(w io.Writer, r io.Reader) error {
+	var req SetKeyStateRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateKeyState(req.State); err != nil {
+		return err
+	}
+
+	ks, err := c.resolveKeyStoreForKey(wr)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if _, err = ks.Get(wr.KeyID); err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	seq, err := c.incrementSequence(wr.KeyStoreID, setKeyState(wr.KeyID, req.State))
+	if err != nil {
+		return fmt.Errorf("increment sequence: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(SetKeyStateResponse{
+		KeyURL:   fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, wr.KeyID),
+		State:    req.State,
+		Sequence: seq,
+	})
+}
+
+func validateKeyState(state KeyState) error {
+	if state != KeyStateActive && state != KeyStateDisabled {
+		return fmt.Errorf("%w: state must be %q or %q", errors.ErrValidation, KeyStateActive, KeyStateDisabled)
+	}
+
+	return nil
+}
+
+// resolveKeyStoreForActiveKey is like resolveKeyStoreForPurpose, but also fails with KeyDisabledError if the key is
+// disabled, or with KeyExpiredError if it expired. It is used by operations that create new artifacts with the key.
+func (c *Command) resolveKeyStoreForActiveKey(wr *WrappedRequest, purpose KeyPurpose) (kms.KeyManager, error) {
+	ks, meta, _, err := c.resolveKeyStoreWithMeta(wr.KeyStoreID, wr.User, wr.SecretShare)
+	if err != nil {
+		return nil, err
+	}
+
+	if wr.KeyID, err = meta.resolveKeyRef(wr.KeyID); err != nil {
+		return nil, err
+	}
+
+	wr.keyType, wr.keyHash = meta.Keys[wr.KeyID].KeyType, meta.Keys[wr.KeyID].Hash
+
+	if err = c.checkKeyPurpose(wr.KeyStoreID, wr.KeyID, purpose, meta); err != nil {
+		return nil, err
+	}
+
+	if err = c.checkKeyActive(wr.KeyStoreID, wr.KeyID, meta); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// checkKeyActive fails if the key is disabled or expired.
+func (c *Command) checkKeyActive(keyStoreID, keyID string, meta *keyStoreMeta) error {
+	if meta.keyState(keyID) == KeyStateDisabled {
+		return &KeyDisabledError{KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, keyID)}
+	}
+
+	return c.checkKeyNotExpired(keyStoreID, keyID, meta)
+}
+
+// setKeyState sets the state of the key. Keys created before the key store started to track its keys get metadata
+// with the state only.
+func setKeyState(keyID string, state KeyState) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		if meta.Keys == nil {
+			meta.Keys = make(map[string]keyMeta)
+		}
+
+		km := meta.Keys[keyID]
+
+		// active is the default, so it isn't saved
+		km.State = state
+		if state == KeyStateActive {
+			km.State = ""
+		}
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// keyState returns the state of the key.
+func (m *keyStoreMeta) keyState(keyID string) KeyState {
+	if km, ok := m.Keys[keyID]; ok && km.State != "" {
+		return km.State
+	}
+
+	return KeyStateActive
+}
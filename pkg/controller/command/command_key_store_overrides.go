@@ -0,0 +1,181 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// overridesTagName is the tag of metadata of key stores with overrides, see ListKeyStores.
+const overridesTagName = "overrides"
+
+// keyStoreOverrides are server settings overridden for the key store by an admin. Zero values use the server
+// defaults.
+type keyStoreOverrides struct {
+	CacheTTL         time.Duration `json:"cache_ttl,omitempty"`
+	RateLimit        float64       `json:"rate_limit,omitempty"`
+	ConcurrencyLimit int           `json:"concurrency_limit,omitempty"`
+}
+
+// keyStoreLimits are the default limits of requests of key stores and the bounds of their overrides. Zero limits
+// are no limit, zero bounds disable overrides.
+type keyStoreLimits struct {
+	rateLimit           float64
+	concurrencyLimit    int
+	maxRateLimit        float64
+	maxConcurrencyLimit int
+}
+
+func (o *keyStoreOverrides) isEmpty() bool {
+	return o == nil || (o.CacheTTL == 0 && o.RateLimit == 0 && o.ConcurrencyLimit == 0)
+}
+
+func (o *keyStoreOverrides) info() *KeyStoreOverrides {
+	if o.isEmpty() {
+		return nil
+	}
+
+	info := &KeyStoreOverrides{RateLimit: o.RateLimit, ConcurrencyLimit: o.ConcurrencyLimit}
+
+	if o.CacheTTL != 0 {
+		info.CacheTTL = o.CacheTTL.String()
+	}
+
+	return info
+}
+
+// SetKeyStoreOverrides overrides server settings for the key store. It is an admin operation, the controller can't
+// set overrides. Settings omitted in the request are reset to the server defaults.
+func (c *Command) SetKeyStoreOverrides(w io.Writer, r io.Reader) error {
+	var req SetKeyStoreOverridesRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	overrides, err := c.parseOverrides(&req)
+	if err != nil {
+		return fmt.Errorf("validate request: %w", err)
+	}
+
+	sequence, err := c.incrementSequence(wr.KeyStoreID, func(meta *keyStoreMeta) {
+		meta.Overrides = overrides
+	})
+	if err != nil {
+		return fmt.Errorf("set overrides: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	return json.NewEncoder(w).Encode(SetKeyStoreOverridesResponse{
+		Overrides: overrides.info(),
+		Sequence:  sequence,
+	})
+}
+
+func (c *Command) parseOverrides(req *SetKeyStoreOverridesRequest) (*keyStoreOverrides, error) {
+	var overrides keyStoreOverrides
+
+	if req.CacheTTL != "" {
+		ttl, err := time.ParseDuration(req.CacheTTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("%w: cache TTL must be a positive duration", errors.ErrValidation)
+		}
+
+		if c.maxKeyStoreCacheTTL == 0 {
+			return nil, fmt.Errorf("%w: cache TTL overrides are disabled", errors.ErrValidation)
+		}
+
+		if ttl > c.maxKeyStoreCacheTTL {
+			return nil, fmt.Errorf("%w: cache TTL must not exceed %s", errors.ErrValidation, c.maxKeyStoreCacheTTL)
+		}
+
+		overrides.CacheTTL = ttl
+	}
+
+	if req.RateLimit != 0 {
+		if req.RateLimit < 0 {
+			return nil, fmt.Errorf("%w: rate limit must be positive", errors.ErrValidation)
+		}
+
+		if c.keyStoreLimits.maxRateLimit == 0 {
+			return nil, fmt.Errorf("%w: rate limit overrides are disabled", errors.ErrValidation)
+		}
+
+		if req.RateLimit > c.keyStoreLimits.maxRateLimit {
+			return nil, fmt.Errorf("%w: rate limit must not exceed %g", errors.ErrValidation, c.keyStoreLimits.maxRateLimit)
+		}
+
+		overrides.RateLimit = req.RateLimit
+	}
+
+	if req.ConcurrencyLimit != 0 {
+		if req.ConcurrencyLimit < 0 {
+			return nil, fmt.Errorf("%w: concurrency limit must be positive", errors.ErrValidation)
+		}
+
+		if c.keyStoreLimits.maxConcurrencyLimit == 0 {
+			return nil, fmt.Errorf("%w: concurrency limit overrides are disabled", errors.ErrValidation)
+		}
+
+		if req.ConcurrencyLimit > c.keyStoreLimits.maxConcurrencyLimit {
+			return nil, fmt.Errorf("%w: concurrency limit must not exceed %d", errors.ErrValidation,
+				c.keyStoreLimits.maxConcurrencyLimit)
+		}
+
+		overrides.ConcurrencyLimit = req.ConcurrencyLimit
+	}
+
+	if overrides.isEmpty() {
+		return nil, nil
+	}
+
+	return &overrides, nil
+}
+
+// keyStoreCacheTTLOf returns the TTL of cached keys of the key store: the override of the key store, bounded by the
+// maximum in case it was lowered after the override was set, or the server default.
+func (c *Command) keyStoreCacheTTLOf(meta *keyStoreMeta) time.Duration {
+	if meta.Overrides == nil || meta.Overrides.CacheTTL == 0 || c.maxKeyStoreCacheTTL == 0 {
+		return c.keyStoreCacheTTL
+	}
+
+	if meta.Overrides.CacheTTL > c.maxKeyStoreCacheTTL {
+		return c.maxKeyStoreCacheTTL
+	}
+
+	return meta.Overrides.CacheTTL
+}
+
+// KeyStoreLimits returns the rate limit (requests per second) and the concurrency limit of requests of the key
+// store, zero if unlimited. See keyStoreCacheTTLOf for how overrides and server defaults are combined.
+func (c *Command) KeyStoreLimits(keyStoreID string) (float64, int, error) {
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rateLimit, concurrencyLimit := c.keyStoreLimits.rateLimit, c.keyStoreLimits.concurrencyLimit
+
+	if meta.Overrides != nil && meta.Overrides.RateLimit > 0 && c.keyStoreLimits.maxRateLimit > 0 {
+		rateLimit = math.Min(meta.Overrides.RateLimit, c.keyStoreLimits.maxRateLimit)
+	}
+
+	if meta.Overrides != nil && meta.Overrides.ConcurrencyLimit > 0 && c.keyStoreLimits.maxConcurrencyLimit > 0 {
+		concurrencyLimit = meta.Overrides.ConcurrencyLimit
+		if concurrencyLimit > c.keyStoreLimits.maxConcurrencyLimit {
+			concurrencyLimit = c.keyStoreLimits.maxConcurrencyLimit
+		}
+	}
+
+	return rateLimit, concurrencyLimit, nil
+}
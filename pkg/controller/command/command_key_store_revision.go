@@ -0,0 +1,176 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+const (
+	// revisionPrefix is the prefix of IDs of revision records of key stores in the key store db.
+	revisionPrefix = "revision_"
+
+	// revisionRecoveryAge is the age after which the revision of a key store whose metadata wasn't saved, e.g.
+	// because the instance that claimed it stopped, is rolled forward by the next update.
+	revisionRecoveryAge = 30 * time.Second
+)
+
+// revisionRecord claims a revision of a key store. Every update of key store metadata is saved as the next revision
+// of the key store: the record of the revision is saved with storage.PutOptions.IsNewKey before the metadata, so with
+// storage that rejects existing keys (e.g. MongoDB) only one of concurrent updates, from any instance, saves it and
+// the others fail with a conflict. The record of the deletion of a key store is kept, so that updates of the deleted
+// key store started before it was deleted can't save it again.
+type revisionRecord struct {
+	SavedAt time.Time     `json:"savedAt"`
+	Deleted bool          `json:"deleted,omitempty"`
+	Meta    *keyStoreMeta `json:"meta,omitempty"`
+}
+
+func revisionID(keyStoreID string, sequence uint64) string {
+	return revisionPrefix + keyStoreID + "_" + strconv.FormatUint(sequence, 10)
+}
+
+// saveRevision saves metadata of the key store as the revision of its sequence number, which must be one more than
+// the sequence number of the metadata it was read as. A conflict error is returned if the revision was already saved.
+func (c *Command) saveRevision(meta *keyStoreMeta) error {
+	meta.SchemaVersion = SchemaVersion
+
+	if err := c.claimRevision(meta.ID, meta.Sequence, &revisionRecord{Meta: meta}); err != nil {
+		return err
+	}
+
+	if err := c.save(meta); err != nil {
+		// the revision can be claimed again, as the metadata wasn't saved
+		if delErr := c.store.Delete(revisionID(meta.ID, meta.Sequence)); delErr != nil {
+			logger.Warnf("Failed to delete revision %d of key store %s: %v", meta.Sequence, meta.ID, delErr)
+		}
+
+		return err
+	}
+
+	// the record of the previous revision isn't needed anymore
+	err := c.store.Delete(revisionID(meta.ID, meta.Sequence-1))
+	if err != nil && !stderrors.Is(err, storage.ErrDataNotFound) {
+		logger.Warnf("Failed to delete revision %d of key store %s: %v", meta.Sequence-1, meta.ID, err)
+	}
+
+	return nil
+}
+
+// claimDeletion claims the revision after the sequence number of the key store for its deletion.
+func (c *Command) claimDeletion(meta *keyStoreMeta) error {
+	return c.claimRevision(meta.ID, meta.Sequence+1, &revisionRecord{Deleted: true})
+}
+
+func (c *Command) claimRevision(keyStoreID string, sequence uint64, rec *revisionRecord) error {
+	rec.SavedAt = c.clock.Now()
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal revision: %w", err)
+	}
+
+	err = c.store.Batch([]storage.Operation{{
+		Key:        revisionID(keyStoreID, sequence),
+		Value:      b,
+		PutOptions: &storage.PutOptions{IsNewKey: true},
+	}})
+	if stderrors.Is(err, storage.ErrDuplicateKey) {
+		c.recoverRevision(keyStoreID, sequence)
+
+		return fmt.Errorf("%w: key store %s was updated concurrently, retry the request", errors.ErrConflict,
+			keyStoreID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("save revision: %w", err)
+	}
+
+	return nil
+}
+
+// recoverRevision rolls the metadata of the key store forward to the claimed revision if the revision wasn't saved
+// within revisionRecoveryAge, so that the key store can be updated again.
+func (c *Command) recoverRevision(keyStoreID string, sequence uint64) {
+	b, err := c.store.Get(revisionID(keyStoreID, sequence))
+	if err != nil {
+		return
+	}
+
+	var rec revisionRecord
+
+	if err = json.Unmarshal(b, &rec); err != nil || rec.Deleted || rec.Meta == nil ||
+		c.clock.Now().Sub(rec.SavedAt) < revisionRecoveryAge {
+		return
+	}
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil || meta.Sequence >= sequence {
+		return
+	}
+
+	if err = c.save(rec.Meta); err != nil {
+		logger.Warnf("Failed to roll key store %s forward to revision %d: %v", keyStoreID, sequence, err)
+
+		return
+	}
+
+	logger.Infof("Rolled key store %s forward to revision %d", keyStoreID, sequence)
+}
+
+// keyStoreLocks serializes updates of a key store within the instance, so that they don't conflict with each other.
+// Updates of different key stores don't wait for each other.
+type keyStoreLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyStoreLock
+}
+
+type keyStoreLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the key store and returns the function that unlocks it.
+func (l *keyStoreLocks) lock(keyStoreID string) func() {
+	l.mu.Lock()
+
+	if l.locks == nil {
+		l.locks = make(map[string]*keyStoreLock)
+	}
+
+	kl, ok := l.locks[keyStoreID]
+	if !ok {
+		kl = &keyStoreLock{}
+		l.locks[keyStoreID] = kl
+	}
+
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.Lock()
+
+	return func() {
+		kl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, keyStoreID)
+		}
+	}
+}
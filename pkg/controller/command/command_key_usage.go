@@ -0,0 +1,72 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
+
+// recordKeyUse records that the key was used for an operation. Failures are logged only, so that usage tracking
+// never fails an operation.
+func (c *Command) recordKeyUse(keyStoreID, keyID string) {
+	if c.keyUsage == nil {
+		return
+	}
+
+	if err := c.keyUsage.Touch(keyStoreID, keyID); err != nil {
+		logger.Ctx(reqlog.WithKeyStore(context.Background(), keyStoreID, c.KeyStoreController)).Warnf(
+			"Failed to record use of key %s/%s/keys/%s: %v", c.baseKeyStoreURL, keyStoreID, keyID, err)
+	}
+}
+
+// keyLastUsed returns the time the key was last used, or nil if usage isn't tracked or the key wasn't used since
+// tracking started.
+func (c *Command) keyLastUsed(keyStoreID, keyID string) (*time.Time, error) {
+	if c.keyUsage == nil {
+		return nil, nil
+	}
+
+	lastUsed, err := c.keyUsage.LastUsed(keyStoreID, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("get last use of key: %w", err)
+	}
+
+	return lastUsed, nil
+}
+
+// keysLastUsed returns last-used times of the keys of the key store by key ID.
+func (c *Command) keysLastUsed(keyStoreID string, unusedSince *time.Time) (map[string]time.Time, error) {
+	if c.keyUsage == nil {
+		if unusedSince != nil {
+			return nil, fmt.Errorf("%w: key usage tracking is disabled", errors.ErrValidation)
+		}
+
+		return nil, nil
+	}
+
+	lastUsed, err := c.keyUsage.LastUsedOfKeyStore(keyStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("get last use of keys: %w", err)
+	}
+
+	return lastUsed, nil
+}
+
+// unusedSince returns whether the key wasn't used since the time. A key created at or after the time is not reported
+// as unused.
+func unusedSince(info *KeyInfo, since time.Time) bool {
+	if info.LastUsedAt != nil {
+		return info.LastUsedAt.Before(since)
+	}
+
+	return info.CreatedAt == nil || info.CreatedAt.Before(since)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// Page sizes of ListKeyStores.
+const (
+	DefaultKeyStorePageSize = 100
+	MaxKeyStorePageSize     = 1000
+)
+
+// controllerTagName is the tag of key store metadata with the hash of the controller. Storage query expressions
+// can't contain colons, so the DID itself can't be the tag value.
+const controllerTagName = "controller_hash"
+
+// ListKeyStores returns key stores of the controller, or key stores with overrides, ordered by ID. It is an admin
+// operation to find key stores that belong to a subject. Key stores that were not used since the controller tag was
+// introduced are not listed, see backfillKeyStoreTags.
+func (c *Command) ListKeyStores(w io.Writer, r io.Reader) error {
+	var req ListKeyStoresRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = req.Validate(); err != nil {
+		return fmt.Errorf("validate request: %w", err)
+	}
+
+	if err = validateFields(wr.Fields, keyStoreInfoFields...); err != nil {
+		return err
+	}
+
+	pageSize := req.PageSize
+	if pageSize == 0 {
+		pageSize = DefaultKeyStorePageSize
+	}
+
+	query := controllerTagName + ":" + controllerTag(req.Controller)
+	if req.Overrides {
+		query = overridesTagName + ":true"
+	}
+
+	keyStores, err := c.queryKeyStores(query, func(meta *keyStoreMeta) bool {
+		if req.Overrides {
+			return !meta.Overrides.isEmpty()
+		}
+
+		return meta.Controller == req.Controller
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(keyStores, func(i, j int) bool { return keyStores[i].ID < keyStores[j].ID })
+
+	// the page token is the ID of the last key store of the previous page
+	start := sort.Search(len(keyStores), func(i int) bool { return keyStores[i].ID > req.PageToken })
+
+	resp := ListKeyStoresResponse{KeyStores: keyStores[start:]}
+
+	if len(resp.KeyStores) > pageSize {
+		resp.KeyStores = resp.KeyStores[:pageSize]
+		resp.NextPageToken = resp.KeyStores[pageSize-1].ID
+	}
+
+	return encodeListFields(w, resp, "key_stores", wr.Fields)
+}
+
+// queryKeyStores returns key stores found by the query expression that match, since tags can be stale or collide.
+func (c *Command) queryKeyStores(query string, match func(meta *keyStoreMeta) bool) ([]KeyStoreInfo, error) {
+	it, err := c.store.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query key stores: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	keyStores := make([]KeyStoreInfo, 0)
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next key store: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		b, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("key store value: %w", err)
+		}
+
+		var meta keyStoreMeta
+
+		if err = json.Unmarshal(b, &meta); err != nil {
+			return nil, fmt.Errorf("unmarshal key store metadata: %w", err)
+		}
+
+		if !match(&meta) {
+			continue
+		}
+
+		keyStores = append(keyStores, KeyStoreInfo{
+			ID:            meta.ID,
+			CreatedAt:     meta.CreatedAt,
+			StorageType:   meta.storageType(),
+			Overrides:     meta.Overrides.info(),
+			SchemaVersion: meta.SchemaVersion,
+		})
+	}
+
+	return keyStores, nil
+}
+
+// backfillKeyStoreTags saves metadata of the key store with its tags if it may have been saved before the tags were
+// introduced, i.e. without a schema version, so that the key store is found by ListKeyStores, the purge of deleted
+// keys and migrations once it is used. The metadata isn't saved if an update of the key store was claimed. An update
+// claimed on another instance while the metadata is saved is rolled forward by the next update, see recoverRevision,
+// and a deletion is completed again.
+func (c *Command) backfillKeyStoreTags(meta *keyStoreMeta) {
+	next := revisionID(meta.ID, meta.Sequence+1)
+
+	if _, err := c.store.Get(next); !stderrors.Is(err, storage.ErrDataNotFound) {
+		return
+	}
+
+	backfilled := *meta
+
+	if err := c.save(&backfilled); err != nil {
+		logger.Warnf("Failed to backfill tags of key store %s: %v", meta.ID, err)
+
+		return
+	}
+
+	b, err := c.store.Get(next)
+	if err != nil {
+		return
+	}
+
+	var rec revisionRecord
+
+	if err = json.Unmarshal(b, &rec); err == nil && rec.Deleted {
+		if err = c.store.Delete(meta.ID); err != nil {
+			logger.Warnf("Failed to delete key store %s: %v", meta.ID, err)
+		}
+	}
+}
+
+// controllerTag returns the value of the controller tag of key store metadata.
+func controllerTag(controller string) string {
+	sum := sha256.Sum256([]byte(controller))
+
+	return hex.EncodeToString(sum[:])
+}
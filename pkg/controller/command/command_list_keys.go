@@ -0,0 +1,75 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"io"
+)
+
+// ListKeys returns metadata of the keys of the key store in the order of creation. Keys created before the key store
+// started to track its keys, and deleted keys, are not listed. Key material isn't accessed, so secret shares aren't
+// needed. With UnusedSince only keys that weren't used since the time are listed.
+func (c *Command) ListKeys(w io.Writer, r io.Reader) error {
+	var req ListKeysRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = validateFields(wr.Fields, keyInfoFields...); err != nil {
+		return err
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	lastUsed, err := c.keysLastUsed(wr.KeyStoreID, req.UnusedSince)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]KeyInfo, 0, len(meta.KeyIDs))
+
+	for _, keyID := range meta.KeyIDs {
+		if meta.keyDeletedAt(keyID) != nil {
+			continue
+		}
+
+		info := KeyInfo{
+			KeyURL:    fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, keyID),
+			Alias:     meta.aliasOf(keyID),
+			State:     meta.keyState(keyID),
+			ExpiresAt: meta.keyExpiry(keyID),
+			Purposes:  meta.keyPurposes(keyID),
+			Origin:    meta.keyOrigin(keyID),
+			Hash:      meta.Keys[keyID].Hash,
+		}
+
+		if km, ok := meta.Keys[keyID]; ok && !km.CreatedAt.IsZero() {
+			createdAt := km.CreatedAt
+
+			info.KeyType = string(km.KeyType)
+			info.CreatedAt = &createdAt
+		}
+
+		if usedAt, ok := lastUsed[keyID]; ok {
+			info.LastUsedAt = &usedAt
+		}
+
+		if req.UnusedSince != nil && !unusedSince(&info, *req.UnusedSince) {
+			continue
+		}
+
+		keys = append(keys, info)
+	}
+
+	return encodeListFields(w, ListKeysResponse{Keys: keys, Sequence: meta.Sequence}, "keys", wr.Fields)
+}
@@ -0,0 +1,353 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/kms/pkg/didkey"
+)
+
+const (
+	// DefaultMigrateProgressInterval is the default number of records between progress reports of a migration.
+	DefaultMigrateProgressInterval = 10000
+
+	// migrateBatchSize is the number of records read and written at once.
+	migrateBatchSize = 100
+)
+
+// MigrateOptions configures MigrateKeyStores.
+type MigrateOptions struct {
+	// DryRun counts the records to copy without writing to the destination.
+	DryRun bool
+	// ProgressInterval is the number of records between calls of Progress. Defaults to
+	// DefaultMigrateProgressInterval.
+	ProgressInterval int
+	// Progress is called with the report so far every ProgressInterval records. Optional.
+	Progress func(report *MigrateReport)
+}
+
+// MigrateReport reports a migration of key stores.
+type MigrateReport struct {
+	// KeyStores is the number of key stores found in the source.
+	KeyStores int
+	// Records is the number of records copied, or that would be copied on a dry run.
+	Records int
+	// Verified is the number of copied records read back from the destination. Zero on a dry run.
+	Verified int
+	// Missing lists records referenced by key store metadata that aren't in the source, e.g. purged keys.
+	Missing []string
+}
+
+// MigrateKeyStores copies key stores from the source database to the destination: metadata of key stores with their
+// tags, DID URL claims, and keysets of the keys of key stores and of the server keys they use. Records are copied
+// verbatim, so keysets stay encrypted with the secret lock of the source. Key stores are found by the controller tag,
+// like with ListKeyStores, and keys of EDV-backed key stores are stored in users' vaults and aren't copied. Copied
+// records are read back from the destination, and an error is returned if any of them is not found. A migration can
+// be run again, e.g. after it was interrupted, as records are overwritten.
+func MigrateKeyStores(src, dst storage.Provider, opts *MigrateOptions) (*MigrateReport, error) {
+	m := &migration{
+		opts:        opts,
+		report:      &MigrateReport{},
+		interval:    opts.ProgressInterval,
+		invokerKeys: make(map[string]struct{}),
+	}
+
+	if m.interval <= 0 {
+		m.interval = DefaultMigrateProgressInterval
+	}
+
+	m.next = m.interval
+
+	var err error
+
+	m.srcMeta, m.srcKeys, err = openMigrateStores(src)
+	if err != nil {
+		return nil, fmt.Errorf("open source: %w", err)
+	}
+
+	if !opts.DryRun {
+		m.dstMeta, m.dstKeys, err = openMigrateStores(dst)
+		if err != nil {
+			return nil, fmt.Errorf("open destination: %w", err)
+		}
+
+		if err = dst.SetStoreConfig(keyStores, storage.StoreConfiguration{TagNames: keyStoreTagNames}); err != nil {
+			return nil, fmt.Errorf("set destination key store db config: %w", err)
+		}
+	}
+
+	if err = m.run(); err != nil {
+		return m.report, err
+	}
+
+	if !opts.DryRun && m.report.Verified != m.report.Records {
+		return m.report, fmt.Errorf("verify destination: %d of %d copied records found",
+			m.report.Verified, m.report.Records)
+	}
+
+	return m.report, nil
+}
+
+// openMigrateStores opens the key store db and the keyset store of the provider.
+func openMigrateStores(provider storage.Provider) (storage.Store, storage.Store, error) {
+	meta, err := provider.OpenStore(keyStores)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open key store db: %w", err)
+	}
+
+	keySets, err := provider.OpenStore(localkms.Namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open keyset store: %w", err)
+	}
+
+	return meta, keySets, nil
+}
+
+type migration struct {
+	opts             *MigrateOptions
+	report           *MigrateReport
+	interval         int
+	next             int // number of records of the next progress report
+	srcMeta, srcKeys storage.Store
+	dstMeta, dstKeys storage.Store
+	invokerKeys      map[string]struct{} // IDs of the EDV capability invoker keys that were copied
+}
+
+func (m *migration) run() error {
+	it, err := m.srcMeta.Query(controllerTagName)
+	if err != nil {
+		return fmt.Errorf("query key stores: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("next key store: %w", err)
+		}
+
+		if !ok {
+			return nil
+		}
+
+		key, err := it.Key()
+		if err != nil {
+			return fmt.Errorf("key store key: %w", err)
+		}
+
+		value, err := it.Value()
+		if err != nil {
+			return fmt.Errorf("key store %s value: %w", key, err)
+		}
+
+		tags, err := it.Tags()
+		if err != nil {
+			return fmt.Errorf("key store %s tags: %w", key, err)
+		}
+
+		if err = m.migrateKeyStore(key, value, tags); err != nil {
+			return fmt.Errorf("migrate key store %s: %w", key, err)
+		}
+	}
+}
+
+func (m *migration) migrateKeyStore(key string, value []byte, tags []storage.Tag) error {
+	var meta keyStoreMeta
+
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return fmt.Errorf("unmarshal key store meta: %w", err)
+	}
+
+	m.report.KeyStores++
+
+	if !m.opts.DryRun {
+		if err := m.dstMeta.Put(key, value, tags...); err != nil {
+			return fmt.Errorf("put key store meta: %w", err)
+		}
+
+		if _, err := m.dstMeta.Get(key); err == nil {
+			m.report.Verified++
+		} else if !errors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("verify key store meta: %w", err)
+		}
+	}
+
+	m.addRecords(1)
+
+	claimIDs := make([]string, 0, len(meta.VerificationMethods))
+
+	for didURL := range meta.VerificationMethods {
+		claimIDs = append(claimIDs, didURLClaimID(didURL))
+	}
+
+	if err := m.copyRecords(key, "DID URL claim", m.srcMeta, m.dstMeta, "", claimIDs); err != nil {
+		return err
+	}
+
+	keyIDs := m.serverKeyIDs(key, &meta)
+
+	if meta.EDV.VaultURL == "" {
+		keyIDs = append(keyIDs, meta.KeyIDs...)
+	}
+
+	// localkms stores keysets under prefixed IDs
+	return m.copyRecords(key, "key", m.srcKeys, m.dstKeys, prefix.StorageKIDPrefix, keyIDs)
+}
+
+// serverKeyIDs returns IDs of the server keys the key store uses: its main key, and, for an EDV-backed key store, its
+// EDV keys and the key that signs requests to the vault. The signing key is shared by key stores, so it is returned
+// only for the first key store that uses it.
+func (m *migration) serverKeyIDs(keyStoreID string, meta *keyStoreMeta) []string {
+	var keyIDs []string
+
+	for _, id := range []string{meta.MainKeyID, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID} {
+		if id != "" {
+			keyIDs = append(keyIDs, id)
+		}
+	}
+
+	if len(meta.EDV.Capability) == 0 {
+		return keyIDs
+	}
+
+	invokerKeyID, err := capabilityInvokerKeyID(meta.EDV.Capability)
+	if err != nil {
+		m.report.Missing = append(m.report.Missing,
+			fmt.Sprintf("key store %s: EDV capability invoker key: %s", keyStoreID, err))
+
+		return keyIDs
+	}
+
+	if _, ok := m.invokerKeys[invokerKeyID]; !ok {
+		m.invokerKeys[invokerKeyID] = struct{}{}
+
+		keyIDs = append(keyIDs, invokerKeyID)
+	}
+
+	return keyIDs
+}
+
+// capabilityInvokerKeyID returns ID of the server key of the did:key that invokes the capability. Requests with the
+// capability are signed with the key it resolves to, see zcapld.AriesDIDKeySignatureHashAlgorithm.
+func capabilityInvokerKeyID(capability []byte) (string, error) {
+	zcap, err := zcapld.ParseCapability(capability)
+	if err != nil {
+		return "", fmt.Errorf("parse capability: %w", err)
+	}
+
+	pub, err := didkey.PublicKey(zcap.Invoker)
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	kid, err := localkms.CreateKID(pub, kms.ED25519Type)
+	if err != nil {
+		return "", fmt.Errorf("create kid: %w", err)
+	}
+
+	return kid, nil
+}
+
+// copyRecords copies untagged records of the key store in batches. Records are stored under the IDs with the prefix.
+// Records not found in the source are reported as missing.
+func (m *migration) copyRecords(keyStoreID, kind string, src, dst storage.Store, idPrefix string, ids []string) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > migrateBatchSize {
+			n = migrateBatchSize
+		}
+
+		if err := m.copyBatch(keyStoreID, kind, src, dst, idPrefix, ids[:n]); err != nil {
+			return err
+		}
+
+		ids = ids[n:]
+	}
+
+	return nil
+}
+
+func (m *migration) copyBatch(keyStoreID, kind string, src, dst storage.Store, idPrefix string, ids []string) error {
+	keys := make([]string, len(ids))
+
+	for i, id := range ids {
+		keys[i] = idPrefix + id
+	}
+
+	values, err := src.GetBulk(keys...)
+	if err != nil {
+		return fmt.Errorf("get %s records: %w", kind, err)
+	}
+
+	var (
+		ops    []storage.Operation
+		copied []string
+	)
+
+	for i, value := range values {
+		if value == nil {
+			m.report.Missing = append(m.report.Missing, fmt.Sprintf("key store %s: %s %s", keyStoreID, kind, ids[i]))
+
+			continue
+		}
+
+		ops = append(ops, storage.Operation{Key: keys[i], Value: value})
+		copied = append(copied, keys[i])
+	}
+
+	if m.opts.DryRun || len(ops) == 0 {
+		m.addRecords(len(ops))
+
+		return nil
+	}
+
+	if err = dst.Batch(ops); err != nil {
+		return fmt.Errorf("put %s records: %w", kind, err)
+	}
+
+	written, err := dst.GetBulk(copied...)
+	if err != nil {
+		return fmt.Errorf("verify %s records: %w", kind, err)
+	}
+
+	for _, value := range written {
+		if value != nil {
+			m.report.Verified++
+		}
+	}
+
+	m.addRecords(len(ops))
+
+	return nil
+}
+
+// addRecords counts copied records and reports progress.
+func (m *migration) addRecords(n int) {
+	m.report.Records += n
+
+	if m.report.Records < m.next {
+		return
+	}
+
+	for m.next <= m.report.Records {
+		m.next += m.interval
+	}
+
+	if m.opts.Progress != nil {
+		m.opts.Progress(m.report)
+	}
+}
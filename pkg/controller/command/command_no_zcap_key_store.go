@@ -0,0 +1,68 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	stderrors "errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// checkNoZCAP fails unless a key store without ZCAPs can be created for the request: the server must enable such key
+// stores, and the request must have an OAuth subject to authorize later requests for the key store.
+func (c *Command) checkNoZCAP(wr *WrappedRequest, req *CreateKeyStoreRequest) error {
+	if !req.DisableZCAP {
+		return nil
+	}
+
+	if !c.enableNoZCAP {
+		return fmt.Errorf("%w: key stores without ZCAPs are disabled on the server", errors.ErrForbidden)
+	}
+
+	if wr.User == "" {
+		return fmt.Errorf("%w: key stores without ZCAPs require an OAuth subject", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// NoZCAPKeyStoreCreator returns the OAuth subject that created the key store without ZCAPs. It fails with
+// ErrForbidden if the key store doesn't exist, is protected by ZCAPs or key stores without ZCAPs are disabled, so
+// that a bearer token can't be used to probe key stores.
+func (c *Command) NoZCAPKeyStoreCreator(keyStoreID string) (string, error) {
+	if !c.enableNoZCAP {
+		return "", fmt.Errorf("%w: key stores without ZCAPs are disabled on the server", errors.ErrForbidden)
+	}
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		if stderrors.Is(err, storage.ErrDataNotFound) {
+			return "", fmt.Errorf("%w: key store %s doesn't exist or requires ZCAPs", errors.ErrForbidden, keyStoreID)
+		}
+
+		return "", err
+	}
+
+	if !meta.NoZCAP {
+		return "", fmt.Errorf("%w: key store %s doesn't exist or requires ZCAPs", errors.ErrForbidden, keyStoreID)
+	}
+
+	return meta.Creator, nil
+}
+
+// owner returns the identity that can delete the key store: the creator of a key store without ZCAPs, or the
+// controller otherwise.
+func (m *keyStoreMeta) owner() string {
+	if m.NoZCAP {
+		return m.Creator
+	}
+
+	return m.Controller
+}
@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
+
+// deletedKeysTagName is the tag of metadata of key stores that have deleted keys, so that the purge doesn't scan all
+// key stores.
+const deletedKeysTagName = "deleted_keys"
+
+var logger = reqlog.New("controller/command")
+
+// PurgeDeletedKeys deletes material of deleted keys whose retention period ended and removes them from their key
+// stores. It returns the number of purged keys. A key store that fails to be purged doesn't stop the purge of
+// others; the last error is returned.
+func (c *Command) PurgeDeletedKeys() (int, error) {
+	keyStoreIDs, err := c.queryKeyStoresWithDeletedKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		purged   int
+		purgeErr error
+	)
+
+	for _, keyStoreID := range keyStoreIDs {
+		n, err := c.purgeDeletedKeys(keyStoreID)
+		purged += n
+
+		if err != nil {
+			purgeErr = fmt.Errorf("purge deleted keys of key store %s: %w", keyStoreID, err)
+		}
+	}
+
+	return purged, purgeErr
+}
+
+// purgeDeletedKeys purges deleted keys of the key store. Keys are removed from key store metadata before their
+// material is deleted, so a key restored concurrently, from any instance, is either kept or fails with a conflict.
+func (c *Command) purgeDeletedKeys(keyStoreID string) (int, error) {
+	defer c.keyStoreLocks.lock(keyStoreID)()
+
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return 0, err
+	}
+
+	var keyIDs []string
+
+	for keyID := range meta.deletedKeyIDs() {
+		if c.retentionEnded(*meta.keyDeletedAt(keyID)) {
+			keyIDs = append(keyIDs, keyID)
+		}
+	}
+
+	if len(keyIDs) == 0 {
+		return 0, nil
+	}
+
+	sort.Strings(keyIDs)
+
+	storageProvider, err := c.keyStorage(meta)
+	if err != nil {
+		return 0, err
+	}
+
+	meta.Sequence++
+
+	for _, keyID := range keyIDs {
+		removeKeyID(keyID)(meta)
+	}
+
+	if err = c.saveRevision(meta); err != nil {
+		return 0, fmt.Errorf("save key store metadata: %w", err)
+	}
+
+	if err = deleteKeys(storageProvider, keyIDs...); err != nil {
+		return 0, err
+	}
+
+	for _, keyID := range keyIDs {
+		logger.Infof("Purged deleted key %s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, keyID)
+
+		if c.keyUsage != nil {
+			if err = c.keyUsage.Delete(keyStoreID, keyID); err != nil {
+				logger.Warnf("Failed to delete last use of purged key %s: %v", keyID, err)
+			}
+		}
+	}
+
+	return len(keyIDs), nil
+}
+
+func (c *Command) queryKeyStoresWithDeletedKeys() ([]string, error) {
+	it, err := c.store.Query(deletedKeysTagName)
+	if err != nil {
+		return nil, fmt.Errorf("query key stores: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var keyStoreIDs []string
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next key store: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		b, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("key store value: %w", err)
+		}
+
+		var meta keyStoreMeta
+
+		if err = json.Unmarshal(b, &meta); err != nil {
+			return nil, fmt.Errorf("unmarshal key store metadata: %w", err)
+		}
+
+		keyStoreIDs = append(keyStoreIDs, meta.ID)
+	}
+
+	return keyStoreIDs, nil
+}
+
+// keyStoreTags returns tags of key store metadata.
+func keyStoreTags(meta *keyStoreMeta) []storage.Tag {
+	// key stores are listed by controller, see ListKeyStores
+	tags := []storage.Tag{{Name: controllerTagName, Value: controllerTag(meta.Controller)}}
+
+	if len(meta.deletedKeyIDs()) > 0 {
+		tags = append(tags, storage.Tag{Name: deletedKeysTagName, Value: "true"})
+	}
+
+	if !meta.Overrides.isEmpty() {
+		tags = append(tags, storage.Tag{Name: overridesTagName, Value: "true"})
+	}
+
+	return tags
+}
+
+// KeyPurger purges deleted keys whose retention period ended in the background.
+type KeyPurger struct {
+	cmd      *Command
+	interval time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewKeyPurger returns a new KeyPurger that purges deleted keys of the command every interval.
+func NewKeyPurger(cmd *Command, interval time.Duration) *KeyPurger {
+	return &KeyPurger{
+		cmd:      cmd,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts purging deleted keys in the background until Stop is called.
+func (p *KeyPurger) Start() {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.Purge()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops purging deleted keys.
+func (p *KeyPurger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// Purge purges deleted keys once. Failures are logged, and the keys are purged on the next run.
+func (p *KeyPurger) Purge() {
+	if _, err := p.cmd.PurgeDeletedKeys(); err != nil {
+		logger.Errorf("purge deleted keys: %v", err)
+	}
+}
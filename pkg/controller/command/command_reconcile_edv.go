@@ -0,0 +1,151 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// EDVFinding is a key of an EDV-backed key store whose document is not in the vault or can't be read from it.
+type EDVFinding struct {
+	KeyID   string `json:"key_id"`
+	Problem string `json:"problem"`
+}
+
+func (f *EDVFinding) String() string {
+	return fmt.Sprintf("key %s: %s", f.KeyID, f.Problem)
+}
+
+// EDVReport is a result of ReconcileEDV.
+type EDVReport struct {
+	KeyStoreID string       `json:"key_store_id"`
+	VaultURL   string       `json:"vault_url"`
+	Checked    int          `json:"checked"`
+	Findings   []EDVFinding `json:"findings"`
+}
+
+// ReconcileEDV checks that the vault of the EDV-backed key store has a document for every key in the key list of the
+// key store metadata, which is the record of the documents the key store expects. Nothing is modified. The wait
+// function is called before every request to EDV, so that the caller can rate-limit them.
+//
+// Documents in the vault that the key store doesn't expect are not reported: the EDV query API can only find
+// documents by indexed attributes, and key records are stored without them.
+func (c *Command) ReconcileEDV(keyStoreID string, wait func()) (*EDVReport, error) {
+	meta, err := c.getKeyStoreMeta(keyStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("get key store: %w", keyStoreNotFound(keyStoreID, err))
+	}
+
+	if meta.EDV.VaultURL == "" {
+		return nil, fmt.Errorf("%w: key store %s is not EDV-backed", errors.ErrValidation, keyStoreID)
+	}
+
+	edvProvider, err := c.resolveEDVProvider(meta.EDV.VaultURL, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID,
+		meta.EDV.Capability)
+	if err != nil {
+		return nil, fmt.Errorf("resolve edv provider: %w", err)
+	}
+
+	store, err := edvProvider.OpenStore(localkms.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("open vault store: %w", err)
+	}
+
+	// localkms stores keysets under prefixed IDs
+	store, err = prefix.NewPrefixStoreWrapper(store, prefix.StorageKIDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("wrap vault store: %w", err)
+	}
+
+	report := &EDVReport{KeyStoreID: keyStoreID, VaultURL: meta.EDV.VaultURL}
+
+	checked := make(map[string]bool, len(meta.KeyIDs))
+
+	for _, kid := range meta.KeyIDs {
+		if checked[kid] {
+			continue
+		}
+
+		checked[kid] = true
+
+		wait()
+
+		_, err = store.Get(kid)
+
+		switch {
+		case stderrors.Is(err, storage.ErrDataNotFound):
+			report.Findings = append(report.Findings, EDVFinding{KeyID: kid, Problem: "missing in vault"})
+		case err != nil:
+			report.Findings = append(report.Findings,
+				EDVFinding{KeyID: kid, Problem: fmt.Sprintf("can't be read from vault: %s", err)})
+		}
+	}
+
+	report.Checked = len(checked)
+
+	return report, nil
+}
+
+// EDVKeyStoreIDs returns IDs of EDV-backed key stores, ordered by ID. Like ListKeyStores, it finds key stores by the
+// controller tag, so key stores that were not saved since the tag was introduced are not returned.
+func (c *Command) EDVKeyStoreIDs() ([]string, error) {
+	return c.keyStoreIDs(func(meta *keyStoreMeta) bool {
+		return meta.EDV.VaultURL != ""
+	})
+}
+
+// keyStoreIDs returns IDs of key stores accepted by the filter, ordered by ID. Key stores are found by the controller
+// tag.
+func (c *Command) keyStoreIDs(filter func(meta *keyStoreMeta) bool) ([]string, error) {
+	it, err := c.store.Query(controllerTagName)
+	if err != nil {
+		return nil, fmt.Errorf("query key stores: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var ids []string
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next key store: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		b, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("key store value: %w", err)
+		}
+
+		var meta keyStoreMeta
+
+		if err = json.Unmarshal(b, &meta); err != nil {
+			return nil, fmt.Errorf("unmarshal key store: %w", err)
+		}
+
+		if filter(&meta) {
+			ids = append(ids, meta.ID)
+		}
+	}
+
+	sort.Strings(ids)
+
+	return ids, nil
+}
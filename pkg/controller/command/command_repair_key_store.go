@@ -0,0 +1,409 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/secretlock/key"
+)
+
+// QuarantineStore is the name of the store with key records that RepairKeyStore moved out of the key store.
+const QuarantineStore = "keystore_quarantine"
+
+// Fixes applied by RepairKeyStore.
+const (
+	RepairFixAddToKeyList      = "add to key list"
+	RepairFixRemoveFromKeyList = "remove from key list"
+	RepairFixRemoveDuplicate   = "remove duplicate"
+	RepairFixRemoveAlias       = "remove alias"
+	RepairFixQuarantine        = "quarantine"
+)
+
+// RepairFinding is a problem with a record of a key store.
+type RepairFinding struct {
+	// Record is the record with the problem: "metadata", "server key <id>", "key <id>" or "alias <alias>".
+	Record  string `json:"record"`
+	Problem string `json:"problem"`
+	// Fix is one of RepairFix* constants, empty if the problem can't be fixed.
+	Fix string `json:"fix,omitempty"`
+}
+
+func (f *RepairFinding) String() string {
+	if f.Fix == "" {
+		return fmt.Sprintf("%s: %s (can't be fixed)", f.Record, f.Problem)
+	}
+
+	return fmt.Sprintf("%s: %s (fix: %s)", f.Record, f.Problem, f.Fix)
+}
+
+// RepairReport is a result of RepairKeyStore.
+type RepairReport struct {
+	KeyStoreID string          `json:"key_store_id"`
+	Findings   []RepairFinding `json:"findings"`
+	// Fixed is true if fixes were applied, Sequence is then the new sequence number of the key store.
+	Fixed    bool   `json:"fixed"`
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// Unfixable returns the number of findings that can't be fixed.
+func (r *RepairReport) Unfixable() int {
+	n := 0
+
+	for i := range r.Findings {
+		if r.Findings[i].Fix == "" {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (r *RepairReport) add(record, problem, fix string) {
+	r.Findings = append(r.Findings, RepairFinding{Record: record, Problem: problem, Fix: fix})
+}
+
+// quarantinedRecord is a key record moved to the quarantine store, so that it can be inspected or restored manually.
+type quarantinedRecord struct {
+	KeyStoreID string `json:"key_store_id"`
+	KeyID      string `json:"key_id"`
+	Reason     string `json:"reason"`
+	Record     []byte `json:"record"`
+}
+
+// RepairKeyStore checks the records of the key store: the metadata, the server keys that protect the key store,
+// the encrypted keysets of its keys and the consistency of the key list and aliases with them. Without fix it only
+// reports problems. With fix it rebuilds the key list and aliases, and moves key records that are not valid or can't be
+// decrypted to QuarantineStore. Problems with the metadata and server keys can't be fixed.
+//
+// Keys of EDV-backed key stores are not checked. Decryption of keys is checked with the server's secret lock, so if
+// none of the keys can be decrypted (e.g. the key store is protected with Shamir secret shares), they are reported but
+// not quarantined. The key store should not be in use while it is repaired.
+func (c *Command) RepairKeyStore(keyStoreID string, fix bool) (*RepairReport, error) {
+	b, err := c.store.Get(keyStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("get key store: %w", keyStoreNotFound(keyStoreID, err))
+	}
+
+	report := &RepairReport{KeyStoreID: keyStoreID}
+
+	var meta keyStoreMeta
+
+	if err = json.Unmarshal(b, &meta); err != nil {
+		report.add("metadata", fmt.Sprintf("can't be parsed: %s", err), "")
+
+		return report, nil
+	}
+
+	// records of a newer version can't be checked, and fixing them would drop fields this server doesn't know
+	if err = meta.checkSchemaVersion(); err != nil {
+		report.add("metadata", err.Error(), "")
+
+		return report, nil
+	}
+
+	mainKeyReadable := c.checkServerKeys(&meta, report)
+
+	keyIDs, updates := checkKeyList(&meta, report)
+
+	var quarantine []quarantinedRecord
+
+	if meta.EDV.VaultURL == "" {
+		var recordUpdates []func(meta *keyStoreMeta)
+
+		recordUpdates, quarantine, err = c.checkKeyRecords(&meta, keyIDs, mainKeyReadable, report)
+		if err != nil {
+			return nil, err
+		}
+
+		updates = append(updates, recordUpdates...)
+	}
+
+	if !fix || len(updates) == 0 {
+		return report, nil
+	}
+
+	if err = c.quarantineKeys(quarantine); err != nil {
+		return nil, err
+	}
+
+	report.Sequence, err = c.incrementSequence(keyStoreID, updates...)
+	if err != nil {
+		return nil, fmt.Errorf("update key store metadata: %w", err)
+	}
+
+	report.Fixed = true
+
+	return report, nil
+}
+
+// checkServerKeys checks that the server keys of the key store can be read with the server's secret lock and returns
+// whether the main key, that encrypts keys of a local key store, is readable.
+func (c *Command) checkServerKeys(meta *keyStoreMeta, report *RepairReport) bool {
+	mainKeyReadable := true
+
+	for _, kid := range []string{meta.MainKeyID, meta.EDV.RecipientKeyID, meta.EDV.MACKeyID} {
+		if kid == "" {
+			continue
+		}
+
+		if _, err := c.kms.Get(kid); err != nil {
+			report.add("server key "+kid, fmt.Sprintf("can't be read with the server secret lock: %s", err), "")
+
+			if kid == meta.MainKeyID {
+				mainKeyReadable = false
+			}
+		}
+	}
+
+	return mainKeyReadable
+}
+
+// checkKeyList checks the key list and aliases against the key metadata. It returns the keys whose records should be
+// checked and the updates that fix the metadata.
+func checkKeyList(meta *keyStoreMeta, report *RepairReport) ([]string, []func(meta *keyStoreMeta)) {
+	var (
+		keyIDs  []string
+		updates []func(meta *keyStoreMeta)
+	)
+
+	listed := make(map[string]bool, len(meta.KeyIDs))
+
+	for _, kid := range meta.KeyIDs {
+		if listed[kid] {
+			report.add("key "+kid, "is listed more than once", RepairFixRemoveDuplicate)
+			updates = append(updates, removeDuplicateKeyID(kid))
+
+			continue
+		}
+
+		listed[kid] = true
+		keyIDs = append(keyIDs, kid)
+	}
+
+	var unlisted []string
+
+	for kid := range meta.Keys {
+		if !listed[kid] {
+			unlisted = append(unlisted, kid)
+		}
+	}
+
+	// keys are listed in the order of creation
+	sort.Slice(unlisted, func(i, j int) bool {
+		ti, tj := meta.Keys[unlisted[i]].CreatedAt, meta.Keys[unlisted[j]].CreatedAt
+		if ti.Equal(tj) {
+			return unlisted[i] < unlisted[j]
+		}
+
+		return ti.Before(tj)
+	})
+
+	for _, kid := range unlisted {
+		report.add("key "+kid, "has metadata, but is not in the key list", RepairFixAddToKeyList)
+		updates = append(updates, appendKeyID(kid))
+		keyIDs = append(keyIDs, kid)
+		listed[kid] = true
+	}
+
+	aliases := make([]string, 0, len(meta.Aliases))
+
+	for alias := range meta.Aliases {
+		aliases = append(aliases, alias)
+	}
+
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		if kid := meta.Aliases[alias]; !listed[kid] {
+			report.add("alias "+alias, fmt.Sprintf("refers to unknown key %s", kid), RepairFixRemoveAlias)
+			updates = append(updates, removeAlias(alias))
+		}
+	}
+
+	return keyIDs, updates
+}
+
+// checkKeyRecords checks that the records of the keys are encrypted keysets that can be decrypted. It returns the
+// updates that remove broken keys from the metadata and the records to quarantine.
+func (c *Command) checkKeyRecords(meta *keyStoreMeta, keyIDs []string, mainKeyReadable bool,
+	report *RepairReport) ([]func(meta *keyStoreMeta), []quarantinedRecord, error) {
+	store, err := c.keyStorageProvider.OpenStore(localkms.Namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open key store: %w", err)
+	}
+
+	// localkms stores keysets under prefixed IDs
+	store, err = prefix.NewPrefixStoreWrapper(store, prefix.StorageKIDPrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrap key store: %w", err)
+	}
+
+	ks, err := c.keyStoreCreator.Create(localKeyURIPrefix+mainKeyIDOrNoop(meta.MainKeyID), &keyStoreProvider{
+		storageProvider: c.keyStorageProvider,
+		secretLock:      key.NewLock(&keyLockProvider{kms: c.kms, crypto: c.crypto}),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create key store: %w", err)
+	}
+
+	var (
+		updates     []func(meta *keyStoreMeta)
+		quarantine  []quarantinedRecord
+		undecrypted []quarantinedRecord
+		decrypted   int
+	)
+
+	for _, kid := range keyIDs {
+		record, err := store.Get(kid)
+		if stderrors.Is(err, storage.ErrDataNotFound) {
+			report.add("key "+kid, "record is missing", RepairFixRemoveFromKeyList)
+			updates = append(updates, removeKeyID(kid))
+
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("get key %s: %w", kid, err)
+		}
+
+		if err = checkEncryptedKeyset(record); err != nil {
+			reason := fmt.Sprintf("record is not an encrypted keyset: %s", err)
+
+			report.add("key "+kid, reason, RepairFixQuarantine)
+			updates = append(updates, removeKeyID(kid))
+			quarantine = append(quarantine, quarantinedRecord{
+				KeyStoreID: meta.ID, KeyID: kid, Reason: reason, Record: record,
+			})
+
+			continue
+		}
+
+		if !mainKeyReadable {
+			continue
+		}
+
+		if _, err = ks.Get(kid); err != nil {
+			undecrypted = append(undecrypted, quarantinedRecord{
+				KeyStoreID: meta.ID, KeyID: kid, Reason: fmt.Sprintf("record can't be decrypted: %s", err), Record: record,
+			})
+
+			continue
+		}
+
+		decrypted++
+	}
+
+	for _, r := range undecrypted {
+		if decrypted == 0 {
+			// most likely the records are fine, but the key store doesn't use the server's secret lock
+			report.add("key "+r.KeyID, r.Reason+"; no key of the key store can be decrypted, so it may use "+
+				"another secret lock", "")
+
+			continue
+		}
+
+		report.add("key "+r.KeyID, r.Reason, RepairFixQuarantine)
+		updates = append(updates, removeKeyID(r.KeyID))
+		quarantine = append(quarantine, r)
+	}
+
+	return updates, quarantine, nil
+}
+
+// quarantineKeys moves the key records to QuarantineStore.
+func (c *Command) quarantineKeys(records []quarantinedRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	quarantine, err := c.storageProvider.OpenStore(QuarantineStore)
+	if err != nil {
+		return fmt.Errorf("open quarantine store: %w", err)
+	}
+
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal quarantined key %s: %w", r.KeyID, err)
+		}
+
+		if err = quarantine.Put(r.KeyStoreID+"/"+r.KeyID, b); err != nil {
+			return fmt.Errorf("quarantine key %s: %w", r.KeyID, err)
+		}
+
+		if err = deleteKeys(c.keyStorageProvider, r.KeyID); err != nil {
+			return fmt.Errorf("delete quarantined key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// checkEncryptedKeyset checks that the record is a JSON-encoded encrypted keyset, the way localkms stores keys.
+func checkEncryptedKeyset(record []byte) error {
+	encrypted, err := keyset.NewJSONReader(bytes.NewReader(record)).ReadEncrypted()
+	if err != nil {
+		return err //nolint:wrapcheck // reported as is
+	}
+
+	if len(encrypted.EncryptedKeyset) == 0 {
+		return stderrors.New("encrypted keyset is empty")
+	}
+
+	return nil
+}
+
+// appendKeyID adds the key to the end of the key list.
+func appendKeyID(keyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		for _, id := range meta.KeyIDs {
+			if id == keyID {
+				return
+			}
+		}
+
+		meta.KeyIDs = append(meta.KeyIDs, keyID)
+	}
+}
+
+// removeDuplicateKeyID keeps only the first occurrence of the key in the key list.
+func removeDuplicateKeyID(keyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		keyIDs := meta.KeyIDs[:0]
+		seen := false
+
+		for _, id := range meta.KeyIDs {
+			if id == keyID {
+				if seen {
+					continue
+				}
+
+				seen = true
+			}
+
+			keyIDs = append(keyIDs, id)
+		}
+
+		meta.KeyIDs = keyIDs
+	}
+}
+
+func removeAlias(alias string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		delete(meta.Aliases, alias)
+	}
+}
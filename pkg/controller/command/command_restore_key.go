@@ -0,0 +1,174 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// DefaultKeyRetentionPeriod is how long a deleted key can be restored before it is purged, if not configured.
+const DefaultKeyRetentionPeriod = 7 * 24 * time.Hour
+
+// RestoreKey restores a deleted key whose retention period hasn't ended yet. Key material isn't accessed, so secret
+// shares aren't needed.
+func (c *Command) RestoreKey(w io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	keyID := meta.keyID(wr.KeyID)
+
+	seq, err := c.incrementSequenceChecked(wr.KeyStoreID, c.checkKeyRestorable(keyID), setKeyRestored(keyID))
+	if err != nil {
+		return fmt.Errorf("restore key: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(RestoreKeyResponse{
+		KeyURL:   fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, keyID),
+		Sequence: seq,
+	})
+}
+
+// checkKeyRestorable fails if the key isn't deleted, or if its retention period ended and it is about to be purged.
+func (c *Command) checkKeyRestorable(keyID string) func(meta *keyStoreMeta) error {
+	return func(meta *keyStoreMeta) error {
+		deletedAt := meta.keyDeletedAt(keyID)
+
+		if deletedAt == nil {
+			if _, ok := meta.Keys[keyID]; ok {
+				return fmt.Errorf("%w: key %s is not deleted", errors.ErrConflict, keyID)
+			}
+
+			return fmt.Errorf("%w: key %s", errors.ErrNotFound, keyID)
+		}
+
+		if c.retentionEnded(*deletedAt) {
+			return fmt.Errorf("%w: retention period of deleted key %s ended", errors.ErrNotFound, keyID)
+		}
+
+		return nil
+	}
+}
+
+// retentionEnded returns whether a key deleted at the time can no longer be restored.
+func (c *Command) retentionEnded(deletedAt time.Time) bool {
+	return !c.clock.Now().Before(deletedAt.Add(c.keyRetentionPeriod))
+}
+
+// setKeyDeleted marks the key deleted and releases its alias. Keys created before the key store started to track its
+// keys get metadata with the deletion time only.
+func setKeyDeleted(keyID string, deletedAt time.Time) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		if meta.Keys == nil {
+			meta.Keys = make(map[string]keyMeta)
+		}
+
+		km := meta.Keys[keyID]
+		km.DeletedAt = &deletedAt
+		km.DeletedAlias = meta.aliasOf(keyID)
+
+		meta.Keys[keyID] = km
+
+		setKeyAlias(keyID, "")(meta)
+	}
+}
+
+// setKeyRestored clears the deletion mark of the key. The key gets its alias back unless another key took it.
+func setKeyRestored(keyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		km, ok := meta.Keys[keyID]
+		if !ok {
+			return
+		}
+
+		if _, taken := meta.Aliases[km.DeletedAlias]; km.DeletedAlias != "" && !taken {
+			setKeyAlias(keyID, km.DeletedAlias)(meta)
+		}
+
+		km.DeletedAt = nil
+		km.DeletedAlias = ""
+
+		meta.Keys[keyID] = km
+	}
+}
+
+// keyDeletedAt returns the time the key was deleted at, or nil if the key isn't deleted.
+func (m *keyStoreMeta) keyDeletedAt(keyID string) *time.Time {
+	if km, ok := m.Keys[keyID]; ok {
+		return km.DeletedAt
+	}
+
+	return nil
+}
+
+// deletedKeyIDs returns IDs of the deleted keys of the key store.
+func (m *keyStoreMeta) deletedKeyIDs() map[string]bool {
+	deleted := make(map[string]bool)
+
+	for keyID, km := range m.Keys {
+		if km.DeletedAt != nil {
+			deleted[keyID] = true
+		}
+	}
+
+	return deleted
+}
+
+// deletedKeysKeyManager hides deleted keys of a key store: they are reported as not found until they are restored or
+// purged.
+type deletedKeysKeyManager struct {
+	kms.KeyManager
+	deleted map[string]bool
+}
+
+// hideDeletedKeys wraps the key manager of the key store, if the key store has deleted keys.
+func hideDeletedKeys(ks kms.KeyManager, meta *keyStoreMeta) kms.KeyManager {
+	deleted := meta.deletedKeyIDs()
+	if len(deleted) == 0 {
+		return ks
+	}
+
+	return &deletedKeysKeyManager{KeyManager: ks, deleted: deleted}
+}
+
+func (k *deletedKeysKeyManager) Get(keyID string) (interface{}, error) {
+	if k.deleted[keyID] {
+		return nil, fmt.Errorf("key %s is deleted: %w", keyID, storage.ErrDataNotFound)
+	}
+
+	return k.KeyManager.Get(keyID)
+}
+
+func (k *deletedKeysKeyManager) Rotate(kt kms.KeyType, keyID string) (string, interface{}, error) {
+	if k.deleted[keyID] {
+		return "", nil, fmt.Errorf("%w: key %s", errors.ErrNotFound, keyID)
+	}
+
+	return k.KeyManager.Rotate(kt, keyID)
+}
+
+func (k *deletedKeysKeyManager) ExportPubKeyBytes(keyID string) ([]byte, kms.KeyType, error) {
+	if k.deleted[keyID] {
+		return nil, "", fmt.Errorf("key %s is deleted: %w", keyID, storage.ErrDataNotFound)
+	}
+
+	return k.KeyManager.ExportPubKeyBytes(keyID)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+)
+
+// validateKeySize validates the key size of a create key request. Only RSA keys have a selectable size.
+func validateKeySize(kt kms.KeyType, size int) error {
+	if size == 0 {
+		return nil
+	}
+
+	if isAESGCMKeyType(kt) {
+		if _, ok := aesGCMKeyTypes[size]; !ok {
+			return fmt.Errorf("%w: key_size of AES-GCM keys must be 128 or 256", errors.ErrValidation)
+		}
+
+		return nil
+	}
+
+	if kt != rsapss.KeyType {
+		return fmt.Errorf("%w: key_size is only supported for %s, %s and %s keys", errors.ErrValidation,
+			rsapss.KeyType, kms.AES128GCMType, kms.AES256GCMType)
+	}
+
+	if !rsapss.IsKeySize(size) {
+		return fmt.Errorf("%w: key_size must be one of %v", errors.ErrValidation, rsapss.KeySizes)
+	}
+
+	return nil
+}
+
+// checkKeyStorage checks that keys of the type can be stored in the key store. RSA keys aren't stored in EDV-backed
+// key stores: every key is a separate encrypted document in the user's vault, and an RSA private keyset is several
+// times the size of an elliptic curve one (over 3 KB for 4096 bits keys before encryption).
+func checkKeyStorage(kt kms.KeyType, meta *keyStoreMeta) error {
+	if kt == rsapss.KeyType && meta.EDV.VaultURL != "" {
+		return fmt.Errorf("%w: %s keys can't be stored in EDV-backed key stores", errors.ErrValidation, kt)
+	}
+
+	return nil
+}
+
+// createKey creates a key of the type. RSA keys are generated by the RSA key pool, if any, and imported, so that they
+// have the requested size and, with a pool, are generated ahead of requests.
+func (c *Command) createKey(ks kms.KeyManager, kt kms.KeyType, size int) (string, error) {
+	if kt != rsapss.KeyType {
+		kid, _, err := ks.Create(kt)
+
+		return kid, err //nolint:wrapcheck
+	}
+
+	if size == 0 {
+		size = rsapss.DefaultKeySize
+	}
+
+	var (
+		priv *rsa.PrivateKey
+		err  error
+	)
+
+	if c.rsaKeyPool != nil {
+		priv, err = c.rsaKeyPool.GenerateKey(size)
+	} else {
+		priv, err = rsapss.GenerateKey(size)
+	}
+
+	if err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	kid, _, err := ks.ImportPrivateKey(priv, kt)
+
+	return kid, err //nolint:wrapcheck
+}
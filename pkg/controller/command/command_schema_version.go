@@ -0,0 +1,67 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// SchemaVersion is the version of key store and key metadata written by this server. Bump it on format changes that
+// servers of the previous version can't handle, and describe the change in the schema version table of the README.
+// Records without a version were written before versions were recorded and are read as version 0.
+const SchemaVersion = 1
+
+// SchemaVersionCode is an error code returned in the body of a request rejected because a record has a newer schema
+// version than the server supports.
+const SchemaVersionCode = "SCHEMA_VERSION_UNSUPPORTED"
+
+// SchemaVersionError is returned when a record has a newer schema version than the server supports. The server
+// refuses to operate on such records: rewriting them would drop fields it doesn't know.
+type SchemaVersionError struct {
+	// Record names the record, e.g. "key store c5s7ocbhfhd2u4cnqm7g".
+	Record  string
+	Version int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("%s: %s has schema version %d, but this server supports schema versions up to %d; "+
+		"a server that supports schema version %d is required", errors.ErrUnprocessableEntity.Error(), e.Record,
+		e.Version, SchemaVersion, e.Version)
+}
+
+// Unwrap returns ErrUnprocessableEntity, so that the error is reported with 422 status.
+func (e *SchemaVersionError) Unwrap() error {
+	return errors.ErrUnprocessableEntity
+}
+
+// checkSchemaVersion fails with SchemaVersionError if the key store or any of its keys has a newer schema version
+// than the server supports. Key metadata is stored in the key store metadata, so a single key of a newer version
+// makes the whole key store read-only for this server; it's refused altogether.
+func (m *keyStoreMeta) checkSchemaVersion() error {
+	if m.SchemaVersion > SchemaVersion {
+		return &SchemaVersionError{Record: "key store " + m.ID, Version: m.SchemaVersion}
+	}
+
+	keyIDs := make([]string, 0, len(m.Keys))
+
+	for keyID := range m.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	sort.Strings(keyIDs)
+
+	for _, keyID := range keyIDs {
+		if v := m.Keys[keyID].SchemaVersion; v > SchemaVersion {
+			return &SchemaVersionError{Record: fmt.Sprintf("key %s of key store %s", keyID, m.ID), Version: v}
+		}
+	}
+
+	return nil
+}
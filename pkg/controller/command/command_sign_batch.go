@@ -0,0 +1,64 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// DefaultMaxSignBatchSize is the default maximum number of messages signed in a single request.
+const DefaultMaxSignBatchSize = 100
+
+// SignBatch signs a batch of messages with the key. Signatures are returned in the order of messages; if any message
+// fails to sign, no signatures are returned.
+func (c *Command) SignBatch(w io.Writer, r io.Reader) error {
+	var req SignBatchRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if len(req.Messages) == 0 || len(req.Messages) > c.maxSignBatchSize {
+		return fmt.Errorf("%w: number of messages must be from 1 to %d", errors.ErrValidation, c.maxSignBatchSize)
+	}
+
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeSign, wr)
+	if err != nil {
+		return err
+	}
+
+	signatures := make([][]byte, len(req.Messages))
+	sign := c.signer(wr)
+
+	// the batch takes one worker, so that a large batch doesn't queue ahead of other requests message by message
+	err = c.runCrypto(wr, func() error {
+		for i, message := range req.Messages {
+			signStartTime := c.clock.Now()
+
+			signature, signErr := sign(message, kh)
+			if signErr != nil {
+				return fmt.Errorf("sign message %d: %w", i, signErr)
+			}
+
+			c.metrics.CryptoSignTime(c.clock.Now().Sub(signStartTime))
+
+			signatures[i] = signature
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(SignBatchResponse{Signatures: signatures})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/ecdsasig"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+)
+
+// SignJWT signs JWT claims with the key and returns a compact JWS. alg is chosen by the key type: EdDSA for ED25519
+// keys, ES256, ES384 or ES512 for NIST ECDSA keys, ES256K for secp256k1 keys and PS256 for RSA-PSS keys. In detached
+// mode, the claims are signed unencoded (RFC 7797) and left out of the JWS, as VC-JWT proofs expect.
+func (c *Command) SignJWT(w io.Writer, r io.Reader) error {
+	var req SignJWTRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	payload, err := jwtPayload(req.Claims)
+	if err != nil {
+		return err
+	}
+
+	ks, err := c.resolveKeyStoreForActiveKey(wr, KeyPurposeSign)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	// JWS algorithms pair every curve with a single hash
+	if err = checkDefaultKeyHash(wr, "JWS"); err != nil {
+		return err
+	}
+
+	_, kt, err := ks.ExportPubKeyBytes(wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("export public key bytes: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	alg := jwkAlgorithm(kt)
+	if alg == "" {
+		return fmt.Errorf("%w: key %s of type %s can't sign a JWS", errors.ErrUnprocessableEntity, wr.KeyID, kt)
+	}
+
+	header, err := jwsHeader(req.Headers, alg, fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID,
+		wr.KeyID), req.Detached)
+	if err != nil {
+		return err
+	}
+
+	kh, err := ks.Get(wr.KeyID)
+	if err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	signingInput := encodedHeader + "." + encodedPayload
+
+	if req.Detached {
+		encodedPayload = ""
+		signingInput = encodedHeader + "." + string(payload)
+	}
+
+	signStartTime := c.clock.Now()
+
+	var signature []byte
+
+	err = c.runCrypto(wr, func() error {
+		var signErr error
+
+		signature, signErr = c.crypto.Sign([]byte(signingInput), kh)
+
+		return signErr
+	})
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	c.metrics.CryptoSignTime(c.clock.Now().Sub(signStartTime))
+
+	if signature, err = jwsSignature(signature, kt); err != nil {
+		return err
+	}
+
+	c.recordKeyUse(wr.KeyStoreID, wr.KeyID)
+
+	return json.NewEncoder(w).Encode(SignJWTResponse{
+		JWS: encodedHeader + "." + encodedPayload + "." + base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// jwtPayload returns the claims in compact form. It fails with ErrValidation unless the claims are a JSON object.
+func jwtPayload(claims json.RawMessage) ([]byte, error) {
+	var obj map[string]json.RawMessage
+
+	if err := json.Unmarshal(claims, &obj); err != nil || obj == nil {
+		return nil, fmt.Errorf("%w: claims must be a JSON object", errors.ErrValidation)
+	}
+
+	var buf bytes.Buffer
+
+	if err := json.Compact(&buf, claims); err != nil {
+		return nil, fmt.Errorf("compact claims: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jwsHeader returns the protected header of the JWS. alg, b64 and crit are set by the server; an alg header that
+// doesn't match the key is rejected rather than silently replaced.
+func jwsHeader(headers map[string]interface{}, alg, keyURL string, detached bool) ([]byte, error) {
+	header := make(map[string]interface{}, len(headers)+3) //nolint:gomnd
+
+	for name, value := range headers {
+		header[name] = value
+	}
+
+	if v, ok := header["alg"]; ok && v != alg {
+		return nil, fmt.Errorf("%w: alg header %v doesn't match the key, %s expected", errors.ErrValidation, v, alg)
+	}
+
+	for _, name := range []string{"b64", "crit"} {
+		if _, ok := header[name]; ok {
+			return nil, fmt.Errorf("%w: %s header is set by the server", errors.ErrValidation, name)
+		}
+	}
+
+	header["alg"] = alg
+
+	if _, ok := header["kid"]; !ok {
+		header["kid"] = keyURL
+	}
+
+	if detached {
+		header["b64"] = false
+		header["crit"] = []string{"b64"}
+	}
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: headers can't be marshaled: %s", errors.ErrValidation, err)
+	}
+
+	return b, nil
+}
+
+// jwsSignature converts an ASN.1 DER ECDSA signature to the fixed-size r||s form JWS requires (RFC 7518). Other
+// signatures are returned as is.
+func jwsSignature(signature []byte, kt kms.KeyType) ([]byte, error) {
+	var size int
+
+	switch kt { //nolint:exhaustive
+	case kms.ECDSAP256TypeDER, secp256k1.KeyTypeDER:
+		size = 32
+	case kms.ECDSAP384TypeDER:
+		size = 48
+	case kms.ECDSAP521TypeDER:
+		size = 66
+	default:
+		return signature, nil
+	}
+
+	b, err := ecdsasig.ToIEEEP1363(signature, size)
+	if err != nil {
+		return nil, fmt.Errorf("convert der signature: %w", err)
+	}
+
+	return b, nil
+}
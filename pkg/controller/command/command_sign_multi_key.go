@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/cryptopool"
+)
+
+const (
+	// DefaultMaxSignMultiKeyMessageSize is the default maximum size in bytes of a message of a multi-key sign request.
+	DefaultMaxSignMultiKeyMessageSize = 64 * 1024
+	// DefaultMaxSignMultiKeyTotalSize is the default maximum total size in bytes of messages of a multi-key sign
+	// request.
+	DefaultMaxSignMultiKeyTotalSize = 1024 * 1024
+)
+
+// SignMultiKey signs messages with keys of the key store, e.g. the credentials of a bundle issued together.
+// Signatures are returned in the order of items. All keys are resolved before anything is signed: if any key is
+// missing, disabled or not allowed to sign, or any message fails to sign, no signatures are returned.
+func (c *Command) SignMultiKey(w io.Writer, r io.Reader) error {
+	var req SignMultiKeyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = c.validateSignMultiKeyRequest(&req); err != nil {
+		return err
+	}
+
+	keyHandles := make([]interface{}, len(req.Items))
+	signers := make([]func([]byte, interface{}) ([]byte, error), len(req.Items))
+
+	for i, item := range req.Items {
+		itemWr := *wr
+		itemWr.KeyID = item.KeyID
+
+		kh, khErr := c.getActiveKeyHandleFromRequest(KeyPurposeSign, &itemWr)
+		if khErr != nil {
+			return fmt.Errorf("item %d: %w", i, khErr)
+		}
+
+		keyHandles[i] = kh
+		signers[i] = c.signer(&itemWr)
+
+		// the request takes a worker of the most expensive class of its keys
+		if i == 0 || cryptopool.ClassOf(itemWr.keyType) == cryptopool.ClassExpensive {
+			wr.keyType = itemWr.keyType
+		}
+	}
+
+	signatures := make([][]byte, len(req.Items))
+
+	err = c.runCrypto(wr, func() error {
+		for i, item := range req.Items {
+			signStartTime := c.clock.Now()
+
+			signature, signErr := signers[i](item.Message, keyHandles[i])
+			if signErr != nil {
+				return fmt.Errorf("sign item %d: %w", i, signErr)
+			}
+
+			c.metrics.CryptoSignTime(c.clock.Now().Sub(signStartTime))
+
+			signatures[i] = signature
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(SignMultiKeyResponse{Signatures: signatures})
+}
+
+func (c *Command) validateSignMultiKeyRequest(req *SignMultiKeyRequest) error {
+	if len(req.Items) == 0 || len(req.Items) > c.maxSignBatchSize {
+		return fmt.Errorf("%w: number of items must be from 1 to %d", errors.ErrValidation, c.maxSignBatchSize)
+	}
+
+	var totalSize int
+
+	for i, item := range req.Items {
+		if item.KeyID == "" {
+			return fmt.Errorf("%w: item %d: key id must be non-empty", errors.ErrValidation, i)
+		}
+
+		if len(item.Message) > c.maxMultiKeyMessage {
+			return fmt.Errorf("%w: item %d: message exceeds %d bytes", errors.ErrValidation, i,
+				c.maxMultiKeyMessage)
+		}
+
+		totalSize += len(item.Message)
+	}
+
+	if totalSize > c.maxMultiKeyTotal {
+		return fmt.Errorf("%w: messages exceed %d bytes in total", errors.ErrValidation, c.maxMultiKeyTotal)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	stderrors "errors"
+	"fmt"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/prehash"
+)
+
+// checkPrehashed fails if a prehashed request signs anything else than a digest in Message.
+func (r *SignRequest) checkPrehashed() error {
+	if !r.Prehashed {
+		return nil
+	}
+
+	if len(r.Messages) > 0 || len(r.Document) > 0 || r.Canonicalization != "" {
+		return fmt.Errorf("%w: a prehashed digest can't be combined with messages or document", errors.ErrValidation)
+	}
+
+	if r.Deterministic {
+		return fmt.Errorf("%w: a prehashed digest can't be signed deterministically", errors.ErrValidation)
+	}
+
+	if len(r.Message) == 0 {
+		return fmt.Errorf("%w: a prehashed digest is required in message", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// prehashAlgorithm returns the algorithm the key of the request signs the digest with, and fails if the size of the
+// digest doesn't match it.
+func prehashAlgorithm(wr *WrappedRequest, kh interface{}, digest []byte) (prehash.Algorithm, error) {
+	algorithm, err := prehash.AlgorithmOf(kh)
+	if stderrors.Is(err, prehash.ErrUnsupportedKey) {
+		return "", fmt.Errorf("%w: key %s of type %s can't sign a prehashed digest, an ed25519 or ecdsa key is "+
+			"required", errors.ErrUnprocessableEntity, wr.KeyID, wr.keyType)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("prehashed algorithm: %w", err)
+	}
+
+	if len(digest) != algorithm.DigestSize() {
+		return "", fmt.Errorf("%w: %s signs %d-byte digests, got %d bytes", errors.ErrValidation, algorithm,
+			algorithm.DigestSize(), len(digest))
+	}
+
+	return algorithm, nil
+}
@@ -0,0 +1,202 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/prehash"
+	"github.com/trustbloc/kms/pkg/upload"
+)
+
+// CreateUpload creates an upload of a payload to be signed with the key, for payloads too large to be sent in a sign
+// request. The payload is uploaded in chunks with PutUploadChunk and signed with SignUpload.
+func (c *Command) CreateUpload(w io.Writer, r io.Reader) error {
+	var req CreateUploadRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if c.uploads == nil {
+		return fmt.Errorf("%w: chunked uploads are disabled", errors.ErrValidation)
+	}
+
+	// a key that can't sign the upload is rejected before the payload is uploaded
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeSign, wr)
+	if err != nil {
+		return err
+	}
+
+	algorithm, err := uploadAlgorithm(wr, kh)
+	if err != nil {
+		return err
+	}
+
+	u, err := c.uploads.Create(wr.KeyStoreID, wr.KeyID, req.Size, req.ChunkSize)
+	if stderrors.Is(err, upload.ErrInvalidSize) {
+		return fmt.Errorf("%w: %s", errors.ErrValidation, err.Error())
+	}
+
+	if err != nil {
+		return fmt.Errorf("create upload: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(CreateUploadResponse{
+		UploadID:     u.ID,
+		Size:         u.Size,
+		ChunkSize:    u.ChunkSize,
+		ExpiresAt:    u.ExpiresAt,
+		Algorithm:    string(algorithm),
+		Verification: algorithm.Verification(),
+	})
+}
+
+// PutUploadChunk saves a chunk of the payload of an upload. Chunks can be uploaded in any order and in parallel, and
+// uploading the same chunk again is a no-op.
+func (c *Command) PutUploadChunk(_ io.Writer, r io.Reader) error {
+	var req PutUploadChunkRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	u, err := c.getUpload(wr)
+	if err != nil {
+		return err
+	}
+
+	err = c.uploads.PutChunk(u, req.Offset, req.Chunk, req.Checksum)
+	if stderrors.Is(err, upload.ErrInvalidChunk) {
+		return fmt.Errorf("%w: %s", errors.ErrValidation, err.Error())
+	}
+
+	if stderrors.Is(err, upload.ErrChunkConflict) {
+		return fmt.Errorf("%w: %s", errors.ErrConflict, err.Error())
+	}
+
+	if err != nil {
+		return fmt.Errorf("put chunk: %w", err)
+	}
+
+	return nil
+}
+
+// SignUpload signs the payload of an upload once all of its chunks are uploaded. The payload is hashed one chunk at
+// a time and the digest is signed, so the payload is never held in memory: ECDSA keys sign the digest of the hash of
+// the key parameters, which verifies as a signature of the payload; Ed25519 keys sign with Ed25519ph. The upload is
+// deleted once it's signed.
+func (c *Command) SignUpload(w io.Writer, r io.Reader) error {
+	wr, err := c.unwrapRequest(nil, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	u, err := c.getUpload(wr)
+	if err != nil {
+		return err
+	}
+
+	kh, err := c.getActiveKeyHandleFromRequest(KeyPurposeSign, wr)
+	if err != nil {
+		return err
+	}
+
+	algorithm, err := uploadAlgorithm(wr, kh)
+	if err != nil {
+		return err
+	}
+
+	h := algorithm.Hash().New()
+
+	err = c.uploads.Read(u, h)
+	if stderrors.Is(err, upload.ErrIncomplete) {
+		return fmt.Errorf("%w: %s", errors.ErrConflict, err.Error())
+	}
+
+	if err != nil {
+		return fmt.Errorf("read upload: %w", err)
+	}
+
+	signStartTime := c.clock.Now()
+
+	var signature []byte
+
+	err = c.runCrypto(wr, func() error {
+		var signErr error
+
+		signature, signErr = prehash.SignKeyset(h.Sum(nil), kh)
+
+		return signErr
+	})
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+
+	c.metrics.CryptoSignTime(c.clock.Now().Sub(signStartTime))
+
+	// an upload that isn't deleted is pruned once it expires
+	if err = c.uploads.Delete(u); err != nil {
+		logger.Warnf("Failed to delete upload %s of key %s of key store %s: %v", u.ID, wr.KeyID, wr.KeyStoreID, err)
+	}
+
+	return json.NewEncoder(w).Encode(SignUploadResponse{
+		Signature:    signature,
+		Size:         u.Size,
+		Algorithm:    string(algorithm),
+		Verification: algorithm.Verification(),
+	})
+}
+
+// getUpload returns the upload of the request. Uploads are bound to key IDs, so a key alias in the request is
+// resolved from the key store metadata.
+func (c *Command) getUpload(wr *WrappedRequest) (*upload.Upload, error) {
+	if c.uploads == nil {
+		return nil, fmt.Errorf("%w: chunked uploads are disabled", errors.ErrValidation)
+	}
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return nil, fmt.Errorf("get key store: %w", err)
+	}
+
+	u, err := c.uploads.Get(wr.KeyStoreID, meta.keyID(wr.KeyID), wr.UploadID)
+	if stderrors.Is(err, upload.ErrNotFound) {
+		return nil, fmt.Errorf("%w: upload %s", errors.ErrNotFound, wr.UploadID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get upload: %w", err)
+	}
+
+	return u, nil
+}
+
+// uploadAlgorithm returns the algorithm the key of the request signs uploads with.
+func uploadAlgorithm(wr *WrappedRequest, kh interface{}) (prehash.Algorithm, error) {
+	if err := checkDefaultKeyHash(wr, "chunked uploads"); err != nil {
+		return "", err
+	}
+
+	algorithm, err := prehash.AlgorithmOf(kh)
+	if stderrors.Is(err, prehash.ErrUnsupportedKey) {
+		return "", fmt.Errorf("%w: key %s of type %s can't sign an upload, an ed25519 or ecdsa key is required",
+			errors.ErrUnprocessableEntity, wr.KeyID, wr.keyType)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("upload algorithm: %w", err)
+	}
+
+	return algorithm, nil
+}
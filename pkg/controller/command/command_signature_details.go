@@ -0,0 +1,51 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/trustbloc/kms/pkg/kms/prehash"
+	"github.com/trustbloc/kms/pkg/kms/sigparams"
+)
+
+// signatureEncodingBase64 is the encoding of signatures in JSON without a signature encoding.
+const signatureEncodingBase64 = "base64"
+
+// signatureDetails returns details of signatures made or verified with the key handle, with the signature in the
+// encoding of the request or response.
+func signatureDetails(kh interface{}, encoding string) (*SignatureDetails, error) {
+	params, err := sigparams.Of(kh)
+	if err != nil {
+		return nil, fmt.Errorf("signature details: %w", err)
+	}
+
+	if encoding == "" {
+		encoding = signatureEncodingBase64
+	}
+
+	return &SignatureDetails{
+		Algorithm:       params.Algorithm,
+		Curve:           params.Curve,
+		Hash:            params.Hash,
+		SignatureFormat: params.Format,
+		JOSEAlgorithm:   params.JOSEAlgorithm,
+		COSEAlgorithm:   params.COSEAlgorithm,
+		OutputPrefix:    params.OutputPrefix,
+		KeyVersion:      params.KeyVersion,
+		Encoding:        encoding,
+	}, nil
+}
+
+// prehashed updates the details for a signature of a prehashed digest. ECDSA signs the digest either way, but an
+// Ed25519ph signature is neither EdDSA nor has a JWS or COSE algorithm.
+func (d *SignatureDetails) prehashed(algorithm prehash.Algorithm) {
+	if algorithm == prehash.Ed25519ph {
+		d.Algorithm = string(algorithm)
+		d.JOSEAlgorithm, d.COSEAlgorithm = "", 0
+	}
+}
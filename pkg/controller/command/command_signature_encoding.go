@@ -0,0 +1,201 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/multiformats/go-multibase"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// Signature encodings of sign responses and verify requests. Signatures are base64-encoded (JSON bytes) without one.
+const (
+	// SignatureEncodingBase64URL is unpadded base64url (RFC 4648 section 5).
+	SignatureEncodingBase64URL = "base64url"
+	// SignatureEncodingBase58BTC is base58 with the bitcoin alphabet, without a multibase prefix.
+	SignatureEncodingBase58BTC = "base58btc"
+	// SignatureEncodingMultibase is multibase base58btc ("z" prefix), as in proofValue of Data Integrity proofs.
+	// Any multibase encoding is accepted to verify.
+	SignatureEncodingMultibase = "multibase"
+)
+
+func checkSignatureEncoding(encoding string) error {
+	switch encoding {
+	case "", SignatureEncodingBase64URL, SignatureEncodingBase58BTC, SignatureEncodingMultibase:
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported signature encoding %q, must be one of [%s %s %s]", errors.ErrValidation,
+			encoding, SignatureEncodingBase64URL, SignatureEncodingBase58BTC, SignatureEncodingMultibase)
+	}
+}
+
+func encodeSignature(signature []byte, encoding string) (string, error) {
+	switch encoding {
+	case SignatureEncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(signature), nil
+	case SignatureEncodingBase58BTC, SignatureEncodingMultibase:
+		s, err := multibase.Encode(multibase.Base58BTC, signature)
+		if err != nil {
+			return "", err
+		}
+
+		if encoding == SignatureEncodingBase58BTC {
+			return s[1:], nil
+		}
+
+		return s, nil
+	default:
+		return base64.StdEncoding.EncodeToString(signature), nil
+	}
+}
+
+func decodeSignature(s, encoding string) ([]byte, error) {
+	var (
+		signature []byte
+		err       error
+	)
+
+	switch encoding {
+	case SignatureEncodingBase64URL:
+		signature, err = base64.RawURLEncoding.DecodeString(s)
+	case SignatureEncodingBase58BTC:
+		_, signature, err = multibase.Decode(string(multibase.Base58BTC) + s)
+	case SignatureEncodingMultibase:
+		_, signature, err = multibase.Decode(s)
+	default:
+		signature, err = base64.StdEncoding.DecodeString(s)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode %s signature: %s", errors.ErrValidation, encoding, err)
+	}
+
+	return signature, nil
+}
+
+type signResponse SignResponse
+
+// MarshalJSON encodes the signature with the encoding of the response.
+func (r SignResponse) MarshalJSON() ([]byte, error) {
+	if r.Encoding == "" {
+		return json.Marshal(signResponse(r))
+	}
+
+	signature, err := encodeSignature(r.Signature, r.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		signResponse
+		Signature string `json:"signature"`
+	}{signResponse: signResponse(r), Signature: signature})
+}
+
+// UnmarshalJSON decodes the signature with the encoding of the response.
+func (r *SignResponse) UnmarshalJSON(data []byte) error {
+	var resp struct {
+		signResponse
+		Signature json.RawMessage `json:"signature"`
+	}
+
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+
+	*r = SignResponse(resp.signResponse)
+
+	if len(resp.Signature) == 0 || string(resp.Signature) == "null" {
+		return nil
+	}
+
+	if resp.Encoding == "" {
+		return json.Unmarshal(resp.Signature, &r.Signature)
+	}
+
+	var s string
+
+	if err := json.Unmarshal(resp.Signature, &s); err != nil {
+		return err
+	}
+
+	signature, err := decodeSignature(s, resp.Encoding)
+	if err != nil {
+		return err
+	}
+
+	r.Signature = signature
+
+	return nil
+}
+
+type verifyRequest VerifyRequest
+
+// MarshalJSON encodes the signature with the encoding of the request.
+func (r VerifyRequest) MarshalJSON() ([]byte, error) {
+	if r.SignatureEncoding == "" {
+		return json.Marshal(verifyRequest(r))
+	}
+
+	signature, err := encodeSignature(r.Signature, r.SignatureEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		verifyRequest
+		Signature string `json:"signature"`
+	}{verifyRequest: verifyRequest(r), Signature: signature})
+}
+
+// UnmarshalJSON keeps the signature as is if it has an encoding, it's decoded when the request is verified.
+func (r *VerifyRequest) UnmarshalJSON(data []byte) error {
+	var req struct {
+		verifyRequest
+		Signature json.RawMessage `json:"signature"`
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	*r = VerifyRequest(req.verifyRequest)
+
+	if len(req.Signature) == 0 || string(req.Signature) == "null" {
+		return nil
+	}
+
+	if r.SignatureEncoding == "" {
+		return json.Unmarshal(req.Signature, &r.Signature)
+	}
+
+	return json.Unmarshal(req.Signature, &r.encodedSignature)
+}
+
+// decodeSignature decodes the signature of the request with its encoding.
+func (r *VerifyRequest) decodeSignature() error {
+	if r.SignatureEncoding == "" {
+		return nil
+	}
+
+	if err := checkSignatureEncoding(r.SignatureEncoding); err != nil {
+		return err
+	}
+
+	signature, err := decodeSignature(r.encodedSignature, r.SignatureEncoding)
+	if err != nil {
+		return err
+	}
+
+	r.Signature = signature
+
+	return nil
+}
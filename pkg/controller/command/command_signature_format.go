@@ -0,0 +1,94 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"fmt"
+
+	"github.com/google/tink/go/core/cryptofmt"
+
+	"github.com/trustbloc/kms/pkg/kms/ecdsasig"
+	"github.com/trustbloc/kms/pkg/kms/sigparams"
+)
+
+// verifySignature verifies the signature of the message with the public key handle of the request. ECDSA signers and verifiers
+// often disagree on the signature format, so a signature in the other format than the one of the key, DER instead of
+// IEEE P1363 or vice versa, is converted and verified again. Both formats encode the same scalars, so accepting
+// either doesn't make signatures easier to forge. If the converted signature doesn't verify either, the error names
+// the mismatch.
+func (c *Command) verifySignature(wr *WrappedRequest, signature, msg []byte, pub interface{}) error {
+	verify := c.verifier(wr)
+
+	err := verify(signature, msg, pub)
+	if err == nil {
+		return nil
+	}
+
+	converted, got, expected := convertSignatureFormat(signature, pub)
+	if converted == nil {
+		return err
+	}
+
+	if verify(converted, msg, pub) == nil {
+		logger.Debugf("Verified %s signature with a key of %s signatures", got, expected)
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: signature encoding mismatch: expected %s, got %s", err, expected, got)
+}
+
+// convertSignatureFormat converts the signature to the format of the ECDSA key if it's in the other format. It
+// returns nil if the key isn't an ECDSA key or the signature isn't in the other format.
+func convertSignatureFormat(signature []byte, pub interface{}) (converted []byte, got, expected string) {
+	params, err := sigparams.Of(pub)
+	if err != nil || params.Format == "" {
+		return nil, "", ""
+	}
+
+	size := ecdsasig.ScalarSize(params.Curve)
+	if size == 0 {
+		return nil, "", ""
+	}
+
+	// signatures of keys with an output prefix start with the prefix, the signature in either format follows it
+	var prefix []byte
+
+	if params.OutputPrefix != "RAW" {
+		if len(signature) <= cryptofmt.NonRawPrefixSize {
+			return nil, "", ""
+		}
+
+		prefix, signature = signature[:cryptofmt.NonRawPrefixSize], signature[cryptofmt.NonRawPrefixSize:]
+	}
+
+	switch params.Format {
+	case sigparams.FormatDER:
+		// a signature of the key's format is never converted, even if it could be read in the other format
+		if ecdsasig.IsDER(signature, size) || !ecdsasig.IsIEEEP1363(signature, size) {
+			return nil, "", ""
+		}
+
+		converted, err = ecdsasig.ToDER(signature, size)
+		got = sigparams.FormatIEEEP1363
+	case sigparams.FormatIEEEP1363:
+		if ecdsasig.IsIEEEP1363(signature, size) || !ecdsasig.IsDER(signature, size) {
+			return nil, "", ""
+		}
+
+		converted, err = ecdsasig.ToIEEEP1363(signature, size)
+		got = sigparams.FormatDER
+	default:
+		return nil, "", ""
+	}
+
+	if err != nil {
+		return nil, "", ""
+	}
+
+	return append(append([]byte(nil), prefix...), converted...), got, params.Format
+}
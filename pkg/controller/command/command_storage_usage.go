@@ -0,0 +1,106 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// StorageUsage returns the number of bytes each key store keeps in server storage, by key store ID: the size of its
+// metadata and of its keysets. Keys of EDV-backed key stores are stored in users' vaults and aren't counted, and
+// archived keys are counted by the size of their stubs, as they aren't recalled to be measured. A key store that
+// fails to be measured doesn't stop measuring others; it is left out and the last error is returned.
+func (c *Command) StorageUsage() (map[string]int64, error) {
+	keyStoreIDs, err := c.keyStoreIDs(func(*keyStoreMeta) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	keySets, err := c.keySetStore()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		usage    = make(map[string]int64, len(keyStoreIDs))
+		usageErr error
+	)
+
+	for _, keyStoreID := range keyStoreIDs {
+		n, err := c.storageUsage(keyStoreID, keySets)
+		if err != nil {
+			usageErr = fmt.Errorf("measure key store %s: %w", keyStoreID, err)
+
+			continue
+		}
+
+		usage[keyStoreID] = n
+	}
+
+	return usage, usageErr
+}
+
+// keySetStore returns the store of keysets of primary key storage, so that archived keys aren't recalled.
+func (c *Command) keySetStore() (storage.Store, error) {
+	provider := c.keyStorageProvider
+	if c.keyArchive != nil {
+		provider = c.keyArchive.Primary()
+	}
+
+	store, err := provider.OpenStore(localkms.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("open key store: %w", err)
+	}
+
+	// localkms stores keysets under prefixed IDs
+	store, err = prefix.NewPrefixStoreWrapper(store, prefix.StorageKIDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key store: %w", err)
+	}
+
+	return store, nil
+}
+
+func (c *Command) storageUsage(keyStoreID string, keySets storage.Store) (int64, error) {
+	b, err := c.store.Get(keyStoreID)
+	if err != nil {
+		return 0, fmt.Errorf("get key store meta: %w", err)
+	}
+
+	var meta keyStoreMeta
+
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return 0, fmt.Errorf("unmarshal key store meta: %w", err)
+	}
+
+	n := int64(len(b))
+
+	if meta.EDV.VaultURL != "" {
+		return n, nil
+	}
+
+	for _, keyID := range meta.KeyIDs {
+		keySet, err := keySets.Get(keyID)
+		if errors.Is(err, storage.ErrDataNotFound) {
+			continue // purged, or lost and waiting to be repaired
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("get key %s: %w", keyID, err)
+		}
+
+		n += int64(len(keySet))
+	}
+
+	return n, nil
+}
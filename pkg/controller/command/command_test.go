@@ -9,35 +9,95 @@ package command_test
 import (
 	"bytes"
 	"context"
+	gocrypto "crypto"
+	gocipher "crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcutil/base58"
 	"github.com/golang/mock/gomock"
+	"github.com/golang/protobuf/proto"
 	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/insecurecleartextkeyset"
 	"github.com/google/tink/go/keyset"
+	chachapb "github.com/google/tink/go/proto/chacha20_poly1305_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	xchachapb "github.com/google/tink/go/proto/xchacha20_poly1305_go_proto"
 	"github.com/google/tink/go/signature"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/primitive/bbs12381g2pub"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto/primitive/composite/ecdh"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto/primitive/composite/keyio"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk/jwksupport"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
 	mockcrypto "github.com/hyperledger/aries-framework-go/pkg/mock/crypto"
 	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
 	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/multiformats/go-multibase"
+	"github.com/square/go-jose/v3"
 	"github.com/stretchr/testify/require"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
 
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/clock"
 	. "github.com/trustbloc/kms/pkg/controller/command"
+	kmserrors "github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/controller/rest"
+	"github.com/trustbloc/kms/pkg/cryptopool"
+	"github.com/trustbloc/kms/pkg/didkey"
+	"github.com/trustbloc/kms/pkg/idempotency"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+	"github.com/trustbloc/kms/pkg/keyusage"
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+	"github.com/trustbloc/kms/pkg/kms/ecdsasig"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+	"github.com/trustbloc/kms/pkg/kms/subkey"
+	"github.com/trustbloc/kms/pkg/kms/x25519"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+	"github.com/trustbloc/kms/pkg/secretshare"
+	"github.com/trustbloc/kms/pkg/signnonce"
+	"github.com/trustbloc/kms/pkg/storage/archive"
+	"github.com/trustbloc/kms/pkg/upload"
+	"github.com/trustbloc/kms/pkg/usagereport"
+	"github.com/trustbloc/kms/pkg/verifycache"
 )
 
 func TestNew(t *testing.T) {
@@ -51,6 +111,17 @@ func TestNew(t *testing.T) {
 		require.Nil(t, cmd)
 		require.EqualError(t, err, "open key store db: open store error")
 	})
+
+	t.Run("Fail to set key store db config", func(t *testing.T) {
+		store := mockstorage.NewMockStoreProvider()
+		store.ErrSetStoreConfig = errors.New("set config error")
+
+		cmd, err := New(&Config{
+			StorageProvider: store,
+		})
+		require.Nil(t, cmd)
+		require.EqualError(t, err, "set key store db config: set config error")
+	})
 }
 
 func TestCommand_CreateDID(t *testing.T) {
@@ -121,17 +192,19 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 			Times(1)
 
 		cache := NewMockCacheProvider(ctrl)
-		cache.EXPECT().Wrap(gomock.Any(), gomock.Any()).Times(1)
+		cache.EXPECT().Wrap(gomock.Any(), gomock.Any(), gomock.Any()).Times(1)
 
 		cmd, err := New(&Config{
-			StorageProvider:  mockstorage.NewMockStoreProvider(),
-			KMS:              km,
-			Crypto:           cr,
-			KeyStoreCreator:  creator,
-			ZCAPService:      zcap,
-			EnableZCAPs:      true,
-			CacheProvider:    cache,
-			KeyStoreCacheTTL: 10 * time.Second,
+			StorageProvider:     mockstorage.NewMockStoreProvider(),
+			KMS:                 km,
+			Crypto:              cr,
+			KeyStoreCreator:     creator,
+			ZCAPService:         zcap,
+			EnableZCAPs:         true,
+			CacheProvider:       cache,
+			KeyStoreCacheTTL:    10 * time.Second,
+			EDVRecipientKeyType: kms.NISTP256ECDHKW,
+			EDVMACKeyType:       kms.HMACSHA256Tag256,
 		})
 		require.NoError(t, err)
 		require.NotNil(t, cmd)
@@ -168,14 +241,25 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
 
 		shamirLockCreator := NewMockShamirSecretLockCreator(ctrl)
-		shamirLockCreator.EXPECT().Create(gomock.Any()).Return(nil, nil).Times(1)
+		shamirLockCreator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
 
 		shamirProvider := NewMockShamirProvider(ctrl)
 		shamirProvider.EXPECT().FetchSecretShare(gomock.Any()).Return([]byte("secret share"), nil).Times(1)
 
 		zcap := NewMockZCAPService(ctrl)
 		zcap.EXPECT().NewCapability(context.Background(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-			Return(&zcapld.Capability{}, nil).
+			DoAndReturn(func(_ context.Context, opts ...zcapld.CapabilityOption) (*zcapld.Capability, error) {
+				var options zcapld.CapabilityOptions
+
+				for _, opt := range opts {
+					opt(&options)
+				}
+
+				require.Contains(t, options.AllowedAction, ActionDeriveKey)
+				require.Contains(t, options.AllowedAction, ActionDeriveSubkey)
+
+				return &zcapld.Capability{}, nil
+			}).
 			Times(1)
 
 		cmd, err := New(&Config{
@@ -209,6 +293,88 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 		require.NoError(t, err)
 	})
 
+	t.Run("Success with XOR secret share scheme", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		creator := NewMockKeyStoreCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+
+		shamirLockCreator := NewMockShamirSecretLockCreator(ctrl)
+		shamirLockCreator.EXPECT().Create(secretshare.SchemeXOR, gomock.Any()).Return(nil, nil).Times(1)
+
+		shamirProvider := NewMockShamirProvider(ctrl)
+		shamirProvider.EXPECT().FetchSecretShare(gomock.Any()).Return([]byte("secret share"), nil).Times(1)
+
+		storageProvider := mockstorage.NewMockStoreProvider()
+
+		cmd, err := New(&Config{
+			StorageProvider:         storageProvider,
+			KMS:                     &mockkms.KeyManager{},
+			KeyStoreCreator:         creator,
+			ShamirSecretLockCreator: shamirLockCreator,
+			ShamirProvider:          shamirProvider,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		req, err := json.Marshal(CreateKeyStoreRequest{
+			Controller:        "did:example:test",
+			SecretShareScheme: secretshare.SchemeXOR,
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			Request:     req,
+			User:        "user",
+			SecretShare: []byte("secret share"),
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKeyStore(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		for _, entry := range storageProvider.Store.Store {
+			require.Contains(t, string(entry.Value), `"secret_share_scheme":"xor"`)
+		}
+	})
+
+	t.Run("Success with clock", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		creator := NewMockKeyStoreCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+
+		storageProvider := mockstorage.NewMockStoreProvider()
+
+		cmd, err := New(&Config{
+			StorageProvider: storageProvider,
+			KMS:             &mockkms.KeyManager{},
+			KeyStoreCreator: creator,
+			Clock:           testutil.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.FixedZone("EEST", 3*60*60))),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		req, err := json.Marshal(CreateKeyStoreRequest{
+			Controller: "did:example:test",
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{Request: req})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKeyStore(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		for _, entry := range storageProvider.Store.Store {
+			require.Contains(t, string(entry.Value), `"created_at":"2022-06-01T09:00:00Z"`)
+		}
+	})
+
 	t.Run("Fail to decode a wrapped request", func(t *testing.T) {
 		cmd, err := New(&Config{
 			StorageProvider: mockstorage.NewMockStoreProvider(),
@@ -243,6 +409,31 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 		require.EqualError(t, err, "validate request: validation failed: controller must be non-empty")
 	})
 
+	t.Run("Fail to validate secret share scheme", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		req, err := json.Marshal(CreateKeyStoreRequest{
+			Controller:        "did:example:test",
+			SecretShareScheme: "invalid",
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			Request: req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKeyStore(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err,
+			"validate request: validation failed: not supported secret share scheme: invalid")
+	})
+
 	t.Run("Fail to prepare EDV provider", func(t *testing.T) {
 		cr, err := tinkcrypto.New()
 		require.NoError(t, err)
@@ -252,9 +443,11 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 		}
 
 		cmd, err := New(&Config{
-			StorageProvider: mockstorage.NewMockStoreProvider(),
-			KMS:             km,
-			Crypto:          cr,
+			StorageProvider:     mockstorage.NewMockStoreProvider(),
+			KMS:                 km,
+			Crypto:              cr,
+			EDVRecipientKeyType: kms.NISTP256ECDHKW,
+			EDVMACKeyType:       kms.HMACSHA256Tag256,
 		})
 		require.NoError(t, err)
 		require.NotNil(t, cmd)
@@ -278,6 +471,123 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 		require.EqualError(t, err, "prepare edv provider: create edv recipient key: create key: create pub key error")
 	})
 
+	t.Run("Fail to resolve EDV vault URL", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cr, err := tinkcrypto.New()
+		require.NoError(t, err)
+
+		km := &mockkms.KeyManager{
+			CrAndExportPubKeyValue: createRecipientPubKey(t),
+		}
+
+		resolver := NewMockURLResolver(ctrl)
+		resolver.EXPECT().Resolve("dns+srv://_edv._tcp.example.com/encrypted-data-vaults/vault-id").
+			Return("", errors.New("resolve error")).Times(1)
+
+		cmd, err := New(&Config{
+			StorageProvider:     mockstorage.NewMockStoreProvider(),
+			KMS:                 km,
+			Crypto:              cr,
+			URLResolver:         resolver,
+			EDVRecipientKeyType: kms.NISTP256ECDHKW,
+			EDVMACKeyType:       kms.HMACSHA256Tag256,
+		})
+		require.NoError(t, err)
+
+		req, err := json.Marshal(CreateKeyStoreRequest{
+			Controller: "did:example:test",
+			EDV: &EDVOptions{
+				VaultURL: "dns+srv://_edv._tcp.example.com/encrypted-data-vaults/vault-id",
+			},
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			Request: req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKeyStore(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "prepare edv provider: create edv provider: resolve vault url: resolve error")
+	})
+
+	t.Run("Fail with invalid storage mode", func(t *testing.T) {
+		edv := &EDVOptions{VaultURL: "https://edv-host/encrypted-data-vaults/vault-id"}
+
+		tests := []struct {
+			name   string
+			req    CreateKeyStoreRequest
+			config *Config
+			err    string
+		}{
+			{
+				name: "unsupported storage mode",
+				req:  CreateKeyStoreRequest{StorageMode: "LocalStorage"},
+				err:  `unsupported storage_mode "LocalStorage", must be one of [local edv]`,
+			},
+			{
+				name: "local storage mode with edv options",
+				req:  CreateKeyStoreRequest{StorageMode: StorageModeLocal, EDV: edv},
+				err:  "edv options are not allowed with storage_mode local",
+			},
+			{
+				name: "edv storage mode without edv options",
+				req:  CreateKeyStoreRequest{StorageMode: StorageModeEDV},
+				err:  "edv options are required with storage_mode edv",
+			},
+			{
+				name: "edv options without vault url",
+				req:  CreateKeyStoreRequest{EDV: &EDVOptions{}},
+				err:  "edv vault_url must be non-empty",
+			},
+			{
+				name:   "edv storage mode without edv support",
+				req:    CreateKeyStoreRequest{StorageMode: StorageModeEDV, EDV: edv},
+				config: &Config{StorageProvider: mockstorage.NewMockStoreProvider(), KMS: &mockkms.KeyManager{}},
+				err:    "storage_mode edv is not supported by the server",
+			},
+			{
+				name:   "edv options without edv support",
+				req:    CreateKeyStoreRequest{EDV: edv},
+				config: &Config{StorageProvider: mockstorage.NewMockStoreProvider(), KMS: &mockkms.KeyManager{}},
+				err:    "storage_mode edv is not supported by the server",
+			},
+		}
+
+		for _, tt := range tests {
+			tc := tt
+			t.Run(tc.name, func(t *testing.T) {
+				config := tc.config
+				if config == nil {
+					config = &Config{
+						StorageProvider:     mockstorage.NewMockStoreProvider(),
+						KMS:                 &mockkms.KeyManager{},
+						EDVRecipientKeyType: kms.NISTP256ECDHKW,
+						EDVMACKeyType:       kms.HMACSHA256Tag256,
+					}
+				}
+
+				cmd, err := New(config)
+				require.NoError(t, err)
+
+				tc.req.Controller = "did:example:test"
+
+				req, err := json.Marshal(tc.req)
+				require.NoError(t, err)
+
+				wr, err := json.Marshal(WrappedRequest{Request: req})
+				require.NoError(t, err)
+
+				err = cmd.CreateKeyStore(&bytes.Buffer{}, bytes.NewBuffer(wr))
+				require.ErrorIs(t, err, kmserrors.ErrValidation)
+				require.Contains(t, err.Error(), tc.err)
+			})
+		}
+	})
+
 	t.Run("Fail to fetch secret share from auth server", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 
@@ -532,124 +842,356 @@ func TestCommand_CreateKeyStore(t *testing.T) {
 	})
 }
 
-func TestCommand_CreateKey(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
-			CreateKeyID: "key_id",
-		}))
+func TestCommand_CreateKeyStoreIdempotency(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv, idempotencyKey string, req CreateKeyStoreRequest) (string,
+		error) {
+		t.Helper()
 
-		req, err := json.Marshal(CreateKeyRequest{
-			KeyType: kms.ED25519,
-		})
+		b, err := json.Marshal(req)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			Request:    req,
-		})
+		wr, err := json.Marshal(WrappedRequest{IdempotencyKey: idempotencyKey, Request: b})
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		var resp CreateKeyStoreResponse
 
-		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+		err = env.cmd.CreateKeyStore(encodeResponse(t, &resp), bytes.NewBuffer(wr))
 
-		var resp CreateKeyResponse
+		return resp.KeyStoreURL, err
+	}
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+	controllerReq := CreateKeyStoreRequest{Controller: "did:example:controller"}
+
+	t.Run("Retry with idempotency key returns the key store", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withIdempotencyKeys(newIdempotencyKeys(t)))
+
+		url1, err := createKeyStore(t, env, "retry-1", controllerReq)
 		require.NoError(t, err)
-		require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
-	})
 
-	t.Run("Success with EDV storage and Shamir secret lock", func(t *testing.T) {
-		keyStoreData := []byte(`{
-		  "id": "key_store_id",
-		  "controller": "controller",
-		  "edv": {
-			"vault_url": "https://edv-host/encrypted-data-vaults/vault-id"
-		  }
-		}`)
+		url2, err := createKeyStore(t, env, "retry-1", controllerReq)
+		require.NoError(t, err)
+		require.Equal(t, url1, url2)
 
-		p := mockstorage.NewMockStoreProvider()
-		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: keyStoreData}
+		url3, err := createKeyStore(t, env, "retry-2", controllerReq)
+		require.NoError(t, err)
+		require.NotEqual(t, url1, url3)
+	})
 
-		km := &mockkms.KeyManager{
-			ExportPubKeyBytesValue: createRecipientPubKey(t),
-			CreateKeyID:            "key_id",
-		}
+	t.Run("Idempotency keys are scoped to the controller", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withIdempotencyKeys(newIdempotencyKeys(t)))
 
-		ctrl := gomock.NewController(t)
+		url1, err := createKeyStore(t, env, "retry-1", controllerReq)
+		require.NoError(t, err)
 
-		shamirLockCreator := NewMockShamirSecretLockCreator(ctrl)
-		shamirLockCreator.EXPECT().Create(gomock.Any()).Return(nil, nil).Times(1)
+		url2, err := createKeyStore(t, env, "retry-1", CreateKeyStoreRequest{Controller: "did:example:other"})
+		require.NoError(t, err)
+		require.NotEqual(t, url1, url2)
+	})
 
-		shamirProvider := NewMockShamirProvider(ctrl)
-		shamirProvider.EXPECT().FetchSecretShare(gomock.Any()).Return([]byte("secret share"), nil).Times(1)
+	t.Run("Dedupe returns the key store of the same request", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withIdempotencyKeys(newIdempotencyKeys(t)))
 
-		cmd := createCmd(t, ctrl,
-			withStorageProvider(p), withKeyManager(km), withShamirSecretLockCreator(shamirLockCreator),
-			withShamirProvider(shamirProvider))
+		req := CreateKeyStoreRequest{Controller: "did:example:controller", Dedupe: true}
 
-		req, err := json.Marshal(CreateKeyRequest{
-			KeyType: kms.ED25519,
-		})
+		url1, err := createKeyStore(t, env, "", req)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID:  "key_store_id",
-			User:        "user",
-			SecretShare: []byte("secret share"),
-			Request:     req,
-		})
+		url2, err := createKeyStore(t, env, "", req)
 		require.NoError(t, err)
+		require.Equal(t, url1, url2)
 
-		var buf bytes.Buffer
+		req.VerifyCache = true
 
-		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
+		url3, err := createKeyStore(t, env, "", req)
 		require.NoError(t, err)
+		require.NotEqual(t, url1, url3)
+	})
 
-		var resp CreateKeyResponse
+	t.Run("Idempotency keys are ignored if not enabled", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+		url1, err := createKeyStore(t, env, "retry-1", controllerReq)
 		require.NoError(t, err)
-		require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
+
+		url2, err := createKeyStore(t, env, "retry-1", controllerReq)
+		require.NoError(t, err)
+		require.NotEqual(t, url1, url2)
 	})
 
-	t.Run("Fail to decode wrapped request", func(t *testing.T) {
-		cmd, err := New(&Config{
-			StorageProvider: mockstorage.NewMockStoreProvider(),
-		})
+	t.Run("Fail with idempotency key reused for another request", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withIdempotencyKeys(newIdempotencyKeys(t)))
+
+		_, err := createKeyStore(t, env, "retry-1", controllerReq)
 		require.NoError(t, err)
-		require.NotNil(t, cmd)
 
-		err = cmd.CreateKey(nil, bytes.NewBuffer(nil))
-		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
+		_, err = createKeyStore(t, env, "retry-1",
+			CreateKeyStoreRequest{Controller: "did:example:controller", VerifyCache: true})
+		require.EqualError(t, err,
+			"unprocessable entity: idempotency key was already used for a different request")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
 	})
 
-	t.Run("Fail to decode payload request", func(t *testing.T) {
-		cmd, err := New(&Config{
-			StorageProvider: mockstorage.NewMockStoreProvider(),
+	t.Run("Fail with too long idempotency key", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withIdempotencyKeys(newIdempotencyKeys(t)))
+
+		_, err := createKeyStore(t, env, strings.Repeat("k", 256), controllerReq)
+		require.EqualError(t, err, "validation failed: idempotency key must be at most 255 characters")
+	})
+}
+
+func TestCommand_CreateKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			CreateKeyID: "key_id",
+		}))
+
+		req, err := json.Marshal(CreateKeyRequest{
+			KeyType: kms.ED25519,
 		})
 		require.NoError(t, err)
-		require.NotNil(t, cmd)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			Request:    nil,
+			Request:    req,
 		})
 		require.NoError(t, err)
 
 		var buf bytes.Buffer
 
 		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "unwrap request: internal error: decode request")
-	})
-
-	t.Run("Fail to get a key store meta data", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+		require.NoError(t, err)
 
-		metrics := NewMockMetricsProvider(ctrl)
-		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).Times(1)
+		var resp CreateKeyResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
+		require.Equal(t, uint64(1), resp.Sequence)
+	})
+
+	t.Run("Concurrent key creation produces strictly increasing sequence numbers", func(t *testing.T) {
+		const n = 50
+
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		km := &mockkms.KeyManager{CreateKeyID: "key_id"}
+
+		creator := NewMockKeyStoreCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(km, nil).AnyTimes()
+
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: []byte(`{"id":"key_store_id"}`)}
+
+		cmd, err := New(&Config{
+			StorageProvider: p,
+			KMS:             km,
+			KeyStoreCreator: creator,
+			MetricsProvider: metrics,
+		})
+		require.NoError(t, err)
+
+		req, err := json.Marshal(CreateKeyRequest{KeyType: kms.ED25519})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", Request: req})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+
+		sequences := make(chan uint64, n)
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				var buf bytes.Buffer
+
+				if e := cmd.CreateKey(&buf, bytes.NewBuffer(wr)); e != nil {
+					t.Errorf("create key: %v", e)
+
+					return
+				}
+
+				var resp CreateKeyResponse
+
+				if e := json.Unmarshal(buf.Bytes(), &resp); e != nil {
+					t.Errorf("unmarshal response: %v", e)
+
+					return
+				}
+
+				sequences <- resp.Sequence
+			}()
+		}
+
+		wg.Wait()
+		close(sequences)
+
+		seen := make(map[uint64]bool, n)
+
+		for seq := range sequences {
+			require.False(t, seen[seq], "duplicate sequence number %d", seq)
+			seen[seq] = true
+		}
+
+		for i := uint64(1); i <= n; i++ {
+			require.True(t, seen[i], "missing sequence number %d", i)
+		}
+	})
+
+	t.Run("Fail to save key store sequence", func(t *testing.T) {
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: []byte(`{"id":"key_store_id"}`)}
+		p.Store.ErrPut = errors.New("put error")
+
+		env := newKeyStoreEnv(t, withStorageProvider(p))
+
+		err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeyRequest{KeyType: kms.ED25519}))
+		require.EqualError(t, err, "increment sequence: save key store metadata: put: put error")
+
+		// the key isn't in the key store metadata, so it must not be kept
+		require.Len(t, env.recorder.keyIDs, 1)
+
+		_, err = env.userKMS.Get(env.recorder.keyIDs[0])
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Success with XOR secret share scheme", func(t *testing.T) {
+		keyStoreData := []byte(`{
+		  "id": "key_store_id",
+		  "controller": "controller",
+		  "secret_share_scheme": "xor"
+		}`)
+
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: keyStoreData}
+
+		ctrl := gomock.NewController(t)
+
+		shamirLockCreator := NewMockShamirSecretLockCreator(ctrl)
+		shamirLockCreator.EXPECT().Create(secretshare.SchemeXOR, gomock.Any()).Return(nil, nil).Times(1)
+
+		shamirProvider := NewMockShamirProvider(ctrl)
+		shamirProvider.EXPECT().FetchSecretShare(gomock.Any()).Return([]byte("secret share"), nil).Times(1)
+
+		cmd := createCmd(t, ctrl,
+			withStorageProvider(p), withKeyManager(&mockkms.KeyManager{CreateKeyID: "key_id"}),
+			withShamirSecretLockCreator(shamirLockCreator), withShamirProvider(shamirProvider))
+
+		req, err := json.Marshal(CreateKeyRequest{
+			KeyType: kms.ED25519,
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID:  "key_store_id",
+			User:        "user",
+			SecretShare: []byte("secret share"),
+			Request:     req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+	})
+
+	t.Run("Success with EDV storage and Shamir secret lock", func(t *testing.T) {
+		keyStoreData := []byte(`{
+		  "id": "key_store_id",
+		  "controller": "controller",
+		  "edv": {
+			"vault_url": "https://edv-host/encrypted-data-vaults/vault-id"
+		  }
+		}`)
+
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: keyStoreData}
+
+		km := &mockkms.KeyManager{
+			ExportPubKeyBytesValue: createRecipientPubKey(t),
+			CreateKeyID:            "key_id",
+		}
+
+		ctrl := gomock.NewController(t)
+
+		shamirLockCreator := NewMockShamirSecretLockCreator(ctrl)
+		shamirLockCreator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(nil, nil).Times(1)
+
+		shamirProvider := NewMockShamirProvider(ctrl)
+		shamirProvider.EXPECT().FetchSecretShare(gomock.Any()).Return([]byte("secret share"), nil).Times(1)
+
+		cmd := createCmd(t, ctrl,
+			withStorageProvider(p), withKeyManager(km), withShamirSecretLockCreator(shamirLockCreator),
+			withShamirProvider(shamirProvider))
+
+		req, err := json.Marshal(CreateKeyRequest{
+			KeyType: kms.ED25519,
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID:  "key_store_id",
+			User:        "user",
+			SecretShare: []byte("secret share"),
+			Request:     req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp CreateKeyResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
+	})
+
+	t.Run("Fail to decode wrapped request", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		err = cmd.CreateKey(nil, bytes.NewBuffer(nil))
+		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
+	})
+
+	t.Run("Fail to decode payload request", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			Request:    nil,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.CreateKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "unwrap request: internal error: decode request")
+	})
+
+	t.Run("Fail to get a key store meta data", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).Times(1)
 
 		cmd, err := New(&Config{
 			StorageProvider: mockstorage.NewMockStoreProvider(),
@@ -720,6 +1262,140 @@ func TestCommand_CreateKey(t *testing.T) {
 	})
 }
 
+func TestCommand_KeyStoreRevisions(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	setup := func(t *testing.T) (*Command, storage.Store, *testutil.FakeClock) {
+		t.Helper()
+
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		km := &mockkms.KeyManager{CreateKeyID: "key_id"}
+
+		creator := NewMockKeyStoreCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(km, nil).AnyTimes()
+
+		p := &newKeyProvider{Provider: mem.NewProvider()}
+
+		store, err := p.OpenStore("keystores")
+		require.NoError(t, err)
+		require.NoError(t, store.Put("key_store_id", []byte(`{"id":"key_store_id"}`)))
+
+		clk := testutil.NewFakeClock(now)
+
+		cmd, err := New(&Config{
+			StorageProvider:    p,
+			KeyStorageProvider: mem.NewProvider(),
+			KMS:                km,
+			KeyStoreCreator:    creator,
+			MetricsProvider:    metrics,
+			Clock:              clk,
+		})
+		require.NoError(t, err)
+
+		return cmd, store, clk
+	}
+
+	createKey := func(t *testing.T, cmd *Command) (uint64, error) {
+		t.Helper()
+
+		req, err := json.Marshal(CreateKeyRequest{KeyType: kms.ED25519})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", Request: req})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		if err = cmd.CreateKey(&buf, bytes.NewBuffer(wr)); err != nil {
+			return 0, err
+		}
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+
+		return resp.Sequence, nil
+	}
+
+	sequence := func(t *testing.T, store storage.Store) uint64 {
+		t.Helper()
+
+		b, err := store.Get("key_store_id")
+		require.NoError(t, err)
+
+		var meta struct {
+			Sequence uint64 `json:"sequence"`
+		}
+
+		require.NoError(t, json.Unmarshal(b, &meta))
+
+		return meta.Sequence
+	}
+
+	t.Run("Updates save consecutive revisions", func(t *testing.T) {
+		cmd, store, _ := setup(t)
+
+		for i := uint64(1); i <= 3; i++ {
+			seq, err := createKey(t, cmd)
+			require.NoError(t, err)
+			require.Equal(t, i, seq)
+		}
+
+		// only the record of the last revision is kept
+		_, err := store.Get("revision_key_store_id_2")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = store.Get("revision_key_store_id_3")
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail with conflict if the revision was saved by another instance", func(t *testing.T) {
+		cmd, store, _ := setup(t)
+
+		require.NoError(t, store.Put("revision_key_store_id_1",
+			[]byte(`{"savedAt":"2022-06-01T12:00:00Z","meta":{"id":"key_store_id","sequence":1}}`)))
+
+		_, err := createKey(t, cmd)
+		require.ErrorIs(t, err, kmserrors.ErrConflict)
+		require.Contains(t, err.Error(), "key store key_store_id was updated concurrently")
+		require.Zero(t, sequence(t, store))
+	})
+
+	t.Run("Roll forward a revision that wasn't saved", func(t *testing.T) {
+		cmd, store, clk := setup(t)
+
+		require.NoError(t, store.Put("revision_key_store_id_1",
+			[]byte(`{"savedAt":"2022-06-01T12:00:00Z","meta":{"id":"key_store_id","sequence":1}}`)))
+
+		clk.Advance(time.Minute)
+
+		_, err := createKey(t, cmd)
+		require.ErrorIs(t, err, kmserrors.ErrConflict)
+		require.Equal(t, uint64(1), sequence(t, store))
+
+		seq, err := createKey(t, cmd)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), seq)
+	})
+
+	t.Run("Deleted key store is not saved again", func(t *testing.T) {
+		cmd, store, clk := setup(t)
+
+		require.NoError(t, store.Put("revision_key_store_id_1",
+			[]byte(`{"savedAt":"2022-06-01T12:00:00Z","deleted":true}`)))
+
+		clk.Advance(time.Minute)
+
+		_, err := createKey(t, cmd)
+		require.ErrorIs(t, err, kmserrors.ErrConflict)
+		require.Zero(t, sequence(t, store))
+	})
+}
+
 func TestCommand_ExportKey(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
@@ -762,585 +1438,834 @@ func TestCommand_ExportKey(t *testing.T) {
 		err = cmd.ExportKey(&buf, bytes.NewBuffer(wr))
 		require.EqualError(t, err, "export public key bytes: export key error")
 	})
-}
 
-func TestCommand_ImportKey(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		tests := []struct {
-			kt kms.KeyType
-		}{
-			{kms.ED25519},
-			{kms.ECDSAP256TypeDER},
-			{kms.ECDSAP384TypeDER},
-			{kms.ECDSAP521TypeDER},
-			{kms.ECDSAP256TypeIEEEP1363},
-			{kms.ECDSAP384TypeIEEEP1363},
-			{kms.ECDSAP521TypeIEEEP1363},
+	t.Run("Success with selected fields", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			ExportPubKeyBytesValue: []byte("public key bytes"),
+			ExportPubKeyTypeValue:  "key_type",
+		}))
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Fields:     []string{"key_type"},
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.ExportKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp map[string]interface{}
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"key_type": "key_type"}, resp)
+	})
+
+	t.Run("Fail with unknown field", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Fields:     []string{"key_type", "unknown"},
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.ExportKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "validate fields: validation failed: unknown field \"unknown\"")
+	})
+
+	t.Run("JWK verifies signature made with the key", func(t *testing.T) {
+		tests := []struct {
+			kt   kms.KeyType
+			alg  string
+			hash func() hash.Hash
+		}{
+			{kms.ED25519Type, "EdDSA", nil},
+			{kms.ECDSAP256TypeDER, "ES256", sha256.New},
+			{kms.ECDSAP256TypeIEEEP1363, "ES256", sha256.New},
+			{kms.ECDSAP384TypeIEEEP1363, "ES384", sha512.New384},
 		}
 
 		for _, tt := range tests {
-			t.Run(fmt.Sprintf("import_%s", tt.kt), func(t *testing.T) {
-				cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
-					ImportPrivateKeyID: "key_id",
-				}))
-
-				pk := createPrivateKey(t, tt.kt)
+			t.Run(string(tt.kt), func(t *testing.T) {
+				localKMS, cmd := createCmdWithLocalKMS(t, 2)
 
-				der, err := x509.MarshalPKCS8PrivateKey(pk)
+				kid, _, err := localKMS.Create(tt.kt)
 				require.NoError(t, err)
 
-				req, err := json.Marshal(ImportKeyRequest{
-					KeyID:   "key_id",
-					KeyType: tt.kt,
-					Key:     der,
-				})
+				message := []byte("test message")
+
+				var signResp SignResponse
+
+				err = cmd.Sign(encodeResponse(t, &signResp), wrapRequest(t, kid, SignRequest{Message: message}))
 				require.NoError(t, err)
 
 				wr, err := json.Marshal(WrappedRequest{
 					KeyStoreID: "key_store_id",
-					Request:    req,
+					KeyID:      kid,
+					Format:     ExportFormatJWK,
 				})
 				require.NoError(t, err)
 
 				var buf bytes.Buffer
 
-				err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
-				require.NoError(t, err)
+				require.NoError(t, cmd.ExportKey(&buf, bytes.NewBuffer(wr)))
 
-				var resp ImportKeyResponse
+				var fields map[string]interface{}
 
-				err = json.Unmarshal(buf.Bytes(), &resp)
-				require.NoError(t, err)
-				require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
-			})
-		}
-	})
+				require.NoError(t, json.Unmarshal(buf.Bytes(), &fields))
+				require.Contains(t, fields, "kty")
+				require.Contains(t, fields, "crv")
+				require.Contains(t, fields, "x")
+				require.NotContains(t, fields, "d")
 
-	t.Run("Fail to parse private key", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t))
+				var key jose.JSONWebKey
 
-		req, err := json.Marshal(ImportKeyRequest{
-			KeyID:   "key_id",
-			KeyType: kms.ED25519,
-			Key:     nil,
-		})
-		require.NoError(t, err)
+				require.NoError(t, key.UnmarshalJSON(buf.Bytes()))
+				require.True(t, key.Valid())
+				require.True(t, key.IsPublic())
+				require.Equal(t, "/key_store_id/keys/"+kid, key.KeyID)
+				require.Equal(t, tt.alg, key.Algorithm)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+				switch pub := key.Key.(type) {
+				case ed25519.PublicKey:
+					require.True(t, ed25519.Verify(pub, message, signResp.Signature))
+				case *ecdsa.PublicKey:
+					h := tt.hash()
+					h.Write(message)
+					digest := h.Sum(nil)
 
-		var buf bytes.Buffer
+					if tt.kt == kms.ECDSAP256TypeDER {
+						require.True(t, ecdsa.VerifyASN1(pub, digest, signResp.Signature))
 
-		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
-		require.Contains(t, err.Error(), "parse private key")
+						return
+					}
+
+					size := len(signResp.Signature) / 2
+					r := new(big.Int).SetBytes(signResp.Signature[:size])
+					s := new(big.Int).SetBytes(signResp.Signature[size:])
+
+					require.True(t, ecdsa.Verify(pub, digest, r, s))
+				default:
+					require.Fail(t, "unexpected key")
+				}
+			})
+		}
 	})
 
-	t.Run("Not supported key type", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t))
+	t.Run("JWK of X25519ECDHKW key", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 1)
 
-		req, err := json.Marshal(ImportKeyRequest{
-			KeyID:   "key_id",
-			KeyType: "invalid",
-		})
+		kid, _, err := localKMS.Create(kms.X25519ECDHKWType)
 		require.NoError(t, err)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			Request:    req,
+			KeyID:      kid,
+			Format:     ExportFormatJWK,
 		})
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		var fields map[string]interface{}
 
-		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "not supported key type: invalid")
+		require.NoError(t, cmd.ExportKey(encodeResponse(t, &fields), bytes.NewBuffer(wr)))
+		require.Equal(t, "OKP", fields["kty"])
+		require.Equal(t, "X25519", fields["crv"])
+		require.NotEmpty(t, fields["x"])
 	})
 
-	t.Run("Fail to import private key", func(t *testing.T) {
+	t.Run("JWK is serialized in canonical form", func(t *testing.T) {
 		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
-			ImportPrivateKeyErr: errors.New("import private key error"),
+			ExportPubKeyBytesValue: bytes.Repeat([]byte{1}, ed25519.PublicKeySize),
+			ExportPubKeyTypeValue:  kms.ED25519Type,
 		}))
 
-		pk := createPrivateKey(t, kms.ED25519)
-
-		der, err := x509.MarshalPKCS8PrivateKey(pk)
-		require.NoError(t, err)
-
-		req, err := json.Marshal(ImportKeyRequest{
-			KeyID:   "key_id",
-			KeyType: kms.ED25519,
-			Key:     der,
-		})
-		require.NoError(t, err)
-
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			Request:    req,
+			KeyID:      "key_id",
+			Format:     ExportFormatJWK,
 		})
 		require.NoError(t, err)
 
 		var buf bytes.Buffer
 
-		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "import private key: import private key error")
+		require.NoError(t, cmd.ExportKey(&buf, bytes.NewBuffer(wr)))
+		require.Equal(t, `{"alg":"EdDSA","crv":"Ed25519","kid":"/key_store_id/keys/key_id","kty":"OKP",`+
+			`"x":"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE"}`+"\n", buf.String())
 	})
-}
 
-func TestCommand_RotateKey(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
+	t.Run("Fail to export key of type not supported in JWK", func(t *testing.T) {
 		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
-			RotateKeyID: "rotate_key_id",
+			ExportPubKeyBytesValue: []byte("public key bytes"),
+			ExportPubKeyTypeValue:  kms.AES256GCMType,
 		}))
 
-		req, err := json.Marshal(RotateKeyRequest{
-			KeyType: kms.ED25519,
-		})
-		require.NoError(t, err)
-
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
 			KeyID:      "key_id",
-			Request:    req,
+			Format:     ExportFormatJWK,
 		})
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		err = cmd.ExportKey(&bytes.Buffer{}, bytes.NewBuffer(wr))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, kmserrors.ErrBadRequest))
+		require.Contains(t, err.Error(), "key of type AES256GCM can't be exported as jwk")
+	})
 
-		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+	t.Run("did:key resolves to the exported key", func(t *testing.T) {
+		for _, kt := range []kms.KeyType{kms.ED25519Type, kms.ECDSAP256TypeDER, kms.ECDSAP384TypeIEEEP1363} {
+			t.Run(string(kt), func(t *testing.T) {
+				localKMS, cmd := createCmdWithLocalKMS(t, 1)
 
-		var resp RotateKeyResponse
+				kid, _, err := localKMS.Create(kt)
+				require.NoError(t, err)
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Contains(t, resp.KeyURL, "rotate_key_id")
-	})
+				pub, _, err := localKMS.ExportPubKeyBytes(kid)
+				require.NoError(t, err)
 
-	t.Run("Fail to decode wrapped request", func(t *testing.T) {
-		cmd, err := New(&Config{
-			StorageProvider: mockstorage.NewMockStoreProvider(),
-		})
-		require.NoError(t, err)
-		require.NotNil(t, cmd)
+				wr, err := json.Marshal(WrappedRequest{
+					KeyStoreID: "key_store_id",
+					KeyID:      kid,
+					Format:     ExportFormatDID,
+				})
+				require.NoError(t, err)
 
-		err = cmd.RotateKey(nil, bytes.NewBuffer(nil))
-		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
+				var resp ExportDIDKeyResponse
+
+				require.NoError(t, cmd.ExportKey(encodeResponse(t, &resp), bytes.NewBuffer(wr)))
+				require.True(t, strings.HasPrefix(resp.DID, "did:key:z"))
+				require.Equal(t, resp.DID+"#"+strings.TrimPrefix(resp.DID, "did:key:"), resp.VerificationMethod)
+
+				key, err := jwksupport.PubKeyBytesToJWK(pub, kt)
+				require.NoError(t, err)
+
+				did, vm, err := fingerprint.CreateDIDKeyByJwk(key)
+				require.NoError(t, err)
+				require.Equal(t, did, resp.DID)
+				require.Equal(t, vm, resp.VerificationMethod)
+
+				if kt == kms.ED25519Type {
+					b, err := fingerprint.PubKeyFromDIDKey(resp.DID)
+					require.NoError(t, err)
+					require.Equal(t, pub, b)
+				}
+			})
+		}
 	})
 
-	t.Run("Fail to get a key store meta data", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+	t.Run("did:key of BLS12381G2 and X25519ECDHKW keys", func(t *testing.T) {
+		for _, kt := range []kms.KeyType{kms.BLS12381G2Type, kms.X25519ECDHKWType} {
+			t.Run(string(kt), func(t *testing.T) {
+				localKMS, cmd := createCmdWithLocalKMS(t, 1)
 
-		metrics := NewMockMetricsProvider(ctrl)
-		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).Times(1)
+				kid, _, err := localKMS.Create(kt)
+				require.NoError(t, err)
 
-		cmd, err := New(&Config{
-			StorageProvider: mockstorage.NewMockStoreProvider(),
-			MetricsProvider: metrics,
-		})
-		require.NoError(t, err)
-		require.NotNil(t, cmd)
+				pub, _, err := localKMS.ExportPubKeyBytes(kid)
+				require.NoError(t, err)
 
-		req, err := json.Marshal(RotateKeyRequest{
-			KeyType: kms.ED25519,
-		})
-		require.NoError(t, err)
+				wr, err := json.Marshal(WrappedRequest{
+					KeyStoreID: "key_store_id",
+					KeyID:      kid,
+					Format:     ExportFormatDID,
+				})
+				require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+				var resp ExportDIDKeyResponse
 
-		var buf bytes.Buffer
+				require.NoError(t, cmd.ExportKey(encodeResponse(t, &resp), bytes.NewBuffer(wr)))
 
-		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "resolve key store: get key store meta: data not found")
+				expected, err := didkey.FromPublicKey(pub, kt)
+				require.NoError(t, err)
+				require.Equal(t, expected.DID, resp.DID)
+				require.Equal(t, expected.VerificationMethod, resp.VerificationMethod)
+			})
+		}
 	})
 
-	t.Run("Fail to rotate a key", func(t *testing.T) {
+	t.Run("Fail to export key of type not supported in did:key", func(t *testing.T) {
 		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
-			RotateKeyErr: errors.New("rotate key error"),
+			ExportPubKeyBytesValue: []byte("public key bytes"),
+			ExportPubKeyTypeValue:  kms.AES256GCMType,
 		}))
 
-		req, err := json.Marshal(RotateKeyRequest{
-			KeyType: kms.ED25519,
-		})
-		require.NoError(t, err)
-
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
 			KeyID:      "key_id",
-			Request:    req,
+			Format:     ExportFormatDID,
 		})
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		err = cmd.ExportKey(&bytes.Buffer{}, bytes.NewBuffer(wr))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, kmserrors.ErrBadRequest))
+		require.Contains(t, err.Error(), "key of type AES256GCM can't be exported as did:key")
+	})
 
-		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "rotate key: rotate key error")
+	t.Run("Fail with invalid format", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+		})
+		require.NoError(t, err)
+
+		tests := []struct {
+			wr  WrappedRequest
+			err string
+		}{
+			{
+				wr:  WrappedRequest{Format: "pem"},
+				err: "validate fields: validation failed: unknown format \"pem\", supported formats: raw, jwk, did",
+			},
+			{
+				wr:  WrappedRequest{Format: ExportFormatJWK, Fields: []string{"public_key"}},
+				err: "validate fields: validation failed: fields can't be selected in jwk format",
+			},
+			{
+				wr:  WrappedRequest{Format: ExportFormatDID, Fields: []string{"key_type"}},
+				err: "validate fields: validation failed: fields can't be selected in did format",
+			},
+		}
+
+		for _, tt := range tests {
+			wr, err := json.Marshal(tt.wr)
+			require.NoError(t, err)
+
+			err = cmd.ExportKey(&bytes.Buffer{}, bytes.NewBuffer(wr))
+			require.EqualError(t, err, tt.err)
+		}
 	})
 }
 
-func TestCommand_Sign(t *testing.T) {
+func TestCommand_GetKeyFingerprint(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			SignValue: []byte("signature"),
-		}))
+		for _, kt := range []kms.KeyType{
+			kms.ED25519Type,
+			kms.ECDSAP256TypeDER,
+			kms.ECDSAP384TypeIEEEP1363,
+			kms.NISTP256ECDHKWType,
+			kms.X25519ECDHKWType,
+			kms.BLS12381G2Type,
+		} {
+			t.Run(string(kt), func(t *testing.T) {
+				localKMS, cmd := createCmdWithLocalKMS(t, 1)
+
+				kid, _, err := localKMS.Create(kt)
+				require.NoError(t, err)
 
-		req, err := json.Marshal(SignRequest{
-			Message: []byte("test message"),
-		})
-		require.NoError(t, err)
+				pub, _, err := localKMS.ExportPubKeyBytes(kid)
+				require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+				wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: kid})
+				require.NoError(t, err)
 
-		var buf bytes.Buffer
+				var resp GetKeyFingerprintResponse
 
-		err = cmd.Sign(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+				require.NoError(t, cmd.GetKeyFingerprint(encodeResponse(t, &resp), bytes.NewBuffer(wr)))
 
-		var resp SignResponse
+				expected, err := didkey.FromPublicKey(pub, kt)
+				require.NoError(t, err)
+				require.Equal(t, expected.DID, resp.DID)
+				require.Equal(t, "did:key:"+resp.Fingerprint, resp.DID)
+				require.NotEmpty(t, resp.JWKThumbprint)
+
+				// go-jose computes thumbprints of NIST curve keys correctly; Ed25519 is checked with the RFC 8037 vector
+				if kt == kms.ECDSAP256TypeDER || kt == kms.ECDSAP384TypeIEEEP1363 || kt == kms.NISTP256ECDHKWType {
+					key, err := jwksupport.PubKeyBytesToJWK(pub, kt)
+					require.NoError(t, err)
+
+					thumbprint, err := key.Thumbprint(gocrypto.SHA256)
+					require.NoError(t, err)
+					require.Equal(t, base64.RawURLEncoding.EncodeToString(thumbprint), resp.JWKThumbprint)
+				}
+			})
+		}
+	})
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+	t.Run("RFC 8037 thumbprint", func(t *testing.T) {
+		pub, err := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
 		require.NoError(t, err)
-		require.Equal(t, []byte("signature"), resp.Signature)
-	})
 
-	t.Run("Fail to sign", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			SignErr: errors.New("sign error"),
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			ExportPubKeyBytesValue: pub,
+			ExportPubKeyTypeValue:  kms.ED25519Type,
 		}))
 
-		req, err := json.Marshal(SignRequest{
-			Message: []byte("test message"),
-		})
-		require.NoError(t, err)
-
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: "key_id"})
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		var resp GetKeyFingerprintResponse
 
-		err = cmd.Sign(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "sign: sign error")
+		require.NoError(t, cmd.GetKeyFingerprint(encodeResponse(t, &resp), bytes.NewBuffer(wr)))
+		require.Equal(t, "z6MktwupdmLXVVqTzCw4i46r4uGyosGXRnR3XjN4Zq7oMMsw", resp.Fingerprint)
+		require.Equal(t, "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", resp.JWKThumbprint)
 	})
-}
-
-func TestCommand_Verify(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
-		require.NoError(t, err)
-
-		cmd := createCmd(t, gomock.NewController(t),
-			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
-			withCrypto(&mockcrypto.Crypto{}),
-		)
 
-		req, err := json.Marshal(VerifyRequest{
-			Signature: []byte("signature"),
-			Message:   []byte("test message"),
-		})
-		require.NoError(t, err)
+	t.Run("Fail with symmetric key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			ExportPubKeyBytesValue: []byte("public key bytes"),
+			ExportPubKeyTypeValue:  kms.AES256GCMType,
+		}))
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: "key_id"})
 		require.NoError(t, err)
 
-		err = cmd.Verify(nil, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+		err = cmd.GetKeyFingerprint(&bytes.Buffer{}, bytes.NewBuffer(wr))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, kmserrors.ErrBadRequest))
+		require.Contains(t, err.Error(), "key of type AES256GCM has no fingerprint")
 	})
 
-	t.Run("Fail to get public key from handle", func(t *testing.T) {
-		badKH, err := keyset.NewHandle(aead.KMSEnvelopeAEADKeyTemplate("badUrl", nil))
+	t.Run("Fail to export public key bytes", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			ExportPubKeyBytesErr: errors.New("export key error"),
+		}))
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: "key_id"})
 		require.NoError(t, err)
 
-		cmd := createCmd(t, gomock.NewController(t),
-			withKeyManager(&mockkms.KeyManager{GetKeyValue: badKH}),
-			withCrypto(&mockcrypto.Crypto{}),
-		)
+		err = cmd.GetKeyFingerprint(&bytes.Buffer{}, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "export public key bytes: export key error")
+	})
 
-		req, err := json.Marshal(VerifyRequest{
-			Signature: []byte("signature"),
-			Message:   []byte("test message"),
-		})
-		require.NoError(t, err)
+	t.Run("Fail with unknown key", func(t *testing.T) {
+		_, cmd := createCmdWithLocalKMS(t, 1)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: "unknown"})
 		require.NoError(t, err)
 
-		err = cmd.Verify(nil, bytes.NewBuffer(wr))
+		err = cmd.GetKeyFingerprint(&bytes.Buffer{}, bytes.NewBuffer(wr))
 		require.Error(t, err)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
 	})
+}
 
-	t.Run("Fail to verify", func(t *testing.T) {
-		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
-		require.NoError(t, err)
+func TestCommand_ImportKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tests := []struct {
+			kt kms.KeyType
+		}{
+			{kms.ED25519},
+			{kms.ECDSAP256TypeDER},
+			{kms.ECDSAP384TypeDER},
+			{kms.ECDSAP521TypeDER},
+			{kms.ECDSAP256TypeIEEEP1363},
+			{kms.ECDSAP384TypeIEEEP1363},
+			{kms.ECDSAP521TypeIEEEP1363},
+		}
 
-		cmd := createCmd(t, gomock.NewController(t),
-			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
-			withCrypto(&mockcrypto.Crypto{VerifyErr: errors.New("verify error")}),
-		)
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("import_%s", tt.kt), func(t *testing.T) {
+				cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+					ImportPrivateKeyID: "key_id",
+				}))
 
-		req, err := json.Marshal(VerifyRequest{
-			Signature: []byte("signature"),
-			Message:   []byte("test message"),
-		})
-		require.NoError(t, err)
+				pk := createPrivateKey(t, tt.kt)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+				der, err := x509.MarshalPKCS8PrivateKey(pk)
+				require.NoError(t, err)
 
-		err = cmd.Verify(nil, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "verify: verify error")
+				req, err := json.Marshal(ImportKeyRequest{
+					KeyID:   "key_id",
+					KeyType: tt.kt,
+					Key:     der,
+				})
+				require.NoError(t, err)
+
+				wr, err := json.Marshal(WrappedRequest{
+					KeyStoreID: "key_store_id",
+					Request:    req,
+				})
+				require.NoError(t, err)
+
+				var buf bytes.Buffer
+
+				err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
+				require.NoError(t, err)
+
+				var resp ImportKeyResponse
+
+				err = json.Unmarshal(buf.Bytes(), &resp)
+				require.NoError(t, err)
+				require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
+			})
+		}
 	})
-}
 
-func TestCommand_Encrypt(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			EncryptValue:      []byte("ciphertext"),
-			EncryptNonceValue: []byte("nonce"),
-		}))
+	t.Run("Fail to parse private key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t))
 
-		req, err := json.Marshal(EncryptRequest{
-			Message: []byte("test message"),
+		req, err := json.Marshal(ImportKeyRequest{
+			KeyID:   "key_id",
+			KeyType: kms.ED25519,
+			Key:     nil,
 		})
 		require.NoError(t, err)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
 			Request:    req,
 		})
 		require.NoError(t, err)
 
 		var buf bytes.Buffer
 
-		err = cmd.Encrypt(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
-
-		var resp EncryptResponse
-
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Equal(t, []byte("ciphertext"), resp.Ciphertext)
-		require.Equal(t, []byte("nonce"), resp.Nonce)
+		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
+		require.Contains(t, err.Error(), "parse private key")
 	})
 
-	t.Run("Fail to encrypt", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			EncryptErr: errors.New("encrypt error"),
-		}))
+	t.Run("Not supported key type", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t))
 
-		req, err := json.Marshal(EncryptRequest{
-			Message: []byte("test message"),
+		req, err := json.Marshal(ImportKeyRequest{
+			KeyID:   "key_id",
+			KeyType: "invalid",
 		})
 		require.NoError(t, err)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
 			Request:    req,
 		})
 		require.NoError(t, err)
 
 		var buf bytes.Buffer
 
-		err = cmd.Encrypt(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "encrypt: encrypt error")
+		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
+		require.True(t, errors.Is(err, kmserrors.ErrUnprocessableEntity))
+		require.EqualError(t, err, "unprocessable entity: not supported key type: invalid, importable key types: "+
+			"ED25519, ECDSAP256DER, ECDSAP384DER, ECDSAP521DER, ECDSAP256IEEEP1363, ECDSAP384IEEEP1363, "+
+			"ECDSAP521IEEEP1363, BLS12381G2, ECDSASecp256k1DER, ECDSASecp256k1IEEEP1363")
 	})
-}
 
-func TestCommand_Decrypt(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			DecryptValue: []byte("plaintext"),
-		}))
+	t.Run("BLS12381G2 key generated by another implementation", func(t *testing.T) {
+		// a key pair generated with MATTR bbs-signatures (base58)
+		privateKey := base58.Decode("5D6Pa8dSwApdnfg7EZR8WnGfvLDCZPZGsZ5Y1ELL9VDj")
+		publicKey := base58.Decode("oqpWYKaZD9M1Kbe94BVXpr8WTdFBNZyKv48cziTiQUeuhm7sBhCABMyYG4kcMrseC68YTFFgyhiNeBKjzdKk9" +
+			"MiRWuLv5H4FFujQsQK2KTAtzU8qTBiZqBHMmnLF4PL7Ytu")
+		messages := [][]byte{[]byte("message1"), []byte("message2"), []byte("message3")}
 
-		req, err := json.Marshal(DecryptRequest{
-			Ciphertext:     []byte("ciphertext"),
-			AssociatedData: []byte("ad"),
-			Nonce:          []byte("nonce"),
-		})
-		require.NoError(t, err)
+		for _, req := range []ImportKeyRequest{
+			{KeyType: kms.BLS12381G2Type, Key: privateKey, ExpectedPublicKey: publicKey},
+			{KeyType: kms.BLS12381G2Type, KeyMultibase: "z5D6Pa8dSwApdnfg7EZR8WnGfvLDCZPZGsZ5Y1ELL9VDj"},
+		} {
+			_, cmd := createCmdWithLocalKMS(t, 6)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+			var importResp ImportKeyResponse
 
-		var buf bytes.Buffer
+			require.NoError(t, cmd.ImportKey(encodeResponse(t, &importResp), wrapRequest(t, "", req)))
+			require.Equal(t, publicKey, importResp.PublicKey)
 
-		err = cmd.Decrypt(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+			keyID := importResp.KeyURL[strings.LastIndex(importResp.KeyURL, "/")+1:]
 
-		var resp DecryptResponse
+			var getResp GetKeyResponse
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Equal(t, []byte("plaintext"), resp.Plaintext)
-	})
+			require.NoError(t, cmd.GetKey(encodeResponse(t, &getResp), wrapRequest(t, keyID, nil)))
+			require.Equal(t, KeyOriginImported, getResp.Origin)
+			require.Equal(t, publicKey, getResp.PublicKey)
 
-	t.Run("Fail to decrypt", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			DecryptErr: errors.New("decrypt error"),
-		}))
+			// signatures of the imported key verify with the other implementation's public key
+			var signResp SignMultiResponse
 
-		req, err := json.Marshal(DecryptRequest{
-			Ciphertext:     []byte("ciphertext"),
-			AssociatedData: []byte("ad"),
-			Nonce:          []byte("nonce"),
-		})
-		require.NoError(t, err)
+			require.NoError(t, cmd.SignMulti(encodeResponse(t, &signResp),
+				wrapRequest(t, keyID, SignMultiRequest{Messages: messages})))
+			require.NoError(t, bbs12381g2pub.New().Verify(messages, signResp.Signature, publicKey))
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+			// and signatures of the private key made outside the key store verify with the imported key
+			signature, err := bbs12381g2pub.New().Sign(messages, privateKey)
+			require.NoError(t, err)
 
-		var buf bytes.Buffer
+			require.NoError(t, cmd.VerifyMulti(nil,
+				wrapRequest(t, keyID, VerifyMultiRequest{Signature: signature, Messages: messages})))
 
-		err = cmd.Decrypt(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "decrypt: decrypt error")
+			var proofResp DeriveProofResponse
+
+			require.NoError(t, cmd.DeriveProof(encodeResponse(t, &proofResp), wrapRequest(t, keyID, DeriveProofRequest{
+				Messages:        messages,
+				Signature:       signature,
+				Nonce:           []byte("nonce"),
+				RevealedIndexes: []int{0, 2},
+			})))
+			require.NoError(t, bbs12381g2pub.New().VerifyProof([][]byte{messages[0], messages[2]}, proofResp.Proof,
+				[]byte("nonce"), publicKey))
+
+			require.NoError(t, cmd.VerifyProof(nil, wrapRequest(t, keyID, VerifyProofRequest{
+				Proof:    proofResp.Proof,
+				Messages: [][]byte{messages[0], messages[2]},
+				Nonce:    []byte("nonce"),
+			})))
+		}
 	})
-}
 
-func TestCommand_ComputeMAC(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			ComputeMACValue: []byte("mac"),
-		}))
+	t.Run("Invalid BLS12381G2 key", func(t *testing.T) {
+		privateKey := base58.Decode("5D6Pa8dSwApdnfg7EZR8WnGfvLDCZPZGsZ5Y1ELL9VDj")
 
-		req, err := json.Marshal(ComputeMACRequest{
-			Data: []byte("data"),
-		})
-		require.NoError(t, err)
+		// the group order of BLS12-381
+		order, ok := new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+		require.True(t, ok)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+		tests := []struct {
+			name string
+			req  ImportKeyRequest
+			err  string
+		}{
+			{
+				name: "zero scalar",
+				req:  ImportKeyRequest{KeyType: kms.BLS12381G2Type, Key: make([]byte, 32)},
+				err:  "BLS12381G2 private key is out of range",
+			},
+			{
+				name: "scalar equal to group order",
+				req:  ImportKeyRequest{KeyType: kms.BLS12381G2Type, Key: order.FillBytes(make([]byte, 32))},
+				err:  "BLS12381G2 private key is out of range",
+			},
+			{
+				name: "wrong size",
+				req:  ImportKeyRequest{KeyType: kms.BLS12381G2Type, Key: make([]byte, 48)},
+				err:  "invalid BLS12381G2 private key size 48",
+			},
+			{
+				name: "public key mismatch",
+				req: ImportKeyRequest{
+					KeyType:           kms.BLS12381G2Type,
+					Key:               privateKey,
+					ExpectedPublicKey: make([]byte, 96),
+				},
+				err: "public key of the private key does not match expected public key",
+			},
+			{
+				name: "invalid multibase",
+				req:  ImportKeyRequest{KeyType: kms.BLS12381G2Type, KeyMultibase: "!invalid"},
+				err:  "decode key_multibase",
+			},
+			{
+				name: "multibase and key",
+				req:  ImportKeyRequest{KeyType: kms.BLS12381G2Type, Key: privateKey, KeyMultibase: "z5D6Pa8dSw"},
+				err:  "key_multibase is mutually exclusive with key and jwk",
+			},
+		}
 
-		var buf bytes.Buffer
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				km := &importingKeyManager{}
+				cmd := createCmd(t, gomock.NewController(t), withKeyManager(km))
+
+				err := cmd.ImportKey(nil, wrapRequest(t, "", tt.req))
+				require.Error(t, err)
+				require.True(t, errors.Is(err, kmserrors.ErrValidation))
+				require.Contains(t, err.Error(), tt.err)
+				require.Nil(t, km.imported)
+			})
+		}
+	})
 
-		err = cmd.ComputeMAC(&buf, bytes.NewBuffer(wr))
+	t.Run("Expected public key", func(t *testing.T) {
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
 		require.NoError(t, err)
 
-		var resp ComputeMACResponse
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+		der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
 		require.NoError(t, err)
-		require.Equal(t, []byte("mac"), resp.MAC)
+
+		for _, req := range []ImportKeyRequest{
+			{KeyType: kms.ED25519Type, Key: edKey, ExpectedPublicKey: edKey.Public().(ed25519.PublicKey)},
+			{KeyType: kms.ECDSAP256TypeDER, JWK: marshalJWK(t, ecKey), ExpectedPublicKey: der},
+			{
+				KeyType:           kms.ECDSAP256TypeIEEEP1363,
+				Key:               ecKey.D.FillBytes(make([]byte, 32)),
+				ExpectedPublicKey: elliptic.Marshal(elliptic.P256(), ecKey.X, ecKey.Y),
+			},
+		} {
+			km := &importingKeyManager{}
+			cmd := createCmd(t, gomock.NewController(t), withKeyManager(km))
+
+			require.NoError(t, cmd.ImportKey(&bytes.Buffer{}, wrapRequest(t, "", req)))
+			require.NotNil(t, km.imported)
+		}
 	})
 
-	t.Run("Fail to compute MAC", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			ComputeMACErr: errors.New("compute mac error"),
-		}))
+	t.Run("Import key in other formats", func(t *testing.T) {
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
 
-		req, err := json.Marshal(ComputeMACRequest{
-			Data: []byte("data"),
-		})
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+		tests := []struct {
+			name string
+			req  ImportKeyRequest
+		}{
+			{"raw Ed25519 seed", ImportKeyRequest{KeyType: kms.ED25519Type, Key: edKey.Seed()}},
+			{"raw Ed25519 key", ImportKeyRequest{KeyType: kms.ED25519Type, Key: edKey}},
+			{"Ed25519 JWK", ImportKeyRequest{KeyType: kms.ED25519Type, JWK: marshalJWK(t, edKey)}},
+			{"raw P-256 key", ImportKeyRequest{KeyType: kms.ECDSAP256TypeIEEEP1363, Key: ecKey.D.FillBytes(make([]byte, 32))}},
+			{"P-256 JWK", ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, JWK: marshalJWK(t, ecKey)}},
+		}
 
-		var buf bytes.Buffer
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				km := &importingKeyManager{}
+				cmd := createCmd(t, gomock.NewController(t), withKeyManager(km))
 
-		err = cmd.ComputeMAC(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "compute mac: compute mac error")
+				var buf bytes.Buffer
+
+				err := cmd.ImportKey(&buf, wrapRequest(t, "", tt.req))
+				require.NoError(t, err)
+
+				switch k := km.imported.(type) {
+				case ed25519.PrivateKey:
+					require.Equal(t, edKey, k)
+				case *ecdsa.PrivateKey:
+					require.True(t, ecKey.Equal(k))
+				default:
+					require.Fail(t, "unexpected key")
+				}
+
+				var resp ImportKeyResponse
+
+				require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+				require.Equal(t, "/key_store_id/keys/key_id", resp.KeyURL)
+				require.Equal(t, []byte("public key"), resp.PublicKey)
+			})
+		}
 	})
-}
 
-func TestCommand_VerifyMAC(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{}))
+	t.Run("Key does not match key type", func(t *testing.T) {
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
 
-		req, err := json.Marshal(VerifyMACRequest{
-			Data: []byte("data"),
-			MAC:  []byte("mac"),
-		})
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 		require.NoError(t, err)
 
-		err = cmd.VerifyMAC(nil, bytes.NewBuffer(wr))
+		p384DER, err := x509.MarshalPKCS8PrivateKey(p384Key)
 		require.NoError(t, err)
+
+		tampered := append(ed25519.PrivateKey{}, edKey...)
+		tampered[ed25519.SeedSize] ^= 0xff
+
+		mismatched := &ecdsa.PrivateKey{PublicKey: p256Key.PublicKey, D: new(big.Int).Add(p256Key.D, big.NewInt(1))}
+
+		tests := []struct {
+			name string
+			req  ImportKeyRequest
+			err  string
+		}{
+			{
+				name: "PKCS #8 key of other curve",
+				req:  ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, Key: p384DER},
+				err:  "key does not match key type ECDSAP256DER",
+			},
+			{
+				name: "Ed25519 key declared as ECDSA",
+				req:  ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, JWK: marshalJWK(t, edKey)},
+				err:  "key does not match key type ECDSAP256DER",
+			},
+			{
+				name: "raw key of wrong size",
+				req:  ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, Key: make([]byte, 48)},
+				err:  "key is neither a PKCS #8 nor a raw ECDSAP256DER private key",
+			},
+			{
+				name: "zero ECDSA scalar",
+				req:  ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, Key: make([]byte, 32)},
+				err:  "invalid ECDSA private key",
+			},
+			{
+				name: "Ed25519 key with other public key",
+				req:  ImportKeyRequest{KeyType: kms.ED25519Type, Key: tampered},
+				err:  "key does not match key type ED25519",
+			},
+			{
+				name: "ECDSA JWK with other public key",
+				req:  ImportKeyRequest{KeyType: kms.ECDSAP256TypeDER, JWK: marshalJWK(t, mismatched)},
+				err:  "key does not match key type ECDSAP256DER",
+			},
+			{
+				name: "public JWK",
+				req:  ImportKeyRequest{KeyType: kms.ED25519Type, JWK: marshalJWK(t, edKey.Public())},
+				err:  "jwk is not an Ed25519 or ECDSA private key",
+			},
+			{
+				name: "invalid JWK",
+				req:  ImportKeyRequest{KeyType: kms.ED25519Type, JWK: []byte(`{"kty":"unknown"}`)},
+				err:  "unmarshal jwk",
+			},
+			{
+				name: "key and JWK",
+				req:  ImportKeyRequest{KeyType: kms.ED25519Type, Key: edKey, JWK: marshalJWK(t, edKey.Public())},
+				err:  "key and jwk are mutually exclusive",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				km := &importingKeyManager{}
+				cmd := createCmd(t, gomock.NewController(t), withKeyManager(km))
+
+				var buf bytes.Buffer
+
+				err := cmd.ImportKey(&buf, wrapRequest(t, "", tt.req))
+				require.Error(t, err)
+				require.True(t, errors.Is(err, kmserrors.ErrValidation))
+				require.Contains(t, err.Error(), tt.err)
+				require.Nil(t, km.imported)
+			})
+		}
 	})
 
-	t.Run("Fail to verify MAC", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			VerifyMACErr: errors.New("verify mac error"),
+	t.Run("Fail to import private key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			ImportPrivateKeyErr: errors.New("import private key error"),
 		}))
 
-		req, err := json.Marshal(VerifyMACRequest{
-			Data: []byte("data"),
-			MAC:  []byte("mac"),
+		pk := createPrivateKey(t, kms.ED25519)
+
+		der, err := x509.MarshalPKCS8PrivateKey(pk)
+		require.NoError(t, err)
+
+		req, err := json.Marshal(ImportKeyRequest{
+			KeyID:   "key_id",
+			KeyType: kms.ED25519,
+			Key:     der,
 		})
 		require.NoError(t, err)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
 			Request:    req,
 		})
 		require.NoError(t, err)
 
-		err = cmd.VerifyMAC(nil, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "verify mac: verify mac error")
+		var buf bytes.Buffer
+
+		err = cmd.ImportKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "import private key: import private key error")
 	})
 }
 
-func TestCommand_SignMulti(t *testing.T) {
+func TestCommand_RotateKey(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			BBSSignValue: []byte("signature"),
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			RotateKeyID:            "rotate_key_id",
+			ExportPubKeyBytesValue: []byte("public key bytes"),
 		}))
 
-		req, err := json.Marshal(SignMultiRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
+		req, err := json.Marshal(RotateKeyRequest{
+			KeyType: kms.ED25519,
 		})
 		require.NoError(t, err)
 
@@ -1353,154 +2278,115 @@ func TestCommand_SignMulti(t *testing.T) {
 
 		var buf bytes.Buffer
 
-		err = cmd.SignMulti(&buf, bytes.NewBuffer(wr))
+		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
 		require.NoError(t, err)
 
-		var resp SignMultiResponse
+		var resp RotateKeyResponse
 
 		err = json.Unmarshal(buf.Bytes(), &resp)
 		require.NoError(t, err)
-		require.Equal(t, []byte("signature"), resp.Signature)
+		require.Contains(t, resp.KeyURL, "rotate_key_id")
+		require.Equal(t, []byte("public key bytes"), resp.PublicKey)
+		require.Equal(t, uint64(1), resp.Sequence)
 	})
 
-	t.Run("Fail to sign multi", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			BBSSignErr: errors.New("sign error"),
-		}))
-
-		req, err := json.Marshal(SignMultiRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-		})
-		require.NoError(t, err)
+	t.Run("Signature made before rotation is verified with rotated key", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 4)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		kid, oldPub, err := localKMS.CreateAndExportPubKeyBytes(kms.ED25519Type)
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		message := []byte("test message")
 
-		err = cmd.SignMulti(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "sign multi: sign error")
-	})
-}
+		var signResp SignResponse
 
-func TestCommand_VerifyMulti(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		err = cmd.Sign(encodeResponse(t, &signResp), wrapRequest(t, kid, SignRequest{Message: message}))
 		require.NoError(t, err)
 
-		cmd := createCmd(t, gomock.NewController(t),
-			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
-			withCrypto(&mockcrypto.Crypto{}),
-		)
+		var rotateResp RotateKeyResponse
 
-		req, err := json.Marshal(VerifyMultiRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-			Signature: []byte("signature"),
-		})
+		err = cmd.RotateKey(encodeResponse(t, &rotateResp), wrapRequest(t, kid, RotateKeyRequest{
+			KeyType: kms.ED25519Type,
+		}))
 		require.NoError(t, err)
+		require.NotEmpty(t, rotateResp.PublicKey)
+		require.NotEqual(t, oldPub, rotateResp.PublicKey)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+		newKID := rotateResp.KeyURL[strings.LastIndex(rotateResp.KeyURL, "/")+1:]
 
-		err = cmd.VerifyMulti(nil, bytes.NewBuffer(wr))
+		err = cmd.Verify(nil, wrapRequest(t, newKID, VerifyRequest{
+			Signature: signResp.Signature,
+			Message:   message,
+		}))
 		require.NoError(t, err)
+
+		err = cmd.Verify(nil, wrapRequest(t, kid, VerifyRequest{
+			Signature: signResp.Signature,
+			Message:   message,
+		}))
+		require.Error(t, err)
 	})
 
-	t.Run("Fail to verify a signature", func(t *testing.T) {
-		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
-		require.NoError(t, err)
+	t.Run("Fail to export public key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			RotateKeyID:          "rotate_key_id",
+			ExportPubKeyBytesErr: errors.New("export key error"),
+		}))
 
-		cmd := createCmd(t, gomock.NewController(t),
-			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
-			withCrypto(&mockcrypto.Crypto{
-				BBSVerifyErr: errors.New("verify error"),
-			}),
-		)
+		var buf bytes.Buffer
 
-		req, err := json.Marshal(VerifyMultiRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-			Signature: []byte("signature"),
-		})
-		require.NoError(t, err)
+		err := cmd.RotateKey(&buf, wrapRequest(t, "key_id", RotateKeyRequest{KeyType: kms.ED25519}))
+		require.EqualError(t, err, "export public key bytes: export key error")
+	})
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
+	t.Run("Fail to decode wrapped request", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
 		})
 		require.NoError(t, err)
+		require.NotNil(t, cmd)
 
-		err = cmd.VerifyMulti(nil, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "verify multi: verify error")
+		err = cmd.RotateKey(nil, bytes.NewBuffer(nil))
+		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
 	})
-}
 
-func TestCommand_DeriveProof(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			DeriveProofValue: []byte("proof"),
-		}))
+	t.Run("Fail to get a key store meta data", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
 
-		req, err := json.Marshal(DeriveProofRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-			Signature:       []byte("signature"),
-			Nonce:           []byte("nonce"),
-			RevealedIndexes: []int{1},
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).Times(1)
+
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+			MetricsProvider: metrics,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, cmd)
+
+		req, err := json.Marshal(RotateKeyRequest{
+			KeyType: kms.ED25519,
 		})
 		require.NoError(t, err)
 
 		wr, err := json.Marshal(WrappedRequest{
 			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
 			Request:    req,
 		})
 		require.NoError(t, err)
 
 		var buf bytes.Buffer
 
-		err = cmd.DeriveProof(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
-
-		var resp DeriveProofResponse
-
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Equal(t, []byte("proof"), resp.Proof)
+		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "resolve key store: get key store meta: data not found")
 	})
 
-	t.Run("Fail to derive proof", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			DeriveProofError: errors.New("derive proof error"),
+	t.Run("Fail to rotate a key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			RotateKeyErr: errors.New("rotate key error"),
 		}))
 
-		req, err := json.Marshal(DeriveProofRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-			Signature:       []byte("signature"),
-			Nonce:           []byte("nonce"),
-			RevealedIndexes: []int{1},
+		req, err := json.Marshal(RotateKeyRequest{
+			KeyType: kms.ED25519,
 		})
 		require.NoError(t, err)
 
@@ -1513,401 +2399,9280 @@ func TestCommand_DeriveProof(t *testing.T) {
 
 		var buf bytes.Buffer
 
-		err = cmd.DeriveProof(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "derive proof: derive proof error")
+		err = cmd.RotateKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "rotate key: rotate key error")
 	})
 }
 
-func TestCommand_VerifyProof(t *testing.T) {
+func TestCommand_DeleteKey(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{}))
+		localKMS, cmd := createCmdWithLocalKMS(t, 3)
 
-		req, err := json.Marshal(VerifyProofRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
-			},
-			Proof: []byte("proof"),
-			Nonce: []byte("nonce"),
-		})
+		kid, _, err := localKMS.Create(kms.ED25519Type)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
+		err = cmd.DeleteKey(nil, wrapRequest(t, kid, nil))
+		require.NoError(t, err)
+
+		// key material is kept for the retention period
+		_, err = localKMS.Get(kid)
+		require.NoError(t, err)
+
+		err = cmd.Sign(&bytes.Buffer{}, wrapRequest(t, kid, SignRequest{Message: []byte("test message")}))
+		require.EqualError(t, err, "get key: not found: key "+kid)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = cmd.DeleteKey(nil, wrapRequest(t, kid, nil))
+		require.EqualError(t, err, "get key: not found: key "+kid)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to decode wrapped request", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
 		})
 		require.NoError(t, err)
 
-		err = cmd.VerifyProof(nil, bytes.NewBuffer(wr))
+		err = cmd.DeleteKey(nil, bytes.NewBuffer(nil))
+		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
+	})
+
+	t.Run("Fail to get a key store meta data", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).Times(1)
+
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+			MetricsProvider: metrics,
+		})
 		require.NoError(t, err)
+
+		err = cmd.DeleteKey(nil, wrapRequest(t, "key_id", nil))
+		require.EqualError(t, err, "resolve key store: get key store meta: data not found")
 	})
 
-	t.Run("Fail to verify proof", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			VerifyProofErr: errors.New("verify proof error"),
+	t.Run("Fail to get a key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{
+			GetKeyErr: errors.New("get key error"),
 		}))
 
-		req, err := json.Marshal(VerifyProofRequest{
-			Messages: [][]byte{
-				[]byte("test message 1"),
-				[]byte("test message 2"),
+		err := cmd.DeleteKey(nil, wrapRequest(t, "key_id", nil))
+		require.EqualError(t, err, "get key: get key error")
+		require.Equal(t, http.StatusInternalServerError, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_RestoreKey(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	newEnv := func(t *testing.T) (*keyStoreEnv, *testutil.FakeClock, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		clk := testutil.NewFakeClock(now)
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics), withClock(clk, 0), withKeyRetentionPeriod(time.Hour))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, clk, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID, alias string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, Alias: alias}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	listKeys := func(t *testing.T, env *keyStoreEnv, keyStoreID string) []string {
+		t.Helper()
+
+		var resp ListKeysResponse
+
+		require.NoError(t, env.cmd.ListKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", nil)))
+
+		keyURLs := make([]string, 0, len(resp.Keys))
+
+		for _, k := range resp.Keys {
+			keyURLs = append(keyURLs, k.KeyURL)
+		}
+
+		return keyURLs
+	}
+
+	t.Run("Deleted key is hidden until restored", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, "signing-key")
+		keyURL := "https://kms.example.com/v1/keystores/" + keyStoreID + "/keys/" + keyID
+
+		var signResp SignResponse
+
+		err := env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+		require.Empty(t, listKeys(t, env, keyStoreID))
+
+		err = env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, SignRequest{Message: []byte("test")}))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.GetKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.ExportKey(nil, wrapKeyStoreRequest(t, keyStoreID, "signing-key", nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.RotateKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			RotateKeyRequest{KeyType: kms.ED25519Type}))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.Validate(ActionSign, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test")}))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		clk.Advance(59 * time.Minute)
+
+		var restoreResp RestoreKeyResponse
+
+		err = env.cmd.RestoreKey(encodeResponse(t, &restoreResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, keyURL, restoreResp.KeyURL)
+		require.Equal(t, []string{keyURL}, listKeys(t, env, keyStoreID))
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, "signing-key",
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.RestoreKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.EqualError(t, err, "restore key: conflict: key "+keyID+" is not deleted")
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Restored key doesn't take back an alias used by another key", func(t *testing.T) {
+		env, _, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, "signing-key")
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+
+		otherKeyID := createKey(t, env, keyStoreID, "signing-key")
+
+		require.NoError(t, env.cmd.RestoreKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+
+		var getResp GetKeyResponse
+
+		err := env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Empty(t, getResp.Alias)
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, otherKeyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, "signing-key", getResp.Alias)
+	})
+
+	t.Run("Key is purged after the retention period", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+		keyIDs := []string{createKey(t, env, keyStoreID, ""), createKey(t, env, keyStoreID, "")}
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyIDs[0], nil)))
+
+		clk.Advance(30 * time.Minute)
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyIDs[1], nil)))
+
+		purged, err := env.cmd.PurgeDeletedKeys()
+		require.NoError(t, err)
+		require.Zero(t, purged)
+
+		clk.Advance(30 * time.Minute)
+
+		err = env.cmd.RestoreKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyIDs[0], nil))
+		require.EqualError(t, err, "restore key: not found: retention period of deleted key "+keyIDs[0]+" ended")
+
+		purged, err = env.cmd.PurgeDeletedKeys()
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		_, err = env.userKMS.Get(keyIDs[0])
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		_, err = env.userKMS.Get(keyIDs[1])
+		require.NoError(t, err)
+
+		err = env.cmd.RestoreKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyIDs[0], nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		clk.Advance(30 * time.Minute)
+
+		purged, err = env.cmd.PurgeDeletedKeys()
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		_, err = env.userKMS.Get(keyIDs[1])
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.Nil(t, meta["key_ids"])
+
+		// key stores without deleted keys are no longer queried
+		purged, err = env.cmd.PurgeDeletedKeys()
+		require.NoError(t, err)
+		require.Zero(t, purged)
+	})
+
+	t.Run("Key purger purges in the background", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, "")
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+
+		clk.Advance(time.Hour)
+
+		purger := NewKeyPurger(env.cmd, time.Millisecond)
+		purger.Start()
+		defer purger.Stop()
+
+		require.Eventually(t, func() bool {
+			_, err := env.userKMS.Get(keyID)
+
+			return errors.Is(err, storage.ErrDataNotFound)
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Fail to restore a key of a missing key store", func(t *testing.T) {
+		env, _, _ := newEnv(t)
+
+		err := env.cmd.RestoreKey(nil, wrapKeyStoreRequest(t, "missing", "key_id", nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to restore a missing key", func(t *testing.T) {
+		env, _, keyStoreID := newEnv(t)
+
+		err := env.cmd.RestoreKey(nil, wrapKeyStoreRequest(t, keyStoreID, "key_id", nil))
+		require.EqualError(t, err, "restore key: not found: key key_id")
+	})
+
+	t.Run("Fail to decode wrapped request", func(t *testing.T) {
+		env, _, _ := newEnv(t)
+
+		err := env.cmd.RestoreKey(nil, bytes.NewBuffer(nil))
+		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
+	})
+}
+
+func TestCommand_DeleteKeyStore(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		var createResp CreateKeyStoreResponse
+
+		req, err := json.Marshal(CreateKeyStoreRequest{Controller: "did:example:controller"})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{Request: req})
+		require.NoError(t, err)
+
+		require.NoError(t, env.cmd.CreateKeyStore(encodeResponse(t, &createResp), bytes.NewBuffer(wr)))
+
+		keyStoreID := strings.TrimPrefix(createResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		keyIDs := make([]string, 2)
+
+		for i := range keyIDs {
+			var createKeyResp CreateKeyResponse
+
+			err = env.cmd.CreateKey(encodeResponse(t, &createKeyResp),
+				wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type}))
+			require.NoError(t, err)
+
+			keyIDs[i] = createKeyResp.KeyURL[strings.LastIndex(createKeyResp.KeyURL, "/")+1:]
+		}
+
+		var rotateResp RotateKeyResponse
+
+		err = env.cmd.RotateKey(encodeResponse(t, &rotateResp),
+			wrapKeyStoreRequest(t, keyStoreID, keyIDs[1], RotateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		keyIDs[1] = rotateResp.KeyURL[strings.LastIndex(rotateResp.KeyURL, "/")+1:]
+
+		env.zcap.EXPECT().Delete(createResp.KeyStoreURL).Return(nil).Times(1)
+
+		err = env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, keyStoreID, "did:example:controller"))
+		require.NoError(t, err)
+
+		for _, kid := range keyIDs {
+			_, err = env.userKMS.Get(kid)
+			require.ErrorIs(t, err, storage.ErrDataNotFound)
+		}
+
+		_, err = env.serverKMS.Get(meta["main_key_id"].(string))
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		err = env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, keyStoreID, "did:example:controller"))
+		require.EqualError(t, err, "get key store: not found: key store "+keyStoreID)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Success with EDV storage keeps keys in the vault", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		userKeyID, _, err := env.userKMS.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		recipientKeyID, _, err := env.serverKMS.Create(kms.NISTP256ECDHKW)
+		require.NoError(t, err)
+
+		macKeyID, _, err := env.serverKMS.Create(kms.HMACSHA256Tag256)
+		require.NoError(t, err)
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "edv_key_store_id",
+			"controller": "did:example:controller",
+			"edv": map[string]interface{}{
+				"vault_url":        "https://edv.example.com/encrypted-data-vaults/vault-id",
+				"recipient_key_id": recipientKeyID,
+				"mac_key_id":       macKeyID,
 			},
-			Proof: []byte("proof"),
-			Nonce: []byte("nonce"),
+			"key_ids": []string{userKeyID},
 		})
+
+		env.zcap.EXPECT().Delete("https://kms.example.com/v1/keystores/edv_key_store_id").Return(nil).Times(1)
+
+		err = env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, "edv_key_store_id", "did:example:controller"))
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
+		_, err = env.userKMS.Get(userKeyID)
+		require.NoError(t, err)
+
+		for _, kid := range []string{recipientKeyID, macKeyID} {
+			_, err = env.serverKMS.Get(kid)
+			require.ErrorIs(t, err, storage.ErrDataNotFound)
+		}
+
+		_, err = env.getKeyStore("edv_key_store_id")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Fail if caller is not the controller", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		for _, caller := range []string{"", "did:example:delegatee"} {
+			err := env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, "key_store_id", caller))
+			require.EqualError(t, err, "forbidden: only the controller can delete the key store")
+			require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+		}
+
+		_, err := env.getKeyStore("key_store_id")
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to delete root capability", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		env.zcap.EXPECT().Delete(gomock.Any()).Return(errors.New("delete error")).Times(1)
+
+		err := env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, "key_store_id", "did:example:controller"))
+		require.EqualError(t, err, "delete root capability: delete error")
+
+		_, err = env.getKeyStore("key_store_id")
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to decode wrapped request", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
 		})
 		require.NoError(t, err)
 
-		err = cmd.VerifyProof(nil, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "verify proof: verify proof error")
+		err = cmd.DeleteKeyStore(nil, bytes.NewBuffer(nil))
+		require.EqualError(t, err, "unwrap request: internal error: decode wrapped request")
 	})
 }
 
-func TestCommand_Easy(t *testing.T) {
+func TestCommand_ListKeyStores(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv, controller string) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: controller}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
 	t.Run("Success", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+		env := newKeyStoreEnv(t)
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().Easy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-			Return([]byte("ciphertext"), nil).Times(1)
+		ids := make([]string, 3)
+
+		for i := range ids {
+			ids[i] = createKeyStore(t, env, "did:example:controller")
+		}
+
+		sort.Strings(ids)
+
+		otherID := createKeyStore(t, env, "did:example:other")
+
+		// key stores saved before the controller tag are not listed until they are used
+		env.putKeyStore(t, map[string]interface{}{"id": "untagged", "controller": "did:example:controller"})
+
+		var resp ListKeyStoresResponse
+
+		err := env.cmd.ListKeyStores(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller", PageSize: 2}))
+		require.NoError(t, err)
+		require.Len(t, resp.KeyStores, 2)
+		require.Equal(t, ids[0], resp.KeyStores[0].ID)
+		require.Equal(t, ids[1], resp.KeyStores[1].ID)
+		require.Equal(t, StorageTypeLocal, resp.KeyStores[0].StorageType)
+		require.False(t, resp.KeyStores[0].CreatedAt.IsZero())
+		require.Equal(t, ids[1], resp.NextPageToken)
+
+		var nextResp ListKeyStoresResponse
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &nextResp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller", PageSize: 2, PageToken: resp.NextPageToken}))
+		require.NoError(t, err)
+		require.Len(t, nextResp.KeyStores, 1)
+		require.Equal(t, ids[2], nextResp.KeyStores[0].ID)
+		require.Empty(t, nextResp.NextPageToken)
+
+		var otherResp ListKeyStoresResponse
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &otherResp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:other"}))
+		require.NoError(t, err)
+		require.Len(t, otherResp.KeyStores, 1)
+		require.Equal(t, otherID, otherResp.KeyStores[0].ID)
+	})
+
+	t.Run("Key store is listed under its new controller", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		id := createKeyStore(t, env, "did:example:controller")
+
+		env.zcap.EXPECT().Supersede(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+		err := env.cmd.UpdateKeyStore(encodeResponse(t, &UpdateKeyStoreResponse{}), wrapCallerKeyStoreRequest(t, id,
+			"did:example:controller", UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.NoError(t, err)
+
+		var oldResp, newResp ListKeyStoresResponse
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &oldResp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+		require.Empty(t, oldResp.KeyStores)
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &newResp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:new-controller"}))
+		require.NoError(t, err)
+		require.Len(t, newResp.KeyStores, 1)
+	})
+
+	t.Run("Key store saved before the controller tag is listed once it is used", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "untagged", "controller": "did:example:controller"})
+
+		list := func(t *testing.T) []KeyStoreInfo {
+			t.Helper()
+
+			var resp ListKeyStoresResponse
+
+			err := env.cmd.ListKeyStores(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+				ListKeyStoresRequest{Controller: "did:example:controller"}))
+			require.NoError(t, err)
+
+			return resp.KeyStores
+		}
+
+		require.Empty(t, list(t))
+
+		var resp GetKeyStoreResponse
+
+		err := env.cmd.GetKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "untagged", "", nil))
+		require.NoError(t, err)
+		require.Zero(t, resp.Sequence)
+
+		keyStores := list(t)
+		require.Len(t, keyStores, 1)
+		require.Equal(t, "untagged", keyStores[0].ID)
+		require.Equal(t, SchemaVersion, keyStores[0].SchemaVersion)
+	})
+
+	t.Run("Key store being deleted is not saved", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "deleted", "controller": "did:example:controller"})
+		require.NoError(t, env.keyStores.Put("revision_deleted_1", []byte(`{"deleted":true}`)))
+
+		err := env.cmd.GetKeyStore(encodeResponse(t, &GetKeyStoreResponse{}),
+			wrapKeyStoreRequest(t, "deleted", "", nil))
+		require.NoError(t, err)
+
+		var resp ListKeyStoresResponse
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+		require.Empty(t, resp.KeyStores)
+	})
+
+	t.Run("Fail with invalid request", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.ListKeyStores(nil, wrapKeyStoreRequest(t, "", "", ListKeyStoresRequest{}))
+		require.EqualError(t, err, "validate request: validation failed: controller must be non-empty")
+
+		err = env.cmd.ListKeyStores(nil, wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller", PageSize: MaxKeyStorePageSize + 1}))
+		require.EqualError(t, err, "validate request: validation failed: page size must be between 1 and 1000")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_SetKeyStoreOverrides(t *testing.T) {
+	// newEnv returns an environment with a cache that records TTLs of resolved key stores
+	newEnv := func(t *testing.T, maxTTL time.Duration, opts ...configOption) (*keyStoreEnv, *[]time.Duration) {
+		t.Helper()
+
+		var ttls []time.Duration
+
+		cache := NewMockCacheProvider(gomock.NewController(t))
+		cache.EXPECT().Wrap(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(p storage.Provider, keyStoreID string, ttl time.Duration) storage.Provider {
+				require.NotEmpty(t, keyStoreID) // cached items are namespaced per key store
+
+				ttls = append(ttls, ttl)
+
+				return p
+			}).AnyTimes()
+
+		env := newKeyStoreEnv(t, append([]configOption{func(c *Config) {
+			c.CacheProvider = cache
+			c.KeyStoreCacheTTL = 10 * time.Minute
+			c.MaxKeyStoreCacheTTL = maxTTL
+		}}, opts...)...)
+
+		return env, &ttls
+	}
+
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	// cacheTTL returns the TTL the key store is cached with when it is resolved
+	cacheTTL := func(t *testing.T, env *keyStoreEnv, ttls *[]time.Duration, keyStoreID string) time.Duration {
+		t.Helper()
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &CreateKeyResponse{}),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+		require.NotEmpty(t, *ttls)
+
+		return (*ttls)[len(*ttls)-1]
+	}
+
+	t.Run("Override takes precedence over the server default", func(t *testing.T) {
+		env, ttls := newEnv(t, 2*time.Hour)
+
+		id := createKeyStore(t, env)
+		otherID := createKeyStore(t, env)
+
+		require.Equal(t, 10*time.Minute, cacheTTL(t, env, ttls, id))
+
+		var resp SetKeyStoreOverridesResponse
+
+		err := env.cmd.SetKeyStoreOverrides(encodeResponse(t, &resp), wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{CacheTTL: "1h"}))
+		require.NoError(t, err)
+		require.Equal(t, &KeyStoreOverrides{CacheTTL: "1h0m0s"}, resp.Overrides)
+		require.Equal(t, uint64(2), resp.Sequence)
+
+		require.Equal(t, time.Hour, cacheTTL(t, env, ttls, id))
+		require.Equal(t, 10*time.Minute, cacheTTL(t, env, ttls, otherID))
+
+		// omitted settings are reset to the server defaults
+		resp = SetKeyStoreOverridesResponse{}
+
+		err = env.cmd.SetKeyStoreOverrides(encodeResponse(t, &resp), wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{}))
+		require.NoError(t, err)
+		require.Nil(t, resp.Overrides)
+
+		require.Equal(t, 10*time.Minute, cacheTTL(t, env, ttls, id))
+	})
+
+	t.Run("Override is bounded by the maximum", func(t *testing.T) {
+		env, ttls := newEnv(t, 2*time.Hour)
+
+		id := createKeyStore(t, env)
+
+		err := env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{CacheTTL: "3h"}))
+		require.EqualError(t, err, "validate request: validation failed: cache TTL must not exceed 2h0m0s")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		require.NoError(t, env.cmd.SetKeyStoreOverrides(encodeResponse(t, &SetKeyStoreOverridesResponse{}),
+			wrapKeyStoreRequest(t, id, "", SetKeyStoreOverridesRequest{CacheTTL: "2h"})))
+		require.Equal(t, 2*time.Hour, cacheTTL(t, env, ttls, id))
+
+		// the maximum is lowered after the override was set
+		lowered, loweredTTLs := newEnv(t, time.Hour, withStorageProvider(env.serverStorage))
+		require.Equal(t, time.Hour, cacheTTL(t, lowered, loweredTTLs, id))
+
+		// overrides are disabled
+		disabled, disabledTTLs := newEnv(t, 0, withStorageProvider(env.serverStorage))
+		require.Equal(t, 10*time.Minute, cacheTTL(t, disabled, disabledTTLs, id))
+
+		err = disabled.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{CacheTTL: "1m"}))
+		require.EqualError(t, err, "validate request: validation failed: cache TTL overrides are disabled")
+	})
+
+	t.Run("Key stores with overrides are listed", func(t *testing.T) {
+		env, _ := newEnv(t, time.Hour)
+
+		id := createKeyStore(t, env)
+		createKeyStore(t, env)
+
+		require.NoError(t, env.cmd.SetKeyStoreOverrides(encodeResponse(t, &SetKeyStoreOverridesResponse{}),
+			wrapKeyStoreRequest(t, id, "", SetKeyStoreOverridesRequest{CacheTTL: "30m"})))
+
+		var resp ListKeyStoresResponse
+
+		err := env.cmd.ListKeyStores(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Overrides: true}))
+		require.NoError(t, err)
+		require.Len(t, resp.KeyStores, 1)
+		require.Equal(t, id, resp.KeyStores[0].ID)
+		require.Equal(t, &KeyStoreOverrides{CacheTTL: "30m0s"}, resp.KeyStores[0].Overrides)
+
+		// key stores whose overrides were reset are not listed
+		require.NoError(t, env.cmd.SetKeyStoreOverrides(encodeResponse(t, &SetKeyStoreOverridesResponse{}),
+			wrapKeyStoreRequest(t, id, "", SetKeyStoreOverridesRequest{})))
+
+		resp = ListKeyStoresResponse{}
+
+		err = env.cmd.ListKeyStores(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Overrides: true}))
+		require.NoError(t, err)
+		require.Empty(t, resp.KeyStores)
+
+		err = env.cmd.ListKeyStores(nil, wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Controller: "did:example:controller", Overrides: true}))
+		require.EqualError(t, err, "validate request: validation failed: controller can't be combined with overrides")
+	})
+
+	withLimits := func(rateLimit, maxRateLimit float64, concurrencyLimit, maxConcurrencyLimit int) configOption {
+		return func(c *Config) {
+			c.KeyStoreRateLimit, c.MaxKeyStoreRateLimit = rateLimit, maxRateLimit
+			c.KeyStoreConcurrencyLimit, c.MaxKeyStoreConcurrencyLimit = concurrencyLimit, maxConcurrencyLimit
+		}
+	}
+
+	t.Run("Limit overrides take precedence over the server defaults", func(t *testing.T) {
+		env, _ := newEnv(t, 0, withLimits(10, 100, 4, 16))
+
+		id := createKeyStore(t, env)
+		otherID := createKeyStore(t, env)
+
+		rateLimit, concurrencyLimit, err := env.cmd.KeyStoreLimits(id)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, rateLimit)
+		require.Equal(t, 4, concurrencyLimit)
+
+		var resp SetKeyStoreOverridesResponse
+
+		err = env.cmd.SetKeyStoreOverrides(encodeResponse(t, &resp), wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{RateLimit: 50, ConcurrencyLimit: 8}))
+		require.NoError(t, err)
+		require.Equal(t, &KeyStoreOverrides{RateLimit: 50, ConcurrencyLimit: 8}, resp.Overrides)
+
+		rateLimit, concurrencyLimit, err = env.cmd.KeyStoreLimits(id)
+		require.NoError(t, err)
+		require.Equal(t, 50.0, rateLimit)
+		require.Equal(t, 8, concurrencyLimit)
+
+		rateLimit, concurrencyLimit, err = env.cmd.KeyStoreLimits(otherID)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, rateLimit)
+		require.Equal(t, 4, concurrencyLimit)
+
+		// a single limit can be overridden
+		require.NoError(t, env.cmd.SetKeyStoreOverrides(encodeResponse(t, &SetKeyStoreOverridesResponse{}),
+			wrapKeyStoreRequest(t, id, "", SetKeyStoreOverridesRequest{ConcurrencyLimit: 1})))
+
+		rateLimit, concurrencyLimit, err = env.cmd.KeyStoreLimits(id)
+		require.NoError(t, err)
+		require.Equal(t, 10.0, rateLimit)
+		require.Equal(t, 1, concurrencyLimit)
+
+		var listResp ListKeyStoresResponse
+
+		require.NoError(t, env.cmd.ListKeyStores(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, "", "",
+			ListKeyStoresRequest{Overrides: true})))
+		require.Len(t, listResp.KeyStores, 1)
+		require.Equal(t, &KeyStoreOverrides{ConcurrencyLimit: 1}, listResp.KeyStores[0].Overrides)
+
+		_, _, err = env.cmd.KeyStoreLimits("unknown")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Limit overrides are bounded by the maximums", func(t *testing.T) {
+		env, _ := newEnv(t, 0, withLimits(0, 100, 0, 16))
+
+		id := createKeyStore(t, env)
+
+		err := env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{RateLimit: 100.5}))
+		require.EqualError(t, err, "validate request: validation failed: rate limit must not exceed 100")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{ConcurrencyLimit: 17}))
+		require.EqualError(t, err, "validate request: validation failed: concurrency limit must not exceed 16")
+
+		err = env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{RateLimit: -1}))
+		require.EqualError(t, err, "validate request: validation failed: rate limit must be positive")
+
+		err = env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{ConcurrencyLimit: -1}))
+		require.EqualError(t, err, "validate request: validation failed: concurrency limit must be positive")
+
+		require.NoError(t, env.cmd.SetKeyStoreOverrides(encodeResponse(t, &SetKeyStoreOverridesResponse{}),
+			wrapKeyStoreRequest(t, id, "", SetKeyStoreOverridesRequest{RateLimit: 100, ConcurrencyLimit: 16})))
+
+		// the maximums are lowered after the overrides were set
+		lowered, _ := newEnv(t, 0, withStorageProvider(env.serverStorage), withLimits(0, 20, 0, 2))
+
+		rateLimit, concurrencyLimit, err := lowered.cmd.KeyStoreLimits(id)
+		require.NoError(t, err)
+		require.Equal(t, 20.0, rateLimit)
+		require.Equal(t, 2, concurrencyLimit)
+
+		// overrides are disabled, the server defaults are used
+		disabled, _ := newEnv(t, 0, withStorageProvider(env.serverStorage), withLimits(5, 0, 3, 0))
+
+		rateLimit, concurrencyLimit, err = disabled.cmd.KeyStoreLimits(id)
+		require.NoError(t, err)
+		require.Equal(t, 5.0, rateLimit)
+		require.Equal(t, 3, concurrencyLimit)
+
+		err = disabled.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{RateLimit: 1}))
+		require.EqualError(t, err, "validate request: validation failed: rate limit overrides are disabled")
+
+		err = disabled.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+			SetKeyStoreOverridesRequest{ConcurrencyLimit: 1}))
+		require.EqualError(t, err, "validate request: validation failed: concurrency limit overrides are disabled")
+	})
+
+	t.Run("Fail with invalid cache TTL", func(t *testing.T) {
+		env, _ := newEnv(t, time.Hour)
+
+		id := createKeyStore(t, env)
+
+		for _, ttl := range []string{"forever", "-1m", "0s"} {
+			err := env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, id, "",
+				SetKeyStoreOverridesRequest{CacheTTL: ttl}))
+			require.EqualError(t, err, "validate request: validation failed: cache TTL must be a positive duration")
+		}
+	})
+
+	t.Run("Fail with unknown key store", func(t *testing.T) {
+		env, _ := newEnv(t, time.Hour)
+
+		err := env.cmd.SetKeyStoreOverrides(nil, wrapKeyStoreRequest(t, "unknown", "",
+			SetKeyStoreOverridesRequest{CacheTTL: "30m"}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_UpdateKeyStore(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withClock(testutil.NewFakeClock(now), 0), withControllerRotationGracePeriod(time.Hour))
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "key_store_id",
+			"controller": "did:example:controller",
+			"sequence":   1,
+		})
+
+		env.zcap.EXPECT().Supersede("https://kms.example.com/v1/keystores/key_store_id", now.Add(time.Hour)).
+			Return(nil).Times(1)
+
+		var resp UpdateKeyStoreResponse
+
+		err := env.cmd.UpdateKeyStore(encodeResponse(t, &resp), wrapCallerKeyStoreRequest(t, "key_store_id",
+			"did:example:controller", UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.NoError(t, err)
+		require.Equal(t, "https://kms.example.com/v1/keystores/key_store_id", resp.KeyStoreURL)
+		require.NotEmpty(t, resp.Capability)
+		require.Equal(t, uint64(2), resp.Sequence)
+
+		var getResp GetKeyStoreResponse
+
+		err = env.cmd.GetKeyStore(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, "key_store_id", "", nil))
+		require.NoError(t, err)
+		require.Equal(t, "did:example:new-controller", getResp.Controller)
+
+		err = env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, "key_store_id", "did:example:controller",
+			UpdateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.EqualError(t, err, "forbidden: only the controller can update the key store")
+	})
+
+	t.Run("Success without ZCAPs", func(t *testing.T) {
+		env := newKeyStoreEnv(t, func(c *Config) { c.EnableZCAPs = false })
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		var resp UpdateKeyStoreResponse
+
+		err := env.cmd.UpdateKeyStore(encodeResponse(t, &resp), wrapCallerKeyStoreRequest(t, "key_store_id", "",
+			UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.NoError(t, err)
+		require.Empty(t, resp.Capability)
+		require.Equal(t, uint64(1), resp.Sequence)
+	})
+
+	t.Run("Fail if caller is not the controller", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		for _, caller := range []string{"", "did:example:delegatee"} {
+			err := env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, "key_store_id", caller,
+				UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+			require.EqualError(t, err, "forbidden: only the controller can update the key store")
+			require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+		}
+	})
+
+	t.Run("Fail with empty controller", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, "key_store_id", "did:example:controller",
+			UpdateKeyStoreRequest{}))
+		require.EqualError(t, err, "validate request: validation failed: controller must be non-empty")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Key store not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, "key_store_id", "did:example:controller",
+			UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.EqualError(t, err, "get key store: not found: key store key_store_id")
+	})
+
+	t.Run("Fail to supersede root capability", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		env.zcap.EXPECT().Supersede(gomock.Any(), gomock.Any()).Return(errors.New("supersede error")).Times(1)
+
+		err := env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, "key_store_id", "did:example:controller",
+			UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.EqualError(t, err, "supersede root capability: supersede error")
+
+		meta, err := env.getKeyStore("key_store_id")
+		require.NoError(t, err)
+		require.Equal(t, "did:example:controller", meta["controller"])
+	})
+}
+
+func TestCommand_NoZCAPKeyStores(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv, user string) (*CreateKeyStoreResponse, error) {
+		t.Helper()
+
+		req, err := json.Marshal(CreateKeyStoreRequest{Controller: "did:example:controller", DisableZCAP: true})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{User: user, Request: req})
+		require.NoError(t, err)
+
+		var resp CreateKeyStoreResponse
+
+		return &resp, env.cmd.CreateKeyStore(encodeResponse(t, &resp), bytes.NewBuffer(wr))
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withNoZCAPKeyStores())
+
+		resp, err := createKeyStore(t, env, "subject")
+		require.NoError(t, err)
+		require.Empty(t, resp.Capability)
+
+		keyStoreID := strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, true, meta["no_zcap"])
+		require.Equal(t, "subject", meta["creator"])
+
+		creator, err := env.cmd.NoZCAPKeyStoreCreator(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, "subject", creator)
+
+		err = env.cmd.UpdateKeyStore(nil, wrapCallerKeyStoreRequest(t, keyStoreID, "subject",
+			UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+		require.EqualError(t, err, "unprocessable entity: key store without ZCAPs has no capability to re-issue "+
+			"for a new controller")
+
+		err = env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, keyStoreID, "did:example:controller"))
+		require.EqualError(t, err, "forbidden: only the controller can delete the key store")
+
+		// no root capability to delete
+		err = env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, keyStoreID, "subject"))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail if key stores without ZCAPs are disabled", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		_, err := createKeyStore(t, env, "subject")
+		require.EqualError(t, err, "forbidden: key stores without ZCAPs are disabled on the server")
+		require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "no_zcap": true, "creator": "subject"})
+
+		_, err = env.cmd.NoZCAPKeyStoreCreator("key_store_id")
+		require.EqualError(t, err, "forbidden: key stores without ZCAPs are disabled on the server")
+	})
+
+	t.Run("Fail without OAuth subject", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withNoZCAPKeyStores())
+
+		_, err := createKeyStore(t, env, "")
+		require.EqualError(t, err, "validation failed: key stores without ZCAPs require an OAuth subject")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to get creator of key store with ZCAPs", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withNoZCAPKeyStores())
+
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		for _, keyStoreID := range []string{"key_store_id", "unknown"} {
+			_, err := env.cmd.NoZCAPKeyStoreCreator(keyStoreID)
+			require.EqualError(t, err, "forbidden: key store "+keyStoreID+" doesn't exist or requires ZCAPs")
+			require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+		}
+	})
+}
+
+func TestCommand_GetKeyStore(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		var createResp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &createResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		keyStoreID := strings.TrimPrefix(createResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		err = env.cmd.CreateKey(encodeResponse(t, &CreateKeyResponse{}),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		var resp GetKeyStoreResponse
+
+		err = env.cmd.GetKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Equal(t, "did:example:controller", resp.Controller)
+		require.False(t, resp.CreatedAt.IsZero())
+		require.Equal(t, StorageTypeLocal, resp.StorageType)
+		require.Equal(t, 1, resp.KeyCount)
+		require.Equal(t, uint64(1), resp.Sequence)
+	})
+
+	t.Run("Success with EDV storage", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "edv_key_store_id",
+			"controller": "did:example:controller",
+			"edv": map[string]interface{}{
+				"vault_url": "https://edv.example.com/encrypted-data-vaults/vault-id",
+			},
+		})
+
+		var resp GetKeyStoreResponse
+
+		err := env.cmd.GetKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "edv_key_store_id", "", nil))
+		require.NoError(t, err)
+		require.Equal(t, StorageTypeEDV, resp.StorageType)
+		require.Equal(t, 0, resp.KeyCount)
+	})
+
+	t.Run("Key store not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.GetKeyStore(nil, wrapKeyStoreRequest(t, "unknown", "", nil))
+		require.EqualError(t, err, "get key store: not found: key store unknown")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_CreateKeys(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		keyTypes := []kms.KeyType{
+			kms.ED25519Type, kms.ECDSAP256TypeDER, kms.X25519ECDHKWType, kms.BLS12381G2Type, kms.HMACSHA256Tag256Type,
+		}
+
+		req := CreateKeysRequest{}
+
+		for _, kt := range keyTypes {
+			req.Keys = append(req.Keys, CreateKeyRequest{KeyType: kt})
+		}
+
+		var resp CreateKeysResponse
+
+		err := env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", req))
+		require.NoError(t, err)
+		require.Len(t, resp.Keys, len(keyTypes))
+		require.Equal(t, uint64(1), resp.Sequence)
+
+		for i, key := range resp.Keys {
+			require.Equal(t, fmt.Sprintf("https://kms.example.com/v1/keystores/%s/keys/%s", keyStoreID,
+				env.recorder.keyIDs[i]), key.KeyURL)
+
+			if keyTypes[i] == kms.HMACSHA256Tag256Type {
+				require.Empty(t, key.PublicKey)
+			} else {
+				require.NotEmpty(t, key.PublicKey)
+			}
+		}
+
+		var getResp GetKeyStoreResponse
+
+		err = env.cmd.GetKeyStore(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Equal(t, len(keyTypes), getResp.KeyCount)
+	})
+
+	t.Run("Failed key deletes created keys", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		err := env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{
+			Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type}, {KeyType: kms.AES256GCMType}, {KeyType: "unknown"}},
+		}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create key 2")
+		require.Len(t, env.recorder.keyIDs, 2)
+
+		for _, kid := range env.recorder.keyIDs {
+			_, err = env.userKMS.Get(kid)
+			require.ErrorIs(t, err, storage.ErrDataNotFound)
+		}
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.NotContains(t, meta, "key_ids")
+		require.EqualValues(t, 0, meta["sequence"])
+	})
+
+	t.Run("Invalid number of keys", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		err := env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{}))
+		require.EqualError(t, err, "validation failed: number of keys must be from 1 to 20")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{
+			Keys: make([]CreateKeyRequest, 21),
+		}))
+		require.EqualError(t, err, "validation failed: number of keys must be from 1 to 20")
+	})
+}
+
+func TestCommand_GetKey(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, kt kms.KeyType) (string, []byte) {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kt}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:], resp.PublicKey
+	}
+
+	t.Run("Success with asymmetric key", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyID, pub := createKey(t, env, keyStoreID, kms.ECDSAP256TypeDER)
+
+		var resp GetKeyResponse
+
+		err := env.cmd.GetKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, string(kms.ECDSAP256TypeDER), resp.KeyType)
+		require.NotNil(t, resp.CreatedAt)
+		require.True(t, resp.Exportable)
+		require.Equal(t, pub, resp.PublicKey)
+	})
+
+	t.Run("Success with symmetric key", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyID, _ := createKey(t, env, keyStoreID, kms.AES256GCMType)
+
+		var buf bytes.Buffer
+
+		err := env.cmd.GetKey(&buf, wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+
+		var resp map[string]interface{}
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+		require.Equal(t, string(kms.AES256GCMType), resp["key_type"])
+		require.Equal(t, false, resp["exportable"])
+		require.NotContains(t, resp, "public_key")
+	})
+
+	t.Run("Success with key created before key tracking", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		keyID, _, err := env.userKMS.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		var resp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, string(kms.ED25519Type), resp.KeyType)
+		require.Nil(t, resp.CreatedAt)
+		require.True(t, resp.Exportable)
+		require.NotEmpty(t, resp.PublicKey)
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		err := env.cmd.GetKey(nil, wrapKeyStoreRequest(t, keyStoreID, "unknown", nil))
+		require.EqualError(t, err, "get key: not found: key unknown")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_KeyAliases(t *testing.T) {
+	newEnv := func(t *testing.T) (*keyStoreEnv, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID, alias string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type, Alias: alias}))
+		require.NoError(t, err)
+
+		return resp.KeyURL
+	}
+
+	t.Run("Sign, verify and export by alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyURL := createKey(t, env, keyStoreID, "signing-key")
+		keyID := keyURL[strings.LastIndex(keyURL, "/")+1:]
+
+		var signResp SignResponse
+
+		err := env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, "signing-key",
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, "signing-key",
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		var exportResp ExportKeyResponse
+
+		err = env.cmd.ExportKey(encodeResponse(t, &exportResp), wrapKeyStoreRequest(t, keyStoreID, "signing-key", nil))
+		require.NoError(t, err)
+		require.NotEmpty(t, exportResp.PublicKey)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, "signing-key", getResp.Alias)
+	})
+
+	t.Run("Conflicting alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyURL := createKey(t, env, keyStoreID, "signing-key")
+
+		err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, Alias: "signing-key"}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+
+		var conflictErr *AliasConflictError
+
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, keyURL, conflictErr.KeyURL)
+		require.Len(t, env.recorder.keyIDs, 1, "key must not be created")
+
+		err = env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{
+			Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type}, {KeyType: kms.ED25519Type, Alias: "signing-key"}},
+		}))
+		require.ErrorAs(t, err, &conflictErr)
+		require.Len(t, env.recorder.keyIDs, 1, "keys must not be created")
+
+		// an alias can't be another key's ID either; key IDs are random and may start with '_' or '-', which an
+		// alias can't
+		for strings.ContainsAny(env.recorder.keyIDs[len(env.recorder.keyIDs)-1][:1], "_-") {
+			keyURL = createKey(t, env, keyStoreID, "")
+		}
+
+		err = env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, Alias: env.recorder.keyIDs[len(env.recorder.keyIDs)-1]}))
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, keyURL, conflictErr.KeyURL)
+	})
+
+	t.Run("Duplicate alias in a batch", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		err := env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{
+			Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type, Alias: "key"}, {KeyType: kms.ED25519Type, Alias: "key"}},
+		}))
+		require.EqualError(t, err, `validation failed: key 1: duplicate alias "key"`)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Batch with aliases", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		var resp CreateKeysResponse
+
+		err := env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", CreateKeysRequest{
+			Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type, Alias: "key-1"}, {KeyType: kms.ED25519Type}},
+		}))
+		require.NoError(t, err)
+
+		var exportResp ExportKeyResponse
+
+		err = env.cmd.ExportKey(encodeResponse(t, &exportResp), wrapKeyStoreRequest(t, keyStoreID, "key-1", nil))
+		require.NoError(t, err)
+		require.Equal(t, resp.Keys[0].PublicKey, exportResp.PublicKey)
+	})
+
+	t.Run("Invalid alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		for _, alias := range []string{"-key", "key/1", "key 1", strings.Repeat("a", 65)} {
+			err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+				CreateKeyRequest{KeyType: kms.ED25519Type, Alias: alias}))
+			require.Error(t, err, alias)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err), alias)
+		}
+	})
+
+	t.Run("Rotated key keeps alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyURL := createKey(t, env, keyStoreID, "signing-key")
+
+		var rotateResp RotateKeyResponse
+
+		err := env.cmd.RotateKey(encodeResponse(t, &rotateResp), wrapKeyStoreRequest(t, keyStoreID,
+			keyURL[strings.LastIndex(keyURL, "/")+1:], RotateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		var exportResp ExportKeyResponse
+
+		err = env.cmd.ExportKey(encodeResponse(t, &exportResp), wrapKeyStoreRequest(t, keyStoreID, "signing-key", nil))
+		require.NoError(t, err)
+		require.Equal(t, rotateResp.PublicKey, exportResp.PublicKey)
+	})
+
+	t.Run("Deleted key releases alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyURL := createKey(t, env, keyStoreID, "signing-key")
+
+		err := env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyURL[strings.LastIndex(keyURL, "/")+1:], nil))
+		require.NoError(t, err)
+
+		err = env.cmd.ExportKey(nil, wrapKeyStoreRequest(t, keyStoreID, "signing-key", nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		createKey(t, env, keyStoreID, "signing-key")
+	})
+}
+
+func TestCommand_UpdateKey(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID, alias string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type, Alias: alias}))
+		require.NoError(t, err)
+
+		return resp.KeyURL
+	}
+
+	t.Run("Rename alias", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyURL := createKey(t, env, keyStoreID, "old-name")
+
+		var resp UpdateKeyResponse
+
+		err := env.cmd.UpdateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "old-name",
+			UpdateKeyRequest{Alias: "new-name"}))
+		require.NoError(t, err)
+		require.Equal(t, keyURL, resp.KeyURL)
+		require.Equal(t, "new-name", resp.Alias)
+		require.Equal(t, uint64(2), resp.Sequence)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, "new-name", nil))
+		require.NoError(t, err)
+		require.Equal(t, "new-name", getResp.Alias)
+
+		err = env.cmd.GetKey(nil, wrapKeyStoreRequest(t, keyStoreID, "old-name", nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Remove alias", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyURL := createKey(t, env, keyStoreID, "name")
+
+		var resp UpdateKeyResponse
+
+		err := env.cmd.UpdateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "name",
+			UpdateKeyRequest{}))
+		require.NoError(t, err)
+		require.Empty(t, resp.Alias)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.NotContains(t, meta, "aliases")
+
+		// the alias is free for another key
+		require.NotEqual(t, keyURL, createKey(t, env, keyStoreID, "name"))
+	})
+
+	t.Run("Alias used by another key", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyURL := createKey(t, env, keyStoreID, "name")
+		createKey(t, env, keyStoreID, "other-name")
+
+		err := env.cmd.UpdateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "other-name",
+			UpdateKeyRequest{Alias: "name"}))
+		require.EqualError(t, err, fmt.Sprintf(`increment sequence: conflict: alias "name" is used by key %s`, keyURL))
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Invalid alias", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.UpdateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			UpdateKeyRequest{Alias: "key/1"}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+
+		err := env.cmd.UpdateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "unknown", UpdateKeyRequest{Alias: "name"}))
+		require.EqualError(t, err, "get key: not found: key unknown")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Register verification methods", func(t *testing.T) {
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		keyStoreID := createKeyStore(t, env)
+		keyURL := createKey(t, env, keyStoreID, "name")
+		keyID := keyURL[strings.LastIndex(keyURL, "/")+1:]
+
+		var resp UpdateKeyResponse
+
+		// the alias is left unchanged by a request without alias
+		err := env.cmd.UpdateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-2", "did:example:123#key-1"}}))
+		require.NoError(t, err)
+		require.Equal(t, keyURL, resp.KeyURL)
+		require.Equal(t, "name", resp.Alias)
+		require.Equal(t, []string{"did:example:123#key-1", "did:example:123#key-2"}, resp.VerificationMethods)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp),
+			wrapKeyStoreRequest(t, keyStoreID, "did:example:123#key-1", nil))
+		require.NoError(t, err)
+		require.Equal(t, "name", getResp.Alias)
+		require.Equal(t, resp.VerificationMethods, getResp.VerificationMethods)
+
+		var signResp SignResponse
+
+		err = env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, "did:example:123#key-2",
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		// verification methods are replaced
+		err = env.cmd.UpdateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			UpdateKeyRequest{Alias: "name", VerificationMethods: []string{"did:example:123#key-1"}}))
+		require.NoError(t, err)
+		require.Equal(t, []string{"did:example:123#key-1"}, resp.VerificationMethods)
+
+		err = env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, "did:example:123#key-2",
+			SignRequest{Message: []byte("test message")}))
+		require.EqualError(t, err, "resolve key store: not found: DID URL did:example:123#key-2 "+
+			"isn't registered for a key of the key store")
+
+		var unknownErr *UnknownDIDURLError
+
+		require.ErrorAs(t, err, &unknownErr)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		// and removed, leaving the alias
+		var removeResp UpdateKeyResponse
+
+		err = env.cmd.UpdateKey(encodeResponse(t, &removeResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			map[string]interface{}{"verification_methods": []string{}}))
+		require.NoError(t, err)
+		require.Equal(t, "name", removeResp.Alias)
+		require.Empty(t, removeResp.VerificationMethods)
+
+		err = env.cmd.GetKey(nil, wrapKeyStoreRequest(t, keyStoreID, "did:example:123#key-1", nil))
+		require.ErrorAs(t, err, &unknownErr)
+	})
+
+	t.Run("Verification method used by another key", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyURL := createKey(t, env, keyStoreID, "name")
+		createKey(t, env, keyStoreID, "other-name")
+
+		err := env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.NoError(t, err)
+
+		err = env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "other-name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.EqualError(t, err, fmt.Sprintf("increment sequence: conflict: DID URL did:example:123#key-1 is "+
+			"registered for key %s", keyURL))
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Verification method registered by another key store", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		createKey(t, env, keyStoreID, "name")
+
+		otherKeyStoreID := createKeyStore(t, env)
+		createKey(t, env, otherKeyStoreID, "name")
+
+		err := env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.NoError(t, err)
+
+		err = env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, otherKeyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.EqualError(t, err, "increment sequence: conflict: DID URL did:example:123#key-1 is registered by "+
+			"another key store")
+
+		var conflictErr *VerificationMethodConflictError
+
+		require.ErrorAs(t, err, &conflictErr)
+		require.Empty(t, conflictErr.KeyURL)
+
+		// DID URLs don't resolve to keys of other key stores
+		err = env.cmd.GetKey(nil, wrapKeyStoreRequest(t, otherKeyStoreID, "did:example:123#key-1", nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		// the DID URL is released when the key store that registered it removes it
+		err = env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{}}))
+		require.NoError(t, err)
+
+		err = env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, otherKeyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.NoError(t, err)
+
+		// or when the key store is deleted
+		env.zcap.EXPECT().Delete(gomock.Any()).Return(nil).Times(1)
+
+		require.NoError(t, env.cmd.DeleteKeyStore(nil, wrapCallerRequest(t, otherKeyStoreID, "did:example:controller")))
+
+		err = env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "name",
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+		require.NoError(t, err)
+	})
+
+	t.Run("Invalid verification method", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		for _, didURL := range []string{"did:example:123", "key-1", "did:example:123/path#key-1"} {
+			err := env.cmd.UpdateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+				UpdateKeyRequest{VerificationMethods: []string{didURL}}))
+			require.Error(t, err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		}
+	})
+}
+
+func TestCommand_SetKeyState(t *testing.T) {
+	newEnv := func(t *testing.T) (*keyStoreEnv, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, kt kms.KeyType, alias string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kt, Alias: alias}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	setState := func(t *testing.T, env *keyStoreEnv, keyStoreID, keyID string, state KeyState) *SetKeyStateResponse {
+		t.Helper()
+
+		var resp SetKeyStateResponse
+
+		err := env.cmd.SetKeyState(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SetKeyStateRequest{State: state}))
+		require.NoError(t, err)
+
+		return &resp
+	}
+
+	requireKeyDisabled := func(t *testing.T, err error, keyStoreID, keyID string) {
+		t.Helper()
+
+		var disabledErr *KeyDisabledError
+
+		require.True(t, errors.As(err, &disabledErr))
+		require.Equal(t, fmt.Sprintf("https://kms.example.com/v1/keystores/%s/keys/%s", keyStoreID, keyID),
+			disabledErr.KeyURL)
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	}
+
+	t.Run("Disabled key verifies but doesn't sign", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type, "")
+
+		var signResp SignResponse
+
+		err := env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		resp := setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+		require.Equal(t, KeyStateDisabled, resp.State)
+		require.Equal(t, fmt.Sprintf("https://kms.example.com/v1/keystores/%s/keys/%s", keyStoreID, keyID),
+			resp.KeyURL)
+		require.Equal(t, uint64(2), resp.Sequence)
+
+		err = env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, SignRequest{Message: []byte("test")}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+
+		err = env.cmd.SignBatch(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignBatchRequest{Messages: [][]byte{[]byte("test")}}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, KeyStateDisabled, getResp.State)
+		require.NotNil(t, getResp.CreatedAt)
+	})
+
+	t.Run("Re-enabled key signs", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type, "")
+
+		setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+		resp := setState(t, env, keyStoreID, keyID, KeyStateActive)
+		require.Equal(t, KeyStateActive, resp.State)
+
+		err := env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, KeyStateActive, getResp.State)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.NotContains(t, meta["keys"].(map[string]interface{})[keyID], "state")
+	})
+
+	t.Run("Disabled key decrypts but doesn't encrypt", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.AES256GCMType, "")
+
+		var encryptResp EncryptResponse
+
+		err := env.cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+
+		err = env.cmd.Encrypt(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+
+		var decryptResp DecryptResponse
+
+		err = env.cmd.Decrypt(encodeResponse(t, &decryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			DecryptRequest{Ciphertext: encryptResp.Ciphertext, Nonce: encryptResp.Nonce}))
+		require.NoError(t, err)
+		require.Equal(t, []byte("test message"), decryptResp.Plaintext)
+	})
+
+	t.Run("Disabled key verifies MAC but doesn't compute it", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.HMACSHA256Tag256Type, "")
+
+		var macResp ComputeMACResponse
+
+		err := env.cmd.ComputeMAC(encodeResponse(t, &macResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			ComputeMACRequest{Data: []byte("test data")}))
+		require.NoError(t, err)
+
+		setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+
+		err = env.cmd.ComputeMAC(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			ComputeMACRequest{Data: []byte("test data")}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+
+		err = env.cmd.VerifyMAC(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyMACRequest{MAC: macResp.MAC, Data: []byte("test data")}))
+		require.NoError(t, err)
+	})
+
+	t.Run("Disabled sender key doesn't wrap", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.NISTP256ECDHKWType, "")
+
+		setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+
+		err := env.cmd.WrapKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			WrapKeyRequest{CEK: []byte("cek"), RecipientPubKey: &crypto.PublicKey{}}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+	})
+
+	t.Run("Disable key by alias", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type, "signing-key")
+
+		resp := setState(t, env, keyStoreID, "signing-key", KeyStateDisabled)
+		require.True(t, strings.HasSuffix(resp.KeyURL, "/"+keyID))
+
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, "signing-key",
+			SignRequest{Message: []byte("test")}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+	})
+
+	t.Run("Dry run with disabled key", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type, "")
+
+		setState(t, env, keyStoreID, keyID, KeyStateDisabled)
+
+		err := env.cmd.Validate(ActionSign, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test")}))
+		requireKeyDisabled(t, err, keyStoreID, keyID)
+
+		err = env.cmd.Validate(ActionVerify, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Validate(ActionSetKeyState, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SetKeyStateRequest{State: "revoked"}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Invalid state", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type, "")
+
+		err := env.cmd.SetKeyState(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SetKeyStateRequest{State: "revoked"}))
+		require.EqualError(t, err, `validation failed: state must be "active" or "disabled"`)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		err := env.cmd.SetKeyState(nil, wrapKeyStoreRequest(t, keyStoreID, "unknown",
+			SetKeyStateRequest{State: KeyStateDisabled}))
+		require.EqualError(t, err, "get key: not found: key unknown")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_RepairKeyStore(t *testing.T) {
+	newEnv := func(t *testing.T, keyTypes ...kms.KeyType) (*keyStoreEnv, string, []string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		keyStoreID := strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		keyIDs := make([]string, 0, len(keyTypes))
+
+		for i, kt := range keyTypes {
+			var keyResp CreateKeyResponse
+
+			err = env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+				CreateKeyRequest{KeyType: kt, Alias: fmt.Sprintf("key-%d", i)}))
+			require.NoError(t, err)
+
+			keyIDs = append(keyIDs, keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:])
+		}
+
+		return env, keyStoreID, keyIDs
+	}
+
+	keyRecords := func(t *testing.T, p storage.Provider) storage.Store {
+		t.Helper()
+
+		store, err := p.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
+
+		store, err = prefix.NewPrefixStoreWrapper(store, prefix.StorageKIDPrefix)
+		require.NoError(t, err)
+
+		return store
+	}
+
+	t.Run("Healthy key store", func(t *testing.T) {
+		env, keyStoreID, _ := newEnv(t, kms.ED25519Type, kms.AES256GCMType)
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Empty(t, report.Findings)
+		require.False(t, report.Fixed)
+	})
+
+	t.Run("Corrupted key record is quarantined", func(t *testing.T) {
+		env, keyStoreID, keyIDs := newEnv(t, kms.ED25519Type, kms.ED25519Type)
+
+		records := keyRecords(t, env.keyStorage)
+		require.NoError(t, records.Put(keyIDs[0], []byte("partial wri")))
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, false)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, "key "+keyIDs[0], report.Findings[0].Record)
+		require.Contains(t, report.Findings[0].Problem, "record is not an encrypted keyset")
+		require.Equal(t, RepairFixQuarantine, report.Findings[0].Fix)
+		require.False(t, report.Fixed)
+
+		// dry run doesn't change anything
+		b, err := records.Get(keyIDs[0])
+		require.NoError(t, err)
+		require.Equal(t, "partial wri", string(b))
+
+		report, err = env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.True(t, report.Fixed)
+		require.Equal(t, uint64(3), report.Sequence)
+
+		_, err = records.Get(keyIDs[0])
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		quarantine, err := env.serverStorage.OpenStore(QuarantineStore)
+		require.NoError(t, err)
+
+		b, err = quarantine.Get(keyStoreID + "/" + keyIDs[0])
+		require.NoError(t, err)
+		require.Contains(t, string(b), base64.StdEncoding.EncodeToString([]byte("partial wri")))
+
+		var listResp ListKeysResponse
+
+		err = env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Len(t, listResp.Keys, 1)
+		require.True(t, strings.HasSuffix(listResp.Keys[0].KeyURL, keyIDs[1]))
+
+		err = env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, "key-1", SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		report, err = env.cmd.RepairKeyStore(keyStoreID, false)
+		require.NoError(t, err)
+		require.Empty(t, report.Findings)
+	})
+
+	t.Run("Undecryptable key record is quarantined", func(t *testing.T) {
+		env, keyStoreID, keyIDs := newEnv(t, kms.ED25519Type, kms.ED25519Type)
+
+		records := keyRecords(t, env.keyStorage)
+		require.NoError(t, records.Put(keyIDs[1], []byte(`{"encryptedKeyset":"AAAA"}`)))
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, "key "+keyIDs[1], report.Findings[0].Record)
+		require.Contains(t, report.Findings[0].Problem, "record can't be decrypted")
+		require.Equal(t, RepairFixQuarantine, report.Findings[0].Fix)
+		require.True(t, report.Fixed)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{keyIDs[0]}, meta["key_ids"])
+		require.Equal(t, map[string]interface{}{"key-0": keyIDs[0]}, meta["aliases"])
+	})
+
+	t.Run("Keys that can't be decrypted at all are not quarantined", func(t *testing.T) {
+		env, keyStoreID, keyIDs := newEnv(t, kms.ED25519Type)
+
+		records := keyRecords(t, env.keyStorage)
+		require.NoError(t, records.Put(keyIDs[0], []byte(`{"encryptedKeyset":"AAAA"}`)))
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Contains(t, report.Findings[0].Problem, "no key of the key store can be decrypted")
+		require.Empty(t, report.Findings[0].Fix)
+		require.Equal(t, 1, report.Unfixable())
+		require.False(t, report.Fixed)
+
+		_, err = records.Get(keyIDs[0])
+		require.NoError(t, err)
+	})
+
+	t.Run("Key list and aliases are rebuilt", func(t *testing.T) {
+		env, keyStoreID, keyIDs := newEnv(t, kms.ED25519Type, kms.ED25519Type, kms.AES256GCMType)
+
+		require.NoError(t, keyRecords(t, env.keyStorage).Delete(keyIDs[2]))
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		meta["key_ids"] = []string{keyIDs[0], keyIDs[0], keyIDs[2]}
+		meta["aliases"].(map[string]interface{})["stale"] = "unknown"
+		env.putKeyStore(t, meta)
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Equal(t, []RepairFinding{
+			{Record: "key " + keyIDs[0], Problem: "is listed more than once", Fix: RepairFixRemoveDuplicate},
+			{Record: "key " + keyIDs[1], Problem: "has metadata, but is not in the key list", Fix: RepairFixAddToKeyList},
+			{Record: "alias stale", Problem: "refers to unknown key unknown", Fix: RepairFixRemoveAlias},
+			{Record: "key " + keyIDs[2], Problem: "record is missing", Fix: RepairFixRemoveFromKeyList},
+		}, report.Findings)
+		require.True(t, report.Fixed)
+
+		meta, err = env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{keyIDs[0], keyIDs[1]}, meta["key_ids"])
+		require.Equal(t, map[string]interface{}{"key-0": keyIDs[0], "key-1": keyIDs[1]}, meta["aliases"])
+		require.NotContains(t, meta["keys"], keyIDs[2])
+	})
+
+	t.Run("Unreadable main key can't be fixed", func(t *testing.T) {
+		env, keyStoreID, _ := newEnv(t, kms.ED25519Type)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		mainKeyID := meta["main_key_id"].(string)
+		require.NoError(t, keyRecords(t, env.serverStorage).Put(mainKeyID, []byte("{}")))
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, "server key "+mainKeyID, report.Findings[0].Record)
+		require.Contains(t, report.Findings[0].String(), "can't be read with the server secret lock")
+		require.Contains(t, report.Findings[0].String(), "(can't be fixed)")
+		require.False(t, report.Fixed)
+	})
+
+	t.Run("Corrupted metadata can't be fixed", func(t *testing.T) {
+		env, keyStoreID, _ := newEnv(t)
+
+		require.NoError(t, env.keyStores.Put(keyStoreID, []byte(`{"id":`)))
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, "metadata", report.Findings[0].Record)
+		require.Equal(t, 1, report.Unfixable())
+	})
+
+	t.Run("Key store not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		_, err := env.cmd.RepairKeyStore("notfound", false)
+		require.Error(t, err)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_ReconcileEDV(t *testing.T) {
+	vault := newFakeVault(t)
+
+	headerSigner := NewMockHeaderSigner(gomock.NewController(t))
+	headerSigner.EXPECT().SignHeader(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(req *http.Request, _ []byte) (*http.Header, error) {
+			return &req.Header, nil
+		}).AnyTimes()
+
+	// keys of the EDV-backed key store are written to the vault
+	creator := NewMockKeyStoreCreator(gomock.NewController(t))
+	creator.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(keyURI string, p kms.Provider) (kms.KeyManager, error) {
+			return localkms.New(keyURI, p)
+		}).AnyTimes()
+
+	env := newKeyStoreEnv(t, func(c *Config) {
+		c.KeyStoreCreator = creator
+		c.EDVRecipientKeyType = kms.NISTP256ECDHKW
+		c.EDVMACKeyType = kms.HMACSHA256Tag256
+		c.HeaderSigner = headerSigner
+	})
+
+	createKeyStore := func(t *testing.T, edv *EDVOptions) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller", EDV: edv}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	keyStoreID := createKeyStore(t, &EDVOptions{VaultURL: vault.URL + "/encrypted-data-vaults/vault-id"})
+	localKeyStoreID := createKeyStore(t, nil)
+
+	keyIDs := make([]string, 3)
+	docIDs := make([]string, 3)
+
+	for i := range keyIDs {
+		before := vault.documentIDs()
+
+		var keyResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+		keyIDs[i] = keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:]
+
+		for id := range vault.documentIDs() {
+			if !before[id] {
+				docIDs[i] = id
+			}
+		}
+
+		require.NotEmpty(t, docIDs[i])
+	}
+
+	// documents deleted directly in the vault
+	vault.delete(docIDs[1])
+	vault.delete(docIDs[2])
+
+	t.Run("Missing documents are reported", func(t *testing.T) {
+		waits := 0
+
+		report, err := env.cmd.ReconcileEDV(keyStoreID, func() { waits++ })
+		require.NoError(t, err)
+		require.Equal(t, keyStoreID, report.KeyStoreID)
+		require.Equal(t, 3, report.Checked)
+		require.Equal(t, 3, waits)
+		require.Equal(t, []EDVFinding{
+			{KeyID: keyIDs[1], Problem: "missing in vault"},
+			{KeyID: keyIDs[2], Problem: "missing in vault"},
+		}, report.Findings)
+	})
+
+	t.Run("Unreadable documents are reported", func(t *testing.T) {
+		vault.fail(http.StatusInternalServerError)
+		defer vault.fail(0)
+
+		report, err := env.cmd.ReconcileEDV(keyStoreID, func() {})
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 3)
+		require.Contains(t, report.Findings[0].Problem, "can't be read from vault:")
+	})
+
+	t.Run("EDV-backed key stores are listed", func(t *testing.T) {
+		ids, err := env.cmd.EDVKeyStoreIDs()
+		require.NoError(t, err)
+		require.Equal(t, []string{keyStoreID}, ids)
+	})
+
+	t.Run("Fail with key store that is not EDV-backed", func(t *testing.T) {
+		_, err := env.cmd.ReconcileEDV(localKeyStoreID, func() {})
+		require.EqualError(t, err, "validation failed: key store "+localKeyStoreID+" is not EDV-backed")
+	})
+
+	t.Run("Fail with unknown key store", func(t *testing.T) {
+		_, err := env.cmd.ReconcileEDV("unknown", func() {})
+		require.EqualError(t, err, "get key store: not found: key store unknown")
+	})
+}
+
+func TestCommand_BackfillFingerprints(t *testing.T) {
+	newEnv := func(t *testing.T, keyStores int) (*keyStoreEnv, []string, []CreateKeyResponse) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		var (
+			keyStoreIDs []string
+			signingKeys []CreateKeyResponse
+		)
+
+		for i := 0; i < keyStores; i++ {
+			var resp CreateKeyStoreResponse
+
+			err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+				CreateKeyStoreRequest{Controller: "did:example:controller"}))
+			require.NoError(t, err)
+
+			keyStoreID := strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+			for _, kt := range []kms.KeyType{kms.ED25519Type, kms.AES256GCMType} {
+				var keyResp CreateKeyResponse
+
+				err = env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+					CreateKeyRequest{KeyType: kt}))
+				require.NoError(t, err)
+
+				if kt == kms.ED25519Type {
+					signingKeys = append(signingKeys, keyResp)
+				}
+			}
+
+			keyStoreIDs = append(keyStoreIDs, keyStoreID)
+		}
+
+		return env, keyStoreIDs, signingKeys
+	}
+
+	// keyFingerprints returns fingerprints of keys of the key store, by key ID
+	keyFingerprints := func(t *testing.T, env *keyStoreEnv, keyStoreID string) map[string]string {
+		t.Helper()
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		fingerprints := map[string]string{}
+
+		for keyID, km := range meta["keys"].(map[string]interface{}) {
+			fingerprint, _ := km.(map[string]interface{})["fingerprint"].(string) //nolint:errcheck
+
+			fingerprints[keyID] = fingerprint
+		}
+
+		return fingerprints
+	}
+
+	// removeFingerprints makes the key store look like it was saved before fingerprints were stored
+	removeFingerprints := func(t *testing.T, env *keyStoreEnv, keyStoreID string) {
+		t.Helper()
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		for _, km := range meta["keys"].(map[string]interface{}) {
+			delete(km.(map[string]interface{}), "fingerprint")
+		}
+
+		tags, err := env.keyStores.GetTags(keyStoreID)
+		require.NoError(t, err)
+
+		b, err := json.Marshal(meta)
+		require.NoError(t, err)
+
+		require.NoError(t, env.keyStores.Put(keyStoreID, b, tags...))
+	}
+
+	expectedFingerprint := func(t *testing.T, key CreateKeyResponse) string {
+		t.Helper()
+
+		didKey, err := didkey.FromPublicKey(key.PublicKey, kms.ED25519Type)
+		require.NoError(t, err)
+
+		return didKey.Fingerprint
+	}
+
+	t.Run("New keys have fingerprints", func(t *testing.T) {
+		env, keyStoreIDs, signingKeys := newEnv(t, 1)
+
+		fingerprints := keyFingerprints(t, env, keyStoreIDs[0])
+		require.Len(t, fingerprints, 2)
+
+		keyID := signingKeys[0].KeyURL[strings.LastIndex(signingKeys[0].KeyURL, "/")+1:]
+
+		for kid, fingerprint := range fingerprints {
+			if kid == keyID {
+				require.Equal(t, expectedFingerprint(t, signingKeys[0]), fingerprint)
+			} else {
+				require.Empty(t, fingerprint, "symmetric keys have no fingerprint")
+			}
+		}
+
+		report, err := env.cmd.BackfillFingerprints(10, func(*BackfillReport) {})
+		require.NoError(t, err)
+		require.Equal(t, &BackfillReport{Cursor: keyStoreIDs[0], KeyStores: 1, Skipped: 1, Unsupported: 1}, report)
+	})
+
+	t.Run("Resume after interruption", func(t *testing.T) {
+		env, keyStoreIDs, signingKeys := newEnv(t, 3)
+
+		for _, keyStoreID := range keyStoreIDs {
+			removeFingerprints(t, env, keyStoreID)
+		}
+
+		sort.Strings(keyStoreIDs)
+
+		// interrupted after the first batch
+		require.Panics(t, func() {
+			_, _ = env.cmd.BackfillFingerprints(2, func(report *BackfillReport) { //nolint:errcheck
+				require.Equal(t, keyStoreIDs[1], report.Cursor)
+				require.Equal(t, 2, report.Updated)
+
+				panic("interrupted")
+			})
+		})
+
+		var batches []BackfillReport
+
+		report, err := env.cmd.BackfillFingerprints(2, func(report *BackfillReport) {
+			batches = append(batches, *report)
+		})
+		require.NoError(t, err)
+		require.Equal(t, &BackfillReport{Cursor: keyStoreIDs[2], KeyStores: 1, Updated: 1, Unsupported: 1}, report)
+		require.Equal(t, []BackfillReport{*report}, batches)
+
+		backfilled := map[string]bool{}
+
+		for _, keyStoreID := range keyStoreIDs {
+			for _, fingerprint := range keyFingerprints(t, env, keyStoreID) {
+				if fingerprint != "" {
+					backfilled[fingerprint] = true
+				}
+			}
+		}
+
+		require.Len(t, backfilled, 3)
+
+		for _, key := range signingKeys {
+			require.True(t, backfilled[expectedFingerprint(t, key)])
+		}
+
+		// the checkpoint is removed when all key stores are processed, the next run starts over and skips
+		// backfilled keys
+		report, err = env.cmd.BackfillFingerprints(1, func(*BackfillReport) {})
+		require.NoError(t, err)
+		require.Equal(t, &BackfillReport{Cursor: keyStoreIDs[2], KeyStores: 3, Skipped: 3, Unsupported: 3}, report)
+	})
+
+	t.Run("Key that can't be read is an error", func(t *testing.T) {
+		env, keyStoreIDs, signingKeys := newEnv(t, 2)
+
+		for _, keyStoreID := range keyStoreIDs {
+			removeFingerprints(t, env, keyStoreID)
+		}
+
+		records, err := env.keyStorage.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
+
+		records, err = prefix.NewPrefixStoreWrapper(records, prefix.StorageKIDPrefix)
+		require.NoError(t, err)
+
+		keyID := signingKeys[0].KeyURL[strings.LastIndex(signingKeys[0].KeyURL, "/")+1:]
+		require.NoError(t, records.Delete(keyID))
+
+		report, err := env.cmd.BackfillFingerprints(10, func(*BackfillReport) {})
+		require.NoError(t, err)
+		require.Equal(t, 2, report.KeyStores)
+		require.Equal(t, 1, report.Updated)
+		require.Equal(t, 2, report.Unsupported)
+		require.Equal(t, 1, report.Errors)
+		require.Empty(t, keyFingerprints(t, env, keyStoreIDs[0])[keyID])
+	})
+
+	t.Run("Fail with invalid batch size", func(t *testing.T) {
+		env, _, _ := newEnv(t, 0)
+
+		_, err := env.cmd.BackfillFingerprints(0, func(*BackfillReport) {})
+		require.EqualError(t, err, "validation failed: batch size must be positive")
+	})
+}
+
+func TestCommand_EDVBreaker(t *testing.T) {
+	vault := newFakeVault(t)
+
+	headerSigner := NewMockHeaderSigner(gomock.NewController(t))
+	headerSigner.EXPECT().SignHeader(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(req *http.Request, _ []byte) (*http.Header, error) {
+			return &req.Header, nil
+		}).AnyTimes()
+
+	creator := NewMockKeyStoreCreator(gomock.NewController(t))
+	creator.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(keyURI string, p kms.Provider) (kms.KeyManager, error) {
+			return localkms.New(keyURI, p)
+		}).AnyTimes()
+
+	clk := testutil.NewFakeClock(time.Now())
+	edvBreaker := breaker.New(breaker.DependencyEDV, breaker.Config{MinCalls: 2, OpenTimeout: time.Minute},
+		breaker.WithClock(clk))
+
+	env := newKeyStoreEnv(t, func(c *Config) {
+		c.KeyStoreCreator = creator
+		c.EDVRecipientKeyType = kms.NISTP256ECDHKW
+		c.EDVMACKeyType = kms.HMACSHA256Tag256
+		c.HeaderSigner = headerSigner
+		c.EDVBreaker = edvBreaker
+		c.EDVTimeout = time.Second
+	})
+
+	var ksResp CreateKeyStoreResponse
+
+	require.NoError(t, env.cmd.CreateKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, "", "",
+		CreateKeyStoreRequest{
+			Controller: "did:example:controller",
+			EDV:        &EDVOptions{VaultURL: vault.URL + "/encrypted-data-vaults/vault-id"},
+		})))
+
+	keyStoreID := strings.TrimPrefix(ksResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+	createKey := func() error {
+		return env.cmd.CreateKey(&bytes.Buffer{}, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type}))
+	}
+
+	require.NoError(t, createKey())
+
+	vault.fail(http.StatusInternalServerError)
+
+	// the breaker opens once failed calls outnumber the successful calls of the first creation
+	for i := 0; i < 10 && edvBreaker.State() == breaker.StateClosed; i++ {
+		require.Error(t, createKey())
+	}
+
+	require.Equal(t, breaker.StateOpen, edvBreaker.State())
+
+	// an open breaker fails fast without calling the vault
+	requests := vault.requestCount()
+	start := time.Now()
+
+	err := createKey()
+	require.ErrorIs(t, err, breaker.ErrOpen)
+	require.Equal(t, http.StatusServiceUnavailable, kmserrors.StatusCodeFromError(err))
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	require.Equal(t, requests, vault.requestCount())
+
+	vault.fail(0)
+	clk.Advance(time.Minute)
+
+	require.NoError(t, createKey())
+	require.Equal(t, breaker.StateClosed, edvBreaker.State())
+}
+
+// fakeVault is an EDV server that keeps documents of a vault in memory.
+type fakeVault struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	docs     map[string][]byte
+	status   int
+	requests int
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	t.Helper()
+
+	v := &fakeVault{docs: make(map[string][]byte)}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+
+	t.Cleanup(v.Close)
+
+	return v
+}
+
+func (v *fakeVault) documentIDs() map[string]bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	ids := make(map[string]bool, len(v.docs))
+
+	for id := range v.docs {
+		ids[id] = true
+	}
+
+	return ids
+}
+
+func (v *fakeVault) delete(docID string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	delete(v.docs, docID)
+}
+
+// fail makes the vault respond with the status, or serve documents again if the status is 0.
+func (v *fakeVault) fail(status int) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.status = status
+}
+
+func (v *fakeVault) requestCount() int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.requests
+}
+
+func (v *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.requests++
+
+	if v.status != 0 {
+		w.WriteHeader(v.status)
+
+		return
+	}
+
+	const documents = "/encrypted-data-vaults/vault-id/documents"
+
+	docID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, documents), "/")
+
+	switch {
+	case r.Method == http.MethodGet:
+		doc, ok := v.docs[docID]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		_, _ = w.Write(doc) //nolint:errcheck
+	case docID == "": // create
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+
+		var doc struct {
+			ID string `json:"id"`
+		}
+
+		_ = json.Unmarshal(body, &doc) //nolint:errcheck
+
+		v.docs[doc.ID] = body
+
+		w.Header().Set("Location", v.URL+documents+"/"+doc.ID)
+		w.WriteHeader(http.StatusCreated)
+	default: // update
+		body, _ := io.ReadAll(r.Body) //nolint:errcheck
+
+		v.docs[docID] = body
+	}
+}
+
+type keyStoreEnv struct {
+	cmd           *Command
+	keyStores     storage.Store
+	zcap          *MockZCAPService
+	userKMS       kms.KeyManager
+	serverKMS     kms.KeyManager
+	recorder      *recordingKeyManager
+	serverStorage storage.Provider
+	keyStorage    storage.Provider
+}
+
+func TestCommand_KeyExpiry(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	newEnv := func(t *testing.T) (*keyStoreEnv, *testutil.FakeClock, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		clk := testutil.NewFakeClock(now)
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics), withClock(clk, time.Minute))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, clk, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, req CreateKeyRequest) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", req))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	requireKeyExpired := func(t *testing.T, err error, keyStoreID, keyID string, expiresAt time.Time) {
+		t.Helper()
+
+		var expiredErr *KeyExpiredError
+
+		require.True(t, errors.As(err, &expiredErr))
+		require.Equal(t, fmt.Sprintf("https://kms.example.com/v1/keystores/%s/keys/%s", keyStoreID, keyID),
+			expiredErr.KeyURL)
+		require.True(t, expiresAt.Equal(expiredErr.ExpiresAt))
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	}
+
+	t.Run("Expired key verifies but doesn't sign", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+
+		expiresAt := now.Add(time.Hour)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type, ExpiresAt: &expiresAt})
+
+		var signResp SignResponse
+
+		err := env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		clk.Advance(time.Hour + 30*time.Second) // within the clock skew
+
+		err = env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID, SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		clk.Advance(30 * time.Second)
+
+		err = env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, SignRequest{Message: []byte("test")}))
+		requireKeyExpired(t, err, keyStoreID, keyID, expiresAt)
+		require.Contains(t, err.Error(), "expired at 2022-06-01T13:00:00Z")
+
+		err = env.cmd.SignBatch(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignBatchRequest{Messages: [][]byte{[]byte("test")}}))
+		requireKeyExpired(t, err, keyStoreID, keyID, expiresAt)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.NotNil(t, getResp.ExpiresAt)
+		require.True(t, expiresAt.Equal(*getResp.ExpiresAt))
+	})
+
+	t.Run("Expired key decrypts but doesn't encrypt", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+
+		expiresAt := now.Add(time.Hour)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.AES256GCMType, ExpiresAt: &expiresAt})
+
+		var encryptResp EncryptResponse
+
+		err := env.cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		clk.Advance(2 * time.Hour)
+
+		err = env.cmd.Encrypt(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		requireKeyExpired(t, err, keyStoreID, keyID, expiresAt)
+
+		var decryptResp DecryptResponse
+
+		err = env.cmd.Decrypt(encodeResponse(t, &decryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			DecryptRequest{Ciphertext: encryptResp.Ciphertext, Nonce: encryptResp.Nonce}))
+		require.NoError(t, err)
+		require.Equal(t, []byte("test message"), decryptResp.Plaintext)
+	})
+
+	t.Run("Key without expiration time doesn't expire", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+
+		clk.Advance(100 * 365 * 24 * time.Hour)
+
+		err := env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID, SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Nil(t, getResp.ExpiresAt)
+	})
+
+	t.Run("Rotated key gets its own expiration time", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t)
+
+		expiresAt := now.Add(time.Hour)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type, ExpiresAt: &expiresAt})
+
+		clk.Advance(2 * time.Hour)
+
+		newExpiresAt := clk.Now().Add(time.Hour)
+
+		var rotateResp RotateKeyResponse
+
+		err := env.cmd.RotateKey(encodeResponse(t, &rotateResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			RotateKeyRequest{KeyType: kms.ED25519Type, ExpiresAt: &newExpiresAt}))
+		require.NoError(t, err)
+
+		newKeyID := rotateResp.KeyURL[strings.LastIndex(rotateResp.KeyURL, "/")+1:]
+
+		err = env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, newKeyID,
+			SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, newKeyID, nil))
+		require.NoError(t, err)
+		require.NotNil(t, getResp.ExpiresAt)
+		require.True(t, newExpiresAt.Equal(*getResp.ExpiresAt))
+	})
+
+	t.Run("Batch creation with expiration times", func(t *testing.T) {
+		env, _, keyStoreID := newEnv(t)
+
+		expiresAt := now.Add(time.Hour)
+
+		var resp CreateKeysResponse
+
+		err := env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{
+				{KeyType: kms.ED25519Type, ExpiresAt: &expiresAt},
+				{KeyType: kms.ED25519Type},
+			}}))
+		require.NoError(t, err)
+		require.Len(t, resp.Keys, 2)
+
+		var listResp ListKeysResponse
+
+		err = env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Len(t, listResp.Keys, 2)
+		require.NotNil(t, listResp.Keys[0].ExpiresAt)
+		require.True(t, expiresAt.Equal(*listResp.Keys[0].ExpiresAt))
+		require.Nil(t, listResp.Keys[1].ExpiresAt)
+	})
+
+	t.Run("Fail to create key with past expiration time", func(t *testing.T) {
+		env, _, keyStoreID := newEnv(t)
+
+		expiresAt := now.Add(-time.Second)
+
+		err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, ExpiresAt: &expiresAt}))
+		require.EqualError(t, err, "validation failed: expires_at must be in the future")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{
+				{KeyType: kms.ED25519Type},
+				{KeyType: kms.ED25519Type, ExpiresAt: &expiresAt},
+			}}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key 1: validation failed: expires_at must be in the future")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_KeyPurposes(t *testing.T) {
+	newEnv := func(t *testing.T) (*keyStoreEnv, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, req CreateKeyRequest) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", req))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	requirePurposeNotAllowed := func(t *testing.T, err error, keyStoreID, keyID string, purpose KeyPurpose) {
+		t.Helper()
+
+		var purposeErr *KeyPurposeError
+
+		require.True(t, errors.As(err, &purposeErr))
+		require.Equal(t, fmt.Sprintf("https://kms.example.com/v1/keystores/%s/keys/%s", keyStoreID, keyID),
+			purposeErr.KeyURL)
+		require.Equal(t, purpose, purposeErr.Purpose)
+		require.Contains(t, err.Error(), fmt.Sprintf("is not allowed for purpose %q", purpose))
+		require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+	}
+
+	t.Run("Signing key can't wrap keys", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{
+			KeyType:  kms.ED25519Type,
+			Purposes: []KeyPurpose{KeyPurposeSign, KeyPurposeVerify},
+		})
+
+		var signResp SignResponse
+
+		err := env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.WrapKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			WrapKeyRequest{CEK: []byte("cek"), RecipientPubKey: &crypto.PublicKey{}}))
+		requirePurposeNotAllowed(t, err, keyStoreID, keyID, KeyPurposeWrap)
+
+		err = env.cmd.Validate(ActionWrap, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			WrapKeyRequest{CEK: []byte("cek"), RecipientPubKey: &crypto.PublicKey{}}))
+		requirePurposeNotAllowed(t, err, keyStoreID, keyID, KeyPurposeWrap)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, []KeyPurpose{KeyPurposeSign, KeyPurposeVerify}, getResp.Purposes)
+	})
+
+	t.Run("Encryption key can't decrypt", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{
+			KeyType:  kms.AES256GCMType,
+			Purposes: []KeyPurpose{KeyPurposeEncrypt},
+		})
+
+		var encryptResp EncryptResponse
+
+		err := env.cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Decrypt(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			DecryptRequest{Ciphertext: encryptResp.Ciphertext, Nonce: encryptResp.Nonce}))
+		requirePurposeNotAllowed(t, err, keyStoreID, keyID, KeyPurposeDecrypt)
+	})
+
+	t.Run("Key without purposes can be used for all operations", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.AES256GCMType})
+
+		var encryptResp EncryptResponse
+
+		err := env.cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			EncryptRequest{Message: []byte("test message")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Decrypt(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			DecryptRequest{Ciphertext: encryptResp.Ciphertext, Nonce: encryptResp.Nonce}))
+		require.NoError(t, err)
+
+		var getResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Nil(t, getResp.Purposes)
+	})
+
+	t.Run("Rotated key keeps purposes", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{
+			KeyType:  kms.ED25519Type,
+			Purposes: []KeyPurpose{KeyPurposeSign},
+		})
+
+		var rotateResp RotateKeyResponse
+
+		err := env.cmd.RotateKey(encodeResponse(t, &rotateResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			RotateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		newKeyID := rotateResp.KeyURL[strings.LastIndex(rotateResp.KeyURL, "/")+1:]
+
+		err = env.cmd.Sign(io.Discard, wrapKeyStoreRequest(t, keyStoreID, newKeyID,
+			SignRequest{Message: []byte("test")}))
+		require.NoError(t, err)
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, newKeyID,
+			VerifyRequest{Message: []byte("test")}))
+		requirePurposeNotAllowed(t, err, keyStoreID, newKeyID, KeyPurposeVerify)
+	})
+
+	t.Run("Derive subkey needs deriveKey purpose", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID, CreateKeyRequest{
+			KeyType:  kms.HMACSHA256Tag256Type,
+			Purposes: []KeyPurpose{KeyPurposeComputeMAC},
+		})
+
+		req := DeriveSubkeyRequest{KeyType: kms.AES256GCMType}
+
+		err := env.cmd.Validate(ActionDeriveSubkey, wrapKeyStoreRequest(t, keyStoreID, keyID, req))
+		requirePurposeNotAllowed(t, err, keyStoreID, keyID, KeyPurposeDeriveKey)
+	})
+
+	t.Run("Batch creation with purposes", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		var resp CreateKeysResponse
+
+		err := env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{
+				{KeyType: kms.ED25519Type, Purposes: []KeyPurpose{KeyPurposeSign}},
+				{KeyType: kms.ED25519Type},
+			}}))
+		require.NoError(t, err)
+
+		var listResp ListKeysResponse
+
+		err = env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Len(t, listResp.Keys, 2)
+		require.Equal(t, []KeyPurpose{KeyPurposeSign}, listResp.Keys[0].Purposes)
+		require.Nil(t, listResp.Keys[1].Purposes)
+	})
+
+	t.Run("Fail to create key with unknown purpose", func(t *testing.T) {
+		env, keyStoreID := newEnv(t)
+
+		err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, Purposes: []KeyPurpose{"export"}}))
+		require.EqualError(t, err, `validation failed: unknown key purpose "export"`)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type, Purposes: []KeyPurpose{"export"}}}}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `key 0: validation failed: unknown key purpose "export"`)
+
+		err = env.cmd.Validate(ActionCreateKey, wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type, Purposes: []KeyPurpose{"export"}}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_KeyUsage(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	newEnv := func(t *testing.T, track bool) (*keyStoreEnv, *testutil.FakeClock, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		clk := testutil.NewFakeClock(now)
+		opts := []configOption{withMetricsProvider(metrics), withClock(clk, 0)}
+
+		if track {
+			tracker, err := keyusage.New(mem.NewProvider(), clk, time.Hour)
+			require.NoError(t, err)
+
+			opts = append(opts, withKeyUsage(tracker))
+		}
+
+		env := newKeyStoreEnv(t, opts...)
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, clk, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	listUnused := func(t *testing.T, env *keyStoreEnv, keyStoreID string, since time.Time) []KeyInfo {
+		t.Helper()
+
+		var resp ListKeysResponse
+
+		require.NoError(t, env.cmd.ListKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			ListKeysRequest{UnusedSince: &since})))
+
+		return resp.Keys
+	}
+
+	t.Run("Sign and verify record use of the key", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t, true)
+		keyID := createKey(t, env, keyStoreID)
+
+		var getResp GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+		require.Nil(t, getResp.LastUsedAt)
+
+		// dry runs don't use the key
+		require.NoError(t, env.cmd.Validate(ActionSign, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")})))
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+		require.Nil(t, getResp.LastUsedAt)
+
+		clk.Advance(time.Minute)
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")})))
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+		require.Equal(t, now.Add(time.Minute), *getResp.LastUsedAt)
+
+		clk.Advance(time.Minute)
+
+		require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("test message")})))
+
+		var listResp ListKeysResponse
+
+		require.NoError(t, env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil)))
+		require.Len(t, listResp.Keys, 1)
+		require.Equal(t, now.Add(2*time.Minute), *listResp.Keys[0].LastUsedAt)
+	})
+
+	t.Run("List keys unused since a time", func(t *testing.T) {
+		env, clk, keyStoreID := newEnv(t, true)
+		usedKeyID := createKey(t, env, keyStoreID)
+		unusedKeyID := createKey(t, env, keyStoreID)
+
+		clk.Advance(24 * time.Hour)
+
+		require.NoError(t, env.cmd.Sign(&bytes.Buffer{}, wrapKeyStoreRequest(t, keyStoreID, usedKeyID,
+			SignRequest{Message: []byte("test message")})))
+
+		clk.Advance(24 * time.Hour)
+
+		newKeyID := createKey(t, env, keyStoreID)
+
+		keys := listUnused(t, env, keyStoreID, now.Add(time.Hour))
+		require.Len(t, keys, 1)
+		require.True(t, strings.HasSuffix(keys[0].KeyURL, "/"+unusedKeyID))
+		require.Nil(t, keys[0].LastUsedAt)
+
+		keys = listUnused(t, env, keyStoreID, now.Add(25*time.Hour))
+		require.Len(t, keys, 2)
+		require.True(t, strings.HasSuffix(keys[0].KeyURL, "/"+usedKeyID))
+		require.Equal(t, now.Add(24*time.Hour), *keys[0].LastUsedAt)
+		require.True(t, strings.HasSuffix(keys[1].KeyURL, "/"+unusedKeyID))
+
+		// keys created after the time are not reported as unused
+		for _, k := range listUnused(t, env, keyStoreID, clk.Now()) {
+			require.False(t, strings.HasSuffix(k.KeyURL, "/"+newKeyID))
+		}
+	})
+
+	t.Run("Tracking disabled", func(t *testing.T) {
+		env, _, keyStoreID := newEnv(t, false)
+		keyID := createKey(t, env, keyStoreID)
+
+		require.NoError(t, env.cmd.Sign(&bytes.Buffer{}, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")})))
+
+		var getResp GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+		require.Nil(t, getResp.LastUsedAt)
+
+		unusedSince := now
+
+		err := env.cmd.ListKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "", ListKeysRequest{UnusedSince: &unusedSince}))
+		require.EqualError(t, err, "validation failed: key usage tracking is disabled")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_ArchiveColdKeys(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	newEnv := func(t *testing.T) (*keyStoreEnv, *testutil.FakeClock, *archive.StorageTarget, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		clk := testutil.NewFakeClock(now)
+
+		tracker, err := keyusage.New(mem.NewProvider(), clk, time.Hour)
+		require.NoError(t, err)
+
+		target := archive.NewStorageTarget(mem.NewProvider())
+
+		// keys are read through the key storage passed to the key store creator, so that archived keys are recalled
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics), withClock(clk, 0), withKeyUsage(tracker),
+			withKeyStoreCreator(&testKeyStoreCreator{}), withKeyArchive(target))
+
+		var resp CreateKeyStoreResponse
+
+		err = env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, clk, target, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	// getKeyset returns the keyset of the key as saved in primary storage
+	getKeyset := func(t *testing.T, env *keyStoreEnv, keyID string) []byte {
+		t.Helper()
+
+		store, err := env.keyStorage.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
+
+		b, err := store.Get(prefix.StorageKIDPrefix + keyID)
+		require.NoError(t, err)
+
+		return b
+	}
+
+	sign := func(t *testing.T, env *keyStoreEnv, keyStoreID, keyID string) error {
+		t.Helper()
+
+		return env.cmd.Sign(&bytes.Buffer{}, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+	}
+
+	t.Run("Keys not used for the period are archived and recalled on use", func(t *testing.T) {
+		env, clk, target, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID)
+		keyset := getKeyset(t, env, keyID)
+
+		archived, err := env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, archived)
+
+		clk.Advance(24 * time.Hour)
+
+		archived, err = env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 1, archived)
+		require.True(t, strings.HasPrefix(string(getKeyset(t, env, keyID)), "archived:"))
+
+		record, err := target.Get(localkms.Namespace, prefix.StorageKIDPrefix+keyID)
+		require.NoError(t, err)
+		require.Equal(t, keyset, record.Value)
+
+		// archived keys are skipped
+		archived, err = env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Zero(t, archived)
+
+		require.NoError(t, sign(t, env, keyStoreID, keyID))
+		require.Equal(t, keyset, getKeyset(t, env, keyID))
+
+		_, err = target.Get(localkms.Namespace, prefix.StorageKIDPrefix+keyID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Recently used and deleted keys are not archived", func(t *testing.T) {
+		env, clk, _, keyStoreID := newEnv(t)
+		usedKeyID := createKey(t, env, keyStoreID)
+		deletedKeyID := createKey(t, env, keyStoreID)
+		coldKeyID := createKey(t, env, keyStoreID)
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, deletedKeyID, nil)))
+
+		clk.Advance(48 * time.Hour)
+
+		require.NoError(t, sign(t, env, keyStoreID, usedKeyID))
+
+		archived, err := env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 1, archived)
+
+		require.False(t, strings.HasPrefix(string(getKeyset(t, env, usedKeyID)), "archived:"))
+		require.False(t, strings.HasPrefix(string(getKeyset(t, env, deletedKeyID)), "archived:"))
+		require.True(t, strings.HasPrefix(string(getKeyset(t, env, coldKeyID)), "archived:"))
+	})
+
+	t.Run("Purged keys are deleted from the archive", func(t *testing.T) {
+		env, clk, target, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID)
+
+		clk.Advance(24 * time.Hour)
+
+		archived, err := env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 1, archived)
+
+		require.NoError(t, env.cmd.DeleteKey(nil, wrapKeyStoreRequest(t, keyStoreID, keyID, nil)))
+
+		clk.Advance(DefaultKeyRetentionPeriod)
+
+		purged, err := env.cmd.PurgeDeletedKeys()
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+
+		_, err = target.Get(localkms.Namespace, prefix.StorageKIDPrefix+keyID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Key archiver archives in the background", func(t *testing.T) {
+		env, clk, _, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID)
+
+		clk.Advance(24 * time.Hour)
+
+		archiver := NewKeyArchiver(env.cmd, 24*time.Hour, time.Millisecond)
+		archiver.Start()
+		defer archiver.Stop()
+
+		require.Eventually(t, func() bool {
+			return strings.HasPrefix(string(getKeyset(t, env, keyID)), "archived:")
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Keys are not archived without an archive", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		archived, err := env.cmd.ArchiveColdKeys(0)
+		require.NoError(t, err)
+		require.Zero(t, archived)
+	})
+
+	t.Run("Storage usage counts stubs of archived keys without recalling them", func(t *testing.T) {
+		env, clk, _, keyStoreID := newEnv(t)
+		keyID := createKey(t, env, keyStoreID)
+
+		meta, err := env.keyStores.Get(keyStoreID)
+		require.NoError(t, err)
+
+		usage, err := env.cmd.StorageUsage()
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{
+			keyStoreID: int64(len(meta) + len(getKeyset(t, env, keyID))),
+		}, usage)
+
+		clk.Advance(24 * time.Hour)
+
+		archived, err := env.cmd.ArchiveColdKeys(24 * time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 1, archived)
+
+		stub := getKeyset(t, env, keyID)
+
+		usage, err = env.cmd.StorageUsage()
+		require.NoError(t, err)
+		require.Equal(t, map[string]int64{keyStoreID: int64(len(meta) + len(stub))}, usage)
+		require.Equal(t, stub, getKeyset(t, env, keyID))
+	})
+}
+
+func TestCommand_ListKeys(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		var ksResp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		keyStoreID := strings.TrimPrefix(ksResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		var listResp ListKeysResponse
+
+		err = env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Empty(t, listResp.Keys)
+
+		var keyURLs []string
+
+		for _, req := range []CreateKeyRequest{
+			{KeyType: kms.ED25519Type, Alias: "signing"},
+			{KeyType: kms.AES256GCMType},
+		} {
+			var resp CreateKeyResponse
+
+			err = env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", req))
+			require.NoError(t, err)
+
+			keyURLs = append(keyURLs, resp.KeyURL)
+		}
+
+		err = env.cmd.ListKeys(encodeResponse(t, &listResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Len(t, listResp.Keys, 2)
+		require.Equal(t, uint64(2), listResp.Sequence)
+
+		require.Equal(t, keyURLs[0], listResp.Keys[0].KeyURL)
+		require.Equal(t, string(kms.ED25519Type), listResp.Keys[0].KeyType)
+		require.Equal(t, "signing", listResp.Keys[0].Alias)
+		require.Equal(t, KeyStateActive, listResp.Keys[0].State)
+		require.NotNil(t, listResp.Keys[0].CreatedAt)
+
+		require.Equal(t, keyURLs[1], listResp.Keys[1].KeyURL)
+		require.Equal(t, string(kms.AES256GCMType), listResp.Keys[1].KeyType)
+		require.Empty(t, listResp.Keys[1].Alias)
+	})
+
+	t.Run("Key store not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.ListKeys(nil, wrapKeyStoreRequest(t, "notfound", "", nil))
+		require.Error(t, err)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+// newKeyStoreEnv returns a command with ZCAPs enabled and separate local KMSs for the server and key stores.
+func newKeyStoreEnv(t *testing.T, opts ...configOption) *keyStoreEnv {
+	t.Helper()
+
+	ctrl := gomock.NewController(t)
+
+	serverStorageProvider := mem.NewProvider()
+	keyStorageProvider := mem.NewProvider()
+
+	serverKMS, err := localkms.New("local-lock://server", &kmsProvider{
+		storageProvider: serverStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	localKMS, err := localkms.New("local-lock://test", &kmsProvider{
+		storageProvider: keyStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	ecKMS, err := secp256k1.Wrap(localKMS, "local-lock://test", &kmsProvider{
+		storageProvider: keyStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	rsaKMS, err := rsapss.Wrap(ecKMS, "local-lock://test", &kmsProvider{
+		storageProvider: keyStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	userKMS, err := subkey.Wrap(rsaKMS, "local-lock://test", &kmsProvider{
+		storageProvider: keyStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	metrics := NewMockMetricsProvider(ctrl)
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+	recorder := &recordingKeyManager{KeyManager: userKMS}
+
+	creator := NewMockKeyStoreCreator(ctrl)
+	creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(recorder, nil).AnyTimes()
+
+	zcap := NewMockZCAPService(ctrl)
+	zcap.EXPECT().NewCapability(gomock.Any(), gomock.Any()).Return(&zcapld.Capability{}, nil).AnyTimes()
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	config := &Config{
+		StorageProvider:    serverStorageProvider,
+		KeyStorageProvider: keyStorageProvider,
+		KMS:                serverKMS,
+		Crypto:             cr,
+		KeyStoreCreator:    creator,
+		ZCAPService:        zcap,
+		EnableZCAPs:        true,
+		BaseKeyStoreURL:    "https://kms.example.com/v1/keystores",
+		MainKeyType:        kms.AES256GCMType,
+		MetricsProvider:    metrics,
+	}
+
+	for i := range opts {
+		opts[i](config)
+	}
+
+	cmd, err := New(config)
+	require.NoError(t, err)
+
+	keyStores, err := serverStorageProvider.OpenStore("keystores")
+	require.NoError(t, err)
+
+	return &keyStoreEnv{
+		cmd:           cmd,
+		keyStores:     keyStores,
+		zcap:          zcap,
+		userKMS:       userKMS,
+		serverKMS:     serverKMS,
+		recorder:      recorder,
+		serverStorage: serverStorageProvider,
+		keyStorage:    keyStorageProvider,
+	}
+}
+
+// recordingKeyManager records IDs of keys created by the key store.
+type recordingKeyManager struct {
+	kms.KeyManager
+	keyIDs []string
+}
+
+func (m *recordingKeyManager) Create(kt kms.KeyType) (string, interface{}, error) {
+	kid, kh, err := m.KeyManager.Create(kt)
+	if err == nil {
+		m.keyIDs = append(m.keyIDs, kid)
+	}
+
+	return kid, kh, err
+}
+
+func (e *keyStoreEnv) putKeyStore(t *testing.T, meta map[string]interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(meta)
+	require.NoError(t, err)
+
+	require.NoError(t, e.keyStores.Put(meta["id"].(string), b))
+}
+
+func (e *keyStoreEnv) getKeyStore(keyStoreID string) (map[string]interface{}, error) {
+	b, err := e.keyStores.Get(keyStoreID)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta map[string]interface{}
+
+	return meta, json.Unmarshal(b, &meta)
+}
+
+func wrapKeyStoreRequest(t *testing.T, keyStoreID, keyID string, req interface{}) io.Reader {
+	t.Helper()
+
+	b, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	wr, err := json.Marshal(WrappedRequest{
+		KeyStoreID: keyStoreID,
+		KeyID:      keyID,
+		Request:    b,
+	})
+	require.NoError(t, err)
+
+	return bytes.NewBuffer(wr)
+}
+
+// wrapRawKeyStoreRequest wraps a request given as JSON, e.g. one that doesn't decode into the request type.
+func wrapRawKeyStoreRequest(t *testing.T, keyStoreID, keyID, req string) io.Reader {
+	t.Helper()
+
+	wr, err := json.Marshal(WrappedRequest{
+		KeyStoreID: keyStoreID,
+		KeyID:      keyID,
+		Request:    []byte(req),
+	})
+	require.NoError(t, err)
+
+	return bytes.NewBuffer(wr)
+}
+
+func wrapCallerKeyStoreRequest(t *testing.T, keyStoreID, caller string, req interface{}) io.Reader {
+	t.Helper()
+
+	b, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	wr, err := json.Marshal(WrappedRequest{
+		KeyStoreID: keyStoreID,
+		Caller:     caller,
+		Request:    b,
+	})
+	require.NoError(t, err)
+
+	return bytes.NewBuffer(wr)
+}
+
+func wrapCallerRequest(t *testing.T, keyStoreID, caller string) io.Reader {
+	t.Helper()
+
+	wr, err := json.Marshal(WrappedRequest{
+		KeyStoreID: keyStoreID,
+		Caller:     caller,
+	})
+	require.NoError(t, err)
+
+	return bytes.NewBuffer(wr)
+}
+
+func TestCommand_CreateToken(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tokens := newOneTimeTokens(t)
+
+		cmd := createCmd(t, gomock.NewController(t), withOneTimeTokens(tokens))
+
+		var buf bytes.Buffer
+
+		err := cmd.CreateToken(&buf, wrapRequest(t, "key_id", CreateTokenRequest{
+			Action:    ActionVerify,
+			ExpiresIn: 60,
+		}))
+		require.NoError(t, err)
+
+		var resp CreateTokenResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+		require.NotEmpty(t, resp.Token)
+		require.NotEmpty(t, resp.ID)
+
+		token, err := tokens.Consume(resp.Token, "key_store_id", "key_id", ActionVerify)
+		require.NoError(t, err)
+		require.Equal(t, resp.ID, token.ID)
+	})
+
+	t.Run("Fail with one-time tokens not enabled", func(t *testing.T) {
+		cmd, err := New(&Config{StorageProvider: mockstorage.NewMockStoreProvider()})
+		require.NoError(t, err)
+
+		err = cmd.CreateToken(nil, wrapRequest(t, "key_id", CreateTokenRequest{Action: ActionVerify, ExpiresIn: 60}))
+		require.EqualError(t, err, "bad request: one-time tokens are not enabled")
+	})
+
+	t.Run("Fail with not supported action", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+			OneTimeTokens:   newOneTimeTokens(t),
+		})
+		require.NoError(t, err)
+
+		err = cmd.CreateToken(nil, wrapRequest(t, "key_id", CreateTokenRequest{Action: ActionSign, ExpiresIn: 60}))
+		require.EqualError(t, err, `validation failed: action "sign" can't be authorized with a one-time token`)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with invalid expiry", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+			OneTimeTokens:   newOneTimeTokens(t),
+		})
+		require.NoError(t, err)
+
+		for _, expiresIn := range []int64{0, -1, 24*60*60 + 1} {
+			err = cmd.CreateToken(nil, wrapRequest(t, "key_id", CreateTokenRequest{
+				Action:    ActionExportKey,
+				ExpiresIn: expiresIn,
+			}))
+			require.EqualError(t, err, "validation failed: expires_in must be between 1 and 86400 seconds")
+		}
+	})
+
+	t.Run("Fail with not found key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withOneTimeTokens(newOneTimeTokens(t)),
+			withKeyManager(&mockkms.KeyManager{GetKeyErr: storage.ErrDataNotFound}))
+
+		err := cmd.CreateToken(nil, wrapRequest(t, "key_id", CreateTokenRequest{Action: ActionVerify, ExpiresIn: 60}))
+		require.EqualError(t, err, "get key: not found: key key_id")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_CreateInvitation(t *testing.T) {
+	const mediatorURL = "https://mediator.example.com/invite"
+
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, kt kms.KeyType) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kt, Alias: "wallet-key"}))
+		require.NoError(t, err)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	decodeInvitation := func(t *testing.T, resp *CreateInvitationResponse) map[string]interface{} {
+		t.Helper()
+
+		u, err := url.Parse(resp.InvitationURL)
+		require.NoError(t, err)
+		require.Equal(t, "mediator.example.com", u.Host)
+
+		oob, err := base64.URLEncoding.DecodeString(u.Query().Get("oob"))
+		require.NoError(t, err)
+		require.JSONEq(t, string(resp.Invitation), string(oob))
+
+		var inv map[string]interface{}
+
+		require.NoError(t, json.Unmarshal(resp.Invitation, &inv))
+
+		return inv
+	}
+
+	t.Run("Success with public key", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withDIDCommMediatorURL(mediatorURL))
+		keyStoreID := createKeyStore(t, env)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type)
+
+		pub, _, err := env.userKMS.ExportPubKeyBytes(keyID)
+		require.NoError(t, err)
+
+		key, err := jwksupport.PubKeyBytesToJWK(pub, kms.ED25519Type)
+		require.NoError(t, err)
+
+		did, vm, err := fingerprint.CreateDIDKeyByJwk(key)
+		require.NoError(t, err)
+
+		var resp CreateInvitationResponse
+
+		err = env.cmd.CreateInvitation(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "wallet-key",
+			CreateInvitationRequest{Label: "KMS"}))
+		require.NoError(t, err)
+
+		inv := decodeInvitation(t, &resp)
+		require.Equal(t, "https://didcomm.org/out-of-band/1.0/invitation", inv["@type"])
+		require.Equal(t, "KMS", inv["label"])
+
+		services, ok := inv["services"].([]interface{})
+		require.True(t, ok)
+		require.Len(t, services, 1)
+		require.Equal(t, mediatorURL, services[0].(map[string]interface{})["serviceEndpoint"])
+		require.Equal(t, []interface{}{did}, services[0].(map[string]interface{})["recipientKeys"])
+
+		attachments, ok := inv["request~attach"].([]interface{})
+		require.True(t, ok)
+		require.Len(t, attachments, 1)
+		require.Equal(t, "public-key", attachments[0].(map[string]interface{})["@id"])
+		require.Equal(t, map[string]interface{}{
+			"json": map[string]interface{}{"did": did, "verification_method": vm},
+		}, attachments[0].(map[string]interface{})["data"])
+	})
+
+	t.Run("Success with sealed capability", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		env := newKeyStoreEnv(t, withDIDCommMediatorURL(mediatorURL), withCryptoBoxCreator(creator))
+		keyStoreID := createKeyStore(t, env)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type)
+
+		cryptoBox.EXPECT().Easy([]byte("capability"), gomock.Len(24), []byte("their pub"), keyID).
+			Return([]byte("sealed"), nil).Times(1)
+
+		var resp CreateInvitationResponse
+
+		err := env.cmd.CreateInvitation(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+			CreateInvitationRequest{Capability: []byte("capability"), TheirPub: []byte("their pub")}))
+		require.NoError(t, err)
+
+		inv := decodeInvitation(t, &resp)
+
+		attachments, ok := inv["request~attach"].([]interface{})
+		require.True(t, ok)
+		require.Len(t, attachments, 2)
+
+		capability := attachments[1].(map[string]interface{})
+		require.Equal(t, "capability", capability["@id"])
+
+		sealed := capability["data"].(map[string]interface{})["json"].(map[string]interface{})
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte("sealed")), sealed["ciphertext"])
+		require.NotEmpty(t, sealed["nonce"])
+		require.True(t, strings.HasPrefix(sealed["sender_key"].(string), "did:key:"))
+	})
+
+	t.Run("Fail with invitations not enabled", func(t *testing.T) {
+		cmd, err := New(&Config{StorageProvider: mockstorage.NewMockStoreProvider()})
+		require.NoError(t, err)
+
+		err = cmd.CreateInvitation(nil, wrapRequest(t, "key_id", CreateInvitationRequest{}))
+		require.EqualError(t, err, "bad request: DIDComm invitations are not enabled")
+	})
+
+	t.Run("Fail with capability without their pub", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider:    mockstorage.NewMockStoreProvider(),
+			DIDCommMediatorURL: mediatorURL,
+		})
+		require.NoError(t, err)
+
+		err = cmd.CreateInvitation(nil, wrapRequest(t, "key_id",
+			CreateInvitationRequest{Capability: []byte("capability")}))
+		require.EqualError(t, err, "validation failed: their_pub is required to attach a capability")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with not ED25519 key", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withDIDCommMediatorURL(mediatorURL))
+		keyStoreID := createKeyStore(t, env)
+		createKey(t, env, keyStoreID, kms.ECDSAP256TypeIEEEP1363)
+
+		err := env.cmd.CreateInvitation(nil, wrapKeyStoreRequest(t, keyStoreID, "wallet-key",
+			CreateInvitationRequest{}))
+		require.EqualError(t, err, "validation failed: invitations need a key of type ED25519")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with not found key", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withDIDCommMediatorURL(mediatorURL))
+		keyStoreID := createKeyStore(t, env)
+
+		err := env.cmd.CreateInvitation(nil, wrapKeyStoreRequest(t, keyStoreID, "unknown",
+			CreateInvitationRequest{}))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to seal capability", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().Easy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, errors.New("easy error")).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		env := newKeyStoreEnv(t, withDIDCommMediatorURL(mediatorURL), withCryptoBoxCreator(creator))
+		keyStoreID := createKeyStore(t, env)
+		keyID := createKey(t, env, keyStoreID, kms.ED25519Type)
+
+		err := env.cmd.CreateInvitation(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			CreateInvitationRequest{Capability: []byte("capability"), TheirPub: []byte("their pub")}))
+		require.EqualError(t, err, "easy: easy error")
+	})
+}
+
+func TestCommand_Sign(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			SignValue: []byte("signature"),
+		}))
+
+		req, err := json.Marshal(SignRequest{
+			Message: []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Sign(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp SignResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("signature"), resp.Signature)
+	})
+
+	t.Run("Retry with nonce returns the same signature", func(t *testing.T) {
+		nonces, err := signnonce.New(mem.NewProvider(), clock.Real(), time.Minute)
+		require.NoError(t, err)
+
+		ctrl := gomock.NewController(t)
+
+		// a retry is not counted as another signing
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).Times(2)
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withSignNonces(nonces), withMetricsProvider(metrics))
+
+		var createResp CreateKeyStoreResponse
+
+		err = env.cmd.CreateKeyStore(encodeResponse(t, &createResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		keyStoreID := strings.TrimPrefix(createResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		var createKeyResp CreateKeyResponse
+
+		// ECDSA signatures of the same message differ
+		err = env.cmd.CreateKey(encodeResponse(t, &createKeyResp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ECDSAP256TypeDER}))
+		require.NoError(t, err)
+
+		keyID := createKeyResp.KeyURL[strings.LastIndex(createKeyResp.KeyURL, "/")+1:]
+
+		sign := func(nonce, message string) (*SignResponse, error) {
+			var resp SignResponse
+
+			return &resp, env.cmd.Sign(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, keyID,
+				SignRequest{Message: []byte(message), Nonce: nonce}))
+		}
+
+		first, err := sign("nonce", "test message")
+		require.NoError(t, err)
+
+		retry, err := sign("nonce", "test message")
+		require.NoError(t, err)
+		require.Equal(t, first.Signature, retry.Signature)
+
+		other, err := sign("", "test message")
+		require.NoError(t, err)
+		require.NotEqual(t, first.Signature, other.Signature)
+
+		_, err = sign("nonce", "other message")
+		require.EqualError(t, err,
+			"unprocessable entity: nonce was already used to sign a different message")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Retry with nonce is not counted in usage reports", func(t *testing.T) {
+		nonces, err := signnonce.New(mem.NewProvider(), clock.Real(), time.Minute)
+		require.NoError(t, err)
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).Times(1)
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withSignNonces(nonces), withMetricsProvider(metrics))
+
+		var createResp CreateKeyStoreResponse
+
+		err = env.cmd.CreateKeyStore(encodeResponse(t, &createResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		keyStoreID := strings.TrimPrefix(createResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		var createKeyResp CreateKeyResponse
+
+		err = env.cmd.CreateKey(encodeResponse(t, &createKeyResp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ECDSAP256TypeDER}))
+		require.NoError(t, err)
+
+		keyID := createKeyResp.KeyURL[strings.LastIndex(createKeyResp.KeyURL, "/")+1:]
+
+		counter, err := usagereport.NewCounter(mem.NewProvider(),
+			testutil.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)), "instance")
+		require.NoError(t, err)
+
+		router := mux.NewRouter()
+		router.Handle("/v1/keystores/{keystoreID}/sign", counter.Middleware(ActionSign, "keystoreID")(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				require.NoError(t, env.cmd.Sign(w, wrapKeyStoreRequest(t, keyStoreID, keyID,
+					SignRequest{Message: []byte("test message"), Nonce: "nonce"})))
+			})))
+
+		for i := 0; i < 2; i++ {
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost,
+				"/v1/keystores/"+keyStoreID+"/sign", nil))
+		}
+
+		require.NoError(t, counter.Flush())
+
+		counts, err := counter.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{keyStoreID: {ActionSign: 1}}, counts)
+	})
+
+	t.Run("Fail to sign", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			SignErr: errors.New("sign error"),
+		}))
+
+		req, err := json.Marshal(SignRequest{
+			Message: []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Sign(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "sign: sign error")
+	})
+
+	t.Run("Sign canonicalized document", func(t *testing.T) {
+		var signed []byte
+
+		canonicalizer, err := canonicalization.New([]string{canonicalization.JCS}, nil)
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t), withCanonicalizer(canonicalizer),
+			withCrypto(&mockcrypto.Crypto{
+				SignFn: func(msg []byte, _ interface{}) ([]byte, error) {
+					signed = msg
+
+					return []byte("signature"), nil
+				},
+			}))
+
+		var resp SignResponse
+
+		err = cmd.Sign(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "key_id", SignRequest{
+			Document:         []byte(`{"b": 1, "a": "\u0041"}`),
+			Canonicalization: canonicalization.JCS,
+		}))
+		require.NoError(t, err)
+		require.Equal(t, []byte("signature"), resp.Signature)
+		require.Equal(t, canonicalization.JCS, resp.Canonicalization)
+
+		digest := sha256.Sum256([]byte(`{"a":"A","b":1}`))
+		require.Equal(t, digest[:], signed)
+	})
+
+	t.Run("Fail to canonicalize document", func(t *testing.T) {
+		canonicalizer, err := canonicalization.New([]string{canonicalization.JCS}, nil)
+		require.NoError(t, err)
+
+		tests := []struct {
+			name string
+			req  SignRequest
+			err  string
+		}{
+			{
+				name: "Profile is not enabled",
+				req:  SignRequest{Document: []byte(`{}`), Canonicalization: canonicalization.URDNA2015},
+				err:  `validation failed: canonicalization profile is not enabled: "urdna2015"`,
+			},
+			{
+				name: "Invalid document",
+				req:  SignRequest{Document: []byte(`{"a": 1, "a": 2}`), Canonicalization: canonicalization.JCS},
+				err:  `validation failed: invalid document: duplicate member "a"`,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cmd := createCmd(t, gomock.NewController(t), withCanonicalizer(canonicalizer),
+					withCrypto(&mockcrypto.Crypto{SignValue: []byte("signature")}))
+
+				err := cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id", tt.req))
+				require.EqualError(t, err, tt.err)
+				require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			})
+		}
+	})
+
+	t.Run("Fail with invalid canonicalization request", func(t *testing.T) {
+		canonicalizer, err := canonicalization.New([]string{canonicalization.JCS}, nil)
+		require.NoError(t, err)
+
+		tests := []struct {
+			name   string
+			config []configOption
+			req    SignRequest
+			err    string
+		}{
+			{
+				name: "Canonicalization is disabled",
+				req:  SignRequest{Document: []byte(`{}`), Canonicalization: canonicalization.JCS},
+				err:  "validation failed: canonicalization is disabled",
+			},
+			{
+				name:   "No document",
+				config: []configOption{withCanonicalizer(canonicalizer)},
+				req:    SignRequest{Message: []byte("test message"), Canonicalization: canonicalization.JCS},
+				err:    "validation failed: document is required with canonicalization",
+			},
+			{
+				name:   "No canonicalization",
+				config: []configOption{withCanonicalizer(canonicalizer)},
+				req:    SignRequest{Document: []byte(`{}`)},
+				err:    "validation failed: canonicalization is required with document",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				env := newKeyStoreEnv(t, tt.config...)
+
+				err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id", tt.req))
+				require.EqualError(t, err, tt.err)
+				require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			})
+		}
+	})
+}
+
+func TestCommand_SignDeterministic(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, kt kms.KeyType) string {
+		t.Helper()
+
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kt})))
+
+		return createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+	}
+
+	message := []byte("test message")
+
+	for _, kt := range []kms.KeyType{
+		kms.ECDSAP256TypeDER, kms.ECDSAP384TypeIEEEP1363, kms.ECDSAP521TypeDER,
+		secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363,
+	} {
+		kt := kt
+
+		t.Run("Sign deterministically with "+string(kt)+" key", func(t *testing.T) {
+			env := newEnv(t)
+			kid := createKey(t, env, kt)
+
+			var first, second SignResponse
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &first),
+				wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message, Deterministic: true})))
+			require.True(t, first.Deterministic)
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &second),
+				wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message, Deterministic: true})))
+			require.Equal(t, first.Signature, second.Signature)
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: first.Signature, Message: message})))
+		})
+	}
+
+	t.Run("Random nonces are not echoed as deterministic", func(t *testing.T) {
+		env := newEnv(t)
+		kid := createKey(t, env, kms.ECDSAP256TypeIEEEP1363)
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+		require.False(t, resp.Deterministic)
+	})
+
+	t.Run("Fail with key type that has no nonce", func(t *testing.T) {
+		env := newEnv(t)
+		kid := createKey(t, env, kms.ED25519Type)
+
+		err := env.cmd.Sign(nil,
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message, Deterministic: true}))
+		require.EqualError(t, err, "unprocessable entity: key "+kid+" of type ED25519 can't sign "+
+			"deterministically, an ecdsa key is required")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with messages", func(t *testing.T) {
+		env := newEnv(t)
+
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			SignRequest{Messages: [][]byte{message}, Deterministic: true}))
+		require.EqualError(t, err, "validation failed: messages can't be signed deterministically")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_SignEncoding(t *testing.T) {
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	var createResp CreateKeyResponse
+
+	require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+		wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+	kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+	message := []byte("test message")
+
+	sign := func(t *testing.T, encoding string) (string, []byte) {
+		t.Helper()
+
+		var buf bytes.Buffer
+
+		require.NoError(t, env.cmd.Sign(&buf, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: message, ResponseEncoding: encoding})))
+
+		var raw struct {
+			Signature string `json:"signature"`
+			Encoding  string `json:"encoding"`
+		}
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &raw))
+		require.Equal(t, encoding, raw.Encoding)
+
+		var resp SignResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &resp))
+
+		return raw.Signature, resp.Signature
+	}
+
+	for _, tc := range []struct {
+		encoding string
+		decode   func(t *testing.T, s string) []byte
+	}{
+		{encoding: "", decode: func(t *testing.T, s string) []byte {
+			b, err := base64.StdEncoding.DecodeString(s)
+			require.NoError(t, err)
+
+			return b
+		}},
+		{encoding: SignatureEncodingBase64URL, decode: func(t *testing.T, s string) []byte {
+			b, err := base64.RawURLEncoding.DecodeString(s)
+			require.NoError(t, err)
+
+			return b
+		}},
+		{encoding: SignatureEncodingBase58BTC, decode: func(t *testing.T, s string) []byte {
+			_, b, err := multibase.Decode("z" + s)
+			require.NoError(t, err)
+
+			return b
+		}},
+		{encoding: SignatureEncodingMultibase, decode: func(t *testing.T, s string) []byte {
+			base, b, err := multibase.Decode(s)
+			require.NoError(t, err)
+			require.Equal(t, multibase.Encoding(multibase.Base58BTC), base)
+
+			return b
+		}},
+	} {
+		tc := tc
+
+		t.Run("Sign and verify with encoding "+tc.encoding, func(t *testing.T) {
+			encoded, signature := sign(t, tc.encoding)
+			require.Equal(t, signature, tc.decode(t, encoded))
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				map[string]interface{}{"signature": encoded, "message": message, "signature_encoding": tc.encoding})))
+
+			// a Go client encodes the signature of the request the same way
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signature, Message: message, SignatureEncoding: tc.encoding})))
+
+			require.Error(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signature, Message: []byte("other"), SignatureEncoding: tc.encoding})))
+		})
+	}
+
+	t.Run("Verify multibase signature with any base", func(t *testing.T) {
+		_, signature := sign(t, "")
+
+		encoded, err := multibase.Encode(multibase.Base64url, signature)
+		require.NoError(t, err)
+
+		require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			map[string]interface{}{"signature": encoded, "message": message, "signature_encoding": "multibase"})))
+	})
+
+	t.Run("Fail with unsupported response encoding", func(t *testing.T) {
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: message, ResponseEncoding: "hex"}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), `unsupported signature encoding "hex"`)
+
+		err = env.cmd.Validate(ActionSign, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: message, ResponseEncoding: "hex"}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with unsupported signature encoding", func(t *testing.T) {
+		req := map[string]interface{}{"signature": "c2ln", "message": message, "signature_encoding": "hex"}
+
+		err := env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid, req))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.Validate(ActionVerify, wrapKeyStoreRequest(t, "key_store_id", kid, req))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with signature that doesn't decode", func(t *testing.T) {
+		for _, encoding := range []string{
+			SignatureEncodingBase64URL, SignatureEncodingBase58BTC, SignatureEncodingMultibase,
+		} {
+			err := env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				map[string]interface{}{"signature": "0OIl+/", "message": message, "signature_encoding": encoding}))
+			require.Error(t, err, encoding)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err), encoding)
+			require.Contains(t, err.Error(), "decode "+encoding+" signature", encoding)
+		}
+	})
+}
+
+func TestCommand_SignMessages(t *testing.T) {
+	t.Run("Sign and verify messages with BBS+", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 3)
+
+		kid, _, err := localKMS.Create(kms.BLS12381G2Type)
+		require.NoError(t, err)
+
+		messages := make([][]byte, 10)
+		for i := range messages {
+			messages[i] = []byte(fmt.Sprintf("test message %d", i))
+		}
+
+		var signResp SignResponse
+
+		err = cmd.Sign(encodeResponse(t, &signResp), wrapRequest(t, kid, SignRequest{Messages: messages}))
+		require.NoError(t, err)
+		require.NotEmpty(t, signResp.Signature)
+
+		err = cmd.Verify(nil, wrapRequest(t, kid, VerifyRequest{Signature: signResp.Signature, Messages: messages}))
+		require.NoError(t, err)
+
+		messages[0] = []byte("other message")
+
+		err = cmd.Verify(nil, wrapRequest(t, kid, VerifyRequest{Signature: signResp.Signature, Messages: messages}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "verify:")
+	})
+
+	t.Run("Fail with invalid request", func(t *testing.T) {
+		messages := [][]byte{[]byte("test message 1"), []byte("test message 2")}
+
+		tests := []struct {
+			name string
+			req  SignRequest
+		}{
+			{
+				name: "Message",
+				req:  SignRequest{Messages: messages, Message: []byte("test message")},
+			},
+			{
+				name: "Document",
+				req:  SignRequest{Messages: messages, Document: []byte(`{}`)},
+			},
+			{
+				name: "Canonicalization",
+				req:  SignRequest{Messages: messages, Canonicalization: canonicalization.JCS},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				env := newKeyStoreEnv(t)
+
+				err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id", tt.req))
+				require.EqualError(t, err, "validation failed: messages can't be combined with message or document")
+				require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			})
+		}
+
+		env := newKeyStoreEnv(t)
+
+		err := env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			VerifyRequest{Signature: []byte("signature"), Messages: messages, Message: []byte("test message")}))
+		require.EqualError(t, err, "validation failed: messages can't be combined with message")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to sign messages", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			BBSSignErr: errors.New("sign error"),
+		}))
+
+		err := cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			SignRequest{Messages: [][]byte{[]byte("test message")}}))
+		require.EqualError(t, err, "sign: sign error")
+	})
+}
+
+func TestCommand_SignPrehashed(t *testing.T) {
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	createKey := func(t *testing.T, kt kms.KeyType) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kt})))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	message := []byte("large artifact")
+
+	t.Run("Ed25519ph", func(t *testing.T) {
+		kid := createKey(t, kms.ED25519Type)
+		digest := sha512.Sum512(message)
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: digest[:], Prehashed: true})))
+		require.True(t, resp.Prehashed)
+		require.Equal(t, "Ed25519ph", resp.Algorithm)
+		require.NotEmpty(t, resp.Verification)
+
+		pub, _, err := env.userKMS.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		require.NoError(t, ed25519.VerifyWithOptions(pub, digest[:], resp.Signature,
+			&ed25519.Options{Hash: gocrypto.SHA512}))
+	})
+
+	t.Run("ECDSA digest verifies as a signature of the message", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+		digest := sha256.Sum256(message)
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: digest[:], Prehashed: true})))
+		require.Equal(t, "ECDSA-P256-SHA256", resp.Algorithm)
+
+		require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: resp.Signature, Message: message})))
+	})
+
+	t.Run("Fail with digest of wrong size", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP384TypeDER)
+		digest := sha256.Sum256(message)
+
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: digest[:], Prehashed: true}))
+		require.EqualError(t, err, "validation failed: ECDSA-P384-SHA384 signs 48-byte digests, got 32 bytes")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with key that can't sign digests", func(t *testing.T) {
+		kid := createKey(t, kms.HMACSHA256Tag256Type)
+
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: make([]byte, 64), Prehashed: true}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't sign a prehashed digest")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with invalid combination", func(t *testing.T) {
+		for _, req := range []SignRequest{
+			{Prehashed: true},
+			{Message: make([]byte, 64), Prehashed: true, Deterministic: true},
+			{Prehashed: true, Messages: [][]byte{[]byte("1")}},
+			{Prehashed: true, Document: json.RawMessage(`{}`), Canonicalization: "jcs"},
+		} {
+			err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id", req))
+			require.Error(t, err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		}
+	})
+}
+
+func TestCommand_SignUpload(t *testing.T) {
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+	uploads, err := upload.New(mem.NewProvider(), testutil.NewFakeClock(time.Now()), time.Hour)
+	require.NoError(t, err)
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics), withUploads(uploads))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	createKey := func(t *testing.T, kt kms.KeyType) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kt})))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	wrapUploadRequest := func(t *testing.T, keyID, uploadID string, req interface{}) io.Reader {
+		t.Helper()
+
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      keyID,
+			UploadID:   uploadID,
+			Request:    b,
+		})
+		require.NoError(t, err)
+
+		return bytes.NewBuffer(wr)
+	}
+
+	payload := []byte("large artifact")
+
+	createUpload := func(t *testing.T, keyID string) *CreateUploadResponse {
+		t.Helper()
+
+		var resp CreateUploadResponse
+
+		require.NoError(t, env.cmd.CreateUpload(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id",
+			keyID, CreateUploadRequest{Size: int64(len(payload)), ChunkSize: 4})))
+		require.NotEmpty(t, resp.UploadID)
+
+		return &resp
+	}
+
+	putChunk := func(t *testing.T, keyID, uploadID string, offset int64) error {
+		t.Helper()
+
+		end := offset + 4
+		if end > int64(len(payload)) {
+			end = int64(len(payload))
+		}
+
+		chunk := payload[offset:end]
+		sum := sha256.Sum256(chunk)
+
+		return env.cmd.PutUploadChunk(nil, wrapUploadRequest(t, keyID, uploadID,
+			PutUploadChunkRequest{Offset: offset, Chunk: chunk, Checksum: sum[:]}))
+	}
+
+	putChunks := func(t *testing.T, keyID, uploadID string, offsets ...int64) {
+		t.Helper()
+
+		for _, offset := range offsets {
+			require.NoError(t, putChunk(t, keyID, uploadID, offset))
+		}
+	}
+
+	t.Run("ECDSA signature verifies as a signature of the payload", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP384TypeDER)
+
+		u := createUpload(t, kid)
+		require.Equal(t, "ECDSA-P384-SHA384", u.Algorithm)
+
+		// out of order, with a retried chunk
+		putChunks(t, kid, u.UploadID, 12, 4, 0, 4, 8)
+
+		var resp SignUploadResponse
+
+		require.NoError(t, env.cmd.SignUpload(encodeResponse(t, &resp), wrapUploadRequest(t, kid, u.UploadID, nil)))
+		require.Equal(t, int64(len(payload)), resp.Size)
+		require.Equal(t, "ECDSA-P384-SHA384", resp.Algorithm)
+
+		require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: resp.Signature, Message: payload})))
+
+		// the upload is deleted once it's signed
+		err := env.cmd.SignUpload(nil, wrapUploadRequest(t, kid, u.UploadID, nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Ed25519ph", func(t *testing.T) {
+		kid := createKey(t, kms.ED25519Type)
+
+		u := createUpload(t, kid)
+		require.Equal(t, "Ed25519ph", u.Algorithm)
+
+		putChunks(t, kid, u.UploadID, 0, 4, 8, 12)
+
+		var resp SignUploadResponse
+
+		require.NoError(t, env.cmd.SignUpload(encodeResponse(t, &resp), wrapUploadRequest(t, kid, u.UploadID, nil)))
+
+		pub, _, err := env.userKMS.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		digest := sha512.Sum512(payload)
+
+		require.NoError(t, ed25519.VerifyWithOptions(pub, digest[:], resp.Signature,
+			&ed25519.Options{Hash: gocrypto.SHA512}))
+	})
+
+	t.Run("Fail with missing chunk", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+
+		u := createUpload(t, kid)
+		putChunks(t, kid, u.UploadID, 0, 8, 12)
+
+		err := env.cmd.SignUpload(nil, wrapUploadRequest(t, kid, u.UploadID, nil))
+		require.EqualError(t, err, "conflict: upload is incomplete: chunk at offset 4 is missing")
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with different chunk at the same offset", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+
+		u := createUpload(t, kid)
+		putChunks(t, kid, u.UploadID, 0)
+
+		chunk := []byte("LARG")
+		sum := sha256.Sum256(chunk)
+
+		err := env.cmd.PutUploadChunk(nil, wrapUploadRequest(t, kid, u.UploadID,
+			PutUploadChunkRequest{Offset: 0, Chunk: chunk, Checksum: sum[:]}))
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with invalid chunk", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+
+		u := createUpload(t, kid)
+
+		err := env.cmd.PutUploadChunk(nil, wrapUploadRequest(t, kid, u.UploadID,
+			PutUploadChunkRequest{Offset: 0, Chunk: payload[:4], Checksum: make([]byte, sha256.Size)}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "checksum of chunk at offset 0 doesn't match")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with upload of another key", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+		other := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+
+		u := createUpload(t, kid)
+
+		err := putChunk(t, other, u.UploadID, 0)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+
+		err = env.cmd.SignUpload(nil, wrapUploadRequest(t, other, u.UploadID, nil))
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with invalid size", func(t *testing.T) {
+		kid := createKey(t, kms.ECDSAP256TypeIEEEP1363)
+
+		err := env.cmd.CreateUpload(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			CreateUploadRequest{Size: 10, ChunkSize: upload.MaxChunkSize + 1}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with key that can't sign uploads", func(t *testing.T) {
+		kid := createKey(t, kms.HMACSHA256Tag256Type)
+
+		err := env.cmd.CreateUpload(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			CreateUploadRequest{Size: 10, ChunkSize: 4}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't sign an upload")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with uploads disabled", func(t *testing.T) {
+		disabled := newKeyStoreEnv(t)
+
+		err := disabled.cmd.CreateUpload(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			CreateUploadRequest{Size: 10, ChunkSize: 4}))
+		require.EqualError(t, err, "validation failed: chunked uploads are disabled")
+
+		err = disabled.cmd.SignUpload(nil, wrapUploadRequest(t, "key_id", "upload_id", nil))
+		require.EqualError(t, err, "validation failed: chunked uploads are disabled")
+	})
+}
+
+func TestCommand_SignatureDetails(t *testing.T) {
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	wrapDetails := func(t *testing.T, keyID string, req interface{}) io.Reader {
+		t.Helper()
+
+		b, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: keyID, Details: true, Request: b})
+		require.NoError(t, err)
+
+		return bytes.NewBuffer(wr)
+	}
+
+	message := []byte("credential")
+
+	for _, tc := range []struct {
+		kt        kms.KeyType
+		algorithm string
+		joseAlg   string
+		coseAlg   int
+	}{
+		{kms.ED25519Type, "EdDSA", "EdDSA", -8},
+		{kms.ECDSAP256TypeDER, "ECDSA", "ES256", -7},
+		{kms.ECDSAP384TypeIEEEP1363, "ECDSA", "ES384", -35},
+		{kms.ECDSAP521TypeDER, "ECDSA", "ES512", -36},
+		{secp256k1.KeyTypeIEEEP1363, "ECDSA", "ES256K", -47},
+		{rsapss.KeyType, "RSASSA-PSS", "PS256", -37},
+	} {
+		tc := tc
+
+		t.Run(string(tc.kt), func(t *testing.T) {
+			var createResp CreateKeyResponse
+
+			require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+				wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: tc.kt})))
+
+			kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+			var signResp SignResponse
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp), wrapDetails(t, kid,
+				SignRequest{Message: message})))
+
+			details := signResp.Details
+			require.NotNil(t, details)
+			require.Equal(t, tc.algorithm, details.Algorithm)
+			require.Equal(t, tc.joseAlg, details.JOSEAlgorithm)
+			require.Equal(t, tc.coseAlg, details.COSEAlgorithm)
+			require.Equal(t, "base64", details.Encoding)
+
+			// the signature verifies outside the crypto under the stated parameters
+			verifyWithDetails(t, details, createResp.PublicKey, tc.kt, message, signResp.Signature)
+
+			var verifyResp VerifyResponse
+
+			require.NoError(t, env.cmd.Verify(encodeResponse(t, &verifyResp), wrapDetails(t, kid,
+				VerifyRequest{Signature: signResp.Signature, Message: message})))
+			require.True(t, verifyResp.Verified)
+			require.Equal(t, details, verifyResp.Details)
+
+			verifyResp = VerifyResponse{}
+
+			require.NoError(t, env.cmd.Verify(encodeResponse(t, &verifyResp), wrapDetails(t, "",
+				VerifyRequest{Signature: signResp.Signature, Message: message, PublicKey: createResp.PublicKey,
+					KeyType: tc.kt})))
+			require.True(t, verifyResp.Verified)
+			require.Equal(t, details.JOSEAlgorithm, verifyResp.Details.JOSEAlgorithm)
+			require.Equal(t, details.SignatureFormat, verifyResp.Details.SignatureFormat)
+		})
+	}
+
+	t.Run("Response encoding", func(t *testing.T) {
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+		kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp), wrapDetails(t, kid,
+			SignRequest{Message: message, ResponseEncoding: SignatureEncodingMultibase})))
+		require.Equal(t, SignatureEncodingMultibase, resp.Details.Encoding)
+	})
+
+	t.Run("Ed25519ph has no JOSE or COSE algorithm", func(t *testing.T) {
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+		kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+		digest := sha512.Sum512(message)
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp), wrapDetails(t, kid,
+			SignRequest{Message: digest[:], Prehashed: true})))
+		require.Equal(t, "Ed25519ph", resp.Details.Algorithm)
+		require.Empty(t, resp.Details.JOSEAlgorithm)
+		require.Zero(t, resp.Details.COSEAlgorithm)
+		require.NoError(t, ed25519.VerifyWithOptions(createResp.PublicKey, digest[:], resp.Signature,
+			&ed25519.Options{Hash: gocrypto.SHA512}))
+	})
+
+	t.Run("No details unless requested", func(t *testing.T) {
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+		kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: message})))
+		require.Nil(t, resp.Details)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, env.cmd.Verify(&buf, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: resp.Signature, Message: message})))
+		require.Zero(t, buf.Len())
+	})
+}
+
+// verifyWithDetails verifies the signature with the standard library as stated by the details, without the crypto.
+func verifyWithDetails(t *testing.T, details *SignatureDetails, pub []byte, kt kms.KeyType, msg, sig []byte) {
+	t.Helper()
+
+	if details.OutputPrefix == "TINK" {
+		sig = sig[5:]
+	}
+
+	hashes := map[string]gocrypto.Hash{"SHA-256": gocrypto.SHA256, "SHA-384": gocrypto.SHA384,
+		"SHA-512": gocrypto.SHA512}
+
+	switch details.Algorithm {
+	case "EdDSA":
+		require.Equal(t, "Ed25519", details.Curve)
+		require.True(t, ed25519.Verify(pub, msg, sig))
+	case "RSASSA-PSS":
+		key, err := rsapss.ParsePublicKey(pub)
+		require.NoError(t, err)
+
+		h := hashes[details.Hash].New()
+		h.Write(msg)
+
+		require.NoError(t, rsa.VerifyPSS(key, hashes[details.Hash], h.Sum(nil), sig,
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}))
+	case "ECDSA":
+		var key *ecdsa.PublicKey
+
+		switch details.Curve {
+		case "secp256k1":
+			var err error
+
+			key, err = secp256k1.ParsePublicKey(pub, kt)
+			require.NoError(t, err)
+		default:
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(),
+				"P-521": elliptic.P521()}
+
+			if k, err := x509.ParsePKIXPublicKey(pub); err == nil {
+				key = k.(*ecdsa.PublicKey)
+			} else {
+				x, y := elliptic.Unmarshal(curves[details.Curve], pub) //nolint:staticcheck
+				require.NotNil(t, x)
+
+				key = &ecdsa.PublicKey{Curve: curves[details.Curve], X: x, Y: y}
+			}
+		}
+
+		h := hashes[details.Hash].New()
+		h.Write(msg)
+		digest := h.Sum(nil)
+
+		switch details.SignatureFormat {
+		case "DER":
+			require.True(t, ecdsa.VerifyASN1(key, digest, sig))
+		case "IEEE_P1363":
+			r, s := new(big.Int).SetBytes(sig[:len(sig)/2]), new(big.Int).SetBytes(sig[len(sig)/2:])
+			require.True(t, ecdsa.Verify(key, digest, r, s))
+		default:
+			t.Fatalf("unexpected signature format %q", details.SignatureFormat)
+		}
+	default:
+		t.Fatalf("unexpected algorithm %q", details.Algorithm)
+	}
+}
+
+func TestCommand_SignBatch(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t), withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}))
+
+		messages := [][]byte{[]byte("message 1"), []byte("message 2"), []byte("message 3")}
+
+		var resp SignBatchResponse
+
+		err = cmd.SignBatch(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			SignBatchRequest{Messages: messages}))
+		require.NoError(t, err)
+		require.Len(t, resp.Signatures, len(messages))
+
+		pub, err := kh.Public()
+		require.NoError(t, err)
+
+		cr, err := tinkcrypto.New()
+		require.NoError(t, err)
+
+		for i, message := range messages {
+			require.NoError(t, cr.Verify(resp.Signatures[i], message, pub))
+		}
+	})
+
+	t.Run("Fail with invalid number of messages", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withMaxSignBatchSize(2))
+
+		for _, messages := range [][][]byte{nil, {[]byte("1"), []byte("2"), []byte("3")}} {
+			err := env.cmd.SignBatch(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+				SignBatchRequest{Messages: messages}))
+			require.EqualError(t, err, "validation failed: number of messages must be from 1 to 2")
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		}
+	})
+
+	t.Run("Fail to sign", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			SignErr: errors.New("sign error"),
+		}))
+
+		err := cmd.SignBatch(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			SignBatchRequest{Messages: [][]byte{[]byte("test message")}}))
+		require.EqualError(t, err, "sign message 0: sign error")
+	})
+}
+
+func TestCommand_SignMultiKey(t *testing.T) {
+	newEnv := func(t *testing.T, signs int, opts ...configOption) (*keyStoreEnv, string) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).Times(signs)
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, append(opts, withMetricsProvider(metrics))...)
+
+		var resp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+
+		return env, strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string, req CreateKeyRequest) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "", req)))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		env, keyStoreID := newEnv(t, 3)
+
+		key1 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+		key2 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ECDSAP256TypeIEEEP1363, Alias: "issuer"})
+
+		items := []SignMultiKeyItem{
+			{KeyID: key1, Message: []byte("credential 1")},
+			{KeyID: "issuer", Message: []byte("credential 2")},
+			{KeyID: key1, Message: []byte("credential 3")},
+		}
+
+		var resp SignMultiKeyResponse
+
+		err := env.cmd.SignMultiKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, keyStoreID, "",
+			SignMultiKeyRequest{Items: items}))
+		require.NoError(t, err)
+		require.Len(t, resp.Signatures, len(items))
+
+		cr, err := tinkcrypto.New()
+		require.NoError(t, err)
+
+		for i, keyID := range []string{key1, key2, key1} {
+			kh, getErr := env.userKMS.Get(keyID)
+			require.NoError(t, getErr)
+
+			pub, pubErr := kh.(*keyset.Handle).Public()
+			require.NoError(t, pubErr)
+
+			require.NoError(t, cr.Verify(resp.Signatures[i], items[i].Message, pub))
+		}
+	})
+
+	t.Run("Fail without signing if a key is disabled", func(t *testing.T) {
+		env, keyStoreID := newEnv(t, 0)
+
+		key1 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+		key2 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+
+		require.NoError(t, env.cmd.SetKeyState(&bytes.Buffer{}, wrapKeyStoreRequest(t, keyStoreID, key2,
+			SetKeyStateRequest{State: KeyStateDisabled})))
+
+		err := env.cmd.SignMultiKey(nil, wrapKeyStoreRequest(t, keyStoreID, "", SignMultiKeyRequest{
+			Items: []SignMultiKeyItem{{KeyID: key1, Message: []byte("1")}, {KeyID: key2, Message: []byte("2")}},
+		}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "item 1: ")
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail without signing if a key is missing", func(t *testing.T) {
+		env, keyStoreID := newEnv(t, 0)
+
+		key1 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+
+		err := env.cmd.SignMultiKey(nil, wrapKeyStoreRequest(t, keyStoreID, "", SignMultiKeyRequest{
+			Items: []SignMultiKeyItem{{KeyID: key1, Message: []byte("1")}, {KeyID: "missing", Message: []byte("2")}},
+		}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "item 1: ")
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail without signing if a key isn't allowed to sign", func(t *testing.T) {
+		env, keyStoreID := newEnv(t, 0)
+
+		key1 := createKey(t, env, keyStoreID, CreateKeyRequest{KeyType: kms.ED25519Type})
+		key2 := createKey(t, env, keyStoreID, CreateKeyRequest{
+			KeyType:  kms.ED25519Type,
+			Purposes: []KeyPurpose{KeyPurposeVerify},
+		})
+
+		err := env.cmd.SignMultiKey(nil, wrapKeyStoreRequest(t, keyStoreID, "", SignMultiKeyRequest{
+			Items: []SignMultiKeyItem{{KeyID: key1, Message: []byte("1")}, {KeyID: key2, Message: []byte("2")}},
+		}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "item 1: ")
+	})
+
+	t.Run("Fail with invalid items", func(t *testing.T) {
+		env, keyStoreID := newEnv(t, 0, withMaxSignBatchSize(2), func(c *Config) {
+			c.MaxSignMultiKeyMessageSize = 4
+			c.MaxSignMultiKeyTotalSize = 6
+		})
+
+		for _, tc := range []struct {
+			items []SignMultiKeyItem
+			err   string
+		}{
+			{nil, "number of items must be from 1 to 2"},
+			{
+				[]SignMultiKeyItem{{KeyID: "a"}, {KeyID: "b"}, {KeyID: "c"}},
+				"number of items must be from 1 to 2",
+			},
+			{[]SignMultiKeyItem{{Message: []byte("1")}}, "item 0: key id must be non-empty"},
+			{
+				[]SignMultiKeyItem{{KeyID: "a", Message: []byte("1")}, {KeyID: "b", Message: []byte("12345")}},
+				"item 1: message exceeds 4 bytes",
+			},
+			{
+				[]SignMultiKeyItem{{KeyID: "a", Message: []byte("1234")}, {KeyID: "b", Message: []byte("123")}},
+				"messages exceed 6 bytes in total",
+			},
+		} {
+			err := env.cmd.SignMultiKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+				SignMultiKeyRequest{Items: tc.items}))
+			require.EqualError(t, err, "validation failed: "+tc.err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		}
+	})
+}
+
+func TestCommand_Verify(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t),
+			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
+			withCrypto(&mockcrypto.Crypto{}),
+		)
+
+		req, err := json.Marshal(VerifyRequest{
+			Signature: []byte("signature"),
+			Message:   []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.Verify(nil, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to get public key from handle", func(t *testing.T) {
+		badKH, err := keyset.NewHandle(aead.KMSEnvelopeAEADKeyTemplate("badUrl", nil))
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t),
+			withKeyManager(&mockkms.KeyManager{GetKeyValue: badKH}),
+			withCrypto(&mockcrypto.Crypto{}),
+		)
+
+		req, err := json.Marshal(VerifyRequest{
+			Signature: []byte("signature"),
+			Message:   []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.Verify(nil, bytes.NewBuffer(wr))
+		require.Error(t, err)
+	})
+
+	t.Run("Fail to verify", func(t *testing.T) {
+		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t),
+			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
+			withCrypto(&mockcrypto.Crypto{VerifyErr: errors.New("verify error")}),
+		)
+
+		req, err := json.Marshal(VerifyRequest{
+			Signature: []byte("signature"),
+			Message:   []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.Verify(nil, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "verify: verify error")
+	})
+}
+
+func TestCommand_VerifyWithCache(t *testing.T) {
+	kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+	require.NoError(t, err)
+
+	storageProvider := func(t *testing.T, verifyCache bool, sequence uint64) storage.Provider {
+		t.Helper()
+
+		b, err := json.Marshal(map[string]interface{}{
+			"id":           "key_store_id",
+			"controller":   "controller",
+			"verify_cache": verifyCache,
+			"sequence":     sequence,
+		})
+		require.NoError(t, err)
+
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: b}
+
+		return p
+	}
+
+	verify := func(t *testing.T, cmd *Command) error {
+		t.Helper()
+
+		req, err := json.Marshal(VerifyRequest{
+			Signature: []byte("signature"),
+			Message:   []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		return cmd.Verify(nil, bytes.NewBuffer(wr))
+	}
+
+	// every verification resolves the key store, including ones with cached results
+	newCmd := func(t *testing.T, p storage.Provider, cr crypto.Crypto, vc *verifycache.VerifyCache,
+		resolveErrs ...error) *Command {
+		t.Helper()
+
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		creator := NewMockKeyStoreCreator(ctrl)
+
+		for _, resolveErr := range resolveErrs {
+			var km kms.KeyManager
+
+			if resolveErr == nil {
+				km = &mockkms.KeyManager{GetKeyValue: kh}
+			}
+
+			creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(km, resolveErr)
+		}
+
+		cmd, err := New(&Config{
+			StorageProvider: p,
+			KMS:             &mockkms.KeyManager{},
+			Crypto:          cr,
+			MetricsProvider: metrics,
+			KeyStoreCreator: creator,
+			VerifyCache:     vc,
+		})
+		require.NoError(t, err)
+
+		return cmd
+	}
+
+	t.Run("Success with cached result", func(t *testing.T) {
+		cr := &countingCrypto{}
+		vc := verifycache.New(newMapCache(), time.Minute)
+
+		cmd := newCmd(t, storageProvider(t, true, 1), cr, vc, nil, nil)
+
+		require.NoError(t, verify(t, cmd))
+		require.NoError(t, verify(t, cmd))
+		require.Equal(t, 1, cr.verifyCalls)
+
+		// key store mutation (e.g. key rotation) changes key version
+		cmd = newCmd(t, storageProvider(t, true, 2), cr, vc, nil)
+
+		require.NoError(t, verify(t, cmd))
+		require.Equal(t, 2, cr.verifyCalls)
+	})
+
+	t.Run("Fail with cached result", func(t *testing.T) {
+		cr := &countingCrypto{Crypto: mockcrypto.Crypto{VerifyErr: errors.New("verify error")}}
+
+		cmd := newCmd(t, storageProvider(t, true, 1), cr, verifycache.New(newMapCache(), time.Minute), nil, nil)
+
+		require.EqualError(t, verify(t, cmd), "verify: verify error")
+		require.EqualError(t, verify(t, cmd), "verify: invalid signature (cached result)")
+		require.Equal(t, 1, cr.verifyCalls)
+	})
+
+	t.Run("Cached result is not returned if the key store can't be resolved", func(t *testing.T) {
+		cr := &countingCrypto{}
+
+		cmd := newCmd(t, storageProvider(t, true, 1), cr, verifycache.New(newMapCache(), time.Minute),
+			nil, errors.New("resolve error"))
+
+		require.NoError(t, verify(t, cmd))
+
+		err := verify(t, cmd)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "resolve error")
+		require.Equal(t, 1, cr.verifyCalls)
+	})
+
+	t.Run("Key store without verify cache", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		metrics := NewMockMetricsProvider(ctrl)
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		creator := NewMockKeyStoreCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(&mockkms.KeyManager{GetKeyValue: kh}, nil).Times(2)
+
+		cr := &countingCrypto{}
+
+		cmd, err := New(&Config{
+			StorageProvider: storageProvider(t, false, 1),
+			KMS:             &mockkms.KeyManager{},
+			Crypto:          cr,
+			MetricsProvider: metrics,
+			KeyStoreCreator: creator,
+			VerifyCache:     verifycache.New(newMapCache(), time.Minute),
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, verify(t, cmd))
+		require.NoError(t, verify(t, cmd))
+		require.Equal(t, 2, cr.verifyCalls)
+	})
+
+	t.Run("Fail to get key store meta", func(t *testing.T) {
+		cmd, err := New(&Config{
+			StorageProvider: mockstorage.NewMockStoreProvider(),
+			VerifyCache:     verifycache.New(newMapCache(), time.Minute),
+		})
+		require.NoError(t, err)
+
+		err = verify(t, cmd)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "resolve key store")
+	})
+}
+
+type countingCrypto struct {
+	mockcrypto.Crypto
+	verifyCalls int
+}
+
+func (c *countingCrypto) Verify(signature, msg []byte, kh interface{}) error {
+	c.verifyCalls++
+
+	return c.Crypto.Verify(signature, msg, kh)
+}
+
+type mapCache struct {
+	items map[interface{}]interface{}
+}
+
+func newMapCache() *mapCache {
+	return &mapCache{items: map[interface{}]interface{}{}}
+}
+
+func (c *mapCache) Get(key interface{}) (interface{}, bool) {
+	v, ok := c.items[key]
+
+	return v, ok
+}
+
+func (c *mapCache) SetWithTTL(key, value interface{}, _ int64, _ time.Duration) bool {
+	c.items[key] = value
+
+	return true
+}
+
+func TestCommand_Encrypt(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			EncryptValue:      []byte("ciphertext"),
+			EncryptNonceValue: []byte("nonce"),
+		}))
+
+		req, err := json.Marshal(EncryptRequest{
+			Message: []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Encrypt(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp EncryptResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ciphertext"), resp.Ciphertext)
+		require.Equal(t, []byte("nonce"), resp.Nonce)
+	})
+
+	t.Run("Fail to encrypt", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			EncryptErr: errors.New("encrypt error"),
+		}))
+
+		req, err := json.Marshal(EncryptRequest{
+			Message: []byte("test message"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Encrypt(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "encrypt: encrypt error")
+	})
+}
+
+func TestCommand_EncryptCallerNonce(t *testing.T) {
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics),
+		withCallerNonces(aesgcm.NewReuseDetector(clock.Real(), time.Hour)))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	createKey := func(t *testing.T, req CreateKeyRequest) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "", req)))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	nonce := make([]byte, aesgcm.NonceSize)
+
+	t.Run("Key size selects the AES-GCM key type", func(t *testing.T) {
+		kid := createKey(t, CreateKeyRequest{KeyType: kms.AES256GCMType, KeySize: 128})
+
+		var resp GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid, nil)))
+		require.Equal(t, string(kms.AES128GCMType), resp.KeyType)
+
+		err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeyRequest{KeyType: kms.AES128GCMType, KeySize: 192}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "key_size of AES-GCM keys must be 128 or 256")
+	})
+
+	t.Run("Server-generated nonce", func(t *testing.T) {
+		kid := createKey(t, CreateKeyRequest{KeyType: kms.AES256GCMType})
+
+		var resp EncryptResponse
+
+		require.NoError(t, env.cmd.Encrypt(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: []byte("content")})))
+		require.Len(t, resp.Nonce, aesgcm.NonceSize)
+	})
+
+	t.Run("Caller nonce", func(t *testing.T) {
+		kid := createKey(t, CreateKeyRequest{KeyType: kms.AES256GCMType})
+
+		var resp EncryptResponse
+
+		require.NoError(t, env.cmd.Encrypt(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: []byte("content"), AssociatedData: []byte("aad"), Nonce: nonce})))
+		require.Equal(t, nonce, resp.Nonce)
+
+		var decryptResp DecryptResponse
+
+		require.NoError(t, env.cmd.Decrypt(encodeResponse(t, &decryptResp), wrapKeyStoreRequest(t, "key_store_id",
+			kid, DecryptRequest{Ciphertext: resp.Ciphertext, AssociatedData: []byte("aad"), Nonce: resp.Nonce})))
+		require.Equal(t, []byte("content"), decryptResp.Plaintext)
+
+		// a reused nonce is logged, not refused
+		require.NoError(t, env.cmd.Encrypt(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: []byte("content"), Nonce: nonce})))
+	})
+
+	t.Run("Fail with caller nonce of invalid size", func(t *testing.T) {
+		kid := createKey(t, CreateKeyRequest{KeyType: kms.AES256GCMType})
+
+		err := env.cmd.Encrypt(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: []byte("content"), Nonce: []byte("nonce")}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "nonce must be 12 bytes")
+	})
+
+	t.Run("Fail with caller nonce for a key that isn't AES-GCM", func(t *testing.T) {
+		kid := createKey(t, CreateKeyRequest{KeyType: kms.ChaCha20Poly1305Type})
+
+		err := env.cmd.Encrypt(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: []byte("content"), Nonce: nonce}))
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "an AES-GCM key is required")
+	})
+
+	t.Run("Fail with caller nonce if caller nonces are not enabled", func(t *testing.T) {
+		disabled := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		err := disabled.cmd.Encrypt(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			EncryptRequest{Message: []byte("content"), Nonce: nonce}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "caller nonces are not enabled")
+	})
+}
+
+func TestCommand_ChaCha20Poly1305(t *testing.T) {
+	// RFC 8439 section 2.8.2 and its XChaCha20-Poly1305 counterpart (draft-irtf-cfrg-xchacha section A.3.1)
+	key := decodeHex(t, "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, " +
+		"sunscreen would be it.")
+	aad := decodeHex(t, "50515253c0c1c2c3c4c5c6c7")
+
+	tests := []struct {
+		keyType    kms.KeyType
+		nonce      string
+		ciphertext string
+		newAEAD    func([]byte) (gocipher.AEAD, error)
+	}{
+		{
+			keyType: kms.ChaCha20Poly1305Type,
+			nonce:   "070000004041424344454647",
+			ciphertext: "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b" +
+				"1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4" +
+				"def08e4b7a9de576d26586cec64b61161ae10b594f09e26a7e902ecbd0600691",
+			newAEAD: chacha20poly1305.New,
+		},
+		{
+			keyType: kms.XChaCha20Poly1305Type,
+			nonce:   "404142434445464748494a4b4c4d4e4f5051525354555657",
+			ciphertext: "bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39" +
+				"ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9" +
+				"664c97637da9768812f615c68b13b52ec0875924c1c7987947deafd8780acf49",
+			newAEAD: chacha20poly1305.NewX,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(string(tt.keyType)+" interop vector", func(t *testing.T) {
+			km := &mockkms.KeyManager{GetKeyValue: chaChaKeyset(t, tt.keyType, key)}
+			cmd := createCmd(t, gomock.NewController(t), withKeyManager(km))
+
+			nonce := decodeHex(t, tt.nonce)
+
+			var decryptResp DecryptResponse
+
+			require.NoError(t, cmd.Decrypt(encodeResponse(t, &decryptResp), wrapKeyStoreRequest(t, "key_store_id",
+				"key_id", DecryptRequest{Ciphertext: decodeHex(t, tt.ciphertext), AssociatedData: aad, Nonce: nonce})))
+			require.Equal(t, plaintext, decryptResp.Plaintext)
+
+			// ciphertexts of the KMS open with other implementations
+			var encryptResp EncryptResponse
+
+			cmd = createCmd(t, gomock.NewController(t), withKeyManager(km))
+
+			require.NoError(t, cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, "key_store_id",
+				"key_id", EncryptRequest{Message: plaintext, AssociatedData: aad})))
+			require.Len(t, encryptResp.Nonce, len(nonce))
+
+			a, err := tt.newAEAD(key)
+			require.NoError(t, err)
+
+			opened, err := a.Open(nil, encryptResp.Nonce, encryptResp.Ciphertext, aad)
+			require.NoError(t, err)
+			require.Equal(t, plaintext, opened)
+		})
+	}
+
+	metrics := NewMockMetricsProvider(gomock.NewController(t))
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+
+	env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+	env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+	var createResp CreateKeyResponse
+
+	require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp), wrapKeyStoreRequest(t, "key_store_id", "",
+		CreateKeyRequest{KeyType: kms.XChaCha20Poly1305Type})))
+
+	kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+	t.Run("XChaCha20Poly1305 key of a key store", func(t *testing.T) {
+		var encryptResp EncryptResponse
+
+		require.NoError(t, env.cmd.Encrypt(encodeResponse(t, &encryptResp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EncryptRequest{Message: plaintext, AssociatedData: aad})))
+		require.Len(t, encryptResp.Nonce, chacha20poly1305.NonceSizeX)
+
+		var decryptResp DecryptResponse
+
+		require.NoError(t, env.cmd.Decrypt(encodeResponse(t, &decryptResp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			DecryptRequest{Ciphertext: encryptResp.Ciphertext, AssociatedData: aad, Nonce: encryptResp.Nonce})))
+		require.Equal(t, plaintext, decryptResp.Plaintext)
+
+		err := env.cmd.Decrypt(nil, wrapKeyStoreRequest(t, "key_store_id", kid, DecryptRequest{
+			Ciphertext: encryptResp.Ciphertext, AssociatedData: aad, Nonce: encryptResp.Nonce[:chacha20poly1305.NonceSize],
+		}))
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "nonce of XChaCha20Poly1305 keys must be 24 bytes")
+	})
+
+	t.Run("Fail to export XChaCha20Poly1305 key", func(t *testing.T) {
+		var buf bytes.Buffer
+
+		err := env.cmd.ExportKey(&buf, wrapKeyStoreRequest(t, "key_store_id", kid, nil))
+		require.Error(t, err)
+		require.Empty(t, buf.Bytes())
+	})
+}
+
+// chaChaKeyset returns a keyset handle of a ChaCha20Poly1305 or XChaCha20Poly1305 key with the key material, without
+// an output prefix, like keys of other implementations.
+func chaChaKeyset(t *testing.T, kt kms.KeyType, material []byte) *keyset.Handle {
+	t.Helper()
+
+	var (
+		typeURL string
+		key     proto.Message
+	)
+
+	switch kt { //nolint:exhaustive
+	case kms.ChaCha20Poly1305Type:
+		typeURL, key = "type.googleapis.com/google.crypto.tink.ChaCha20Poly1305Key",
+			&chachapb.ChaCha20Poly1305Key{KeyValue: material}
+	case kms.XChaCha20Poly1305Type:
+		typeURL, key = "type.googleapis.com/google.crypto.tink.XChaCha20Poly1305Key",
+			&xchachapb.XChaCha20Poly1305Key{KeyValue: material}
+	default:
+		t.Fatalf("unsupported key type %s", kt)
+	}
+
+	value, err := proto.Marshal(key)
+	require.NoError(t, err)
+
+	kh, err := insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: &tinkpb.Keyset{
+		PrimaryKeyId: 1,
+		Key: []*tinkpb.Keyset_Key{{
+			KeyData: &tinkpb.KeyData{
+				TypeUrl:         typeURL,
+				Value:           value,
+				KeyMaterialType: tinkpb.KeyData_SYMMETRIC,
+			},
+			Status:           tinkpb.KeyStatusType_ENABLED,
+			KeyId:            1,
+			OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+		}},
+	}})
+	require.NoError(t, err)
+
+	return kh
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	return b
+}
+
+func TestCommand_Decrypt(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			DecryptValue: []byte("plaintext"),
+		}))
+
+		req, err := json.Marshal(DecryptRequest{
+			Ciphertext:     []byte("ciphertext"),
+			AssociatedData: []byte("ad"),
+			Nonce:          []byte("nonce"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Decrypt(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp DecryptResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+	})
+
+	t.Run("Fail to decrypt", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			DecryptErr: errors.New("decrypt error"),
+		}))
+
+		req, err := json.Marshal(DecryptRequest{
+			Ciphertext:     []byte("ciphertext"),
+			AssociatedData: []byte("ad"),
+			Nonce:          []byte("nonce"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.Decrypt(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "decrypt: decrypt error")
+	})
+
+	t.Run("Fail with tampered ciphertext", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 2)
+
+		keyID, _, err := localKMS.Create(kms.AES256GCMType)
+		require.NoError(t, err)
+
+		req, err := json.Marshal(EncryptRequest{
+			Message:        []byte("test message"),
+			AssociatedData: []byte("ad"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: keyID, Request: req})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, cmd.Encrypt(&buf, bytes.NewBuffer(wr)))
+
+		var encResp EncryptResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &encResp))
+
+		encResp.Ciphertext[0] ^= 0x01
+
+		req, err = json.Marshal(DecryptRequest{
+			Ciphertext:     encResp.Ciphertext,
+			AssociatedData: []byte("ad"),
+			Nonce:          encResp.Nonce,
+		})
+		require.NoError(t, err)
+
+		wr, err = json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: keyID, Request: req})
+		require.NoError(t, err)
+
+		err = cmd.Decrypt(&bytes.Buffer{}, bytes.NewBuffer(wr))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		var decryptErr *DecryptionFailedError
+
+		require.True(t, errors.As(err, &decryptErr))
+		require.Equal(t, "/key_store_id/keys/"+keyID, decryptErr.KeyURL)
+	})
+}
+
+func TestCommand_ComputeMAC(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			ComputeMACValue: []byte("mac"),
+		}))
+
+		req, err := json.Marshal(ComputeMACRequest{
+			Data: []byte("data"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.ComputeMAC(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp ComputeMACResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("mac"), resp.MAC)
+	})
+
+	t.Run("Fail to compute MAC", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			ComputeMACErr: errors.New("compute mac error"),
+		}))
+
+		req, err := json.Marshal(ComputeMACRequest{
+			Data: []byte("data"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.ComputeMAC(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "compute mac: compute mac error")
+	})
+}
+
+func TestCommand_VerifyMAC(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{}))
+
+		req, err := json.Marshal(VerifyMACRequest{
+			Data: []byte("data"),
+			MAC:  []byte("mac"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyMAC(nil, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to verify MAC", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			VerifyMACErr: errors.New("verify mac error"),
+		}))
+
+		req, err := json.Marshal(VerifyMACRequest{
+			Data: []byte("data"),
+			MAC:  []byte("mac"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyMAC(nil, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "verify mac: verify mac error")
+	})
+}
+
+func TestCommand_SignMulti(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			BBSSignValue: []byte("signature"),
+		}))
+
+		req, err := json.Marshal(SignMultiRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.SignMulti(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp SignMultiResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("signature"), resp.Signature)
+	})
+
+	t.Run("Fail to sign multi", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			BBSSignErr: errors.New("sign error"),
+		}))
+
+		req, err := json.Marshal(SignMultiRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.SignMulti(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "sign multi: sign error")
+	})
+}
+
+func TestCommand_VerifyMulti(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t),
+			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
+			withCrypto(&mockcrypto.Crypto{}),
+		)
+
+		req, err := json.Marshal(VerifyMultiRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Signature: []byte("signature"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyMulti(nil, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to verify a signature", func(t *testing.T) {
+		kh, err := keyset.NewHandle(signature.ED25519KeyTemplate())
+		require.NoError(t, err)
+
+		cmd := createCmd(t, gomock.NewController(t),
+			withKeyManager(&mockkms.KeyManager{GetKeyValue: kh}),
+			withCrypto(&mockcrypto.Crypto{
+				BBSVerifyErr: errors.New("verify error"),
+			}),
+		)
+
+		req, err := json.Marshal(VerifyMultiRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Signature: []byte("signature"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyMulti(nil, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "verify multi: verify error")
+	})
+}
+
+func TestCommand_DeriveProof(t *testing.T) {
+	t.Run("BLS12381G2 key of local KMS", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 4)
+
+		kid, _, err := localKMS.Create(kms.BLS12381G2Type)
+		require.NoError(t, err)
+
+		messages := [][]byte{[]byte("message1"), []byte("message2")}
+
+		var signResp SignMultiResponse
+
+		require.NoError(t, cmd.SignMulti(encodeResponse(t, &signResp),
+			wrapRequest(t, kid, SignMultiRequest{Messages: messages})))
+
+		require.NoError(t, cmd.VerifyMulti(nil,
+			wrapRequest(t, kid, VerifyMultiRequest{Signature: signResp.Signature, Messages: messages})))
+
+		var proofResp DeriveProofResponse
+
+		require.NoError(t, cmd.DeriveProof(encodeResponse(t, &proofResp), wrapRequest(t, kid, DeriveProofRequest{
+			Messages:        messages,
+			Signature:       signResp.Signature,
+			Nonce:           []byte("nonce"),
+			RevealedIndexes: []int{1},
+		})))
+
+		require.NoError(t, cmd.VerifyProof(nil, wrapRequest(t, kid, VerifyProofRequest{
+			Proof:    proofResp.Proof,
+			Messages: messages[1:],
+			Nonce:    []byte("nonce"),
+		})))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			DeriveProofValue: []byte("proof"),
+		}))
+
+		req, err := json.Marshal(DeriveProofRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Signature:       []byte("signature"),
+			Nonce:           []byte("nonce"),
+			RevealedIndexes: []int{1},
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.DeriveProof(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp DeriveProofResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("proof"), resp.Proof)
+	})
+
+	t.Run("Fail to derive proof", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			DeriveProofError: errors.New("derive proof error"),
+		}))
+
+		req, err := json.Marshal(DeriveProofRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Signature:       []byte("signature"),
+			Nonce:           []byte("nonce"),
+			RevealedIndexes: []int{1},
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.DeriveProof(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "derive proof: derive proof error")
+	})
+
+	t.Run("Fail with invalid revealed indexes", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			indexes []int
+			err     string
+		}{
+			{
+				name:    "Index out of range",
+				indexes: []int{0, 2},
+				err:     "unprocessable entity: revealed index 2 is out of range of 2 messages",
+			},
+			{
+				name:    "Negative index",
+				indexes: []int{-1},
+				err:     "unprocessable entity: revealed index -1 is out of range of 2 messages",
+			},
+			{
+				name:    "Duplicated index",
+				indexes: []int{1, 0, 1},
+				err:     "unprocessable entity: revealed index 1 is duplicated",
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				env := newKeyStoreEnv(t)
+
+				err := env.cmd.DeriveProof(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id", DeriveProofRequest{
+					Messages:        [][]byte{[]byte("test message 1"), []byte("test message 2")},
+					Signature:       []byte("signature"),
+					Nonce:           []byte("nonce"),
+					RevealedIndexes: tt.indexes,
+				}))
+				require.EqualError(t, err, tt.err)
+				require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+			})
+		}
+	})
+}
+
+func TestCommand_VerifyProof(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{}))
+
+		req, err := json.Marshal(VerifyProofRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Proof: []byte("proof"),
+			Nonce: []byte("nonce"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyProof(nil, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to verify proof", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			VerifyProofErr: errors.New("verify proof error"),
+		}))
+
+		req, err := json.Marshal(VerifyProofRequest{
+			Messages: [][]byte{
+				[]byte("test message 1"),
+				[]byte("test message 2"),
+			},
+			Proof: []byte("proof"),
+			Nonce: []byte("nonce"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		err = cmd.VerifyProof(nil, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "verify proof: verify proof error")
+	})
+}
+
+func TestCommand_Easy(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().Easy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return([]byte("ciphertext"), nil).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, ctrl, withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(EasyRequest{
+			Payload:  []byte("payload"),
+			Nonce:    []byte("nonce"),
+			TheirPub: []byte("their pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp EasyResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ciphertext"), resp.Ciphertext)
+	})
+
+	t.Run("Fail to seal a payload", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().Easy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, errors.New("easy error")).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, ctrl, withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(EasyRequest{
+			Payload:  []byte("payload"),
+			Nonce:    []byte("nonce"),
+			TheirPub: []byte("their pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "unwrap wrap request: easy: easy error")
+	})
+}
+
+func TestCommand_EasyOpen(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return([]byte("plaintext"), nil).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(EasyOpenRequest{
+			Ciphertext: []byte("payload"),
+			Nonce:      []byte("nonce"),
+			TheirPub:   []byte("their pub"),
+			MyPub:      []byte("my pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp EasyOpenResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+	})
+
+	t.Run("Fail to unseal a ciphertext", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return(nil, errors.New("seal open error")).Times(1)
+		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(nil, errors.New("easy open error")).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(EasyOpenRequest{
+			Ciphertext: []byte("payload"),
+			Nonce:      []byte("nonce"),
+			TheirPub:   []byte("their pub"),
+			MyPub:      []byte("my pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		// since both easyopen and sealopen are both part of UnwrapKey, if easyopen fails, sealopen will be executed
+		// and will be logged as last error regardless (we try easyopen first then sealopen in this order)
+		require.EqualError(t, err, "unwrapKey cryptobox request invalid: seal open: seal open error")
+	})
+}
+
+func TestCommand_SealOpen(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return([]byte("plaintext"), nil).Times(1)
+		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil,
+			errors.New("easy open error")).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(SealOpenRequest{
+			Ciphertext: []byte("payload"),
+			MyPub:      []byte("my pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp SealOpenResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+	})
+
+	t.Run("Fail to decrypt a ciphertext", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		cryptoBox := NewMockCryptoBox(ctrl)
+		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return(nil, errors.New("seal open error")).Times(1)
+		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil,
+			errors.New("easy open error")).Times(1)
+
+		creator := NewMockCryptoBoxCreator(ctrl)
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+
+		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+
+		req, err := json.Marshal(SealOpenRequest{
+			Ciphertext: []byte("payload"),
+			MyPub:      []byte("my pub"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "unwrapKey cryptobox request invalid: seal open: seal open error")
+	})
+}
+
+func TestCommand_SignJWT(t *testing.T) {
+	newEnv := func(t *testing.T, kt kms.KeyType) (*keyStoreEnv, string, []byte) {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		kid, _, err := env.userKMS.Create(kt)
+		require.NoError(t, err)
+
+		pub, _, err := env.userKMS.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		return env, kid, pub
+	}
+
+	// ECDSA DER keys are exported in PKIX form, IEEE P1363 keys as uncompressed points
+	ecdsaPublicKey := func(t *testing.T, curve elliptic.Curve, pub []byte) *ecdsa.PublicKey {
+		t.Helper()
+
+		if x, y := elliptic.Unmarshal(curve, pub); x != nil {
+			return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+
+		key, err := x509.ParsePKIXPublicKey(pub)
+		require.NoError(t, err)
+
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		require.True(t, ok)
+
+		return ecKey
+	}
+
+	// verify checks the JWS with the exported public key and returns its decoded header and payload
+	verify := func(t *testing.T, jws string, kt kms.KeyType, pub, detachedPayload []byte) (map[string]interface{},
+		[]byte) {
+		t.Helper()
+
+		parts := strings.Split(jws, ".")
+		require.Len(t, parts, 3)
+
+		headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+		require.NoError(t, err)
+
+		var header map[string]interface{}
+
+		require.NoError(t, json.Unmarshal(headerBytes, &header))
+
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+
+		signingInput := []byte(parts[0] + "." + parts[1])
+
+		if detachedPayload != nil {
+			require.Empty(t, parts[1])
+
+			payload = detachedPayload
+			signingInput = append([]byte(parts[0]+"."), detachedPayload...)
+		}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+
+		switch kt { //nolint:exhaustive
+		case kms.ED25519Type:
+			require.True(t, ed25519.Verify(pub, signingInput, signature))
+		case kms.ECDSAP256TypeDER, kms.ECDSAP256TypeIEEEP1363:
+			require.Len(t, signature, 64)
+
+			digest := sha256.Sum256(signingInput)
+			require.True(t, ecdsa.Verify(ecdsaPublicKey(t, elliptic.P256(), pub), digest[:],
+				new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+		case kms.ECDSAP384TypeDER, kms.ECDSAP384TypeIEEEP1363:
+			require.Len(t, signature, 96)
+
+			digest := sha512.Sum384(signingInput)
+			require.True(t, ecdsa.Verify(ecdsaPublicKey(t, elliptic.P384(), pub), digest[:],
+				new(big.Int).SetBytes(signature[:48]), new(big.Int).SetBytes(signature[48:])))
+		case secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363:
+			require.Len(t, signature, 64)
+
+			key, err := secp256k1.ParsePublicKey(pub, kt)
+			require.NoError(t, err)
+
+			digest := sha256.Sum256(signingInput)
+			require.True(t, ecdsa.Verify(key, digest[:],
+				new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])))
+		default:
+			t.Fatalf("unexpected key type %s", kt)
+		}
+
+		return header, payload
+	}
+
+	claims := json.RawMessage(`{"iss": "did:example:issuer", "sub": "did:example:subject"}`)
+
+	for _, tc := range []struct {
+		kt  kms.KeyType
+		alg string
+	}{
+		{kt: kms.ED25519Type, alg: "EdDSA"},
+		{kt: kms.ECDSAP256TypeDER, alg: "ES256"},
+		{kt: kms.ECDSAP256TypeIEEEP1363, alg: "ES256"},
+		{kt: kms.ECDSAP384TypeDER, alg: "ES384"},
+		{kt: kms.ECDSAP384TypeIEEEP1363, alg: "ES384"},
+		{kt: secp256k1.KeyTypeDER, alg: "ES256K"},
+		{kt: secp256k1.KeyTypeIEEEP1363, alg: "ES256K"},
+	} {
+		tc := tc
+
+		t.Run("Sign claims with "+string(tc.kt)+" key", func(t *testing.T) {
+			env, kid, pub := newEnv(t, tc.kt)
+
+			var resp SignJWTResponse
+
+			require.NoError(t, env.cmd.SignJWT(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+				SignJWTRequest{Claims: claims, Headers: map[string]interface{}{"typ": "JWT"}})))
+
+			header, payload := verify(t, resp.JWS, tc.kt, pub, nil)
+			require.Equal(t, map[string]interface{}{
+				"alg": tc.alg,
+				"kid": "https://kms.example.com/v1/keystores/key_store_id/keys/" + kid,
+				"typ": "JWT",
+			}, header)
+			require.JSONEq(t, string(claims), string(payload))
+		})
+	}
+
+	t.Run("Sign detached unencoded claims", func(t *testing.T) {
+		env, kid, pub := newEnv(t, kms.ED25519Type)
+
+		var resp SignJWTResponse
+
+		require.NoError(t, env.cmd.SignJWT(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignJWTRequest{Claims: claims, Headers: map[string]interface{}{"kid": "did:example:issuer#key-1"},
+				Detached: true})))
+
+		header, _ := verify(t, resp.JWS, kms.ED25519Type, pub,
+			[]byte(`{"iss":"did:example:issuer","sub":"did:example:subject"}`))
+		require.Equal(t, map[string]interface{}{
+			"alg":  "EdDSA",
+			"kid":  "did:example:issuer#key-1",
+			"b64":  false,
+			"crit": []interface{}{"b64"},
+		}, header)
+	})
+
+	t.Run("Fail with key that can't sign a JWS", func(t *testing.T) {
+		env, kid, _ := newEnv(t, kms.NISTP256ECDHKWType)
+
+		err := env.cmd.SignJWT(nil, wrapKeyStoreRequest(t, "key_store_id", kid, SignJWTRequest{Claims: claims}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	for _, tc := range []struct {
+		name string
+		req  SignJWTRequest
+		err  string
+	}{
+		{
+			name: "claims that aren't a JSON object",
+			req:  SignJWTRequest{Claims: json.RawMessage(`["claim"]`)},
+			err:  "claims must be a JSON object",
+		},
+		{
+			name: "missing claims",
+			req:  SignJWTRequest{},
+			err:  "claims must be a JSON object",
+		},
+		{
+			name: "alg that doesn't match the key",
+			req:  SignJWTRequest{Claims: claims, Headers: map[string]interface{}{"alg": "ES256"}},
+			err:  "alg header ES256 doesn't match the key, EdDSA expected",
+		},
+		{
+			name: "b64 header",
+			req:  SignJWTRequest{Claims: claims, Headers: map[string]interface{}{"b64": false}},
+			err:  "b64 header is set by the server",
+		},
+	} {
+		tc := tc
+
+		t.Run("Fail with "+tc.name, func(t *testing.T) {
+			env, kid, _ := newEnv(t, kms.ED25519Type)
+
+			err := env.cmd.SignJWT(nil, wrapKeyStoreRequest(t, "key_store_id", kid, tc.req))
+			require.Error(t, err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestCommand_Secp256k1(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	message := []byte("test message")
+
+	for _, tc := range []struct {
+		kt      kms.KeyType
+		sigSize int
+	}{
+		{kt: secp256k1.KeyTypeDER},
+		{kt: secp256k1.KeyTypeIEEEP1363, sigSize: 64},
+	} {
+		tc := tc
+
+		t.Run("Create, sign, verify and export "+string(tc.kt)+" key", func(t *testing.T) {
+			env := newEnv(t)
+
+			var createResp CreateKeyResponse
+
+			require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+				wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: tc.kt})))
+
+			kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+			pub, err := secp256k1.ParsePublicKey(createResp.PublicKey, tc.kt)
+			require.NoError(t, err)
+
+			var signResp SignResponse
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+				wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+
+			if tc.sigSize > 0 {
+				require.Len(t, signResp.Signature, tc.sigSize)
+			}
+
+			require.NoError(t, secp256k1.Verify(pub, signResp.Signature, message, tc.kt))
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: message})))
+
+			err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: []byte("other message")}))
+			require.Error(t, err)
+
+			var jwkBuf bytes.Buffer
+
+			require.NoError(t, env.cmd.ExportKey(&jwkBuf, bytes.NewBufferString(fmt.Sprintf(
+				`{"key_store_id":"key_store_id","key_id":%q,"format":"jwk"}`, kid))))
+
+			var j map[string]interface{}
+
+			require.NoError(t, json.Unmarshal(jwkBuf.Bytes(), &j))
+			require.Equal(t, "ES256K", j["alg"])
+			require.Equal(t, "EC", j["kty"])
+			require.Equal(t, "secp256k1", j["crv"])
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))), j["x"])
+		})
+	}
+
+	t.Run("Import raw private key", func(t *testing.T) {
+		env := newEnv(t)
+
+		priv, err := ecdsa.GenerateKey(secp256k1.Curve(), rand.Reader)
+		require.NoError(t, err)
+
+		d := make([]byte, 32)
+		priv.D.FillBytes(d)
+
+		expected := elliptic.Marshal(priv.Curve, priv.X, priv.Y)
+
+		var resp ImportKeyResponse
+
+		require.NoError(t, env.cmd.ImportKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			ImportKeyRequest{KeyType: secp256k1.KeyTypeIEEEP1363, Key: d, ExpectedPublicKey: expected})))
+		require.Equal(t, expected, resp.PublicKey)
+
+		kid := resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+		require.NoError(t, secp256k1.Verify(&priv.PublicKey, signResp.Signature, message,
+			secp256k1.KeyTypeIEEEP1363))
+	})
+
+	t.Run("Import JWK", func(t *testing.T) {
+		env := newEnv(t)
+
+		priv, err := ecdsa.GenerateKey(secp256k1.Curve(), rand.Reader)
+		require.NoError(t, err)
+
+		j, err := jwksupport.JWKFromKey(priv)
+		require.NoError(t, err)
+
+		b, err := j.MarshalJSON()
+		require.NoError(t, err)
+
+		var resp ImportKeyResponse
+
+		require.NoError(t, env.cmd.ImportKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			ImportKeyRequest{KeyType: secp256k1.KeyTypeDER, JWK: b})))
+
+		pub, err := secp256k1.ParsePublicKey(resp.PublicKey, secp256k1.KeyTypeDER)
+		require.NoError(t, err)
+		require.True(t, pub.Equal(&priv.PublicKey))
+	})
+
+	t.Run("Fail to import key of another curve", func(t *testing.T) {
+		env := newEnv(t)
+
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		j, err := jwksupport.JWKFromKey(priv)
+		require.NoError(t, err)
+
+		b, err := j.MarshalJSON()
+		require.NoError(t, err)
+
+		err = env.cmd.ImportKey(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+			ImportKeyRequest{KeyType: secp256k1.KeyTypeDER, JWK: b}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "key does not match key type ECDSASecp256k1DER")
+	})
+}
+
+func TestCommand_NISTCurves(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	message := []byte("test message")
+
+	for _, tc := range []struct {
+		kt      kms.KeyType
+		curve   elliptic.Curve
+		hash    func() hash.Hash
+		alg     string
+		crv     string
+		sigSize int // size of IEEE P1363 signatures
+	}{
+		{kt: kms.ECDSAP256TypeDER, curve: elliptic.P256(), hash: sha256.New, alg: "ES256", crv: "P-256"},
+		{kt: kms.ECDSAP256TypeIEEEP1363, curve: elliptic.P256(), hash: sha256.New, alg: "ES256", crv: "P-256",
+			sigSize: 64},
+		{kt: kms.ECDSAP384TypeDER, curve: elliptic.P384(), hash: sha512.New384, alg: "ES384", crv: "P-384"},
+		{kt: kms.ECDSAP384TypeIEEEP1363, curve: elliptic.P384(), hash: sha512.New384, alg: "ES384", crv: "P-384",
+			sigSize: 96},
+		{kt: kms.ECDSAP521TypeDER, curve: elliptic.P521(), hash: sha512.New, alg: "ES512", crv: "P-521"},
+		{kt: kms.ECDSAP521TypeIEEEP1363, curve: elliptic.P521(), hash: sha512.New, alg: "ES512", crv: "P-521",
+			sigSize: 132},
+	} {
+		tc := tc
+
+		t.Run("Create, sign, verify and export "+string(tc.kt)+" key", func(t *testing.T) {
+			env := newEnv(t)
+
+			var createResp CreateKeyResponse
+
+			require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+				wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: tc.kt})))
+
+			kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+			// DER keys are exported in PKIX form, IEEE P1363 keys as uncompressed points
+			pub := &ecdsa.PublicKey{Curve: tc.curve}
+
+			if tc.sigSize > 0 {
+				pub.X, pub.Y = elliptic.Unmarshal(tc.curve, createResp.PublicKey)
+				require.NotNil(t, pub.X)
+			} else {
+				key, err := x509.ParsePKIXPublicKey(createResp.PublicKey)
+				require.NoError(t, err)
+
+				ecKey, ok := key.(*ecdsa.PublicKey)
+				require.True(t, ok)
+				require.Equal(t, tc.curve, ecKey.Curve)
+
+				pub = ecKey
+			}
+
+			var signResp SignResponse
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+				wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+
+			h := tc.hash()
+			h.Write(message)
+			digest := h.Sum(nil)
+
+			if tc.sigSize > 0 {
+				require.Len(t, signResp.Signature, tc.sigSize)
+
+				r := new(big.Int).SetBytes(signResp.Signature[:tc.sigSize/2])
+				sig := new(big.Int).SetBytes(signResp.Signature[tc.sigSize/2:])
+				require.True(t, ecdsa.Verify(pub, digest, r, sig))
+			} else {
+				require.True(t, ecdsa.VerifyASN1(pub, digest, signResp.Signature))
+			}
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: message})))
+
+			err := env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: []byte("other message")}))
+			require.Error(t, err)
+
+			var jwkBuf bytes.Buffer
+
+			require.NoError(t, env.cmd.ExportKey(&jwkBuf, bytes.NewBufferString(fmt.Sprintf(
+				`{"key_store_id":"key_store_id","key_id":%q,"format":"jwk"}`, kid))))
+
+			var j map[string]interface{}
+
+			size := (tc.curve.Params().BitSize + 7) / 8
+
+			require.NoError(t, json.Unmarshal(jwkBuf.Bytes(), &j))
+			require.Equal(t, tc.alg, j["alg"])
+			require.Equal(t, "EC", j["kty"])
+			require.Equal(t, tc.crv, j["crv"])
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))), j["x"])
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))), j["y"])
+
+			var didResp ExportDIDKeyResponse
+
+			require.NoError(t, env.cmd.ExportKey(encodeResponse(t, &didResp), bytes.NewBufferString(fmt.Sprintf(
+				`{"key_store_id":"key_store_id","key_id":%q,"format":"did"}`, kid))))
+
+			expected, err := didkey.FromPublicKey(createResp.PublicKey, tc.kt)
+			require.NoError(t, err)
+			require.Equal(t, expected.DID, didResp.DID)
+
+			var jwtResp SignJWTResponse
+
+			require.NoError(t, env.cmd.SignJWT(encodeResponse(t, &jwtResp), wrapKeyStoreRequest(t, "key_store_id", kid,
+				SignJWTRequest{Claims: json.RawMessage(`{"iss":"did:example:issuer"}`)})))
+
+			parts := strings.Split(jwtResp.JWS, ".")
+			require.Len(t, parts, 3)
+
+			jwsSig, err := base64.RawURLEncoding.DecodeString(parts[2])
+			require.NoError(t, err)
+			require.Len(t, jwsSig, 2*size)
+
+			h = tc.hash()
+			h.Write([]byte(parts[0] + "." + parts[1]))
+
+			require.True(t, ecdsa.Verify(pub, h.Sum(nil), new(big.Int).SetBytes(jwsSig[:size]),
+				new(big.Int).SetBytes(jwsSig[size:])))
+		})
+	}
+}
+
+// updateGolden rewrites golden files with the output of the tests: go test ./pkg/controller/command -update.
+var updateGolden = flag.Bool("update", false, "update golden files") //nolint:gochecknoglobals
+
+func TestCommand_TestVectors(t *testing.T) {
+	const goldenFile = "testdata/test_vectors.json"
+
+	t.Run("Vectors match golden file", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withKeyStoreCreator(&testKeyStoreCreator{}))
+
+		var buf bytes.Buffer
+
+		require.NoError(t, env.cmd.TestVectors(&buf, nil))
+
+		if *updateGolden {
+			var indented bytes.Buffer
+
+			require.NoError(t, json.Indent(&indented, buf.Bytes(), "", "  "))
+			require.NoError(t, os.WriteFile(goldenFile, indented.Bytes(), 0o600))
+		}
+
+		b, err := os.ReadFile(goldenFile)
+		require.NoError(t, err)
+
+		vectors, golden := readTestVectors(t, buf.Bytes()), readTestVectors(t, b)
+
+		require.Len(t, vectors.Keys, 13)
+
+		for i, v := range vectors.Keys {
+			require.Equal(t, golden.Keys[i].KeyType, v.KeyType)
+
+			// random signatures can't be compared, both must verify
+			if !v.Deterministic {
+				verifyTestVectorSignature(t, v)
+				verifyTestVectorSignature(t, golden.Keys[i])
+
+				v.Signature, golden.Keys[i].Signature = nil, nil
+			}
+		}
+
+		require.Equal(t, golden, vectors)
+	})
+
+	t.Run("Vectors agree with other implementations", func(t *testing.T) {
+		b, err := os.ReadFile(goldenFile)
+		require.NoError(t, err)
+
+		golden := readTestVectors(t, b)
+
+		deterministic := map[string]bool{}
+
+		for _, v := range golden.Keys {
+			deterministic[strings.TrimSpace(v.KeyType+" "+v.Hash)] = v.Deterministic
+
+			verifyTestVectorSignature(t, v)
+		}
+
+		require.Equal(t, map[string]bool{
+			"ED25519":                 true,
+			"ECDSAP256DER":            false,
+			"ECDSAP256IEEEP1363":      false,
+			"ECDSAP384DER":            false,
+			"ECDSAP384IEEEP1363":      false,
+			"ECDSAP521DER":            false,
+			"ECDSAP521IEEEP1363":      false,
+			"ECDSASecp256k1DER":       true,
+			"ECDSASecp256k1IEEEP1363": true,
+			"BLS12381G2":              false,
+			"ECDSAP256DER SHA-384":    false,
+			"ECDSAP256DER SHA-512":    false,
+			"ECDSAP384DER SHA-512":    false,
+		}, deterministic)
+
+		edKey := ed25519.NewKeyFromSeed(golden.Keys[0].PrivateKey)
+
+		require.Equal(t, []byte(edKey.Public().(ed25519.PublicKey)), golden.Keys[0].PublicKey)
+		require.Equal(t, ed25519.Sign(edKey, golden.Keys[0].Message), golden.Keys[0].Signature)
+		require.Equal(t, "did:key:z6MktwupdmLXVVqTzCw4i46r4uGyosGXRnR3XjN4Zq7oMMsw", golden.Keys[0].DIDKey.DID)
+
+		zv := golden.ZCAPInvocation
+
+		require.Equal(t, golden.Keys[0].DIDKey.VerificationMethod, zv.Invoker)
+		require.True(t, ed25519.Verify(edKey.Public().(ed25519.PublicKey), []byte(zv.SignatureBase), zv.Signature))
+		require.Equal(t, "(request-target): get /v1/keystores/testvectors/keys/"+
+			strings.TrimPrefix(golden.Keys[0].KeyURL, "https://kms.example.com/v1/keystores/testvectors/keys/")+
+			"/export\n(created): 1640995200\ncapability-invocation: "+zv.Headers["capability-invocation"],
+			zv.SignatureBase)
+
+		params := strings.TrimPrefix(zv.Headers["capability-invocation"], `zcap capability="`)
+		encoded := params[:strings.Index(params, `"`)]
+
+		compressed, err := base64.URLEncoding.DecodeString(encoded)
+		require.NoError(t, err)
+
+		capability, err := zcapld.DecompressZCAP(base64.URLEncoding.EncodeToString(compressed))
+		require.NoError(t, err)
+
+		var expected zcapld.Capability
+
+		require.NoError(t, json.Unmarshal(zv.Capability, &expected))
+		require.Equal(t, &expected, capability)
+		require.Equal(t, zv.Invoker, capability.Invoker)
+		require.Contains(t, capability.AllowedAction, ActionExportKey)
+	})
+
+	t.Run("Fail to create key store", func(t *testing.T) {
+		env := newKeyStoreEnv(t, withKeyStoreCreator(&testKeyStoreCreator{err: errors.New("create failed")}))
+
+		err := env.cmd.TestVectors(&bytes.Buffer{}, nil)
+		require.EqualError(t, err, "ED25519 test vector: create key store: create failed")
+	})
+}
+
+// readTestVectors reads test vectors with JSON members in compact form, so that vectors read from indented JSON are
+// equal to the same vectors read from compact JSON.
+func readTestVectors(t *testing.T, b []byte) *TestVectorsResponse {
+	t.Helper()
+
+	var vectors TestVectorsResponse
+
+	require.NoError(t, json.Unmarshal(b, &vectors))
+
+	compact := func(raw json.RawMessage) json.RawMessage {
+		if raw == nil {
+			return nil
+		}
+
+		var buf bytes.Buffer
+
+		require.NoError(t, json.Compact(&buf, raw))
+
+		return buf.Bytes()
+	}
+
+	for _, v := range vectors.Keys {
+		v.JWK = compact(v.JWK)
+	}
+
+	vectors.ZCAPInvocation.Capability = compact(vectors.ZCAPInvocation.Capability)
+
+	return &vectors
+}
+
+// verifyTestVectorSignature verifies the signature of the test vector with the public key, independently of the
+// KMS.
+func verifyTestVectorSignature(t *testing.T, v *KeyTestVector) {
+	t.Helper()
+
+	if v.KeyType == string(kms.BLS12381G2Type) {
+		require.NoError(t, bbs12381g2pub.New().Verify(v.Messages, v.Signature, v.PublicKey))
+
+		return
+	}
+
+	var (
+		curve elliptic.Curve
+		h     hash.Hash
+	)
+
+	switch {
+	case strings.HasPrefix(v.KeyType, "ED25519"):
+		require.True(t, ed25519.Verify(v.PublicKey, v.Message, v.Signature))
+
+		return
+	case strings.HasPrefix(v.KeyType, "ECDSAP256"):
+		curve, h = elliptic.P256(), sha256.New()
+	case strings.HasPrefix(v.KeyType, "ECDSAP384"):
+		curve, h = elliptic.P384(), sha512.New384()
+	case strings.HasPrefix(v.KeyType, "ECDSAP521"):
+		curve, h = elliptic.P521(), sha512.New()
+	default:
+		// secp256k1 signatures are deterministic and compared with the golden file
+		return
+	}
+
+	switch v.Hash {
+	case "SHA-384":
+		h = sha512.New384()
+	case "SHA-512":
+		h = sha512.New()
+	}
+
+	h.Write(v.Message)
+	digest := h.Sum(nil)
+
+	if strings.HasSuffix(v.KeyType, "IEEEP1363") {
+		x, y := elliptic.Unmarshal(curve, v.PublicKey)
+		require.NotNil(t, x)
+
+		size := (curve.Params().BitSize + 7) / 8
+		require.Len(t, v.Signature, 2*size)
+
+		r, s := new(big.Int).SetBytes(v.Signature[:size]), new(big.Int).SetBytes(v.Signature[size:])
+		require.True(t, ecdsa.Verify(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest, r, s))
+
+		return
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(v.PublicKey)
+	require.NoError(t, err)
+	require.True(t, ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest, v.Signature))
+}
+
+func TestCommand_RSAPSS(t *testing.T) {
+	newEnv := func(t *testing.T, opts ...configOption) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, append([]configOption{withMetricsProvider(metrics)}, opts...)...)
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	message := []byte("test message")
+
+	for _, size := range []int{0, 3072} {
+		size := size
+
+		t.Run(fmt.Sprintf("Create, sign, verify and export key of size %d", size), func(t *testing.T) {
+			env := newEnv(t)
+
+			var createResp CreateKeyResponse
+
+			require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+				wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: rsapss.KeyType, KeySize: size})))
+
+			kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+			pub, err := rsapss.ParsePublicKey(createResp.PublicKey)
+			require.NoError(t, err)
+
+			if size == 0 {
+				size = rsapss.DefaultKeySize
+			}
+
+			require.Equal(t, size, pub.N.BitLen())
+
+			var signResp SignResponse
+
+			require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+				wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+			require.Len(t, signResp.Signature, size/8)
+			require.NoError(t, rsapss.Verify(pub, signResp.Signature, message))
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: message})))
+
+			err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: signResp.Signature, Message: []byte("other message")}))
+			require.Error(t, err)
+
+			var jwkBuf bytes.Buffer
+
+			require.NoError(t, env.cmd.ExportKey(&jwkBuf, bytes.NewBufferString(fmt.Sprintf(
+				`{"key_store_id":"key_store_id","key_id":%q,"format":"jwk"}`, kid))))
+
+			var j map[string]interface{}
+
+			require.NoError(t, json.Unmarshal(jwkBuf.Bytes(), &j))
+			require.Equal(t, "PS256", j["alg"])
+			require.Equal(t, "RSA", j["kty"])
+			require.Equal(t, base64.RawURLEncoding.EncodeToString(pub.N.Bytes()), j["n"])
+			require.Equal(t, "AQAB", j["e"])
+
+			var jwtResp SignJWTResponse
+
+			require.NoError(t, env.cmd.SignJWT(encodeResponse(t, &jwtResp), wrapKeyStoreRequest(t, "key_store_id", kid,
+				SignJWTRequest{Claims: json.RawMessage(`{"iss":"did:example:issuer"}`)})))
+
+			parts := strings.Split(jwtResp.JWS, ".")
+			require.Len(t, parts, 3)
+
+			header, err := base64.RawURLEncoding.DecodeString(parts[0])
+			require.NoError(t, err)
+			require.Contains(t, string(header), `"alg":"PS256"`)
+
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			require.NoError(t, err)
+			require.NoError(t, rsapss.Verify(pub, signature, []byte(parts[0]+"."+parts[1])))
+		})
+	}
+
+	t.Run("Create keys with a key pool", func(t *testing.T) {
+		pool := rsapss.NewPool(1)
+		pool.Start()
+
+		env := newEnv(t, func(c *Config) { c.RSAKeyPool = pool })
+
+		var resp CreateKeysResponse
+
+		require.NoError(t, env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{
+				{KeyType: rsapss.KeyType, KeySize: 4096},
+				{KeyType: kms.ED25519Type},
+			}})))
+		require.Len(t, resp.Keys, 2)
+
+		pub, err := rsapss.ParsePublicKey(resp.Keys[0].PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, 4096, pub.N.BitLen())
+	})
+
+	t.Run("Rotated key keeps its size", func(t *testing.T) {
+		env := newEnv(t)
+
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: rsapss.KeyType, KeySize: 3072})))
+
+		kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+		var rotateResp RotateKeyResponse
+
+		require.NoError(t, env.cmd.RotateKey(encodeResponse(t, &rotateResp), wrapKeyStoreRequest(t, "key_store_id",
+			kid, RotateKeyRequest{KeyType: rsapss.KeyType})))
+
+		pub, err := rsapss.ParsePublicKey(rotateResp.PublicKey)
+		require.NoError(t, err)
+		require.Equal(t, 3072, pub.N.BitLen())
+	})
+
+	for _, tc := range []struct {
+		name string
+		req  CreateKeyRequest
+		err  string
+	}{
+		{
+			name: "unsupported key size",
+			req:  CreateKeyRequest{KeyType: rsapss.KeyType, KeySize: 1024},
+			err:  "key_size must be one of [2048 3072 4096]",
+		},
+		{
+			name: "key size of a key type without sizes",
+			req:  CreateKeyRequest{KeyType: kms.ED25519Type, KeySize: 2048},
+			err:  "key_size is only supported for RSAPS256, AES128GCM and AES256GCM keys",
+		},
+	} {
+		tc := tc
+
+		t.Run("Fail with "+tc.name, func(t *testing.T) {
+			env := newEnv(t)
+
+			err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "", tc.req))
+			require.Error(t, err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			require.Contains(t, err.Error(), tc.err)
+
+			err = env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+				CreateKeysRequest{Keys: []CreateKeyRequest{tc.req}}))
+			require.Error(t, err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	t.Run("Fail with EDV-backed key store", func(t *testing.T) {
+		vault := newFakeVault(t)
+
+		headerSigner := NewMockHeaderSigner(gomock.NewController(t))
+		headerSigner.EXPECT().SignHeader(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(req *http.Request, _ []byte) (*http.Header, error) {
+				return &req.Header, nil
+			}).AnyTimes()
+
+		creator := NewMockKeyStoreCreator(gomock.NewController(t))
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(keyURI string, p kms.Provider) (kms.KeyManager, error) {
+				return localkms.New(keyURI, p)
+			}).AnyTimes()
+
+		env := newKeyStoreEnv(t, func(c *Config) {
+			c.KeyStoreCreator = creator
+			c.EDVRecipientKeyType = kms.NISTP256ECDHKW
+			c.EDVMACKeyType = kms.HMACSHA256Tag256
+			c.HeaderSigner = headerSigner
+		})
+
+		var ksResp CreateKeyStoreResponse
+
+		require.NoError(t, env.cmd.CreateKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{
+				Controller: "did:example:controller",
+				EDV:        &EDVOptions{VaultURL: vault.URL + "/encrypted-data-vaults/vault-id"},
+			})))
+
+		keyStoreID := strings.TrimPrefix(ksResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		var keyResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type})))
+
+		kid := keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:]
+		docs := len(vault.documentIDs())
+
+		for name, call := range map[string]func() error{
+			"create": func() error {
+				return env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+					CreateKeyRequest{KeyType: rsapss.KeyType}))
+			},
+			"create batch": func() error {
+				return env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+					CreateKeysRequest{Keys: []CreateKeyRequest{{KeyType: kms.ED25519Type}, {KeyType: rsapss.KeyType}}}))
+			},
+			"rotate": func() error {
+				return env.cmd.RotateKey(nil, wrapKeyStoreRequest(t, keyStoreID, kid,
+					RotateKeyRequest{KeyType: rsapss.KeyType}))
+			},
+		} {
+			err := call()
+			require.Error(t, err, name)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err), name)
+			require.Contains(t, err.Error(), "RSAPS256 keys can't be stored in EDV-backed key stores", name)
+		}
+
+		require.Len(t, vault.documentIDs(), docs)
+	})
+}
+
+func TestCommand_KeyHash(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, req CreateKeyRequest) (string, []byte) {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "", req)))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:], resp.PublicKey
+	}
+
+	exportJWK := func(t *testing.T, env *keyStoreEnv, kid string) map[string]interface{} {
+		t.Helper()
+
+		var buf bytes.Buffer
+
+		require.NoError(t, env.cmd.ExportKey(&buf, bytes.NewBufferString(fmt.Sprintf(
+			`{"key_store_id":"key_store_id","key_id":%q,"format":"jwk"}`, kid))))
+
+		var j map[string]interface{}
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &j))
+
+		return j
+	}
+
+	message := []byte("test message")
+
+	t.Run("P-256 key with SHA-384", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, pubBytes := createKey(t, env, CreateKeyRequest{KeyType: kms.ECDSAP256TypeDER, Hash: "SHA-384"})
+
+		pub, err := x509.ParsePKIXPublicKey(pubBytes)
+		require.NoError(t, err)
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+
+		digest := sha512.Sum384(message)
+		require.True(t, ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signResp.Signature))
+
+		require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: signResp.Signature, Message: message})))
+
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: signResp.Signature, Message: []byte("other message")}))
+		require.Error(t, err)
+
+		var batchResp SignBatchResponse
+
+		require.NoError(t, env.cmd.SignBatch(encodeResponse(t, &batchResp), wrapKeyStoreRequest(t, "key_store_id",
+			kid, SignBatchRequest{Messages: [][]byte{message}})))
+		require.True(t, ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], batchResp.Signatures[0]))
+
+		var deterministic, again SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &deterministic),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message, Deterministic: true})))
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &again),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message, Deterministic: true})))
+		require.Equal(t, deterministic.Signature, again.Signature)
+		require.True(t, ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], deterministic.Signature))
+
+		var getResp GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, nil)))
+		require.Equal(t, "SHA-384", getResp.Hash)
+
+		j := exportJWK(t, env, kid)
+		require.NotContains(t, j, "alg")
+		require.Equal(t, "P-256", j["crv"])
+
+		err = env.cmd.SignJWT(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignJWTRequest{Claims: json.RawMessage(`{"iss":"did:example:issuer"}`)}))
+		require.ErrorIs(t, err, kmserrors.ErrUnprocessableEntity)
+		require.Contains(t, err.Error(), "signs with SHA-384")
+
+		prehashed := sha256.Sum256(message)
+
+		err = env.cmd.Sign(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SignRequest{Message: prehashed[:], Prehashed: true}))
+		require.ErrorIs(t, err, kmserrors.ErrUnprocessableEntity)
+	})
+
+	t.Run("Details name the hash", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env, CreateKeyRequest{KeyType: kms.ECDSAP256TypeIEEEP1363, Hash: "SHA-512"})
+
+		b, err := json.Marshal(SignRequest{Message: message})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: kid, Details: true, Request: b})
+		require.NoError(t, err)
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp), bytes.NewBuffer(wr)))
+		require.Equal(t, "SHA-512", signResp.Details.Hash)
+		require.Equal(t, "P-256", signResp.Details.Curve)
+		require.Empty(t, signResp.Details.JOSEAlgorithm)
+		require.Zero(t, signResp.Details.COSEAlgorithm)
+	})
+
+	t.Run("Default hash", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env, CreateKeyRequest{KeyType: kms.ECDSAP384TypeDER, Hash: "SHA-384"})
+
+		var getResp GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &getResp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, nil)))
+		require.Empty(t, getResp.Hash)
+		require.Equal(t, "ES384", exportJWK(t, env, kid)["alg"])
+	})
+
+	t.Run("Rotated key keeps its hash", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env, CreateKeyRequest{KeyType: kms.ECDSAP256TypeDER, Hash: "SHA-512"})
+
+		err := env.cmd.RotateKey(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			RotateKeyRequest{KeyType: kms.ED25519Type}))
+		require.ErrorIs(t, err, kmserrors.ErrValidation)
+
+		var rotateResp RotateKeyResponse
+
+		require.NoError(t, env.cmd.RotateKey(encodeResponse(t, &rotateResp), wrapKeyStoreRequest(t, "key_store_id",
+			kid, RotateKeyRequest{KeyType: kms.ECDSAP256TypeDER})))
+
+		newKID := rotateResp.KeyURL[strings.LastIndex(rotateResp.KeyURL, "/")+1:]
+
+		pub, err := x509.ParsePKIXPublicKey(rotateResp.PublicKey)
+		require.NoError(t, err)
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+			wrapKeyStoreRequest(t, "key_store_id", newKID, SignRequest{Message: message})))
+
+		digest := sha512.Sum512(message)
+		require.True(t, ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], signResp.Signature))
+	})
+
+	t.Run("Create keys in a batch", func(t *testing.T) {
+		env := newEnv(t)
+
+		var resp CreateKeysResponse
+
+		require.NoError(t, env.cmd.CreateKeys(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{
+				{KeyType: kms.ECDSAP384TypeDER, Hash: "SHA-512"},
+				{KeyType: kms.ECDSAP384TypeDER},
+			}})))
+
+		var listResp ListKeysResponse
+
+		require.NoError(t, env.cmd.ListKeys(encodeResponse(t, &listResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", nil)))
+
+		hashes := map[string]string{}
+
+		for _, k := range listResp.Keys {
+			hashes[k.KeyURL] = k.Hash
+		}
+
+		require.Equal(t, map[string]string{resp.Keys[0].KeyURL: "SHA-512", resp.Keys[1].KeyURL: ""}, hashes)
+
+		err := env.cmd.CreateKeys(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeysRequest{Keys: []CreateKeyRequest{{KeyType: kms.ECDSAP384TypeDER, Hash: "SHA-256"}}}))
+		require.ErrorIs(t, err, kmserrors.ErrValidation)
+		require.Contains(t, err.Error(), "key 0:")
+	})
+
+	t.Run("Unsafe or unsupported hashes", func(t *testing.T) {
+		env := newEnv(t)
+
+		tests := []struct {
+			kt      kms.KeyType
+			hash    string
+			allowed string
+		}{
+			{kms.ECDSAP256TypeDER, "SHA-1", "allowed hashes: SHA-256, SHA-384, SHA-512"},
+			{kms.ECDSAP384TypeIEEEP1363, "SHA-256", "allowed hashes: SHA-384, SHA-512"},
+			{kms.ECDSAP521TypeDER, "SHA-384", "allowed hashes: SHA-512"},
+			{kms.ED25519Type, "SHA-512", "only ecdsa keys of nist curves can select a hash"},
+			{secp256k1.KeyTypeDER, "SHA-384", "only ecdsa keys of nist curves can select a hash"},
+		}
+
+		for _, tt := range tests {
+			err := env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+				CreateKeyRequest{KeyType: tt.kt, Hash: tt.hash}))
+			require.ErrorIs(t, err, kmserrors.ErrValidation)
+			require.Contains(t, err.Error(), tt.allowed)
+		}
+	})
+}
+
+func TestCommand_CryptoPools(t *testing.T) {
+	newEnv := func(t *testing.T) (*keyStoreEnv, *cryptopool.Pools, string, string) {
+		t.Helper()
+
+		pools := cryptopool.New(cryptopool.Config{ExpensiveWorkers: 1})
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withCryptoPools(pools), withMetricsProvider(metrics))
+
+		blsKID, _, err := env.userKMS.Create(kms.BLS12381G2Type)
+		require.NoError(t, err)
+
+		edKID, _, err := env.userKMS.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "key_store_id",
+			"controller": "did:example:controller",
+			"keys": map[string]interface{}{
+				blsKID: map[string]interface{}{"key_type": kms.BLS12381G2Type},
+				edKID:  map[string]interface{}{"key_type": kms.ED25519Type},
+			},
+		})
+
+		return env, pools, blsKID, edKID
+	}
+
+	signMultiReq := SignMultiRequest{Messages: [][]byte{[]byte("message 1"), []byte("message 2")}}
+
+	t.Run("Run expensive operations in the pool", func(t *testing.T) {
+		env, _, blsKID, _ := newEnv(t)
+
+		var resp SignMultiResponse
+
+		require.NoError(t, env.cmd.SignMulti(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", blsKID, signMultiReq)))
+		require.NotEmpty(t, resp.Signature)
+	})
+
+	t.Run("Reject expensive operations with 429 if the pool is busy", func(t *testing.T) {
+		env, pools, blsKID, edKID := newEnv(t)
+
+		started, release := make(chan struct{}), make(chan struct{})
+		defer close(release)
+
+		go func() {
+			_ = pools.Do(kms.BLS12381G2Type, func() error { //nolint:errcheck
+				close(started)
+				<-release
+
+				return nil
+			})
+		}()
+
+		<-started
+
+		err := env.cmd.SignMulti(nil, wrapKeyStoreRequest(t, "key_store_id", blsKID, signMultiReq))
+		require.Error(t, err)
+		require.Equal(t, http.StatusTooManyRequests, kmserrors.StatusCodeFromError(err))
+		require.ErrorIs(t, err, kmserrors.ErrTooManyRequests)
+
+		var resp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", edKID, SignRequest{Message: []byte("message")})))
+		require.NotEmpty(t, resp.Signature)
+	})
+}
+
+func TestCommand_CryptoBoxKey(t *testing.T) {
+	nonce := bytes.Repeat([]byte{1}, 24)
+	theirPub := bytes.Repeat([]byte{2}, 32)
+
+	newEnv := func(t *testing.T, cryptoBox CryptoBox, kt kms.KeyType) (*keyStoreEnv, string, []byte) {
+		t.Helper()
+
+		creator := NewMockCryptoBoxCreator(gomock.NewController(t))
+		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).AnyTimes()
+
+		env := newKeyStoreEnv(t, withCryptoBoxCreator(creator))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		kid, _, err := env.userKMS.Create(kt)
+		require.NoError(t, err)
+
+		pub, _, err := env.userKMS.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		return env, kid, pub
+	}
+
+	t.Run("Easy", func(t *testing.T) {
+		cryptoBox := NewMockCryptoBox(gomock.NewController(t))
+		env, kid, _ := newEnv(t, cryptoBox, kms.ED25519Type)
+
+		cryptoBox.EXPECT().Easy([]byte("payload"), nonce, theirPub, kid).Return([]byte("ciphertext"), nil)
+
+		var resp EasyResponse
+
+		require.NoError(t, env.cmd.Easy(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EasyRequest{Payload: []byte("payload"), Nonce: nonce, TheirPub: theirPub})))
+		require.Equal(t, []byte("ciphertext"), resp.Ciphertext)
+	})
+
+	t.Run("EasyOpen defaults my_pub to the key", func(t *testing.T) {
+		cryptoBox := NewMockCryptoBox(gomock.NewController(t))
+		env, kid, pub := newEnv(t, cryptoBox, kms.ED25519Type)
+
+		cryptoBox.EXPECT().EasyOpen([]byte("ciphertext"), nonce, theirPub, pub).Return([]byte("plaintext"), nil)
+
+		var resp EasyOpenResponse
+
+		require.NoError(t, env.cmd.EasyOpen(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			EasyOpenRequest{Ciphertext: []byte("ciphertext"), Nonce: nonce, TheirPub: theirPub})))
+		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+	})
+
+	t.Run("SealOpen with my_pub of the key", func(t *testing.T) {
+		cryptoBox := NewMockCryptoBox(gomock.NewController(t))
+		env, kid, pub := newEnv(t, cryptoBox, kms.ED25519Type)
+
+		cryptoBox.EXPECT().SealOpen([]byte("ciphertext"), pub).Return([]byte("plaintext"), nil)
+
+		var resp SealOpenResponse
+
+		require.NoError(t, env.cmd.SealOpen(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			SealOpenRequest{Ciphertext: []byte("ciphertext"), MyPub: pub})))
+		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+	})
+
+	t.Run("Fail with my_pub of another key", func(t *testing.T) {
+		env, kid, _ := newEnv(t, NewMockCryptoBox(gomock.NewController(t)), kms.ED25519Type)
+
+		err := env.cmd.SealOpen(io.Discard, wrapKeyStoreRequest(t, "key_store_id", kid,
+			SealOpenRequest{Ciphertext: []byte("ciphertext"), MyPub: theirPub}))
+		require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		require.Contains(t, err.Error(), "my_pub doesn't match key "+kid)
+	})
+
+	t.Run("Fail with key that is not ED25519", func(t *testing.T) {
+		env, kid, _ := newEnv(t, NewMockCryptoBox(gomock.NewController(t)), kms.ECDSAP256TypeIEEEP1363)
+
+		err := env.cmd.Easy(io.Discard, wrapKeyStoreRequest(t, "key_store_id", kid,
+			EasyRequest{Payload: []byte("payload"), Nonce: nonce, TheirPub: theirPub}))
+		require.True(t, errors.Is(err, kmserrors.ErrUnprocessableEntity))
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+		require.Contains(t, err.Error(), "crypto box requires an ED25519 key")
+	})
+
+	t.Run("Fail with unknown key", func(t *testing.T) {
+		env, _, _ := newEnv(t, NewMockCryptoBox(gomock.NewController(t)), kms.ED25519Type)
+
+		err := env.cmd.EasyOpen(io.Discard, wrapKeyStoreRequest(t, "key_store_id", "unknown",
+			EasyOpenRequest{Ciphertext: []byte("ciphertext"), Nonce: nonce, TheirPub: theirPub}))
+		require.True(t, errors.Is(err, kmserrors.ErrNotFound))
+	})
+
+	t.Run("Fail with invalid their_pub or nonce", func(t *testing.T) {
+		env, kid, _ := newEnv(t, NewMockCryptoBox(gomock.NewController(t)), kms.ED25519Type)
+
+		err := env.cmd.Easy(io.Discard, wrapKeyStoreRequest(t, "key_store_id", kid,
+			EasyRequest{Payload: []byte("payload"), Nonce: nonce, TheirPub: []byte("their pub")}))
+		require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		require.Contains(t, err.Error(), "their_pub must be a 32-byte Curve25519 public key")
+
+		err = env.cmd.EasyOpen(io.Discard, wrapKeyStoreRequest(t, "key_store_id", kid,
+			EasyOpenRequest{Ciphertext: []byte("ciphertext"), Nonce: []byte("nonce"), TheirPub: theirPub}))
+		require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		require.Contains(t, err.Error(), "nonce must be 24 bytes")
+	})
+}
+
+func TestCommand_WrapKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			WrapValue: &crypto.RecipientWrappedKey{},
+		}))
+
+		req, err := json.Marshal(WrapKeyRequest{
+			CEK:             []byte("cek"),
+			APU:             []byte("apu"),
+			APV:             []byte("apv"),
+			RecipientPubKey: &crypto.PublicKey{},
+			Tag:             []byte("tag"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp WrapKeyResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.NotNil(t, resp.RecipientWrappedKey)
+	})
+
+	t.Run("Fail to wrap a key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			WrapError: errors.New("wrap error"),
+		}))
+
+		req, err := json.Marshal(WrapKeyRequest{
+			CEK:             []byte("cek"),
+			APU:             []byte("apu"),
+			APV:             []byte("apv"),
+			RecipientPubKey: &crypto.PublicKey{},
+			Tag:             []byte("tag"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "wrap key: wrap error")
+	})
+
+	t.Run("Wrap with sender_kid and unwrap (Authcrypt)", func(t *testing.T) {
+		localKMS, cmd := createCmdWithLocalKMS(t, 2)
+
+		senderKID, _, err := localKMS.Create(kms.NISTP256ECDHKWType)
+		require.NoError(t, err)
+
+		recipientKID, _, err := localKMS.Create(kms.NISTP256ECDHKWType)
+		require.NoError(t, err)
+
+		pubKey := func(kid string) *crypto.PublicKey {
+			b, _, e := localKMS.ExportPubKeyBytes(kid)
+			require.NoError(t, e)
+
+			var pub crypto.PublicKey
+
+			require.NoError(t, json.Unmarshal(b, &pub))
+
+			return &pub
+		}
+
+		cek := []byte("0123456789abcdef0123456789abcdef")
+
+		req, err := json.Marshal(WrapKeyRequest{
+			CEK:             cek,
+			APU:             []byte("sender"),
+			APV:             []byte("recipient"),
+			RecipientPubKey: pubKey(recipientKID),
+			SenderKID:       senderKID,
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", Request: req})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		require.NoError(t, cmd.WrapKey(&buf, bytes.NewBuffer(wr)))
+
+		var wrapResp WrapKeyResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &wrapResp))
+		require.Contains(t, wrapResp.Alg, "ECDH-1PU")
+
+		req, err = json.Marshal(UnwrapKeyRequest{
+			WrappedKey:   wrapResp.RecipientWrappedKey,
+			SenderPubKey: pubKey(senderKID),
+		})
+		require.NoError(t, err)
+
+		wr, err = json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: recipientKID, Request: req})
+		require.NoError(t, err)
+
+		buf.Reset()
+
+		require.NoError(t, cmd.UnwrapKey(&buf, bytes.NewBuffer(wr)))
+
+		var unwrapResp UnwrapKeyResponse
+
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &unwrapResp))
+		require.Equal(t, cek, unwrapResp.Key)
+	})
+
+	t.Run("Fail with invalid request", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+
+		for _, tc := range []struct {
+			req   WrapKeyRequest
+			keyID string
+			err   string
+		}{
+			{
+				req: WrapKeyRequest{CEK: []byte("cek")},
+				err: "validation failed: recipient_pub_key is required",
+			},
+			{
+				req:   WrapKeyRequest{CEK: []byte("cek"), RecipientPubKey: &crypto.PublicKey{}, SenderKID: "other"},
+				keyID: "key_id",
+				err:   "validation failed: sender_kid other doesn't match key key_id of the request",
+			},
+		} {
+			req, err := json.Marshal(tc.req)
+			require.NoError(t, err)
+
+			wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: tc.keyID, Request: req})
+			require.NoError(t, err)
+
+			err = env.cmd.WrapKey(&bytes.Buffer{}, bytes.NewBuffer(wr))
+			require.EqualError(t, err, tc.err)
+			require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		}
+	})
+}
+
+func TestCommand_UnwrapKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			UnwrapValue: []byte("key"),
+		}))
+
+		req, err := json.Marshal(UnwrapKeyRequest{
+			WrappedKey:   crypto.RecipientWrappedKey{EncryptedCEK: []byte{0}},
+			SenderPubKey: &crypto.PublicKey{},
+			Tag:          []byte("tag"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		require.NoError(t, err)
+
+		var resp UnwrapKeyResponse
+
+		err = json.Unmarshal(buf.Bytes(), &resp)
+		require.NoError(t, err)
+		require.Equal(t, []byte("key"), resp.Key)
+	})
+
+	t.Run("Fail to unwrap a key", func(t *testing.T) {
+		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
+			UnwrapError: errors.New("unwrap error"),
+		}))
+
+		req, err := json.Marshal(UnwrapKeyRequest{
+			WrappedKey:   crypto.RecipientWrappedKey{EncryptedCEK: []byte("0")},
+			SenderPubKey: &crypto.PublicKey{},
+			Tag:          []byte("tag"),
+		})
+		require.NoError(t, err)
+
+		wr, err := json.Marshal(WrappedRequest{
+			KeyStoreID: "key_store_id",
+			KeyID:      "key_id",
+			Request:    req,
+		})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+
+		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		require.EqualError(t, err, "unwrap key: unwrap error")
+	})
+}
+
+func TestCommand_JWE(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	// createKey creates a key in the user's KMS and returns its ID and public key
+	createKey := func(t *testing.T, env *keyStoreEnv, kt kms.KeyType) (string, *crypto.PublicKey) {
+		t.Helper()
+
+		kid, _, err := env.userKMS.Create(kt)
+		require.NoError(t, err)
+
+		pubBytes, _, err := env.userKMS.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		var pub crypto.PublicKey
+
+		require.NoError(t, json.Unmarshal(pubBytes, &pub))
+
+		return kid, &pub
+	}
+
+	encrypt := func(t *testing.T, env *keyStoreEnv, req EncryptJWERequest) string {
+		t.Helper()
+
+		var resp EncryptJWEResponse
+
+		require.NoError(t, env.cmd.EncryptJWE(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, "key_store_id", "", req)))
+
+		return resp.JWE
+	}
+
+	decrypt := func(t *testing.T, env *keyStoreEnv, kid, jwe string) ([]byte, error) {
+		t.Helper()
+
+		var resp DecryptJWEResponse
+
+		w := io.Discard
+		if kid != "" {
+			w = encodeResponse(t, &resp)
+		}
+
+		err := env.cmd.DecryptJWE(w, wrapKeyStoreRequest(t, "key_store_id", kid, DecryptJWERequest{JWE: jwe}))
+
+		return resp.Plaintext, err
+	}
+
+	t.Run("Encrypt for multiple recipients in JSON serialization", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid256, pub256 := createKey(t, env, kms.NISTP256ECDHKWType)
+		kid384, pub384 := createKey(t, env, kms.NISTP384ECDHKWType)
+
+		jwe := encrypt(t, env, EncryptJWERequest{
+			Plaintext:  []byte("secret message"),
+			Recipients: []*crypto.PublicKey{pub256, pub384},
+		})
+		require.True(t, strings.HasPrefix(jwe, "{"))
+
+		for _, kid := range []string{kid256, kid384} {
+			plaintext, err := decrypt(t, env, kid, jwe)
+			require.NoError(t, err)
+			require.Equal(t, []byte("secret message"), plaintext)
+		}
+	})
+
+	t.Run("Encrypt for a single recipient in compact serialization", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, pub := createKey(t, env, kms.NISTP256ECDHKWType)
+
+		jwe := encrypt(t, env, EncryptJWERequest{
+			Plaintext:     []byte("secret message"),
+			Recipients:    []*crypto.PublicKey{pub},
+			Alg:           JWEAlgECDHESA256KW,
+			Enc:           JWEEncA256GCM,
+			Serialization: JWESerializationCompact,
+		})
+
+		parts := strings.Split(jwe, ".")
+		require.Len(t, parts, 5)
+
+		headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+		require.NoError(t, err)
+
+		var header map[string]interface{}
+
+		require.NoError(t, json.Unmarshal(headerBytes, &header))
+		require.Equal(t, "ECDH-ES+A256KW", header["alg"])
+		require.Equal(t, "A256GCM", header["enc"])
+		require.Equal(t, "JOSE", header["typ"])
+
+		plaintext, err := decrypt(t, env, kid, jwe)
+		require.NoError(t, err)
+		require.Equal(t, []byte("secret message"), plaintext)
+	})
+
+	t.Run("Fail to decrypt with a key that isn't a recipient", func(t *testing.T) {
+		env := newEnv(t)
+
+		_, pub := createKey(t, env, kms.NISTP256ECDHKWType)
+		otherKID, _ := createKey(t, env, kms.NISTP256ECDHKWType)
+
+		jwe := encrypt(t, env, EncryptJWERequest{Plaintext: []byte("secret message"),
+			Recipients: []*crypto.PublicKey{pub}})
+
+		_, err := decrypt(t, env, otherKID, jwe)
+
+		var decryptionErr *DecryptionFailedError
+
+		require.ErrorAs(t, err, &decryptionErr)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to decrypt with a key that can't decrypt a JWE", func(t *testing.T) {
+		env := newEnv(t)
+
+		_, pub := createKey(t, env, kms.NISTP256ECDHKWType)
+
+		kid, _, err := env.userKMS.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "key_store_id",
+			"controller": "did:example:controller",
+			"keys":       map[string]interface{}{kid: map[string]interface{}{"key_type": kms.ED25519Type}},
+		})
+
+		jwe := encrypt(t, env, EncryptJWERequest{Plaintext: []byte("secret message"),
+			Recipients: []*crypto.PublicKey{pub}})
+
+		_, err = decrypt(t, env, kid, jwe)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't decrypt a JWE, supported: NISTP256ECDHKW, NISTP384ECDHKW, "+
+			"NISTP521ECDHKW")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to decrypt a JWE with unsupported algorithms", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env, kms.NISTP256ECDHKWType)
+
+		for header, errMsg := range map[string]string{
+			`{"alg":"RSA-OAEP","enc":"A256GCM"}`:        "alg RSA-OAEP is not supported, supported: ECDH-ES+A256KW",
+			`{"alg":"ECDH-ES+A256KW","enc":"XC20P"}`:    "enc XC20P is not supported, supported: A256GCM",
+			`{"alg":"ECDH-1PU+A256KW","enc":"A256GCM"}`: "alg ECDH-1PU+A256KW is not supported",
+		} {
+			jwe := base64.RawURLEncoding.EncodeToString([]byte(header)) + ".a2V5.aXY.Y2lwaGVydGV4dA.dGFn"
+
+			_, err := decrypt(t, env, kid, jwe)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errMsg)
+			require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+		}
+	})
+
+	t.Run("Fail to decrypt an invalid JWE", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env, kms.NISTP256ECDHKWType)
+
+		_, err := decrypt(t, env, kid, "not a jwe")
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+	})
+
+	x25519Pub := &crypto.PublicKey{Type: "OKP", Curve: "X25519", X: []byte("x"), Y: []byte("y")}
+
+	for _, tc := range []struct {
+		name   string
+		req    EncryptJWERequest
+		status int
+		err    string
+	}{
+		{
+			name:   "unsupported alg",
+			req:    EncryptJWERequest{Alg: "ECDH-ES+XC20PKW"},
+			status: http.StatusUnprocessableEntity,
+			err:    "alg ECDH-ES+XC20PKW is not supported, supported: ECDH-ES+A256KW",
+		},
+		{
+			name:   "unsupported enc",
+			req:    EncryptJWERequest{Enc: "A128CBC-HS256"},
+			status: http.StatusUnprocessableEntity,
+			err:    "enc A128CBC-HS256 is not supported, supported: A256GCM",
+		},
+		{
+			name:   "unsupported serialization",
+			req:    EncryptJWERequest{Serialization: "flattened"},
+			status: http.StatusUnprocessableEntity,
+			err:    "serialization flattened is not supported, supported: json, compact",
+		},
+		{
+			name:   "unsupported recipient key",
+			req:    EncryptJWERequest{Recipients: []*crypto.PublicKey{x25519Pub}},
+			status: http.StatusUnprocessableEntity,
+			err:    "recipient 0: OKP key on curve X25519 is not supported with ECDH-ES+A256KW",
+		},
+		{
+			name:   "no recipients",
+			req:    EncryptJWERequest{},
+			status: http.StatusBadRequest,
+			err:    "recipients must be non-empty",
+		},
+		{
+			name: "multiple recipients in compact serialization",
+			req: EncryptJWERequest{Serialization: JWESerializationCompact,
+				Recipients: []*crypto.PublicKey{x25519Pub, x25519Pub}},
+			status: http.StatusBadRequest,
+			err:    "compact serialization allows a single recipient",
+		},
+	} {
+		tc := tc
+
+		t.Run("Fail to encrypt with "+tc.name, func(t *testing.T) {
+			env := newEnv(t)
+
+			err := env.cmd.EncryptJWE(nil, wrapKeyStoreRequest(t, "key_store_id", "", tc.req))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+			require.Equal(t, tc.status, kmserrors.StatusCodeFromError(err))
+		})
+	}
+}
+
+func TestCommand_DeriveKey(t *testing.T) {
+	newEnv := func(t *testing.T, opts ...configOption) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, append(opts, withMetricsProvider(metrics))...)
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	// createKey creates an X25519 key in the user's KMS and returns its ID and public key
+	createKey := func(t *testing.T, env *keyStoreEnv) (string, *crypto.PublicKey) {
+		t.Helper()
+
+		kid, pubBytes, err := env.userKMS.CreateAndExportPubKeyBytes(kms.X25519ECDHKWType)
+		require.NoError(t, err)
+
+		var pub crypto.PublicKey
+
+		require.NoError(t, json.Unmarshal(pubBytes, &pub))
+
+		return kid, &pub
+	}
+
+	peerPriv := make([]byte, x25519.KeySize)
+	_, err := rand.Read(peerPriv)
+	require.NoError(t, err)
+
+	peerPub, err := curve25519.X25519(peerPriv, curve25519.Basepoint)
+	require.NoError(t, err)
+
+	peerJWK := func(x []byte) json.RawMessage {
+		return json.RawMessage(fmt.Sprintf(`{"kty":"OKP","crv":"X25519","x":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(x)))
+	}
+
+	deriveKey := func(t *testing.T, env *keyStoreEnv, kid string, req DeriveKeyRequest) (*DeriveKeyResponse, error) {
+		t.Helper()
+
+		var resp DeriveKeyResponse
+
+		err := env.cmd.DeriveKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid, req))
+
+		return &resp, err
+	}
+
+	t.Run("Derive a raw key", func(t *testing.T) {
+		env := newEnv(t, func(c *Config) { c.EnableRawDerivedKeys = true })
+
+		kid, pub := createKey(t, env)
+
+		resp, err := deriveKey(t, env, kid, DeriveKeyRequest{
+			PeerPublicKey: peerJWK(peerPub),
+			KDF:           DeriveKeyKDF{Alg: KDFHKDFSHA256, Salt: []byte("salt"), Info: []byte("info"), Length: 48},
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp.WrappedKey)
+		require.Len(t, resp.Key, 48)
+
+		// the peer derives the same key with the public key of the key
+		expected, err := x25519.DeriveKey(peerPriv, pub.X, []byte("salt"), []byte("info"), 48)
+		require.NoError(t, err)
+		require.Equal(t, expected, resp.Key)
+
+		resp, err = deriveKey(t, env, kid, DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub)})
+		require.NoError(t, err)
+		require.Len(t, resp.Key, DefaultDerivedKeyLength)
+	})
+
+	t.Run("Derive a key wrapped for a recipient", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, pub := createKey(t, env)
+		recipientKID, recipient := createKey(t, env)
+
+		resp, err := deriveKey(t, env, kid, DeriveKeyRequest{
+			PeerPublicKey: peerJWK(peerPub),
+			KDF:           DeriveKeyKDF{Info: []byte("info")},
+			Recipient:     recipient,
+		})
+		require.NoError(t, err)
+		require.Nil(t, resp.Key)
+		require.NotNil(t, resp.WrappedKey)
+
+		var unwrapped UnwrapKeyResponse
+
+		require.NoError(t, env.cmd.UnwrapKey(encodeResponse(t, &unwrapped),
+			wrapKeyStoreRequest(t, "key_store_id", recipientKID, UnwrapKeyRequest{WrappedKey: *resp.WrappedKey})))
+
+		expected, err := x25519.DeriveKey(peerPriv, pub.X, nil, []byte("info"), DefaultDerivedKeyLength)
+		require.NoError(t, err)
+		require.Equal(t, expected, unwrapped.Key)
+	})
+
+	t.Run("Fail to derive a raw key if the server doesn't allow it", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid, _ := createKey(t, env)
+
+		_, err := deriveKey(t, env, kid, DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "derived keys are only returned wrapped for a recipient")
+		require.Equal(t, http.StatusForbidden, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail to derive with a key of another type", func(t *testing.T) {
+		env := newEnv(t, func(c *Config) { c.EnableRawDerivedKeys = true })
+
+		kid, _, err := env.userKMS.Create(kms.NISTP256ECDHKWType)
+		require.NoError(t, err)
+
+		_, err = deriveKey(t, env, kid, DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "can't derive keys")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "key_store_id",
+			"controller": "did:example:controller",
+			"keys":       map[string]interface{}{kid: map[string]interface{}{"key_type": kms.NISTP256ECDHKWType}},
+		})
+
+		_, err = deriveKey(t, env, kid, DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "of type NISTP256ECDHKW can't derive keys, supported: X25519ECDHKW")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	for _, tc := range []struct {
+		name   string
+		req    DeriveKeyRequest
+		status int
+		err    string
+	}{
+		{
+			name:   "no peer public key",
+			req:    DeriveKeyRequest{},
+			status: http.StatusBadRequest,
+			err:    "peer_public_key is required",
+		},
+		{
+			name:   "invalid peer public key",
+			req:    DeriveKeyRequest{PeerPublicKey: json.RawMessage(`{"kty":"OKP","crv":"X25519","x":"eA"}`)},
+			status: http.StatusBadRequest,
+			err:    "unmarshal peer_public_key",
+		},
+		{
+			name: "peer public key on another curve",
+			req: DeriveKeyRequest{PeerPublicKey: json.RawMessage(`{"kty":"EC","crv":"P-256",` +
+				`"x":"igrFmi0whuihKnj9R3Om1SoMph72wUGeFaBbzG2vzns","y":"efsX5b10x8yjyrj4ny3pGfLcY7Xby1KzgqOdqnsrJIM"}`)},
+			status: http.StatusUnprocessableEntity,
+			err:    "peer_public_key must be an OKP JWK on curve X25519",
+		},
+		{
+			name:   "low order peer public key",
+			req:    DeriveKeyRequest{PeerPublicKey: peerJWK(make([]byte, x25519.KeySize))},
+			status: http.StatusBadRequest,
+			err:    "invalid x25519 public key",
+		},
+		{
+			name:   "unsupported kdf",
+			req:    DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub), KDF: DeriveKeyKDF{Alg: "ConcatKDF"}},
+			status: http.StatusUnprocessableEntity,
+			err:    "kdf alg ConcatKDF is not supported, supported: HKDF-SHA256",
+		},
+		{
+			name:   "invalid length",
+			req:    DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub), KDF: DeriveKeyKDF{Length: 20}},
+			status: http.StatusBadRequest,
+			err:    "kdf length must be a multiple of 8 between 16 and 64",
+		},
+		{
+			name:   "recipient without key",
+			req:    DeriveKeyRequest{PeerPublicKey: peerJWK(peerPub), Recipient: &crypto.PublicKey{Curve: "X25519"}},
+			status: http.StatusBadRequest,
+			err:    "recipient must have an x coordinate",
+		},
+	} {
+		tc := tc
+
+		t.Run("Fail to derive with "+tc.name, func(t *testing.T) {
+			env := newEnv(t, func(c *Config) { c.EnableRawDerivedKeys = true })
+
+			kid, _ := createKey(t, env)
+
+			_, err := deriveKey(t, env, kid, tc.req)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+			require.Equal(t, tc.status, kmserrors.StatusCodeFromError(err))
+		})
+	}
+}
+
+func TestCommand_DeriveSubkey(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv, kt kms.KeyType) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			CreateKeyRequest{KeyType: kt})))
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	deriveSubkey := func(t *testing.T, env *keyStoreEnv, kid string, req DeriveSubkeyRequest) (string, error) {
+		t.Helper()
+
+		var resp DeriveSubkeyResponse
+
+		err := env.cmd.DeriveSubkey(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid, req))
+		if err != nil {
+			return "", err
+		}
+
+		require.Equal(t, SchemaVersion, resp.SchemaVersion)
+		require.NotZero(t, resp.Sequence)
+
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:], nil
+	}
+
+	computeMAC := func(t *testing.T, env *keyStoreEnv, kid string) []byte {
+		t.Helper()
+
+		var resp ComputeMACResponse
+
+		require.NoError(t, env.cmd.ComputeMAC(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", kid,
+			ComputeMACRequest{Data: []byte("data")})))
+
+		// without the key ID prefix of the MAC
+		return resp.MAC[5:]
+	}
+
+	t.Run("Derive an encryption key", func(t *testing.T) {
+		env := newEnv(t)
+
+		master := createKey(t, env, kms.HMACSHA256Tag256Type)
+
+		kid, err := deriveSubkey(t, env, master, DeriveSubkeyRequest{
+			Salt: []byte("salt"), Info: []byte("tenant-1"), KeyType: kms.AES256GCMType, Alias: "tenant-1",
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, master, kid)
+
+		var encrypted EncryptResponse
+
+		require.NoError(t, env.cmd.Encrypt(encodeResponse(t, &encrypted), wrapKeyStoreRequest(t, "key_store_id",
+			"tenant-1", EncryptRequest{Message: []byte("message")})))
+
+		var decrypted DecryptResponse
+
+		require.NoError(t, env.cmd.Decrypt(encodeResponse(t, &decrypted), wrapKeyStoreRequest(t, "key_store_id", kid,
+			DecryptRequest{Ciphertext: encrypted.Ciphertext, Nonce: encrypted.Nonce})))
+		require.Equal(t, "message", string(decrypted.Plaintext))
+
+		var key GetKeyResponse
+
+		require.NoError(t, env.cmd.GetKey(encodeResponse(t, &key), wrapKeyStoreRequest(t, "key_store_id", kid, nil)))
+		require.Equal(t, string(kms.AES256GCMType), key.KeyType)
+		require.Equal(t, "tenant-1", key.Alias)
+		require.Equal(t, KeyOriginDerived, key.Origin)
+	})
+
+	t.Run("Same salt and info derive the same key material", func(t *testing.T) {
+		env := newEnv(t)
+
+		master := createKey(t, env, kms.HMACSHA256Tag256Type)
+
+		req := DeriveSubkeyRequest{Salt: []byte("salt"), Info: []byte("tenant-1"), KeyType: kms.HMACSHA256Tag256Type}
+
+		kid1, err := deriveSubkey(t, env, master, req)
+		require.NoError(t, err)
+
+		kid2, err := deriveSubkey(t, env, master, req)
+		require.NoError(t, err)
+		require.NotEqual(t, kid1, kid2)
+
+		req.Info = []byte("tenant-2")
+
+		kid3, err := deriveSubkey(t, env, master, req)
+		require.NoError(t, err)
+
+		require.Equal(t, computeMAC(t, env, kid1), computeMAC(t, env, kid2))
+		require.NotEqual(t, computeMAC(t, env, kid1), computeMAC(t, env, kid3))
+		require.NotEqual(t, computeMAC(t, env, master), computeMAC(t, env, kid1))
+	})
+
+	t.Run("Fail to derive from an asymmetric key", func(t *testing.T) {
+		env := newEnv(t)
+
+		kid := createKey(t, env, kms.ED25519Type)
+
+		_, err := deriveSubkey(t, env, kid, DeriveSubkeyRequest{KeyType: kms.AES256GCMType})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "of type ED25519 can't derive keys")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+
+		// a key without key type in the metadata is checked when it's read
+		kid, _, err = env.userKMS.Create(kms.ECDSAP256TypeDER)
+		require.NoError(t, err)
+
+		_, err = deriveSubkey(t, env, kid, DeriveSubkeyRequest{KeyType: kms.AES256GCMType})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key is not an hmac key")
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with alias of another key", func(t *testing.T) {
+		env := newEnv(t)
+
+		master := createKey(t, env, kms.HMACSHA256Tag256Type)
+
+		_, err := deriveSubkey(t, env, master, DeriveSubkeyRequest{KeyType: kms.AES128GCMType, Alias: "index"})
+		require.NoError(t, err)
+
+		_, err = deriveSubkey(t, env, master, DeriveSubkeyRequest{KeyType: kms.AES128GCMType, Alias: "index"})
+		require.Error(t, err)
+		require.Equal(t, http.StatusConflict, kmserrors.StatusCodeFromError(err))
+	})
+
+	t.Run("Fail with invalid request", func(t *testing.T) {
+		env := newEnv(t)
+
+		master := createKey(t, env, kms.HMACSHA256Tag256Type)
+
+		_, err := deriveSubkey(t, env, master, DeriveSubkeyRequest{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "key_type is required")
+		require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+
+		_, err = deriveSubkey(t, env, master, DeriveSubkeyRequest{KeyType: kms.ED25519Type})
+		require.EqualError(t, err, "unprocessable entity: keys of type ED25519 can't be derived, supported: "+
+			"AES128GCM, AES256GCM, ChaCha20Poly1305, HMACSHA256Tag256, XChaCha20Poly1305")
+
+		_, err = deriveSubkey(t, env, "unknown", DeriveSubkeyRequest{KeyType: kms.AES256GCMType})
+		require.Error(t, err)
+		require.Equal(t, http.StatusNotFound, kmserrors.StatusCodeFromError(err))
+	})
+}
+
+func TestCommand_SchemaVersion(t *testing.T) {
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+		return newKeyStoreEnv(t, withMetricsProvider(metrics))
+	}
+
+	createKey := func(t *testing.T, env *keyStoreEnv) (string, string) {
+		t.Helper()
+
+		var ksResp CreateKeyStoreResponse
+
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, ksResp.SchemaVersion)
+
+		keyStoreID := strings.TrimPrefix(ksResp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+
+		var keyResp CreateKeyResponse
+
+		err = env.cmd.CreateKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, "",
+			CreateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, keyResp.SchemaVersion)
+
+		return keyStoreID, keyResp.KeyURL[strings.LastIndex(keyResp.KeyURL, "/")+1:]
+	}
+
+	// setVersion rewrites the stored metadata as a newer server would, with a field this server doesn't know.
+	setVersion := func(t *testing.T, env *keyStoreEnv, keyStoreID, keyID string, version int) {
+		t.Helper()
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+
+		if keyID == "" {
+			meta["schema_version"] = version
+			meta["unknown_field"] = "value"
+		} else {
+			key := meta["keys"].(map[string]interface{})[keyID].(map[string]interface{})
+			key["schema_version"] = version
+			key["unknown_field"] = "value"
+		}
+
+		env.putKeyStore(t, meta)
+	}
+
+	t.Run("Records are saved with the current version", func(t *testing.T) {
+		env := newEnv(t)
+		keyStoreID, keyID := createKey(t, env)
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.EqualValues(t, SchemaVersion, meta["schema_version"])
+		require.EqualValues(t, SchemaVersion,
+			meta["keys"].(map[string]interface{})[keyID].(map[string]interface{})["schema_version"])
+
+		var ksResp GetKeyStoreResponse
+
+		err = env.cmd.GetKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, keyStoreID, "", nil))
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, ksResp.SchemaVersion)
+
+		var keyResp GetKeyResponse
+
+		err = env.cmd.GetKey(encodeResponse(t, &keyResp), wrapKeyStoreRequest(t, keyStoreID, keyID, nil))
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, keyResp.SchemaVersion)
+	})
+
+	t.Run("Records without version are upgraded on save", func(t *testing.T) {
+		env := newEnv(t)
+
+		env.putKeyStore(t, map[string]interface{}{
+			"id":         "old_key_store_id",
+			"controller": "did:example:controller",
+		})
+
+		var ksResp GetKeyStoreResponse
+
+		err := env.cmd.GetKeyStore(encodeResponse(t, &ksResp), wrapKeyStoreRequest(t, "old_key_store_id", "", nil))
+		require.NoError(t, err)
+		require.Equal(t, 0, ksResp.SchemaVersion)
+
+		err = env.cmd.CreateKey(encodeResponse(t, &CreateKeyResponse{}), wrapKeyStoreRequest(t, "old_key_store_id",
+			"", CreateKeyRequest{KeyType: kms.ED25519Type}))
+		require.NoError(t, err)
+
+		meta, err := env.getKeyStore("old_key_store_id")
+		require.NoError(t, err)
+		require.EqualValues(t, SchemaVersion, meta["schema_version"])
+	})
+
+	t.Run("Key store of newer version is refused", func(t *testing.T) {
+		env := newEnv(t)
+		keyStoreID, keyID := createKey(t, env)
+
+		setVersion(t, env, keyStoreID, "", SchemaVersion+1)
+
+		before, err := env.keyStores.Get(keyStoreID)
+		require.NoError(t, err)
+
+		requests := map[string]func() error{
+			"get key store": func() error {
+				return env.cmd.GetKeyStore(nil, wrapKeyStoreRequest(t, keyStoreID, "", nil))
+			},
+			"update key store": func() error {
+				return env.cmd.UpdateKeyStore(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+					UpdateKeyStoreRequest{Controller: "did:example:new-controller"}))
+			},
+			"create key": func() error {
+				return env.cmd.CreateKey(nil, wrapKeyStoreRequest(t, keyStoreID, "",
+					CreateKeyRequest{KeyType: kms.ED25519Type}))
+			},
+			"sign": func() error {
+				return env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+					SignRequest{Message: []byte("test message")}))
+			},
+		}
+
+		for name, request := range requests {
+			err = request()
+			require.Error(t, err, name)
+			require.Contains(t, err.Error(), fmt.Sprintf("key store %s has schema version %d, but this server "+
+				"supports schema versions up to %d; a server that supports schema version %d is required",
+				keyStoreID, SchemaVersion+1, SchemaVersion, SchemaVersion+1), name)
+			require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err), name)
+
+			var versionErr *SchemaVersionError
+
+			require.True(t, errors.As(err, &versionErr), name)
+			require.Equal(t, SchemaVersion+1, versionErr.Version, name)
+		}
+
+		after, err := env.keyStores.Get(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+
+	t.Run("Key of newer version is refused", func(t *testing.T) {
+		env := newEnv(t)
+		keyStoreID, keyID := createKey(t, env)
+
+		setVersion(t, env, keyStoreID, keyID, SchemaVersion+1)
+
+		err := env.cmd.Sign(nil, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			SignRequest{Message: []byte("test message")}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), fmt.Sprintf("key %s of key store %s has schema version %d", keyID,
+			keyStoreID, SchemaVersion+1))
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
+
+		meta, err := env.getKeyStore(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, "value",
+			meta["keys"].(map[string]interface{})[keyID].(map[string]interface{})["unknown_field"])
+	})
+
+	t.Run("Repair doesn't fix key store of newer version", func(t *testing.T) {
+		env := newEnv(t)
+		keyStoreID, _ := createKey(t, env)
+
+		setVersion(t, env, keyStoreID, "", SchemaVersion+1)
+
+		report, err := env.cmd.RepairKeyStore(keyStoreID, true)
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 1)
+		require.Equal(t, "metadata", report.Findings[0].Record)
+		require.Contains(t, report.Findings[0].Problem, "has schema version")
+		require.Empty(t, report.Findings[0].Fix)
+		require.False(t, report.Fixed)
+	})
+}
+
+func TestCommand_RequestLimits(t *testing.T) {
+	limits := jsonlimit.Limits{MaxDepth: 3, MaxArrayLength: 2, MaxStringLength: 64}
+
+	for _, tc := range []struct {
+		name  string
+		exec  func(cmd *Command, r io.Reader) error
+		req   string
+		limit string
+		err   string
+	}{
+		{
+			name:  "Nesting depth of sign request",
+			exec:  func(cmd *Command, r io.Reader) error { return cmd.Sign(nil, r) },
+			req:   `{"message": "dGVzdA==", "document": {"a": {"b": {"c": 1}}}}`,
+			limit: jsonlimit.LimitDepth,
+			err:   "unwrap request: bad request: request body: max_depth 3 exceeded at $.document.a.b",
+		},
+		{
+			name:  "Number of messages of sign batch request",
+			exec:  func(cmd *Command, r io.Reader) error { return cmd.SignBatch(nil, r) },
+			req:   `{"messages": ["MQ==", "Mg==", "Mw=="]}`,
+			limit: jsonlimit.LimitArrayLength,
+			err:   "unwrap request: bad request: request body: max_array_length 2 exceeded at $.messages",
+		},
+		{
+			name:  "String size of crypto box request",
+			exec:  func(cmd *Command, r io.Reader) error { return cmd.Easy(nil, r) },
+			req:   `{"payload": "` + strings.Repeat("A", 68) + `"}`,
+			limit: jsonlimit.LimitStringLength,
+			err:   "unwrap request: bad request: request body: max_string_length 64 exceeded at $.payload",
+		},
+		{
+			name:  "Dry run",
+			exec:  func(cmd *Command, r io.Reader) error { return cmd.Validate(ActionSign, r) },
+			req:   `[[[[]]]]`,
+			limit: jsonlimit.LimitDepth,
+			err:   "unwrap request: bad request: request body: max_depth 3 exceeded at $[0][0][0]",
+		},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+
+			metrics := NewMockMetricsProvider(ctrl)
+			metrics.EXPECT().RequestLimitRejection(tc.limit).Times(1)
+
+			env := newKeyStoreEnv(t, withRequestLimits(limits), withMetricsProvider(metrics))
+
+			err := tc.exec(env.cmd, wrapRawKeyStoreRequest(t, "key_store_id", "key_id", tc.req))
+			require.EqualError(t, err, tc.err)
+			require.Equal(t, http.StatusBadRequest, kmserrors.StatusCodeFromError(err))
+		})
+	}
+
+	t.Run("Default limits", func(t *testing.T) {
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().RequestLimitRejection(jsonlimit.LimitDepth).Times(1)
+
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+
+		req := `{"document": ` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`
+
+		err := env.cmd.Sign(nil, wrapRawKeyStoreRequest(t, "key_store_id", "key_id", req))
+		require.ErrorIs(t, err, kmserrors.ErrBadRequest)
+		require.Contains(t, err.Error(), "max_depth 32 exceeded")
+	})
+}
+
+func TestCommand_VerifyWithPublicKey(t *testing.T) {
+	message := []byte("test message")
+
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
+
+		env := newKeyStoreEnv(t)
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
+
+		return env
+	}
+
+	verify := func(t *testing.T, env *keyStoreEnv, req VerifyRequest) (bool, error) {
+		t.Helper()
+
+		var resp VerifyResponse
+
+		err := env.cmd.Verify(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "", req))
+
+		return resp.Verified, err
+	}
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	p384Pub, err := x509.MarshalPKIXPublicKey(&p384Key.PublicKey)
+	require.NoError(t, err)
+
+	p384Digest := sha512.Sum384(message)
+
+	p384Signature, err := ecdsa.SignASN1(rand.Reader, p384Key, p384Digest[:])
+	require.NoError(t, err)
+
+	k1Key, err := ecdsa.GenerateKey(secp256k1.Curve(), rand.Reader)
+	require.NoError(t, err)
+
+	k1Pub, err := secp256k1.MarshalPublicKey(&k1Key.PublicKey, secp256k1.KeyTypeIEEEP1363)
+	require.NoError(t, err)
+
+	k1Signature, err := secp256k1.Sign(k1Key, message, secp256k1.KeyTypeIEEEP1363)
+	require.NoError(t, err)
+
+	tests := []struct {
+		keyType   kms.KeyType
+		pub       []byte
+		key       interface{}
+		signature []byte
+	}{
+		{kms.ED25519Type, edPub, edPub, ed25519.Sign(edPriv, message)},
+		{kms.ECDSAP384TypeDER, p384Pub, &p384Key.PublicKey, p384Signature},
+		{secp256k1.KeyTypeIEEEP1363, k1Pub, &k1Key.PublicKey, k1Signature},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(fmt.Sprintf("Verify %s signature with public key and JWK", tc.keyType), func(t *testing.T) {
+			env := newEnv(t)
+
+			j, err := jwksupport.JWKFromKey(tc.key)
+			require.NoError(t, err)
+
+			jwkBytes, err := j.MarshalJSON()
+			require.NoError(t, err)
+
+			for _, req := range []VerifyRequest{
+				{PublicKey: tc.pub, KeyType: tc.keyType},
+				{JWK: jwkBytes, KeyType: tc.keyType},
+			} {
+				req.Signature, req.Message = tc.signature, message
+
+				verified, err := verify(t, env, req)
+				require.NoError(t, err)
+				require.True(t, verified)
+
+				// a signature of another message is not an error
+				req.Message = []byte("other message")
+
+				verified, err = verify(t, env, req)
+				require.NoError(t, err)
+				require.False(t, verified)
+			}
+		})
+	}
+
+	t.Run("Verify BBS+ signature of messages", func(t *testing.T) {
+		env := newEnv(t)
+
+		pub, priv, err := bbs12381g2pub.GenerateKeyPair(sha256.New, nil)
+		require.NoError(t, err)
+
+		pubBytes, err := pub.Marshal()
+		require.NoError(t, err)
+
+		privBytes, err := priv.Marshal()
+		require.NoError(t, err)
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+		messages := [][]byte{[]byte("message1"), []byte("message2")}
 
-		cmd := createCmd(t, ctrl, withCryptoBoxCreator(creator))
+		signature, err := bbs12381g2pub.New().Sign(messages, privBytes)
+		require.NoError(t, err)
 
-		req, err := json.Marshal(EasyRequest{
-			Payload:  []byte("payload"),
-			Nonce:    []byte("nonce"),
-			TheirPub: []byte("their pub"),
+		verified, err := verify(t, env, VerifyRequest{
+			Signature: signature, Messages: messages, PublicKey: pubBytes, KeyType: kms.BLS12381G2Type,
 		})
 		require.NoError(t, err)
+		require.True(t, verified)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
+		_, err = verify(t, env, VerifyRequest{
+			Signature: signature, Message: message, PublicKey: pubBytes, KeyType: kms.BLS12381G2Type,
 		})
-		require.NoError(t, err)
+		require.True(t, errors.Is(err, kmserrors.ErrValidation))
+	})
 
-		var buf bytes.Buffer
+	t.Run("Invalid public keys are unprocessable", func(t *testing.T) {
+		env := newEnv(t)
 
-		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+		j, err := jwksupport.JWKFromKey(&p384Key.PublicKey)
 		require.NoError(t, err)
 
-		var resp EasyResponse
-
-		err = json.Unmarshal(buf.Bytes(), &resp)
+		p384JWK, err := j.MarshalJSON()
 		require.NoError(t, err)
-		require.Equal(t, []byte("ciphertext"), resp.Ciphertext)
+
+		for _, req := range []VerifyRequest{
+			{PublicKey: []byte("not a key"), KeyType: kms.ECDSAP256TypeDER},
+			{PublicKey: p384Pub, KeyType: kms.ED25519Type},
+			{PublicKey: edPub, KeyType: kms.X25519ECDHKWType},
+			{JWK: []byte(`{"kty":"EC"}`), KeyType: kms.ECDSAP384TypeDER},
+			{JWK: p384JWK, KeyType: kms.ECDSAP256TypeDER},
+			{JWK: p384JWK, KeyType: kms.ED25519Type},
+		} {
+			req.Signature, req.Message = p384Signature, message
+
+			_, err = verify(t, env, req)
+			require.Error(t, err, string(req.KeyType))
+			require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err), err.Error())
+		}
 	})
 
-	t.Run("Fail to seal a payload", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+	t.Run("Invalid requests", func(t *testing.T) {
+		env := newEnv(t)
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().Easy(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-			Return(nil, errors.New("easy error")).Times(1)
+		for _, req := range []VerifyRequest{
+			{PublicKey: edPub},
+			{PublicKey: edPub, JWK: []byte(`{}`), KeyType: kms.ED25519Type},
+		} {
+			_, err = verify(t, env, req)
+			require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		}
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", "key_id",
+			VerifyRequest{PublicKey: edPub, KeyType: kms.ED25519Type}))
+		require.True(t, errors.Is(err, kmserrors.ErrValidation))
+		require.Contains(t, err.Error(), "can't be combined with a key of the key store")
+	})
 
-		cmd := createCmd(t, ctrl, withCryptoBoxCreator(creator))
+	t.Run("Key store not found", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
 
-		req, err := json.Marshal(EasyRequest{
-			Payload:  []byte("payload"),
-			Nonce:    []byte("nonce"),
-			TheirPub: []byte("their pub"),
-		})
-		require.NoError(t, err)
+		err := env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", "",
+			VerifyRequest{PublicKey: edPub, KeyType: kms.ED25519Type}))
+		require.True(t, errors.Is(err, storage.ErrDataNotFound))
+	})
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+	t.Run("Dry run doesn't need a key", func(t *testing.T) {
+		env := newEnv(t)
 
-		var buf bytes.Buffer
+		require.NoError(t, env.cmd.Validate(ActionVerify, wrapKeyStoreRequest(t, "key_store_id", "",
+			VerifyRequest{PublicKey: edPub, KeyType: kms.ED25519Type})))
 
-		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "unwrap wrap request: easy: easy error")
+		err := env.cmd.Validate(ActionVerify, wrapKeyStoreRequest(t, "key_store_id", "",
+			VerifyRequest{PublicKey: []byte("not a key"), KeyType: kms.ED25519Type}))
+		require.Error(t, err)
+		require.Equal(t, http.StatusUnprocessableEntity, kmserrors.StatusCodeFromError(err))
 	})
 }
 
-func TestCommand_EasyOpen(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+func TestCommand_VerifySignatureFormat(t *testing.T) {
+	message := []byte("test message")
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-			Return([]byte("plaintext"), nil).Times(1)
+	newEnv := func(t *testing.T) *keyStoreEnv {
+		t.Helper()
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+		metrics := NewMockMetricsProvider(gomock.NewController(t))
+		metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+		metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
 
-		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+		env := newKeyStoreEnv(t, withMetricsProvider(metrics))
+		env.putKeyStore(t, map[string]interface{}{"id": "key_store_id", "controller": "did:example:controller"})
 
-		req, err := json.Marshal(EasyOpenRequest{
-			Ciphertext: []byte("payload"),
-			Nonce:      []byte("nonce"),
-			TheirPub:   []byte("their pub"),
-			MyPub:      []byte("my pub"),
+		return env
+	}
+
+	signWithNewKey := func(t *testing.T, env *keyStoreEnv, kt kms.KeyType) (string, []byte) {
+		t.Helper()
+
+		var createResp CreateKeyResponse
+
+		require.NoError(t, env.cmd.CreateKey(encodeResponse(t, &createResp),
+			wrapKeyStoreRequest(t, "key_store_id", "", CreateKeyRequest{KeyType: kt})))
+
+		kid := createResp.KeyURL[strings.LastIndex(createResp.KeyURL, "/")+1:]
+
+		var signResp SignResponse
+
+		require.NoError(t, env.cmd.Sign(encodeResponse(t, &signResp),
+			wrapKeyStoreRequest(t, "key_store_id", kid, SignRequest{Message: message})))
+
+		return kid, signResp.Signature
+	}
+
+	tests := []struct {
+		keyType kms.KeyType
+		size    int
+		convert func([]byte, int) ([]byte, error)
+	}{
+		{kms.ECDSAP256TypeDER, 32, ecdsasig.ToIEEEP1363},
+		{kms.ECDSAP521TypeDER, 66, ecdsasig.ToIEEEP1363},
+		{kms.ECDSAP384TypeIEEEP1363, 48, ecdsasig.ToDER},
+		{secp256k1.KeyTypeIEEEP1363, 32, ecdsasig.ToDER},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run("Verify signature in the other format with "+string(tc.keyType)+" key", func(t *testing.T) {
+			env := newEnv(t)
+			kid, sig := signWithNewKey(t, env, tc.keyType)
+
+			converted, err := tc.convert(sig, tc.size)
+			require.NoError(t, err)
+
+			require.NoError(t, env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: converted, Message: message})))
+
+			err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+				VerifyRequest{Signature: converted, Message: []byte("other message")}))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "signature encoding mismatch")
 		})
+	}
+
+	t.Run("Mismatch names the formats", func(t *testing.T) {
+		env := newEnv(t)
+		kid, sig := signWithNewKey(t, env, kms.ECDSAP256TypeIEEEP1363)
+
+		der, err := ecdsasig.ToDER(sig, 32)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: der, Message: []byte("other message")}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "signature encoding mismatch: expected IEEE_P1363, got DER")
+
+		// signatures in the format of the key are not converted
+		err = env.cmd.Verify(nil, wrapKeyStoreRequest(t, "key_store_id", kid,
+			VerifyRequest{Signature: sig, Message: []byte("other message")}))
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), "signature encoding mismatch")
+	})
+
+	t.Run("Verify signature in the other format with public key", func(t *testing.T) {
+		env := newEnv(t)
+
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		j, err := jwksupport.JWKFromKey(&key.PublicKey)
+		require.NoError(t, err)
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		jwkBytes, err := j.MarshalJSON()
 		require.NoError(t, err)
 
-		var resp EasyOpenResponse
+		digest := sha512.Sum384(message)
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
 		require.NoError(t, err)
-		require.Equal(t, []byte("plaintext"), resp.Plaintext)
+
+		var resp VerifyResponse
+
+		require.NoError(t, env.cmd.Verify(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "key_store_id", "",
+			VerifyRequest{Signature: sig, Message: message, JWK: jwkBytes, KeyType: kms.ECDSAP384TypeIEEEP1363})))
+		require.True(t, resp.Verified)
 	})
+}
 
-	t.Run("Fail to unseal a ciphertext", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+func TestMigrateKeyStores(t *testing.T) {
+	createKeyStore := func(t *testing.T, env *keyStoreEnv) string {
+		t.Helper()
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return(nil, errors.New("seal open error")).Times(1)
-		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
-			Return(nil, errors.New("easy open error")).Times(1)
+		var resp CreateKeyStoreResponse
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+		err := env.cmd.CreateKeyStore(encodeResponse(t, &resp), wrapKeyStoreRequest(t, "", "",
+			CreateKeyStoreRequest{Controller: "did:example:controller"}))
+		require.NoError(t, err)
 
-		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+		return strings.TrimPrefix(resp.KeyStoreURL, "https://kms.example.com/v1/keystores/")
+	}
 
-		req, err := json.Marshal(EasyOpenRequest{
-			Ciphertext: []byte("payload"),
-			Nonce:      []byte("nonce"),
-			TheirPub:   []byte("their pub"),
-			MyPub:      []byte("my pub"),
-		})
+	createKey := func(t *testing.T, env *keyStoreEnv, keyStoreID string) string {
+		t.Helper()
+
+		var resp CreateKeyResponse
+
+		err := env.cmd.CreateKey(encodeResponse(t, &resp),
+			wrapKeyStoreRequest(t, keyStoreID, "", CreateKeyRequest{KeyType: kms.ED25519Type, Alias: "name"}))
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		return resp.KeyURL[strings.LastIndex(resp.KeyURL, "/")+1:]
+	}
+
+	t.Run("Key store metadata, DID URL claims and main key are copied", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
+		keyID := createKey(t, env, keyStoreID)
+
+		err := env.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, keyStoreID, keyID,
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		dst := mem.NewProvider()
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
-		// since both easyopen and sealopen are both part of UnwrapKey, if easyopen fails, sealopen will be executed
-		// and will be logged as last error regardless (we try easyopen first then sealopen in this order)
-		require.EqualError(t, err, "unwrapKey cryptobox request invalid: seal open: seal open error")
-	})
-}
+		report, err := MigrateKeyStores(env.serverStorage, dst, &MigrateOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 1, report.KeyStores)
+		require.Equal(t, 3, report.Records) // metadata, DID URL claim, main key
+		require.Equal(t, 3, report.Verified)
+		// keys of the key store are stored by another provider in tests
+		require.Equal(t, []string{"key store " + keyStoreID + ": key " + keyID}, report.Missing)
 
-func TestCommand_SealOpen(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+		meta, err := env.keyStores.Get(keyStoreID)
+		require.NoError(t, err)
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return([]byte("plaintext"), nil).Times(1)
-		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil,
-			errors.New("easy open error")).Times(1)
+		dstKeyStores, err := dst.OpenStore("keystores")
+		require.NoError(t, err)
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+		dstMeta, err := dstKeyStores.Get(keyStoreID)
+		require.NoError(t, err)
+		require.Equal(t, meta, dstMeta)
 
-		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+		// the DID URL stays claimed by the key store in the destination
+		dstEnv := newKeyStoreEnv(t, withStorageProvider(dst))
+		otherKeyStoreID := createKeyStore(t, dstEnv)
+		otherKeyID := createKey(t, dstEnv, otherKeyStoreID)
 
-		req, err := json.Marshal(SealOpenRequest{
-			Ciphertext: []byte("payload"),
-			MyPub:      []byte("my pub"),
-		})
+		err = dstEnv.cmd.UpdateKey(io.Discard, wrapKeyStoreRequest(t, otherKeyStoreID, otherKeyID,
+			map[string]interface{}{"verification_methods": []string{"did:example:123#key-1"}}))
+
+		var conflictErr *VerificationMethodConflictError
+
+		require.ErrorAs(t, err, &conflictErr)
+	})
+
+	t.Run("Server keys of EDV-backed key stores are copied", func(t *testing.T) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		invoker, err := didkey.FromPublicKey(pub, kms.ED25519Type)
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		invokerKeyID, err := localkms.CreateKID(pub, kms.ED25519Type)
+		require.NoError(t, err)
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
+		capability, err := json.Marshal(map[string]interface{}{"invoker": invoker.VerificationMethod})
 		require.NoError(t, err)
 
-		var resp SealOpenResponse
+		src := mem.NewProvider()
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
+		keyStores, err := src.OpenStore("keystores")
 		require.NoError(t, err)
-		require.Equal(t, []byte("plaintext"), resp.Plaintext)
-	})
 
-	t.Run("Fail to decrypt a ciphertext", func(t *testing.T) {
-		ctrl := gomock.NewController(t)
+		keySets, err := src.OpenStore(localkms.Namespace)
+		require.NoError(t, err)
 
-		cryptoBox := NewMockCryptoBox(ctrl)
-		cryptoBox.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return(nil, errors.New("seal open error")).Times(1)
-		cryptoBox.EXPECT().EasyOpen(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil,
-			errors.New("easy open error")).Times(1)
+		// two key stores authorized with the same capability invoker
+		for _, id := range []string{"ks1", "ks2"} {
+			meta, err := json.Marshal(map[string]interface{}{
+				"id":          id,
+				"controller":  "did:example:controller",
+				"main_key_id": id + "-main",
+				"key_ids":     []string{id + "-vault-key"}, // stored in the vault
+				"edv": map[string]interface{}{
+					"vault_url":        "https://edv.example.com/encrypted-data-vaults/" + id,
+					"recipient_key_id": id + "-recipient",
+					"mac_key_id":       id + "-mac",
+					"capability":       capability,
+				},
+			})
+			require.NoError(t, err)
 
-		creator := NewMockCryptoBoxCreator(ctrl)
-		creator.EXPECT().Create(gomock.Any()).Return(cryptoBox, nil).Times(1)
+			require.NoError(t, keyStores.Put(id, meta, storage.Tag{Name: "controller_hash", Value: "hash"}))
 
-		cmd := createCmd(t, gomock.NewController(t), withCryptoBoxCreator(creator))
+			for _, keyID := range []string{id + "-main", id + "-recipient", id + "-mac"} {
+				require.NoError(t, keySets.Put(prefix.StorageKIDPrefix+keyID, []byte("encrypted keyset "+keyID)))
+			}
+		}
 
-		req, err := json.Marshal(SealOpenRequest{
-			Ciphertext: []byte("payload"),
-			MyPub:      []byte("my pub"),
+		require.NoError(t, keySets.Put(prefix.StorageKIDPrefix+invokerKeyID, []byte("encrypted invoker keyset")))
+
+		var progress []int
+
+		dst := mem.NewProvider()
+
+		report, err := MigrateKeyStores(src, dst, &MigrateOptions{
+			ProgressInterval: 3,
+			Progress: func(report *MigrateReport) {
+				progress = append(progress, report.Records)
+			},
 		})
 		require.NoError(t, err)
+		require.Equal(t, 2, report.KeyStores)
+		require.Equal(t, 9, report.Records) // 2 key stores with 3 keys each, and the invoker key
+		require.Equal(t, 9, report.Verified)
+		require.Empty(t, report.Missing)
+		require.Equal(t, []int{5, 6, 9}, progress)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		dstKeySets, err := dst.OpenStore(localkms.Namespace)
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		keySet, err := dstKeySets.Get(prefix.StorageKIDPrefix + invokerKeyID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("encrypted invoker keyset"), keySet)
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "unwrapKey cryptobox request invalid: seal open: seal open error")
+		_, err = dstKeySets.Get(prefix.StorageKIDPrefix + "ks1-vault-key")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		dstKeyStores, err := dst.OpenStore("keystores")
+		require.NoError(t, err)
+
+		tags, err := dstKeyStores.GetTags("ks2")
+		require.NoError(t, err)
+		require.Equal(t, []storage.Tag{{Name: "controller_hash", Value: "hash"}}, tags)
 	})
-}
 
-func TestCommand_WrapKey(t *testing.T) {
-	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			WrapValue: &crypto.RecipientWrappedKey{},
-		}))
+	t.Run("Dry run doesn't write to the destination", func(t *testing.T) {
+		env := newKeyStoreEnv(t)
+		keyStoreID := createKeyStore(t, env)
 
-		req, err := json.Marshal(WrapKeyRequest{
-			CEK:             []byte("cek"),
-			APU:             []byte("apu"),
-			APV:             []byte("apv"),
-			RecipientPubKey: &crypto.PublicKey{},
-			Tag:             []byte("tag"),
-		})
+		dst := mem.NewProvider()
+
+		report, err := MigrateKeyStores(env.serverStorage, dst, &MigrateOptions{DryRun: true})
 		require.NoError(t, err)
+		require.Equal(t, 1, report.KeyStores)
+		require.Equal(t, 2, report.Records) // metadata and main key
+		require.Zero(t, report.Verified)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		dstKeyStores, err := dst.OpenStore("keystores")
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		_, err = dstKeyStores.Get(keyStoreID)
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
 
-		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
+	t.Run("Fail with invalid key store metadata", func(t *testing.T) {
+		src := mem.NewProvider()
+
+		keyStores, err := src.OpenStore("keystores")
 		require.NoError(t, err)
 
-		var resp WrapKeyResponse
+		require.NoError(t, keyStores.Put("corrupted", []byte("{"),
+			storage.Tag{Name: "controller_hash", Value: "hash"}))
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.NotNil(t, resp.RecipientWrappedKey)
+		_, err = MigrateKeyStores(src, mem.NewProvider(), &MigrateOptions{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "migrate key store corrupted: unmarshal key store meta")
 	})
+}
 
-	t.Run("Fail to wrap a key", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			WrapError: errors.New("wrap error"),
-		}))
+func TestCommand_Validate(t *testing.T) {
+	newCmd := func(t *testing.T) *Command {
+		t.Helper()
 
-		req, err := json.Marshal(WrapKeyRequest{
-			CEK:             []byte("cek"),
-			APU:             []byte("apu"),
-			APV:             []byte("apv"),
-			RecipientPubKey: &crypto.PublicKey{},
-			Tag:             []byte("tag"),
+		// key store is never opened in dry-run mode
+		creator := NewMockKeyStoreCreator(gomock.NewController(t))
+		creator.EXPECT().Create(gomock.Any(), gomock.Any()).Times(0)
+
+		p := mockstorage.NewMockStoreProvider()
+		p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: []byte(`{"id":"key_store_id"}`)}
+
+		cmd, err := New(&Config{
+			StorageProvider: p,
+			KMS:             &mockkms.KeyManager{},
+			KeyStoreCreator: creator,
 		})
 		require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
+		return cmd
+	}
+
+	wrap := func(t *testing.T, keyStoreID, keyID string, req interface{}) *bytes.Buffer {
+		t.Helper()
+
+		r, err := json.Marshal(req)
 		require.NoError(t, err)
 
-		var buf bytes.Buffer
+		wr, err := json.Marshal(WrappedRequest{KeyStoreID: keyStoreID, KeyID: keyID, Request: r})
+		require.NoError(t, err)
 
-		err = cmd.WrapKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "wrap key: wrap error")
-	})
-}
+		return bytes.NewBuffer(wr)
+	}
 
-func TestCommand_UnwrapKey(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			UnwrapValue: []byte("key"),
-		}))
+		cmd := newCmd(t)
+
+		require.NoError(t, cmd.Validate(ActionSign, wrap(t, "key_store_id", "key_id", SignRequest{})))
+		require.NoError(t, cmd.Validate(ActionCreateKey,
+			wrap(t, "key_store_id", "", CreateKeyRequest{KeyType: kms.ED25519Type})))
+		require.NoError(t, cmd.Validate(ActionExportKey, wrap(t, "key_store_id", "key_id", nil)))
+		require.NoError(t, cmd.Validate(ActionSignBatch,
+			wrap(t, "key_store_id", "key_id", SignBatchRequest{Messages: [][]byte{[]byte("message")}})))
+		require.NoError(t, cmd.Validate(ActionSignJWT,
+			wrap(t, "key_store_id", "key_id", SignJWTRequest{Claims: json.RawMessage(`{"sub":"subject"}`)})))
+		require.NoError(t, cmd.Validate(ActionEncryptJWE, wrap(t, "key_store_id", "", EncryptJWERequest{
+			Plaintext:  []byte("plaintext"),
+			Recipients: []*crypto.PublicKey{{KID: "recipient", X: []byte("x"), Y: []byte("y"), Curve: "P-256", Type: "EC"}},
+		})))
+	})
 
-		req, err := json.Marshal(UnwrapKeyRequest{
-			WrappedKey:   crypto.RecipientWrappedKey{EncryptedCEK: []byte{0}},
-			SenderPubKey: &crypto.PublicKey{},
-			Tag:          []byte("tag"),
-		})
-		require.NoError(t, err)
+	t.Run("Supports every key store action", func(t *testing.T) {
+		cmd := newCmd(t)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+		for _, h := range rest.New(cmd).GetRESTHandlers() {
+			if !h.Auth().HasFlag(rest.AuthZCAP) {
+				continue
+			}
 
-		var buf bytes.Buffer
+			// requests are empty, so most of them fail validation, but not as unsupported
+			err := cmd.Validate(h.Action(), wrap(t, "key_store_id", "key_id", nil))
+			if err != nil {
+				require.NotContains(t, err.Error(), "dry run is not supported", h.Path())
+			}
+		}
+	})
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
-		require.NoError(t, err)
+	t.Run("Fail with too many messages in batch", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionSignBatch, wrap(t, "key_store_id", "key_id", SignBatchRequest{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "number of messages must be from 1 to")
+	})
 
-		var resp UnwrapKeyResponse
+	t.Run("Fail with not supported JWE algorithm", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionEncryptJWE,
+			wrap(t, "key_store_id", "", EncryptJWERequest{Alg: "RSA1_5"}))
+		require.ErrorIs(t, err, kmserrors.ErrUnprocessableEntity)
+	})
 
-		err = json.Unmarshal(buf.Bytes(), &resp)
-		require.NoError(t, err)
-		require.Equal(t, []byte("key"), resp.Key)
+	t.Run("Derive subkey", func(t *testing.T) {
+		cmd := newCmd(t)
+
+		require.NoError(t, cmd.Validate(ActionDeriveSubkey,
+			wrap(t, "key_store_id", "key_id", DeriveSubkeyRequest{KeyType: kms.AES256GCMType})))
+
+		err := cmd.Validate(ActionDeriveSubkey, wrap(t, "key_store_id", "key_id", DeriveSubkeyRequest{}))
+		require.EqualError(t, err, "validation failed: key_type is required")
+
+		err = cmd.Validate(ActionDeriveSubkey,
+			wrap(t, "key_store_id", "key_id", DeriveSubkeyRequest{KeyType: kms.ED25519Type}))
+		require.ErrorIs(t, err, kmserrors.ErrUnprocessableEntity)
 	})
 
-	t.Run("Fail to unwrap a key", func(t *testing.T) {
-		cmd := createCmd(t, gomock.NewController(t), withCrypto(&mockcrypto.Crypto{
-			UnwrapError: errors.New("unwrap error"),
-		}))
+	t.Run("Fail with unknown field", func(t *testing.T) {
+		cmd := newCmd(t)
 
-		req, err := json.Marshal(UnwrapKeyRequest{
-			WrappedKey:   crypto.RecipientWrappedKey{EncryptedCEK: []byte("0")},
-			SenderPubKey: &crypto.PublicKey{},
-			Tag:          []byte("tag"),
-		})
-		require.NoError(t, err)
+		for _, action := range []string{ActionGetKey, ActionGetKeyStore, ActionListKeys} {
+			wr, err := json.Marshal(WrappedRequest{KeyStoreID: "key_store_id", KeyID: "key_id",
+				Request: []byte("{}"), Fields: []string{"secret"}})
+			require.NoError(t, err)
 
-		wr, err := json.Marshal(WrappedRequest{
-			KeyStoreID: "key_store_id",
-			KeyID:      "key_id",
-			Request:    req,
-		})
-		require.NoError(t, err)
+			err = cmd.Validate(action, bytes.NewBuffer(wr))
+			require.EqualError(t, err, `validation failed: unknown field "secret"`, action)
+		}
+	})
 
-		var buf bytes.Buffer
+	t.Run("Fail with not supported action", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionCreateDID, wrap(t, "key_store_id", "", nil))
+		require.EqualError(t, err, "validation failed: dry run is not supported for action createDID")
+	})
 
-		err = cmd.UnwrapKey(&buf, bytes.NewBuffer(wr))
-		require.EqualError(t, err, "unwrap key: unwrap error")
+	t.Run("Fail with empty key id", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionSign, wrap(t, "key_store_id", "", SignRequest{}))
+		require.EqualError(t, err, "validation failed: key id must be non-empty")
+	})
+
+	t.Run("Fail with empty key type", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionCreateKey, wrap(t, "key_store_id", "", CreateKeyRequest{}))
+		require.EqualError(t, err, "validation failed: key type must be non-empty")
+	})
+
+	t.Run("Fail with not supported import key type", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionImportKey,
+			wrap(t, "key_store_id", "", ImportKeyRequest{KeyType: kms.AES256GCMType}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unprocessable entity: not supported key type: AES256GCM")
+	})
+
+	t.Run("Fail with unknown key store", func(t *testing.T) {
+		err := newCmd(t).Validate(ActionSign, wrap(t, "unknown", "key_id", SignRequest{}))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get key store")
 	})
 }
 
@@ -1916,6 +11681,7 @@ func createCmd(t *testing.T, ctrl *gomock.Controller, opts ...configOption) *Com
 
 	metrics := NewMockMetricsProvider(ctrl)
 	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoCanonicalizeTime(gomock.Any(), gomock.Any()).AnyTimes()
 	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
 	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
 
@@ -1965,6 +11731,268 @@ func withStorageProvider(p storage.Provider) configOption {
 	}
 }
 
+func withVerifyCache(vc *verifycache.VerifyCache) configOption {
+	return func(c *Config) {
+		c.VerifyCache = vc
+	}
+}
+
+func withOneTimeTokens(tokens *onetimetoken.Store) configOption {
+	return func(c *Config) {
+		c.OneTimeTokens = tokens
+	}
+}
+
+func withMetricsProvider(m *MockMetricsProvider) configOption {
+	return func(c *Config) {
+		c.MetricsProvider = m
+	}
+}
+
+func withCanonicalizer(canonicalizer *canonicalization.Canonicalizer) configOption {
+	return func(c *Config) {
+		c.Canonicalizer = canonicalizer
+	}
+}
+
+func withMaxSignBatchSize(size int) configOption {
+	return func(c *Config) {
+		c.MaxSignBatchSize = size
+	}
+}
+
+func withRequestLimits(limits jsonlimit.Limits) configOption {
+	return func(c *Config) {
+		c.RequestLimits = limits
+	}
+}
+
+func withUploads(uploads *upload.Store) configOption {
+	return func(c *Config) {
+		c.Uploads = uploads
+	}
+}
+
+func withSignNonces(nonces *signnonce.Store) configOption {
+	return func(c *Config) {
+		c.SignNonces = nonces
+	}
+}
+
+func withCallerNonces(nonces *aesgcm.ReuseDetector) configOption {
+	return func(c *Config) {
+		c.CallerNonces = nonces
+	}
+}
+
+func withDIDCommMediatorURL(mediatorURL string) configOption {
+	return func(c *Config) {
+		c.DIDCommMediatorURL = mediatorURL
+	}
+}
+
+func withIdempotencyKeys(keys *idempotency.Store) configOption {
+	return func(c *Config) {
+		c.IdempotencyKeys = keys
+	}
+}
+
+func withClock(clk clock.Clock, keyExpiryClockSkew time.Duration) configOption {
+	return func(c *Config) {
+		c.Clock = clk
+		c.KeyExpiryClockSkew = keyExpiryClockSkew
+	}
+}
+
+func withKeyRetentionPeriod(retentionPeriod time.Duration) configOption {
+	return func(c *Config) {
+		c.KeyRetentionPeriod = retentionPeriod
+	}
+}
+
+func withKeyUsage(tracker *keyusage.Tracker) configOption {
+	return func(c *Config) {
+		c.KeyUsage = tracker
+	}
+}
+
+func withKeyArchive(target archive.Target) configOption {
+	return func(c *Config) {
+		c.KeyArchive = archive.New(c.KeyStorageProvider, target)
+		c.KeyStorageProvider = c.KeyArchive
+	}
+}
+
+func withCryptoPools(pools *cryptopool.Pools) configOption {
+	return func(c *Config) {
+		c.CryptoPools = pools
+	}
+}
+
+func withControllerRotationGracePeriod(gracePeriod time.Duration) configOption {
+	return func(c *Config) {
+		c.ControllerRotationGracePeriod = gracePeriod
+	}
+}
+
+func withNoZCAPKeyStores() configOption {
+	return func(c *Config) {
+		c.EnableNoZCAPKeyStores = true
+	}
+}
+
+func newIdempotencyKeys(t *testing.T) *idempotency.Store {
+	t.Helper()
+
+	keys, err := idempotency.New(mem.NewProvider(), clock.Real(), time.Hour)
+	require.NoError(t, err)
+
+	return keys
+}
+
+func newOneTimeTokens(t *testing.T) *onetimetoken.Store {
+	t.Helper()
+
+	tokens, err := onetimetoken.New(mem.NewProvider(), clock.Real())
+	require.NoError(t, err)
+
+	return tokens
+}
+
+// createCmdWithLocalKMS returns a command with a key store backed by local KMS, and the local KMS to create keys.
+// The key store is expected to be resolved resolveTimes times.
+func createCmdWithLocalKMS(t *testing.T, resolveTimes int) (kms.KeyManager, *Command) {
+	t.Helper()
+
+	keyStorageProvider := mem.NewProvider()
+
+	localKMS, err := localkms.New("local-lock://test", &kmsProvider{
+		storageProvider: keyStorageProvider,
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+
+	metrics := NewMockMetricsProvider(ctrl)
+	metrics.EXPECT().CryptoSignTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().CryptoCanonicalizeTime(gomock.Any(), gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreGetKeyTime(gomock.Any()).AnyTimes()
+	metrics.EXPECT().KeyStoreResolveTime(gomock.Any()).AnyTimes()
+
+	creator := NewMockKeyStoreCreator(ctrl)
+	creator.EXPECT().Create(gomock.Any(), gomock.Any()).Return(localKMS, nil).Times(resolveTimes)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	keyStoreData, err := json.Marshal(map[string]interface{}{
+		"id":         "key_store_id",
+		"controller": "controller",
+	})
+	require.NoError(t, err)
+
+	p := mockstorage.NewMockStoreProvider()
+	p.Store.Store["key_store_id"] = mockstorage.DBEntry{Value: keyStoreData}
+
+	cmd, err := New(&Config{
+		StorageProvider:    p,
+		KeyStorageProvider: keyStorageProvider,
+		KMS:                localKMS,
+		Crypto:             cr,
+		MetricsProvider:    metrics,
+		KeyStoreCreator:    creator,
+	})
+	require.NoError(t, err)
+
+	return localKMS, cmd
+}
+
+func wrapRequest(t *testing.T, keyID string, req interface{}) io.Reader {
+	t.Helper()
+
+	b, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	wr, err := json.Marshal(WrappedRequest{
+		KeyStoreID: "key_store_id",
+		KeyID:      keyID,
+		Request:    b,
+	})
+	require.NoError(t, err)
+
+	return bytes.NewBuffer(wr)
+}
+
+type responseDecoder struct {
+	t    *testing.T
+	resp interface{}
+}
+
+func (d *responseDecoder) Write(p []byte) (int, error) {
+	require.NoError(d.t, json.Unmarshal(p, d.resp))
+
+	return len(p), nil
+}
+
+// encodeResponse returns a writer that decodes a command response into resp.
+func encodeResponse(t *testing.T, resp interface{}) io.Writer {
+	t.Helper()
+
+	return &responseDecoder{t: t, resp: resp}
+}
+
+type kmsProvider struct {
+	storageProvider storage.Provider
+	secretLock      secretlock.Service
+}
+
+func (p *kmsProvider) StorageProvider() storage.Provider {
+	return p.storageProvider
+}
+
+func (p *kmsProvider) SecretLock() secretlock.Service {
+	return p.secretLock
+}
+
+// testKeyStoreCreator creates key stores like the server does.
+type testKeyStoreCreator struct {
+	err error
+}
+
+func (c *testKeyStoreCreator) Create(keyURI string, provider kms.Provider) (kms.KeyManager, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	km, err := localkms.New(keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	ecKM, err := secp256k1.Wrap(km, keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKM, err := rsapss.Wrap(ecKM, keyURI, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return subkey.Wrap(rsaKM, keyURI, provider)
+}
+
+type keyStoreCreator interface {
+	Create(keyURI string, provider kms.Provider) (kms.KeyManager, error)
+}
+
+func withKeyStoreCreator(creator keyStoreCreator) configOption {
+	return func(c *Config) {
+		c.KeyStoreCreator = creator
+	}
+}
+
 func withKeyManager(km kms.KeyManager) configOption {
 	return func(c *Config) {
 		c.KMS = km
@@ -1988,7 +12016,7 @@ func withCryptoBoxCreator(creator cryptoBoxCreator) configOption {
 }
 
 type shamirSecretLockCreator interface {
-	Create(secretShares [][]byte) (secretlock.Service, error)
+	Create(scheme string, secretShares [][]byte) (secretlock.Service, error)
 }
 
 func withShamirSecretLockCreator(creator shamirSecretLockCreator) configOption {
@@ -2007,6 +12035,35 @@ func withShamirProvider(provider shamirProvider) configOption {
 	}
 }
 
+func marshalJWK(t *testing.T, key interface{}) json.RawMessage {
+	t.Helper()
+
+	j, err := jwksupport.JWKFromKey(key)
+	require.NoError(t, err)
+
+	b, err := j.MarshalJSON()
+	require.NoError(t, err)
+
+	return b
+}
+
+// importingKeyManager records the imported private key.
+type importingKeyManager struct {
+	mockkms.KeyManager
+	imported interface{}
+}
+
+func (m *importingKeyManager) ImportPrivateKey(privateKey interface{}, _ kms.KeyType,
+	_ ...kms.PrivateKeyOpts) (string, interface{}, error) {
+	m.imported = privateKey
+
+	return "key_id", nil, nil
+}
+
+func (m *importingKeyManager) ExportPubKeyBytes(string) ([]byte, kms.KeyType, error) {
+	return []byte("public key"), "", nil
+}
+
 func createPrivateKey(t *testing.T, kt kms.KeyType) interface{} {
 	t.Helper()
 
@@ -2055,3 +12112,40 @@ func createRecipientPubKey(t *testing.T) []byte {
 
 	return buf.Bytes()
 }
+
+// newKeyProvider rejects existing keys stored with IsNewKey option, like MongoDB does.
+type newKeyProvider struct {
+	storage.Provider
+	mutex sync.Mutex
+}
+
+func (p *newKeyProvider) OpenStore(name string) (storage.Store, error) {
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newKeyStore{Store: store, mutex: &p.mutex}, nil
+}
+
+type newKeyStore struct {
+	storage.Store
+	mutex *sync.Mutex
+}
+
+func (s *newKeyStore) Batch(operations []storage.Operation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, op := range operations {
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			continue
+		}
+
+		if _, err := s.Store.Get(op.Key); err == nil {
+			return storage.ErrDuplicateKey
+		}
+	}
+
+	return s.Store.Batch(operations)
+}
@@ -0,0 +1,348 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/ecdsahash"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
+)
+
+// testVectorsKeyStoreID is the ID of the key store in key URLs of the test vectors. The keys are imported into an
+// ephemeral in-memory key store, never into the key stores of the server.
+const testVectorsKeyStoreID = "testvectors"
+
+// testVectorsMessage is the message signed with the test vector keys.
+var testVectorsMessage = []byte("trustbloc kms test vector") //nolint:gochecknoglobals
+
+// testVectorsCreated is the creation time of the HTTP signature of the ZCAP invocation test vector.
+var testVectorsCreated = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC) //nolint:gochecknoglobals
+
+// testVectorsCoveredComponents are the components of the request covered by the HTTP signature of the ZCAP
+// invocation test vector.
+var testVectorsCoveredComponents = []string{ //nolint:gochecknoglobals
+	"(request-target)", "(created)", zcapld.CapabilityInvocationHTTPHeader,
+}
+
+// testVectorKeys are the private keys of the test vectors, hex-encoded in the raw form ImportKey accepts. They are
+// published test keys (from RFC 8032 and RFC 6979 where there is one for the curve, otherwise a SHA-256 of a label)
+// and must never protect anything. Keys with a hash sign like keys created with that hash.
+var testVectorKeys = []struct { //nolint:gochecknoglobals
+	keyType kms.KeyType
+	hash    string
+	key     string
+}{
+	// RFC 8032, 7.1, TEST 1
+	{kms.ED25519Type, "", "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"},
+	// RFC 6979, A.2.5
+	{kms.ECDSAP256TypeDER, "", "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+	{kms.ECDSAP256TypeIEEEP1363, "", "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+	// RFC 6979, A.2.6
+	{kms.ECDSAP384TypeDER, "", "6b9d3dad2e1b8c1c05b19875b6659f4de23c3b667bf297ba9aa47740787137d8" +
+		"96d5724e4c70a825f872c9ea60d2edf5"},
+	{kms.ECDSAP384TypeIEEEP1363, "", "6b9d3dad2e1b8c1c05b19875b6659f4de23c3b667bf297ba9aa47740787137d8" +
+		"96d5724e4c70a825f872c9ea60d2edf5"},
+	// RFC 6979, A.2.7
+	{kms.ECDSAP521TypeDER, "", "00fad06daa62ba3b25d2fb40133da757205de67f5bb0018fee8c86e1b68c7e75ca" +
+		"a896eb32f1f47c70855836a6d16fcc1466f6d8fbec67db89ec0c08b0e996b83538"},
+	{kms.ECDSAP521TypeIEEEP1363, "", "00fad06daa62ba3b25d2fb40133da757205de67f5bb0018fee8c86e1b68c7e75ca" +
+		"a896eb32f1f47c70855836a6d16fcc1466f6d8fbec67db89ec0c08b0e996b83538"},
+	// SHA-256("trustbloc kms test vector secp256k1")
+	{secp256k1.KeyTypeDER, "", "2e079d6dd907d91adfb5b0264135a9f39a21a9489efce4295001f57483537c37"},
+	{secp256k1.KeyTypeIEEEP1363, "", "2e079d6dd907d91adfb5b0264135a9f39a21a9489efce4295001f57483537c37"},
+	// SHA-256("trustbloc kms test vector BLS12381G2")
+	{kms.BLS12381G2Type, "", "5f3a97e3de28662ce15750727f88c83aa8a296372174e86bfac1a503a9c007b8"},
+	// RFC 6979, A.2.5 and A.2.6, with hashes other than the one of the key type
+	{kms.ECDSAP256TypeDER, ecdsahash.SHA384, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+	{kms.ECDSAP256TypeDER, ecdsahash.SHA512, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"},
+	{kms.ECDSAP384TypeDER, ecdsahash.SHA512, "6b9d3dad2e1b8c1c05b19875b6659f4de23c3b667bf297ba9aa47740787137d8" +
+		"96d5724e4c70a825f872c9ea60d2edf5"},
+}
+
+// TestVectors returns canonical test vectors for client implementers: for each key type that can sign and be
+// imported, a fixed test key, the signature of a message and the exports of the public key, and a worked example of
+// a ZCAP invocation. The vectors are made by the same code as the operations they describe: keys are parsed and
+// imported like ImportKey does, signed with like Sign does and exported like ExportKey and GetKeyFingerprint do.
+func (c *Command) TestVectors(w io.Writer, _ io.Reader) error {
+	var resp TestVectorsResponse
+
+	for _, k := range testVectorKeys {
+		v, err := c.keyTestVector(k.keyType, k.hash, k.key)
+		if err != nil {
+			return fmt.Errorf("%s test vector: %w", strings.TrimSpace(string(k.keyType)+" "+k.hash), err)
+		}
+
+		resp.Keys = append(resp.Keys, v)
+	}
+
+	zcapVector, err := c.zcapInvocationTestVector(resp.Keys[0])
+	if err != nil {
+		return fmt.Errorf("zcap invocation test vector: %w", err)
+	}
+
+	resp.ZCAPInvocation = zcapVector
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// testVectorsKeyStore imports the test key into an ephemeral in-memory key store. The key ID is the JWK thumbprint
+// of the public key, so that key URLs of the vectors don't change; for Ed25519 keys it's also the key ID that
+// signatures of ZCAP invocations are made with. Key types of the same key have the same key ID, so each key gets its
+// own key store.
+func (c *Command) testVectorsKeyStore(kt kms.KeyType, key []byte) (kms.KeyManager, string, error) {
+	ks, err := c.ephemeralKeyStore()
+	if err != nil {
+		return nil, "", err
+	}
+
+	privateKey, err := parseKeyBytes(key, kt)
+	if err != nil {
+		return nil, "", fmt.Errorf("parse private key: %w", err)
+	}
+
+	pub, err := publicKeyBytes(privateKey, kt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	thumbprint, err := jwkThumbprint(pub, kt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kid, _, err := ks.ImportPrivateKey(privateKey, kt, kms.WithKeyID(thumbprint))
+	if err != nil {
+		return nil, "", fmt.Errorf("import private key: %w", err)
+	}
+
+	return ks, kid, nil
+}
+
+func (c *Command) keyTestVector(kt kms.KeyType, hash, keyHex string) (*KeyTestVector, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("decode private key: %w", err)
+	}
+
+	ks, kid, err := c.testVectorsKeyStore(kt, key)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, _, err := ks.ExportPubKeyBytes(kid)
+	if err != nil {
+		return nil, fmt.Errorf("export public key bytes: %w", err)
+	}
+
+	kh, err := ks.Get(kid)
+	if err != nil {
+		return nil, fmt.Errorf("get key: %w", err)
+	}
+
+	pubKH, err := publicKeyHandle(kh)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &KeyTestVector{
+		KeyType:    string(kt),
+		Hash:       hash,
+		PrivateKey: key,
+		KeyURL:     fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, testVectorsKeyStoreID, kid),
+		PublicKey:  pub,
+	}
+
+	wr := &WrappedRequest{keyType: kt, keyHash: hash}
+	sign := func() ([]byte, error) { return c.signer(wr)(testVectorsMessage, kh) }
+	verify := func(sig []byte) error { return c.verifier(wr)(sig, testVectorsMessage, pubKH) }
+
+	// BBS+ keys sign lists of messages
+	if kt == kms.BLS12381G2Type {
+		v.Messages = [][]byte{testVectorsMessage}
+
+		sign = func() ([]byte, error) { return c.crypto.SignMulti(v.Messages, kh) }
+		verify = func(sig []byte) error { return c.crypto.VerifyMulti(v.Messages, sig, pubKH) }
+	} else {
+		v.Message = testVectorsMessage
+	}
+
+	if v.Signature, v.Deterministic, err = testVectorSignature(sign, verify); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	if err = encodeJWK(&b, pub, kt, hash, v.KeyURL); err == nil {
+		v.JWK = bytes.TrimSpace(b.Bytes())
+	} else if !stderrors.Is(err, errors.ErrBadRequest) {
+		return nil, err
+	}
+
+	// key types that can't be exported as did:key have no fingerprint either
+	if v.DIDKey, err = newDIDKey(pub, kt); err != nil && !stderrors.Is(err, errors.ErrBadRequest) {
+		return nil, err
+	}
+
+	if v.DIDKey != nil {
+		if v.Fingerprint, err = keyFingerprint(pub, kt); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// testVectorSignature signs twice to tell whether signatures of the key type are deterministic, and verifies the
+// signature.
+func testVectorSignature(sign func() ([]byte, error), verify func([]byte) error) ([]byte, bool, error) {
+	sig, err := sign()
+	if err != nil {
+		return nil, false, fmt.Errorf("sign: %w", err)
+	}
+
+	again, err := sign()
+	if err != nil {
+		return nil, false, fmt.Errorf("sign: %w", err)
+	}
+
+	if err = verify(sig); err != nil {
+		return nil, false, fmt.Errorf("verify: %w", err)
+	}
+
+	return sig, bytes.Equal(sig, again), nil
+}
+
+// zcapInvocationTestVector makes a worked example of a ZCAP invocation: a request to export the Ed25519 test key,
+// invoking the root capability of the key store with the test key as its invoker. The HTTP signature is verified the
+// way the ZCAP middleware verifies it, so the signature base can't drift from what the server checks.
+func (c *Command) zcapInvocationTestVector(ed25519Vector *KeyTestVector) (*ZCAPInvocationTestVector, error) {
+	if ed25519Vector.DIDKey == nil {
+		return nil, fmt.Errorf("no did:key of the %s test key", ed25519Vector.KeyType)
+	}
+
+	ks, _, err := c.testVectorsKeyStore(kms.ED25519Type, ed25519Vector.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	invoker := ed25519Vector.DIDKey.VerificationMethod
+	resource := fmt.Sprintf("%s/%s", c.baseKeyStoreURL, testVectorsKeyStoreID)
+
+	capability := rootCapability(rootCapabilityOptions(resource, invoker)...)
+
+	// the capability is compressed in canonical form
+	capabilityJSON, err := canonicalization.MarshalJCS(capability)
+	if err != nil {
+		return nil, fmt.Errorf("marshal capability: %w", err)
+	}
+
+	compressed, err := zcapldsvc.CompressZCAP(capability)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ed25519Vector.KeyURL+"/export", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set(zcapld.CapabilityInvocationHTTPHeader, fmt.Sprintf(`zcap capability="%s",action="%s"`,
+		base64.URLEncoding.EncodeToString(compressed), ActionExportKey))
+
+	alg := &zcapld.AriesDIDKeySignatureHashAlgorithm{Crypto: c.crypto, KMS: ks}
+	base := signatureBase(req, testVectorsCoveredComponents, testVectorsCreated)
+
+	sig, err := alg.Create(httpsignatures.Secret{KeyID: invoker, Algorithm: alg.Algorithm()}, []byte(base))
+	if err != nil {
+		return nil, fmt.Errorf("sign signature base: %w", err)
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="%s",created=%d,headers="%s",signature="%s"`,
+		invoker, alg.Algorithm(), testVectorsCreated.Unix(), strings.Join(testVectorsCoveredComponents, " "),
+		base64.StdEncoding.EncodeToString(sig)))
+
+	hs := httpsignatures.NewHTTPSignatures(&zcapld.AriesDIDKeySecrets{})
+	hs.SetSignatureHashAlgorithm(alg)
+
+	if err = hs.Verify(req); err != nil {
+		return nil, fmt.Errorf("verify http signature: %w", err)
+	}
+
+	return &ZCAPInvocationTestVector{
+		Method:            req.Method,
+		URL:               req.URL.String(),
+		Capability:        capabilityJSON,
+		Invoker:           invoker,
+		Created:           testVectorsCreated.Unix(),
+		CoveredComponents: testVectorsCoveredComponents,
+		SignatureBase:     base,
+		Signature:         sig,
+		Headers: map[string]string{
+			zcapld.CapabilityInvocationHTTPHeader: req.Header.Get(zcapld.CapabilityInvocationHTTPHeader),
+			"Signature":                           req.Header.Get("Signature"),
+		},
+	}, nil
+}
+
+// rootCapability returns the root capability with the options without a proof. The server signs root capabilities
+// with a random nonce, so the proof of a capability can't be part of a test vector.
+func rootCapability(options ...zcapld.CapabilityOption) *zcapld.Capability {
+	opts := &zcapld.CapabilityOptions{}
+
+	for _, opt := range options {
+		opt(opts)
+	}
+
+	return &zcapld.Capability{
+		Context:          zcapld.SecurityContextV2,
+		ID:               opts.ID,
+		Invoker:          opts.Invoker,
+		Controller:       opts.Controller,
+		Delegator:        opts.Delegator,
+		Parent:           opts.Parent,
+		AllowedAction:    opts.AllowedAction,
+		InvocationTarget: opts.InvocationTarget,
+		Caveats:          opts.Caveats,
+	}
+}
+
+// signatureBase returns the signature base of the HTTP signature covering the components of the request, one
+// "name: value" line per component (draft-cavage-http-signatures, as implemented by the HTTP signature library).
+func signatureBase(req *http.Request, components []string, created time.Time) string {
+	lines := make([]string, len(components))
+
+	for i, component := range components {
+		switch component {
+		case "(request-target)":
+			lines[i] = fmt.Sprintf("%s: %s %s", component, strings.ToLower(req.Method), req.URL.RequestURI())
+		case "(created)":
+			lines[i] = fmt.Sprintf("%s: %d", component, created.Unix())
+		default:
+			lines[i] = fmt.Sprintf("%s: %s", strings.ToLower(component), strings.TrimSpace(req.Header.Get(component)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,211 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// AliasConflictError is returned when an alias is already used by another key of the key store.
+type AliasConflictError struct {
+	Alias  string
+	KeyURL string // URL of the key that uses the alias
+}
+
+func (e *AliasConflictError) Error() string {
+	return fmt.Sprintf("%s: alias %q is used by key %s", errors.ErrConflict.Error(), e.Alias, e.KeyURL)
+}
+
+// Unwrap returns ErrConflict, so that the error is reported with 409 status.
+func (e *AliasConflictError) Unwrap() error {
+	return errors.ErrConflict
+}
+
+// UpdateKey sets, renames or, with an empty alias, removes the alias of a key. It also registers DID URLs of the
+// verification methods the key is known by, so that requests can reference the key by them. A DID URL is unique across
+// key stores; registering a DID URL of another key fails with VerificationMethodConflictError.
+func (c *Command) UpdateKey(w io.Writer, r io.Reader) error {
+	var req UpdateKeyRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	// fields of the request are updated only if set, except for the alias, which is updated by legacy requests
+	// that set nothing else
+	var fields map[string]json.RawMessage
+
+	if err = json.Unmarshal(wr.Request, &fields); err != nil {
+		return fmt.Errorf("%w: decode request", errors.ErrInternal)
+	}
+
+	_, setVMs := fields["verification_methods"]
+	_, setAlias := fields["alias"]
+	setAlias = setAlias || !setVMs
+
+	if setAlias && req.Alias != "" {
+		if err = validateAlias(req.Alias); err != nil {
+			return err
+		}
+	}
+
+	if err = validateVerificationMethods(req.VerificationMethods); err != nil {
+		return err
+	}
+
+	ks, err := c.resolveKeyStoreForKey(wr)
+	if err != nil {
+		return fmt.Errorf("resolve key store: %w", err)
+	}
+
+	if _, err = ks.Get(wr.KeyID); err != nil {
+		return fmt.Errorf("get key: %w", keyNotFound(wr.KeyID, err))
+	}
+
+	var (
+		checks  []func(meta *keyStoreMeta) error
+		updates []func(meta *keyStoreMeta)
+		resp    UpdateKeyResponse
+	)
+
+	if setAlias {
+		checks = append(checks, c.checkAliases(wr.KeyStoreID, map[string]string{req.Alias: wr.KeyID}))
+		updates = append(updates, setKeyAlias(wr.KeyID, req.Alias))
+	}
+
+	if setVMs {
+		// claims are checked last, so that a request rejected by another check doesn't claim DID URLs
+		checks = append(checks, c.claimVerificationMethods(wr.KeyStoreID, wr.KeyID, req.VerificationMethods))
+		updates = append(updates, setVerificationMethods(wr.KeyID, req.VerificationMethods))
+	}
+
+	// the response reflects the metadata as saved
+	updates = append(updates, func(meta *keyStoreMeta) {
+		resp.Alias = meta.aliasOf(wr.KeyID)
+		resp.VerificationMethods = meta.verificationMethodsOf(wr.KeyID)
+	})
+
+	resp.Sequence, err = c.incrementSequenceChecked(wr.KeyStoreID, allChecks(checks...), updates...)
+	if err != nil {
+		return fmt.Errorf("increment sequence: %w", err)
+	}
+
+	resp.KeyURL = fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, wr.KeyStoreID, wr.KeyID)
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// allChecks returns a check that fails with the error of the first failed check.
+func allChecks(checks ...func(meta *keyStoreMeta) error) func(meta *keyStoreMeta) error {
+	return func(meta *keyStoreMeta) error {
+		for _, check := range checks {
+			if err := check(meta); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("%w: alias must be 1 to 64 letters, digits, '.', '_' or '-' and start with a letter "+
+			"or digit", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// checkAliases returns a check that fails with AliasConflictError if any of the aliases (mapped to IDs of the keys
+// they are for) is used by another key of the key store, either as an alias or as a key ID. Empty aliases are
+// skipped.
+func (c *Command) checkAliases(keyStoreID string, aliases map[string]string) func(meta *keyStoreMeta) error {
+	return func(meta *keyStoreMeta) error {
+		for alias, keyID := range aliases {
+			if alias == "" {
+				continue
+			}
+
+			existing, ok := meta.Aliases[alias]
+			if !ok {
+				if _, isKeyID := meta.Keys[alias]; isKeyID {
+					existing = alias
+				}
+			}
+
+			if existing != "" && existing != keyID {
+				return &AliasConflictError{
+					Alias:  alias,
+					KeyURL: fmt.Sprintf("%s/%s/keys/%s", c.baseKeyStoreURL, keyStoreID, existing),
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// setKeyAlias replaces the alias of the key. An empty alias removes the alias of the key.
+func setKeyAlias(keyID, alias string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		if old := meta.aliasOf(keyID); old != "" {
+			delete(meta.Aliases, old)
+		}
+
+		if alias == "" {
+			return
+		}
+
+		if meta.Aliases == nil {
+			meta.Aliases = make(map[string]string)
+		}
+
+		meta.Aliases[alias] = keyID
+	}
+}
+
+// moveKeyAlias moves the alias of the key, if any, to another key (e.g. to the new key on rotation).
+func moveKeyAlias(fromKeyID, toKeyID string) func(meta *keyStoreMeta) {
+	return func(meta *keyStoreMeta) {
+		if alias := meta.aliasOf(fromKeyID); alias != "" {
+			meta.Aliases[alias] = toKeyID
+		}
+	}
+}
+
+// keyID returns ID of the key with the alias or registered DID URL, or the value as is if it is neither.
+func (m *keyStoreMeta) keyID(idOrAlias string) string {
+	if id, ok := m.Aliases[idOrAlias]; ok {
+		return id
+	}
+
+	if id, ok := m.VerificationMethods[idOrAlias]; ok {
+		return id
+	}
+
+	return idOrAlias
+}
+
+// aliasOf returns the alias of the key, or an empty string if the key has no alias.
+func (m *keyStoreMeta) aliasOf(keyID string) string {
+	for alias, id := range m.Aliases {
+		if id == keyID {
+			return alias
+		}
+	}
+
+	return ""
+}
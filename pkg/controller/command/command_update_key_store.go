@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// UpdateKeyStore sets a new controller of the key store. Only the current controller can update the key store. The
+// root capability is re-issued for the new controller; the old capability is still accepted until the controller
+// rotation grace period ends, so that clients of the old controller can switch over.
+func (c *Command) UpdateKeyStore(w io.Writer, r io.Reader) error {
+	var req UpdateKeyStoreRequest
+
+	wr, err := c.unwrapRequest(&req, r)
+	if err != nil {
+		return fmt.Errorf("unwrap request: %w", err)
+	}
+
+	if err = req.Validate(); err != nil {
+		return fmt.Errorf("validate request: %w", err)
+	}
+
+	defer c.keyStoreLocks.lock(wr.KeyStoreID)()
+
+	meta, err := c.getKeyStoreMeta(wr.KeyStoreID)
+	if err != nil {
+		return fmt.Errorf("get key store: %w", keyStoreNotFound(wr.KeyStoreID, err))
+	}
+
+	if meta.NoZCAP {
+		return fmt.Errorf("%w: key store without ZCAPs has no capability to re-issue for a new controller",
+			errors.ErrUnprocessableEntity)
+	}
+
+	if c.enableZCAPs && wr.Caller != meta.Controller {
+		return fmt.Errorf("%w: only the controller can update the key store", errors.ErrForbidden)
+	}
+
+	keyStoreURL := c.baseKeyStoreURL + "/" + meta.ID
+
+	var rootCapability []byte
+
+	if c.enableZCAPs {
+		// the capability is re-issued before the metadata is saved, so that a failed update leaves the key store
+		// with the old controller and capability
+		if err = c.zcap.Supersede(keyStoreURL, c.clock.Now().Add(c.controllerGrace)); err != nil {
+			return fmt.Errorf("supersede root capability: %w", err)
+		}
+
+		rootCapability, err = c.newCompressedZCAP(context.Background(), keyStoreURL, req.Controller)
+		if err != nil {
+			return fmt.Errorf("create root capability: %w", err)
+		}
+	}
+
+	meta.Controller = req.Controller
+	meta.Sequence++
+
+	if err = c.saveRevision(meta); err != nil {
+		return fmt.Errorf("save key store metadata: %w", err)
+	}
+
+	return json.NewEncoder(w).Encode(UpdateKeyStoreResponse{
+		KeyStoreURL: keyStoreURL,
+		Capability:  rootCapability,
+		Sequence:    meta.Sequence,
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/primitive/bbs12381g2pub"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+)
+
+// verifyWithPublicKey verifies a signature with the public key of the request, e.g. of another party, instead of a
+// key of the key store. The key store is only checked to exist; the key is handled by an ephemeral key store.
+// A signature that doesn't verify is not an error: the response tells whether it verified, so that clients can tell
+// bad signatures from failures.
+func (c *Command) verifyWithPublicKey(w io.Writer, wr *WrappedRequest, req *VerifyRequest) error {
+	if wr.KeyID != "" {
+		return fmt.Errorf("%w: public_key and jwk can't be combined with a key of the key store", errors.ErrValidation)
+	}
+
+	if _, err := c.getKeyStoreMeta(wr.KeyStoreID); err != nil {
+		return fmt.Errorf("get key store: %w", err)
+	}
+
+	kh, err := c.requestPublicKeyHandle(req)
+	if err != nil {
+		return err
+	}
+
+	wr.keyType = req.KeyType
+
+	var invalid error
+
+	err = c.runCrypto(wr, func() error {
+		if len(req.Messages) > 0 {
+			invalid = c.crypto.VerifyMulti(req.Messages, req.Signature, kh)
+		} else {
+			invalid = c.verifySignature(wr, req.Signature, req.Message, kh)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	resp := VerifyResponse{Verified: invalid == nil}
+
+	if wr.Details {
+		if resp.Details, err = signatureDetails(kh, req.SignatureEncoding); err != nil {
+			return err
+		}
+	}
+
+	return json.NewEncoder(w).Encode(&resp)
+}
+
+// requestPublicKeyHandle returns a handle of the public key of the verify request. It fails with
+// ErrUnprocessableEntity if the key is not a signing key of its key type.
+func (c *Command) requestPublicKeyHandle(req *VerifyRequest) (interface{}, error) {
+	if req.KeyType == "" {
+		return nil, fmt.Errorf("%w: key_type is required with public_key or jwk", errors.ErrValidation)
+	}
+
+	if len(req.PublicKey) > 0 && len(req.JWK) > 0 {
+		return nil, fmt.Errorf("%w: public_key can't be combined with jwk", errors.ErrValidation)
+	}
+
+	if jwkAlgorithm(req.KeyType) == "" && req.KeyType != kms.BLS12381G2Type {
+		return nil, fmt.Errorf("%w: key type %s can't verify signatures", errors.ErrUnprocessableEntity, req.KeyType)
+	}
+
+	if (len(req.Messages) > 0) != (req.KeyType == kms.BLS12381G2Type) {
+		return nil, fmt.Errorf("%w: messages are verified with %s keys only, and %s keys verify messages only",
+			errors.ErrValidation, kms.BLS12381G2Type, kms.BLS12381G2Type)
+	}
+
+	pub := req.PublicKey
+
+	if len(req.JWK) > 0 {
+		var err error
+
+		pub, err = jwkPublicKeyBytes(req.JWK, req.KeyType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// handles of Ed25519 keys are created from keys of any size, and fail only when used
+	if req.KeyType == kms.ED25519Type && len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: invalid %s public key size %d", errors.ErrUnprocessableEntity, req.KeyType,
+			len(pub))
+	}
+
+	ks, err := c.ephemeralKeyStore()
+	if err != nil {
+		return nil, err
+	}
+
+	kh, err := ks.PubKeyBytesToHandle(pub, req.KeyType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s public key: %s", errors.ErrUnprocessableEntity, req.KeyType, err)
+	}
+
+	return kh, nil
+}
+
+// jwkPublicKeyBytes converts a public key JWK to the format keys of the key type are exported in, which is what key
+// stores take as public key bytes. The JWK must be a key of the key type.
+func jwkPublicKeyBytes(b json.RawMessage, kt kms.KeyType) ([]byte, error) {
+	var j jwk.JWK
+
+	if err := j.UnmarshalJSON(b); err != nil {
+		return nil, fmt.Errorf("%w: invalid jwk: %s", errors.ErrUnprocessableEntity, err)
+	}
+
+	mismatch := fmt.Errorf("%w: jwk is not a %s public key", errors.ErrUnprocessableEntity, kt)
+
+	switch key := j.Key.(type) {
+	case ed25519.PublicKey:
+		if kt != kms.ED25519Type {
+			return nil, mismatch
+		}
+
+		return key, nil
+	case *ecdsa.PublicKey:
+		if ecdsaCurve(kt) != key.Curve {
+			return nil, mismatch
+		}
+
+		return ecdsaPublicKeyBytes(key, kt)
+	case *rsa.PublicKey:
+		if kt != rsapss.KeyType {
+			return nil, mismatch
+		}
+
+		return rsapss.MarshalPublicKey(key) //nolint:wrapcheck
+	case *bbs12381g2pub.PublicKey:
+		if kt != kms.BLS12381G2Type {
+			return nil, mismatch
+		}
+
+		pub, err := key.Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("marshal public key: %w", err)
+		}
+
+		return pub, nil
+	default:
+		return nil, mismatch
+	}
+}
+
+// ecdsaPublicKeyBytes returns the ECDSA public key in the format keys of the key type are exported in.
+func ecdsaPublicKeyBytes(pub *ecdsa.PublicKey, kt kms.KeyType) ([]byte, error) {
+	switch kt { //nolint:exhaustive
+	case secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363:
+		return secp256k1.MarshalPublicKey(pub, kt) //nolint:wrapcheck
+	case kms.ECDSAP256TypeDER, kms.ECDSAP384TypeDER, kms.ECDSAP521TypeDER:
+		b, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, fmt.Errorf("marshal public key: %w", err)
+		}
+
+		return b, nil
+	default:
+		return elliptic.Marshal(pub.Curve, pub.X, pub.Y), nil
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/trustbloc/kms/pkg/controller/errors"
+)
+
+// Fields that can be selected with WrappedRequest.Fields in responses. Fields of list responses select fields of the
+// listed items.
+var ( //nolint:gochecknoglobals
+	exportKeyFields = []string{"public_key", "key_type"}
+	getKeyFields    = []string{
+		"key_type", "alias", "state", "created_at", "expires_at", "purposes", "origin", "last_used_at", "exportable",
+		"public_key", "verification_methods", "schema_version",
+	}
+	keyInfoFields = []string{
+		"key_url", "key_type", "alias", "state", "created_at", "expires_at", "purposes", "origin", "last_used_at",
+	}
+	getKeyStoreFields = []string{
+		"controller", "created_at", "storage_type", "key_count", "sequence", "schema_version",
+	}
+	keyStoreInfoFields = []string{"id", "created_at", "storage_type", "overrides", "schema_version"}
+)
+
+// validateFields checks that every requested field is one of the known fields of the response.
+func validateFields(fields []string, known ...string) error {
+	for _, f := range fields {
+		found := false
+
+		for _, k := range known {
+			if f == k {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: unknown field %q", errors.ErrValidation, f)
+		}
+	}
+
+	return nil
+}
+
+// encodeFields writes v to w keeping only the given fields. If no fields are requested, v is written as is.
+func encodeFields(w io.Writer, v interface{}, fields []string) error {
+	if len(fields) == 0 {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	all, err := toObject(v)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(selectFields(all, fields))
+}
+
+// encodeListFields writes the list response v to w keeping only the given fields of the items of its list member.
+// Other members of the response are kept. If no fields are requested, v is written as is.
+func encodeListFields(w io.Writer, v interface{}, list string, fields []string) error {
+	if len(fields) == 0 {
+		return json.NewEncoder(w).Encode(v)
+	}
+
+	resp, err := toObject(v)
+	if err != nil {
+		return err
+	}
+
+	var items []map[string]json.RawMessage
+
+	if err = json.Unmarshal(resp[list], &items); err != nil {
+		return fmt.Errorf("unmarshal %s: %w", list, err)
+	}
+
+	selected := make([]map[string]json.RawMessage, len(items))
+
+	for i, item := range items {
+		selected[i] = selectFields(item, fields)
+	}
+
+	if resp[list], err = json.Marshal(selected); err != nil {
+		return fmt.Errorf("marshal %s: %w", list, err)
+	}
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func toObject(v interface{}) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal response: %w", err)
+	}
+
+	var obj map[string]json.RawMessage
+
+	if err = json.Unmarshal(b, &obj); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return obj, nil
+}
+
+func selectFields(obj map[string]json.RawMessage, fields []string) map[string]json.RawMessage {
+	selected := make(map[string]json.RawMessage, len(fields))
+
+	for _, f := range fields {
+		if val, ok := obj[f]; ok {
+			selected[f] = val
+		}
+	}
+
+	return selected
+}
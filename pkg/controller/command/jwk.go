@@ -0,0 +1,200 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package command
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk/jwksupport"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/didkey"
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+)
+
+// Formats of exported public keys.
+const (
+	ExportFormatRaw = "raw" // base64-encoded public key bytes in ExportKeyResponse, the default
+	ExportFormatJWK = "jwk" // JSON Web Key (RFC 7517)
+	ExportFormatDID = "did" // did:key identifier and verification method in ExportDIDKeyResponse
+)
+
+func validateExportFormat(format string, fields []string) error {
+	switch format {
+	case "", ExportFormatRaw:
+		return validateFields(fields, exportKeyFields...)
+	case ExportFormatJWK, ExportFormatDID:
+		if len(fields) > 0 {
+			return fmt.Errorf("%w: fields can't be selected in %s format", errors.ErrValidation, format)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown format %q, supported formats: %s, %s, %s", errors.ErrValidation, format,
+			ExportFormatRaw, ExportFormatJWK, ExportFormatDID)
+	}
+}
+
+// encodeJWK writes the public key as a JWK identified by the key URL. The JWK is serialized in canonical form (JCS),
+// so that the same key is always exported as the same bytes. A key that doesn't sign with the hash of its key type
+// has no "alg": no JWS algorithm pairs its curve with its hash, and the one of the key type wouldn't verify.
+func encodeJWK(w io.Writer, pub []byte, kt kms.KeyType, hash, keyURL string) error {
+	j, err := pubKeyJWK(pub, kt)
+	if err != nil {
+		return fmt.Errorf("%w: key of type %s can't be exported as jwk: %s", errors.ErrBadRequest, kt, err)
+	}
+
+	j.KeyID = keyURL
+
+	if hash == "" {
+		j.Algorithm = jwkAlgorithm(kt)
+	}
+
+	b, err := canonicalization.MarshalJCS(j)
+	if err != nil {
+		return fmt.Errorf("marshal jwk: %w", err)
+	}
+
+	_, err = w.Write(append(b, '\n'))
+
+	return err //nolint:wrapcheck
+}
+
+// pubKeyJWK converts a public key exported from the KMS to a JWK. X25519 ECDH-KW keys are exported as JSON
+// crypto.PublicKey, but jwksupport takes the raw key. jwksupport doesn't convert exported secp256k1 keys, and
+// converts RSA keys from PKCS #1 rather than the PKIX format RSA-PSS keys are exported in.
+func pubKeyJWK(pub []byte, kt kms.KeyType) (*jwk.JWK, error) {
+	if kt == rsapss.KeyType {
+		key, err := rsapss.ParsePublicKey(pub)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		return jwksupport.JWKFromKey(key) //nolint:wrapcheck
+	}
+
+	if secp256k1.IsKeyType(kt) {
+		key, err := secp256k1.ParsePublicKey(pub, kt)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		return jwksupport.JWKFromKey(key) //nolint:wrapcheck
+	}
+
+	if kt == kms.X25519ECDHKWType {
+		var key crypto.PublicKey
+
+		if err := json.Unmarshal(pub, &key); err != nil {
+			return nil, fmt.Errorf("unmarshal x25519 public key: %w", err)
+		}
+
+		pub = key.X
+	}
+
+	return jwksupport.PubKeyBytesToJWK(pub, kt) //nolint:wrapcheck
+}
+
+// jwkThumbprint returns the base64url-encoded SHA-256 JWK thumbprint (RFC 7638) of the public key. The thumbprint is
+// computed over the required members of the key type, so that it doesn't depend on "kid" or "alg". Unlike the
+// thumbprint of go-jose, it supports X25519 and BLS12381G2 keys.
+func jwkThumbprint(pub []byte, kt kms.KeyType) (string, error) {
+	j, err := pubKeyJWK(pub, kt)
+	if err != nil {
+		return "", fmt.Errorf("%w: key of type %s has no jwk thumbprint: %s", errors.ErrBadRequest, kt, err)
+	}
+
+	b, err := j.MarshalJSON()
+	if err != nil {
+		return "", fmt.Errorf("marshal jwk: %w", err)
+	}
+
+	var members map[string]interface{}
+
+	if err = json.Unmarshal(b, &members); err != nil {
+		return "", fmt.Errorf("unmarshal jwk: %w", err)
+	}
+
+	required := make(map[string]interface{})
+
+	for _, name := range thumbprintMembers(j) {
+		if v, ok := members[name]; ok {
+			required[name] = v
+		}
+	}
+
+	// json.Marshal sorts map keys and adds no whitespace, as RFC 7638 requires
+	b, err = json.Marshal(required)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwk thumbprint input: %w", err)
+	}
+
+	h := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+// thumbprintMembers returns names of the required members of the JWK (RFC 7638, section 3.2).
+func thumbprintMembers(j *jwk.JWK) []string {
+	switch j.Kty {
+	case "RSA":
+		return []string{"e", "kty", "n"}
+	case "OKP":
+		return []string{"crv", "kty", "x"}
+	default:
+		return []string{"crv", "kty", "x", "y"}
+	}
+}
+
+// encodeDIDKey writes the did:key (https://w3c-ccg.github.io/did-method-key/) of the public key and its
+// verification method.
+func encodeDIDKey(w io.Writer, pub []byte, kt kms.KeyType) error {
+	didKey, err := newDIDKey(pub, kt)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(didKey)
+}
+
+func newDIDKey(pub []byte, kt kms.KeyType) (*ExportDIDKeyResponse, error) {
+	didKey, err := didkey.FromPublicKey(pub, kt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key of type %s can't be exported as did:key: %s", errors.ErrBadRequest, kt, err)
+	}
+
+	return &ExportDIDKeyResponse{DID: didKey.DID, VerificationMethod: didKey.VerificationMethod}, nil
+}
+
+// jwkAlgorithm returns a JWS algorithm (RFC 7518, RFC 8037) of signatures made with the key type.
+func jwkAlgorithm(kt kms.KeyType) string {
+	switch kt { //nolint:exhaustive
+	case kms.ED25519Type:
+		return "EdDSA"
+	case kms.ECDSAP256TypeDER, kms.ECDSAP256TypeIEEEP1363:
+		return "ES256"
+	case kms.ECDSAP384TypeDER, kms.ECDSAP384TypeIEEEP1363:
+		return "ES384"
+	case kms.ECDSAP521TypeDER, kms.ECDSAP521TypeIEEEP1363:
+		return "ES512"
+	case secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363:
+		return "ES256K"
+	case rsapss.KeyType:
+		return "PS256"
+	default:
+		return ""
+	}
+}
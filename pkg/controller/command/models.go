@@ -7,21 +7,38 @@ SPDX-License-Identifier: Apache-2.0
 package command
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 
 	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/secretshare"
 )
 
 // WrappedRequest is a command request with a wrapped original request from user.
 type WrappedRequest struct {
-	KeyStoreID  string `json:"key_store_id"`
-	KeyID       string `json:"key_id"`
-	User        string `json:"user"`
-	SecretShare []byte `json:"secret_share"`
-	Request     []byte `json:"request"`
+	KeyStoreID  string   `json:"key_store_id"`
+	KeyID       string   `json:"key_id"`
+	User        string   `json:"user"`
+	Caller      string   `json:"caller,omitempty"` // authorized caller, e.g. the invoker of a capability
+	SecretShare []byte   `json:"secret_share"`
+	Fields      []string `json:"fields,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	// IdempotencyKey identifies a key store creation request, so that a retry returns the key store of the first one.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	Request        []byte `json:"request"`
+	// Details adds the parameters the crypto signed or verified with to sign and verify responses.
+	Details bool `json:"details,omitempty"`
+	// UploadID identifies an upload of a payload to sign, see CreateUpload.
+	UploadID string `json:"upload_id,omitempty"`
+
+	// keyType is the type of the key of the request from key store metadata, set by key store resolvers.
+	keyType kms.KeyType
+	// keyHash is the hash the key of the request signs with if it isn't the one of its key type, set with keyType.
+	keyHash string
 }
 
 // CreateDIDResponse is a response for CreateDID request.
@@ -31,9 +48,31 @@ type CreateDIDResponse struct {
 
 // CreateKeyStoreRequest is a request to create user's key store.
 type CreateKeyStoreRequest struct {
-	Controller string      `json:"controller"`
-	EDV        *EDVOptions `json:"edv"`
-}
+	Controller string `json:"controller"`
+	// StorageMode is where keys of the key store are stored. If empty, it's StorageModeEDV if EDV options are set
+	// and StorageModeLocal otherwise.
+	StorageMode       StorageMode `json:"storage_mode,omitempty"`
+	EDV               *EDVOptions `json:"edv"`
+	SecretShareScheme string      `json:"secret_share_scheme,omitempty"`
+	// VerifyCache enables caching of verification results for keys of the key store, if the server has verify
+	// cache configured.
+	VerifyCache bool `json:"verify_cache,omitempty"`
+	// Dedupe makes a repeated request with the same content return the key store of the first request, as if the
+	// content was the idempotency key.
+	Dedupe bool `json:"dedupe,omitempty"`
+	// DisableZCAP creates a key store without a root capability, authorized by the OAuth subject of the request
+	// instead. The server must enable key stores without ZCAPs.
+	DisableZCAP bool `json:"disable_zcap,omitempty"`
+}
+
+// StorageMode is where keys of a key store are stored.
+type StorageMode string
+
+// Storage modes of key stores.
+const (
+	StorageModeLocal StorageMode = "local" // the key storage of the server
+	StorageModeEDV   StorageMode = "edv"   // a vault of the user on an EDV server
+)
 
 // EDVOptions represents options for creating data vault on EDV.
 type EDVOptions struct {
@@ -47,46 +86,286 @@ func (r *CreateKeyStoreRequest) Validate() error {
 		return fmt.Errorf("%w: controller must be non-empty", errors.ErrValidation)
 	}
 
+	if !secretshare.IsSupported(r.SecretShareScheme) {
+		return fmt.Errorf("%w: not supported secret share scheme: %s", errors.ErrValidation, r.SecretShareScheme)
+	}
+
+	switch r.StorageMode {
+	case "":
+	case StorageModeLocal:
+		if r.EDV != nil {
+			return fmt.Errorf("%w: edv options are not allowed with storage_mode %s", errors.ErrValidation,
+				StorageModeLocal)
+		}
+	case StorageModeEDV:
+		if r.EDV == nil {
+			return fmt.Errorf("%w: edv options are required with storage_mode %s", errors.ErrValidation,
+				StorageModeEDV)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported storage_mode %q, must be one of [%s %s]", errors.ErrValidation,
+			r.StorageMode, StorageModeLocal, StorageModeEDV)
+	}
+
+	if r.EDV != nil && r.EDV.VaultURL == "" {
+		return fmt.Errorf("%w: edv vault_url must be non-empty", errors.ErrValidation)
+	}
+
 	return nil
 }
 
+// storageMode returns the storage mode of the key store, inferred from EDV options if not set.
+func (r *CreateKeyStoreRequest) storageMode() StorageMode {
+	if r.StorageMode == "" && r.EDV != nil {
+		return StorageModeEDV
+	}
+
+	if r.StorageMode == "" {
+		return StorageModeLocal
+	}
+
+	return r.StorageMode
+}
+
 // CreateKeyStoreResponse is a response for CreateKeyStore request.
 type CreateKeyStoreResponse struct {
 	KeyStoreURL string `json:"key_store_url"`
 	Capability  []byte `json:"capability,omitempty"`
+	// SchemaVersion is the version of the key store metadata format of the server.
+	SchemaVersion int `json:"schema_version"`
+}
+
+// ListKeyStoresRequest is a request to list key stores of the controller, or key stores with overrides.
+type ListKeyStoresRequest struct {
+	Controller string `json:"controller"`
+	// Overrides lists key stores of all controllers that have overrides, instead of key stores of the controller.
+	Overrides bool `json:"overrides,omitempty"`
+	// PageToken is the NextPageToken of the previous page. Empty for the first page.
+	PageToken string `json:"page_token,omitempty"`
+	// PageSize defaults to DefaultKeyStorePageSize.
+	PageSize int `json:"page_size,omitempty"`
+}
+
+// Validate validates ListKeyStores request.
+func (r *ListKeyStoresRequest) Validate() error {
+	if r.Controller == "" && !r.Overrides {
+		return fmt.Errorf("%w: controller must be non-empty", errors.ErrValidation)
+	}
+
+	if r.Controller != "" && r.Overrides {
+		return fmt.Errorf("%w: controller can't be combined with overrides", errors.ErrValidation)
+	}
+
+	if r.PageSize < 0 || r.PageSize > MaxKeyStorePageSize {
+		return fmt.Errorf("%w: page size must be between 1 and %d", errors.ErrValidation, MaxKeyStorePageSize)
+	}
+
+	return nil
+}
+
+// ListKeyStoresResponse is a response for ListKeyStores request.
+type ListKeyStoresResponse struct {
+	KeyStores []KeyStoreInfo `json:"key_stores"`
+	// NextPageToken is empty on the last page.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// KeyStoreInfo is metadata of a key store listed by ListKeyStores request.
+type KeyStoreInfo struct {
+	ID          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	StorageType string    `json:"storage_type"`
+	// Overrides are omitted if the key store uses the server defaults.
+	Overrides *KeyStoreOverrides `json:"overrides,omitempty"`
+	// SchemaVersion is the version of the key store metadata format. Key stores of newer versions are listed, but
+	// can't be used with this server.
+	SchemaVersion int `json:"schema_version"`
+}
+
+// KeyStoreOverrides are server settings overridden for the key store.
+type KeyStoreOverrides struct {
+	// CacheTTL is the TTL of cached keys of the key store, e.g. "1h".
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// RateLimit is the maximum number of requests of the key store per second.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	// ConcurrencyLimit is the maximum number of requests of the key store served at the same time.
+	ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+}
+
+// SetKeyStoreOverridesRequest is a request to override server settings for the key store. Omitted settings use the
+// server defaults.
+type SetKeyStoreOverridesRequest struct {
+	// CacheTTL is the TTL of cached keys of the key store, e.g. "1h". At most the maximum configured on the server.
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// RateLimit is the maximum number of requests of the key store per second. At most the maximum configured on the
+	// server.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	// ConcurrencyLimit is the maximum number of requests of the key store served at the same time. At most the
+	// maximum configured on the server.
+	ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+}
+
+// SetKeyStoreOverridesResponse is a response for SetKeyStoreOverrides request.
+type SetKeyStoreOverridesResponse struct {
+	// Overrides are omitted if all settings were reset to the server defaults.
+	Overrides *KeyStoreOverrides `json:"overrides,omitempty"`
+	Sequence  uint64             `json:"sequence"`
+}
+
+// UpdateKeyStoreRequest is a request to update the key store.
+type UpdateKeyStoreRequest struct {
+	Controller string `json:"controller"`
+}
+
+// Validate validates UpdateKeyStore request.
+func (r *UpdateKeyStoreRequest) Validate() error {
+	if r.Controller == "" {
+		return fmt.Errorf("%w: controller must be non-empty", errors.ErrValidation)
+	}
+
+	return nil
+}
+
+// UpdateKeyStoreResponse is a response for UpdateKeyStore request.
+type UpdateKeyStoreResponse struct {
+	KeyStoreURL string `json:"key_store_url"`
+	Capability  []byte `json:"capability,omitempty"`
+	Sequence    uint64 `json:"sequence"`
 }
 
-// CreateKeyRequest is a request to create a key.
+// GetKeyStoreResponse is a response for GetKeyStore request.
+type GetKeyStoreResponse struct {
+	Controller  string    `json:"controller"`
+	CreatedAt   time.Time `json:"created_at"`
+	StorageType string    `json:"storage_type"`
+	// KeyCount doesn't include keys created before the key store started to track its keys.
+	KeyCount int    `json:"key_count"`
+	Sequence uint64 `json:"sequence"`
+	// SchemaVersion is the version of the key store metadata format, 0 for key stores that weren't saved since
+	// versions were recorded.
+	SchemaVersion int `json:"schema_version"`
+}
+
+// CreateKeyRequest is a request to create a key. An optional alias must be unique within the key store. A key with
+// an expiration time can't create new artifacts (e.g. signatures) after it expires. A key with purposes can't be used
+// for other operations.
 type CreateKeyRequest struct {
-	KeyType kms.KeyType `json:"key_type"`
+	KeyType   kms.KeyType  `json:"key_type"`
+	Alias     string       `json:"alias,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Purposes  []KeyPurpose `json:"purposes,omitempty"` // if empty, the key can be used for all operations
+	// KeySize is the size in bits of RSAPS256 keys: 2048, 3072 or 4096, defaults to 2048. For AES-GCM keys, 128 or
+	// 256 selects AES128GCM or AES256GCM.
+	KeySize int `json:"key_size,omitempty"`
+	// Hash is the hash of messages signed with NIST ECDSA keys, e.g. SHA-384 for P-256 keys. Defaults to the hash of
+	// the key type: SHA-256 for P-256, SHA-384 for P-384 and SHA-512 for P-521 keys.
+	Hash string `json:"hash,omitempty"`
 }
 
 // CreateKeyResponse is a response for CreateKey request.
 type CreateKeyResponse struct {
+	KeyURL        string `json:"key_url"`
+	PublicKey     []byte `json:"public_key"`
+	Sequence      uint64 `json:"sequence"`
+	SchemaVersion int    `json:"schema_version"` // version of the key metadata format
+}
+
+// CreateKeysRequest is a request to create a batch of keys.
+type CreateKeysRequest struct {
+	Keys []CreateKeyRequest `json:"keys"`
+}
+
+// CreatedKey is a key created by CreateKeys request.
+type CreatedKey struct {
 	KeyURL    string `json:"key_url"`
 	PublicKey []byte `json:"public_key"`
 }
 
-// ImportKeyRequest is a request to import a key.
+// CreateKeysResponse is a response for CreateKeys request. Keys are in the order of the request.
+type CreateKeysResponse struct {
+	Keys          []CreatedKey `json:"keys"`
+	Sequence      uint64       `json:"sequence"`
+	SchemaVersion int          `json:"schema_version"` // version of the key metadata format
+}
+
+// ImportKeyRequest is a request to import a key. The key is either a PKCS #8 or raw private key in Key, a raw private
+// key in KeyMultibase, or a private JWK in JWK. If ExpectedPublicKey is set, the public key derived from the private
+// key must match it.
 type ImportKeyRequest struct {
-	Key     []byte      `json:"key"`
-	KeyType kms.KeyType `json:"key_type"`
-	KeyID   string      `json:"key_id,omitempty"`
+	Key               []byte          `json:"key,omitempty"`
+	KeyMultibase      string          `json:"key_multibase,omitempty"`
+	JWK               json.RawMessage `json:"jwk,omitempty"`
+	KeyType           kms.KeyType     `json:"key_type"`
+	KeyID             string          `json:"key_id,omitempty"`
+	ExpectedPublicKey []byte          `json:"expected_public_key,omitempty"` // in the format of exported public keys
 }
 
 // ImportKeyResponse is a response for ImportKey request.
 type ImportKeyResponse struct {
-	KeyURL string `json:"key_url"`
+	KeyURL        string `json:"key_url"`
+	PublicKey     []byte `json:"public_key"`
+	Sequence      uint64 `json:"sequence"`
+	SchemaVersion int    `json:"schema_version"` // version of the key metadata format
+}
+
+// UpdateKeyRequest is a request to update a key. An empty alias removes the alias of the key.
+type UpdateKeyRequest struct {
+	Alias string `json:"alias"`
+	// VerificationMethods, if set, replace DID URLs registered for the key; an empty list removes them. A request
+	// that sets verification methods without alias leaves the alias unchanged.
+	VerificationMethods []string `json:"verification_methods,omitempty"`
+}
+
+// UpdateKeyResponse is a response for UpdateKey request.
+type UpdateKeyResponse struct {
+	KeyURL              string   `json:"key_url"`
+	Alias               string   `json:"alias,omitempty"`
+	VerificationMethods []string `json:"verification_methods,omitempty"`
+	Sequence            uint64   `json:"sequence"`
+}
+
+// SetKeyStateRequest is a request to disable or re-enable a key.
+type SetKeyStateRequest struct {
+	State KeyState `json:"state"`
+}
+
+// SetKeyStateResponse is a response for SetKeyState request.
+type SetKeyStateResponse struct {
+	KeyURL   string   `json:"key_url"`
+	State    KeyState `json:"state"`
+	Sequence uint64   `json:"sequence"`
+}
+
+// RestoreKeyResponse is a response for RestoreKey request.
+type RestoreKeyResponse struct {
+	KeyURL   string `json:"key_url"`
+	Sequence uint64 `json:"sequence"`
 }
 
 // RotateKeyRequest is a request to rotate a key.
 type RotateKeyRequest struct {
-	KeyType kms.KeyType `json:"key_type"`
+	KeyType   kms.KeyType `json:"key_type"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"` // expiration time of the new key
 }
 
 // RotateKeyResponse is a response for RotateKeyRequest request.
 type RotateKeyResponse struct {
-	KeyURL string `json:"key_url"`
+	KeyURL    string `json:"key_url"`
+	PublicKey []byte `json:"public_key"`
+	Sequence  uint64 `json:"sequence"`
+}
+
+// CreateTokenRequest is a request to mint a one-time token for an operation on a key.
+type CreateTokenRequest struct {
+	Action    string `json:"action"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+}
+
+// CreateTokenResponse is a response for CreateTokenRequest request.
+type CreateTokenResponse struct {
+	Token     string    `json:"token"`
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // ExportKeyResponse is a response for ExportKey request.
@@ -95,26 +374,307 @@ type ExportKeyResponse struct {
 	KeyType   string `json:"key_type"`
 }
 
+// GetKeyResponse is a response for GetKey request. Exportable reports whether the public key can be exported, private
+// keys can't be exported from the key store.
+type GetKeyResponse struct {
+	KeyType    string       `json:"key_type,omitempty"`
+	Alias      string       `json:"alias,omitempty"`
+	State      KeyState     `json:"state"`
+	CreatedAt  *time.Time   `json:"created_at,omitempty"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	Purposes   []KeyPurpose `json:"purposes,omitempty"`
+	Origin     KeyOrigin    `json:"origin,omitempty"`
+	Hash       string       `json:"hash,omitempty"`         // set if the key doesn't sign with the hash of its type
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"` // nil if usage isn't tracked or the key wasn't used
+	Exportable bool         `json:"exportable"`
+	PublicKey  []byte       `json:"public_key,omitempty"`
+	// VerificationMethods are DID URLs registered for the key.
+	VerificationMethods []string `json:"verification_methods,omitempty"`
+	// SchemaVersion is the version of the key metadata format, 0 for keys created before versions were recorded.
+	SchemaVersion int `json:"schema_version"`
+}
+
+// ListKeysRequest is a request to list keys of the key store.
+type ListKeysRequest struct {
+	// UnusedSince selects keys that weren't used since the time. Requires key usage tracking.
+	UnusedSince *time.Time `json:"unused_since,omitempty"`
+}
+
+// ListKeysResponse is a response for ListKeys request.
+type ListKeysResponse struct {
+	Keys     []KeyInfo `json:"keys"`
+	Sequence uint64    `json:"sequence"`
+}
+
+// KeyInfo is metadata of a key listed by ListKeys request.
+type KeyInfo struct {
+	KeyURL     string       `json:"key_url"`
+	KeyType    string       `json:"key_type,omitempty"`
+	Alias      string       `json:"alias,omitempty"`
+	State      KeyState     `json:"state"`
+	CreatedAt  *time.Time   `json:"created_at,omitempty"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	Purposes   []KeyPurpose `json:"purposes,omitempty"`
+	Origin     KeyOrigin    `json:"origin,omitempty"`
+	Hash       string       `json:"hash,omitempty"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"`
+}
+
+// ExportDIDKeyResponse is a response for ExportKey request in did format.
+type ExportDIDKeyResponse struct {
+	DID                string `json:"did"`
+	VerificationMethod string `json:"verification_method"` // DID URL with the key fingerprint as a fragment
+}
+
+// GetKeyFingerprintResponse is a response for GetKeyFingerprint request.
+type GetKeyFingerprintResponse struct {
+	Fingerprint   string `json:"fingerprint"`    // multibase (base58btc) multicodec public key, as in did:key
+	DID           string `json:"did"`            // did:key made of the fingerprint
+	JWKThumbprint string `json:"jwk_thumbprint"` // base64url-encoded SHA-256 JWK thumbprint (RFC 7638)
+}
+
+// TestVectorsResponse is a response for TestVectors request.
+type TestVectorsResponse struct {
+	Keys           []*KeyTestVector          `json:"keys"`
+	ZCAPInvocation *ZCAPInvocationTestVector `json:"zcap_invocation"`
+}
+
+// KeyTestVector is a test vector of a key type: a fixed test key, a signature made with it and its exports.
+type KeyTestVector struct {
+	KeyType string `json:"key_type"`
+	// Hash is the hash the key was created with, if it isn't the one of the key type.
+	Hash       string `json:"hash,omitempty"`
+	PrivateKey []byte `json:"private_key"` // raw private key, as ImportKey takes it; a published test key
+	KeyURL     string `json:"key_url"`
+	PublicKey  []byte `json:"public_key"` // as exported by ExportKey
+	Message    []byte `json:"message,omitempty"`
+	// Messages are the messages signed with BBS+ keys, which sign lists of messages.
+	Messages  [][]byte `json:"messages,omitempty"`
+	Signature []byte   `json:"signature"`
+	// Deterministic is false if the key type makes a different signature each time (e.g. NIST curve ECDSA). The
+	// signature can then only be verified, not reproduced.
+	Deterministic bool                       `json:"deterministic"`
+	JWK           json.RawMessage            `json:"jwk,omitempty"`         // ExportKey with format=jwk
+	DIDKey        *ExportDIDKeyResponse      `json:"did_key,omitempty"`     // ExportKey with format=did
+	Fingerprint   *GetKeyFingerprintResponse `json:"fingerprint,omitempty"` // GetKeyFingerprint
+}
+
+// ZCAPInvocationTestVector is a worked example of a request invoking a capability: the capability, the lines of the
+// signature base of the HTTP signature, the signature and the headers of the request.
+type ZCAPInvocationTestVector struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Capability is the root capability of the key store, without its proof.
+	Capability        json.RawMessage   `json:"capability"`
+	Invoker           string            `json:"invoker"` // keyId of the HTTP signature
+	Created           int64             `json:"created"`
+	CoveredComponents []string          `json:"covered_components"`
+	SignatureBase     string            `json:"signature_base"`
+	Signature         []byte            `json:"signature"`
+	Headers           map[string]string `json:"headers"`
+}
+
+// CreateInvitationRequest is a request to create a DIDComm out-of-band invitation for the key. A capability, if set,
+// is attached sealed for TheirPub (X25519 public key of the recipient).
+type CreateInvitationRequest struct {
+	Label      string `json:"label,omitempty"`
+	Capability []byte `json:"capability,omitempty"`
+	TheirPub   []byte `json:"their_pub,omitempty"`
+}
+
+// CreateInvitationResponse is a response for CreateInvitation request.
+type CreateInvitationResponse struct {
+	Invitation    json.RawMessage `json:"invitation"`
+	InvitationURL string          `json:"invitation_url"` // mediator URL with the invitation in "oob" query parameter
+}
+
 // SignRequest is a request to sign a message.
 type SignRequest struct {
 	Message []byte `json:"message"`
+	// Nonce identifies the request, so that a retry returns the signature of the first request.
+	Nonce string `json:"nonce,omitempty"`
+	// Document is a JSON document to sign instead of Message. It's transformed with the Canonicalization profile,
+	// hashed with SHA-256 and the hash is signed.
+	Document json.RawMessage `json:"document,omitempty"`
+	// Canonicalization is a profile to transform Document with: none, jcs or urdna2015.
+	Canonicalization string `json:"canonicalization,omitempty"`
+	// Messages are signed with a single BBS+ signature instead of Message. It requires a BLS12381G2 key.
+	Messages [][]byte `json:"messages,omitempty"`
+	// Deterministic derives the nonce from the key and the message (RFC 6979), so that the same message always has
+	// the same signature. It requires an ECDSA key.
+	Deterministic bool `json:"deterministic,omitempty"`
+	// ResponseEncoding is an encoding of the signature in the response: base64url, base58btc or multibase. The
+	// signature is base64-encoded without it.
+	ResponseEncoding string `json:"response_encoding,omitempty"`
+	// Prehashed signs Message as a digest computed by the client: SHA-512 with Ed25519ph for Ed25519 keys, the hash
+	// of the key parameters for ECDSA keys.
+	Prehashed bool `json:"prehashed,omitempty"`
 }
 
 // SignResponse is a response for Sign request.
 type SignResponse struct {
 	Signature []byte `json:"signature"`
+	// Canonicalization is a profile the document was transformed with before signing.
+	Canonicalization string `json:"canonicalization,omitempty"`
+	// Deterministic is true if the nonce of the signature was derived with RFC 6979.
+	Deterministic bool `json:"deterministic,omitempty"`
+	// Encoding is the encoding of Signature in JSON, base64 if empty.
+	Encoding string `json:"encoding,omitempty"`
+	// Prehashed is true if a digest was signed.
+	Prehashed bool `json:"prehashed,omitempty"`
+	// Algorithm is the algorithm a prehashed digest was signed with, e.g. Ed25519ph.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Verification describes how a signature of a prehashed digest is verified.
+	Verification string `json:"verification,omitempty"`
+	// Details are the parameters the signature was made with, if requested.
+	Details *SignatureDetails `json:"details,omitempty"`
+}
+
+// CreateUploadRequest is a request to create an upload of a payload to sign.
+type CreateUploadRequest struct {
+	// Size is the size of the payload in bytes.
+	Size int64 `json:"size"`
+	// ChunkSize is the size of the chunks of the payload in bytes. Every chunk but the last one has this size.
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// CreateUploadResponse is a response for CreateUpload request.
+type CreateUploadResponse struct {
+	UploadID  string    `json:"upload_id"`
+	Size      int64     `json:"size"`
+	ChunkSize int64     `json:"chunk_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Algorithm is the algorithm the payload will be signed with, e.g. Ed25519ph.
+	Algorithm string `json:"algorithm"`
+	// Verification describes how the signature of the payload is verified.
+	Verification string `json:"verification"`
+}
+
+// PutUploadChunkRequest is a request to upload a chunk of a payload.
+type PutUploadChunkRequest struct {
+	// Offset is the offset of the chunk in the payload, a multiple of the chunk size of the upload.
+	Offset int64  `json:"offset"`
+	Chunk  []byte `json:"chunk"`
+	// Checksum is the SHA-256 hash of the chunk.
+	Checksum []byte `json:"checksum"`
+}
+
+// SignUploadResponse is a response for SignUpload request.
+type SignUploadResponse struct {
+	Signature []byte `json:"signature"`
+	// Size is the size of the signed payload in bytes.
+	Size int64 `json:"size"`
+	// Algorithm is the algorithm the payload was signed with, e.g. Ed25519ph.
+	Algorithm string `json:"algorithm"`
+	// Verification describes how the signature is verified.
+	Verification string `json:"verification"`
+}
+
+// SignatureDetails are the parameters the crypto signed or verified a signature with, read from the key that was
+// used, so that clients can build proofs and JWS headers without assuming them from the key type.
+type SignatureDetails struct {
+	// Algorithm is the signature algorithm: ECDSA, EdDSA, Ed25519ph, RSASSA-PSS, RSASSA-PKCS1-v1_5 or BBS+.
+	Algorithm string `json:"algorithm"`
+	// Curve is the curve of the key, e.g. P-256, secp256k1 or Ed25519. Empty for RSA keys.
+	Curve string `json:"curve,omitempty"`
+	// Hash is the hash of the message, e.g. SHA-256. For a prehashed signature, the hash of the digest.
+	Hash string `json:"hash,omitempty"`
+	// SignatureFormat is the format of ECDSA signatures: DER or IEEE_P1363.
+	SignatureFormat string `json:"signature_format,omitempty"`
+	// JOSEAlgorithm is the JWS alg of the signature, e.g. ES256. Empty if there is none.
+	JOSEAlgorithm string `json:"jose_alg,omitempty"`
+	// COSEAlgorithm is the COSE algorithm of the signature, e.g. -7 for ES256. Zero if there is none.
+	COSEAlgorithm int `json:"cose_alg,omitempty"`
+	// OutputPrefix is the tink output prefix of signatures of the key: RAW, or TINK for signatures prefixed with
+	// 0x01 and the key version.
+	OutputPrefix string `json:"output_prefix"`
+	// KeyVersion is the version of the key within its keyset that signed or verified.
+	KeyVersion uint32 `json:"key_version"`
+	// Encoding is the encoding of the signature in the JSON of the request or response, e.g. base64.
+	Encoding string `json:"encoding"`
+}
+
+// SignBatchRequest is a request to sign a batch of messages.
+type SignBatchRequest struct {
+	Messages [][]byte `json:"messages"`
+}
+
+// SignBatchResponse is a response for SignBatch request. Signatures are in the order of messages.
+type SignBatchResponse struct {
+	Signatures [][]byte `json:"signatures"`
+}
+
+// SignMultiKeyRequest is a request to sign messages with keys of the key store.
+type SignMultiKeyRequest struct {
+	Items []SignMultiKeyItem `json:"items"`
+}
+
+// SignMultiKeyItem is a message to sign with a key of the key store.
+type SignMultiKeyItem struct {
+	// KeyID is the ID or alias of the key.
+	KeyID   string `json:"key_id"`
+	Message []byte `json:"message"`
+}
+
+// SignMultiKeyResponse is a response for SignMultiKey request. Signatures are in the order of items.
+type SignMultiKeyResponse struct {
+	Signatures [][]byte `json:"signatures"`
+}
+
+// SignJWTRequest is a request to sign JWT claims as a compact JWS.
+type SignJWTRequest struct {
+	// Claims is a JSON object signed as the payload of the JWS.
+	Claims json.RawMessage `json:"claims"`
+	// Headers are protected headers of the JWS. alg is set by the key type and kid defaults to the key URL.
+	Headers map[string]interface{} `json:"headers,omitempty"`
+	// Detached signs the claims unencoded (b64=false, RFC 7797) and omits them from the JWS.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// SignJWTResponse is a response for SignJWT request.
+type SignJWTResponse struct {
+	// JWS is the compact serialization of the JWS; its payload is empty if detached.
+	JWS string `json:"jws"`
 }
 
 // VerifyRequest is a request to verify a signature.
 type VerifyRequest struct {
 	Signature []byte `json:"signature"`
 	Message   []byte `json:"message"`
+	// Messages are verified against a BBS+ signature instead of Message.
+	Messages [][]byte `json:"messages,omitempty"`
+	// PublicKey is a key to verify the signature with instead of a key of the key store, in the format keys of
+	// KeyType are exported in. The request must not have a key ID.
+	PublicKey []byte `json:"public_key,omitempty"`
+	// JWK is a key to verify the signature with instead of a key of the key store, as a JWK instead of PublicKey.
+	JWK json.RawMessage `json:"jwk,omitempty"`
+	// KeyType is the type of PublicKey or JWK.
+	KeyType kms.KeyType `json:"key_type,omitempty"`
+	// SignatureEncoding is an encoding of Signature in JSON: base64url, base58btc or multibase. Signature is
+	// base64-encoded without it.
+	SignatureEncoding string `json:"signature_encoding,omitempty"`
+
+	encodedSignature string
+}
+
+func (r *VerifyRequest) hasPublicKey() bool {
+	return len(r.PublicKey) > 0 || len(r.JWK) > 0
+}
+
+// VerifyResponse is a response for Verify request with a public key or with details.
+type VerifyResponse struct {
+	Verified bool `json:"verified"`
+	// Details are the parameters the signature was verified with, if requested.
+	Details *SignatureDetails `json:"details,omitempty"`
 }
 
 // EncryptRequest is a request to encrypt a message with associated data.
 type EncryptRequest struct {
 	Message        []byte `json:"message"`
 	AssociatedData []byte `json:"associated_data,omitempty"`
+	// Nonce is a caller-managed AES-GCM nonce, accepted only if caller nonces are enabled. The caller must never
+	// reuse a nonce with the same key.
+	Nonce []byte `json:"nonce,omitempty"`
 }
 
 // EncryptResponse is a response for Encrypt request.
@@ -204,7 +764,8 @@ type EasyOpenRequest struct {
 	Ciphertext []byte `json:"ciphertext"`
 	Nonce      []byte `json:"nonce"`
 	TheirPub   []byte `json:"their_pub"`
-	MyPub      []byte `json:"my_pub"`
+	// MyPub is the ED25519 public key of the recipient. It may be omitted on the easyopen endpoint of the key.
+	MyPub []byte `json:"my_pub"`
 }
 
 // EasyOpenResponse is a response for EasyOpen request.
@@ -215,7 +776,8 @@ type EasyOpenResponse struct {
 // SealOpenRequest is a request to decrypt a ciphertext encrypted with Seal.
 type SealOpenRequest struct {
 	Ciphertext []byte `json:"ciphertext"`
-	MyPub      []byte `json:"my_pub"`
+	// MyPub is the ED25519 public key of the recipient. It may be omitted on the sealopen endpoint of the key.
+	MyPub []byte `json:"my_pub"`
 }
 
 // SealOpenResponse is a response for SealOpen request.
@@ -230,6 +792,9 @@ type WrapKeyRequest struct {
 	APV             []byte            `json:"apv"`
 	RecipientPubKey *crypto.PublicKey `json:"recipient_pub_key"`
 	Tag             []byte            `json:"tag,omitempty"`
+	// SenderKID selects ECDH-1PU key wrapping (Authcrypt) with the key of the key store as the sender, like the key
+	// in the path of the request does.
+	SenderKID string `json:"sender_kid,omitempty"`
 }
 
 // WrapKeyResponse is a response for WrapKey request.
@@ -248,3 +813,74 @@ type UnwrapKeyRequest struct {
 type UnwrapKeyResponse struct {
 	Key []byte `json:"key"`
 }
+
+// EncryptJWERequest is a request to encrypt a plaintext as a JWE for recipient public keys.
+type EncryptJWERequest struct {
+	Plaintext  []byte              `json:"plaintext"`
+	Recipients []*crypto.PublicKey `json:"recipients"`
+	// Alg is the key management algorithm; ECDH-ES+A256KW if empty.
+	Alg string `json:"alg,omitempty"`
+	// Enc is the content encryption algorithm; A256GCM if empty.
+	Enc string `json:"enc,omitempty"`
+	// Serialization is either "json" (default) or "compact". Compact serialization allows a single recipient only.
+	Serialization string `json:"serialization,omitempty"`
+}
+
+// EncryptJWEResponse is a response for EncryptJWE request.
+type EncryptJWEResponse struct {
+	JWE string `json:"jwe"`
+}
+
+// DecryptJWERequest is a request to decrypt a JWE with the key as the recipient key.
+type DecryptJWERequest struct {
+	// JWE is the JWE in compact or JSON serialization.
+	JWE string `json:"jwe"`
+}
+
+// DecryptJWEResponse is a response for DecryptJWE request.
+type DecryptJWEResponse struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+// DeriveKeyRequest is a request to derive a key from the X25519 shared secret of the key and a peer public key.
+type DeriveKeyRequest struct {
+	// PeerPublicKey is the X25519 public key of the peer as an OKP JWK.
+	PeerPublicKey json.RawMessage `json:"peer_public_key"`
+	KDF           DeriveKeyKDF    `json:"kdf"`
+	// Recipient is the public key the derived key is wrapped for. The derived key is returned raw if nil, which
+	// the server may not allow.
+	Recipient *crypto.PublicKey `json:"recipient,omitempty"`
+}
+
+// DeriveKeyKDF are the parameters of the key derivation function of a DeriveKey request.
+type DeriveKeyKDF struct {
+	// Alg is the key derivation function; HKDF-SHA256 if empty.
+	Alg  string `json:"alg,omitempty"`
+	Salt []byte `json:"salt,omitempty"`
+	Info []byte `json:"info,omitempty"`
+	// Length is the length in bytes of the derived key; DefaultDerivedKeyLength if zero.
+	Length int `json:"length,omitempty"`
+}
+
+// DeriveKeyResponse is a response for DeriveKey request. It has either the raw derived key or the derived key wrapped
+// for the recipient of the request.
+type DeriveKeyResponse struct {
+	Key        []byte                      `json:"key,omitempty"`
+	WrappedKey *crypto.RecipientWrappedKey `json:"wrapped_key,omitempty"`
+}
+
+// DeriveSubkeyRequest is a request to derive a new key of the key store from an HMAC key with HKDF.
+type DeriveSubkeyRequest struct {
+	Salt []byte `json:"salt,omitempty"`
+	Info []byte `json:"info,omitempty"`
+	// KeyType is the type of the derived key, one of the symmetric key types of subkey.KeyTypes.
+	KeyType kms.KeyType `json:"key_type"`
+	Alias   string      `json:"alias,omitempty"`
+}
+
+// DeriveSubkeyResponse is a response for DeriveSubkey request.
+type DeriveSubkeyResponse struct {
+	KeyURL        string `json:"key_url"`
+	Sequence      uint64 `json:"sequence"`
+	SchemaVersion int    `json:"schema_version"`
+}
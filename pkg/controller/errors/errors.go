@@ -16,7 +16,12 @@ var (
 	ErrValidation = NewBadRequestError(New("validation failed"))
 	ErrBadRequest = NewBadRequestError(New("bad request"))
 	ErrNotFound   = NewNotFoundError(New("not found"))
+	ErrForbidden  = NewForbiddenError(New("forbidden"))
+	ErrConflict   = NewConflictError(New("conflict"))
 	ErrInternal   = NewStatusInternalServerError(New("internal error"))
+
+	ErrUnprocessableEntity = NewUnprocessableEntityError(New("unprocessable entity"))
+	ErrTooManyRequests     = NewTooManyRequestsError(New("too many requests"))
 )
 
 // StatusErr an error with status code.
@@ -55,6 +60,26 @@ func NewNotFoundError(err error) *StatusErr {
 	return &StatusErr{error: err, status: http.StatusNotFound}
 }
 
+// NewForbiddenError represents Forbidden error.
+func NewForbiddenError(err error) *StatusErr {
+	return &StatusErr{error: err, status: http.StatusForbidden}
+}
+
+// NewConflictError represents Conflict error.
+func NewConflictError(err error) *StatusErr {
+	return &StatusErr{error: err, status: http.StatusConflict}
+}
+
+// NewUnprocessableEntityError represents UnprocessableEntity error.
+func NewUnprocessableEntityError(err error) *StatusErr {
+	return &StatusErr{error: err, status: http.StatusUnprocessableEntity}
+}
+
+// NewTooManyRequestsError represents TooManyRequests error.
+func NewTooManyRequestsError(err error) *StatusErr {
+	return &StatusErr{error: err, status: http.StatusTooManyRequests}
+}
+
 // StatusCodeFromError returns status code if an error implements an interface.
 func StatusCodeFromError(e error) int {
 	if err, ok := e.(interface{ StatusCode() int }); ok { // nolint: errorlint
@@ -24,6 +24,10 @@ func TestStatusCodeFromError(t *testing.T) {
 	require.Equal(t, StatusCodeFromError(NewStatusInternalServerError(New(errMsg))), http.StatusInternalServerError)
 	require.Equal(t, StatusCodeFromError(NewBadRequestError(New(errMsg))), http.StatusBadRequest)
 	require.Equal(t, StatusCodeFromError(NewNotFoundError(New(errMsg))), http.StatusNotFound)
+	require.Equal(t, StatusCodeFromError(NewForbiddenError(New(errMsg))), http.StatusForbidden)
+	require.Equal(t, StatusCodeFromError(NewConflictError(New(errMsg))), http.StatusConflict)
+	require.Equal(t, StatusCodeFromError(NewUnprocessableEntityError(New(errMsg))), http.StatusUnprocessableEntity)
+	require.Equal(t, StatusCodeFromError(NewTooManyRequestsError(New(errMsg))), http.StatusTooManyRequests)
 
 	// by default error has status InternalServerError
 	require.Equal(t, StatusCodeFromError(New(errMsg)), http.StatusInternalServerError)
@@ -41,6 +45,19 @@ func TestStatusCodeFromError(t *testing.T) {
 	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrNotFound), ErrNotFound))
 	require.Equal(t, errors.Unwrap(NewBadRequestError(fmt.Errorf("wrapped: %w", ErrNotFound))), ErrNotFound)
 
+	require.Equal(t, StatusCodeFromError(fmt.Errorf("wrapped: %w", ErrForbidden)), http.StatusForbidden)
+	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrForbidden), ErrForbidden))
+
+	require.Equal(t, StatusCodeFromError(fmt.Errorf("wrapped: %w", ErrConflict)), http.StatusConflict)
+	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrConflict), ErrConflict))
+
+	require.Equal(t, StatusCodeFromError(fmt.Errorf("wrapped: %w", ErrUnprocessableEntity)),
+		http.StatusUnprocessableEntity)
+	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrUnprocessableEntity), ErrUnprocessableEntity))
+
+	require.Equal(t, StatusCodeFromError(fmt.Errorf("wrapped: %w", ErrTooManyRequests)), http.StatusTooManyRequests)
+	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrTooManyRequests), ErrTooManyRequests))
+
 	require.Equal(t, StatusCodeFromError(fmt.Errorf("wrapped: %w", ErrInternal)), http.StatusInternalServerError)
 	require.True(t, errors.Is(fmt.Errorf("wrapped: %w", ErrInternal), ErrInternal))
 	require.Equal(t, errors.Unwrap(NewBadRequestError(fmt.Errorf("wrapped: %w", ErrInternal))), ErrInternal)
@@ -0,0 +1,65 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package adminmw
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const adminScheme = "Admin"
+
+var auditLogger = log.New("admin-audit")
+
+// Middleware is an admin token auth middleware. Admin tokens are a separate scheme, so that user tokens are never
+// accepted by admin endpoints.
+type Middleware struct {
+	Token *secrets.Secret
+}
+
+// Accept accepts requests with an admin token in Authorization header.
+func (mw *Middleware) Accept(req *http.Request) bool {
+	for _, h := range req.Header.Values("Authorization") {
+		if strings.HasPrefix(h, adminScheme+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware returns middleware func.
+func (mw *Middleware) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &adminHandler{token: mw.Token, next: next}
+	}
+}
+
+type adminHandler struct {
+	token *secrets.Secret
+	next  http.Handler
+}
+
+// ServeHTTP calls the next handler if the request has the admin token. An empty token doesn't authorize requests.
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token := strings.TrimSpace(strings.TrimPrefix(req.Header.Get("Authorization"), adminScheme+" "))
+
+	if !h.token.Equal([]byte(token)) {
+		auditLogger.Warnf("rejected admin request: %s %s", req.Method, req.URL.Path)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	auditLogger.Infof("admin request: %s %s", req.Method, req.URL.Path)
+
+	h.next.ServeHTTP(w, req)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package adminmw_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/adminmw"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+func TestMiddleware_Accept(t *testing.T) {
+	mw := &adminmw.Middleware{}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "Bearer token")
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "Admin token")
+	require.True(t, mw.Accept(req))
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  *secrets.Secret
+		header string
+		status int
+	}{
+		{name: "Admin token", token: secrets.New([]byte("admin")), header: "Admin admin", status: http.StatusOK},
+		{name: "Wrong token", token: secrets.New([]byte("admin")), header: "Admin user", status: http.StatusUnauthorized},
+		{name: "Empty token", token: secrets.New([]byte("admin")), header: "Admin ", status: http.StatusUnauthorized},
+		{name: "Token not configured", token: nil, header: "Admin ", status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+
+			h := (&adminmw.Middleware{Token: tc.token}).Middleware()(http.HandlerFunc(
+				func(http.ResponseWriter, *http.Request) {
+					called = true
+				}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/keystores", nil)
+			req.Header.Set("Authorization", tc.header)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			require.Equal(t, tc.status, rr.Code)
+			require.Equal(t, tc.status == http.StatusOK, called)
+		})
+	}
+}
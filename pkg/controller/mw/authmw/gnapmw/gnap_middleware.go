@@ -10,12 +10,16 @@ SPDX-License-Identifier: Apache-2.0
 package gnapmw
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
 	"github.com/trustbloc/auth/spi/gnap"
+
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
 )
 
 const (
@@ -73,9 +77,15 @@ type gnapHandler struct {
 
 // ServeHTTP authorizes an incoming HTTP request using GNAP.
 func (h *gnapHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := dryrun.FromContext(req.Context())
+
 	tokenHeader := strings.Split(strings.Trim(req.Header.Get("Authorization"), " "), " ")
 
 	if len(tokenHeader) < 2 || tokenHeader[0] != gnapToken {
+		if report != nil {
+			report.Fail(dryrun.CheckGNAP, errors.New("invalid authorization header"))
+		}
+
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 
 		return
@@ -91,16 +101,36 @@ func (h *gnapHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	resp, err := h.client.Introspect(introspectReq)
 	if err != nil {
+		if report != nil {
+			report.Fail(dryrun.CheckGNAP, fmt.Errorf("introspect token: %w", err))
+		}
+
+		var openErr *breaker.OpenError
+
+		if errors.As(err, &openErr) {
+			breaker.WriteResponse(w, openErr)
+
+			return
+		}
+
 		http.Error(w, fmt.Sprintf("introspect token: %s", err.Error()), http.StatusInternalServerError)
 
 		return
 	}
 
 	if !resp.Active {
+		if report != nil {
+			report.Fail(dryrun.CheckGNAP, errors.New("access token is not active"))
+		}
+
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 
 		return
 	}
 
+	if report != nil {
+		report.Pass(dryrun.CheckGNAP, "access token is active")
+	}
+
 	h.next.ServeHTTP(w, req)
 }
@@ -8,18 +8,23 @@ package gnapmw_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
 	"github.com/stretchr/testify/require"
 	"github.com/trustbloc/auth/spi/gnap"
 
+	"github.com/trustbloc/kms/pkg/breaker"
 	"github.com/trustbloc/kms/pkg/controller/mw/authmw/gnapmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
 )
 
 func TestAccept(t *testing.T) {
@@ -136,6 +141,32 @@ func TestMiddleware(t *testing.T) {
 		require.Equal(t, http.StatusInternalServerError, rr.Code)
 	})
 
+	t.Run("should return 503 ServiceUnavailable if breaker of auth server is open", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		client := NewMockGNAPRSClient(ctrl)
+		client.EXPECT().Introspect(gomock.Any()).Return(nil, fmt.Errorf("failed to post HTTP request: %w",
+			&breaker.OpenError{Dependency: breaker.DependencyHubAuth, RetryAfter: 10 * time.Second}))
+
+		mw := gnapmw.Middleware{Client: client, RSPubKey: &jwk.JWK{}}
+
+		next := NewMockHTTPHandler(ctrl)
+		next.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(0)
+
+		req, err := http.NewRequestWithContext(context.Background(), "", "", nil)
+		require.NoError(t, err)
+
+		req.Header.Add("Authorization", "GNAP token")
+
+		rr := httptest.NewRecorder()
+
+		mw.Middleware()(next).ServeHTTP(rr, req)
+
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Equal(t, "10", rr.Header().Get("Retry-After"))
+		require.Contains(t, rr.Body.String(), breaker.Code)
+	})
+
 	t.Run("should return 401 Unauthorized if token is inactive", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
 
@@ -158,4 +189,33 @@ func TestMiddleware(t *testing.T) {
 
 		require.Equal(t, http.StatusUnauthorized, rr.Code)
 	})
+
+	t.Run("should report checks in dry-run mode", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		client := NewMockGNAPRSClient(ctrl)
+		client.EXPECT().Introspect(gomock.Any()).Return(&gnap.IntrospectResponse{Active: false}, nil)
+
+		mw := gnapmw.Middleware{Client: client, RSPubKey: &jwk.JWK{}}
+
+		next := NewMockHTTPHandler(ctrl)
+		next.EXPECT().ServeHTTP(gomock.Any(), gomock.Any()).Times(0)
+
+		req, err := http.NewRequestWithContext(context.Background(), "", "/?dryRun=true", nil)
+		require.NoError(t, err)
+
+		req.Header.Add("Authorization", "GNAP token")
+
+		rr := httptest.NewRecorder()
+
+		dryrun.Middleware("sign")(mw.Middleware()(next)).ServeHTTP(rr, req)
+
+		var report dryrun.Report
+
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&report))
+		require.False(t, report.Allowed)
+		require.Len(t, report.Checks, 1)
+		require.Equal(t, dryrun.CheckGNAP, report.Checks[0].Name)
+		require.Equal(t, "access token is not active", report.Checks[0].Detail)
+	})
 }
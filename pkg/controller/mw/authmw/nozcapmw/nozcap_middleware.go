@@ -0,0 +1,101 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nozcapmw
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	kmserrors "github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+)
+
+// authUserHeader is set to the OAuth subject by the third-party service that introspects the token, e.g. Oathkeeper.
+const authUserHeader = "Auth-User"
+
+type keyStoreCreators interface {
+	NoZCAPKeyStoreCreator(keyStoreID string) (string, error)
+}
+
+// Middleware is an auth middleware for key stores created without ZCAPs. A request is authorized if its OAuth
+// subject created the key store.
+type Middleware struct {
+	KeyStores       keyStoreCreators
+	KeyStoreVarName string
+}
+
+// Accept accepts requests with Bearer token in Authorization header and without a capability invocation. Token
+// introspection is done by third-party service, e.g. Oathkeeper reverse proxy.
+func (mw *Middleware) Accept(req *http.Request) bool {
+	if _, ok := req.Header["Capability-Invocation"]; ok {
+		return false
+	}
+
+	for _, h := range req.Header.Values("Authorization") {
+		if strings.HasPrefix(h, "Bearer ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware returns middleware func.
+func (mw *Middleware) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &noZCAPHandler{mw: mw, next: next}
+	}
+}
+
+type noZCAPHandler struct {
+	mw   *Middleware
+	next http.Handler
+}
+
+// ServeHTTP calls the next handler with the OAuth subject as the caller if the subject created the key store.
+func (h *noZCAPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := dryrun.FromContext(req.Context())
+
+	subject := req.Header.Get(authUserHeader)
+	if subject == "" {
+		if report != nil {
+			report.Fail(dryrun.CheckNoZCAP, errors.New("missing OAuth subject"))
+		}
+
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	keyStoreID := mux.Vars(req)[h.mw.KeyStoreVarName]
+
+	creator, err := h.mw.KeyStores.NoZCAPKeyStoreCreator(keyStoreID)
+	if err == nil && creator != subject {
+		err = fmt.Errorf("%w: key store %s was created by another subject", kmserrors.ErrForbidden, keyStoreID)
+	}
+
+	if err != nil {
+		if report != nil {
+			report.Fail(dryrun.CheckNoZCAP, err)
+		}
+
+		http.Error(w, err.Error(), kmserrors.StatusCodeFromError(err))
+
+		return
+	}
+
+	if report != nil {
+		report.Pass(dryrun.CheckNoZCAP, "OAuth subject created the key store")
+	}
+
+	h.next.ServeHTTP(w, req.WithContext(authmw.WithCaller(req.Context(), subject)))
+}
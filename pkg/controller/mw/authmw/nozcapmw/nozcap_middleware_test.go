@@ -0,0 +1,164 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package nozcapmw_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	kmserrors "github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/nozcapmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+)
+
+func TestMiddleware_Accept(t *testing.T) {
+	mw := &nozcapmw.Middleware{}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "GNAP token")
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "Bearer token")
+	require.True(t, mw.Accept(req))
+
+	req.Header.Set("Capability-Invocation", "zcap capability=\"...\"")
+	require.False(t, mw.Accept(req))
+}
+
+func TestMiddleware(t *testing.T) {
+	keyStores := keyStoreCreators{
+		"ks":      {creator: "subject"},
+		"zcap-ks": {err: fmt.Errorf("%w: key store zcap-ks requires ZCAPs", kmserrors.ErrForbidden)},
+		"fail-ks": {err: errors.New("get error")},
+	}
+
+	t.Run("Creator is authorized as the caller", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		rr := serve(h, "subject", "ks")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 1, h.calls)
+		require.Equal(t, "subject", h.caller)
+	})
+
+	t.Run("Another subject", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		rr := serve(h, "other", "ks")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Contains(t, rr.Body.String(), "key store ks was created by another subject")
+		require.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Key store with ZCAPs", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		rr := serve(h, "subject", "zcap-ks")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Missing OAuth subject", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		rr := serve(h, "", "ks")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Key store error", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		rr := serve(h, "subject", "fail-ks")
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Dry run", func(t *testing.T) {
+		h := newHandler(keyStores)
+
+		for subject, passed := range map[string]bool{"subject": true, "other": false, "": false} {
+			req := newRequest(subject, "ks")
+			req.URL.RawQuery = dryrun.QueryParam + "=true"
+
+			rr := httptest.NewRecorder()
+
+			dryrun.Middleware("sign")(h.mw).ServeHTTP(rr, req)
+
+			var report dryrun.Report
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+			require.Equal(t, dryrun.CheckNoZCAP, report.Checks[0].Name)
+			require.Equal(t, passed, report.Checks[0].Passed)
+		}
+	})
+}
+
+type handler struct {
+	mw     http.Handler
+	calls  int
+	caller string
+}
+
+func (h *handler) ServeHTTP(_ http.ResponseWriter, req *http.Request) {
+	h.calls++
+	h.caller = authmw.CallerFromContext(req.Context())
+}
+
+type keyStoreCreator struct {
+	creator string
+	err     error
+}
+
+type keyStoreCreators map[string]keyStoreCreator
+
+func (c keyStoreCreators) NoZCAPKeyStoreCreator(keyStoreID string) (string, error) {
+	ks := c[keyStoreID]
+
+	return ks.creator, ks.err
+}
+
+func newHandler(keyStores keyStoreCreators) *handler {
+	mw := &nozcapmw.Middleware{
+		KeyStores:       keyStores,
+		KeyStoreVarName: "keystore",
+	}
+
+	h := &handler{}
+	h.mw = mw.Middleware()(h)
+
+	return h
+}
+
+func newRequest(subject, keyStoreID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+
+	if subject != "" {
+		req.Header.Set("Auth-User", subject)
+	}
+
+	return mux.SetURLVars(req, map[string]string{"keystore": keyStoreID})
+}
+
+func serve(h *handler, subject, keyStoreID string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+
+	h.mw.ServeHTTP(rr, newRequest(subject, keyStoreID))
+
+	return rr
+}
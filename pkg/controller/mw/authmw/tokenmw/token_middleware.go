@@ -0,0 +1,150 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokenmw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+)
+
+const oneTimeToken = "OneTime"
+
+// Error codes of rejected one-time tokens.
+const (
+	CodeTokenInvalid  = "token_invalid"
+	CodeTokenMismatch = "token_mismatch"
+	CodeTokenExpired  = "token_expired"
+	CodeTokenConsumed = "token_consumed"
+)
+
+var auditLogger = log.New("onetimetoken-audit")
+
+type tokenStore interface {
+	Check(secret, keyStoreID, keyID, action string) (*onetimetoken.Token, error)
+	Consume(secret, keyStoreID, keyID, action string) (*onetimetoken.Token, error)
+}
+
+// Middleware is a one-time token auth middleware.
+type Middleware struct {
+	Store           tokenStore
+	Action          string
+	KeyStoreVarName string
+	KeyVarName      string
+}
+
+// Accept accepts requests with a one-time token in Authorization header.
+func (mw *Middleware) Accept(req *http.Request) bool {
+	for _, h := range req.Header.Values("Authorization") {
+		if strings.HasPrefix(h, oneTimeToken+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware returns middleware func.
+func (mw *Middleware) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return &tokenHandler{mw: mw, next: next}
+	}
+}
+
+type tokenHandler struct {
+	mw   *Middleware
+	next http.Handler
+}
+
+// ServeHTTP consumes the one-time token and calls the next handler if the token authorizes the request. In dry-run
+// mode the token is checked but not consumed.
+func (h *tokenHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	secret := strings.TrimSpace(strings.TrimPrefix(req.Header.Get("Authorization"), oneTimeToken+" "))
+	vars := mux.Vars(req)
+
+	report := dryrun.FromContext(req.Context())
+
+	var (
+		token *onetimetoken.Token
+		err   error
+	)
+
+	if report != nil {
+		token, err = h.mw.Store.Check(secret, vars[h.mw.KeyStoreVarName], vars[h.mw.KeyVarName], h.mw.Action)
+	} else {
+		token, err = h.mw.Store.Consume(secret, vars[h.mw.KeyStoreVarName], vars[h.mw.KeyVarName], h.mw.Action)
+	}
+
+	if err != nil {
+		if report != nil {
+			report.Fail(dryrun.CheckToken, err)
+		}
+
+		sendError(w, err)
+
+		return
+	}
+
+	if report != nil {
+		report.Pass(dryrun.CheckToken, fmt.Sprintf("one-time token %s is valid", token.ID))
+		h.next.ServeHTTP(w, req)
+
+		return
+	}
+
+	rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	h.next.ServeHTTP(rw, req)
+
+	auditLogger.Infof("operation authorized by one-time token: id=%s action=%s %s %s status=%d",
+		token.ID, h.mw.Action, req.Method, req.URL.Path, rw.status)
+}
+
+func sendError(w http.ResponseWriter, err error) {
+	status, code := http.StatusForbidden, ""
+
+	switch {
+	case errors.Is(err, onetimetoken.ErrInvalid):
+		status, code = http.StatusUnauthorized, CodeTokenInvalid
+	case errors.Is(err, onetimetoken.ErrMismatch):
+		code = CodeTokenMismatch
+	case errors.Is(err, onetimetoken.ErrExpired):
+		code = CodeTokenExpired
+	case errors.Is(err, onetimetoken.ErrConsumed):
+		code = CodeTokenConsumed
+	default:
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(&errorResponse{Message: err.Error(), Code: code}) //nolint:errcheck
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tokenmw_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw/tokenmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+)
+
+func TestMiddleware_Accept(t *testing.T) {
+	mw := &tokenmw.Middleware{}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "GNAP token")
+	require.False(t, mw.Accept(req))
+
+	req.Header.Set("Authorization", "OneTime token")
+	require.True(t, mw.Accept(req))
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("Token authorizes exactly one operation", func(t *testing.T) {
+		store, clk := newStore(t)
+
+		secret, _, err := store.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		h := newHandler(store, "verify")
+
+		rr := serve(h, secret, "ks", "key")
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.Equal(t, 1, h.calls)
+
+		rr = serve(h, secret, "ks", "key")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Equal(t, tokenmw.CodeTokenConsumed, errorCode(t, rr))
+		require.Equal(t, 1, h.calls)
+
+		secret, _, err = store.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+
+		rr = serve(h, secret, "ks", "key")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Equal(t, tokenmw.CodeTokenExpired, errorCode(t, rr))
+		require.Equal(t, 1, h.calls)
+	})
+
+	t.Run("Token for another key", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		secret, _, err := store.Mint("ks", "key", "exportKey", time.Minute)
+		require.NoError(t, err)
+
+		h := newHandler(store, "exportKey")
+
+		rr := serve(h, secret, "ks", "other")
+		require.Equal(t, http.StatusForbidden, rr.Code)
+		require.Equal(t, tokenmw.CodeTokenMismatch, errorCode(t, rr))
+		require.Equal(t, 0, h.calls)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		h := newHandler(store, "verify")
+
+		rr := serve(h, "unknown", "ks", "key")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Equal(t, tokenmw.CodeTokenInvalid, errorCode(t, rr))
+	})
+
+	t.Run("Store error", func(t *testing.T) {
+		h := newHandler(&failingStore{err: errors.New("store error")}, "verify")
+
+		rr := serve(h, "token", "ks", "key")
+		require.Equal(t, http.StatusInternalServerError, rr.Code)
+		require.Empty(t, errorCode(t, rr))
+	})
+
+	t.Run("Dry run does not consume token", func(t *testing.T) {
+		store, _ := newStore(t)
+
+		secret, _, err := store.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		h := newHandler(store, "verify")
+
+		req := newRequest(secret, "ks", "key")
+		req.URL.RawQuery = dryrun.QueryParam + "=true"
+
+		rr := httptest.NewRecorder()
+
+		dryrun.Middleware("verify")(h.mw).ServeHTTP(rr, req)
+
+		var report dryrun.Report
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+		require.Equal(t, dryrun.CheckToken, report.Checks[0].Name)
+		require.True(t, report.Checks[0].Passed)
+
+		_, err = store.Consume(secret, "ks", "key", "verify")
+		require.NoError(t, err)
+	})
+}
+
+type handler struct {
+	mw    http.Handler
+	calls int
+}
+
+func (h *handler) ServeHTTP(http.ResponseWriter, *http.Request) {
+	h.calls++
+}
+
+type tokenStore interface {
+	Check(secret, keyStoreID, keyID, action string) (*onetimetoken.Token, error)
+	Consume(secret, keyStoreID, keyID, action string) (*onetimetoken.Token, error)
+}
+
+func newHandler(store tokenStore, action string) *handler {
+	mw := &tokenmw.Middleware{
+		Store:           store,
+		Action:          action,
+		KeyStoreVarName: "keystore",
+		KeyVarName:      "key",
+	}
+
+	h := &handler{}
+	h.mw = mw.Middleware()(h)
+
+	return h
+}
+
+func newRequest(secret, keyStoreID, keyID string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "OneTime "+secret)
+
+	return mux.SetURLVars(req, map[string]string{"keystore": keyStoreID, "key": keyID})
+}
+
+func serve(h *handler, secret, keyStoreID, keyID string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+
+	h.mw.ServeHTTP(rr, newRequest(secret, keyStoreID, keyID))
+
+	return rr
+}
+
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var resp struct {
+		Code string `json:"code"`
+	}
+
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+	return resp.Code
+}
+
+func newStore(t *testing.T) (*onetimetoken.Store, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(time.Now())
+
+	store, err := onetimetoken.New(mem.NewProvider(), clk)
+	require.NoError(t, err)
+
+	return store, clk
+}
+
+type failingStore struct {
+	err error
+}
+
+func (s *failingStore) Check(string, string, string, string) (*onetimetoken.Token, error) {
+	return nil, s.err
+}
+
+func (s *failingStore) Consume(string, string, string, string) (*onetimetoken.Token, error) {
+	return nil, s.err
+}
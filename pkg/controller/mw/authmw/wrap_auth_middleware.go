@@ -8,7 +8,10 @@ SPDX-License-Identifier: Apache-2.0
 
 package authmw
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // Middleware represents an auth middleware that can handle authorization for the given HTTP request.
 type Middleware interface {
@@ -16,6 +19,21 @@ type Middleware interface {
 	Middleware() func(http.Handler) http.Handler
 }
 
+type callerContextKey struct{}
+
+// WithCaller returns a copy of ctx with the identity of the authorized caller, e.g. the invoker of a capability.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerContextKey{}, caller)
+}
+
+// CallerFromContext returns the identity of the authorized caller, or an empty string if the auth method doesn't
+// identify the caller.
+func CallerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerContextKey{}).(string) //nolint:errcheck
+
+	return caller
+}
+
 // HTTPHandler is an alias for http.Handler (used by GoMock to generate a mock).
 type HTTPHandler = http.Handler
 
@@ -61,3 +61,10 @@ func TestWrapMiddleware(t *testing.T) {
 		require.Equal(t, http.StatusOK, rr.Code)
 	})
 }
+
+func TestCallerFromContext(t *testing.T) {
+	require.Empty(t, authmw.CallerFromContext(context.Background()))
+
+	ctx := authmw.WithCaller(context.Background(), "did:key:z6Mk#z6Mk")
+	require.Equal(t, "did:key:z6Mk#z6Mk", authmw.CallerFromContext(ctx))
+}
@@ -0,0 +1,163 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package zcapmw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
+)
+
+// HintHeader is a response header with a remediation hint for a rejected capability invocation. It is set only if
+// ZCAPConfig.DebugAuth is enabled. The value is a hint class followed by "; " and details.
+const HintHeader = "Auth-Hint"
+
+// Hint classes of rejected capability invocations.
+const (
+	// HintSignatureBaseMismatch means that the HTTP signature doesn't verify against the request the server received,
+	// e.g. because a proxy rewrote the request target, or a covered header is missing or expired.
+	HintSignatureBaseMismatch = "signature-base-mismatch"
+	// HintUnknownInvokerKey means that the key of the HTTP signature can't be resolved, or isn't the invoker of the
+	// capability.
+	HintUnknownInvokerKey = "unknown-invoker-key"
+	// HintResourceMismatch means that the capability is not for the key store of the request URI.
+	HintResourceMismatch = "resource-mismatch"
+	// HintActionNotPermitted means that the capability doesn't allow the action of the endpoint.
+	HintActionNotPermitted = "action-not-permitted"
+	// HintCapabilitySuperseded means that the root capability was re-issued for a new controller of the key store and
+	// the grace period of the old capability has ended.
+	HintCapabilitySuperseded = "capability-superseded"
+)
+
+const fingerprintLen = 8
+
+// sensitiveHeaders are covered headers with values that are not fingerprinted in hints.
+var sensitiveHeaders = map[string]bool{ //nolint:gochecknoglobals // read-only set
+	"authorization": true,
+	"cookie":        true,
+	"secret-share":  true,
+}
+
+// actionError is returned when the capability invocation doesn't allow the action of the endpoint.
+type actionError struct {
+	msg  string
+	hint string
+}
+
+func (e *actionError) Error() string {
+	return e.msg
+}
+
+// authHint returns a remediation hint for the error of a rejected capability invocation, or an empty string if the
+// error doesn't fall into any hint class. Hints never include signatures, capability proofs or values of covered
+// headers; the errors of the HTTP signature and zcap libraries are matched by their messages.
+func authHint(err error, r *http.Request, expected *zcapld.InvocationExpectations) string {
+	var actionErr *actionError
+	if errors.As(err, &actionErr) {
+		return actionErr.hint
+	}
+
+	if errors.Is(err, zcapldsvc.ErrCapabilitySuperseded) {
+		return fmt.Sprintf("%s; key store controller has changed, use the capability issued to the new controller",
+			HintCapabilitySuperseded)
+	}
+
+	msg := err.Error()
+
+	switch {
+	case strings.Contains(msg, "failed to resolve did:key URL"):
+		return fmt.Sprintf("%s; keyId %q of the signature can't be resolved to a did:key", HintUnknownInvokerKey,
+			signatureParams(r).KeyID)
+	case strings.Contains(msg, "failed to verify http signature"):
+		return signatureBaseHint(err, r)
+	case strings.Contains(msg, "the authorized invoker does not match the verification method"):
+		return fmt.Sprintf("%s; keyId %q of the signature is not the invoker of the capability",
+			HintUnknownInvokerKey, signatureParams(r).KeyID)
+	case strings.Contains(msg, "expected target does not match"),
+		strings.Contains(msg, "expected root capability does not match"),
+		strings.Contains(msg, "failed to resolve root capability"):
+		return fmt.Sprintf("%s; capability is not for resource %q", HintResourceMismatch, expected.Target)
+	case strings.Contains(msg, "capability action"), strings.Contains(msg, "not allowed by parent"):
+		return fmt.Sprintf("%s; capability doesn't allow action %q", HintActionNotPermitted, expected.Action)
+	default:
+		return ""
+	}
+}
+
+// signatureBaseHint explains why the HTTP signature doesn't verify. If the signature is wrong, the hint lists
+// fingerprints of the signature base lines the server built, so that the client can find the lines that differ from
+// the ones it signed.
+func signatureBaseHint(err error, r *http.Request) string {
+	var hsErr *httpsignatures.ErrHS
+	if !errors.As(err, &hsErr) {
+		return fmt.Sprintf("%s; invalid signature header", HintSignatureBaseMismatch)
+	}
+
+	if hsErr.Message != "wrong signature" {
+		return fmt.Sprintf("%s; %s", HintSignatureBaseMismatch, hsErr.Error())
+	}
+
+	params := signatureParams(r)
+
+	fingerprints := make([]string, 0, len(params.Headers))
+
+	for _, h := range params.Headers {
+		fingerprints = append(fingerprints, h+"="+lineFingerprint(h, signatureBaseLine(h, &params, r)))
+	}
+
+	return fmt.Sprintf("%s; server request target is %q; sha256 prefixes of signature base lines: %s",
+		HintSignatureBaseMismatch, strings.ToLower(r.Method)+" "+r.URL.RequestURI(), strings.Join(fingerprints, " "))
+}
+
+// signatureBaseLine returns the line of the signature base for the covered component, the same way as the HTTP
+// signature library does.
+func signatureBaseLine(component string, params *httpsignatures.Headers, r *http.Request) string {
+	switch component {
+	case "(request-target)":
+		return fmt.Sprintf("%s: %s %s", component, strings.ToLower(r.Method), r.URL.RequestURI())
+	case "(created)":
+		return fmt.Sprintf("%s: %d", component, params.Created.Unix())
+	case "(expires)":
+		return fmt.Sprintf("%s: %d", component, params.Expires.Unix())
+	default:
+		values := r.Header[textproto.CanonicalMIMEHeaderKey(component)]
+		if len(values) == 0 {
+			return ""
+		}
+
+		return fmt.Sprintf("%s: %s", strings.ToLower(component), strings.TrimSpace(values[0]))
+	}
+}
+
+func lineFingerprint(component, line string) string {
+	if sensitiveHeaders[strings.ToLower(component)] {
+		return "redacted"
+	}
+
+	sum := sha256.Sum256([]byte(line))
+
+	return hex.EncodeToString(sum[:])[:fingerprintLen]
+}
+
+// signatureParams returns parameters of the Signature header, or empty parameters if the header is malformed.
+func signatureParams(r *http.Request) httpsignatures.Headers {
+	params, err := httpsignatures.NewParser().ParseSignatureHeader(r.Header.Get("Signature"))
+	if err != nil {
+		return httpsignatures.Headers{}
+	}
+
+	return params
+}
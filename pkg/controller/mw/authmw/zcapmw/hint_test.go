@@ -0,0 +1,276 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package zcapmw //nolint:testpackage // mocking internal implementation details
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	mockcrypto "github.com/hyperledger/aries-framework-go/pkg/mock/crypto"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+	vdrkey "github.com/hyperledger/aries-framework-go/pkg/vdr/key"
+	"github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/stretchr/testify/require"
+	"github.com/trustbloc/edge-core/pkg/log/mocklogger"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/kms/pkg/controller/rest"
+	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
+)
+
+const (
+	baseResourceURL = "https://kms.example.com/v1/keystores"
+	keyStoreID      = "ks1"
+	resource        = baseResourceURL + "/" + keyStoreID
+	keysPath        = "/v1/keystores/" + keyStoreID + "/keys"
+)
+
+func TestMiddleware_DebugAuth(t *testing.T) {
+	t.Run("Action not permitted", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		resp := env.do(t, env.capability(resource, env.keyID, "sign"), "createKey", nil)
+
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.Equal(t, `action-not-permitted; capability allows ["sign"], endpoint needs "createKey"`,
+			resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Invoked action not permitted", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		resp := env.do(t, env.capability(resource, env.keyID, "sign", "createKey"), "sign", nil)
+
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.Equal(t, `action-not-permitted; invoked action "sign", endpoint needs "createKey"`,
+			resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Capability for another resource", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		resp := env.do(t, env.capability(baseResourceURL+"/other", env.keyID, "createKey"), "createKey", nil)
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Equal(t, fmt.Sprintf("resource-mismatch; capability is not for resource %q", resource),
+			resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Signing key is not the invoker", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		_, otherKeyID := fingerprint.CreateDIDKey(newPublicKey(t))
+
+		resp := env.do(t, env.capability(resource, otherKeyID, "createKey"), "createKey", nil)
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Equal(t,
+			fmt.Sprintf("unknown-invoker-key; keyId %q of the signature is not the invoker of the capability", env.keyID),
+			resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Signing key can't be resolved", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		resp := env.do(t, env.capability(resource, env.keyID, "createKey"), "createKey", func(r *http.Request) {
+			r.Header.Set("Signature", strings.Replace(r.Header.Get("Signature"), env.keyID, "did:example:123", 1))
+		})
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Equal(t, `unknown-invoker-key; keyId "did:example:123" of the signature can't be resolved to a did:key`,
+			resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Signature doesn't match the signature base", func(t *testing.T) {
+		env := newHintEnv(t)
+		env.crypto.VerifyErr = errors.New("signature doesn't match")
+		env.headers["Secret-Share"] = "c2VjcmV0"
+
+		capability := env.capability(resource, env.keyID, "createKey")
+
+		resp := env.do(t, capability, "createKey", nil, "(request-target)", "capability-invocation", "secret-share")
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		hint := resp.Header.Get(HintHeader)
+		require.Equal(t, fmt.Sprintf(`signature-base-mismatch; server request target is "post %s"; `+
+			"sha256 prefixes of signature base lines: (request-target)=%s capability-invocation=%s "+
+			"secret-share=redacted", keysPath,
+			sha256Prefix("(request-target): post "+keysPath),
+			sha256Prefix("capability-invocation: "+invocationHeader(t, capability, "createKey"))), hint)
+		require.NotContains(t, hint, "c2VjcmV0")
+	})
+
+	t.Run("Covered header is missing", func(t *testing.T) {
+		env := newHintEnv(t)
+		env.headers["Request-Id"] = "1"
+
+		resp := env.do(t, env.capability(resource, env.keyID, "createKey"), "createKey", func(r *http.Request) {
+			r.Header.Del("Request-Id")
+		}, "(request-target)", "request-id")
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Equal(t, "signature-base-mismatch; build signature string error: "+
+			"header 'request-id', required in signature, not found", resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Signature header is missing", func(t *testing.T) {
+		env := newHintEnv(t)
+
+		resp := env.do(t, env.capability(resource, env.keyID, "createKey"), "createKey", func(r *http.Request) {
+			r.Header.Del("Signature")
+		})
+
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		require.Equal(t, "signature-base-mismatch; signature header not found", resp.Header.Get(HintHeader))
+	})
+
+	t.Run("Capability superseded", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, keysPath, nil)
+		err := fmt.Errorf("check invoker: %w", zcapldsvc.ErrCapabilitySuperseded)
+
+		require.Equal(t, "capability-superseded; key store controller has changed, use the capability issued to the "+
+			"new controller", authHint(err, req, &zcapld.InvocationExpectations{Target: resource}))
+	})
+
+	t.Run("No hints without debug auth", func(t *testing.T) {
+		env := newHintEnv(t)
+		env.config.DebugAuth = false
+
+		resp := env.do(t, env.capability(resource, env.keyID, "sign"), "createKey", nil)
+
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+		require.Empty(t, resp.Header.Get(HintHeader))
+	})
+}
+
+type hintEnv struct {
+	config  *ZCAPConfig
+	auth    *mockAuthService
+	crypto  *mockcrypto.Crypto
+	keyID   string
+	headers map[string]string // set on requests before they are signed
+}
+
+func newHintEnv(t *testing.T) *hintEnv {
+	t.Helper()
+
+	_, keyID := fingerprint.CreateDIDKey(newPublicKey(t))
+
+	env := &hintEnv{
+		crypto:  &mockcrypto.Crypto{},
+		keyID:   keyID,
+		headers: map[string]string{},
+	}
+
+	env.auth = &mockAuthService{keyManager: &mockkms.KeyManager{}, crpto: env.crypto}
+
+	env.config = &ZCAPConfig{
+		AuthService:          env.auth,
+		Logger:               &mocklogger.MockLogger{},
+		VDRResolver:          vdr.New(vdr.WithVDR(vdrkey.New())),
+		BaseResourceURL:      baseResourceURL,
+		ResourceIDQueryParam: rest.KeyStoreVarName,
+		DebugAuth:            true,
+	}
+
+	return env
+}
+
+// capability returns a root capability that the key server resolves for the invocation.
+func (e *hintEnv) capability(target, invoker string, actions ...string) *zcapld.Capability {
+	c := &zcapld.Capability{
+		ID:               target,
+		Invoker:          invoker,
+		AllowedAction:    actions,
+		InvocationTarget: zcapld.InvocationTarget{ID: target},
+	}
+
+	e.auth.resolveVal = c
+
+	return c
+}
+
+// do sends a request with the capability invocation signed by the key of the env. The update func changes the
+// request after it is signed.
+func (e *hintEnv) do(t *testing.T, capability *zcapld.Capability, action string, update func(*http.Request),
+	coveredHeaders ...string) *http.Response {
+	t.Helper()
+
+	router := mux.NewRouter()
+	router.Handle(rest.KeyPath, (&Middleware{Config: e.config, Action: "createKey"}).Middleware()(&handler{}))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+keysPath, nil) // nolint:noctx // ignore
+	require.NoError(t, err)
+
+	req.Header.Set(zcapld.CapabilityInvocationHTTPHeader, invocationHeader(t, capability, action))
+
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	if len(coveredHeaders) == 0 {
+		coveredHeaders = []string{"(request-target)", "(created)", zcapld.CapabilityInvocationHTTPHeader}
+	}
+
+	hs := httpsignatures.NewHTTPSignatures(&zcapld.AriesDIDKeySecrets{})
+	hs.SetDefaultSignatureHeaders(coveredHeaders)
+	hs.SetSignatureHashAlgorithm(&zcapld.AriesDIDKeySignatureHashAlgorithm{
+		Crypto: &mockcrypto.Crypto{SignValue: []byte("signature")},
+		KMS:    &mockkms.KeyManager{},
+	})
+
+	require.NoError(t, hs.Sign(e.keyID, req))
+
+	if update != nil {
+		update(req)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return resp
+}
+
+func invocationHeader(t *testing.T, capability *zcapld.Capability, action string) string {
+	t.Helper()
+
+	compressed, err := zcapld.CompressZCAP(capability)
+	require.NoError(t, err)
+
+	return fmt.Sprintf(`zcap capability="%s",action="%s"`, compressed, action)
+}
+
+func newPublicKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return pub
+}
+
+func sha256Prefix(line string) string {
+	sum := sha256.Sum256([]byte(line))
+
+	return hex.EncodeToString(sum[:])[:8]
+}
@@ -10,7 +10,11 @@ package zcapmw
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -24,7 +28,12 @@ import (
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+	"github.com/trustbloc/kms/pkg/gziplimit"
 	"github.com/trustbloc/kms/pkg/metrics"
+	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
 )
 
 // DocumentLoader is an alias for ld.DocumentLoader.
@@ -42,6 +51,7 @@ type authService interface {
 	KMS() kms.KeyManager
 	Crypto() crypto.Crypto
 	Resolve(string) (*zcapld.Capability, error)
+	CheckInvoker(uri, invoker string) error
 }
 
 // ZCAPConfig is a configuration for zcapld middleware.
@@ -52,6 +62,11 @@ type ZCAPConfig struct {
 	VDRResolver          zcapld.VDRResolver
 	BaseResourceURL      string
 	ResourceIDQueryParam string
+	// DebugAuth enables remediation hints in the HintHeader of rejected capability invocations.
+	DebugAuth bool
+	// MaxCapabilitySize is the maximum decompressed size of invoked capabilities in bytes. Defaults to
+	// gziplimit.DefaultMaxSize.
+	MaxCapabilitySize int64
 }
 
 // Middleware is a zcapld auth middleware.
@@ -73,6 +88,7 @@ func (mw *Middleware) Middleware() func(http.Handler) http.Handler {
 		return &mwHandler{
 			next:                 next,
 			zcaps:                &capabilityResolverMetrics{wrapped: mw.Config.AuthService},
+			invokers:             mw.Config.AuthService,
 			keys:                 mw.Config.AuthService.KMS(),
 			crpto:                mw.Config.AuthService.Crypto(),
 			jsonLDLoader:         &documentLoaderMetrics{wrapped: mw.Config.JSONLDLoader},
@@ -82,10 +98,16 @@ func (mw *Middleware) Middleware() func(http.Handler) http.Handler {
 			baseResourceURL:      mw.Config.BaseResourceURL,
 			resourceIDQueryParam: mw.Config.ResourceIDQueryParam,
 			handlerAction:        mw.Action,
+			debugAuth:            mw.Config.DebugAuth,
+			maxCapabilitySize:    mw.Config.MaxCapabilitySize,
 		}
 	}
 }
 
+type invokerChecker interface {
+	CheckInvoker(uri, invoker string) error
+}
+
 type namer interface {
 	GetName() string
 }
@@ -93,6 +115,7 @@ type namer interface {
 type mwHandler struct {
 	next                 http.Handler
 	zcaps                zcapld.CapabilityResolver
+	invokers             invokerChecker
 	keys                 kms.KeyManager
 	crpto                crypto.Crypto
 	jsonLDLoader         ld.DocumentLoader
@@ -102,6 +125,8 @@ type mwHandler struct {
 	baseResourceURL      string
 	resourceIDQueryParam string
 	handlerAction        string
+	debugAuth            bool
+	maxCapabilitySize    int64
 }
 
 func (h *mwHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -124,13 +149,52 @@ func (h *mwHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Action:         h.handlerAction,
 	}
 
+	report := dryrun.FromContext(r.Context())
+
+	// called before the error response is written, so the hint header is sent with it
+	errConsumer := func(err error) {
+		h.logError(err)
+
+		if report != nil {
+			report.Fail(dryrun.CheckZCAP, err)
+		}
+
+		if h.debugAuth {
+			if hint := authHint(err, r, expectations); hint != "" {
+				w.Header().Set(HintHeader, hint)
+			}
+		}
+	}
+
+	// the capability is decompressed with a limit before the zcapld handler decompresses it without one
+	invoked, err := zcapldsvc.DecodeZCAP(invocationParams(r)["capability"], h.maxCapabilitySize)
+
+	var limitErr *gziplimit.LimitError
+
+	if errors.As(err, &limitErr) {
+		metrics.Get().RequestLimitRejection(gziplimit.LimitDecompressedSize)
+		errConsumer(err)
+		http.Error(w, "capability too large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if err = h.checkInvokedAction(r, invoked); err != nil {
+		errConsumer(err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+
+		return
+	}
+
+	vdrResolver := &dependencyResolver{wrapped: h.vdrResolver}
+
 	// TODO make KeyResolver configurable
 	// TODO make signature suites configurable
 	zcapld.NewHTTPSigAuthHandler(
 		&zcapld.HTTPSigAuthConfig{
 			CapabilityResolver: h.zcaps,
-			KeyResolver:        zcapld.NewDIDKeyResolver(h.vdrResolver),
-			VDRResolver:        h.vdrResolver,
+			KeyResolver:        zcapld.NewDIDKeyResolver(vdrResolver),
+			VDRResolver:        vdrResolver,
 			VerifierOptions: []zcapld.VerificationOption{
 				zcapld.WithSignatureSuites(
 					ed25519signature2018.New(suite.WithVerifier(ed25519signature2018.NewPublicKeyVerifier())),
@@ -138,16 +202,40 @@ func (h *mwHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				zcapld.WithLDDocumentLoaders(h.jsonLDLoader),
 			},
 			Secrets:     &zcapld.AriesDIDKeySecrets{},
-			ErrConsumer: h.logError,
+			ErrConsumer: errConsumer,
 			KMS:         h.keys,
 			Crypto:      h.crpto,
 		},
 		expectations,
-		func(w http.ResponseWriter, r *http.Request) {
+		func(_ http.ResponseWriter, r *http.Request) {
+			c := invokedCapability(invoked)
+
+			// the zcapld handler verifies the root capability against its own proof only, so a root capability that
+			// was re-issued for another invoker is rejected here
+			if c != nil && c.Parent == "" {
+				if err := h.invokers.CheckInvoker(c.ID, c.Invoker); err != nil {
+					errConsumer(err)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+					return
+				}
+			}
+
 			metrics.Get().ZCAPLDTime(time.Since(getStartTime))
+
+			if report != nil {
+				report.Pass(dryrun.CheckZCAP, "capability invocation verified")
+				report.SetCapability(c)
+			}
+
+			// the invocation is signed by the invoker of the capability
+			if c != nil {
+				r = r.WithContext(authmw.WithCaller(r.Context(), c.Invoker))
+			}
+
 			h.next.ServeHTTP(w, r)
 		},
-	).ServeHTTP(w, r)
+	).ServeHTTP(&dependencyErrorWriter{ResponseWriter: w, resolver: vdrResolver}, r)
 
 	h.logger.Debugf("finished handling request: %s", r.URL.String())
 }
@@ -156,6 +244,68 @@ func (h *mwHandler) logError(err error) {
 	h.logger.Errorf("unauthorized capability invocation: %s", err.Error())
 }
 
+// checkInvokedAction rejects invocations of a different action and capabilities that do not allow the handler's
+// action. Malformed invocations (a nil zcap) are left to the zcapld handler which responds with 401.
+func (h *mwHandler) checkInvokedAction(r *http.Request, zcap *zcapld.Capability) error {
+	params := invocationParams(r)
+
+	if action, ok := params["action"]; ok && action != h.handlerAction {
+		return &actionError{
+			msg:  fmt.Sprintf("invoked action %q does not match %q", action, h.handlerAction),
+			hint: fmt.Sprintf("%s; invoked action %q, endpoint needs %q", HintActionNotPermitted, action, h.handlerAction),
+		}
+	}
+
+	if zcap == nil {
+		return nil // verified by zcapld handler
+	}
+
+	for _, action := range zcap.AllowedAction {
+		if action == h.handlerAction {
+			return nil
+		}
+	}
+
+	return &actionError{
+		msg: fmt.Sprintf("capability does not allow action %q", h.handlerAction),
+		hint: fmt.Sprintf("%s; capability allows %q, endpoint needs %q", HintActionNotPermitted,
+			zcap.AllowedAction, h.handlerAction),
+	}
+}
+
+// invokedCapability returns the (already verified) capability of the Capability-Invocation header.
+func invokedCapability(zcap *zcapld.Capability) *dryrun.Capability {
+	if zcap == nil {
+		return nil
+	}
+
+	return &dryrun.Capability{
+		ID:             zcap.ID,
+		Parent:         zcap.Parent,
+		Invoker:        zcap.Invoker,
+		AllowedActions: zcap.AllowedAction,
+	}
+}
+
+// invocationParams returns parameters of the Capability-Invocation header.
+func invocationParams(r *http.Request) map[string]string {
+	const numParts = 2
+
+	params := make(map[string]string)
+	value := strings.TrimSpace(r.Header.Get(zcapld.CapabilityInvocationHTTPHeader))
+
+	for _, param := range strings.Split(strings.TrimPrefix(value, "zcap "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", numParts)
+		if len(kv) != numParts {
+			continue
+		}
+
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+
+	return params
+}
+
 type muxNamer struct{}
 
 func (m *muxNamer) GetName(r *http.Request) namer {
@@ -190,6 +340,63 @@ func (w *documentLoaderMetrics) LoadDocument(u string) (*ld.RemoteDocument, erro
 	return d, err
 }
 
+// dependencyResolver remembers whether a DID of the invocation couldn't be resolved because the breaker of the DID
+// resolver is open, so that the request fails with 503 instead of 401.
+type dependencyResolver struct {
+	wrapped zcapld.VDRResolver
+	mutex   sync.Mutex
+	openErr *breaker.OpenError
+}
+
+func (r *dependencyResolver) Resolve(didStr string, opts ...vdr.DIDMethodOption) (*did.DocResolution, error) {
+	d, err := r.wrapped.Resolve(didStr, opts...)
+
+	var openErr *breaker.OpenError
+
+	if errors.As(err, &openErr) {
+		r.mutex.Lock()
+		r.openErr = openErr
+		r.mutex.Unlock()
+	}
+
+	return d, err
+}
+
+func (r *dependencyResolver) openError() *breaker.OpenError {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.openErr
+}
+
+// dependencyErrorWriter replaces the 401 response of the zcapld handler with a 503 response if the DID resolver was
+// unavailable.
+type dependencyErrorWriter struct {
+	http.ResponseWriter
+	resolver *dependencyResolver
+	replaced bool
+}
+
+func (w *dependencyErrorWriter) WriteHeader(status int) {
+	if openErr := w.resolver.openError(); status == http.StatusUnauthorized && openErr != nil {
+		w.replaced = true
+
+		breaker.WriteResponse(w.ResponseWriter, openErr)
+
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *dependencyErrorWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil // the body of the replaced response
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
 type vdrResolverMetrics struct {
 	wrapped zcapld.VDRResolver
 }
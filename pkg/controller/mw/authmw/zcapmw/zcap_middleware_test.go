@@ -8,9 +8,13 @@ package zcapmw //nolint:testpackage // mocking internal implementation details
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
@@ -19,6 +23,7 @@ import (
 	"github.com/trustbloc/edge-core/pkg/log/mocklogger"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/breaker"
 	"github.com/trustbloc/kms/pkg/controller/rest"
 )
 
@@ -51,6 +56,78 @@ func TestMiddleware(t *testing.T) {
 			require.Len(t, h.requestsCaptured, 0) // we're not sending zcaps
 		})
 
+		t.Run("forbidden if capability does not allow action", func(t *testing.T) {
+			tests := []struct {
+				name           string
+				invokedAction  string
+				allowedActions []string
+			}{
+				{name: "invoked action mismatch", invokedAction: "sign", allowedActions: []string{"deleteKey", "sign"}},
+				{name: "action not allowed", invokedAction: "deleteKey", allowedActions: []string{"sign"}},
+			}
+
+			for _, tt := range tests {
+				tc := tt
+				t.Run(tc.name, func(t *testing.T) {
+					h := &handler{}
+
+					mwFactory := Middleware{Config: newConfig(), Action: "deleteKey"}
+
+					mw := mwFactory.Middleware()(h)
+					server := httptest.NewServer(mw)
+					defer server.Close()
+
+					compressed, err := zcapld.CompressZCAP(&zcapld.Capability{
+						ID:            "urn:zcap:test",
+						AllowedAction: tc.allowedActions,
+					})
+					require.NoError(t, err)
+
+					req, err := http.NewRequest(http.MethodDelete, server.URL+rest.KeyPath, nil) // nolint:noctx // ignore
+					require.NoError(t, err)
+
+					req.Header.Set(zcapld.CapabilityInvocationHTTPHeader,
+						fmt.Sprintf(`zcap capability="%s",action="%s"`, compressed, tc.invokedAction))
+
+					response, err := http.DefaultClient.Do(req) // nolint:bodyclose // ignore
+					require.NoError(t, err)
+
+					require.Equal(t, http.StatusForbidden, response.StatusCode)
+					require.Len(t, h.requestsCaptured, 0)
+				})
+			}
+		})
+
+		t.Run("request entity too large if capability exceeds the max size", func(t *testing.T) {
+			h := &handler{}
+
+			config := newConfig()
+			config.MaxCapabilitySize = 64
+
+			mwFactory := Middleware{Config: config, Action: "sign"}
+
+			server := httptest.NewServer(mwFactory.Middleware()(h))
+			defer server.Close()
+
+			compressed, err := zcapld.CompressZCAP(&zcapld.Capability{
+				ID:            "urn:zcap:test",
+				AllowedAction: []string{"sign", strings.Repeat("a", 1000)},
+			})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, server.URL+rest.KeyPath, nil) // nolint:noctx // ignore
+			require.NoError(t, err)
+
+			req.Header.Set(zcapld.CapabilityInvocationHTTPHeader,
+				fmt.Sprintf(`zcap capability="%s",action="sign"`, compressed))
+
+			response, err := http.DefaultClient.Do(req) // nolint:bodyclose // ignore
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+			require.Len(t, h.requestsCaptured, 0)
+		})
+
 		t.Run("badrequest if endpoint is not valid", func(t *testing.T) {
 			h := &handler{}
 
@@ -128,6 +205,49 @@ func TestZCAPMetrics(t *testing.T) {
 	})
 }
 
+func TestDependencyErrorWriter(t *testing.T) {
+	t.Run("Replaces 401 if DID resolver is unavailable", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		resolver := NewMockVDRResolver(ctrl)
+		resolver.EXPECT().Resolve("did:orb:test").Return(nil, fmt.Errorf("did method read failed: %w",
+			&breaker.OpenError{Dependency: breaker.DependencyDIDResolver, RetryAfter: 5 * time.Second}))
+
+		r := &dependencyResolver{wrapped: resolver}
+
+		_, err := r.Resolve("did:orb:test")
+		require.ErrorIs(t, err, breaker.ErrOpen)
+
+		rr := httptest.NewRecorder()
+
+		http.Error(&dependencyErrorWriter{ResponseWriter: rr, resolver: r}, "unauthorized", http.StatusUnauthorized)
+
+		require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		require.Equal(t, "5", rr.Header().Get("Retry-After"))
+		require.Contains(t, rr.Body.String(), breaker.Code)
+		require.NotContains(t, rr.Body.String(), "unauthorized")
+	})
+
+	t.Run("Keeps 401 if DID resolver is available", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		resolver := NewMockVDRResolver(ctrl)
+		resolver.EXPECT().Resolve("did:orb:test").Return(nil, errors.New("not found"))
+
+		r := &dependencyResolver{wrapped: resolver}
+
+		_, err := r.Resolve("did:orb:test")
+		require.Error(t, err)
+
+		rr := httptest.NewRecorder()
+
+		http.Error(&dependencyErrorWriter{ResponseWriter: rr, resolver: r}, "unauthorized", http.StatusUnauthorized)
+
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+		require.Equal(t, "unauthorized\n", rr.Body.String())
+	})
+}
+
 type mockNamer struct {
 	name string
 }
@@ -164,6 +284,7 @@ type mockAuthService struct {
 	crpto            crypto.Crypto
 	resolveVal       *zcapld.Capability
 	resolveErr       error
+	checkInvokerErr  error
 }
 
 func (m *mockAuthService) CreateDIDKey(context.Context) (string, error) {
@@ -189,3 +310,7 @@ func (m *mockAuthService) Crypto() crypto.Crypto {
 func (m *mockAuthService) Resolve(string) (*zcapld.Capability, error) {
 	return m.resolveVal, m.resolveErr
 }
+
+func (m *mockAuthService) CheckInvoker(string, string) error {
+	return m.checkInvokerErr
+}
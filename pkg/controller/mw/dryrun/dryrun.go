@@ -0,0 +1,203 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dryrun
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+// QueryParam is a query parameter that enables dry-run mode for a key operation.
+const QueryParam = "dryRun"
+
+// Names of checks reported in dry-run mode.
+const (
+	CheckAuthorization = "authorization"
+	CheckZCAP          = "zcap"
+	CheckGNAP          = "gnap"
+	CheckToken         = "token"
+	CheckNoZCAP        = "no_zcap"
+	CheckValidation    = "validation"
+)
+
+// Dry runs are audited separately from key operations.
+var auditLogger = log.New("dryrun-audit")
+
+type contextKey struct{}
+
+// Check is a result of a single check in the request pipeline.
+type Check struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Capability describes the capability that satisfied the action.
+type Capability struct {
+	ID             string   `json:"id"`
+	Parent         string   `json:"parent,omitempty"`
+	Invoker        string   `json:"invoker,omitempty"`
+	AllowedActions []string `json:"allowed_actions,omitempty"`
+}
+
+// Report is a result of a dry run of a key operation.
+type Report struct {
+	DryRun     bool        `json:"dry_run"`
+	Action     string      `json:"action"`
+	Allowed    bool        `json:"allowed"`
+	Capability *Capability `json:"capability,omitempty"`
+	Checks     []*Check    `json:"checks"`
+
+	mutex   sync.Mutex
+	reached bool
+}
+
+// Pass records a passed check.
+func (r *Report) Pass(name, detail string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Checks = append(r.Checks, &Check{Name: name, Passed: true, Detail: detail})
+}
+
+// Fail records a failed check.
+func (r *Report) Fail(name string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Checks = append(r.Checks, &Check{Name: name, Passed: false, Detail: err.Error()})
+}
+
+// SetCapability records the capability that satisfied the action.
+func (r *Report) SetCapability(c *Capability) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Capability = c
+}
+
+func (r *Report) failed() []string {
+	var names []string
+
+	for _, c := range r.Checks {
+		if !c.Passed {
+			names = append(names, c.Name)
+		}
+	}
+
+	return names
+}
+
+// FromContext returns the dry-run report of the request, or nil if the request is not a dry run.
+func FromContext(ctx context.Context) *Report {
+	r, _ := ctx.Value(contextKey{}).(*Report) //nolint:errcheck
+
+	return r
+}
+
+// IsDryRun checks if dry-run mode is requested.
+func IsDryRun(req *http.Request) bool {
+	v, err := strconv.ParseBool(req.URL.Query().Get(QueryParam))
+
+	return err == nil && v
+}
+
+// Middleware returns middleware that serves dry-run requests for the action. The rest of the pipeline (auth
+// middlewares and the handler wrapped with Terminate) runs with a report in the request context, and the report
+// is returned instead of the operation response. Other requests are passed through.
+func Middleware(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !IsDryRun(req) {
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			report := &Report{DryRun: true, Action: action, Checks: []*Check{}}
+			rec := &bufferedWriter{header: http.Header{}, statusCode: http.StatusOK}
+
+			next.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), contextKey{}, report)))
+
+			report.mutex.Lock()
+
+			if !report.reached && len(report.failed()) == 0 {
+				// rejected by a middleware that doesn't report its checks
+				report.Checks = append(report.Checks, &Check{
+					Name:   CheckAuthorization,
+					Detail: fmt.Sprintf("%d %s", rec.statusCode, strings.TrimSpace(rec.body.String())),
+				})
+			}
+
+			report.Allowed = report.reached && len(report.failed()) == 0
+
+			auditLogger.Infof("dry run: action=%s path=%s allowed=%t failed=%v",
+				action, req.URL.Path, report.Allowed, report.failed())
+
+			report.mutex.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				auditLogger.Errorf("write dry-run report: %v", err)
+			}
+		})
+	}
+}
+
+// Terminate returns middleware that stops dry-run requests before the handler: validate is called instead, so
+// no key material is touched and no state is changed. It should wrap the handler inside auth middlewares.
+func Terminate(validate func(req *http.Request) error) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			report := FromContext(req.Context())
+			if report == nil {
+				next.ServeHTTP(w, req)
+
+				return
+			}
+
+			report.mutex.Lock()
+			report.reached = true
+			report.mutex.Unlock()
+
+			if err := validate(req); err != nil {
+				report.Fail(CheckValidation, err)
+
+				return
+			}
+
+			report.Pass(CheckValidation, "request is valid")
+		})
+	}
+}
+
+type bufferedWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
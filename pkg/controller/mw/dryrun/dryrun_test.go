@@ -0,0 +1,128 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package dryrun_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw/dryrun"
+)
+
+func TestMiddleware(t *testing.T) {
+	var executed bool
+
+	operation := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		executed = true
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+
+			if report := dryrun.FromContext(req.Context()); report != nil {
+				report.Pass(dryrun.CheckZCAP, "capability invocation verified")
+				report.SetCapability(&dryrun.Capability{ID: "urn:zcap:delegated", Parent: "urn:zcap:root"})
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+
+	newHandler := func(validateErr error) http.Handler {
+		validate := func(*http.Request) error { return validateErr }
+
+		return dryrun.Middleware("sign")(auth(dryrun.Terminate(validate)(operation)))
+	}
+
+	t.Run("Not a dry run", func(t *testing.T) {
+		executed = false
+
+		req := httptest.NewRequest(http.MethodPost, "/sign?dryRun=false", nil)
+		req.Header.Set("Authorization", "token")
+
+		w := httptest.NewRecorder()
+		newHandler(nil).ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.True(t, executed)
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		executed = false
+
+		req := httptest.NewRequest(http.MethodPost, "/sign?dryRun=true", nil)
+		req.Header.Set("Authorization", "token")
+
+		w := httptest.NewRecorder()
+		newHandler(nil).ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.False(t, executed)
+
+		report := decodeReport(t, w)
+		require.True(t, report.DryRun)
+		require.True(t, report.Allowed)
+		require.Equal(t, "sign", report.Action)
+		require.Equal(t, "urn:zcap:delegated", report.Capability.ID)
+		require.Len(t, report.Checks, 2)
+		require.Equal(t, dryrun.CheckZCAP, report.Checks[0].Name)
+		require.Equal(t, dryrun.CheckValidation, report.Checks[1].Name)
+	})
+
+	t.Run("Validation failed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/sign?dryRun=true", nil)
+		req.Header.Set("Authorization", "token")
+
+		w := httptest.NewRecorder()
+		newHandler(errors.New("key id must be non-empty")).ServeHTTP(w, req)
+
+		report := decodeReport(t, w)
+		require.False(t, report.Allowed)
+		require.True(t, report.Checks[0].Passed)
+		require.False(t, report.Checks[1].Passed)
+		require.Equal(t, "key id must be non-empty", report.Checks[1].Detail)
+	})
+
+	t.Run("Rejected by auth middleware", func(t *testing.T) {
+		executed = false
+
+		w := httptest.NewRecorder()
+		newHandler(nil).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sign?dryRun=true", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.False(t, executed)
+
+		report := decodeReport(t, w)
+		require.False(t, report.Allowed)
+		require.Nil(t, report.Capability)
+		require.Len(t, report.Checks, 1)
+		require.Equal(t, dryrun.CheckAuthorization, report.Checks[0].Name)
+		require.Equal(t, "401 unauthorized", report.Checks[0].Detail)
+	})
+}
+
+func decodeReport(t *testing.T, w *httptest.ResponseRecorder) *dryrun.Report {
+	t.Helper()
+
+	var report dryrun.Report
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+
+	return &report
+}
@@ -0,0 +1,246 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const (
+	keyStoreLimitSubsystem      = "key_store_limit"
+	keyStoreLimitRequestsMetric = "requests_count"
+
+	// RateLimitedCode is an error code returned in the body of a request rejected by the rate limit of its key store.
+	RateLimitedCode = "KEY_STORE_RATE_LIMITED"
+	// ConcurrencyLimitedCode is an error code returned in the body of a request rejected by the concurrency limit of
+	// its key store.
+	ConcurrencyLimitedCode = "KEY_STORE_CONCURRENCY_LIMITED"
+
+	defaultLimitsRefreshInterval = 30 * time.Second
+)
+
+//nolint:gochecknoglobals
+var (
+	keyStoreLimitMetricsOnce      sync.Once
+	keyStoreLimitRequestsInstance *prometheus.CounterVec
+)
+
+type keyStoreLimits interface {
+	KeyStoreLimits(keyStoreID string) (rateLimit float64, concurrencyLimit int, err error)
+}
+
+// KeyStoreLimitConfig configures KeyStoreLimiter.
+type KeyStoreLimitConfig struct {
+	// Limits returns the rate limit (requests per second) and the concurrency limit of a key store, zero if unlimited.
+	Limits keyStoreLimits
+	// KeyStoreVarName is the route variable with the ID of the key store of the request.
+	KeyStoreVarName string
+	// RefreshInterval is how long limits of a key store are used before they are read again, so that changed
+	// overrides take effect. Defaults to 30s.
+	RefreshInterval time.Duration
+	// Clock defaults to system time.
+	Clock clock.Clock
+}
+
+// KeyStoreLimiter rejects requests of a key store above its rate limit or its concurrency limit with 429, so that a
+// single key store can't take over the server. Limits are kept per instance of the server.
+type KeyStoreLimiter struct {
+	config    KeyStoreLimitConfig
+	requests  *prometheus.CounterVec
+	mu        sync.Mutex
+	keyStores map[string]*keyStoreLimitState
+	sweptAt   time.Time
+}
+
+type keyStoreLimitState struct {
+	rateLimit        float64
+	concurrencyLimit int
+	readAt           time.Time // when the limits were read
+	tokens           float64   // requests that can be served without exceeding the rate limit
+	usedAt           time.Time // when tokens were last refilled
+	seenAt           time.Time // when the last request arrived, see sweep
+	inFlight         int
+}
+
+// NewKeyStoreLimiter returns a new KeyStoreLimiter instance.
+func NewKeyStoreLimiter(config KeyStoreLimitConfig) *KeyStoreLimiter {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultLimitsRefreshInterval
+	}
+
+	if config.Clock == nil {
+		config.Clock = clock.Real()
+	}
+
+	keyStoreLimitMetricsOnce.Do(func() {
+		keyStoreLimitRequestsInstance = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: keyStoreLimitSubsystem,
+			Name:      keyStoreLimitRequestsMetric,
+			Help:      "The total number of requests rejected by limits of their key stores",
+		}, []string{"limit"})
+
+		prometheus.MustRegister(keyStoreLimitRequestsInstance)
+	})
+
+	return &KeyStoreLimiter{
+		config:    config,
+		requests:  keyStoreLimitRequestsInstance,
+		keyStores: make(map[string]*keyStoreLimitState),
+	}
+}
+
+// Middleware returns a middleware that rejects requests above the limits of their key store with 429. Requests
+// without a key store, or of key stores whose limits can't be read, are passed on.
+func (l *KeyStoreLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyStoreID := mux.Vars(r)[l.config.KeyStoreVarName]
+		if keyStoreID == "" {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		release, code, retryAfter := l.acquire(keyStoreID)
+		if code == "" {
+			defer release()
+
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		limit := "rate"
+		if code == ConcurrencyLimitedCode {
+			limit = "concurrency"
+		}
+
+		l.requests.WithLabelValues(limit).Inc()
+
+		logger.Ctx(r.Context()).Warnf("Rejected request %q above the %s limit of key store %s", r.URL.Path, limit,
+			keyStoreID)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+		w.WriteHeader(http.StatusTooManyRequests)
+
+		if err := json.NewEncoder(w).Encode(loadShedResponse{
+			Message: "too many requests for the key store, try again later",
+			Code:    code,
+		}); err != nil {
+			logger.Ctx(r.Context()).Errorf("send key store limit response: %v", err)
+		}
+	})
+}
+
+// acquire takes a request of the key store within its limits. It returns the function that ends the request, or the
+// error code and how long to wait if a limit is exceeded.
+func (l *KeyStoreLimiter) acquire(keyStoreID string) (func(), string, time.Duration) {
+	s := l.refresh(keyStoreID)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.config.Clock.Now()
+
+	if s.rateLimit > 0 {
+		// the bucket holds at most a second of requests, so a key store can't save up for a burst
+		s.tokens = math.Min(math.Max(s.rateLimit, 1), s.tokens+now.Sub(s.usedAt).Seconds()*s.rateLimit)
+	}
+
+	s.usedAt = now
+
+	if s.concurrencyLimit > 0 && s.inFlight >= s.concurrencyLimit {
+		return nil, ConcurrencyLimitedCode, time.Second
+	}
+
+	if s.rateLimit > 0 {
+		if s.tokens < 1 {
+			return nil, RateLimitedCode, time.Duration((1 - s.tokens) / s.rateLimit * float64(time.Second))
+		}
+
+		s.tokens--
+	}
+
+	s.inFlight++
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		s.inFlight--
+	}, "", 0
+}
+
+// refresh returns the state of the key store, with its limits read again unless they were read within the refresh
+// interval. Limits are read without holding the lock; concurrent requests may read them more than once.
+func (l *KeyStoreLimiter) refresh(keyStoreID string) *keyStoreLimitState {
+	now := l.config.Clock.Now()
+
+	l.mu.Lock()
+
+	l.sweep(now)
+
+	s, ok := l.keyStores[keyStoreID]
+	if !ok {
+		s = &keyStoreLimitState{usedAt: now}
+		l.keyStores[keyStoreID] = s
+	}
+
+	s.seenAt = now
+	fresh := ok && now.Sub(s.readAt) < l.config.RefreshInterval
+
+	l.mu.Unlock()
+
+	if fresh {
+		return s
+	}
+
+	rateLimit, concurrencyLimit, err := l.config.Limits.KeyStoreLimits(keyStoreID)
+	if err != nil {
+		// e.g. the key store doesn't exist, its handler reports the error; limits are read again after the interval
+		rateLimit, concurrencyLimit = 0, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if s.readAt.IsZero() {
+		// a new key store starts with a full bucket
+		s.tokens = math.Max(rateLimit, 1)
+	}
+
+	s.rateLimit, s.concurrencyLimit, s.readAt = rateLimit, concurrencyLimit, now
+
+	return s
+}
+
+// sweep forgets key stores without requests within the refresh interval, so that the state doesn't grow with every
+// key store ever served. It must be called with the lock held.
+func (l *KeyStoreLimiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < l.config.RefreshInterval {
+		return
+	}
+
+	for id, s := range l.keyStores {
+		if s.inFlight == 0 && now.Sub(s.seenAt) >= l.config.RefreshInterval {
+			delete(l.keyStores, id)
+		}
+	}
+
+	l.sweptAt = now
+}
@@ -0,0 +1,191 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+func TestKeyStoreLimiter(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Requests above the rate limit are rejected", func(t *testing.T) {
+		clk := testutil.NewFakeClock(now)
+		limits := &mockKeyStoreLimits{limits: map[string][2]float64{"a": {2, 0}}}
+
+		l := mw.NewKeyStoreLimiter(mw.KeyStoreLimitConfig{Limits: limits, KeyStoreVarName: "id", Clock: clk})
+
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+
+		w := serveKeyStore(t, l, "a", nil)
+		require.Equal(t, http.StatusTooManyRequests, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+		require.Equal(t, mw.RateLimitedCode, errorCode(t, w))
+
+		clk.Advance(500 * time.Millisecond)
+
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		require.Equal(t, http.StatusTooManyRequests, serveKeyStore(t, l, "a", nil).Code)
+
+		// unused time doesn't add up to more than a second of requests
+		clk.Advance(time.Minute)
+
+		for i := 0; i < 2; i++ {
+			require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		}
+
+		require.Equal(t, http.StatusTooManyRequests, serveKeyStore(t, l, "a", nil).Code)
+	})
+
+	t.Run("Requests above the concurrency limit are rejected", func(t *testing.T) {
+		limits := &mockKeyStoreLimits{limits: map[string][2]float64{"a": {0, 1}, "b": {0, 1}}}
+
+		l := mw.NewKeyStoreLimiter(mw.KeyStoreLimitConfig{Limits: limits, KeyStoreVarName: "id"})
+
+		var nested, other *httptest.ResponseRecorder
+
+		w := serveKeyStore(t, l, "a", func() {
+			nested = serveKeyStore(t, l, "a", nil)
+			other = serveKeyStore(t, l, "b", nil)
+		})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		require.Equal(t, http.StatusTooManyRequests, nested.Code)
+		require.Equal(t, mw.ConcurrencyLimitedCode, errorCode(t, nested))
+		require.Equal(t, http.StatusOK, other.Code, "limits are per key store")
+
+		// the request ended
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+	})
+
+	t.Run("Requests without limits are served", func(t *testing.T) {
+		limits := &mockKeyStoreLimits{limits: map[string][2]float64{"a": {0, 0}}, err: map[string]error{
+			"missing": errors.New("key store not found"),
+		}}
+
+		l := mw.NewKeyStoreLimiter(mw.KeyStoreLimitConfig{Limits: limits, KeyStoreVarName: "id"})
+
+		for i := 0; i < 10; i++ {
+			require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+			require.Equal(t, http.StatusOK, serveKeyStore(t, l, "missing", nil).Code)
+			require.Equal(t, http.StatusOK, serveKeyStore(t, l, "", nil).Code)
+		}
+
+		require.Equal(t, 2, limits.readCount(), "limits are read once per key store within the refresh interval")
+	})
+
+	t.Run("Changed limits take effect after the refresh interval", func(t *testing.T) {
+		clk := testutil.NewFakeClock(now)
+		limits := &mockKeyStoreLimits{limits: map[string][2]float64{"a": {1, 0}}}
+
+		l := mw.NewKeyStoreLimiter(mw.KeyStoreLimitConfig{
+			Limits:          limits,
+			KeyStoreVarName: "id",
+			RefreshInterval: time.Minute,
+			Clock:           clk,
+		})
+
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		require.Equal(t, http.StatusTooManyRequests, serveKeyStore(t, l, "a", nil).Code)
+
+		limits.set("a", 100, 0)
+		clk.Advance(time.Second)
+
+		require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		require.Equal(t, http.StatusTooManyRequests, serveKeyStore(t, l, "a", nil).Code, "old limits are used")
+
+		clk.Advance(time.Minute)
+
+		for i := 0; i < 100; i++ {
+			require.Equal(t, http.StatusOK, serveKeyStore(t, l, "a", nil).Code)
+		}
+
+		require.Equal(t, http.StatusTooManyRequests, serveKeyStore(t, l, "a", nil).Code)
+	})
+}
+
+type mockKeyStoreLimits struct {
+	mu     sync.Mutex
+	limits map[string][2]float64
+	err    map[string]error
+	reads  int
+}
+
+func (m *mockKeyStoreLimits) KeyStoreLimits(keyStoreID string) (float64, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reads++
+
+	if err := m.err[keyStoreID]; err != nil {
+		return 0, 0, err
+	}
+
+	return m.limits[keyStoreID][0], int(m.limits[keyStoreID][1]), nil
+}
+
+func (m *mockKeyStoreLimits) set(keyStoreID string, rateLimit float64, concurrencyLimit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.limits[keyStoreID] = [2]float64{rateLimit, float64(concurrencyLimit)}
+}
+
+func (m *mockKeyStoreLimits) readCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reads
+}
+
+// serveKeyStore serves a request of the key store, calling during with the request in flight.
+func serveKeyStore(t *testing.T, l *mw.KeyStoreLimiter, keyStoreID string,
+	during func()) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+
+	r := httptest.NewRequest(http.MethodPost, "/test", nil)
+	if keyStoreID != "" {
+		r = mux.SetURLVars(r, map[string]string{"id": keyStoreID})
+	}
+
+	l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if during != nil {
+			during()
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(w, r)
+
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var resp struct {
+		Code string `json:"code"`
+	}
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+
+	return resp.Code
+}
@@ -0,0 +1,247 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	loadShedSubsystem        = "load_shed"
+	loadShedRequestsMetric   = "requests_count"
+	loadShedHeapMetric       = "heap_bytes"
+	loadShedGoroutinesMetric = "goroutines"
+	loadShedPressureMetric   = "pressure"
+
+	// LoadShedCode is an error code returned in the body of a rejected request.
+	LoadShedCode = "LOAD_SHED"
+
+	// signShedPressure is a pressure level at which sign requests are rejected in addition to creates.
+	signShedPressure = 1.25
+
+	defaultSampleInterval = time.Second
+)
+
+// Priority defines the order in which requests are rejected under pressure.
+type Priority int
+
+const (
+	// PriorityEssential requests (e.g. health check) are never rejected.
+	PriorityEssential Priority = iota
+	// PrioritySign requests are rejected when pressure is critical.
+	PrioritySign
+	// PriorityCreate requests are rejected first, as soon as any threshold is exceeded.
+	PriorityCreate
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCreate:
+		return "create"
+	case PrioritySign:
+		return "sign"
+	default:
+		return "essential"
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	loadShedMetricsOnce     sync.Once
+	loadShedMetricsInstance *loadShedMetrics
+)
+
+type loadShedMetrics struct {
+	requestCounter *prometheus.CounterVec
+	heapBytes      prometheus.Gauge
+	goroutines     prometheus.Gauge
+	pressure       prometheus.Gauge
+}
+
+// LoadShedConfig configures LoadShedder.
+type LoadShedConfig struct {
+	// MaxHeapBytes is a heap usage threshold. Zero disables heap check.
+	MaxHeapBytes uint64
+	// MaxGoroutines is a goroutine count threshold. Zero disables goroutine check.
+	MaxGoroutines int
+	// SampleInterval defines how often runtime stats are sampled. Defaults to 1s.
+	SampleInterval time.Duration
+	// ReadStats returns current heap usage and goroutine count. Defaults to runtime stats.
+	ReadStats func() (heapBytes uint64, goroutines int)
+}
+
+// LoadShedder rejects non-essential requests when the server is under memory or goroutine pressure.
+type LoadShedder struct {
+	config   LoadShedConfig
+	pressure uint64 // math.Float64bits of the current pressure
+	metrics  *loadShedMetrics
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewLoadShedder returns a new LoadShedder instance.
+func NewLoadShedder(config LoadShedConfig) *LoadShedder {
+	if config.SampleInterval <= 0 {
+		config.SampleInterval = defaultSampleInterval
+	}
+
+	if config.ReadStats == nil {
+		config.ReadStats = readRuntimeStats
+	}
+
+	loadShedMetricsOnce.Do(func() {
+		loadShedMetricsInstance = newLoadShedMetrics()
+	})
+
+	return &LoadShedder{
+		config:  config,
+		metrics: loadShedMetricsInstance,
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts sampling runtime stats in the background until Stop is called.
+func (s *LoadShedder) Start() {
+	s.Sample()
+
+	go func() {
+		ticker := time.NewTicker(s.config.SampleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Sample()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling runtime stats.
+func (s *LoadShedder) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Sample reads runtime stats and updates the current pressure. Pressure is a ratio of the observed value to its
+// threshold; the highest ratio is used. Values of 1 and above mean that the server is overloaded.
+func (s *LoadShedder) Sample() {
+	heapBytes, goroutines := s.config.ReadStats()
+
+	var pressure float64
+
+	if s.config.MaxHeapBytes > 0 {
+		pressure = math.Max(pressure, float64(heapBytes)/float64(s.config.MaxHeapBytes))
+	}
+
+	if s.config.MaxGoroutines > 0 {
+		pressure = math.Max(pressure, float64(goroutines)/float64(s.config.MaxGoroutines))
+	}
+
+	atomic.StoreUint64(&s.pressure, math.Float64bits(pressure))
+
+	s.metrics.heapBytes.Set(float64(heapBytes))
+	s.metrics.goroutines.Set(float64(goroutines))
+	s.metrics.pressure.Set(pressure)
+}
+
+// Pressure returns the last sampled pressure.
+func (s *LoadShedder) Pressure() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.pressure))
+}
+
+func (s *LoadShedder) shouldShed(p Priority) bool {
+	switch p {
+	case PriorityCreate:
+		return s.Pressure() >= 1
+	case PrioritySign:
+		return s.Pressure() >= signShedPressure
+	default:
+		return false
+	}
+}
+
+// Middleware returns a middleware that rejects requests of the given priority with 503 while under pressure.
+func (s *LoadShedder) Middleware(p Priority) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.shouldShed(p) {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			s.metrics.requestCounter.WithLabelValues(p.String()).Inc()
+
+			logger.Ctx(r.Context()).Warnf("Load shedding %s request %q: pressure %.2f", p, r.URL.Path, s.Pressure())
+
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.config.SampleInterval.Seconds()))))
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			if err := json.NewEncoder(w).Encode(loadShedResponse{
+				Message: "server is overloaded, try again later",
+				Code:    LoadShedCode,
+			}); err != nil {
+				logger.Ctx(r.Context()).Errorf("send load shed response: %v", err)
+			}
+		})
+	}
+}
+
+type loadShedResponse struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+func readRuntimeStats() (uint64, int) {
+	var m runtime.MemStats
+
+	runtime.ReadMemStats(&m)
+
+	return m.HeapAlloc, runtime.NumGoroutine()
+}
+
+func newLoadShedMetrics() *loadShedMetrics {
+	m := &loadShedMetrics{
+		requestCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: loadShedSubsystem,
+			Name:      loadShedRequestsMetric,
+			Help:      "The total number of requests rejected due to load shedding",
+		}, []string{"priority"}),
+		heapBytes:  newLoadShedGauge(loadShedHeapMetric, "The last sampled heap usage in bytes"),
+		goroutines: newLoadShedGauge(loadShedGoroutinesMetric, "The last sampled number of goroutines"),
+		pressure: newLoadShedGauge(loadShedPressureMetric,
+			"The last sampled pressure; values of 1 and above mean that requests are shed"),
+	}
+
+	prometheus.MustRegister(m.requestCounter, m.heapBytes, m.goroutines, m.pressure)
+
+	return m
+}
+
+func newLoadShedGauge(name, help string) prometheus.Gauge {
+	return prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: loadShedSubsystem,
+		Name:      name,
+		Help:      help,
+	})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw"
+)
+
+func TestLoadShedder(t *testing.T) {
+	t.Run("Requests are served below thresholds", func(t *testing.T) {
+		s := mw.NewLoadShedder(mw.LoadShedConfig{
+			MaxHeapBytes:  100,
+			MaxGoroutines: 10,
+			ReadStats:     stats(50, 5),
+		})
+		s.Sample()
+
+		require.Equal(t, 0.5, s.Pressure())
+
+		for _, p := range []mw.Priority{mw.PriorityCreate, mw.PrioritySign, mw.PriorityEssential} {
+			require.Equal(t, http.StatusOK, serve(t, s, p).Code)
+		}
+	})
+
+	t.Run("Creates are shed first", func(t *testing.T) {
+		s := mw.NewLoadShedder(mw.LoadShedConfig{
+			MaxHeapBytes:  100,
+			MaxGoroutines: 10,
+			ReadStats:     stats(110, 5),
+		})
+		s.Sample()
+
+		w := serve(t, s, mw.PriorityCreate)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+		require.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		var resp struct {
+			Code string `json:"code"`
+		}
+
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(t, mw.LoadShedCode, resp.Code)
+
+		require.Equal(t, http.StatusOK, serve(t, s, mw.PrioritySign).Code)
+		require.Equal(t, http.StatusOK, serve(t, s, mw.PriorityEssential).Code)
+	})
+
+	t.Run("Signs are shed under critical pressure", func(t *testing.T) {
+		s := mw.NewLoadShedder(mw.LoadShedConfig{
+			MaxHeapBytes:  100,
+			MaxGoroutines: 10,
+			ReadStats:     stats(50, 20),
+		})
+		s.Sample()
+
+		require.Equal(t, http.StatusServiceUnavailable, serve(t, s, mw.PriorityCreate).Code)
+		require.Equal(t, http.StatusServiceUnavailable, serve(t, s, mw.PrioritySign).Code)
+		require.Equal(t, http.StatusOK, serve(t, s, mw.PriorityEssential).Code)
+	})
+
+	t.Run("Zero thresholds disable shedding", func(t *testing.T) {
+		s := mw.NewLoadShedder(mw.LoadShedConfig{ReadStats: stats(1<<40, 1<<20)})
+		s.Sample()
+
+		require.Zero(t, s.Pressure())
+		require.Equal(t, http.StatusOK, serve(t, s, mw.PriorityCreate).Code)
+	})
+
+	t.Run("Recovers when pressure drops", func(t *testing.T) {
+		var goroutines int64 = 20
+
+		s := mw.NewLoadShedder(mw.LoadShedConfig{
+			MaxGoroutines:  10,
+			SampleInterval: time.Millisecond,
+			ReadStats: func() (uint64, int) {
+				return 0, int(atomic.LoadInt64(&goroutines))
+			},
+		})
+
+		s.Start()
+		defer s.Stop()
+
+		require.Equal(t, http.StatusServiceUnavailable, serve(t, s, mw.PriorityCreate).Code)
+
+		atomic.StoreInt64(&goroutines, 1)
+
+		require.Eventually(t, func() bool {
+			return serve(t, s, mw.PriorityCreate).Code == http.StatusOK
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Uses runtime stats by default", func(t *testing.T) {
+		s := mw.NewLoadShedder(mw.LoadShedConfig{MaxGoroutines: 1})
+		s.Sample()
+
+		require.Greater(t, s.Pressure(), 1.0)
+	})
+}
+
+func stats(heapBytes uint64, goroutines int) func() (uint64, int) {
+	return func() (uint64, int) {
+		return heapBytes, goroutines
+	}
+}
+
+func serve(t *testing.T, s *mw.LoadShedder, p mw.Priority) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.Middleware(p)(&mockHandler{}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/test", nil))
+
+	return w
+}
@@ -0,0 +1,38 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
+
+type keyStoreControllers interface {
+	KeyStoreController(keyStoreID string) (string, error)
+}
+
+// LogContext returns a middleware that adds the key store of the request, taken from the route variable, to the
+// request context, so that log lines written while serving the request carry the key store ID and the hash of its
+// controller (see reqlog). The controller is read from the key stores only if a line is logged. Requests without a
+// key store are passed on unchanged.
+func LogContext(keyStores keyStoreControllers, keyStoreVarName string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyStoreID := mux.Vars(r)[keyStoreVarName]
+			if keyStoreID == "" {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(reqlog.WithKeyStore(r.Context(), keyStoreID, keyStores.KeyStoreController)))
+		})
+	}
+}
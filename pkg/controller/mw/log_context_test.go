@@ -0,0 +1,83 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mw_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/controller/mw"
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
+
+func TestLogContext(t *testing.T) {
+	serve := func(t *testing.T, keyStores *keyStoreControllers, path string) reqlog.Fields {
+		t.Helper()
+
+		var fields reqlog.Fields
+
+		router := mux.NewRouter()
+		router.Use(mw.LogContext(keyStores, "keystore"))
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields = reqlog.FromContext(r.Context())
+		})
+
+		router.Handle("/v1/keystores/{keystore}/keys", handler)
+		router.Handle("/v1/keystores", handler)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		return fields
+	}
+
+	t.Run("Key store request", func(t *testing.T) {
+		keyStores := &keyStoreControllers{controllers: map[string]string{"ks": "did:example:controller"}}
+
+		require.Equal(t, reqlog.Fields{
+			KeyStoreID: "ks",
+			Controller: reqlog.HashController("did:example:controller"),
+		}, serve(t, keyStores, "/v1/keystores/ks/keys"))
+		require.Equal(t, 1, keyStores.calls)
+	})
+
+	t.Run("Unknown key store", func(t *testing.T) {
+		keyStores := &keyStoreControllers{}
+
+		require.Equal(t, reqlog.Fields{KeyStoreID: "unknown"}, serve(t, keyStores, "/v1/keystores/unknown/keys"))
+	})
+
+	t.Run("Request without key store", func(t *testing.T) {
+		keyStores := &keyStoreControllers{}
+
+		require.True(t, serve(t, keyStores, "/v1/keystores").IsEmpty())
+		require.Zero(t, keyStores.calls)
+	})
+}
+
+type keyStoreControllers struct {
+	controllers map[string]string
+	calls       int
+}
+
+func (k *keyStoreControllers) KeyStoreController(keyStoreID string) (string, error) {
+	k.calls++
+
+	c, ok := k.controllers[keyStoreID]
+	if !ok {
+		return "", errors.New("not found")
+	}
+
+	return c, nil
+}
@@ -13,8 +13,9 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/hyperledger/aries-framework-go/pkg/common/log"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustbloc/kms/pkg/reqlog"
 )
 
 const (
@@ -24,7 +25,7 @@ const (
 	responseStatusCounterMetric = "http_response_status_count"
 )
 
-var logger = log.New("prometheus")
+var logger = reqlog.New("prometheus")
 
 //nolint:gochecknoglobals
 var (
@@ -36,6 +36,11 @@ const (
 	AuthZCAP
 	// AuthGNAP defines GNAP as a supported auth method for the handler.
 	AuthGNAP
+	// AuthToken defines one-time tokens as a supported auth method for the handler.
+	AuthToken
+	// AuthAdmin defines the admin token as the auth method of the handler. Admin handlers are not exposed if the
+	// admin token is not configured.
+	AuthAdmin
 )
 
 // HasFlag checks if the given auth method is set.
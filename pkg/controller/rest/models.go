@@ -39,12 +39,21 @@ type createKeyStoreReq struct { //nolint:unused,deadcode
 	// Secret-Share header
 	SecretShare string `json:"Secret-Share"`
 
+	// The header with a key that identifies the request, so that a retry returns the key store of the first request.
+	//
+	// Idempotency-Key header
+	IdempotencyKey string `json:"Idempotency-Key"`
+
 	// in: body
 	Body struct {
 		// Controller of the key store.
 		// required: true
 		Controller string `json:"controller"`
 
+		// Where keys of the key store are stored: local (the server's storage) or edv (a vault on EDV, requires edv
+		// options). If empty, it's edv if edv options are set and local otherwise.
+		StorageMode string `json:"storage_mode,omitempty"`
+
 		// Options for EDV-backed key store. If empty, key store is created in server's storage.
 		EDV struct {
 			// Vault URL on EDV server.
@@ -53,6 +62,13 @@ type createKeyStoreReq struct { //nolint:unused,deadcode
 			// Base64-encoded EDV ZCAPs.
 			Capability string `json:"capability"`
 		} `json:"edv"`
+
+		// Algorithm used to combine secret shares for Shamir secret lock. Supported options: shamir (default), xor.
+		SecretShareScheme string `json:"secret_share_scheme,omitempty"`
+
+		// Return the key store of an earlier request with the same content from the controller instead of creating
+		// another one.
+		Dedupe bool `json:"dedupe,omitempty"`
 	}
 }
 
@@ -67,6 +83,9 @@ type createKeyStoreResp struct { //nolint:unused,deadcode
 
 		// Base64-encoded root ZCAPs for key store.
 		Capability string `json:"capability"`
+
+		// The version of the key store metadata format of the server.
+		SchemaVersion int `json:"schema_version"`
 	}
 }
 
@@ -85,6 +104,19 @@ type createKeyReq struct { //nolint:unused,deadcode
 		// A type of key to create. Check https://github.com/hyperledger/aries-framework-go/blob/main/pkg/kms/api.go
 		// for supported key types.
 		KeyType string `json:"key_type"`
+
+		// An optional alias of the key, unique within the key store. It can be used instead of the key ID in the
+		// key's URL. 1 to 64 letters, digits, '.', '_' or '-', starting with a letter or digit.
+		Alias string `json:"alias,omitempty"`
+
+		// An optional RFC 3339 expiration time of the key, in the future. An expired key can't sign, encrypt,
+		// compute MACs or wrap keys, but can still verify, decrypt and unwrap.
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+		// Optional operations the key can be used for: sign, verify, deriveProof, encrypt, decrypt, computeMAC,
+		// verifyMAC, wrap or unwrap. Other operations with the key are rejected with 403. If empty, the key can be
+		// used for all operations. Rotated keys keep their purposes.
+		Purposes []string `json:"purposes,omitempty"`
 	}
 }
 
@@ -99,6 +131,66 @@ type createKeyResp struct { //nolint:unused,deadcode
 
 		// A base64-encoded public key. It is empty if key is symmetric.
 		PublicKey string `json:"public_key"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+
+		// The version of the key metadata format.
+		SchemaVersion int `json:"schema_version"`
+	}
+}
+
+// createKeysReq model
+//
+// swagger:parameters createKeysReq
+type createKeysReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// in: body
+	Body struct {
+		// Keys to create, at most 20.
+		// required: true
+		Keys []struct {
+			// A type of key to create. Check https://github.com/hyperledger/aries-framework-go/blob/main/pkg/kms/api.go
+			// for supported key types.
+			KeyType string `json:"key_type"`
+
+			// An optional alias of the key, unique within the key store.
+			Alias string `json:"alias,omitempty"`
+
+			// An optional RFC 3339 expiration time of the key, in the future.
+			ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+			// Optional operations the key can be used for. If empty, the key can be used for all operations.
+			Purposes []string `json:"purposes,omitempty"`
+		} `json:"keys"`
+	}
+}
+
+// createKeysResp model
+//
+// swagger:response createKeysResp
+type createKeysResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Created keys in the order of the request.
+		Keys []struct {
+			// URL to created key.
+			KeyURL string `json:"key_url"`
+
+			// A base64-encoded public key. It is empty if key is symmetric.
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+
+		// Key store sequence number after the operation. It is incremented once for the batch.
+		Sequence uint64 `json:"sequence"`
+
+		// The version of the key metadata format.
+		SchemaVersion int `json:"schema_version"`
 	}
 }
 
@@ -114,9 +206,15 @@ type importKeyReq struct { //nolint:unused,deadcode
 
 	// in: body
 	Body struct {
-		// A base64-encoded key to import.
-		// required: true
-		Key string `json:"key"`
+		// A base64-encoded PKCS #8 or raw private key to import: an Ed25519 seed or private key, a big-endian
+		// ECDSA private scalar or a big-endian BLS12-381 private scalar. One of key, key_multibase or jwk is required.
+		Key string `json:"key,omitempty"`
+
+		// A multibase-encoded raw private key to import, e.g. a base58btc ("z" prefix) BLS12-381 private key.
+		KeyMultibase string `json:"key_multibase,omitempty"`
+
+		// A private key to import in JWK format.
+		JWK map[string]interface{} `json:"jwk,omitempty"`
 
 		// A type of key to be imported.
 		// required: true
@@ -124,6 +222,10 @@ type importKeyReq struct { //nolint:unused,deadcode
 
 		// An optional key ID to associate imported key with.
 		KeyID string `json:"key_id,omitempty"`
+
+		// An optional base64-encoded public key, in the format of exported public keys, that the public key of the
+		// private key must match.
+		ExpectedPublicKey string `json:"expected_public_key,omitempty"`
 	}
 }
 
@@ -135,6 +237,82 @@ type importKeyResp struct { //nolint:unused,deadcode
 	Body struct {
 		// URL of imported key.
 		KeyURL string `json:"key_url"`
+
+		// A base64-encoded public key of imported key.
+		PublicKey string `json:"public_key"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+
+		// The version of the key metadata format.
+		SchemaVersion int `json:"schema_version"`
+	}
+}
+
+// getKeyReq model
+//
+// swagger:parameters getKeyReq
+type getKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// Comma-separated list of fields to include in the response, e.g. key_type,public_key. Defaults to all fields.
+	//
+	// in: query
+	Fields string `json:"fields"`
+}
+
+// getKeyResp model
+//
+// swagger:response getKeyResp
+type getKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A type of the key. Empty if the type of a symmetric key wasn't recorded on creation.
+		KeyType string `json:"key_type,omitempty"`
+
+		// The alias of the key. Omitted if the key has no alias.
+		Alias string `json:"alias,omitempty"`
+
+		// The state of the key: "active" or "disabled".
+		State string `json:"state"`
+
+		// Time when the key was created. Omitted for keys created before key stores started to track their keys.
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+
+		// Expiration time of the key. Omitted if the key doesn't expire.
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+		// Operations the key can be used for. Omitted if the key can be used for all operations.
+		Purposes []string `json:"purposes,omitempty"`
+
+		// Origin of the key: "imported" for imported keys, omitted for keys generated by the key store.
+		Origin string `json:"origin,omitempty"`
+
+		// Time when the key was last used, accurate to the usage tracking interval of the server. Omitted if usage
+		// isn't tracked or the key wasn't used since tracking started.
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+		// Whether the public key can be exported. Private keys can't be exported.
+		Exportable bool `json:"exportable"`
+
+		// A base64-encoded public key. Omitted for symmetric keys.
+		PublicKey string `json:"public_key,omitempty"`
+
+		// DID URLs of verification methods registered for the key. Omitted if none are registered.
+		VerificationMethods []string `json:"verification_methods,omitempty"`
+
+		// The version of the key metadata format. 0 for keys created before versions were recorded.
+		SchemaVersion int `json:"schema_version"`
 	}
 }
 
@@ -148,11 +326,22 @@ type exportKeyReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
 	KeyID string `json:"key_id"`
+
+	// Comma-separated list of fields to include in the response (public_key, key_type). Defaults to all fields.
+	//
+	// in: query
+	Fields string `json:"fields"`
+
+	// A format of the public key: raw (default), jwk or did. A JWK is returned as is, "did" returns the did:key
+	// and its verification method. Fields can only be selected in raw format.
+	//
+	// in: query
+	Format string `json:"format"`
 }
 
 // exportKeyResp model
@@ -161,96 +350,1053 @@ type exportKeyReq struct { //nolint:unused,deadcode
 type exportKeyResp struct { //nolint:unused,deadcode
 	// in: body
 	Body struct {
-		// A base64-encoded public key.
-		PublicKey string `json:"public_key"`
+		// A base64-encoded public key.
+		PublicKey string `json:"public_key"`
+
+		// A type of the key.
+		KeyType string `json:"key_type"`
+
+		// A did:key of the public key, returned in did format instead of other fields.
+		DID string `json:"did,omitempty"`
+
+		// A verification method of the did:key (DID URL with the key fingerprint as a fragment), returned in did
+		// format.
+		VerificationMethod string `json:"verification_method,omitempty"`
+	}
+}
+
+// getKeyFingerprintReq model
+//
+// swagger:parameters getKeyFingerprintReq
+type getKeyFingerprintReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+}
+
+// getKeyFingerprintResp model
+//
+// swagger:response getKeyFingerprintResp
+type getKeyFingerprintResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A multibase (base58btc) encoded multicodec public key, the fingerprint of its did:key.
+		Fingerprint string `json:"fingerprint"`
+
+		// A did:key of the public key.
+		DID string `json:"did"`
+
+		// A base64url-encoded SHA-256 JWK thumbprint (RFC 7638) of the public key.
+		JWKThumbprint string `json:"jwk_thumbprint"`
+	}
+}
+
+// rotateKeyReq model
+//
+// swagger:parameters rotateKeyReq
+type rotateKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+
+		// A type on new key.
+		// required: true
+		KeyType string `json:"key_type"`
+
+		// An optional RFC 3339 expiration time of the new key, in the future.
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+}
+
+// rotateKeyResp model
+//
+// swagger:response rotateKeyResp
+type rotateKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// URL of rotated key. The previous key URL is no longer valid, signatures made with previous versions of
+		// the key can be verified with the rotated key.
+		KeyURL string `json:"key_url"`
+
+		// A base64-encoded public key of the new version of the key. It is empty if key is symmetric.
+		PublicKey string `json:"public_key"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// updateKeyReq model
+//
+// swagger:parameters updateKeyReq
+type updateKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID, alias or registered DID URL.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// A new alias of the key. An empty alias removes the alias of the key. Left unchanged if the request sets
+		// verification methods without alias.
+		Alias string `json:"alias"`
+
+		// DID URLs of verification methods the key is known by, e.g. did:example:123#key-1. They replace DID URLs
+		// registered for the key; an empty list removes them. A DID URL is unique across key stores.
+		VerificationMethods []string `json:"verification_methods,omitempty"`
+	}
+}
+
+// updateKeyResp model
+//
+// swagger:response updateKeyResp
+type updateKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// URL of the key with the key ID.
+		KeyURL string `json:"key_url"`
+
+		// The alias of the key. Omitted if the alias was removed.
+		Alias string `json:"alias,omitempty"`
+
+		// DID URLs registered for the key.
+		VerificationMethods []string `json:"verification_methods,omitempty"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// setKeyStateReq model
+//
+// swagger:parameters setKeyStateReq
+type setKeyStateReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// A new state of the key: "active" or "disabled".
+		//
+		// required: true
+		State string `json:"state"`
+	}
+}
+
+// setKeyStateResp model
+//
+// swagger:response setKeyStateResp
+type setKeyStateResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// URL of the key with the key ID.
+		KeyURL string `json:"key_url"`
+
+		// The state of the key.
+		State string `json:"state"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// listKeysReq model
+//
+// swagger:parameters listKeysReq
+type listKeysReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// Lists only keys that weren't used since the RFC 3339 time. Keys created at or after the time are not listed.
+	// Fails if key usage tracking is disabled on the server.
+	//
+	// in: query
+	UnusedSince string `json:"unused_since"`
+
+	// Comma-separated list of fields to include in each listed key, e.g. key_url,alias. Defaults to all fields.
+	//
+	// in: query
+	Fields string `json:"fields"`
+}
+
+// listKeysResp model
+//
+// swagger:response listKeysResp
+type listKeysResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Keys of the key store in the order of creation.
+		Keys []struct {
+			// URL of the key.
+			KeyURL string `json:"key_url"`
+
+			// A type of the key.
+			KeyType string `json:"key_type,omitempty"`
+
+			// The alias of the key. Omitted if the key has no alias.
+			Alias string `json:"alias,omitempty"`
+
+			// The state of the key: "active" or "disabled".
+			State string `json:"state"`
+
+			// Time when the key was created.
+			CreatedAt *time.Time `json:"created_at,omitempty"`
+
+			// Expiration time of the key. Omitted if the key doesn't expire.
+			ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+			// Operations the key can be used for. Omitted if the key can be used for all operations.
+			Purposes []string `json:"purposes,omitempty"`
+
+			// Origin of the key: "imported" for imported keys, omitted for keys generated by the key store.
+			Origin string `json:"origin,omitempty"`
+
+			// Time when the key was last used, accurate to the usage tracking interval of the server. Omitted if
+			// usage isn't tracked or the key wasn't used since tracking started.
+			LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		} `json:"keys"`
+
+		// Key store sequence number.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// getKeyStoreReq model
+//
+// swagger:parameters getKeyStoreReq
+type getKeyStoreReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// Comma-separated list of fields to include in the response, e.g. controller,key_count. Defaults to all fields.
+	//
+	// in: query
+	Fields string `json:"fields"`
+}
+
+// getKeyStoreResp model
+//
+// swagger:response getKeyStoreResp
+type getKeyStoreResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A controller of the key store.
+		Controller string `json:"controller"`
+
+		// Time when the key store was created.
+		CreatedAt time.Time `json:"created_at"`
+
+		// A type of the key store storage: "local" or "edv".
+		StorageType string `json:"storage_type"`
+
+		// A number of keys in the key store. Keys created before the key store started to track its keys are not
+		// counted.
+		KeyCount int `json:"key_count"`
+
+		// Key store sequence number. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+
+		// The version of the key store metadata format. 0 for key stores not saved since versions were recorded.
+		SchemaVersion int `json:"schema_version"`
+	}
+}
+
+// listKeyStoresReq model
+//
+// swagger:parameters listKeyStoresReq
+type listKeyStoresReq struct { //nolint:unused,deadcode
+	// The header with the admin token: "Admin <token>".
+	//
+	// in: header
+	// required: true
+	Authorization string `json:"Authorization"`
+
+	// The controller of the key stores. Required unless overrides is set.
+	//
+	// in: query
+	Controller string `json:"controller"`
+
+	// Set to true to list key stores of all controllers that have overrides instead.
+	//
+	// in: query
+	Overrides bool `json:"overrides"`
+
+	// The next_page_token of the previous page.
+	//
+	// in: query
+	PageToken string `json:"page_token"`
+
+	// The maximum number of key stores in the response. Defaults to 100, at most 1000.
+	//
+	// in: query
+	PageSize int `json:"page_size"`
+
+	// Comma-separated list of fields to include in each listed key store, e.g. id,storage_type. Defaults to all
+	// fields.
+	//
+	// in: query
+	Fields string `json:"fields"`
+}
+
+// listKeyStoresResp model
+//
+// swagger:response listKeyStoresResp
+type listKeyStoresResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Key stores of the controller, or with overrides, ordered by ID.
+		KeyStores []struct {
+			// The key store's ID.
+			ID string `json:"id"`
+
+			// Time when the key store was created.
+			CreatedAt time.Time `json:"created_at"`
+
+			// A type of the key store storage: "local" or "edv".
+			StorageType string `json:"storage_type"`
+
+			// Server settings overridden for the key store. Omitted if there are none.
+			Overrides *keyStoreOverrides `json:"overrides,omitempty"`
+
+			// The version of the key store metadata format. Key stores of newer versions than the server supports
+			// are listed, but requests for them are rejected with 422.
+			SchemaVersion int `json:"schema_version"`
+		} `json:"key_stores"`
+
+		// The token of the next page. Omitted on the last page.
+		NextPageToken string `json:"next_page_token,omitempty"`
+	}
+}
+
+// updateKeyStoreReq model
+//
+// swagger:parameters updateKeyStoreReq
+type updateKeyStoreReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// in: body
+	Body struct {
+		// New controller of the key store.
+		// required: true
+		Controller string `json:"controller"`
+	}
+}
+
+// updateKeyStoreResp model
+//
+// swagger:response updateKeyStoreResp
+type updateKeyStoreResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Key store URL.
+		KeyStoreURL string `json:"key_store_url"`
+
+		// Base64-encoded root ZCAPs for key store, issued to the new controller.
+		Capability string `json:"capability"`
+
+		// Key store sequence number after the update.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// keyStoreOverrides model
+type keyStoreOverrides struct { //nolint:unused,deadcode
+	// TTL of cached keys of the key store, e.g. "1h".
+	CacheTTL string `json:"cache_ttl,omitempty"`
+
+	// Maximum number of requests of the key store per second.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+
+	// Maximum number of requests of the key store served at the same time.
+	ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+}
+
+// setKeyStoreOverridesReq model
+//
+// swagger:parameters setKeyStoreOverridesReq
+type setKeyStoreOverridesReq struct { //nolint:unused,deadcode
+	// The header with the admin token: "Admin <token>".
+	//
+	// in: header
+	// required: true
+	Authorization string `json:"Authorization"`
+
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// in: body
+	Body struct {
+		// TTL of cached keys of the key store, e.g. "1h". At most --key-store-cache-ttl-max. Omit to use the
+		// server default.
+		CacheTTL string `json:"cache_ttl,omitempty"`
+
+		// Maximum number of requests of the key store per second. At most --key-store-rate-limit-max. Omit to use
+		// the server default.
+		RateLimit float64 `json:"rate_limit,omitempty"`
+
+		// Maximum number of requests of the key store served at the same time. At most
+		// --key-store-concurrency-limit-max. Omit to use the server default.
+		ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+	}
+}
+
+// setKeyStoreOverridesResp model
+//
+// swagger:response setKeyStoreOverridesResp
+type setKeyStoreOverridesResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Server settings overridden for the key store. Omitted if all were reset to the server defaults.
+		Overrides *keyStoreOverrides `json:"overrides,omitempty"`
+
+		// Key store sequence number after the update.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// deleteKeyStoreReq model
+//
+// swagger:parameters deleteKeyStoreReq
+type deleteKeyStoreReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+}
+
+// deleteKeyStoreResp model
+//
+// swagger:response deleteKeyStoreResp
+type deleteKeyStoreResp struct{} //nolint:unused,deadcode
+
+// deleteKeyReq model
+//
+// swagger:parameters deleteKeyReq
+type deleteKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+}
+
+// deleteKeyResp model
+//
+// swagger:response deleteKeyResp
+type deleteKeyResp struct{} //nolint:unused,deadcode
+
+// restoreKeyReq model
+//
+// swagger:parameters restoreKeyReq
+type restoreKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+}
+
+// restoreKeyResp model
+//
+// swagger:response restoreKeyResp
+type restoreKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// URL of the restored key.
+		KeyURL string `json:"key_url"`
+
+		// Key store sequence number after the operation. It is incremented on every mutating operation.
+		Sequence uint64 `json:"sequence"`
+	}
+}
+
+// createTokenReq model
+//
+// swagger:parameters createTokenReq
+type createTokenReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// An action the token authorizes: "verify" or "exportKey".
+		// required: true
+		Action string `json:"action"`
+
+		// Token lifetime in seconds, at most one day.
+		// required: true
+		ExpiresIn int64 `json:"expires_in"`
+	}
+}
+
+// createTokenResp model
+//
+// swagger:response createTokenResp
+type createTokenResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A secret to present in "Authorization: OneTime <token>" header. It authorizes exactly one operation.
+		Token string `json:"token"`
+
+		// ID of the token. It is not a secret and links audit entries of the operation to the minting.
+		ID string `json:"id"`
+
+		// Time after which the token is no longer accepted.
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+}
+
+// createInvitationReq model
+//
+// swagger:parameters createInvitationReq
+type createInvitationReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// A label of the invitation shown to the recipient.
+		Label string `json:"label,omitempty"`
+
+		// A capability to attach. It is sealed with the key for their_pub.
+		Capability []byte `json:"capability,omitempty"`
+
+		// X25519 public key of the recipient. Required with capability.
+		TheirPub []byte `json:"their_pub,omitempty"`
+	}
+}
+
+// createInvitationResp model
+//
+// swagger:response createInvitationResp
+type createInvitationResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// The out-of-band invitation.
+		Invitation map[string]interface{} `json:"invitation"`
+
+		// The mediator URL with the base64url-encoded invitation in "oob" query parameter, e.g. for a QR code.
+		InvitationURL string `json:"invitation_url"`
+	}
+}
+
+// signReq model
+//
+// swagger:parameters signReq
+type signReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// True to add the parameters the signature was made with to the response.
+	//
+	// in: query
+	Details bool `json:"details"`
+
+	// in: body
+	Body struct {
+		// A base64-encoded message to sign.
+		Message string `json:"message"`
+
+		// An optional client nonce. A retry with the same nonce and message returns the signature of the first
+		// request while it is kept on the server (5 minutes by default).
+		Nonce string `json:"nonce,omitempty"`
+
+		// An optional JSON document to sign instead of the message. It's transformed with the canonicalization
+		// profile, hashed with SHA-256 and the hash is signed.
+		Document interface{} `json:"document,omitempty"`
+
+		// A canonicalization profile for the document: none, jcs or urdna2015. Required with the document.
+		Canonicalization string `json:"canonicalization,omitempty"`
+
+		// Optional base64-encoded messages to sign with a single BBS+ signature instead of the message. Requires
+		// a BLS12381G2 key.
+		Messages []string `json:"messages,omitempty"`
+
+		// Derive the nonce from the key and the message (RFC 6979), so that the same message always has the same
+		// signature. Requires an ECDSA key.
+		Deterministic bool `json:"deterministic,omitempty"`
+
+		// An encoding of the signature in the response: base64url, base58btc or multibase (base58btc with a "z"
+		// prefix). The signature is base64-encoded by default.
+		ResponseEncoding string `json:"response_encoding,omitempty"`
+
+		// Sign the message as a digest computed by the client: SHA-512 with Ed25519ph for Ed25519 keys, the hash
+		// of the key parameters (SHA-256, SHA-384 or SHA-512) for ECDSA keys.
+		Prehashed bool `json:"prehashed,omitempty"`
+	}
+}
+
+// signResp model
+//
+// swagger:response signResp
+type signResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A signature, base64-encoded or in the encoding of the response.
+		Signature string `json:"signature"`
+
+		// The canonicalization profile the document was transformed with.
+		Canonicalization string `json:"canonicalization,omitempty"`
+
+		// True if the nonce of the signature was derived with RFC 6979.
+		Deterministic bool `json:"deterministic,omitempty"`
+
+		// The encoding of the signature, if other than base64.
+		Encoding string `json:"encoding,omitempty"`
+
+		// True if a prehashed digest was signed.
+		Prehashed bool `json:"prehashed,omitempty"`
+
+		// The algorithm a prehashed digest was signed with: Ed25519ph, ECDSA-P256-SHA256, ECDSA-P384-SHA384 or
+		// ECDSA-P521-SHA512.
+		Algorithm string `json:"algorithm,omitempty"`
+
+		// How the signature of a prehashed digest is verified. Ed25519ph signatures don't verify as Ed25519
+		// signatures of the message.
+		Verification string `json:"verification,omitempty"`
+
+		// The parameters the signature was made with, if requested.
+		Details *signatureDetails `json:"details,omitempty"`
+	}
+}
+
+// signatureDetails are the parameters the crypto signed or verified a signature with, read from the key used.
+type signatureDetails struct { //nolint:unused,deadcode
+	// The signature algorithm: ECDSA, EdDSA, Ed25519ph, RSASSA-PSS, RSASSA-PKCS1-v1_5 or BBS+.
+	Algorithm string `json:"algorithm"`
+
+	// The curve of the key, e.g. P-256, secp256k1 or Ed25519. Empty for RSA keys.
+	Curve string `json:"curve,omitempty"`
+
+	// The hash of the message, e.g. SHA-256.
+	Hash string `json:"hash,omitempty"`
+
+	// The format of ECDSA signatures: DER or IEEE_P1363.
+	SignatureFormat string `json:"signature_format,omitempty"`
+
+	// The JWS alg of the signature, e.g. ES256. JWS requires ECDSA signatures in the IEEE_P1363 format.
+	JOSEAlgorithm string `json:"jose_alg,omitempty"`
+
+	// The COSE algorithm of the signature, e.g. -7 for ES256.
+	COSEAlgorithm int `json:"cose_alg,omitempty"`
+
+	// The tink output prefix of signatures: RAW, or TINK for signatures prefixed with 0x01 and the key version.
+	OutputPrefix string `json:"output_prefix"`
+
+	// The version of the key within its keyset.
+	KeyVersion uint32 `json:"key_version"`
+
+	// The encoding of the signature in JSON, e.g. base64.
+	Encoding string `json:"encoding"`
+}
+
+// signBatchReq model
+//
+// swagger:parameters signBatchReq
+type signBatchReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// Base64-encoded messages to sign, at most 100 by default (--sign-batch-max-size).
+		// required: true
+		Messages []string `json:"messages"`
+	}
+}
+
+// signBatchResp model
+//
+// swagger:response signBatchResp
+type signBatchResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Base64-encoded signatures in the order of messages.
+		Signatures []string `json:"signatures"`
+	}
+}
+
+// signMultiKeyReq model
+//
+// swagger:parameters signMultiKeyReq
+type signMultiKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// in: body
+	Body struct {
+		// Messages to sign with keys, at most 100 by default (--sign-batch-max-size).
+		// required: true
+		Items []struct {
+			// The key's ID or alias.
+			// required: true
+			KeyID string `json:"key_id"`
+			// Base64-encoded message, at most 64 KiB by default (--sign-multi-key-max-message-size).
+			// required: true
+			Message string `json:"message"`
+		} `json:"items"`
+	}
+}
+
+// signMultiKeyResp model
+//
+// swagger:response signMultiKeyResp
+type signMultiKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Base64-encoded signatures in the order of items.
+		Signatures []string `json:"signatures"`
+	}
+}
+
+// signJWTReq model
+//
+// swagger:parameters signJWTReq
+type signJWTReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// JWT claims, a JSON object.
+		// required: true
+		Claims map[string]interface{} `json:"claims"`
+
+		// Protected headers of the JWS. alg is set by the key type; kid defaults to the key URL.
+		Headers map[string]interface{} `json:"headers,omitempty"`
+
+		// Signs the claims unencoded (b64=false, RFC 7797) and leaves them out of the JWS.
+		Detached bool `json:"detached,omitempty"`
+	}
+}
+
+// signJWTResp model
+//
+// swagger:response signJWTResp
+type signJWTResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// The compact serialization of the JWS; its payload is empty if detached.
+		JWS string `json:"jws"`
+	}
+}
+
+// createUploadReq model
+//
+// swagger:parameters createUploadReq
+type createUploadReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// The size of the payload in bytes, up to 4 GiB.
+		// required: true
+		Size int64 `json:"size"`
+
+		// The size of chunks in bytes, up to 8 MiB. Every chunk but the last one has this size; a payload has up to
+		// 10000 chunks.
+		// required: true
+		ChunkSize int64 `json:"chunk_size"`
+	}
+}
+
+// createUploadResp model
+//
+// swagger:response createUploadResp
+type createUploadResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// The ID of the upload, in the URLs of chunk and sign requests.
+		UploadID string `json:"upload_id"`
+
+		Size      int64 `json:"size"`
+		ChunkSize int64 `json:"chunk_size"`
+
+		// The time the upload and its chunks are deleted if the payload isn't signed.
+		ExpiresAt time.Time `json:"expires_at"`
+
+		// The algorithm the payload will be signed with: Ed25519ph, ECDSA-P256-SHA256, ECDSA-P384-SHA384 or
+		// ECDSA-P521-SHA512.
+		Algorithm string `json:"algorithm"`
+
+		// How the signature is verified.
+		Verification string `json:"verification"`
+	}
+}
+
+// putChunkReq model
+//
+// swagger:parameters putChunkReq
+type putChunkReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// The upload's ID.
+	//
+	// in: path
+	// required: true
+	UploadID string `json:"upload_id"`
+
+	// in: body
+	Body struct {
+		// The offset of the chunk in the payload, a multiple of the chunk size.
+		// required: true
+		Offset int64 `json:"offset"`
+
+		// A base64-encoded chunk.
+		// required: true
+		Chunk string `json:"chunk"`
+
+		// A base64-encoded SHA-256 hash of the chunk.
+		// required: true
+		Checksum string `json:"checksum"`
 	}
 }
 
-// rotateKeyReq model
+// putChunkResp model
 //
-// swagger:parameters rotateKeyReq
-type rotateKeyReq struct { //nolint:unused,deadcode
+// swagger:response putChunkResp
+type putChunkResp struct{} //nolint:unused,deadcode
+
+// signUploadReq model
+//
+// swagger:parameters signUploadReq
+type signUploadReq struct { //nolint:unused,deadcode
 	// The key store's ID.
 	//
 	// in: path
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
 	KeyID string `json:"key_id"`
 
-	// in: body
-	Body struct {
-
-		// A type on new key.
-		// required: true
-		KeyType string `json:"key_type"`
-	}
+	// The upload's ID.
+	//
+	// in: path
+	// required: true
+	UploadID string `json:"upload_id"`
 }
 
-// rotateKeyResp model
+// signUploadResp model
 //
-// swagger:response rotateKeyResp
-type rotateKeyResp struct { //nolint:unused,deadcode
+// swagger:response signUploadResp
+type signUploadResp struct { //nolint:unused,deadcode
 	// in: body
 	Body struct {
-		// URL of rotated key.
-		KeyURL string `json:"key_url"`
+		// A base64-encoded signature of the payload.
+		Signature string `json:"signature"`
+
+		// The size of the signed payload in bytes.
+		Size int64 `json:"size"`
+
+		// The algorithm the payload was signed with.
+		Algorithm string `json:"algorithm"`
+
+		// How the signature is verified.
+		Verification string `json:"verification"`
 	}
 }
 
-// signReq model
+// verifyReq model
 //
-// swagger:parameters signReq
-type signReq struct { //nolint:unused,deadcode
+// swagger:parameters verifyReq
+type verifyReq struct { //nolint:unused,deadcode
 	// The key store's ID.
 	//
 	// in: path
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
 	KeyID string `json:"key_id"`
 
+	// True to add the parameters the signature was verified with to the response.
+	//
+	// in: query
+	Details bool `json:"details"`
+
 	// in: body
 	Body struct {
-		// A base64-encoded message to sign.
+		// A signature, base64-encoded or in the signature encoding.
+		Signature string `json:"signature"`
+
+		// A base64-encoded message.
 		Message string `json:"message"`
+
+		// Optional base64-encoded messages to verify a BBS+ signature of instead of the message.
+		Messages []string `json:"messages,omitempty"`
+
+		// An encoding of the signature: base64url, base58btc or multibase (any base). The signature is
+		// base64-encoded by default.
+		SignatureEncoding string `json:"signature_encoding,omitempty"`
 	}
 }
 
-// signResp model
+// verifyResp model
 //
-// swagger:response signResp
-type signResp struct { //nolint:unused,deadcode
+// swagger:response verifyResp
+type verifyResp struct { //nolint:unused,deadcode
+	// Empty unless details are requested.
+	//
 	// in: body
 	Body struct {
-		// A base64-encoded signature.
-		Signature string `json:"signature"`
+		// True, an invalid signature is an error.
+		Verified bool `json:"verified"`
+
+		// The parameters the signature was verified with.
+		Details *signatureDetails `json:"details,omitempty"`
 	}
 }
 
-// verifyReq model
+// verifyWithPublicKeyReq model
 //
-// swagger:parameters verifyReq
-type verifyReq struct { //nolint:unused,deadcode
+// swagger:parameters verifyWithPublicKeyReq
+type verifyWithPublicKeyReq struct { //nolint:unused,deadcode
 	// The key store's ID.
 	//
 	// in: path
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// True to add the parameters the signature was verified with to the response.
 	//
-	// in: path
-	// required: true
-	KeyID string `json:"key_id"`
+	// in: query
+	Details bool `json:"details"`
 
 	// in: body
 	Body struct {
@@ -258,14 +1404,36 @@ type verifyReq struct { //nolint:unused,deadcode
 		Signature string `json:"signature"`
 
 		// A base64-encoded message.
-		Message string `json:"message"`
+		Message string `json:"message,omitempty"`
+
+		// Optional base64-encoded messages to verify a BBS+ signature of instead of the message.
+		Messages []string `json:"messages,omitempty"`
+
+		// A base64-encoded public key in the format keys of the key type are exported in.
+		PublicKey string `json:"public_key,omitempty"`
+
+		// The public key as a JWK, instead of public_key.
+		JWK map[string]interface{} `json:"jwk,omitempty"`
+
+		// Type of the public key.
+		// required: true
+		KeyType string `json:"key_type"`
 	}
 }
 
-// verifyResp model
+// verifyWithPublicKeyResp model
 //
-// swagger:response verifyResp
-type verifyResp struct{} //nolint:unused,deadcode
+// swagger:response verifyWithPublicKeyResp
+type verifyWithPublicKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// False if the signature doesn't verify with the public key.
+		Verified bool `json:"verified"`
+
+		// The parameters the signature was verified with, if requested.
+		Details *signatureDetails `json:"details,omitempty"`
+	}
+}
 
 // encryptReq model
 //
@@ -277,7 +1445,7 @@ type encryptReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -292,6 +1460,10 @@ type encryptReq struct { //nolint:unused,deadcode
 		// A base64-encoded associated data to be authenticated, but not encrypted.
 		// Associated data is optional, so this parameter can be nil.
 		AssociatedData string `json:"associated_data,omitempty"`
+
+		// A base64-encoded 12-byte nonce of an AES-GCM key, accepted only if caller nonces are enabled.
+		// The server generates the nonce if not set.
+		Nonce string `json:"nonce,omitempty"`
 	}
 }
 
@@ -319,7 +1491,7 @@ type decryptReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -362,7 +1534,7 @@ type computeMACReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -397,7 +1569,7 @@ type verifyMACReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -430,7 +1602,7 @@ type signMultiReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -464,7 +1636,7 @@ type verifyMultiReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -495,7 +1667,7 @@ type deriveProofReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -515,7 +1687,7 @@ type deriveProofReq struct { //nolint:unused,deadcode
 		// required: true
 		Nonce string `json:"nonce"`
 
-		// A vector of revealed messages.
+		// Zero-based indexes of the revealed messages, each in range of the messages and unique.
 		// required: true
 		RevealedIndexes []int `json:"revealed_indexes"`
 	}
@@ -542,7 +1714,7 @@ type verifyProofReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -579,7 +1751,7 @@ type easyReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The ID or alias of the ED25519 key.
 	//
 	// in: path
 	// required: true
@@ -591,11 +1763,11 @@ type easyReq struct { //nolint:unused,deadcode
 		// required: true
 		Payload string `json:"payload"`
 
-		// A base64-encoded nonce.
+		// A base64-encoded 24-byte nonce.
 		// required: true
 		Nonce string `json:"nonce"`
 
-		// A base64-encoded public key.
+		// A base64-encoded Curve25519 public key of the recipient.
 		// required: true
 		TheirPub string `json:"their_pub"`
 	}
@@ -622,23 +1794,28 @@ type easyOpenReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
+	// The ID or alias of the ED25519 key.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
 	// in: body
 	Body struct {
 		// A base64-encoded ciphertext.
 		// required: true
 		Ciphertext string `json:"ciphertext"`
 
-		// A base64-encoded nonce.
+		// A base64-encoded 24-byte nonce.
 		// required: true
 		Nonce string `json:"nonce"`
 
-		// A base64-encoded their public key.
+		// A base64-encoded Curve25519 public key of the sender.
 		// required: true
 		TheirPub string `json:"their_pub"`
 
-		// A base64-encoded my public key.
-		// required: true
-		MyPub string `json:"my_pub"`
+		// A base64-encoded ED25519 public key of the key. Defaults to the public key of the key.
+		MyPub string `json:"my_pub,omitempty"`
 	}
 }
 
@@ -663,15 +1840,20 @@ type sealOpenReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
+	// The ID or alias of the ED25519 key.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
 	// in: body
 	Body struct {
 		// A base64-encoded ciphertext.
 		// required: true
 		Ciphertext string `json:"ciphertext"`
 
-		// A base64-encoded my public key.
-		// required: true
-		MyPub string `json:"my_pub"`
+		// A base64-encoded ED25519 public key of the key. Defaults to the public key of the key.
+		MyPub string `json:"my_pub,omitempty"`
 	}
 }
 
@@ -761,6 +1943,10 @@ type wrapKeyReq struct { //nolint:unused,deadcode
 		// Recipient public key.
 		// required: true
 		RecipientPubKey publicKey `json:"recipient_pub_key"`
+
+		// The ID of the key of the key store to wrap with as the sender. Selects ECDH-1PU key wrapping (Authcrypt)
+		// instead of ECDH-ES (Anoncrypt).
+		SenderKID string `json:"sender_kid,omitempty"`
 	}
 }
 
@@ -824,7 +2010,7 @@ type unwrapKeyReq struct { //nolint:unused,deadcode
 	// required: true
 	KeyStoreID string `json:"key_store_id"`
 
-	// The key's ID.
+	// The key's ID or alias.
 	//
 	// in: path
 	// required: true
@@ -857,6 +2043,191 @@ type unwrapKeyResp struct { //nolint:unused,deadcode
 	}
 }
 
+// encryptJWEReq model
+//
+// swagger:parameters encryptJWEReq
+type encryptJWEReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// in: body
+	Body struct {
+		// A base64-encoded plaintext.
+		// required: true
+		Plaintext string `json:"plaintext"`
+
+		// Public keys of the recipients, EC keys on P-256, P-384 or P-521 curves.
+		// required: true
+		Recipients []*publicKey `json:"recipients"`
+
+		// Key management algorithm. Only ECDH-ES+A256KW is supported.
+		Alg string `json:"alg,omitempty"`
+
+		// Content encryption algorithm. Only A256GCM is supported.
+		Enc string `json:"enc,omitempty"`
+
+		// JWE serialization, json (default) or compact. Compact serialization allows a single recipient only.
+		Serialization string `json:"serialization,omitempty"`
+	}
+}
+
+// encryptJWEResp model
+//
+// swagger:response encryptJWEResp
+type encryptJWEResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// The serialized JWE.
+		JWE string `json:"jwe"`
+	}
+}
+
+// decryptJWEReq model
+//
+// swagger:parameters decryptJWEReq
+type decryptJWEReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// A JWE in compact or JSON serialization.
+		// required: true
+		JWE string `json:"jwe"`
+	}
+}
+
+// decryptJWEResp model
+//
+// swagger:response decryptJWEResp
+type decryptJWEResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A base64-encoded plaintext.
+		Plaintext string `json:"plaintext"`
+	}
+}
+
+// deriveKeyReq model
+//
+// swagger:parameters deriveKeyReq
+type deriveKeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The key's ID or alias.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// X25519 public key of the peer as an OKP JWK.
+		// required: true
+		PeerPublicKey map[string]interface{} `json:"peer_public_key"`
+
+		// Key derivation parameters.
+		KDF struct {
+			// Key derivation function. Only HKDF-SHA256 is supported.
+			Alg string `json:"alg,omitempty"`
+
+			// A base64-encoded HKDF salt.
+			Salt string `json:"salt,omitempty"`
+
+			// A base64-encoded HKDF info.
+			Info string `json:"info,omitempty"`
+
+			// Length of the derived key in bytes, a multiple of 8 between 16 and 64. Defaults to 32.
+			Length int `json:"length,omitempty"`
+		} `json:"kdf"`
+
+		// Public key the derived key is wrapped for. Without it the derived key is returned raw, if the server
+		// allows it.
+		Recipient *publicKey `json:"recipient,omitempty"`
+	}
+}
+
+// deriveKeyResp model
+//
+// swagger:response deriveKeyResp
+type deriveKeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// A base64-encoded derived key, if it's returned raw.
+		Key string `json:"key,omitempty"`
+
+		// The derived key wrapped for the recipient.
+		WrappedKey *wrappedKey `json:"wrapped_key,omitempty"`
+	}
+}
+
+// deriveSubkeyReq model
+//
+// swagger:parameters deriveSubkeyReq
+type deriveSubkeyReq struct { //nolint:unused,deadcode
+	// The key store's ID.
+	//
+	// in: path
+	// required: true
+	KeyStoreID string `json:"key_store_id"`
+
+	// The ID or alias of the HMAC key to derive from.
+	//
+	// in: path
+	// required: true
+	KeyID string `json:"key_id"`
+
+	// in: body
+	Body struct {
+		// A base64-encoded HKDF salt.
+		Salt string `json:"salt,omitempty"`
+
+		// A base64-encoded HKDF info, e.g. the name of the tenant of the derived key.
+		Info string `json:"info,omitempty"`
+
+		// Type of the derived key: AES128GCM, AES256GCM, ChaCha20Poly1305, XChaCha20Poly1305 or
+		// HMACSHA256Tag256.
+		// required: true
+		KeyType string `json:"key_type"`
+
+		// Alias of the derived key.
+		Alias string `json:"alias,omitempty"`
+	}
+}
+
+// deriveSubkeyResp model
+//
+// swagger:response deriveSubkeyResp
+type deriveSubkeyResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// URL of the derived key.
+		KeyURL string `json:"key_url"`
+
+		// Sequence of the key store after the key was added.
+		Sequence uint64 `json:"sequence"`
+
+		// Version of the key metadata format.
+		SchemaVersion int `json:"schema_version"`
+	}
+}
+
 // healthCheckReq model
 //
 // swagger:parameters healthCheckRequest
@@ -870,9 +2241,106 @@ type healthCheckResp struct { //nolint:unused,deadcode
 	Body struct {
 		Status      string    `json:"status"`
 		CurrentTime time.Time `json:"current_time"`
+		// NoZCAPKeyStores is true if the server allows key stores without ZCAPs.
+		NoZCAPKeyStores bool `json:"no_zcap_key_stores,omitempty"`
+	}
+}
+
+// infoReq model
+//
+// swagger:parameters infoReq
+type infoReq struct{} //nolint:unused,deadcode
+
+// infoResp model
+//
+// swagger:response infoResp
+type infoResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// DisabledOperations are actions whose endpoints answer 404 Not Found, e.g. "wrap".
+		DisabledOperations []string `json:"disabled_operations"`
+		// NoZCAPKeyStores is true if the server allows key stores without ZCAPs.
+		NoZCAPKeyStores bool `json:"no_zcap_key_stores"`
+		// TestVectors is true if the server serves test vectors.
+		TestVectors bool `json:"test_vectors"`
+	}
+}
+
+// testVectorsReq model
+//
+// swagger:parameters testVectorsReq
+type testVectorsReq struct{} //nolint:unused,deadcode
+
+// testVectorsResp model
+//
+// swagger:response testVectorsResp
+type testVectorsResp struct { //nolint:unused,deadcode
+	// in: body
+	Body struct {
+		// Test vectors of key types.
+		Keys []keyTestVector `json:"keys"`
+
+		// A signed request invoking the root capability of a key store.
+		ZCAPInvocation zcapInvocationTestVector `json:"zcap_invocation"`
 	}
 }
 
+type keyTestVector struct { //nolint:unused
+	KeyType string `json:"key_type"`
+
+	// A base64-encoded private key, as imported. A published test key, never to be used for anything else.
+	PrivateKey string `json:"private_key"`
+
+	KeyURL string `json:"key_url"`
+
+	// A base64-encoded public key, as exported.
+	PublicKey string `json:"public_key"`
+
+	// A base64-encoded signed message.
+	Message string `json:"message,omitempty"`
+
+	// Base64-encoded messages signed with BBS+ keys.
+	Messages []string `json:"messages,omitempty"`
+
+	// A base64-encoded signature.
+	Signature string `json:"signature"`
+
+	// False if the key type makes a different signature each time, so the signature can only be verified.
+	Deterministic bool `json:"deterministic"`
+
+	// The public key as JWK, if the key type has one.
+	JWK map[string]interface{} `json:"jwk,omitempty"`
+
+	// The public key as did:key, if the key type has one.
+	DIDKey map[string]string `json:"did_key,omitempty"`
+
+	// Fingerprints of the public key, if it has a did:key.
+	Fingerprint map[string]interface{} `json:"fingerprint,omitempty"`
+}
+
+type zcapInvocationTestVector struct { //nolint:unused
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// The root capability of the key store, without its proof.
+	Capability map[string]interface{} `json:"capability"`
+
+	// The keyId of the HTTP signature.
+	Invoker string `json:"invoker"`
+
+	// Creation time of the HTTP signature in Unix seconds.
+	Created int64 `json:"created"`
+
+	CoveredComponents []string `json:"covered_components"`
+	SignatureBase     string   `json:"signature_base"`
+
+	// A base64-encoded signature.
+	Signature string `json:"signature"`
+
+	// Headers of the request.
+	Headers map[string]string `json:"headers"`
+}
+
 // errorResp model
 //
 // swagger:response errorResp
@@ -10,42 +10,75 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/hyperledger/aries-framework-go/pkg/common/log"
 
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/clock"
 	"github.com/trustbloc/kms/pkg/controller/command"
 	"github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
+	"github.com/trustbloc/kms/pkg/reqlog"
 )
 
 // API endpoints.
 const (
-	KeyStoreVarName = "keystore"
-	keyVarName      = "key"
-	BaseV1Path      = "/v1"
-	KeyStorePath    = BaseV1Path + "/keystores"
-	DIDPath         = KeyStorePath + "/did"
-	KeyPath         = KeyStorePath + "/{" + KeyStoreVarName + "}/keys"
-	ExportKeyPath   = KeyPath + "/{" + keyVarName + "}/export"
-	RotateKeyPath   = KeyPath + "/{" + keyVarName + "}/rotate"
-	SignPath        = KeyPath + "/{" + keyVarName + "}/sign"
-	VerifyPath      = KeyPath + "/{" + keyVarName + "}/verify"
-	EncryptPath     = KeyPath + "/{" + keyVarName + "}/encrypt"
-	DecryptPath     = KeyPath + "/{" + keyVarName + "}/decrypt"
-	ComputeMACPath  = KeyPath + "/{" + keyVarName + "}/computemac"
-	VerifyMACPath   = KeyPath + "/{" + keyVarName + "}/verifymac"
-	SignMultiPath   = KeyPath + "/{" + keyVarName + "}/signmulti"
-	VerifyMultiPath = KeyPath + "/{" + keyVarName + "}/verifymulti"
-	DeriveProofPath = KeyPath + "/{" + keyVarName + "}/deriveproof"
-	VerifyProofPath = KeyPath + "/{" + keyVarName + "}/verifyproof"
-	WrapKeyPath     = KeyStorePath + "/{" + KeyStoreVarName + "}/wrap"
-	WrapKeyAEPath   = KeyPath + "/{" + keyVarName + "}/wrap"
-	UnwrapKeyPath   = KeyPath + "/{" + keyVarName + "}/unwrap"
-	HealthCheckPath = "/healthcheck"
+	KeyStoreVarName     = "keystore"
+	KeyVarName          = "key"
+	UploadVarName       = "upload"
+	BaseV1Path          = "/v1"
+	KeyStorePath        = BaseV1Path + "/keystores"
+	DIDPath             = KeyStorePath + "/did"
+	KeyStoreIDPath      = KeyStorePath + "/{" + KeyStoreVarName + "}"
+	OverridesPath       = KeyStoreIDPath + "/overrides"
+	KeyPath             = KeyStorePath + "/{" + KeyStoreVarName + "}/keys"
+	BatchKeyPath        = KeyPath + "/batch"
+	DeleteKeyPath       = KeyPath + "/{" + KeyVarName + "}"
+	ExportKeyPath       = KeyPath + "/{" + KeyVarName + "}/export"
+	FingerprintPath     = KeyPath + "/{" + KeyVarName + "}/fingerprint"
+	RotateKeyPath       = KeyPath + "/{" + KeyVarName + "}/rotate"
+	KeyStatePath        = KeyPath + "/{" + KeyVarName + "}/state"
+	RestoreKeyPath      = KeyPath + "/{" + KeyVarName + "}/restore"
+	TokensPath          = KeyPath + "/{" + KeyVarName + "}/tokens"
+	InvitationPath      = KeyPath + "/{" + KeyVarName + "}/invitation"
+	SignPath            = KeyPath + "/{" + KeyVarName + "}/sign"
+	SignBatchPath       = SignPath + "/batch"
+	SignMultiKeyPath    = KeyStoreIDPath + "/signmulti"
+	SignJWTPath         = KeyPath + "/{" + KeyVarName + "}/signjwt"
+	UploadsPath         = KeyPath + "/{" + KeyVarName + "}/uploads"
+	UploadPath          = UploadsPath + "/{" + UploadVarName + "}"
+	VerifyPath          = KeyPath + "/{" + KeyVarName + "}/verify"
+	VerifyPublicKeyPath = KeyStoreIDPath + "/verify"
+	EncryptPath         = KeyPath + "/{" + KeyVarName + "}/encrypt"
+	DecryptPath         = KeyPath + "/{" + KeyVarName + "}/decrypt"
+	ComputeMACPath      = KeyPath + "/{" + KeyVarName + "}/computemac"
+	VerifyMACPath       = KeyPath + "/{" + KeyVarName + "}/verifymac"
+	SignMultiPath       = KeyPath + "/{" + KeyVarName + "}/signmulti"
+	VerifyMultiPath     = KeyPath + "/{" + KeyVarName + "}/verifymulti"
+	DeriveProofPath     = KeyPath + "/{" + KeyVarName + "}/deriveproof"
+	VerifyProofPath     = KeyPath + "/{" + KeyVarName + "}/verifyproof"
+	WrapKeyPath         = KeyStorePath + "/{" + KeyStoreVarName + "}/wrap"
+	WrapKeyAEPath       = KeyPath + "/{" + KeyVarName + "}/wrap"
+	UnwrapKeyPath       = KeyPath + "/{" + KeyVarName + "}/unwrap"
+	EncryptJWEPath      = KeyStorePath + "/{" + KeyStoreVarName + "}/encryptjwe"
+	DecryptJWEPath      = KeyPath + "/{" + KeyVarName + "}/decryptjwe"
+	DeriveKeyPath       = KeyPath + "/{" + KeyVarName + "}/derive"
+	DeriveSubkeyPath    = KeyPath + "/{" + KeyVarName + "}/subkeys"
+	EasyPath            = KeyPath + "/{" + KeyVarName + "}/easy"
+	EasyOpenPath        = KeyPath + "/{" + KeyVarName + "}/easyopen"
+	SealOpenPath        = KeyPath + "/{" + KeyVarName + "}/sealopen"
+	HealthCheckPath     = "/healthcheck"
+	InfoPath            = "/info"
+	TestVectorsPath     = "/devel/test-vectors"
 )
 
 const (
@@ -53,19 +86,51 @@ const (
 	applicationJSON   = "application/json"
 	authUserHeader    = "Auth-User"
 	secretShareHeader = "Secret-Share"
+	idempotencyHeader = "Idempotency-Key"
+	fieldsQueryParam  = "fields"
+	formatQueryParam  = "format"
+	detailsQueryParam = "details"
+
+	controllerQueryParam = "controller"
+	pageTokenQueryParam  = "page_token"
+	pageSizeQueryParam   = "page_size"
+	overridesQueryParam  = "overrides"
+
+	unusedSinceQueryParam = "unused_since"
 )
 
-var logger = log.New("controller/rest")
+var logger = reqlog.New("controller/rest")
 
 // Cmd defines command methods.
 type Cmd interface {
 	CreateDID(w io.Writer, r io.Reader) error
 	CreateKeyStore(w io.Writer, r io.Reader) error
+	GetKeyStore(w io.Writer, r io.Reader) error
+	ListKeyStores(w io.Writer, r io.Reader) error
+	DeleteKeyStore(w io.Writer, r io.Reader) error
+	UpdateKeyStore(w io.Writer, r io.Reader) error
+	SetKeyStoreOverrides(w io.Writer, r io.Reader) error
 	CreateKey(w io.Writer, r io.Reader) error
+	CreateKeys(w io.Writer, r io.Reader) error
+	GetKey(w io.Writer, r io.Reader) error
+	ListKeys(w io.Writer, r io.Reader) error
 	ExportKey(w io.Writer, r io.Reader) error
+	GetKeyFingerprint(w io.Writer, r io.Reader) error
 	RotateKey(w io.Writer, r io.Reader) error
+	UpdateKey(w io.Writer, r io.Reader) error
+	SetKeyState(w io.Writer, r io.Reader) error
+	DeleteKey(w io.Writer, r io.Reader) error
+	RestoreKey(w io.Writer, r io.Reader) error
+	CreateToken(w io.Writer, r io.Reader) error
+	CreateInvitation(w io.Writer, r io.Reader) error
 	ImportKey(w io.Writer, r io.Reader) error
 	Sign(w io.Writer, r io.Reader) error
+	SignBatch(w io.Writer, r io.Reader) error
+	SignMultiKey(w io.Writer, r io.Reader) error
+	SignJWT(w io.Writer, r io.Reader) error
+	CreateUpload(w io.Writer, r io.Reader) error
+	PutUploadChunk(w io.Writer, r io.Reader) error
+	SignUpload(w io.Writer, r io.Reader) error
 	Verify(w io.Writer, r io.Reader) error
 	Encrypt(w io.Writer, r io.Reader) error
 	Decrypt(w io.Writer, r io.Reader) error
@@ -77,29 +142,111 @@ type Cmd interface {
 	VerifyProof(w io.Writer, r io.Reader) error
 	WrapKey(w io.Writer, r io.Reader) error
 	UnwrapKey(w io.Writer, r io.Reader) error
+	EncryptJWE(w io.Writer, r io.Reader) error
+	DecryptJWE(w io.Writer, r io.Reader) error
+	DeriveKey(w io.Writer, r io.Reader) error
+	DeriveSubkey(w io.Writer, r io.Reader) error
+	Easy(w io.Writer, r io.Reader) error
+	EasyOpen(w io.Writer, r io.Reader) error
+	SealOpen(w io.Writer, r io.Reader) error
+	TestVectors(w io.Writer, r io.Reader) error
+	Validate(action string, r io.Reader) error
 }
 
 // Operation represents REST API controller.
 type Operation struct {
-	cmd Cmd
+	cmd               Cmd
+	clock             clock.Clock
+	enableNoZCAP      bool
+	enableTestVectors bool
+	disabled          []string
+}
+
+// Option configures REST API controller.
+type Option func(o *Operation)
+
+// WithClock sets the clock used to report current time. Defaults to system time.
+func WithClock(c clock.Clock) Option {
+	return func(o *Operation) {
+		o.clock = c
+	}
+}
+
+// WithNoZCAPKeyStores advertises in the health check that the server allows key stores without ZCAPs, so that test
+// clients can tell whether to create them.
+func WithNoZCAPKeyStores(enabled bool) Option {
+	return func(o *Operation) {
+		o.enableNoZCAP = enabled
+	}
+}
+
+// WithDisabledOperations reports in the server info the operations disabled on the server, so that clients can tell
+// a disabled endpoint from a missing one.
+func WithDisabledOperations(operations []string) Option {
+	return func(o *Operation) {
+		o.disabled = operations
+	}
+}
+
+// WithTestVectors serves canonical test vectors for client implementers. The vectors are made with well-known
+// private keys, so the endpoint is for development servers only.
+func WithTestVectors(enabled bool) Option {
+	return func(o *Operation) {
+		o.enableTestVectors = enabled
+	}
 }
 
 // New returns REST API controller.
-func New(cmd Cmd) *Operation {
-	return &Operation{cmd: cmd}
+func New(cmd Cmd, opts ...Option) *Operation {
+	o := &Operation{
+		cmd:   cmd,
+		clock: clock.Real(),
+	}
+
+	for _, fn := range opts {
+		fn(o)
+	}
+
+	return o
 }
 
 // GetRESTHandlers returns list of all handlers supported by this controller.
 func (o *Operation) GetRESTHandlers() []Handler {
-	return []Handler{
+	handlers := []Handler{
 		NewHTTPHandler(DIDPath, http.MethodPost, o.CreateDID, command.ActionCreateDID, AuthOAuth2),
 		NewHTTPHandler(KeyStorePath, http.MethodPost, o.CreateKeyStore, command.ActionCreateKeyStore, AuthOAuth2|AuthGNAP), //nolint:lll
+		NewHTTPHandler(KeyStorePath, http.MethodGet, o.ListKeyStores, command.ActionListKeyStores, AuthAdmin),
+		NewHTTPHandler(KeyStoreIDPath, http.MethodGet, o.GetKeyStore, command.ActionGetKeyStore, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(KeyStoreIDPath, http.MethodDelete, o.DeleteKeyStore, command.ActionDeleteKeyStore, AuthZCAP),
+		NewHTTPHandler(KeyStoreIDPath, http.MethodPatch, o.UpdateKeyStore, command.ActionUpdateKeyStore, AuthZCAP),
+		NewHTTPHandler(OverridesPath, http.MethodPut, o.SetKeyStoreOverrides, command.ActionSetOverrides, AuthAdmin),
 		NewHTTPHandler(KeyPath, http.MethodPost, o.CreateKey, command.ActionCreateKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(BatchKeyPath, http.MethodPost, o.CreateKeys, command.ActionCreateKeys, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(KeyPath, http.MethodPut, o.ImportKey, command.ActionImportKey, AuthZCAP|AuthGNAP),
-		NewHTTPHandler(ExportKeyPath, http.MethodGet, o.ExportKey, command.ActionExportKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(KeyPath, http.MethodGet, o.ListKeys, command.ActionListKeys, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DeleteKeyPath, http.MethodGet, o.GetKey, command.ActionGetKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(ExportKeyPath, http.MethodGet, o.ExportKey, command.ActionExportKey, AuthZCAP|AuthGNAP|AuthToken),
+		NewHTTPHandler(FingerprintPath, http.MethodGet, o.GetKeyFingerprint, command.ActionExportKey,
+			AuthZCAP|AuthGNAP|AuthToken),
 		NewHTTPHandler(RotateKeyPath, http.MethodPost, o.RotateKey, command.ActionRotateKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DeleteKeyPath, http.MethodPatch, o.UpdateKey, command.ActionUpdateKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DeleteKeyPath, http.MethodDelete, o.DeleteKey, command.ActionDeleteKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(RestoreKeyPath, http.MethodPost, o.RestoreKey, command.ActionRestoreKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(KeyStatePath, http.MethodPatch, o.SetKeyState, command.ActionSetKeyState, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(TokensPath, http.MethodPost, o.CreateToken, command.ActionCreateToken, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(InvitationPath, http.MethodPost, o.CreateInvitation, command.ActionInvitation,
+			AuthZCAP|AuthGNAP),
 		NewHTTPHandler(SignPath, http.MethodPost, o.Sign, command.ActionSign, AuthZCAP|AuthGNAP),
-		NewHTTPHandler(VerifyPath, http.MethodPost, o.Verify, command.ActionVerify, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(SignBatchPath, http.MethodPost, o.SignBatch, command.ActionSignBatch, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(SignMultiKeyPath, http.MethodPost, o.SignMultiKey, command.ActionSignMultiKey,
+			AuthZCAP|AuthGNAP),
+		NewHTTPHandler(SignJWTPath, http.MethodPost, o.SignJWT, command.ActionSignJWT, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(UploadsPath, http.MethodPost, o.CreateUpload, command.ActionSignUpload, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(UploadPath, http.MethodPut, o.PutUploadChunk, command.ActionSignUpload, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(UploadPath, http.MethodPost, o.SignUpload, command.ActionSignUpload, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(VerifyPath, http.MethodPost, o.Verify, command.ActionVerify, AuthZCAP|AuthGNAP|AuthToken),
+		NewHTTPHandler(VerifyPublicKeyPath, http.MethodPost, o.VerifyWithPublicKey, command.ActionVerify,
+			AuthZCAP|AuthGNAP),
 		NewHTTPHandler(EncryptPath, http.MethodPost, o.Encrypt, command.ActionEncrypt, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(DecryptPath, http.MethodPost, o.Decrypt, command.ActionDecrypt, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(ComputeMACPath, http.MethodPost, o.ComputeMAC, command.ActionComputeMac, AuthZCAP|AuthGNAP),
@@ -111,8 +258,22 @@ func (o *Operation) GetRESTHandlers() []Handler {
 		NewHTTPHandler(WrapKeyPath, http.MethodPost, o.WrapKey, command.ActionWrap, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(WrapKeyAEPath, http.MethodPost, o.WrapKeyAE, command.ActionWrap, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(UnwrapKeyPath, http.MethodPost, o.UnwrapKey, command.ActionUnwrap, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(EncryptJWEPath, http.MethodPost, o.EncryptJWE, command.ActionEncryptJWE, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DecryptJWEPath, http.MethodPost, o.DecryptJWE, command.ActionDecryptJWE, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DeriveKeyPath, http.MethodPost, o.DeriveKey, command.ActionDeriveKey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(DeriveSubkeyPath, http.MethodPost, o.DeriveSubkey, command.ActionDeriveSubkey, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(EasyPath, http.MethodPost, o.Easy, command.ActionEasy, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(EasyOpenPath, http.MethodPost, o.EasyOpen, command.ActionEasyOpen, AuthZCAP|AuthGNAP),
+		NewHTTPHandler(SealOpenPath, http.MethodPost, o.SealOpen, command.ActionSealOpen, AuthZCAP|AuthGNAP),
 		NewHTTPHandler(HealthCheckPath, http.MethodGet, o.HealthCheck, "", AuthNone),
+		NewHTTPHandler(InfoPath, http.MethodGet, o.Info, "", AuthNone),
+	}
+
+	if o.enableTestVectors {
+		handlers = append(handlers, NewHTTPHandler(TestVectorsPath, http.MethodGet, o.TestVectors, "", AuthNone))
 	}
+
+	return handlers
 }
 
 // CreateDID swagger:route POST /v1/keystores/did kms createDIDReq
@@ -128,7 +289,8 @@ func (o *Operation) CreateDID(rw http.ResponseWriter, req *http.Request) {
 
 // CreateKeyStore swagger:route POST /v1/keystores kms createKeyStoreReq
 //
-// Creates a new key store.
+// Creates a new key store. A request with an Idempotency-Key header, or with dedupe set, that repeats an earlier
+// request of the same controller returns the key store created by the earlier request.
 //
 // Responses:
 //        201: createKeyStoreResp
@@ -137,6 +299,59 @@ func (o *Operation) CreateKeyStore(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.CreateKeyStore, rw, req)
 }
 
+// ListKeyStores swagger:route GET /v1/keystores kms listKeyStoresReq
+//
+// Lists key stores of the controller, or with overrides=true key stores that have overrides, ordered by ID. Admin
+// operation authorized with the admin token ("Authorization: Admin <token>").
+//
+// Responses:
+//        200: listKeyStoresResp
+//    default: errorResp
+func (o *Operation) ListKeyStores(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	listReq := command.ListKeyStoresRequest{
+		Controller: query.Get(controllerQueryParam),
+		PageToken:  query.Get(pageTokenQueryParam),
+	}
+
+	if v := query.Get(overridesQueryParam); v != "" {
+		overrides, err := strconv.ParseBool(v)
+		if err != nil {
+			rw.Header().Set(contentType, applicationJSON)
+			sendError(rw, req, fmt.Errorf("%w: %s must be a boolean", errors.ErrBadRequest, overridesQueryParam))
+
+			return
+		}
+
+		listReq.Overrides = overrides
+	}
+
+	if v := query.Get(pageSizeQueryParam); v != "" {
+		pageSize, err := strconv.Atoi(v)
+		if err != nil {
+			rw.Header().Set(contentType, applicationJSON)
+			sendError(rw, req, fmt.Errorf("%w: %s must be a number", errors.ErrBadRequest, pageSizeQueryParam))
+
+			return
+		}
+
+		listReq.PageSize = pageSize
+	}
+
+	b, err := json.Marshal(listReq)
+	if err != nil {
+		rw.Header().Set(contentType, applicationJSON)
+		sendError(rw, req, fmt.Errorf("%w: marshal request", errors.ErrInternal))
+
+		return
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(b))
+
+	executeWithFields(o.cmd.ListKeyStores, rw, req)
+}
+
 // CreateKey swagger:route POST /v1/keystores/{key_store_id}/keys kms createKeyReq
 //
 // Creates a new key.
@@ -148,9 +363,20 @@ func (o *Operation) CreateKey(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.CreateKey, rw, req)
 }
 
+// CreateKeys swagger:route POST /v1/keystores/{key_store_id}/keys/batch kms createKeysReq
+//
+// Creates a batch of keys. Keys are created all or nothing: if any key fails, no key is created.
+//
+// Responses:
+//        201: createKeysResp
+//    default: errorResp
+func (o *Operation) CreateKeys(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.CreateKeys, rw, req)
+}
+
 // ImportKey swagger:route PUT /v1/keystores/{key_store_id}/keys kms importKeyReq
 //
-// Imports a private key.
+// Imports an Ed25519 or ECDSA private key. Responds with 422 if the key type can't be imported.
 //
 // Responses:
 //        201: importKeyResp
@@ -159,15 +385,81 @@ func (o *Operation) ImportKey(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.ImportKey, rw, req)
 }
 
-// ExportKey swagger:route GET /v1/keystores/{key_store_id}/keys/{key_id} kms exportKeyReq
+// GetKey swagger:route GET /v1/keystores/{key_store_id}/keys/{key_id} kms getKeyReq
+//
+// Returns the key type, creation time, whether the public key is exportable and, for asymmetric keys, the public key.
+// Private key material is never returned. Type and creation time are not known for keys created before key stores
+// started to track their keys, the type of such asymmetric keys is taken from the public key.
+//
+// Responses:
+//        200: getKeyResp
+//    default: errorResp
+func (o *Operation) GetKey(rw http.ResponseWriter, req *http.Request) {
+	executeWithFields(o.cmd.GetKey, rw, req)
+}
+
+// ListKeys swagger:route GET /v1/keystores/{key_store_id}/keys kms listKeysReq
+//
+// Lists keys of the key store with their type, alias, state, creation, expiration and last-used time, in the order
+// of creation. Keys created before key stores started to track their keys are not listed. With "unused_since" query
+// parameter only keys that weren't used since the time are listed.
+//
+// Responses:
+//        200: listKeysResp
+//    default: errorResp
+func (o *Operation) ListKeys(rw http.ResponseWriter, req *http.Request) {
+	var listReq command.ListKeysRequest
+
+	if v := req.URL.Query().Get(unusedSinceQueryParam); v != "" {
+		unusedSince, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			rw.Header().Set(contentType, applicationJSON)
+			sendError(rw, req, fmt.Errorf("%w: %s must be an RFC 3339 time", errors.ErrBadRequest, unusedSinceQueryParam))
+
+			return
+		}
+
+		listReq.UnusedSince = &unusedSince
+	}
+
+	b, err := json.Marshal(listReq)
+	if err != nil {
+		rw.Header().Set(contentType, applicationJSON)
+		sendError(rw, req, fmt.Errorf("%w: marshal request", errors.ErrInternal))
+
+		return
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(b))
+
+	executeWithFields(o.cmd.ListKeys, rw, req)
+}
+
+// ExportKey swagger:route GET /v1/keystores/{key_store_id}/keys/{key_id}/export kms exportKeyReq
 //
-// Exports a public key.
+// Exports a public key. An optional comma-separated "fields" query parameter selects the fields of the response.
+// With "format=jwk" query parameter the public key is returned as a JWK with the key URL as "kid", with "format=did"
+// as a did:key and its verification method.
+// If response signing is enabled on the server, the response is signed with a detached JWS in the
+// "Response-Signature" header, or wrapped in a JWS JSON envelope if "application/jose+json" is accepted.
 //
 // Responses:
 //        200: exportKeyResp
 //    default: errorResp
 func (o *Operation) ExportKey(rw http.ResponseWriter, req *http.Request) {
-	execute(o.cmd.ExportKey, rw, req)
+	executeWithFields(o.cmd.ExportKey, rw, req)
+}
+
+// GetKeyFingerprint swagger:route GET /v1/keystores/{key_store_id}/keys/{key_id}/fingerprint kms getKeyFingerprintReq
+//
+// Returns the multibase fingerprint of a public key (as in its did:key) and its SHA-256 JWK thumbprint (RFC 7638).
+// The request is authorized like key export.
+//
+// Responses:
+//        200: getKeyFingerprintResp
+//    default: errorResp
+func (o *Operation) GetKeyFingerprint(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.GetKeyFingerprint, rw, req)
 }
 
 // RotateKey swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/rotate kms rotateKeyReq
@@ -181,9 +473,147 @@ func (o *Operation) RotateKey(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.RotateKey, rw, req)
 }
 
+// UpdateKey swagger:route PATCH /v1/keystores/{key_store_id}/keys/{key_id} kms updateKeyReq
+//
+// Sets or renames the alias of the key, an empty alias removes it. An alias can be used instead of the key ID in
+// the key's URL. Responds with 409 and the URL of the other key if the alias is already used in the key store.
+// Registers DID URLs of verification methods, which can be used instead of the key ID as well. Responds with 409 and
+// VERIFICATION_METHOD_CONFLICT code if a DID URL is registered for another key.
+//
+// Responses:
+//        200: updateKeyResp
+//    default: errorResp
+func (o *Operation) UpdateKey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.UpdateKey, rw, req)
+}
+
+// SetKeyState swagger:route PATCH /v1/keystores/{key_store_id}/keys/{key_id}/state kms setKeyStateReq
+//
+// Disables or re-enables the key. Sign, encrypt, compute MAC and wrap requests with a disabled key are rejected with
+// 409 and a KEY_DISABLED code; verify, decrypt and unwrap keep working, so that existing artifacts remain checkable.
+// Requests with an expired key are rejected the same way with a KEY_EXPIRED code.
+//
+// Responses:
+//        200: setKeyStateResp
+//    default: errorResp
+func (o *Operation) SetKeyState(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SetKeyState, rw, req)
+}
+
+// GetKeyStore swagger:route GET /v1/keystores/{key_store_id} kms getKeyStoreReq
+//
+// Returns metadata of the key store: its controller, creation time, storage type ("local" or "edv") and number of
+// keys.
+//
+// Responses:
+//        200: getKeyStoreResp
+//    default: errorResp
+func (o *Operation) GetKeyStore(rw http.ResponseWriter, req *http.Request) {
+	executeWithFields(o.cmd.GetKeyStore, rw, req)
+}
+
+// DeleteKeyStore swagger:route DELETE /v1/keystores/{key_store_id} kms deleteKeyStoreReq
+//
+// Deletes the key store with its keys and root capability. Only the controller of the key store can delete it.
+// The vault of an EDV-backed key store is not deleted.
+//
+// Responses:
+//        204: deleteKeyStoreResp
+//    default: errorResp
+func (o *Operation) DeleteKeyStore(rw http.ResponseWriter, req *http.Request) {
+	execute(func(w io.Writer, r io.Reader) error {
+		if err := o.cmd.DeleteKeyStore(w, r); err != nil {
+			return err
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}, rw, req)
+}
+
+// UpdateKeyStore swagger:route PATCH /v1/keystores/{key_store_id} kms updateKeyStoreReq
+//
+// Sets a new controller of the key store and returns the root capability re-issued for it. Only the controller of
+// the key store can update it. The old root capability is accepted until the controller rotation grace period ends.
+//
+// Responses:
+//        200: updateKeyStoreResp
+//    default: errorResp
+func (o *Operation) UpdateKeyStore(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.UpdateKeyStore, rw, req)
+}
+
+// SetKeyStoreOverrides swagger:route PUT /v1/keystores/{key_store_id}/overrides kms setKeyStoreOverridesReq
+//
+// Overrides server settings for the key store; omitted settings are reset to the server defaults. Admin operation
+// authorized with the admin token ("Authorization: Admin <token>").
+//
+// Responses:
+//        200: setKeyStoreOverridesResp
+//    default: errorResp
+func (o *Operation) SetKeyStoreOverrides(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SetKeyStoreOverrides, rw, req)
+}
+
+// DeleteKey swagger:route DELETE /v1/keystores/{key_store_id}/keys/{key_id} kms deleteKeyReq
+//
+// Deletes the key. The key is no longer listed or usable, but it can be restored until the retention period of the
+// server ends, after which the key is purged.
+//
+// Responses:
+//        204: deleteKeyResp
+//    default: errorResp
+func (o *Operation) DeleteKey(rw http.ResponseWriter, req *http.Request) {
+	execute(func(w io.Writer, r io.Reader) error {
+		if err := o.cmd.DeleteKey(w, r); err != nil {
+			return err
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}, rw, req)
+}
+
+// RestoreKey swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/restore kms restoreKeyReq
+//
+// Restores a deleted key. Responds with 404 if the retention period of the key ended, and with 409 if the key isn't
+// deleted.
+//
+// Responses:
+//        200: restoreKeyResp
+//    default: errorResp
+func (o *Operation) RestoreKey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.RestoreKey, rw, req)
+}
+
+// CreateToken swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/tokens kms createTokenReq
+//
+// Mints a one-time token that authorizes a single verify or export of the key until it expires.
+//
+// Responses:
+//        200: createTokenResp
+//    default: errorResp
+func (o *Operation) CreateToken(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.CreateToken, rw, req)
+}
+
+// CreateInvitation swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/invitation kms createInvitationReq
+//
+// Creates a DIDComm out-of-band invitation with the public key of the ED25519 key, and optionally a capability sealed
+// for the recipient, as attachments. Available only if the server is started with a DIDComm mediator URL.
+//
+// Responses:
+//        200: createInvitationResp
+//    default: errorResp
+func (o *Operation) CreateInvitation(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.CreateInvitation, rw, req)
+}
+
 // Sign swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/sign crypto signReq
 //
-// Signs a message.
+// Signs a message, or messages with a BBS+ signature.
 //
 // Responses:
 //        200: signResp
@@ -192,9 +622,88 @@ func (o *Operation) Sign(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.Sign, rw, req)
 }
 
+// SignBatch swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/sign/batch crypto signBatchReq
+//
+// Signs a batch of messages with a single authorization check. Signatures are returned in the order of messages.
+//
+// Responses:
+//        200: signBatchResp
+//    default: errorResp
+func (o *Operation) SignBatch(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SignBatch, rw, req)
+}
+
+// SignMultiKey swagger:route POST /v1/keystores/{key_store_id}/signmulti crypto signMultiKeyReq
+//
+// Signs messages with keys of the key store, e.g. the credentials of a bundle. Signatures are returned in the order
+// of items; if any key is missing or disabled, nothing is signed.
+//
+// Responses:
+//        200: signMultiKeyResp
+//    default: errorResp
+func (o *Operation) SignMultiKey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SignMultiKey, rw, req)
+}
+
+// SignJWT swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/signjwt crypto signJWTReq
+//
+// Signs JWT claims as a compact JWS. alg is chosen by the key type (EdDSA, ES256, ES384 or ES512). With detached, the
+// claims are signed unencoded (RFC 7797) and left out of the JWS.
+//
+// Responses:
+//        200: signJWTResp
+//    default: errorResp
+func (o *Operation) SignJWT(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SignJWT, rw, req)
+}
+
+// CreateUpload swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/uploads crypto createUploadReq
+//
+// Creates an upload of a payload too large for a sign request. The payload is uploaded in chunks of the chunk size
+// and signed once all chunks are uploaded. Responds with 422 if the key is neither an Ed25519 nor an ECDSA key.
+//
+// Responses:
+//        200: createUploadResp
+//    default: errorResp
+func (o *Operation) CreateUpload(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.CreateUpload, rw, req)
+}
+
+// PutUploadChunk swagger:route PUT /v1/keystores/{key_store_id}/keys/{key_id}/uploads/{upload_id} crypto putChunkReq
+//
+// Uploads a chunk of the payload at its offset. Chunks can be uploaded in any order and in parallel; uploading the
+// same chunk again succeeds, a different chunk at the same offset is rejected with 409.
+//
+// Responses:
+//        204: putChunkResp
+//    default: errorResp
+func (o *Operation) PutUploadChunk(rw http.ResponseWriter, req *http.Request) {
+	execute(func(w io.Writer, r io.Reader) error {
+		if err := o.cmd.PutUploadChunk(w, r); err != nil {
+			return err
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}, rw, req)
+}
+
+// SignUpload swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/uploads/{upload_id} crypto signUploadReq
+//
+// Signs the uploaded payload, hashing it one chunk at a time, and deletes the upload. Responds with 409 if a chunk is
+// missing. ECDSA signatures verify as signatures of the payload; Ed25519 keys sign with Ed25519ph.
+//
+// Responses:
+//        200: signUploadResp
+//    default: errorResp
+func (o *Operation) SignUpload(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SignUpload, rw, req)
+}
+
 // Verify swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/verify crypto verifyReq
 //
-// Verifies a signature.
+// Verifies a signature of a message, or a BBS+ signature of messages.
 //
 // Responses:
 //        200: verifyResp
@@ -203,6 +712,18 @@ func (o *Operation) Verify(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.Verify, rw, req)
 }
 
+// VerifyWithPublicKey swagger:route POST /v1/keystores/{key_store_id}/verify crypto verifyWithPublicKeyReq
+//
+// Verifies a signature with a public key of the request (raw or JWK) instead of a key of the key store. A signature
+// that doesn't verify is reported in the response, not as an error.
+//
+// Responses:
+//        200: verifyWithPublicKeyResp
+//    default: errorResp
+func (o *Operation) VerifyWithPublicKey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.Verify, rw, req)
+}
+
 // Encrypt swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/encrypt crypto encryptReq
 //
 // Encrypts a message with associated authenticated data.
@@ -280,7 +801,8 @@ func (o *Operation) VerifyMulti(rw http.ResponseWriter, req *http.Request) {
 
 // DeriveProof swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/deriveproof crypto deriveProofReq
 //
-// Creates a BBS+ signature proof for a list of revealed messages.
+// Creates a BBS+ signature proof for a list of revealed messages. Responds with 422 if a revealed index is out of
+// range of the messages or duplicated.
 //
 // Responses:
 //        200: deriveProofResp
@@ -302,7 +824,8 @@ func (o *Operation) VerifyProof(rw http.ResponseWriter, req *http.Request) {
 
 // WrapKey swagger:route POST /v1/keystores/{key_store_id}/wrap crypto wrapKeyReq
 //
-// Wraps CEK using ECDH-ES key wrapping (Anoncrypt).
+// Wraps CEK using ECDH-ES key wrapping (Anoncrypt), or ECDH-1PU key wrapping (Authcrypt) with the sender key set by
+// sender_kid.
 //
 // Responses:
 //        200: wrapKeyResp
@@ -333,6 +856,83 @@ func (o *Operation) UnwrapKey(rw http.ResponseWriter, req *http.Request) {
 	execute(o.cmd.UnwrapKey, rw, req)
 }
 
+// EncryptJWE swagger:route POST /v1/keystores/{key_store_id}/encryptjwe crypto encryptJWEReq
+//
+// Encrypts a plaintext as a JWE for recipient public keys with ECDH-ES+A256KW and A256GCM.
+//
+// Responses:
+//        200: encryptJWEResp
+//    default: errorResp
+func (o *Operation) EncryptJWE(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.EncryptJWE, rw, req)
+}
+
+// DecryptJWE swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/decryptjwe crypto decryptJWEReq
+//
+// Decrypts a JWE with the key as the recipient key.
+//
+// Responses:
+//        200: decryptJWEResp
+//    default: errorResp
+func (o *Operation) DecryptJWE(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.DecryptJWE, rw, req)
+}
+
+// DeriveKey swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/derive crypto deriveKeyReq
+//
+// Derives a key from the X25519 shared secret of the key and a peer public key with HKDF-SHA256.
+//
+// Responses:
+//        200: deriveKeyResp
+//    default: errorResp
+func (o *Operation) DeriveKey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.DeriveKey, rw, req)
+}
+
+// DeriveSubkey swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/subkeys crypto deriveSubkeyReq
+//
+// Derives a new key of the key store from the HMAC key with HKDF.
+//
+// Responses:
+//        200: deriveSubkeyResp
+//    default: errorResp
+func (o *Operation) DeriveSubkey(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.DeriveSubkey, rw, req)
+}
+
+// Easy swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/easy crypto easyReq
+//
+// Seals a payload for the peer's Curve25519 public key with the ED25519 key (DIDComm v1 crypto box).
+//
+// Responses:
+//        200: easyResp
+//    default: errorResp
+func (o *Operation) Easy(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.Easy, rw, req)
+}
+
+// EasyOpen swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/easyopen crypto easyOpenReq
+//
+// Unseals a ciphertext sealed with easy by the peer for the ED25519 key.
+//
+// Responses:
+//        200: easyOpenResp
+//    default: errorResp
+func (o *Operation) EasyOpen(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.EasyOpen, rw, req)
+}
+
+// SealOpen swagger:route POST /v1/keystores/{key_store_id}/keys/{key_id}/sealopen crypto sealOpenReq
+//
+// Decrypts a ciphertext sealed anonymously for the ED25519 key.
+//
+// Responses:
+//        200: sealOpenResp
+//    default: errorResp
+func (o *Operation) SealOpen(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.SealOpen, rw, req)
+}
+
 // HealthCheck swagger:route GET /healthcheck server healthCheckReq
 //
 // Returns a health check status.
@@ -340,34 +940,118 @@ func (o *Operation) UnwrapKey(rw http.ResponseWriter, req *http.Request) {
 // Responses:
 //        200: healthCheckResp
 //    default: errorResp
-func (o *Operation) HealthCheck(rw http.ResponseWriter, _ *http.Request) {
+func (o *Operation) HealthCheck(rw http.ResponseWriter, req *http.Request) {
 	rw.Header().Set(contentType, applicationJSON)
 
-	err := json.NewEncoder(rw).Encode(map[string]interface{}{ //nolint: wrapcheck
+	resp := map[string]interface{}{
 		"status":       "success",
-		"current_time": time.Now(),
+		"current_time": o.clock.Now().UTC(),
+	}
+
+	if o.enableNoZCAP {
+		resp["no_zcap_key_stores"] = true
+	}
+
+	err := json.NewEncoder(rw).Encode(resp) //nolint: wrapcheck
+	if err != nil {
+		sendError(rw, req, fmt.Errorf("%w: encode health check response", errors.ErrInternal))
+	}
+}
+
+// Info swagger:route GET /info server infoReq
+//
+// Returns capabilities of the server: operations disabled with --disabled-operations and optional features.
+//
+// Responses:
+//        200: infoResp
+//    default: errorResp
+func (o *Operation) Info(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set(contentType, applicationJSON)
+
+	disabled := make([]string, 0, len(o.disabled))
+
+	for _, operation := range o.disabled {
+		if operation = strings.TrimSpace(operation); operation != "" {
+			disabled = append(disabled, operation)
+		}
+	}
+
+	sort.Strings(disabled)
+
+	err := json.NewEncoder(rw).Encode(map[string]interface{}{ //nolint: wrapcheck
+		"disabled_operations": disabled,
+		"no_zcap_key_stores":  o.enableNoZCAP,
+		"test_vectors":        o.enableTestVectors,
 	})
 	if err != nil {
-		sendError(rw, fmt.Errorf("%w: encode health check response", errors.ErrInternal))
+		sendError(rw, req, fmt.Errorf("%w: encode info response", errors.ErrInternal))
+	}
+}
+
+// TestVectors swagger:route GET /devel/test-vectors server testVectorsReq
+//
+// Returns signatures, JWKs, did:keys and fingerprints of fixed test keys of each key type, and a signed ZCAP
+// invocation, for testing client implementations. Served by development servers only.
+//
+// Responses:
+//        200: testVectorsResp
+//    default: errorResp
+func (o *Operation) TestVectors(rw http.ResponseWriter, req *http.Request) {
+	execute(o.cmd.TestVectors, rw, req)
+}
+
+// Validate returns a function that validates a request for the action without executing it. It is used to serve
+// dry-run requests.
+func (o *Operation) Validate(action string) func(req *http.Request) error {
+	return func(req *http.Request) error {
+		r, err := wrapRequest(req, selectsFields(action))
+		if err != nil {
+			return fmt.Errorf("wrap request: %w", err)
+		}
+
+		return o.cmd.Validate(action, bytes.NewBuffer(r))
+	}
+}
+
+// selectsFields reports whether the response of the action can be narrowed with the "fields" query parameter.
+func selectsFields(action string) bool {
+	switch action {
+	case command.ActionExportKey, command.ActionGetKey, command.ActionListKeys, command.ActionGetKeyStore,
+		command.ActionListKeyStores:
+		return true
+	default:
+		return false
 	}
 }
 
+// execute runs the command with the wrapped request. The "fields" query parameter is rejected, as the response of the
+// command can't be narrowed.
 func execute(exec command.Exec, rw http.ResponseWriter, req *http.Request) {
+	run(exec, rw, req, false)
+}
+
+// executeWithFields runs the command with the wrapped request, passing fields of the response selected by the
+// "fields" query parameter to the command.
+func executeWithFields(exec command.Exec, rw http.ResponseWriter, req *http.Request) {
+	run(exec, rw, req, true)
+}
+
+func run(exec command.Exec, rw http.ResponseWriter, req *http.Request, withFields bool) {
 	rw.Header().Set(contentType, applicationJSON)
 
-	r, err := wrapRequest(req)
+	r, err := wrapRequest(req, withFields)
 	if err != nil {
-		sendError(rw, fmt.Errorf("wrap request: %w", err))
+		sendError(rw, req, fmt.Errorf("wrap request: %w", err))
 
 		return
 	}
 
 	if err = exec(rw, bytes.NewBuffer(r)); err != nil {
-		sendError(rw, fmt.Errorf("%s %s: %w", req.Method, req.RequestURI, err))
+		sendError(rw, req, fmt.Errorf("%s %s: %w", req.Method, req.RequestURI, err))
 	}
 }
 
-func wrapRequest(req *http.Request) ([]byte, error) {
+func wrapRequest(req *http.Request, withFields bool) ([]byte, error) {
 	var buf bytes.Buffer
 
 	_, err := io.Copy(&buf, req.Body)
@@ -386,28 +1070,125 @@ func wrapRequest(req *http.Request) ([]byte, error) {
 		}
 	}
 
+	var fields []string
+
+	if f := req.URL.Query().Get(fieldsQueryParam); f != "" {
+		if !withFields {
+			return nil, fmt.Errorf("%w: %s query parameter is not supported by this endpoint", errors.ErrBadRequest,
+				fieldsQueryParam)
+		}
+
+		fields = strings.Split(f, ",")
+	}
+
+	var details bool
+
+	if d := req.URL.Query().Get(detailsQueryParam); d != "" {
+		details, err = strconv.ParseBool(d)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be true or false", errors.ErrBadRequest, detailsQueryParam)
+		}
+	}
+
 	vars := mux.Vars(req)
 
 	return json.Marshal(&command.WrappedRequest{
-		KeyStoreID:  vars[KeyStoreVarName],
-		KeyID:       vars[keyVarName],
-		User:        req.Header.Get(authUserHeader),
-		SecretShare: secret,
-		Request:     buf.Bytes(),
+		KeyStoreID:     vars[KeyStoreVarName],
+		KeyID:          vars[KeyVarName],
+		User:           req.Header.Get(authUserHeader),
+		Caller:         authmw.CallerFromContext(req.Context()),
+		SecretShare:    secret,
+		Fields:         fields,
+		Format:         req.URL.Query().Get(formatQueryParam),
+		IdempotencyKey: req.Header.Get(idempotencyHeader),
+		Details:        details,
+		UploadID:       vars[UploadVarName],
+		Request:        buf.Bytes(),
 	})
 }
 
 // ErrorResponse is an error response model.
 type ErrorResponse struct {
 	Message string `json:"message"`
+	// KeyURL is the URL of the key that conflicts with the request (e.g. uses the requested alias).
+	KeyURL string `json:"key_url,omitempty"`
+	// Code identifies the error for clients (e.g. KEY_DISABLED).
+	Code string `json:"code,omitempty"`
 }
 
-func sendError(rw http.ResponseWriter, e error) {
-	logger.Errorf("%v", e)
+// sendError logs the error with fields of the request and writes it to the response.
+func sendError(rw http.ResponseWriter, req *http.Request, e error) {
+	logger.Ctx(req.Context()).Errorf("%v", e)
+
+	status := errors.StatusCodeFromError(e)
+	resp := ErrorResponse{Message: e.Error()}
+
+	var openErr *breaker.OpenError
+
+	// the request failed fast, whatever the operation would have responded with the dependency unavailable
+	if stderrors.As(e, &openErr) {
+		status = http.StatusServiceUnavailable
+		resp.Code = breaker.Code
+
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.RetryAfter.Seconds()))))
+	}
+
+	rw.WriteHeader(status)
+
+	var conflictErr *command.AliasConflictError
+
+	if stderrors.As(e, &conflictErr) {
+		resp.KeyURL = conflictErr.KeyURL
+	}
+
+	var disabledErr *command.KeyDisabledError
 
-	rw.WriteHeader(errors.StatusCodeFromError(e))
+	if stderrors.As(e, &disabledErr) {
+		resp.KeyURL = disabledErr.KeyURL
+		resp.Code = command.KeyDisabledCode
+	}
+
+	var expiredErr *command.KeyExpiredError
+
+	if stderrors.As(e, &expiredErr) {
+		resp.KeyURL = expiredErr.KeyURL
+		resp.Code = command.KeyExpiredCode
+	}
+
+	var purposeErr *command.KeyPurposeError
+
+	if stderrors.As(e, &purposeErr) {
+		resp.KeyURL = purposeErr.KeyURL
+		resp.Code = command.KeyPurposeCode
+	}
+
+	var decryptErr *command.DecryptionFailedError
+
+	if stderrors.As(e, &decryptErr) {
+		resp.KeyURL = decryptErr.KeyURL
+		resp.Code = command.DecryptionFailedCode
+	}
+
+	var unknownDIDURLErr *command.UnknownDIDURLError
+
+	if stderrors.As(e, &unknownDIDURLErr) {
+		resp.Code = command.UnknownDIDURLCode
+	}
+
+	var vmConflictErr *command.VerificationMethodConflictError
+
+	if stderrors.As(e, &vmConflictErr) {
+		resp.KeyURL = vmConflictErr.KeyURL
+		resp.Code = command.VerificationMethodConflictCode
+	}
+
+	var schemaErr *command.SchemaVersionError
+
+	if stderrors.As(e, &schemaErr) {
+		resp.Code = command.SchemaVersionCode
+	}
 
-	if err := json.NewEncoder(rw).Encode(ErrorResponse{Message: e.Error()}); err != nil {
-		logger.Errorf("send error response: %v", err)
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		logger.Ctx(req.Context()).Errorf("send error response: %v", err)
 	}
 }
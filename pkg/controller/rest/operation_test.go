@@ -11,7 +11,9 @@ package rest_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,14 +21,23 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/stretchr/testify/require"
 
+	"github.com/trustbloc/kms/pkg/breaker"
 	"github.com/trustbloc/kms/pkg/controller/command"
+	kmserrors "github.com/trustbloc/kms/pkg/controller/errors"
+	"github.com/trustbloc/kms/pkg/controller/mw/authmw"
 	. "github.com/trustbloc/kms/pkg/controller/rest"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/metrics"
 )
 
 func TestOperation_CreateDID(t *testing.T) {
@@ -83,6 +94,22 @@ func TestOperation_CreateKeyStore(t *testing.T) {
 	require.Equal(t, http.StatusOK, handleRequest(t, op, KeyStorePath, http.MethodPost, bytes.NewBufferString(body)))
 }
 
+func TestOperation_CreateKeyStoreWithIdempotencyKey(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().CreateKeyStore(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var wr command.WrappedRequest
+
+		require.NoError(t, json.NewDecoder(r).Decode(&wr))
+		require.Equal(t, "retry-1", wr.IdempotencyKey)
+	}).Return(nil).Times(1)
+
+	op := New(cmd)
+
+	require.Equal(t, http.StatusOK, handleRequest(t, op, KeyStorePath, http.MethodPost,
+		bytes.NewBufferString(`{"controller": "did:example:test"}`), withHeader("Idempotency-Key", "retry-1")))
+}
+
 func TestOperation_CreateKey(t *testing.T) {
 	cmd := NewMockCmd(gomock.NewController(t))
 
@@ -103,58 +130,823 @@ func TestOperation_CreateKey(t *testing.T) {
 }
 
 func TestOperation_ImportKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ImportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.ImportKeyRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, kms.ED25519Type, req.KeyType)
+			require.Equal(t, []byte("key material"), req.Key)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		body := fmt.Sprintf(`{
+			"key": "%s",
+			"key_type": "ED25519"
+		}`, base64.StdEncoding.EncodeToString([]byte("key material")))
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, KeyPath, http.MethodPut, bytes.NewBufferString(body)))
+	})
+
+	t.Run("Not supported key type", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ImportKey(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: not supported key type", kmserrors.ErrUnprocessableEntity)).Times(1)
+
+		op := New(cmd)
+
+		body := `{"jwk": {"kty": "oct"}, "key_type": "AES256GCM"}`
+
+		require.Equal(t, http.StatusUnprocessableEntity,
+			handleRequest(t, op, KeyPath, http.MethodPut, bytes.NewBufferString(body)))
+	})
+}
+
+func TestOperation_CreateKeys(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().CreateKeys(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.CreateKeysRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Len(t, req.Keys, 2)
+			require.NoError(t, json.NewEncoder(w).Encode(command.CreateKeysResponse{
+				Keys:     []command.CreatedKey{{KeyURL: "key1"}, {KeyURL: "key2"}},
+				Sequence: 1,
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, BatchKeyPath, http.MethodPost,
+			bytes.NewBufferString(`{"keys":[{"key_type":"ED25519"},{"key_type":"HMACSHA256Tag256"}]}`)))
+	})
+
+	t.Run("Validation error", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().CreateKeys(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: number of keys must be from 1 to 20", kmserrors.ErrValidation)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusBadRequest, handleRequest(t, op, BatchKeyPath, http.MethodPost,
+			bytes.NewBufferString(`{"keys":[]}`)))
+	})
+}
+
+func TestOperation_SignBatch(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SignBatch(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.SignBatchRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, [][]byte{[]byte("message 1"), []byte("message 2")}, req.Messages)
+			require.NoError(t, json.NewEncoder(w).Encode(command.SignBatchResponse{
+				Signatures: [][]byte{[]byte("signature 1"), []byte("signature 2")},
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, SignBatchPath, http.MethodPost,
+			bytes.NewBufferString(`{"messages":["bWVzc2FnZSAx","bWVzc2FnZSAy"]}`)))
+	})
+
+	t.Run("Validation error", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SignBatch(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: number of messages must be from 1 to 100", kmserrors.ErrValidation)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusBadRequest, handleRequest(t, op, SignBatchPath, http.MethodPost,
+			bytes.NewBufferString(`{"messages":[]}`)))
+	})
+}
+
+func TestOperation_SignMultiKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SignMultiKey(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.SignMultiKeyRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, []command.SignMultiKeyItem{
+				{KeyID: "key1", Message: []byte("message 1")},
+				{KeyID: "key2", Message: []byte("message 2")},
+			}, req.Items)
+			require.NoError(t, json.NewEncoder(w).Encode(command.SignMultiKeyResponse{
+				Signatures: [][]byte{[]byte("signature 1"), []byte("signature 2")},
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, SignMultiKeyPath, http.MethodPost,
+			bytes.NewBufferString(`{"items":[{"key_id":"key1","message":"bWVzc2FnZSAx"},`+
+				`{"key_id":"key2","message":"bWVzc2FnZSAy"}]}`)))
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SignMultiKey(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("item 1: get key: %w: key key2", kmserrors.ErrNotFound)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNotFound, handleRequest(t, op, SignMultiKeyPath, http.MethodPost,
+			bytes.NewBufferString(`{"items":[{"key_id":"key1","message":"bWVzc2FnZSAx"},`+
+				`{"key_id":"key2","message":"bWVzc2FnZSAy"}]}`)))
+	})
+}
+
+func TestOperation_GetKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().GetKey(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			require.NoError(t, unwrapRequest(r, nil))
+			require.NoError(t, json.NewEncoder(w).Encode(command.GetKeyResponse{
+				KeyType:    string(kms.ED25519Type),
+				Exportable: true,
+				PublicKey:  []byte("public key"),
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, DeleteKeyPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().GetKey(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("get key: %w", kmserrors.ErrNotFound)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNotFound,
+			handleRequest(t, op, DeleteKeyPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+}
+
+func TestOperation_ExportKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ExportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			require.NoError(t, unwrapRequest(r, nil))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, ExportKeyPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+
+	t.Run("Success with selected fields", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ExportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.Equal(t, []string{"public_key", "key_type"}, wr.Fields)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, ExportKeyPath, http.MethodGet, bytes.NewReader(nil),
+			withQuery("fields=public_key,key_type")))
+	})
+
+	t.Run("Success with JWK format", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ExportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.Equal(t, command.ExportFormatJWK, wr.Format)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, ExportKeyPath, http.MethodGet, bytes.NewReader(nil),
+			withQuery("format=jwk")))
+	})
+}
+
+func TestOperation_GetKeyFingerprint(t *testing.T) {
 	cmd := NewMockCmd(gomock.NewController(t))
 
-	cmd.EXPECT().ImportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
-		var req command.ImportKeyRequest
+	cmd.EXPECT().GetKeyFingerprint(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		require.NoError(t, unwrapRequest(r, nil))
+	}).Return(nil).Times(1)
+
+	op := New(cmd)
+
+	require.Equal(t, http.StatusOK, handleRequest(t, op, FingerprintPath, http.MethodGet, bytes.NewReader(nil)))
+}
+
+func TestOperation_GetKeyStore(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().GetKeyStore(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			require.NoError(t, unwrapRequest(r, nil))
+			require.NoError(t, json.NewEncoder(w).Encode(command.GetKeyStoreResponse{
+				Controller:  "did:example:controller",
+				StorageType: command.StorageTypeEDV,
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+
+	t.Run("Key store not found", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().GetKeyStore(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("get key store: %w", kmserrors.ErrNotFound)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNotFound,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+}
+
+func TestOperation_DeleteKeyStore(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().DeleteKeyStore(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.Equal(t, "did:example:controller", wr.Caller)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNoContent,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodDelete, bytes.NewReader(nil),
+				withCaller("did:example:controller")))
+	})
+
+	t.Run("Caller is not the controller", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().DeleteKeyStore(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: only the controller can delete the key store", kmserrors.ErrForbidden)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusForbidden,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodDelete, bytes.NewReader(nil)))
+	})
+}
+
+func TestOperation_ListKeyStores(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ListKeyStores(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.ListKeyStoresRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, command.ListKeyStoresRequest{
+				Controller: "did:example:controller",
+				PageToken:  "key_store_id",
+				PageSize:   10,
+			}, req)
+			require.NoError(t, json.NewEncoder(w).Encode(command.ListKeyStoresResponse{
+				KeyStores: []command.KeyStoreInfo{{ID: "next_key_store_id", StorageType: command.StorageTypeLocal}},
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, KeyStorePath, http.MethodGet, bytes.NewReader(nil),
+				withQuery("controller=did:example:controller&page_token=key_store_id&page_size=10")))
+	})
+
+	t.Run("Fail with invalid page size", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusBadRequest,
+			handleRequest(t, op, KeyStorePath, http.MethodGet, bytes.NewReader(nil),
+				withQuery("controller=did:example:controller&page_size=ten")))
+	})
+
+	t.Run("Key stores with overrides", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ListKeyStores(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.ListKeyStoresRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, command.ListKeyStoresRequest{Overrides: true}, req)
+			require.NoError(t, json.NewEncoder(w).Encode(command.ListKeyStoresResponse{
+				KeyStores: []command.KeyStoreInfo{{
+					ID:        "key_store_id",
+					Overrides: &command.KeyStoreOverrides{CacheTTL: "1h0m0s"},
+				}},
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, KeyStorePath, http.MethodGet, bytes.NewReader(nil), withQuery("overrides=true")))
+	})
+
+	t.Run("Fail with invalid overrides", func(t *testing.T) {
+		op := New(NewMockCmd(gomock.NewController(t)))
+
+		require.Equal(t, http.StatusBadRequest,
+			handleRequest(t, op, KeyStorePath, http.MethodGet, bytes.NewReader(nil), withQuery("overrides=yes")))
+	})
+
+	t.Run("Is an admin operation", func(t *testing.T) {
+		h := handlerLookup(t, New(NewMockCmd(gomock.NewController(t))), KeyStorePath, http.MethodGet)
+
+		require.Equal(t, AuthAdmin, h.Auth())
+	})
+}
+
+func TestOperation_SetKeyStoreOverrides(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SetKeyStoreOverrides(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.SetKeyStoreOverridesRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, command.SetKeyStoreOverridesRequest{CacheTTL: "1h", RateLimit: 2.5, ConcurrencyLimit: 8},
+				req)
+			require.NoError(t, json.NewEncoder(w).Encode(command.SetKeyStoreOverridesResponse{
+				Overrides: &command.KeyStoreOverrides{CacheTTL: "1h0m0s", RateLimit: 2.5, ConcurrencyLimit: 8},
+				Sequence:  2,
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, OverridesPath, http.MethodPut,
+			bytes.NewBufferString(`{"cache_ttl":"1h","rate_limit":2.5,"concurrency_limit":8}`)))
+	})
+
+	t.Run("Fail with exceeded maximum", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SetKeyStoreOverrides(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: cache TTL must not exceed 1h0m0s", kmserrors.ErrValidation)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusBadRequest,
+			handleRequest(t, op, OverridesPath, http.MethodPut, bytes.NewBufferString(`{"cache_ttl":"2h"}`)))
+	})
+
+	t.Run("Is an admin operation", func(t *testing.T) {
+		h := handlerLookup(t, New(NewMockCmd(gomock.NewController(t))), OverridesPath, http.MethodPut)
+
+		require.Equal(t, AuthAdmin, h.Auth())
+	})
+}
+
+func TestOperation_UpdateKeyStore(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().UpdateKeyStore(gomock.Any(), gomock.Any()).Do(func(w io.Writer, r io.Reader) {
+			var req command.UpdateKeyStoreRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.Equal(t, "did:example:new-controller", req.Controller)
+			require.NoError(t, json.NewEncoder(w).Encode(command.UpdateKeyStoreResponse{
+				KeyStoreURL: "https://kms.example.com/v1/keystores/key_store_id",
+				Capability:  []byte("capability"),
+				Sequence:    2,
+			}))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodPatch,
+				bytes.NewBufferString(`{"controller":"did:example:new-controller"}`),
+				withCaller("did:example:controller")))
+	})
+
+	t.Run("Caller is not the controller", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().UpdateKeyStore(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: only the controller can update the key store", kmserrors.ErrForbidden)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusForbidden,
+			handleRequest(t, op, KeyStoreIDPath, http.MethodPatch,
+				bytes.NewBufferString(`{"controller":"did:example:new-controller"}`)))
+	})
+}
+
+func TestOperation_DeleteKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().DeleteKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			require.NoError(t, unwrapRequest(r, nil))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNoContent,
+			handleRequest(t, op, DeleteKeyPath, http.MethodDelete, bytes.NewReader(nil)))
+	})
+
+	t.Run("Key not found", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().DeleteKey(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("get key: %w", kmserrors.ErrNotFound)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNotFound,
+			handleRequest(t, op, DeleteKeyPath, http.MethodDelete, bytes.NewReader(nil)))
+	})
+}
+
+func TestOperation_UpdateKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().UpdateKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.UpdateKeyRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, "signing-key", req.Alias)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, DeleteKeyPath, http.MethodPatch, bytes.NewBufferString(`{"alias": "signing-key"}`)))
+	})
+
+	t.Run("Alias conflict returns URL of the key", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().UpdateKey(gomock.Any(), gomock.Any()).Return(fmt.Errorf("increment sequence: %w",
+			&command.AliasConflictError{Alias: "signing-key", KeyURL: "https://kms.example.com/keys/key_id"})).Times(1)
+
+		rr := httptest.NewRecorder()
+		New(cmd).UpdateKey(rr, httptest.NewRequest(http.MethodPatch, "/v1/keystores/ks/keys/other",
+			bytes.NewBufferString(`{"alias": "signing-key"}`)))
+
+		require.Equal(t, http.StatusConflict, rr.Code)
+
+		var resp ErrorResponse
+
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Equal(t, "https://kms.example.com/keys/key_id", resp.KeyURL)
+		require.Contains(t, resp.Message, `alias "signing-key" is used by key https://kms.example.com/keys/key_id`)
+	})
+}
+
+func TestOperation_SetKeyState(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SetKeyState(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.SetKeyStateRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, command.KeyStateDisabled, req.State)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK,
+			handleRequest(t, op, KeyStatePath, http.MethodPatch, bytes.NewBufferString(`{"state": "disabled"}`)))
+	})
+
+	t.Run("Disabled key returns error code", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Return(fmt.Errorf("get key handle: %w",
+			&command.KeyDisabledError{KeyURL: "https://kms.example.com/keys/key_id"})).Times(1)
+
+		rr := httptest.NewRecorder()
+		New(cmd).Sign(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/key_id/sign",
+			bytes.NewBufferString(`{"message": "dGVzdA=="}`)))
+
+		require.Equal(t, http.StatusConflict, rr.Code)
+
+		var resp ErrorResponse
+
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Equal(t, command.KeyDisabledCode, resp.Code)
+		require.Equal(t, "https://kms.example.com/keys/key_id", resp.KeyURL)
+		require.Contains(t, resp.Message, "key https://kms.example.com/keys/key_id is disabled")
+	})
+}
+
+func TestOperation_RestoreKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().RestoreKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.NotEmpty(t, wr.KeyID)
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, RestoreKeyPath, http.MethodPost, bytes.NewReader(nil)))
+	})
+
+	t.Run("Retention period ended", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().RestoreKey(gomock.Any(), gomock.Any()).Return(fmt.Errorf(
+			"restore key: %w: retention period of deleted key keyID ended", kmserrors.ErrNotFound)).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusNotFound,
+			handleRequest(t, op, RestoreKeyPath, http.MethodPost, bytes.NewReader(nil)))
+	})
+}
+
+func TestOperation_ListKeys(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+		cmd.EXPECT().ListKeys(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, KeyPath, http.MethodGet, bytes.NewReader(nil)))
+	})
+
+	t.Run("Success with unused_since", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().ListKeys(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.ListKeysRequest
+
+			require.NoError(t, unwrapRequest(r, &req))
+			require.True(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Equal(*req.UnusedSince))
+		}).Return(nil).Times(1)
+
+		op := New(cmd)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, KeyPath, http.MethodGet, bytes.NewReader(nil),
+			withQuery("unused_since=2022-01-01T00:00:00Z")))
+	})
+
+	t.Run("Fail with invalid unused_since", func(t *testing.T) {
+		op := New(NewMockCmd(gomock.NewController(t)))
+
+		require.Equal(t, http.StatusBadRequest, handleRequest(t, op, KeyPath, http.MethodGet, bytes.NewReader(nil),
+			withQuery("unused_since=2022-01-01")))
+	})
+}
+
+func TestOperation_KeyExpired(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Return(fmt.Errorf("get key handle: %w",
+		&command.KeyExpiredError{
+			KeyURL:    "https://kms.example.com/keys/key_id",
+			ExpiresAt: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC),
+		})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).Sign(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/key_id/sign",
+		bytes.NewBufferString(`{"message": "dGVzdA=="}`)))
+
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.KeyExpiredCode, resp.Code)
+	require.Equal(t, "https://kms.example.com/keys/key_id", resp.KeyURL)
+	require.Contains(t, resp.Message, "key https://kms.example.com/keys/key_id expired at 2022-06-01T12:00:00Z")
+}
+
+func TestOperation_KeyPurposeNotAllowed(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().WrapKey(gomock.Any(), gomock.Any()).Return(fmt.Errorf("resolve key store: %w",
+		&command.KeyPurposeError{
+			KeyURL:  "https://kms.example.com/keys/key_id",
+			Purpose: command.KeyPurposeWrap,
+		})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).WrapKeyAE(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/key_id/wrap",
+		bytes.NewBufferString(`{"cek": "Y2Vr"}`)))
+
+	require.Equal(t, http.StatusForbidden, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.KeyPurposeCode, resp.Code)
+	require.Equal(t, "https://kms.example.com/keys/key_id", resp.KeyURL)
+	require.Contains(t, resp.Message, `key https://kms.example.com/keys/key_id is not allowed for purpose "wrap"`)
+}
+
+func TestOperation_UnknownDIDURL(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Return(fmt.Errorf("resolve key store: %w",
+		&command.UnknownDIDURLError{DIDURL: "did:example:123#key-1"})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).Sign(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/did:example:123%23key-1/sign",
+		bytes.NewBufferString(`{"message": "dGVzdA=="}`)))
+
+	require.Equal(t, http.StatusNotFound, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.UnknownDIDURLCode, resp.Code)
+	require.Contains(t, resp.Message, "DID URL did:example:123#key-1 isn't registered for a key of the key store")
+}
+
+func TestOperation_VerificationMethodConflict(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().UpdateKey(gomock.Any(), gomock.Any()).Return(fmt.Errorf("increment sequence: %w",
+		&command.VerificationMethodConflictError{DIDURL: "did:example:123#key-1"})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).UpdateKey(rr, httptest.NewRequest(http.MethodPatch, "/v1/keystores/ks/keys/key_id",
+		bytes.NewBufferString(`{"verification_methods": ["did:example:123#key-1"]}`)))
+
+	require.Equal(t, http.StatusConflict, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.VerificationMethodConflictCode, resp.Code)
+	require.Empty(t, resp.KeyURL)
+	require.Contains(t, resp.Message, "DID URL did:example:123#key-1 is registered by another key store")
+}
+
+func TestOperation_DecryptionFailed(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().Decrypt(gomock.Any(), gomock.Any()).Return(fmt.Errorf("decrypt: %w",
+		&command.DecryptionFailedError{KeyURL: "https://kms.example.com/keys/key_id"})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).Decrypt(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/key_id/decrypt",
+		bytes.NewBufferString(`{"ciphertext": "Y2lwaGVydGV4dA==", "nonce": "bm9uY2U="}`)))
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.DecryptionFailedCode, resp.Code)
+	require.Equal(t, "https://kms.example.com/keys/key_id", resp.KeyURL)
+	require.Contains(t, resp.Message, "ciphertext can't be decrypted with key https://kms.example.com/keys/key_id")
+}
+
+func TestOperation_CreateToken(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().CreateToken(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.CreateTokenRequest
 		require.NoError(t, unwrapRequest(r, &req))
 
-		require.Equal(t, kms.ED25519Type, req.KeyType)
-		require.Equal(t, []byte("key material"), req.Key)
+		require.Equal(t, command.ActionVerify, req.Action)
+		require.EqualValues(t, 300, req.ExpiresIn)
+	}).Return(nil).Times(1)
+
+	op := New(cmd)
+
+	body := `{"action": "verify", "expires_in": 300}`
+
+	require.Equal(t, http.StatusOK, handleRequest(t, op, TokensPath, http.MethodPost, bytes.NewBufferString(body)))
+}
+
+func TestOperation_CreateInvitation(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().CreateInvitation(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.CreateInvitationRequest
+		require.NoError(t, unwrapRequest(r, &req))
+
+		require.Equal(t, "KMS", req.Label)
+		require.Equal(t, []byte("capability"), req.Capability)
+		require.Equal(t, []byte("their pub"), req.TheirPub)
+	}).Return(nil).Times(1)
+
+	op := New(cmd)
+
+	body := `{"label": "KMS", "capability": "Y2FwYWJpbGl0eQ==", "their_pub": "dGhlaXIgcHVi"}`
+
+	require.Equal(t, http.StatusOK, handleRequest(t, op, InvitationPath, http.MethodPost, bytes.NewBufferString(body)))
+}
+
+func TestOperation_Sign(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.SignRequest
+		require.NoError(t, unwrapRequest(r, &req))
+
+		require.Equal(t, []byte("test message"), req.Message)
 	}).Return(nil).Times(1)
 
 	op := New(cmd)
 
-	body := fmt.Sprintf(`{
-		"key": "%s",
-		"key_type": "ED25519"
-	}`, base64.StdEncoding.EncodeToString([]byte("key material")))
+	body := fmt.Sprintf(`{
+		"message": "%s"
+	}`, base64.StdEncoding.EncodeToString([]byte("test message")))
+
+	require.Equal(t, http.StatusOK, handleRequest(t, op, SignPath, http.MethodPost, bytes.NewBufferString(body)))
+}
+
+func TestOperation_SignWithDetails(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
 
-	require.Equal(t, http.StatusOK, handleRequest(t, op, KeyPath, http.MethodPut, bytes.NewBufferString(body)))
-}
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.True(t, wr.Details)
+		}).Return(nil).Times(1)
 
-func TestOperation_ExportKey(t *testing.T) {
-	cmd := NewMockCmd(gomock.NewController(t))
+		op := New(cmd)
 
-	cmd.EXPECT().ExportKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
-		require.NoError(t, unwrapRequest(r, nil))
-	}).Return(nil).Times(1)
+		require.Equal(t, http.StatusOK, handleRequest(t, op, SignPath, http.MethodPost,
+			bytes.NewBufferString(`{"message": "dGVzdA=="}`), withQuery("details=true")))
+	})
 
-	op := New(cmd)
+	t.Run("Fail with invalid details", func(t *testing.T) {
+		op := New(NewMockCmd(gomock.NewController(t)))
 
-	require.Equal(t, http.StatusOK, handleRequest(t, op, ExportKeyPath, http.MethodGet, bytes.NewReader(nil)))
+		require.Equal(t, http.StatusBadRequest, handleRequest(t, op, SignPath, http.MethodPost,
+			bytes.NewBufferString(`{"message": "dGVzdA=="}`), withQuery("details=yes")))
+	})
 }
 
-func TestOperation_Sign(t *testing.T) {
+func TestOperation_Verify(t *testing.T) {
 	cmd := NewMockCmd(gomock.NewController(t))
 
-	cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
-		var req command.SignRequest
+	cmd.EXPECT().Verify(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.VerifyRequest
 		require.NoError(t, unwrapRequest(r, &req))
 
+		require.Equal(t, []byte("signature"), req.Signature)
 		require.Equal(t, []byte("test message"), req.Message)
 	}).Return(nil).Times(1)
 
 	op := New(cmd)
 
 	body := fmt.Sprintf(`{
+		"signature": "%s",
 		"message": "%s"
-	}`, base64.StdEncoding.EncodeToString([]byte("test message")))
+	}`, base64.StdEncoding.EncodeToString([]byte("signature")),
+		base64.StdEncoding.EncodeToString([]byte("test message")))
 
-	require.Equal(t, http.StatusOK, handleRequest(t, op, SignPath, http.MethodPost, bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK, handleRequest(t, op, VerifyPath, http.MethodPost, bytes.NewBufferString(body)))
 }
 
-func TestOperation_Verify(t *testing.T) {
+func TestOperation_VerifyWithPublicKey(t *testing.T) {
 	cmd := NewMockCmd(gomock.NewController(t))
 
 	cmd.EXPECT().Verify(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
@@ -162,18 +954,23 @@ func TestOperation_Verify(t *testing.T) {
 		require.NoError(t, unwrapRequest(r, &req))
 
 		require.Equal(t, []byte("signature"), req.Signature)
-		require.Equal(t, []byte("test message"), req.Message)
+		require.Equal(t, []byte("public key"), req.PublicKey)
+		require.Equal(t, kms.ED25519Type, req.KeyType)
 	}).Return(nil).Times(1)
 
 	op := New(cmd)
 
 	body := fmt.Sprintf(`{
 		"signature": "%s",
-		"message": "%s"
+		"message": "%s",
+		"public_key": "%s",
+		"key_type": "ED25519"
 	}`, base64.StdEncoding.EncodeToString([]byte("signature")),
-		base64.StdEncoding.EncodeToString([]byte("test message")))
+		base64.StdEncoding.EncodeToString([]byte("test message")),
+		base64.StdEncoding.EncodeToString([]byte("public key")))
 
-	require.Equal(t, http.StatusOK, handleRequest(t, op, VerifyPath, http.MethodPost, bytes.NewBufferString(body)))
+	require.Equal(t, http.StatusOK,
+		handleRequest(t, op, VerifyPublicKeyPath, http.MethodPost, bytes.NewBufferString(body)))
 }
 
 func TestOperation_Encrypt(t *testing.T) {
@@ -436,6 +1233,225 @@ func TestOperation_SealOpen(t *testing.T) {
 	require.Equal(t, http.StatusOK, handleRequest(t, op, UnwrapKeyPath, http.MethodPost, bytes.NewBufferString(body)))
 }
 
+func TestOperation_SignJWT(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().SignJWT(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.SignJWTRequest
+		require.NoError(t, unwrapRequest(r, &req))
+
+		require.JSONEq(t, `{"iss": "did:example:issuer"}`, string(req.Claims))
+		require.Equal(t, map[string]interface{}{"typ": "JWT"}, req.Headers)
+		require.True(t, req.Detached)
+	}).Return(nil).Times(1)
+
+	body := `{"claims": {"iss": "did:example:issuer"}, "headers": {"typ": "JWT"}, "detached": true}`
+
+	require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), SignJWTPath, http.MethodPost,
+		bytes.NewBufferString(body)))
+}
+
+func TestOperation_JWE(t *testing.T) {
+	t.Run("Encrypt", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().EncryptJWE(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.EncryptJWERequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, []byte("plaintext"), req.Plaintext)
+			require.Len(t, req.Recipients, 1)
+			require.Equal(t, "P-256", req.Recipients[0].Curve)
+			require.Equal(t, "compact", req.Serialization)
+		}).Return(nil).Times(1)
+
+		body := `{"plaintext": "cGxhaW50ZXh0", "recipients": [{"curve": "P-256", "type": "EC"}],
+			"serialization": "compact"}`
+
+		require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), EncryptJWEPath, http.MethodPost,
+			bytes.NewBufferString(body)))
+	})
+
+	t.Run("Decrypt", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().DecryptJWE(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.DecryptJWERequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, "header.key.iv.ciphertext.tag", req.JWE)
+		}).Return(nil).Times(1)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), DecryptJWEPath, http.MethodPost,
+			bytes.NewBufferString(`{"jwe": "header.key.iv.ciphertext.tag"}`)))
+	})
+}
+
+func TestOperation_DeriveKey(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().DeriveKey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.DeriveKeyRequest
+		require.NoError(t, unwrapRequest(r, &req))
+
+		require.JSONEq(t, `{"kty": "OKP", "crv": "X25519", "x": "eA"}`, string(req.PeerPublicKey))
+		require.Equal(t, []byte("info"), req.KDF.Info)
+		require.Equal(t, 16, req.KDF.Length)
+		require.Equal(t, "X25519", req.Recipient.Curve)
+	}).Return(nil).Times(1)
+
+	body := `{"peer_public_key": {"kty": "OKP", "crv": "X25519", "x": "eA"}, "kdf": {"info": "aW5mbw==", "length": 16},
+		"recipient": {"curve": "X25519", "type": "OKP"}}`
+
+	require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), DeriveKeyPath, http.MethodPost,
+		bytes.NewBufferString(body)))
+}
+
+func TestOperation_DeriveSubkey(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().DeriveSubkey(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+		var req command.DeriveSubkeyRequest
+		require.NoError(t, unwrapRequest(r, &req))
+
+		require.Equal(t, []byte("salt"), req.Salt)
+		require.Equal(t, []byte("info"), req.Info)
+		require.Equal(t, kms.AES256GCMType, req.KeyType)
+		require.Equal(t, "tenant-index", req.Alias)
+	}).Return(nil).Times(1)
+
+	body := `{"salt": "c2FsdA==", "info": "aW5mbw==", "key_type": "AES256GCM", "alias": "tenant-index"}`
+
+	require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), DeriveSubkeyPath, http.MethodPost,
+		bytes.NewBufferString(body)))
+
+	h := handlerLookup(t, New(cmd), DeriveSubkeyPath, http.MethodPost)
+	require.Equal(t, command.ActionDeriveSubkey, h.Action())
+}
+
+func TestOperation_Uploads(t *testing.T) {
+	t.Run("Create upload", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().CreateUpload(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.CreateUploadRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, int64(1<<30), req.Size)
+			require.Equal(t, int64(8<<20), req.ChunkSize)
+		}).Return(nil).Times(1)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), UploadsPath, http.MethodPost,
+			bytes.NewBufferString(`{"size": 1073741824, "chunk_size": 8388608}`)))
+	})
+
+	t.Run("Put chunk", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().PutUploadChunk(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.Equal(t, "upload_id", wr.UploadID)
+
+			var req command.PutUploadChunkRequest
+			require.NoError(t, json.Unmarshal(wr.Request, &req))
+
+			require.Equal(t, int64(4), req.Offset)
+			require.Equal(t, []byte("data"), req.Chunk)
+		}).Return(nil).Times(1)
+
+		rr := serveRequestWithBody(t, New(cmd), UploadPath,
+			"/v1/keystores/key_store_id/keys/key_id/uploads/upload_id", http.MethodPut,
+			bytes.NewBufferString(`{"offset": 4, "chunk": "ZGF0YQ==", "checksum": "AA=="}`))
+		require.Equal(t, http.StatusNoContent, rr.Code)
+	})
+
+	t.Run("Put conflicting chunk", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().PutUploadChunk(gomock.Any(), gomock.Any()).
+			Return(fmt.Errorf("%w: a different chunk was already uploaded at the offset", kmserrors.ErrConflict)).
+			Times(1)
+
+		require.Equal(t, http.StatusConflict, handleRequest(t, New(cmd), UploadPath, http.MethodPut,
+			bytes.NewBufferString(`{"offset": 0}`)))
+	})
+
+	t.Run("Sign upload", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SignUpload(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var wr command.WrappedRequest
+			require.NoError(t, json.NewDecoder(r).Decode(&wr))
+			require.Equal(t, "upload_id", wr.UploadID)
+		}).Return(nil).Times(1)
+
+		rr := serveRequest(t, New(cmd), UploadPath, "/v1/keystores/key_store_id/keys/key_id/uploads/upload_id",
+			http.MethodPost)
+		require.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Routes are authorized with signUpload", func(t *testing.T) {
+		op := New(NewMockCmd(gomock.NewController(t)))
+
+		require.Equal(t, command.ActionSignUpload, handlerLookup(t, op, UploadsPath, http.MethodPost).Action())
+		require.Equal(t, command.ActionSignUpload, handlerLookup(t, op, UploadPath, http.MethodPut).Action())
+		require.Equal(t, command.ActionSignUpload, handlerLookup(t, op, UploadPath, http.MethodPost).Action())
+	})
+}
+
+func TestOperation_CryptoBoxKey(t *testing.T) {
+	t.Run("Easy", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().Easy(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.EasyRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, []byte("payload"), req.Payload)
+			require.Equal(t, []byte("nonce"), req.Nonce)
+			require.Equal(t, []byte("public key material"), req.TheirPub)
+		}).Return(nil).Times(1)
+
+		body := fmt.Sprintf(`{"payload": "%s", "nonce": "%s", "their_pub": "%s"}`,
+			base64.StdEncoding.EncodeToString([]byte("payload")),
+			base64.StdEncoding.EncodeToString([]byte("nonce")),
+			base64.StdEncoding.EncodeToString([]byte("public key material")))
+
+		require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), EasyPath, http.MethodPost,
+			bytes.NewBufferString(body)))
+	})
+
+	t.Run("EasyOpen", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().EasyOpen(gomock.Any(), gomock.Any()).Do(func(_ io.Writer, r io.Reader) {
+			var req command.EasyOpenRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, []byte("ciphertext"), req.Ciphertext)
+			require.Empty(t, req.MyPub)
+		}).Return(nil).Times(1)
+
+		body := fmt.Sprintf(`{"ciphertext": "%s"}`, base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+		require.Equal(t, http.StatusOK, handleRequest(t, New(cmd), EasyOpenPath, http.MethodPost,
+			bytes.NewBufferString(body)))
+	})
+
+	t.Run("SealOpen", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().SealOpen(gomock.Any(), gomock.Any()).Return(
+			fmt.Errorf("%w: crypto box requires an ED25519 key", kmserrors.ErrUnprocessableEntity)).Times(1)
+
+		body := fmt.Sprintf(`{"ciphertext": "%s"}`, base64.StdEncoding.EncodeToString([]byte("ciphertext")))
+
+		require.Equal(t, http.StatusUnprocessableEntity, handleRequest(t, New(cmd), SealOpenPath, http.MethodPost,
+			bytes.NewBufferString(body)))
+	})
+}
+
 func TestOperation_WrapKey(t *testing.T) {
 	cmd := NewMockCmd(gomock.NewController(t))
 
@@ -589,10 +1605,260 @@ func TestOperation_UnwrapKey(t *testing.T) {
 	require.Equal(t, http.StatusOK, handleRequest(t, op, UnwrapKeyPath, http.MethodPost, bytes.NewBufferString(body)))
 }
 
+func TestOperation_Validate(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+
+		cmd.EXPECT().Validate(command.ActionSign, gomock.Any()).DoAndReturn(func(_ string, r io.Reader) error {
+			var req command.SignRequest
+			require.NoError(t, unwrapRequest(r, &req))
+
+			require.Equal(t, []byte("test message"), req.Message)
+
+			return nil
+		}).Times(1)
+		cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Times(0)
+
+		body := fmt.Sprintf(`{"message": "%s"}`, base64.StdEncoding.EncodeToString([]byte("test message")))
+
+		err := New(cmd).Validate(command.ActionSign)(
+			httptest.NewRequest(http.MethodPost, SignPath, bytes.NewBufferString(body)))
+		require.NoError(t, err)
+	})
+
+	t.Run("Fail to wrap request", func(t *testing.T) {
+		cmd := NewMockCmd(gomock.NewController(t))
+		cmd.EXPECT().Validate(gomock.Any(), gomock.Any()).Times(0)
+
+		req := httptest.NewRequest(http.MethodPost, SignPath, bytes.NewBufferString("{}"))
+		req.Header.Set("Secret-Share", "not base64!")
+
+		err := New(cmd).Validate(command.ActionSign)(req)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "wrap request")
+	})
+}
+
 func TestOperation_HealthCheck(t *testing.T) {
-	op := New(nil)
+	t.Run("Success", func(t *testing.T) {
+		op := New(nil)
+
+		require.Equal(t, http.StatusOK, handleRequest(t, op, HealthCheckPath, http.MethodGet, bytes.NewBuffer(nil)))
+	})
+
+	t.Run("Current time from clock", func(t *testing.T) {
+		now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.FixedZone("EEST", 3*60*60))
+
+		op := New(nil, WithClock(testutil.NewFakeClock(now)))
+
+		rr := httptest.NewRecorder()
+		op.HealthCheck(rr, httptest.NewRequest(http.MethodGet, HealthCheckPath, nil))
+
+		var resp struct {
+			CurrentTime string `json:"current_time"`
+		}
+
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+		require.Equal(t, "2022-06-01T09:00:00Z", resp.CurrentTime)
+	})
+
+	t.Run("Key stores without ZCAPs are advertised", func(t *testing.T) {
+		for _, enabled := range []bool{true, false} {
+			op := New(nil, WithNoZCAPKeyStores(enabled))
+
+			rr := httptest.NewRecorder()
+			op.HealthCheck(rr, httptest.NewRequest(http.MethodGet, HealthCheckPath, nil))
+
+			var resp struct {
+				NoZCAPKeyStores bool `json:"no_zcap_key_stores"`
+			}
+
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+			require.Equal(t, enabled, resp.NoZCAPKeyStores)
+		}
+	})
+}
+
+func TestOperation_Info(t *testing.T) {
+	info := func(t *testing.T, op *Operation) map[string]interface{} {
+		t.Helper()
+
+		rr := httptest.NewRecorder()
+		op.Info(rr, httptest.NewRequest(http.MethodGet, InfoPath, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var resp map[string]interface{}
+
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+
+		return resp
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		require.Equal(t, map[string]interface{}{
+			"disabled_operations": []interface{}{},
+			"no_zcap_key_stores":  false,
+			"test_vectors":        false,
+		}, info(t, New(nil)))
+	})
+
+	t.Run("Disabled operations and features", func(t *testing.T) {
+		op := New(nil, WithDisabledOperations([]string{"wrap", " exportKey", ""}), WithNoZCAPKeyStores(true),
+			WithTestVectors(true))
+
+		require.Equal(t, map[string]interface{}{
+			"disabled_operations": []interface{}{"exportKey", "wrap"},
+			"no_zcap_key_stores":  true,
+			"test_vectors":        true,
+		}, info(t, op))
+	})
+
+	t.Run("Routed without auth", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, handleRequest(t, New(nil), InfoPath, http.MethodGet, bytes.NewBuffer(nil)))
+	})
+}
+
+func TestOperation_Fields(t *testing.T) {
+	op := New(newFieldsCmd(t))
+
+	tests := []struct {
+		name   string
+		path   string
+		url    string
+		query  string
+		list   string
+		fields []string
+	}{
+		{
+			name:   "Get key store",
+			path:   KeyStoreIDPath,
+			url:    "/v1/keystores/key_store_id",
+			query:  "fields=controller,key_count",
+			fields: []string{"controller", "key_count"},
+		},
+		{
+			name:   "List key stores",
+			path:   KeyStorePath,
+			url:    "/v1/keystores",
+			query:  "controller=did:example:controller&fields=id,storage_type",
+			list:   "key_stores",
+			fields: []string{"id", "storage_type"},
+		},
+		{
+			name:   "Get key",
+			path:   DeleteKeyPath,
+			url:    "/v1/keystores/key_store_id/keys/key_id",
+			query:  "fields=key_type,public_key",
+			fields: []string{"key_type", "public_key"},
+		},
+		{
+			name:   "List keys",
+			path:   KeyPath,
+			url:    "/v1/keystores/key_store_id/keys",
+			query:  "fields=key_url,alias",
+			list:   "keys",
+			fields: []string{"key_url", "alias"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serveRequest(t, op, tt.path, tt.url, http.MethodGet, withQuery(tt.query))
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			var resp map[string]json.RawMessage
+
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+			obj := resp
+
+			if tt.list != "" {
+				var items []map[string]json.RawMessage
+
+				require.NoError(t, json.Unmarshal(resp[tt.list], &items))
+				require.Len(t, items, 1)
+
+				obj = items[0]
+			}
+
+			require.ElementsMatch(t, tt.fields, keysOf(obj))
+		})
+
+		t.Run(tt.name+" with unknown field", func(t *testing.T) {
+			rr := serveRequest(t, op, tt.path, tt.url, http.MethodGet, withQuery(tt.query+",secret"))
+			require.Equal(t, http.StatusBadRequest, rr.Code)
+			require.Contains(t, rr.Body.String(), `unknown field \"secret\"`)
+		})
+	}
+
+	t.Run("List keys keeps sequence", func(t *testing.T) {
+		rr := serveRequest(t, op, KeyPath, "/v1/keystores/key_store_id/keys", http.MethodGet,
+			withQuery("fields=alias"))
+		require.Equal(t, http.StatusOK, rr.Code)
+		require.JSONEq(t, `{"keys":[{"alias":"signing"}],"sequence":1}`, rr.Body.String())
+	})
+}
+
+// newFieldsCmd returns a command with a key store of did:example:controller that has an Ed25519 key.
+func newFieldsCmd(t *testing.T) *command.Command {
+	t.Helper()
+
+	storageProvider := mem.NewProvider()
+
+	cmd, err := command.New(&command.Config{
+		StorageProvider:    storageProvider,
+		KeyStorageProvider: storageProvider,
+		KeyStoreCreator: &keyStoreCreator{km: &mockkms.KeyManager{
+			ExportPubKeyBytesValue: []byte("public key"),
+			ExportPubKeyTypeValue:  kms.ED25519Type,
+		}},
+		BaseKeyStoreURL: "https://kms.example.com/v1/keystores",
+		MetricsProvider: metrics.Get(),
+	})
+	require.NoError(t, err)
+
+	meta, err := json.Marshal(map[string]interface{}{
+		"id":         "key_store_id",
+		"controller": "did:example:controller",
+		"created_at": time.Now(),
+		"sequence":   1,
+		"key_ids":    []string{"key_id"},
+		"aliases":    map[string]string{"signing": "key_id"},
+		"keys": map[string]interface{}{
+			"key_id": map[string]interface{}{"key_type": kms.ED25519Type, "created_at": time.Now()},
+		},
+	})
+	require.NoError(t, err)
+
+	keyStores, err := storageProvider.OpenStore("keystores")
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("did:example:controller"))
 
-	require.Equal(t, http.StatusOK, handleRequest(t, op, HealthCheckPath, http.MethodGet, bytes.NewBuffer(nil)))
+	require.NoError(t, keyStores.Put("key_store_id", meta,
+		storage.Tag{Name: "controller_hash", Value: hex.EncodeToString(sum[:])}))
+
+	return cmd
+}
+
+type keyStoreCreator struct {
+	km kms.KeyManager
+}
+
+func (c *keyStoreCreator) Create(string, kms.Provider) (kms.KeyManager, error) {
+	return c.km, nil
+}
+
+func keysOf(obj map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(obj))
+
+	for k := range obj {
+		keys = append(keys, k)
+	}
+
+	return keys
 }
 
 func unwrapRequest(r io.Reader, req interface{}) error {
@@ -609,14 +1875,53 @@ func unwrapRequest(r io.Reader, req interface{}) error {
 	return nil
 }
 
-func handleRequest(t *testing.T, op *Operation, path, method string, body io.Reader) int {
+type requestOption func(r *http.Request)
+
+func withQuery(query string) requestOption {
+	return func(r *http.Request) {
+		r.URL.RawQuery = query
+	}
+}
+
+func withHeader(name, value string) requestOption {
+	return func(r *http.Request) {
+		r.Header.Set(name, value)
+	}
+}
+
+func withCaller(caller string) requestOption {
+	return func(r *http.Request) {
+		*r = *r.WithContext(authmw.WithCaller(r.Context(), caller))
+	}
+}
+
+func handleRequest(t *testing.T, op *Operation, path, method string, body io.Reader, opts ...requestOption) int {
+	t.Helper()
+
+	return serveRequestWithBody(t, op, path, path, method, body, opts...).Code
+}
+
+// serveRequest sends a request without a body to the url served by the handler of the path.
+func serveRequest(t *testing.T, op *Operation, path, url, method string,
+	opts ...requestOption) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return serveRequestWithBody(t, op, path, url, method, bytes.NewReader(nil), opts...)
+}
+
+func serveRequestWithBody(t *testing.T, op *Operation, path, url, method string, body io.Reader,
+	opts ...requestOption) *httptest.ResponseRecorder {
 	t.Helper()
 
 	handler := handlerLookup(t, op, path, method)
 
-	req, err := http.NewRequestWithContext(context.Background(), handler.Method(), handler.Path(), body)
+	req, err := http.NewRequestWithContext(context.Background(), handler.Method(), url, body)
 	require.NoError(t, err)
 
+	for _, opt := range opts {
+		opt(req)
+	}
+
 	router := mux.NewRouter()
 
 	router.HandleFunc(handler.Path(), handler.Handler()).Methods(handler.Method())
@@ -625,7 +1930,7 @@ func handleRequest(t *testing.T, op *Operation, path, method string, body io.Rea
 
 	router.ServeHTTP(rr, req)
 
-	return rr.Code
+	return rr
 }
 
 func handlerLookup(t *testing.T, op *Operation, path, method string) Handler {
@@ -650,3 +1955,40 @@ type failingReader struct{}
 func (*failingReader) Read(_ []byte) (n int, err error) {
 	return 0, errors.New("read error")
 }
+
+func TestOperation_SchemaVersionUnsupported(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().GetKeyStore(gomock.Any(), gomock.Any()).Return(fmt.Errorf("get key store meta: %w",
+		&command.SchemaVersionError{Record: "key store ks", Version: command.SchemaVersion + 1})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).GetKeyStore(rr, httptest.NewRequest(http.MethodGet, "/v1/keystores/ks", nil))
+
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, command.SchemaVersionCode, resp.Code)
+	require.Contains(t, resp.Message, fmt.Sprintf("key store ks has schema version %d", command.SchemaVersion+1))
+}
+
+func TestOperation_DependencyUnavailable(t *testing.T) {
+	cmd := NewMockCmd(gomock.NewController(t))
+
+	cmd.EXPECT().Sign(gomock.Any(), gomock.Any()).Return(fmt.Errorf("fetch secret share: %w",
+		&breaker.OpenError{Dependency: breaker.DependencyHubAuth, RetryAfter: 1500 * time.Millisecond})).Times(1)
+
+	rr := httptest.NewRecorder()
+	New(cmd).Sign(rr, httptest.NewRequest(http.MethodPost, "/v1/keystores/ks/keys/k/sign", nil))
+
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "2", rr.Header().Get("Retry-After"))
+
+	var resp ErrorResponse
+
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&resp))
+	require.Equal(t, breaker.Code, resp.Code)
+	require.Contains(t, resp.Message, "fetch secret share: dependency hub-auth is unavailable: circuit breaker is open")
+}
@@ -0,0 +1,199 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cryptopool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const (
+	namespace = "kms"
+	subsystem = "crypto_pool"
+)
+
+// Class is a cost class of crypto operations.
+type Class string
+
+const (
+	// ClassCheap operations (e.g. Ed25519 and ECDSA) take microseconds.
+	ClassCheap Class = "cheap"
+	// ClassExpensive operations (BBS+ and RSA) are 10-50x more expensive than cheap ones.
+	ClassExpensive Class = "expensive"
+)
+
+// ErrQueueFull is returned when all workers of the expensive pool are busy and its queue is full.
+var ErrQueueFull = errors.New("too many expensive crypto operations in progress")
+
+// ClassOf returns the cost class of operations with keys of the key type. Unknown key types are cheap.
+func ClassOf(kt kms.KeyType) Class {
+	switch kt { //nolint:exhaustive
+	case kms.BLS12381G2Type, kms.RSARS256Type, kms.RSAPS256Type:
+		return ClassExpensive
+	default:
+		return ClassCheap
+	}
+}
+
+// Config configures Pools.
+type Config struct {
+	// CheapWorkers is the number of cheap operations that run at once. Zero means no limit.
+	CheapWorkers int
+	// ExpensiveWorkers is the number of expensive operations that run at once. Zero means no limit.
+	ExpensiveWorkers int
+	// ExpensiveQueueSize is the number of expensive operations that wait for a worker. Operations beyond it are
+	// rejected with ErrQueueFull. Cheap operations always wait.
+	ExpensiveQueueSize int
+	// Clock measures the time operations wait for a worker. Defaults to system time.
+	Clock clock.Clock
+}
+
+// Pools run crypto operations in pools of workers per cost class, so that a burst of expensive operations can't
+// consume every CPU and starve cheap ones.
+type Pools struct {
+	cheap     *pool
+	expensive *pool
+}
+
+// New returns new Pools.
+func New(config Config) *Pools {
+	m := getMetrics()
+
+	if config.Clock == nil {
+		config.Clock = clock.Real()
+	}
+
+	return &Pools{
+		cheap:     newPool(ClassCheap, config.CheapWorkers, -1, m, config.Clock),
+		expensive: newPool(ClassExpensive, config.ExpensiveWorkers, config.ExpensiveQueueSize, m, config.Clock),
+	}
+}
+
+// Do runs the operation with a key of the key type in a worker of the pool of its cost class, and returns the error
+// of the operation. It fails with ErrQueueFull without running the operation if the pool can't queue it.
+func (p *Pools) Do(kt kms.KeyType, op func() error) error {
+	if ClassOf(kt) == ClassExpensive {
+		return p.expensive.do(op)
+	}
+
+	return p.cheap.do(op)
+}
+
+type pool struct {
+	class     Class
+	workers   chan struct{} // nil if the number of workers is not limited
+	queueSize int64         // negative if the queue is not limited
+	queued    int64
+	metrics   *poolMetrics
+	clock     clock.Clock
+}
+
+func newPool(class Class, workers, queueSize int, m *poolMetrics, clk clock.Clock) *pool {
+	p := &pool{
+		class:     class,
+		queueSize: int64(queueSize),
+		metrics:   m,
+		clock:     clk,
+	}
+
+	if workers > 0 {
+		p.workers = make(chan struct{}, workers)
+	}
+
+	return p
+}
+
+func (p *pool) do(op func() error) error {
+	if p.workers == nil {
+		return op()
+	}
+
+	if err := p.acquire(); err != nil {
+		return err
+	}
+
+	defer func() { <-p.workers }()
+
+	return op()
+}
+
+func (p *pool) acquire() error {
+	select {
+	case p.workers <- struct{}{}:
+		p.metrics.waitTime.WithLabelValues(string(p.class)).Observe(0)
+
+		return nil
+	default:
+	}
+
+	queued := atomic.AddInt64(&p.queued, 1)
+	if p.queueSize >= 0 && queued > p.queueSize {
+		p.metrics.queueDepth.WithLabelValues(string(p.class)).Set(float64(atomic.AddInt64(&p.queued, -1)))
+		p.metrics.rejected.WithLabelValues(string(p.class)).Inc()
+
+		return ErrQueueFull
+	}
+
+	p.metrics.queueDepth.WithLabelValues(string(p.class)).Set(float64(queued))
+
+	startTime := p.clock.Now()
+
+	p.workers <- struct{}{}
+
+	p.metrics.queueDepth.WithLabelValues(string(p.class)).Set(float64(atomic.AddInt64(&p.queued, -1)))
+	p.metrics.waitTime.WithLabelValues(string(p.class)).Observe(p.clock.Now().Sub(startTime).Seconds())
+
+	return nil
+}
+
+var (
+	metricsOnce     sync.Once    //nolint:gochecknoglobals
+	metricsInstance *poolMetrics //nolint:gochecknoglobals
+)
+
+type poolMetrics struct {
+	queueDepth *prometheus.GaugeVec
+	waitTime   *prometheus.HistogramVec
+	rejected   *prometheus.CounterVec
+}
+
+func getMetrics() *poolMetrics {
+	metricsOnce.Do(func() {
+		m := &poolMetrics{
+			queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "queue_depth",
+				Help:      "The number of crypto operations waiting for a worker",
+			}, []string{"class"}),
+			waitTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "wait_seconds",
+				Help:      "The time crypto operations waited for a worker",
+			}, []string{"class"}),
+			rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: subsystem,
+				Name:      "rejected_count",
+				Help:      "The total number of crypto operations rejected because the queue was full",
+			}, []string{"class"}),
+		}
+
+		prometheus.MustRegister(m.queueDepth, m.waitTime, m.rejected)
+
+		metricsInstance = m
+	})
+
+	return metricsInstance
+}
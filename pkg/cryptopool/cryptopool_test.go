@@ -0,0 +1,126 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package cryptopool_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/cryptopool"
+)
+
+func TestClassOf(t *testing.T) {
+	require.Equal(t, cryptopool.ClassExpensive, cryptopool.ClassOf(kms.BLS12381G2Type))
+	require.Equal(t, cryptopool.ClassExpensive, cryptopool.ClassOf(kms.RSAPS256Type))
+	require.Equal(t, cryptopool.ClassCheap, cryptopool.ClassOf(kms.ED25519Type))
+	require.Equal(t, cryptopool.ClassCheap, cryptopool.ClassOf(kms.ECDSAP256TypeIEEEP1363))
+	require.Equal(t, cryptopool.ClassCheap, cryptopool.ClassOf(""))
+}
+
+func TestPools_Do(t *testing.T) {
+	t.Run("Return error of the operation", func(t *testing.T) {
+		pools := cryptopool.New(cryptopool.Config{CheapWorkers: 1, ExpensiveWorkers: 1})
+
+		require.EqualError(t, pools.Do(kms.ED25519Type, func() error { return errors.New("sign error") }),
+			"sign error")
+		require.NoError(t, pools.Do(kms.BLS12381G2Type, func() error { return nil }))
+	})
+
+	t.Run("Reject expensive operations when the queue is full", func(t *testing.T) {
+		pools := cryptopool.New(cryptopool.Config{CheapWorkers: 1, ExpensiveWorkers: 1})
+
+		running, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+
+		go func() {
+			done <- pools.Do(kms.BLS12381G2Type, func() error {
+				close(running)
+				<-release
+
+				return nil
+			})
+		}()
+
+		<-running
+
+		require.ErrorIs(t, pools.Do(kms.RSARS256Type, func() error { return nil }), cryptopool.ErrQueueFull)
+
+		// cheap operations run in their own pool, even while expensive ones are rejected
+		require.NoError(t, pools.Do(kms.ED25519Type, func() error { return nil }))
+
+		close(release)
+		require.NoError(t, <-done)
+
+		require.NoError(t, pools.Do(kms.BLS12381G2Type, func() error { return nil }))
+	})
+
+	t.Run("Queue expensive operations", func(t *testing.T) {
+		pools := cryptopool.New(cryptopool.Config{ExpensiveWorkers: 1, ExpensiveQueueSize: 10})
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				require.NoError(t, pools.Do(kms.BLS12381G2Type, func() error { return nil }))
+			}()
+		}
+
+		wg.Wait()
+	})
+
+	t.Run("Cheap operations wait for a worker", func(t *testing.T) {
+		pools := cryptopool.New(cryptopool.Config{CheapWorkers: 2})
+
+		var (
+			wg      sync.WaitGroup
+			mutex   sync.Mutex
+			running int
+			maxSeen int
+		)
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				require.NoError(t, pools.Do(kms.ED25519Type, func() error {
+					mutex.Lock()
+					running++
+					if running > maxSeen {
+						maxSeen = running
+					}
+					mutex.Unlock()
+
+					mutex.Lock()
+					running--
+					mutex.Unlock()
+
+					return nil
+				}))
+			}()
+		}
+
+		wg.Wait()
+
+		require.LessOrEqual(t, maxSeen, 2)
+	})
+
+	t.Run("No limits", func(t *testing.T) {
+		pools := cryptopool.New(cryptopool.Config{})
+
+		require.NoError(t, pools.Do(kms.BLS12381G2Type, func() error { return nil }))
+		require.NoError(t, pools.Do(kms.ED25519Type, func() error { return nil }))
+	})
+}
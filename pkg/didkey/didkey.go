@@ -0,0 +1,206 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package didkey builds did:key identifiers (https://w3c-ccg.github.io/did-method-key/) of public keys in the format
+// they are exported from the KMS. It is the single place where kms-server, its tests and clients turn public keys
+// into did:keys, so that all of them support the same key types.
+package didkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
+)
+
+const (
+	ed25519KeySize = 32
+	x25519KeySize  = 32
+	bls12381G2Size = 96
+)
+
+// ErrUnsupportedKeyType is returned for key types that have no did:key representation.
+var ErrUnsupportedKeyType = errors.New("key type is not supported by did:key")
+
+// DIDKey is a did:key of a public key and its verification method (the DID URL with the key fingerprint as a
+// fragment).
+type DIDKey struct {
+	DID                string
+	VerificationMethod string
+	// Fingerprint is the multibase (base58btc) encoded multicodec public key the did:key is made of.
+	Fingerprint string
+}
+
+// FromPublicKey returns the did:key of a public key exported from the KMS. Supported key types are ED25519, ECDSA
+// P-256, P-384 and P-521 (DER and IEEE P1363), NIST P-256, P-384 and P-521 ECDH-KW, X25519 ECDH-KW and BLS12381G2.
+func FromPublicKey(pub []byte, kt kms.KeyType) (*DIDKey, error) {
+	code, raw, err := fingerprintKey(pub, kt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDIDKey(code, raw), nil
+}
+
+// FromECDSAKey returns the did:key of an ECDSA public key.
+func FromECDSAKey(pub *ecdsa.PublicKey) (*DIDKey, error) {
+	if pub == nil || pub.Curve == nil {
+		return nil, errors.New("ecdsa public key is required")
+	}
+
+	code, err := curveCode(pub.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDIDKey(code, elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y)), nil
+}
+
+func newDIDKey(code uint64, raw []byte) *DIDKey {
+	did, vm := fingerprint.CreateDIDKeyByCode(code, raw)
+
+	return &DIDKey{
+		DID:                did,
+		VerificationMethod: vm,
+		Fingerprint:        fingerprint.KeyFingerprint(code, raw),
+	}
+}
+
+// PublicKey returns the raw public key of the did:key, or of its verification method. NIST P curve keys are
+// returned in compressed form.
+func PublicKey(didKey string) ([]byte, error) {
+	if i := strings.Index(didKey, "#"); i >= 0 {
+		didKey = didKey[:i]
+	}
+
+	pub, err := fingerprint.PubKeyFromDIDKey(didKey)
+	if err != nil {
+		return nil, fmt.Errorf("parse did:key: %w", err)
+	}
+
+	return pub, nil
+}
+
+// fingerprintKey returns the multicodec code of the key type and the raw public key the fingerprint is made of.
+func fingerprintKey(pub []byte, kt kms.KeyType) (uint64, []byte, error) { //nolint:gocyclo
+	switch kt { //nolint:exhaustive
+	case kms.ED25519Type:
+		if len(pub) != ed25519KeySize {
+			return 0, nil, fmt.Errorf("invalid %s public key size %d", kt, len(pub))
+		}
+
+		return fingerprint.ED25519PubKeyMultiCodec, pub, nil
+	case kms.BLS12381G2Type:
+		if len(pub) != bls12381G2Size {
+			return 0, nil, fmt.Errorf("invalid %s public key size %d", kt, len(pub))
+		}
+
+		return fingerprint.BLS12381g2PubKeyMultiCodec, pub, nil
+	case kms.X25519ECDHKWType:
+		key, err := unmarshalECDHKey(pub)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if len(key.X) != x25519KeySize {
+			return 0, nil, fmt.Errorf("invalid %s public key size %d", kt, len(key.X))
+		}
+
+		return fingerprint.X25519PubKeyMultiCodec, key.X, nil
+	case kms.ECDSAP256TypeIEEEP1363, kms.ECDSAP384TypeIEEEP1363, kms.ECDSAP521TypeIEEEP1363:
+		curve := ecdsaCurve(kt)
+
+		x, y := elliptic.Unmarshal(curve, pub)
+		if x == nil {
+			return 0, nil, fmt.Errorf("invalid %s public key", kt)
+		}
+
+		return ecdsaFingerprintKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+	case kms.ECDSAP256TypeDER, kms.ECDSAP384TypeDER, kms.ECDSAP521TypeDER:
+		key, err := x509.ParsePKIXPublicKey(pub)
+		if err != nil {
+			return 0, nil, fmt.Errorf("parse %s public key: %w", kt, err)
+		}
+
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != ecdsaCurve(kt) {
+			return 0, nil, fmt.Errorf("invalid %s public key", kt)
+		}
+
+		return ecdsaFingerprintKey(ecKey)
+	case kms.NISTP256ECDHKWType, kms.NISTP384ECDHKWType, kms.NISTP521ECDHKWType:
+		key, err := unmarshalECDHKey(pub)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		ecKey := &ecdsa.PublicKey{
+			Curve: ecdsaCurve(kt),
+			X:     new(big.Int).SetBytes(key.X),
+			Y:     new(big.Int).SetBytes(key.Y),
+		}
+
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return 0, nil, fmt.Errorf("invalid %s public key", kt)
+		}
+
+		return ecdsaFingerprintKey(ecKey)
+	default:
+		return 0, nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, kt)
+	}
+}
+
+func ecdsaFingerprintKey(pub *ecdsa.PublicKey) (uint64, []byte, error) {
+	code, err := curveCode(pub.Curve)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return code, elliptic.MarshalCompressed(pub.Curve, pub.X, pub.Y), nil
+}
+
+// unmarshalECDHKey unmarshals an ECDH-KW public key, which the KMS exports as a JSON crypto.PublicKey.
+func unmarshalECDHKey(pub []byte) (*crypto.PublicKey, error) {
+	var key crypto.PublicKey
+
+	if err := json.Unmarshal(pub, &key); err != nil {
+		return nil, fmt.Errorf("unmarshal ecdh public key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func curveCode(curve elliptic.Curve) (uint64, error) {
+	switch curve {
+	case elliptic.P256():
+		return fingerprint.P256PubKeyMultiCodec, nil
+	case elliptic.P384():
+		return fingerprint.P384PubKeyMultiCodec, nil
+	case elliptic.P521():
+		return fingerprint.P521PubKeyMultiCodec, nil
+	default:
+		return 0, fmt.Errorf("%w: curve %s", ErrUnsupportedKeyType, curve.Params().Name)
+	}
+}
+
+func ecdsaCurve(kt kms.KeyType) elliptic.Curve {
+	switch kt { //nolint:exhaustive
+	case kms.ECDSAP384TypeIEEEP1363, kms.ECDSAP384TypeDER, kms.NISTP384ECDHKWType:
+		return elliptic.P384()
+	case kms.ECDSAP521TypeIEEEP1363, kms.ECDSAP521TypeDER, kms.NISTP521ECDHKWType:
+		return elliptic.P521()
+	default:
+		return elliptic.P256()
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package didkey_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/didkey"
+)
+
+// Public keys of test vectors: Ed25519 from RFC 8032 (test 1), X25519 from RFC 7748 (Alice), P-256 and P-384 from
+// RFC 6979 (A.2.5, A.2.6). BLS12381G2 keys are not validated, so any 96 bytes make a vector.
+const (
+	ed25519Pub = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+	x25519Pub  = "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"
+	p256X      = "60fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6"
+	p256Y      = "7903fe1008b8bc99a41ae9e95628bc64f2f1b20c2d7e9f5177a3c294d4462299"
+	p384X      = "ec3a4e415b4e19a4568618029f427fa5da9a8bc4ae92e02e06aae5286b300c64def8f0ea9055866064a254515480bc13"
+	p384Y      = "8015d9b72d7d57244ea8ef9ac0c621896708a59367f9dfb9f54ca84b3f1c9db1288b231c3ae0d4fe7344fd2533264720"
+
+	ed25519DID = "did:key:z6MktwupdmLXVVqTzCw4i46r4uGyosGXRnR3XjN4Zq7oMMsw"
+	x25519DID  = "did:key:z6LSkdrX4EvewpktHBjvNxRDogPdC5iVF8LT3LPKefGAgi89"
+	p256DID    = "did:key:zDnaepBuvsQ8cpsWrVKw8fbpGpvPeNSjVPTWoq6cRqaYzBKVP"
+	p384DID    = "did:key:z82LkuBieyGShVBhvtE2zoiD6Kma4tJGFtkAhxR5pfkp5QPw4LutoYWhvQCnGjdVn14kujQ"
+	blsDID     = "did:key:zUC6EqdSftC9w3v8GNw383Bq9c1R5P8JNQaJ1FMmczE3rsqKncxbbGBm9RPRnKGjFPZeLUcSmECNnqF7QmTiDJg53SFW4x" +
+		"S7ASpsDFnFEzgkuzCiZc8iGwNB3QTcPesajq546Xg"
+)
+
+func TestFromPublicKey(t *testing.T) {
+	p256 := ecdsaKey(t, elliptic.P256(), p256X, p256Y)
+	p384 := ecdsaKey(t, elliptic.P384(), p384X, p384Y)
+
+	bls := make([]byte, 96)
+	for i := range bls {
+		bls[i] = byte(i)
+	}
+
+	tests := []struct {
+		kt  kms.KeyType
+		pub []byte
+		did string
+	}{
+		{kt: kms.ED25519Type, pub: decodeHex(t, ed25519Pub), did: ed25519DID},
+		{kt: kms.X25519ECDHKWType, pub: ecdhKey(t, decodeHex(t, x25519Pub), nil), did: x25519DID},
+		{kt: kms.ECDSAP256TypeIEEEP1363, pub: elliptic.Marshal(p256.Curve, p256.X, p256.Y), did: p256DID},
+		{kt: kms.ECDSAP256TypeDER, pub: derKey(t, p256), did: p256DID},
+		{kt: kms.NISTP256ECDHKWType, pub: ecdhKey(t, p256.X.Bytes(), p256.Y.Bytes()), did: p256DID},
+		{kt: kms.ECDSAP384TypeIEEEP1363, pub: elliptic.Marshal(p384.Curve, p384.X, p384.Y), did: p384DID},
+		{kt: kms.ECDSAP384TypeDER, pub: derKey(t, p384), did: p384DID},
+		{kt: kms.NISTP384ECDHKWType, pub: ecdhKey(t, p384.X.Bytes(), p384.Y.Bytes()), did: p384DID},
+		{kt: kms.BLS12381G2Type, pub: bls, did: blsDID},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(string(tc.kt), func(t *testing.T) {
+			didKey, err := didkey.FromPublicKey(tc.pub, tc.kt)
+			require.NoError(t, err)
+			require.Equal(t, tc.did, didKey.DID)
+			require.Equal(t, tc.did+"#"+strings.TrimPrefix(tc.did, "did:key:"), didKey.VerificationMethod)
+			require.Equal(t, strings.TrimPrefix(tc.did, "did:key:"), didKey.Fingerprint)
+		})
+	}
+}
+
+func TestFromPublicKey_KMSKeys(t *testing.T) {
+	localKMS, err := localkms.New("local-lock://test", &kmsProvider{
+		storageProvider: mem.NewProvider(),
+		secretLock:      &noop.NoLock{},
+	})
+	require.NoError(t, err)
+
+	for _, kt := range []kms.KeyType{
+		kms.ED25519Type,
+		kms.ECDSAP256TypeDER,
+		kms.ECDSAP384TypeIEEEP1363,
+		kms.ECDSAP521TypeDER,
+		kms.NISTP256ECDHKWType,
+		kms.NISTP384ECDHKWType,
+		kms.X25519ECDHKWType,
+		kms.BLS12381G2Type,
+	} {
+		t.Run(string(kt), func(t *testing.T) {
+			_, pub, err := localKMS.CreateAndExportPubKeyBytes(kt)
+			require.NoError(t, err)
+
+			didKey, err := didkey.FromPublicKey(pub, kt)
+			require.NoError(t, err)
+
+			b, err := didkey.PublicKey(didKey.VerificationMethod)
+			require.NoError(t, err)
+			require.NotEmpty(t, b)
+
+			if kt == kms.ED25519Type || kt == kms.BLS12381G2Type {
+				require.Equal(t, pub, b)
+			}
+		})
+	}
+}
+
+func TestFromPublicKey_Errors(t *testing.T) {
+	p256 := ecdsaKey(t, elliptic.P256(), p256X, p256Y)
+
+	tests := []struct {
+		name string
+		kt   kms.KeyType
+		pub  []byte
+		err  string
+	}{
+		{name: "Symmetric key", kt: kms.AES256GCMType, err: "key type is not supported by did:key: AES256GCM"},
+		{name: "Short ED25519 key", kt: kms.ED25519Type, pub: []byte("short"), err: "invalid ED25519 public key size 5"},
+		{name: "Short BLS12381G2 key", kt: kms.BLS12381G2Type, pub: []byte("short"), err: "invalid BLS12381G2 public key"},
+		{name: "Invalid IEEE P1363 key", kt: kms.ECDSAP256TypeIEEEP1363, pub: []byte("invalid"), err: "invalid"},
+		{name: "Invalid DER key", kt: kms.ECDSAP256TypeDER, pub: []byte("invalid"), err: "parse ECDSAP256DER"},
+		{name: "DER key of another curve", kt: kms.ECDSAP384TypeDER, pub: derKey(t, p256), err: "invalid"},
+		{name: "ECDH key not in JSON", kt: kms.NISTP256ECDHKWType, pub: []byte("invalid"), err: "unmarshal"},
+		{
+			name: "ECDH key not on curve",
+			kt:   kms.NISTP256ECDHKWType,
+			pub:  ecdhKey(t, p256.X.Bytes(), p256.X.Bytes()),
+			err:  "invalid NISTP256ECDHKW public key",
+		},
+		{
+			name: "Short X25519 key",
+			kt:   kms.X25519ECDHKWType,
+			pub:  ecdhKey(t, []byte("short"), nil),
+			err:  "invalid X25519ECDHKW public key size 5",
+		},
+	}
+
+	for _, tt := range tests {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := didkey.FromPublicKey(tc.pub, tc.kt)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	_, err := didkey.FromPublicKey(nil, kms.HMACSHA256Tag256Type)
+	require.True(t, errors.Is(err, didkey.ErrUnsupportedKeyType))
+}
+
+func TestFromECDSAKey(t *testing.T) {
+	didKey, err := didkey.FromECDSAKey(ecdsaKey(t, elliptic.P256(), p256X, p256Y))
+	require.NoError(t, err)
+	require.Equal(t, p256DID, didKey.DID)
+	require.Equal(t, strings.TrimPrefix(p256DID, "did:key:"), didKey.Fingerprint)
+
+	_, err = didkey.FromECDSAKey(nil)
+	require.EqualError(t, err, "ecdsa public key is required")
+
+	p224 := elliptic.P224()
+
+	_, err = didkey.FromECDSAKey(&ecdsa.PublicKey{Curve: p224, X: p224.Params().Gx, Y: p224.Params().Gy})
+	require.True(t, errors.Is(err, didkey.ErrUnsupportedKeyType))
+}
+
+func TestPublicKey(t *testing.T) {
+	pub, err := didkey.PublicKey(ed25519DID)
+	require.NoError(t, err)
+	require.Equal(t, decodeHex(t, ed25519Pub), pub)
+
+	pub, err = didkey.PublicKey(x25519DID + "#" + strings.TrimPrefix(x25519DID, "did:key:"))
+	require.NoError(t, err)
+	require.Equal(t, decodeHex(t, x25519Pub), pub)
+
+	_, err = didkey.PublicKey("did:example:123")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "parse did:key")
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+
+	return b
+}
+
+func ecdsaKey(t *testing.T, curve elliptic.Curve, x, y string) *ecdsa.PublicKey {
+	t.Helper()
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(decodeHex(t, x)),
+		Y:     new(big.Int).SetBytes(decodeHex(t, y)),
+	}
+}
+
+func derKey(t *testing.T, pub *ecdsa.PublicKey) []byte {
+	t.Helper()
+
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	return b
+}
+
+func ecdhKey(t *testing.T, x, y []byte) []byte {
+	t.Helper()
+
+	b, err := json.Marshal(&crypto.PublicKey{X: x, Y: y})
+	require.NoError(t, err)
+
+	return b
+}
+
+type kmsProvider struct {
+	storageProvider storage.Provider
+	secretLock      secretlock.Service
+}
+
+func (p *kmsProvider) StorageProvider() storage.Provider {
+	return p.storageProvider
+}
+
+func (p *kmsProvider) SecretLock() secretlock.Service {
+	return p.secretLock
+}
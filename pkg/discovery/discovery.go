@@ -0,0 +1,340 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const (
+	// SRVScheme is a URL scheme of service URLs resolved with DNS SRV records,
+	// e.g. dns+srv://_hub-auth._tcp.example.com/path?scheme=https.
+	SRVScheme = "dns+srv"
+
+	// DefaultTTL is used for SRV records if resolver does not report TTL.
+	DefaultTTL = 30 * time.Second
+
+	defaultTargetScheme = "https"
+	lookupTimeout       = 10 * time.Second
+	resolvConfPath      = "/etc/resolv.conf"
+)
+
+var logger = log.New("discovery")
+
+// Resolver looks up SRV records.
+type Resolver interface {
+	// LookupSRV returns SRV records for the name and how long they may be cached.
+	LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error)
+}
+
+// Option configures Endpoint and Registry.
+type Option func(o *options)
+
+type options struct {
+	clock clock.Clock
+}
+
+// WithClock sets the clock used to expire resolved targets.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{clock: clock.Real()}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// IsSRV checks if the URL should be resolved with DNS SRV records.
+func IsSRV(rawURL string) bool {
+	return strings.HasPrefix(rawURL, SRVScheme+"://")
+}
+
+// Endpoint is a service endpoint resolved from DNS SRV records. Targets are re-resolved in the background when TTL
+// expires, and requests are balanced across targets with the lowest priority in proportion to their weights.
+type Endpoint struct {
+	name     string
+	scheme   string
+	path     string
+	resolver Resolver
+	clock    clock.Clock
+
+	mutex      sync.Mutex
+	targets    []*target
+	expiresAt  time.Time
+	refreshing bool
+}
+
+type target struct {
+	url     string
+	weight  int
+	current int // smooth weighted round-robin state
+}
+
+// NewEndpoint parses dns+srv URL and resolves SRV records. It fails if initial resolution fails.
+func NewEndpoint(rawURL string, resolver Resolver, opts ...Option) (*Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	if u.Scheme != SRVScheme {
+		return nil, fmt.Errorf("not a %s url: %s", SRVScheme, rawURL)
+	}
+
+	scheme := u.Query().Get("scheme")
+	if scheme == "" {
+		scheme = defaultTargetScheme
+	}
+
+	if resolver == nil {
+		resolver = &NetResolver{}
+	}
+
+	e := &Endpoint{
+		name:     u.Host,
+		scheme:   scheme,
+		path:     u.Path,
+		resolver: resolver,
+		clock:    newOptions(opts).clock,
+	}
+
+	if err = e.refresh(); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// URL returns a base URL of the next target. If TTL has expired, targets are re-resolved in the background and the
+// last known targets are used meanwhile, so requests don't wait for DNS. A single lookup runs at a time.
+func (e *Endpoint) URL() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.refreshing && e.clock.Now().After(e.expiresAt) {
+		e.refreshing = true
+
+		go e.refreshInBackground()
+	}
+
+	return e.nextTarget()
+}
+
+// nextTarget selects a target with smooth weighted round-robin, which spreads picks of a target evenly.
+func (e *Endpoint) nextTarget() string {
+	var (
+		best  *target
+		total int
+	)
+
+	for _, t := range e.targets {
+		t.current += t.weight
+		total += t.weight
+
+		if best == nil || t.current > best.current {
+			best = t
+		}
+	}
+
+	best.current -= total
+
+	return best.url
+}
+
+func (e *Endpoint) refreshInBackground() {
+	if err := e.refresh(); err != nil {
+		logger.Warnf("Failed to re-resolve %s, using last known targets: %v", e.name, err)
+	}
+
+	e.mutex.Lock()
+	e.refreshing = false
+	e.mutex.Unlock()
+}
+
+func (e *Endpoint) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	records, ttl, err := e.resolver.LookupSRV(ctx, e.name)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if err == nil && len(records) == 0 {
+		err = errors.New("no records")
+	}
+
+	if err != nil {
+		if e.targets != nil {
+			// keep last known good targets and retry after TTL
+			e.expiresAt = e.clock.Now().Add(DefaultTTL)
+		}
+
+		return fmt.Errorf("lookup srv %s: %w", e.name, err)
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	e.targets = e.buildTargets(records)
+	e.expiresAt = e.clock.Now().Add(ttl)
+
+	return nil
+}
+
+// buildTargets returns targets of records with the lowest priority; other records are backups. Records with zero
+// weight are used only if all records have zero weight (RFC 2782), and then equally.
+func (e *Endpoint) buildTargets(srv []*net.SRV) []*target {
+	records := append([]*net.SRV(nil), srv...)
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	weighted := false
+
+	for _, r := range records {
+		if r.Priority != records[0].Priority {
+			break
+		}
+
+		weighted = weighted || r.Weight > 0
+	}
+
+	var targets []*target
+
+	for _, r := range records {
+		if r.Priority != records[0].Priority {
+			break
+		}
+
+		weight := int(r.Weight)
+		if !weighted {
+			weight = 1
+		}
+
+		if weight == 0 {
+			continue
+		}
+
+		host := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+
+		targets = append(targets, &target{
+			url:    (&url.URL{Scheme: e.scheme, Host: host, Path: e.path}).String(),
+			weight: weight,
+		})
+	}
+
+	return targets
+}
+
+// Registry resolves dns+srv URLs, caching endpoints per URL. Other URLs are returned as is.
+type Registry struct {
+	resolver  Resolver
+	opts      []Option
+	mutex     sync.Mutex
+	endpoints map[string]*Endpoint
+}
+
+// NewRegistry returns a new Registry. If resolver is nil, system DNS resolver is used.
+func NewRegistry(resolver Resolver, opts ...Option) *Registry {
+	return &Registry{
+		resolver:  resolver,
+		opts:      opts,
+		endpoints: make(map[string]*Endpoint),
+	}
+}
+
+// Resolve returns a URL of the service target.
+func (r *Registry) Resolve(rawURL string) (string, error) {
+	if !IsSRV(rawURL) {
+		return rawURL, nil
+	}
+
+	r.mutex.Lock()
+
+	e, ok := r.endpoints[rawURL]
+	if !ok {
+		var err error
+
+		e, err = NewEndpoint(rawURL, r.resolver, r.opts...)
+		if err != nil {
+			r.mutex.Unlock()
+
+			return "", err
+		}
+
+		r.endpoints[rawURL] = e
+	}
+
+	r.mutex.Unlock()
+
+	return e.URL(), nil
+}
+
+// NetResolver resolves SRV records with DNS servers of the system and reports the lowest TTL of the records. If the
+// servers can't be queried directly, e.g. without /etc/resolv.conf or when the response is truncated, records are
+// looked up with the system resolver, which doesn't expose TTLs, and DefaultTTL is reported.
+type NetResolver struct {
+	// Servers are addresses (host:port) of DNS servers to query. Defaults to the nameservers of /etc/resolv.conf.
+	Servers []string
+}
+
+// LookupSRV looks up SRV records for the name (e.g. _hub-auth._tcp.example.com).
+func (r *NetResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	servers := r.Servers
+	if servers == nil {
+		servers = systemNameservers(resolvConfPath)
+	}
+
+	var queryErr error
+
+	for _, server := range servers {
+		records, ttl, err := querySRV(ctx, server, name)
+		if err == nil {
+			return records, ttl, nil
+		}
+
+		if errors.Is(err, errNameNotFound) {
+			return nil, 0, fmt.Errorf("lookup srv: %w", err)
+		}
+
+		queryErr = err
+	}
+
+	if queryErr != nil {
+		logger.Debugf("Failed to query DNS servers for %s, using system resolver: %v", name, queryErr)
+	}
+
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, 0, fmt.Errorf("lookup srv: %w", err)
+	}
+
+	return records, DefaultTTL, nil
+}
@@ -0,0 +1,362 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/trustbloc/kms/pkg/discovery"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+func TestNewEndpoint(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		r := &mockResolver{records: []*net.SRV{
+			{Target: "auth-1.example.com.", Port: 8443, Priority: 10},
+			{Target: "auth-2.example.com.", Port: 8443, Priority: 10},
+			{Target: "auth-backup.example.com.", Port: 8443, Priority: 20},
+		}}
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com/api", r)
+		require.NoError(t, err)
+		require.Equal(t, "_hub-auth._tcp.example.com", r.lastName())
+
+		// balanced across targets with the lowest priority
+		require.Equal(t, "https://auth-1.example.com:8443/api", e.URL())
+		require.Equal(t, "https://auth-2.example.com:8443/api", e.URL())
+		require.Equal(t, "https://auth-1.example.com:8443/api", e.URL())
+	})
+
+	t.Run("Custom target scheme", func(t *testing.T) {
+		r := &mockResolver{records: []*net.SRV{{Target: "edv.local.", Port: 8080}}}
+
+		e, err := discovery.NewEndpoint("dns+srv://_edv._tcp.local?scheme=http", r)
+		require.NoError(t, err)
+		require.Equal(t, "http://edv.local:8080", e.URL())
+	})
+
+	t.Run("Fail with not dns+srv url", func(t *testing.T) {
+		_, err := discovery.NewEndpoint("https://example.com", &mockResolver{})
+		require.EqualError(t, err, "not a dns+srv url: https://example.com")
+	})
+
+	t.Run("Fail with initial resolution error", func(t *testing.T) {
+		_, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", &mockResolver{err: errors.New("timeout")})
+		require.EqualError(t, err, "lookup srv _hub-auth._tcp.example.com: timeout")
+	})
+
+	t.Run("Fail with no records", func(t *testing.T) {
+		_, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", &mockResolver{})
+		require.EqualError(t, err, "lookup srv _hub-auth._tcp.example.com: no records")
+	})
+}
+
+func TestEndpoint_URL(t *testing.T) {
+	t.Run("Re-resolves in the background when TTL expires", func(t *testing.T) {
+		r := &mockResolver{
+			records: []*net.SRV{{Target: "old.example.com.", Port: 443}},
+			ttl:     time.Minute,
+		}
+
+		clk := testutil.NewFakeClock(time.Now())
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", r, discovery.WithClock(clk))
+		require.NoError(t, err)
+		require.Equal(t, "https://old.example.com:443", e.URL())
+
+		r.set([]*net.SRV{{Target: "new.example.com.", Port: 443}}, nil)
+
+		clk.Advance(time.Minute - time.Second)
+		require.Equal(t, "https://old.example.com:443", e.URL())
+		require.Equal(t, 1, r.calls())
+
+		// the stale target is served while the lookup runs
+		clk.Advance(2 * time.Second)
+		require.Equal(t, "https://old.example.com:443", e.URL())
+
+		require.Eventually(t, func() bool {
+			return e.URL() == "https://new.example.com:443"
+		}, time.Second, time.Millisecond)
+		require.Equal(t, 2, r.calls())
+	})
+
+	t.Run("Single lookup at a time", func(t *testing.T) {
+		r := &mockResolver{
+			records: []*net.SRV{{Target: "auth.example.com.", Port: 443}},
+			ttl:     time.Minute,
+		}
+
+		clk := testutil.NewFakeClock(time.Now())
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", r, discovery.WithClock(clk))
+		require.NoError(t, err)
+
+		r.block()
+		clk.Advance(2 * time.Minute)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				require.Equal(t, "https://auth.example.com:443", e.URL())
+			}()
+		}
+
+		wg.Wait()
+		r.unblock()
+
+		require.Eventually(t, func() bool { return r.calls() == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("Balances by weight", func(t *testing.T) {
+		r := &mockResolver{records: []*net.SRV{
+			{Target: "heavy.example.com.", Port: 443, Priority: 10, Weight: 3},
+			{Target: "light.example.com.", Port: 443, Priority: 10, Weight: 1},
+			{Target: "unused.example.com.", Port: 443, Priority: 10, Weight: 0},
+			{Target: "backup.example.com.", Port: 443, Priority: 20, Weight: 10},
+		}}
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", r)
+		require.NoError(t, err)
+
+		counts := map[string]int{}
+
+		for i := 0; i < 40; i++ {
+			counts[e.URL()]++
+		}
+
+		require.Equal(t, map[string]int{
+			"https://heavy.example.com:443": 30,
+			"https://light.example.com:443": 10,
+		}, counts)
+	})
+
+	t.Run("Re-resolves with real clock", func(t *testing.T) {
+		r := &mockResolver{
+			records: []*net.SRV{{Target: "old.example.com.", Port: 443}},
+			ttl:     time.Millisecond,
+		}
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", r)
+		require.NoError(t, err)
+		require.Equal(t, "https://old.example.com:443", e.URL())
+
+		r.set([]*net.SRV{{Target: "new.example.com.", Port: 443}}, nil)
+
+		require.Eventually(t, func() bool {
+			return e.URL() == "https://new.example.com:443"
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Falls back to last known good targets", func(t *testing.T) {
+		r := &mockResolver{
+			records: []*net.SRV{{Target: "auth.example.com.", Port: 443}},
+			ttl:     time.Minute,
+		}
+
+		clk := testutil.NewFakeClock(time.Now())
+
+		e, err := discovery.NewEndpoint("dns+srv://_hub-auth._tcp.example.com", r, discovery.WithClock(clk))
+		require.NoError(t, err)
+
+		r.set(nil, errors.New("dns unavailable"))
+
+		clk.Advance(2 * time.Minute)
+		require.Equal(t, "https://auth.example.com:443", e.URL())
+		require.Eventually(t, func() bool { return r.calls() == 2 }, time.Second, time.Millisecond)
+
+		// failed lookup is retried after DefaultTTL
+		clk.Advance(discovery.DefaultTTL - time.Second)
+		require.Equal(t, "https://auth.example.com:443", e.URL())
+		require.Equal(t, 2, r.calls())
+
+		clk.Advance(2 * time.Second)
+		require.Eventually(t, func() bool {
+			return e.URL() == "https://auth.example.com:443" && r.calls() == 3
+		}, time.Second, time.Millisecond)
+	})
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	r := &mockResolver{records: []*net.SRV{{Target: "edv.example.com.", Port: 443}}}
+
+	registry := discovery.NewRegistry(r)
+
+	u, err := registry.Resolve("https://edv-host/encrypted-data-vaults/vault-id")
+	require.NoError(t, err)
+	require.Equal(t, "https://edv-host/encrypted-data-vaults/vault-id", u)
+	require.Zero(t, r.calls())
+
+	u, err = registry.Resolve("dns+srv://_edv._tcp.example.com/encrypted-data-vaults/vault-id")
+	require.NoError(t, err)
+	require.Equal(t, "https://edv.example.com:443/encrypted-data-vaults/vault-id", u)
+
+	_, err = registry.Resolve("dns+srv://_edv._tcp.example.com/encrypted-data-vaults/vault-id")
+	require.NoError(t, err)
+	require.Equal(t, 1, r.calls(), "endpoint should be cached")
+
+	r.set(nil, errors.New("dns unavailable"))
+
+	_, err = registry.Resolve("dns+srv://_other._tcp.example.com")
+	require.Error(t, err)
+}
+
+type mockResolver struct {
+	mutex   sync.Mutex
+	records []*net.SRV
+	ttl     time.Duration
+	err     error
+	names   []string
+	blocked chan struct{}
+}
+
+func (r *mockResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	r.mutex.Lock()
+	blocked := r.blocked
+	r.mutex.Unlock()
+
+	if blocked != nil {
+		<-blocked
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.names = append(r.names, name)
+
+	return r.records, r.ttl, r.err
+}
+
+func (r *mockResolver) set(records []*net.SRV, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.records, r.err = records, err
+}
+
+// block blocks lookups until unblock is called.
+func (r *mockResolver) block() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.blocked = make(chan struct{})
+}
+
+func (r *mockResolver) unblock() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	close(r.blocked)
+	r.blocked = nil
+}
+
+func (r *mockResolver) calls() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.names)
+}
+
+func (r *mockResolver) lastName() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.names[len(r.names)-1]
+}
+
+func TestNetResolver(t *testing.T) {
+	server := newDNSServer(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		if q.Name.String() != "_hub-auth._tcp.example.com." {
+			return dnsmessage.RCodeNameError, nil
+		}
+
+		target := dnsmessage.MustNewName("auth.example.com.")
+
+		return dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: 8443, Target: target},
+			},
+			{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 120},
+				Body:   &dnsmessage.SRVResource{Priority: 20, Weight: 1, Port: 8443, Target: target},
+			},
+		}
+	})
+
+	r := &discovery.NetResolver{Servers: []string{server}}
+
+	t.Run("Reports the lowest TTL of the records", func(t *testing.T) {
+		records, ttl, err := r.LookupSRV(context.Background(), "_hub-auth._tcp.example.com")
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Minute, ttl)
+		require.Equal(t, []*net.SRV{
+			{Target: "auth.example.com.", Port: 8443, Priority: 10, Weight: 5},
+			{Target: "auth.example.com.", Port: 8443, Priority: 20, Weight: 1},
+		}, records)
+	})
+
+	t.Run("Fail with unknown name", func(t *testing.T) {
+		_, _, err := r.LookupSRV(context.Background(), "_other._tcp.example.com")
+		require.EqualError(t, err, "lookup srv: no such host")
+	})
+}
+
+// newDNSServer starts a UDP DNS server that answers questions with the handler and returns its address.
+func newDNSServer(t *testing.T,
+	handler func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource)) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { require.NoError(t, conn.Close()) })
+
+	go func() {
+		buf := make([]byte, 512)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var query dnsmessage.Message
+
+			if err = query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+
+			rcode, answers := handler(query.Questions[0])
+
+			resp, err := (&dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: rcode},
+				Questions: query.Questions,
+				Answers:   answers,
+			}).Pack()
+			if err != nil {
+				continue
+			}
+
+			_, _ = conn.WriteTo(resp, addr) //nolint:errcheck
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
@@ -0,0 +1,165 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	dnsPort       = "53"
+	maxDNSMessage = 4096
+)
+
+var (
+	errNameNotFound = errors.New("no such host")
+	errTruncated    = errors.New("truncated response")
+)
+
+// systemNameservers returns addresses of nameservers in the resolv.conf file, or nil if it can't be read.
+func systemNameservers(path string) []string {
+	f, err := os.Open(path) //nolint:gosec // path of the system resolver configuration
+	if err != nil {
+		return nil
+	}
+
+	defer f.Close() //nolint:errcheck
+
+	var servers []string
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+
+		servers = append(servers, net.JoinHostPort(fields[1], dnsPort))
+	}
+
+	return servers
+}
+
+// querySRV queries the DNS server for SRV records of the name over UDP. It returns the records and their lowest TTL.
+func querySRV(ctx context.Context, server, name string) ([]*net.SRV, time.Duration, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid name: %w", err)
+	}
+
+	var idBytes [2]byte
+
+	if _, err = rand.Read(idBytes[:]); err != nil {
+		return nil, 0, fmt.Errorf("query id: %w", err)
+	}
+
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("pack query: %w", err)
+	}
+
+	resp, err := exchange(ctx, server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var msg dnsmessage.Message
+
+	if err = msg.Unpack(resp); err != nil {
+		return nil, 0, fmt.Errorf("unpack response: %w", err)
+	}
+
+	switch {
+	case msg.ID != id:
+		return nil, 0, errors.New("response id mismatch")
+	case msg.Truncated:
+		return nil, 0, errTruncated
+	case msg.RCode == dnsmessage.RCodeNameError:
+		return nil, 0, errNameNotFound
+	case msg.RCode != dnsmessage.RCodeSuccess:
+		return nil, 0, fmt.Errorf("response code %s", msg.RCode)
+	}
+
+	return srvRecords(msg.Answers)
+}
+
+func exchange(ctx context.Context, server string, query []byte) ([]byte, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", server, err)
+	}
+
+	defer conn.Close() //nolint:errcheck
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("set deadline: %w", err)
+		}
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, fmt.Errorf("send query to %s: %w", server, err)
+	}
+
+	buf := make([]byte, maxDNSMessage)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("read response from %s: %w", server, err)
+	}
+
+	return buf[:n], nil
+}
+
+// srvRecords returns SRV records of the answers and their lowest TTL.
+func srvRecords(answers []dnsmessage.Resource) ([]*net.SRV, time.Duration, error) {
+	var (
+		records []*net.SRV
+		ttl     uint32
+	)
+
+	for _, answer := range answers {
+		srv, ok := answer.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue // e.g. CNAME of the name
+		}
+
+		if len(records) == 0 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+
+		records = append(records, &net.SRV{
+			Target:   srv.Target.String(),
+			Port:     srv.Port,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+		})
+	}
+
+	if len(records) == 0 {
+		return nil, 0, errNameNotFound
+	}
+
+	return records, time.Duration(ttl) * time.Second, nil
+}
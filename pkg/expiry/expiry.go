@@ -0,0 +1,394 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package expiry stores short-lived records (idempotency keys, sign nonces, one-time tokens, uploads) and prunes them
+// once they expire. None of the storage providers expire data on their own, so expired records are found in a way
+// that suits the provider: with a range query on an indexed tag (MongoDB), a heap of expiry times kept in memory
+// (mem), or a scan of the records of the store (any other provider).
+package expiry
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// Classes of records. Metrics are reported per class.
+const (
+	ClassIdempotencyKey = "idempotency_key"
+	ClassSignNonce      = "sign_nonce"
+	ClassOneTimeToken   = "one_time_token"
+	ClassUpload         = "upload"
+)
+
+// expiresAtTagName is the tag of records with their expiry time in Unix seconds.
+const expiresAtTagName = "expires_at"
+
+// Mode is how expired records of a store are found.
+type Mode int
+
+const (
+	// ModeScan finds expired records by reading the expiry tags of all records of the store. It works with any
+	// storage provider.
+	ModeScan Mode = iota
+	// ModeRange finds expired records with a range query on the indexed expiry tag. The storage provider must support
+	// range queries (e.g. MongoDB).
+	ModeRange
+	// ModeHeap keeps expiry times of records in a heap in memory, so that pruning doesn't read the store. Only for
+	// storage that doesn't outlive the process (e.g. mem): records saved by other processes aren't pruned.
+	ModeHeap
+)
+
+type metricsProvider interface {
+	ExpiringRecords(class string, live int)
+	ExpiredRecords(class string, count int)
+}
+
+// Option configures a Store.
+type Option func(s *Store)
+
+// WithMode sets how expired records are found. Defaults to ModeScan.
+func WithMode(mode Mode) Option {
+	return func(s *Store) {
+		s.mode = mode
+	}
+}
+
+// WithMetrics sets the provider of live and expired record counts.
+func WithMetrics(m metricsProvider) Option {
+	return func(s *Store) {
+		s.metrics = m
+	}
+}
+
+// WithPruner adds the store to the stores pruned by the pruner.
+func WithPruner(p *Pruner) Option {
+	return func(s *Store) {
+		s.pruner = p
+	}
+}
+
+// Store keeps records of a class until they expire. Records are read and written like in storage.Store, with an
+// expiry time; readers still check expiry times themselves, since records are pruned in the background.
+type Store struct {
+	class   string
+	store   storage.Store
+	clock   clock.Clock
+	mode    Mode
+	metrics metricsProvider
+	pruner  *Pruner
+
+	mutex   sync.Mutex // guards heap and expiry
+	heap    expiryHeap
+	expiry  map[string]time.Time // current expiry times of records in the heap
+	pruneMu sync.Mutex           // serializes pruning
+}
+
+// Open opens the store with the name for records of the class.
+func Open(provider storage.Provider, name, class string, clk clock.Clock, opts ...Option) (*Store, error) {
+	store, err := provider.OpenStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	err = provider.SetStoreConfig(name, storage.StoreConfiguration{TagNames: []string{expiresAtTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set store config: %w", err)
+	}
+
+	s := &Store{
+		class:  class,
+		store:  store,
+		clock:  clk,
+		expiry: make(map[string]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.pruner != nil {
+		s.pruner.add(s)
+	}
+
+	return s, nil
+}
+
+// Class returns the class of records of the store.
+func (s *Store) Class() string {
+	return s.class
+}
+
+// Get returns the record with the key. It returns storage.ErrDataNotFound if there is none.
+func (s *Store) Get(key string) ([]byte, error) {
+	return s.store.Get(key) //nolint:wrapcheck
+}
+
+// Put saves the record with the key until expiresAt. With isNewKey set, storage that rejects existing keys returns
+// storage.ErrDuplicateKey if the record exists (see storage.PutOptions).
+func (s *Store) Put(key string, value []byte, expiresAt time.Time, isNewKey bool) error {
+	err := s.store.Batch([]storage.Operation{{
+		Key:        key,
+		Value:      value,
+		Tags:       []storage.Tag{{Name: expiresAtTagName, Value: strconv.FormatInt(unixCeil(expiresAt), 10)}},
+		PutOptions: &storage.PutOptions{IsNewKey: isNewKey},
+	}})
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if s.mode == ModeHeap {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.expiry[key] = expiresAt
+		heap.Push(&s.heap, heapEntry{key: key, expiresAt: expiresAt})
+	}
+
+	return nil
+}
+
+// Delete deletes the record with the key. Deleting a record that doesn't exist is not an error.
+func (s *Store) Delete(key string) error {
+	if err := s.store.Delete(key); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return err //nolint:wrapcheck
+	}
+
+	if s.mode == ModeHeap {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		// the heap entry is dropped when it's popped
+		delete(s.expiry, key)
+	}
+
+	return nil
+}
+
+// Expired returns true if a record that expires at expiresAt is expired.
+func (s *Store) Expired(expiresAt time.Time) bool {
+	return !s.clock.Now().Before(expiresAt)
+}
+
+// Prune deletes expired records and returns the number of deleted records. Records without an expiry tag (saved
+// before records were tagged) are left to the lazy deletion of readers.
+func (s *Store) Prune() (int, error) {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+
+	now := s.clock.Now()
+
+	var (
+		expired []string
+		live    int
+		err     error
+	)
+
+	switch s.mode {
+	case ModeHeap:
+		expired, live = s.popExpired(now)
+	case ModeRange:
+		expired, live, err = s.queryExpired(now)
+	default:
+		expired, live, err = s.scanExpired(now)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(expired) > 0 {
+		ops := make([]storage.Operation, len(expired))
+
+		for i, key := range expired {
+			ops[i] = storage.Operation{Key: key} // an operation without a value deletes the key
+		}
+
+		if err = s.store.Batch(ops); err != nil {
+			return 0, fmt.Errorf("delete expired records: %w", err)
+		}
+	}
+
+	if s.metrics != nil {
+		s.metrics.ExpiringRecords(s.class, live)
+		s.metrics.ExpiredRecords(s.class, len(expired))
+	}
+
+	return len(expired), nil
+}
+
+// popExpired pops records that expired by now from the heap. It returns their keys and the number of live records.
+func (s *Store) popExpired(now time.Time) ([]string, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var expired []string
+
+	for s.heap.Len() > 0 && !now.Before(s.heap[0].expiresAt) {
+		e, _ := heap.Pop(&s.heap).(heapEntry) //nolint:errcheck
+
+		// the record was deleted, or saved again with another expiry time
+		if expiresAt, ok := s.expiry[e.key]; !ok || !expiresAt.Equal(e.expiresAt) {
+			continue
+		}
+
+		delete(s.expiry, e.key)
+
+		expired = append(expired, e.key)
+	}
+
+	return expired, len(s.expiry)
+}
+
+// queryExpired returns keys of records that expired by now with a range query, and the number of live records.
+func (s *Store) queryExpired(now time.Time) ([]string, int, error) {
+	expired, err := s.keys(fmt.Sprintf("%s<=%d", expiresAtTagName, now.Unix()))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	it, err := s.store.Query(expiresAtTagName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query records: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	total, err := it.TotalItems()
+	if err != nil {
+		return nil, 0, fmt.Errorf("count records: %w", err)
+	}
+
+	return expired, total - len(expired), nil
+}
+
+// scanExpired returns keys of records that expired by now from the expiry tags of all records, and the number of
+// live records.
+func (s *Store) scanExpired(now time.Time) ([]string, int, error) {
+	it, err := s.store.Query(expiresAtTagName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query records: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var (
+		expired []string
+		live    int
+	)
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, 0, fmt.Errorf("next record: %w", err)
+		}
+
+		if !ok {
+			return expired, live, nil
+		}
+
+		key, err := it.Key()
+		if err != nil {
+			return nil, 0, fmt.Errorf("get record key: %w", err)
+		}
+
+		tags, err := it.Tags()
+		if err != nil {
+			return nil, 0, fmt.Errorf("get record tags: %w", err)
+		}
+
+		if isExpired(tags, now) {
+			expired = append(expired, key)
+		} else {
+			live++
+		}
+	}
+}
+
+func (s *Store) keys(query string) ([]string, error) {
+	it, err := s.store.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query expired records: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var keys []string
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next expired record: %w", err)
+		}
+
+		if !ok {
+			return keys, nil
+		}
+
+		key, err := it.Key()
+		if err != nil {
+			return nil, fmt.Errorf("get expired record key: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+}
+
+func isExpired(tags []storage.Tag, now time.Time) bool {
+	for _, tag := range tags {
+		if tag.Name != expiresAtTagName {
+			continue
+		}
+
+		expiresAt, err := strconv.ParseInt(tag.Value, 10, 64)
+
+		return err == nil && expiresAt <= now.Unix()
+	}
+
+	return false
+}
+
+// unixCeil returns the time in Unix seconds rounded up, so that records aren't pruned before they expire.
+func unixCeil(t time.Time) int64 {
+	if t.Nanosecond() > 0 {
+		return t.Unix() + 1
+	}
+
+	return t.Unix()
+}
+
+type heapEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryHeap is a min-heap of records by expiry time.
+type expiryHeap []heapEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	e, _ := x.(heapEntry) //nolint:errcheck
+
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return e
+}
@@ -0,0 +1,304 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package expiry_test
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+func TestStore_Prune(t *testing.T) {
+	modes := map[string]struct {
+		mode     expiry.Mode
+		provider func() storage.Provider
+	}{
+		"Scan":  {mode: expiry.ModeScan, provider: func() storage.Provider { return mem.NewProvider() }},
+		"Range": {mode: expiry.ModeRange, provider: func() storage.Provider { return &rangeProvider{mem.NewProvider()} }},
+		"Heap":  {mode: expiry.ModeHeap, provider: func() storage.Provider { return mem.NewProvider() }},
+	}
+
+	for name, tc := range modes {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			clk := testutil.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC))
+			m := &metrics{live: map[string]int{}, expired: map[string]int{}}
+
+			s, err := expiry.Open(tc.provider(), "records", expiry.ClassSignNonce, clk,
+				expiry.WithMode(tc.mode), expiry.WithMetrics(m))
+			require.NoError(t, err)
+			require.Equal(t, expiry.ClassSignNonce, s.Class())
+
+			now := clk.Now()
+
+			require.NoError(t, s.Put("a", []byte(`"a"`), now.Add(time.Minute), false))
+			require.NoError(t, s.Put("b", []byte(`"b"`), now.Add(90*time.Second+time.Millisecond), false))
+			require.NoError(t, s.Put("c", []byte(`"c"`), now.Add(time.Hour), true))
+			require.NoError(t, s.Put("d", []byte(`"d"`), now.Add(time.Minute), false))
+			require.NoError(t, s.Delete("d"))
+			require.NoError(t, s.Delete("missing"))
+
+			// saved again with a later expiry time
+			require.NoError(t, s.Put("a", []byte(`"a2"`), now.Add(2*time.Hour), false))
+
+			n, err := s.Prune()
+			require.NoError(t, err)
+			require.Zero(t, n)
+			require.Equal(t, 3, m.live[expiry.ClassSignNonce])
+
+			clk.Advance(90 * time.Second)
+
+			// b expires a millisecond later, and isn't pruned early
+			require.False(t, s.Expired(now.Add(90*time.Second+time.Millisecond)))
+
+			n, err = s.Prune()
+			require.NoError(t, err)
+			require.Zero(t, n)
+
+			clk.Advance(time.Second)
+
+			n, err = s.Prune()
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+			require.Equal(t, 2, m.live[expiry.ClassSignNonce])
+			require.Equal(t, 1, m.expired[expiry.ClassSignNonce])
+
+			_, err = s.Get("b")
+			require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+			clk.Advance(time.Hour)
+
+			n, err = s.Prune()
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+			require.Equal(t, 1, m.live[expiry.ClassSignNonce])
+
+			_, err = s.Get("c")
+			require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+			b, err := s.Get("a")
+			require.NoError(t, err)
+			require.Equal(t, `"a2"`, string(b))
+
+			clk.Advance(time.Hour)
+
+			n, err = s.Prune()
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+			require.Zero(t, m.live[expiry.ClassSignNonce])
+			require.Equal(t, 3, m.expired[expiry.ClassSignNonce])
+		})
+	}
+
+	t.Run("Records without expiry tag are kept", func(t *testing.T) {
+		clk := testutil.NewFakeClock(time.Now())
+		provider := mem.NewProvider()
+
+		s, err := expiry.Open(provider, "records", expiry.ClassOneTimeToken, clk)
+		require.NoError(t, err)
+
+		store, err := provider.OpenStore("records")
+		require.NoError(t, err)
+		require.NoError(t, store.Put("untagged", []byte(`"value"`)))
+
+		clk.Advance(time.Hour)
+
+		n, err := s.Prune()
+		require.NoError(t, err)
+		require.Zero(t, n)
+
+		_, err = s.Get("untagged")
+		require.NoError(t, err)
+	})
+
+	t.Run("Query error", func(t *testing.T) {
+		for _, mode := range []expiry.Mode{expiry.ModeScan, expiry.ModeRange} {
+			s, err := expiry.Open(&failingProvider{Provider: mem.NewProvider()}, "records", expiry.ClassSignNonce,
+				testutil.NewFakeClock(time.Now()), expiry.WithMode(mode))
+			require.NoError(t, err)
+
+			_, err = s.Prune()
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "query failed")
+		}
+	})
+}
+
+func TestOpen(t *testing.T) {
+	_, err := expiry.Open(&failingProvider{Provider: mem.NewProvider(), openErr: errors.New("open failed")},
+		"records", expiry.ClassSignNonce, testutil.NewFakeClock(time.Now()))
+	require.EqualError(t, err, "open store: open failed")
+}
+
+func TestPruner(t *testing.T) {
+	clk := testutil.NewFakeClock(time.Now())
+	pruner := expiry.NewPruner(time.Millisecond)
+
+	s, err := expiry.Open(mem.NewProvider(), "records", expiry.ClassIdempotencyKey, clk,
+		expiry.WithMode(expiry.ModeHeap), expiry.WithPruner(pruner))
+	require.NoError(t, err)
+
+	failing, err := expiry.Open(&failingProvider{Provider: mem.NewProvider()}, "records",
+		expiry.ClassSignNonce, clk, expiry.WithPruner(pruner))
+	require.NoError(t, err)
+	require.NotNil(t, failing)
+
+	require.NoError(t, s.Put("key", []byte(`"value"`), clk.Now().Add(time.Minute), false))
+
+	clk.Advance(time.Minute)
+
+	pruner.Start()
+	defer pruner.Stop()
+
+	require.Eventually(t, func() bool {
+		_, err := s.Get("key")
+
+		return errors.Is(err, storage.ErrDataNotFound)
+	}, time.Second, time.Millisecond)
+
+	pruner.Stop()
+	pruner.Stop()
+}
+
+type metrics struct {
+	live    map[string]int
+	expired map[string]int
+}
+
+func (m *metrics) ExpiringRecords(class string, live int) {
+	m.live[class] = live
+}
+
+func (m *metrics) ExpiredRecords(class string, count int) {
+	m.expired[class] += count
+}
+
+// rangeProvider supports range queries on the expiry tag like MongoDB.
+type rangeProvider struct {
+	storage.Provider
+}
+
+func (p *rangeProvider) OpenStore(name string) (storage.Store, error) {
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rangeStore{Store: store}, nil
+}
+
+type rangeStore struct {
+	storage.Store
+}
+
+func (s *rangeStore) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	parts := strings.Split(expression, "<=")
+	if len(parts) != 2 {
+		return s.Store.Query(expression, options...)
+	}
+
+	limit, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := s.Store.Query(parts[0], options...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var keys []string
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			return &keysIterator{keys: keys}, nil
+		}
+
+		key, _ := it.Key()                              //nolint:errcheck
+		tags, _ := it.Tags()                            //nolint:errcheck
+		v, _ := strconv.ParseInt(tags[0].Value, 10, 64) //nolint:errcheck
+
+		if v <= limit {
+			keys = append(keys, key)
+		}
+	}
+}
+
+type keysIterator struct {
+	keys []string
+	i    int
+}
+
+func (it *keysIterator) Next() (bool, error) {
+	it.i++
+
+	return it.i <= len(it.keys), nil
+}
+
+func (it *keysIterator) Key() (string, error) {
+	return it.keys[it.i-1], nil
+}
+
+func (it *keysIterator) Value() ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (it *keysIterator) Tags() ([]storage.Tag, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (it *keysIterator) TotalItems() (int, error) {
+	return len(it.keys), nil
+}
+
+func (it *keysIterator) Close() error {
+	return nil
+}
+
+type failingProvider struct {
+	storage.Provider
+	openErr error
+}
+
+func (p *failingProvider) OpenStore(name string) (storage.Store, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingStore{Store: store}, nil
+}
+
+type failingStore struct {
+	storage.Store
+}
+
+func (s *failingStore) Query(string, ...storage.QueryOption) (storage.Iterator, error) {
+	return nil, fmt.Errorf("query failed")
+}
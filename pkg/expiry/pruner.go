@@ -0,0 +1,85 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package expiry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+var logger = log.New("expiry")
+
+// Pruner prunes expired records of its stores in the background.
+type Pruner struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	stores   []*Store
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPruner returns a new Pruner that prunes expired records of its stores every interval. Stores are added with
+// WithPruner when they are opened.
+func NewPruner(interval time.Duration) *Pruner {
+	return &Pruner{
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+func (p *Pruner) add(s *Store) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stores = append(p.stores, s)
+}
+
+// Start starts pruning expired records in the background until Stop is called.
+func (p *Pruner) Start() {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.Prune()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops pruning expired records.
+func (p *Pruner) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+// Prune prunes expired records of the stores once. Failures are logged, and the records are pruned on the next run.
+func (p *Pruner) Prune() {
+	p.mutex.Lock()
+	stores := append([]*Store(nil), p.stores...)
+	p.mutex.Unlock()
+
+	for _, s := range stores {
+		n, err := s.Prune()
+		if err != nil {
+			logger.Errorf("prune expired %s records: %v", s.class, err)
+
+			continue
+		}
+
+		if n > 0 {
+			logger.Debugf("pruned %d expired %s records", n, s.class)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package gziplimit compresses and decompresses gzip data, e.g. capabilities, with a limit on the decompressed size.
+// The limit is enforced while the stream is read, so that a crafted stream that decompresses to gigabytes (a gzip
+// bomb) is rejected after reading at most the limit.
+package gziplimit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// LimitDecompressedSize is the name of the limit, e.g. in metrics of rejected requests.
+const LimitDecompressedSize = "max_decompressed_size"
+
+// DefaultMaxSize is the default maximum decompressed size in bytes.
+const DefaultMaxSize = 1 << 20 // 1 MiB
+
+// LimitError is returned when data decompresses to more than the maximum size. Servers respond to it with
+// 413 Request Entity Too Large.
+type LimitError struct {
+	// Max is the maximum decompressed size in bytes.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeded", LimitDecompressedSize, e.Max)
+}
+
+// Compress gzips the data.
+func Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("compress: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("close gzip writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress gunzips the data. Data that decompresses to more than maxSize bytes is rejected with a LimitError; a
+// maxSize that is not positive means DefaultMaxSize.
+func Decompress(data []byte, maxSize int64) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// NewReader returns a reader of the decompressed stream. Reading past maxSize bytes returns a LimitError; a maxSize
+// that is not positive means DefaultMaxSize.
+func NewReader(r io.Reader, maxSize int64) (io.Reader, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open gzip reader: %w", err)
+	}
+
+	return &limitedReader{r: zr, remaining: maxSize, max: maxSize}, nil
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	max       int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &LimitError{Max: l.max}
+	}
+
+	// reads one byte more than remains, so that a stream of exactly the max size isn't rejected
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+
+	if l.remaining < 0 {
+		return n - 1, &LimitError{Max: l.max}
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, fmt.Errorf("decompress: %w", err)
+	}
+
+	return n, err //nolint:wrapcheck // io.EOF must not be wrapped
+}
@@ -0,0 +1,82 @@
+//go:build go1.18
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gziplimit_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/trustbloc/kms/pkg/gziplimit"
+)
+
+const fuzzMaxSize = 4096
+
+// FuzzDecompress checks that streams are either rejected, or decompressed like compress/gzip decompresses them and
+// within the limit. Streams over the limit are rejected with a LimitError.
+func FuzzDecompress(f *testing.F) {
+	valid, err := gziplimit.Compress([]byte(`{"id":"urn:zcap:root","invoker":"did:key:z6Mk"}`))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	bomb, err := gziplimit.Compress(make([]byte, 1<<20))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, seed := range [][]byte{
+		valid,
+		valid[:len(valid)/2], // truncated
+		valid[:10],           // header only
+		append(append([]byte{}, valid...), valid...), // multistream
+		bomb,
+		[]byte("garbage"),
+		{0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff},
+		{},
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		b, err := gziplimit.Decompress(data, fuzzMaxSize)
+
+		expected, expectedErr := gunzip(data)
+
+		var limitErr *gziplimit.LimitError
+
+		if errors.As(err, &limitErr) {
+			if expectedErr == nil && len(expected) <= fuzzMaxSize {
+				t.Fatalf("stream of %d bytes rejected", len(expected))
+			}
+
+			return
+		}
+
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("decompress error %v, compress/gzip error %v", err, expectedErr)
+		}
+
+		if err == nil && !bytes.Equal(b, expected) {
+			t.Fatalf("decompressed %q, compress/gzip decompressed %q", b, expected)
+		}
+	})
+}
+
+// gunzip decompresses up to twice the limit with compress/gzip.
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(io.LimitReader(r, 2*fuzzMaxSize))
+}
@@ -0,0 +1,101 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package gziplimit_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/gziplimit"
+)
+
+func TestDecompress(t *testing.T) {
+	compress := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+
+		compressed, err := gziplimit.Compress(data)
+		require.NoError(t, err)
+
+		return compressed
+	}
+
+	t.Run("Within the limit", func(t *testing.T) {
+		for _, size := range []int{0, 1, 99, 100} {
+			data := bytes.Repeat([]byte("a"), size)
+
+			b, err := gziplimit.Decompress(compress(t, data), 100)
+			require.NoError(t, err)
+			require.Equal(t, data, b)
+		}
+	})
+
+	t.Run("Default limit", func(t *testing.T) {
+		data := make([]byte, gziplimit.DefaultMaxSize)
+
+		b, err := gziplimit.Decompress(compress(t, data), 0)
+		require.NoError(t, err)
+		require.Len(t, b, gziplimit.DefaultMaxSize)
+
+		_, err = gziplimit.Decompress(compress(t, append(data, 0)), 0)
+		require.EqualError(t, err, "max_decompressed_size 1048576 exceeded")
+	})
+
+	t.Run("Bomb", func(t *testing.T) {
+		bomb := compress(t, make([]byte, 64<<20)) // 64 MiB of zeros compress to about 128 KiB
+		require.Less(t, len(bomb), 256<<10)
+
+		_, err := gziplimit.Decompress(bomb, 1024)
+		require.EqualError(t, err, "max_decompressed_size 1024 exceeded")
+
+		var limitErr *gziplimit.LimitError
+
+		require.True(t, errors.As(err, &limitErr))
+		require.Equal(t, int64(1024), limitErr.Max)
+	})
+
+	t.Run("Streaming reads stop at the limit", func(t *testing.T) {
+		r, err := gziplimit.NewReader(bytes.NewReader(compress(t, make([]byte, 1000))), 10)
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+
+		var read int
+
+		for {
+			n, err := r.Read(buf)
+			read += n
+
+			if err != nil {
+				var limitErr *gziplimit.LimitError
+
+				require.True(t, errors.As(err, &limitErr))
+
+				break
+			}
+		}
+
+		require.Equal(t, 10, read)
+	})
+
+	t.Run("Truncated stream", func(t *testing.T) {
+		compressed := compress(t, []byte("capability"))
+
+		_, err := gziplimit.Decompress(compressed[:len(compressed)-4], 100)
+		require.Error(t, err)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("Garbage", func(t *testing.T) {
+		_, err := gziplimit.Decompress([]byte("not gzip"), 100)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "open gzip reader")
+	})
+}
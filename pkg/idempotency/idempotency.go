@@ -0,0 +1,194 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/expiry"
+)
+
+const (
+	// StoreName is the name of the store with responses of requests with idempotency keys.
+	StoreName = "idempotencykeys"
+
+	recordKeyPrefix = "record_"
+)
+
+var (
+	// ErrMismatch is returned when the idempotency key is reused for a different request.
+	ErrMismatch = errors.New("idempotency key was already used for a different request")
+	// ErrInProgress is returned when a request with the same idempotency key hasn't completed yet.
+	ErrInProgress = errors.New("request with the idempotency key is in progress")
+)
+
+type record struct {
+	RequestHash string    `json:"request_hash"`
+	Response    []byte    `json:"response,omitempty"` // empty while the request is in progress
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Store keeps responses of requests with idempotency keys, so that a retried request returns the response of the
+// first one instead of being executed again. Responses are kept in storage, so replicas return the same response.
+type Store struct {
+	store *expiry.Store
+	clock clock.Clock
+	ttl   time.Duration
+	mutex sync.Mutex // serializes reservation of keys within the process
+}
+
+// New returns a new Store that keeps responses for ttl. Expired responses are pruned as configured by the options.
+func New(provider storage.Provider, clk clock.Clock, ttl time.Duration, opts ...expiry.Option) (*Store, error) {
+	store, err := expiry.Open(provider, StoreName, expiry.ClassIdempotencyKey, clk, opts...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &Store{store: store, clock: clk, ttl: ttl}, nil
+}
+
+// Do returns the response saved for the idempotency key of the scope (e.g. a key store controller), or executes the
+// request and saves its response. The returned flag is true if the response was saved by an earlier request. The key
+// is reserved before the request is executed, so a concurrent request with the same key fails with ErrInProgress
+// instead of being executed too; the guarantee holds across replicas with storage that rejects existing keys for
+// storage.PutOptions.IsNewKey (e.g. MongoDB). A failed request releases the key, so that it can be retried.
+func (s *Store) Do(scope, idempotencyKey string, request []byte, do func() ([]byte, error)) ([]byte, bool, error) {
+	key := recordKeyPrefix + hash([]byte(scope), []byte(idempotencyKey))
+	requestHash := hash(request)
+
+	saved, err := s.reserve(key, requestHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if saved != nil {
+		return saved, true, nil
+	}
+
+	response, err := do()
+	if err != nil {
+		if deleteErr := s.store.Delete(key); deleteErr != nil {
+			return nil, false, fmt.Errorf("%w (release idempotency key: %s)", err, deleteErr.Error())
+		}
+
+		return nil, false, err
+	}
+
+	if err = s.put(key, &record{
+		RequestHash: requestHash,
+		Response:    response,
+		ExpiresAt:   s.clock.Now().UTC().Add(s.ttl),
+	}, false); err != nil {
+		return nil, false, fmt.Errorf("save response: %w", err)
+	}
+
+	return response, false, nil
+}
+
+// reserve saves an in-progress record for the key. It returns the saved response if the request was already
+// completed.
+func (s *Store) reserve(key, requestHash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	saved, err := s.get(key, requestHash)
+	if err != nil || saved != nil {
+		return saved, err
+	}
+
+	err = s.put(key, &record{
+		RequestHash: requestHash,
+		ExpiresAt:   s.clock.Now().UTC().Add(s.ttl),
+	}, true)
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		// reserved by another replica in the meantime
+		saved, err = s.get(key, requestHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if saved == nil {
+			return nil, errors.New("response saved by a concurrent request expired")
+		}
+
+		return saved, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("reserve idempotency key: %w", err)
+	}
+
+	return nil, nil
+}
+
+// get returns a response saved for the key, or nil if there is none. An expired record is deleted, so that the
+// idempotency key can be used again.
+func (s *Store) get(key, requestHash string) ([]byte, error) {
+	b, err := s.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get response: %w", err)
+	}
+
+	var r record
+
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	if s.store.Expired(r.ExpiresAt) {
+		if err = s.store.Delete(key); err != nil {
+			return nil, fmt.Errorf("delete expired response: %w", err)
+		}
+
+		return nil, nil
+	}
+
+	if r.RequestHash != requestHash {
+		return nil, ErrMismatch
+	}
+
+	if r.Response == nil {
+		return nil, ErrInProgress
+	}
+
+	return r.Response, nil
+}
+
+func (s *Store) put(key string, r *record, isNewKey bool) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+
+	return s.store.Put(key, b, r.ExpiresAt, isNewKey)
+}
+
+// hash returns a hex-encoded hash of the values. Values are length-prefixed, so different sets of values don't
+// produce the same input.
+func hash(values ...[]byte) string {
+	h := sha256.New()
+
+	for _, v := range values {
+		h.Write([]byte(fmt.Sprintf("%d:", len(v))))
+		h.Write(v)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,247 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package idempotency_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/idempotency"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+func TestStore_Do(t *testing.T) {
+	t.Run("Retry returns saved response", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		creator := &counterCreator{}
+
+		resp, replayed, err := s.Do("controller", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("key store 1"), resp)
+
+		resp, replayed, err = s.Do("controller", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+		require.True(t, replayed)
+		require.Equal(t, []byte("key store 1"), resp)
+		require.EqualValues(t, 1, creator.count)
+	})
+
+	t.Run("Keys are scoped", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		creator := &counterCreator{}
+
+		_, _, err := s.Do("controller", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+
+		resp, replayed, err := s.Do("other", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("key store 2"), resp)
+	})
+
+	t.Run("Key reused for another request", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		creator := &counterCreator{}
+
+		_, _, err := s.Do("controller", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+
+		_, _, err = s.Do("controller", "key", []byte("other request"), creator.create)
+		require.ErrorIs(t, err, idempotency.ErrMismatch)
+		require.EqualValues(t, 1, creator.count)
+	})
+
+	t.Run("Expired response is not returned", func(t *testing.T) {
+		s, clk := newStore(t, mem.NewProvider())
+		creator := &counterCreator{}
+
+		_, _, err := s.Do("controller", "key", []byte("request"), creator.create)
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+
+		resp, replayed, err := s.Do("controller", "key", []byte("other request"), creator.create)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("key store 2"), resp)
+	})
+
+	t.Run("Expired response is pruned", func(t *testing.T) {
+		provider := mem.NewProvider()
+		clk := testutil.NewFakeClock(time.Now())
+		pruner := expiry.NewPruner(time.Hour)
+
+		s, err := idempotency.New(provider, clk, time.Minute, expiry.WithPruner(pruner))
+		require.NoError(t, err)
+
+		_, _, err = s.Do("controller", "key", []byte("request"), (&counterCreator{}).create)
+		require.NoError(t, err)
+
+		pruner.Prune()
+		requireRecords(t, provider, 1)
+
+		// expiry tags are rounded up to the second
+		clk.Advance(time.Minute + time.Second)
+		pruner.Prune()
+		requireRecords(t, provider, 0)
+	})
+
+	t.Run("Failed request releases the key", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		_, _, err := s.Do("controller", "key", []byte("request"), func() ([]byte, error) {
+			return nil, errors.New("create error")
+		})
+		require.EqualError(t, err, "create error")
+
+		resp, replayed, err := s.Do("controller", "key", []byte("request"), (&counterCreator{}).create)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("key store 1"), resp)
+	})
+
+	t.Run("Request in progress", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		_, _, err := s.Do("controller", "key", []byte("request"), func() ([]byte, error) {
+			_, _, doErr := s.Do("controller", "key", []byte("request"), (&counterCreator{}).create)
+			require.ErrorIs(t, doErr, idempotency.ErrInProgress)
+
+			return []byte("key store"), nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Concurrent requests across replicas create one key store", func(t *testing.T) {
+		provider := &newKeyProvider{Provider: mem.NewProvider()}
+
+		s1, _ := newStore(t, provider)
+		s2, _ := newStore(t, provider)
+
+		creator := &counterCreator{}
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			s := s1
+			if i%2 == 1 {
+				s = s2
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				resp, _, err := s.Do("controller", "key", []byte("request"), creator.create)
+				if err != nil && !errors.Is(err, idempotency.ErrInProgress) {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				if err == nil && string(resp) != "key store 1" {
+					t.Errorf("unexpected response: %s", resp)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		require.EqualValues(t, 1, creator.count)
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := idempotency.New(&newKeyProvider{openErr: errors.New("open error")},
+		testutil.NewFakeClock(time.Now()), time.Minute)
+	require.EqualError(t, err, "open store: open error")
+}
+
+func requireRecords(t *testing.T, provider storage.Provider, count int) {
+	t.Helper()
+
+	store, err := provider.OpenStore(idempotency.StoreName)
+	require.NoError(t, err)
+
+	it, err := store.Query("expires_at")
+	require.NoError(t, err)
+
+	n, err := it.TotalItems()
+	require.NoError(t, err)
+	require.Equal(t, count, n)
+}
+
+func newStore(t *testing.T, provider storage.Provider) (*idempotency.Store, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s, err := idempotency.New(provider, clk, time.Minute)
+	require.NoError(t, err)
+
+	return s, clk
+}
+
+// counterCreator returns a different response on every call, like creating a new key store.
+type counterCreator struct {
+	count int32
+}
+
+func (c *counterCreator) create() ([]byte, error) {
+	return []byte(fmt.Sprintf("key store %d", atomic.AddInt32(&c.count, 1))), nil
+}
+
+// newKeyProvider rejects existing keys stored with IsNewKey option, like MongoDB does.
+type newKeyProvider struct {
+	storage.Provider
+	openErr error
+	mutex   sync.Mutex
+}
+
+func (p *newKeyProvider) OpenStore(name string) (storage.Store, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newKeyStore{Store: store, mutex: &p.mutex}, nil
+}
+
+type newKeyStore struct {
+	storage.Store
+	mutex *sync.Mutex
+}
+
+func (s *newKeyStore) Batch(operations []storage.Operation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, op := range operations {
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			continue
+		}
+
+		if _, err := s.Store.Get(op.Key); err == nil {
+			return storage.ErrDuplicateKey
+		}
+	}
+
+	return s.Store.Batch(operations)
+}
@@ -0,0 +1,223 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package jsonlimit decodes JSON with limits on nesting depth, array lengths and string sizes. The limits are checked
+// by a single pass over the bytes before they're decoded, so that crafted documents, e.g. deeply nested arrays, are
+// rejected at a cost linear in their size.
+package jsonlimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Names of limits in LimitError.
+const (
+	LimitDepth        = "max_depth"
+	LimitArrayLength  = "max_array_length"
+	LimitStringLength = "max_string_length"
+)
+
+// Default limits.
+const (
+	DefaultMaxDepth        = 32
+	DefaultMaxArrayLength  = 10000
+	DefaultMaxStringLength = 16 << 20 // 16 MiB
+)
+
+// maxKeySize is the size of a key in the path of a LimitError, longer keys are truncated.
+const maxKeySize = 64
+
+// Limits of JSON documents. A limit that is not positive is not checked.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of objects and arrays; the root object has depth 1.
+	MaxDepth int
+	// MaxArrayLength is the maximum number of elements of an array, e.g. of messages of a batch.
+	MaxArrayLength int
+	// MaxStringLength is the maximum size of a string or an object key in bytes, as encoded in the document.
+	MaxStringLength int
+}
+
+// DefaultLimits returns the default limits.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxDepth:        DefaultMaxDepth,
+		MaxArrayLength:  DefaultMaxArrayLength,
+		MaxStringLength: DefaultMaxStringLength,
+	}
+}
+
+// LimitError is returned when a document exceeds a limit.
+type LimitError struct {
+	// Limit is the name of the exceeded limit, e.g. LimitDepth.
+	Limit string
+	// Max is the value of the limit.
+	Max int
+	// Path locates the value that exceeds the limit, e.g. "$.messages" or "$.keys[3].alias".
+	Path string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeded at %s", e.Limit, e.Max, e.Path)
+}
+
+// Decode checks the document against the limits and decodes it into v.
+func Decode(data []byte, v interface{}, limits Limits) error {
+	if err := Check(data, limits); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v) //nolint:wrapcheck
+}
+
+type frame struct {
+	array bool
+	// length is the number of elements of an array seen so far.
+	length int
+	// pending is true if a value is expected next: after '[' or a comma in an array, after ':' in an object.
+	pending bool
+	// expectKey is true after '{' or a comma in an object.
+	expectKey bool
+	key       string
+}
+
+// Check checks the document against the limits. It doesn't validate the document: malformed JSON that is within the
+// limits is left to the decoder to reject.
+func Check(data []byte, limits Limits) error { //nolint:gocyclo,cyclop
+	var stack []frame
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch c {
+		case '{', '[':
+			if err := startValue(stack, limits); err != nil {
+				return err
+			}
+
+			if limits.MaxDepth > 0 && len(stack) >= limits.MaxDepth {
+				return &LimitError{Limit: LimitDepth, Max: limits.MaxDepth, Path: path(stack)}
+			}
+
+			stack = append(stack, frame{array: c == '[', pending: c == '[', expectKey: c == '{'})
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ',':
+			if len(stack) > 0 {
+				top := &stack[len(stack)-1]
+				top.pending = top.array
+				top.expectKey = !top.array
+			}
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].pending = true
+			}
+		case '"':
+			end := stringEnd(data, i+1)
+
+			if len(stack) > 0 && stack[len(stack)-1].expectKey {
+				top := &stack[len(stack)-1]
+				top.expectKey = false
+				top.key = string(data[i+1 : minInt(end, i+1+maxKeySize)])
+			} else if err := startValue(stack, limits); err != nil {
+				return err
+			}
+
+			if limits.MaxStringLength > 0 && end-i-1 > limits.MaxStringLength {
+				return &LimitError{Limit: LimitStringLength, Max: limits.MaxStringLength, Path: path(stack)}
+			}
+
+			i = end
+		case ' ', '\t', '\r', '\n':
+		default:
+			// numbers, true, false and null
+			if err := startValue(stack, limits); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// startValue counts a value that starts in the innermost array.
+func startValue(stack []frame, limits Limits) error {
+	if len(stack) == 0 {
+		return nil
+	}
+
+	top := &stack[len(stack)-1]
+
+	if !top.pending {
+		return nil
+	}
+
+	top.pending = false
+
+	if !top.array {
+		return nil
+	}
+
+	top.length++
+
+	if limits.MaxArrayLength > 0 && top.length > limits.MaxArrayLength {
+		return &LimitError{Limit: LimitArrayLength, Max: limits.MaxArrayLength, Path: path(stack[:len(stack)-1])}
+	}
+
+	return nil
+}
+
+// stringEnd returns the index of the quote that ends the string starting at i, or len(data) if the string isn't
+// terminated.
+func stringEnd(data []byte, i int) int {
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return len(data)
+}
+
+// path returns the path of the value being read.
+func path(stack []frame) string {
+	var b strings.Builder
+
+	b.WriteString("$")
+
+	for i := range stack {
+		if stack[i].array {
+			b.WriteString("[" + strconv.Itoa(maxInt(stack[i].length-1, 0)) + "]")
+		} else if stack[i].key != "" {
+			b.WriteString("." + stack[i].key)
+		}
+	}
+
+	return b.String()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,88 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+//go:build go1.18
+
+package jsonlimit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+)
+
+// FuzzDecode checks that documents are either rejected with a LimitError, or decoded like encoding/json decodes them
+// and within the limits.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		`{"message": "dGVzdA=="}`,
+		`{"messages": ["bWVzc2FnZSAx", "bWVzc2FnZSAy"]}`,
+		`{"a": [[[[[[1]]]]]]}`,
+		`[{}, {"b": "\"\\"}, null, true, -1.5e3]`,
+		`{"unterminated": "`,
+		`]]}}{{[[`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	limits := jsonlimit.Limits{MaxDepth: 4, MaxArrayLength: 4, MaxStringLength: 16}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v, expected interface{}
+
+		err := jsonlimit.Decode(data, &v, limits)
+
+		var limitErr *jsonlimit.LimitError
+
+		if errors.As(err, &limitErr) {
+			return
+		}
+
+		expectedErr := json.Unmarshal(data, &expected)
+
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("decode error %v, encoding/json error %v", err, expectedErr)
+		}
+
+		if err == nil && exceeds(v, 1, limits) {
+			t.Fatalf("document exceeds limits: %s", data)
+		}
+	})
+}
+
+// exceeds returns true if the decoded value exceeds the max depth or max array length.
+func exceeds(v interface{}, depth int, limits jsonlimit.Limits) bool {
+	var children []interface{}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, e := range val {
+			children = append(children, e)
+		}
+	case []interface{}:
+		if len(val) > limits.MaxArrayLength {
+			return true
+		}
+
+		children = val
+	default:
+		return false
+	}
+
+	if depth > limits.MaxDepth {
+		return true
+	}
+
+	for _, e := range children {
+		if exceeds(e, depth+1, limits) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,94 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package jsonlimit_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/jsonlimit"
+)
+
+func TestCheck(t *testing.T) {
+	limits := jsonlimit.Limits{MaxDepth: 3, MaxArrayLength: 3, MaxStringLength: 8}
+
+	for _, tc := range []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{name: "within limits", doc: `{"a": [1, "two", {"b": true}], "c": "12345678"}`},
+		{name: "empty document", doc: ``},
+		{name: "empty array", doc: `{"a": []}`},
+		{name: "escaped quote", doc: `{"a": "\"\"\"\""}`},
+		{name: "brackets in string", doc: `{"a": "[[[[", "b": "{{{{"}`},
+		{name: "commas in string", doc: `{"a": [",,,,,,"]}`},
+		{name: "depth", doc: `{"a": [[[1]]]}`, err: "max_depth 3 exceeded at $.a[0][0]"},
+		{name: "depth of root array", doc: `[[[[]]]]`, err: "max_depth 3 exceeded at $[0][0][0]"},
+		{name: "array length", doc: `{"messages": [1, 2, 3, 4]}`, err: "max_array_length 3 exceeded at $.messages"},
+		{name: "nested array length", doc: `[{"a": ["x", "y", 1, 2]}]`, err: "max_array_length 3 exceeded at $[0].a"},
+		{name: "array length of objects", doc: `[{}, {}, {}, {}]`, err: "max_array_length 3 exceeded at $"},
+		{name: "string length", doc: `{"a": [1, 2, "123456789"]}`, err: "max_string_length 8 exceeded at $.a[2]"},
+		{name: "key length", doc: `{"123456789": 1}`, err: "max_string_length 8 exceeded at $.123456789"},
+		{name: "escaped string length", doc: `{"a": "\"\"\"\"\""}`, err: "max_string_length 8 exceeded at $.a"},
+		{name: "unterminated string", doc: `{"a": "123456789`, err: "max_string_length 8 exceeded at $.a"},
+	} {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			err := jsonlimit.Check([]byte(tc.doc), limits)
+			if tc.err == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.EqualError(t, err, tc.err)
+
+			var limitErr *jsonlimit.LimitError
+
+			require.True(t, errors.As(err, &limitErr))
+		})
+	}
+
+	t.Run("Limits that are not positive are not checked", func(t *testing.T) {
+		doc := strings.Repeat("[", 100) + strings.Repeat("]", 100)
+
+		require.NoError(t, jsonlimit.Check([]byte(doc), jsonlimit.Limits{}))
+	})
+
+	t.Run("Deeply nested document is rejected early", func(t *testing.T) {
+		doc := strings.Repeat("[", 1<<20)
+
+		err := jsonlimit.Check([]byte(doc), jsonlimit.DefaultLimits())
+		require.EqualError(t, err, "max_depth 32 exceeded at $"+strings.Repeat("[0]", 32))
+	})
+
+	t.Run("Long keys are truncated in the path", func(t *testing.T) {
+		key := strings.Repeat("k", 100)
+
+		err := jsonlimit.Check([]byte(`{"`+key+`": [[1]]}`), jsonlimit.Limits{MaxDepth: 2})
+		require.EqualError(t, err, "max_depth 2 exceeded at $."+key[:64]+"[0]")
+	})
+}
+
+func TestDecode(t *testing.T) {
+	var v struct {
+		Messages []string `json:"messages"`
+	}
+
+	require.NoError(t, jsonlimit.Decode([]byte(`{"messages": ["a", "b"]}`), &v, jsonlimit.DefaultLimits()))
+	require.Equal(t, []string{"a", "b"}, v.Messages)
+
+	err := jsonlimit.Decode([]byte(`{"messages": ["a", "b"]}`), &v, jsonlimit.Limits{MaxArrayLength: 1})
+	require.EqualError(t, err, "max_array_length 1 exceeded at $.messages")
+
+	require.Error(t, jsonlimit.Decode([]byte(`{"messages": [}`), &v, jsonlimit.DefaultLimits()))
+}
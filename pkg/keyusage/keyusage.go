@@ -0,0 +1,230 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package keyusage records when keys were last used, so that operators can find keys that are no longer in use.
+package keyusage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const (
+	// StoreName is the name of the store with last-used times of keys.
+	StoreName = "keyusage"
+
+	// keyStoreTagName is the tag of last-used times with the ID of the key store of the key.
+	keyStoreTagName = "key_store"
+)
+
+type usage struct {
+	KeyID    string    `json:"key_id"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+type entry struct {
+	saved time.Time // last-used time saved to storage
+	used  time.Time // last use seen by the process, possibly not saved yet
+}
+
+// Tracker records last-used times of keys. Writes are coalesced: a key's last-used time is saved at most once per
+// interval by the process, and later uses within the interval are kept in memory only. Saved times may therefore lag
+// by up to the interval, which is negligible for the time spans cleanup policies deal with.
+type Tracker struct {
+	store     storage.Store
+	clock     clock.Clock
+	interval  time.Duration
+	mutex     sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// New returns a new Tracker that saves the last-used time of a key at most once per interval.
+func New(provider storage.Provider, clk clock.Clock, interval time.Duration) (*Tracker, error) {
+	store, err := provider.OpenStore(StoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	err = provider.SetStoreConfig(StoreName, storage.StoreConfiguration{TagNames: []string{keyStoreTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set store config: %w", err)
+	}
+
+	return &Tracker{
+		store:     store,
+		clock:     clk,
+		interval:  interval,
+		entries:   make(map[string]*entry),
+		lastSweep: clk.Now(),
+	}, nil
+}
+
+// Touch records that the key was used now. The time is saved only if it wasn't saved within the interval.
+func (t *Tracker) Touch(keyStoreID, keyID string) error {
+	key := storageKey(keyStoreID, keyID)
+	now := t.clock.Now().UTC()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.sweep(now)
+
+	e, ok := t.entries[key]
+	if ok && now.Sub(e.saved) < t.interval {
+		if now.After(e.used) {
+			e.used = now
+		}
+
+		return nil
+	}
+
+	b, err := json.Marshal(&usage{KeyID: keyID, LastUsed: now})
+	if err != nil {
+		return fmt.Errorf("marshal last-used time: %w", err)
+	}
+
+	if err = t.store.Put(key, b, storage.Tag{Name: keyStoreTagName, Value: keyStoreID}); err != nil {
+		return fmt.Errorf("save last-used time: %w", err)
+	}
+
+	t.entries[key] = &entry{saved: now, used: now}
+
+	return nil
+}
+
+// LastUsed returns the time the key was last used, or nil if it wasn't used since tracking started.
+func (t *Tracker) LastUsed(keyStoreID, keyID string) (*time.Time, error) {
+	key := storageKey(keyStoreID, keyID)
+
+	b, err := t.store.Get(key)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("get last-used time: %w", err)
+	}
+
+	var lastUsed *time.Time
+
+	if err == nil {
+		var u usage
+
+		if err = json.Unmarshal(b, &u); err != nil {
+			return nil, fmt.Errorf("unmarshal last-used time: %w", err)
+		}
+
+		lastUsed = &u.LastUsed
+	}
+
+	return t.latest(key, lastUsed), nil
+}
+
+// LastUsedOfKeyStore returns last-used times of the keys of the key store by key ID. Keys that weren't used since
+// tracking started are not included.
+func (t *Tracker) LastUsedOfKeyStore(keyStoreID string) (map[string]time.Time, error) {
+	it, err := t.store.Query(fmt.Sprintf("%s:%s", keyStoreTagName, keyStoreID))
+	if err != nil {
+		return nil, fmt.Errorf("query last-used times: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	times := make(map[string]time.Time)
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next last-used time: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		b, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("last-used time value: %w", err)
+		}
+
+		var u usage
+
+		if err = json.Unmarshal(b, &u); err != nil {
+			return nil, fmt.Errorf("unmarshal last-used time: %w", err)
+		}
+
+		times[u.KeyID] = u.LastUsed
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	prefix := storageKey(keyStoreID, "")
+
+	for key, e := range t.entries {
+		keyID := strings.TrimPrefix(key, prefix)
+
+		if keyID != key && e.used.After(times[keyID]) {
+			times[keyID] = e.used
+		}
+	}
+
+	return times, nil
+}
+
+// Delete removes the last-used time of the key, e.g. when the key is purged.
+func (t *Tracker) Delete(keyStoreID, keyID string) error {
+	key := storageKey(keyStoreID, keyID)
+
+	t.mutex.Lock()
+	delete(t.entries, key)
+	t.mutex.Unlock()
+
+	if err := t.store.Delete(key); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("delete last-used time: %w", err)
+	}
+
+	return nil
+}
+
+// latest returns the later of the saved time and the last use seen by the process.
+func (t *Tracker) latest(key string, saved *time.Time) *time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if e, ok := t.entries[key]; ok && (saved == nil || e.used.After(*saved)) {
+		used := e.used
+
+		return &used
+	}
+
+	return saved
+}
+
+// sweep drops entries saved more than an interval ago, at most once per interval, so that memory is bounded by keys
+// used recently. The next use of a dropped key is saved again.
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.interval {
+		return
+	}
+
+	for key, e := range t.entries {
+		if now.Sub(e.saved) >= t.interval {
+			delete(t.entries, key)
+		}
+	}
+
+	t.lastSweep = now
+}
+
+func storageKey(keyStoreID, keyID string) string {
+	return keyStoreID + "/" + keyID
+}
@@ -0,0 +1,263 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package keyusage_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/keyusage"
+)
+
+var start = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTracker_Touch(t *testing.T) {
+	t.Run("Uses within the interval are coalesced", func(t *testing.T) {
+		provider := &countingProvider{Provider: mem.NewProvider()}
+		tracker, clk := newTracker(t, provider)
+
+		for i := 0; i < 100; i++ {
+			require.NoError(t, tracker.Touch("ks", "key"))
+			clk.Advance(time.Second)
+		}
+
+		require.EqualValues(t, 1, provider.puts())
+
+		lastUsed, err := tracker.LastUsed("ks", "key")
+		require.NoError(t, err)
+		require.Equal(t, start.Add(99*time.Second), *lastUsed)
+	})
+
+	t.Run("Use after the interval is saved", func(t *testing.T) {
+		provider := &countingProvider{Provider: mem.NewProvider()}
+		tracker, clk := newTracker(t, provider)
+
+		require.NoError(t, tracker.Touch("ks", "key"))
+
+		clk.Advance(time.Hour)
+
+		require.NoError(t, tracker.Touch("ks", "key"))
+		require.EqualValues(t, 2, provider.puts())
+
+		// another process sees the saved time
+		other, err := keyusage.New(provider, clk, time.Hour)
+		require.NoError(t, err)
+
+		lastUsed, err := other.LastUsed("ks", "key")
+		require.NoError(t, err)
+		require.Equal(t, start.Add(time.Hour), *lastUsed)
+	})
+
+	t.Run("Keys are coalesced separately", func(t *testing.T) {
+		provider := &countingProvider{Provider: mem.NewProvider()}
+		tracker, _ := newTracker(t, provider)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, tracker.Touch("ks", "key1"))
+			require.NoError(t, tracker.Touch("ks", "key2"))
+			require.NoError(t, tracker.Touch("other", "key1"))
+		}
+
+		require.EqualValues(t, 3, provider.puts())
+	})
+
+	t.Run("Concurrent uses are coalesced", func(t *testing.T) {
+		provider := &countingProvider{Provider: mem.NewProvider()}
+		tracker, _ := newTracker(t, provider)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				require.NoError(t, tracker.Touch("ks", "key"))
+			}()
+		}
+
+		wg.Wait()
+
+		require.EqualValues(t, 1, provider.puts())
+	})
+
+	t.Run("Fail to save", func(t *testing.T) {
+		tracker, _ := newTracker(t, &countingProvider{Provider: mem.NewProvider(), putErr: errors.New("put error")})
+
+		require.EqualError(t, tracker.Touch("ks", "key"), "save last-used time: put error")
+
+		lastUsed, err := tracker.LastUsed("ks", "key")
+		require.NoError(t, err)
+		require.Nil(t, lastUsed)
+	})
+}
+
+func TestTracker_LastUsed(t *testing.T) {
+	tracker, _ := newTracker(t, mem.NewProvider())
+
+	lastUsed, err := tracker.LastUsed("ks", "key")
+	require.NoError(t, err)
+	require.Nil(t, lastUsed)
+
+	require.NoError(t, tracker.Touch("ks", "key"))
+
+	lastUsed, err = tracker.LastUsed("ks", "key")
+	require.NoError(t, err)
+	require.Equal(t, start, *lastUsed)
+}
+
+func TestTracker_LastUsedOfKeyStore(t *testing.T) {
+	tracker, clk := newTracker(t, mem.NewProvider())
+
+	require.NoError(t, tracker.Touch("ks", "key1"))
+	clk.Advance(time.Minute)
+	require.NoError(t, tracker.Touch("ks", "key2"))
+	clk.Advance(time.Minute)
+	require.NoError(t, tracker.Touch("ks", "key1")) // coalesced
+	require.NoError(t, tracker.Touch("other", "key3"))
+
+	times, err := tracker.LastUsedOfKeyStore("ks")
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Time{
+		"key1": start.Add(2 * time.Minute),
+		"key2": start.Add(time.Minute),
+	}, times)
+
+	times, err = tracker.LastUsedOfKeyStore("unknown")
+	require.NoError(t, err)
+	require.Empty(t, times)
+}
+
+func TestTracker_Delete(t *testing.T) {
+	provider := &countingProvider{Provider: mem.NewProvider()}
+	tracker, _ := newTracker(t, provider)
+
+	require.NoError(t, tracker.Touch("ks", "key"))
+	require.NoError(t, tracker.Delete("ks", "key"))
+
+	lastUsed, err := tracker.LastUsed("ks", "key")
+	require.NoError(t, err)
+	require.Nil(t, lastUsed)
+
+	// the next use is saved again
+	require.NoError(t, tracker.Touch("ks", "key"))
+	require.EqualValues(t, 2, provider.puts())
+
+	require.NoError(t, tracker.Delete("ks", "unknown"))
+}
+
+func TestTracker_Sweep(t *testing.T) {
+	provider := &countingProvider{Provider: mem.NewProvider()}
+	tracker, clk := newTracker(t, provider)
+
+	require.NoError(t, tracker.Touch("ks", "key1"))
+
+	clk.Advance(2 * time.Hour)
+
+	// sweeps key1, whose next use is saved again
+	require.NoError(t, tracker.Touch("ks", "key2"))
+	require.NoError(t, tracker.Touch("ks", "key1"))
+	require.EqualValues(t, 3, provider.puts())
+
+	lastUsed, err := tracker.LastUsed("ks", "key1")
+	require.NoError(t, err)
+	require.Equal(t, start.Add(2*time.Hour), *lastUsed)
+}
+
+func TestNew(t *testing.T) {
+	_, err := keyusage.New(&countingProvider{Provider: mem.NewProvider(), openErr: errors.New("open error")},
+		testutil.NewFakeClock(start), time.Hour)
+	require.EqualError(t, err, "open store: open error")
+}
+
+// BenchmarkTracker_Touch measures tracking under a sign-like workload over a few hot keys, and checks that it doesn't
+// write to storage per use.
+func BenchmarkTracker_Touch(b *testing.B) {
+	const keys = 10
+
+	provider := &countingProvider{Provider: mem.NewProvider()}
+
+	tracker, err := keyusage.New(provider, testutil.NewFakeClock(start), time.Hour)
+	require.NoError(b, err)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err = tracker.Touch("ks", fmt.Sprintf("key%d", i%keys)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+
+	if n := provider.puts(); n > keys {
+		b.Fatalf("%d writes for %d uses of %d keys", n, b.N, keys)
+	}
+
+	b.ReportMetric(float64(provider.puts())/float64(b.N), "writes/op")
+}
+
+func newTracker(t *testing.T, provider storage.Provider) (*keyusage.Tracker, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(start)
+
+	tracker, err := keyusage.New(provider, clk, time.Hour)
+	require.NoError(t, err)
+
+	return tracker, clk
+}
+
+// countingProvider counts writes to its stores.
+type countingProvider struct {
+	storage.Provider
+	openErr error
+	putErr  error
+	count   int32
+}
+
+func (p *countingProvider) OpenStore(name string) (storage.Store, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingStore{Store: store, provider: p}, nil
+}
+
+func (p *countingProvider) puts() int32 {
+	return atomic.LoadInt32(&p.count)
+}
+
+type countingStore struct {
+	storage.Store
+	provider *countingProvider
+}
+
+func (s *countingStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if s.provider.putErr != nil {
+		return s.provider.putErr
+	}
+
+	atomic.AddInt32(&s.provider.count, 1)
+
+	return s.Store.Put(key, value, tags...)
+}
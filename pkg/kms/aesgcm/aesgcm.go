@@ -0,0 +1,160 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package aesgcm encrypts with AES-GCM keys of local key stores under nonces chosen by clients, for interop with
+// systems that manage nonces themselves. Tink always generates the nonce, so the key is read from the keyset here and
+// the ciphertext is laid out the way tink does, so that it decrypts with the crypto. A nonce must never be reused with
+// the same key: ReuseDetector reports nonces seen twice on a key within a window.
+package aesgcm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	aeadsubtle "github.com/google/tink/go/aead/subtle"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	gcmpb "github.com/google/tink/go/proto/aes_gcm_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const aesGCMKeyTypeURL = "type.googleapis.com/google.crypto.tink.AesGcmKey"
+
+// NonceSize is the size in bytes of AES-GCM nonces.
+const NonceSize = aeadsubtle.AESGCMIVSize
+
+var (
+	// ErrUnsupportedKey is returned when the primary key of the keyset isn't an AES-GCM key.
+	ErrUnsupportedKey = errors.New("key can't encrypt with a caller nonce, an AES-GCM key is required")
+	// ErrNonceSize is returned when the nonce isn't NonceSize bytes.
+	ErrNonceSize = fmt.Errorf("nonce must be %d bytes", NonceSize)
+)
+
+// EncryptKeyset encrypts the message with the primary AES-GCM key of a keyset handle under the nonce. The returned
+// ciphertext has the tag appended and no nonce, like ciphertexts of the crypto, so it decrypts with the nonce.
+func EncryptKeyset(msg, aad, nonce []byte, kh interface{}) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		return nil, ErrNonceSize
+	}
+
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is not a keyset", ErrUnsupportedKey)
+	}
+
+	// the key is read in memory only, like the crypto does to encrypt
+	key, err := primaryKey(insecurecleartextkeyset.KeysetMaterial(h))
+	if err != nil {
+		return nil, err
+	}
+
+	if key.KeyData.TypeUrl != aesGCMKeyTypeURL {
+		return nil, ErrUnsupportedKey
+	}
+
+	// legacy keys authenticate a zero byte appended to the message, local key stores never create them
+	if key.OutputPrefixType == tinkpb.OutputPrefixType_LEGACY {
+		return nil, errors.New("legacy output prefix is not supported")
+	}
+
+	pb := new(gcmpb.AesGcmKey)
+	if err = proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+		return nil, fmt.Errorf("invalid aes-gcm key: %w", err)
+	}
+
+	block, err := aes.NewCipher(pb.KeyValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aes-gcm key: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	return gcm.Seal(nil, nonce, msg, aad), nil
+}
+
+func primaryKey(ks *tinkpb.Keyset) (*tinkpb.Keyset_Key, error) {
+	for _, key := range ks.Key {
+		if key.KeyId == ks.PrimaryKeyId {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+type seenNonce struct {
+	id     string
+	seenAt time.Time
+}
+
+// ReuseDetector remembers nonces used with keys for a window. Nonces are kept in memory, so reuse is only detected
+// within the process.
+type ReuseDetector struct {
+	clock  clock.Clock
+	window time.Duration
+	mutex  sync.Mutex
+	seen   map[string]time.Time
+	order  []seenNonce // in the order nonces were seen, to prune expired ones
+}
+
+// NewReuseDetector returns a new ReuseDetector that remembers nonces for window.
+func NewReuseDetector(clk clock.Clock, window time.Duration) *ReuseDetector {
+	return &ReuseDetector{clock: clk, window: window, seen: map[string]time.Time{}}
+}
+
+// Seen records the nonce used with the key and returns true if it was already used with the key within the window.
+func (d *ReuseDetector) Seen(keyStoreID, keyID string, nonce []byte) bool {
+	id := nonceID(keyStoreID, keyID, nonce)
+	now := d.clock.Now()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for len(d.order) > 0 && now.Sub(d.order[0].seenAt) >= d.window {
+		// a nonce seen again was moved to the end, only its latest entry removes it
+		if d.seen[d.order[0].id].Equal(d.order[0].seenAt) {
+			delete(d.seen, d.order[0].id)
+		}
+
+		d.order = d.order[1:]
+	}
+
+	_, reused := d.seen[id]
+
+	d.seen[id] = now
+	d.order = append(d.order, seenNonce{id: id, seenAt: now})
+
+	return reused
+}
+
+func nonceID(keyStoreID, keyID string, nonce []byte) string {
+	h := sha256.New()
+
+	// length prefixes keep (key store, key, nonce) triples unambiguous
+	for _, b := range [][]byte{[]byte(keyStoreID), []byte(keyID), nonce} {
+		var l [8]byte
+
+		binary.BigEndian.PutUint64(l[:], uint64(len(b)))
+
+		h.Write(l[:])
+		h.Write(b)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,113 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package aesgcm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/aesgcm"
+)
+
+func TestEncryptKeyset(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	c, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	nonce := make([]byte, aesgcm.NonceSize)
+
+	for _, kt := range []kms.KeyType{kms.AES128GCMType, kms.AES256GCMType, kms.AES256GCMNoPrefixType} {
+		kt := kt
+
+		t.Run(string(kt), func(t *testing.T) {
+			_, kh, err := km.Create(kt)
+			require.NoError(t, err)
+
+			cipher, err := aesgcm.EncryptKeyset([]byte("content"), []byte("aad"), nonce, kh)
+			require.NoError(t, err)
+
+			plain, err := c.Decrypt(cipher, []byte("aad"), nonce, kh)
+			require.NoError(t, err)
+			require.Equal(t, []byte("content"), plain)
+		})
+	}
+
+	t.Run("Fail with invalid nonce size", func(t *testing.T) {
+		_, kh, err := km.Create(kms.AES256GCMType)
+		require.NoError(t, err)
+
+		_, err = aesgcm.EncryptKeyset([]byte("content"), nil, make([]byte, 24), kh)
+		require.ErrorIs(t, err, aesgcm.ErrNonceSize)
+	})
+
+	t.Run("Fail with unsupported key", func(t *testing.T) {
+		_, kh, err := km.Create(kms.ChaCha20Poly1305Type)
+		require.NoError(t, err)
+
+		_, err = aesgcm.EncryptKeyset([]byte("content"), nil, nonce, kh)
+		require.ErrorIs(t, err, aesgcm.ErrUnsupportedKey)
+
+		_, err = aesgcm.EncryptKeyset([]byte("content"), nil, nonce, "not a keyset")
+		require.ErrorIs(t, err, aesgcm.ErrUnsupportedKey)
+	})
+}
+
+func TestReuseDetector(t *testing.T) {
+	clk := &mockClock{now: time.Now()}
+	d := aesgcm.NewReuseDetector(clk, time.Minute)
+
+	nonce := []byte("nonce")
+
+	require.False(t, d.Seen("ks", "key", nonce))
+	require.True(t, d.Seen("ks", "key", nonce))
+	require.False(t, d.Seen("ks", "other key", nonce))
+	require.False(t, d.Seen("other ks", "key", nonce))
+	require.False(t, d.Seen("ks", "key", []byte("other nonce")))
+
+	clk.now = clk.now.Add(30 * time.Second)
+	require.True(t, d.Seen("ks", "key", nonce))
+
+	// the nonce was seen again 30 seconds ago, so the first sighting expiring doesn't forget it
+	clk.now = clk.now.Add(45 * time.Second)
+	require.True(t, d.Seen("ks", "key", nonce))
+
+	clk.now = clk.now.Add(time.Minute)
+	require.False(t, d.Seen("ks", "key", nonce))
+	require.False(t, d.Seen("ks", "other key", nonce))
+}
+
+type mockClock struct {
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	return c.now
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
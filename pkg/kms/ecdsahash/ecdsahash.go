@@ -0,0 +1,283 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ecdsahash signs and verifies with NIST ECDSA keys of local key stores using a hash other than the one of
+// the key type, e.g. P-256 with SHA-384 for systems that require that pairing. Tink pairs every curve with a single
+// hash and rejects keysets with other pairings, so keysets keep the parameters of their key type and the hash is
+// passed by the caller. The private key is read from the keyset here and signatures are encoded and prefixed the way
+// the tink signer does.
+package ecdsahash
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/cryptofmt"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	signaturesubtle "github.com/google/tink/go/signature/subtle"
+	"github.com/google/tink/go/subtle"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/kms/rfc6979"
+)
+
+const (
+	privateKeyTypeURL = "type.googleapis.com/google.crypto.tink.EcdsaPrivateKey"
+	publicKeyTypeURL  = "type.googleapis.com/google.crypto.tink.EcdsaPublicKey"
+)
+
+// Hashes of messages signed with ECDSA keys, named like in signature details.
+const (
+	SHA256 = "SHA-256"
+	SHA384 = "SHA-384"
+	SHA512 = "SHA-512"
+)
+
+// allowedHashes are the hashes keys of a curve can sign with, the hash of the key type first. A hash must be at least
+// as strong as the curve, so that it isn't the weakest part of the signature.
+var allowedHashes = map[string][]string{ //nolint:gochecknoglobals
+	"NIST_P256": {SHA256, SHA384, SHA512},
+	"NIST_P384": {SHA384, SHA512},
+	"NIST_P521": {SHA512},
+}
+
+var (
+	// ErrUnsupportedHash is returned when a key type can't sign with the hash.
+	ErrUnsupportedHash = errors.New("unsupported hash")
+	// ErrNotECDSAKey is returned when the primary key of the keyset isn't a NIST ECDSA key.
+	ErrNotECDSAKey = errors.New("key is not an ecdsa key")
+	// ErrInvalidSignature is returned when a signature doesn't verify.
+	ErrInvalidSignature = errors.New("invalid ecdsa signature")
+)
+
+// Allowed returns the hashes keys of the type can sign with, the default hash of the key type first. It returns nil
+// for key types other than NIST ECDSA.
+func Allowed(kt kms.KeyType) []string {
+	return allowedHashes[curveOf(kt)]
+}
+
+// IsDefault returns true if the hash is the one keys of the type sign with when no hash is selected.
+func IsDefault(kt kms.KeyType, hashName string) bool {
+	allowed := Allowed(kt)
+
+	return len(allowed) > 0 && allowed[0] == hashName
+}
+
+// Check fails with ErrUnsupportedHash, listing the allowed hashes, unless keys of the type can sign with the hash.
+func Check(kt kms.KeyType, hashName string) error {
+	allowed := Allowed(kt)
+	if allowed == nil {
+		return fmt.Errorf("%w: only ecdsa keys of nist curves can select a hash, not %s keys", ErrUnsupportedHash, kt)
+	}
+
+	for _, h := range allowed {
+		if h == hashName {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s keys can't sign with %q, allowed hashes: %s", ErrUnsupportedHash, kt, hashName,
+		strings.Join(allowed, ", "))
+}
+
+func curveOf(kt kms.KeyType) string {
+	switch kt { //nolint:exhaustive
+	case kms.ECDSAP256TypeDER, kms.ECDSAP256TypeIEEEP1363:
+		return "NIST_P256"
+	case kms.ECDSAP384TypeDER, kms.ECDSAP384TypeIEEEP1363:
+		return "NIST_P384"
+	case kms.ECDSAP521TypeDER, kms.ECDSAP521TypeIEEEP1363:
+		return "NIST_P521"
+	default:
+		return ""
+	}
+}
+
+// SignKeyset hashes the message with the hash and signs it with the primary key of a keyset handle of a NIST ECDSA
+// key. Nonces are random, or derived as specified in RFC 6979 if deterministic. The signature is encoded with the
+// encoding of the key parameters (DER or IEEE P1363).
+func SignKeyset(msg []byte, kh interface{}, hashName string, deterministic bool) ([]byte, error) {
+	ks, err := keysetOf(kh)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range ks.Key {
+		if key.KeyId == ks.PrimaryKeyId {
+			return signKey(key, msg, hashName, deterministic)
+		}
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+// VerifyKeyset verifies the signature of the message hashed with the hash with the keys of a keyset handle of a NIST
+// ECDSA key, private or public. Like with the tink verifier, any enabled key of the keyset whose output prefix the
+// signature starts with can verify it.
+func VerifyKeyset(signature, msg []byte, kh interface{}, hashName string) error {
+	ks, err := keysetOf(kh)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range ks.Key {
+		if key.Status != tinkpb.KeyStatusType_ENABLED {
+			continue
+		}
+
+		prefix, prefixErr := cryptofmt.OutputPrefix(key)
+		if prefixErr != nil || !bytes.HasPrefix(signature, []byte(prefix)) {
+			continue
+		}
+
+		if verifyKey(key, signature[len(prefix):], msg, hashName) == nil {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func keysetOf(kh interface{}) (*tinkpb.Keyset, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is not a keyset", ErrNotECDSAKey)
+	}
+
+	// the key is read in memory only, like the crypto does to sign
+	return insecurecleartextkeyset.KeysetMaterial(h), nil
+}
+
+func signKey(key *tinkpb.Keyset_Key, msg []byte, hashName string, deterministic bool) ([]byte, error) {
+	if key.KeyData.TypeUrl != privateKeyTypeURL {
+		return nil, ErrNotECDSAKey
+	}
+
+	// legacy keys sign the message with a zero byte appended, local key stores never create them
+	if key.OutputPrefixType == tinkpb.OutputPrefixType_LEGACY {
+		return nil, errors.New("legacy output prefix is not supported")
+	}
+
+	prefix, err := cryptofmt.OutputPrefix(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pb := new(ecdsapb.EcdsaPrivateKey)
+
+	if err = proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+		return nil, fmt.Errorf("invalid ecdsa private key: %w", err)
+	}
+
+	pub, hashFunc, encoding, err := publicKey(pb.GetPublicKey(), hashName)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := &ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(pb.KeyValue)}
+
+	var r, s *big.Int
+
+	if deterministic {
+		r, s, err = rfc6979.Sign(priv, hashFunc, msg)
+	} else {
+		r, s, err = ecdsa.Sign(rand.Reader, priv, digest(hashFunc, msg))
+	}
+
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	sig, err := signaturesubtle.NewECDSASignature(r, s).EncodeECDSASignature(encoding, pub.Curve.Params().Name)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return append([]byte(prefix), sig...), nil
+}
+
+func verifyKey(key *tinkpb.Keyset_Key, signature, msg []byte, hashName string) error {
+	pb := new(ecdsapb.EcdsaPublicKey)
+
+	switch key.KeyData.TypeUrl {
+	case publicKeyTypeURL:
+		if err := proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+			return fmt.Errorf("invalid ecdsa public key: %w", err)
+		}
+	case privateKeyTypeURL:
+		priv := new(ecdsapb.EcdsaPrivateKey)
+		if err := proto.Unmarshal(key.KeyData.Value, priv); err != nil {
+			return fmt.Errorf("invalid ecdsa private key: %w", err)
+		}
+
+		pb = priv.GetPublicKey()
+	default:
+		return ErrNotECDSAKey
+	}
+
+	pub, hashFunc, encoding, err := publicKey(pb, hashName)
+	if err != nil {
+		return err
+	}
+
+	sig, err := signaturesubtle.DecodeECDSASignature(signature, encoding)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !ecdsa.Verify(pub, digest(hashFunc, msg), sig.R, sig.S) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// publicKey returns the public key of the key proto, the hash function and the signature encoding. It fails unless
+// the curve of the key can sign with the hash.
+func publicKey(pb *ecdsapb.EcdsaPublicKey, hashName string) (*ecdsa.PublicKey, func() hash.Hash, string, error) {
+	params := pb.GetParams()
+	curveName := commonpb.EllipticCurveType_name[int32(params.GetCurve())]
+
+	allowed := false
+
+	for _, h := range allowedHashes[curveName] {
+		allowed = allowed || h == hashName
+	}
+
+	curve := subtle.GetCurve(curveName)
+	if curve == nil || !allowed {
+		return nil, nil, "", fmt.Errorf("%w: %s with %s", ErrUnsupportedHash, curveName, hashName)
+	}
+
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(pb.X),
+		Y:     new(big.Int).SetBytes(pb.Y),
+	}
+
+	// tink names hashes without a dash
+	hashFunc := subtle.GetHashFunc(strings.ReplaceAll(hashName, "-", ""))
+
+	return pub, hashFunc, ecdsapb.EcdsaSignatureEncoding_name[int32(params.GetEncoding())], nil
+}
+
+func digest(hashFunc func() hash.Hash, msg []byte) []byte {
+	h := hashFunc()
+	h.Write(msg) //nolint:errcheck // hash writes never fail
+
+	return h.Sum(nil)
+}
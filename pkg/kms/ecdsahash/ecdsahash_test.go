@@ -0,0 +1,220 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ecdsahash_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"math/big"
+	"testing"
+
+	"github.com/google/tink/go/keyset"
+	signaturesubtle "github.com/google/tink/go/signature/subtle"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/ecdsahash"
+)
+
+func TestCheck(t *testing.T) {
+	require.NoError(t, ecdsahash.Check(kms.ECDSAP256TypeDER, ecdsahash.SHA384))
+	require.NoError(t, ecdsahash.Check(kms.ECDSAP384TypeIEEEP1363, ecdsahash.SHA512))
+
+	err := ecdsahash.Check(kms.ECDSAP256TypeDER, "SHA-1")
+	require.ErrorIs(t, err, ecdsahash.ErrUnsupportedHash)
+	require.Contains(t, err.Error(), "allowed hashes: SHA-256, SHA-384, SHA-512")
+
+	err = ecdsahash.Check(kms.ECDSAP384TypeDER, ecdsahash.SHA256)
+	require.ErrorIs(t, err, ecdsahash.ErrUnsupportedHash)
+	require.Contains(t, err.Error(), "allowed hashes: SHA-384, SHA-512")
+
+	err = ecdsahash.Check(kms.ED25519Type, ecdsahash.SHA512)
+	require.ErrorIs(t, err, ecdsahash.ErrUnsupportedHash)
+
+	require.True(t, ecdsahash.IsDefault(kms.ECDSAP521TypeDER, ecdsahash.SHA512))
+	require.False(t, ecdsahash.IsDefault(kms.ECDSAP256TypeDER, ecdsahash.SHA384))
+	require.False(t, ecdsahash.IsDefault(kms.ED25519Type, ecdsahash.SHA512))
+}
+
+func TestSignKeyset(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	t.Run("RFC 6979 vectors", func(t *testing.T) {
+		// RFC 6979 appendix A.2.5, P-256 with SHA-384 and SHA-512
+		priv := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256()},
+			D:         hexInt(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"),
+		}
+		priv.X, priv.Y = priv.Curve.ScalarBaseMult(priv.D.Bytes())
+
+		_, kh, err := km.ImportPrivateKey(priv, kms.ECDSAP256TypeIEEEP1363)
+		require.NoError(t, err)
+
+		tests := []struct {
+			hash string
+			r    string
+			s    string
+		}{
+			{
+				hash: ecdsahash.SHA384,
+				r:    "0EAFEA039B20E9B42309FB1D89E213057CBF973DC0CFC8F129EDDDC800EF7719",
+				s:    "4861F0491E6998B9455193E34E7B0D284DDD7149A74B95B9261F13ABDE940954",
+			},
+			{
+				hash: ecdsahash.SHA512,
+				r:    "8496A60B5E9B47C825488827E0495B0E3FA109EC4568FD3F8D1097678EB97F00",
+				s:    "2362AB1ADBE2B8ADF9CB9EDAB740EA6049C028114F2460F96554F61FAE3302FE",
+			},
+		}
+
+		for _, tt := range tests {
+			sig, err := ecdsahash.SignKeyset([]byte("sample"), kh, tt.hash, true)
+			require.NoError(t, err)
+
+			decoded, err := signaturesubtle.DecodeECDSASignature(sig, "IEEE_P1363")
+			require.NoError(t, err)
+			require.Equal(t, hexInt(t, tt.r), decoded.R, tt.hash)
+			require.Equal(t, hexInt(t, tt.s), decoded.S, tt.hash)
+		}
+	})
+
+	tests := []struct {
+		keyType kms.KeyType
+		hash    string
+	}{
+		{kms.ECDSAP256TypeDER, ecdsahash.SHA384},
+		{kms.ECDSAP256TypeIEEEP1363, ecdsahash.SHA512},
+		{kms.ECDSAP384TypeDER, ecdsahash.SHA512},
+		{kms.ECDSAP521TypeIEEEP1363, ecdsahash.SHA512},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.keyType)+" "+tt.hash, func(t *testing.T) {
+			kid, _, err := km.Create(tt.keyType)
+			require.NoError(t, err)
+
+			kh, err := km.Get(kid)
+			require.NoError(t, err)
+
+			pubKH, err := kh.(*keyset.Handle).Public()
+			require.NoError(t, err)
+
+			for _, deterministic := range []bool{false, true} {
+				sig, err := ecdsahash.SignKeyset([]byte("message"), kh, tt.hash, deterministic)
+				require.NoError(t, err)
+
+				require.NoError(t, ecdsahash.VerifyKeyset(sig, []byte("message"), pubKH, tt.hash))
+				require.NoError(t, ecdsahash.VerifyKeyset(sig, []byte("message"), kh, tt.hash))
+
+				err = ecdsahash.VerifyKeyset(sig, []byte("other message"), pubKH, tt.hash)
+				require.ErrorIs(t, err, ecdsahash.ErrInvalidSignature)
+			}
+		})
+	}
+
+	t.Run("Signature of another hash doesn't verify", func(t *testing.T) {
+		kid, _, err := km.Create(kms.ECDSAP256TypeDER)
+		require.NoError(t, err)
+
+		kh, err := km.Get(kid)
+		require.NoError(t, err)
+
+		c, err := tinkcrypto.New()
+		require.NoError(t, err)
+
+		pubKH, err := kh.(*keyset.Handle).Public()
+		require.NoError(t, err)
+
+		sig, err := ecdsahash.SignKeyset([]byte("message"), kh, ecdsahash.SHA384, false)
+		require.NoError(t, err)
+		require.Error(t, c.Verify(sig, []byte("message"), pubKH))
+
+		sig, err = c.Sign([]byte("message"), kh)
+		require.NoError(t, err)
+
+		err = ecdsahash.VerifyKeyset(sig, []byte("message"), pubKH, ecdsahash.SHA384)
+		require.ErrorIs(t, err, ecdsahash.ErrInvalidSignature)
+
+		// the default hash signs like the crypto
+		require.NoError(t, ecdsahash.VerifyKeyset(sig, []byte("message"), pubKH, ecdsahash.SHA256))
+	})
+
+	t.Run("Signature verifies independently of the KMS", func(t *testing.T) {
+		kid, _, err := km.Create(kms.ECDSAP256TypeIEEEP1363)
+		require.NoError(t, err)
+
+		kh, err := km.Get(kid)
+		require.NoError(t, err)
+
+		pub, _, err := km.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		sig, err := ecdsahash.SignKeyset([]byte("message"), kh, ecdsahash.SHA384, false)
+		require.NoError(t, err)
+
+		x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+		require.NotNil(t, x)
+
+		digest := sha512.Sum384([]byte("message"))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+
+		require.True(t, ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s))
+	})
+
+	t.Run("Unsupported keys and hashes", func(t *testing.T) {
+		kid, _, err := km.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		kh, err := km.Get(kid)
+		require.NoError(t, err)
+
+		_, err = ecdsahash.SignKeyset([]byte("message"), kh, ecdsahash.SHA512, false)
+		require.ErrorIs(t, err, ecdsahash.ErrNotECDSAKey)
+
+		_, err = ecdsahash.SignKeyset([]byte("message"), "not a keyset", ecdsahash.SHA512, false)
+		require.ErrorIs(t, err, ecdsahash.ErrNotECDSAKey)
+
+		kid, _, err = km.Create(kms.ECDSAP384TypeDER)
+		require.NoError(t, err)
+
+		kh, err = km.Get(kid)
+		require.NoError(t, err)
+
+		_, err = ecdsahash.SignKeyset([]byte("message"), kh, ecdsahash.SHA256, false)
+		require.ErrorIs(t, err, ecdsahash.ErrUnsupportedHash)
+	})
+}
+
+func hexInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+
+	i, ok := new(big.Int).SetString(s, 16)
+	require.True(t, ok)
+
+	return i
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
@@ -0,0 +1,100 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ecdsasig converts ECDSA signatures between the ASN.1 DER format and the IEEE P1363 format, the fixed-size
+// concatenation r||s of the signature scalars.
+package ecdsasig
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrFormat is returned when a signature isn't in the format it's converted from.
+var ErrFormat = errors.New("invalid signature format")
+
+// scalarSizes are sizes in bytes of the signature scalars by curve, as named in sigparams.
+var scalarSizes = map[string]int{ //nolint:gochecknoglobals
+	"P-256":     32,
+	"P-384":     48,
+	"P-521":     66,
+	"secp256k1": 32,
+}
+
+type signature struct {
+	R, S *big.Int
+}
+
+// ScalarSize returns the size in bytes of the signature scalars of the curve, or zero if the curve isn't known.
+func ScalarSize(curve string) int {
+	return scalarSizes[curve]
+}
+
+// IsDER reports whether the signature is an ASN.1 DER signature with scalars of at most size bytes.
+func IsDER(sig []byte, size int) bool {
+	_, err := parseDER(sig, size)
+
+	return err == nil
+}
+
+// IsIEEEP1363 reports whether the signature has the size of an IEEE P1363 signature with scalars of size bytes.
+func IsIEEEP1363(sig []byte, size int) bool {
+	return size > 0 && len(sig) == 2*size
+}
+
+// ToIEEEP1363 converts the ASN.1 DER signature to the IEEE P1363 format with scalars of size bytes.
+func ToIEEEP1363(sig []byte, size int) ([]byte, error) {
+	rs, err := parseDER(sig, size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 2*size) //nolint:gomnd
+
+	rs.R.FillBytes(b[:size])
+	rs.S.FillBytes(b[size:])
+
+	return b, nil
+}
+
+// ToDER converts the IEEE P1363 signature with scalars of size bytes to the ASN.1 DER format.
+func ToDER(sig []byte, size int) ([]byte, error) {
+	if !IsIEEEP1363(sig, size) {
+		return nil, fmt.Errorf("%w: IEEE P1363 signature of %d-byte scalars must be %d bytes, got %d", ErrFormat,
+			size, 2*size, len(sig))
+	}
+
+	b, err := asn1.Marshal(signature{
+		R: new(big.Int).SetBytes(sig[:size]),
+		S: new(big.Int).SetBytes(sig[size:]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal der signature: %w", err)
+	}
+
+	return b, nil
+}
+
+func parseDER(sig []byte, size int) (*signature, error) {
+	var rs signature
+
+	rest, err := asn1.Unmarshal(sig, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unmarshal der signature: %s", ErrFormat, err)
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: trailing data after der signature", ErrFormat)
+	}
+
+	if rs.R.Sign() <= 0 || rs.S.Sign() <= 0 || rs.R.BitLen() > 8*size || rs.S.BitLen() > 8*size {
+		return nil, fmt.Errorf("%w: der signature scalars out of range", ErrFormat)
+	}
+
+	return &rs, nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ecdsasig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/ecdsasig"
+)
+
+func TestConvert(t *testing.T) {
+	digest := sha256.Sum256([]byte("test message"))
+
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		curve := curve
+
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			size := ecdsasig.ScalarSize(curve.Params().Name)
+			require.Equal(t, (curve.Params().BitSize+7)/8, size)
+
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			require.NoError(t, err)
+
+			der, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			require.NoError(t, err)
+			require.True(t, ecdsasig.IsDER(der, size))
+
+			p1363, err := ecdsasig.ToIEEEP1363(der, size)
+			require.NoError(t, err)
+			require.True(t, ecdsasig.IsIEEEP1363(p1363, size))
+			require.False(t, ecdsasig.IsDER(p1363, size))
+
+			r, s := new(big.Int).SetBytes(p1363[:size]), new(big.Int).SetBytes(p1363[size:])
+			require.True(t, ecdsa.Verify(&key.PublicKey, digest[:], r, s))
+
+			back, err := ecdsasig.ToDER(p1363, size)
+			require.NoError(t, err)
+			require.Equal(t, der, back)
+		})
+	}
+
+	t.Run("Short scalars are padded", func(t *testing.T) {
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{big.NewInt(1), big.NewInt(2)})
+		require.NoError(t, err)
+
+		p1363, err := ecdsasig.ToIEEEP1363(der, 32)
+		require.NoError(t, err)
+		require.Len(t, p1363, 64)
+		require.Equal(t, byte(1), p1363[31])
+		require.Equal(t, byte(2), p1363[63])
+	})
+
+	t.Run("Unknown curve", func(t *testing.T) {
+		require.Zero(t, ecdsasig.ScalarSize("P-224"))
+		require.False(t, ecdsasig.IsIEEEP1363(make([]byte, 64), 0))
+	})
+}
+
+func TestConvertErrors(t *testing.T) {
+	der := func(r, s *big.Int) []byte {
+		b, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+		require.NoError(t, err)
+
+		return b
+	}
+
+	tooLarge := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	for name, sig := range map[string][]byte{
+		"not asn.1":         []byte("signature"),
+		"trailing data":     append(der(big.NewInt(1), big.NewInt(2)), 0),
+		"zero scalar":       der(big.NewInt(0), big.NewInt(2)),
+		"negative scalar":   der(big.NewInt(1), big.NewInt(-2)),
+		"scalar too large":  der(tooLarge, big.NewInt(2)),
+		"p1363 is not asn1": make([]byte, 64),
+	} {
+		_, err := ecdsasig.ToIEEEP1363(sig, 32)
+		require.True(t, errors.Is(err, ecdsasig.ErrFormat), name)
+		require.False(t, ecdsasig.IsDER(sig, 32), name)
+	}
+
+	_, err := ecdsasig.ToDER(make([]byte, 63), 32)
+	require.True(t, errors.Is(err, ecdsasig.ErrFormat))
+	require.EqualError(t, err, "invalid signature format: IEEE P1363 signature of 32-byte scalars must be 64 bytes, got 63")
+}
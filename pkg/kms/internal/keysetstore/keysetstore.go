@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package keysetstore writes Tink keysets to the store of a local KMS, for key types that the local KMS can't create
+// itself. Keysets are encrypted with the primary key of the local KMS, so that it reads them like its own keys.
+package keysetstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/tink/go/aead"
+	"github.com/google/tink/go/keyset"
+	"github.com/google/tink/go/tink"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/store/wrapper/prefix"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// Store is the keyset store of a local KMS.
+type Store struct {
+	store             storage.Store
+	primaryKeyEnvAEAD tink.AEAD
+}
+
+// New returns the keyset store of the local KMS. primaryKeyURI and the provider must be the ones the local KMS was
+// created with.
+func New(primaryKeyURI string, p kms.Provider) (*Store, error) {
+	i := strings.Index(primaryKeyURI, "://")
+	if i <= 0 || i+len("://") == len(primaryKeyURI) {
+		return nil, fmt.Errorf("invalid primary key uri: %s", primaryKeyURI)
+	}
+
+	s, err := p.StorageProvider().OpenStore(localkms.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("open key store: %w", err)
+	}
+
+	store, err := prefix.NewPrefixStoreWrapper(s, prefix.StorageKIDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key store: %w", err)
+	}
+
+	return &Store{
+		store: store,
+		primaryKeyEnvAEAD: aead.NewKMSEnvelopeAEAD2(aead.AES256GCMKeyTemplate(), &secretLockAEAD{
+			keyURI:     primaryKeyURI[i+len("://"):],
+			secretLock: p.SecretLock(),
+		}),
+	}, nil
+}
+
+// Put writes the keyset encrypted with the primary key, like the local KMS writes keysets, under the key ID. It fails
+// if a key with the ID exists.
+func (s *Store) Put(keyID string, kh *keyset.Handle) error {
+	if _, err := s.store.Get(keyID); err == nil {
+		return fmt.Errorf("key %s already exists", keyID)
+	} else if !errors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("check key %s: %w", keyID, err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := kh.Write(keyset.NewJSONWriter(buf), s.primaryKeyEnvAEAD); err != nil {
+		return fmt.Errorf("write keyset: %w", err)
+	}
+
+	if err := s.store.Put(keyID, buf.Bytes()); err != nil {
+		return fmt.Errorf("store key %s: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// Delete deletes the keyset of the key.
+func (s *Store) Delete(keyID string) error {
+	return s.store.Delete(keyID) //nolint:wrapcheck
+}
+
+// secretLockAEAD encrypts keysets with the primary key in the secret lock, as the local KMS does.
+type secretLockAEAD struct {
+	keyURI     string
+	secretLock secretlock.Service
+}
+
+func (a *secretLockAEAD) Encrypt(plaintext, additionalData []byte) ([]byte, error) {
+	resp, err := a.secretLock.Encrypt(a.keyURI, &secretlock.EncryptRequest{
+		Plaintext:                   base64.URLEncoding.EncodeToString(plaintext),
+		AdditionalAuthenticatedData: base64.URLEncoding.EncodeToString(additionalData),
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return base64.URLEncoding.DecodeString(resp.Ciphertext) //nolint:wrapcheck
+}
+
+func (a *secretLockAEAD) Decrypt(ciphertext, additionalData []byte) ([]byte, error) {
+	resp, err := a.secretLock.Decrypt(a.keyURI, &secretlock.DecryptRequest{
+		Ciphertext:                  base64.URLEncoding.EncodeToString(ciphertext),
+		AdditionalAuthenticatedData: base64.URLEncoding.EncodeToString(additionalData),
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return base64.URLEncoding.DecodeString(resp.Plaintext) //nolint:wrapcheck
+}
@@ -0,0 +1,241 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package prehash signs digests computed by clients with Ed25519 and NIST ECDSA keys of local key stores, so that
+// large payloads don't have to be sent to the server. Ed25519 keys sign with Ed25519ph (RFC 8032 section 5.1) over a
+// SHA-512 digest; ECDSA keys sign the digest of the hash of the key parameters, which is what ECDSA signs anyway.
+// Tink only signs messages, so the private key is read from the keyset here and the signature is encoded and
+// prefixed the way the tink signer does.
+package prehash
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/cryptofmt"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	ed25519pb "github.com/google/tink/go/proto/ed25519_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	signaturesubtle "github.com/google/tink/go/signature/subtle"
+	"github.com/google/tink/go/subtle"
+)
+
+const (
+	ecdsaPrivateKeyTypeURL   = "type.googleapis.com/google.crypto.tink.EcdsaPrivateKey"
+	ed25519PrivateKeyTypeURL = "type.googleapis.com/google.crypto.tink.Ed25519PrivateKey"
+)
+
+// Algorithm is a signature algorithm over a digest.
+type Algorithm string
+
+// Algorithms of prehashed signatures.
+const (
+	// Ed25519ph signatures are verified with Ed25519ph against the SHA-512 digest, not as Ed25519 signatures of the
+	// message.
+	Ed25519ph Algorithm = "Ed25519ph"
+	// ECDSAP256SHA256 signatures verify as ECDSA signatures of the message, or of the SHA-256 digest.
+	ECDSAP256SHA256 Algorithm = "ECDSA-P256-SHA256"
+	// ECDSAP384SHA384 signatures verify as ECDSA signatures of the message, or of the SHA-384 digest.
+	ECDSAP384SHA384 Algorithm = "ECDSA-P384-SHA384"
+	// ECDSAP521SHA512 signatures verify as ECDSA signatures of the message, or of the SHA-512 digest.
+	ECDSAP521SHA512 Algorithm = "ECDSA-P521-SHA512"
+)
+
+// Hash returns the hash that computes digests signed with the algorithm.
+func (a Algorithm) Hash() crypto.Hash {
+	switch a {
+	case ECDSAP256SHA256:
+		return crypto.SHA256
+	case ECDSAP384SHA384:
+		return crypto.SHA384
+	default:
+		return crypto.SHA512
+	}
+}
+
+// DigestSize returns the size in bytes of digests signed with the algorithm.
+func (a Algorithm) DigestSize() int {
+	return a.Hash().Size()
+}
+
+// Verification describes how signatures of the algorithm are verified.
+func (a Algorithm) Verification() string {
+	if a == Ed25519ph {
+		return "verify with Ed25519ph (RFC 8032) against the SHA-512 digest of the message; the signature doesn't " +
+			"verify as an Ed25519 signature of the message"
+	}
+
+	return "verifies as an ECDSA signature of the message"
+}
+
+var (
+	// ErrUnsupportedKey is returned when the primary key of the keyset can't sign digests.
+	ErrUnsupportedKey = errors.New("key can't sign digests, an ed25519 or ecdsa key is required")
+	// ErrDigestSize is returned when the size of the digest doesn't match the algorithm of the key.
+	ErrDigestSize = errors.New("invalid digest size")
+)
+
+// AlgorithmOf returns the algorithm the primary key of a keyset handle signs digests with.
+func AlgorithmOf(kh interface{}) (Algorithm, error) {
+	key, err := primaryKey(kh)
+	if err != nil {
+		return "", err
+	}
+
+	switch key.KeyData.TypeUrl {
+	case ed25519PrivateKeyTypeURL:
+		return Ed25519ph, nil
+	case ecdsaPrivateKeyTypeURL:
+		pb := new(ecdsapb.EcdsaPrivateKey)
+		if err = proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+			return "", fmt.Errorf("invalid ecdsa private key: %w", err)
+		}
+
+		return ecdsaAlgorithm(pb.GetPublicKey().GetParams())
+	default:
+		return "", ErrUnsupportedKey
+	}
+}
+
+// SignKeyset signs the digest with the primary key of a keyset handle of an Ed25519 or ECDSA key. ECDSA signatures
+// are encoded with the encoding of the key parameters (DER or IEEE P1363), so they verify like signatures of the
+// crypto.
+func SignKeyset(digest []byte, kh interface{}) ([]byte, error) {
+	key, err := primaryKey(kh)
+	if err != nil {
+		return nil, err
+	}
+
+	// legacy keys sign the message with a zero byte appended, local key stores never create them
+	if key.OutputPrefixType == tinkpb.OutputPrefixType_LEGACY {
+		return nil, errors.New("legacy output prefix is not supported")
+	}
+
+	prefix, err := cryptofmt.OutputPrefix(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+
+	switch key.KeyData.TypeUrl {
+	case ed25519PrivateKeyTypeURL:
+		sig, err = signEd25519ph(key, digest)
+	case ecdsaPrivateKeyTypeURL:
+		sig, err = signECDSA(key, digest)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(prefix), sig...), nil
+}
+
+func primaryKey(kh interface{}) (*tinkpb.Keyset_Key, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is not a keyset", ErrUnsupportedKey)
+	}
+
+	// the key is read in memory only, like the crypto does to sign
+	ks := insecurecleartextkeyset.KeysetMaterial(h)
+
+	for _, key := range ks.Key {
+		if key.KeyId == ks.PrimaryKeyId {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+func signEd25519ph(key *tinkpb.Keyset_Key, digest []byte) ([]byte, error) {
+	if err := checkDigestSize(Ed25519ph, digest); err != nil {
+		return nil, err
+	}
+
+	pb := new(ed25519pb.Ed25519PrivateKey)
+	if err := proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+		return nil, fmt.Errorf("invalid ed25519 private key: %w", err)
+	}
+
+	if len(pb.KeyValue) != ed25519.SeedSize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+
+	return ed25519.NewKeyFromSeed(pb.KeyValue).Sign(nil, digest, &ed25519.Options{Hash: crypto.SHA512})
+}
+
+func signECDSA(key *tinkpb.Keyset_Key, digest []byte) ([]byte, error) {
+	pb := new(ecdsapb.EcdsaPrivateKey)
+	if err := proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+		return nil, fmt.Errorf("invalid ecdsa private key: %w", err)
+	}
+
+	params := pb.GetPublicKey().GetParams()
+
+	algorithm, err := ecdsaAlgorithm(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkDigestSize(algorithm, digest); err != nil {
+		return nil, err
+	}
+
+	curve := subtle.GetCurve(commonpb.EllipticCurveType_name[int32(params.GetCurve())])
+	encoding := ecdsapb.EcdsaSignatureEncoding_name[int32(params.GetEncoding())]
+
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(pb.PublicKey.X),
+			Y:     new(big.Int).SetBytes(pb.PublicKey.Y),
+		},
+		D: new(big.Int).SetBytes(pb.KeyValue),
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return signaturesubtle.NewECDSASignature(r, s).EncodeECDSASignature(encoding, curve.Params().Name)
+}
+
+func ecdsaAlgorithm(params *ecdsapb.EcdsaParams) (Algorithm, error) {
+	switch {
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P256 && params.GetHashType() == commonpb.HashType_SHA256:
+		return ECDSAP256SHA256, nil
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P384 && params.GetHashType() == commonpb.HashType_SHA384:
+		return ECDSAP384SHA384, nil
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P521 && params.GetHashType() == commonpb.HashType_SHA512:
+		return ECDSAP521SHA512, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported ecdsa parameters", ErrUnsupportedKey)
+	}
+}
+
+func checkDigestSize(algorithm Algorithm, digest []byte) error {
+	if len(digest) != algorithm.DigestSize() {
+		return fmt.Errorf("%w: %s signs %d-byte digests, got %d bytes", ErrDigestSize, algorithm,
+			algorithm.DigestSize(), len(digest))
+	}
+
+	return nil
+}
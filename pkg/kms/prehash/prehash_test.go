@@ -0,0 +1,134 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package prehash_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/prehash"
+)
+
+func TestSignKeyset(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	c, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	message := []byte("large artifact")
+
+	t.Run("Ed25519ph", func(t *testing.T) {
+		kid, kh, err := km.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		algorithm, err := prehash.AlgorithmOf(kh)
+		require.NoError(t, err)
+		require.Equal(t, prehash.Ed25519ph, algorithm)
+		require.Contains(t, algorithm.Verification(), "doesn't verify as an Ed25519 signature")
+
+		h := crypto.SHA512.New()
+		h.Write(message)
+		digest := h.Sum(nil)
+
+		sig, err := prehash.SignKeyset(digest, kh)
+		require.NoError(t, err)
+
+		pub, _, err := km.ExportPubKeyBytes(kid)
+		require.NoError(t, err)
+
+		require.NoError(t, ed25519.VerifyWithOptions(pub, digest, sig, &ed25519.Options{Hash: crypto.SHA512}))
+
+		// an Ed25519ph signature isn't an Ed25519 signature of the message
+		pubKH, err := kh.(*keyset.Handle).Public()
+		require.NoError(t, err)
+		require.Error(t, c.Verify(sig, message, pubKH))
+
+		_, err = prehash.SignKeyset(digest[:32], kh)
+		require.ErrorIs(t, err, prehash.ErrDigestSize)
+		require.EqualError(t, err, "invalid digest size: Ed25519ph signs 64-byte digests, got 32 bytes")
+	})
+
+	tests := []struct {
+		keyType   kms.KeyType
+		algorithm prehash.Algorithm
+		hash      crypto.Hash
+	}{
+		{kms.ECDSAP256TypeDER, prehash.ECDSAP256SHA256, crypto.SHA256},
+		{kms.ECDSAP384TypeDER, prehash.ECDSAP384SHA384, crypto.SHA384},
+		{kms.ECDSAP521TypeDER, prehash.ECDSAP521SHA512, crypto.SHA512},
+		{kms.ECDSAP256TypeIEEEP1363, prehash.ECDSAP256SHA256, crypto.SHA256},
+		{kms.ECDSAP384TypeIEEEP1363, prehash.ECDSAP384SHA384, crypto.SHA384},
+		{kms.ECDSAP521TypeIEEEP1363, prehash.ECDSAP521SHA512, crypto.SHA512},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			_, kh, err := km.Create(tt.keyType)
+			require.NoError(t, err)
+
+			algorithm, err := prehash.AlgorithmOf(kh)
+			require.NoError(t, err)
+			require.Equal(t, tt.algorithm, algorithm)
+			require.Equal(t, tt.hash, algorithm.Hash())
+			require.Equal(t, tt.hash.Size(), algorithm.DigestSize())
+			require.Equal(t, "verifies as an ECDSA signature of the message", algorithm.Verification())
+
+			h := tt.hash.New()
+			h.Write(message)
+
+			sig, err := prehash.SignKeyset(h.Sum(nil), kh)
+			require.NoError(t, err)
+
+			// the signature of the digest verifies as a signature of the message
+			pubKH, err := kh.(*keyset.Handle).Public()
+			require.NoError(t, err)
+			require.NoError(t, c.Verify(sig, message, pubKH))
+
+			_, err = prehash.SignKeyset(message, kh)
+			require.ErrorIs(t, err, prehash.ErrDigestSize)
+		})
+	}
+
+	t.Run("Unsupported key", func(t *testing.T) {
+		_, kh, err := km.Create(kms.HMACSHA256Tag256Type)
+		require.NoError(t, err)
+
+		_, err = prehash.AlgorithmOf(kh)
+		require.ErrorIs(t, err, prehash.ErrUnsupportedKey)
+
+		_, err = prehash.SignKeyset(make([]byte, 64), kh)
+		require.ErrorIs(t, err, prehash.ErrUnsupportedKey)
+
+		_, err = prehash.SignKeyset(make([]byte, 64), "not a keyset")
+		require.ErrorIs(t, err, prehash.ErrUnsupportedKey)
+	})
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
@@ -0,0 +1,243 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package rfc6979 signs with NIST ECDSA keys of local key stores using deterministic nonces (RFC 6979), so that the
+// same key and message always produce the same signature. Tink only signs with random nonces, so the private key is
+// read from the keyset here and the signature is encoded and prefixed the way the tink signer does.
+package rfc6979
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/cryptofmt"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	signaturesubtle "github.com/google/tink/go/signature/subtle"
+	"github.com/google/tink/go/subtle"
+)
+
+const privateKeyTypeURL = "type.googleapis.com/google.crypto.tink.EcdsaPrivateKey"
+
+// ErrNotECDSAKey is returned when the primary key of the keyset isn't a NIST ECDSA private key.
+var ErrNotECDSAKey = errors.New("key is not an ecdsa private key")
+
+// SignKeyset signs the message with the primary key of a keyset handle of an ECDSA key. The message is hashed with
+// the hash of the key parameters and the signature is encoded with the encoding of the key parameters (DER or
+// IEEE P1363), so it verifies like a signature of the crypto.
+func SignKeyset(msg []byte, kh interface{}) ([]byte, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is not a keyset", ErrNotECDSAKey)
+	}
+
+	// the key is read in memory only, like the crypto does to sign
+	ks := insecurecleartextkeyset.KeysetMaterial(h)
+
+	for _, key := range ks.Key {
+		if key.KeyId != ks.PrimaryKeyId {
+			continue
+		}
+
+		return signKey(key, msg)
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+func signKey(key *tinkpb.Keyset_Key, msg []byte) ([]byte, error) {
+	if key.KeyData.TypeUrl != privateKeyTypeURL {
+		return nil, ErrNotECDSAKey
+	}
+
+	// legacy keys sign the message with a zero byte appended, local key stores never create them
+	if key.OutputPrefixType == tinkpb.OutputPrefixType_LEGACY {
+		return nil, errors.New("legacy output prefix is not supported")
+	}
+
+	prefix, err := cryptofmt.OutputPrefix(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pb := new(ecdsapb.EcdsaPrivateKey)
+
+	if err = proto.Unmarshal(key.KeyData.Value, pb); err != nil {
+		return nil, fmt.Errorf("invalid ecdsa private key: %w", err)
+	}
+
+	params := pb.GetPublicKey().GetParams()
+	hashName := commonpb.HashType_name[int32(params.GetHashType())]
+	encoding := ecdsapb.EcdsaSignatureEncoding_name[int32(params.GetEncoding())]
+
+	curve := subtle.GetCurve(commonpb.EllipticCurveType_name[int32(params.GetCurve())])
+	hashFunc := subtle.GetHashFunc(hashName)
+
+	if curve == nil || hashFunc == nil {
+		return nil, errors.New("unsupported ecdsa parameters")
+	}
+
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(pb.PublicKey.X),
+			Y:     new(big.Int).SetBytes(pb.PublicKey.Y),
+		},
+		D: new(big.Int).SetBytes(pb.KeyValue),
+	}
+
+	r, s, err := Sign(priv, hashFunc, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signaturesubtle.NewECDSASignature(r, s).EncodeECDSASignature(encoding, curve.Params().Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(prefix), sig...), nil
+}
+
+// Sign hashes the message with the hash function and signs the hash with the private key, deriving the nonce from
+// the key and the hash as specified in RFC 6979 section 3.2.
+func Sign(priv *ecdsa.PrivateKey, hashFunc func() hash.Hash, msg []byte) (*big.Int, *big.Int, error) {
+	if priv == nil || priv.Curve == nil || priv.D == nil {
+		return nil, nil, errors.New("invalid ecdsa private key")
+	}
+
+	n := priv.Curve.Params().N
+
+	if priv.D.Sign() <= 0 || priv.D.Cmp(n) >= 0 {
+		return nil, nil, errors.New("invalid ecdsa private key")
+	}
+
+	hf := hashFunc()
+	hf.Write(msg) //nolint:errcheck // hash writes never fail
+
+	digest := hf.Sum(nil)
+	e := bits2int(digest, n)
+	nonces := newNonceGenerator(priv.D, digest, n, hashFunc)
+
+	for {
+		k := nonces.next()
+
+		r, s := sign(priv.Curve, priv.D, e, k)
+		if r.Sign() != 0 && s.Sign() != 0 {
+			return r, s, nil
+		}
+	}
+}
+
+// sign returns the ECDSA signature of the truncated hash e with the private key d and the nonce k, (0, 0) if
+// either part is zero and another nonce must be used.
+func sign(curve elliptic.Curve, d, e, k *big.Int) (*big.Int, *big.Int) {
+	n := curve.Params().N
+
+	x, _ := curve.ScalarBaseMult(k.FillBytes(make([]byte, (n.BitLen()+7)/8)))
+	r := new(big.Int).Mod(x, n)
+
+	if r.Sign() == 0 {
+		return r, r
+	}
+
+	s := new(big.Int).Mul(d, r)
+	s.Add(s, e)
+	s.Mul(s, new(big.Int).ModInverse(k, n))
+	s.Mod(s, n)
+
+	return r, s
+}
+
+// nonceGenerator is the HMAC_DRBG of RFC 6979 section 3.2, it yields the candidate nonces in order.
+type nonceGenerator struct {
+	n        *big.Int
+	hashFunc func() hash.Hash
+	k        []byte
+	v        []byte
+	started  bool
+}
+
+func newNonceGenerator(d *big.Int, digest []byte, n *big.Int, hashFunc func() hash.Hash) *nonceGenerator {
+	size := hashFunc().Size()
+	g := &nonceGenerator{n: n, hashFunc: hashFunc, k: make([]byte, size), v: make([]byte, size)}
+
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+
+	x := int2octets(d, n)
+	h := int2octets(new(big.Int).Mod(bits2int(digest, n), n), n)
+
+	// steps d to g
+	g.k = g.mac(g.v, []byte{0x00}, x, h)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, x, h)
+	g.v = g.mac(g.v)
+
+	return g
+}
+
+// next returns the next nonce in [1, n-1] (step h).
+func (g *nonceGenerator) next() *big.Int {
+	rlen := (g.n.BitLen() + 7) / 8
+
+	for {
+		if g.started {
+			g.k = g.mac(g.v, []byte{0x00})
+			g.v = g.mac(g.v)
+		}
+
+		g.started = true
+
+		var t []byte
+
+		for len(t) < rlen {
+			g.v = g.mac(g.v)
+			t = append(t, g.v...)
+		}
+
+		k := bits2int(t, g.n)
+		if k.Sign() > 0 && k.Cmp(g.n) < 0 {
+			return k
+		}
+	}
+}
+
+func (g *nonceGenerator) mac(data ...[]byte) []byte {
+	m := hmac.New(g.hashFunc, g.k)
+
+	for _, d := range data {
+		m.Write(d) //nolint:errcheck // hash writes never fail
+	}
+
+	return m.Sum(nil)
+}
+
+// bits2int converts the leftmost bits of b to an integer of at most the bit length of n (RFC 6979 section 2.3.2).
+func bits2int(b []byte, n *big.Int) *big.Int {
+	i := new(big.Int).SetBytes(b)
+
+	if excess := len(b)*8 - n.BitLen(); excess > 0 {
+		i.Rsh(i, uint(excess))
+	}
+
+	return i
+}
+
+// int2octets converts the integer to a byte string of the byte length of n (RFC 6979 section 2.3.3).
+func int2octets(i, n *big.Int) []byte {
+	return i.FillBytes(make([]byte, (n.BitLen()+7)/8))
+}
@@ -0,0 +1,140 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rfc6979_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/rfc6979"
+)
+
+func TestSign(t *testing.T) {
+	// RFC 6979 appendix A.2.5, P-256 with SHA-256
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: elliptic.P256()},
+		D:         hexInt(t, "C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721"),
+	}
+	priv.X, priv.Y = priv.Curve.ScalarBaseMult(priv.D.Bytes())
+
+	tests := []struct {
+		msg string
+		r   string
+		s   string
+	}{
+		{
+			msg: "sample",
+			r:   "EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716",
+			s:   "F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8",
+		},
+		{
+			msg: "test",
+			r:   "F1ABB023518351CD71D881567B1EA663ED3EFCF6C5132B354F28D3B0B7D38367",
+			s:   "019F4113742A2B14BD25926B49C649155F267E60D3814B4C0CC84250E46F0083",
+		},
+	}
+
+	for _, tt := range tests {
+		r, s, err := rfc6979.Sign(priv, sha256.New, []byte(tt.msg))
+		require.NoError(t, err)
+		require.Equal(t, hexInt(t, tt.r), r, tt.msg)
+		require.Equal(t, hexInt(t, tt.s), s, tt.msg)
+	}
+
+	t.Run("Invalid private key", func(t *testing.T) {
+		_, _, err := rfc6979.Sign(&ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: elliptic.P256()},
+			D: big.NewInt(0)}, sha256.New, []byte("sample"))
+		require.EqualError(t, err, "invalid ecdsa private key")
+	})
+}
+
+func TestSignKeyset(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	c, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	keyTypes := []kms.KeyType{
+		kms.ECDSAP256TypeDER, kms.ECDSAP384TypeDER, kms.ECDSAP521TypeDER,
+		kms.ECDSAP256TypeIEEEP1363, kms.ECDSAP384TypeIEEEP1363, kms.ECDSAP521TypeIEEEP1363,
+	}
+
+	for _, kt := range keyTypes {
+		t.Run(string(kt), func(t *testing.T) {
+			kid, _, err := km.Create(kt)
+			require.NoError(t, err)
+
+			kh, err := km.Get(kid)
+			require.NoError(t, err)
+
+			sig, err := rfc6979.SignKeyset([]byte("message"), kh)
+			require.NoError(t, err)
+
+			again, err := rfc6979.SignKeyset([]byte("message"), kh)
+			require.NoError(t, err)
+			require.Equal(t, sig, again)
+
+			pubKH, err := kh.(*keyset.Handle).Public()
+			require.NoError(t, err)
+			require.NoError(t, c.Verify(sig, []byte("message"), pubKH))
+
+			other, err := rfc6979.SignKeyset([]byte("other message"), kh)
+			require.NoError(t, err)
+			require.NotEqual(t, sig, other)
+		})
+	}
+
+	t.Run("Not an ECDSA key", func(t *testing.T) {
+		kid, _, err := km.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		kh, err := km.Get(kid)
+		require.NoError(t, err)
+
+		_, err = rfc6979.SignKeyset([]byte("message"), kh)
+		require.ErrorIs(t, err, rfc6979.ErrNotECDSAKey)
+
+		_, err = rfc6979.SignKeyset([]byte("message"), "not a keyset")
+		require.ErrorIs(t, err, rfc6979.ErrNotECDSAKey)
+	})
+}
+
+func hexInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+
+	i, ok := new(big.Int).SetString(s, 16)
+	require.True(t, ok)
+
+	return i
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
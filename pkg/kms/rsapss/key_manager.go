@@ -0,0 +1,298 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rsapss
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	rsapb "github.com/google/tink/go/proto/rsa_ssa_pss_go_proto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/kms/internal/keysetstore"
+)
+
+// KeyManager is a local KMS that supports RSA-PSS keys. Keys of other types are handled by the wrapped key manager,
+// e.g. a secp256k1.KeyManager. RSA-PSS keys are written to the store of the local KMS, encrypted with its primary
+// key, so that the local KMS reads them like its own keys.
+type KeyManager struct {
+	kms.KeyManager
+	keysets *keysetstore.Store
+}
+
+// Wrap adds RSA-PSS keys to a local KMS. primaryKeyURI and the provider must be the ones the local KMS was created
+// with.
+func Wrap(km kms.KeyManager, primaryKeyURI string, p kms.Provider) (*KeyManager, error) {
+	keysets, err := keysetstore.New(primaryKeyURI, p)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &KeyManager{KeyManager: km, keysets: keysets}, nil
+}
+
+// Create creates a key of the type. RSA-PSS keys are DefaultKeySize bits; to create keys of other sizes, generate
+// them and import them with ImportPrivateKey. The ID of an RSA-PSS key is its JWK thumbprint.
+func (m *KeyManager) Create(kt kms.KeyType) (string, interface{}, error) {
+	if kt != KeyType {
+		return m.KeyManager.Create(kt) //nolint:wrapcheck
+	}
+
+	template, err := keyTemplate(DefaultKeySize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kh, err := keyset.NewHandle(template)
+	if err != nil {
+		return "", nil, fmt.Errorf("create keyset: %w", err)
+	}
+
+	kid, err := m.storeKeyset(kh, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, kh, nil
+}
+
+// Rotate adds a new primary key of the type to the keyset of the key, and stores the keyset under the ID of the new
+// key. A new RSA-PSS key has the size of the rotated key if that's an RSA-PSS key, DefaultKeySize otherwise.
+func (m *KeyManager) Rotate(kt kms.KeyType, keyID string) (string, interface{}, error) {
+	if kt != KeyType {
+		return m.KeyManager.Rotate(kt, keyID) //nolint:wrapcheck
+	}
+
+	kh, err := m.KeyManager.Get(keyID)
+	if err != nil {
+		return "", nil, fmt.Errorf("get key: %w", err)
+	}
+
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return "", nil, fmt.Errorf("key %s is not a keyset", keyID)
+	}
+
+	size := DefaultKeySize
+
+	if isRSAPSSKeyset(h) {
+		pub, pubErr := publicKeyOf(h)
+		if pubErr != nil {
+			return "", nil, pubErr
+		}
+
+		size = pub.N.BitLen()
+	}
+
+	template, err := keyTemplate(size)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manager := keyset.NewManagerFromHandle(h)
+
+	if err = manager.Rotate(template); err != nil {
+		return "", nil, fmt.Errorf("rotate keyset: %w", err)
+	}
+
+	rotated, err := manager.Handle()
+	if err != nil {
+		return "", nil, fmt.Errorf("get rotated keyset: %w", err)
+	}
+
+	kid, err := m.storeKeyset(rotated, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err = m.keysets.Delete(keyID); err != nil {
+		return "", nil, fmt.Errorf("delete rotated key %s: %w", keyID, err)
+	}
+
+	return kid, rotated, nil
+}
+
+// ExportPubKeyBytes exports the public key of the key. RSA-PSS public keys are exported in the format of
+// MarshalPublicKey.
+func (m *KeyManager) ExportPubKeyBytes(keyID string) ([]byte, kms.KeyType, error) {
+	pub, kt, err := m.KeyManager.ExportPubKeyBytes(keyID)
+	if err == nil {
+		return pub, kt, nil
+	}
+
+	// the wrapped key manager can't export RSA-PSS keys, other keys are only read once
+	kh, getErr := m.KeyManager.Get(keyID)
+	if getErr != nil {
+		return nil, "", err //nolint:wrapcheck
+	}
+
+	h, ok := kh.(*keyset.Handle)
+	if !ok || !isRSAPSSKeyset(h) {
+		return nil, "", err //nolint:wrapcheck
+	}
+
+	b, err := exportPublicKey(h)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, KeyType, nil
+}
+
+// CreateAndExportPubKeyBytes creates a key of the type and exports its public key.
+func (m *KeyManager) CreateAndExportPubKeyBytes(kt kms.KeyType) (string, []byte, error) {
+	if kt != KeyType {
+		return m.KeyManager.CreateAndExportPubKeyBytes(kt) //nolint:wrapcheck
+	}
+
+	kid, kh, err := m.Create(kt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pub, err := exportPublicKey(kh.(*keyset.Handle)) //nolint:forcetypeassert
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, pub, nil
+}
+
+// PubKeyBytesToHandle returns a handle of a public key in the format the key type is exported in, e.g. to verify
+// signatures with the public key of another party.
+func (m *KeyManager) PubKeyBytesToHandle(pubKey []byte, kt kms.KeyType) (interface{}, error) {
+	if kt != KeyType {
+		return m.KeyManager.PubKeyBytesToHandle(pubKey, kt) //nolint:wrapcheck
+	}
+
+	pub, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPublicKeyset(pub)
+}
+
+// ImportPrivateKey imports an RSA private key, an *rsa.PrivateKey of one of KeySizes bits.
+func (m *KeyManager) ImportPrivateKey(privKey interface{}, kt kms.KeyType,
+	opts ...kms.PrivateKeyOpts) (string, interface{}, error) {
+	if kt != KeyType {
+		return m.KeyManager.ImportPrivateKey(privKey, kt, opts...) //nolint:wrapcheck
+	}
+
+	priv, ok := privKey.(*rsa.PrivateKey)
+	if !ok || len(priv.Primes) != 2 || priv.Validate() != nil {
+		return "", nil, fmt.Errorf("import private key: not a %s private key", kt)
+	}
+
+	if err := validatePublicKey(&priv.PublicKey); err != nil {
+		return "", nil, fmt.Errorf("import private key: %w", err)
+	}
+
+	kh, err := newKeyset(priv)
+	if err != nil {
+		return "", nil, err
+	}
+
+	o := kms.NewOpt()
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	kid, err := m.storeKeyset(kh, o.KsID())
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, kh, nil
+}
+
+// storeKeyset writes the keyset to the store of the local KMS under the key ID or the JWK thumbprint of the primary
+// key if the key ID is empty.
+func (m *KeyManager) storeKeyset(kh *keyset.Handle, keyID string) (string, error) {
+	if keyID == "" {
+		pub, err := publicKeyOf(kh)
+		if err != nil {
+			return "", err
+		}
+
+		if keyID, err = Thumbprint(pub); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.keysets.Put(keyID, kh); err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	return keyID, nil
+}
+
+// isRSAPSSKeyset returns true if the primary key of the keyset is an RSA-PSS private key.
+func isRSAPSSKeyset(kh *keyset.Handle) bool {
+	info := kh.KeysetInfo()
+
+	for _, key := range info.KeyInfo {
+		if key.KeyId == info.PrimaryKeyId {
+			return key.TypeUrl == privateKeyTypeURL
+		}
+	}
+
+	return false
+}
+
+func exportPublicKey(kh *keyset.Handle) ([]byte, error) {
+	pub, err := publicKeyOf(kh)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := MarshalPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return b, nil
+}
+
+// publicKeyOf returns the public key of the primary key of an RSA-PSS keyset.
+func publicKeyOf(kh *keyset.Handle) (*rsa.PublicKey, error) {
+	pubKH, err := kh.Public()
+	if err != nil {
+		return nil, fmt.Errorf("get public keyset: %w", err)
+	}
+
+	w := &keyset.MemReaderWriter{}
+
+	if err = pubKH.WriteWithNoSecrets(w); err != nil {
+		return nil, fmt.Errorf("write public keyset: %w", err)
+	}
+
+	for _, key := range w.Keyset.Key {
+		if key.KeyId != w.Keyset.PrimaryKeyId {
+			continue
+		}
+
+		if key.KeyData.TypeUrl != publicKeyTypeURL {
+			return nil, errors.New("primary key is not an rsa-pss key")
+		}
+
+		pubKey := new(rsapb.RsaSsaPssPublicKey)
+
+		if err = proto.Unmarshal(key.KeyData.Value, pubKey); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+		}
+
+		return publicKey(pubKey)
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
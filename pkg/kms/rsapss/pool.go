@@ -0,0 +1,69 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rsapss
+
+import (
+	"crypto/rsa"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+var logger = log.New("rsapss")
+
+// Pool generates RSA keys in the background, so that requests to create RSA keys don't wait for key generation,
+// which takes hundreds of milliseconds for 4096 bits keys. Up to size keys of each of KeySizes are kept ready. When
+// no key of the requested size is ready, e.g. under a burst of requests, the key is generated on the spot.
+type Pool struct {
+	keys     map[int]chan *rsa.PrivateKey
+	generate func(bits int) (*rsa.PrivateKey, error)
+	start    sync.Once
+}
+
+// NewPool returns a pool of up to size keys of each of KeySizes. Keys are generated once the pool is started.
+func NewPool(size int) *Pool {
+	keys := make(map[int]chan *rsa.PrivateKey, len(KeySizes))
+
+	for _, bits := range KeySizes {
+		keys[bits] = make(chan *rsa.PrivateKey, size)
+	}
+
+	return &Pool{keys: keys, generate: GenerateKey}
+}
+
+// Start starts generating keys, a goroutine per key size. Keys are generated one at a time per size, so that the pool
+// doesn't compete with requests for more than a CPU per size.
+func (p *Pool) Start() {
+	p.start.Do(func() {
+		for bits, keys := range p.keys {
+			go p.fill(bits, keys)
+		}
+	})
+}
+
+func (p *Pool) fill(bits int, keys chan<- *rsa.PrivateKey) {
+	for {
+		priv, err := p.generate(bits)
+		if err != nil {
+			logger.Errorf("generate %d bits rsa key: %s", bits, err)
+
+			return
+		}
+
+		keys <- priv
+	}
+}
+
+// GenerateKey returns a ready key of the size in bits, or generates one if none is ready.
+func (p *Pool) GenerateKey(bits int) (*rsa.PrivateKey, error) {
+	select {
+	case priv := <-p.keys[bits]:
+		return priv, nil
+	default:
+		return p.generate(bits)
+	}
+}
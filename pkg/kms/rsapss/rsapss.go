@@ -0,0 +1,146 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package rsapss adds RSA-PSS keys (PS256) to local key stores. Neither Tink for Go nor the local KMS of
+// aries-framework-go create RSA keys, so keys are Tink keysets of key types registered by this package, like
+// secp256k1 keys. They are stored by the local KMS like its own keys, and sign and verify with the Tink based crypto.
+package rsapss
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+)
+
+const (
+	// KeyType is the type of RSA-PSS keys: signatures are RSASSA-PSS with SHA-256, MGF1 with SHA-256 and a 32 bytes
+	// salt, as PS256 JWS (RFC 7518) require. Public keys are exported as DER encoded SubjectPublicKeyInfo.
+	KeyType = kms.RSAPS256Type
+
+	// DefaultKeySize is the size in bits of keys created without a size.
+	DefaultKeySize = 2048
+
+	publicExponent = 65537
+	saltLength     = sha256.Size
+)
+
+// KeySizes are the supported sizes of keys in bits.
+var KeySizes = []int{2048, 3072, 4096} //nolint:gochecknoglobals
+
+// ErrInvalidSignature is returned when a signature doesn't verify.
+var ErrInvalidSignature = errors.New("invalid rsa-pss signature")
+
+// IsKeySize returns true if keys of the size in bits are supported.
+func IsKeySize(bits int) bool {
+	for _, size := range KeySizes {
+		if size == bits {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GenerateKey generates a key of the size in bits.
+func GenerateKey(bits int) (*rsa.PrivateKey, error) {
+	if !IsKeySize(bits) {
+		return nil, fmt.Errorf("unsupported rsa key size %d, supported sizes: %v", bits, KeySizes)
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, fmt.Errorf("generate rsa key: %w", err)
+	}
+
+	return priv, nil
+}
+
+// MarshalPublicKey returns the public key in the format the key store exports public keys, a DER encoded
+// SubjectPublicKeyInfo.
+func MarshalPublicKey(pub *rsa.PublicKey) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(pub) //nolint:wrapcheck
+}
+
+// ParsePublicKey parses a public key exported from the key store.
+func ParsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an rsa public key")
+	}
+
+	if err = validatePublicKey(pub); err != nil {
+		return nil, err
+	}
+
+	return pub, nil
+}
+
+func validatePublicKey(pub *rsa.PublicKey) error {
+	if !IsKeySize(pub.N.BitLen()) {
+		return fmt.Errorf("unsupported rsa key size %d, supported sizes: %v", pub.N.BitLen(), KeySizes)
+	}
+
+	if pub.E != publicExponent {
+		return fmt.Errorf("unsupported rsa public exponent %d", pub.E)
+	}
+
+	return nil
+}
+
+// Thumbprint returns the base64url-encoded SHA-256 JWK thumbprint (RFC 7638) of the public key, which the key store
+// uses as the key ID, like for other asymmetric keys.
+func Thumbprint(pub *rsa.PublicKey) (string, error) {
+	// json.Marshal sorts map keys and adds no whitespace, as RFC 7638 requires
+	b, err := json.Marshal(map[string]string{
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal jwk thumbprint input: %w", err)
+	}
+
+	h := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+// Sign signs the SHA-256 digest of the message with RSASSA-PSS.
+func Sign(priv *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+
+	sig, err := rsa.SignPSS(rand.Reader, priv, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: saltLength})
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	return sig, nil
+}
+
+// Verify verifies an RSASSA-PSS signature of the message. The salt must be 32 bytes, as PS256 require.
+func Verify(pub *rsa.PublicKey, sig, msg []byte) error {
+	digest := sha256.Sum256(msg)
+
+	if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: saltLength}); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rsapss_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+)
+
+const primaryKeyURI = "local-lock://test"
+
+func TestKeyManager(t *testing.T) {
+	msg := []byte("test message")
+
+	km, local := newKeyManager(t)
+
+	kid, kh, err := km.Create(rsapss.KeyType)
+	require.NoError(t, err)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	sig, err := cr.Sign(msg, kh)
+	require.NoError(t, err)
+	require.Len(t, sig, 256)
+
+	// keys are read by the local KMS like its own keys
+	stored, err := local.Get(kid)
+	require.NoError(t, err)
+
+	pubKH, err := stored.(*keyset.Handle).Public()
+	require.NoError(t, err)
+	require.NoError(t, cr.Verify(sig, msg, pubKH))
+	require.Error(t, cr.Verify(sig, []byte("other message"), pubKH))
+
+	pubBytes, kt, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, rsapss.KeyType, kt)
+
+	pub, err := rsapss.ParsePublicKey(pubBytes)
+	require.NoError(t, err)
+	require.Equal(t, rsapss.DefaultKeySize, pub.N.BitLen())
+
+	thumbprint, err := rsapss.Thumbprint(pub)
+	require.NoError(t, err)
+	require.Equal(t, thumbprint, kid)
+
+	// signatures verify with the standard library as PS256 signatures
+	digest := sha256.Sum256(msg)
+	require.NoError(t, rsa.VerifyPSS(pub, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: 32}))
+
+	handle, err := km.PubKeyBytesToHandle(pubBytes, rsapss.KeyType)
+	require.NoError(t, err)
+	require.NoError(t, cr.Verify(sig, msg, handle))
+
+	kid, pubBytes, err = km.CreateAndExportPubKeyBytes(rsapss.KeyType)
+	require.NoError(t, err)
+
+	exported, _, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, pubBytes, exported)
+}
+
+func TestKeyManager_ImportPrivateKey(t *testing.T) {
+	km, _ := newKeyManager(t)
+
+	priv, err := rsapss.GenerateKey(3072)
+	require.NoError(t, err)
+
+	kid, kh, err := km.ImportPrivateKey(priv, rsapss.KeyType)
+	require.NoError(t, err)
+
+	pubBytes, _, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+
+	expected, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	require.Equal(t, expected, pubBytes)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	sig, err := cr.Sign([]byte("test message"), kh)
+	require.NoError(t, err)
+	require.Len(t, sig, 384)
+	require.NoError(t, rsapss.Verify(&priv.PublicKey, sig, []byte("test message")))
+
+	t.Run("Rotated key has the size of the key", func(t *testing.T) {
+		newKID, _, err := km.Rotate(rsapss.KeyType, kid)
+		require.NoError(t, err)
+		require.NotEqual(t, kid, newKID)
+
+		_, err = km.Get(kid)
+		require.Error(t, err)
+
+		pubBytes, _, err := km.ExportPubKeyBytes(newKID)
+		require.NoError(t, err)
+
+		pub, err := rsapss.ParsePublicKey(pubBytes)
+		require.NoError(t, err)
+		require.Equal(t, 3072, pub.N.BitLen())
+	})
+
+	t.Run("With key ID", func(t *testing.T) {
+		kid, _, err = km.ImportPrivateKey(priv, rsapss.KeyType, kms.WithKeyID("imported"))
+		require.NoError(t, err)
+		require.Equal(t, "imported", kid)
+
+		_, _, err = km.ImportPrivateKey(priv, rsapss.KeyType, kms.WithKeyID("imported"))
+		require.EqualError(t, err, "key imported already exists")
+	})
+
+	t.Run("Key of unsupported size", func(t *testing.T) {
+		small, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.NoError(t, err)
+
+		_, _, err = km.ImportPrivateKey(small, rsapss.KeyType)
+		require.EqualError(t, err,
+			"import private key: unsupported rsa key size 1024, supported sizes: [2048 3072 4096]")
+	})
+
+	t.Run("Not an RSA key", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		_, _, err = km.ImportPrivateKey(ecKey, rsapss.KeyType)
+		require.EqualError(t, err, "import private key: not a RSAPS256 private key")
+	})
+}
+
+func TestKeyManager_OtherKeyTypes(t *testing.T) {
+	km, _ := newKeyManager(t)
+
+	kid, pub, err := km.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+	require.Len(t, pub, 32)
+
+	_, kt, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, kms.ED25519Type, kt)
+
+	// an ED25519 key is rotated to an RSA-PSS key of the default size
+	kid, _, err = km.Rotate(rsapss.KeyType, kid)
+	require.NoError(t, err)
+
+	_, kt, err = km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, rsapss.KeyType, kt)
+
+	kid, _, err = km.Create(kms.AES256GCMType)
+	require.NoError(t, err)
+
+	_, _, err = km.ExportPubKeyBytes(kid)
+	require.Error(t, err)
+}
+
+func TestWrap(t *testing.T) {
+	_, err := rsapss.Wrap(nil, "test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.EqualError(t, err, "invalid primary key uri: test")
+}
+
+func TestGenerateKey(t *testing.T) {
+	_, err := rsapss.GenerateKey(1024)
+	require.EqualError(t, err, "unsupported rsa key size 1024, supported sizes: [2048 3072 4096]")
+}
+
+func TestVerify(t *testing.T) {
+	priv, err := rsapss.GenerateKey(2048)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("test message"))
+
+	// PS256 signatures have a salt of the size of the hash
+	sig, err := rsa.SignPSS(rand.Reader, priv, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: 20})
+	require.NoError(t, err)
+
+	err = rsapss.Verify(&priv.PublicKey, sig, []byte("test message"))
+	require.ErrorIs(t, err, rsapss.ErrInvalidSignature)
+
+	sig, err = rsapss.Sign(priv, []byte("test message"))
+	require.NoError(t, err)
+	require.NoError(t, rsapss.Verify(&priv.PublicKey, sig, []byte("test message")))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	b, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	require.NoError(t, err)
+
+	_, err = rsapss.ParsePublicKey(b)
+	require.EqualError(t, err, "not an rsa public key")
+
+	_, err = rsapss.ParsePublicKey([]byte("invalid"))
+	require.Error(t, err)
+}
+
+func TestPool(t *testing.T) {
+	pool := rsapss.NewPool(1)
+
+	// keys are generated on the spot until the pool is started
+	priv, err := pool.GenerateKey(2048)
+	require.NoError(t, err)
+	require.Equal(t, 2048, priv.N.BitLen())
+
+	pool.Start()
+
+	priv, err = pool.GenerateKey(3072)
+	require.NoError(t, err)
+	require.Equal(t, 3072, priv.N.BitLen())
+
+	_, err = pool.GenerateKey(1024)
+	require.Error(t, err)
+}
+
+func newKeyManager(t *testing.T) (*rsapss.KeyManager, kms.KeyManager) {
+	t.Helper()
+
+	p := &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}}
+
+	local, err := localkms.New(primaryKeyURI, p)
+	require.NoError(t, err)
+
+	km, err := rsapss.Wrap(local, primaryKeyURI, p)
+	require.NoError(t, err)
+
+	return km, local
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
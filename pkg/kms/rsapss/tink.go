@@ -0,0 +1,335 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package rsapss
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/registry"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	rsapb "github.com/google/tink/go/proto/rsa_ssa_pss_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+)
+
+// Keys are stored in Tink's RSA-PSS protos under type URLs of this package: Tink for Go has no key managers of them.
+// Only the PS256 params are supported.
+const (
+	privateKeyTypeURL = "type.trustbloc.dev/trustbloc.kms.RsaSsaPssPrivateKey"
+	publicKeyTypeURL  = "type.trustbloc.dev/trustbloc.kms.RsaSsaPssPublicKey"
+	keyVersion        = 0
+)
+
+var errInvalidKey = errors.New("invalid rsa-pss key")
+
+func init() { //nolint:gochecknoinits
+	if err := registry.RegisterKeyManager(new(privateKeyManager)); err != nil {
+		panic(fmt.Sprintf("rsapss: register private key manager: %v", err))
+	}
+
+	if err := registry.RegisterKeyManager(new(publicKeyManager)); err != nil {
+		panic(fmt.Sprintf("rsapss: register public key manager: %v", err))
+	}
+}
+
+func keyParams() *rsapb.RsaSsaPssParams {
+	return &rsapb.RsaSsaPssParams{
+		SigHash:    commonpb.HashType_SHA256,
+		Mgf1Hash:   commonpb.HashType_SHA256,
+		SaltLength: saltLength,
+	}
+}
+
+func validateParams(params *rsapb.RsaSsaPssParams) error {
+	if params == nil || params.SigHash != commonpb.HashType_SHA256 || params.Mgf1Hash != commonpb.HashType_SHA256 ||
+		params.SaltLength != saltLength {
+		return fmt.Errorf("%w: unsupported params", errInvalidKey)
+	}
+
+	return nil
+}
+
+// keyTemplate returns the Tink key template of keys of the size in bits.
+func keyTemplate(bits int) (*tinkpb.KeyTemplate, error) {
+	if !IsKeySize(bits) {
+		return nil, fmt.Errorf("unsupported rsa key size %d, supported sizes: %v", bits, KeySizes)
+	}
+
+	format, err := proto.Marshal(&rsapb.RsaSsaPssKeyFormat{
+		Params:            keyParams(),
+		ModulusSizeInBits: uint32(bits),
+		PublicExponent:    big.NewInt(publicExponent).Bytes(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal key format: %w", err)
+	}
+
+	return &tinkpb.KeyTemplate{
+		TypeUrl:          privateKeyTypeURL,
+		Value:            format,
+		OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+	}, nil
+}
+
+func newPublicKeyProto(pub *rsa.PublicKey) *rsapb.RsaSsaPssPublicKey {
+	return &rsapb.RsaSsaPssPublicKey{
+		Version: keyVersion,
+		Params:  keyParams(),
+		N:       pub.N.Bytes(),
+		E:       big.NewInt(int64(pub.E)).Bytes(),
+	}
+}
+
+func newPrivateKeyProto(priv *rsa.PrivateKey) *rsapb.RsaSsaPssPrivateKey {
+	priv.Precompute()
+
+	return &rsapb.RsaSsaPssPrivateKey{
+		Version:   keyVersion,
+		PublicKey: newPublicKeyProto(&priv.PublicKey),
+		D:         priv.D.Bytes(),
+		P:         priv.Primes[0].Bytes(),
+		Q:         priv.Primes[1].Bytes(),
+		Dp:        priv.Precomputed.Dp.Bytes(),
+		Dq:        priv.Precomputed.Dq.Bytes(),
+		Crt:       priv.Precomputed.Qinv.Bytes(),
+	}
+}
+
+// newKeyset returns a keyset handle of the private key, for keys generated outside of Tink.
+func newKeyset(priv *rsa.PrivateKey) (*keyset.Handle, error) {
+	value, err := proto.Marshal(newPrivateKeyProto(priv))
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	// the keyset is only held in memory until it's encrypted by the key manager
+	return insecurecleartextkeyset.Read(&keyset.MemReaderWriter{ //nolint:wrapcheck
+		Keyset: singleKeyset(privateKeyTypeURL, value, tinkpb.KeyData_ASYMMETRIC_PRIVATE),
+	})
+}
+
+// newPublicKeyset returns a keyset handle of the public key.
+func newPublicKeyset(pub *rsa.PublicKey) (*keyset.Handle, error) {
+	value, err := proto.Marshal(newPublicKeyProto(pub))
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return keyset.NewHandleWithNoSecrets( //nolint:wrapcheck
+		singleKeyset(publicKeyTypeURL, value, tinkpb.KeyData_ASYMMETRIC_PUBLIC))
+}
+
+func singleKeyset(typeURL string, value []byte, materialType tinkpb.KeyData_KeyMaterialType) *tinkpb.Keyset {
+	return &tinkpb.Keyset{
+		PrimaryKeyId: 1,
+		Key: []*tinkpb.Keyset_Key{{
+			KeyData: &tinkpb.KeyData{
+				TypeUrl:         typeURL,
+				Value:           value,
+				KeyMaterialType: materialType,
+			},
+			Status:           tinkpb.KeyStatusType_ENABLED,
+			KeyId:            1,
+			OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+		}},
+	}
+}
+
+func parsePrivateKey(serializedKey []byte) (*rsa.PrivateKey, error) {
+	key := new(rsapb.RsaSsaPssPrivateKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	if err := keyset.ValidateKeyVersion(key.Version, keyVersion); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	pub, err := publicKey(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := &rsa.PrivateKey{
+		PublicKey: *pub,
+		D:         new(big.Int).SetBytes(key.D),
+		Primes:    []*big.Int{new(big.Int).SetBytes(key.P), new(big.Int).SetBytes(key.Q)},
+	}
+
+	if err = priv.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	// the CRT values of the key are computed again rather than trusted
+	priv.Precompute()
+
+	return priv, nil
+}
+
+func publicKey(key *rsapb.RsaSsaPssPublicKey) (*rsa.PublicKey, error) {
+	if key == nil {
+		return nil, fmt.Errorf("%w: no public key", errInvalidKey)
+	}
+
+	if err := keyset.ValidateKeyVersion(key.Version, keyVersion); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	if err := validateParams(key.Params); err != nil {
+		return nil, err
+	}
+
+	e := new(big.Int).SetBytes(key.E)
+
+	if !e.IsInt64() || e.Int64() != publicExponent {
+		return nil, fmt.Errorf("%w: unsupported public exponent", errInvalidKey)
+	}
+
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(key.N), E: publicExponent}
+
+	if err := validatePublicKey(pub); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	return pub, nil
+}
+
+// privateKeyManager is the Tink key manager of RSA-PSS private keys. Their primitive is a tink.Signer.
+type privateKeyManager struct{}
+
+func (km *privateKeyManager) Primitive(serializedKey []byte) (interface{}, error) {
+	priv, err := parsePrivateKey(serializedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signer{key: priv}, nil
+}
+
+func (km *privateKeyManager) NewKey(serializedKeyFormat []byte) (proto.Message, error) {
+	format := new(rsapb.RsaSsaPssKeyFormat)
+
+	if err := proto.Unmarshal(serializedKeyFormat, format); err != nil {
+		return nil, fmt.Errorf("invalid rsa-pss key format: %w", err)
+	}
+
+	if err := validateParams(format.Params); err != nil {
+		return nil, err
+	}
+
+	if e := new(big.Int).SetBytes(format.PublicExponent); !e.IsInt64() || e.Int64() != publicExponent {
+		return nil, fmt.Errorf("%w: unsupported public exponent", errInvalidKey)
+	}
+
+	priv, err := GenerateKey(int(format.ModulusSizeInBits))
+	if err != nil {
+		return nil, err
+	}
+
+	return newPrivateKeyProto(priv), nil
+}
+
+func (km *privateKeyManager) NewKeyData(serializedKeyFormat []byte) (*tinkpb.KeyData, error) {
+	key, err := km.NewKey(serializedKeyFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := proto.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	return &tinkpb.KeyData{
+		TypeUrl:         privateKeyTypeURL,
+		Value:           value,
+		KeyMaterialType: tinkpb.KeyData_ASYMMETRIC_PRIVATE,
+	}, nil
+}
+
+func (km *privateKeyManager) PublicKeyData(serializedKey []byte) (*tinkpb.KeyData, error) {
+	key := new(rsapb.RsaSsaPssPrivateKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	value, err := proto.Marshal(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return &tinkpb.KeyData{
+		TypeUrl:         publicKeyTypeURL,
+		Value:           value,
+		KeyMaterialType: tinkpb.KeyData_ASYMMETRIC_PUBLIC,
+	}, nil
+}
+
+func (km *privateKeyManager) DoesSupport(typeURL string) bool {
+	return typeURL == privateKeyTypeURL
+}
+
+func (km *privateKeyManager) TypeURL() string {
+	return privateKeyTypeURL
+}
+
+// publicKeyManager is the Tink key manager of RSA-PSS public keys. Their primitive is a tink.Verifier.
+type publicKeyManager struct{}
+
+func (km *publicKeyManager) Primitive(serializedKey []byte) (interface{}, error) {
+	key := new(rsapb.RsaSsaPssPublicKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	pub, err := publicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifier{key: pub}, nil
+}
+
+func (km *publicKeyManager) NewKey([]byte) (proto.Message, error) {
+	return nil, errors.New("rsa-pss public keys can't be generated")
+}
+
+func (km *publicKeyManager) NewKeyData([]byte) (*tinkpb.KeyData, error) {
+	return nil, errors.New("rsa-pss public keys can't be generated")
+}
+
+func (km *publicKeyManager) DoesSupport(typeURL string) bool {
+	return typeURL == publicKeyTypeURL
+}
+
+func (km *publicKeyManager) TypeURL() string {
+	return publicKeyTypeURL
+}
+
+type signer struct {
+	key *rsa.PrivateKey
+}
+
+func (s *signer) Sign(data []byte) ([]byte, error) {
+	return Sign(s.key, data)
+}
+
+type verifier struct {
+	key *rsa.PublicKey
+}
+
+func (v *verifier) Verify(signature, data []byte) error {
+	return Verify(v.key, signature, data)
+}
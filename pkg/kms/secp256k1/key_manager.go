@@ -0,0 +1,288 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secp256k1
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/keyset"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/kms/internal/keysetstore"
+)
+
+// KeyManager is a local KMS that supports secp256k1 keys. Keys of other types are handled by the wrapped local KMS.
+// secp256k1 keys are written to the store of the local KMS, encrypted with its primary key, so that the local KMS
+// reads them like its own keys.
+type KeyManager struct {
+	kms.KeyManager
+	keysets *keysetstore.Store
+}
+
+// Wrap adds secp256k1 keys to a local KMS. primaryKeyURI and the provider must be the ones the local KMS was created
+// with.
+func Wrap(km kms.KeyManager, primaryKeyURI string, p kms.Provider) (*KeyManager, error) {
+	keysets, err := keysetstore.New(primaryKeyURI, p)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &KeyManager{KeyManager: km, keysets: keysets}, nil
+}
+
+// Create creates a key of the type. The ID of a secp256k1 key is its JWK thumbprint.
+func (m *KeyManager) Create(kt kms.KeyType) (string, interface{}, error) {
+	if !IsKeyType(kt) {
+		return m.KeyManager.Create(kt) //nolint:wrapcheck
+	}
+
+	template, err := keyTemplate(kt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kh, err := keyset.NewHandle(template)
+	if err != nil {
+		return "", nil, fmt.Errorf("create keyset: %w", err)
+	}
+
+	kid, err := m.storeKeyset(kh, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, kh, nil
+}
+
+// Rotate adds a new primary key of the type to the keyset of the key, and stores the keyset under the ID of the new
+// key.
+func (m *KeyManager) Rotate(kt kms.KeyType, keyID string) (string, interface{}, error) {
+	if !IsKeyType(kt) {
+		return m.KeyManager.Rotate(kt, keyID) //nolint:wrapcheck
+	}
+
+	kh, err := m.KeyManager.Get(keyID)
+	if err != nil {
+		return "", nil, fmt.Errorf("get key: %w", err)
+	}
+
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return "", nil, fmt.Errorf("key %s is not a keyset", keyID)
+	}
+
+	template, err := keyTemplate(kt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manager := keyset.NewManagerFromHandle(h)
+
+	if err = manager.Rotate(template); err != nil {
+		return "", nil, fmt.Errorf("rotate keyset: %w", err)
+	}
+
+	rotated, err := manager.Handle()
+	if err != nil {
+		return "", nil, fmt.Errorf("get rotated keyset: %w", err)
+	}
+
+	kid, err := m.storeKeyset(rotated, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err = m.keysets.Delete(keyID); err != nil {
+		return "", nil, fmt.Errorf("delete rotated key %s: %w", keyID, err)
+	}
+
+	return kid, rotated, nil
+}
+
+// ExportPubKeyBytes exports the public key of the key. secp256k1 public keys are exported in the format of
+// MarshalPublicKey.
+func (m *KeyManager) ExportPubKeyBytes(keyID string) ([]byte, kms.KeyType, error) {
+	pub, kt, err := m.KeyManager.ExportPubKeyBytes(keyID)
+	if err == nil {
+		return pub, kt, nil
+	}
+
+	// the local KMS can't export secp256k1 keys, other keys are only read once
+	kh, getErr := m.KeyManager.Get(keyID)
+	if getErr != nil {
+		return nil, "", err //nolint:wrapcheck
+	}
+
+	h, ok := kh.(*keyset.Handle)
+	if !ok || !isSecp256k1Keyset(h) {
+		return nil, "", err //nolint:wrapcheck
+	}
+
+	return exportPublicKey(h)
+}
+
+// CreateAndExportPubKeyBytes creates a key of the type and exports its public key.
+func (m *KeyManager) CreateAndExportPubKeyBytes(kt kms.KeyType) (string, []byte, error) {
+	if !IsKeyType(kt) {
+		return m.KeyManager.CreateAndExportPubKeyBytes(kt) //nolint:wrapcheck
+	}
+
+	kid, kh, err := m.Create(kt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pub, _, err := exportPublicKey(kh.(*keyset.Handle)) //nolint:forcetypeassert
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, pub, nil
+}
+
+// PubKeyBytesToHandle returns a handle of a public key in the format the key type is exported in, e.g. to verify
+// signatures with the public key of another party.
+func (m *KeyManager) PubKeyBytesToHandle(pubKey []byte, kt kms.KeyType) (interface{}, error) {
+	if !IsKeyType(kt) {
+		return m.KeyManager.PubKeyBytesToHandle(pubKey, kt) //nolint:wrapcheck
+	}
+
+	pub, err := ParsePublicKey(pubKey, kt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPublicKeyset(pub, kt)
+}
+
+// ImportPrivateKey imports a secp256k1 private key, an *ecdsa.PrivateKey on the btcec curve or a *btcec.PrivateKey.
+func (m *KeyManager) ImportPrivateKey(privKey interface{}, kt kms.KeyType,
+	opts ...kms.PrivateKeyOpts) (string, interface{}, error) {
+	if !IsKeyType(kt) {
+		return m.KeyManager.ImportPrivateKey(privKey, kt, opts...) //nolint:wrapcheck
+	}
+
+	var priv *ecdsa.PrivateKey
+
+	switch k := privKey.(type) {
+	case *btcec.PrivateKey:
+		priv = k.ToECDSA()
+	case *ecdsa.PrivateKey:
+		priv = k
+	default:
+		return "", nil, fmt.Errorf("import private key: not a %s private key", kt)
+	}
+
+	if priv.Curve != btcec.S256() || priv.D == nil || priv.D.Sign() <= 0 || priv.D.Cmp(btcec.S256().N) >= 0 {
+		return "", nil, fmt.Errorf("import private key: not a %s private key", kt)
+	}
+
+	kh, err := newKeyset(priv, kt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	o := kms.NewOpt()
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	kid, err := m.storeKeyset(kh, o.KsID())
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kid, kh, nil
+}
+
+// storeKeyset writes the keyset to the store of the local KMS under the key ID or the JWK thumbprint of the primary
+// key if the key ID is empty.
+func (m *KeyManager) storeKeyset(kh *keyset.Handle, keyID string) (string, error) {
+	if keyID == "" {
+		pub, _, err := publicKeyOf(kh)
+		if err != nil {
+			return "", err
+		}
+
+		if keyID, err = Thumbprint(pub); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.keysets.Put(keyID, kh); err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	return keyID, nil
+}
+
+// isSecp256k1Keyset returns true if the primary key of the keyset is a secp256k1 private key.
+func isSecp256k1Keyset(kh *keyset.Handle) bool {
+	info := kh.KeysetInfo()
+
+	for _, key := range info.KeyInfo {
+		if key.KeyId == info.PrimaryKeyId {
+			return key.TypeUrl == privateKeyTypeURL
+		}
+	}
+
+	return false
+}
+
+func exportPublicKey(kh *keyset.Handle) ([]byte, kms.KeyType, error) {
+	pub, kt, err := publicKeyOf(kh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, err := MarshalPublicKey(pub, kt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, kt, nil
+}
+
+// publicKeyOf returns the public key of the primary key of a secp256k1 keyset.
+func publicKeyOf(kh *keyset.Handle) (*ecdsa.PublicKey, kms.KeyType, error) {
+	pubKH, err := kh.Public()
+	if err != nil {
+		return nil, "", fmt.Errorf("get public keyset: %w", err)
+	}
+
+	w := &keyset.MemReaderWriter{}
+
+	if err = pubKH.WriteWithNoSecrets(w); err != nil {
+		return nil, "", fmt.Errorf("write public keyset: %w", err)
+	}
+
+	for _, key := range w.Keyset.Key {
+		if key.KeyId != w.Keyset.PrimaryKeyId {
+			continue
+		}
+
+		if key.KeyData.TypeUrl != publicKeyTypeURL {
+			return nil, "", errors.New("primary key is not a secp256k1 key")
+		}
+
+		pubKey := new(ecdsapb.EcdsaPublicKey)
+
+		if err = proto.Unmarshal(key.KeyData.Value, pubKey); err != nil {
+			return nil, "", fmt.Errorf("%w: %s", errInvalidKey, err)
+		}
+
+		return publicKey(pubKey)
+	}
+
+	return nil, "", errors.New("keyset has no primary key")
+}
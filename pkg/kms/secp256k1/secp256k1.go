@@ -0,0 +1,188 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package secp256k1 adds ECDSA secp256k1 keys (ES256K) to local key stores. Neither Tink nor the local KMS of
+// aries-framework-go support the curve, so keys are Tink keysets of key types registered by this package. They are
+// stored by the local KMS like its own keys, and sign and verify with the Tink based crypto.
+package secp256k1
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+)
+
+const (
+	// KeyTypeDER is the type of secp256k1 keys that make ASN.1 DER encoded signatures. Public keys are exported as
+	// DER encoded SubjectPublicKeyInfo.
+	KeyTypeDER kms.KeyType = "ECDSASecp256k1DER"
+	// KeyTypeIEEEP1363 is the type of secp256k1 keys that make IEEE P1363 (r||s) signatures, the form ES256K JWS
+	// (RFC 8812) require. Public keys are exported as uncompressed points.
+	KeyTypeIEEEP1363 = kms.ECDSASecp256k1TypeIEEEP1363
+
+	scalarSize = 32
+)
+
+//nolint:gochecknoglobals
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1      = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// ErrInvalidSignature is returned when a signature doesn't verify or is not in the format of the key type.
+var ErrInvalidSignature = errors.New("invalid secp256k1 signature")
+
+// IsKeyType returns true for secp256k1 key types.
+func IsKeyType(kt kms.KeyType) bool {
+	return kt == KeyTypeDER || kt == KeyTypeIEEEP1363
+}
+
+// Curve returns the secp256k1 curve.
+func Curve() *btcec.KoblitzCurve {
+	return btcec.S256()
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.ObjectIdentifier
+	}
+	PublicKey asn1.BitString
+}
+
+// MarshalPublicKey returns the public key in the format the key store exports public keys of the key type.
+func MarshalPublicKey(pub *ecdsa.PublicKey, kt kms.KeyType) ([]byte, error) {
+	point := (*btcec.PublicKey)(pub).SerializeUncompressed()
+
+	switch kt { //nolint:exhaustive
+	case KeyTypeIEEEP1363:
+		return point, nil
+	case KeyTypeDER:
+		var info subjectPublicKeyInfo
+
+		info.Algorithm.Algorithm = oidPublicKeyECDSA
+		info.Algorithm.Parameters = oidSecp256k1
+		info.PublicKey = asn1.BitString{Bytes: point, BitLength: 8 * len(point)} //nolint:gomnd
+
+		return asn1.Marshal(info) //nolint:wrapcheck
+	default:
+		return nil, fmt.Errorf("not a secp256k1 key type: %s", kt)
+	}
+}
+
+// ParsePublicKey parses a public key exported from the key store.
+func ParsePublicKey(b []byte, kt kms.KeyType) (*ecdsa.PublicKey, error) {
+	switch kt { //nolint:exhaustive
+	case KeyTypeIEEEP1363:
+	case KeyTypeDER:
+		var info subjectPublicKeyInfo
+
+		rest, err := asn1.Unmarshal(b, &info)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal public key: %w", err)
+		}
+
+		if len(rest) > 0 || !info.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) ||
+			!info.Algorithm.Parameters.Equal(oidSecp256k1) {
+			return nil, errors.New("not a secp256k1 public key")
+		}
+
+		b = info.PublicKey.RightAlign()
+	default:
+		return nil, fmt.Errorf("not a secp256k1 key type: %s", kt)
+	}
+
+	pub, err := btcec.ParsePubKey(b, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	return pub.ToECDSA(), nil
+}
+
+// Thumbprint returns the base64url-encoded SHA-256 JWK thumbprint (RFC 7638) of the public key, which the key store
+// uses as the key ID, like for other asymmetric keys.
+func Thumbprint(pub *ecdsa.PublicKey) (string, error) {
+	x := make([]byte, scalarSize)
+	y := make([]byte, scalarSize)
+
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+
+	// json.Marshal sorts map keys and adds no whitespace, as RFC 7638 requires
+	b, err := json.Marshal(map[string]string{
+		"crv": "secp256k1",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(x),
+		"y":   base64.RawURLEncoding.EncodeToString(y),
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal jwk thumbprint input: %w", err)
+	}
+
+	h := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+// Sign signs the SHA-256 digest of the message with a deterministic (RFC 6979), low-S signature in the format of
+// the key type.
+func Sign(priv *ecdsa.PrivateKey, msg []byte, kt kms.KeyType) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+
+	sig, err := (*btcec.PrivateKey)(priv).Sign(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	if kt == KeyTypeDER {
+		return sig.Serialize(), nil
+	}
+
+	b := make([]byte, 2*scalarSize) //nolint:gomnd
+
+	sig.R.FillBytes(b[:scalarSize])
+	sig.S.FillBytes(b[scalarSize:])
+
+	return b, nil
+}
+
+// Verify verifies a signature of the message in the format of the key type. High-S signatures are accepted, as other
+// ECDSA implementations make them.
+func Verify(pub *ecdsa.PublicKey, sig, msg []byte, kt kms.KeyType) error {
+	var r, s *big.Int
+
+	if kt == KeyTypeDER {
+		parsed, err := btcec.ParseDERSignature(sig, btcec.S256())
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidSignature, err)
+		}
+
+		r, s = parsed.R, parsed.S
+	} else {
+		if len(sig) != 2*scalarSize {
+			return fmt.Errorf("%w: size %d, expected %d", ErrInvalidSignature, len(sig), 2*scalarSize)
+		}
+
+		r, s = new(big.Int).SetBytes(sig[:scalarSize]), new(big.Int).SetBytes(sig[scalarSize:])
+	}
+
+	digest := sha256.Sum256(msg)
+
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
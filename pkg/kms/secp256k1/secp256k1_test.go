@@ -0,0 +1,259 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secp256k1_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+)
+
+const primaryKeyURI = "local-lock://test"
+
+func TestKeyManager(t *testing.T) {
+	msg := []byte("test message")
+
+	for _, kt := range []kms.KeyType{secp256k1.KeyTypeDER, secp256k1.KeyTypeIEEEP1363} {
+		kt := kt
+
+		t.Run(string(kt), func(t *testing.T) {
+			km, local := newKeyManager(t)
+
+			kid, kh, err := km.Create(kt)
+			require.NoError(t, err)
+
+			cr, err := tinkcrypto.New()
+			require.NoError(t, err)
+
+			sig, err := cr.Sign(msg, kh)
+			require.NoError(t, err)
+
+			// keys are read by the local KMS like its own keys
+			stored, err := local.Get(kid)
+			require.NoError(t, err)
+
+			pubKH, err := stored.(*keyset.Handle).Public()
+			require.NoError(t, err)
+			require.NoError(t, cr.Verify(sig, msg, pubKH))
+			require.Error(t, cr.Verify(sig, []byte("other message"), pubKH))
+
+			pubBytes, exportedType, err := km.ExportPubKeyBytes(kid)
+			require.NoError(t, err)
+			require.Equal(t, kt, exportedType)
+
+			pub, err := secp256k1.ParsePublicKey(pubBytes, kt)
+			require.NoError(t, err)
+
+			thumbprint, err := secp256k1.Thumbprint(pub)
+			require.NoError(t, err)
+			require.Equal(t, thumbprint, kid)
+
+			// signatures verify with the standard library, in the format of the key type
+			r, s := parseSignature(t, sig, kt)
+			digest := sha256.Sum256(msg)
+			require.True(t, ecdsa.Verify(pub, digest[:], r, s))
+			require.True(t, s.Cmp(new(big.Int).Rsh(btcec.S256().N, 1)) <= 0, "signature must be low-S")
+
+			handle, err := km.PubKeyBytesToHandle(pubBytes, kt)
+			require.NoError(t, err)
+			require.NoError(t, cr.Verify(sig, msg, handle))
+		})
+	}
+}
+
+func TestKeyManager_PublicKeyFormats(t *testing.T) {
+	km, _ := newKeyManager(t)
+
+	kid, _, err := km.Create(secp256k1.KeyTypeIEEEP1363)
+	require.NoError(t, err)
+
+	pub, _, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Len(t, pub, 65)
+	require.Equal(t, byte(4), pub[0])
+
+	kid, _, err = km.Create(secp256k1.KeyTypeDER)
+	require.NoError(t, err)
+
+	pub, _, err = km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+
+	var info struct {
+		Algorithm struct {
+			Algorithm  asn1.ObjectIdentifier
+			Parameters asn1.ObjectIdentifier
+		}
+		PublicKey asn1.BitString
+	}
+
+	_, err = asn1.Unmarshal(pub, &info)
+	require.NoError(t, err)
+	require.Equal(t, "1.2.840.10045.2.1", info.Algorithm.Algorithm.String())
+	require.Equal(t, "1.3.132.0.10", info.Algorithm.Parameters.String())
+	require.Len(t, info.PublicKey.Bytes, 65)
+}
+
+func TestKeyManager_ImportPrivateKey(t *testing.T) {
+	km, _ := newKeyManager(t)
+
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	kid, kh, err := km.ImportPrivateKey(priv.ToECDSA(), secp256k1.KeyTypeIEEEP1363)
+	require.NoError(t, err)
+
+	pubBytes, _, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, priv.PubKey().SerializeUncompressed(), pubBytes)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	sig, err := cr.Sign([]byte("test message"), kh)
+	require.NoError(t, err)
+
+	expected, err := secp256k1.Sign(priv.ToECDSA(), []byte("test message"), secp256k1.KeyTypeIEEEP1363)
+	require.NoError(t, err)
+	require.Equal(t, expected, sig, "signatures must be deterministic")
+
+	t.Run("With key ID", func(t *testing.T) {
+		kid, _, err = km.ImportPrivateKey(priv, secp256k1.KeyTypeDER, kms.WithKeyID("imported"))
+		require.NoError(t, err)
+		require.Equal(t, "imported", kid)
+
+		_, _, err = km.ImportPrivateKey(priv, secp256k1.KeyTypeDER, kms.WithKeyID("imported"))
+		require.EqualError(t, err, "key imported already exists")
+	})
+
+	t.Run("Key of another curve", func(t *testing.T) {
+		_, _, err = km.ImportPrivateKey(&ecdsa.PrivateKey{}, secp256k1.KeyTypeDER)
+		require.EqualError(t, err, "import private key: not a ECDSASecp256k1DER private key")
+	})
+}
+
+func TestKeyManager_Rotate(t *testing.T) {
+	km, local := newKeyManager(t)
+
+	kid, _, err := km.Create(secp256k1.KeyTypeDER)
+	require.NoError(t, err)
+
+	newKID, kh, err := km.Rotate(secp256k1.KeyTypeIEEEP1363, kid)
+	require.NoError(t, err)
+	require.NotEqual(t, kid, newKID)
+
+	_, err = local.Get(kid)
+	require.Error(t, err)
+
+	_, kt, err := km.ExportPubKeyBytes(newKID)
+	require.NoError(t, err)
+	require.Equal(t, secp256k1.KeyTypeIEEEP1363, kt)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	sig, err := cr.Sign([]byte("test message"), kh)
+	require.NoError(t, err)
+	require.Len(t, sig, 64)
+}
+
+func TestKeyManager_OtherKeyTypes(t *testing.T) {
+	km, _ := newKeyManager(t)
+
+	kid, pub, err := km.CreateAndExportPubKeyBytes(kms.ED25519Type)
+	require.NoError(t, err)
+	require.Len(t, pub, 32)
+
+	_, kt, err := km.ExportPubKeyBytes(kid)
+	require.NoError(t, err)
+	require.Equal(t, kms.ED25519Type, kt)
+
+	kid, _, err = km.Create(kms.AES256GCMType)
+	require.NoError(t, err)
+
+	_, _, err = km.ExportPubKeyBytes(kid)
+	require.Error(t, err)
+}
+
+func TestWrap(t *testing.T) {
+	_, err := secp256k1.Wrap(nil, "test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.EqualError(t, err, "invalid primary key uri: test")
+}
+
+func TestVerify(t *testing.T) {
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	sig, err := secp256k1.Sign(priv.ToECDSA(), []byte("test message"), secp256k1.KeyTypeDER)
+	require.NoError(t, err)
+
+	require.NoError(t, secp256k1.Verify(priv.PubKey().ToECDSA(), sig, []byte("test message"), secp256k1.KeyTypeDER))
+
+	// a DER signature is not an IEEE P1363 signature
+	err = secp256k1.Verify(priv.PubKey().ToECDSA(), sig, []byte("test message"), secp256k1.KeyTypeIEEEP1363)
+	require.ErrorIs(t, err, secp256k1.ErrInvalidSignature)
+}
+
+func newKeyManager(t *testing.T) (*secp256k1.KeyManager, kms.KeyManager) {
+	t.Helper()
+
+	p := &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}}
+
+	local, err := localkms.New(primaryKeyURI, p)
+	require.NoError(t, err)
+
+	km, err := secp256k1.Wrap(local, primaryKeyURI, p)
+	require.NoError(t, err)
+
+	return km, local
+}
+
+func parseSignature(t *testing.T, sig []byte, kt kms.KeyType) (*big.Int, *big.Int) {
+	t.Helper()
+
+	if kt == secp256k1.KeyTypeIEEEP1363 {
+		require.Len(t, sig, 64)
+
+		return new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	}
+
+	var rs struct {
+		R, S *big.Int
+	}
+
+	_, err := asn1.Unmarshal(sig, &rs)
+	require.NoError(t, err)
+
+	return rs.R, rs.S
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
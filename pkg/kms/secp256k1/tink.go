@@ -0,0 +1,350 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secp256k1
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/core/registry"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+)
+
+// Keys are stored in Tink's ECDSA protos under type URLs of this package, with an unknown curve: Tink's curve enum
+// has no secp256k1. The hash is always SHA-256, the encoding selects the signature format.
+const (
+	privateKeyTypeURL = "type.trustbloc.dev/trustbloc.kms.Secp256k1PrivateKey"
+	publicKeyTypeURL  = "type.trustbloc.dev/trustbloc.kms.Secp256k1PublicKey"
+	keyVersion        = 0
+)
+
+var errInvalidKey = errors.New("invalid secp256k1 key")
+
+func init() { //nolint:gochecknoinits
+	if err := registry.RegisterKeyManager(new(privateKeyManager)); err != nil {
+		panic(fmt.Sprintf("secp256k1: register private key manager: %v", err))
+	}
+
+	if err := registry.RegisterKeyManager(new(publicKeyManager)); err != nil {
+		panic(fmt.Sprintf("secp256k1: register public key manager: %v", err))
+	}
+}
+
+// keyTemplate returns the Tink key template of the key type.
+func keyTemplate(kt kms.KeyType) (*tinkpb.KeyTemplate, error) {
+	params, err := keyParams(kt)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := proto.Marshal(&ecdsapb.EcdsaKeyFormat{Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("marshal key format: %w", err)
+	}
+
+	return &tinkpb.KeyTemplate{
+		TypeUrl:          privateKeyTypeURL,
+		Value:            format,
+		OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+	}, nil
+}
+
+func keyParams(kt kms.KeyType) (*ecdsapb.EcdsaParams, error) {
+	params := &ecdsapb.EcdsaParams{
+		HashType: commonpb.HashType_SHA256,
+		Curve:    commonpb.EllipticCurveType_UNKNOWN_CURVE,
+	}
+
+	switch kt { //nolint:exhaustive
+	case KeyTypeDER:
+		params.Encoding = ecdsapb.EcdsaSignatureEncoding_DER
+	case KeyTypeIEEEP1363:
+		params.Encoding = ecdsapb.EcdsaSignatureEncoding_IEEE_P1363
+	default:
+		return nil, fmt.Errorf("not a secp256k1 key type: %s", kt)
+	}
+
+	return params, nil
+}
+
+func keyType(params *ecdsapb.EcdsaParams) (kms.KeyType, error) {
+	if params == nil || params.HashType != commonpb.HashType_SHA256 {
+		return "", fmt.Errorf("%w: unsupported params", errInvalidKey)
+	}
+
+	switch params.Encoding { //nolint:exhaustive
+	case ecdsapb.EcdsaSignatureEncoding_DER:
+		return KeyTypeDER, nil
+	case ecdsapb.EcdsaSignatureEncoding_IEEE_P1363:
+		return KeyTypeIEEEP1363, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported encoding %s", errInvalidKey, params.Encoding)
+	}
+}
+
+func newPrivateKeyProto(priv *ecdsa.PrivateKey, kt kms.KeyType) (*ecdsapb.EcdsaPrivateKey, error) {
+	params, err := keyParams(kt)
+	if err != nil {
+		return nil, err
+	}
+
+	d := make([]byte, scalarSize)
+	x := make([]byte, scalarSize)
+	y := make([]byte, scalarSize)
+
+	priv.D.FillBytes(d)
+	priv.X.FillBytes(x)
+	priv.Y.FillBytes(y)
+
+	return &ecdsapb.EcdsaPrivateKey{
+		Version:   keyVersion,
+		PublicKey: &ecdsapb.EcdsaPublicKey{Version: keyVersion, Params: params, X: x, Y: y},
+		KeyValue:  d,
+	}, nil
+}
+
+// newKeyset returns a keyset handle of the private key, for keys generated outside of Tink.
+func newKeyset(priv *ecdsa.PrivateKey, kt kms.KeyType) (*keyset.Handle, error) {
+	key, err := newPrivateKeyProto(priv, kt)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := proto.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	// the keyset is only held in memory until it's encrypted by the key manager
+	return insecurecleartextkeyset.Read(&keyset.MemReaderWriter{ //nolint:wrapcheck
+		Keyset: singleKeyset(privateKeyTypeURL, value, tinkpb.KeyData_ASYMMETRIC_PRIVATE),
+	})
+}
+
+// newPublicKeyset returns a keyset handle of the public key.
+func newPublicKeyset(pub *ecdsa.PublicKey, kt kms.KeyType) (*keyset.Handle, error) {
+	params, err := keyParams(kt)
+	if err != nil {
+		return nil, err
+	}
+
+	x := make([]byte, scalarSize)
+	y := make([]byte, scalarSize)
+
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+
+	value, err := proto.Marshal(&ecdsapb.EcdsaPublicKey{Version: keyVersion, Params: params, X: x, Y: y})
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return keyset.NewHandleWithNoSecrets( //nolint:wrapcheck
+		singleKeyset(publicKeyTypeURL, value, tinkpb.KeyData_ASYMMETRIC_PUBLIC))
+}
+
+func singleKeyset(typeURL string, value []byte, materialType tinkpb.KeyData_KeyMaterialType) *tinkpb.Keyset {
+	return &tinkpb.Keyset{
+		PrimaryKeyId: 1,
+		Key: []*tinkpb.Keyset_Key{{
+			KeyData: &tinkpb.KeyData{
+				TypeUrl:         typeURL,
+				Value:           value,
+				KeyMaterialType: materialType,
+			},
+			Status:           tinkpb.KeyStatusType_ENABLED,
+			KeyId:            1,
+			OutputPrefixType: tinkpb.OutputPrefixType_RAW,
+		}},
+	}
+}
+
+func parsePrivateKey(serializedKey []byte) (*ecdsa.PrivateKey, kms.KeyType, error) {
+	key := new(ecdsapb.EcdsaPrivateKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, "", fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	if err := keyset.ValidateKeyVersion(key.Version, keyVersion); err != nil {
+		return nil, "", fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	pub, kt, err := publicKey(key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	priv, _ := btcec.PrivKeyFromBytes(btcec.S256(), key.KeyValue)
+
+	if priv.X.Cmp(pub.X) != 0 || priv.Y.Cmp(pub.Y) != 0 {
+		return nil, "", fmt.Errorf("%w: public key doesn't match private key", errInvalidKey)
+	}
+
+	return priv.ToECDSA(), kt, nil
+}
+
+func publicKey(key *ecdsapb.EcdsaPublicKey) (*ecdsa.PublicKey, kms.KeyType, error) {
+	if key == nil {
+		return nil, "", fmt.Errorf("%w: no public key", errInvalidKey)
+	}
+
+	if err := keyset.ValidateKeyVersion(key.Version, keyVersion); err != nil {
+		return nil, "", fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	kt, err := keyType(key.Params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pub := &ecdsa.PublicKey{Curve: btcec.S256(), X: new(big.Int).SetBytes(key.X), Y: new(big.Int).SetBytes(key.Y)}
+
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, "", fmt.Errorf("%w: point is not on curve", errInvalidKey)
+	}
+
+	return pub, kt, nil
+}
+
+// privateKeyManager is the Tink key manager of secp256k1 private keys. Their primitive is a tink.Signer.
+type privateKeyManager struct{}
+
+func (km *privateKeyManager) Primitive(serializedKey []byte) (interface{}, error) {
+	priv, kt, err := parsePrivateKey(serializedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signer{key: priv, keyType: kt}, nil
+}
+
+func (km *privateKeyManager) NewKey(serializedKeyFormat []byte) (proto.Message, error) {
+	format := new(ecdsapb.EcdsaKeyFormat)
+
+	if err := proto.Unmarshal(serializedKeyFormat, format); err != nil {
+		return nil, fmt.Errorf("invalid secp256k1 key format: %w", err)
+	}
+
+	kt, err := keyType(format.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("generate secp256k1 key: %w", err)
+	}
+
+	return newPrivateKeyProto(priv.ToECDSA(), kt)
+}
+
+func (km *privateKeyManager) NewKeyData(serializedKeyFormat []byte) (*tinkpb.KeyData, error) {
+	key, err := km.NewKey(serializedKeyFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := proto.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal private key: %w", err)
+	}
+
+	return &tinkpb.KeyData{
+		TypeUrl:         privateKeyTypeURL,
+		Value:           value,
+		KeyMaterialType: tinkpb.KeyData_ASYMMETRIC_PRIVATE,
+	}, nil
+}
+
+func (km *privateKeyManager) PublicKeyData(serializedKey []byte) (*tinkpb.KeyData, error) {
+	key := new(ecdsapb.EcdsaPrivateKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	value, err := proto.Marshal(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key: %w", err)
+	}
+
+	return &tinkpb.KeyData{
+		TypeUrl:         publicKeyTypeURL,
+		Value:           value,
+		KeyMaterialType: tinkpb.KeyData_ASYMMETRIC_PUBLIC,
+	}, nil
+}
+
+func (km *privateKeyManager) DoesSupport(typeURL string) bool {
+	return typeURL == privateKeyTypeURL
+}
+
+func (km *privateKeyManager) TypeURL() string {
+	return privateKeyTypeURL
+}
+
+// publicKeyManager is the Tink key manager of secp256k1 public keys. Their primitive is a tink.Verifier.
+type publicKeyManager struct{}
+
+func (km *publicKeyManager) Primitive(serializedKey []byte) (interface{}, error) {
+	key := new(ecdsapb.EcdsaPublicKey)
+
+	if err := proto.Unmarshal(serializedKey, key); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidKey, err)
+	}
+
+	pub, kt, err := publicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifier{key: pub, keyType: kt}, nil
+}
+
+func (km *publicKeyManager) NewKey([]byte) (proto.Message, error) {
+	return nil, errors.New("secp256k1 public keys can't be generated")
+}
+
+func (km *publicKeyManager) NewKeyData([]byte) (*tinkpb.KeyData, error) {
+	return nil, errors.New("secp256k1 public keys can't be generated")
+}
+
+func (km *publicKeyManager) DoesSupport(typeURL string) bool {
+	return typeURL == publicKeyTypeURL
+}
+
+func (km *publicKeyManager) TypeURL() string {
+	return publicKeyTypeURL
+}
+
+type signer struct {
+	key     *ecdsa.PrivateKey
+	keyType kms.KeyType
+}
+
+func (s *signer) Sign(data []byte) ([]byte, error) {
+	return Sign(s.key, data, s.keyType)
+}
+
+type verifier struct {
+	key     *ecdsa.PublicKey
+	keyType kms.KeyType
+}
+
+func (v *verifier) Verify(signature, data []byte) error {
+	return Verify(v.key, signature, data, v.keyType)
+}
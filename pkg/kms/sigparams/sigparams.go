@@ -0,0 +1,295 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package sigparams reads the parameters a keyset signs and verifies with (algorithm, curve, hash, signature format
+// and the matching JOSE and COSE algorithms) from the primary key of the keyset, so that clients building proofs or
+// JWS headers are told what the crypto did instead of assuming it from the key type.
+package sigparams
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdsapb "github.com/google/tink/go/proto/ecdsa_go_proto"
+	rsapkcs1pb "github.com/google/tink/go/proto/rsa_ssa_pkcs1_go_proto"
+	rsapsspb "github.com/google/tink/go/proto/rsa_ssa_pss_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	bbspb "github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto/primitive/proto/bbs_go_proto"
+)
+
+// Algorithms of signatures.
+const (
+	AlgorithmECDSA     = "ECDSA"
+	AlgorithmEdDSA     = "EdDSA"
+	AlgorithmRSASSAPSS = "RSASSA-PSS"
+	AlgorithmRSASSAV15 = "RSASSA-PKCS1-v1_5"
+	AlgorithmBBS       = "BBS+"
+)
+
+// Formats of ECDSA signatures.
+const (
+	FormatDER       = "DER"
+	FormatIEEEP1363 = "IEEE_P1363"
+)
+
+type keyKind int
+
+const (
+	kindECDSA keyKind = iota + 1
+	kindSecp256k1
+	kindEd25519
+	kindRSASSAPSS
+	kindRSASSAPKCS1
+	kindBBS
+)
+
+// keyKinds are kinds of signing keys by type URL, of both private and public keys. secp256k1 and RSA-PSS keys of
+// the KMS have their own type URLs.
+var keyKinds = map[string]keyKind{ //nolint:gochecknoglobals
+	"type.googleapis.com/google.crypto.tink.EcdsaPrivateKey":           kindECDSA,
+	"type.googleapis.com/google.crypto.tink.EcdsaPublicKey":            kindECDSA,
+	"type.trustbloc.dev/trustbloc.kms.Secp256k1PrivateKey":             kindSecp256k1,
+	"type.trustbloc.dev/trustbloc.kms.Secp256k1PublicKey":              kindSecp256k1,
+	"type.googleapis.com/google.crypto.tink.Ed25519PrivateKey":         kindEd25519,
+	"type.googleapis.com/google.crypto.tink.Ed25519PublicKey":          kindEd25519,
+	"type.googleapis.com/google.crypto.tink.RsaSsaPssPrivateKey":       kindRSASSAPSS,
+	"type.googleapis.com/google.crypto.tink.RsaSsaPssPublicKey":        kindRSASSAPSS,
+	"type.trustbloc.dev/trustbloc.kms.RsaSsaPssPrivateKey":             kindRSASSAPSS,
+	"type.trustbloc.dev/trustbloc.kms.RsaSsaPssPublicKey":              kindRSASSAPSS,
+	"type.googleapis.com/google.crypto.tink.RsaSsaPkcs1PrivateKey":     kindRSASSAPKCS1,
+	"type.googleapis.com/google.crypto.tink.RsaSsaPkcs1PublicKey":      kindRSASSAPKCS1,
+	"type.hyperledger.org/hyperledger.aries.crypto.tink.BBSPrivateKey": kindBBS,
+	"type.hyperledger.org/hyperledger.aries.crypto.tink.BBSPublicKey":  kindBBS,
+}
+
+// ErrUnsupportedKey is returned when the primary key of the keyset isn't a signing key.
+var ErrUnsupportedKey = errors.New("not a signing key")
+
+// Params are parameters of signatures of a key.
+type Params struct {
+	// Algorithm is the signature algorithm, e.g. ECDSA.
+	Algorithm string
+	// Curve is the curve of the key, e.g. P-256 or Ed25519. Empty for RSA keys.
+	Curve string
+	// Hash is the hash of the message that is signed, e.g. SHA-256.
+	Hash string
+	// Format is the format of ECDSA signatures, DER or IEEE_P1363. Empty for other algorithms.
+	Format string
+	// JOSEAlgorithm is the JWS alg of signatures of the key, empty if there is none. JWS requires ECDSA signatures
+	// in the IEEE P1363 format.
+	JOSEAlgorithm string
+	// COSEAlgorithm is the COSE algorithm of signatures of the key, zero if there is none.
+	COSEAlgorithm int
+	// OutputPrefix is the tink output prefix type of the key: signatures of TINK keys start with 0x01 and the
+	// 4-byte key version, RAW keys have no prefix.
+	OutputPrefix string
+	// KeyVersion is the ID of the key within its keyset, which is the one in the prefix of TINK signatures.
+	KeyVersion uint32
+}
+
+// Of returns the parameters of signatures of the primary key of a keyset handle, private or public.
+func Of(kh interface{}) (*Params, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, fmt.Errorf("%w: key is not a keyset", ErrUnsupportedKey)
+	}
+
+	// only the parameters of the key are read, the key material isn't used
+	key, err := primaryKey(insecurecleartextkeyset.KeysetMaterial(h))
+	if err != nil {
+		return nil, err
+	}
+
+	kind, ok := keyKinds[key.KeyData.TypeUrl]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKey, key.KeyData.TypeUrl)
+	}
+
+	var p *Params
+
+	switch kind {
+	case kindECDSA, kindSecp256k1:
+		p, err = ecdsaParams(key.KeyData, kind == kindSecp256k1)
+	case kindEd25519:
+		p = &Params{Algorithm: AlgorithmEdDSA, Curve: "Ed25519", Hash: "SHA-512", JOSEAlgorithm: "EdDSA",
+			COSEAlgorithm: -8}
+	case kindRSASSAPSS:
+		p, err = rsaSSAPSSParams(key.KeyData)
+	case kindRSASSAPKCS1:
+		p, err = rsaSSAPKCS1Params(key.KeyData)
+	case kindBBS:
+		p, err = bbsParams(key.KeyData)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.OutputPrefix = key.OutputPrefixType.String()
+	p.KeyVersion = key.KeyId
+
+	return p, nil
+}
+
+func primaryKey(ks *tinkpb.Keyset) (*tinkpb.Keyset_Key, error) {
+	for _, key := range ks.Key {
+		if key.KeyId == ks.PrimaryKeyId {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+func ecdsaParams(data *tinkpb.KeyData, secp256k1 bool) (*Params, error) {
+	var params *ecdsapb.EcdsaParams
+
+	if data.KeyMaterialType == tinkpb.KeyData_ASYMMETRIC_PRIVATE {
+		pb := new(ecdsapb.EcdsaPrivateKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid ecdsa private key: %w", err)
+		}
+
+		params = pb.GetPublicKey().GetParams()
+	} else {
+		pb := new(ecdsapb.EcdsaPublicKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid ecdsa public key: %w", err)
+		}
+
+		params = pb.GetParams()
+	}
+
+	p := &Params{Algorithm: AlgorithmECDSA, Hash: hashName(params.GetHashType())}
+
+	switch params.GetEncoding() { //nolint:exhaustive
+	case ecdsapb.EcdsaSignatureEncoding_DER:
+		p.Format = FormatDER
+	case ecdsapb.EcdsaSignatureEncoding_IEEE_P1363:
+		p.Format = FormatIEEEP1363
+	}
+
+	var joseHash commonpb.HashType // the hash JOSE and COSE algorithms of the curve sign with
+
+	// secp256k1 keys don't have a tink curve
+	switch {
+	case secp256k1:
+		p.Curve, p.JOSEAlgorithm, p.COSEAlgorithm, joseHash = "secp256k1", "ES256K", -47, commonpb.HashType_SHA256
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P256:
+		p.Curve, p.JOSEAlgorithm, p.COSEAlgorithm, joseHash = "P-256", "ES256", -7, commonpb.HashType_SHA256
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P384:
+		p.Curve, p.JOSEAlgorithm, p.COSEAlgorithm, joseHash = "P-384", "ES384", -35, commonpb.HashType_SHA384
+	case params.GetCurve() == commonpb.EllipticCurveType_NIST_P521:
+		p.Curve, p.JOSEAlgorithm, p.COSEAlgorithm, joseHash = "P-521", "ES512", -36, commonpb.HashType_SHA512
+	default:
+		return nil, fmt.Errorf("%w: unsupported ecdsa curve %s", ErrUnsupportedKey, params.GetCurve())
+	}
+
+	// other combinations of curve and hash have neither a JWS nor a COSE algorithm
+	if params.GetHashType() != joseHash {
+		p.JOSEAlgorithm, p.COSEAlgorithm = "", 0
+	}
+
+	return p, nil
+}
+
+func rsaSSAPSSParams(data *tinkpb.KeyData) (*Params, error) {
+	var params *rsapsspb.RsaSsaPssParams
+
+	if data.KeyMaterialType == tinkpb.KeyData_ASYMMETRIC_PRIVATE {
+		pb := new(rsapsspb.RsaSsaPssPrivateKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid rsa-pss private key: %w", err)
+		}
+
+		params = pb.GetPublicKey().GetParams()
+	} else {
+		pb := new(rsapsspb.RsaSsaPssPublicKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid rsa-pss public key: %w", err)
+		}
+
+		params = pb.GetParams()
+	}
+
+	p := &Params{Algorithm: AlgorithmRSASSAPSS, Hash: hashName(params.GetSigHash())}
+
+	// PS256 is SHA-256 with MGF1 SHA-256 and a 32-byte salt
+	if params.GetSigHash() == commonpb.HashType_SHA256 && params.GetMgf1Hash() == commonpb.HashType_SHA256 &&
+		params.GetSaltLength() == 32 { //nolint:gomnd
+		p.JOSEAlgorithm, p.COSEAlgorithm = "PS256", -37
+	}
+
+	return p, nil
+}
+
+func rsaSSAPKCS1Params(data *tinkpb.KeyData) (*Params, error) {
+	var params *rsapkcs1pb.RsaSsaPkcs1Params
+
+	if data.KeyMaterialType == tinkpb.KeyData_ASYMMETRIC_PRIVATE {
+		pb := new(rsapkcs1pb.RsaSsaPkcs1PrivateKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid rsa-pkcs1 private key: %w", err)
+		}
+
+		params = pb.GetPublicKey().GetParams()
+	} else {
+		pb := new(rsapkcs1pb.RsaSsaPkcs1PublicKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid rsa-pkcs1 public key: %w", err)
+		}
+
+		params = pb.GetParams()
+	}
+
+	p := &Params{Algorithm: AlgorithmRSASSAV15, Hash: hashName(params.GetHashType())}
+
+	if params.GetHashType() == commonpb.HashType_SHA256 {
+		p.JOSEAlgorithm, p.COSEAlgorithm = "RS256", -257
+	}
+
+	return p, nil
+}
+
+func bbsParams(data *tinkpb.KeyData) (*Params, error) {
+	var params *bbspb.BBSParams
+
+	if data.KeyMaterialType == tinkpb.KeyData_ASYMMETRIC_PRIVATE {
+		pb := new(bbspb.BBSPrivateKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid bbs+ private key: %w", err)
+		}
+
+		params = pb.GetPublicKey().GetParams()
+	} else {
+		pb := new(bbspb.BBSPublicKey)
+		if err := proto.Unmarshal(data.Value, pb); err != nil {
+			return nil, fmt.Errorf("invalid bbs+ public key: %w", err)
+		}
+
+		params = pb.GetParams()
+	}
+
+	// BBS+ signatures have neither a JWS nor a COSE algorithm
+	return &Params{Algorithm: AlgorithmBBS, Curve: "BLS12-381", Hash: hashName(params.GetHashType())}, nil
+}
+
+func hashName(h commonpb.HashType) string {
+	switch h { //nolint:exhaustive
+	case commonpb.HashType_SHA256:
+		return "SHA-256"
+	case commonpb.HashType_SHA384:
+		return "SHA-384"
+	case commonpb.HashType_SHA512:
+		return "SHA-512"
+	default:
+		return h.String()
+	}
+}
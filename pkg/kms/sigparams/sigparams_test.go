@@ -0,0 +1,124 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sigparams_test
+
+import (
+	"testing"
+
+	"github.com/google/tink/go/keyset"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/rsapss"
+	"github.com/trustbloc/kms/pkg/kms/secp256k1"
+	"github.com/trustbloc/kms/pkg/kms/sigparams"
+)
+
+func TestOf(t *testing.T) {
+	p := &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}}
+
+	local, err := localkms.New("local-lock://test", p)
+	require.NoError(t, err)
+
+	secp256k1KMS, err := secp256k1.Wrap(local, "local-lock://test", p)
+	require.NoError(t, err)
+
+	km, err := rsapss.Wrap(secp256k1KMS, "local-lock://test", p)
+	require.NoError(t, err)
+
+	tests := []struct {
+		keyType kms.KeyType
+		params  sigparams.Params
+	}{
+		{kms.ED25519Type, sigparams.Params{Algorithm: "EdDSA", Curve: "Ed25519", Hash: "SHA-512",
+			JOSEAlgorithm: "EdDSA", COSEAlgorithm: -8}},
+		{kms.ECDSAP256TypeDER, sigparams.Params{Algorithm: "ECDSA", Curve: "P-256", Hash: "SHA-256", Format: "DER",
+			JOSEAlgorithm: "ES256", COSEAlgorithm: -7}},
+		{kms.ECDSAP384TypeIEEEP1363, sigparams.Params{Algorithm: "ECDSA", Curve: "P-384", Hash: "SHA-384",
+			Format: "IEEE_P1363", JOSEAlgorithm: "ES384", COSEAlgorithm: -35}},
+		{kms.ECDSAP521TypeDER, sigparams.Params{Algorithm: "ECDSA", Curve: "P-521", Hash: "SHA-512", Format: "DER",
+			JOSEAlgorithm: "ES512", COSEAlgorithm: -36}},
+		{secp256k1.KeyTypeIEEEP1363, sigparams.Params{Algorithm: "ECDSA", Curve: "secp256k1", Hash: "SHA-256",
+			Format: "IEEE_P1363", JOSEAlgorithm: "ES256K", COSEAlgorithm: -47}},
+		{rsapss.KeyType, sigparams.Params{Algorithm: "RSASSA-PSS", Hash: "SHA-256", JOSEAlgorithm: "PS256",
+			COSEAlgorithm: -37}},
+		{kms.BLS12381G2Type, sigparams.Params{Algorithm: "BBS+", Curve: "BLS12-381", Hash: "SHA-256"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			_, kh, err := km.Create(tt.keyType)
+			require.NoError(t, err)
+
+			params, err := sigparams.Of(kh)
+			require.NoError(t, err)
+			require.NotZero(t, params.KeyVersion)
+			require.Equal(t, kh.(*keyset.Handle).KeysetInfo().PrimaryKeyId, params.KeyVersion)
+			require.NotEmpty(t, params.OutputPrefix)
+
+			expected := tt.params
+			expected.OutputPrefix, expected.KeyVersion = params.OutputPrefix, params.KeyVersion
+			require.Equal(t, expected, *params)
+
+			// public keysets have the parameters of their private keys
+			pub, err := kh.(*keyset.Handle).Public()
+			require.NoError(t, err)
+
+			pubParams, err := sigparams.Of(pub)
+			require.NoError(t, err)
+			require.Equal(t, params, pubParams)
+		})
+	}
+
+	t.Run("Output prefix", func(t *testing.T) {
+		// local KMS keys sign without a prefix
+		_, kh, err := km.Create(kms.ECDSAP256TypeIEEEP1363)
+		require.NoError(t, err)
+
+		params, err := sigparams.Of(kh)
+		require.NoError(t, err)
+		require.Equal(t, "RAW", params.OutputPrefix)
+
+		_, kh, err = km.Create(rsapss.KeyType)
+		require.NoError(t, err)
+
+		params, err = sigparams.Of(kh)
+		require.NoError(t, err)
+		require.Equal(t, "RAW", params.OutputPrefix)
+	})
+
+	t.Run("Fail with unsupported key", func(t *testing.T) {
+		_, kh, err := km.Create(kms.AES256GCMType)
+		require.NoError(t, err)
+
+		_, err = sigparams.Of(kh)
+		require.ErrorIs(t, err, sigparams.ErrUnsupportedKey)
+
+		_, err = sigparams.Of("not a keyset")
+		require.ErrorIs(t, err, sigparams.ErrUnsupportedKey)
+	})
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
@@ -0,0 +1,70 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package subkey
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/google/tink/go/subtle/random"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+
+	"github.com/trustbloc/kms/pkg/kms/internal/keysetstore"
+)
+
+// keyIDSize is the size of random IDs of derived keys, like IDs of symmetric keys of the local KMS.
+const keyIDSize = 32
+
+// KeyManager is a local KMS that stores keys derived with Derive. Keys are written to the store of the local KMS,
+// encrypted with its primary key, so that the local KMS reads them like its own keys. Other operations are handled by
+// the wrapped key manager.
+type KeyManager struct {
+	kms.KeyManager
+	keysets *keysetstore.Store
+}
+
+// Wrap adds derived keys to a local KMS. primaryKeyURI and the provider must be the ones the local KMS was created
+// with.
+func Wrap(km kms.KeyManager, primaryKeyURI string, p kms.Provider) (*KeyManager, error) {
+	keysets, err := keysetstore.New(primaryKeyURI, p)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &KeyManager{KeyManager: km, keysets: keysets}, nil
+}
+
+// ImportPrivateKey stores a *Key, which must be derived as a key of the key type, under a random key ID or the ID set
+// with kms.WithKeyID. Other keys are imported by the wrapped key manager.
+func (m *KeyManager) ImportPrivateKey(privKey interface{}, kt kms.KeyType,
+	opts ...kms.PrivateKeyOpts) (string, interface{}, error) {
+	key, ok := privKey.(*Key)
+	if !ok {
+		return m.KeyManager.ImportPrivateKey(privKey, kt, opts...) //nolint:wrapcheck
+	}
+
+	if kt != key.keyType {
+		return "", nil, fmt.Errorf("import derived key: key was derived as %s, not %s", key.keyType, kt)
+	}
+
+	o := kms.NewOpt()
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	kid := o.KsID()
+	if kid == "" {
+		kid = base64.RawURLEncoding.EncodeToString(random.GetRandomBytes(keyIDSize))
+	}
+
+	if err := m.keysets.Put(kid, key.keyset); err != nil {
+		return "", nil, err //nolint:wrapcheck
+	}
+
+	return kid, key.keyset, nil
+}
@@ -0,0 +1,181 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package subkey derives symmetric keys from HMAC keys of local key stores with HKDF (RFC 5869), e.g. per-tenant
+// index keys from a master key, and stores them as new keys of the key store. Neither key leaves the KMS; the HMAC
+// key is read from its keyset here, since the local KMS of aries-framework-go only uses it to compute MACs.
+package subkey
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	aesgcmpb "github.com/google/tink/go/proto/aes_gcm_go_proto"
+	chachapb "github.com/google/tink/go/proto/chacha20_poly1305_go_proto"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	hmacpb "github.com/google/tink/go/proto/hmac_go_proto"
+	tinkpb "github.com/google/tink/go/proto/tink_go_proto"
+	xchachapb "github.com/google/tink/go/proto/xchacha20_poly1305_go_proto"
+	"github.com/google/tink/go/subtle/random"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	hmacTypeURL              = "type.googleapis.com/google.crypto.tink.HmacKey"
+	aesGCMTypeURL            = "type.googleapis.com/google.crypto.tink.AesGcmKey"
+	chaCha20Poly1305TypeURL  = "type.googleapis.com/google.crypto.tink.ChaCha20Poly1305Key"
+	xChaCha20Poly1305TypeURL = "type.googleapis.com/google.crypto.tink.XChaCha20Poly1305Key"
+
+	hmacTagSize = 32
+)
+
+var (
+	// ErrNotHMACKey is returned when the source key is not an HMAC key, e.g. an asymmetric key.
+	ErrNotHMACKey = errors.New("key is not an hmac key")
+	// ErrUnsupportedKeyType is returned for key types that keys can't be derived as.
+	ErrUnsupportedKeyType = errors.New("keys of the key type can't be derived")
+)
+
+// KeyTypes are the key types of derived keys, by the size of their key material in bytes.
+var KeyTypes = map[kms.KeyType]int{ //nolint:gochecknoglobals
+	kms.AES128GCMType:         16,
+	kms.AES256GCMType:         32,
+	kms.ChaCha20Poly1305Type:  32,
+	kms.XChaCha20Poly1305Type: 32,
+	kms.HMACSHA256Tag256Type:  32,
+}
+
+// Key is a key derived with Derive. It's stored in a key store with KeyManager.ImportPrivateKey.
+type Key struct {
+	keyType kms.KeyType
+	keyset  *keyset.Handle
+}
+
+// Derive derives a key of the key type from the primary key of the keyset handle of an HMAC key, with HKDF using the
+// hash function of the HMAC key, the HMAC key as input key material, salt and info. The same key, salt and info
+// always derive the same key.
+func Derive(kh interface{}, salt, info []byte, kt kms.KeyType) (*Key, error) {
+	size, ok := KeyTypes[kt]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, kt)
+	}
+
+	secret, h, err := hmacKey(kh)
+	if err != nil {
+		return nil, err
+	}
+
+	material := make([]byte, size)
+
+	if _, err = io.ReadFull(hkdf.New(h, secret, salt, info), material); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	keyData, err := newKeyData(kt, material)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := random.GetRandomUint32()
+
+	handle, err := insecurecleartextkeyset.Read(&keyset.MemReaderWriter{Keyset: &tinkpb.Keyset{
+		PrimaryKeyId: keyID,
+		Key: []*tinkpb.Keyset_Key{{
+			KeyData:          keyData,
+			Status:           tinkpb.KeyStatusType_ENABLED,
+			KeyId:            keyID,
+			OutputPrefixType: tinkpb.OutputPrefixType_TINK,
+		}},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("create keyset: %w", err)
+	}
+
+	return &Key{keyType: kt, keyset: handle}, nil
+}
+
+// hmacKey returns the HMAC key of the primary key of the keyset handle and its hash function.
+func hmacKey(kh interface{}) ([]byte, func() hash.Hash, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: not a keyset", ErrNotHMACKey)
+	}
+
+	// the key is read in memory only, like the crypto does to compute MACs
+	ks := insecurecleartextkeyset.KeysetMaterial(h)
+
+	for _, key := range ks.Key {
+		if key.KeyId != ks.PrimaryKeyId {
+			continue
+		}
+
+		if key.KeyData.TypeUrl != hmacTypeURL {
+			return nil, nil, ErrNotHMACKey
+		}
+
+		hmacKey := new(hmacpb.HmacKey)
+
+		if err := proto.Unmarshal(key.KeyData.Value, hmacKey); err != nil {
+			return nil, nil, fmt.Errorf("invalid hmac key: %w", err)
+		}
+
+		switch hmacKey.GetParams().GetHash() { //nolint:exhaustive
+		case commonpb.HashType_SHA256:
+			return hmacKey.KeyValue, sha256.New, nil
+		case commonpb.HashType_SHA384:
+			return hmacKey.KeyValue, sha512.New384, nil
+		case commonpb.HashType_SHA512:
+			return hmacKey.KeyValue, sha512.New, nil
+		default:
+			return nil, nil, fmt.Errorf("hmac key hash %s is not supported", hmacKey.GetParams().GetHash())
+		}
+	}
+
+	return nil, nil, errors.New("keyset has no primary key")
+}
+
+// newKeyData returns the Tink key data of a key of the key type with the key material.
+func newKeyData(kt kms.KeyType, material []byte) (*tinkpb.KeyData, error) {
+	var (
+		typeURL string
+		key     proto.Message
+	)
+
+	switch kt { //nolint:exhaustive
+	case kms.AES128GCMType, kms.AES256GCMType:
+		typeURL, key = aesGCMTypeURL, &aesgcmpb.AesGcmKey{KeyValue: material}
+	case kms.ChaCha20Poly1305Type:
+		typeURL, key = chaCha20Poly1305TypeURL, &chachapb.ChaCha20Poly1305Key{KeyValue: material}
+	case kms.XChaCha20Poly1305Type:
+		typeURL, key = xChaCha20Poly1305TypeURL, &xchachapb.XChaCha20Poly1305Key{KeyValue: material}
+	case kms.HMACSHA256Tag256Type:
+		typeURL, key = hmacTypeURL, &hmacpb.HmacKey{
+			Params:   &hmacpb.HmacParams{Hash: commonpb.HashType_SHA256, TagSize: hmacTagSize},
+			KeyValue: material,
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, kt)
+	}
+
+	value, err := proto.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	return &tinkpb.KeyData{
+		TypeUrl:         typeURL,
+		Value:           value,
+		KeyMaterialType: tinkpb.KeyData_SYMMETRIC,
+	}, nil
+}
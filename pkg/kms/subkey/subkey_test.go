@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package subkey_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/kms/subkey"
+)
+
+// tinkPrefixSize is the size of the key ID prefix of outputs of keys with the TINK output prefix.
+const tinkPrefixSize = 5
+
+func TestDerive(t *testing.T) {
+	p := &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}}
+
+	local, err := localkms.New("local-lock://test", p)
+	require.NoError(t, err)
+
+	km, err := subkey.Wrap(local, "local-lock://test", p)
+	require.NoError(t, err)
+
+	cr, err := tinkcrypto.New()
+	require.NoError(t, err)
+
+	_, master, err := km.Create(kms.HMACSHA256Tag256Type)
+	require.NoError(t, err)
+
+	derive := func(t *testing.T, info string, kt kms.KeyType) (string, interface{}) {
+		t.Helper()
+
+		key, err := subkey.Derive(master, []byte("salt"), []byte(info), kt)
+		require.NoError(t, err)
+
+		kid, _, err := km.ImportPrivateKey(key, kt)
+		require.NoError(t, err)
+
+		// the key is read back by the local KMS
+		kh, err := km.Get(kid)
+		require.NoError(t, err)
+
+		return kid, kh
+	}
+
+	t.Run("Derived keys are stored as keys of the key store", func(t *testing.T) {
+		for kt := range subkey.KeyTypes {
+			if kt == kms.HMACSHA256Tag256Type {
+				continue
+			}
+
+			_, kh := derive(t, "tenant-1", kt)
+
+			ct, iv, err := cr.Encrypt([]byte("message"), []byte("aad"), kh)
+			require.NoError(t, err, kt)
+
+			pt, err := cr.Decrypt(ct, []byte("aad"), iv, kh)
+			require.NoError(t, err, kt)
+			require.Equal(t, "message", string(pt))
+		}
+	})
+
+	t.Run("Same salt and info derive the same key", func(t *testing.T) {
+		kid1, kh1 := derive(t, "tenant-1", kms.HMACSHA256Tag256Type)
+		kid2, kh2 := derive(t, "tenant-1", kms.HMACSHA256Tag256Type)
+		_, kh3 := derive(t, "tenant-2", kms.HMACSHA256Tag256Type)
+
+		require.NotEqual(t, kid1, kid2)
+
+		mac1, err := cr.ComputeMAC([]byte("data"), kh1)
+		require.NoError(t, err)
+
+		mac2, err := cr.ComputeMAC([]byte("data"), kh2)
+		require.NoError(t, err)
+
+		mac3, err := cr.ComputeMAC([]byte("data"), kh3)
+		require.NoError(t, err)
+
+		require.Equal(t, mac1[tinkPrefixSize:], mac2[tinkPrefixSize:])
+		require.NotEqual(t, mac1[tinkPrefixSize:], mac3[tinkPrefixSize:])
+
+		masterMAC, err := cr.ComputeMAC([]byte("data"), master)
+		require.NoError(t, err)
+		require.NotEqual(t, masterMAC[tinkPrefixSize:], mac1[tinkPrefixSize:])
+	})
+
+	t.Run("Import with key ID", func(t *testing.T) {
+		key, err := subkey.Derive(master, nil, nil, kms.AES256GCMType)
+		require.NoError(t, err)
+
+		kid, _, err := km.ImportPrivateKey(key, kms.AES256GCMType, kms.WithKeyID("derived"))
+		require.NoError(t, err)
+		require.Equal(t, "derived", kid)
+
+		_, _, err = km.ImportPrivateKey(key, kms.AES256GCMType, kms.WithKeyID("derived"))
+		require.EqualError(t, err, "key derived already exists")
+	})
+
+	t.Run("Fail to import as another key type", func(t *testing.T) {
+		key, err := subkey.Derive(master, nil, nil, kms.AES256GCMType)
+		require.NoError(t, err)
+
+		_, _, err = km.ImportPrivateKey(key, kms.AES128GCMType)
+		require.EqualError(t, err, "import derived key: key was derived as AES256GCM, not AES128GCM")
+	})
+
+	t.Run("Fail to derive from asymmetric key", func(t *testing.T) {
+		_, kh, err := km.Create(kms.ED25519Type)
+		require.NoError(t, err)
+
+		_, err = subkey.Derive(kh, nil, nil, kms.AES256GCMType)
+		require.ErrorIs(t, err, subkey.ErrNotHMACKey)
+
+		_, err = subkey.Derive("not a keyset", nil, nil, kms.AES256GCMType)
+		require.ErrorIs(t, err, subkey.ErrNotHMACKey)
+	})
+
+	t.Run("Fail to derive unsupported key type", func(t *testing.T) {
+		_, err := subkey.Derive(master, nil, nil, kms.ED25519Type)
+		require.ErrorIs(t, err, subkey.ErrUnsupportedKeyType)
+	})
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
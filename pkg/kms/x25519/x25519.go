@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package x25519 derives keys from X25519 ECDH-KW keys of local key stores and peer public keys, for ECIES-style
+// flows where the shared secret is computed by the KMS and the private key never leaves it. The local KMS of
+// aries-framework-go only uses these keys to wrap and unwrap keys, so the private key is read from the keyset here.
+package x25519
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/google/tink/go/insecurecleartextkeyset"
+	"github.com/google/tink/go/keyset"
+	commonpb "github.com/google/tink/go/proto/common_go_proto"
+	ecdhpb "github.com/hyperledger/aries-framework-go/pkg/crypto/tinkcrypto/primitive/proto/ecdh_aead_go_proto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	privateKeyTypeURL = "type.hyperledger.org/hyperledger.aries.crypto.tink.X25519EcdhKwPrivateKey"
+
+	// KeySize is the size of X25519 private and public keys.
+	KeySize = curve25519.ScalarSize
+)
+
+// ErrInvalidPublicKey is returned when the peer public key isn't an X25519 public key, or is a low order point that
+// makes the shared secret all zeros.
+var ErrInvalidPublicKey = errors.New("invalid x25519 public key")
+
+// PrivateKey returns the X25519 private key of a keyset handle of an X25519ECDHKW key.
+func PrivateKey(kh interface{}) ([]byte, error) {
+	h, ok := kh.(*keyset.Handle)
+	if !ok {
+		return nil, errors.New("key is not a keyset")
+	}
+
+	// the key is read in memory only, like the crypto does to unwrap keys
+	ks := insecurecleartextkeyset.KeysetMaterial(h)
+
+	for _, key := range ks.Key {
+		if key.KeyId != ks.PrimaryKeyId {
+			continue
+		}
+
+		if key.KeyData.TypeUrl != privateKeyTypeURL {
+			return nil, errors.New("primary key is not an x25519 ecdh-kw private key")
+		}
+
+		priv := new(ecdhpb.EcdhAeadPrivateKey)
+
+		if err := proto.Unmarshal(key.KeyData.Value, priv); err != nil {
+			return nil, fmt.Errorf("invalid x25519 private key: %w", err)
+		}
+
+		if priv.PublicKey.GetParams().GetKwParams().GetCurveType() != commonpb.EllipticCurveType_CURVE25519 ||
+			len(priv.KeyValue) != KeySize {
+			return nil, errors.New("invalid x25519 private key")
+		}
+
+		return priv.KeyValue, nil
+	}
+
+	return nil, errors.New("keyset has no primary key")
+}
+
+// SharedSecret returns the X25519 shared secret of the private key and the peer public key.
+func SharedSecret(priv, peerPub []byte) ([]byte, error) {
+	if len(peerPub) != KeySize {
+		return nil, fmt.Errorf("%w: must be %d bytes", ErrInvalidPublicKey, KeySize)
+	}
+
+	z, err := curve25519.X25519(priv, peerPub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPublicKey, err)
+	}
+
+	return z, nil
+}
+
+// DeriveKey derives a key of length bytes from the X25519 shared secret of the private key and the peer public key
+// with HKDF-SHA256 (RFC 5869), salt and info.
+func DeriveKey(priv, peerPub, salt, info []byte, length int) ([]byte, error) {
+	z, err := SharedSecret(priv, peerPub)
+	if err != nil {
+		return nil, err
+	}
+
+	key := make([]byte, length)
+
+	if _, err = io.ReadFull(hkdf.New(sha256.New, z, salt, info), key); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package x25519_test
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/kms"
+	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
+	"github.com/hyperledger/aries-framework-go/pkg/secretlock/noop"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/trustbloc/kms/pkg/kms/x25519"
+)
+
+func TestDeriveKey(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	kid, pubBytes, err := km.CreateAndExportPubKeyBytes(kms.X25519ECDHKWType)
+	require.NoError(t, err)
+
+	var pub crypto.PublicKey
+
+	require.NoError(t, json.Unmarshal(pubBytes, &pub))
+
+	kh, err := km.Get(kid)
+	require.NoError(t, err)
+
+	priv, err := x25519.PrivateKey(kh)
+	require.NoError(t, err)
+	require.Len(t, priv, x25519.KeySize)
+
+	peerPriv := make([]byte, x25519.KeySize)
+	_, err = rand.Read(peerPriv)
+	require.NoError(t, err)
+
+	peerPub, err := curve25519.X25519(peerPriv, curve25519.Basepoint)
+	require.NoError(t, err)
+
+	key, err := x25519.DeriveKey(priv, peerPub, []byte("salt"), []byte("info"), 32)
+	require.NoError(t, err)
+	require.Len(t, key, 32)
+
+	// the peer derives the same key from the public key of the KMS key
+	peerKey, err := x25519.DeriveKey(peerPriv, pub.X, []byte("salt"), []byte("info"), 32)
+	require.NoError(t, err)
+	require.Equal(t, key, peerKey)
+
+	other, err := x25519.DeriveKey(priv, peerPub, []byte("salt"), []byte("other info"), 32)
+	require.NoError(t, err)
+	require.NotEqual(t, key, other)
+
+	t.Run("Low order public key", func(t *testing.T) {
+		_, err = x25519.DeriveKey(priv, make([]byte, x25519.KeySize), nil, nil, 32)
+		require.ErrorIs(t, err, x25519.ErrInvalidPublicKey)
+	})
+
+	t.Run("Public key of invalid size", func(t *testing.T) {
+		_, err = x25519.DeriveKey(priv, []byte("short"), nil, nil, 32)
+		require.ErrorIs(t, err, x25519.ErrInvalidPublicKey)
+	})
+}
+
+func TestPrivateKey(t *testing.T) {
+	km, err := localkms.New("local-lock://test", &provider{storage: mem.NewProvider(), lock: &noop.NoLock{}})
+	require.NoError(t, err)
+
+	kid, _, err := km.Create(kms.NISTP256ECDHKWType)
+	require.NoError(t, err)
+
+	kh, err := km.Get(kid)
+	require.NoError(t, err)
+
+	_, err = x25519.PrivateKey(kh)
+	require.EqualError(t, err, "primary key is not an x25519 ecdh-kw private key")
+
+	_, err = x25519.PrivateKey("not a keyset")
+	require.EqualError(t, err, "key is not a keyset")
+}
+
+type provider struct {
+	storage storage.Provider
+	lock    secretlock.Service
+}
+
+func (p *provider) StorageProvider() storage.Provider {
+	return p.storage
+}
+
+func (p *provider) SecretLock() secretlock.Service {
+	return p.lock
+}
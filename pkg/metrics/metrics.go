@@ -12,14 +12,20 @@ import (
 
 	"github.com/hyperledger/aries-framework-go/pkg/common/log"
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustbloc/kms/pkg/breaker"
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/gziplimit"
+	"github.com/trustbloc/kms/pkg/jsonlimit"
 )
 
 const (
 	namespace = "kms"
 
 	// Crypto.
-	crypto               = "crypto"
-	cryptoSignTimeMetric = "sign_seconds"
+	crypto                       = "crypto"
+	cryptoSignTimeMetric         = "sign_seconds"
+	cryptoCanonicalizeTimeMetric = "canonicalize_seconds"
 
 	// DB.
 	db                  = "db"
@@ -30,11 +36,13 @@ const (
 	dbQueryTimeMetric   = "query_seconds"
 	dbDeleteTimeMetric  = "delete_seconds"
 	dbBatchTimeMetric   = "batch_seconds"
+	dbRetriesMetric     = "retries_count"
 
 	// Key store.
 	keyStore                       = "key_store"
 	keyStoreResolveTimeMetric      = "resolve_seconds"
 	keyStoreGetKeyTimeMetric       = "get_key_seconds"
+	keyStoreArchiveRecallMetric    = "archive_recall_seconds"
 	awsSecretLockDecryptTimeMetric = "aws_secret_lock_decrypt_seconds"
 	keySecretLockDecryptTimeMetric = "key_secret_lock_decrypt_seconds"
 	awsSecretLockEncryptTimeMetric = "aws_secret_lock_encrypt_seconds"
@@ -46,6 +54,19 @@ const (
 	zcapCapabilityResolveTimeMetric = "capability_resolve_seconds"
 	zcapLoadDocumentTimeMetric      = "load_document_seconds"
 	zcapVDRResolveTimeMetric        = "vdr_resolve_seconds"
+
+	// Requests.
+	request                      = "request"
+	requestLimitRejectionsMetric = "limit_rejections_count"
+
+	// Expiring records.
+	expiringRecords           = "expiring_records"
+	expiringRecordsLiveMetric = "live_count"
+	expiredRecordsMetric      = "expired_count"
+
+	// Dependencies.
+	dependency                   = "dependency"
+	dependencyBreakerStateMetric = "breaker_state"
 )
 
 var logger = log.New("metrics")
@@ -57,7 +78,8 @@ var (
 
 // Metrics manages the metrics for KMS.
 type Metrics struct {
-	cryptoSignTime prometheus.Histogram
+	cryptoSignTime          prometheus.Histogram
+	cryptoCanonicalizeTimes map[string]prometheus.Histogram
 
 	dbPutTimes     map[string]prometheus.Histogram
 	dbGetTimes     map[string]prometheus.Histogram
@@ -66,9 +88,11 @@ type Metrics struct {
 	dbQueryTimes   map[string]prometheus.Histogram
 	dbDeleteTimes  map[string]prometheus.Histogram
 	dbBatchTimes   map[string]prometheus.Histogram
+	dbRetries      map[string]prometheus.Counter
 
 	keyStoreResolveTime prometheus.Histogram
 	keyStoreGetKeyTime  prometheus.Histogram
+	archiveRecallTime   prometheus.Histogram
 
 	awsSecretLockDecryptTime prometheus.Histogram
 	keySecretLockDecryptTime prometheus.Histogram
@@ -80,6 +104,13 @@ type Metrics struct {
 	zcapldCapabilityResolveTime prometheus.Histogram
 	zcapldLoadDocumentTime      prometheus.Histogram
 	zcapldVDRResolve            prometheus.Histogram
+
+	requestLimitRejections map[string]prometheus.Counter
+
+	expiringRecordsLive map[string]prometheus.Gauge
+	expiredRecords      map[string]prometheus.Counter
+
+	dependencyBreakerStates map[string]prometheus.Gauge
 }
 
 // Get returns an KMS metrics provider.
@@ -93,9 +124,17 @@ func Get() *Metrics {
 
 func newMetrics() *Metrics {
 	dbTypes := []string{"CouchDB", "MongoDB", "EDV", "Cache"}
+	canonicalizationProfiles := []string{"none", "jcs", "urdna2015"}
+	recordClasses := []string{
+		expiry.ClassIdempotencyKey, expiry.ClassSignNonce, expiry.ClassOneTimeToken, expiry.ClassUpload,
+	}
+	dependencies := []string{
+		breaker.DependencyHubAuth, breaker.DependencyEDV, breaker.DependencyDIDResolver, breaker.DependencyWebhook,
+	}
 
 	m := &Metrics{
 		cryptoSignTime:              newCryptoSignTime(),
+		cryptoCanonicalizeTimes:     newCryptoCanonicalizeTime(canonicalizationProfiles),
 		dbPutTimes:                  newDBPutTime(dbTypes),
 		dbGetTimes:                  newDBGetTime(dbTypes),
 		dbGetTagsTimes:              newDBGetTagsTime(dbTypes),
@@ -103,8 +142,10 @@ func newMetrics() *Metrics {
 		dbQueryTimes:                newDBQueryTime(dbTypes),
 		dbDeleteTimes:               newDBDeleteTime(dbTypes),
 		dbBatchTimes:                newDBBatchTime(dbTypes),
+		dbRetries:                   newDBRetries(dbTypes),
 		keyStoreResolveTime:         newKeyStoreResolveTime(),
 		keyStoreGetKeyTime:          newKeyStoreGetKeyTime(),
+		archiveRecallTime:           newArchiveRecallTime(),
 		awsSecretLockDecryptTime:    newAWSSecretLockDecryptTime(),
 		keySecretLockDecryptTime:    newKeySecretLockDecryptTime(),
 		awsSecretLockEncryptTime:    newAWSSecretLockEncryptTime(),
@@ -113,14 +154,24 @@ func newMetrics() *Metrics {
 		zcapldCapabilityResolveTime: newZCAPCapabilityResolveTime(),
 		zcapldLoadDocumentTime:      newZCAPLoadDocumentTime(),
 		zcapldVDRResolve:            newZCAPVDRResolveTime(),
+		requestLimitRejections: newRequestLimitRejections(
+			[]string{jsonlimit.LimitDepth, jsonlimit.LimitArrayLength, jsonlimit.LimitStringLength,
+				gziplimit.LimitDecompressedSize}),
+		expiringRecordsLive:     newExpiringRecordsLive(recordClasses),
+		expiredRecords:          newExpiredRecords(recordClasses),
+		dependencyBreakerStates: newDependencyBreakerStates(dependencies),
 	}
 
 	prometheus.MustRegister(
 		m.cryptoSignTime, m.keyStoreResolveTime, m.keyStoreGetKeyTime, m.awsSecretLockDecryptTime, m.keySecretLockDecryptTime,
 		m.awsSecretLockEncryptTime, m.keySecretLockEncryptTime, m.zcapldTime, m.zcapldCapabilityResolveTime,
-		m.zcapldLoadDocumentTime, m.zcapldVDRResolve,
+		m.zcapldLoadDocumentTime, m.zcapldVDRResolve, m.archiveRecallTime,
 	)
 
+	for _, c := range m.cryptoCanonicalizeTimes {
+		prometheus.MustRegister(c)
+	}
+
 	for _, c := range m.dbPutTimes {
 		prometheus.MustRegister(c)
 	}
@@ -149,6 +200,26 @@ func newMetrics() *Metrics {
 		prometheus.MustRegister(c)
 	}
 
+	for _, c := range m.dbRetries {
+		prometheus.MustRegister(c)
+	}
+
+	for _, c := range m.requestLimitRejections {
+		prometheus.MustRegister(c)
+	}
+
+	for _, c := range m.expiringRecordsLive {
+		prometheus.MustRegister(c)
+	}
+
+	for _, c := range m.expiredRecords {
+		prometheus.MustRegister(c)
+	}
+
+	for _, c := range m.dependencyBreakerStates {
+		prometheus.MustRegister(c)
+	}
+
 	return m
 }
 
@@ -159,6 +230,13 @@ func (m *Metrics) CryptoSignTime(value time.Duration) {
 	logger.Debugf("Sign time: %s", value)
 }
 
+// CryptoCanonicalizeTime records the time it takes to canonicalize a document with the profile before signing.
+func (m *Metrics) CryptoCanonicalizeTime(profile string, value time.Duration) {
+	if c, ok := m.cryptoCanonicalizeTimes[profile]; ok {
+		c.Observe(value.Seconds())
+	}
+}
+
 // DBPutTime records the time it takes to store data in db.
 func (m *Metrics) DBPutTime(dbType string, value time.Duration) {
 	if c, ok := m.dbPutTimes[dbType]; ok {
@@ -208,6 +286,13 @@ func (m *Metrics) DBBatchTime(dbType string, value time.Duration) {
 	}
 }
 
+// DBRetry records a retry of a db operation that failed with a transient error.
+func (m *Metrics) DBRetry(dbType string) {
+	if c, ok := m.dbRetries[dbType]; ok {
+		c.Inc()
+	}
+}
+
 // KeyStoreResolveTime records the time it takes to resolve a key store.
 func (m *Metrics) KeyStoreResolveTime(value time.Duration) {
 	m.keyStoreResolveTime.Observe(value.Seconds())
@@ -222,6 +307,13 @@ func (m *Metrics) KeyStoreGetKeyTime(value time.Duration) {
 	logger.Debugf("KeyStoreGetKey time: %s", value)
 }
 
+// ArchiveRecallTime records the time it takes to recall an archived key to primary storage.
+func (m *Metrics) ArchiveRecallTime(value time.Duration) {
+	m.archiveRecallTime.Observe(value.Seconds())
+
+	logger.Debugf("ArchiveRecall time: %s", value)
+}
+
 // AWSSecretLockDecryptTime records the time it takes to decrypt key from a key store.
 func (m *Metrics) AWSSecretLockDecryptTime(value time.Duration) {
 	m.awsSecretLockDecryptTime.Observe(value.Seconds())
@@ -278,6 +370,35 @@ func (m *Metrics) ZCAPLDVDRResolveTime(value time.Duration) {
 	logger.Debugf("ZCAPLD VDR resolve time: %s", value)
 }
 
+// RequestLimitRejection records a request rejected because its body or invoked capability exceeds a decoding limit,
+// e.g. the max depth.
+func (m *Metrics) RequestLimitRejection(limit string) {
+	if c, ok := m.requestLimitRejections[limit]; ok {
+		c.Inc()
+	}
+}
+
+// ExpiringRecords records the number of live records of the class, as of the last pruning.
+func (m *Metrics) ExpiringRecords(class string, live int) {
+	if c, ok := m.expiringRecordsLive[class]; ok {
+		c.Set(float64(live))
+	}
+}
+
+// ExpiredRecords records expired records of the class deleted by pruning.
+func (m *Metrics) ExpiredRecords(class string, count int) {
+	if c, ok := m.expiredRecords[class]; ok {
+		c.Add(float64(count))
+	}
+}
+
+// DependencyBreakerState records the state of the circuit breaker of the dependency: 0 closed, 1 half-open, 2 open.
+func (m *Metrics) DependencyBreakerState(dependency string, state int) {
+	if c, ok := m.dependencyBreakerStates[dependency]; ok {
+		c.Set(float64(state))
+	}
+}
+
 func newHistogram(subsystem, name, help string, labels prometheus.Labels) prometheus.Histogram {
 	return prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace:   namespace,
@@ -296,6 +417,20 @@ func newCryptoSignTime() prometheus.Histogram {
 	)
 }
 
+func newCryptoCanonicalizeTime(profiles []string) map[string]prometheus.Histogram {
+	counters := make(map[string]prometheus.Histogram)
+
+	for _, profile := range profiles {
+		counters[profile] = newHistogram(
+			crypto, cryptoCanonicalizeTimeMetric,
+			"The time (in seconds) that it takes to canonicalize document before signing.",
+			prometheus.Labels{"profile": profile},
+		)
+	}
+
+	return counters
+}
+
 func newDBPutTime(dbTypes []string) map[string]prometheus.Histogram {
 	counters := make(map[string]prometheus.Histogram)
 
@@ -410,6 +545,14 @@ func newKeyStoreGetKeyTime() prometheus.Histogram {
 	)
 }
 
+func newArchiveRecallTime() prometheus.Histogram {
+	return newHistogram(
+		keyStore, keyStoreArchiveRecallMetric,
+		"The time (in seconds) that it takes to recall an archived key to primary storage.",
+		nil,
+	)
+}
+
 func newAWSSecretLockDecryptTime() prometheus.Histogram {
 	return newHistogram(
 		keyStore, awsSecretLockDecryptTimeMetric,
@@ -473,3 +616,83 @@ func newZCAPVDRResolveTime() prometheus.Histogram {
 		nil,
 	)
 }
+
+func newRequestLimitRejections(limits []string) map[string]prometheus.Counter {
+	counters := make(map[string]prometheus.Counter)
+
+	for _, limit := range limits {
+		counters[limit] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   request,
+			Name:        requestLimitRejectionsMetric,
+			Help:        "The number of requests rejected because their body or capability exceeds a decoding limit.",
+			ConstLabels: prometheus.Labels{"limit": limit},
+		})
+	}
+
+	return counters
+}
+
+func newDBRetries(dbTypes []string) map[string]prometheus.Counter {
+	counters := make(map[string]prometheus.Counter)
+
+	for _, dbType := range dbTypes {
+		counters[dbType] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   db,
+			Name:        dbRetriesMetric,
+			Help:        "The number of db operations retried after a transient error.",
+			ConstLabels: prometheus.Labels{"type": dbType},
+		})
+	}
+
+	return counters
+}
+
+func newExpiringRecordsLive(classes []string) map[string]prometheus.Gauge {
+	gauges := make(map[string]prometheus.Gauge)
+
+	for _, class := range classes {
+		gauges[class] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   expiringRecords,
+			Name:        expiringRecordsLiveMetric,
+			Help:        "The number of records that haven't expired yet, as of the last pruning.",
+			ConstLabels: prometheus.Labels{"class": class},
+		})
+	}
+
+	return gauges
+}
+
+func newExpiredRecords(classes []string) map[string]prometheus.Counter {
+	counters := make(map[string]prometheus.Counter)
+
+	for _, class := range classes {
+		counters[class] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   expiringRecords,
+			Name:        expiredRecordsMetric,
+			Help:        "The number of expired records deleted by pruning.",
+			ConstLabels: prometheus.Labels{"class": class},
+		})
+	}
+
+	return counters
+}
+
+func newDependencyBreakerStates(dependencies []string) map[string]prometheus.Gauge {
+	gauges := make(map[string]prometheus.Gauge)
+
+	for _, dep := range dependencies {
+		gauges[dep] = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   dependency,
+			Name:        dependencyBreakerStateMetric,
+			Help:        "The state of the circuit breaker of the dependency: 0 closed, 1 half-open, 2 open.",
+			ConstLabels: prometheus.Labels{"dependency": dep},
+		})
+	}
+
+	return gauges
+}
@@ -22,6 +22,7 @@ func TestMetrics(t *testing.T) {
 
 	t.Run("Metrics create", func(t *testing.T) {
 		require.NotPanics(t, func() { m.CryptoSignTime(time.Second) })
+		require.NotPanics(t, func() { m.CryptoCanonicalizeTime("urdna2015", time.Second) })
 		require.NotPanics(t, func() { m.DBPutTime("CouchDB", time.Second) })
 		require.NotPanics(t, func() { m.DBGetTime("CouchDB", time.Second) })
 		require.NotPanics(t, func() { m.DBGetTagsTime("CouchDB", time.Second) })
@@ -29,8 +30,10 @@ func TestMetrics(t *testing.T) {
 		require.NotPanics(t, func() { m.DBQueryTime("CouchDB", time.Second) })
 		require.NotPanics(t, func() { m.DBDeleteTime("CouchDB", time.Second) })
 		require.NotPanics(t, func() { m.DBBatchTime("CouchDB", time.Second) })
+		require.NotPanics(t, func() { m.DBRetry("MongoDB") })
 		require.NotPanics(t, func() { m.KeyStoreGetKeyTime(time.Second) })
 		require.NotPanics(t, func() { m.KeyStoreResolveTime(time.Second) })
+		require.NotPanics(t, func() { m.ArchiveRecallTime(time.Second) })
 		require.NotPanics(t, func() { m.AWSSecretLockEncryptTime(time.Second) })
 		require.NotPanics(t, func() { m.AWSSecretLockDecryptTime(time.Second) })
 		require.NotPanics(t, func() { m.KeySecretLockEncryptTime(time.Second) })
@@ -39,5 +42,9 @@ func TestMetrics(t *testing.T) {
 		require.NotPanics(t, func() { m.ZCAPLDCapabilityResolveTime(time.Second) })
 		require.NotPanics(t, func() { m.ZCAPLDLoadDocumentTime(time.Second) })
 		require.NotPanics(t, func() { m.ZCAPLDVDRResolveTime(time.Second) })
+		require.NotPanics(t, func() { m.RequestLimitRejection("max_depth") })
+		require.NotPanics(t, func() { m.ExpiringRecords("sign_nonce", 3) })
+		require.NotPanics(t, func() { m.ExpiredRecords("sign_nonce", 2) })
+		require.NotPanics(t, func() { m.DependencyBreakerState("hub-auth", 2) })
 	})
 }
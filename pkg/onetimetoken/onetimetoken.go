@@ -0,0 +1,203 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package onetimetoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/expiry"
+)
+
+const (
+	// StoreName is the name of the store with one-time tokens.
+	StoreName = "onetimetokens"
+
+	tokenKeyPrefix    = "token_"
+	consumedKeyPrefix = "consumed_"
+	secretSize        = 32
+	idSize            = 16
+)
+
+// Errors returned by Check and Consume.
+var (
+	ErrInvalid  = errors.New("invalid one-time token")
+	ErrMismatch = errors.New("one-time token does not authorize the operation")
+	ErrExpired  = errors.New("one-time token expired")
+	ErrConsumed = errors.New("one-time token already used")
+)
+
+// Token operations are audited separately from key operations. Audit entries carry the token ID, never the secret.
+var auditLogger = log.New("onetimetoken-audit")
+
+// Token is a single-use authorization of an operation on a key.
+type Token struct {
+	// ID identifies the minting event. It is not a secret and links audit entries of the operation to the minting.
+	ID         string    `json:"id"`
+	KeyStoreID string    `json:"key_store_id"`
+	KeyID      string    `json:"key_id"`
+	Action     string    `json:"action"`
+	MintedAt   time.Time `json:"minted_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Store mints and consumes one-time tokens. Tokens are kept in storage, so a token minted on one replica can be
+// consumed on any other. Tokens and their consumed markers are pruned once the tokens expire.
+type Store struct {
+	store *expiry.Store
+	clock clock.Clock
+	mutex sync.Mutex // serializes consumption within the process
+}
+
+// New returns a new Store. Expired tokens are pruned as configured by the options.
+func New(provider storage.Provider, clk clock.Clock, opts ...expiry.Option) (*Store, error) {
+	store, err := expiry.Open(provider, StoreName, expiry.ClassOneTimeToken, clk, opts...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &Store{store: store, clock: clk}, nil
+}
+
+// Mint creates a token that authorizes exactly one action on the key until ttl elapses. The returned secret is
+// presented by the bearer of the token; only its hash is stored.
+func (s *Store) Mint(keyStoreID, keyID, action string, ttl time.Duration) (string, *Token, error) {
+	secret, err := randomString(secretSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("generate secret: %w", err)
+	}
+
+	id, err := randomString(idSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("generate id: %w", err)
+	}
+
+	now := s.clock.Now().UTC()
+
+	token := &Token{
+		ID:         id,
+		KeyStoreID: keyStoreID,
+		KeyID:      keyID,
+		Action:     action,
+		MintedAt:   now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	b, err := json.Marshal(token)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshal token: %w", err)
+	}
+
+	if err = s.store.Put(tokenKeyPrefix+hashSecret(secret), b, token.ExpiresAt, false); err != nil {
+		return "", nil, fmt.Errorf("save token: %w", err)
+	}
+
+	auditLogger.Infof("one-time token minted: id=%s keystore=%s key=%s action=%s expires=%s",
+		token.ID, token.KeyStoreID, token.KeyID, token.Action, token.ExpiresAt.Format(time.RFC3339))
+
+	return secret, token, nil
+}
+
+// Check returns the token for the secret if it authorizes the action on the key, without consuming it.
+func (s *Store) Check(secret, keyStoreID, keyID, action string) (*Token, error) {
+	token, err := s.get(hashSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if token.KeyStoreID != keyStoreID || token.KeyID != keyID || token.Action != action {
+		return nil, ErrMismatch
+	}
+
+	if _, err = s.store.Get(consumedKeyPrefix + hashSecret(secret)); err == nil {
+		return nil, ErrConsumed
+	} else if !errors.Is(err, storage.ErrDataNotFound) {
+		return nil, fmt.Errorf("get consumed marker: %w", err)
+	}
+
+	if s.store.Expired(token.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	return token, nil
+}
+
+// Consume checks that the token for the secret authorizes the action on the key and marks it as used. Of concurrent
+// consumers of the same token exactly one succeeds, others get ErrConsumed. The marker is stored as a new key, so
+// the guarantee holds across replicas with storage that rejects existing keys for storage.PutOptions.IsNewKey
+// (e.g. MongoDB).
+func (s *Store) Consume(secret, keyStoreID, keyID, action string) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err := s.Check(secret, keyStoreID, keyID, action)
+	if err != nil {
+		return nil, err
+	}
+
+	// the marker is needed only as long as the token could be consumed
+	err = s.store.Put(consumedKeyPrefix+hashSecret(secret), []byte(s.clock.Now().UTC().Format(time.RFC3339Nano)),
+		token.ExpiresAt, true)
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		return nil, ErrConsumed
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("save consumed marker: %w", err)
+	}
+
+	auditLogger.Infof("one-time token consumed: id=%s keystore=%s key=%s action=%s minted=%s",
+		token.ID, token.KeyStoreID, token.KeyID, token.Action, token.MintedAt.Format(time.RFC3339))
+
+	return token, nil
+}
+
+func (s *Store) get(hash string) (*Token, error) {
+	b, err := s.store.Get(tokenKeyPrefix + hash)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, ErrInvalid
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+
+	var token Token
+
+	if err = json.Unmarshal(b, &token); err != nil {
+		return nil, fmt.Errorf("unmarshal token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(h[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err //nolint:wrapcheck
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package onetimetoken_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/onetimetoken"
+)
+
+func TestStore_Consume(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		s, clk := newStore(t, mem.NewProvider())
+
+		secret, minted, err := s.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+		require.NotEmpty(t, secret)
+		require.NotEmpty(t, minted.ID)
+		require.Equal(t, clk.Now().Add(time.Minute), minted.ExpiresAt)
+
+		token, err := s.Consume(secret, "ks", "key", "verify")
+		require.NoError(t, err)
+		require.Equal(t, minted.ID, token.ID)
+	})
+
+	t.Run("Token is used once", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		secret, _, err := s.Mint("ks", "key", "exportKey", time.Minute)
+		require.NoError(t, err)
+
+		_, err = s.Consume(secret, "ks", "key", "exportKey")
+		require.NoError(t, err)
+
+		_, err = s.Consume(secret, "ks", "key", "exportKey")
+		require.ErrorIs(t, err, onetimetoken.ErrConsumed)
+
+		_, err = s.Check(secret, "ks", "key", "exportKey")
+		require.ErrorIs(t, err, onetimetoken.ErrConsumed)
+	})
+
+	t.Run("Token is used once across replicas", func(t *testing.T) {
+		provider := &newKeyProvider{Provider: mem.NewProvider()}
+
+		s1, _ := newStore(t, provider)
+		s2, _ := newStore(t, provider)
+
+		secret, _, err := s1.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		var (
+			wg        sync.WaitGroup
+			succeeded int32
+		)
+
+		for i := 0; i < 10; i++ {
+			s := s1
+			if i%2 == 1 {
+				s = s2
+			}
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				if _, e := s.Consume(secret, "ks", "key", "verify"); e == nil {
+					atomic.AddInt32(&succeeded, 1)
+				} else if !errors.Is(e, onetimetoken.ErrConsumed) {
+					t.Errorf("unexpected error: %v", e)
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		require.EqualValues(t, 1, succeeded)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		s, clk := newStore(t, mem.NewProvider())
+
+		secret, _, err := s.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+
+		_, err = s.Consume(secret, "ks", "key", "verify")
+		require.ErrorIs(t, err, onetimetoken.ErrExpired)
+	})
+
+	t.Run("Expired token and consumed marker are pruned", func(t *testing.T) {
+		provider := mem.NewProvider()
+		clk := testutil.NewFakeClock(time.Now())
+		pruner := expiry.NewPruner(time.Hour)
+
+		s, err := onetimetoken.New(provider, clk, expiry.WithMode(expiry.ModeHeap), expiry.WithPruner(pruner))
+		require.NoError(t, err)
+
+		secret, _, err := s.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		_, err = s.Consume(secret, "ks", "key", "verify")
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+		pruner.Prune()
+
+		_, err = s.Check(secret, "ks", "key", "verify")
+		require.ErrorIs(t, err, onetimetoken.ErrInvalid)
+
+		store, err := provider.OpenStore(onetimetoken.StoreName)
+		require.NoError(t, err)
+
+		it, err := store.Query("expires_at")
+		require.NoError(t, err)
+
+		n, err := it.TotalItems()
+		require.NoError(t, err)
+		require.Zero(t, n)
+	})
+
+	t.Run("Token for another operation", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		secret, _, err := s.Mint("ks", "key", "verify", time.Minute)
+		require.NoError(t, err)
+
+		_, err = s.Consume(secret, "ks", "key", "exportKey")
+		require.ErrorIs(t, err, onetimetoken.ErrMismatch)
+
+		_, err = s.Consume(secret, "ks", "other", "verify")
+		require.ErrorIs(t, err, onetimetoken.ErrMismatch)
+
+		_, err = s.Consume(secret, "ks", "key", "verify")
+		require.NoError(t, err)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		_, err := s.Consume("unknown", "ks", "key", "verify")
+		require.ErrorIs(t, err, onetimetoken.ErrInvalid)
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := onetimetoken.New(&newKeyProvider{openErr: errors.New("open error")}, testutil.NewFakeClock(time.Now()))
+	require.EqualError(t, err, "open store: open error")
+}
+
+func newStore(t *testing.T, provider storage.Provider) (*onetimetoken.Store, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s, err := onetimetoken.New(provider, clk)
+	require.NoError(t, err)
+
+	return s, clk
+}
+
+// newKeyProvider rejects existing keys stored with IsNewKey option, like MongoDB does.
+type newKeyProvider struct {
+	storage.Provider
+	openErr error
+	mutex   sync.Mutex
+}
+
+func (p *newKeyProvider) OpenStore(name string) (storage.Store, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newKeyStore{Store: store, mutex: &p.mutex}, nil
+}
+
+type newKeyStore struct {
+	storage.Store
+	mutex *sync.Mutex
+}
+
+func (s *newKeyStore) Batch(operations []storage.Operation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, op := range operations {
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			continue
+		}
+
+		if _, err := s.Store.Get(op.Key); err == nil {
+			return storage.ErrDuplicateKey
+		}
+	}
+
+	return s.Store.Batch(operations)
+}
@@ -0,0 +1,234 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package readiness implements a readiness probe: the server is ready to serve traffic if its dependencies, e.g. the
+// database and the Auth server, answer within a short timeout. Unlike /healthcheck, which answers as long as the
+// process runs, the probe fails while a dependency is unreachable, so that the pod is taken out of rotation.
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+const (
+	// Path is a path of the readiness probe.
+	Path = "/ready"
+	// DefaultTimeout is the default timeout of each check.
+	DefaultTimeout = 2 * time.Second
+
+	statusReady    = "ready"
+	statusNotReady = "not_ready"
+	statusOK       = "ok"
+	statusFail     = "fail"
+
+	// probeKey is a key that is looked up to check a store; it's never written, so a healthy store reports it missing.
+	probeKey = "readiness_probe"
+)
+
+var logger = log.New("readiness")
+
+// Check checks a dependency. It returns an error if the dependency is unavailable.
+type Check func(ctx context.Context) error
+
+// Option configures the Checker.
+type Option func(c *Checker)
+
+// WithTimeout sets the timeout of each check. Defaults to DefaultTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = timeout
+	}
+}
+
+// Checker runs checks of dependencies of the server.
+type Checker struct {
+	names   []string
+	checks  map[string]Check
+	timeout time.Duration
+}
+
+// New returns a Checker without checks.
+func New(opts ...Option) *Checker {
+	c := &Checker{
+		checks:  make(map[string]Check),
+		timeout: DefaultTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Add adds a check of the named dependency. A check added with the name of an earlier check replaces it.
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+
+	c.checks[name] = check
+}
+
+// DependencyStatus is a status of a dependency.
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Response is a response of the readiness probe.
+type Response struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*DependencyStatus `json:"dependencies"`
+}
+
+// Check runs all checks concurrently, each with the timeout, and reports whether all dependencies are available.
+func (c *Checker) Check(ctx context.Context) *Response {
+	resp := &Response{
+		Status:       statusReady,
+		Dependencies: make(map[string]*DependencyStatus, len(c.names)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, name := range c.names {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			err := c.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				resp.Status = statusNotReady
+				resp.Dependencies[name] = &DependencyStatus{Status: statusFail, Error: err.Error()}
+
+				return
+			}
+
+			resp.Dependencies[name] = &DependencyStatus{Status: statusOK}
+		}(name, c.checks[name])
+	}
+
+	wg.Wait()
+
+	return resp
+}
+
+// run runs the check with the timeout. Checks that ignore the context, e.g. of storage providers, are abandoned when
+// the timeout expires.
+func (c *Checker) run(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check timed out after %s", c.timeout)
+	}
+}
+
+// Handler returns a handler of the readiness probe. It responds with 200 if all dependencies are available and with
+// 503 otherwise; the body reports the status of each dependency.
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := c.Check(r.Context())
+
+		code := http.StatusOK
+
+		if resp.Status != statusReady {
+			code = http.StatusServiceUnavailable
+
+			logger.Warnf("Not ready: %s", failedDependencies(resp))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Errorf("Failed to write readiness response: %v", err)
+		}
+	})
+}
+
+func failedDependencies(resp *Response) string {
+	var failed []string
+
+	for name, status := range resp.Dependencies {
+		if status.Status != statusOK {
+			failed = append(failed, fmt.Sprintf("%s (%s)", name, status.Error))
+		}
+	}
+
+	sort.Strings(failed)
+
+	return fmt.Sprint(failed)
+}
+
+// StoreCheck returns a check that reads a record of the named store of the provider. The record doesn't exist, so the
+// store is available if the read reports it missing.
+func StoreCheck(provider storage.Provider, storeName string) Check {
+	return func(context.Context) error {
+		store, err := provider.OpenStore(storeName)
+		if err != nil {
+			return fmt.Errorf("open store %s: %w", storeName, err)
+		}
+
+		if _, err = store.Get(probeKey); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("read store %s: %w", storeName, err)
+		}
+
+		return nil
+	}
+}
+
+// HTTPCheck returns a check that sends a GET request to the URL returned by the function; the function is called
+// for each check, so that the check follows re-resolved URLs. The server is available if it responds with a status
+// below 500.
+func HTTPCheck(client *http.Client, url func() string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url(), nil)
+		if err != nil {
+			return fmt.Errorf("create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("send request: %w", err)
+		}
+
+		defer resp.Body.Close() //nolint:errcheck // the body isn't read
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package readiness_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/readiness"
+)
+
+func TestHandler(t *testing.T) {
+	serve := func(t *testing.T, c *readiness.Checker) (int, *readiness.Response) {
+		t.Helper()
+
+		rr := httptest.NewRecorder()
+
+		c.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, readiness.Path, nil))
+
+		var resp readiness.Response
+
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+
+		return rr.Code, &resp
+	}
+
+	t.Run("Ready if all checks pass", func(t *testing.T) {
+		c := readiness.New()
+		c.Add("database", readiness.StoreCheck(mem.NewProvider(), "keystores"))
+		c.Add("auth_server", func(context.Context) error { return nil })
+
+		code, resp := serve(t, c)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "ready", resp.Status)
+		require.Equal(t, &readiness.DependencyStatus{Status: "ok"}, resp.Dependencies["database"])
+		require.Equal(t, &readiness.DependencyStatus{Status: "ok"}, resp.Dependencies["auth_server"])
+	})
+
+	t.Run("Not ready if a check fails", func(t *testing.T) {
+		c := readiness.New()
+		c.Add("database", readiness.StoreCheck(&failingProvider{}, "keystores"))
+		c.Add("auth_server", func(context.Context) error { return nil })
+
+		code, resp := serve(t, c)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "not_ready", resp.Status)
+		require.Equal(t, &readiness.DependencyStatus{
+			Status: "fail",
+			Error:  "open store keystores: connection refused",
+		}, resp.Dependencies["database"])
+		require.Equal(t, "ok", resp.Dependencies["auth_server"].Status)
+	})
+
+	t.Run("Not ready if a check times out", func(t *testing.T) {
+		c := readiness.New(readiness.WithTimeout(10 * time.Millisecond))
+		c.Add("database", func(context.Context) error {
+			time.Sleep(time.Second)
+
+			return nil
+		})
+
+		start := time.Now()
+
+		code, resp := serve(t, c)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "check timed out after 10ms", resp.Dependencies["database"].Error)
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Later check replaces earlier check of the same name", func(t *testing.T) {
+		c := readiness.New()
+		c.Add("database", func(context.Context) error { return errors.New("down") })
+		c.Add("database", func(context.Context) error { return nil })
+
+		code, resp := serve(t, c)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, resp.Dependencies, 1)
+	})
+}
+
+func TestHTTPCheck(t *testing.T) {
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthcheck", r.URL.Path)
+
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	check := readiness.HTTPCheck(srv.Client(), func() string { return srv.URL + "/healthcheck" })
+
+	require.NoError(t, check(context.Background()))
+
+	status = http.StatusUnauthorized
+	require.NoError(t, check(context.Background()))
+
+	status = http.StatusBadGateway
+	require.EqualError(t, check(context.Background()), "unexpected status 502")
+
+	unreachable := readiness.HTTPCheck(srv.Client(), func() string { return "http://127.0.0.1:1" })
+	require.Error(t, unreachable(context.Background()))
+
+	invalid := readiness.HTTPCheck(srv.Client(), func() string { return "%zz" })
+	require.Error(t, invalid(context.Background()))
+}
+
+func TestStoreCheck(t *testing.T) {
+	require.NoError(t, readiness.StoreCheck(mem.NewProvider(), "kmsdb")(context.Background()))
+
+	err := readiness.StoreCheck(&failingProvider{store: &failingStore{}}, "kmsdb")(context.Background())
+	require.EqualError(t, err, "read store kmsdb: connection refused")
+}
+
+var errConnectionRefused = errors.New("connection refused")
+
+type failingProvider struct {
+	storage.Provider
+	store storage.Store
+}
+
+func (p *failingProvider) OpenStore(string) (storage.Store, error) {
+	if p.store != nil {
+		return p.store, nil
+	}
+
+	return nil, errConnectionRefused
+}
+
+type failingStore struct {
+	storage.Store
+}
+
+func (s *failingStore) Get(string) ([]byte, error) {
+	return nil, errConnectionRefused
+}
@@ -0,0 +1,198 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+// Index tracks the version and hash of every replicated record. On the standby, it makes applying records
+// idempotent; on both sides, it is used to compute digests for reconciliation.
+type Index struct {
+	store storage.Store
+}
+
+type indexEntry struct {
+	Version uint64 `json:"version"`
+	Hash    string `json:"hash,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// StoreDigest is a number of records in a store and a hash over their keys and values.
+type StoreDigest struct {
+	Count int    `json:"count"`
+	Hash  string `json:"hash"`
+}
+
+// Digest is a digest of replicated stores.
+type Digest struct {
+	Stores map[string]*StoreDigest `json:"stores"`
+}
+
+// NewIndex opens the replication index in the given provider.
+func NewIndex(p storage.Provider) (*Index, error) {
+	s, err := p.OpenStore(indexStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open index store: %w", err)
+	}
+
+	err = p.SetStoreConfig(indexStoreName, storage.StoreConfiguration{TagNames: []string{storeTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set index store config: %w", err)
+	}
+
+	return &Index{store: s}, nil
+}
+
+func indexKey(store, key string) string {
+	return store + "/" + key
+}
+
+func (i *Index) get(store, key string) (*indexEntry, error) {
+	b, err := i.store.Get(indexKey(store, key))
+	if err != nil {
+		if errors.Is(err, storage.ErrDataNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("get index entry: %w", err)
+	}
+
+	var e indexEntry
+
+	if err = json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("unmarshal index entry: %w", err)
+	}
+
+	return &e, nil
+}
+
+func (i *Index) put(r *Record) error {
+	e := indexEntry{Version: r.Version}
+
+	if r.Op == OpDelete {
+		// keep a tombstone, so a delayed put of an older version is not applied after delete
+		e.Deleted = true
+	} else {
+		e.Hash = hashValue(r.Value)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal index entry: %w", err)
+	}
+
+	if err = i.store.Put(indexKey(r.Store, r.Key), b, storage.Tag{Name: storeTagName, Value: r.Store}); err != nil {
+		return fmt.Errorf("put index entry: %w", err)
+	}
+
+	return nil
+}
+
+// Digest computes digests of the given stores.
+func (i *Index) Digest(stores ...string) (*Digest, error) {
+	d := &Digest{Stores: make(map[string]*StoreDigest, len(stores))}
+
+	for _, name := range stores {
+		sd, err := i.storeDigest(name)
+		if err != nil {
+			return nil, fmt.Errorf("digest of %s: %w", name, err)
+		}
+
+		d.Stores[name] = sd
+	}
+
+	return d, nil
+}
+
+func (i *Index) storeDigest(name string) (*StoreDigest, error) {
+	it, err := i.store.Query(storeTagName + ":" + name)
+	if err != nil {
+		return nil, fmt.Errorf("query index: %w", err)
+	}
+
+	defer func() {
+		if closeErr := it.Close(); closeErr != nil {
+			logger.Warnf("close index iterator: %v", closeErr)
+		}
+	}()
+
+	lines := make([]string, 0)
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next index entry: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		k, err := it.Key()
+		if err != nil {
+			return nil, fmt.Errorf("index entry key: %w", err)
+		}
+
+		v, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("index entry value: %w", err)
+		}
+
+		var e indexEntry
+
+		if err = json.Unmarshal(v, &e); err != nil {
+			return nil, fmt.Errorf("unmarshal index entry: %w", err)
+		}
+
+		if !e.Deleted {
+			lines = append(lines, strings.TrimPrefix(k, name+"/")+"="+e.Hash)
+		}
+	}
+
+	sort.Strings(lines)
+
+	h := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+
+	return &StoreDigest{Count: len(lines), Hash: hex.EncodeToString(h[:])}, nil
+}
+
+// Handler returns a handler that serves digests of the given stores to clients with the token.
+func (i *Index) Handler(token *secrets.Secret, stores []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !authorized(req, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		d, err := i.Digest(stores...)
+		if err != nil {
+			logger.Errorf("compute digest: %v", err)
+			http.Error(w, "compute digest", http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err = json.NewEncoder(w).Encode(d); err != nil {
+			logger.Errorf("write digest: %v", err)
+		}
+	})
+}
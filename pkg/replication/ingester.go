@@ -0,0 +1,175 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const maxBatchBytes = 64 << 20
+
+// IngesterConfig configures Ingester.
+type IngesterConfig struct {
+	// Provider is a storage provider of the standby.
+	Provider storage.Provider
+	// Index records versions of applied records.
+	Index *Index
+	// Token authenticates the primary.
+	Token *secrets.Secret
+	// Stores are names of stores the primary is allowed to replicate. Defaults to DefaultStores.
+	Stores []string
+	// Clock defaults to system time.
+	Clock clock.Clock
+}
+
+// Ingester applies records received from the primary. Records are applied idempotently: a record is skipped if
+// the same or a newer version of it has already been applied, so the primary's state always wins.
+type Ingester struct {
+	config  IngesterConfig
+	stores  map[string]storage.Store
+	metrics *replicationMetrics
+	mutex   sync.Mutex
+}
+
+// NewIngester returns a new Ingester.
+func NewIngester(config IngesterConfig) *Ingester {
+	if len(config.Stores) == 0 {
+		config.Stores = DefaultStores()
+	}
+
+	if config.Clock == nil {
+		config.Clock = clock.Real()
+	}
+
+	stores := make(map[string]storage.Store, len(config.Stores))
+
+	for _, s := range config.Stores {
+		stores[s] = nil
+	}
+
+	return &Ingester{
+		config:  config,
+		stores:  stores,
+		metrics: getMetrics(),
+	}
+}
+
+// Apply applies records in order.
+func (i *Ingester) Apply(records []*Record) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, r := range records {
+		if err := i.apply(r); err != nil {
+			return fmt.Errorf("apply %s/%s: %w", r.Store, r.Key, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *Ingester) apply(r *Record) error {
+	s, err := i.openStore(r.Store)
+	if err != nil {
+		return err
+	}
+
+	e, err := i.config.Index.get(r.Store, r.Key)
+	if err != nil {
+		return err
+	}
+
+	if e != nil && e.Version >= r.Version {
+		return nil
+	}
+
+	switch r.Op {
+	case OpPut:
+		err = s.Put(r.Key, r.Value, r.Tags...)
+	case OpDelete:
+		err = s.Delete(r.Key)
+		if errors.Is(err, storage.ErrDataNotFound) {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("unknown op: %s", r.Op)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.Op, err)
+	}
+
+	if err = i.config.Index.put(r); err != nil {
+		return err
+	}
+
+	i.metrics.applied.Inc()
+	i.metrics.applyLag.Set(i.config.Clock.Now().Sub(r.Timestamp).Seconds())
+
+	return nil
+}
+
+func (i *Ingester) openStore(name string) (storage.Store, error) {
+	s, ok := i.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("store %s is not replicated", name)
+	}
+
+	if s != nil {
+		return s, nil
+	}
+
+	s, err := i.config.Provider.OpenStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	i.stores[name] = s
+
+	return s, nil
+}
+
+// ServeHTTP applies a batch of records sent by the primary.
+func (i *Ingester) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	if !authorized(req, i.config.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	var batch Batch
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxBatchBytes)).Decode(&batch); err != nil {
+		http.Error(w, "decode batch", http.StatusBadRequest)
+
+		return
+	}
+
+	if err := i.Apply(batch.Records); err != nil {
+		logger.Errorf("Failed to apply replicated records: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,316 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultQueueSize     = 10000
+	defaultFlushInterval = time.Second
+	sendTimeout          = 30 * time.Second
+)
+
+type httpClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// PublisherConfig configures Publisher.
+type PublisherConfig struct {
+	// StandbyURL is a base URL of the standby ingestion server.
+	StandbyURL string
+	// Token authenticates the publisher on the standby.
+	Token *secrets.Secret
+	// HTTPClient is used to send records. It should be configured with a client certificate for mTLS.
+	HTTPClient httpClient
+	// Index records versions and hashes of published records.
+	Index *Index
+	// Stores are names of replicated stores. Defaults to DefaultStores.
+	Stores []string
+	// BatchSize is a maximum number of records sent in one request. Defaults to 100.
+	BatchSize int
+	// QueueSize is a maximum number of records waiting to be sent; records are dropped when the queue is full.
+	// Defaults to 10000.
+	QueueSize int
+	// FlushInterval defines how often queued records are sent. Defaults to 1s.
+	FlushInterval time.Duration
+	// Clock defaults to system time.
+	Clock clock.Clock
+}
+
+// Publisher streams changes of replicated stores to the standby asynchronously. Changes are captured by the storage
+// provider returned from Wrap.
+type Publisher struct {
+	config  PublisherConfig
+	stores  map[string]struct{}
+	queue   chan *Record
+	metrics *replicationMetrics
+
+	mutex       sync.Mutex
+	lastVersion uint64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewPublisher returns a new Publisher.
+func NewPublisher(config PublisherConfig) *Publisher {
+	if len(config.Stores) == 0 {
+		config.Stores = DefaultStores()
+	}
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+
+	if config.Clock == nil {
+		config.Clock = clock.Real()
+	}
+
+	stores := make(map[string]struct{}, len(config.Stores))
+
+	for _, s := range config.Stores {
+		stores[s] = struct{}{}
+	}
+
+	return &Publisher{
+		config:  config,
+		stores:  stores,
+		queue:   make(chan *Record, config.QueueSize),
+		metrics: getMetrics(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Wrap returns a storage provider that publishes changes of replicated stores.
+func (p *Publisher) Wrap(provider storage.Provider) storage.Provider {
+	return &publishingProvider{Provider: provider, publisher: p}
+}
+
+// Start starts sending records in the background until Stop is called.
+func (p *Publisher) Start() {
+	go p.run()
+}
+
+// Stop stops sending records. Records that are not sent yet are lost; reconciliation detects the difference.
+func (p *Publisher) Stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+func (p *Publisher) publish(r *Record) {
+	p.mutex.Lock()
+
+	now := p.config.Clock.Now()
+
+	// versions are time-based, so they keep increasing across restarts of the primary
+	v := uint64(now.UnixNano())
+	if v <= p.lastVersion {
+		v = p.lastVersion + 1
+	}
+
+	p.lastVersion = v
+
+	p.mutex.Unlock()
+
+	r.Version = v
+	r.Timestamp = now.UTC()
+
+	if err := p.config.Index.put(r); err != nil {
+		logger.Warnf("Failed to index replicated record %s/%s: %v", r.Store, r.Key, err)
+	}
+
+	select {
+	case p.queue <- r:
+	default:
+		p.metrics.dropped.Inc()
+		logger.Errorf("Replication queue is full, dropped record %s/%s", r.Store, r.Key)
+	}
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(p.config.FlushInterval)
+	defer ticker.Stop()
+
+	var pending []*Record
+
+	for {
+		in := p.queue
+		if len(pending) >= p.config.BatchSize {
+			// stop reading the queue until the standby catches up
+			in = nil
+		}
+
+		select {
+		case r := <-in:
+			pending = append(pending, r)
+
+			if len(pending) < p.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+
+		pending = p.flush(pending)
+	}
+}
+
+func (p *Publisher) flush(pending []*Record) []*Record {
+	if len(pending) > 0 {
+		n := len(pending)
+		if n > p.config.BatchSize {
+			n = p.config.BatchSize
+		}
+
+		if err := p.send(pending[:n]); err != nil {
+			p.metrics.sendErrors.Inc()
+			logger.Warnf("Failed to send %d records to standby: %v", n, err)
+		} else {
+			p.metrics.sent.Add(float64(n))
+			pending = pending[n:]
+		}
+	}
+
+	p.metrics.queued.Set(float64(len(pending) + len(p.queue)))
+
+	if len(pending) > 0 {
+		p.metrics.lag.Set(p.config.Clock.Now().Sub(pending[0].Timestamp).Seconds())
+	} else {
+		p.metrics.lag.Set(0)
+	}
+
+	return pending
+}
+
+func (p *Publisher) send(records []*Record) error {
+	b, err := json.Marshal(Batch{Records: records})
+	if err != nil {
+		return fmt.Errorf("marshal batch: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(p.config.StandbyURL, "/")+RecordsPath, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	// header values are strings, the token can't be kept out of the heap once the request is built
+	p.config.Token.Use(func(token []byte) {
+		req.Header.Set("Authorization", "Bearer "+string(token))
+	})
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Warnf("close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+
+		return fmt.Errorf("standby returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+type publishingProvider struct {
+	storage.Provider
+	publisher *Publisher
+}
+
+func (p *publishingProvider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if _, ok := p.publisher.stores[name]; !ok {
+		return s, nil
+	}
+
+	return &publishingStore{Store: s, name: name, publisher: p.publisher}, nil
+}
+
+type publishingStore struct {
+	storage.Store
+	name      string
+	publisher *Publisher
+}
+
+func (s *publishingStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if err := s.Store.Put(key, value, tags...); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.publisher.publish(&Record{Store: s.name, Key: key, Op: OpPut, Value: value, Tags: tags})
+
+	return nil
+}
+
+func (s *publishingStore) Delete(key string) error {
+	if err := s.Store.Delete(key); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.publisher.publish(&Record{Store: s.name, Key: key, Op: OpDelete})
+
+	return nil
+}
+
+func (s *publishingStore) Batch(operations []storage.Operation) error {
+	if err := s.Store.Batch(operations); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	for _, op := range operations {
+		if op.Value == nil {
+			s.publisher.publish(&Record{Store: s.name, Key: op.Key, Op: OpDelete})
+		} else {
+			s.publisher.publish(&Record{Store: s.name, Key: op.Key, Op: OpPut, Value: op.Value, Tags: op.Tags})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Difference is a mismatch between digests of a store on the primary and the standby.
+type Difference struct {
+	Store   string
+	Primary *StoreDigest
+	Standby *StoreDigest
+}
+
+// String returns a human-readable description of the difference.
+func (d *Difference) String() string {
+	return fmt.Sprintf("%s: primary %s, standby %s", d.Store, describe(d.Primary), describe(d.Standby))
+}
+
+func describe(d *StoreDigest) string {
+	if d == nil {
+		return "missing"
+	}
+
+	return fmt.Sprintf("%d records (%s)", d.Count, d.Hash)
+}
+
+// FetchDigest fetches the digest of replicated stores from a KMS server.
+func FetchDigest(ctx context.Context, client httpClient, baseURL, token string) (*Digest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+DigestPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get digest: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Warnf("close response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get digest: status %d", resp.StatusCode)
+	}
+
+	var d Digest
+
+	if err = json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("decode digest: %w", err)
+	}
+
+	return &d, nil
+}
+
+// Compare returns stores with different digests, sorted by store name.
+func Compare(primary, standby *Digest) []*Difference {
+	names := make(map[string]struct{})
+
+	for n := range primary.Stores {
+		names[n] = struct{}{}
+	}
+
+	for n := range standby.Stores {
+		names[n] = struct{}{}
+	}
+
+	var diffs []*Difference
+
+	for n := range names {
+		p, s := primary.Stores[n], standby.Stores[n]
+
+		if p != nil && s != nil && *p == *s {
+			continue
+		}
+
+		diffs = append(diffs, &Difference{Store: n, Primary: p, Standby: s})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Store < diffs[j].Store })
+
+	return diffs
+}
@@ -0,0 +1,140 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+// Paths of replication endpoints.
+const (
+	RecordsPath = "/replication/records"
+	DigestPath  = "/replication/digest"
+)
+
+// Replication modes.
+const (
+	ModePrimary = "primary"
+	ModeStandby = "standby"
+)
+
+// Record operations.
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+)
+
+const (
+	indexStoreName = "replication_index"
+	storeTagName   = "store"
+
+	namespace = "kms"
+	subsystem = "replication"
+)
+
+var logger = log.New("replication")
+
+// DefaultStores are stores with key store metadata and keys.
+func DefaultStores() []string {
+	return []string{"keystores", "kmsdb"}
+}
+
+// Record is a change of a single record in a replicated store.
+type Record struct {
+	// Version orders changes of the same record; records with older versions than already applied are ignored.
+	Version   uint64        `json:"version"`
+	Store     string        `json:"store"`
+	Key       string        `json:"key"`
+	Op        string        `json:"op"`
+	Value     []byte        `json:"value,omitempty"`
+	Tags      []storage.Tag `json:"tags,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// Batch is a batch of records sent to the standby.
+type Batch struct {
+	Records []*Record `json:"records"`
+}
+
+func hashValue(v []byte) string {
+	h := sha256.Sum256(v)
+
+	return hex.EncodeToString(h[:])
+}
+
+// authorized checks the bearer token of the request in constant time.
+func authorized(req *http.Request, token *secrets.Secret) bool {
+	v := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+	return token.Equal([]byte(v))
+}
+
+//nolint:gochecknoglobals
+var (
+	metricsOnce     sync.Once
+	metricsInstance *replicationMetrics
+)
+
+type replicationMetrics struct {
+	lag        prometheus.Gauge
+	queued     prometheus.Gauge
+	sent       prometheus.Counter
+	dropped    prometheus.Counter
+	sendErrors prometheus.Counter
+	applied    prometheus.Counter
+	applyLag   prometheus.Gauge
+}
+
+func getMetrics() *replicationMetrics {
+	metricsOnce.Do(func() {
+		m := &replicationMetrics{
+			lag:        newGauge("lag_seconds", "Age of the oldest record not yet acknowledged by the standby"),
+			queued:     newGauge("queue_length", "The number of records waiting to be sent to the standby"),
+			sent:       newCounter("records_sent_count", "The total number of records sent to the standby"),
+			dropped:    newCounter("records_dropped_count", "The total number of records dropped on full queue"),
+			sendErrors: newCounter("send_errors_count", "The total number of failed attempts to send records"),
+			applied:    newCounter("records_applied_count", "The total number of records applied on the standby"),
+			applyLag: newGauge("apply_lag_seconds",
+				"Time between a change on the primary and applying it on the standby"),
+		}
+
+		prometheus.MustRegister(m.lag, m.queued, m.sent, m.dropped, m.sendErrors, m.applied, m.applyLag)
+
+		metricsInstance = m
+	})
+
+	return metricsInstance
+}
+
+func newGauge(name, help string) prometheus.Gauge {
+	return prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	})
+}
+
+func newCounter(name, help string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	})
+}
@@ -0,0 +1,209 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/replication"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const token = "replication-token"
+
+type region struct {
+	provider storage.Provider
+	index    *replication.Index
+}
+
+func newRegion(t *testing.T) *region {
+	t.Helper()
+
+	p := mem.NewProvider()
+
+	idx, err := replication.NewIndex(p)
+	require.NoError(t, err)
+
+	return &region{provider: p, index: idx}
+}
+
+func TestReplication(t *testing.T) {
+	primary, standby := newRegion(t), newRegion(t)
+
+	ingester := replication.NewIngester(replication.IngesterConfig{
+		Provider: standby.provider,
+		Index:    standby.index,
+		Token:    secrets.New([]byte(token)),
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle(replication.RecordsPath, ingester)
+	mux.Handle(replication.DigestPath, standby.index.Handler(secrets.New([]byte(token)), replication.DefaultStores()))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	publisher := replication.NewPublisher(replication.PublisherConfig{
+		StandbyURL:    srv.URL,
+		Token:         secrets.New([]byte(token)),
+		HTTPClient:    srv.Client(),
+		Index:         primary.index,
+		FlushInterval: time.Millisecond,
+	})
+
+	publisher.Start()
+	defer publisher.Stop()
+
+	provider := publisher.Wrap(primary.provider)
+
+	keyStores, err := provider.OpenStore("keystores")
+	require.NoError(t, err)
+
+	keys, err := provider.OpenStore("kmsdb")
+	require.NoError(t, err)
+
+	other, err := provider.OpenStore("other")
+	require.NoError(t, err)
+
+	require.NoError(t, keyStores.Put("ks1", []byte(`{"id":"ks1"}`), storage.Tag{Name: "controller", Value: "did"}))
+	require.NoError(t, keys.Put("k1", []byte("wrapped key 1")))
+	require.NoError(t, keys.Put("k2", []byte("wrapped key 2")))
+	require.NoError(t, keys.Delete("k2"))
+	require.NoError(t, keys.Batch([]storage.Operation{{Key: "k3", Value: []byte("wrapped key 3")}}))
+	require.NoError(t, other.Put("not-replicated", []byte("value")))
+
+	standbyKeys, err := standby.provider.OpenStore("kmsdb")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err = standbyKeys.Get("k3")
+
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	v, err := standbyKeys.Get("k1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("wrapped key 1"), v)
+
+	_, err = standbyKeys.Get("k2")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	standbyKeyStores, err := standby.provider.OpenStore("keystores")
+	require.NoError(t, err)
+
+	tags, err := standbyKeyStores.GetTags("ks1")
+	require.NoError(t, err)
+	require.Equal(t, []storage.Tag{{Name: "controller", Value: "did"}}, tags)
+
+	standbyOther, err := standby.provider.OpenStore("other")
+	require.NoError(t, err)
+
+	_, err = standbyOther.Get("not-replicated")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	t.Run("Digests match", func(t *testing.T) {
+		primaryDigest, err := primary.index.Digest(replication.DefaultStores()...)
+		require.NoError(t, err)
+		require.Equal(t, 2, primaryDigest.Stores["kmsdb"].Count)
+
+		standbyDigest, err := replication.FetchDigest(context.Background(), srv.Client(), srv.URL, token)
+		require.NoError(t, err)
+
+		require.Empty(t, replication.Compare(primaryDigest, standbyDigest))
+	})
+}
+
+func TestIngester(t *testing.T) {
+	newIngester := func(t *testing.T) (*replication.Ingester, *region) {
+		t.Helper()
+
+		r := newRegion(t)
+
+		return replication.NewIngester(replication.IngesterConfig{
+			Provider: r.provider,
+			Index:    r.index,
+			Token:    secrets.New([]byte(token)),
+			Clock:    testutil.NewFakeClock(time.Now()),
+		}), r
+	}
+
+	t.Run("Older versions are ignored", func(t *testing.T) {
+		ingester, r := newIngester(t)
+
+		require.NoError(t, ingester.Apply([]*replication.Record{
+			{Version: 2, Store: "kmsdb", Key: "k", Op: replication.OpPut, Value: []byte("new")},
+			{Version: 1, Store: "kmsdb", Key: "k", Op: replication.OpPut, Value: []byte("old")},
+			// applying the same batch again is a no-op
+			{Version: 2, Store: "kmsdb", Key: "k", Op: replication.OpPut, Value: []byte("new")},
+		}))
+
+		s, err := r.provider.OpenStore("kmsdb")
+		require.NoError(t, err)
+
+		v, err := s.Get("k")
+		require.NoError(t, err)
+		require.Equal(t, []byte("new"), v)
+
+		// delayed put is not applied after delete
+		require.NoError(t, ingester.Apply([]*replication.Record{
+			{Version: 3, Store: "kmsdb", Key: "k", Op: replication.OpDelete},
+			{Version: 2, Store: "kmsdb", Key: "k", Op: replication.OpPut, Value: []byte("new")},
+		}))
+
+		_, err = s.Get("k")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Fail with not replicated store", func(t *testing.T) {
+		ingester, _ := newIngester(t)
+
+		err := ingester.Apply([]*replication.Record{{Version: 1, Store: "zcaps", Key: "k", Op: replication.OpPut}})
+		require.EqualError(t, err, "apply zcaps/k: store zcaps is not replicated")
+	})
+
+	t.Run("Fail with invalid token", func(t *testing.T) {
+		ingester, _ := newIngester(t)
+
+		b, err := json.Marshal(replication.Batch{})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, replication.RecordsPath, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer invalid")
+
+		w := httptest.NewRecorder()
+		ingester.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestCompare(t *testing.T) {
+	primary := &replication.Digest{Stores: map[string]*replication.StoreDigest{
+		"keystores": {Count: 1, Hash: "a"},
+		"kmsdb":     {Count: 2, Hash: "b"},
+	}}
+
+	standby := &replication.Digest{Stores: map[string]*replication.StoreDigest{
+		"keystores": {Count: 1, Hash: "a"},
+		"kmsdb":     {Count: 1, Hash: "c"},
+	}}
+
+	diffs := replication.Compare(primary, standby)
+	require.Len(t, diffs, 1)
+	require.Equal(t, "kmsdb: primary 2 records (b), standby 1 records (c)", diffs[0].String())
+}
@@ -0,0 +1,140 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package reqlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/log"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// Log formats.
+const (
+	FormatText = "text" // the default format of aries loggers
+	FormatJSON = "json" // a JSON object per line, see NewJSONProvider
+)
+
+// jsonLine is a log line in JSON format.
+type jsonLine struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	Logger     string `json:"logger"`
+	Message    string `json:"msg"`
+	KeyStoreID string `json:"keystore_id,omitempty"`
+	Controller string `json:"controller,omitempty"`
+}
+
+// JSONOption configures the JSON provider.
+type JSONOption func(p *jsonProvider)
+
+// WithClock sets the clock of the time of log lines. Defaults to system time.
+func WithClock(clk clock.Clock) JSONOption {
+	return func(p *jsonProvider) {
+		p.clock = clk
+	}
+}
+
+// NewJSONProvider returns a provider of loggers that write a JSON object per line to w, with the time, level, module
+// and message of the line and fields of the request, if any. Install it with log.Initialize before anything is logged.
+func NewJSONProvider(w io.Writer, opts ...JSONOption) log.LoggerProvider {
+	p := &jsonProvider{w: w, clock: clock.Real()}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+type jsonProvider struct {
+	mutex sync.Mutex
+	w     io.Writer
+	clock clock.Clock
+}
+
+func (p *jsonProvider) GetLogger(module string) log.Logger {
+	return &jsonLogger{provider: p, module: module}
+}
+
+func (p *jsonProvider) write(line *jsonLine) {
+	b, err := json.Marshal(line)
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"level":"error","msg":"marshal log line: %s"}`, err))
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, _ = p.w.Write(append(b, '\n')) //nolint:errcheck
+}
+
+type jsonLogger struct {
+	provider *jsonProvider
+	module   string
+}
+
+func (l *jsonLogger) Panicf(msg string, args ...interface{}) {
+	l.log("panic", msg, args)
+	panic(fmt.Sprintf(msg, args...))
+}
+
+func (l *jsonLogger) Fatalf(msg string, args ...interface{}) {
+	l.log("fatal", msg, args)
+	os.Exit(1)
+}
+
+func (l *jsonLogger) Errorf(msg string, args ...interface{}) {
+	l.log("error", msg, args)
+}
+
+func (l *jsonLogger) Warnf(msg string, args ...interface{}) {
+	l.log("warning", msg, args)
+}
+
+func (l *jsonLogger) Infof(msg string, args ...interface{}) {
+	l.log("info", msg, args)
+}
+
+func (l *jsonLogger) Debugf(msg string, args ...interface{}) {
+	l.log("debug", msg, args)
+}
+
+func (l *jsonLogger) log(level, msg string, args []interface{}) {
+	line := &jsonLine{
+		Time:   l.provider.clock.Now().UTC().Format(time.RFC3339Nano),
+		Level:  level,
+		Logger: l.module,
+	}
+
+	// messages of an Entry carry fields of the request
+	if m, ok := singleMessage(msg, args); ok {
+		line.Message = m.text
+		line.KeyStoreID = m.fields.KeyStoreID
+		line.Controller = m.fields.Controller
+	} else {
+		line.Message = fmt.Sprintf(msg, args...)
+	}
+
+	l.provider.write(line)
+}
+
+func singleMessage(msg string, args []interface{}) (*message, bool) {
+	if msg != "%s" || len(args) != 1 {
+		return nil, false
+	}
+
+	m, ok := args[0].(*message)
+
+	return m, ok
+}
@@ -0,0 +1,195 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package reqlog adds identifiers of the key store a request operates on to the log lines written while serving it,
+// so that log lines can be correlated to a tenant without parsing messages. The key store ID and a hash of the
+// controller of the key store are carried as structured fields: separate members of JSON log lines, key=value pairs
+// appended to text ones.
+package reqlog
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+// Names of the fields in log lines.
+const (
+	FieldKeyStoreID = "keystore_id"
+	FieldController = "controller"
+)
+
+// controllerHashSize is the size in bytes of the controller hash, enough to tell controllers apart in logs.
+const controllerHashSize = 16
+
+// Fields are identifiers of the key store of a request.
+type Fields struct {
+	KeyStoreID string
+	// Controller is a hash of the controller of the key store, see HashController.
+	Controller string
+}
+
+// IsEmpty returns true if no field is set.
+func (f Fields) IsEmpty() bool {
+	return f.KeyStoreID == "" && f.Controller == ""
+}
+
+// HashController returns the hex-encoded, truncated SHA-256 hash of the controller, so that log lines of a controller
+// can be correlated without logging the controller, e.g. a DID that identifies a person.
+func HashController(controller string) string {
+	if controller == "" {
+		return ""
+	}
+
+	h := sha256.Sum256([]byte(controller))
+
+	return hex.EncodeToString(h[:controllerHashSize])
+}
+
+type contextKey struct{}
+
+// lazyFields resolves the controller on first use, so that requests that log nothing don't read the key store.
+type lazyFields struct {
+	once    sync.Once
+	fields  Fields
+	resolve func(keyStoreID string) (string, error)
+}
+
+// WithFields returns a context that carries the fields.
+func WithFields(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, contextKey{}, &lazyFields{fields: fields})
+}
+
+// WithKeyStore returns a context that carries the key store ID and the hash of its controller, resolved with
+// controller the first time the fields are read. A controller that can't be resolved, e.g. of a key store that
+// doesn't exist, is left out.
+func WithKeyStore(ctx context.Context, keyStoreID string,
+	controller func(keyStoreID string) (string, error)) context.Context {
+	return context.WithValue(ctx, contextKey{}, &lazyFields{
+		fields:  Fields{KeyStoreID: keyStoreID},
+		resolve: controller,
+	})
+}
+
+// FromContext returns the fields carried by the context, if any.
+func FromContext(ctx context.Context) Fields {
+	lf, ok := ctx.Value(contextKey{}).(*lazyFields)
+	if !ok {
+		return Fields{}
+	}
+
+	lf.once.Do(func() {
+		if lf.resolve == nil {
+			return
+		}
+
+		if c, err := lf.resolve(lf.fields.KeyStoreID); err == nil {
+			lf.fields.Controller = HashController(c)
+		}
+	})
+
+	return lf.fields
+}
+
+// Logger is a logger of a module that adds fields of a request to log lines.
+type Logger struct {
+	log *log.Log
+}
+
+// New returns a logger of the module. Lines are written by the logger of the module, so they follow its level.
+func New(module string) *Logger {
+	return &Logger{log: log.New(module)}
+}
+
+// With returns an entry that adds the fields to log lines.
+func (l *Logger) With(fields Fields) *Entry {
+	return &Entry{log: l.log, fields: fields}
+}
+
+// Ctx returns an entry that adds the fields carried by the context to log lines.
+func (l *Logger) Ctx(ctx context.Context) *Entry {
+	return l.With(FromContext(ctx))
+}
+
+// Debugf logs a message without fields.
+func (l *Logger) Debugf(msg string, args ...interface{}) {
+	l.log.Debugf(msg, args...)
+}
+
+// Infof logs a message without fields.
+func (l *Logger) Infof(msg string, args ...interface{}) {
+	l.log.Infof(msg, args...)
+}
+
+// Warnf logs a message without fields.
+func (l *Logger) Warnf(msg string, args ...interface{}) {
+	l.log.Warnf(msg, args...)
+}
+
+// Errorf logs a message without fields.
+func (l *Logger) Errorf(msg string, args ...interface{}) {
+	l.log.Errorf(msg, args...)
+}
+
+// Entry logs messages with fields.
+type Entry struct {
+	log    *log.Log
+	fields Fields
+}
+
+// Debugf logs a message with the fields.
+func (e *Entry) Debugf(msg string, args ...interface{}) {
+	e.log.Debugf("%s", e.message(msg, args))
+}
+
+// Infof logs a message with the fields.
+func (e *Entry) Infof(msg string, args ...interface{}) {
+	e.log.Infof("%s", e.message(msg, args))
+}
+
+// Warnf logs a message with the fields.
+func (e *Entry) Warnf(msg string, args ...interface{}) {
+	e.log.Warnf("%s", e.message(msg, args))
+}
+
+// Errorf logs a message with the fields.
+func (e *Entry) Errorf(msg string, args ...interface{}) {
+	e.log.Errorf("%s", e.message(msg, args))
+}
+
+// message returns the message passed to the logger of the module as a single argument, so that the JSON logger
+// writes the fields as members while other loggers print them as text.
+func (e *Entry) message(msg string, args []interface{}) *message {
+	return &message{text: fmt.Sprintf(msg, args...), fields: e.fields}
+}
+
+type message struct {
+	text   string
+	fields Fields
+}
+
+// String returns the message followed by non-empty fields as key=value pairs.
+func (m *message) String() string {
+	var b strings.Builder
+
+	b.WriteString(m.text)
+
+	for _, f := range [][2]string{
+		{FieldKeyStoreID, m.fields.KeyStoreID},
+		{FieldController, m.fields.Controller},
+	} {
+		if f[1] != "" {
+			fmt.Fprintf(&b, " %s=%s", f[0], f[1])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package reqlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	logspi "github.com/hyperledger/aries-framework-go/spi/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+)
+
+var output bytes.Buffer
+
+func TestMain(m *testing.M) {
+	log.Initialize(NewJSONProvider(&output))
+
+	os.Exit(m.Run())
+}
+
+func TestHashController(t *testing.T) {
+	h := HashController("did:example:controller")
+	require.Len(t, h, 2*controllerHashSize)
+	require.Equal(t, h, HashController("did:example:controller"))
+	require.NotEqual(t, h, HashController("did:example:other"))
+	require.Empty(t, HashController(""))
+}
+
+func TestFromContext(t *testing.T) {
+	t.Run("Fields", func(t *testing.T) {
+		fields := Fields{KeyStoreID: "ks", Controller: "hash"}
+
+		require.Equal(t, fields, FromContext(WithFields(context.Background(), fields)))
+	})
+
+	t.Run("Controller is resolved once, on first use", func(t *testing.T) {
+		calls := 0
+
+		ctx := WithKeyStore(context.Background(), "ks", func(keyStoreID string) (string, error) {
+			require.Equal(t, "ks", keyStoreID)
+
+			calls++
+
+			return "did:example:controller", nil
+		})
+		require.Zero(t, calls)
+
+		expected := Fields{KeyStoreID: "ks", Controller: HashController("did:example:controller")}
+
+		require.Equal(t, expected, FromContext(ctx))
+		require.Equal(t, expected, FromContext(ctx))
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("Controller that can't be resolved is left out", func(t *testing.T) {
+		ctx := WithKeyStore(context.Background(), "ks", func(string) (string, error) {
+			return "", errors.New("not found")
+		})
+
+		require.Equal(t, Fields{KeyStoreID: "ks"}, FromContext(ctx))
+	})
+
+	t.Run("No fields", func(t *testing.T) {
+		require.True(t, FromContext(context.Background()).IsEmpty())
+	})
+}
+
+func TestLogger(t *testing.T) {
+	log.SetLevel("reqlog-test", logspi.DEBUG)
+
+	logger := New("reqlog-test")
+
+	t.Run("Fields are members of JSON lines", func(t *testing.T) {
+		ctx := WithFields(context.Background(), Fields{KeyStoreID: "ks", Controller: "hash"})
+
+		for level, logf := range map[string]func(string, ...interface{}){
+			"debug":   logger.Ctx(ctx).Debugf,
+			"info":    logger.Ctx(ctx).Infof,
+			"warning": logger.Ctx(ctx).Warnf,
+			"error":   logger.Ctx(ctx).Errorf,
+		} {
+			line := logLine(t, func() { logf("message %d", 1) })
+			require.Equal(t, level, line.Level)
+			require.Equal(t, "reqlog-test", line.Logger)
+			require.Equal(t, "message 1", line.Message)
+			require.Equal(t, "ks", line.KeyStoreID)
+			require.Equal(t, "hash", line.Controller)
+			require.NotEmpty(t, line.Time)
+		}
+	})
+
+	t.Run("Lines without fields", func(t *testing.T) {
+		for level, logf := range map[string]func(string, ...interface{}){
+			"debug":   logger.Debugf,
+			"info":    logger.Infof,
+			"warning": logger.Warnf,
+			"error":   logger.Errorf,
+		} {
+			line := logLine(t, func() { logf("message %s", "%s") })
+			require.Equal(t, level, line.Level)
+			require.Equal(t, "message %s", line.Message)
+			require.Empty(t, line.KeyStoreID)
+			require.Empty(t, line.Controller)
+		}
+	})
+
+	t.Run("Levels of the module apply", func(t *testing.T) {
+		log.SetLevel("reqlog-test", logspi.INFO)
+		defer log.SetLevel("reqlog-test", logspi.DEBUG)
+
+		output.Reset()
+		logger.With(Fields{KeyStoreID: "ks"}).Debugf("message")
+		require.Zero(t, output.Len())
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		output.Reset()
+
+		require.PanicsWithValue(t, "message 1", func() {
+			NewJSONProvider(&output).GetLogger("reqlog-test").Panicf("message %d", 1)
+		})
+		require.Contains(t, output.String(), `"level":"panic"`)
+	})
+
+	t.Run("Time of lines is from the clock", func(t *testing.T) {
+		var out bytes.Buffer
+
+		clk := testutil.NewFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+
+		NewJSONProvider(&out, WithClock(clk)).GetLogger("reqlog-test").Infof("message")
+
+		require.Contains(t, out.String(), `"time":"2021-06-01T12:00:00Z"`)
+	})
+}
+
+func TestMessage(t *testing.T) {
+	entry := New("reqlog-test").With(Fields{KeyStoreID: "ks", Controller: "hash"})
+
+	// text loggers print fields as key=value pairs
+	require.Equal(t, "message 1 keystore_id=ks controller=hash", entry.message("message %d", []interface{}{1}).String())
+
+	entry = New("reqlog-test").With(Fields{KeyStoreID: "ks"})
+	require.Equal(t, "message keystore_id=ks", entry.message("message", nil).String())
+}
+
+func logLine(t *testing.T, logf func()) *jsonLine {
+	t.Helper()
+
+	output.Reset()
+	logf()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var line jsonLine
+
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+
+	return &line
+}
@@ -0,0 +1,289 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package respsign_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	mockstorage "github.com/hyperledger/aries-framework-go/pkg/mock/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/respsign"
+)
+
+const payload = `{"public_key":"AQID"}`
+
+func TestSigner(t *testing.T) {
+	t.Run("Sign and verify detached JWS", func(t *testing.T) {
+		s := newSigner(t)
+
+		jws, err := s.Sign([]byte(payload))
+		require.NoError(t, err)
+		require.Contains(t, jws, "..")
+		require.True(t, strings.HasPrefix(s.KeyID(), "did:key:"))
+
+		require.NoError(t, respsign.Verify([]byte(payload), jws, s.KeySet(), time.Now()))
+		require.EqualError(t, respsign.Verify([]byte("tampered"), jws, s.KeySet(), time.Now()), "invalid signature")
+	})
+
+	t.Run("Sign and verify envelope", func(t *testing.T) {
+		s := newSigner(t)
+
+		e, err := s.SignEnvelope([]byte(payload))
+		require.NoError(t, err)
+
+		b, err := json.Marshal(e)
+		require.NoError(t, err)
+
+		verified, err := respsign.VerifyEnvelope(b, s.KeySet(), time.Now())
+		require.NoError(t, err)
+		require.Equal(t, payload, string(verified))
+	})
+
+	t.Run("Fail with not P-256 key", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+
+		_, err = respsign.NewSigner(key)
+		require.EqualError(t, err, "current key: P-256 key is required")
+	})
+
+	t.Run("Fail with random source error", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		s, err := respsign.NewSigner(key, respsign.WithRandom(&failingReader{}))
+		require.NoError(t, err)
+
+		_, err = s.Sign([]byte(payload))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "sign:")
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldSigner := newSigner(t)
+
+	oldJWS, err := oldSigner.Sign([]byte(payload))
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	validUntil := time.Now().Add(time.Hour)
+
+	oldPub, ok := oldSigner.KeySet().Keys[0].JWK.Key.(*ecdsa.PublicKey)
+	require.True(t, ok)
+
+	newSigner, err := respsign.NewSigner(key,
+		respsign.WithRetiredKeys(&respsign.RetiredKey{PublicKey: oldPub, ValidUntil: validUntil}))
+	require.NoError(t, err)
+	require.Len(t, newSigner.KeySet().Keys, 2)
+
+	newJWS, err := newSigner.Sign([]byte(payload))
+	require.NoError(t, err)
+
+	// both keys are accepted during the overlap
+	require.NoError(t, respsign.Verify([]byte(payload), oldJWS, newSigner.KeySet(), time.Now()))
+	require.NoError(t, respsign.Verify([]byte(payload), newJWS, newSigner.KeySet(), time.Now()))
+
+	// retired key is rejected after the overlap
+	err = respsign.Verify([]byte(payload), oldJWS, newSigner.KeySet(), validUntil.Add(time.Second))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "has expired")
+	require.NoError(t, respsign.Verify([]byte(payload), newJWS, newSigner.KeySet(), validUntil.Add(time.Second)))
+}
+
+func TestKeySet(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := testutil.NewFakeClock(now)
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	s, err := respsign.NewSigner(key, respsign.WithClock(clk),
+		respsign.WithRetiredKeys(&respsign.RetiredKey{PublicKey: &oldKey.PublicKey, ValidUntil: now.Add(time.Hour)}))
+	require.NoError(t, err)
+	require.Len(t, s.KeySet().Keys, 2)
+
+	// expired retired keys are not published
+	clk.Advance(time.Hour)
+
+	require.Len(t, s.KeySet().Keys, 1)
+	require.Equal(t, s.KeyID(), s.KeySet().Keys[0].KID)
+
+	rec := httptest.NewRecorder()
+	s.KeySetHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, respsign.WellKnownPath, nil))
+
+	var keySet respsign.KeySet
+
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &keySet))
+	require.Len(t, keySet.Keys, 1)
+}
+
+func TestRetireKeys(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("Overlap is not extended by restarts", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore(respsign.RetiredKeysStoreName)
+		require.NoError(t, err)
+
+		clk := testutil.NewFakeClock(now)
+
+		retired, err := respsign.RetireKeys(store, clk, time.Hour, &key.PublicKey)
+		require.NoError(t, err)
+		require.Len(t, retired, 1)
+		require.Equal(t, now.Add(time.Hour), retired[0].ValidUntil)
+
+		clk.Advance(30 * time.Minute)
+
+		retired, err = respsign.RetireKeys(store, clk, time.Hour, &key.PublicKey)
+		require.NoError(t, err)
+		require.True(t, now.Add(time.Hour).Equal(retired[0].ValidUntil))
+	})
+
+	t.Run("Fail with invalid key", func(t *testing.T) {
+		store, err := mem.NewProvider().OpenStore(respsign.RetiredKeysStoreName)
+		require.NoError(t, err)
+
+		p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+
+		_, err = respsign.RetireKeys(store, testutil.NewFakeClock(now), time.Hour, &p384Key.PublicKey)
+		require.EqualError(t, err, "retired key: P-256 key is required")
+	})
+
+	t.Run("Fail to get retirement", func(t *testing.T) {
+		store := &mockstorage.MockStore{Store: map[string]mockstorage.DBEntry{}, ErrGet: errors.New("get error")}
+
+		_, err := respsign.RetireKeys(store, testutil.NewFakeClock(now), time.Hour, &key.PublicKey)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "get retirement: get error")
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	s := newSigner(t)
+
+	handler := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(payload)) //nolint:errcheck
+	}))
+
+	t.Run("Signature in header", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, payload, w.Body.String())
+		require.NoError(t, respsign.Verify(w.Body.Bytes(), w.Header().Get(respsign.SignatureHeader),
+			fetchKeySet(t, s), time.Now()))
+	})
+
+	t.Run("Signed JSON envelope", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("Accept", respsign.JOSEJSONMediaType)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, respsign.JOSEJSONMediaType, w.Header().Get("Content-Type"))
+		require.Empty(t, w.Header().Get(respsign.SignatureHeader))
+
+		b, err := respsign.VerifyEnvelope(w.Body.Bytes(), fetchKeySet(t, s), time.Now())
+		require.NoError(t, err)
+		require.Equal(t, payload, string(b))
+	})
+
+	t.Run("Error responses are not signed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test?fail=true", nil))
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Empty(t, w.Header().Get(respsign.SignatureHeader))
+	})
+}
+
+func TestVerify(t *testing.T) {
+	s := newSigner(t)
+
+	jws, err := s.Sign([]byte(payload))
+	require.NoError(t, err)
+
+	t.Run("Fail with invalid JWS", func(t *testing.T) {
+		require.EqualError(t, respsign.Verify([]byte(payload), "invalid", s.KeySet(), time.Now()),
+			"invalid detached jws")
+	})
+
+	t.Run("Fail with unknown key", func(t *testing.T) {
+		err := respsign.Verify([]byte(payload), jws, newSigner(t).KeySet(), time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unknown key")
+	})
+
+	t.Run("Fail with invalid envelope", func(t *testing.T) {
+		_, err := respsign.VerifyEnvelope([]byte("invalid"), s.KeySet(), time.Now())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "unmarshal envelope")
+	})
+}
+
+func newSigner(t *testing.T) *respsign.Signer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	s, err := respsign.NewSigner(key)
+	require.NoError(t, err)
+
+	return s
+}
+
+type failingReader struct{}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+// fetchKeySet gets keys the way a verifier does, through the well-known endpoint.
+func fetchKeySet(t *testing.T, s *respsign.Signer) *respsign.KeySet {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	s.KeySetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, respsign.WellKnownPath, nil))
+
+	var keys respsign.KeySet
+
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&keys))
+
+	return &keys
+}
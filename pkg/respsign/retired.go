@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package respsign
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// RetiredKeysStoreName is the name of the store with the times response signing keys were retired.
+const RetiredKeysStoreName = "response_signing_keys"
+
+type retirement struct {
+	ValidUntil time.Time `json:"valid_until"`
+}
+
+// RetireKeys returns the retired keys with the end of their overlap. The end is saved in the store when a key is first
+// retired, so restarts of the server don't extend it. It's saved with storage.PutOptions.IsNewKey, so with storage
+// that rejects existing keys (e.g. MongoDB) instances started at the same time agree on it.
+func RetireKeys(store storage.Store, clk clock.Clock, overlap time.Duration,
+	keys ...*ecdsa.PublicKey) ([]*RetiredKey, error) {
+	retired := make([]*RetiredKey, 0, len(keys))
+
+	for _, pub := range keys {
+		k, err := publishedKey(pub, nil)
+		if err != nil {
+			return nil, fmt.Errorf("retired key: %w", err)
+		}
+
+		validUntil, err := retire(store, k.KID, clk.Now().Add(overlap))
+		if err != nil {
+			return nil, fmt.Errorf("retire key %s: %w", k.KID, err)
+		}
+
+		retired = append(retired, &RetiredKey{PublicKey: pub, ValidUntil: validUntil})
+	}
+
+	return retired, nil
+}
+
+// retire returns the end of the overlap of the key saved in the store, saving validUntil if the key wasn't retired.
+func retire(store storage.Store, kid string, validUntil time.Time) (time.Time, error) {
+	r, err := getRetirement(store, kid)
+	if err == nil {
+		return r.ValidUntil, nil
+	}
+
+	if !errors.Is(err, storage.ErrDataNotFound) {
+		return time.Time{}, err
+	}
+
+	b, err := json.Marshal(retirement{ValidUntil: validUntil})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("marshal retirement: %w", err)
+	}
+
+	err = store.Batch([]storage.Operation{{
+		Key:        kid,
+		Value:      b,
+		PutOptions: &storage.PutOptions{IsNewKey: true},
+	}})
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		// retired by another instance in the meantime
+		r, err = getRetirement(store, kid)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return r.ValidUntil, nil
+	}
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("save retirement: %w", err)
+	}
+
+	return validUntil, nil
+}
+
+func getRetirement(store storage.Store, kid string) (*retirement, error) {
+	b, err := store.Get(kid)
+	if err != nil {
+		return nil, fmt.Errorf("get retirement: %w", err)
+	}
+
+	var r retirement
+
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal retirement: %w", err)
+	}
+
+	return &r, nil
+}
@@ -0,0 +1,313 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package respsign
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/didkey"
+)
+
+const (
+	// SignatureHeader is a response header with a detached JWS of the response body.
+	SignatureHeader = "Response-Signature"
+	// JOSEJSONMediaType is a media type to request a response wrapped in a flattened JWS JSON envelope.
+	JOSEJSONMediaType = "application/jose+json"
+	// WellKnownPath is a path where response signing keys are published.
+	WellKnownPath = "/.well-known/kms-response-signing-keys"
+
+	algES256     = "ES256"
+	coordinateSz = 32
+)
+
+var logger = log.New("respsign")
+
+// RetiredKey is a previous response signing key that is still published until ValidUntil, so responses signed
+// before rotation can be verified.
+type RetiredKey struct {
+	PublicKey  *ecdsa.PublicKey
+	ValidUntil time.Time
+}
+
+// PublishedKey is a response signing key published at the well-known endpoint.
+type PublishedKey struct {
+	KID        string     `json:"kid"`
+	JWK        *jwk.JWK   `json:"jwk"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+}
+
+// KeySet is a set of response signing keys.
+type KeySet struct {
+	Keys []*PublishedKey `json:"keys"`
+}
+
+// Envelope is a flattened JWS JSON serialization of a signed response.
+type Envelope struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Signature string `json:"signature"`
+}
+
+type protectedHeader struct {
+	Alg string `json:"alg"`
+	KID string `json:"kid"`
+}
+
+// Option configures Signer.
+type Option func(o *options)
+
+type options struct {
+	retired []*RetiredKey
+	rand    io.Reader
+	clock   clock.Clock
+}
+
+// WithRetiredKeys sets previous signing keys that are published alongside the current key.
+func WithRetiredKeys(keys ...*RetiredKey) Option {
+	return func(o *options) {
+		o.retired = append(o.retired, keys...)
+	}
+}
+
+// WithRandom sets the source of randomness for signing. Defaults to crypto/rand.Reader.
+func WithRandom(r io.Reader) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// WithClock sets the clock used to leave expired retired keys out of the key set. Defaults to the system clock.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Signer signs response payloads with the server identity key. The key is identified by its did:key.
+type Signer struct {
+	key    *ecdsa.PrivateKey
+	kid    string
+	keySet *KeySet
+	rand   io.Reader
+	clock  clock.Clock
+}
+
+// NewSigner returns a new Signer for the given P-256 key.
+func NewSigner(key *ecdsa.PrivateKey, opts ...Option) (*Signer, error) {
+	o := &options{rand: rand.Reader, clock: clock.Real()}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	current, err := publishedKey(&key.PublicKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("current key: %w", err)
+	}
+
+	keySet := &KeySet{Keys: []*PublishedKey{current}}
+
+	for _, r := range o.retired {
+		validUntil := r.ValidUntil
+
+		k, err := publishedKey(r.PublicKey, &validUntil)
+		if err != nil {
+			return nil, fmt.Errorf("retired key: %w", err)
+		}
+
+		keySet.Keys = append(keySet.Keys, k)
+	}
+
+	return &Signer{
+		key:    key,
+		kid:    current.KID,
+		keySet: keySet,
+		rand:   o.rand,
+		clock:  o.clock,
+	}, nil
+}
+
+func publishedKey(pub *ecdsa.PublicKey, validUntil *time.Time) (*PublishedKey, error) {
+	if pub == nil || pub.Curve != elliptic.P256() {
+		return nil, errors.New("P-256 key is required")
+	}
+
+	j := &jwk.JWK{Kty: "EC", Crv: "P-256"}
+	j.Key = pub
+	j.Algorithm = algES256
+
+	didKey, err := didkey.FromECDSAKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("create did:key: %w", err)
+	}
+
+	kid := didKey.VerificationMethod
+	j.KeyID = kid
+
+	return &PublishedKey{KID: kid, JWK: j, ValidUntil: validUntil}, nil
+}
+
+// KeyID returns did:key identifier of the current signing key.
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+// KeySet returns keys published at the well-known endpoint: the current key and retired keys that haven't expired.
+func (s *Signer) KeySet() *KeySet {
+	now := s.clock.Now()
+
+	keySet := &KeySet{Keys: make([]*PublishedKey, 0, len(s.keySet.Keys))}
+
+	for _, k := range s.keySet.Keys {
+		if k.ValidUntil == nil || now.Before(*k.ValidUntil) {
+			keySet.Keys = append(keySet.Keys, k)
+		}
+	}
+
+	return keySet
+}
+
+// Sign returns a detached compact JWS (RFC 7515, Appendix F) of the payload.
+func (s *Signer) Sign(payload []byte) (string, error) {
+	e, err := s.sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return e.Protected + ".." + e.Signature, nil
+}
+
+// SignEnvelope returns the payload in a flattened JWS JSON envelope, e.g. to sign documents the server delivers to
+// other parties. Envelopes are verified with VerifyEnvelope.
+func (s *Signer) SignEnvelope(payload []byte) (*Envelope, error) {
+	return s.sign(payload)
+}
+
+func (s *Signer) sign(payload []byte) (*Envelope, error) {
+	header, err := json.Marshal(protectedHeader{Alg: algES256, KID: s.kid})
+	if err != nil {
+		return nil, fmt.Errorf("marshal header: %w", err)
+	}
+
+	e := &Envelope{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Protected: base64.RawURLEncoding.EncodeToString(header),
+	}
+
+	digest := sha256.Sum256([]byte(e.Protected + "." + e.Payload))
+
+	r, sig, err := ecdsa.Sign(s.rand, s.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	raw := make([]byte, 2*coordinateSz)
+	r.FillBytes(raw[:coordinateSz])
+	sig.FillBytes(raw[coordinateSz:])
+
+	e.Signature = base64.RawURLEncoding.EncodeToString(raw)
+
+	return e, nil
+}
+
+// Middleware signs successful responses of the next handler. The signature is delivered in the Response-Signature
+// header, or, if the client accepts application/jose+json, the response is wrapped in a JWS JSON envelope.
+func (s *Signer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedWriter{header: http.Header{}, statusCode: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+
+		body := rec.body.Bytes()
+
+		if rec.statusCode < http.StatusOK || rec.statusCode >= http.StatusMultipleChoices {
+			w.WriteHeader(rec.statusCode)
+			write(w, body)
+
+			return
+		}
+
+		e, err := s.sign(body)
+		if err != nil {
+			logger.Errorf("sign response: %v", err)
+
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		if strings.Contains(r.Header.Get("Accept"), JOSEJSONMediaType) {
+			w.Header().Set("Content-Type", JOSEJSONMediaType)
+			w.WriteHeader(rec.statusCode)
+
+			if err = json.NewEncoder(w).Encode(e); err != nil {
+				logger.Errorf("write signed response: %v", err)
+			}
+
+			return
+		}
+
+		w.Header().Set(SignatureHeader, e.Protected+".."+e.Signature)
+		w.WriteHeader(rec.statusCode)
+		write(w, body)
+	})
+}
+
+// KeySetHandler returns a handler that serves response signing keys.
+func (s *Signer) KeySetHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(s.KeySet()); err != nil {
+			logger.Errorf("write key set: %v", err)
+		}
+	})
+}
+
+func write(w http.ResponseWriter, b []byte) {
+	if _, err := w.Write(b); err != nil {
+		logger.Errorf("write response: %v", err)
+	}
+}
+
+type bufferedWriter struct {
+	header     http.Header
+	body       bytes.Buffer
+	statusCode int
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
@@ -0,0 +1,125 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package respsign
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Verify verifies a detached compact JWS of the response payload against published keys. Retired keys are accepted
+// only until their validity ends.
+func Verify(payload []byte, detachedJWS string, keys *KeySet, now time.Time) error {
+	parts := strings.Split(detachedJWS, ".")
+	if len(parts) != 3 || parts[1] != "" { //nolint:gomnd
+		return errors.New("invalid detached jws")
+	}
+
+	return verify(&Envelope{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Protected: parts[0],
+		Signature: parts[2],
+	}, keys, now)
+}
+
+// VerifyEnvelope verifies a JWS JSON envelope against published keys and returns the original response payload.
+func VerifyEnvelope(envelope []byte, keys *KeySet, now time.Time) ([]byte, error) {
+	var e Envelope
+
+	if err := json.Unmarshal(envelope, &e); err != nil {
+		return nil, fmt.Errorf("unmarshal envelope: %w", err)
+	}
+
+	if err := verify(&e, keys, now); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("decode payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+func verify(e *Envelope, keys *KeySet, now time.Time) error {
+	headerBytes, err := base64.RawURLEncoding.DecodeString(e.Protected)
+	if err != nil {
+		return fmt.Errorf("decode header: %w", err)
+	}
+
+	var header protectedHeader
+
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return fmt.Errorf("unmarshal header: %w", err)
+	}
+
+	if header.Alg != algES256 {
+		return fmt.Errorf("not supported alg: %s", header.Alg)
+	}
+
+	pub, err := findKey(keys, header.KID, now)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(e.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+
+	if len(sig) != 2*coordinateSz {
+		return errors.New("invalid signature length")
+	}
+
+	digest := sha256.Sum256([]byte(e.Protected + "." + e.Payload))
+
+	r := new(big.Int).SetBytes(sig[:coordinateSz])
+	s := new(big.Int).SetBytes(sig[coordinateSz:])
+
+	if !ecdsa.Verify(pub, digest[:], r, s) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+func findKey(keys *KeySet, kid string, now time.Time) (*ecdsa.PublicKey, error) {
+	if keys == nil {
+		return nil, errors.New("no keys")
+	}
+
+	for _, k := range keys.Keys {
+		if k.KID != kid {
+			continue
+		}
+
+		if k.ValidUntil != nil && now.After(*k.ValidUntil) {
+			return nil, fmt.Errorf("key %s has expired", kid)
+		}
+
+		if k.JWK == nil {
+			return nil, fmt.Errorf("key %s has no jwk", kid)
+		}
+
+		pub, ok := k.JWK.Key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an ecdsa public key", kid)
+		}
+
+		return pub, nil
+	}
+
+	return nil, fmt.Errorf("unknown key: %s", kid)
+}
@@ -19,10 +19,13 @@ import (
 	"github.com/hyperledger/aries-framework-go/pkg/secretlock"
 
 	"github.com/trustbloc/kms/pkg/metrics"
+	"github.com/trustbloc/kms/pkg/reqlog"
 )
 
 const nonceLenBytes = 4
 
+var logger = reqlog.New("secretlock/key")
+
 type provider interface {
 	KMS() kms.KeyManager
 	Crypto() crypto.Crypto
@@ -30,8 +33,9 @@ type provider interface {
 
 // Lock is a secret lock based on private key.
 type Lock struct {
-	kms    kms.KeyManager
-	crypto crypto.Crypto
+	kms       kms.KeyManager
+	crypto    crypto.Crypto
+	logFields reqlog.Fields
 }
 
 // NewLock returns a new instance of key Lock.
@@ -42,8 +46,33 @@ func NewLock(p provider) *Lock {
 	}
 }
 
+// WithLogFields returns a copy of the lock that logs failures with the fields, e.g. of the request the lock was
+// created for.
+func (l *Lock) WithLogFields(fields reqlog.Fields) *Lock {
+	return &Lock{kms: l.kms, crypto: l.crypto, logFields: fields}
+}
+
 // Encrypt encrypts request with key identified by keyURI.
 func (l *Lock) Encrypt(keyURI string, req *secretlock.EncryptRequest) (*secretlock.EncryptResponse, error) {
+	resp, err := l.encrypt(keyURI, req)
+	if err != nil {
+		logger.With(l.logFields).Debugf("encrypt with key %s failed: %v", keyURI, err)
+	}
+
+	return resp, err
+}
+
+// Decrypt decrypts request with key identified by keyURI.
+func (l *Lock) Decrypt(keyURI string, req *secretlock.DecryptRequest) (*secretlock.DecryptResponse, error) {
+	resp, err := l.decrypt(keyURI, req)
+	if err != nil {
+		logger.With(l.logFields).Debugf("decrypt with key %s failed: %v", keyURI, err)
+	}
+
+	return resp, err
+}
+
+func (l *Lock) encrypt(keyURI string, req *secretlock.EncryptRequest) (*secretlock.EncryptResponse, error) {
 	kh, err := l.kms.Get(keyURI)
 	if err != nil {
 		return nil, fmt.Errorf("get key handle: %w", err)
@@ -68,8 +97,7 @@ func (l *Lock) Encrypt(keyURI string, req *secretlock.EncryptRequest) (*secretlo
 	}, nil
 }
 
-// Decrypt decrypts request with key identified by keyURI.
-func (l *Lock) Decrypt(keyURI string, req *secretlock.DecryptRequest) (*secretlock.DecryptResponse, error) {
+func (l *Lock) decrypt(keyURI string, req *secretlock.DecryptRequest) (*secretlock.DecryptResponse, error) {
 	kh, err := l.kms.Get(keyURI)
 	if err != nil {
 		return nil, fmt.Errorf("get key handle: %w", err)
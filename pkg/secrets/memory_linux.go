@@ -0,0 +1,40 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocate returns memory outside of the Go heap that is locked in RAM (never swapped) and excluded from core dumps.
+func allocate(size int) ([]byte, error) {
+	b, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("mmap: %w", err)
+	}
+
+	if err = unix.Mlock(b); err != nil {
+		_ = unix.Munmap(b) //nolint:errcheck
+
+		return nil, fmt.Errorf("mlock: %w", err)
+	}
+
+	if err = unix.Madvise(b, unix.MADV_DONTDUMP); err != nil {
+		_ = unix.Munmap(b) //nolint:errcheck
+
+		return nil, fmt.Errorf("madvise: %w", err)
+	}
+
+	return b, nil
+}
+
+// release unmaps memory returned by allocate. Memory allocated on the heap (if allocate failed) is left to GC.
+func release(b []byte) {
+	_ = unix.Munmap(b) //nolint:errcheck // fails for heap memory
+}
@@ -0,0 +1,18 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+//go:build !linux
+
+package secrets
+
+import "errors"
+
+// allocate is not supported on this platform, secrets are kept in regular memory.
+func allocate(int) ([]byte, error) {
+	return nil, errors.New("locked memory is not supported on this platform")
+}
+
+func release([]byte) {}
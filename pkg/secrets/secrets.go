@@ -0,0 +1,175 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets
+
+import (
+	"crypto/subtle"
+	"os"
+	"sync"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+const redacted = "[REDACTED]"
+
+var logger = log.New("secrets")
+
+// Secret is a sensitive value (e.g. an access token) kept in memory that is locked and excluded from core dumps
+// where the platform supports it. The value is only available inside Use, a Secret is never printed. A nil Secret
+// is empty.
+type Secret struct {
+	mutex sync.RWMutex
+	data  []byte
+}
+
+// New returns a Secret with a copy of value. The value is zeroed, so the caller doesn't keep the plaintext.
+func New(value []byte) *Secret {
+	s := &Secret{}
+
+	if len(value) == 0 {
+		return s
+	}
+
+	data, err := allocate(len(value))
+	if err != nil {
+		logger.Warnf("Failed to allocate locked memory, the secret is kept in regular memory: %v", err)
+
+		data = make([]byte, len(value))
+	}
+
+	copy(data, value)
+	wipe(value)
+
+	s.data = data
+
+	return s
+}
+
+// Use calls fn with the secret value, which is empty for an empty secret. The value is the locked memory of the
+// secret: fn must not modify or retain it, and should not convert it to a string, as strings can't be zeroed.
+func (s *Secret) Use(fn func(value []byte)) {
+	if s == nil {
+		fn(nil)
+
+		return
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	fn(s.data)
+}
+
+// IsEmpty checks if the secret has no value.
+func (s *Secret) IsEmpty() bool {
+	if s == nil {
+		return true
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.data) == 0
+}
+
+// Equal compares the secret with the value in constant time. An empty secret is not equal to any value.
+func (s *Secret) Equal(value []byte) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.data) != 0 && subtle.ConstantTimeCompare(s.data, value) == 1
+}
+
+// String returns a placeholder, so the secret isn't leaked by formatting.
+func (s *Secret) String() string {
+	return redacted
+}
+
+// GoString returns a placeholder, so the secret isn't leaked by %#v formatting.
+func (s *Secret) GoString() string {
+	return redacted
+}
+
+// Destroy zeroes the secret and releases its memory. The secret is empty afterwards.
+func (s *Secret) Destroy() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data == nil {
+		return
+	}
+
+	wipe(s.data)
+	release(s.data)
+
+	s.data = nil
+}
+
+// Manager moves sensitive values out of the process environment into secrets. Components get the values from the
+// secrets instead of environment variables or configuration strings.
+type Manager struct {
+	mutex   sync.Mutex
+	secrets map[string]*Secret
+}
+
+// NewManager returns a new Manager.
+func NewManager() *Manager {
+	return &Manager{secrets: map[string]*Secret{}}
+}
+
+// FromEnv moves the value of the environment variable into a secret: the variable is removed from the environment,
+// so it isn't inherited by child processes or read again. The original value can't be scrubbed safely from Go: it
+// stays in the environment block the process was started with, visible in /proc/<pid>/environ to the same user and
+// root, and in core dumps. Subsequent calls with the same name return the same secret.
+func (m *Manager) FromEnv(name string) *Secret {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if s, ok := m.secrets[name]; ok {
+		return s
+	}
+
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	s := New([]byte(value))
+
+	if err := os.Unsetenv(name); err != nil {
+		logger.Warnf("Failed to unset %s: %v", name, err)
+	}
+
+	m.secrets[name] = s
+
+	return s
+}
+
+// Close destroys all secrets of the manager.
+func (m *Manager) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, s := range m.secrets {
+		s.Destroy()
+		delete(m.secrets, name)
+	}
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
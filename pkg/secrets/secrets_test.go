@@ -0,0 +1,111 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secrets_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const envKey = "KMS_TEST_SECRET"
+
+func TestSecret(t *testing.T) {
+	t.Run("Value is only available inside Use", func(t *testing.T) {
+		value := []byte("secret value")
+
+		s := secrets.New(value)
+		require.Equal(t, make([]byte, len(value)), value)
+
+		require.Equal(t, "secret value", secretValue(s))
+		require.False(t, s.IsEmpty())
+
+		for _, format := range []string{"%s", "%v", "%+v", "%#v", "%q"} {
+			require.NotContains(t, fmt.Sprintf(format, s), "secret value")
+			require.NotContains(t, fmt.Sprintf(format, struct{ S *secrets.Secret }{S: s}), "secret value")
+		}
+	})
+
+	t.Run("Equal", func(t *testing.T) {
+		s := secrets.New([]byte("token"))
+
+		require.True(t, s.Equal([]byte("token")))
+		require.False(t, s.Equal([]byte("other")))
+		require.False(t, secrets.New(nil).Equal(nil))
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		s := secrets.New([]byte("token"))
+
+		s.Destroy()
+		s.Destroy()
+
+		require.True(t, s.IsEmpty())
+		require.Empty(t, secretValue(s))
+		require.False(t, s.Equal([]byte("token")))
+	})
+
+	t.Run("Nil secret is empty", func(t *testing.T) {
+		var s *secrets.Secret
+
+		require.True(t, s.IsEmpty())
+		require.Empty(t, secretValue(s))
+		require.False(t, s.Equal(nil))
+		require.NotPanics(t, s.Destroy)
+	})
+}
+
+func TestManager_FromEnv(t *testing.T) {
+	t.Run("Moves value from environment", func(t *testing.T) {
+		t.Setenv(envKey, "env secret")
+
+		m := secrets.NewManager()
+		defer m.Close()
+
+		s := m.FromEnv(envKey)
+
+		_, ok := os.LookupEnv(envKey)
+		require.False(t, ok)
+		require.Equal(t, "env secret", secretValue(s))
+
+		require.Same(t, s, m.FromEnv(envKey))
+	})
+
+	t.Run("Not set variable", func(t *testing.T) {
+		m := secrets.NewManager()
+
+		s := m.FromEnv(envKey)
+		require.Nil(t, s)
+		require.True(t, s.IsEmpty())
+	})
+
+	t.Run("Close destroys secrets", func(t *testing.T) {
+		t.Setenv(envKey, "env secret")
+
+		m := secrets.NewManager()
+
+		s := m.FromEnv(envKey)
+
+		m.Close()
+
+		require.True(t, s.IsEmpty())
+	})
+}
+
+func secretValue(s *secrets.Secret) string {
+	var v string
+
+	s.Use(func(value []byte) {
+		v = string(value)
+	})
+
+	return v
+}
@@ -0,0 +1,85 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secretshare
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lafriks/go-shamir"
+)
+
+// Supported secret sharing schemes.
+const (
+	SchemeShamir = "shamir"
+	SchemeXOR    = "xor"
+)
+
+// Combiner reconstructs the original secret from the given shares.
+type Combiner interface {
+	Combine(shares [][]byte) ([]byte, error)
+}
+
+// NewCombiner returns a Combiner for the given secret sharing scheme. Shamir is used if scheme is empty.
+func NewCombiner(scheme string) (Combiner, error) {
+	switch scheme {
+	case "", SchemeShamir:
+		return &ShamirCombiner{}, nil
+	case SchemeXOR:
+		return &XORCombiner{}, nil
+	default:
+		return nil, fmt.Errorf("not supported secret sharing scheme: %s", scheme)
+	}
+}
+
+// IsSupported checks if the given secret sharing scheme is supported.
+func IsSupported(scheme string) bool {
+	_, err := NewCombiner(scheme)
+
+	return err == nil
+}
+
+// ShamirCombiner combines shares created with Shamir's Secret Sharing scheme.
+type ShamirCombiner struct{}
+
+// Combine reconstructs the secret from Shamir shares.
+func (c *ShamirCombiner) Combine(shares [][]byte) ([]byte, error) {
+	secret, err := shamir.Combine(shares...)
+	if err != nil {
+		return nil, fmt.Errorf("shamir combine: %w", err)
+	}
+
+	return secret, nil
+}
+
+// XORCombiner combines additive shares where the secret is XOR of all shares.
+type XORCombiner struct{}
+
+// Combine reconstructs the secret by XOR-ing all shares. All shares must be of the same length.
+func (c *XORCombiner) Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 { //nolint:gomnd
+		return nil, errors.New("xor combine: at least two shares are required")
+	}
+
+	if len(shares[0]) == 0 {
+		return nil, errors.New("xor combine: empty share")
+	}
+
+	secret := make([]byte, len(shares[0]))
+
+	for _, share := range shares {
+		if len(share) != len(secret) {
+			return nil, errors.New("xor combine: shares must be of the same length")
+		}
+
+		for i := range share {
+			secret[i] ^= share[i]
+		}
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package secretshare_test
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/lafriks/go-shamir"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/secretshare"
+)
+
+func TestNewCombiner(t *testing.T) {
+	t.Run("Shamir is used by default", func(t *testing.T) {
+		c, err := secretshare.NewCombiner("")
+		require.NoError(t, err)
+		require.IsType(t, &secretshare.ShamirCombiner{}, c)
+	})
+
+	t.Run("XOR", func(t *testing.T) {
+		c, err := secretshare.NewCombiner(secretshare.SchemeXOR)
+		require.NoError(t, err)
+		require.IsType(t, &secretshare.XORCombiner{}, c)
+	})
+
+	t.Run("Not supported scheme", func(t *testing.T) {
+		c, err := secretshare.NewCombiner("invalid")
+		require.Nil(t, c)
+		require.EqualError(t, err, "not supported secret sharing scheme: invalid")
+		require.False(t, secretshare.IsSupported("invalid"))
+	})
+}
+
+func TestShamirCombiner_Combine(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		secret := randomBytes(t, 32)
+
+		shares, err := shamir.Split(secret, 2, 2)
+		require.NoError(t, err)
+
+		combined, err := (&secretshare.ShamirCombiner{}).Combine(shares)
+		require.NoError(t, err)
+		require.Equal(t, secret, combined)
+	})
+
+	t.Run("Fail to combine", func(t *testing.T) {
+		_, err := (&secretshare.ShamirCombiner{}).Combine([][]byte{[]byte("share")})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "shamir combine")
+	})
+}
+
+func TestXORCombiner_Combine(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		secret := randomBytes(t, 32)
+
+		combined, err := (&secretshare.XORCombiner{}).Combine(xorSplit(t, secret, 2))
+		require.NoError(t, err)
+		require.Equal(t, secret, combined)
+
+		combined, err = (&secretshare.XORCombiner{}).Combine(xorSplit(t, secret, 5))
+		require.NoError(t, err)
+		require.Equal(t, secret, combined)
+	})
+
+	t.Run("Fail with a single share", func(t *testing.T) {
+		_, err := (&secretshare.XORCombiner{}).Combine([][]byte{[]byte("share")})
+		require.EqualError(t, err, "xor combine: at least two shares are required")
+	})
+
+	t.Run("Fail with empty shares", func(t *testing.T) {
+		_, err := (&secretshare.XORCombiner{}).Combine([][]byte{{}, {}})
+		require.EqualError(t, err, "xor combine: empty share")
+	})
+
+	t.Run("Fail with shares of different length", func(t *testing.T) {
+		_, err := (&secretshare.XORCombiner{}).Combine([][]byte{[]byte("share"), []byte("longer share")})
+		require.EqualError(t, err, "xor combine: shares must be of the same length")
+	})
+}
+
+// xorSplit is a reference implementation of additive (XOR) secret splitting: n-1 shares are random and the last
+// share is XOR of the secret with all random shares.
+func xorSplit(t *testing.T, secret []byte, n int) [][]byte {
+	t.Helper()
+
+	shares := make([][]byte, n)
+	last := append([]byte(nil), secret...)
+
+	for i := 0; i < n-1; i++ {
+		shares[i] = randomBytes(t, len(secret))
+
+		for j := range last {
+			last[j] ^= shares[i][j]
+		}
+	}
+
+	shares[n-1] = last
+
+	return shares
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+
+	return b
+}
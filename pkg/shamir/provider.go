@@ -7,7 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package shamir
 
 //nolint:lll
-//go:generate mockgen -destination gomocks_test.go -self_package mocks -package shamir_test -source=provider.go -mock_names httpClient=MockHTTPClient
+//go:generate mockgen -destination gomocks_test.go -self_package mocks -package shamir_test -source=provider.go -mock_names httpClient=MockHTTPClient,endpoint=MockEndpoint
 
 import (
 	"context"
@@ -18,6 +18,8 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+
+	"github.com/trustbloc/kms/pkg/secrets"
 )
 
 type httpClient interface {
@@ -29,39 +31,59 @@ type Provider interface {
 	FetchSecretShare(subject string) ([]byte, error)
 }
 
+type endpoint interface {
+	URL() string
+}
+
+type staticEndpoint string
+
+func (e staticEndpoint) URL() string {
+	return string(e)
+}
+
 type provider struct {
 	httpClient      httpClient
-	authServerURL   string
-	authServerToken string
+	authServer      endpoint
+	authServerToken *secrets.Secret
 }
 
 // ProviderConfig is a configuration for shamir Provider.
 type ProviderConfig struct {
-	HTTPClient      httpClient
-	AuthServerURL   string
-	AuthServerToken string
+	HTTPClient         httpClient
+	AuthServerURL      string
+	AuthServerEndpoint endpoint // resolves Auth server URL on every request, overrides AuthServerURL if set
+	AuthServerToken    *secrets.Secret
 }
 
 // CreateProvider returns new shamir secret provider.
 func CreateProvider(c *ProviderConfig) Provider {
+	var authServer endpoint = staticEndpoint(c.AuthServerURL)
+
+	if c.AuthServerEndpoint != nil {
+		authServer = c.AuthServerEndpoint
+	}
+
 	return &provider{
 		httpClient:      c.HTTPClient,
-		authServerURL:   c.AuthServerURL,
+		authServer:      authServer,
 		authServerToken: c.AuthServerToken,
 	}
 }
 
 func (p *provider) FetchSecretShare(subject string) ([]byte, error) {
-	uri := fmt.Sprintf("%s/secret?sub=%s", p.authServerURL, url.QueryEscape(subject))
+	uri := fmt.Sprintf("%s/secret?sub=%s", p.authServer.URL(), url.QueryEscape(subject))
 
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, uri, nil)
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
 
-	req.Header.Set("authorization",
-		fmt.Sprintf("Bearer %s", base64.StdEncoding.EncodeToString([]byte(p.authServerToken))),
-	)
+	// without a static token, the HTTP client is expected to authorize requests (e.g. with OAuth access tokens)
+	if !p.authServerToken.IsEmpty() {
+		p.authServerToken.Use(func(token []byte) {
+			req.Header.Set("authorization", "Bearer "+base64.StdEncoding.EncodeToString(token))
+		})
+	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
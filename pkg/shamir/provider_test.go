@@ -18,6 +18,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/trustbloc/kms/pkg/secrets"
 	"github.com/trustbloc/kms/pkg/shamir"
 )
 
@@ -41,7 +42,7 @@ func TestProvider_FetchSecretShare_Success(t *testing.T) {
 
 	provider := shamir.CreateProvider(&shamir.ProviderConfig{
 		AuthServerURL:   "https://auth-server",
-		AuthServerToken: "test token",
+		AuthServerToken: secrets.New([]byte("test token")),
 		HTTPClient:      client,
 	})
 	require.NotNil(t, provider)
@@ -52,6 +53,74 @@ func TestProvider_FetchSecretShare_Success(t *testing.T) {
 	require.Equal(t, "secret share", string(bts))
 }
 
+func TestProvider_FetchSecretShare_WithAuthServerEndpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	b, err := json.Marshal(struct {
+		Secret string `json:"secret"`
+	}{
+		Secret: base64.StdEncoding.EncodeToString([]byte("secret share")),
+	})
+	require.NoError(t, err)
+
+	endpoint := NewMockEndpoint(ctrl)
+	endpoint.EXPECT().URL().Return("https://auth-server-2").Times(1)
+
+	client := NewMockHTTPClient(ctrl)
+	client.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, "auth-server-2", req.URL.Host)
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(b)),
+		}, nil
+	}).Times(1)
+
+	provider := shamir.CreateProvider(&shamir.ProviderConfig{
+		AuthServerURL:      "https://auth-server",
+		AuthServerEndpoint: endpoint,
+		AuthServerToken:    secrets.New([]byte("test token")),
+		HTTPClient:         client,
+	})
+
+	bts, err := provider.FetchSecretShare("test_sub")
+
+	require.NoError(t, err)
+	require.Equal(t, "secret share", string(bts))
+}
+
+func TestProvider_FetchSecretShare_WithoutStaticToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	b, err := json.Marshal(struct {
+		Secret string `json:"secret"`
+	}{
+		Secret: base64.StdEncoding.EncodeToString([]byte("secret share")),
+	})
+	require.NoError(t, err)
+
+	client := NewMockHTTPClient(ctrl)
+	client.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		// left to the HTTP client, e.g. to authorize with OAuth access tokens
+		require.Empty(t, req.Header.Get("authorization"))
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(b)),
+		}, nil
+	}).Times(1)
+
+	provider := shamir.CreateProvider(&shamir.ProviderConfig{
+		AuthServerURL: "https://auth-server",
+		HTTPClient:    client,
+	})
+
+	bts, err := provider.FetchSecretShare("test_sub")
+
+	require.NoError(t, err)
+	require.Equal(t, "secret share", string(bts))
+}
+
 func TestProvider_FetchSecretShare_Failed(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -72,7 +141,7 @@ func TestProvider_FetchSecretShare_Failed(t *testing.T) {
 
 	provider := shamir.CreateProvider(&shamir.ProviderConfig{
 		AuthServerURL:   "https://auth-server",
-		AuthServerToken: "test token",
+		AuthServerToken: secrets.New([]byte("test token")),
 		HTTPClient:      client,
 	})
 	require.NotNil(t, provider)
@@ -91,7 +160,7 @@ func TestProvider_FetchSecretShare_HttpFailed(t *testing.T) {
 
 	provider := shamir.CreateProvider(&shamir.ProviderConfig{
 		AuthServerURL:   "https://auth-server",
-		AuthServerToken: "test token",
+		AuthServerToken: secrets.New([]byte("test token")),
 		HTTPClient:      client,
 	})
 	require.NotNil(t, provider)
@@ -0,0 +1,179 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signnonce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/expiry"
+)
+
+const (
+	// StoreName is the name of the store with signatures of requests with nonces.
+	StoreName = "signnonces"
+
+	resultKeyPrefix = "result_"
+
+	// ReplayedHeader is set to "true" on responses of sign requests that return the signature saved for the nonce
+	// instead of signing, so that the request isn't counted as another signing.
+	ReplayedHeader = "Sign-Nonce-Replayed"
+)
+
+// ErrMismatch is returned when the nonce is reused to sign a different message.
+var ErrMismatch = errors.New("nonce was already used to sign a different message")
+
+type result struct {
+	MessageHash string    `json:"message_hash"`
+	Signature   []byte    `json:"signature"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Store keeps signatures of sign requests with client nonces, so that a retried request returns the signature of
+// the first one instead of signing again. Signatures are kept in storage, so replicas return the same signature.
+type Store struct {
+	store *expiry.Store
+	clock clock.Clock
+	ttl   time.Duration
+	mutex sync.Mutex // serializes saving of signatures within the process
+}
+
+// New returns a new Store that keeps signatures for ttl. Expired signatures are pruned as configured by the options.
+func New(provider storage.Provider, clk clock.Clock, ttl time.Duration, opts ...expiry.Option) (*Store, error) {
+	store, err := expiry.Open(provider, StoreName, expiry.ClassSignNonce, clk, opts...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &Store{store: store, clock: clk, ttl: ttl}, nil
+}
+
+// Sign returns the signature saved for the nonce on the key, or signs the message and saves the signature. The
+// returned flag is true if the signature was saved by an earlier request. Of concurrent requests with the same nonce
+// all get the signature saved first. The guarantee holds across replicas with storage that rejects existing keys for
+// storage.PutOptions.IsNewKey (e.g. MongoDB).
+func (s *Store) Sign(keyStoreID, keyID, nonce string, message []byte,
+	sign func() ([]byte, error)) ([]byte, bool, error) {
+	key := resultKeyPrefix + hash([]byte(keyStoreID), []byte(keyID), []byte(nonce))
+	messageHash := hash(message)
+
+	saved, err := s.get(key, messageHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if saved != nil {
+		return saved, true, nil
+	}
+
+	signature, err := sign()
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the nonce could have been used by a concurrent request while signing
+	saved, err = s.get(key, messageHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if saved != nil {
+		return saved, true, nil
+	}
+
+	r := &result{
+		MessageHash: messageHash,
+		Signature:   signature,
+		ExpiresAt:   s.clock.Now().UTC().Add(s.ttl),
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, false, fmt.Errorf("marshal signature: %w", err)
+	}
+
+	err = s.store.Put(key, b, r.ExpiresAt, true)
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		return s.getSaved(key, messageHash)
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("save signature: %w", err)
+	}
+
+	return signature, false, nil
+}
+
+// get returns a signature saved for the key, or nil if there is none. An expired signature is deleted, so that the
+// nonce can be used again.
+func (s *Store) get(key, messageHash string) ([]byte, error) {
+	b, err := s.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get signature: %w", err)
+	}
+
+	var r result
+
+	if err = json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("unmarshal signature: %w", err)
+	}
+
+	if s.store.Expired(r.ExpiresAt) {
+		if err = s.store.Delete(key); err != nil {
+			return nil, fmt.Errorf("delete expired signature: %w", err)
+		}
+
+		return nil, nil
+	}
+
+	if r.MessageHash != messageHash {
+		return nil, ErrMismatch
+	}
+
+	return r.Signature, nil
+}
+
+func (s *Store) getSaved(key, messageHash string) ([]byte, bool, error) {
+	saved, err := s.get(key, messageHash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if saved == nil {
+		return nil, false, errors.New("signature saved by a concurrent request expired")
+	}
+
+	return saved, true, nil
+}
+
+// hash returns a hex-encoded hash of the values. Values are length-prefixed, so different sets of values don't
+// produce the same input.
+func hash(values ...[]byte) string {
+	h := sha256.New()
+
+	for _, v := range values {
+		h.Write([]byte(fmt.Sprintf("%d:", len(v))))
+		h.Write(v)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,237 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package signnonce_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/signnonce"
+)
+
+func TestStore_Sign(t *testing.T) {
+	t.Run("Retry returns saved signature", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		signer := &counterSigner{}
+
+		sig, replayed, err := s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("signature 1"), sig)
+
+		sig, replayed, err = s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+		require.True(t, replayed)
+		require.Equal(t, []byte("signature 1"), sig)
+		require.EqualValues(t, 1, signer.count)
+	})
+
+	t.Run("Nonces are scoped to the key", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		signer := &counterSigner{}
+
+		_, _, err := s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+
+		sig, replayed, err := s.Sign("ks", "other", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("signature 2"), sig)
+	})
+
+	t.Run("Nonce reused for another message", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+		signer := &counterSigner{}
+
+		_, _, err := s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+
+		_, _, err = s.Sign("ks", "key", "nonce", []byte("other message"), signer.sign)
+		require.ErrorIs(t, err, signnonce.ErrMismatch)
+		require.EqualValues(t, 1, signer.count)
+	})
+
+	t.Run("Expired signature is not returned", func(t *testing.T) {
+		s, clk := newStore(t, mem.NewProvider())
+		signer := &counterSigner{}
+
+		_, _, err := s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+		require.NoError(t, err)
+
+		clk.Advance(time.Minute)
+
+		sig, replayed, err := s.Sign("ks", "key", "nonce", []byte("other message"), signer.sign)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("signature 2"), sig)
+	})
+
+	t.Run("Expired signature is pruned", func(t *testing.T) {
+		provider := mem.NewProvider()
+		clk := testutil.NewFakeClock(time.Now())
+		pruner := expiry.NewPruner(time.Hour)
+
+		s, err := signnonce.New(provider, clk, time.Minute, expiry.WithPruner(pruner))
+		require.NoError(t, err)
+
+		_, _, err = s.Sign("ks", "key", "nonce", []byte("message"), (&counterSigner{}).sign)
+		require.NoError(t, err)
+
+		pruner.Prune()
+		requireRecords(t, provider, 1)
+
+		// expiry tags are rounded up to the second
+		clk.Advance(time.Minute + time.Second)
+		pruner.Prune()
+		requireRecords(t, provider, 0)
+	})
+
+	t.Run("Sign error is not saved", func(t *testing.T) {
+		s, _ := newStore(t, mem.NewProvider())
+
+		_, _, err := s.Sign("ks", "key", "nonce", []byte("message"), func() ([]byte, error) {
+			return nil, errors.New("sign error")
+		})
+		require.EqualError(t, err, "sign error")
+
+		sig, replayed, err := s.Sign("ks", "key", "nonce", []byte("message"), (&counterSigner{}).sign)
+		require.NoError(t, err)
+		require.False(t, replayed)
+		require.Equal(t, []byte("signature 1"), sig)
+	})
+
+	t.Run("Concurrent requests across replicas get the same signature", func(t *testing.T) {
+		provider := &newKeyProvider{Provider: mem.NewProvider()}
+
+		s1, _ := newStore(t, provider)
+		s2, _ := newStore(t, provider)
+
+		signer := &counterSigner{}
+
+		var wg sync.WaitGroup
+
+		signatures := make([][]byte, 10)
+
+		for i := range signatures {
+			s := s1
+			if i%2 == 1 {
+				s = s2
+			}
+
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				sig, _, err := s.Sign("ks", "key", "nonce", []byte("message"), signer.sign)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				signatures[i] = sig
+			}(i)
+		}
+
+		wg.Wait()
+
+		for _, sig := range signatures {
+			require.Equal(t, signatures[0], sig)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := signnonce.New(&newKeyProvider{openErr: errors.New("open error")},
+		testutil.NewFakeClock(time.Now()), time.Minute)
+	require.EqualError(t, err, "open store: open error")
+}
+
+func requireRecords(t *testing.T, provider storage.Provider, count int) {
+	t.Helper()
+
+	store, err := provider.OpenStore(signnonce.StoreName)
+	require.NoError(t, err)
+
+	it, err := store.Query("expires_at")
+	require.NoError(t, err)
+
+	n, err := it.TotalItems()
+	require.NoError(t, err)
+	require.Equal(t, count, n)
+}
+
+func newStore(t *testing.T, provider storage.Provider) (*signnonce.Store, *testutil.FakeClock) {
+	t.Helper()
+
+	clk := testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	s, err := signnonce.New(provider, clk, time.Minute)
+	require.NoError(t, err)
+
+	return s, clk
+}
+
+// counterSigner returns a different signature on every call, like signing with a non-deterministic algorithm.
+type counterSigner struct {
+	count int32
+}
+
+func (s *counterSigner) sign() ([]byte, error) {
+	return []byte(fmt.Sprintf("signature %d", atomic.AddInt32(&s.count, 1))), nil
+}
+
+// newKeyProvider rejects existing keys stored with IsNewKey option, like MongoDB does.
+type newKeyProvider struct {
+	storage.Provider
+	openErr error
+	mutex   sync.Mutex
+}
+
+func (p *newKeyProvider) OpenStore(name string) (storage.Store, error) {
+	if p.openErr != nil {
+		return nil, p.openErr
+	}
+
+	store, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newKeyStore{Store: store, mutex: &p.mutex}, nil
+}
+
+type newKeyStore struct {
+	storage.Store
+	mutex *sync.Mutex
+}
+
+func (s *newKeyStore) Batch(operations []storage.Operation) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, op := range operations {
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			continue
+		}
+
+		if _, err := s.Store.Get(op.Key); err == nil {
+			return storage.ErrDuplicateKey
+		}
+	}
+
+	return s.Store.Batch(operations)
+}
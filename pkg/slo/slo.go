@@ -0,0 +1,487 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+const (
+	// ErrorRateMetric is the metric of HTTP response statuses that error rate objectives are evaluated against.
+	ErrorRateMetric = "kms_http_response_status_count"
+
+	// clearRatio is a share of the target below which a burning objective is resolved, so that a value hovering
+	// around the target doesn't make the alert flap.
+	clearRatio = 0.9
+
+	defaultInterval   = 30 * time.Second
+	defaultMinSamples = 10
+	webhookTimeout    = 10 * time.Second
+)
+
+var logger = log.New("slo")
+
+// State is a state of an objective reported in alerts.
+type State string
+
+const (
+	// StateBurning is reported when the objective is violated over its window.
+	StateBurning State = "burning"
+	// StateResolved is reported when a burning objective is met again.
+	StateResolved State = "resolved"
+)
+
+// Duration is a time.Duration that is read from JSON as a string, e.g. "5m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses the duration from a string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Objective is a service level objective. A latency objective sets Metric, Quantile and MaxLatency: the quantile of
+// the histogram must stay below MaxLatency. An error rate objective sets MaxErrorRate: the share of 5xx responses
+// must stay below it.
+type Objective struct {
+	Name         string   `json:"name"`
+	Metric       string   `json:"metric,omitempty"`
+	Quantile     float64  `json:"quantile,omitempty"`
+	MaxLatency   Duration `json:"max_latency,omitempty"`
+	MaxErrorRate float64  `json:"max_error_rate,omitempty"`
+	// Window is a period over which the objective is evaluated.
+	Window Duration `json:"window"`
+	// MinSamples is a number of observations in the window below which the objective isn't evaluated. Defaults to 10.
+	MinSamples uint64 `json:"min_samples,omitempty"`
+}
+
+func (o *Objective) isErrorRate() bool {
+	return o.MaxErrorRate > 0
+}
+
+func (o *Objective) target() float64 {
+	if o.isErrorRate() {
+		return o.MaxErrorRate
+	}
+
+	return o.MaxLatency.Seconds()
+}
+
+func (o *Objective) validate() error {
+	if o.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if o.Window.Duration <= 0 {
+		return fmt.Errorf("objective %q: window must be positive", o.Name)
+	}
+
+	if o.isErrorRate() {
+		if o.MaxErrorRate >= 1 || o.Metric != "" || o.Quantile != 0 || o.MaxLatency.Duration != 0 {
+			return fmt.Errorf("objective %q: error rate objective needs only max_error_rate below 1", o.Name)
+		}
+
+		return nil
+	}
+
+	if o.Metric == "" || o.Quantile <= 0 || o.Quantile >= 1 || o.MaxLatency.Duration <= 0 {
+		return fmt.Errorf("objective %q: latency objective needs metric, quantile from 0 to 1 and max_latency",
+			o.Name)
+	}
+
+	return nil
+}
+
+// Config configures Evaluator. Objectives, Interval and WebhookURL are read from the SLO config file.
+type Config struct {
+	Objectives []Objective `json:"objectives"`
+	// Interval defines how often objectives are evaluated. Defaults to 30s.
+	Interval Duration `json:"interval,omitempty"`
+	// WebhookURL receives alerts as JSON POST requests. Alerts are only logged if empty.
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// Gatherer provides metrics. Defaults to the Prometheus registry the server exposes metrics from.
+	Gatherer prometheus.Gatherer `json:"-"`
+	// Clock defaults to the system time.
+	Clock clock.Clock `json:"-"`
+	// Notify is called on every alert. Defaults to logging the alert and sending it to WebhookURL.
+	Notify func(Alert) `json:"-"`
+	// HTTPClient sends alerts to WebhookURL. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client `json:"-"`
+}
+
+// LoadConfig reads an SLO config file in JSON format.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path) //nolint:gosec // the path is set by the operator
+	if err != nil {
+		return nil, fmt.Errorf("read slo config: %w", err)
+	}
+
+	var config Config
+
+	if err = json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("parse slo config: %w", err)
+	}
+
+	if len(config.Objectives) == 0 {
+		return nil, fmt.Errorf("slo config has no objectives")
+	}
+
+	for i := range config.Objectives {
+		if err = config.Objectives[i].validate(); err != nil {
+			return nil, fmt.Errorf("slo config: %w", err)
+		}
+	}
+
+	return &config, nil
+}
+
+// Alert is sent when an objective starts or stops burning.
+type Alert struct {
+	Objective string    `json:"objective"`
+	State     State     `json:"state"`
+	Value     float64   `json:"value"`  // the observed quantile in seconds or error rate
+	Target    float64   `json:"target"` // max_latency in seconds or max_error_rate
+	Window    string    `json:"window"`
+	Time      time.Time `json:"time"`
+}
+
+// snapshot is a cumulative state of a metric at a point in time.
+type snapshot struct {
+	time    time.Time
+	buckets map[float64]uint64 // cumulative count per upper bound
+	count   uint64
+	errors  uint64
+}
+
+type objectiveState struct {
+	objective Objective
+	snapshots []*snapshot
+	burning   bool
+}
+
+// Evaluator evaluates service level objectives against metrics of the server in the background and sends alerts
+// when objectives start or stop burning. State is kept in memory only.
+type Evaluator struct {
+	config   Config
+	states   []*objectiveState
+	mutex    sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a new Evaluator.
+func New(config *Config) *Evaluator {
+	c := *config
+
+	if c.Interval.Duration <= 0 {
+		c.Interval.Duration = defaultInterval
+	}
+
+	if c.Gatherer == nil {
+		c.Gatherer = prometheus.DefaultGatherer
+	}
+
+	if c.Clock == nil {
+		c.Clock = clock.Real()
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: webhookTimeout}
+	}
+
+	e := &Evaluator{
+		config: c,
+		done:   make(chan struct{}),
+	}
+
+	if e.config.Notify == nil {
+		e.config.Notify = e.notify
+	}
+
+	for _, o := range c.Objectives {
+		if o.MinSamples == 0 {
+			o.MinSamples = defaultMinSamples
+		}
+
+		e.states = append(e.states, &objectiveState{objective: o})
+	}
+
+	return e
+}
+
+// Start starts evaluating objectives in the background until Stop is called.
+func (e *Evaluator) Start() {
+	e.Evaluate()
+
+	go func() {
+		ticker := time.NewTicker(e.config.Interval.Duration)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.Evaluate()
+			case <-e.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops evaluating objectives.
+func (e *Evaluator) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.done)
+	})
+}
+
+// Evaluate takes a snapshot of metrics and evaluates objectives over their windows. An objective starts burning when
+// its value exceeds the target, and is resolved when the value drops below 90% of the target.
+func (e *Evaluator) Evaluate() {
+	families, err := e.config.Gatherer.Gather()
+	if err != nil {
+		logger.Errorf("gather metrics for slo evaluation: %v", err)
+
+		return
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+
+	for _, f := range families {
+		byName[f.GetName()] = f
+	}
+
+	now := e.config.Clock.Now()
+
+	var alerts []Alert
+
+	e.mutex.Lock()
+
+	for _, s := range e.states {
+		if alert, ok := s.evaluate(now, byName); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	e.mutex.Unlock()
+
+	for _, a := range alerts {
+		e.config.Notify(a)
+	}
+}
+
+func (s *objectiveState) evaluate(now time.Time, families map[string]*dto.MetricFamily) (Alert, bool) {
+	o := &s.objective
+
+	if o.isErrorRate() {
+		s.snapshots = append(s.snapshots, errorRateSnapshot(now, families[ErrorRateMetric]))
+	} else {
+		s.snapshots = append(s.snapshots, histogramSnapshot(now, families[o.Metric]))
+	}
+
+	// the newest snapshot at or before the start of the window is kept as a base
+	start := now.Add(-o.Window.Duration)
+
+	for len(s.snapshots) > 1 && !s.snapshots[1].time.After(start) {
+		s.snapshots = s.snapshots[1:]
+	}
+
+	base, current := s.snapshots[0], s.snapshots[len(s.snapshots)-1]
+
+	count := current.count - base.count
+	if count < o.MinSamples {
+		return Alert{}, false
+	}
+
+	var value float64
+
+	if o.isErrorRate() {
+		value = float64(current.errors-base.errors) / float64(count)
+	} else {
+		value = quantile(o.Quantile, base, current)
+	}
+
+	switch {
+	case !s.burning && value > o.target():
+		s.burning = true
+	case s.burning && value < o.target()*clearRatio:
+		s.burning = false
+	default:
+		return Alert{}, false
+	}
+
+	state := StateResolved
+	if s.burning {
+		state = StateBurning
+	}
+
+	return Alert{
+		Objective: o.Name,
+		State:     state,
+		Value:     value,
+		Target:    o.target(),
+		Window:    o.Window.String(),
+		Time:      now.UTC(),
+	}, true
+}
+
+// histogramSnapshot sums buckets of all series of the histogram. A metric that isn't registered yet has no
+// observations.
+func histogramSnapshot(now time.Time, family *dto.MetricFamily) *snapshot {
+	s := &snapshot{time: now, buckets: map[float64]uint64{}}
+
+	for _, m := range family.GetMetric() {
+		h := m.GetHistogram()
+
+		s.count += h.GetSampleCount()
+
+		for _, b := range h.GetBucket() {
+			s.buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+		}
+	}
+
+	return s
+}
+
+func errorRateSnapshot(now time.Time, family *dto.MetricFamily) *snapshot {
+	s := &snapshot{time: now}
+
+	for _, m := range family.GetMetric() {
+		count := uint64(m.GetCounter().GetValue())
+
+		s.count += count
+
+		for _, l := range m.GetLabel() {
+			if l.GetName() != "status" {
+				continue
+			}
+
+			if code, err := strconv.Atoi(l.GetValue()); err == nil && code >= http.StatusInternalServerError {
+				s.errors += count
+			}
+		}
+	}
+
+	return s
+}
+
+// quantile estimates the quantile of observations between the snapshots, interpolating linearly within a bucket the
+// same way as histogram_quantile in Prometheus. Observations above the highest bucket are reported as its bound.
+func quantile(q float64, base, current *snapshot) float64 {
+	bounds := make([]float64, 0, len(current.buckets))
+
+	for b := range current.buckets {
+		bounds = append(bounds, b)
+	}
+
+	sort.Float64s(bounds)
+
+	count := current.count - base.count
+	rank := q * float64(count)
+
+	var lowerBound, lowerCount float64
+
+	for _, b := range bounds {
+		cumulative := float64(current.buckets[b] - base.buckets[b])
+
+		if cumulative >= rank {
+			if math.IsInf(b, 1) || cumulative == lowerCount {
+				return lowerBound
+			}
+
+			return lowerBound + (b-lowerBound)*(rank-lowerCount)/(cumulative-lowerCount)
+		}
+
+		lowerBound, lowerCount = b, cumulative
+	}
+
+	return lowerBound
+}
+
+func (e *Evaluator) notify(a Alert) {
+	if a.State == StateBurning {
+		logger.Warnf("SLO %q is burning: %g over %s exceeds %g", a.Objective, a.Value, a.Window, a.Target)
+	} else {
+		logger.Infof("SLO %q is resolved: %g over %s", a.Objective, a.Value, a.Window)
+	}
+
+	if e.config.WebhookURL == "" {
+		return
+	}
+
+	if err := e.sendWebhook(a); err != nil {
+		logger.Errorf("send slo alert to webhook: %v", err)
+	}
+}
+
+func (e *Evaluator) sendWebhook(a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, e.config.WebhookURL,
+		bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post alert: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Errorf("close webhook response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package slo_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/slo"
+)
+
+const signMetric = "kms_crypto_sign_seconds"
+
+func TestEvaluator_Latency(t *testing.T) {
+	signP95 := slo.Objective{
+		Name:       "sign-p95",
+		Metric:     signMetric,
+		Quantile:   0.95,
+		MaxLatency: slo.Duration{Duration: 150 * time.Millisecond},
+		Window:     slo.Duration{Duration: 5 * time.Minute},
+	}
+
+	t.Run("Objective burns and resolves with hysteresis", func(t *testing.T) {
+		env := newEnv(t, signP95)
+		env.evaluate(time.Minute)
+
+		env.observe(100, 50*time.Millisecond)
+		env.evaluate(5 * time.Minute)
+		require.Empty(t, env.alerts)
+
+		env.observe(100, 400*time.Millisecond)
+		env.evaluate(5 * time.Minute)
+		require.Len(t, env.alerts, 1)
+		require.Equal(t, slo.StateBurning, env.alerts[0].State)
+		require.Equal(t, "sign-p95", env.alerts[0].Objective)
+		require.InDelta(t, 0.15, env.alerts[0].Target, 1e-9)
+		require.Greater(t, env.alerts[0].Value, 0.25)
+
+		// p95 of ~143ms is below the target, but not enough below it to resolve
+		env.observe(93, 50*time.Millisecond)
+		env.observe(7, 200*time.Millisecond)
+		env.evaluate(5 * time.Minute)
+		require.Len(t, env.alerts, 1)
+
+		env.observe(100, 50*time.Millisecond)
+		env.evaluate(5 * time.Minute)
+		require.Len(t, env.alerts, 2)
+		require.Equal(t, slo.StateResolved, env.alerts[1].State)
+		require.Less(t, env.alerts[1].Value, 0.135)
+	})
+
+	t.Run("Slow observations burn until they leave the window", func(t *testing.T) {
+		env := newEnv(t, signP95)
+		env.evaluate(time.Minute)
+
+		env.observe(100, time.Second)
+		env.evaluate(time.Minute)
+		require.Len(t, env.alerts, 1)
+
+		for i := 0; i < 4; i++ {
+			env.observe(100, 10*time.Millisecond)
+			env.evaluate(time.Minute)
+		}
+
+		require.Len(t, env.alerts, 1)
+
+		env.observe(100, 10*time.Millisecond)
+		env.evaluate(time.Minute)
+		require.Len(t, env.alerts, 2)
+		require.Equal(t, slo.StateResolved, env.alerts[1].State)
+	})
+
+	t.Run("Objective is not evaluated with too few samples", func(t *testing.T) {
+		env := newEnv(t, signP95)
+		env.evaluate(time.Minute)
+
+		env.observe(9, time.Second)
+		env.evaluate(time.Minute)
+		require.Empty(t, env.alerts)
+	})
+
+	t.Run("Missing metric has no samples", func(t *testing.T) {
+		objective := signP95
+		objective.Metric = "kms_unknown_seconds"
+
+		env := newEnv(t, objective)
+		env.evaluate(time.Minute)
+
+		env.observe(100, time.Second)
+		env.evaluate(time.Minute)
+		require.Empty(t, env.alerts)
+	})
+}
+
+func TestEvaluator_ErrorRate(t *testing.T) {
+	env := newEnv(t, slo.Objective{
+		Name:         "errors",
+		MaxErrorRate: 0.01,
+		Window:       slo.Duration{Duration: 5 * time.Minute},
+	})
+	env.evaluate(time.Minute)
+
+	env.respond(99, http.StatusOK)
+	env.respond(1, http.StatusNotFound)
+	env.evaluate(5 * time.Minute)
+	require.Empty(t, env.alerts)
+
+	env.respond(95, http.StatusOK)
+	env.respond(5, http.StatusServiceUnavailable)
+	env.evaluate(5 * time.Minute)
+	require.Len(t, env.alerts, 1)
+	require.Equal(t, slo.StateBurning, env.alerts[0].State)
+	require.InDelta(t, 0.05, env.alerts[0].Value, 1e-9)
+
+	env.respond(1000, http.StatusOK)
+	env.evaluate(5 * time.Minute)
+	require.Len(t, env.alerts, 2)
+	require.Equal(t, slo.StateResolved, env.alerts[1].State)
+}
+
+func TestEvaluator_Webhook(t *testing.T) {
+	alerts := make(chan slo.Alert, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a slo.Alert
+
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&a))
+
+		alerts <- a
+	}))
+	defer srv.Close()
+
+	env := newEnv(t, slo.Objective{
+		Name:         "errors",
+		MaxErrorRate: 0.01,
+		Window:       slo.Duration{Duration: 5 * time.Minute},
+	})
+
+	e := slo.New(&slo.Config{
+		Objectives: env.config.Objectives,
+		WebhookURL: srv.URL,
+		Gatherer:   env.config.Gatherer,
+		Clock:      env.config.Clock,
+	})
+	e.Evaluate()
+
+	env.respond(100, http.StatusInternalServerError)
+	env.clock.Advance(time.Minute)
+	e.Evaluate()
+
+	a := <-alerts
+	require.Equal(t, "errors", a.Objective)
+	require.Equal(t, slo.StateBurning, a.State)
+	require.Equal(t, "5m0s", a.Window)
+}
+
+func TestEvaluator_StartStop(t *testing.T) {
+	e := slo.New(&slo.Config{
+		Objectives: []slo.Objective{{
+			Name:         "errors",
+			MaxErrorRate: 0.01,
+			Window:       slo.Duration{Duration: time.Minute},
+		}},
+		Interval: slo.Duration{Duration: time.Millisecond},
+		Gatherer: prometheus.NewRegistry(),
+	})
+
+	e.Start()
+	time.Sleep(5 * time.Millisecond)
+	e.Stop()
+	e.Stop()
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		config, err := slo.LoadConfig(writeConfig(t, `{
+  "interval": "10s",
+  "webhook_url": "https://alerts.example.com/kms",
+  "objectives": [
+    {"name": "sign-p95", "metric": "kms_crypto_sign_seconds", "quantile": 0.95, "max_latency": "150ms",
+     "window": "5m"},
+    {"name": "errors", "max_error_rate": 0.01, "window": "5m", "min_samples": 100}
+  ]
+}`))
+		require.NoError(t, err)
+		require.Equal(t, 10*time.Second, config.Interval.Duration)
+		require.Equal(t, "https://alerts.example.com/kms", config.WebhookURL)
+		require.Len(t, config.Objectives, 2)
+		require.Equal(t, 150*time.Millisecond, config.Objectives[0].MaxLatency.Duration)
+		require.EqualValues(t, 100, config.Objectives[1].MinSamples)
+	})
+
+	t.Run("Fail to read file", func(t *testing.T) {
+		_, err := slo.LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "read slo config")
+	})
+
+	for name, tc := range map[string]struct {
+		config string
+		err    string
+	}{
+		"invalid JSON":      {`{`, "parse slo config"},
+		"invalid duration":  {`{"objectives": [{"name": "a", "window": "5 minutes"}]}`, "parse slo config"},
+		"not a string":      {`{"objectives": [{"name": "a", "window": 300}]}`, "duration must be a string"},
+		"no objectives":     {`{}`, "slo config has no objectives"},
+		"no name":           {`{"objectives": [{"window": "5m"}]}`, "name is required"},
+		"no window":         {`{"objectives": [{"name": "a", "max_error_rate": 0.01}]}`, "window must be positive"},
+		"error rate over 1": {`{"objectives": [{"name": "a", "max_error_rate": 1, "window": "5m"}]}`, "error rate"},
+		"mixed objective": {
+			`{"objectives": [{"name": "a", "max_error_rate": 0.01, "quantile": 0.9, "window": "5m"}]}`, "error rate",
+		},
+		"no metric": {
+			`{"objectives": [{"name": "a", "quantile": 0.9, "max_latency": "1s", "window": "5m"}]}`,
+			"latency objective needs",
+		},
+	} {
+		t.Run("Fail with "+name, func(t *testing.T) {
+			_, err := slo.LoadConfig(writeConfig(t, tc.config))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestDuration_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(slo.Duration{Duration: 5 * time.Minute})
+	require.NoError(t, err)
+	require.Equal(t, `"5m0s"`, string(b))
+}
+
+type env struct {
+	config    *slo.Config
+	evaluator *slo.Evaluator
+	clock     *testutil.FakeClock
+	signTime  prometheus.Histogram
+	statuses  *prometheus.CounterVec
+	alerts    []slo.Alert
+}
+
+// newEnv returns an evaluator of metrics that are recorded the same way as metrics of the server.
+func newEnv(t *testing.T, objectives ...slo.Objective) *env {
+	t.Helper()
+
+	e := &env{
+		clock: testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)),
+		signTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "kms",
+			Subsystem: "crypto",
+			Name:      "sign_seconds",
+		}),
+		statuses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "kms",
+			Name:      "http_response_status_count",
+		}, []string{"status"}),
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e.signTime, e.statuses)
+
+	e.config = &slo.Config{
+		Objectives: objectives,
+		Gatherer:   registry,
+		Clock:      e.clock,
+		Notify: func(a slo.Alert) {
+			e.alerts = append(e.alerts, a)
+		},
+	}
+	e.evaluator = slo.New(e.config)
+
+	return e
+}
+
+func (e *env) observe(n int, latency time.Duration) {
+	for i := 0; i < n; i++ {
+		e.signTime.Observe(latency.Seconds())
+	}
+}
+
+func (e *env) respond(n int, status int) {
+	e.statuses.WithLabelValues(strconv.Itoa(status)).Add(float64(n))
+}
+
+func (e *env) evaluate(after time.Duration) {
+	e.clock.Advance(after)
+	e.evaluator.Evaluate()
+}
+
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "slo.json")
+
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+
+	return path
+}
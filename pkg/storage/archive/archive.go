@@ -0,0 +1,383 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package archive moves records of cold keys from primary storage to a cheaper archive target, leaving a stub in
+// primary storage. A record is recalled to primary storage transparently when its stub is read.
+//
+// Records are moved so that they exist in at least one tier at any time: a record is written to the target before
+// it's replaced with a stub, and written back to primary storage before it's deleted from the target. A crash in
+// between leaves a copy in both tiers, and primary storage decides which copy is used.
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// stubPrefix starts the value of a record that was moved to the archive. It's followed by the time of archival.
+const stubPrefix = "archived:"
+
+var logger = log.New("archive")
+
+// Record is an archived record with its tags.
+type Record struct {
+	Value []byte        `json:"value"`
+	Tags  []storage.Tag `json:"tags,omitempty"`
+}
+
+// Target stores archived records, e.g. a database of its own (see NewStorageTarget) or an S3-compatible object store
+// (see NewS3Target).
+type Target interface {
+	// Put saves the record of the store under the key, replacing an earlier copy.
+	Put(storeName, key string, record *Record) error
+	// Get returns the record of the store saved under the key, or storage.ErrDataNotFound.
+	Get(storeName, key string) (*Record, error)
+	// Delete deletes the record of the store saved under the key. Deleting a missing record isn't an error.
+	Delete(storeName, key string) error
+}
+
+type metricsProvider interface {
+	ArchiveRecallTime(value time.Duration)
+}
+
+// Option configures a Provider.
+type Option func(p *Provider)
+
+// WithClock sets the clock of archival times and recall durations. Defaults to system time.
+func WithClock(clk clock.Clock) Option {
+	return func(p *Provider) {
+		p.clock = clk
+	}
+}
+
+// WithMetrics sets the provider of recall times.
+func WithMetrics(m metricsProvider) Option {
+	return func(p *Provider) {
+		p.metrics = m
+	}
+}
+
+// Provider is a storage.Provider that recalls archived records of the primary storage provider from the target.
+// Records are archived with Archive.
+//
+// A record is locked while it's archived, recalled or changed through the provider, so that concurrent reads recall
+// it once. Records shouldn't be changed through other providers of the primary storage while they are archived.
+type Provider struct {
+	primary storage.Provider
+	target  Target
+	clock   clock.Clock
+	metrics metricsProvider
+
+	mutex sync.Mutex
+	locks map[string]*recordLock
+}
+
+type recordLock struct {
+	sync.Mutex
+	refs int
+}
+
+// New returns a new Provider of the primary storage provider with records archived in the target.
+func New(primary storage.Provider, target Target, opts ...Option) *Provider {
+	p := &Provider{
+		primary: primary,
+		target:  target,
+		clock:   clock.Real(),
+		locks:   make(map[string]*recordLock),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// OpenStore opens the store of the primary storage provider.
+func (p *Provider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.primary.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{store: s, name: name, provider: p}, nil
+}
+
+// SetStoreConfig sets the configuration of the store of the primary storage provider.
+func (p *Provider) SetStoreConfig(name string, config storage.StoreConfiguration) error {
+	return p.primary.SetStoreConfig(name, config)
+}
+
+// GetStoreConfig gets the configuration of the store of the primary storage provider.
+func (p *Provider) GetStoreConfig(name string) (storage.StoreConfiguration, error) {
+	return p.primary.GetStoreConfig(name)
+}
+
+// GetOpenStores returns the open stores of the primary storage provider.
+func (p *Provider) GetOpenStores() []storage.Store {
+	return p.primary.GetOpenStores()
+}
+
+// Close closes the primary storage provider.
+func (p *Provider) Close() error {
+	return p.primary.Close()
+}
+
+// Primary returns the primary storage provider, for reads that must not recall archived records (e.g. to measure
+// stored sizes). Values of archived records read through it are stubs.
+func (p *Provider) Primary() storage.Provider {
+	return p.primary
+}
+
+// Archive moves the record of the store saved under the key to the target, and replaces it with a stub. It returns
+// false if the record is archived already, and storage.ErrDataNotFound if there's no record.
+func (p *Provider) Archive(storeName, key string) (bool, error) {
+	s, err := p.primary.OpenStore(storeName)
+	if err != nil {
+		return false, fmt.Errorf("open store: %w", err)
+	}
+
+	unlock := p.lock(storeName, key)
+	defer unlock()
+
+	value, err := s.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("get record: %w", err)
+	}
+
+	if isStub(value) {
+		return false, nil
+	}
+
+	tags, err := s.GetTags(key)
+	if err != nil {
+		return false, fmt.Errorf("get record tags: %w", err)
+	}
+
+	if err = p.target.Put(storeName, key, &Record{Value: value, Tags: tags}); err != nil {
+		return false, fmt.Errorf("put record to archive: %w", err)
+	}
+
+	// the record is in both tiers until the stub replaces it
+	stub := []byte(stubPrefix + p.clock.Now().UTC().Format(time.RFC3339))
+
+	if err = s.Put(key, stub, tags...); err != nil {
+		return false, fmt.Errorf("put stub: %w", err)
+	}
+
+	return true, nil
+}
+
+// recall moves the archived record of the store back to primary storage and returns its value. Concurrent recalls
+// of the record wait for the first one and read the recalled value.
+func (p *Provider) recall(s *store, key string) ([]byte, error) {
+	unlock := p.lock(s.name, key)
+	defer unlock()
+
+	value, err := s.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isStub(value) {
+		return value, nil
+	}
+
+	start := p.clock.Now()
+
+	record, err := p.target.Get(s.name, key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		// not wrapped: the record exists, but its archived copy is lost
+		return nil, fmt.Errorf("recall record %s of store %s: not found in archive", key, s.name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("recall record %s of store %s: %w", key, s.name, err)
+	}
+
+	if err = s.store.Put(key, record.Value, record.Tags...); err != nil {
+		return nil, fmt.Errorf("put recalled record: %w", err)
+	}
+
+	// the recalled record is used from now on, a copy left in the archive is overwritten when it's archived again
+	if err = p.target.Delete(s.name, key); err != nil {
+		logger.Warnf("Failed to delete recalled record %s of store %s from archive: %v", key, s.name, err)
+	}
+
+	if p.metrics != nil {
+		p.metrics.ArchiveRecallTime(p.clock.Now().Sub(start))
+	}
+
+	logger.Infof("Recalled record %s of store %s archived at %s", key, s.name,
+		bytes.TrimPrefix(value, []byte(stubPrefix)))
+
+	return record.Value, nil
+}
+
+// lock locks the record of the store and returns the function that unlocks it.
+func (p *Provider) lock(storeName, key string) func() {
+	id := storeName + "/" + key
+
+	p.mutex.Lock()
+
+	l, ok := p.locks[id]
+	if !ok {
+		l = &recordLock{}
+		p.locks[id] = l
+	}
+
+	l.refs++
+
+	p.mutex.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if l.refs--; l.refs == 0 {
+			delete(p.locks, id)
+		}
+	}
+}
+
+func isStub(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(stubPrefix))
+}
+
+type store struct {
+	store    storage.Store
+	name     string
+	provider *Provider
+}
+
+// Put saves the record to primary storage. A copy of an archived record left in the target is ignored from now on.
+func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
+	unlock := s.provider.lock(s.name, key)
+	defer unlock()
+
+	return s.store.Put(key, value, tags...)
+}
+
+// Get returns the record, recalling it if it's archived.
+func (s *store) Get(key string) ([]byte, error) {
+	value, err := s.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.resolve(key, value)
+}
+
+// GetTags returns tags of the record. Tags of an archived record are kept with its stub.
+func (s *store) GetTags(key string) ([]storage.Tag, error) {
+	return s.store.GetTags(key)
+}
+
+// GetBulk returns the records, recalling archived ones.
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	values, err := s.store.GetBulk(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		if values[i], err = s.resolve(keys[i], value); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// Query returns records of primary storage that satisfy the expression. Archived records are recalled when their
+// values are read.
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	it, err := s.store.Query(expression, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterator{Iterator: it, store: s}, nil
+}
+
+// Delete deletes the record from primary storage and, if it's archived, from the target.
+func (s *store) Delete(key string) error {
+	unlock := s.provider.lock(s.name, key)
+	defer unlock()
+
+	value, err := s.store.Get(key)
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return err
+	}
+
+	if err = s.store.Delete(key); err != nil {
+		return err
+	}
+
+	if isStub(value) {
+		if err = s.provider.target.Delete(s.name, key); err != nil {
+			return fmt.Errorf("delete record from archive: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Batch performs the operations on primary storage. Records must not be archived concurrently.
+func (s *store) Batch(operations []storage.Operation) error {
+	return s.store.Batch(operations)
+}
+
+// Flush flushes primary storage.
+func (s *store) Flush() error {
+	return s.store.Flush()
+}
+
+// Close closes the store of primary storage.
+func (s *store) Close() error {
+	return s.store.Close()
+}
+
+// resolve returns the value, or the recalled record if the value is a stub.
+func (s *store) resolve(key string, value []byte) ([]byte, error) {
+	if value == nil || !isStub(value) {
+		return value, nil
+	}
+
+	return s.provider.recall(s, key)
+}
+
+type iterator struct {
+	storage.Iterator
+	store *store
+}
+
+// Value returns the value of the current record, recalling it if it's archived.
+func (it *iterator) Value() ([]byte, error) {
+	value, err := it.Iterator.Value()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := it.Iterator.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	return it.store.resolve(key, value)
+}
@@ -0,0 +1,517 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archive_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/storage/archive"
+)
+
+const storeName = "kmsdb"
+
+var (
+	now   = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	keyA  = []byte("keyset A")
+	tagsA = []storage.Tag{{Name: "tag", Value: "a"}}
+)
+
+func TestProvider_Archive(t *testing.T) {
+	t.Run("Record is moved to the archive and replaced with a stub", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA, tagsA...)
+
+		archived, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+		require.True(t, archived)
+
+		require.Equal(t, "archived:2022-06-01T12:00:00Z", string(get(t, primary, "a")))
+		require.Equal(t, &archive.Record{Value: keyA, Tags: tagsA}, target.record(t, "a"))
+
+		// tags stay with the stub, so that queries still find the record
+		store, err := primary.OpenStore(storeName)
+		require.NoError(t, err)
+
+		tags, err := store.GetTags("a")
+		require.NoError(t, err)
+		require.Equal(t, tagsA, tags)
+
+		archived, err = p.Archive(storeName, "a")
+		require.NoError(t, err)
+		require.False(t, archived)
+		require.EqualValues(t, 1, target.puts)
+	})
+
+	t.Run("Fail to archive a missing record", func(t *testing.T) {
+		_, _, p := newProvider(t)
+
+		_, err := p.Archive(storeName, "missing")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+	})
+
+	t.Run("Record stays in primary storage if the archive fails", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		target.putErr = errors.New("archive unavailable")
+
+		_, err := p.Archive(storeName, "a")
+		require.EqualError(t, err, "put record to archive: archive unavailable")
+		require.Equal(t, keyA, get(t, primary, "a"))
+	})
+
+	t.Run("Record is in both tiers if the stub fails to be saved", func(t *testing.T) {
+		primary, target, _ := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		failing := &failingProvider{Provider: primary, putErr: errors.New("primary unavailable")}
+		p := archive.New(failing, target)
+
+		_, err := p.Archive(storeName, "a")
+		require.EqualError(t, err, "put stub: primary unavailable")
+		require.Equal(t, keyA, get(t, primary, "a"))
+		require.Equal(t, keyA, target.record(t, "a").Value)
+
+		// the record in primary storage is used, and archived again later
+		failing.putErr = nil
+
+		archived, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+		require.True(t, archived)
+	})
+}
+
+func TestProvider_Recall(t *testing.T) {
+	t.Run("Archived record is recalled on read", func(t *testing.T) {
+		metrics := &recallMetrics{}
+		primary, target, _ := newProvider(t)
+		p := archive.New(primary, target, archive.WithMetrics(metrics))
+
+		put(t, primary, "a", keyA, tagsA...)
+		put(t, primary, "b", []byte("keyset B"))
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		b, err := store.Get("a")
+		require.NoError(t, err)
+		require.Equal(t, keyA, b)
+
+		require.Equal(t, keyA, get(t, primary, "a"))
+		require.Equal(t, 1, metrics.recalls)
+
+		_, err = target.Target.Get(storeName, "a")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+		// recalled records are read from primary storage
+		b, err = store.Get("a")
+		require.NoError(t, err)
+		require.Equal(t, keyA, b)
+		require.Equal(t, 1, metrics.recalls)
+		require.EqualValues(t, 1, target.gets)
+
+		b, err = store.Get("b")
+		require.NoError(t, err)
+		require.Equal(t, []byte("keyset B"), b)
+	})
+
+	t.Run("Concurrent reads recall the record once", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		const readers = 20
+
+		var wg sync.WaitGroup
+
+		values := make([][]byte, readers)
+		errs := make([]error, readers)
+
+		for i := 0; i < readers; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				values[i], errs[i] = store.Get("a")
+			}(i)
+		}
+
+		wg.Wait()
+
+		for i := 0; i < readers; i++ {
+			require.NoError(t, errs[i])
+			require.Equal(t, keyA, values[i])
+		}
+
+		require.EqualValues(t, 1, target.gets)
+		require.Equal(t, keyA, get(t, primary, "a"))
+	})
+
+	t.Run("Records are recalled by bulk reads and queries", func(t *testing.T) {
+		primary, _, p := newProvider(t)
+		put(t, primary, "a", keyA, tagsA...)
+		put(t, primary, "b", []byte("keyset B"), tagsA...)
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		values, err := store.GetBulk("a", "b", "missing")
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{keyA, []byte("keyset B"), nil}, values)
+
+		_, err = p.Archive(storeName, "b")
+		require.NoError(t, err)
+
+		it, err := store.Query("tag:a")
+		require.NoError(t, err)
+
+		defer it.Close() // nolint: errcheck
+
+		var queried []string
+
+		for {
+			ok, err := it.Next()
+			require.NoError(t, err)
+
+			if !ok {
+				break
+			}
+
+			value, err := it.Value()
+			require.NoError(t, err)
+
+			queried = append(queried, string(value))
+		}
+
+		require.ElementsMatch(t, []string{"keyset A", "keyset B"}, queried)
+	})
+
+	t.Run("Record stays archived if it fails to be recalled", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		target.getErr = errors.New("archive unavailable")
+
+		_, err = store.Get("a")
+		require.EqualError(t, err, "recall record a of store kmsdb: archive unavailable")
+		require.True(t, strings.HasPrefix(string(get(t, primary, "a")), "archived:"))
+
+		target.getErr = nil
+
+		b, err := store.Get("a")
+		require.NoError(t, err)
+		require.Equal(t, keyA, b)
+	})
+
+	t.Run("Record is in both tiers if it fails to be deleted from the archive", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		target.deleteErr = errors.New("archive unavailable")
+
+		b, err := store.Get("a")
+		require.NoError(t, err)
+		require.Equal(t, keyA, b)
+		require.Equal(t, keyA, target.record(t, "a").Value)
+	})
+
+	t.Run("Fail to recall a record missing in the archive", func(t *testing.T) {
+		primary, target, p := newProvider(t)
+		put(t, primary, "a", keyA)
+
+		_, err := p.Archive(storeName, "a")
+		require.NoError(t, err)
+		require.NoError(t, target.Delete(storeName, "a"))
+
+		store, err := p.OpenStore(storeName)
+		require.NoError(t, err)
+
+		// the record isn't reported as not found: it exists, but can't be read
+		_, err = store.Get("a")
+		require.EqualError(t, err, "recall record a of store kmsdb: not found in archive")
+		require.False(t, errors.Is(err, storage.ErrDataNotFound))
+	})
+}
+
+func TestProvider_Delete(t *testing.T) {
+	primary, target, p := newProvider(t)
+	put(t, primary, "a", keyA)
+	put(t, primary, "b", []byte("keyset B"))
+
+	_, err := p.Archive(storeName, "a")
+	require.NoError(t, err)
+
+	store, err := p.OpenStore(storeName)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Delete("a"))
+	require.NoError(t, store.Delete("b"))
+	require.NoError(t, store.Delete("missing"))
+
+	_, err = store.Get("a")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	_, err = target.Get(storeName, "a")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+	require.EqualValues(t, 1, target.deletes)
+}
+
+func TestS3Target(t *testing.T) {
+	client := &s3Client{objects: map[string][]byte{}}
+	target := archive.NewS3Target(client, "bucket")
+
+	require.NoError(t, target.Put(storeName, "a", &archive.Record{Value: keyA, Tags: tagsA}))
+	require.Contains(t, client.objects, "bucket/kmsdb/a")
+
+	record, err := target.Get(storeName, "a")
+	require.NoError(t, err)
+	require.Equal(t, &archive.Record{Value: keyA, Tags: tagsA}, record)
+
+	require.NoError(t, target.Delete(storeName, "a"))
+	require.NoError(t, target.Delete(storeName, "a"))
+
+	_, err = target.Get(storeName, "a")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+
+	client.err = errors.New("s3 unavailable")
+
+	require.EqualError(t, target.Put(storeName, "a", &archive.Record{Value: keyA}), "put object: s3 unavailable")
+	require.EqualError(t, target.Delete(storeName, "a"), "delete object: s3 unavailable")
+
+	_, err = target.Get(storeName, "a")
+	require.EqualError(t, err, "get object: s3 unavailable")
+}
+
+func TestProvider_WithS3Target(t *testing.T) {
+	primary := mem.NewProvider()
+	p := archive.New(primary, archive.NewS3Target(&s3Client{objects: map[string][]byte{}}, "bucket"))
+
+	put(t, primary, "a", keyA, tagsA...)
+
+	_, err := p.Archive(storeName, "a")
+	require.NoError(t, err)
+
+	store, err := p.OpenStore(storeName)
+	require.NoError(t, err)
+
+	b, err := store.Get("a")
+	require.NoError(t, err)
+	require.Equal(t, keyA, b)
+
+	tags, err := store.GetTags("a")
+	require.NoError(t, err)
+	require.Equal(t, tagsA, tags)
+}
+
+func newProvider(t *testing.T) (storage.Provider, *countingTarget, *archive.Provider) {
+	t.Helper()
+
+	primary := mem.NewProvider()
+	target := &countingTarget{Target: archive.NewStorageTarget(mem.NewProvider())}
+
+	return primary, target, archive.New(primary, target, archive.WithClock(testutil.NewFakeClock(now)))
+}
+
+func put(t *testing.T, provider storage.Provider, key string, value []byte, tags ...storage.Tag) {
+	t.Helper()
+
+	store, err := provider.OpenStore(storeName)
+	require.NoError(t, err)
+
+	require.NoError(t, provider.SetStoreConfig(storeName, storage.StoreConfiguration{TagNames: []string{"tag"}}))
+	require.NoError(t, store.Put(key, value, tags...))
+}
+
+func get(t *testing.T, provider storage.Provider, key string) []byte {
+	t.Helper()
+
+	store, err := provider.OpenStore(storeName)
+	require.NoError(t, err)
+
+	b, err := store.Get(key)
+	require.NoError(t, err)
+
+	return b
+}
+
+// countingTarget counts calls of the target and fails them on demand.
+type countingTarget struct {
+	archive.Target
+	puts, gets, deletes       int32
+	putErr, getErr, deleteErr error
+}
+
+func (t *countingTarget) Put(storeName, key string, record *archive.Record) error {
+	atomic.AddInt32(&t.puts, 1)
+
+	if t.putErr != nil {
+		return t.putErr
+	}
+
+	return t.Target.Put(storeName, key, record)
+}
+
+func (t *countingTarget) Get(storeName, key string) (*archive.Record, error) {
+	atomic.AddInt32(&t.gets, 1)
+
+	// a slow archive, so that concurrent reads overlap
+	time.Sleep(10 * time.Millisecond)
+
+	if t.getErr != nil {
+		return nil, t.getErr
+	}
+
+	return t.Target.Get(storeName, key)
+}
+
+func (t *countingTarget) Delete(storeName, key string) error {
+	atomic.AddInt32(&t.deletes, 1)
+
+	if t.deleteErr != nil {
+		return t.deleteErr
+	}
+
+	return t.Target.Delete(storeName, key)
+}
+
+func (t *countingTarget) record(tb testing.TB, key string) *archive.Record {
+	tb.Helper()
+
+	record, err := t.Target.Get(storeName, key)
+	require.NoError(tb, err)
+
+	return record
+}
+
+type failingProvider struct {
+	storage.Provider
+	putErr error
+}
+
+func (p *failingProvider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingStore{Store: s, provider: p}, nil
+}
+
+type failingStore struct {
+	storage.Store
+	provider *failingProvider
+}
+
+func (s *failingStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if s.provider.putErr != nil {
+		return s.provider.putErr
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+type recallMetrics struct {
+	recalls int
+}
+
+func (m *recallMetrics) ArchiveRecallTime(time.Duration) {
+	m.recalls++
+}
+
+// s3Client keeps objects in memory by bucket and key.
+type s3Client struct {
+	s3iface.S3API
+	mutex   sync.Mutex
+	objects map[string][]byte
+	err     error
+}
+
+func (c *s3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.objects[*in.Bucket+"/"+*in.Key] = b
+
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (c *s3Client) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	b, ok := c.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "the specified key does not exist", nil)
+	}
+
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (c *s3Client) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.objects, *in.Bucket+"/"+*in.Key)
+
+	return &s3.DeleteObjectOutput{}, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// StorageTarget archives records in stores of a storage provider, e.g. of a separate database. Records are saved in
+// stores of the same names as in primary storage.
+type StorageTarget struct {
+	provider storage.Provider
+	mutex    sync.Mutex
+	stores   map[string]storage.Store
+}
+
+// NewStorageTarget returns a new StorageTarget that archives records with the storage provider.
+func NewStorageTarget(provider storage.Provider) *StorageTarget {
+	return &StorageTarget{provider: provider, stores: make(map[string]storage.Store)}
+}
+
+// Put saves the record with its tags.
+func (t *StorageTarget) Put(storeName, key string, record *Record) error {
+	s, err := t.openStore(storeName)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(key, record.Value, record.Tags...)
+}
+
+// Get returns the record with its tags.
+func (t *StorageTarget) Get(storeName, key string) (*Record, error) {
+	s, err := t.openStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := s.GetTags(key)
+	if err != nil {
+		return nil, fmt.Errorf("get tags: %w", err)
+	}
+
+	return &Record{Value: value, Tags: tags}, nil
+}
+
+// Delete deletes the record.
+func (t *StorageTarget) Delete(storeName, key string) error {
+	s, err := t.openStore(storeName)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Delete(key); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func (t *StorageTarget) openStore(name string) (storage.Store, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s, ok := t.stores[name]; ok {
+		return s, nil
+	}
+
+	s, err := t.provider.OpenStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("open archive store: %w", err)
+	}
+
+	t.stores[name] = s
+
+	return s, nil
+}
+
+// S3Target archives records as objects of a bucket of an S3-compatible object store. A record is saved as a JSON
+// object with its tags, named by the store name and the key.
+type S3Target struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// NewS3Target returns a new S3Target that archives records in the bucket.
+func NewS3Target(client s3iface.S3API, bucket string) *S3Target {
+	return &S3Target{client: client, bucket: bucket}
+}
+
+// Put saves the record as an object.
+func (t *S3Target) Put(storeName, key string, record *Record) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+
+	_, err = t.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(t.bucket),
+		Key:         aws.String(objectKey(storeName, key)),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+
+	return nil
+}
+
+// Get returns the record saved as an object.
+func (t *S3Target) Get(storeName, key string) (*Record, error) {
+	out, err := t.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(objectKey(storeName, key)),
+	})
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, fmt.Errorf("get object: %w", storage.ErrDataNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+
+	defer out.Body.Close() // nolint: errcheck
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read object: %w", err)
+	}
+
+	var record Record
+
+	if err = json.Unmarshal(b, &record); err != nil {
+		return nil, fmt.Errorf("unmarshal record: %w", err)
+	}
+
+	return &record, nil
+}
+
+// Delete deletes the object of the record. S3 doesn't fail to delete missing objects.
+func (t *S3Target) Delete(storeName, key string) error {
+	_, err := t.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(objectKey(storeName, key)),
+	})
+	if err != nil {
+		return fmt.Errorf("delete object: %w", err)
+	}
+
+	return nil
+}
+
+func objectKey(storeName, key string) string {
+	return storeName + "/" + key
+}
@@ -0,0 +1,454 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package redis implements a cache backed by a Redis server, so that instances of the server behind a load balancer
+// share cached items.
+//
+// The cache is an optimization: when Redis can't be reached, reads are misses and writes are dropped, so that callers
+// fall through to the origin instead of failing. After a failed call, Redis isn't called again for the retry
+// interval, so that an outage doesn't add a connection timeout to every request.
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/secrets"
+)
+
+const (
+	defaultPort          = "6379"
+	defaultTimeout       = time.Second
+	defaultRetryInterval = 5 * time.Second
+	defaultMaxIdleConns  = 16
+)
+
+var logger = log.New("storage/cache/redis")
+
+// Option configures the cache.
+type Option func(c *Cache)
+
+// WithKeyPrefix sets a prefix of keys of cached items, so that several servers can share a Redis database.
+func WithKeyPrefix(prefix string) Option {
+	return func(c *Cache) {
+		c.prefix = prefix
+	}
+}
+
+// WithTLSConfig sets the TLS configuration of connections to "rediss" URLs.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Cache) {
+		c.tlsConfig = config
+	}
+}
+
+// WithTimeout sets the timeout of connecting to Redis and of a call. Defaults to 1 second.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Cache) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetryInterval sets the interval Redis isn't called for after a failed call. Defaults to 5 seconds.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(c *Cache) {
+		c.retryInterval = interval
+	}
+}
+
+// WithClock sets the clock of the retry interval. Defaults to system time.
+func WithClock(clk clock.Clock) Option {
+	return func(c *Cache) {
+		c.clock = clk
+	}
+}
+
+// Cache is a cache of byte slices keyed by strings, backed by a Redis server. It implements the Cache interfaces of
+// the storage and Shamir cache providers.
+type Cache struct {
+	addr          string
+	username      string
+	password      *secrets.Secret
+	db            int
+	useTLS        bool
+	tlsConfig     *tls.Config
+	prefix        string
+	timeout       time.Duration
+	retryInterval time.Duration
+	clock         clock.Clock
+	idle          chan *conn
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// New returns a new Cache for the Redis URL: redis://[[user]:password@]host[:port][/db], or rediss:// for TLS.
+// The URL is a secret, as it may have the password. The password is kept as a secret and only read to authenticate
+// connections. No connection is made until the cache is used, see Ping.
+func New(rawURL *secrets.Secret, opts ...Option) (*Cache, error) {
+	var (
+		u   *url.URL
+		err error
+	)
+
+	rawURL.Use(func(value []byte) {
+		u, err = url.Parse(string(value))
+	})
+
+	if err != nil {
+		// the error of url.Parse quotes the URL with the password
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return nil, fmt.Errorf("parse url: %w", err)
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported url scheme %q: must be redis or rediss", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.New("url has no host")
+	}
+
+	c := &Cache{
+		addr:          u.Host,
+		useTLS:        u.Scheme == "rediss",
+		timeout:       defaultTimeout,
+		retryInterval: defaultRetryInterval,
+		clock:         clock.Real(),
+		idle:          make(chan *conn, defaultMaxIdleConns),
+	}
+
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	if u.User != nil {
+		c.username = u.User.Username()
+
+		if password, ok := u.User.Password(); ok {
+			c.password = secrets.New([]byte(password))
+		}
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		c.db, err = strconv.Atoi(db)
+		if err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid database number %q", db)
+		}
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.useTLS && c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return c, nil
+}
+
+// Ping checks that Redis can be reached.
+func (c *Cache) Ping() error {
+	_, err := c.do("PING")
+
+	return err
+}
+
+// Get returns the cached value of the key. Failed calls are misses.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	k, ok := key.(string)
+	if !ok {
+		return nil, false
+	}
+
+	v, err := c.call("GET", c.prefix+k)
+	if err != nil {
+		return nil, false
+	}
+
+	b, ok := v.([]byte)
+
+	return b, ok
+}
+
+// SetWithTTL caches the value of the key for the TTL, a zero TTL means the item never expires. Only byte slice values
+// with string keys are cached. The cost is ignored: Redis evicts items by its own policy. Returns false if the item
+// wasn't cached.
+func (c *Cache) SetWithTTL(key, value interface{}, _ int64, ttl time.Duration) bool {
+	k, ok := key.(string)
+	if !ok {
+		return false
+	}
+
+	b, ok := value.([]byte)
+	if !ok {
+		return false
+	}
+
+	args := []string{"SET", c.prefix + k, string(b)}
+
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+
+	_, err := c.call(args...)
+
+	return err == nil
+}
+
+// Del removes the key from the cache. A failed removal is logged: the item stays cached until it expires.
+func (c *Cache) Del(key interface{}) {
+	k, ok := key.(string)
+	if !ok {
+		return
+	}
+
+	if _, err := c.call("DEL", c.prefix+k); err != nil {
+		logger.Warnf("delete cached item: %v", err)
+	}
+}
+
+// Clear does nothing: the cache is shared with other instances, so items are left to expire.
+func (c *Cache) Clear() {}
+
+// Close closes idle connections.
+func (c *Cache) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			if err := cn.Close(); err != nil {
+				return fmt.Errorf("close connection: %w", err)
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// call runs the command unless Redis is considered down. A failure marks Redis down for the retry interval.
+func (c *Cache) call(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	down := c.clock.Now().Before(c.downUntil)
+	c.mu.Unlock()
+
+	if down {
+		return nil, errors.New("redis is unavailable")
+	}
+
+	v, err := c.do(args...)
+
+	var replyErr replyError
+
+	if err != nil && !errors.As(err, &replyErr) {
+		c.mu.Lock()
+		c.downUntil = c.clock.Now().Add(c.retryInterval)
+		c.mu.Unlock()
+
+		logger.Warnf("redis is unavailable, falling through to origin for %s: %v", c.retryInterval, err)
+	}
+
+	return v, err
+}
+
+// do runs the command on a pooled connection. Connections are discarded after network errors.
+func (c *Cache) do(args ...string) (interface{}, error) {
+	cn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := cn.do(c.timeout, args...)
+
+	var replyErr replyError
+
+	if err != nil && !errors.As(err, &replyErr) {
+		_ = cn.Close() //nolint:errcheck // the connection is broken anyway
+
+		return nil, err
+	}
+
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close() //nolint:errcheck // enough connections are idle
+	}
+
+	return v, err
+}
+
+// conn returns an idle connection or a new one, authenticated and with the database selected.
+func (c *Cache) conn() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+
+	var (
+		nc  net.Conn
+		err error
+	)
+
+	if c.useTLS {
+		nc, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", c.addr)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("connect to redis: %w", err)
+	}
+
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if !c.password.IsEmpty() {
+		args := [][]byte{[]byte("AUTH")}
+
+		if c.username != "" {
+			args = append(args, []byte(c.username))
+		}
+
+		c.password.Use(func(password []byte) {
+			_, err = cn.doBytes(c.timeout, append(args, password)...)
+		})
+
+		if err != nil {
+			_ = cn.Close() //nolint:errcheck // the connection isn't usable
+
+			return nil, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	if c.db != 0 {
+		if _, err = cn.do(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			_ = cn.Close() //nolint:errcheck // the connection isn't usable
+
+			return nil, fmt.Errorf("select database: %w", err)
+		}
+	}
+
+	return cn, nil
+}
+
+// replyError is an error reply of Redis. The connection stays usable after it.
+type replyError string
+
+func (e replyError) Error() string {
+	return "redis: " + string(e)
+}
+
+// conn is a connection speaking RESP, the Redis serialization protocol.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends the command and reads its reply: nil, a string, an int64 or a byte slice.
+func (cn *conn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	b := make([][]byte, len(args))
+
+	for i, arg := range args {
+		b[i] = []byte(arg)
+	}
+
+	return cn.doBytes(timeout, b...)
+}
+
+// doBytes is do with arguments that may be secrets: they aren't copied to strings, and the request is zeroed once
+// it's written.
+func (cn *conn) doBytes(timeout time.Duration, args ...[]byte) (interface{}, error) {
+	// deadlines of connections are in system time
+	if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+
+	req := append([]byte{'*'}, strconv.Itoa(len(args))...)
+	req = append(req, '\r', '\n')
+
+	for _, arg := range args {
+		req = append(req, '$')
+		req = append(req, strconv.Itoa(len(arg))...)
+		req = append(req, '\r', '\n')
+		req = append(req, arg...)
+		req = append(req, '\r', '\n')
+	}
+
+	_, err := cn.Conn.Write(req)
+
+	for i := range req {
+		req[i] = 0
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("write command: %w", err)
+	}
+
+	return cn.readReply()
+}
+
+func (cn *conn) readReply() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read reply: %w", err)
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("read reply: empty line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, replyError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse integer reply: %w", err)
+		}
+
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("parse bulk reply length: %w", err)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2) // with the trailing CRLF
+
+		if _, err = io.ReadFull(cn.r, b); err != nil {
+			return nil, fmt.Errorf("read bulk reply: %w", err)
+		}
+
+		return b[:n], nil
+	default:
+		return nil, fmt.Errorf("unsupported reply type %q", line[0])
+	}
+}
@@ -41,11 +41,12 @@ type Provider struct {
 }
 
 type wrappedProvider struct {
-	provider StorageProvider
-	cache    Cache
-	ttl      time.Duration
-	stores   []Store
-	mu       sync.Mutex
+	provider  StorageProvider
+	cache     Cache
+	ttl       time.Duration
+	namespace string
+	stores    []Store
+	mu        sync.Mutex
 }
 
 // Wrap adds caching support to the underlying StorageProvider.
@@ -59,14 +60,16 @@ func (p *Provider) Wrap(storageProvider StorageProvider, opts ...WrapOption) sto
 	}
 
 	return &wrappedProvider{
-		provider: storageProvider,
-		cache:    p.Cache,
-		ttl:      o.cacheTTL,
+		provider:  storageProvider,
+		cache:     p.Cache,
+		ttl:       o.cacheTTL,
+		namespace: o.namespace,
 	}
 }
 
 type wrapOptions struct {
-	cacheTTL time.Duration
+	cacheTTL  time.Duration
+	namespace string
 }
 
 // WrapOption configures wrapped provider.
@@ -79,6 +82,14 @@ func WithCacheTTL(ttl time.Duration) WrapOption {
 	}
 }
 
+// WithNamespace sets a namespace of cache items, e.g. the ID of a key store, so that items of providers wrapped for
+// different namespaces don't collide in a shared cache.
+func WithNamespace(namespace string) WrapOption {
+	return func(o *wrapOptions) {
+		o.namespace = namespace
+	}
+}
+
 // OpenStore opens a Store that supports caching.
 func (p *wrappedProvider) OpenStore(name string) (Store, error) {
 	store, err := p.provider.OpenStore(name)
@@ -86,9 +97,14 @@ func (p *wrappedProvider) OpenStore(name string) (Store, error) {
 		return nil, fmt.Errorf("open store: %w", err)
 	}
 
+	namespace := name
+	if p.namespace != "" {
+		namespace = p.namespace + "/" + name
+	}
+
 	ws := &wrappedStore{
 		store:      store,
-		namespace:  name,
+		namespace:  namespace,
 		cache:      p.cache,
 		ttl:        p.ttl,
 		cachedKeys: make([]string, 0),
@@ -189,6 +189,31 @@ func TestWrappedStore_Get(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []byte("test value"), v)
 	})
+
+	t.Run("Namespaced cache keys", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		c := NewMockCache(ctrl)
+		c.EXPECT().Get("keystore1/test_key").Return(nil, false).Times(1)
+		c.EXPECT().SetWithTTL("keystore1/test_key", []byte("test value"), int64(1), time.Minute).Times(1)
+
+		cacheProvider := cache.Provider{Cache: c}
+
+		s := NewMockStore(ctrl)
+		s.EXPECT().Get("key").Return([]byte("test value"), nil)
+
+		p := NewMockStorageProvider(ctrl)
+		p.EXPECT().OpenStore("test").Return(s, nil).Times(1)
+
+		wp := cacheProvider.Wrap(p, cache.WithCacheTTL(time.Minute), cache.WithNamespace("keystore1"))
+
+		store, err := wp.OpenStore("test")
+		require.NoError(t, err)
+
+		v, err := store.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("test value"), v)
+	})
 }
 
 func TestWrappedStore_Close(t *testing.T) {
@@ -6,12 +6,17 @@ SPDX-License-Identifier: Apache-2.0
 
 package metrics
 
-import "github.com/hyperledger/aries-framework-go/spi/storage"
+import (
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/reqlog"
+)
 
 // ProviderWrapper wrap aries provider.
 type ProviderWrapper struct {
-	p      storage.Provider
-	dbType string
+	p         storage.Provider
+	dbType    string
+	logFields reqlog.Fields
 }
 
 // Wrap return new store provider metrics.
@@ -19,6 +24,12 @@ func Wrap(p storage.Provider, dbType string) *ProviderWrapper {
 	return &ProviderWrapper{p: p, dbType: dbType}
 }
 
+// WithLogFields returns a copy of the provider whose stores log failed operations with the fields, e.g. of the
+// request the provider was opened for.
+func (prov *ProviderWrapper) WithLogFields(fields reqlog.Fields) *ProviderWrapper {
+	return &ProviderWrapper{p: prov.p, dbType: prov.dbType, logFields: fields}
+}
+
 // OpenStore open store.
 func (prov *ProviderWrapper) OpenStore(name string) (storage.Store, error) {
 	s, err := prov.p.OpenStore(name)
@@ -26,7 +37,10 @@ func (prov *ProviderWrapper) OpenStore(name string) (storage.Store, error) {
 		return nil, err
 	}
 
-	return NewStore(s, prov.dbType), nil
+	store := NewStore(s, prov.dbType)
+	store.logFields = prov.logFields
+
+	return store, nil
 }
 
 // SetStoreConfig set store config.
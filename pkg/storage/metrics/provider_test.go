@@ -7,12 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package metrics_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"testing"
 
 	ariesmockstorage "github.com/hyperledger/aries-framework-go/component/storageutil/mock"
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+	logspi "github.com/hyperledger/aries-framework-go/spi/log"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/stretchr/testify/require"
 
+	"github.com/trustbloc/kms/pkg/reqlog"
 	"github.com/trustbloc/kms/pkg/storage/metrics"
 )
 
@@ -42,3 +48,33 @@ func TestProvider(t *testing.T) {
 		require.NoError(t, s.Close())
 	})
 }
+
+func TestProvider_WithLogFields(t *testing.T) {
+	var output bytes.Buffer
+
+	log.Initialize(reqlog.NewJSONProvider(&output))
+	log.SetLevel("storage", logspi.DEBUG)
+
+	p := metrics.Wrap(&ariesmockstorage.Provider{
+		OpenStoreReturn: &ariesmockstorage.Store{ErrPut: errors.New("put error"), ErrGet: storage.ErrDataNotFound},
+	}, "CouchDB").WithLogFields(reqlog.Fields{KeyStoreID: "ks", Controller: "hash"})
+
+	s, err := p.OpenStore("s1")
+	require.NoError(t, err)
+
+	require.Error(t, s.Put("k1", []byte("v1")))
+
+	var line map[string]string
+
+	require.NoError(t, json.Unmarshal(output.Bytes(), &line))
+	require.Equal(t, "ks", line[reqlog.FieldKeyStoreID])
+	require.Equal(t, "hash", line[reqlog.FieldController])
+	require.Contains(t, line["msg"], "put error")
+
+	// missing data isn't a failure
+	output.Reset()
+
+	_, err = s.Get("k1")
+	require.ErrorIs(t, err, storage.ErrDataNotFound)
+	require.Empty(t, output.String())
+}
@@ -7,18 +7,23 @@ SPDX-License-Identifier: Apache-2.0
 package metrics
 
 import (
+	"errors"
 	"time"
 
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 
 	"github.com/trustbloc/kms/pkg/metrics"
+	"github.com/trustbloc/kms/pkg/reqlog"
 )
 
+var logger = reqlog.New("storage")
+
 // StoreWrapper wrap aries store.
 type StoreWrapper struct {
-	s      storage.Store
-	m      metricsProvider
-	dbType string
+	s         storage.Store
+	m         metricsProvider
+	dbType    string
+	logFields reqlog.Fields
 }
 
 type metricsProvider interface {
@@ -41,7 +46,7 @@ func (store *StoreWrapper) Put(key string, value []byte, tags ...storage.Tag) er
 	start := time.Now()
 	defer func() { store.m.DBPutTime(store.dbType, time.Since(start)) }()
 
-	return store.s.Put(key, value, tags...)
+	return store.logError("put", key, store.s.Put(key, value, tags...))
 }
 
 // Get data.
@@ -49,7 +54,9 @@ func (store *StoreWrapper) Get(key string) ([]byte, error) {
 	start := time.Now()
 	defer func() { store.m.DBGetTime(store.dbType, time.Since(start)) }()
 
-	return store.s.Get(key)
+	value, err := store.s.Get(key)
+
+	return value, store.logError("get", key, err)
 }
 
 // GetTags get tags.
@@ -81,7 +88,7 @@ func (store *StoreWrapper) Delete(key string) error {
 	start := time.Now()
 	defer func() { store.m.DBDeleteTime(store.dbType, time.Since(start)) }()
 
-	return store.s.Delete(key)
+	return store.logError("delete", key, store.s.Delete(key))
 }
 
 // Batch data.
@@ -89,7 +96,7 @@ func (store *StoreWrapper) Batch(operations []storage.Operation) error {
 	start := time.Now()
 	defer func() { store.m.DBBatchTime(store.dbType, time.Since(start)) }()
 
-	return store.s.Batch(operations)
+	return store.logError("batch", "", store.s.Batch(operations))
 }
 
 // Flush data.
@@ -101,3 +108,13 @@ func (store *StoreWrapper) Flush() error {
 func (store *StoreWrapper) Close() error {
 	return store.s.Close()
 }
+
+// logError logs a failed operation at debug level with the log fields of the store, and returns the error. Keys that
+// aren't found are not logged.
+func (store *StoreWrapper) logError(op, key string, err error) error {
+	if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		logger.With(store.logFields).Debugf("%s %s %q failed: %v", store.dbType, op, key, err)
+	}
+
+	return err
+}
@@ -0,0 +1,668 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package postgres implements a storage provider backed by a PostgreSQL database.
+//
+// Records of all stores are kept in one table, keyed by the store name (with the prefix of the provider) and the key.
+// Tags are kept in a JSONB column, indexed with GIN, so that tag queries don't scan the table. The schema is created
+// and migrated when the provider is created.
+//
+// The package uses database/sql and doesn't link a driver: binaries must import one that registers itself as
+// DriverName (e.g. github.com/lib/pq).
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// DriverName is the name of the database/sql driver the provider is used with.
+const DriverName = "postgres"
+
+const (
+	defaultTimeout  = 10 * time.Second
+	defaultPageSize = 25
+)
+
+// DriverRegistered reports whether a driver is registered as DriverName, i.e. whether the binary links one.
+func DriverRegistered() bool {
+	for _, name := range sql.Drivers() {
+		if name == DriverName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Option configures the provider.
+type Option func(p *Provider)
+
+// WithDBPrefix sets a prefix of store names, so that several servers can share a database.
+func WithDBPrefix(prefix string) Option {
+	return func(p *Provider) {
+		p.prefix = prefix
+	}
+}
+
+// WithTimeout sets the timeout of database calls. Defaults to 10 seconds.
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Provider) {
+		p.timeout = timeout
+	}
+}
+
+// Provider is a storage provider backed by a PostgreSQL database.
+type Provider struct {
+	db      *sql.DB
+	prefix  string
+	timeout time.Duration
+	stmts   *statements
+
+	mutex      sync.RWMutex
+	openStores map[string]*store
+}
+
+// NewProvider returns a new Provider that stores records in the database. The schema is migrated to the latest
+// version, and the statements of store operations are prepared. The provider takes ownership of db, Close closes it.
+func NewProvider(db *sql.DB, opts ...Option) (*Provider, error) {
+	p := &Provider{
+		db:         db,
+		timeout:    defaultTimeout,
+		openStores: make(map[string]*store),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	if err := migrate(ctx, db); err != nil {
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
+
+	stmts, err := prepareStatements(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	p.stmts = stmts
+
+	return p, nil
+}
+
+// OpenStore opens the store with the name. Store names are case-insensitive.
+func (p *Provider) OpenStore(name string) (storage.Store, error) {
+	if name == "" {
+		return nil, errors.New("store name cannot be empty")
+	}
+
+	name = strings.ToLower(p.prefix + name)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if s, ok := p.openStores[name]; ok {
+		return s, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// the store is recorded, so that GetStoreConfig finds stores without data
+	if _, err := p.stmts.createStore.ExecContext(ctx, name); err != nil {
+		return nil, fmt.Errorf("create store %s: %w", name, err)
+	}
+
+	s := &store{name: name, provider: p}
+	p.openStores[name] = s
+
+	return s, nil
+}
+
+// SetStoreConfig saves the configuration of the store. Tags of all stores are indexed, so the tag names are only
+// recorded to be returned by GetStoreConfig.
+func (p *Provider) SetStoreConfig(name string, config storage.StoreConfiguration) error {
+	if name == "" {
+		return errors.New("store name cannot be empty")
+	}
+
+	for _, tagName := range config.TagNames {
+		if strings.Contains(tagName, ":") {
+			return fmt.Errorf("tag name %q cannot contain ':'", tagName)
+		}
+	}
+
+	name = strings.ToLower(p.prefix + name)
+
+	p.mutex.RLock()
+	_, ok := p.openStores[name]
+	p.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("store %s: %w", name, storage.ErrStoreNotFound)
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal store config: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	if _, err = p.stmts.setStoreConfig.ExecContext(ctx, name, string(b)); err != nil {
+		return fmt.Errorf("set config of store %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetStoreConfig returns the configuration of the store. It fails with ErrStoreNotFound if the store was never opened.
+func (p *Provider) GetStoreConfig(name string) (storage.StoreConfiguration, error) {
+	if name == "" {
+		return storage.StoreConfiguration{}, errors.New("store name cannot be empty")
+	}
+
+	name = strings.ToLower(p.prefix + name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	var b []byte
+
+	err := p.stmts.getStoreConfig.QueryRowContext(ctx, name).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.StoreConfiguration{}, fmt.Errorf("store %s: %w", name, storage.ErrStoreNotFound)
+	}
+
+	if err != nil {
+		return storage.StoreConfiguration{}, fmt.Errorf("get config of store %s: %w", name, err)
+	}
+
+	var config storage.StoreConfiguration
+
+	if err = json.Unmarshal(b, &config); err != nil {
+		return storage.StoreConfiguration{}, fmt.Errorf("unmarshal store config: %w", err)
+	}
+
+	return config, nil
+}
+
+// GetOpenStores returns the stores opened with the provider.
+func (p *Provider) GetOpenStores() []storage.Store {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	stores := make([]storage.Store, 0, len(p.openStores))
+
+	for _, s := range p.openStores {
+		stores = append(stores, s)
+	}
+
+	return stores
+}
+
+// Close closes the stores, the prepared statements and the database.
+func (p *Provider) Close() error {
+	p.mutex.Lock()
+	p.openStores = make(map[string]*store)
+	p.mutex.Unlock()
+
+	if err := p.stmts.close(); err != nil {
+		return err
+	}
+
+	return p.db.Close()
+}
+
+func (p *Provider) closeStore(name string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.openStores, name)
+}
+
+type store struct {
+	name     string
+	provider *Provider
+}
+
+func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
+	if err := validatePut(key, value, tags); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	return s.put(ctx, s.provider.stmts.put, key, value, tags)
+}
+
+func (s *store) put(ctx context.Context, stmt *sql.Stmt, key string, value []byte, tags []storage.Tag) error {
+	b, err := marshalTags(tags)
+	if err != nil {
+		return err
+	}
+
+	if _, err = stmt.ExecContext(ctx, s.name, key, value, b); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *store) Get(key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("key is mandatory")
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	var value []byte
+
+	err := s.provider.stmts.get.QueryRowContext(ctx, s.name, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("key %s: %w", key, storage.ErrDataNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	return value, nil
+}
+
+func (s *store) GetTags(key string) ([]storage.Tag, error) {
+	if key == "" {
+		return nil, errors.New("key is mandatory")
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	var b []byte
+
+	err := s.provider.stmts.getTags.QueryRowContext(ctx, s.name, key).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("key %s: %w", key, storage.ErrDataNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get tags of %s: %w", key, err)
+	}
+
+	return unmarshalTags(b)
+}
+
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keys are mandatory")
+	}
+
+	values := make([][]byte, len(keys))
+
+	for i, key := range keys {
+		value, err := s.Get(key)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	opts := storage.QueryOptions{PageSize: defaultPageSize}
+
+	for _, option := range options {
+		option(&opts)
+	}
+
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	q, err := buildQuery(expression, opts.SortOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterator{
+		store:    s,
+		query:    q,
+		pageSize: opts.PageSize,
+		offset:   opts.InitialPageNum * opts.PageSize,
+		current:  -1,
+	}, nil
+}
+
+func (s *store) Delete(key string) error {
+	if key == "" {
+		return errors.New("key is mandatory")
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	if _, err := s.provider.stmts.delete.ExecContext(ctx, s.name, key); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Batch performs the operations in a transaction, so that either all of them or none are applied.
+func (s *store) Batch(operations []storage.Operation) error {
+	if len(operations) == 0 {
+		return errors.New("batch requires at least one operation")
+	}
+
+	for _, op := range operations {
+		if op.Key == "" {
+			return errors.New("key is mandatory")
+		}
+
+		if op.Value != nil {
+			if err := validatePut(op.Key, op.Value, op.Tags); err != nil {
+				return err
+			}
+		}
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	tx, err := s.provider.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if err = s.batch(ctx, tx, operations); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback: %s)", err, rollbackErr.Error())
+		}
+
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *store) batch(ctx context.Context, tx *sql.Tx, operations []storage.Operation) error {
+	stmts := s.provider.stmts
+
+	for _, op := range operations {
+		if op.Value == nil {
+			if _, err := tx.StmtContext(ctx, stmts.delete).ExecContext(ctx, s.name, op.Key); err != nil {
+				return fmt.Errorf("delete %s: %w", op.Key, err)
+			}
+
+			continue
+		}
+
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			if err := s.put(ctx, tx.StmtContext(ctx, stmts.put), op.Key, op.Value, op.Tags); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		b, err := marshalTags(op.Tags)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.StmtContext(ctx, stmts.insert).ExecContext(ctx, s.name, op.Key, op.Value, b)
+		if err != nil {
+			return fmt.Errorf("insert %s: %w", op.Key, err)
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("key %s: %w", op.Key, storage.ErrDuplicateKey)
+		}
+	}
+
+	return nil
+}
+
+// Flush is a no-op, operations aren't queued.
+func (s *store) Flush() error {
+	return nil
+}
+
+func (s *store) Close() error {
+	s.provider.closeStore(s.name)
+
+	return nil
+}
+
+func (s *store) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.provider.timeout)
+}
+
+type row struct {
+	key   string
+	value []byte
+	tags  []byte
+}
+
+type iterator struct {
+	store    *store
+	query    *query
+	pageSize int
+	offset   int
+	page     []row
+	current  int
+	done     bool
+}
+
+func (it *iterator) Next() (bool, error) {
+	it.current++
+
+	if it.current < len(it.page) {
+		return true, nil
+	}
+
+	// a page shorter than the page size is the last one
+	if it.done || (it.page != nil && len(it.page) < it.pageSize) {
+		it.done = true
+
+		return false, nil
+	}
+
+	if err := it.fetch(); err != nil {
+		return false, err
+	}
+
+	it.current = 0
+
+	if len(it.page) == 0 {
+		it.done = true
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (it *iterator) fetch() error {
+	stmt, err := it.store.provider.stmts.prepared(it.query.selectSQL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := it.store.context()
+	defer cancel()
+
+	rows, err := stmt.QueryContext(ctx, it.query.selectArgs(it.store.name, it.pageSize, it.offset)...)
+	if err != nil {
+		return fmt.Errorf("query records: %w", err)
+	}
+
+	defer rows.Close() // nolint: errcheck
+
+	page := make([]row, 0, it.pageSize)
+
+	for rows.Next() {
+		var r row
+
+		if err = rows.Scan(&r.key, &r.value, &r.tags); err != nil {
+			return fmt.Errorf("scan record: %w", err)
+		}
+
+		page = append(page, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("query records: %w", err)
+	}
+
+	it.page = page
+	it.offset += len(page)
+
+	return nil
+}
+
+func (it *iterator) entry() (*row, error) {
+	if it.current < 0 || it.current >= len(it.page) {
+		return nil, errors.New("iterator is exhausted")
+	}
+
+	return &it.page[it.current], nil
+}
+
+func (it *iterator) Key() (string, error) {
+	r, err := it.entry()
+	if err != nil {
+		return "", err
+	}
+
+	return r.key, nil
+}
+
+func (it *iterator) Value() ([]byte, error) {
+	r, err := it.entry()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.value, nil
+}
+
+func (it *iterator) Tags() ([]storage.Tag, error) {
+	r, err := it.entry()
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalTags(r.tags)
+}
+
+func (it *iterator) TotalItems() (int, error) {
+	stmt, err := it.store.provider.stmts.prepared(it.query.countSQL)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := it.store.context()
+	defer cancel()
+
+	var total int
+
+	if err = stmt.QueryRowContext(ctx, it.query.countArgs(it.store.name)...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("count records: %w", err)
+	}
+
+	return total, nil
+}
+
+func (it *iterator) Close() error {
+	it.page = nil
+	it.done = true
+
+	return nil
+}
+
+func validatePut(key string, value []byte, tags []storage.Tag) error {
+	if key == "" {
+		return errors.New("key is mandatory")
+	}
+
+	if value == nil {
+		return errors.New("value is mandatory")
+	}
+
+	names := make(map[string]struct{}, len(tags))
+
+	for _, tag := range tags {
+		if strings.Contains(tag.Name, ":") || strings.Contains(tag.Value, ":") {
+			return fmt.Errorf("tag %s:%s: names and values cannot contain ':'", tag.Name, tag.Value)
+		}
+
+		if _, ok := names[tag.Name]; ok {
+			return fmt.Errorf("tag name %s is used more than once", tag.Name)
+		}
+
+		names[tag.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// marshalTags returns tags as a JSON object of tag names to values. JSON is passed as a string, as drivers send
+// []byte as bytea.
+func marshalTags(tags []storage.Tag) (string, error) {
+	m := make(map[string]string, len(tags))
+
+	for _, tag := range tags {
+		m[tag.Name] = tag.Value
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("marshal tags: %w", err)
+	}
+
+	return string(b), nil
+}
+
+func unmarshalTags(b []byte) ([]storage.Tag, error) {
+	var m map[string]string
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal tags: %w", err)
+	}
+
+	tags := make([]storage.Tag, 0, len(m))
+
+	for name, value := range m {
+		tags = append(tags, storage.Tag{Name: name, Value: value})
+	}
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	return tags, nil
+}
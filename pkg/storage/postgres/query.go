@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+)
+
+// numericPattern matches tag values compared as numbers by range criteria and sorting.
+const numericPattern = `'^-?[0-9]+(\.[0-9]+)?$'`
+
+// query is SQL of a store query. Tag names and values of the expression are parameters, so that queries of the same
+// structure share prepared statements.
+type query struct {
+	selectSQL string
+	countSQL  string
+	params    []interface{} // parameters of the expression, following the store name
+	sortTag   string
+}
+
+// selectArgs returns arguments of selectSQL.
+func (q *query) selectArgs(storeName string, limit, offset int) []interface{} {
+	args := q.countArgs(storeName)
+
+	if q.sortTag != "" {
+		args = append(args, q.sortTag)
+	}
+
+	return append(args, limit, offset)
+}
+
+// countArgs returns arguments of countSQL.
+func (q *query) countArgs(storeName string) []interface{} {
+	return append([]interface{}{storeName}, q.params...)
+}
+
+// buildQuery returns SQL of the query expression. Expressions are criteria joined with "&&" and "||", ANDs are
+// evaluated before ORs. A criterion is a tag name (records with the tag), TagName:TagValue (records with the tag
+// value), or a tag name compared with a number by <, <=, > or >= (records with numeric tag values in the range).
+func buildQuery(expression string, sortOptions *storage.SortOptions) (*query, error) {
+	if expression == "" {
+		return nil, errors.New("invalid expression: expression cannot be empty")
+	}
+
+	q := &query{}
+
+	var orGroups []string
+
+	for _, group := range strings.Split(expression, "||") {
+		var criteria []string
+
+		for _, criterion := range strings.Split(group, "&&") {
+			cond, err := q.condition(criterion)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expression %q: %w", expression, err)
+			}
+
+			criteria = append(criteria, cond)
+		}
+
+		orGroups = append(orGroups, "("+strings.Join(criteria, " AND ")+")")
+	}
+
+	where := "WHERE store = $1 AND (" + strings.Join(orGroups, " OR ") + ")"
+
+	order := "ORDER BY key"
+
+	if sortOptions != nil {
+		if sortOptions.TagName == "" {
+			return nil, errors.New("invalid sort options: tag name cannot be empty")
+		}
+
+		q.sortTag = sortOptions.TagName
+
+		dir := "ASC"
+		if sortOptions.Order == storage.SortDescending {
+			dir = "DESC"
+		}
+
+		// numeric values are sorted by value, others lexicographically
+		tag := fmt.Sprintf("tags->>$%d", len(q.params)+2) //nolint:gomnd
+		order = fmt.Sprintf("ORDER BY CASE WHEN %[1]s ~ %[2]s THEN (%[1]s)::numeric END %[3]s, %[1]s %[3]s, key",
+			tag, numericPattern, dir)
+	}
+
+	next := len(q.params) + 2 //nolint:gomnd
+	if q.sortTag != "" {
+		next++
+	}
+
+	q.selectSQL = fmt.Sprintf("SELECT key, value, tags FROM kms_records %s %s LIMIT $%d OFFSET $%d", where, order,
+		next, next+1)
+	q.countSQL = "SELECT COUNT(*) FROM kms_records " + where
+
+	return q, nil
+}
+
+// condition returns SQL of the criterion, adding its tag name and value to the parameters.
+func (q *query) condition(criterion string) (string, error) {
+	i := strings.IndexAny(criterion, ":<>")
+	if i < 0 {
+		if criterion == "" {
+			return "", errors.New("criterion cannot be empty")
+		}
+
+		return fmt.Sprintf("tags ? %s", q.param(criterion)), nil
+	}
+
+	name, op, value := criterion[:i], criterion[i:i+1], criterion[i+1:]
+	if name == "" {
+		return "", fmt.Errorf("criterion %q has no tag name", criterion)
+	}
+
+	if op == ":" {
+		return fmt.Sprintf("tags @> jsonb_build_object(%s::text, %s::text)", q.param(name), q.param(value)), nil
+	}
+
+	if strings.HasPrefix(value, "=") {
+		op, value = op+"=", value[1:]
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return "", fmt.Errorf("criterion %q compares with a value that isn't a number", criterion)
+	}
+
+	tag := "tags->>" + q.param(name)
+
+	// the cast is guarded by the pattern, so that records with non-numeric values don't fail the query
+	return fmt.Sprintf("CASE WHEN %[1]s ~ %[2]s THEN (%[1]s)::numeric %[3]s %[4]s::numeric ELSE false END",
+		tag, numericPattern, op, q.param(value)), nil
+}
+
+// param adds the value to the parameters and returns its placeholder.
+func (q *query) param(value string) string {
+	q.params = append(q.params, value)
+
+	return fmt.Sprintf("$%d", len(q.params)+1)
+}
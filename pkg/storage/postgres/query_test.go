@@ -0,0 +1,67 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package postgres //nolint:testpackage
+
+import (
+	"testing"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQuery(t *testing.T) {
+	t.Run("Tag name", func(t *testing.T) {
+		q, err := buildQuery("controller_hash", nil)
+		require.NoError(t, err)
+		require.Equal(t, "SELECT key, value, tags FROM kms_records WHERE store = $1 AND ((tags ? $2)) ORDER BY key "+
+			"LIMIT $3 OFFSET $4", q.selectSQL)
+		require.Equal(t, "SELECT COUNT(*) FROM kms_records WHERE store = $1 AND ((tags ? $2))", q.countSQL)
+		require.Equal(t, []interface{}{"store", "controller_hash", 25, 50}, q.selectArgs("store", 25, 50))
+	})
+
+	t.Run("Criteria share statements regardless of tag names and values", func(t *testing.T) {
+		q1, err := buildQuery("a:1&&b<=2||c", nil)
+		require.NoError(t, err)
+
+		q2, err := buildQuery("x:y&&expires_at<=1654084800||z", nil)
+		require.NoError(t, err)
+
+		require.Equal(t, q1.selectSQL, q2.selectSQL)
+		require.Equal(t, []interface{}{"store", "x", "y", "expires_at", "1654084800", "z"}, q2.countArgs("store"))
+		require.Contains(t, q2.countSQL, "((tags @> jsonb_build_object($2::text, $3::text) AND CASE WHEN "+
+			"tags->>$4 ~ '^-?[0-9]+(\\.[0-9]+)?$' THEN (tags->>$4)::numeric <= $5::numeric ELSE false END) OR "+
+			"(tags ? $6))")
+	})
+
+	t.Run("Range operators", func(t *testing.T) {
+		for _, op := range []string{"<", "<=", ">", ">="} {
+			q, err := buildQuery("n"+op+"-1.5", nil)
+			require.NoError(t, err)
+			require.Contains(t, q.countSQL, ")::numeric "+op+" $3::numeric")
+			require.Equal(t, []interface{}{"store", "n", "-1.5"}, q.countArgs("store"))
+		}
+	})
+
+	t.Run("Sort by tag", func(t *testing.T) {
+		q, err := buildQuery("a:b", &storage.SortOptions{Order: storage.SortDescending, TagName: "n"})
+		require.NoError(t, err)
+		require.Contains(t, q.selectSQL, "ORDER BY CASE WHEN tags->>$4 ~ '^-?[0-9]+(\\.[0-9]+)?$' THEN "+
+			"(tags->>$4)::numeric END DESC, tags->>$4 DESC, key LIMIT $5 OFFSET $6")
+		require.Equal(t, []interface{}{"store", "a", "b", "n", 10, 0}, q.selectArgs("store", 10, 0))
+		require.Equal(t, []interface{}{"store", "a", "b"}, q.countArgs("store"))
+	})
+
+	t.Run("Invalid expressions", func(t *testing.T) {
+		for _, expr := range []string{"", "a&&", "||a", ":b", "a<b", "a>=", "a<=1&&<2"} {
+			_, err := buildQuery(expr, nil)
+			require.Error(t, err, expr)
+		}
+
+		_, err := buildQuery("a", &storage.SortOptions{})
+		require.EqualError(t, err, "invalid sort options: tag name cannot be empty")
+	})
+}
@@ -0,0 +1,195 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// migrationLockID is the key of the advisory lock that serializes migrations of servers started at the same time.
+const migrationLockID = 0x6b6d73 // "kms"
+
+// migrations are applied in order, each once. Applied migrations must not be changed, new ones are appended.
+var migrations = []string{ //nolint:gochecknoglobals
+	// 1: stores and their records. Tags are a JSON object of tag names to values.
+	`CREATE TABLE kms_stores (
+		name TEXT PRIMARY KEY,
+		config JSONB NOT NULL DEFAULT '{}'::jsonb
+	);
+	CREATE TABLE kms_records (
+		store TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value BYTEA NOT NULL,
+		tags JSONB NOT NULL DEFAULT '{}'::jsonb,
+		PRIMARY KEY (store, key)
+	);
+	CREATE INDEX kms_records_tags ON kms_records USING GIN (tags);`,
+}
+
+// migrate applies migrations that weren't applied to the database yet.
+func migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	if err = applyMigrations(ctx, tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback: %s)", err, rollbackErr.Error())
+		}
+
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func applyMigrations(ctx context.Context, tx *sql.Tx) error {
+	// the lock is released with the end of the transaction
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+
+	_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS kms_schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("create migrations table: %w", err)
+	}
+
+	var version int
+
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM kms_schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("get schema version: %w", err)
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest supported version %d", version,
+			len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err = tx.ExecContext(ctx, migrations[i]); err != nil {
+			return fmt.Errorf("apply migration %d: %w", i+1, err)
+		}
+
+		if _, err = tx.ExecContext(ctx, `INSERT INTO kms_schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("record migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// statements are prepared once per provider and reused by all stores. Statements of queries depend on the shape of
+// the query expression, so they are prepared on first use.
+type statements struct {
+	db *sql.DB
+
+	createStore    *sql.Stmt
+	setStoreConfig *sql.Stmt
+	getStoreConfig *sql.Stmt
+	put            *sql.Stmt
+	insert         *sql.Stmt
+	get            *sql.Stmt
+	getTags        *sql.Stmt
+	delete         *sql.Stmt
+
+	mutex   sync.Mutex
+	queries map[string]*sql.Stmt
+}
+
+func prepareStatements(ctx context.Context, db *sql.DB) (*statements, error) {
+	s := &statements{db: db, queries: make(map[string]*sql.Stmt)}
+
+	for _, stmt := range []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&s.createStore, `INSERT INTO kms_stores (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`},
+		{&s.setStoreConfig, `INSERT INTO kms_stores (name, config) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET config = EXCLUDED.config`},
+		{&s.getStoreConfig, `SELECT config FROM kms_stores WHERE name = $1`},
+		{&s.put, `INSERT INTO kms_records (store, key, value, tags) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (store, key) DO UPDATE SET value = EXCLUDED.value, tags = EXCLUDED.tags`},
+		{&s.insert, `INSERT INTO kms_records (store, key, value, tags) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (store, key) DO NOTHING`},
+		{&s.get, `SELECT value FROM kms_records WHERE store = $1 AND key = $2`},
+		{&s.getTags, `SELECT tags FROM kms_records WHERE store = $1 AND key = $2`},
+		{&s.delete, `DELETE FROM kms_records WHERE store = $1 AND key = $2`},
+	} {
+		prepared, err := db.PrepareContext(ctx, stmt.query)
+		if err != nil {
+			s.close() // nolint: errcheck,gosec
+
+			return nil, fmt.Errorf("prepare statement: %w", err)
+		}
+
+		*stmt.dst = prepared
+	}
+
+	return s, nil
+}
+
+// prepared returns the prepared statement of the query, preparing it on first use. Query SQL depends only on the
+// structure of the expression, tag names and values are parameters, so the number of statements is small.
+func (s *statements) prepared(query string) (*sql.Stmt, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stmt, ok := s.queries[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare query: %w", err)
+	}
+
+	s.queries[query] = stmt
+
+	return stmt, nil
+}
+
+func (s *statements) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stmts := []*sql.Stmt{
+		s.createStore, s.setStoreConfig, s.getStoreConfig, s.put, s.insert, s.get, s.getTags, s.delete,
+	}
+
+	for _, stmt := range s.queries {
+		stmts = append(stmts, stmt)
+	}
+
+	s.queries = make(map[string]*sql.Stmt)
+
+	// all statements are closed, the first error is returned
+	var firstErr error
+
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("close statement: %w", err)
+		}
+	}
+
+	return firstErr
+}
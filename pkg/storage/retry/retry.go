@@ -0,0 +1,241 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package retry implements a storage provider that retries store operations that failed with transient errors, e.g.
+// connection resets and primary elections of a MongoDB replica set, with exponential backoff. Which errors are
+// transient is decided by a classifier of the database, other errors are returned at once.
+package retry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/metrics"
+)
+
+// Defaults of the options.
+const (
+	DefaultMaxRetries      = 3
+	DefaultInitialInterval = 50 * time.Millisecond
+	DefaultMaxInterval     = time.Second
+)
+
+// Option configures the provider.
+type Option func(p *Provider)
+
+// WithMaxRetries sets the number of retries of an operation. Defaults to DefaultMaxRetries, zero disables retries.
+func WithMaxRetries(n int) Option {
+	return func(p *Provider) {
+		p.maxRetries = n
+	}
+}
+
+// WithBackoff sets the interval before the first retry, doubled for each next retry up to the max interval. Defaults to
+// DefaultInitialInterval and DefaultMaxInterval.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(p *Provider) {
+		p.initialInterval = initial
+		p.maxInterval = max
+	}
+}
+
+// Provider is a storage provider whose stores retry operations that failed with transient errors.
+type Provider struct {
+	storage.Provider
+	dbType          string
+	isTransient     func(error) bool
+	maxRetries      int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+}
+
+// Wrap returns a Provider that retries operations of the stores of the provider failed with errors the classifier
+// reports as transient. Retries are counted in metrics by the database type, e.g. "MongoDB".
+func Wrap(p storage.Provider, dbType string, isTransient func(error) bool, opts ...Option) *Provider {
+	rp := &Provider{
+		Provider:        p,
+		dbType:          dbType,
+		isTransient:     isTransient,
+		maxRetries:      DefaultMaxRetries,
+		initialInterval: DefaultInitialInterval,
+		maxInterval:     DefaultMaxInterval,
+	}
+
+	for _, opt := range opts {
+		opt(rp)
+	}
+
+	return rp
+}
+
+// OpenStore opens the store, retrying transient errors.
+func (p *Provider) OpenStore(name string) (storage.Store, error) {
+	var s storage.Store
+
+	err := p.do(func() error {
+		var err error
+
+		s, err = p.Provider.OpenStore(name)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{Store: s, provider: p}, nil
+}
+
+// SetStoreConfig sets the store configuration, retrying transient errors.
+func (p *Provider) SetStoreConfig(name string, config storage.StoreConfiguration) error {
+	return p.do(func() error {
+		return p.Provider.SetStoreConfig(name, config)
+	})
+}
+
+// GetStoreConfig gets the store configuration, retrying transient errors.
+func (p *Provider) GetStoreConfig(name string) (storage.StoreConfiguration, error) {
+	var config storage.StoreConfiguration
+
+	err := p.do(func() error {
+		var err error
+
+		config, err = p.Provider.GetStoreConfig(name)
+
+		return err
+	})
+
+	return config, err
+}
+
+// do runs the operation, retrying it while it fails with transient errors. A duplicate key is never retried, even if
+// the error is also labeled retryable: after a retry it can't be told whether the key was inserted by the failed
+// attempt or by a concurrent writer, so it is returned to the caller instead of being counted as a success.
+func (p *Provider) do(op func() error) error {
+	if p.maxRetries <= 0 {
+		return op()
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.initialInterval
+	b.MaxInterval = p.maxInterval
+	b.MaxElapsedTime = 0 // the number of retries is limited instead
+
+	attempt := 0
+
+	return backoff.Retry(func() error {
+		if attempt > 0 {
+			metrics.Get().DBRetry(p.dbType)
+		}
+
+		attempt++
+
+		err := op()
+		if err != nil && (errors.Is(err, storage.ErrDataNotFound) || errors.Is(err, storage.ErrDuplicateKey) ||
+			!p.isTransient(err)) {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}, backoff.WithMaxRetries(b, uint64(p.maxRetries)))
+}
+
+type store struct {
+	storage.Store
+	provider *Provider
+}
+
+func (s *store) Put(key string, value []byte, tags ...storage.Tag) error {
+	return s.provider.do(func() error {
+		return s.Store.Put(key, value, tags...)
+	})
+}
+
+func (s *store) Get(key string) ([]byte, error) {
+	var value []byte
+
+	err := s.provider.do(func() error {
+		var err error
+
+		value, err = s.Store.Get(key)
+
+		return err
+	})
+
+	return value, err
+}
+
+func (s *store) GetTags(key string) ([]storage.Tag, error) {
+	var tags []storage.Tag
+
+	err := s.provider.do(func() error {
+		var err error
+
+		tags, err = s.Store.GetTags(key)
+
+		return err
+	})
+
+	return tags, err
+}
+
+func (s *store) GetBulk(keys ...string) ([][]byte, error) {
+	var values [][]byte
+
+	err := s.provider.do(func() error {
+		var err error
+
+		values, err = s.Store.GetBulk(keys...)
+
+		return err
+	})
+
+	return values, err
+}
+
+// Query retries the query; errors of the returned iterator aren't retried.
+func (s *store) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	var iterator storage.Iterator
+
+	err := s.provider.do(func() error {
+		var err error
+
+		iterator, err = s.Store.Query(expression, options...)
+
+		return err
+	})
+
+	return iterator, err
+}
+
+func (s *store) Delete(key string) error {
+	return s.provider.do(func() error {
+		return s.Store.Delete(key)
+	})
+}
+
+// Batch retries the batch unless it inserts new keys: an insert that was applied before the error would fail as a
+// duplicate of itself when retried.
+func (s *store) Batch(operations []storage.Operation) error {
+	for _, op := range operations {
+		if op.PutOptions != nil && op.PutOptions.IsNewKey {
+			return s.Store.Batch(operations)
+		}
+	}
+
+	return s.provider.do(func() error {
+		return s.Store.Batch(operations)
+	})
+}
+
+func (s *store) Flush() error {
+	return s.provider.do(func() error {
+		return s.Store.Flush()
+	})
+}
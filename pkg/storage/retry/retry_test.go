@@ -0,0 +1,288 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package retry_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/metrics"
+	"github.com/trustbloc/kms/pkg/storage/retry"
+)
+
+var errTransient = errors.New("connection reset by peer")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestStore(t *testing.T) {
+	metrics.Get() // registers the retry counters
+
+	newStore := func(t *testing.T, opts ...retry.Option) (storage.Store, *flakyProvider) {
+		t.Helper()
+
+		flaky := &flakyProvider{Provider: mem.NewProvider()}
+
+		opts = append([]retry.Option{retry.WithBackoff(time.Millisecond, 2*time.Millisecond)}, opts...)
+
+		s, err := retry.Wrap(flaky, "MongoDB", isTransient, opts...).OpenStore("test")
+		require.NoError(t, err)
+
+		return s, flaky
+	}
+
+	t.Run("Retries transient errors", func(t *testing.T) {
+		s, flaky := newStore(t)
+
+		retries := retryCount(t)
+
+		flaky.failures = 2
+		require.NoError(t, s.Put("key", []byte("value")))
+
+		flaky.failures = 3
+		v, err := s.Get("key")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), v)
+
+		flaky.failures = 1
+		_, err = s.GetTags("key")
+		require.NoError(t, err)
+
+		flaky.failures = 1
+		_, err = s.GetBulk("key")
+		require.NoError(t, err)
+
+		flaky.failures = 1
+		iter, err := s.Query("tag")
+		require.NoError(t, err)
+		require.NoError(t, iter.Close())
+
+		flaky.failures = 1
+		require.NoError(t, s.Batch([]storage.Operation{{Key: "other", Value: []byte("value")}}))
+
+		flaky.failures = 1
+		require.NoError(t, s.Delete("key"))
+
+		require.Equal(t, float64(2+3+1+1+1+1+1), retryCount(t)-retries)
+	})
+
+	t.Run("Fails after max retries", func(t *testing.T) {
+		s, flaky := newStore(t, retry.WithMaxRetries(2))
+
+		flaky.failures = 3
+		require.ErrorIs(t, s.Put("key", []byte("value")), errTransient)
+		require.Equal(t, 3, flaky.calls)
+	})
+
+	t.Run("Does not retry other errors", func(t *testing.T) {
+		s, flaky := newStore(t)
+
+		_, err := s.Get("missing")
+		require.ErrorIs(t, err, storage.ErrDataNotFound)
+		require.Equal(t, 1, flaky.calls)
+
+		flaky.err = errors.New("invalid tag")
+		flaky.failures = 1
+		require.EqualError(t, s.Put("key", []byte("value")), "invalid tag")
+		require.Equal(t, 2, flaky.calls)
+	})
+
+	t.Run("Does not retry batches that insert new keys", func(t *testing.T) {
+		s, flaky := newStore(t)
+
+		flaky.failures = 1
+		err := s.Batch([]storage.Operation{
+			{Key: "key", Value: []byte("value"), PutOptions: &storage.PutOptions{IsNewKey: true}},
+		})
+		require.ErrorIs(t, err, errTransient)
+		require.Equal(t, 1, flaky.calls)
+	})
+
+	t.Run("Does not retry duplicate keys labeled transient", func(t *testing.T) {
+		s, flaky := newStore(t)
+
+		flaky.err = fmt.Errorf("%w: %v", storage.ErrDuplicateKey, errTransient)
+		flaky.failures = 2
+		require.ErrorIs(t, s.Batch([]storage.Operation{{Key: "key", Value: []byte("value")}}), storage.ErrDuplicateKey)
+		require.Equal(t, 1, flaky.calls)
+	})
+
+	t.Run("Zero max retries disables retries", func(t *testing.T) {
+		s, flaky := newStore(t, retry.WithMaxRetries(0))
+
+		flaky.failures = 1
+		require.ErrorIs(t, s.Delete("key"), errTransient)
+		require.Equal(t, 1, flaky.calls)
+	})
+}
+
+func TestProvider(t *testing.T) {
+	flaky := &flakyProvider{Provider: mem.NewProvider(), failures: 1}
+
+	p := retry.Wrap(flaky, "MongoDB", isTransient, retry.WithBackoff(time.Millisecond, time.Millisecond))
+
+	_, err := p.OpenStore("test")
+	require.NoError(t, err)
+
+	flaky.failures = 1
+	require.NoError(t, p.SetStoreConfig("test", storage.StoreConfiguration{TagNames: []string{"tag"}}))
+
+	flaky.failures = 1
+	config, err := p.GetStoreConfig("test")
+	require.NoError(t, err)
+	require.Equal(t, []string{"tag"}, config.TagNames)
+}
+
+// retryCount returns the number of retries of MongoDB operations counted in metrics.
+func retryCount(t *testing.T) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != "kms_db_retries_count" {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "type" && label.GetValue() == "MongoDB" {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	t.Fatal("retry counter is not registered")
+
+	return 0
+}
+
+// flakyProvider fails the next calls of the provider and its stores with the error, errTransient by default.
+type flakyProvider struct {
+	storage.Provider
+	failures int
+	calls    int
+	err      error
+}
+
+func (p *flakyProvider) fail() error {
+	p.calls++
+
+	if p.failures == 0 {
+		return nil
+	}
+
+	p.failures--
+
+	if p.err != nil {
+		return p.err
+	}
+
+	return errTransient
+}
+
+func (p *flakyProvider) OpenStore(name string) (storage.Store, error) {
+	if err := p.fail(); err != nil {
+		return nil, err
+	}
+
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.calls = 0
+
+	return &flakyStore{Store: s, provider: p}, nil
+}
+
+func (p *flakyProvider) SetStoreConfig(name string, config storage.StoreConfiguration) error {
+	if err := p.fail(); err != nil {
+		return err
+	}
+
+	return p.Provider.SetStoreConfig(name, config)
+}
+
+func (p *flakyProvider) GetStoreConfig(name string) (storage.StoreConfiguration, error) {
+	if err := p.fail(); err != nil {
+		return storage.StoreConfiguration{}, err
+	}
+
+	return p.Provider.GetStoreConfig(name)
+}
+
+type flakyStore struct {
+	storage.Store
+	provider *flakyProvider
+}
+
+func (s *flakyStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	if err := s.provider.fail(); err != nil {
+		return err
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+func (s *flakyStore) Get(key string) ([]byte, error) {
+	if err := s.provider.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.Store.Get(key)
+}
+
+func (s *flakyStore) GetTags(key string) ([]storage.Tag, error) {
+	if err := s.provider.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.Store.GetTags(key)
+}
+
+func (s *flakyStore) GetBulk(keys ...string) ([][]byte, error) {
+	if err := s.provider.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.Store.GetBulk(keys...)
+}
+
+func (s *flakyStore) Query(expression string, options ...storage.QueryOption) (storage.Iterator, error) {
+	if err := s.provider.fail(); err != nil {
+		return nil, err
+	}
+
+	return s.Store.Query(expression, options...)
+}
+
+func (s *flakyStore) Delete(key string) error {
+	if err := s.provider.fail(); err != nil {
+		return err
+	}
+
+	return s.Store.Delete(key)
+}
+
+func (s *flakyStore) Batch(operations []storage.Operation) error {
+	if err := s.provider.fail(); err != nil {
+		return err
+	}
+
+	return s.Store.Batch(operations)
+}
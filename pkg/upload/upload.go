@@ -0,0 +1,304 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package upload stages payloads uploaded in chunks, so that a payload too large for a single request can be signed
+// as a stream. The payload is split in chunks of a size chosen when the upload is created; chunks can be uploaded in
+// any order and in parallel, as every chunk is a record of its own. Chunks are read back in order one at a time, so
+// the payload is never held in memory. Uploads and their chunks expire together and are pruned like other expiring
+// records.
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/expiry"
+)
+
+const (
+	// StoreName is the name of the store with uploads and their chunks.
+	StoreName = "uploads"
+
+	// MaxSize is the maximum size in bytes of an uploaded payload.
+	MaxSize = 4 << 30 // 4 GiB
+	// MaxChunkSize is the maximum size in bytes of a chunk. Chunks are base64-encoded in requests, so they stay within
+	// the default request limits.
+	MaxChunkSize = 8 << 20 // 8 MiB
+	// MaxChunks is the maximum number of chunks of an upload.
+	MaxChunks = 10000
+
+	uploadKeyPrefix = "upload_"
+	chunkKeyPrefix  = "chunk_"
+	idSize          = 16
+)
+
+// Errors returned by Store.
+var (
+	// ErrNotFound is returned when an upload doesn't exist, has expired or belongs to another key.
+	ErrNotFound = errors.New("upload not found")
+	// ErrInvalidSize is returned when the size or chunk size of a new upload is out of bounds.
+	ErrInvalidSize = errors.New("invalid upload size")
+	// ErrInvalidChunk is returned when a chunk doesn't fit the upload at its offset, or its checksum doesn't match.
+	ErrInvalidChunk = errors.New("invalid chunk")
+	// ErrChunkConflict is returned when a chunk is uploaded again at an offset with different content.
+	ErrChunkConflict = errors.New("a different chunk was already uploaded at the offset")
+	// ErrIncomplete is returned when a payload is read before all of its chunks are uploaded.
+	ErrIncomplete = errors.New("upload is incomplete")
+)
+
+// Upload is a payload uploaded in chunks for a key.
+type Upload struct {
+	ID         string    `json:"id"`
+	KeyStoreID string    `json:"key_store_id"`
+	KeyID      string    `json:"key_id"`
+	Size       int64     `json:"size"`
+	ChunkSize  int64     `json:"chunk_size"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// chunks returns the number of chunks of the upload.
+func (u *Upload) chunks() int64 {
+	return (u.Size + u.ChunkSize - 1) / u.ChunkSize
+}
+
+// chunkSize returns the size of the chunk with the index, the last chunk can be shorter than the others.
+func (u *Upload) chunkSize(index int64) int64 {
+	if index == u.chunks()-1 {
+		return u.Size - index*u.ChunkSize
+	}
+
+	return u.ChunkSize
+}
+
+type chunk struct {
+	Checksum []byte `json:"checksum"`
+	Data     []byte `json:"data"`
+}
+
+// Store keeps uploads and their chunks until they expire. Records are kept in storage, so chunks of an upload can be
+// sent to any replica.
+type Store struct {
+	store *expiry.Store
+	clock clock.Clock
+	ttl   time.Duration
+}
+
+// New returns a new Store that keeps uploads for ttl after they're created. Abandoned uploads are pruned as
+// configured by the options.
+func New(provider storage.Provider, clk clock.Clock, ttl time.Duration, opts ...expiry.Option) (*Store, error) {
+	store, err := expiry.Open(provider, StoreName, expiry.ClassUpload, clk, opts...)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return &Store{store: store, clock: clk, ttl: ttl}, nil
+}
+
+// Create creates an upload of a payload of the size for the key, to be uploaded in chunks of chunkSize bytes.
+func (s *Store) Create(keyStoreID, keyID string, size, chunkSize int64) (*Upload, error) {
+	if size <= 0 || size > MaxSize {
+		return nil, fmt.Errorf("%w: size must be between 1 and %d bytes", ErrInvalidSize, int64(MaxSize))
+	}
+
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%w: chunk size must be between 1 and %d bytes", ErrInvalidSize, MaxChunkSize)
+	}
+
+	if (size+chunkSize-1)/chunkSize > MaxChunks {
+		return nil, fmt.Errorf("%w: a payload of %d bytes can't be uploaded in more than %d chunks", ErrInvalidSize,
+			size, MaxChunks)
+	}
+
+	b := make([]byte, idSize)
+
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("generate id: %w", err)
+	}
+
+	u := &Upload{
+		ID:         base64.RawURLEncoding.EncodeToString(b),
+		KeyStoreID: keyStoreID,
+		KeyID:      keyID,
+		Size:       size,
+		ChunkSize:  chunkSize,
+		ExpiresAt:  s.clock.Now().UTC().Add(s.ttl),
+	}
+
+	v, err := json.Marshal(u)
+	if err != nil {
+		return nil, fmt.Errorf("marshal upload: %w", err)
+	}
+
+	if err = s.store.Put(uploadKeyPrefix+u.ID, v, u.ExpiresAt, true); err != nil {
+		return nil, fmt.Errorf("save upload: %w", err)
+	}
+
+	return u, nil
+}
+
+// Get returns the upload with the ID for the key. It returns ErrNotFound if the upload has expired or was created
+// for another key.
+func (s *Store) Get(keyStoreID, keyID, id string) (*Upload, error) {
+	b, err := s.store.Get(uploadKeyPrefix + id)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get upload: %w", err)
+	}
+
+	var u Upload
+
+	if err = json.Unmarshal(b, &u); err != nil {
+		return nil, fmt.Errorf("unmarshal upload: %w", err)
+	}
+
+	if u.KeyStoreID != keyStoreID || u.KeyID != keyID || s.store.Expired(u.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return &u, nil
+}
+
+// PutChunk saves the chunk of the upload at the offset. The offset must be a multiple of the chunk size of the
+// upload, and the checksum the SHA-256 hash of the chunk. Uploading the same chunk again is a no-op, so that chunks
+// can be retried. Of concurrent uploads of different chunks at the same offset, only one is saved with storage that
+// rejects existing keys for storage.PutOptions.IsNewKey (e.g. MongoDB).
+func (s *Store) PutChunk(u *Upload, offset int64, data, checksum []byte) error {
+	if offset < 0 || offset >= u.Size || offset%u.ChunkSize != 0 {
+		return fmt.Errorf("%w: offset must be a multiple of %d less than %d", ErrInvalidChunk, u.ChunkSize, u.Size)
+	}
+
+	index := offset / u.ChunkSize
+
+	if size := u.chunkSize(index); int64(len(data)) != size {
+		return fmt.Errorf("%w: chunk at offset %d must have %d bytes, got %d", ErrInvalidChunk, offset, size,
+			len(data))
+	}
+
+	sum := sha256.Sum256(data)
+
+	if !bytes.Equal(sum[:], checksum) {
+		return fmt.Errorf("%w: checksum of chunk at offset %d doesn't match", ErrInvalidChunk, offset)
+	}
+
+	key := chunkKey(u.ID, index)
+
+	saved, err := s.getChunk(key)
+	if err != nil {
+		return err
+	}
+
+	if saved != nil {
+		return checkDuplicate(saved, checksum, offset)
+	}
+
+	v, err := json.Marshal(&chunk{Checksum: checksum, Data: data})
+	if err != nil {
+		return fmt.Errorf("marshal chunk: %w", err)
+	}
+
+	// chunks expire with their upload
+	err = s.store.Put(key, v, u.ExpiresAt, true)
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		if saved, err = s.getChunk(key); err != nil {
+			return err
+		}
+
+		return checkDuplicate(saved, checksum, offset)
+	}
+
+	if err != nil {
+		return fmt.Errorf("save chunk: %w", err)
+	}
+
+	return nil
+}
+
+// Read writes the payload of the upload to w, one chunk at a time in the order of offsets. It returns ErrIncomplete
+// if a chunk is missing.
+func (s *Store) Read(u *Upload, w io.Writer) error {
+	for index := int64(0); index < u.chunks(); index++ {
+		c, err := s.getChunk(chunkKey(u.ID, index))
+		if err != nil {
+			return err
+		}
+
+		if c == nil {
+			return fmt.Errorf("%w: chunk at offset %d is missing", ErrIncomplete, index*u.ChunkSize)
+		}
+
+		if sum := sha256.Sum256(c.Data); !bytes.Equal(sum[:], c.Checksum) {
+			return fmt.Errorf("checksum of saved chunk at offset %d doesn't match", index*u.ChunkSize)
+		}
+
+		if _, err = w.Write(c.Data); err != nil {
+			return fmt.Errorf("write chunk: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes the upload and its chunks.
+func (s *Store) Delete(u *Upload) error {
+	// the upload is deleted first, so that it can't be read without its chunks
+	if err := s.store.Delete(uploadKeyPrefix + u.ID); err != nil {
+		return fmt.Errorf("delete upload: %w", err)
+	}
+
+	for index := int64(0); index < u.chunks(); index++ {
+		if err := s.store.Delete(chunkKey(u.ID, index)); err != nil {
+			return fmt.Errorf("delete chunk: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getChunk returns the chunk saved with the key, or nil if there is none.
+func (s *Store) getChunk(key string) (*chunk, error) {
+	b, err := s.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("get chunk: %w", err)
+	}
+
+	var c chunk
+
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("unmarshal chunk: %w", err)
+	}
+
+	return &c, nil
+}
+
+func checkDuplicate(saved *chunk, checksum []byte, offset int64) error {
+	if !bytes.Equal(saved.Checksum, checksum) {
+		return fmt.Errorf("%w: offset %d", ErrChunkConflict, offset)
+	}
+
+	return nil
+}
+
+func chunkKey(id string, index int64) string {
+	return chunkKeyPrefix + id + "_" + strconv.FormatInt(index, 10)
+}
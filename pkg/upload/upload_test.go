@@ -0,0 +1,341 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package upload_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/expiry"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/upload"
+)
+
+func TestStore_Create(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", 10, 4)
+		require.NoError(t, err)
+		require.NotEmpty(t, u.ID)
+		require.Equal(t, time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC), u.ExpiresAt)
+
+		got, err := s.Get("ks", "key", u.ID)
+		require.NoError(t, err)
+		require.Equal(t, u, got)
+	})
+
+	t.Run("Invalid sizes", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		tests := []struct {
+			name      string
+			size      int64
+			chunkSize int64
+		}{
+			{"Empty payload", 0, 4},
+			{"Payload too large", upload.MaxSize + 1, upload.MaxChunkSize},
+			{"Empty chunks", 10, 0},
+			{"Chunks too large", upload.MaxChunkSize + 1, upload.MaxChunkSize + 1},
+			{"Too many chunks", upload.MaxChunks + 1, 1},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := s.Create("ks", "key", tt.size, tt.chunkSize)
+				require.ErrorIs(t, err, upload.ErrInvalidSize)
+			})
+		}
+	})
+
+	t.Run("Upload of another key is not found", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", 10, 4)
+		require.NoError(t, err)
+
+		_, err = s.Get("ks", "other", u.ID)
+		require.ErrorIs(t, err, upload.ErrNotFound)
+
+		_, err = s.Get("other", "key", u.ID)
+		require.ErrorIs(t, err, upload.ErrNotFound)
+	})
+}
+
+func TestStore_PutChunk(t *testing.T) {
+	payload := []byte("0123456789")
+
+	t.Run("Chunks out of order", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 4)
+		require.NoError(t, err)
+
+		for _, offset := range []int64{8, 0, 4} {
+			putChunk(t, s, u, payload, offset)
+		}
+
+		var buf bytes.Buffer
+
+		require.NoError(t, s.Read(u, &buf))
+		require.Equal(t, payload, buf.Bytes())
+	})
+
+	t.Run("Chunks in parallel", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 1)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+
+		for offset := range payload {
+			wg.Add(1)
+
+			go func(offset int64) {
+				defer wg.Done()
+
+				chunk := payload[offset : offset+1]
+				sum := sha256.Sum256(chunk)
+
+				require.NoError(t, s.PutChunk(u, offset, chunk, sum[:]))
+			}(int64(offset))
+		}
+
+		wg.Wait()
+
+		var buf bytes.Buffer
+
+		require.NoError(t, s.Read(u, &buf))
+		require.Equal(t, payload, buf.Bytes())
+	})
+
+	t.Run("Duplicate chunk", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 4)
+		require.NoError(t, err)
+
+		putChunk(t, s, u, payload, 4)
+		putChunk(t, s, u, payload, 4)
+
+		other := []byte("abcd")
+		sum := sha256.Sum256(other)
+
+		err = s.PutChunk(u, 4, other, sum[:])
+		require.ErrorIs(t, err, upload.ErrChunkConflict)
+	})
+
+	t.Run("Duplicate chunk saved concurrently", func(t *testing.T) {
+		s := newStore(t, &newKeyProvider{Provider: mem.NewProvider(), race: "chunk_"})
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 4)
+		require.NoError(t, err)
+
+		// the provider saves the chunk of a concurrent request first
+		putChunk(t, s, u, payload, 0)
+	})
+
+	t.Run("Invalid chunks", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 4)
+		require.NoError(t, err)
+
+		chunk := payload[:4]
+		sum := sha256.Sum256(chunk)
+
+		tests := []struct {
+			name     string
+			offset   int64
+			chunk    []byte
+			checksum []byte
+		}{
+			{"Negative offset", -4, chunk, sum[:]},
+			{"Offset past the end", 12, chunk, sum[:]},
+			{"Unaligned offset", 2, chunk, sum[:]},
+			{"Chunk too short", 0, chunk[:3], sum[:]},
+			{"Last chunk too long", 8, chunk, sum[:]},
+			{"Checksum mismatch", 0, chunk, make([]byte, sha256.Size)},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := s.PutChunk(u, tt.offset, tt.chunk, tt.checksum)
+				require.ErrorIs(t, err, upload.ErrInvalidChunk)
+			})
+		}
+	})
+}
+
+func TestStore_Read(t *testing.T) {
+	t.Run("Missing chunk", func(t *testing.T) {
+		s := newStore(t, mem.NewProvider())
+
+		payload := []byte("0123456789")
+
+		u, err := s.Create("ks", "key", int64(len(payload)), 4)
+		require.NoError(t, err)
+
+		putChunk(t, s, u, payload, 0)
+		putChunk(t, s, u, payload, 8)
+
+		err = s.Read(u, &bytes.Buffer{})
+		require.ErrorIs(t, err, upload.ErrIncomplete)
+		require.Contains(t, err.Error(), "chunk at offset 4 is missing")
+	})
+}
+
+func TestStore_Delete(t *testing.T) {
+	provider := mem.NewProvider()
+	s := newStore(t, provider)
+
+	payload := []byte("0123456789")
+
+	u, err := s.Create("ks", "key", int64(len(payload)), 4)
+	require.NoError(t, err)
+
+	putChunk(t, s, u, payload, 0)
+	putChunk(t, s, u, payload, 4)
+
+	require.NoError(t, s.Delete(u))
+
+	_, err = s.Get("ks", "key", u.ID)
+	require.ErrorIs(t, err, upload.ErrNotFound)
+	require.Zero(t, countRecords(t, provider))
+}
+
+func TestStore_Prune(t *testing.T) {
+	provider := mem.NewProvider()
+	clk := testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	pruner := expiry.NewPruner(time.Minute)
+
+	s, err := upload.New(provider, clk, time.Hour, expiry.WithPruner(pruner))
+	require.NoError(t, err)
+
+	payload := []byte("0123456789")
+
+	abandoned, err := s.Create("ks", "key", int64(len(payload)), 4)
+	require.NoError(t, err)
+
+	putChunk(t, s, abandoned, payload, 0)
+	putChunk(t, s, abandoned, payload, 8)
+
+	clk.Advance(30 * time.Minute)
+
+	live, err := s.Create("ks", "key", int64(len(payload)), 4)
+	require.NoError(t, err)
+
+	putChunk(t, s, live, payload, 4)
+
+	clk.Advance(30 * time.Minute)
+
+	_, err = s.Get("ks", "key", abandoned.ID)
+	require.ErrorIs(t, err, upload.ErrNotFound)
+
+	pruner.Prune()
+
+	// the abandoned upload and its chunks are deleted
+	require.Equal(t, 2, countRecords(t, provider))
+
+	_, err = s.Get("ks", "key", live.ID)
+	require.NoError(t, err)
+}
+
+func newStore(t *testing.T, provider storage.Provider) *upload.Store {
+	t.Helper()
+
+	s, err := upload.New(provider, testutil.NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)), time.Hour)
+	require.NoError(t, err)
+
+	return s
+}
+
+func putChunk(t *testing.T, s *upload.Store, u *upload.Upload, payload []byte, offset int64) {
+	t.Helper()
+
+	end := offset + u.ChunkSize
+	if end > int64(len(payload)) {
+		end = int64(len(payload))
+	}
+
+	chunk := payload[offset:end]
+	sum := sha256.Sum256(chunk)
+
+	require.NoError(t, s.PutChunk(u, offset, chunk, sum[:]))
+}
+
+func countRecords(t *testing.T, provider storage.Provider) int {
+	t.Helper()
+
+	store, err := provider.OpenStore(upload.StoreName)
+	require.NoError(t, err)
+
+	it, err := store.Query("expires_at")
+	require.NoError(t, err)
+
+	defer it.Close() // nolint: errcheck
+
+	n, err := it.TotalItems()
+	require.NoError(t, err)
+
+	return n
+}
+
+// newKeyProvider rejects existing keys stored with IsNewKey option, like MongoDB does. Records with keys with the race
+// prefix are saved by a concurrent request just before.
+type newKeyProvider struct {
+	storage.Provider
+	race string
+}
+
+func (p *newKeyProvider) OpenStore(name string) (storage.Store, error) {
+	s, err := p.Provider.OpenStore(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &newKeyStore{Store: s, race: p.race}, nil
+}
+
+type newKeyStore struct {
+	storage.Store
+	race string
+}
+
+func (s *newKeyStore) Batch(ops []storage.Operation) error {
+	for _, op := range ops {
+		if op.PutOptions == nil || !op.PutOptions.IsNewKey {
+			continue
+		}
+
+		if s.race != "" && strings.HasPrefix(op.Key, s.race) {
+			if err := s.Store.Batch(ops); err != nil {
+				return err
+			}
+
+			return storage.ErrDuplicateKey
+		}
+
+		if _, err := s.Store.Get(op.Key); err == nil {
+			return storage.ErrDuplicateKey
+		} else if !errors.Is(err, storage.ErrDataNotFound) {
+			return err
+		}
+	}
+
+	return s.Store.Batch(ops)
+}
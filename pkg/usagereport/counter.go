@@ -0,0 +1,276 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package usagereport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/signnonce"
+)
+
+const (
+	// CountersStoreName is the name of the store with operation counts of instances.
+	CountersStoreName = "usage_counters"
+
+	// dayTagName is the tag of counts with their day.
+	dayTagName = "day"
+)
+
+type counterKey struct {
+	day    string
+	tenant string
+}
+
+// counts is a record of operation counts of a tenant for a day, as seen by an instance.
+type counts struct {
+	Day        string           `json:"day"`
+	Tenant     string           `json:"tenant"`
+	Instance   string           `json:"instance"`
+	Operations map[string]int64 `json:"operations"`
+}
+
+type entry struct {
+	operations map[string]int64
+	dirty      bool // changed since the last flush
+}
+
+// Counter counts operations of tenants served by the instance. Counts are kept in memory and saved by Flush; every
+// instance saves its own totals for the day, so that instances never overwrite each other's counts, and a retried
+// flush never counts an operation twice. Operations counted since the last flush are lost if the process crashes.
+type Counter struct {
+	store      storage.Store
+	clock      clock.Clock
+	instanceID string
+	mutex      sync.Mutex
+	entries    map[counterKey]*entry
+}
+
+// NewCounter returns a new Counter of the instance. The instance ID must be unique among running instances, e.g.
+// random per process.
+func NewCounter(provider storage.Provider, clk clock.Clock, instanceID string) (*Counter, error) {
+	store, err := provider.OpenStore(CountersStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	err = provider.SetStoreConfig(CountersStoreName, storage.StoreConfiguration{TagNames: []string{dayTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set store config: %w", err)
+	}
+
+	return &Counter{
+		store:      store,
+		clock:      clk,
+		instanceID: instanceID,
+		entries:    make(map[counterKey]*entry),
+	}, nil
+}
+
+// Add counts an operation of the tenant now.
+func (c *Counter) Add(tenant, operation string) {
+	key := counterKey{day: dayOf(c.clock.Now()), tenant: tenant}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{operations: make(map[string]int64)}
+		c.entries[key] = e
+	}
+
+	e.operations[operation]++
+	e.dirty = true
+}
+
+// Flush saves counts that changed since the last flush. Counts of past days are dropped from memory once saved, as
+// operations are only counted for the current day.
+func (c *Counter) Flush() error {
+	today := dayOf(c.clock.Now())
+
+	c.mutex.Lock()
+
+	var pending []*counts
+
+	for key, e := range c.entries {
+		if !e.dirty {
+			if key.day != today {
+				delete(c.entries, key)
+			}
+
+			continue
+		}
+
+		operations := make(map[string]int64, len(e.operations))
+		for op, n := range e.operations {
+			operations[op] = n
+		}
+
+		pending = append(pending, &counts{
+			Day:        key.day,
+			Tenant:     key.tenant,
+			Instance:   c.instanceID,
+			Operations: operations,
+		})
+
+		e.dirty = false
+	}
+
+	c.mutex.Unlock()
+
+	for i, rec := range pending {
+		if err := c.save(rec); err != nil {
+			c.markDirty(pending[i:])
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Counter) save(rec *counts) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal counts: %w", err)
+	}
+
+	err = c.store.Put(rec.Day+"/"+rec.Tenant+"/"+rec.Instance, b, storage.Tag{Name: dayTagName, Value: rec.Day})
+	if err != nil {
+		return fmt.Errorf("save counts: %w", err)
+	}
+
+	return nil
+}
+
+// markDirty marks counts that failed to be saved, so that they are saved by the next flush.
+func (c *Counter) markDirty(failed []*counts) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, rec := range failed {
+		if e, ok := c.entries[counterKey{day: rec.Day, tenant: rec.Tenant}]; ok {
+			e.dirty = true
+		}
+	}
+}
+
+// DayCounts returns operation counts of the day saved by all instances, by tenant.
+func (c *Counter) DayCounts(day string) (map[string]map[string]int64, error) {
+	records, err := c.query(day)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make(map[string]map[string]int64)
+
+	for _, rec := range records {
+		operations, ok := tenants[rec.Tenant]
+		if !ok {
+			operations = make(map[string]int64)
+			tenants[rec.Tenant] = operations
+		}
+
+		for op, n := range rec.Operations {
+			operations[op] += n
+		}
+	}
+
+	return tenants, nil
+}
+
+// DeleteDay deletes counts of the day saved by all instances, e.g. once the day is reported.
+func (c *Counter) DeleteDay(day string) error {
+	records, err := c.query(day)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		err = c.store.Delete(rec.Day + "/" + rec.Tenant + "/" + rec.Instance)
+		if err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("delete counts: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Counter) query(day string) ([]*counts, error) {
+	it, err := c.store.Query(dayTagName + ":" + day)
+	if err != nil {
+		return nil, fmt.Errorf("query counts: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var records []*counts
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("next counts: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		b, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("counts value: %w", err)
+		}
+
+		var rec counts
+
+		if err = json.Unmarshal(b, &rec); err != nil {
+			return nil, fmt.Errorf("unmarshal counts: %w", err)
+		}
+
+		records = append(records, &rec)
+	}
+
+	return records, nil
+}
+
+// Middleware counts requests of the operation that the next handler completes successfully, for the tenant named by
+// the route variable. Requests without the variable (e.g. to create a key store) aren't counted, nor are sign
+// requests answered with the signature saved for their nonce.
+func (c *Counter) Middleware(operation, tenantVarName string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(rec, r)
+
+			tenant := mux.Vars(r)[tenantVarName]
+			if tenant == "" || rec.statusCode >= http.StatusBadRequest || rec.Header().Get(signnonce.ReplayedHeader) != "" {
+				return
+			}
+
+			c.Add(tenant, operation)
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,532 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package usagereport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/respsign"
+)
+
+const (
+	// ReportsStoreName is the name of the store with the lease and the progress of reporting.
+	ReportsStoreName = "usage_reports"
+	// DeadLetterStoreName is the name of the store with reports that failed to be delivered.
+	DeadLetterStoreName = "usage_reports_dead_letter"
+
+	// startedKey is the ID of the record with the first day counted, so that days before reports were enabled
+	// aren't reported.
+	startedKey = "started"
+	// reportedPrefix prefixes IDs of records of reported days, followed by the day.
+	reportedPrefix = "reported_"
+	// deliveredPrefix prefixes IDs of records of delivered reports, followed by the report ID. A run that is
+	// interrupted doesn't deliver them again.
+	deliveredPrefix = "delivered_"
+
+	defaultInterval      = time.Minute
+	defaultDelay         = time.Hour
+	defaultLeaseDuration = 10 * time.Minute
+	defaultRateLimit     = 10
+	defaultMaxRetries    = 5
+	defaultRetryInterval = time.Second
+	defaultCatchUpDays   = 7
+
+	day = 24 * time.Hour
+)
+
+// Signer signs documents of reports with the server identity key, see respsign.Signer.
+type Signer interface {
+	SignEnvelope(payload []byte) (*respsign.Envelope, error)
+}
+
+// StorageUsage measures bytes tenants keep in server storage, by tenant, see command.Command.StorageUsage. Tenants
+// that fail to be measured are left out and an error is returned with the others.
+type StorageUsage interface {
+	StorageUsage() (map[string]int64, error)
+}
+
+// Config configures Generator.
+type Config struct {
+	// Provider stores the lease, the progress of reporting and the dead-letter store. It must be shared by all
+	// instances.
+	Provider     storage.Provider
+	Counter      *Counter
+	StorageUsage StorageUsage
+	Signer       Signer
+	Target       Target
+	Clock        clock.Clock
+	// InstanceID identifies the instance that holds the lease. It must be unique among running instances.
+	InstanceID string
+	// Interval is how often counts are flushed and, by the lease holder, days reported. Defaults to 1m.
+	Interval time.Duration
+	// Delay is the time after the end of a day before it's reported, so that all instances flushed its counts.
+	// Defaults to 1h.
+	Delay time.Duration
+	// LeaseDuration is the term of the lease. Defaults to 10m.
+	LeaseDuration time.Duration
+	// RateLimit is the maximum number of deliveries per second. Defaults to 10.
+	RateLimit int
+	// MaxRetries is the number of times a failed delivery is retried before the report is saved to the dead-letter
+	// store. Defaults to 5.
+	MaxRetries int
+	// RetryInterval is the delay before the first retry, doubled for every next retry. Defaults to 1s.
+	RetryInterval time.Duration
+	// CatchUpDays is the number of days that are reported if they weren't, e.g. after all instances were down over
+	// midnight. Defaults to 7.
+	CatchUpDays int
+}
+
+// DeadLetter is a report that failed to be delivered, saved to the dead-letter store under the report ID.
+type DeadLetter struct {
+	ReportID string          `json:"report_id"`
+	Document json.RawMessage `json:"document"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// Generator reports days in the background. Every instance runs a Generator, which flushes counts of the instance;
+// only the instance that holds the lease reports days.
+type Generator struct {
+	config       Config
+	store        storage.Store
+	deadLetters  storage.Store
+	lease        *lease
+	nextDelivery time.Time
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	runMutex sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a new Generator. Days before the first start of a Generator with the storage aren't reported.
+func New(config *Config) (*Generator, error) {
+	c := *config
+	setDefaults(&c)
+
+	store, err := c.Provider.OpenStore(ReportsStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open store: %w", err)
+	}
+
+	err = c.Provider.SetStoreConfig(ReportsStoreName, storage.StoreConfiguration{TagNames: []string{dayTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set store config: %w", err)
+	}
+
+	deadLetters, err := c.Provider.OpenStore(DeadLetterStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("open dead-letter store: %w", err)
+	}
+
+	err = c.Provider.SetStoreConfig(DeadLetterStoreName, storage.StoreConfiguration{TagNames: []string{dayTagName}})
+	if err != nil {
+		return nil, fmt.Errorf("set dead-letter store config: %w", err)
+	}
+
+	// an existing record is kept, started is the first day counted by any instance
+	if _, err = store.Get(startedKey); errors.Is(err, storage.ErrDataNotFound) {
+		err = store.Batch([]storage.Operation{{
+			Key:        startedKey,
+			Value:      []byte(`"` + dayOf(c.Clock.Now()) + `"`),
+			PutOptions: &storage.PutOptions{IsNewKey: true},
+		}})
+		if errors.Is(err, storage.ErrDuplicateKey) {
+			err = nil // saved by another instance in the meantime
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("save start day: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Generator{
+		config:      c,
+		store:       store,
+		deadLetters: deadLetters,
+		lease: &lease{
+			store:      store,
+			clock:      c.Clock,
+			instanceID: c.InstanceID,
+			duration:   c.LeaseDuration,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func setDefaults(c *Config) {
+	if c.Clock == nil {
+		c.Clock = clock.Real()
+	}
+
+	if c.Interval <= 0 {
+		c.Interval = defaultInterval
+	}
+
+	if c.Delay <= 0 {
+		c.Delay = defaultDelay
+	}
+
+	if c.LeaseDuration <= 0 {
+		c.LeaseDuration = defaultLeaseDuration
+	}
+
+	if c.RateLimit <= 0 {
+		c.RateLimit = defaultRateLimit
+	}
+
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = defaultMaxRetries
+	}
+
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+
+	if c.CatchUpDays <= 0 {
+		c.CatchUpDays = defaultCatchUpDays
+	}
+}
+
+// Start starts flushing counts and reporting days in the background until Stop is called.
+func (g *Generator) Start() {
+	go func() {
+		ticker := time.NewTicker(g.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				g.Run()
+			case <-g.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting, interrupting deliveries in progress, and flushes counts of the instance.
+func (g *Generator) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.done)
+		g.cancel()
+
+		if err := g.config.Counter.Flush(); err != nil {
+			logger.Errorf("flush usage counts: %v", err)
+		}
+	})
+}
+
+// Run flushes counts of the instance and, if the instance holds the lease, reports days that are over and weren't
+// reported. Failures are logged, and the days are reported on the next run.
+func (g *Generator) Run() {
+	g.runMutex.Lock()
+	defer g.runMutex.Unlock()
+
+	if err := g.config.Counter.Flush(); err != nil {
+		logger.Errorf("flush usage counts: %v", err)
+	}
+
+	leader, err := g.lease.acquire()
+	if err != nil {
+		logger.Errorf("acquire usage report lease: %v", err)
+
+		return
+	}
+
+	if !leader {
+		return
+	}
+
+	days, err := g.pendingDays()
+	if err != nil {
+		logger.Errorf("get days to report: %v", err)
+
+		return
+	}
+
+	for _, d := range days {
+		if err = g.reportDay(d); err != nil {
+			logger.Errorf("report usage of %s: %v", d, err)
+
+			return
+		}
+	}
+}
+
+// pendingDays returns days that are over for longer than the delay and weren't reported, oldest first.
+func (g *Generator) pendingDays() ([]string, error) {
+	b, err := g.store.Get(startedKey)
+	if err != nil {
+		return nil, fmt.Errorf("get start day: %w", err)
+	}
+
+	var started string
+
+	if err = json.Unmarshal(b, &started); err != nil {
+		return nil, fmt.Errorf("unmarshal start day: %w", err)
+	}
+
+	last := g.config.Clock.Now().Add(-g.config.Delay - day)
+
+	var days []string
+
+	for i := g.config.CatchUpDays - 1; i >= 0; i-- {
+		d := dayOf(last.Add(-time.Duration(i) * day))
+		if d < started {
+			continue
+		}
+
+		reported, err := g.exists(reportedPrefix + d)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reported {
+			days = append(days, d)
+		}
+	}
+
+	return days, nil
+}
+
+// reportDay delivers reports of the day to tenants with counts or data in storage. The day is marked reported once
+// every report is delivered or saved to the dead-letter store.
+func (g *Generator) reportDay(d string) error {
+	tenantCounts, err := g.config.Counter.DayCounts(d)
+	if err != nil {
+		return err
+	}
+
+	usage, err := g.config.StorageUsage.StorageUsage()
+	if usage == nil {
+		return fmt.Errorf("measure storage usage: %w", err)
+	}
+
+	if err != nil {
+		logger.Warnf("measure storage usage: %v", err)
+	}
+
+	tenants := make([]string, 0, len(usage))
+
+	for tenant := range usage {
+		tenants = append(tenants, tenant)
+	}
+
+	for tenant := range tenantCounts {
+		if _, ok := usage[tenant]; !ok {
+			tenants = append(tenants, tenant) // deleted key store
+		}
+	}
+
+	sort.Strings(tenants)
+
+	generatedAt := g.config.Clock.Now()
+
+	for _, tenant := range tenants {
+		report := NewReport(tenant, d, tenantCounts[tenant], usage[tenant], generatedAt)
+
+		delivered, err := g.exists(deliveredPrefix + report.ID)
+		if err != nil {
+			return err
+		}
+
+		if delivered {
+			continue
+		}
+
+		if err = g.deliver(report); err != nil {
+			return err
+		}
+	}
+
+	if err = g.store.Put(reportedPrefix+d, []byte(`"`+generatedAt.UTC().Format(time.RFC3339)+`"`)); err != nil {
+		return fmt.Errorf("save reported day: %w", err)
+	}
+
+	if err = g.cleanUp(d); err != nil {
+		logger.Warnf("clean up records of reported day %s: %v", d, err)
+	}
+
+	return nil
+}
+
+// deliver signs and delivers the report with retries, or saves it to the dead-letter store, and marks it delivered.
+func (g *Generator) deliver(report *Report) error {
+	payload, err := report.Marshal()
+	if err != nil {
+		return err
+	}
+
+	envelope, err := g.config.Signer.SignEnvelope(payload)
+	if err != nil {
+		return fmt.Errorf("sign report: %w", err)
+	}
+
+	doc, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("marshal signed report: %w", err)
+	}
+
+	signed := &SignedReport{Report: report, Document: doc}
+
+	attempts, err := g.deliverWithRetries(signed)
+	if g.ctx.Err() != nil {
+		return fmt.Errorf("deliver report: %w", g.ctx.Err())
+	}
+
+	if err != nil {
+		logger.Errorf("deliver usage report %s after %d attempts: %v", report.ID, attempts, err)
+
+		if err = g.saveDeadLetter(signed, attempts, err); err != nil {
+			return err
+		}
+	}
+
+	err = g.store.Put(deliveredPrefix+report.ID, []byte("true"), storage.Tag{Name: dayTagName, Value: report.Day})
+	if err != nil {
+		return fmt.Errorf("save delivered report: %w", err)
+	}
+
+	return nil
+}
+
+// deliverWithRetries delivers the report, retrying failed deliveries with exponential backoff. Deliveries, including
+// retries, are limited to the rate limit. It returns the number of attempts.
+func (g *Generator) deliverWithRetries(report *SignedReport) (int, error) {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = g.config.RetryInterval
+	b.Multiplier = 2
+	b.MaxElapsedTime = 0
+
+	var attempts int
+
+	err := backoff.Retry(func() error {
+		if err := g.wait(); err != nil {
+			return backoff.Permanent(err)
+		}
+
+		attempts++
+
+		return g.config.Target.Deliver(g.ctx, report)
+	}, backoff.WithContext(backoff.WithMaxRetries(b, uint64(g.config.MaxRetries)), g.ctx))
+
+	return attempts, err
+}
+
+// wait waits until the next delivery is allowed by the rate limit.
+func (g *Generator) wait() error {
+	now := g.config.Clock.Now()
+
+	if g.nextDelivery.After(now) {
+		timer := time.NewTimer(g.nextDelivery.Sub(now))
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-g.ctx.Done():
+			return g.ctx.Err()
+		}
+
+		now = g.nextDelivery
+	}
+
+	g.nextDelivery = now.Add(time.Second / time.Duration(g.config.RateLimit))
+
+	return nil
+}
+
+func (g *Generator) saveDeadLetter(report *SignedReport, attempts int, deliveryErr error) error {
+	b, err := json.Marshal(&DeadLetter{
+		ReportID: report.Report.ID,
+		Document: report.Document,
+		Error:    deliveryErr.Error(),
+		Attempts: attempts,
+		FailedAt: g.config.Clock.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal dead letter: %w", err)
+	}
+
+	err = g.deadLetters.Put(report.Report.ID, b, storage.Tag{Name: dayTagName, Value: report.Report.Day})
+	if err != nil {
+		return fmt.Errorf("save dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// cleanUp deletes counts of the reported day and records of its delivered reports.
+func (g *Generator) cleanUp(d string) error {
+	if err := g.config.Counter.DeleteDay(d); err != nil {
+		return err
+	}
+
+	it, err := g.store.Query(dayTagName + ":" + d)
+	if err != nil {
+		return fmt.Errorf("query delivered reports: %w", err)
+	}
+
+	defer it.Close() // nolint: errcheck
+
+	var keys []string
+
+	for {
+		ok, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("next delivered report: %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		key, err := it.Key()
+		if err != nil {
+			return fmt.Errorf("delivered report key: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		if err = g.store.Delete(key); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+			return fmt.Errorf("delete delivered report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (g *Generator) exists(key string) (bool, error) {
+	_, err := g.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	return true, nil
+}
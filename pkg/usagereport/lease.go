@@ -0,0 +1,102 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package usagereport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+
+	"github.com/trustbloc/kms/pkg/clock"
+)
+
+// leasePrefix prefixes IDs of lease records, followed by the start of the lease term in Unix seconds.
+const leasePrefix = "lease_"
+
+type leaseRecord struct {
+	Holder string `json:"holder"`
+}
+
+// lease elects the instance that generates reports. Time is divided into terms of the lease duration, and the first
+// instance to save the record of a term holds the lease for the term. The record is saved with
+// storage.PutOptions.IsNewKey, so a single instance is elected with storage that rejects existing keys (e.g.
+// MongoDB); with other storage, instances that claim a term at the same time may both hold it, which is tolerated
+// as deliveries are idempotent by report ID.
+type lease struct {
+	store      storage.Store
+	clock      clock.Clock
+	instanceID string
+	duration   time.Duration
+}
+
+// acquire returns true if the instance holds the lease of the current term, claiming it if no instance has.
+func (l *lease) acquire() (bool, error) {
+	term := l.clock.Now().Truncate(l.duration)
+	key := leaseKey(term)
+
+	holder, err := l.holder(key)
+	if err != nil {
+		return false, err
+	}
+
+	if holder != "" {
+		return holder == l.instanceID, nil
+	}
+
+	b, err := json.Marshal(&leaseRecord{Holder: l.instanceID})
+	if err != nil {
+		return false, fmt.Errorf("marshal lease: %w", err)
+	}
+
+	err = l.store.Batch([]storage.Operation{{
+		Key:        key,
+		Value:      b,
+		PutOptions: &storage.PutOptions{IsNewKey: true},
+	}})
+	if errors.Is(err, storage.ErrDuplicateKey) {
+		return false, nil // claimed by another instance in the meantime
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("save lease: %w", err)
+	}
+
+	// records of past terms aren't needed anymore
+	if err = l.store.Delete(leaseKey(term.Add(-l.duration))); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		logger.Warnf("delete previous lease: %v", err)
+	}
+
+	return true, nil
+}
+
+// holder returns the holder of the lease record, or an empty string if there's no record.
+func (l *lease) holder(key string) (string, error) {
+	b, err := l.store.Get(key)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get lease: %w", err)
+	}
+
+	var rec leaseRecord
+
+	if err = json.Unmarshal(b, &rec); err != nil {
+		return "", fmt.Errorf("unmarshal lease: %w", err)
+	}
+
+	return rec.Holder, nil
+}
+
+func leaseKey(term time.Time) string {
+	return leasePrefix + strconv.FormatInt(term.Unix(), 10)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package usagereport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/trustbloc/kms/pkg/respsign"
+)
+
+// ReportIDHeader is a header of webhook requests with the ID of the delivered report.
+const ReportIDHeader = "Usage-Report-ID"
+
+// SignedReport is a report with its signed document: a flattened JWS JSON envelope of the report, signed with the
+// server identity key. Receivers verify it against the keys published at respsign.WellKnownPath.
+type SignedReport struct {
+	Report   *Report
+	Document []byte
+}
+
+// Target receives signed reports, e.g. a webhook (see NewWebhookTarget) or a bucket of an S3-compatible object store
+// (see NewS3Target). A report may be delivered more than once, e.g. when a delivery is retried after a timeout.
+type Target interface {
+	Deliver(ctx context.Context, report *SignedReport) error
+}
+
+// WebhookTarget posts signed reports to a URL.
+type WebhookTarget struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookTarget returns a new WebhookTarget that posts signed reports to the URL with the client.
+func NewWebhookTarget(url string, client *http.Client) *WebhookTarget {
+	return &WebhookTarget{url: url, client: client}
+}
+
+// Deliver posts the signed document of the report. Responses with other than 2xx status fail the delivery.
+func (t *WebhookTarget) Deliver(ctx context.Context, report *SignedReport) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(report.Document))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", respsign.JOSEJSONMediaType)
+	req.Header.Set(ReportIDHeader, report.Report.ID)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post report: %w", err)
+	}
+
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			logger.Errorf("close webhook response body: %v", closeErr)
+		}
+	}()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// S3Target saves signed reports as objects of a bucket of an S3-compatible object store. A report is saved as
+// <prefix><day>/<tenant>.json, so a redelivered report replaces the object.
+type S3Target struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Target returns a new S3Target that saves signed reports in the bucket under the key prefix.
+func NewS3Target(client s3iface.S3API, bucket, prefix string) *S3Target {
+	return &S3Target{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Deliver saves the signed document of the report as an object.
+func (t *S3Target) Deliver(ctx context.Context, report *SignedReport) error {
+	_, err := t.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(t.bucket),
+		Key:         aws.String(t.prefix + report.Report.Day + "/" + report.Report.Tenant + ".json"),
+		Body:        bytes.NewReader(report.Document),
+		ContentType: aws.String(respsign.JOSEJSONMediaType),
+	})
+	if err != nil {
+		return fmt.Errorf("put object: %w", err)
+	}
+
+	return nil
+}
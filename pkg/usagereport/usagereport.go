@@ -0,0 +1,82 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package usagereport delivers daily usage reports of tenants (key stores): the number of operations by type and
+// the bytes kept in storage. Every instance counts operations it serves (see Counter) and saves the counts to shared
+// storage; the instance that holds the lease (see Generator) aggregates the counts of a day once it's over, signs a
+// report per tenant with the server identity key and delivers it to a Target. Reports that fail to be delivered are
+// saved to the dead-letter store.
+package usagereport
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/aries-framework-go/pkg/common/log"
+)
+
+// SchemaVersion is the version of the report format. It's incremented on changes that aren't backward compatible,
+// i.e. when a field is removed or its meaning changes; new optional fields don't change the version.
+const SchemaVersion = 1
+
+// dayLayout formats days of reports and counts, in UTC.
+const dayLayout = "2006-01-02"
+
+var logger = log.New("usagereport")
+
+// Report is a usage report of a tenant for a day.
+type Report struct {
+	SchemaVersion int `json:"schema_version"`
+	// ID identifies the report of the tenant for the day, so that receivers can drop duplicate deliveries.
+	ID string `json:"id"`
+	// Tenant is the ID of the key store.
+	Tenant string `json:"tenant"`
+	// Day is the UTC day of the report, as YYYY-MM-DD.
+	Day string `json:"day"`
+	// Operations are counts of successful operations of the day by operation type, e.g. "sign".
+	Operations map[string]int64 `json:"operations"`
+	// StorageBytes is the number of bytes the tenant keeps in server storage, measured when the report is generated.
+	StorageBytes int64     `json:"storage_bytes"`
+	GeneratedAt  time.Time `json:"generated_at"`
+}
+
+// NewReport returns a report of the tenant for the day.
+func NewReport(tenant, day string, operations map[string]int64, storageBytes int64, generatedAt time.Time) *Report {
+	if operations == nil {
+		operations = map[string]int64{}
+	}
+
+	return &Report{
+		SchemaVersion: SchemaVersion,
+		ID:            reportID(day, tenant),
+		Tenant:        tenant,
+		Day:           day,
+		Operations:    operations,
+		StorageBytes:  storageBytes,
+		GeneratedAt:   generatedAt.UTC(),
+	}
+}
+
+// Marshal returns the JSON document of the report that is signed. Operation types are sorted, so the same report
+// always has the same document.
+func (r *Report) Marshal() ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("marshal report: %w", err)
+	}
+
+	return b, nil
+}
+
+func reportID(day, tenant string) string {
+	return day + "/" + tenant
+}
+
+// dayOf returns the UTC day of the time.
+func dayOf(t time.Time) string {
+	return t.UTC().Format(dayLayout)
+}
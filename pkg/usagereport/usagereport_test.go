@@ -0,0 +1,550 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package usagereport_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
+	"github.com/hyperledger/aries-framework-go/spi/storage"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/internal/testutil"
+	"github.com/trustbloc/kms/pkg/respsign"
+	"github.com/trustbloc/kms/pkg/signnonce"
+	"github.com/trustbloc/kms/pkg/usagereport"
+)
+
+// updateGolden rewrites golden files with the output of the tests: go test ./pkg/usagereport -update.
+var updateGolden = flag.Bool("update", false, "update golden files") //nolint:gochecknoglobals
+
+var start = time.Date(2022, 6, 1, 9, 30, 0, 0, time.UTC)
+
+func TestReport_Golden(t *testing.T) {
+	const goldenFile = "testdata/report_v1.json"
+
+	report := usagereport.NewReport("z6MkKeyStore", "2022-06-01",
+		map[string]int64{"sign": 120, "verify": 7, "createKey": 1}, 4096, start.Add(25*time.Hour))
+
+	b, err := report.Marshal()
+	require.NoError(t, err)
+
+	var indented bytes.Buffer
+
+	require.NoError(t, json.Indent(&indented, b, "", "  "))
+
+	if *updateGolden {
+		require.NoError(t, os.WriteFile(goldenFile, indented.Bytes(), 0o600))
+	}
+
+	golden, err := os.ReadFile(goldenFile)
+	require.NoError(t, err)
+	require.Equal(t, string(golden), indented.String())
+
+	var decoded usagereport.Report
+
+	require.NoError(t, json.Unmarshal(golden, &decoded))
+	require.Equal(t, report, &decoded)
+	require.Equal(t, usagereport.SchemaVersion, decoded.SchemaVersion)
+}
+
+func TestCounter(t *testing.T) {
+	t.Run("Counts of instances are summed", func(t *testing.T) {
+		provider := mem.NewProvider()
+		clk := testutil.NewFakeClock(start)
+
+		c1, err := usagereport.NewCounter(provider, clk, "instance-1")
+		require.NoError(t, err)
+
+		c2, err := usagereport.NewCounter(provider, clk, "instance-2")
+		require.NoError(t, err)
+
+		c1.Add("ks1", "sign")
+		c1.Add("ks1", "sign")
+		c2.Add("ks1", "sign")
+		c2.Add("ks2", "verify")
+
+		require.NoError(t, c1.Flush())
+		require.NoError(t, c2.Flush())
+
+		// flushing again saves the totals, so counts aren't doubled
+		c1.Add("ks1", "sign")
+		require.NoError(t, c1.Flush())
+		require.NoError(t, c1.Flush())
+
+		counts, err := c1.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{
+			"ks1": {"sign": 4},
+			"ks2": {"verify": 1},
+		}, counts)
+
+		// counts are kept per day
+		clk.Advance(24 * time.Hour)
+		c1.Add("ks1", "sign")
+		require.NoError(t, c1.Flush())
+
+		counts, err = c1.DayCounts("2022-06-02")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{"ks1": {"sign": 1}}, counts)
+
+		require.NoError(t, c1.DeleteDay("2022-06-01"))
+
+		counts, err = c1.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Empty(t, counts)
+	})
+
+	t.Run("Counts that failed to be saved are saved by the next flush", func(t *testing.T) {
+		memProvider := mem.NewProvider()
+		store := &failingStore{Store: mustOpenStore(t, memProvider, usagereport.CountersStoreName)}
+		provider := &storeProvider{Provider: memProvider, store: store}
+
+		c, err := usagereport.NewCounter(provider, testutil.NewFakeClock(start), "instance")
+		require.NoError(t, err)
+
+		c.Add("ks", "sign")
+
+		store.setErr(errors.New("storage unavailable"))
+		require.EqualError(t, c.Flush(), "save counts: storage unavailable")
+
+		store.setErr(nil)
+		require.NoError(t, c.Flush())
+
+		counts, err := c.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{"ks": {"sign": 1}}, counts)
+	})
+
+	t.Run("Middleware counts successful requests of key stores", func(t *testing.T) {
+		c, err := usagereport.NewCounter(mem.NewProvider(), testutil.NewFakeClock(start), "instance")
+		require.NoError(t, err)
+
+		status := http.StatusOK
+
+		router := mux.NewRouter()
+		router.Handle("/v1/keystores/{keystoreID}/keys/{keyID}/sign", c.Middleware("sign", "keystoreID")(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(status) })))
+		router.Handle("/v1/keystores", c.Middleware("createKeyStore", "keystoreID")(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusCreated) })))
+
+		for _, s := range []int{http.StatusOK, http.StatusOK, http.StatusBadRequest, http.StatusInternalServerError} {
+			status = s
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost,
+				"/v1/keystores/ks/keys/key/sign", nil))
+		}
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/keystores", nil))
+
+		require.NoError(t, c.Flush())
+
+		counts, err := c.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{"ks": {"sign": 2}}, counts)
+	})
+
+	t.Run("Middleware doesn't count sign nonce replays", func(t *testing.T) {
+		c, err := usagereport.NewCounter(mem.NewProvider(), testutil.NewFakeClock(start), "instance")
+		require.NoError(t, err)
+
+		replayed := false
+
+		router := mux.NewRouter()
+		router.Handle("/v1/keystores/{keystoreID}/keys/{keyID}/sign", c.Middleware("sign", "keystoreID")(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if replayed {
+					w.Header().Set(signnonce.ReplayedHeader, "true")
+				}
+			})))
+
+		for _, r := range []bool{false, true, true} {
+			replayed = r
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost,
+				"/v1/keystores/ks/keys/key/sign", nil))
+		}
+
+		require.NoError(t, c.Flush())
+
+		counts, err := c.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Equal(t, map[string]map[string]int64{"ks": {"sign": 1}}, counts)
+	})
+}
+
+func TestGenerator(t *testing.T) {
+	t.Run("Signed reports are delivered to the webhook once", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusOK)
+
+		env.counter.Add("ks1", "sign")
+		env.counter.Add("ks1", "sign")
+		env.counter.Add("ks1", "verify")
+		env.counter.Add("ks3", "sign") // deleted key store, not in storage usage
+
+		// the day isn't over
+		env.generator.Run()
+		require.Empty(t, env.receiver.received())
+
+		// the day is over, but counts of other instances may not be flushed yet
+		env.clock.Advance(15 * time.Hour)
+		env.generator.Run()
+		require.Empty(t, env.receiver.received())
+
+		env.clock.Advance(time.Hour)
+		env.generator.Run()
+
+		reports := env.receiver.received()
+		require.Len(t, reports, 3)
+
+		require.Equal(t, "2022-06-01/ks1", reports[0].ID)
+		require.Equal(t, "ks1", reports[0].Tenant)
+		require.Equal(t, "2022-06-01", reports[0].Day)
+		require.Equal(t, map[string]int64{"sign": 2, "verify": 1}, reports[0].Operations)
+		require.EqualValues(t, 100, reports[0].StorageBytes)
+
+		require.Equal(t, "ks2", reports[1].Tenant)
+		require.Empty(t, reports[1].Operations)
+		require.EqualValues(t, 200, reports[1].StorageBytes)
+
+		require.Equal(t, "ks3", reports[2].Tenant)
+		require.Equal(t, map[string]int64{"sign": 1}, reports[2].Operations)
+		require.Zero(t, reports[2].StorageBytes)
+
+		// the day is reported
+		env.generator.Run()
+		require.Len(t, env.receiver.received(), 3)
+
+		counts, err := env.counter.DayCounts("2022-06-01")
+		require.NoError(t, err)
+		require.Empty(t, counts)
+	})
+
+	t.Run("Only the lease holder reports", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusOK)
+
+		other, err := usagereport.New(&usagereport.Config{
+			Provider:     env.provider,
+			Counter:      env.counter,
+			StorageUsage: env.usage,
+			Signer:       env.signer,
+			Target:       usagereport.NewWebhookTarget(env.receiver.server.URL, http.DefaultClient),
+			Clock:        env.clock,
+			InstanceID:   "instance-2",
+			Delay:        30 * time.Minute,
+		})
+		require.NoError(t, err)
+
+		// June 2nd, 00:52: the day would be reported by the other instance, but the lease is held by the first one
+		env.clock.Advance(15*time.Hour + 22*time.Minute)
+
+		env.generator.Run()
+		other.Run()
+		require.Empty(t, env.receiver.received())
+
+		// the other instance claims the lease of the next term first
+		env.clock.Advance(10 * time.Minute)
+
+		other.Run()
+		env.generator.Run()
+		require.Len(t, env.receiver.received(), 2)
+	})
+
+	t.Run("Reports that fail to be delivered are saved to the dead-letter store", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusServiceUnavailable)
+
+		env.clock.Advance(24 * time.Hour)
+		env.generator.Run()
+
+		require.Equal(t, 6, env.receiver.attempts()) // each report is retried twice
+
+		store := mustOpenStore(t, env.provider, usagereport.DeadLetterStoreName)
+
+		b, err := store.Get("2022-06-01/ks1")
+		require.NoError(t, err)
+
+		var deadLetter usagereport.DeadLetter
+
+		require.NoError(t, json.Unmarshal(b, &deadLetter))
+		require.Equal(t, "2022-06-01/ks1", deadLetter.ReportID)
+		require.Equal(t, 3, deadLetter.Attempts)
+		require.Equal(t, "webhook returned status 503", deadLetter.Error)
+
+		payload, err := respsign.VerifyEnvelope(deadLetter.Document, env.signer.KeySet(), time.Now())
+		require.NoError(t, err)
+		require.Contains(t, string(payload), `"tenant":"ks1"`)
+
+		_, err = store.Get("2022-06-01/ks2")
+		require.NoError(t, err)
+
+		// dead letters aren't redelivered
+		env.generator.Run()
+		require.Equal(t, 6, env.receiver.attempts())
+	})
+
+	t.Run("Days before reporting started aren't reported", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusOK)
+
+		env.clock.Advance(3 * 24 * time.Hour)
+		env.generator.Run()
+
+		// June 1st to 3rd
+		require.Len(t, env.receiver.received(), 6)
+	})
+
+	t.Run("Storage usage error", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusOK)
+		env.usage.err = errors.New("storage unavailable")
+
+		env.clock.Advance(24 * time.Hour)
+		env.generator.Run()
+		require.Empty(t, env.receiver.received())
+
+		// measured key stores are reported
+		env.usage.partial = true
+		env.generator.Run()
+		require.Len(t, env.receiver.received(), 2)
+	})
+
+	t.Run("Stop interrupts deliveries", func(t *testing.T) {
+		env := newGeneratorEnv(t, http.StatusServiceUnavailable)
+
+		env.clock.Advance(24 * time.Hour)
+		env.generator.Stop()
+		env.generator.Run()
+
+		require.Zero(t, env.receiver.attempts())
+	})
+}
+
+func TestS3Target(t *testing.T) {
+	client := &s3Client{}
+	target := usagereport.NewS3Target(client, "bucket", "reports/")
+
+	report := &usagereport.SignedReport{
+		Report:   usagereport.NewReport("ks", "2022-06-01", nil, 0, start),
+		Document: []byte(`{"payload":""}`),
+	}
+
+	require.NoError(t, target.Deliver(context.Background(), report))
+	require.Equal(t, "reports/2022-06-01/ks.json", client.key)
+	require.Equal(t, report.Document, client.body)
+
+	client.err = errors.New("s3 unavailable")
+	require.EqualError(t, target.Deliver(context.Background(), report), "put object: s3 unavailable")
+}
+
+type generatorEnv struct {
+	provider  storage.Provider
+	clock     *testutil.FakeClock
+	counter   *usagereport.Counter
+	usage     *storageUsage
+	signer    *respsign.Signer
+	receiver  *receiver
+	generator *usagereport.Generator
+}
+
+func newGeneratorEnv(t *testing.T, status int) *generatorEnv {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := respsign.NewSigner(key)
+	require.NoError(t, err)
+
+	provider := mem.NewProvider()
+	clk := testutil.NewFakeClock(start)
+
+	counter, err := usagereport.NewCounter(provider, clk, "instance-1")
+	require.NoError(t, err)
+
+	recv := newReceiver(t, signer.KeySet(), status)
+	usage := &storageUsage{usage: map[string]int64{"ks1": 100, "ks2": 200}}
+
+	generator, err := usagereport.New(&usagereport.Config{
+		Provider:      provider,
+		Counter:       counter,
+		StorageUsage:  usage,
+		Signer:        signer,
+		Target:        usagereport.NewWebhookTarget(recv.server.URL, http.DefaultClient),
+		Clock:         clk,
+		InstanceID:    "instance-1",
+		RateLimit:     1000,
+		MaxRetries:    2,
+		RetryInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	return &generatorEnv{
+		provider:  provider,
+		clock:     clk,
+		counter:   counter,
+		usage:     usage,
+		signer:    signer,
+		receiver:  recv,
+		generator: generator,
+	}
+}
+
+// receiver is a stub webhook that verifies signed reports.
+type receiver struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	n      int
+	// reports are received reports by ID, in order of delivery
+	reports []*usagereport.Report
+}
+
+func newReceiver(t *testing.T, keys *respsign.KeySet, status int) *receiver {
+	t.Helper()
+
+	r := &receiver{}
+
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.n++
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+
+			return
+		}
+
+		b, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.Equal(t, respsign.JOSEJSONMediaType, req.Header.Get("Content-Type"))
+
+		payload, err := respsign.VerifyEnvelope(b, keys, time.Now())
+		require.NoError(t, err)
+
+		var report usagereport.Report
+
+		require.NoError(t, json.Unmarshal(payload, &report))
+		require.Equal(t, report.ID, req.Header.Get(usagereport.ReportIDHeader))
+
+		r.reports = append(r.reports, &report)
+	}))
+
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *receiver) received() []*usagereport.Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]*usagereport.Report(nil), r.reports...)
+}
+
+func (r *receiver) attempts() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.n
+}
+
+type storageUsage struct {
+	usage   map[string]int64
+	err     error
+	partial bool
+}
+
+func (s *storageUsage) StorageUsage() (map[string]int64, error) {
+	if s.err != nil && !s.partial {
+		return nil, s.err
+	}
+
+	return s.usage, s.err
+}
+
+func mustOpenStore(t *testing.T, provider storage.Provider, name string) storage.Store {
+	t.Helper()
+
+	store, err := provider.OpenStore(name)
+	require.NoError(t, err)
+
+	return store
+}
+
+// storeProvider opens the store for all names.
+type storeProvider struct {
+	storage.Provider
+	store storage.Store
+}
+
+func (p *storeProvider) OpenStore(string) (storage.Store, error) {
+	return p.store, nil
+}
+
+type failingStore struct {
+	storage.Store
+	mutex sync.Mutex
+	err   error
+}
+
+func (s *failingStore) setErr(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.err = err
+}
+
+func (s *failingStore) Put(key string, value []byte, tags ...storage.Tag) error {
+	s.mutex.Lock()
+	err := s.err
+	s.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Put(key, value, tags...)
+}
+
+type s3Client struct {
+	s3iface.S3API
+	key  string
+	body []byte
+	err  error
+}
+
+func (c *s3Client) PutObjectWithContext(_ aws.Context, in *s3.PutObjectInput,
+	_ ...request.Option) (*s3.PutObjectOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.key, c.body = aws.StringValue(in.Key), b
+
+	return &s3.PutObjectOutput{}, nil
+}
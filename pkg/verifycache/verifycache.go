@@ -0,0 +1,127 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verifycache
+
+//go:generate mockgen -destination gomocks_test.go -package verifycache_test . Cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	keyNamespace  = "verify_result"
+	cacheItemCost = 1
+
+	namespace = "kms"
+	subsystem = "verify_cache"
+)
+
+// ErrInvalidSignature is returned for a cached failed verification.
+var ErrInvalidSignature = errors.New("invalid signature (cached result)")
+
+// Cache represents caching functionality. Concrete implementation is expected to be thread-safe, bounded in size,
+// and clean up items when ttl is expired.
+type Cache interface {
+	Get(key interface{}) (interface{}, bool)
+	SetWithTTL(key, value interface{}, cost int64, ttl time.Duration) bool
+}
+
+// VerifyCache caches results of signature verification keyed by a hash of key version, message and signature.
+type VerifyCache struct {
+	cache   Cache
+	ttl     time.Duration
+	metrics *cacheMetrics
+}
+
+// New returns a new VerifyCache.
+func New(cache Cache, ttl time.Duration) *VerifyCache {
+	return &VerifyCache{
+		cache:   cache,
+		ttl:     ttl,
+		metrics: getMetrics(),
+	}
+}
+
+// Get returns a cached result of verification of the signature with the given version of the key, and false if
+// there is no cached result. The key version must change when the key is rotated or disabled, so stale results are
+// not returned.
+func (c *VerifyCache) Get(keyVersion string, message, signature []byte) (valid, ok bool) {
+	v, ok := c.cache.Get(cacheItemID(keyVersion, message, signature))
+	if !ok {
+		c.metrics.misses.Inc()
+
+		return false, false
+	}
+
+	c.metrics.hits.Inc()
+
+	valid, _ = v.(bool) //nolint:errcheck
+
+	return valid, true
+}
+
+// Set caches a result of verification of the signature with the given version of the key.
+func (c *VerifyCache) Set(keyVersion string, message, signature []byte, valid bool) {
+	c.cache.SetWithTTL(cacheItemID(keyVersion, message, signature), valid, cacheItemCost, c.ttl)
+}
+
+func cacheItemID(keyVersion string, message, signature []byte) string {
+	h := sha256.New()
+
+	// length prefixes keep (version, message, signature) triples unambiguous
+	for _, b := range [][]byte{[]byte(keyVersion), message, signature} {
+		var l [8]byte
+
+		binary.BigEndian.PutUint64(l[:], uint64(len(b)))
+
+		h.Write(l[:])
+		h.Write(b)
+	}
+
+	return keyNamespace + "_" + hex.EncodeToString(h.Sum(nil))
+}
+
+var (
+	metricsOnce     sync.Once     //nolint:gochecknoglobals
+	metricsInstance *cacheMetrics //nolint:gochecknoglobals
+)
+
+type cacheMetrics struct {
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+func getMetrics() *cacheMetrics {
+	metricsOnce.Do(func() {
+		m := &cacheMetrics{
+			hits:   newCounter("hits_count", "The total number of verifications served from the cache"),
+			misses: newCounter("misses_count", "The total number of verifications not found in the cache"),
+		}
+
+		prometheus.MustRegister(m.hits, m.misses)
+
+		metricsInstance = m
+	})
+
+	return metricsInstance
+}
+
+func newCounter(name, help string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	})
+}
@@ -0,0 +1,77 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package verifycache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/trustbloc/kms/pkg/verifycache"
+)
+
+func TestVerifyCache_Get(t *testing.T) {
+	t.Run("Cache miss", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		c := NewMockCache(ctrl)
+		c.EXPECT().Get(gomock.Any()).Return(nil, false).Times(1)
+
+		valid, ok := verifycache.New(c, time.Minute).Get("ks/key@1", []byte("msg"), []byte("sig"))
+		require.False(t, ok)
+		require.False(t, valid)
+	})
+
+	t.Run("Cache hit", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		c := NewMockCache(ctrl)
+		c.EXPECT().Get(gomock.Any()).Return(true, true).Times(1)
+
+		valid, ok := verifycache.New(c, time.Minute).Get("ks/key@1", []byte("msg"), []byte("sig"))
+		require.True(t, ok)
+		require.True(t, valid)
+	})
+
+	t.Run("Cache hit with failed verification", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+
+		c := NewMockCache(ctrl)
+		c.EXPECT().Get(gomock.Any()).Return(false, true).Times(1)
+
+		valid, ok := verifycache.New(c, time.Minute).Get("ks/key@1", []byte("msg"), []byte("sig"))
+		require.True(t, ok)
+		require.False(t, valid)
+	})
+}
+
+func TestVerifyCache_Set(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	c := NewMockCache(ctrl)
+
+	var keys []interface{}
+
+	c.EXPECT().SetWithTTL(gomock.Any(), true, int64(1), time.Minute).
+		Do(func(key, value interface{}, cost int64, ttl time.Duration) {
+			keys = append(keys, key)
+		}).Times(3)
+
+	vc := verifycache.New(c, time.Minute)
+
+	vc.Set("ks/key@1", []byte("msg"), []byte("sig"), true)
+	// a new key version (e.g. after rotation) results in a different key
+	vc.Set("ks/key@2", []byte("msg"), []byte("sig"), true)
+	// moving bytes between message and signature results in a different key
+	vc.Set("ks/key@1", []byte("ms"), []byte("gsig"), true)
+
+	require.Len(t, keys, 3)
+	require.NotEqual(t, keys[0], keys[1])
+	require.NotEqual(t, keys[0], keys[2])
+}
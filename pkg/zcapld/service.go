@@ -7,13 +7,13 @@ SPDX-License-Identifier: Apache-2.0
 package zcapld
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	cryptoapi "github.com/hyperledger/aries-framework-go/pkg/crypto"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
@@ -21,39 +21,73 @@ import (
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite/ed25519signature2018"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/util/signature"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
-	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/igor-pavlenko/httpsignatures-go"
 	"github.com/piprate/json-gold/ld"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/kms/pkg/canonicalization"
+	"github.com/trustbloc/kms/pkg/clock"
+	"github.com/trustbloc/kms/pkg/didkey"
+	"github.com/trustbloc/kms/pkg/gziplimit"
 )
 
 const (
 	zcapsStoreName = "zcaps"
+	// supersededSuffix is appended to the ID of a capability to store the invoker of the capability it replaced.
+	supersededSuffix = "#superseded"
 )
 
+// ErrCapabilitySuperseded is returned by CheckInvoker if the capability has been replaced by a capability for another
+// invoker.
+var ErrCapabilitySuperseded = errors.New("capability has been superseded")
+
+// supersededCapability is the invoker of a replaced capability and the time until which it is still accepted.
+type supersededCapability struct {
+	Invoker string    `json:"invoker"`
+	Until   time.Time `json:"until"`
+}
+
 // Service to provide zcapld functionality.
 type Service struct {
 	keyManager   kms.KeyManager
 	crypto       cryptoapi.Crypto
 	store        storage.Store
 	jsonLDLoader ld.DocumentLoader
+	clock        clock.Clock
+}
+
+// Option configures Service.
+type Option func(s *Service)
+
+// WithClock sets the clock used to expire grace periods of superseded capabilities.
+func WithClock(c clock.Clock) Option {
+	return func(s *Service) {
+		s.clock = c
+	}
 }
 
 // New return zcap service.
 func New(keyManager kms.KeyManager, crypto cryptoapi.Crypto, sp storage.Provider,
-	jsonLDLoader ld.DocumentLoader) (*Service, error) {
+	jsonLDLoader ld.DocumentLoader, opts ...Option) (*Service, error) {
 	store, err := sp.OpenStore(zcapsStoreName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open store: %w", err)
 	}
 
-	return &Service{
+	s := &Service{
 		keyManager:   keyManager,
 		crypto:       crypto,
 		store:        store,
 		jsonLDLoader: jsonLDLoader,
-	}, nil
+		clock:        clock.Real(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
 }
 
 // CreateDIDKey create did key.
@@ -63,7 +97,7 @@ func (s *Service) CreateDIDKey(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("failed to create crypto signer: %w", err)
 	}
 
-	return didKeyURL(signer.PublicKeyBytes()), nil
+	return didKeyURL(signer.PublicKeyBytes())
 }
 
 // SignHeader sign header.
@@ -108,11 +142,16 @@ func (s *Service) NewCapability(ctx context.Context, options ...zcapld.Capabilit
 		return nil, fmt.Errorf("failed to create a new signer: %w", err)
 	}
 
+	verificationMethod, err := didKeyURL(signer.PublicKeyBytes())
+	if err != nil {
+		return nil, err
+	}
+
 	zcap, err := zcapld.NewCapability(
 		&zcapld.Signer{
 			SignatureSuite:     ed25519signature2018.New(suite.WithSigner(signer)),
 			SuiteType:          ed25519signature2018.SignatureType,
-			VerificationMethod: didKeyURL(signer.PublicKeyBytes()),
+			VerificationMethod: verificationMethod,
 			ProcessorOpts:      []jsonld.ProcessorOpts{jsonld.WithDocumentLoader(s.jsonLDLoader)},
 		},
 		options...,
@@ -149,6 +188,74 @@ func (s *Service) Resolve(uri string) (*zcapld.Capability, error) {
 	return capability, nil
 }
 
+// Delete deletes the capability from storage. Capabilities delegated from it can no longer be verified.
+func (s *Service) Delete(uri string) error {
+	if err := s.store.Delete(uri); err != nil {
+		return fmt.Errorf("failed to delete zcap from storage: %w", err)
+	}
+
+	if err := s.store.Delete(uri + supersededSuffix); err != nil && !errors.Is(err, storage.ErrDataNotFound) {
+		return fmt.Errorf("failed to delete superseded zcap from storage: %w", err)
+	}
+
+	return nil
+}
+
+// Supersede keeps the invoker of the stored capability accepted by CheckInvoker until the given time. It is called
+// before the capability is replaced by a new capability with the same ID.
+func (s *Service) Supersede(uri string, until time.Time) error {
+	capability, err := s.Resolve(uri)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(supersededCapability{Invoker: capability.Invoker, Until: until})
+	if err != nil {
+		return fmt.Errorf("failed to marshal superseded zcap: %w", err)
+	}
+
+	if err = s.store.Put(uri+supersededSuffix, raw); err != nil {
+		return fmt.Errorf("failed to store superseded zcap: %w", err)
+	}
+
+	return nil
+}
+
+// CheckInvoker checks that the invoker can invoke the stored capability. The invoker must be the invoker of the
+// stored capability, or of the capability it superseded until the grace period ends. Otherwise,
+// ErrCapabilitySuperseded is returned.
+func (s *Service) CheckInvoker(uri, invoker string) error {
+	capability, err := s.Resolve(uri)
+	if err != nil {
+		return err
+	}
+
+	if capability.Invoker == invoker {
+		return nil
+	}
+
+	raw, err := s.store.Get(uri + supersededSuffix)
+	if errors.Is(err, storage.ErrDataNotFound) {
+		return ErrCapabilitySuperseded
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch superseded zcap from storage: %w", err)
+	}
+
+	var superseded supersededCapability
+
+	if err = json.Unmarshal(raw, &superseded); err != nil {
+		return fmt.Errorf("failed to unmarshal superseded zcap: %w", err)
+	}
+
+	if superseded.Invoker != invoker || !s.clock.Now().Before(superseded.Until) {
+		return ErrCapabilitySuperseded
+	}
+
+	return nil
+}
+
 // KMS returns the kms.KeyManager.
 func (s *Service) KMS() kms.KeyManager {
 	return s.keyManager
@@ -159,36 +266,58 @@ func (s *Service) Crypto() cryptoapi.Crypto {
 	return s.crypto
 }
 
-// CompressZCAP gzips the zcap, then base64URL-encodes it.
+// CompressZCAP serializes the zcap in canonical form (JCS), gzips it, then base64URL-encodes it. The same zcap always
+// compresses to the same bytes.
 func CompressZCAP(zcap *zcapld.Capability) ([]byte, error) {
 	if zcap == nil {
 		return nil, fmt.Errorf("marshal zcap: %s", "zcap is nil")
 	}
 
-	raw, err := json.Marshal(zcap)
+	raw, err := canonicalization.MarshalJCS(zcap)
 	if err != nil {
 		return nil, fmt.Errorf("marshal zcap: %w", err)
 	}
 
-	compressed := bytes.NewBuffer(nil)
+	compressed, err := gziplimit.Compress(raw)
+	if err != nil {
+		return nil, fmt.Errorf("compress zcap: %w", err)
+	}
 
-	w := gzip.NewWriter(compressed)
+	return compressed, nil
+}
 
-	_, err = w.Write(raw)
+// DecompressZCAP gunzips and parses the zcap compressed with CompressZCAP. A zcap that decompresses to more than
+// maxSize bytes is rejected with a gziplimit.LimitError; a maxSize that is not positive means gziplimit.DefaultMaxSize.
+func DecompressZCAP(compressed []byte, maxSize int64) (*zcapld.Capability, error) {
+	raw, err := gziplimit.Decompress(compressed, maxSize)
 	if err != nil {
-		return nil, fmt.Errorf("compress zcap: %w", err)
+		return nil, fmt.Errorf("decompress zcap: %w", err)
 	}
 
-	err = w.Close()
+	zcap, err := zcapld.ParseCapability(raw)
 	if err != nil {
-		return nil, fmt.Errorf("close gzip writer: %w", err)
+		return nil, fmt.Errorf("parse zcap: %w", err)
 	}
 
-	return compressed.Bytes(), nil
+	return zcap, nil
 }
 
-func didKeyURL(pubKeyBytes []byte) string {
-	_, didKeyURL := fingerprint.CreateDIDKey(pubKeyBytes)
+// DecodeZCAP decodes the base64URL-encoded compressed zcap, e.g. of the Capability-Invocation header. See
+// DecompressZCAP.
+func DecodeZCAP(value string, maxSize int64) (*zcapld.Capability, error) {
+	compressed, err := base64.URLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decode zcap: %w", err)
+	}
+
+	return DecompressZCAP(compressed, maxSize)
+}
+
+func didKeyURL(pubKeyBytes []byte) (string, error) {
+	didKey, err := didkey.FromPublicKey(pubKeyBytes, kms.ED25519Type)
+	if err != nil {
+		return "", fmt.Errorf("create did:key: %w", err)
+	}
 
-	return didKeyURL
+	return didKey.VerificationMethod, nil
 }
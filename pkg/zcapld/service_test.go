@@ -7,13 +7,20 @@ SPDX-License-Identifier: Apache-2.0
 package zcapld_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/verifiable"
 	mockcrypto "github.com/hyperledger/aries-framework-go/pkg/mock/crypto"
 	mockkms "github.com/hyperledger/aries-framework-go/pkg/mock/kms"
 	mockldstore "github.com/hyperledger/aries-framework-go/pkg/mock/ld"
@@ -24,13 +31,15 @@ import (
 	zcapld2 "github.com/trustbloc/edge-core/pkg/zcapld"
 	"golang.org/x/net/context"
 
+	"github.com/trustbloc/kms/pkg/gziplimit"
+	"github.com/trustbloc/kms/pkg/internal/testutil"
 	"github.com/trustbloc/kms/pkg/zcapld"
 )
 
 func TestNew(t *testing.T) {
 	t.Run("error if cannot open store", func(t *testing.T) {
 		_, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{ErrOpenStoreHandle: errors.New("test")},
 			createTestDocumentLoader(t),
@@ -58,7 +67,7 @@ func TestService_CreateDIDKey(t *testing.T) {
 
 	t.Run("test success", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{},
 			createTestDocumentLoader(t),
@@ -70,12 +79,27 @@ func TestService_CreateDIDKey(t *testing.T) {
 		require.NoError(t, err)
 		require.NotEmpty(t, didKey)
 	})
+
+	t.Run("test error from invalid public key", func(t *testing.T) {
+		svc, err := zcapld.New(
+			&mockkms.KeyManager{ExportPubKeyBytesValue: []byte("invalid")},
+			&mockcrypto.Crypto{},
+			&mockstorage.MockStoreProvider{},
+			createTestDocumentLoader(t),
+		)
+		require.NoError(t, err)
+
+		didKey, err := svc.CreateDIDKey(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "create did:key")
+		require.Empty(t, didKey)
+	})
 }
 
 func TestService_SignHeader(t *testing.T) {
 	t.Run("test error from parse capability", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{},
 			createTestDocumentLoader(t),
@@ -89,7 +113,7 @@ func TestService_SignHeader(t *testing.T) {
 
 	t.Run("test error from sign header", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{},
 			createTestDocumentLoader(t),
@@ -112,7 +136,7 @@ func TestService_NewCapability(t *testing.T) {
 		target := xid.New().String()
 		allowedAction := []string{xid.New().String(), xid.New().String()}
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{Store: make(map[string]mockstorage.DBEntry)}},
 			createTestDocumentLoader(t),
@@ -145,7 +169,7 @@ func TestService_NewCapability(t *testing.T) {
 
 	t.Run("error if cannot create zcap", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{SignErr: errors.New("test")},
 			&mockstorage.MockStoreProvider{},
 			createTestDocumentLoader(t),
@@ -158,7 +182,7 @@ func TestService_NewCapability(t *testing.T) {
 
 	t.Run("error if cannot save zcap to store", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{
 				Store:  make(map[string]mockstorage.DBEntry),
@@ -173,13 +197,104 @@ func TestService_NewCapability(t *testing.T) {
 	})
 }
 
+func TestCompressZCAP(t *testing.T) {
+	t.Run("Compresses the zcap in canonical form", func(t *testing.T) {
+		zcap := &zcapld2.Capability{
+			Context:          "https://w3id.org/security/v2",
+			ID:               "urn:zcap:root",
+			Invoker:          "did:key:z6MkInvoker",
+			Controller:       "did:key:z6MkController",
+			AllowedAction:    []string{"sign", "verify"},
+			InvocationTarget: zcapld2.InvocationTarget{ID: "https://kms.example.com/v1/keystores/ks", Type: "urn:kms:keystore"},
+			Caveats:          []zcapld2.Caveat{{Type: "expiry", Duration: 600}},
+			Proof: []verifiable.Proof{{
+				"type":               "Ed25519Signature2018",
+				"proofPurpose":       "capabilityDelegation",
+				"created":            "2022-01-01T00:00:00Z",
+				"verificationMethod": "did:key:z6MkController#z6MkController",
+				"jws":                "eyJhbGciOiJFZERTQSJ9..c2lnbmF0dXJl",
+			}},
+		}
+
+		compressed, err := zcapld.CompressZCAP(zcap)
+		require.NoError(t, err)
+
+		again, err := zcapld.CompressZCAP(zcap)
+		require.NoError(t, err)
+		require.Equal(t, compressed, again)
+
+		r, err := gzip.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+
+		raw, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, `{"@context":"https://w3id.org/security/v2","allowedAction":["sign","verify"],`+
+			`"caveats":[{"duration":600,"type":"expiry"}],"controller":"did:key:z6MkController",`+
+			`"id":"urn:zcap:root","invocationTarget":{"ID":"https://kms.example.com/v1/keystores/ks",`+
+			`"Type":"urn:kms:keystore"},"invoker":"did:key:z6MkInvoker","proof":[{"created":"2022-01-01T00:00:00Z",`+
+			`"jws":"eyJhbGciOiJFZERTQSJ9..c2lnbmF0dXJl","proofPurpose":"capabilityDelegation",`+
+			`"type":"Ed25519Signature2018","verificationMethod":"did:key:z6MkController#z6MkController"}]}`,
+			string(raw))
+	})
+
+	t.Run("Fail with nil zcap", func(t *testing.T) {
+		_, err := zcapld.CompressZCAP(nil)
+		require.EqualError(t, err, "marshal zcap: zcap is nil")
+	})
+}
+
+func TestDecompressZCAP(t *testing.T) {
+	zcap := &zcapld2.Capability{
+		Context:       "https://w3id.org/security/v2",
+		ID:            "urn:zcap:root",
+		Invoker:       "did:key:z6MkInvoker",
+		AllowedAction: []string{"sign"},
+	}
+
+	compressed, err := zcapld.CompressZCAP(zcap)
+	require.NoError(t, err)
+
+	t.Run("Decompresses the zcap", func(t *testing.T) {
+		decompressed, err := zcapld.DecompressZCAP(compressed, 0)
+		require.NoError(t, err)
+		require.Equal(t, zcap.ID, decompressed.ID)
+		require.Equal(t, zcap.Invoker, decompressed.Invoker)
+
+		decoded, err := zcapld.DecodeZCAP(base64.URLEncoding.EncodeToString(compressed), 0)
+		require.NoError(t, err)
+		require.Equal(t, decompressed, decoded)
+	})
+
+	t.Run("Fail with zcap over the max size", func(t *testing.T) {
+		_, err := zcapld.DecompressZCAP(compressed, 16)
+		require.EqualError(t, err, "decompress zcap: max_decompressed_size 16 exceeded")
+
+		var limitErr *gziplimit.LimitError
+
+		require.True(t, errors.As(err, &limitErr))
+	})
+
+	t.Run("Fail with invalid zcap", func(t *testing.T) {
+		notZCAP, err := gziplimit.Compress([]byte("not a zcap"))
+		require.NoError(t, err)
+
+		_, err = zcapld.DecompressZCAP(notZCAP, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "parse zcap")
+
+		_, err = zcapld.DecodeZCAP("!", 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "decode zcap")
+	})
+}
+
 func TestService_Resolve(t *testing.T) {
 	t.Run("resolves zcap from store", func(t *testing.T) {
 		store := &mockstorage.MockStore{
 			Store: make(map[string]mockstorage.DBEntry),
 		}
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{Store: store},
 			createTestDocumentLoader(t),
@@ -203,7 +318,7 @@ func TestService_Resolve(t *testing.T) {
 
 	t.Run("error if cannot get zcap from store", func(t *testing.T) {
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{
 				Store:  make(map[string]mockstorage.DBEntry),
@@ -227,7 +342,7 @@ func TestService_Resolve(t *testing.T) {
 			},
 		}
 		svc, err := zcapld.New(
-			&mockkms.KeyManager{},
+			newKeyManager(),
 			&mockcrypto.Crypto{},
 			&mockstorage.MockStoreProvider{Store: store},
 			createTestDocumentLoader(t),
@@ -242,6 +357,129 @@ func TestService_Resolve(t *testing.T) {
 	})
 }
 
+func TestService_Delete(t *testing.T) {
+	t.Run("deletes zcap from store", func(t *testing.T) {
+		store := &mockstorage.MockStore{
+			Store: map[string]mockstorage.DBEntry{
+				"uri": {Value: []byte("capability")},
+			},
+		}
+		svc, err := zcapld.New(
+			newKeyManager(),
+			&mockcrypto.Crypto{},
+			&mockstorage.MockStoreProvider{Store: store},
+			createTestDocumentLoader(t),
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, svc.Delete("uri"))
+		require.NotContains(t, store.Store, "uri")
+	})
+
+	t.Run("error if cannot delete zcap from store", func(t *testing.T) {
+		svc, err := zcapld.New(
+			newKeyManager(),
+			&mockcrypto.Crypto{},
+			&mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{
+				Store:     make(map[string]mockstorage.DBEntry),
+				ErrDelete: errors.New("delete error"),
+			}},
+			createTestDocumentLoader(t),
+		)
+		require.NoError(t, err)
+
+		err = svc.Delete("uri")
+		require.EqualError(t, err, "failed to delete zcap from storage: delete error")
+	})
+}
+
+func TestService_CheckInvoker(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	var clk *testutil.FakeClock
+
+	newService := func(t *testing.T) *zcapld.Service {
+		t.Helper()
+
+		clk = testutil.NewFakeClock(now)
+
+		svc, err := zcapld.New(
+			newKeyManager(),
+			&mockcrypto.Crypto{},
+			&mockstorage.MockStoreProvider{Store: &mockstorage.MockStore{Store: make(map[string]mockstorage.DBEntry)}},
+			createTestDocumentLoader(t),
+			zcapld.WithClock(clk),
+		)
+		require.NoError(t, err)
+
+		_, err = svc.NewCapability(context.Background(), zcapld2.WithID("uri"), zcapld2.WithInvoker("did:example:old"))
+		require.NoError(t, err)
+
+		return svc
+	}
+
+	replace := func(t *testing.T, svc *zcapld.Service, until time.Time) {
+		t.Helper()
+
+		require.NoError(t, svc.Supersede("uri", until))
+
+		_, err := svc.NewCapability(context.Background(), zcapld2.WithID("uri"), zcapld2.WithInvoker("did:example:new"))
+		require.NoError(t, err)
+	}
+
+	t.Run("accepts invoker of stored zcap", func(t *testing.T) {
+		svc := newService(t)
+
+		require.NoError(t, svc.CheckInvoker("uri", "did:example:old"))
+		require.ErrorIs(t, svc.CheckInvoker("uri", "did:example:other"), zcapld.ErrCapabilitySuperseded)
+	})
+
+	t.Run("accepts superseded invoker during grace period", func(t *testing.T) {
+		svc := newService(t)
+		replace(t, svc, now.Add(time.Hour))
+
+		require.NoError(t, svc.CheckInvoker("uri", "did:example:new"))
+		require.NoError(t, svc.CheckInvoker("uri", "did:example:old"))
+		require.ErrorIs(t, svc.CheckInvoker("uri", "did:example:other"), zcapld.ErrCapabilitySuperseded)
+	})
+
+	t.Run("rejects superseded invoker after grace period", func(t *testing.T) {
+		svc := newService(t)
+		replace(t, svc, now.Add(time.Hour))
+
+		require.NoError(t, svc.CheckInvoker("uri", "did:example:old"))
+
+		clk.Advance(time.Hour)
+
+		require.NoError(t, svc.CheckInvoker("uri", "did:example:new"))
+		require.ErrorIs(t, svc.CheckInvoker("uri", "did:example:old"), zcapld.ErrCapabilitySuperseded)
+	})
+
+	t.Run("superseded invoker is deleted with zcap", func(t *testing.T) {
+		svc := newService(t)
+		replace(t, svc, now.Add(time.Hour))
+
+		require.NoError(t, svc.Delete("uri"))
+
+		_, err := svc.NewCapability(context.Background(), zcapld2.WithID("uri"), zcapld2.WithInvoker("did:example:new"))
+		require.NoError(t, err)
+
+		require.ErrorIs(t, svc.CheckInvoker("uri", "did:example:old"), zcapld.ErrCapabilitySuperseded)
+	})
+
+	t.Run("error if zcap is not found", func(t *testing.T) {
+		svc := newService(t)
+
+		require.Error(t, svc.Supersede("unknown", now))
+		require.Error(t, svc.CheckInvoker("unknown", "did:example:old"))
+	})
+}
+
+// newKeyManager returns a mock key manager that exports a valid ED25519 public key.
+func newKeyManager() *mockkms.KeyManager {
+	return &mockkms.KeyManager{ExportPubKeyBytesValue: make([]byte, ed25519.PublicKeySize)}
+}
+
 func createTestDocumentLoader(t *testing.T) *ld.DocumentLoader {
 	t.Helper()
 
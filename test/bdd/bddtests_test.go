@@ -31,10 +31,15 @@ const (
 	caCertPath      = "fixtures/keys/tls/ec-cacert.pem"
 	composeDir      = "./fixtures/"
 	composeFilePath = composeDir + "docker-compose.yml"
+
+	dependencyLogLines = 50
 )
 
 var logger = log.New("kms/bdd")
 
+// healthPoller records readiness of docker-compose dependencies during the run, nil if disabled.
+var healthPoller *context.HealthPoller //nolint:gochecknoglobals
+
 func TestMain(m *testing.M) {
 	// default is to run all tests with tag @all but excluding those marked with @wip
 	tags := "all && ~@wip"
@@ -108,12 +113,18 @@ func initializeTestSuite(ctx *godog.TestSuiteContext) {
 				}
 			}
 
+			startHealthPoller()
+
 			logger.Infof("*** testSleep=%d\n\n", testSleep)
 			time.Sleep(time.Second * time.Duration(testSleep))
 		}
 	})
 
 	ctx.AfterSuite(func() {
+		if healthPoller != nil {
+			healthPoller.Stop()
+		}
+
 		if compose {
 			logger.Infof("Running %s", strings.Join(dockerComposeDown, " "))
 
@@ -125,6 +136,30 @@ func initializeTestSuite(ctx *godog.TestSuiteContext) {
 	})
 }
 
+// startHealthPoller starts recording readiness of dependencies, so that environmental failures can be told apart
+// from test failures. Set DISABLE_HEALTH_POLLER=true to disable it.
+func startHealthPoller() {
+	if os.Getenv("DISABLE_HEALTH_POLLER") == "true" {
+		return
+	}
+
+	caCertPathVal := caCertPath
+	if os.Getenv("DISABLE_CUSTOM_CA") == "true" {
+		caCertPathVal = ""
+	}
+
+	poller, err := context.NewHealthPoller(caCertPathVal, context.ComposeDependencies())
+	if err != nil {
+		logger.Errorf("Failed to create dependency health poller: %s", err.Error())
+
+		return
+	}
+
+	poller.Start()
+
+	healthPoller = poller
+}
+
 type feature interface {
 	// SetContext is called before every scenario is run with a fresh new context.
 	SetContext(*context.BDDContext)
@@ -160,11 +195,23 @@ func initializeScenario(ctx *godog.ScenarioContext) {
 		f.RegisterSteps(ctx)
 	}
 
+	var scenarioStart time.Time
+
 	ctx.BeforeScenario(func(sc *godog.Scenario) {
+		scenarioStart = time.Now()
+
 		for _, f := range features {
 			f.SetContext(bddContext)
 		}
 	})
+
+	// godog hooks can't change the error of a failed step, so the report is logged right after it
+	ctx.AfterStep(func(st *godog.Step, err error) {
+		if err != nil && healthPoller != nil {
+			logger.Errorf("Step %q failed: %s\n%s", st.Text, err.Error(),
+				healthPoller.Report(scenarioStart, dependencyLogLines))
+		}
+	})
 }
 
 func buildOptions(tags, format string) *godog.Options {
@@ -13,3 +13,22 @@ Feature: Keystore management operations
     And   Hub Auth is running on "auth.trustbloc.local" port "8070"
     When  user makes an HTTP POST to "https://localhost:4466/v1/keystores" to create a keystore
     Then  user gets a response with HTTP status "200 OK" and valid key store URL and root capabilities
+
+  Scenario Outline: User creates a keystore with an invalid storage mode
+    Given Key Server is running on "localhost" port "4466"
+    And   Hub Auth is running on "auth.trustbloc.local" port "8070"
+    When  user makes an HTTP POST to "https://localhost:4466/v1/keystores" to create a keystore with storage mode "<mode>" <edv> EDV options
+    Then  user gets a response with HTTP status "400 Bad Request" and error "<error>"
+
+    Examples:
+      | mode         | edv     | error                                               |
+      | LocalStorage | without | must be one of [local edv]                          |
+      | EDV          | with    | must be one of [local edv]                          |
+      | local        | with    | edv options are not allowed with storage_mode local |
+      | edv          | without | edv options are required with storage_mode edv      |
+
+  # orb-kms is started with KMS_EDV_DISABLE=true
+  Scenario: User creates a keystore with EDV storage mode on a server without EDV support
+    Given Hub Auth is running on "auth.trustbloc.local" port "8070"
+    When  user makes an HTTP POST to "https://localhost:8078/v1/keystores" to create a keystore with storage mode "edv" with EDV options
+    Then  user gets a response with HTTP status "400 Bad Request" and error "storage_mode edv is not supported by the server"
@@ -24,12 +24,26 @@ Feature: KMS and crypto operations
     Then  "Alice" gets a response with HTTP status "200 OK"
      And  "Alice" gets a response with non-empty "key_url"
 
+  Scenario: User retries keystore creation with an idempotency key
+    Given "Alice" has created an empty keystore on Key Server retrying with the same idempotency key
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "key_url"
+
   Scenario: User creates multiple keys with parallel requests
     Given "Alice" has created an empty keystore on Key Server
 
     When  "Alice" makes parallel HTTP POST requests to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "AES128GCM,ChaCha20Poly1305,XChaCha20Poly1305,ED25519,HMACSHA256Tag256,NISTP256ECDHKW,X25519ECDHKW,BLS12381G2" keys
     Then  "Alice" gets a response with HTTP status "200 OK" for each request
 
+  Scenario: User creates multiple keys with a batch request
+    Given "Alice" has created an empty keystore on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/batch" to create "ED25519,ECDSAP256DER,X25519ECDHKW,BLS12381G2,HMACSHA256Tag256" keys in a batch
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "key_count" with value "5"
+
   Scenario: User exports a public key
     Given "Bob" has created a keystore with "ED25519" key on Key Server
 
@@ -37,6 +51,104 @@ Feature: KMS and crypto operations
     Then  "Bob" gets a response with HTTP status "200 OK"
      And  "Bob" gets a response with non-empty "public_key"
 
+  Scenario: User exports a public key as JWK and verifies a signature with it
+    Given "Bob" has created a keystore with "ED25519" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export?format=jwk" to export public key as JWK and verifies signature of "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "kid" with value "https://kms.trustbloc.local:8076/v1/keystores/([^/]+)/keys/([^/]+)"
+     And  "Bob" gets a response with "alg" with value "EdDSA"
+
+  Scenario: User signs JWT claims and verifies the JWS with the exported JWK
+    Given "Bob" has created a keystore with "ED25519" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/signjwt" to sign JWT claims '{"iss": "did:example:issuer", "sub": "did:example:subject"}'
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export?format=jwk" to export public key as JWK and verifies JWS of claims '{"iss": "did:example:issuer", "sub": "did:example:subject"}'
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/signjwt" to sign JWT claims '{"iss": "did:example:issuer"}' detached
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export?format=jwk" to export public key as JWK and verifies JWS of claims '{"iss": "did:example:issuer"}'
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/signjwt" to sign JWT claims '["not", "an", "object"]'
+    Then  "Bob" gets a response with HTTP status "400 Bad Request"
+
+  Scenario Outline: User signs with a <keyType> key and verifies the signature with the exported public key
+    Given "Bob" has created a keystore with "<keyType>" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export" to export public key and verifies ECDSA signature of "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with no "errMessage"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export?format=jwk" to export public key as JWK and verifies signature of "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "alg" with value "<alg>"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export?format=did" to export public key as did:key
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "did" with value "^did:key:<didPrefix>"
+
+    # ED25519, secp256k1 and BLS12381G2 keys are covered by their own scenarios
+    Examples:
+      | keyType            | alg   | didPrefix |
+      | ECDSAP256DER       | ES256 | zDn       |
+      | ECDSAP256IEEEP1363 | ES256 | zDn       |
+      | ECDSAP384DER       | ES384 | z82       |
+      | ECDSAP384IEEEP1363 | ES384 | z82       |
+      | ECDSAP521DER       | ES512 | z2J9      |
+      | ECDSAP521IEEEP1363 | ES512 | z2J9      |
+
+  Scenario: User signs with a ECDSASecp256k1DER key and verifies the signature with another secp256k1 library
+    Given "Bob" has created a keystore with "ECDSASecp256k1DER" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export" to export public key and verifies secp256k1 signature of "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with no "errMessage"
+
+  Scenario: User signs with a ECDSASecp256k1IEEEP1363 key and verifies the signature with another secp256k1 library
+    Given "Bob" has created a keystore with "ECDSASecp256k1IEEEP1363" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/export" to export public key and verifies secp256k1 signature of "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with no "errMessage"
+
   Scenario: User creates and exports a key
     Given "Alice" has created an empty keystore on Key Server
 
@@ -52,6 +164,18 @@ Feature: KMS and crypto operations
     Then  "Bob" gets a response with HTTP status "200 OK"
      And  "Bob" gets a response with "key_url" with value "https://kms.trustbloc.local:8076/v1/keystores/([^/]+)/keys/keyID"
 
+  Scenario: User imports a private key in JWK format
+    Given "Bob" has created an empty keystore on Key Server
+
+    When  "Bob" makes an HTTP PUT to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to import "ECDSAP256DER" private key in JWK format
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with non-empty "key_url"
+     And  "Bob" gets a response with non-empty "public_key"
+
+    When  "Bob" makes an HTTP PUT to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to import "AES256GCM" private key in JWK format
+    Then  "Bob" gets a response with HTTP status "422 Unprocessable Entity"
+     And  "Bob" gets a response with "errMessage" with value "importable key types: ED25519, ECDSAP256DER"
+
   Scenario: User signs a message and verifies a signature
     Given "Alice" has created a keystore with "ED25519" key on Key Server
 
@@ -63,6 +187,146 @@ Feature: KMS and crypto operations
     Then  "Alice" gets a response with HTTP status "200 OK"
      And  "Alice" gets a response with no "errMessage"
 
+  Scenario: User B verifies a signature of User A with User A's public key
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Bob" has created a keystore with "ED25519" key on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    Given "Bob" has a public key of "Alice"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/verify" to verify "signature" of "Alice" for "test message" with their "ED25519" public key
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "verified" with value "true"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/verify" to verify "signature" of "Alice" for "other message" with their "ED25519" public key
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "verified" with value "false"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/verify" to verify "signature" of "Alice" for "test message" with their "ECDSAP256DER" public key
+    Then  "Bob" gets a response with HTTP status "422 Unprocessable Entity"
+
+  Scenario: User signs messages with BBS+, verifies a signature and derives a proof
+    Given "Alice" has created a keystore with "BLS12381G2" key on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign 10 messages with BBS+
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "signature"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for 10 messages with BBS+
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with no "errMessage"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/deriveproof" to derive a proof revealing "0,4,9" of 10 messages
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verifyproof" to verify "proof" revealing "0,4,9" of 10 messages
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/deriveproof" to derive a proof revealing "0,10" of 10 messages
+    Then  "Alice" gets a response with HTTP status "422 Unprocessable Entity"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/deriveproof" to derive a proof revealing "4,4" of 10 messages
+    Then  "Alice" gets a response with HTTP status "422 Unprocessable Entity"
+
+  Scenario: User signs a batch of messages and verifies a signature
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign/batch" to sign "message 1,message 2,message 3" in a batch
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "signature_count" with value "3"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature_1" for "message 2"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+  Scenario: User signs with a key alias and renames the alias
+    Given "Alice" has created an empty keystore on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key with alias "signing-key"
+    Then  "Alice" gets a response with HTTP status "201 Created"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key with alias "signing-key"
+    Then  "Alice" gets a response with HTTP status "409 Conflict"
+     And  "Alice" gets a response with non-empty "key_url"
+
+    When  "Alice" refers to the key by alias "signing-key"
+     And  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP PATCH to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to set key alias "release-key"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "alias" with value "release-key"
+
+    When  "Alice" refers to the key by alias "release-key"
+     And  "Alice" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to get the key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "alias" with value "release-key"
+
+  Scenario: User creates a key with an expiration time
+    Given "Alice" has created an empty keystore on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key expiring at "2099-01-01T00:00:00Z"
+    Then  "Alice" gets a response with HTTP status "201 Created"
+
+    When  "Alice" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to get the key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "expires_at" with value "2099-01-01T00:00:00Z"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key expiring at "2001-01-01T00:00:00Z"
+    Then  "Alice" gets a response with HTTP status "400 Bad Request"
+
+  Scenario: User creates a key restricted to signing
+    Given "Alice" has created an empty keystore on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key for purposes "sign"
+    Then  "Alice" gets a response with HTTP status "201 Created"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message" with a key not allowed to verify
+    Then  "Alice" gets a response with HTTP status "403 Forbidden"
+     And  "Alice" gets a response with "code" with value "KEY_PURPOSE_NOT_ALLOWED"
+
+  Scenario: User disables and re-enables a key
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+
+    When  "Alice" makes an HTTP PATCH to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/state" to set key state "disabled"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "state" with value "disabled"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "other message" with a disabled key
+    Then  "Alice" gets a response with HTTP status "409 Conflict"
+     And  "Alice" gets a response with "code" with value "KEY_DISABLED"
+
+    When  "Alice" makes an HTTP PATCH to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/state" to set key state "active"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "other message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+  Scenario: User shares a single verification with a one-time token
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/tokens" to mint a one-time token for "verify"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "token"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message" with a one-time token
+    Then  "Alice" gets a response with HTTP status "200 OK"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message" with a one-time token
+    Then  "Alice" gets a response with HTTP status "403 Forbidden"
+
   Scenario: User creates and rotates a key
     Given "Alice" has created a keystore with "AES256GCM" key on Key Server
       And "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/encrypt" to encrypt "test message"
@@ -74,6 +338,82 @@ Feature: KMS and crypto operations
     Then  "Alice" gets a response with HTTP status "200 OK"
      And  "Alice" gets a response with "plaintext" with value "test message"
 
+  Scenario: User verifies a signature made before key rotation
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/rotate" to rotate "ED25519" key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "public_key"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/verify" to verify "signature" for "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with no "errMessage"
+
+  Scenario: User deletes a key
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+
+    When  "Alice" makes an HTTP DELETE to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to delete a key using "sign" action
+    Then  "Alice" gets a response with HTTP status "403 Forbidden"
+
+    When  "Alice" makes an HTTP DELETE to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to delete a key using "deleteKey" action
+    Then  "Alice" gets a response with HTTP status "204 No Content"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message" with a deleted key
+    Then  "Alice" gets a response with HTTP status "404 Not Found"
+
+    When  "Alice" makes an HTTP DELETE to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to delete a key using "deleteKey" action
+    Then  "Alice" gets a response with HTTP status "404 Not Found"
+
+  Scenario: User gets keystore and key metadata
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+
+    When  "Alice" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}" to get the keystore
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "storage_type" with value "edv"
+     And  "Alice" gets a response with "key_count" with value "1"
+
+    When  "Alice" makes an HTTP GET to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}" to get the key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "key_type" with value "ED25519"
+     And  "Alice" gets a response with "exportable" with value "true"
+
+  Scenario: User deletes a keystore
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+
+    When  "Alice" makes an HTTP DELETE to "https://localhost:4466/v1/keystores/{keystoreID}" to delete the keystore
+    Then  "Alice" gets a response with HTTP status "204 No Content"
+
+    When  "Alice" makes an HTTP DELETE to "https://localhost:4466/v1/keystores/{keystoreID}" to delete the keystore
+    Then  "Alice" gets a response with HTTP status "401 Unauthorized"
+
+  Scenario: User creates a keystore without ZCAPs and uses it with the bearer token
+    Given "Alice" has created a keystore without ZCAPs on Key Server
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "key_url"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sign" to sign "test message"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "signature"
+
+  Scenario: Bearer token doesn't authorize keystores with ZCAPs or created by another user
+    Given "Alice" has created a keystore without ZCAPs on Key Server
+      And "Bob" has created an empty keystore on Key Server
+
+    When  "Bob" uses the keystore of "Bob" with the bearer token
+     And  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key and is rejected
+    Then  "Bob" gets a response with HTTP status "403 Forbidden"
+
+    When  "Bob" uses the keystore of "Alice" with the bearer token
+     And  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys" to create "ED25519" key and is rejected
+    Then  "Bob" gets a response with HTTP status "403 Forbidden"
+
+  Scenario: Keystores without ZCAPs are refused by Key Server that doesn't allow them
+    When  "Alice" makes an HTTP POST to "https://localhost:4455/v1/keystores" to create a keystore without ZCAPs
+    Then  "Alice" gets a response with HTTP status "403 Forbidden"
+
   Scenario: User encrypts/decrypts a message
     Given "Bob" has created a keystore with "AES256GCM" key on Key Server
 
@@ -86,6 +426,16 @@ Feature: KMS and crypto operations
     Then  "Bob" gets a response with HTTP status "200 OK"
      And  "Bob" gets a response with "plaintext" with value "test message"
 
+  Scenario: User fails to decrypt a tampered ciphertext
+    Given "Bob" has created a keystore with "AES256GCM" key on Key Server
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/encrypt" to encrypt "test message"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/decrypt" to decrypt "ciphertext" with a flipped byte
+    Then  "Bob" gets a response with HTTP status "400 Bad Request"
+     And  "Bob" gets a response with "code" with value "DECRYPTION_FAILED"
+
   Scenario: User computes/verifies MAC for data
     Given "Alice" has created a keystore with "HMACSHA256Tag256" key on Key Server
 
@@ -110,6 +460,38 @@ Feature: KMS and crypto operations
     Then  "Bob" gets a response with HTTP status "200 OK"
      And  "Bob" gets a response with content of "testCEK" key
 
+  Scenario: User A encrypts a JWE for User B, User B decrypts it with the keystore key
+    Given "Alice" has created a keystore with "NISTP256ECDHKW" key on Key Server
+      And "Bob" has created a keystore with "NISTP256ECDHKW" key on Key Server
+      And "Alice" has a public key of "Bob"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/encryptjwe" to encrypt "test message" as a "compact" JWE for "Bob"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "jwe"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/decryptjwe" to decrypt the JWE from "Alice"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "plaintext" with value "test message"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/encryptjwe" to encrypt "test message" as a JWE with enc "XC20P" for "Bob"
+    Then  "Alice" gets a response with HTTP status "422 Unprocessable Entity"
+
+  Scenario: User A derives a key from the shared secret with User B, User B unwraps it
+    Given "Alice" has created a keystore with "X25519ECDHKW" key on Key Server
+      And "Bob" has created a keystore with "X25519ECDHKW" key on Key Server
+      And "Alice" has a public key of "Bob"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/derive" to derive a key with "Bob" wrapped for "Bob"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "wrapped_key"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/unwrap" to unwrap "wrapped_key" from "Alice"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with non-empty "key"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/derive" to derive a raw key with "Bob"
+    Then  "Alice" gets a response with HTTP status "403 Forbidden"
+
   Scenario: User A wraps XC20P key for User B, User B successfully unwraps it (Anoncrypt)
     Given "Alice" has created a keystore with "X25519ECDHKW" key on Key Server
       And "Bob" has created a keystore with "X25519ECDHKW" key on Key Server
@@ -33,6 +33,38 @@ Feature: KMS CryptoBox operations
     Then  "Bob" gets a response with HTTP status "200 OK"
      And  "Bob" gets a response with "plaintext" with value "test payload"
 
+  Scenario: User A seals a payload for User B with the easy endpoint of the key, User B opens it with the easyopen endpoint
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Bob" has created a keystore with "ED25519" key on Key Server
+      And "Alice" has a public key of "Bob"
+      And "Bob" has a public key of "Alice"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/easy" to easy "test payload" for "Bob"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with non-empty "ciphertext"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/easyopen" to easyOpen "ciphertext" from "Alice"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "plaintext" with value "test payload"
+
+  Scenario: User B decrypts a payload sealed by User A with the sealopen endpoint of the key
+    Given "Bob" has created a keystore with "ED25519" key on Key Server
+      And "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Bob" has a public key of "Alice"
+      And "Bob" has sealed "test payload" for "Alice"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/sealopen" to sealOpen "ciphertext" from "Bob"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Alice" gets a response with "plaintext" with value "test payload"
+
+  Scenario: CryptoBox endpoints of a key reject keys that are not ED25519
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Bob" has created a keystore with "ECDSAP256DER" key on Key Server
+      And "Bob" has a public key of "Alice"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/easy" to easy "test payload" for "Alice"
+    Then  "Bob" gets a response with HTTP status "422 Unprocessable Entity"
+
   Scenario: User B decrypts ("seal open") a payload that was encrypted ("seal") by User A
     Given "Bob" has created a keystore with "ED25519" key on Key Server
       And "Alice" has created a keystore with "ED25519" key on Key Server
@@ -44,3 +76,18 @@ Feature: KMS CryptoBox operations
     When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/unwrap" to sealOpen "ciphertext" from "Bob"
     Then  "Alice" gets a response with HTTP status "200 OK"
      And  "Alice" gets a response with "plaintext" with value "test payload"
+
+  Scenario: User A sends a sealed capability to User B's wallet in a DIDComm out-of-band invitation
+    Given "Alice" has created a keystore with "ED25519" key on Key Server
+      And "Bob" has created a keystore with "ED25519" key on Key Server
+      And "Alice" has a public key of "Bob"
+      And "Bob" has a public key of "Alice"
+
+    When  "Alice" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/invitation" to create a DIDComm invitation with capability "test capability" for "Bob"
+    Then  "Alice" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a DIDComm invitation with the public key of "Alice"
+     And  Aries agent at "http://localhost:8092" accepts the DIDComm invitation of "Alice"
+
+    When  "Bob" makes an HTTP POST to "https://localhost:4466/v1/keystores/{keystoreID}/keys/{keyID}/unwrap" to easyOpen "ciphertext" from "Alice"
+    Then  "Bob" gets a response with HTTP status "200 OK"
+     And  "Bob" gets a response with "plaintext" with value "test capability"
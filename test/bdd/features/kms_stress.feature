@@ -4,6 +4,8 @@
 # SPDX-License-Identifier: Apache-2.0
 #
 
+# Users created with "Create ... users" can't sign capability invocations: Key Server must be started with
+# --enable-no-zcap-key-stores, or the users must be created from a prototype.
 @kms_stress
 Feature: KMS stress test
   Background:
@@ -12,7 +14,63 @@ Feature: KMS stress test
   @kms_stress_local
   Scenario: Stress test KMS methods with local storage
     When  Create "USER_NUMS" users
-     And  "USER_NUMS" users request to create a keystore on "LocalStorage" with "ED25519" key and sign 1 time using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  "USER_NUMS" users request to create a keystore on "local" with "ED25519" key and sign 1 time using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_batch_sign
+  Scenario: Stress test KMS methods with batch signing
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users request to create a keystore on "local" with "ED25519" key and sign 100 times in batches of 50 using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_sign_multi_key
+  Scenario: Compare signing with several keys in a single signmulti request to sequential signs
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users request to create a keystore with 5 "ED25519" keys and sign them 10 times with signmulti using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_wrap
+  Scenario: Stress test key wrapping
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users request to create a keystore with "NISTP256ECDHKW" key and wrap 10 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_crypto_box
+  Scenario: Stress test CryptoBox easy and easyOpen between pairs of users
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users exchange "test payload" in pairs with easy and easyOpen using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  # Key Server must run with crypto worker pools enabled, e.g. --crypto-expensive-workers set to half of its CPUs
+  @kms_stress_mixed_cost
+  Scenario: Signing with ED25519 keys stays fast while BBS+ keys are signing
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users sign 10 times with "ED25519" keys alongside "BLS12381G2" keys and the p95 latency grows at most 2 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  # the report times creating keys per key type, RSA key generation is slow
+  @kms_stress_mixed_cost_rsa
+  Scenario: Signing with ED25519 keys stays fast while RSA-PSS keys are signing
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users sign 10 times with "ED25519" keys alongside "RSAPS256" keys and the p95 latency grows at most 2 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_overload
+  Scenario: Key Server sheds load and stays healthy when deliberately overloaded
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users overload Key Server with "ED25519" keys and sign 10 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_record
+  Scenario: Record a stress test session for deterministic benchmarking
+    When  Create "USER_NUMS" users
+     And  "USER_NUMS" users record a session of creating a keystore with "ED25519" key and signing 10 times to "KMS_STRESS_RECORDING" env
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+
+  @kms_stress_replay
+  Scenario: Replay a recorded stress test session
+    When  Create "USER_NUMS" users
+     And  Session recorded in "KMS_STRESS_RECORDING" env is replayed on Key Server using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And  Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
 
   @kms_stress_authz
   Scenario: Stress test authz KMS methods
@@ -29,7 +87,8 @@ Feature: KMS stress test
      And "John" login with "SUBJECT" and gets "ACCESS_TOKEN" and "SECRET_SHARE" env
      And Create "USER_NUMS" users from prototype "John"
      And "USER_NUMS" users has created a data vault on EDV for storing keys
-     And "USER_NUMS" users request to create a keystore on "EDV" with "ED25519" key and sign 110 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And "USER_NUMS" users request to create a keystore on "edv" with "ED25519" key and sign 110 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+     And Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
 
 
   @kms_stress_ops_local
@@ -40,4 +99,5 @@ Feature: KMS stress test
     And "John" login with "SUBJECT" and gets "ACCESS_TOKEN" and "SECRET_SHARE" env
     And Create "USER_NUMS" users from prototype "John"
     And "USER_NUMS" users has created a data vault on EDV for storing keys
-    And "USER_NUMS" users request to create a keystore on "LocalStorage" with "ED25519" key and sign 110 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+    And "USER_NUMS" users request to create a keystore on "local" with "ED25519" key and sign 110 times using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
+    And Keystores created during the run are deleted using "KMS_STRESS_CONCURRENT_REQ" concurrent requests
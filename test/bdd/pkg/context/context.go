@@ -49,17 +49,9 @@ func (k kmsProvider) SecretLock() secretlock.Service {
 
 // NewBDDContext creates a new BDD context.
 func NewBDDContext(caCertPath string) (*BDDContext, error) {
-	var tlsConfig *tls.Config
-
-	if caCertPath != "" {
-		rootCAs, err := tlsutils.GetCertPool(false, []string{caCertPath})
-		if err != nil {
-			return nil, err
-		}
-
-		tlsConfig = &tls.Config{
-			RootCAs: rootCAs, MinVersion: tls.VersionTLS12,
-		}
+	tlsConfig, err := newTLSConfig(caCertPath)
+	if err != nil {
+		return nil, err
 	}
 
 	keyManager, err := localkms.New(
@@ -81,6 +73,21 @@ func NewBDDContext(caCertPath string) (*BDDContext, error) {
 	}, nil
 }
 
+func newTLSConfig(caCertPath string) (*tls.Config, error) {
+	if caCertPath == "" {
+		return nil, nil //nolint:nilnil // default TLS config is used
+	}
+
+	rootCAs, err := tlsutils.GetCertPool(false, []string{caCertPath})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		RootCAs: rootCAs, MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
 // TLSConfig returns a TLS config that BDD context was initialized with.
 func (ctx *BDDContext) TLSConfig() *tls.Config {
 	return ctx.tlsConfig
@@ -0,0 +1,33 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package context
+
+// Dependency is a docker-compose service (see fixtures/docker-compose.yml) the BDD tests depend on.
+type Dependency struct {
+	// Container is the container name of the service.
+	Container string
+	// HealthURL is requested from the host in addition to checking the container state. Optional.
+	HealthURL string
+}
+
+// ComposeDependencies returns services of fixtures/docker-compose.yml that BDD failures are commonly traced to.
+func ComposeDependencies() []Dependency {
+	return []Dependency{
+		{Container: "kms.trustbloc.local", HealthURL: "https://localhost:8076/healthcheck"},
+		{Container: "kms-1.trustbloc.local", HealthURL: "https://localhost:8074/healthcheck"},
+		{Container: "kms-2.trustbloc.local", HealthURL: "https://localhost:8075/healthcheck"},
+		{Container: "authz-kms.trustbloc.local", HealthURL: "https://localhost:8077/healthcheck"},
+		{Container: "oathkeeper-auth-keyserver.trustbloc.local"},
+		{Container: "oathkeeper-ops-keyserver.trustbloc.local"},
+		{Container: "edv.trustbloc.local", HealthURL: "https://localhost:8081/healthcheck"},
+		{Container: "auth.trustbloc.local", HealthURL: "https://auth.trustbloc.local:8070/healthcheck"},
+		{Container: "hydra.trustbloc.local"},
+		{Container: "aws-kms.trustbloc.local"},
+		{Container: "mongodb.example.com"},
+		{Container: "mysql"},
+	}
+}
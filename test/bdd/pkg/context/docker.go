@@ -0,0 +1,176 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package context
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDockerSocket = "/var/run/docker.sock"
+	dockerTimeout       = 5 * time.Second
+)
+
+// dockerClient is a minimal client of the Docker Engine API served on a unix socket.
+type dockerClient struct {
+	httpClient *http.Client
+}
+
+type containerState struct {
+	Status       string // created, running, restarting, exited, etc.
+	Health       string // healthy, unhealthy or starting; empty if the container has no healthcheck
+	ExitCode     int
+	RestartCount int
+	TTY          bool
+}
+
+func newDockerClient() *dockerClient {
+	socket := defaultDockerSocket
+
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		socket = strings.TrimPrefix(host, "unix://")
+	}
+
+	return &dockerClient{
+		httpClient: &http.Client{
+			Timeout: dockerTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (c *dockerClient) inspect(ctx context.Context, container string) (*containerState, error) {
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(container)+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	var info struct {
+		RestartCount int
+		State        struct {
+			Status   string
+			ExitCode int
+			Health   *struct {
+				Status string
+			}
+		}
+		Config struct {
+			Tty bool
+		}
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode container info: %w", err)
+	}
+
+	state := &containerState{
+		Status:       info.State.Status,
+		ExitCode:     info.State.ExitCode,
+		RestartCount: info.RestartCount,
+		TTY:          info.Config.Tty,
+	}
+
+	if info.State.Health != nil {
+		state.Health = info.State.Health.Status
+	}
+
+	return state, nil
+}
+
+// logs returns the last lines of stdout and stderr of the container.
+func (c *dockerClient) logs(ctx context.Context, container string, tail int, tty bool) (string, error) {
+	query := url.Values{
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"timestamps": {"1"},
+		"tail":       {strconv.Itoa(tail)},
+	}
+
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(container)+"/logs", query)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close() //nolint:errcheck
+
+	if tty {
+		b, err := io.ReadAll(resp.Body)
+
+		return string(b), err //nolint:wrapcheck
+	}
+
+	return demuxLogs(resp.Body)
+}
+
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker api: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body) //nolint:errcheck
+		resp.Body.Close()                //nolint:errcheck,gosec
+
+		return nil, fmt.Errorf("docker api: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, nil
+}
+
+// demuxLogs reads a multiplexed log stream of a container without TTY: every frame has an 8-byte header with
+// the stream type and the big-endian frame size.
+func demuxLogs(r io.Reader) (string, error) {
+	var (
+		out    strings.Builder
+		header [8]byte
+	)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return out.String(), nil
+			}
+
+			return out.String(), fmt.Errorf("read log frame header: %w", err)
+		}
+
+		if _, err := io.CopyN(&out, r, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return out.String(), fmt.Errorf("read log frame: %w", err)
+		}
+	}
+}
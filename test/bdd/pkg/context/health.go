@@ -0,0 +1,253 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package context
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthPollInterval = 2 * time.Second
+	healthCheckTimeout = 3 * time.Second
+)
+
+// HealthEvent is a change of a dependency's readiness.
+type HealthEvent struct {
+	Time   time.Time
+	Ready  bool
+	Status string
+}
+
+// HealthPoller records readiness timelines of BDD dependencies while the tests run. A dependency is ready if its
+// container is running (and healthy if it has a healthcheck) and its health URL, if any, responds with 200 OK.
+type HealthPoller struct {
+	deps       []Dependency
+	docker     *dockerClient
+	httpClient *http.Client
+	interval   time.Duration
+
+	mutex     sync.Mutex
+	timelines map[string][]HealthEvent
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewHealthPoller returns a new HealthPoller of the dependencies. Health URLs are requested with the CA
+// certificate, if set.
+func NewHealthPoller(caCertPath string, deps []Dependency) (*HealthPoller, error) {
+	tlsConfig, err := newTLSConfig(caCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HealthPoller{
+		deps:   deps,
+		docker: newDockerClient(),
+		httpClient: &http.Client{
+			Timeout:   healthCheckTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		interval:  healthPollInterval,
+		timelines: map[string][]HealthEvent{},
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}, nil
+}
+
+// Start starts polling the dependencies in the background until Stop is called.
+func (p *HealthPoller) Start() {
+	go func() {
+		defer close(p.stopped)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			p.poll()
+
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops polling.
+func (p *HealthPoller) Stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+func (p *HealthPoller) poll() {
+	var wg sync.WaitGroup
+
+	for _, dep := range p.deps {
+		wg.Add(1)
+
+		go func(dep Dependency) {
+			defer wg.Done()
+
+			p.record(dep.Container, p.check(dep))
+		}(dep)
+	}
+
+	wg.Wait()
+}
+
+// record appends the event to the timeline of the dependency if readiness or status has changed.
+func (p *HealthPoller) record(container string, e HealthEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	timeline := p.timelines[container]
+
+	if n := len(timeline); n > 0 && timeline[n-1].Ready == e.Ready && timeline[n-1].Status == e.Status {
+		return
+	}
+
+	p.timelines[container] = append(timeline, e)
+}
+
+func (p *HealthPoller) check(dep Dependency) HealthEvent {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	e := HealthEvent{Time: time.Now()}
+
+	state, err := p.docker.inspect(ctx, dep.Container)
+	if err != nil {
+		e.Status = err.Error()
+
+		return e
+	}
+
+	e.Status = state.Status
+	e.Ready = state.Status == "running"
+
+	if state.Health != "" {
+		e.Status += " (" + state.Health + ")"
+		e.Ready = e.Ready && state.Health == "healthy"
+	}
+
+	if state.Status == "exited" {
+		e.Status += fmt.Sprintf(" with code %d", state.ExitCode)
+	}
+
+	if state.RestartCount > 0 {
+		e.Status += fmt.Sprintf(", restarted %d times", state.RestartCount)
+	}
+
+	if !e.Ready || dep.HealthURL == "" {
+		return e
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dep.HealthURL, nil)
+	if err != nil {
+		e.Ready = false
+		e.Status += ", healthcheck: " + err.Error()
+
+		return e
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		e.Ready = false
+		e.Status += ", healthcheck: " + err.Error()
+
+		return e
+	}
+
+	resp.Body.Close() //nolint:errcheck,gosec
+
+	if resp.StatusCode != http.StatusOK {
+		e.Ready = false
+		e.Status += ", healthcheck: " + resp.Status
+	}
+
+	return e
+}
+
+// Report polls the dependencies and returns their readiness with changes since the given time, followed by the last
+// log lines of dependencies that are not ready or were not ready at some point since then.
+func (p *HealthPoller) Report(since time.Time, logLines int) string {
+	p.poll()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var (
+		sb      strings.Builder
+		failing []string
+	)
+
+	sb.WriteString("Dependency health report:\n")
+
+	for _, dep := range p.deps {
+		timeline := p.timelines[dep.Container]
+		if len(timeline) == 0 {
+			continue
+		}
+
+		current := timeline[len(timeline)-1]
+		failed := !current.Ready
+
+		fmt.Fprintf(&sb, "  %-42s %-9s %s\n", dep.Container, readiness(current.Ready), current.Status)
+
+		for _, e := range timeline {
+			if e.Time.Before(since) {
+				continue
+			}
+
+			failed = failed || !e.Ready
+
+			fmt.Fprintf(&sb, "    %s %-9s %s\n", e.Time.Format("15:04:05"), readiness(e.Ready), e.Status)
+		}
+
+		if failed {
+			failing = append(failing, dep.Container)
+		}
+	}
+
+	for _, container := range failing {
+		fmt.Fprintf(&sb, "Last %d log lines of %s:\n%s\n", logLines, container, p.logs(container, logLines))
+	}
+
+	return sb.String()
+}
+
+func (p *HealthPoller) logs(container string, lines int) string {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	state, err := p.docker.inspect(ctx, container)
+	if err != nil {
+		return "  " + err.Error()
+	}
+
+	logs, err := p.docker.logs(ctx, container, lines, state.TTY)
+	if err != nil {
+		return "  " + err.Error()
+	}
+
+	return logs
+}
+
+func readiness(ready bool) string {
+	if ready {
+		return "ready"
+	}
+
+	return "NOT READY"
+}
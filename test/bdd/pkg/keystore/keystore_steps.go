@@ -8,18 +8,18 @@ package keystore
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/cucumber/godog"
 	"github.com/trustbloc/edge-core/pkg/log"
-	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/gziplimit"
+	zcapldsvc "github.com/trustbloc/kms/pkg/zcapld"
 	"github.com/trustbloc/kms/test/bdd/pkg/auth"
 	"github.com/trustbloc/kms/test/bdd/pkg/context"
 	"github.com/trustbloc/kms/test/bdd/pkg/internal/bddutil"
@@ -33,6 +33,13 @@ const (
 	}`
 
 	contentType = "application/json"
+
+	// edvVaultURL is a vault of EDV options of requests that are rejected before the vault is reached.
+	edvVaultURL = "https://edv.trustbloc.local:8081/encrypted-data-vaults/vault-id"
+
+	// keyStoreBaseURL is where key stores are served from, as configured with KMS_BASE_URL for the KMS servers in
+	// docker-compose. Generated URLs must use it even though requests reach the KMS through the oathkeeper proxy.
+	keyStoreBaseURL = "https://kms.trustbloc.local:8076/v1/keystores/"
 )
 
 // Steps defines steps context for keystore operations.
@@ -60,9 +67,35 @@ func (s *Steps) RegisterSteps(ctx *godog.ScenarioContext) {
 	ctx.Step(`^user makes an HTTP POST to "([^"]*)" to create a keystore$`, s.sendCreateKeystoreRequest)
 	ctx.Step(`^user gets a response with HTTP status "([^"]*)" and valid key store URL and root capabilities$`,
 		s.checkResponse)
+	ctx.Step(`^user makes an HTTP POST to "([^"]*)" to create a keystore with storage mode "([^"]*)" (with|without) EDV options$`, //nolint:lll
+		s.sendCreateKeystoreWithStorageModeRequest)
+	ctx.Step(`^user gets a response with HTTP status "([^"]*)" and error "([^"]*)"$`, s.checkErrorResponse)
 }
 
 func (s *Steps) sendCreateKeystoreRequest(endpoint string) error {
+	return s.postCreateKeystoreRequest(endpoint, []byte(createKeystoreReq))
+}
+
+// sendCreateKeystoreWithStorageModeRequest sends a request with the storage mode and, if with is "with", EDV options.
+func (s *Steps) sendCreateKeystoreWithStorageModeRequest(endpoint, storageMode, with string) error {
+	req := map[string]interface{}{
+		"controller":   controller,
+		"storage_mode": storageMode,
+	}
+
+	if with == "with" {
+		req["edv"] = map[string]interface{}{"vault_url": edvVaultURL}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	return s.postCreateKeystoreRequest(endpoint, body)
+}
+
+func (s *Steps) postCreateKeystoreRequest(endpoint string, req []byte) error {
 	login := auth.NewAuthLogin(s.bddContext.LoginConfig, s.bddContext.TLSConfig())
 
 	_, accessToken, err := login.WalletLogin()
@@ -70,13 +103,11 @@ func (s *Steps) sendCreateKeystoreRequest(endpoint string) error {
 		return fmt.Errorf("failed to login auth: %w", err)
 	}
 
-	body := bytes.NewBuffer([]byte(createKeystoreReq))
-
 	resp, err := bddutil.HTTPDo(
 		http.MethodPost,
 		endpoint,
 		headers(accessToken),
-		body, s.bddContext.TLSConfig(),
+		bytes.NewBuffer(req), s.bddContext.TLSConfig(),
 	)
 	if err != nil {
 		return err
@@ -120,19 +151,13 @@ func (s *Steps) checkResponse(status string) error {
 		return fmt.Errorf("invalid key store URL: %w", err)
 	}
 
-	compressed, err := gzip.NewReader(bytes.NewReader(resp.Capability))
-	if err != nil {
-		return fmt.Errorf("failed to open new gzip reader: %w", err)
+	if !strings.HasPrefix(resp.KeyStoreURL, keyStoreBaseURL) {
+		return fmt.Errorf("key store URL %s is not under the configured base %s", resp.KeyStoreURL, keyStoreBaseURL)
 	}
 
-	uncompressed, err := ioutil.ReadAll(compressed)
+	zcap, err := zcapldsvc.DecompressZCAP(resp.Capability, gziplimit.DefaultMaxSize)
 	if err != nil {
-		return fmt.Errorf("failed to gunzip capability: %w", err)
-	}
-
-	zcap, err := zcapld.ParseCapability(uncompressed)
-	if err != nil {
-		return fmt.Errorf("failed to parse capability: %w", err)
+		return fmt.Errorf("failed to decompress capability: %w", err)
 	}
 
 	if zcap.Invoker != controller {
@@ -142,6 +167,33 @@ func (s *Steps) checkResponse(status string) error {
 		)
 	}
 
+	if zcap.InvocationTarget.ID != resp.KeyStoreURL {
+		return fmt.Errorf(
+			"service returned wrong invocation target; expected %s got %s",
+			resp.KeyStoreURL, zcap.InvocationTarget.ID,
+		)
+	}
+
+	return nil
+}
+
+func (s *Steps) checkErrorResponse(status, message string) error {
+	if s.status != status {
+		return fmt.Errorf("expected HTTP response status %q, got: %q", status, s.status)
+	}
+
+	var resp struct {
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal(s.response, &resp); err != nil {
+		return fmt.Errorf("unmarshal error response: %w", err)
+	}
+
+	if !strings.Contains(resp.Message, message) {
+		return fmt.Errorf("expected error %q, got: %q", message, resp.Message)
+	}
+
 	return nil
 }
 
@@ -12,7 +12,6 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/hyperledger/aries-framework-go/pkg/vdr/fingerprint"
 	"github.com/lafriks/go-shamir"
 	"github.com/rs/xid"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
@@ -152,18 +151,14 @@ func (s *Steps) prepareDataVaultConfig(u *user) (*models.DataVaultConfiguration,
 		return nil, fmt.Errorf("failed to create auth keystore key: %w", errCreate)
 	}
 
-	if errExport := s.makeExportPubKeyReqAuthzKMS(u,
+	if errExport := s.makeExportDIDKeyReqAuthzKMS(u,
 		s.bddContext.AuthZKeyServerURL+exportKeyEndpoint); errExport != nil {
 		return nil, fmt.Errorf("failed to export authz keystore key: %w", errExport)
 	}
 
-	pkBytes := []byte(u.data["publicKey"])
-
-	_, didKey := fingerprint.CreateDIDKey(pkBytes)
-
 	return &models.DataVaultConfiguration{
 		Sequence:    0,
-		Controller:  didKey,
+		Controller:  u.data["verificationMethod"],
 		ReferenceID: xid.New().String(),
 		KEK:         models.IDTypePair{ID: "https://example.com/kms/12345", Type: "AesKeyWrappingKey2019"},
 		HMAC:        models.IDTypePair{ID: "https://example.com/kms/67891", Type: "Sha256HmacKey2019"},
@@ -248,8 +243,8 @@ func (s *Steps) makeCreateKeyReqAuthzKMS(u *user, endpoint, keyType string) erro
 	return nil
 }
 
-func (s *Steps) makeExportPubKeyReqAuthzKMS(u *user, endpoint string) error {
-	request, err := u.prepareGetRequest(endpoint)
+func (s *Steps) makeExportDIDKeyReqAuthzKMS(u *user, endpoint string) error {
+	request, err := u.prepareGetRequest(endpoint + "?format=did")
 	if err != nil {
 		return err
 	}
@@ -269,14 +264,15 @@ func (s *Steps) makeExportPubKeyReqAuthzKMS(u *user, endpoint string) error {
 		}
 	}()
 
-	var exportKeyResponse exportKeyResp
+	var exportKeyResponse exportDIDKeyResp
 
 	if respErr := u.processResponse(&exportKeyResponse, response); respErr != nil {
 		return respErr
 	}
 
 	u.data = map[string]string{
-		"publicKey": string(exportKeyResponse.PublicKey),
+		"did":                exportKeyResponse.DID,
+		"verificationMethod": exportKeyResponse.VerificationMethod,
 	}
 
 	return nil
@@ -10,6 +10,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"net/http"
+	"path"
 
 	"github.com/hyperledger/aries-framework-go/pkg/kms/localkms"
 
@@ -34,7 +35,7 @@ func (s *Steps) makeEasyPayloadReq(userName, endpoint, payload, recipient string
 		TheirPub: recPubCurve25519,
 	}
 
-	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionWrap)
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, cryptoBoxAction(endpoint))
 	if err != nil {
 		return err
 	}
@@ -76,7 +77,7 @@ func (s *Steps) makeEasyOpenReq(userName, endpoint, tag, sender string) error {
 		MyPub:      myPub,
 	}
 
-	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionUnwrap)
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, cryptoBoxAction(endpoint))
 	if err != nil {
 		return err
 	}
@@ -134,7 +135,7 @@ func (s *Steps) makeSealOpenReq(userName, endpoint, tag, sender string) error {
 		MyPub:      myPub,
 	}
 
-	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionUnwrap)
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, cryptoBoxAction(endpoint))
 	if err != nil {
 		return err
 	}
@@ -154,6 +155,23 @@ func (s *Steps) makeSealOpenReq(userName, endpoint, tag, sender string) error {
 	return nil
 }
 
+// cryptoBoxAction returns the action of the CryptoBox endpoint. The wrap and unwrap endpoints also serve CryptoBox
+// requests, with the actions of key wrapping.
+func cryptoBoxAction(endpoint string) string {
+	switch path.Base(endpoint) {
+	case "easy":
+		return actionEasy
+	case "easyopen":
+		return actionEasyOpen
+	case "sealopen":
+		return actionSealOpen
+	case "wrap":
+		return actionWrap
+	default:
+		return actionUnwrap
+	}
+}
+
 func (s *Steps) makeHTTPReq(u *user, req interface{}, endpoint, action string) (*http.Response, func(), error) {
 	request, err := u.preparePostRequest(req, endpoint)
 	if err != nil {
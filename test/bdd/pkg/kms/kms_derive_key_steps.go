@@ -0,0 +1,106 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// makeDeriveKeyReq derives a key from the shared secret of the user's key and the public key of the peer, wrapped for
+// the public key of the recipient.
+func (s *Steps) makeDeriveKeyReq(userName, endpoint, peer, recipient string) error {
+	u := s.users[userName]
+
+	recipientPubKey, ok := u.recipientPubKeys[recipient]
+	if !ok || recipientPubKey.parsedKey == nil {
+		return fmt.Errorf("no public key of %s", recipient)
+	}
+
+	r, err := s.newDeriveKeyReq(userName, peer)
+	if err != nil {
+		return err
+	}
+
+	r.Recipient = recipientPubKey.parsedKey
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionDeriveKey)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var resp deriveKeyResp
+
+	if respErr := u.processResponse(&resp, response); respErr != nil {
+		return respErr
+	}
+
+	wrappedKey, err := json.Marshal(resp.WrappedKey)
+	if err != nil {
+		return err
+	}
+
+	u.data = map[string]string{
+		"wrapped_key": string(wrappedKey),
+	}
+
+	return nil
+}
+
+// makeRejectedRawDeriveKeyReq derives a key without a recipient, which the server rejects unless raw derived keys
+// are enabled.
+func (s *Steps) makeRejectedRawDeriveKeyReq(userName, endpoint, peer string) error {
+	u := s.users[userName]
+	u.response = nil
+
+	r, err := s.newDeriveKeyReq(userName, peer)
+	if err != nil {
+		return err
+	}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionDeriveKey)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	if err = u.processResponse(&deriveKeyResp{}, response); err == nil {
+		return errors.New("expected raw key derivation to fail")
+	}
+
+	if u.response == nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Steps) newDeriveKeyReq(userName, peer string) (*deriveKeyReq, error) {
+	peerPubKey, ok := s.users[userName].recipientPubKeys[peer]
+	if !ok || peerPubKey.parsedKey == nil {
+		return nil, fmt.Errorf("no public key of %s", peer)
+	}
+
+	jwk, err := json.Marshal(map[string]string{
+		"kty": "OKP",
+		"crv": "X25519",
+		"x":   base64.RawURLEncoding.EncodeToString(peerPubKey.parsedKey.X),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deriveKeyReq{
+		PeerPublicKey: jwk,
+		KDF:           deriveKeyKDF{Info: []byte("bdd test")},
+	}, nil
+}
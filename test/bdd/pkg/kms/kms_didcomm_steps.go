@@ -0,0 +1,170 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/trustbloc/kms/pkg/didkey"
+	"github.com/trustbloc/kms/test/bdd/pkg/internal/cryptoutil"
+)
+
+// makeCreateInvitationReq requests an out-of-band invitation with the capability sealed for the recipient. The sealed
+// capability is kept as "ciphertext" and "nonce", so that the recipient can open it with easyOpen.
+func (s *Steps) makeCreateInvitationReq(userName, endpoint, capability, recipient string) error {
+	u := s.users[userName]
+
+	theirPub, err := cryptoutil.PublicEd25519toCurve25519(u.recipientPubKeys[recipient].rawBytes)
+	if err != nil {
+		return err
+	}
+
+	r := &createInvitationReq{
+		Capability: []byte(capability),
+		TheirPub:   theirPub,
+	}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionInvitation)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var invitationResponse createInvitationResp
+
+	if respErr := u.processResponse(&invitationResponse, response); respErr != nil {
+		return respErr
+	}
+
+	if invitationResponse.InvitationURL == "" {
+		return fmt.Errorf("create invitation returned an empty invitation url")
+	}
+
+	var sealed sealedCapability
+
+	if err = decodeAttachment(invitationResponse.Invitation, "capability", &sealed); err != nil {
+		return err
+	}
+
+	u.data = map[string]string{
+		"invitation": string(invitationResponse.Invitation),
+		"ciphertext": string(sealed.Ciphertext),
+		"nonce":      string(sealed.Nonce),
+	}
+
+	return nil
+}
+
+// checkInvitationPublicKey checks that the public key and the sealed capability in the invitation of the sender are
+// from the sender's key.
+func (s *Steps) checkInvitationPublicKey(userName, sender string) error {
+	u := s.users[userName]
+
+	inv := []byte(s.users[sender].data["invitation"])
+
+	var didKey struct {
+		DID string `json:"did"`
+	}
+
+	if err := decodeAttachment(inv, "public-key", &didKey); err != nil {
+		return err
+	}
+
+	pub, err := didkey.PublicKey(didKey.DID)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(pub, u.recipientPubKeys[sender].rawBytes) {
+		return fmt.Errorf("invitation has public key of another key: %s", didKey.DID)
+	}
+
+	var sealed sealedCapability
+
+	if err = decodeAttachment(inv, "capability", &sealed); err != nil {
+		return err
+	}
+
+	if sealed.SenderKey != didKey.DID {
+		return fmt.Errorf("capability is sealed by %s, expected %s", sealed.SenderKey, didKey.DID)
+	}
+
+	return nil
+}
+
+// acceptInvitation makes the Aries agent accept the invitation of the user, as a DIDComm wallet would.
+func (s *Steps) acceptInvitation(agentURL, userName string) error {
+	payload, err := json.Marshal(&acceptInvitationReq{
+		Invitation: json.RawMessage(s.users[userName].data["invitation"]),
+		MyLabel:    "bdd-wallet",
+	})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPost,
+		agentURL+"/outofband/accept-invitation", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create http request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body) //nolint:errcheck // the body is only used in the error message
+
+		return fmt.Errorf("accept invitation: %s: %s", resp.Status, body)
+	}
+
+	var acceptResponse acceptInvitationResp
+
+	if err = json.NewDecoder(resp.Body).Decode(&acceptResponse); err != nil {
+		return fmt.Errorf("parse response: %w", err)
+	}
+
+	if acceptResponse.ConnectionID == "" {
+		return fmt.Errorf("accept invitation returned an empty connection id")
+	}
+
+	return nil
+}
+
+func decodeAttachment(inv []byte, id string, v interface{}) error {
+	var i invitation
+
+	if err := json.Unmarshal(inv, &i); err != nil {
+		return fmt.Errorf("parse invitation: %w", err)
+	}
+
+	for _, a := range i.Requests {
+		if a.ID == id {
+			if err := json.Unmarshal(a.Data.JSON, v); err != nil {
+				return fmt.Errorf("parse %s attachment: %w", id, err)
+			}
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invitation has no %s attachment", id)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// hash functions of ES384 and ES512 signatures.
+	_ "crypto/sha512"
+)
+
+// verifyECDSASignature verifies the signature made by the user with the NIST curve public key exported from the
+// endpoint. DER key types are exported in PKIX form and sign in ASN.1 DER, IEEE P1363 key types are exported as
+// uncompressed points and sign in the fixed-size r||s form.
+func (s *Steps) verifyECDSASignature(userName, endpoint, message string) error {
+	u := s.users[userName]
+
+	signature := []byte(u.data["signature"])
+
+	if err := s.makeExportPubKeyReq(userName, endpoint); err != nil {
+		return err
+	}
+
+	keyType := u.data["key_type"]
+	pubBytes := []byte(u.data["public_key"])
+
+	curve := nistCurve(keyType)
+	if curve == nil {
+		return fmt.Errorf("expected NIST curve ECDSA key, got: %s", keyType)
+	}
+
+	ieee := strings.HasSuffix(keyType, "IEEEP1363")
+
+	var pub *ecdsa.PublicKey
+
+	if ieee {
+		x, y := elliptic.Unmarshal(curve, pubBytes)
+		if x == nil {
+			return fmt.Errorf("invalid %s public key", keyType)
+		}
+
+		pub = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	} else {
+		key, err := x509.ParsePKIXPublicKey(pubBytes)
+		if err != nil {
+			return fmt.Errorf("parse %s public key: %w", keyType, err)
+		}
+
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve != curve {
+			return fmt.Errorf("invalid %s public key", keyType)
+		}
+
+		pub = ecKey
+	}
+
+	if ieee && len(signature) != 2*curveSize(curve) {
+		return fmt.Errorf("expected %d bytes IEEE P1363 signature, got %d bytes", 2*curveSize(curve), len(signature))
+	}
+
+	if !verifyECDSA(pub, []byte(message), signature, ieee) {
+		return fmt.Errorf("%s signature of %q doesn't verify", keyType, message)
+	}
+
+	return nil
+}
+
+// makeExportDIDKeyReq exports the public key as did:key.
+func (s *Steps) makeExportDIDKeyReq(userName, endpoint string) error {
+	u := s.users[userName]
+
+	request, err := u.prepareGetRequest(endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionExportKey)
+	if err != nil {
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var didKey exportDIDKeyResp
+
+	if respErr := u.processResponse(&didKey, resp); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"did":                didKey.DID,
+		"verificationMethod": didKey.VerificationMethod,
+	}
+
+	return nil
+}
+
+// verifyECDSA verifies an ECDSA signature of the message with the hash of the curve (SHA-256 for P-256, SHA-384 for
+// P-384, SHA-512 for P-521), in IEEE P1363 or ASN.1 DER form.
+func verifyECDSA(pub *ecdsa.PublicKey, message, signature []byte, ieee bool) bool {
+	h := curveHash(pub.Curve).New()
+	h.Write(message)
+	digest := h.Sum(nil)
+
+	if !ieee {
+		return ecdsa.VerifyASN1(pub, digest, signature)
+	}
+
+	size := curveSize(pub.Curve)
+
+	if len(signature) != 2*size {
+		return false
+	}
+
+	return ecdsa.Verify(pub, digest, new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:]))
+}
+
+func nistCurve(keyType string) elliptic.Curve {
+	switch strings.TrimSuffix(strings.TrimSuffix(keyType, "DER"), "IEEEP1363") {
+	case "ECDSAP256":
+		return elliptic.P256()
+	case "ECDSAP384":
+		return elliptic.P384()
+	case "ECDSAP521":
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+func curveHash(curve elliptic.Curve) crypto.Hash {
+	switch curve {
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+func curveSize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8 //nolint:gomnd
+}
@@ -0,0 +1,96 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+)
+
+// makeEncryptJWEReq encrypts the message as a JWE in the serialization for the public key of the recipient.
+func (s *Steps) makeEncryptJWEReq(userName, endpoint, message, serialization, recipient string) error {
+	return s.encryptJWE(userName, endpoint, recipient, &encryptJWEReq{
+		Plaintext:     []byte(message),
+		Serialization: serialization,
+	})
+}
+
+func (s *Steps) makeRejectedEncryptJWEReq(userName, endpoint, message, enc, recipient string) error {
+	u := s.users[userName]
+	u.response = nil
+
+	err := s.encryptJWE(userName, endpoint, recipient, &encryptJWEReq{Plaintext: []byte(message), Enc: enc})
+	if err == nil {
+		return errors.New("expected JWE encryption to fail")
+	}
+
+	if u.response == nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Steps) encryptJWE(userName, endpoint, recipient string, r *encryptJWEReq) error {
+	u := s.users[userName]
+
+	recipientPubKey, ok := u.recipientPubKeys[recipient]
+	if !ok || recipientPubKey.parsedKey == nil {
+		return fmt.Errorf("no public key of %s", recipient)
+	}
+
+	r.Recipients = []*crypto.PublicKey{recipientPubKey.parsedKey}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionEncryptJWE)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var resp encryptJWEResp
+
+	if respErr := u.processResponse(&resp, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"jwe": resp.JWE,
+	}
+
+	return nil
+}
+
+// makeDecryptJWEReq decrypts the JWE the sender has encrypted for the user.
+func (s *Steps) makeDecryptJWEReq(userName, endpoint, sender string) error {
+	u := s.users[userName]
+
+	r := &decryptJWEReq{
+		JWE: s.users[sender].data["jwe"],
+	}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionDecryptJWE)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var resp decryptJWEResp
+
+	if respErr := u.processResponse(&resp, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"plaintext": string(resp.Plaintext),
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type healthCheckResp struct {
+	NoZCAPKeyStores bool `json:"no_zcap_key_stores"`
+}
+
+// createNoZCAPKeystore creates a keystore without ZCAPs. Requests for the keystore are authorized by the OAuth
+// subject of the user.
+func (s *Steps) createNoZCAPKeystore(userName string) error {
+	u := s.users[userName]
+	u.disableZCAP = true
+
+	if u.controller == "" {
+		u.controller = controller
+	}
+
+	return s.createKeystoreReq(u, &createKeystoreReq{Controller: u.controller},
+		s.bddContext.KeyServerURL+createKeystoreEndpoint, "")
+}
+
+func (s *Steps) makeRejectedCreateNoZCAPKeystoreReq(userName, endpoint string) error {
+	u := s.users[userName]
+	u.disableZCAP = true
+	u.response = nil
+
+	if u.controller == "" {
+		u.controller = controller
+	}
+
+	err := s.createKeystoreReq(u, &createKeystoreReq{Controller: u.controller}, endpoint, "")
+	if err == nil {
+		return errors.New("expected keystore creation to fail")
+	}
+
+	if u.response == nil {
+		return err
+	}
+
+	return nil
+}
+
+// useKeystoreWithBearerToken makes the user send requests for the keystore of another user with the bearer token
+// instead of capability invocations.
+func (s *Steps) useKeystoreWithBearerToken(userName, ownerName string) error {
+	u := s.users[userName]
+	owner := s.users[ownerName]
+
+	u.keystoreID = owner.keystoreID
+	u.keyID = owner.keyID
+	u.disableZCAP = true
+
+	return nil
+}
+
+func (s *Steps) makeRejectedCreateKeyReq(userName, endpoint, keyType string) error {
+	u := s.users[userName]
+	u.response = nil
+
+	err := s.makeCreateKeyReq(userName, endpoint, keyType)
+	if err == nil {
+		return errors.New("expected key creation to fail")
+	}
+
+	if u.response == nil {
+		return err
+	}
+
+	return nil
+}
+
+// noZCAPKeyStoresEnabled checks if Key Server allows keystores without ZCAPs.
+func (s *Steps) noZCAPKeyStoresEnabled() (bool, error) {
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodGet,
+		s.bddContext.KeyServerURL+healthCheckEndpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("new health check request: %w", err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("health check: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("health check: unexpected status %s", response.Status)
+	}
+
+	var resp healthCheckResp
+
+	if err = json.NewDecoder(response.Body).Decode(&resp); err != nil {
+		return false, fmt.Errorf("decode health check response: %w", err)
+	}
+
+	return resp.NoZCAPKeyStores, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+const (
+	secp256k1TypeDER       = "ECDSASecp256k1DER"
+	secp256k1TypeIEEEP1363 = "ECDSASecp256k1IEEEP1363"
+)
+
+//nolint:gochecknoglobals
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1      = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// verifySecp256k1Signature verifies the signature made by the user with the public key exported from the endpoint.
+// The public key and signature are decoded by btcec, independently of the key server, so that a mismatch between the
+// DER and IEEE P1363 encodings of the key type is caught. Signatures must be low-S, as Ethereum requires.
+func (s *Steps) verifySecp256k1Signature(userName, endpoint, message string) error {
+	u := s.users[userName]
+
+	signature := []byte(u.data["signature"])
+
+	if err := s.makeExportPubKeyReq(userName, endpoint); err != nil {
+		return err
+	}
+
+	keyType := u.data["key_type"]
+
+	pub, err := parseSecp256k1PublicKey([]byte(u.data["public_key"]), keyType)
+	if err != nil {
+		return err
+	}
+
+	var sig *btcec.Signature
+
+	switch keyType {
+	case secp256k1TypeDER:
+		sig, err = btcec.ParseDERSignature(signature, btcec.S256())
+		if err != nil {
+			return fmt.Errorf("parse DER signature: %w", err)
+		}
+	case secp256k1TypeIEEEP1363:
+		if len(signature) != 64 { //nolint:gomnd
+			return fmt.Errorf("expected 64 bytes IEEE P1363 signature, got %d bytes", len(signature))
+		}
+
+		sig = &btcec.Signature{
+			R: new(big.Int).SetBytes(signature[:32]),
+			S: new(big.Int).SetBytes(signature[32:]),
+		}
+	default:
+		return fmt.Errorf("expected secp256k1 key, got: %s", keyType)
+	}
+
+	if sig.S.Cmp(new(big.Int).Rsh(btcec.S256().N, 1)) > 0 {
+		return fmt.Errorf("signature is not low-S")
+	}
+
+	digest := sha256.Sum256([]byte(message))
+
+	if !sig.Verify(digest[:], pub) {
+		return fmt.Errorf("secp256k1 signature of %q doesn't verify", message)
+	}
+
+	return nil
+}
+
+// parseSecp256k1PublicKey parses a public key exported as an uncompressed point (IEEE P1363 keys) or a DER encoded
+// SubjectPublicKeyInfo (DER keys), which crypto/x509 doesn't parse for secp256k1.
+func parseSecp256k1PublicKey(b []byte, keyType string) (*btcec.PublicKey, error) {
+	if keyType == secp256k1TypeDER {
+		var info struct {
+			Algorithm struct {
+				Algorithm  asn1.ObjectIdentifier
+				Parameters asn1.ObjectIdentifier
+			}
+			PublicKey asn1.BitString
+		}
+
+		if _, err := asn1.Unmarshal(b, &info); err != nil {
+			return nil, fmt.Errorf("unmarshal public key: %w", err)
+		}
+
+		if !info.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) || !info.Algorithm.Parameters.Equal(oidSecp256k1) {
+			return nil, fmt.Errorf("expected secp256k1 public key, got algorithm %s with parameters %s",
+				info.Algorithm.Algorithm, info.Algorithm.Parameters)
+		}
+
+		b = info.PublicKey.RightAlign()
+	}
+
+	pub, err := btcec.ParsePubKey(b, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	return pub, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func (s *Steps) makeSignJWTReq(userName, endpoint, claims string) error {
+	return s.signJWT(userName, endpoint, claims, false)
+}
+
+func (s *Steps) makeSignDetachedJWTReq(userName, endpoint, claims string) error {
+	return s.signJWT(userName, endpoint, claims, true)
+}
+
+func (s *Steps) signJWT(userName, endpoint, claims string, detached bool) error {
+	u := s.users[userName]
+
+	r := &signJWTReq{
+		Claims:   json.RawMessage(claims),
+		Headers:  map[string]interface{}{"typ": "JWT"},
+		Detached: detached,
+	}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionSignJWT)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var signJWTResponse signJWTResp
+
+	if respErr := u.processResponse(&signJWTResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"jws": signJWTResponse.JWS,
+	}
+
+	return nil
+}
+
+// verifyJWSWithJWK verifies the JWS signed by the user with the Ed25519 JWK exported from the endpoint. The claims are
+// the payload of the JWS, or its unencoded detached payload (RFC 7797) if the payload of the JWS is empty.
+func (s *Steps) verifyJWSWithJWK(userName, endpoint, claims string) error {
+	u := s.users[userName]
+
+	jws := u.data["jws"]
+
+	key, err := s.exportJWK(u, endpoint)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := key.Key.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("expected Ed25519 public JWK, got: %T", key.Key)
+	}
+
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 { //nolint:gomnd
+		return fmt.Errorf("expected compact JWS, got: %s", jws)
+	}
+
+	var compactClaims bytes.Buffer
+
+	if err = json.Compact(&compactClaims, []byte(claims)); err != nil {
+		return fmt.Errorf("compact claims: %w", err)
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+
+	if parts[1] == "" {
+		signingInput = parts[0] + "." + compactClaims.String()
+	} else {
+		payload, decodeErr := base64.RawURLEncoding.DecodeString(parts[1])
+		if decodeErr != nil {
+			return fmt.Errorf("decode payload: %w", decodeErr)
+		}
+
+		if !bytes.Equal(payload, compactClaims.Bytes()) {
+			return fmt.Errorf("expected payload %s, got: %s", compactClaims.String(), payload)
+		}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+
+	if !ed25519.Verify(pub, []byte(signingInput), signature) {
+		return fmt.Errorf("JWS is not verified with exported JWK")
+	}
+
+	u.data = map[string]string{
+		"kid": key.KeyID,
+	}
+
+	return nil
+}
@@ -9,7 +9,9 @@ package kms
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
@@ -19,11 +21,15 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cucumber/godog"
 	"github.com/hyperledger/aries-framework-go/component/storageutil/mem"
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
+	"github.com/hyperledger/aries-framework-go/pkg/doc/jose/jwk/jwksupport"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/ld"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/jsonld"
 	"github.com/hyperledger/aries-framework-go/pkg/doc/signature/suite"
@@ -32,6 +38,8 @@ import (
 	ldstore "github.com/hyperledger/aries-framework-go/pkg/store/ld"
 	"github.com/hyperledger/aries-framework-go/spi/storage"
 	"github.com/igor-pavlenko/httpsignatures-go"
+	"github.com/rs/xid"
+	"github.com/square/go-jose/v3"
 	"github.com/trustbloc/edge-core/pkg/log"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
@@ -46,9 +54,19 @@ const (
 	keysEndpoint           = "/v1/keystores/{keystoreID}/keys"
 	exportKeyEndpoint      = "/v1/keystores/{keystoreID}/keys/{keyID}/export"
 	signEndpoint           = "/v1/keystores/{keystoreID}/keys/{keyID}/sign"
+	signBatchEndpoint      = "/v1/keystores/{keystoreID}/keys/{keyID}/sign/batch"
+	signMultiKeyEndpoint   = "/v1/keystores/{keystoreID}/signmulti"
 	verifyEndpoint         = "/v1/keystores/{keystoreID}/keys/{keyID}/verify"
+	wrapEndpoint           = "/v1/keystores/{keystoreID}/wrap"
+	unwrapEndpoint         = "/v1/keystores/{keystoreID}/keys/{keyID}/unwrap"
+	easyEndpoint           = "/v1/keystores/{keystoreID}/keys/{keyID}/easy"
+	easyOpenEndpoint       = "/v1/keystores/{keystoreID}/keys/{keyID}/easyopen"
+	healthCheckEndpoint    = "/healthcheck"
 )
 
+// bbsProofNonce is the nonce of BBS+ proofs derived in the scenarios.
+const bbsProofNonce = "nonce"
+
 // Steps defines steps context for the KMS operations.
 type Steps struct {
 	bddContext *bddcontext.BDDContext
@@ -56,6 +74,10 @@ type Steps struct {
 	logger     log.Logger
 	users      map[string]*user
 	keys       map[string][]byte
+
+	// keystores created on Key Server, deleted by the teardown step of stress tests
+	keyStores      []*createdKeyStore
+	keyStoresMutex sync.Mutex
 }
 
 // NewSteps creates steps context for the KMS operations.
@@ -83,12 +105,43 @@ func (s *Steps) RegisterSteps(ctx *godog.ScenarioContext) {
 	ctx.Step(`^"([^"]*)" has created a data vault on EDV for storing keys$`, s.createEDVDataVault)
 	ctx.Step(`^"([^"]*)" users has created a data vault on EDV for storing keys$`, s.createEDVDataVaultForMultipleUsers)
 	ctx.Step(`^"([^"]*)" has created an empty keystore on Key Server$`, s.createKeystore)
+	ctx.Step(`^"([^"]*)" has created an empty keystore on Key Server retrying with the same idempotency key$`,
+		s.createKeystoreWithRetry)
 	ctx.Step(`^"([^"]*)" has created a keystore with "([^"]*)" key on Key Server$`, s.createKeystoreAndKey)
+	ctx.Step(`^"([^"]*)" has created a keystore without ZCAPs on Key Server$`, s.createNoZCAPKeystore)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create a keystore without ZCAPs$`,
+		s.makeRejectedCreateNoZCAPKeystoreReq)
+	ctx.Step(`^"([^"]*)" uses the keystore of "([^"]*)" with the bearer token$`, s.useKeystoreWithBearerToken)
 	ctx.Step(`^"([^"]*)" users request to create a keystore on "([^"]*)" with "([^"]*)" key and sign ([^"]*) times using "([^"]*)" concurrent requests$`, //nolint:lll
 		s.stressTestForMultipleUsers)
 
+	ctx.Step(`^"([^"]*)" users request to create a keystore on "([^"]*)" with "([^"]*)" key and sign ([^"]*) times in batches of ([^"]*) using "([^"]*)" concurrent requests$`, //nolint:lll
+		s.stressTestForMultipleUsersWithSignBatch)
+
+	ctx.Step(`^"([^"]*)" users request to create a keystore with ([^"]*) "([^"]*)" keys and sign them ([^"]*) times with signmulti using "([^"]*)" concurrent requests$`, //nolint:lll
+		s.signMultiKeyStressTest)
+
+	ctx.Step(`^"([^"]*)" users request to create a keystore with "([^"]*)" key and wrap ([^"]*) times using "([^"]*)" concurrent requests$`, //nolint:lll
+		s.wrapStressTestForMultipleUsers)
+
+	ctx.Step(`^"([^"]*)" users exchange "([^"]*)" in pairs with easy and easyOpen using "([^"]*)" concurrent requests$`,
+		s.cryptoBoxStressTestForUserPairs)
+
+	ctx.Step(`^"([^"]*)" users sign ([^"]*) times with "([^"]*)" keys alongside "([^"]*)" keys and the p95 latency grows at most ([^"]*) times using "([^"]*)" concurrent requests$`, //nolint:lll
+		s.mixedCostStressTest)
+
+	ctx.Step(`^"([^"]*)" users overload Key Server with "([^"]*)" keys and sign ([^"]*) times using "([^"]*)" concurrent requests$`, //nolint:lll
+		s.overloadKeyServer)
+
+	ctx.Step(`^"([^"]*)" users record a session of creating a keystore with "([^"]*)" key and signing ([^"]*) times to "([^"]*)" env$`, //nolint:lll
+		s.recordStressSession)
+	ctx.Step(`^Session recorded in "([^"]*)" env is replayed on Key Server using "([^"]*)" concurrent requests$`,
+		s.replayStressSession)
+
 	ctx.Step(`^"([^"]*)" requests to authz kms to create a keystore and a key for user "([^"]*)" and sign using "([^"]*)" concurrent requests$`, //nolint:lll
 		s.authStressTestForMultipleUsers)
+	ctx.Step(`^Keystores created during the run are deleted using "([^"]*)" concurrent requests$`,
+		s.deleteCreatedKeystores)
 
 	// common response checking steps
 	ctx.Step(`^"([^"]*)" gets a response with HTTP status "([^"]*)"$`, s.checkRespStatus)
@@ -100,21 +153,73 @@ func (s *Steps) RegisterSteps(ctx *godog.ScenarioContext) {
 	ctx.Step(`^"([^"]*)" gets a response with content of "([^"]*)" key$`, s.checkRespWithKeyContent)
 	// create/export/import key steps
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" key$`, s.makeCreateKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" key and is rejected$`,
+		s.makeRejectedCreateKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" keys in a batch$`, s.makeCreateKeysReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" key with alias "([^"]*)"$`,
+		s.makeCreateKeyWithAliasReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" key expiring at "([^"]*)"$`,
+		s.makeCreateKeyWithExpiryReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create "([^"]*)" key for purposes "([^"]*)"$`,
+		s.makeCreateKeyWithPurposesReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP PATCH to "([^"]*)" to set key alias "([^"]*)"$`, s.makeUpdateKeyAliasReq)
+	ctx.Step(`^"([^"]*)" refers to the key by alias "([^"]*)"$`, s.useKeyAlias)
+	ctx.Step(`^"([^"]*)" makes an HTTP PATCH to "([^"]*)" to set key state "([^"]*)"$`, s.makeSetKeyStateReq)
 	ctx.Step(`^"([^"]*)" makes parallel HTTP POST requests to "([^"]*)" to create "([^"]*)" keys$`,
 		s.makeParallelCreateKeyReqs)
 	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key$`, s.makeExportPubKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key as JWK and verifies signature of "([^"]*)"$`,
+		s.makeExportJWKReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create and export "([^"]*)" key$`,
 		s.makeCreateAndExportKeyReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP PUT to "([^"]*)" to import a private key with ID "([^"]*)"$`,
 		s.makeImportKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP PUT to "([^"]*)" to import "([^"]*)" private key in JWK format$`,
+		s.makeImportJWKReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to rotate "([^"]*)" key$`, s.makeRotateKeyReq)
 	// sign/verify message steps
+	ctx.Step(`^"([^"]*)" makes an HTTP DELETE to "([^"]*)" to delete a key using "([^"]*)" action$`, s.makeDeleteKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to get the keystore$`, s.makeGetKeystoreReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP DELETE to "([^"]*)" to delete the keystore$`, s.makeDeleteKeystoreReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to get the key$`, s.makeGetKeyReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign "([^"]*)"$`, s.makeSignMessageReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign "([^"]*)" in a batch$`, s.makeSignBatchReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign JWT claims '([^']*)'$`, s.makeSignJWTReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign JWT claims '([^']*)' detached$`,
+		s.makeSignDetachedJWTReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key as JWK and verifies JWS of claims '([^']*)'$`, //nolint:lll
+		s.verifyJWSWithJWK)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key and verifies secp256k1 signature of "([^"]*)"$`, //nolint:lll
+		s.verifySecp256k1Signature)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key and verifies ECDSA signature of "([^"]*)"$`, //nolint:lll
+		s.verifyECDSASignature)
+	ctx.Step(`^"([^"]*)" makes an HTTP GET to "([^"]*)" to export public key as did:key$`, s.makeExportDIDKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign (\d+) messages with BBS\+$`, s.makeSignMessagesReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign "([^"]*)" with a deleted key$`,
+		s.makeRejectedSignMessageReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sign "([^"]*)" with a disabled key$`,
+		s.makeRejectedSignMessageReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" for "([^"]*)"$`, s.makeVerifySignatureReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" of "([^"]*)" for "([^"]*)" with their "([^"]*)" public key$`, //nolint:lll
+		s.makeVerifyWithPublicKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" for (\d+) messages with BBS\+$`,
+		s.makeVerifyMessagesSignatureReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to derive a proof revealing "([^"]*)" of (\d+) messages$`,
+		s.makeDeriveProofReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" revealing "([^"]*)" of (\d+) messages$`,
+		s.makeVerifyProofReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" for "([^"]*)" with a key not allowed to verify$`, //nolint:lll
+		s.makeRejectedVerifySignatureReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to mint a one-time token for "([^"]*)"$`,
+		s.makeCreateTokenReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify "([^"]*)" for "([^"]*)" with a one-time token$`,
+		s.makeVerifySignatureReqWithToken)
 
 	// encrypt/decrypt message steps
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to encrypt "([^"]*)"$`, s.makeEncryptMessageReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to decrypt "([^"]*)"$`, s.makeDecryptCipherReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to decrypt "([^"]*)" with a flipped byte$`,
+		s.makeTamperedDecryptCipherReq)
 	// compute/verify MAC steps
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to compute MAC for "([^"]*)"$`, s.makeComputeMACReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to verify MAC "([^"]*)" for "([^"]*)"$`, s.makeVerifyMACReq)
@@ -122,10 +227,23 @@ func (s *Steps) RegisterSteps(ctx *godog.ScenarioContext) {
 	ctx.Step(`^"([^"]*)" has a public key of "([^"]*)"$`, s.getPubKeyOfRecipient)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to wrap "([^"]*)" for "([^"]*)"$`, s.makeWrapKeyReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to unwrap "([^"]*)" from "([^"]*)"$`, s.makeUnwrapKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to encrypt "([^"]*)" as a "([^"]*)" JWE for "([^"]*)"$`,
+		s.makeEncryptJWEReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to encrypt "([^"]*)" as a JWE with enc "([^"]*)" for "([^"]*)"$`, //nolint:lll
+		s.makeRejectedEncryptJWEReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to decrypt the JWE from "([^"]*)"$`, s.makeDecryptJWEReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to derive a key with "([^"]*)" wrapped for "([^"]*)"$`,
+		s.makeDeriveKeyReq)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to derive a raw key with "([^"]*)"$`,
+		s.makeRejectedRawDeriveKeyReq)
 	// CryptoBox steps
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to easy "([^"]*)" for "([^"]*)"$`, s.makeEasyPayloadReq)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to easyOpen "([^"]*)" from "([^"]*)"$`, s.makeEasyOpenReq)
 	ctx.Step(`^"([^"]*)" has sealed "([^"]*)" for "([^"]*)"$`, s.sealPayloadForRecipient)
+	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to create a DIDComm invitation with capability "([^"]*)" for "([^"]*)"$`, //nolint:lll
+		s.makeCreateInvitationReq)
+	ctx.Step(`^"([^"]*)" gets a DIDComm invitation with the public key of "([^"]*)"$`, s.checkInvitationPublicKey)
+	ctx.Step(`^Aries agent at "([^"]*)" accepts the DIDComm invitation of "([^"]*)"$`, s.acceptInvitation)
 	ctx.Step(`^"([^"]*)" makes an HTTP POST to "([^"]*)" to sealOpen "([^"]*)" from "([^"]*)"$`, s.makeSealOpenReq)
 }
 
@@ -140,41 +258,88 @@ func (s *Steps) createKeystoreAndKey(user, keyType string) error {
 func (s *Steps) createKeystore(userName string) error {
 	u := s.users[userName]
 
-	if err := s.createDID(u); err != nil {
+	r, err := s.newCreateKeystoreReq(u)
+	if err != nil {
 		return err
 	}
 
-	edvCapability, err := s.createChainCapability(u)
+	return s.createKeystoreReq(u, r, s.bddContext.KeyServerURL+createKeystoreEndpoint, "")
+}
+
+// createKeystoreWithRetry creates a keystore and retries the same request with the same idempotency key, as a client
+// would after a timeout. The retry must return the keystore of the first request.
+func (s *Steps) createKeystoreWithRetry(userName string) error {
+	u := s.users[userName]
+
+	r, err := s.newCreateKeystoreReq(u)
 	if err != nil {
 		return err
 	}
 
+	idempotencyKey := xid.New().String()
+
+	if err = s.createKeystoreReq(u, r, s.bddContext.KeyServerURL+createKeystoreEndpoint, idempotencyKey); err != nil {
+		return err
+	}
+
+	keystoreID := u.keystoreID
+
+	if err = s.createKeystoreReq(u, r, s.bddContext.KeyServerURL+createKeystoreEndpoint, idempotencyKey); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+
+	if u.keystoreID != keystoreID {
+		return fmt.Errorf("retry created another keystore: %s, expected %s", u.keystoreID, keystoreID)
+	}
+
+	return nil
+}
+
+func (s *Steps) newCreateKeystoreReq(u *user) (*createKeystoreReq, error) {
+	if err := s.createDID(u); err != nil {
+		return nil, err
+	}
+
+	edvCapability, err := s.createChainCapability(u)
+	if err != nil {
+		return nil, err
+	}
+
 	capabilityBytes, err := json.Marshal(edvCapability)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	r := &createKeystoreReq{
+	return &createKeystoreReq{
 		Controller: u.controller,
 		EDV: &edvOptions{
 			// TODO: replace hardcoded URL with the proper s.bddContext.EDVServerURL
 			VaultURL:   "https://edv.trustbloc.local:8081" + edvBasePath + "/" + u.vaultID,
 			Capability: capabilityBytes,
 		},
-	}
-
-	return s.createKeystoreReq(u, r, s.bddContext.KeyServerURL+createKeystoreEndpoint)
+	}, nil
 }
 
-func (s *Steps) createKeystoreReq(u *user, r *createKeystoreReq, endpoint string) error {
+// createKeystoreReq creates a keystore. The idempotency key is sent in the Idempotency-Key header if not empty.
+func (s *Steps) createKeystoreReq(u *user, r *createKeystoreReq, endpoint, idempotencyKey string) error {
+	r.DisableZCAP = u.disableZCAP
+
 	request, err := u.preparePostRequest(r, endpoint)
 	if err != nil {
 		return err
 	}
 
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", u.accessToken))
+	if u.disableZCAP {
+		u.setBearerToken(request)
+	} else {
+		request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", u.accessToken))
+	}
 
-	response, err := s.httpClient.Do(request)
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	response, err := s.do(u, opCreateKeyStore, request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
@@ -186,6 +351,16 @@ func (s *Steps) createKeystoreReq(u *user, r *createKeystoreReq, endpoint string
 		}
 	}()
 
+	if err = processCreateKeystoreResp(u, response); err != nil {
+		return err
+	}
+
+	s.trackKeyStore(u)
+
+	return nil
+}
+
+func processCreateKeystoreResp(u *user, response *http.Response) error {
 	var resp createKeyStoreResp
 
 	if err := u.processResponse(&resp, response); err != nil {
@@ -271,7 +446,7 @@ func (s *Steps) makeCreateKeyReq(userName, endpoint, keyType string) error {
 		return fmt.Errorf("build create key request: %w", err)
 	}
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.do(u, actionCreateKey, req)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
@@ -286,6 +461,54 @@ func (s *Steps) makeCreateKeyReq(userName, endpoint, keyType string) error {
 	return processCreateKeyResp(u, resp)
 }
 
+// makeCreateKeysReq creates keys of comma-separated types with a single batch request.
+func (s *Steps) makeCreateKeysReq(userName, endpoint, keyTypes string) error {
+	u := s.users[userName]
+
+	r := &createKeysReq{}
+
+	for _, kt := range strings.Split(keyTypes, ",") {
+		r.Keys = append(r.Keys, createKeyReq{KeyType: kt})
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionCreateKeys); err != nil {
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var createKeysResponse createKeysResp
+
+	if respErr := u.processResponse(&createKeysResponse, resp); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"key_count": strconv.Itoa(len(createKeysResponse.Keys)),
+	}
+
+	return nil
+}
+
 func processCreateKeyResp(u *user, resp *http.Response) error {
 	var r createKeyResp
 
@@ -434,6 +657,84 @@ func (s *Steps) makeExportPubKeyReq(userName, endpoint string) error {
 	return nil
 }
 
+// makeExportJWKReq exports an Ed25519 or NIST curve ECDSA public key as JWK and verifies the signature from the
+// previous response with it.
+func (s *Steps) makeExportJWKReq(userName, endpoint, message string) error {
+	u := s.users[userName]
+
+	signature := []byte(u.data["signature"])
+
+	key, err := s.exportJWK(u, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if !key.IsPublic() {
+		return fmt.Errorf("expected public JWK")
+	}
+
+	var verified bool
+
+	switch pub := key.Key.(type) {
+	case ed25519.PublicKey:
+		verified = ed25519.Verify(pub, []byte(message), signature)
+	case *ecdsa.PublicKey:
+		// the JWK doesn't tell DER from IEEE P1363 key types, a signature of the size of r||s is tried as both
+		verified = verifyECDSA(pub, []byte(message), signature, true) ||
+			verifyECDSA(pub, []byte(message), signature, false)
+	default:
+		return fmt.Errorf("expected Ed25519 or ECDSA public JWK, got: %T", key.Key)
+	}
+
+	if !verified {
+		return fmt.Errorf("signature is not verified with exported JWK")
+	}
+
+	u.data = map[string]string{
+		"kid": key.KeyID,
+		"alg": key.Algorithm,
+	}
+
+	return nil
+}
+
+func (s *Steps) exportJWK(u *user, endpoint string) (*jose.JSONWebKey, error) {
+	request, err := u.prepareGetRequest(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionExportKey)
+	if err != nil {
+		return nil, fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return nil, fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var key jose.JSONWebKey
+
+	if respErr := u.processResponse(&key, resp); respErr != nil {
+		return nil, respErr
+	}
+
+	return &key, nil
+}
+
 func (s *Steps) makeCreateAndExportKeyReq(user, endpoint, keyType string) error {
 	u := s.users[user]
 
@@ -542,19 +843,39 @@ func (s *Steps) makeImportKeyReq(userName, endpoint, keyID string) error {
 	return nil
 }
 
-func (s *Steps) makeRotateKeyReq(userName, endpoint, keyType string) error {
+// makeImportJWKReq imports a new Ed25519 key for ED25519 key type or a new P-256 key otherwise.
+func (s *Steps) makeImportJWKReq(userName, endpoint, keyType string) error {
 	u := s.users[userName]
 
-	r := &rotateKeyReq{
-		keyType,
+	var privateKey interface{}
+
+	if keyType == string(kms.ED25519Type) {
+		_, privateKey, _ = ed25519.GenerateKey(rand.Reader) //nolint:errcheck // never fails with crypto/rand
+	} else {
+		pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate ecdsa key: %w", err)
+		}
+
+		privateKey = pk
 	}
 
-	request, err := u.preparePostRequest(r, endpoint)
+	j, err := jwksupport.JWKFromKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create jwk: %w", err)
+	}
+
+	jwkBytes, err := j.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal jwk: %w", err)
+	}
+
+	request, err := u.preparePutRequest(&importKeyReq{JWK: jwkBytes, KeyType: kms.KeyType(keyType)}, endpoint)
 	if err != nil {
 		return err
 	}
 
-	err = u.SetCapabilityInvocation(request, actionRotateKey)
+	err = u.SetCapabilityInvocation(request, actionImportKey)
 	if err != nil {
 		return fmt.Errorf("user failed to set capability invocation: %w", err)
 	}
@@ -564,37 +885,41 @@ func (s *Steps) makeRotateKeyReq(userName, endpoint, keyType string) error {
 		return fmt.Errorf("user failed to sign request: %w", err)
 	}
 
-	response, err := s.httpClient.Do(request)
+	resp, err := s.httpClient.Do(request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
 
 	defer func() {
-		closeErr := response.Body.Close()
+		closeErr := resp.Body.Close()
 		if closeErr != nil {
 			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
 		}
 	}()
 
-	var rotateKeyResponse rotateKeyResp
+	var importKeyResponse importKeyResp
+
+	if respErr := u.processResponse(&importKeyResponse, resp); respErr != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return nil // the status and error message are checked by the scenario
+		}
 
-	if respErr := u.processResponse(&rotateKeyResponse, response); respErr != nil {
 		return respErr
 	}
 
-	parts := strings.Split(rotateKeyResponse.KeyURL, "/")
-	u.keyID = parts[len(parts)-1]
-
-	u.data["key_url"] = rotateKeyResponse.KeyURL
+	u.data = map[string]string{
+		"key_url":    importKeyResponse.KeyURL,
+		"public_key": string(importKeyResponse.PublicKey),
+	}
 
 	return nil
 }
 
-func (s *Steps) makeSignMessageReq(userName, endpoint, message string) error { //nolint:dupl // ignore
+func (s *Steps) makeRotateKeyReq(userName, endpoint, keyType string) error {
 	u := s.users[userName]
 
-	r := &signReq{
-		Message: []byte(message),
+	r := &rotateKeyReq{
+		keyType,
 	}
 
 	request, err := u.preparePostRequest(r, endpoint)
@@ -602,9 +927,9 @@ func (s *Steps) makeSignMessageReq(userName, endpoint, message string) error { /
 		return err
 	}
 
-	err = u.SetCapabilityInvocation(request, actionSign)
+	err = u.SetCapabilityInvocation(request, actionRotateKey)
 	if err != nil {
-		return fmt.Errorf("user failed to set zcap on request: %w", err)
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
 	}
 
 	err = u.Sign(request)
@@ -624,45 +949,36 @@ func (s *Steps) makeSignMessageReq(userName, endpoint, message string) error { /
 		}
 	}()
 
-	var signResponse signResp
+	var rotateKeyResponse rotateKeyResp
 
-	if respErr := u.processResponse(&signResponse, response); respErr != nil {
+	if respErr := u.processResponse(&rotateKeyResponse, response); respErr != nil {
 		return respErr
 	}
 
-	u.data = map[string]string{
-		"signature": string(signResponse.Signature),
-	}
-
-	return nil
-}
-
-func (s *Steps) makeVerifySignatureReq(userName, endpoint, tag, message string) error {
-	u := s.users[userName]
+	parts := strings.Split(rotateKeyResponse.KeyURL, "/")
+	u.keyID = parts[len(parts)-1]
 
-	r := &verifyReq{
-		Signature: []byte(u.data[tag]),
-		Message:   []byte(message),
-	}
+	u.data["key_url"] = rotateKeyResponse.KeyURL
+	u.data["public_key"] = string(rotateKeyResponse.PublicKey)
 
-	return s.makeVerifyReq(u, actionVerify, r, endpoint)
+	return nil
 }
 
-func (s *Steps) makeEncryptMessageReq(userName, endpoint, message string) error {
+// makeDeleteKeyReq deletes the user's key invoking the given zcap action. Error responses are not step failures,
+// the status is checked in the next steps.
+func (s *Steps) makeDeleteKeyReq(userName, endpoint, action string) error {
 	u := s.users[userName]
 
-	r := &encryptReq{
-		Message: []byte(message),
-	}
+	uri := buildURI(endpoint, u.keystoreID, u.keyID)
 
-	request, err := u.preparePostRequest(r, endpoint)
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodDelete, uri, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("create http request: %w", err)
 	}
 
-	err = u.SetCapabilityInvocation(request, actionEncrypt)
+	err = u.SetCapabilityInvocation(request, action)
 	if err != nil {
-		return fmt.Errorf("user failed to set zcap on request: %w", err)
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
 	}
 
 	err = u.Sign(request)
@@ -670,48 +986,37 @@ func (s *Steps) makeEncryptMessageReq(userName, endpoint, message string) error
 		return fmt.Errorf("user failed to sign request: %w", err)
 	}
 
-	response, err := s.httpClient.Do(request)
+	resp, err := s.httpClient.Do(request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
 
 	defer func() {
-		closeErr := response.Body.Close()
+		closeErr := resp.Body.Close()
 		if closeErr != nil {
 			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
 		}
 	}()
 
-	var encryptResponse encryptResp
-
-	if respErr := u.processResponse(&encryptResponse, response); respErr != nil {
-		return respErr
-	}
-
-	u.data = map[string]string{
-		"ciphertext": string(encryptResponse.Ciphertext),
-		"nonce":      string(encryptResponse.Nonce),
+	u.response = &response{
+		status:     resp.Status,
+		statusCode: resp.StatusCode,
 	}
 
 	return nil
 }
 
-func (s *Steps) makeDecryptCipherReq(userName, endpoint, tag string) error {
+func (s *Steps) makeGetKeystoreReq(userName, endpoint string) error {
 	u := s.users[userName]
 
-	r := &decryptReq{
-		Ciphertext: []byte(u.data[tag]),
-		Nonce:      []byte(u.data["nonce"]),
-	}
-
-	request, err := u.preparePostRequest(r, endpoint)
+	request, err := u.prepareGetRequest(endpoint)
 	if err != nil {
 		return err
 	}
 
-	err = u.SetCapabilityInvocation(request, actionDecrypt)
+	err = u.SetCapabilityInvocation(request, actionGetKeyStore)
 	if err != nil {
-		return fmt.Errorf("user failed to set zcap: %w", err)
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
 	}
 
 	err = u.Sign(request)
@@ -719,46 +1024,44 @@ func (s *Steps) makeDecryptCipherReq(userName, endpoint, tag string) error {
 		return fmt.Errorf("user failed to sign request: %w", err)
 	}
 
-	response, err := s.httpClient.Do(request)
+	resp, err := s.httpClient.Do(request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
 
 	defer func() {
-		closeErr := response.Body.Close()
+		closeErr := resp.Body.Close()
 		if closeErr != nil {
 			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
 		}
 	}()
 
-	var decryptResponse decryptResp
+	var getKeystoreResponse getKeystoreResp
 
-	if respErr := u.processResponse(&decryptResponse, response); respErr != nil {
+	if respErr := u.processResponse(&getKeystoreResponse, resp); respErr != nil {
 		return respErr
 	}
 
 	u.data = map[string]string{
-		"plaintext": string(decryptResponse.Plaintext),
+		"controller":   getKeystoreResponse.Controller,
+		"storage_type": getKeystoreResponse.StorageType,
+		"key_count":    strconv.Itoa(getKeystoreResponse.KeyCount),
 	}
 
 	return nil
 }
 
-func (s *Steps) makeComputeMACReq(userName, endpoint, data string) error { //nolint:dupl // ignore
+func (s *Steps) makeGetKeyReq(userName, endpoint string) error {
 	u := s.users[userName]
 
-	r := &computeMACReq{
-		Data: []byte(data),
-	}
-
-	request, err := u.preparePostRequest(r, endpoint)
+	request, err := u.prepareGetRequest(endpoint)
 	if err != nil {
 		return err
 	}
 
-	err = u.SetCapabilityInvocation(request, actionComputeMac)
+	err = u.SetCapabilityInvocation(request, actionGetKey)
 	if err != nil {
-		return fmt.Errorf("user failed to set zcap: %w", err)
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
 	}
 
 	err = u.Sign(request)
@@ -766,29 +1069,845 @@ func (s *Steps) makeComputeMACReq(userName, endpoint, data string) error { //nol
 		return fmt.Errorf("user failed to sign request: %w", err)
 	}
 
-	response, err := s.httpClient.Do(request)
+	resp, err := s.httpClient.Do(request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
 
 	defer func() {
-		closeErr := response.Body.Close()
+		closeErr := resp.Body.Close()
 		if closeErr != nil {
 			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
 		}
 	}()
 
-	var computeMACResponse computeMACResp
+	var getKeyResponse getKeyResp
 
-	if respErr := u.processResponse(&computeMACResponse, response); respErr != nil {
+	if respErr := u.processResponse(&getKeyResponse, resp); respErr != nil {
 		return respErr
 	}
 
 	u.data = map[string]string{
-		"mac": string(computeMACResponse.MAC),
+		"key_type":   string(getKeyResponse.KeyType),
+		"alias":      getKeyResponse.Alias,
+		"exportable": strconv.FormatBool(getKeyResponse.Exportable),
+		"public_key": string(getKeyResponse.PublicKey),
 	}
 
-	return nil
+	if getKeyResponse.ExpiresAt != nil {
+		u.data["expires_at"] = getKeyResponse.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return nil
+}
+
+// makeCreateKeyWithAliasReq creates a key with the alias. Error responses are not step failures, the status is
+// checked in the next steps.
+func (s *Steps) makeCreateKeyWithAliasReq(userName, endpoint, keyType, alias string) error {
+	return s.makeCreateKeyWithOptionsReq(userName, endpoint, &createKeyReq{KeyType: keyType, Alias: alias})
+}
+
+// makeCreateKeyWithExpiryReq creates a key that expires at the given RFC 3339 time. Error responses are not step
+// failures, the status is checked in the next steps.
+func (s *Steps) makeCreateKeyWithExpiryReq(userName, endpoint, keyType, expiresAt string) error {
+	t, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return fmt.Errorf("parse expiration time: %w", err)
+	}
+
+	return s.makeCreateKeyWithOptionsReq(userName, endpoint, &createKeyReq{KeyType: keyType, ExpiresAt: &t})
+}
+
+// makeCreateKeyWithPurposesReq creates a key restricted to the comma-separated purposes. Error responses are not step
+// failures, the status is checked in the next steps.
+func (s *Steps) makeCreateKeyWithPurposesReq(userName, endpoint, keyType, purposes string) error {
+	return s.makeCreateKeyWithOptionsReq(userName, endpoint,
+		&createKeyReq{KeyType: keyType, Purposes: strings.Split(purposes, ",")})
+}
+
+func (s *Steps) makeCreateKeyWithOptionsReq(userName, endpoint string, req *createKeyReq) error {
+	u := s.users[userName]
+
+	request, err := u.preparePostRequest(req, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionCreateKey); err != nil {
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	if err = processCreateKeyResp(u, resp); err != nil && resp.StatusCode < http.StatusBadRequest {
+		return err
+	}
+
+	return nil
+}
+
+// makeUpdateKeyAliasReq sets the alias of the user's key. Error responses are not step failures, the status is
+// checked in the next steps.
+func (s *Steps) makeUpdateKeyAliasReq(userName, endpoint, alias string) error {
+	u := s.users[userName]
+
+	payload, err := json.Marshal(&updateKeyReq{Alias: alias})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPatch,
+		buildURI(endpoint, u.keystoreID, u.keyID), bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create http request: %w", err)
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionUpdateKey); err != nil {
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var updateKeyResponse updateKeyResp
+
+	if err = u.processResponse(&updateKeyResponse, resp); err != nil {
+		if resp.StatusCode < http.StatusBadRequest {
+			return err
+		}
+
+		return nil
+	}
+
+	u.data = map[string]string{
+		"key_url": updateKeyResponse.KeyURL,
+		"alias":   updateKeyResponse.Alias,
+	}
+
+	return nil
+}
+
+// makeSetKeyStateReq disables or re-enables the user's key.
+func (s *Steps) makeSetKeyStateReq(userName, endpoint, state string) error {
+	u := s.users[userName]
+
+	payload, err := json.Marshal(&setKeyStateReq{State: state})
+	if err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodPatch,
+		buildURI(endpoint, u.keystoreID, u.keyID), bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create http request: %w", err)
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionSetKeyState); err != nil {
+		return fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var setKeyStateResponse setKeyStateResp
+
+	if err = u.processResponse(&setKeyStateResponse, resp); err != nil {
+		return err
+	}
+
+	// data of earlier steps is kept, so that artifacts created before the key was disabled can be checked
+	if u.data == nil {
+		u.data = map[string]string{}
+	}
+
+	u.data["key_url"] = setKeyStateResponse.KeyURL
+	u.data["state"] = setKeyStateResponse.State
+
+	return nil
+}
+
+// useKeyAlias makes next requests of the user refer to the key by the alias instead of the key ID.
+func (s *Steps) useKeyAlias(userName, alias string) error {
+	s.users[userName].keyID = alias
+
+	return nil
+}
+
+// makeDeleteKeystoreReq deletes the user's keystore. Error responses are not step failures, the status is checked
+// in the next steps.
+func (s *Steps) makeDeleteKeystoreReq(userName, endpoint string) error {
+	u := s.users[userName]
+
+	statusCode, status, err := s.deleteKeystore(u, buildURI(endpoint, u.keystoreID, ""), u.kmsCapability)
+	if err != nil {
+		return err
+	}
+
+	u.response = &response{
+		status:     status,
+		statusCode: statusCode,
+	}
+
+	return nil
+}
+
+// deleteKeystore makes an HTTP DELETE request to the keystore URI invoking the given root capability of the keystore.
+func (s *Steps) deleteKeystore(u *user, uri string, capability *zcapld.Capability) (int, string, error) {
+	request, err := http.NewRequestWithContext(context.Background(), http.MethodDelete, uri, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("create http request: %w", err)
+	}
+
+	if err = u.invokeCapability(request, capability, "deleteKeyStore"); err != nil {
+		return 0, "", fmt.Errorf("user failed to set capability invocation: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return 0, "", fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return 0, "", fmt.Errorf("http do: %w", err)
+	}
+
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+	}
+
+	return resp.StatusCode, resp.Status, nil
+}
+
+// makeRejectedSignMessageReq signs with a key that can't sign, e.g. a deleted or disabled one. The status is checked
+// in the next steps.
+func (s *Steps) makeRejectedSignMessageReq(userName, endpoint, message string) error {
+	err := s.makeSignMessageReq(userName, endpoint, message)
+	if err == nil {
+		return fmt.Errorf("expected sign to fail")
+	}
+
+	if s.users[userName].response == nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Steps) makeSignMessageReq(userName, endpoint, message string) error {
+	return s.makeSignReq(userName, endpoint, &signReq{Message: []byte(message)})
+}
+
+func (s *Steps) makeSignMessagesReq(userName, endpoint, count string) error {
+	messages, err := bbsMessages(count)
+	if err != nil {
+		return err
+	}
+
+	return s.makeSignReq(userName, endpoint, &signReq{Messages: messages})
+}
+
+func (s *Steps) makeSignReq(userName, endpoint string, r *signReq) error { //nolint:dupl // ignore
+	u := s.users[userName]
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionSign)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.do(u, actionSign, request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	return processSignResp(u, response)
+}
+
+func processSignResp(u *user, response *http.Response) error {
+	var signResponse signResp
+
+	if respErr := u.processResponse(&signResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"signature": string(signResponse.Signature),
+	}
+
+	return nil
+}
+
+func (s *Steps) makeSignBatchReq(userName, endpoint, messages string) error {
+	var batch [][]byte
+
+	for _, m := range strings.Split(messages, ",") {
+		batch = append(batch, []byte(m))
+	}
+
+	return s.signBatch(userName, endpoint, batch)
+}
+
+func (s *Steps) signBatch(userName, endpoint string, messages [][]byte) error {
+	u := s.users[userName]
+
+	request, err := u.preparePostRequest(&signBatchReq{Messages: messages}, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionSignBatch); err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.do(u, actionSignBatch, request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	return processSignBatchResp(u, response)
+}
+
+func processSignBatchResp(u *user, response *http.Response) error {
+	var signBatchResponse signBatchResp
+
+	if respErr := u.processResponse(&signBatchResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"signature_count": strconv.Itoa(len(signBatchResponse.Signatures)),
+	}
+
+	for i, signature := range signBatchResponse.Signatures {
+		u.data[fmt.Sprintf("signature_%d", i)] = string(signature)
+	}
+
+	return nil
+}
+
+// signMultiKey signs each message with the key of the same index with a single multi-key sign request.
+func (s *Steps) signMultiKey(userName, endpoint string, keyIDs []string, messages [][]byte) error {
+	u := s.users[userName]
+
+	r := &signMultiKeyReq{}
+
+	for i, keyID := range keyIDs {
+		r.Items = append(r.Items, signMultiKeyItem{KeyID: keyID, Message: messages[i]})
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	if err = u.SetCapabilityInvocation(request, actionSignMultiKey); err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	if err = u.Sign(request); err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.do(u, actionSignMultiKey, request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var resp signBatchResp
+
+	if err = u.processResponse(&resp, response); err != nil {
+		return err
+	}
+
+	if len(resp.Signatures) != len(keyIDs) {
+		return fmt.Errorf("expected %d signatures, got %d", len(keyIDs), len(resp.Signatures))
+	}
+
+	return nil
+}
+
+func (s *Steps) makeVerifySignatureReq(userName, endpoint, tag, message string) error {
+	u := s.users[userName]
+
+	r := &verifyReq{
+		Signature: []byte(u.data[tag]),
+		Message:   []byte(message),
+	}
+
+	return s.makeVerifyReq(u, actionVerify, r, endpoint)
+}
+
+func (s *Steps) makeVerifyMessagesSignatureReq(userName, endpoint, tag, count string) error {
+	u := s.users[userName]
+
+	messages, err := bbsMessages(count)
+	if err != nil {
+		return err
+	}
+
+	r := &verifyReq{
+		Signature: []byte(u.data[tag]),
+		Messages:  messages,
+	}
+
+	return s.makeVerifyReq(u, actionVerify, r, endpoint)
+}
+
+// makeDeriveProofReq derives a proof of the user's BBS+ signature revealing messages at the indexes. Error responses
+// are not step failures, the status is checked in the next steps.
+func (s *Steps) makeDeriveProofReq(userName, endpoint, indexes, count string) error {
+	u := s.users[userName]
+
+	messages, err := bbsMessages(count)
+	if err != nil {
+		return err
+	}
+
+	revealed, err := revealedIndexes(indexes)
+	if err != nil {
+		return err
+	}
+
+	r := &deriveProofReq{
+		Messages:        messages,
+		Signature:       []byte(u.data["signature"]),
+		Nonce:           []byte(bbsProofNonce),
+		RevealedIndexes: revealed,
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionDeriveProof)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.do(u, actionDeriveProof, request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var deriveProofResponse deriveProofResp
+
+	if respErr := u.processResponse(&deriveProofResponse, response); respErr != nil {
+		if u.response == nil {
+			return respErr
+		}
+
+		return nil
+	}
+
+	u.data["proof"] = string(deriveProofResponse.Proof)
+
+	return nil
+}
+
+func (s *Steps) makeVerifyProofReq(userName, endpoint, tag, indexes, count string) error {
+	u := s.users[userName]
+
+	messages, err := bbsMessages(count)
+	if err != nil {
+		return err
+	}
+
+	revealed, err := revealedIndexes(indexes)
+	if err != nil {
+		return err
+	}
+
+	r := &verifyProofReq{
+		Proof: []byte(u.data[tag]),
+		Nonce: []byte(bbsProofNonce),
+	}
+
+	for _, i := range revealed {
+		r.Messages = append(r.Messages, messages[i])
+	}
+
+	return s.makeVerifyReq(u, actionVerifyProof, r, endpoint)
+}
+
+func revealedIndexes(indexes string) ([]int, error) {
+	var revealed []int
+
+	for _, index := range strings.Split(indexes, ",") {
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return nil, fmt.Errorf("invalid revealed index: %w", err)
+		}
+
+		revealed = append(revealed, i)
+	}
+
+	return revealed, nil
+}
+
+// bbsMessages returns messages signed with a single BBS+ signature in the scenarios.
+func bbsMessages(count string) ([][]byte, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message count: %w", err)
+	}
+
+	messages := make([][]byte, n)
+
+	for i := range messages {
+		messages[i] = []byte(fmt.Sprintf("message %d", i+1))
+	}
+
+	return messages, nil
+}
+
+func (s *Steps) makeRejectedVerifySignatureReq(userName, endpoint, tag, message string) error {
+	err := s.makeVerifySignatureReq(userName, endpoint, tag, message)
+	if err == nil {
+		return fmt.Errorf("expected verify to fail")
+	}
+
+	if s.users[userName].response == nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Steps) makeCreateTokenReq(userName, endpoint, action string) error {
+	u := s.users[userName]
+
+	r := &createTokenReq{
+		Action:    action,
+		ExpiresIn: 600, //nolint:gomnd // ten minutes
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionCreateToken)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var createTokenResponse createTokenResp
+
+	if respErr := u.processResponse(&createTokenResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data["token"] = createTokenResponse.Token
+
+	return nil
+}
+
+// makeVerifySignatureReqWithToken verifies the signature authorized by the user's one-time token instead of a
+// capability. Error responses are not step failures, the status is checked in the next steps.
+func (s *Steps) makeVerifySignatureReqWithToken(userName, endpoint, tag, message string) error {
+	u := s.users[userName]
+
+	r := &verifyReq{
+		Signature: []byte(u.data[tag]),
+		Message:   []byte(message),
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "OneTime "+u.data["token"])
+
+	resp, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	u.response = &response{
+		status:     resp.Status,
+		statusCode: resp.StatusCode,
+	}
+
+	return nil
+}
+
+func (s *Steps) makeEncryptMessageReq(userName, endpoint, message string) error {
+	u := s.users[userName]
+
+	r := &encryptReq{
+		Message: []byte(message),
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionEncrypt)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap on request: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var encryptResponse encryptResp
+
+	if respErr := u.processResponse(&encryptResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"ciphertext": string(encryptResponse.Ciphertext),
+		"nonce":      string(encryptResponse.Nonce),
+	}
+
+	return nil
+}
+
+func (s *Steps) makeDecryptCipherReq(userName, endpoint, tag string) error {
+	u := s.users[userName]
+
+	r := &decryptReq{
+		Ciphertext: []byte(u.data[tag]),
+		Nonce:      []byte(u.data["nonce"]),
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionDecrypt)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var decryptResponse decryptResp
+
+	if respErr := u.processResponse(&decryptResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"plaintext": string(decryptResponse.Plaintext),
+	}
+
+	return nil
+}
+
+// makeTamperedDecryptCipherReq flips a byte of the ciphertext and expects decryption to fail.
+func (s *Steps) makeTamperedDecryptCipherReq(userName, endpoint, tag string) error {
+	u := s.users[userName]
+
+	ciphertext := []byte(u.data[tag])
+	if len(ciphertext) == 0 {
+		return fmt.Errorf("no %q to tamper with", tag)
+	}
+
+	ciphertext[0] ^= 0x01
+	u.data[tag] = string(ciphertext)
+
+	err := s.makeDecryptCipherReq(userName, endpoint, tag)
+	if err == nil {
+		return fmt.Errorf("expected decrypt to fail")
+	}
+
+	if u.response == nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Steps) makeComputeMACReq(userName, endpoint, data string) error { //nolint:dupl // ignore
+	u := s.users[userName]
+
+	r := &computeMACReq{
+		Data: []byte(data),
+	}
+
+	request, err := u.preparePostRequest(r, endpoint)
+	if err != nil {
+		return err
+	}
+
+	err = u.SetCapabilityInvocation(request, actionComputeMac)
+	if err != nil {
+		return fmt.Errorf("user failed to set zcap: %w", err)
+	}
+
+	err = u.Sign(request)
+	if err != nil {
+		return fmt.Errorf("user failed to sign request: %w", err)
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	var computeMACResponse computeMACResp
+
+	if respErr := u.processResponse(&computeMACResponse, response); respErr != nil {
+		return respErr
+	}
+
+	u.data = map[string]string{
+		"mac": string(computeMACResponse.MAC),
+	}
+
+	return nil
 }
 
 func (s *Steps) makeVerifyMACReq(userName, endpoint, tag, data string) error {
@@ -818,7 +1937,7 @@ func (s *Steps) makeVerifyReq(u *user, action string, r interface{}, endpoint st
 		return fmt.Errorf("user failed to sign request: %w", err)
 	}
 
-	response, err := s.httpClient.Do(request)
+	response, err := s.do(u, action, request)
 	if err != nil {
 		return fmt.Errorf("http do: %w", err)
 	}
@@ -0,0 +1,58 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// makeVerifyWithPublicKeyReq verifies the signature of the signer with the signer's public key, exported earlier, in
+// the user's own key store. Error responses are not step failures, the status is checked in the next steps.
+func (s *Steps) makeVerifyWithPublicKeyReq(userName, endpoint, tag, signerName, message, keyType string) error {
+	u := s.users[userName]
+
+	signer, ok := s.users[signerName]
+	if !ok {
+		return fmt.Errorf("no user with name %s exist", signerName)
+	}
+
+	pub, ok := u.recipientPubKeys[signerName]
+	if !ok {
+		return fmt.Errorf("no public key of %s", signerName)
+	}
+
+	r := &verifyReq{
+		Signature: []byte(signer.data[tag]),
+		Message:   []byte(message),
+		PublicKey: pub.rawBytes,
+		KeyType:   keyType,
+	}
+
+	response, closeBody, err := s.makeHTTPReq(u, r, endpoint, actionVerify)
+	if err != nil {
+		return err
+	}
+
+	defer closeBody()
+
+	var resp verifyResp
+
+	if respErr := u.processResponse(&resp, response); respErr != nil {
+		if u.response == nil {
+			return respErr
+		}
+
+		return nil
+	}
+
+	u.data = map[string]string{
+		"verified": strconv.FormatBool(resp.Verified),
+	}
+
+	return nil
+}
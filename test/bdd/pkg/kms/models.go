@@ -7,6 +7,9 @@ SPDX-License-Identifier: Apache-2.0
 package kms
 
 import (
+	"encoding/json"
+	"time"
+
 	"github.com/hyperledger/aries-framework-go/pkg/crypto"
 	"github.com/hyperledger/aries-framework-go/pkg/kms"
 )
@@ -16,10 +19,15 @@ type createDIDResp struct {
 }
 
 type createKeystoreReq struct {
-	Controller string      `json:"controller"`
-	EDV        *edvOptions `json:"edv"`
+	Controller  string      `json:"controller"`
+	StorageMode string      `json:"storage_mode,omitempty"`
+	EDV         *edvOptions `json:"edv"`
+	DisableZCAP bool        `json:"disable_zcap,omitempty"`
 }
 
+// storageModeEDV is the storage mode of key stores with keys stored on EDV, see command.StorageModeEDV.
+const storageModeEDV = "edv"
+
 type edvOptions struct {
 	VaultURL   string `json:"vault_url"`
 	Capability []byte `json:"capability"`
@@ -31,8 +39,63 @@ type createKeyStoreResp struct {
 }
 
 type createKeyReq struct {
-	KeyType   string `json:"key_type"`
-	ExportKey bool   `json:"export"`
+	KeyType   string     `json:"key_type"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Purposes  []string   `json:"purposes,omitempty"`
+	ExportKey bool       `json:"export"`
+}
+
+type updateKeyReq struct {
+	Alias string `json:"alias"`
+}
+
+type updateKeyResp struct {
+	KeyURL string `json:"key_url"`
+	Alias  string `json:"alias"`
+}
+
+type setKeyStateReq struct {
+	State string `json:"state"`
+}
+
+type setKeyStateResp struct {
+	KeyURL string `json:"key_url"`
+	State  string `json:"state"`
+}
+
+type createInvitationReq struct {
+	Capability []byte `json:"capability,omitempty"`
+	TheirPub   []byte `json:"their_pub,omitempty"`
+}
+
+type createInvitationResp struct {
+	Invitation    json.RawMessage `json:"invitation"`
+	InvitationURL string          `json:"invitation_url"`
+}
+
+type invitation struct {
+	Requests []struct {
+		ID   string `json:"@id"`
+		Data struct {
+			JSON json.RawMessage `json:"json"`
+		} `json:"data"`
+	} `json:"request~attach"`
+}
+
+type sealedCapability struct {
+	Ciphertext []byte `json:"ciphertext"`
+	Nonce      []byte `json:"nonce"`
+	SenderKey  string `json:"sender_key"`
+}
+
+type acceptInvitationReq struct {
+	Invitation json.RawMessage `json:"invitation"`
+	MyLabel    string          `json:"my_label"`
+}
+
+type acceptInvitationResp struct {
+	ConnectionID string `json:"connection_id"`
 }
 
 type createKeyResp struct {
@@ -40,19 +103,48 @@ type createKeyResp struct {
 	PublicKey []byte `json:"public_key"`
 }
 
+type createKeysReq struct {
+	Keys []createKeyReq `json:"keys"`
+}
+
+type createKeysResp struct {
+	Keys []createKeyResp `json:"keys"`
+}
+
+type getKeystoreResp struct {
+	Controller  string `json:"controller"`
+	StorageType string `json:"storage_type"`
+	KeyCount    int    `json:"key_count"`
+}
+
+type getKeyResp struct {
+	KeyType    kms.KeyType `json:"key_type"`
+	Alias      string      `json:"alias"`
+	Exportable bool        `json:"exportable"`
+	PublicKey  []byte      `json:"public_key"`
+	ExpiresAt  *time.Time  `json:"expires_at"`
+}
+
 type exportKeyResp struct {
 	PublicKey []byte      `json:"public_key"`
 	KeyType   kms.KeyType `json:"key_type"`
 }
 
+type exportDIDKeyResp struct {
+	DID                string `json:"did"`
+	VerificationMethod string `json:"verification_method"`
+}
+
 type importKeyReq struct {
-	Key     []byte      `json:"key"`
-	KeyType kms.KeyType `json:"key_type"`
-	KeyID   string      `json:"key_id,omitempty"`
+	Key     []byte          `json:"key,omitempty"`
+	JWK     json.RawMessage `json:"jwk,omitempty"`
+	KeyType kms.KeyType     `json:"key_type"`
+	KeyID   string          `json:"key_id,omitempty"`
 }
 
 type importKeyResp struct {
-	KeyURL string `json:"key_url"`
+	KeyURL    string `json:"key_url"`
+	PublicKey []byte `json:"public_key"`
 }
 
 type rotateKeyReq struct {
@@ -60,20 +152,97 @@ type rotateKeyReq struct {
 }
 
 type rotateKeyResp struct {
-	KeyURL string `json:"key_url"`
+	KeyURL    string `json:"key_url"`
+	PublicKey []byte `json:"public_key"`
+}
+
+type createTokenReq struct {
+	Action    string `json:"action"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type createTokenResp struct {
+	Token string `json:"token"`
+	ID    string `json:"id"`
 }
 
 type signReq struct {
-	Message []byte `json:"message"`
+	Message  []byte   `json:"message,omitempty"`
+	Messages [][]byte `json:"messages,omitempty"`
 }
 
 type signResp struct {
-	Signature []byte `json:"signature"`
+	Signature []byte            `json:"signature"`
+	Details   *signatureDetails `json:"details,omitempty"`
+}
+
+type signatureDetails struct {
+	Algorithm       string `json:"algorithm"`
+	Curve           string `json:"curve,omitempty"`
+	Hash            string `json:"hash,omitempty"`
+	SignatureFormat string `json:"signature_format,omitempty"`
+	JOSEAlgorithm   string `json:"jose_alg,omitempty"`
+	COSEAlgorithm   int    `json:"cose_alg,omitempty"`
+	OutputPrefix    string `json:"output_prefix"`
+	KeyVersion      uint32 `json:"key_version"`
+	Encoding        string `json:"encoding"`
+}
+
+type signJWTReq struct {
+	Claims   json.RawMessage        `json:"claims"`
+	Headers  map[string]interface{} `json:"headers,omitempty"`
+	Detached bool                   `json:"detached,omitempty"`
+}
+
+type signJWTResp struct {
+	JWS string `json:"jws"`
+}
+
+type signBatchReq struct {
+	Messages [][]byte `json:"messages"`
+}
+
+type signBatchResp struct {
+	Signatures [][]byte `json:"signatures"`
+}
+
+type signMultiKeyReq struct {
+	Items []signMultiKeyItem `json:"items"`
+}
+
+type signMultiKeyItem struct {
+	KeyID   string `json:"key_id"`
+	Message []byte `json:"message"`
 }
 
 type verifyReq struct {
-	Signature []byte `json:"signature"`
-	Message   []byte `json:"message"`
+	Signature []byte   `json:"signature"`
+	Message   []byte   `json:"message,omitempty"`
+	Messages  [][]byte `json:"messages,omitempty"`
+	PublicKey []byte   `json:"public_key,omitempty"`
+	KeyType   string   `json:"key_type,omitempty"`
+}
+
+type verifyResp struct {
+	Verified bool              `json:"verified"`
+	Details  *signatureDetails `json:"details,omitempty"`
+}
+
+type deriveProofReq struct {
+	Messages        [][]byte `json:"messages"`
+	Signature       []byte   `json:"signature"`
+	Nonce           []byte   `json:"nonce"`
+	RevealedIndexes []int    `json:"revealed_indexes"`
+}
+
+type deriveProofResp struct {
+	Proof []byte `json:"proof"`
+}
+
+type verifyProofReq struct {
+	Proof    []byte   `json:"proof"`
+	Messages [][]byte `json:"messages"`
+	Nonce    []byte   `json:"nonce"`
 }
 
 type encryptReq struct {
@@ -115,6 +284,7 @@ type wrapReq struct {
 	APV             []byte            `json:"apv"`
 	RecipientPubKey *crypto.PublicKey `json:"recipient_pub_key"`
 	Tag             []byte            `json:"tag,omitempty"`
+	SenderKID       string            `json:"sender_kid,omitempty"`
 }
 
 type wrapResp struct {
@@ -131,12 +301,49 @@ type unwrapResp struct {
 	Key []byte `json:"key"`
 }
 
+type encryptJWEReq struct {
+	Plaintext     []byte              `json:"plaintext"`
+	Recipients    []*crypto.PublicKey `json:"recipients"`
+	Enc           string              `json:"enc,omitempty"`
+	Serialization string              `json:"serialization,omitempty"`
+}
+
+type encryptJWEResp struct {
+	JWE string `json:"jwe"`
+}
+
+type decryptJWEReq struct {
+	JWE string `json:"jwe"`
+}
+
+type decryptJWEResp struct {
+	Plaintext []byte `json:"plaintext"`
+}
+
+type deriveKeyReq struct {
+	PeerPublicKey json.RawMessage   `json:"peer_public_key"`
+	KDF           deriveKeyKDF      `json:"kdf"`
+	Recipient     *crypto.PublicKey `json:"recipient,omitempty"`
+}
+
+type deriveKeyKDF struct {
+	Info   []byte `json:"info,omitempty"`
+	Length int    `json:"length,omitempty"`
+}
+
+type deriveKeyResp struct {
+	Key        []byte                      `json:"key,omitempty"`
+	WrappedKey *crypto.RecipientWrappedKey `json:"wrapped_key,omitempty"`
+}
+
 type setSecretRequest struct {
 	Secret []byte `json:"secret"`
 }
 
 type errorResponse struct {
 	Message string `json:"errMessage,omitempty"`
+	KeyURL  string `json:"key_url,omitempty"`
+	Code    string `json:"code,omitempty"`
 }
 
 type easyReq struct {
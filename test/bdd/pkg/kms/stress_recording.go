@@ -0,0 +1,403 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greenpau/go-calculator"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
+
+	"github.com/trustbloc/kms/test/bdd/pkg/internal/bddutil"
+)
+
+const (
+	// stressRecordingSchemaVersion is a version of the recording file format. Bump it on incompatible changes.
+	stressRecordingSchemaVersion = 1
+	defaultStressRecordingPath   = "fixtures/stress-recordings/local-ed25519.json"
+
+	opCreateKeyStore = "createKeyStore"
+
+	keystoreIDPlaceholder  = "{keystoreID}"
+	keyIDPlaceholder       = "{keyID}"
+	accessTokenPlaceholder = "{accessToken}"
+	subjectPlaceholder     = "{subject}"
+	signaturePlaceholder   = "{signature}"
+)
+
+// headers that carry per-run credentials or replay protection; they are regenerated on replay.
+var volatileHeaders = map[string]struct{}{ //nolint:gochecknoglobals
+	"Signature": {},
+	"Date":      {},
+	"Digest":    {},
+	http.CanonicalHeaderKey(zcapld.CapabilityInvocationHTTPHeader): {},
+}
+
+var zcapActionRegexp = regexp.MustCompile(`action="([^"]*)"`) //nolint:gochecknoglobals
+
+// stressRecording is a sequence of prepared requests captured during a stress test run.
+type stressRecording struct {
+	SchemaVersion int              `json:"schema_version"`
+	Sessions      []*stressSession `json:"sessions"`
+}
+
+// stressSession is a sequence of requests made by one user.
+type stressSession struct {
+	User       string               `json:"user"`
+	Operations []*recordedOperation `json:"operations"`
+}
+
+// recordedOperation is a request with per-run values (key store and key IDs, tokens, signatures) replaced
+// by placeholders.
+type recordedOperation struct {
+	Op      string            `json:"op"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// ZCAPAction is set if the request was signed with a capability invocation for the action.
+	ZCAPAction string `json:"zcap_action,omitempty"`
+}
+
+// do sends the request, recording it first if the user has a session.
+func (s *Steps) do(u *user, op string, r *http.Request) (*http.Response, error) {
+	if u.session != nil {
+		if err := s.recordOperation(u, op, r); err != nil {
+			return nil, fmt.Errorf("record %s: %w", op, err)
+		}
+	}
+
+	return s.httpClient.Do(r)
+}
+
+func (s *Steps) recordOperation(u *user, op string, r *http.Request) error {
+	replacements := []string{s.bddContext.KeyServerURL, ""}
+
+	if u.keystoreID != "" {
+		replacements = append(replacements, u.keystoreID, keystoreIDPlaceholder)
+	}
+
+	if u.keyID != "" {
+		replacements = append(replacements, u.keyID, keyIDPlaceholder)
+	}
+
+	if u.accessToken != "" {
+		replacements = append(replacements, u.accessToken, accessTokenPlaceholder)
+	}
+
+	if u.subject != "" {
+		replacements = append(replacements, u.subject, subjectPlaceholder)
+	}
+
+	if sig := u.data["signature"]; sig != "" {
+		replacements = append(replacements, base64.StdEncoding.EncodeToString([]byte(sig)), signaturePlaceholder)
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+
+	rec := &recordedOperation{
+		Op:      op,
+		Method:  r.Method,
+		Path:    replacer.Replace(r.URL.String()),
+		Headers: map[string]string{},
+	}
+
+	for name := range r.Header {
+		if _, ok := volatileHeaders[name]; ok {
+			continue
+		}
+
+		rec.Headers[name] = replacer.Replace(r.Header.Get(name))
+	}
+
+	if m := zcapActionRegexp.FindStringSubmatch(r.Header.Get(zcapld.CapabilityInvocationHTTPHeader)); m != nil {
+		rec.ZCAPAction = m[1]
+	}
+
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return fmt.Errorf("get body: %w", err)
+		}
+
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+
+		rec.Body = replacer.Replace(string(b))
+	}
+
+	u.session.Operations = append(u.session.Operations, rec)
+
+	return nil
+}
+
+// recordStressSession runs the local storage stress test and saves the requests made by each user to a file.
+// Keep the run small: the recording is replayed as is on every benchmark run.
+func (s *Steps) recordStressSession(usersNumberEnv, keyType string, signTimes int, recordingPathEnv string) error {
+	usersNumber, err := getUsersNumber(usersNumberEnv)
+	if err != nil {
+		return err
+	}
+
+	recording := &stressRecording{SchemaVersion: stressRecordingSchemaVersion}
+
+	pool := bddutil.NewWorkerPool(1, s.logger)
+
+	pool.Start()
+
+	for i := 0; i < usersNumber; i++ {
+		userName := fmt.Sprintf(userNameTplt, i)
+
+		session := &stressSession{User: userName}
+		s.users[userName].session = session
+
+		recording.Sessions = append(recording.Sessions, session)
+
+		pool.Submit(&stressRequest{
+			userName:     userName,
+			keyServerURL: s.bddContext.KeyServerURL,
+			keyType:      keyType,
+			steps:        s,
+			signRequests: signTimes,
+		})
+	}
+
+	pool.Stop()
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return resp.Err
+		}
+	}
+
+	b, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal recording: %w", err)
+	}
+
+	path := stressRecordingPath(recordingPathEnv)
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil { //nolint:gomnd
+		return fmt.Errorf("create recordings dir: %w", err)
+	}
+
+	if err = ioutil.WriteFile(path, b, 0o600); err != nil { //nolint:gomnd
+		return fmt.Errorf("write recording: %w", err)
+	}
+
+	fmt.Printf("recorded %d sessions to %s\n", len(recording.Sessions), path)
+
+	return nil
+}
+
+// replayStressSession re-executes recorded sessions against Key Server with fresh tokens, capabilities and
+// signatures, so that consecutive benchmark runs send byte-identical workloads.
+func (s *Steps) replayStressSession(recordingPathEnv, concurrencyEnv string) error {
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	recording, err := readStressRecording(stressRecordingPath(recordingPathEnv))
+	if err != nil {
+		return err
+	}
+
+	for _, session := range recording.Sessions {
+		if _, ok := s.users[session.User]; !ok {
+			return fmt.Errorf("no user %s for recorded session", session.User)
+		}
+	}
+
+	perf := &replayPerfInfo{durations: map[string][]int64{}}
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for _, session := range recording.Sessions {
+		pool.Submit(&replayRequest{
+			user:    s.users[session.User],
+			session: session,
+			steps:   s,
+			perf:    perf,
+		})
+	}
+
+	pool.Stop()
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return resp.Err
+		}
+	}
+
+	for _, op := range perf.ops {
+		calc := calculator.NewInt64(perf.durations[op])
+		fmt.Printf("%s avg time: %s\n", op, (time.Duration(calc.Mean().Register.Mean) *
+			time.Millisecond).String())
+		fmt.Printf("%s max time: %s\n", op, (time.Duration(calc.Max().Register.MaxValue) *
+			time.Millisecond).String())
+		fmt.Printf("%s min time: %s\n", op, (time.Duration(calc.Min().Register.MinValue) *
+			time.Millisecond).String())
+		fmt.Println("------")
+	}
+
+	return nil
+}
+
+func stressRecordingPath(recordingPathEnv string) string {
+	if path := os.Getenv(recordingPathEnv); path != "" {
+		return path
+	}
+
+	return defaultStressRecordingPath
+}
+
+func readStressRecording(path string) (*stressRecording, error) {
+	b, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+
+	var recording stressRecording
+
+	if err = json.Unmarshal(b, &recording); err != nil {
+		return nil, fmt.Errorf("unmarshal recording: %w", err)
+	}
+
+	if recording.SchemaVersion != stressRecordingSchemaVersion {
+		return nil, fmt.Errorf("unsupported recording schema version %d, expected %d",
+			recording.SchemaVersion, stressRecordingSchemaVersion)
+	}
+
+	return &recording, nil
+}
+
+type replayPerfInfo struct {
+	mutex     sync.Mutex
+	ops       []string
+	durations map[string][]int64
+}
+
+func (p *replayPerfInfo) add(op string, d time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.durations[op]; !ok {
+		p.ops = append(p.ops, op)
+	}
+
+	p.durations[op] = append(p.durations[op], d.Milliseconds())
+}
+
+type replayRequest struct {
+	user    *user
+	session *stressSession
+	steps   *Steps
+	perf    *replayPerfInfo
+}
+
+func (r *replayRequest) Invoke() (interface{}, error) {
+	// start every replay from scratch, IDs are assigned by the server
+	r.user.keystoreID, r.user.keyID, r.user.data = "", "", nil
+
+	for i, op := range r.session.Operations {
+		startTime := time.Now()
+
+		if err := r.steps.replayOperation(r.user, op); err != nil {
+			return nil, fmt.Errorf("replay %s operation %d (%s): %w", r.session.User, i, op.Op, err)
+		}
+
+		r.perf.add(op.Op, time.Since(startTime))
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+func (s *Steps) replayOperation(u *user, op *recordedOperation) error {
+	replacer := strings.NewReplacer(
+		keystoreIDPlaceholder, u.keystoreID,
+		keyIDPlaceholder, u.keyID,
+		accessTokenPlaceholder, u.accessToken,
+		subjectPlaceholder, u.subject,
+		signaturePlaceholder, base64.StdEncoding.EncodeToString([]byte(u.data["signature"])),
+	)
+
+	var body io.Reader
+
+	if op.Body != "" {
+		body = bytes.NewReader([]byte(replacer.Replace(op.Body)))
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), op.Method,
+		s.bddContext.KeyServerURL+replacer.Replace(op.Path), body)
+	if err != nil {
+		return fmt.Errorf("create http request: %w", err)
+	}
+
+	for name, value := range op.Headers {
+		request.Header.Set(name, replacer.Replace(value))
+	}
+
+	// capability invocation and HTTP signature are regenerated, so every request gets a fresh nonce
+	if op.ZCAPAction != "" {
+		if err = u.SetCapabilityInvocation(request, op.ZCAPAction); err != nil {
+			return fmt.Errorf("user failed to set zcap on request: %w", err)
+		}
+
+		if err = u.Sign(request); err != nil {
+			return fmt.Errorf("user failed to sign request: %w", err)
+		}
+	}
+
+	response, err := s.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http do: %w", err)
+	}
+
+	defer func() {
+		closeErr := response.Body.Close()
+		if closeErr != nil {
+			s.logger.Errorf("Failed to close response body: %s\n", closeErr.Error())
+		}
+	}()
+
+	switch op.Op {
+	case opCreateKeyStore:
+		if err = processCreateKeystoreResp(u, response); err != nil {
+			return err
+		}
+
+		s.trackKeyStore(u)
+
+		return nil
+	case actionCreateKey:
+		return processCreateKeyResp(u, response)
+	case actionSign:
+		return processSignResp(u, response)
+	case actionSignBatch:
+		return processSignBatchResp(u, response)
+	default:
+		return u.processResponse(nil, response)
+	}
+}
@@ -12,11 +12,15 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/greenpau/go-calculator"
+	"github.com/rs/xid"
+	"github.com/trustbloc/edge-core/pkg/zcapld"
 
 	"github.com/trustbloc/kms/test/bdd/pkg/auth"
 	"github.com/trustbloc/kms/test/bdd/pkg/internal/bddutil"
@@ -25,14 +29,29 @@ import (
 const (
 	userNameTplt = "User%d"
 	controller   = "did:example:123456789"
+
+	createKeyStoreAttempts   = 3
+	createKeyStoreRetryDelay = 500 * time.Millisecond
 )
 
+// createUsers creates users that can't sign capability invocations, so their keystores are created without ZCAPs
+// and authorized by their OAuth subjects. Key Server must allow such keystores.
 func (s *Steps) createUsers(usersNumberEnv string) error {
 	usersNumber, err := getUsersNumber(usersNumberEnv)
 	if err != nil {
 		return err
 	}
 
+	enabled, err := s.noZCAPKeyStoresEnabled()
+	if err != nil {
+		return err
+	}
+
+	if !enabled {
+		return fmt.Errorf("key server at %s doesn't allow keystores without ZCAPs: start it with "+
+			"--enable-no-zcap-key-stores or create users from a prototype", s.bddContext.KeyServerURL)
+	}
+
 	for i := 0; i < usersNumber; i++ {
 		userName := fmt.Sprintf(userNameTplt, i)
 
@@ -40,6 +59,9 @@ func (s *Steps) createUsers(usersNumberEnv string) error {
 			name:        userName,
 			controller:  controller,
 			disableZCAP: true,
+			// the stress Key Server is reached without a proxy that introspects tokens, so any token is accepted
+			subject:     "stress-" + xid.New().String(),
+			accessToken: xid.New().String(),
 		}
 		s.users[userName] = u
 
@@ -122,9 +144,25 @@ func (s *Steps) createEDVDataVaultForMultipleUsers(usersNumberEnv string) error
 	return nil
 }
 
-//nolint:funlen,gocyclo
 func (s *Steps) stressTestForMultipleUsers(
-	totalRequestsEnv, storeType, keyType string, signTimes int, concurrencyEnv string) error {
+	totalRequestsEnv, storageMode, keyType string, signTimes int, concurrencyEnv string) error {
+	return s.runStressTest(totalRequestsEnv, storageMode, keyType, signTimes, 0, concurrencyEnv)
+}
+
+// stressTestForMultipleUsersWithSignBatch runs the stress test with messages signed by the sign batch endpoint, so
+// that HTTP and authorization overhead is paid once per batch instead of once per signature.
+func (s *Steps) stressTestForMultipleUsersWithSignBatch(
+	totalRequestsEnv, storageMode, keyType string, signTimes, signBatchSize int, concurrencyEnv string) error {
+	if signBatchSize <= 0 {
+		return fmt.Errorf("invalid sign batch size: %d", signBatchSize)
+	}
+
+	return s.runStressTest(totalRequestsEnv, storageMode, keyType, signTimes, signBatchSize, concurrencyEnv)
+}
+
+//nolint:funlen,gocyclo
+func (s *Steps) runStressTest(
+	totalRequestsEnv, storageMode, keyType string, signTimes, signBatchSize int, concurrencyEnv string) error {
 	totalRequests, err := getUsersNumber(totalRequestsEnv)
 	if err != nil {
 		return err
@@ -135,13 +173,10 @@ func (s *Steps) stressTestForMultipleUsers(
 		return err
 	}
 
-	if storeType != "EDV" && storeType != "LocalStorage" {
-		return errors.New("invalid store type:" + storeType)
-	}
-
+	// the storage mode is validated by the server, an invalid one fails creation of key stores
 	var edvCapabilities [][]byte
 
-	if storeType == "EDV" {
+	if storageMode == storageModeEDV {
 		for i := 0; i < totalRequests; i++ {
 			userName := fmt.Sprintf(userNameTplt, i)
 
@@ -180,12 +215,14 @@ func (s *Steps) stressTestForMultipleUsers(
 
 	for i := 0; i < totalRequests; i++ {
 		r := &stressRequest{
-			userName:     fmt.Sprintf(userNameTplt, i),
-			keyServerURL: s.bddContext.KeyServerURL,
-			edvServerURL: s.bddContext.EDVServerURL,
-			keyType:      keyType,
-			steps:        s,
-			signRequests: signTimes,
+			userName:      fmt.Sprintf(userNameTplt, i),
+			storageMode:   storageMode,
+			keyServerURL:  s.bddContext.KeyServerURL,
+			edvServerURL:  s.bddContext.EDVServerURL,
+			keyType:       keyType,
+			steps:         s,
+			signRequests:  signTimes,
+			signBatchSize: signBatchSize,
 		}
 		if edvCapabilities != nil {
 			r.edvCapability = edvCapabilities[i]
@@ -206,6 +243,7 @@ func (s *Steps) stressTestForMultipleUsers(
 		createKeyStoreHTTPTime []int64
 		createKeyHTTPTime      []int64
 		signHTTPTime           []int64
+		signAmortizedTime      []int64
 		verifyHTTPTime         []int64
 	)
 
@@ -222,6 +260,7 @@ func (s *Steps) stressTestForMultipleUsers(
 		createKeyStoreHTTPTime = append(createKeyStoreHTTPTime, perfInfo.createKeyStoreHTTPTime)
 		createKeyHTTPTime = append(createKeyHTTPTime, perfInfo.createKeyHTTPTime)
 		signHTTPTime = append(signHTTPTime, perfInfo.signHTTPTime)
+		signAmortizedTime = append(signAmortizedTime, perfInfo.signAmortizedTime)
 		verifyHTTPTime = append(verifyHTTPTime, perfInfo.verifyHTTPTime)
 	}
 
@@ -252,6 +291,15 @@ func (s *Steps) stressTestForMultipleUsers(
 		time.Millisecond).String())
 	fmt.Println("------")
 
+	calc = calculator.NewInt64(signAmortizedTime)
+	fmt.Printf("sign avg time per signature: %s\n", (time.Duration(calc.Mean().Register.Mean) *
+		time.Microsecond).String())
+	fmt.Printf("sign max time per signature: %s\n", (time.Duration(calc.Max().Register.MaxValue) *
+		time.Microsecond).String())
+	fmt.Printf("sign min time per signature: %s\n", (time.Duration(calc.Min().Register.MinValue) *
+		time.Microsecond).String())
+	fmt.Println("------")
+
 	calc = calculator.NewInt64(verifyHTTPTime)
 	fmt.Printf("verify avg time: %s\n", (time.Duration(calc.Mean().Register.Mean) *
 		time.Millisecond).String())
@@ -351,6 +399,159 @@ func (s *Steps) authStressTestForMultipleUsers(totalRequestsEnv, userName, concu
 	return nil
 }
 
+// overloadKeyServer runs a sustained load against a Key Server started with low load shedding thresholds. It expects
+// the server to reject part of the requests with 503 instead of failing, and to stay healthy after the run.
+func (s *Steps) overloadKeyServer(totalRequestsEnv, keyType string, signTimes int, concurrencyEnv string) error {
+	totalRequests, err := getUsersNumber(totalRequestsEnv)
+	if err != nil {
+		return err
+	}
+
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for i := 0; i < totalRequests; i++ {
+		pool.Submit(&overloadRequest{stressRequest{
+			userName:     fmt.Sprintf(userNameTplt, i),
+			keyServerURL: s.bddContext.KeyServerURL,
+			keyType:      keyType,
+			steps:        s,
+			signRequests: signTimes,
+		}})
+	}
+
+	pool.Stop()
+
+	var served, shed int
+
+	for _, resp := range pool.Responses() {
+		if errors.Is(resp.Err, errLoadShed) {
+			shed++
+
+			continue
+		}
+
+		if resp.Err != nil {
+			return fmt.Errorf("server failed under load: %w", resp.Err)
+		}
+
+		served++
+	}
+
+	fmt.Printf("overload: %d requests served, %d requests shed\n", served, shed)
+
+	resp, err := bddutil.HTTPDo(http.MethodGet, s.bddContext.KeyServerURL+"/healthcheck", nil, nil,
+		s.bddContext.TLSConfig())
+	if err != nil {
+		return fmt.Errorf("health check after overload: %w", err)
+	}
+
+	if closeErr := resp.Body.Close(); closeErr != nil {
+		s.logger.Errorf("Failed to close response body: %s", closeErr)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected server to stay healthy after overload but got status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// createdKeyStore is a keystore created on Key Server with the root capability needed to delete it. Keystores
+// without ZCAPs are deleted with the bearer token of their creator.
+type createdKeyStore struct {
+	user       *user
+	keyStoreID string
+	capability *zcapld.Capability
+}
+
+// trackKeyStore remembers the keystore the user has just created, so that it can be deleted at the end of the run.
+func (s *Steps) trackKeyStore(u *user) {
+	if !u.disableZCAP && u.kmsCapability == nil {
+		return // a keystore with ZCAPs can't be deleted without a root capability
+	}
+
+	s.keyStoresMutex.Lock()
+	defer s.keyStoresMutex.Unlock()
+
+	s.keyStores = append(s.keyStores, &createdKeyStore{
+		user:       u,
+		keyStoreID: u.keystoreID,
+		capability: u.kmsCapability,
+	})
+}
+
+// deleteCreatedKeystores deletes all keystores created on Key Server during the run, so that the storage of
+// the server doesn't grow between test cycles.
+func (s *Steps) deleteCreatedKeystores(concurrencyEnv string) error {
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	s.keyStoresMutex.Lock()
+	keyStores := s.keyStores
+	s.keyStores = nil
+	s.keyStoresMutex.Unlock()
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for _, ks := range keyStores {
+		pool.Submit(&deleteKeystoreRequest{
+			keyStore: ks,
+			uri:      s.bddContext.KeyServerURL + createKeystoreEndpoint + "/" + ks.keyStoreID,
+			steps:    s,
+		})
+	}
+
+	pool.Stop()
+
+	var failed int
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			s.logger.Errorf("Failed to delete keystore: %s", resp.Err)
+
+			failed++
+		}
+	}
+
+	fmt.Printf("deleted %d of %d keystores\n", len(keyStores)-failed, len(keyStores))
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d keystores", failed, len(keyStores))
+	}
+
+	return nil
+}
+
+type deleteKeystoreRequest struct {
+	keyStore *createdKeyStore
+	uri      string
+	steps    *Steps
+}
+
+func (r *deleteKeystoreRequest) Invoke() (interface{}, error) {
+	statusCode, status, err := r.steps.deleteKeystore(r.keyStore.user, r.uri, r.keyStore.capability)
+	if err != nil {
+		return nil, fmt.Errorf("delete keystore %s: %w", r.keyStore.keyStoreID, err)
+	}
+
+	if statusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("delete keystore %s: unexpected response status %s", r.keyStore.keyStoreID, status)
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
 func getConcurrencyReq(concurrencyEnv string) (int, error) {
 	concurrencyReqStr := os.Getenv(concurrencyEnv)
 	if concurrencyReqStr == "" {
@@ -371,26 +572,51 @@ func getUsersNumber(usersNumberEnv string) (int, error) {
 
 type stressRequest struct {
 	userName      string
+	storageMode   string
 	edvCapability []byte
 	edvServerURL  string
 	keyServerURL  string
 	keyType       string
 	steps         *Steps
 	signRequests  int
+	signBatchSize int // messages are signed by the sign batch endpoint if positive
 }
 
 type stressRequestPerfInfo struct {
 	createKeyStoreHTTPTime int64
 	createKeyHTTPTime      int64
-	signHTTPTime           int64
+	signHTTPTime           int64 // milliseconds per sign request
+	signAmortizedTime      int64 // microseconds per signature
 	verifyHTTPTime         int64
 }
 
+// createKeystore creates a keystore, retrying failed requests with the same idempotency key, so that a retry of
+// a request that reached the server doesn't leave an orphaned keystore.
+func (r *stressRequest) createKeystore(u *user, createReq *createKeystoreReq) error {
+	idempotencyKey := xid.New().String()
+
+	var err error
+
+	for attempt := 1; attempt <= createKeyStoreAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * createKeyStoreRetryDelay)
+		}
+
+		err = r.steps.createKeystoreReq(u, createReq, r.keyServerURL+createKeystoreEndpoint, idempotencyKey)
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
 func (r *stressRequest) Invoke() (interface{}, error) {
 	u := r.steps.users[r.userName]
 
 	createReq := &createKeystoreReq{
-		Controller: u.controller,
+		Controller:  u.controller,
+		StorageMode: r.storageMode,
 	}
 
 	if r.edvCapability != nil {
@@ -404,7 +630,7 @@ func (r *stressRequest) Invoke() (interface{}, error) {
 
 	startTime := time.Now()
 
-	err := r.steps.createKeystoreReq(u, createReq, r.keyServerURL+createKeystoreEndpoint)
+	err := r.createKeystore(u, createReq)
 	if err != nil {
 		return nil, fmt.Errorf("create keystore %w", err)
 	}
@@ -424,18 +650,33 @@ func (r *stressRequest) Invoke() (interface{}, error) {
 
 	startTime = time.Now()
 
-	for i := 0; i < r.signRequests; i++ {
-		err = r.steps.makeSignMessageReq(r.userName, r.keyServerURL+signEndpoint, message)
+	signatureTag := "signature"
+	signHTTPRequests := r.signRequests
+
+	if r.signBatchSize > 0 {
+		signatureTag = "signature_0"
+
+		signHTTPRequests, err = r.signInBatches(message)
 		if err != nil {
-			return nil, fmt.Errorf("sign %w", err)
+			return nil, fmt.Errorf("sign batch %w", err)
+		}
+	} else {
+		for i := 0; i < r.signRequests; i++ {
+			err = r.steps.makeSignMessageReq(r.userName, r.keyServerURL+signEndpoint, message)
+			if err != nil {
+				return nil, fmt.Errorf("sign %w", err)
+			}
 		}
 	}
 
-	perfInfo.signHTTPTime = time.Since(startTime).Milliseconds() / int64(r.signRequests)
+	signTime := time.Since(startTime)
+
+	perfInfo.signHTTPTime = signTime.Milliseconds() / int64(signHTTPRequests)
+	perfInfo.signAmortizedTime = signTime.Microseconds() / int64(r.signRequests)
 
 	startTime = time.Now()
 
-	err = r.steps.makeVerifySignatureReq(r.userName, r.keyServerURL+verifyEndpoint, "signature", message)
+	err = r.steps.makeVerifySignatureReq(r.userName, r.keyServerURL+verifyEndpoint, signatureTag, message)
 	if err != nil {
 		return nil, err
 	}
@@ -445,6 +686,688 @@ func (r *stressRequest) Invoke() (interface{}, error) {
 	return perfInfo, nil
 }
 
+// signInBatches signs the message signRequests times with batches of at most signBatchSize messages. It returns the
+// number of sign batch requests made.
+func (r *stressRequest) signInBatches(message string) (int, error) {
+	var requests int
+
+	for signed := 0; signed < r.signRequests; signed += r.signBatchSize {
+		n := r.signBatchSize
+		if remaining := r.signRequests - signed; remaining < n {
+			n = remaining
+		}
+
+		messages := make([][]byte, n)
+		for i := range messages {
+			messages[i] = []byte(message)
+		}
+
+		if err := r.steps.signBatch(r.userName, r.keyServerURL+signBatchEndpoint, messages); err != nil {
+			return 0, err
+		}
+
+		requests++
+	}
+
+	return requests, nil
+}
+
+// signMultiKeyStressTest compares signing a message with each of keyCount keys of a keystore in a single multi-key
+// sign request to signing it with the keys one request after another, e.g. to sign the credentials of a bundle. Each
+// user signs signTimes rounds both ways.
+func (s *Steps) signMultiKeyStressTest(totalRequestsEnv string, keyCount int, keyType string, signTimes int,
+	concurrencyEnv string) error {
+	totalRequests, err := getUsersNumber(totalRequestsEnv)
+	if err != nil {
+		return err
+	}
+
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	if keyCount <= 0 || signTimes <= 0 {
+		return fmt.Errorf("invalid key count or sign times: %d, %d", keyCount, signTimes)
+	}
+
+	fmt.Printf("totalRequests: %d, concurrencyReq: %d", totalRequests, concurrencyReq)
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for i := 0; i < totalRequests; i++ {
+		pool.Submit(&signMultiKeyStressRequest{
+			stressRequest: stressRequest{
+				userName:     fmt.Sprintf(userNameTplt, i),
+				keyServerURL: s.bddContext.KeyServerURL,
+				keyType:      keyType,
+				steps:        s,
+				signRequests: signTimes,
+			},
+			keyCount: keyCount,
+		})
+	}
+
+	pool.Stop()
+
+	if len(pool.Responses()) != totalRequests {
+		return fmt.Errorf("expecting %d responses but got %d", totalRequests, len(pool.Responses()))
+	}
+
+	var sequentialTime, multiKeyTime []int64
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return resp.Err
+		}
+
+		perfInfo, ok := resp.Resp.(signMultiKeyPerfInfo)
+		if !ok {
+			return fmt.Errorf("invalid signMultiKeyPerfInfo response")
+		}
+
+		sequentialTime = append(sequentialTime, perfInfo.sequentialTime...)
+		multiKeyTime = append(multiKeyTime, perfInfo.multiKeyTime...)
+	}
+
+	printLatency(fmt.Sprintf("%d sequential signs", keyCount), sequentialTime, time.Microsecond)
+	printLatency(fmt.Sprintf("signmulti with %d keys", keyCount), multiKeyTime, time.Microsecond)
+
+	sequentialMean := calculator.NewInt64(sequentialTime).Mean().Register.Mean
+	multiKeyMean := calculator.NewInt64(multiKeyTime).Mean().Register.Mean
+
+	if multiKeyMean > 0 {
+		fmt.Printf("signmulti is %.1f times as fast as %d sequential signs\n", sequentialMean/multiKeyMean, keyCount)
+	}
+
+	return nil
+}
+
+type signMultiKeyStressRequest struct {
+	stressRequest
+	keyCount int
+}
+
+type signMultiKeyPerfInfo struct {
+	sequentialTime []int64 // microseconds per round of sign requests, one per key
+	multiKeyTime   []int64 // microseconds per multi-key sign request
+}
+
+func (r *signMultiKeyStressRequest) Invoke() (interface{}, error) {
+	u := r.steps.users[r.userName]
+
+	if err := r.createKeystore(u, &createKeystoreReq{Controller: u.controller}); err != nil {
+		return nil, fmt.Errorf("create keystore %w", err)
+	}
+
+	keyIDs := make([]string, r.keyCount)
+	messages := make([][]byte, r.keyCount)
+
+	for i := range keyIDs {
+		if err := r.steps.makeCreateKeyReq(r.userName, r.keyServerURL+keysEndpoint, r.keyType); err != nil {
+			return nil, fmt.Errorf("create key %w", err)
+		}
+
+		keyIDs[i] = u.keyID
+		messages[i] = []byte(randomMessage(1024)) //nolint:gomnd
+	}
+
+	var perfInfo signMultiKeyPerfInfo
+
+	for round := 0; round < r.signRequests; round++ {
+		startTime := time.Now()
+
+		for i, keyID := range keyIDs {
+			u.keyID = keyID
+
+			if err := r.steps.makeSignMessageReq(r.userName, r.keyServerURL+signEndpoint,
+				string(messages[i])); err != nil {
+				return nil, fmt.Errorf("sign %w", err)
+			}
+		}
+
+		perfInfo.sequentialTime = append(perfInfo.sequentialTime, time.Since(startTime).Microseconds())
+
+		startTime = time.Now()
+
+		if err := r.steps.signMultiKey(r.userName, r.keyServerURL+signMultiKeyEndpoint, keyIDs,
+			messages); err != nil {
+			return nil, fmt.Errorf("signmulti %w", err)
+		}
+
+		perfInfo.multiKeyTime = append(perfInfo.multiKeyTime, time.Since(startTime).Microseconds())
+	}
+
+	return perfInfo, nil
+}
+
+// wrapStressTestForMultipleUsers runs the stress test with a CEK wrapped for the user's own key and unwrapped again
+// instead of signing and verifying, so that wrap and unwrap latency is measured the same way.
+func (s *Steps) wrapStressTestForMultipleUsers(
+	totalRequestsEnv, keyType string, wrapTimes int, concurrencyEnv string) error {
+	totalRequests, err := getUsersNumber(totalRequestsEnv)
+	if err != nil {
+		return err
+	}
+
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	if wrapTimes <= 0 {
+		return fmt.Errorf("invalid wrap times: %d", wrapTimes)
+	}
+
+	fmt.Printf("totalRequests: %d, concurrencyReq: %d", totalRequests, concurrencyReq)
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for i := 0; i < totalRequests; i++ {
+		pool.Submit(&wrapStressRequest{
+			stressRequest: stressRequest{
+				userName:     fmt.Sprintf(userNameTplt, i),
+				keyServerURL: s.bddContext.KeyServerURL,
+				keyType:      keyType,
+				steps:        s,
+			},
+			wrapRequests: wrapTimes,
+		})
+	}
+
+	pool.Stop()
+
+	if len(pool.Responses()) != totalRequests {
+		return fmt.Errorf("expecting %d responses but got %d", totalRequests, len(pool.Responses()))
+	}
+
+	var (
+		createKeyStoreHTTPTime []int64
+		createKeyHTTPTime      []int64
+		wrapHTTPTime           []int64
+		unwrapHTTPTime         []int64
+	)
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return resp.Err
+		}
+
+		perfInfo, ok := resp.Resp.(wrapRequestPerfInfo)
+		if !ok {
+			return fmt.Errorf("invalid wrapRequestPerfInfo response")
+		}
+
+		createKeyStoreHTTPTime = append(createKeyStoreHTTPTime, perfInfo.createKeyStoreHTTPTime)
+		createKeyHTTPTime = append(createKeyHTTPTime, perfInfo.createKeyHTTPTime)
+		wrapHTTPTime = append(wrapHTTPTime, perfInfo.wrapHTTPTime)
+		unwrapHTTPTime = append(unwrapHTTPTime, perfInfo.unwrapHTTPTime)
+	}
+
+	printLatency("create key store", createKeyStoreHTTPTime, time.Millisecond)
+	printLatency("create key", createKeyHTTPTime, time.Millisecond)
+	printLatency("wrap", wrapHTTPTime, time.Microsecond)
+	printLatency("unwrap", unwrapHTTPTime, time.Microsecond)
+
+	return nil
+}
+
+// printLatency prints the mean, max and min of latencies measured in the unit.
+func printLatency(operation string, latencies []int64, unit time.Duration) {
+	calc := calculator.NewInt64(latencies)
+	fmt.Printf("%s avg time: %s\n", operation, (time.Duration(calc.Mean().Register.Mean) * unit).String())
+	fmt.Printf("%s max time: %s\n", operation, (time.Duration(calc.Max().Register.MaxValue) * unit).String())
+	fmt.Printf("%s min time: %s\n", operation, (time.Duration(calc.Min().Register.MinValue) * unit).String())
+	fmt.Println("------")
+}
+
+type wrapStressRequest struct {
+	stressRequest
+	wrapRequests int
+}
+
+type wrapRequestPerfInfo struct {
+	createKeyStoreHTTPTime int64
+	createKeyHTTPTime      int64
+	wrapHTTPTime           int64 // microseconds per wrap request
+	unwrapHTTPTime         int64 // microseconds per unwrap request
+}
+
+func (r *wrapStressRequest) Invoke() (interface{}, error) {
+	u := r.steps.users[r.userName]
+
+	perfInfo := wrapRequestPerfInfo{}
+
+	startTime := time.Now()
+
+	if err := r.createKeystore(u, &createKeystoreReq{Controller: u.controller}); err != nil {
+		return nil, fmt.Errorf("create keystore %w", err)
+	}
+
+	perfInfo.createKeyStoreHTTPTime = time.Since(startTime).Milliseconds()
+
+	startTime = time.Now()
+
+	if err := r.steps.makeCreateKeyReq(r.userName, r.keyServerURL+keysEndpoint, r.keyType); err != nil {
+		return nil, fmt.Errorf("create key %w", err)
+	}
+
+	perfInfo.createKeyHTTPTime = time.Since(startTime).Milliseconds()
+
+	// the CEK is wrapped for the user's own key, so that the user can unwrap it
+	if err := r.steps.makeExportPubKeyReq(r.userName, r.keyServerURL+exportKeyEndpoint); err != nil {
+		return nil, fmt.Errorf("export public key %w", err)
+	}
+
+	rawBytes := []byte(u.data["public_key"])
+
+	pubKey, ok := parsePublicKey(rawBytes)
+	if !ok {
+		return nil, fmt.Errorf("%s key can't be used for key wrapping", r.keyType)
+	}
+
+	u.recipientPubKeys = map[string]*publicKeyData{r.userName: {rawBytes: rawBytes, parsedKey: pubKey}}
+
+	startTime = time.Now()
+
+	for i := 0; i < r.wrapRequests; i++ {
+		if err := r.steps.makeWrapKeyReq(r.userName, r.keyServerURL+wrapEndpoint, "testCEK", r.userName); err != nil {
+			return nil, fmt.Errorf("wrap %w", err)
+		}
+	}
+
+	perfInfo.wrapHTTPTime = time.Since(startTime).Microseconds() / int64(r.wrapRequests)
+
+	wrappedKey := u.data["wrapped_key"]
+
+	startTime = time.Now()
+
+	for i := 0; i < r.wrapRequests; i++ {
+		// unwrap replaces the user's data with the unwrapped key
+		u.data = map[string]string{"wrapped_key": wrappedKey}
+
+		if err := r.steps.makeUnwrapKeyReq(r.userName, r.keyServerURL+unwrapEndpoint, "wrapped_key",
+			r.userName); err != nil {
+			return nil, fmt.Errorf("unwrap %w", err)
+		}
+	}
+
+	perfInfo.unwrapHTTPTime = time.Since(startTime).Microseconds() / int64(r.wrapRequests)
+
+	if u.data["key"] != string(r.steps.keys["testCEK"]) {
+		return nil, errors.New("unwrapped key doesn't match the wrapped CEK")
+	}
+
+	return perfInfo, nil
+}
+
+// cryptoBoxStressTestForUserPairs pairs the users created for the stress test. In each pair, both users create a
+// keystore with an ED25519 key, the first user seals the payload for the second one with easy and the second user
+// opens it with easyOpen.
+func (s *Steps) cryptoBoxStressTestForUserPairs(usersNumberEnv, payload, concurrencyEnv string) error {
+	usersNumber, err := getUsersNumber(usersNumberEnv)
+	if err != nil {
+		return err
+	}
+
+	if usersNumber < 2 { //nolint:gomnd
+		return fmt.Errorf("at least 2 users are needed to exchange a payload, got %d", usersNumber)
+	}
+
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	pairs := usersNumber / 2 //nolint:gomnd
+
+	for i := 0; i < pairs; i++ {
+		pool.Submit(&cryptoBoxStressRequest{
+			stressRequest: stressRequest{
+				userName:     fmt.Sprintf(userNameTplt, 2*i),
+				keyServerURL: s.bddContext.KeyServerURL,
+				keyType:      "ED25519",
+				steps:        s,
+			},
+			recipientName: fmt.Sprintf(userNameTplt, 2*i+1),
+			payload:       payload,
+		})
+	}
+
+	pool.Stop()
+
+	if len(pool.Responses()) != pairs {
+		return fmt.Errorf("expecting %d responses but got %d", pairs, len(pool.Responses()))
+	}
+
+	var easyHTTPTime, easyOpenHTTPTime []int64
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return resp.Err
+		}
+
+		perfInfo, ok := resp.Resp.(cryptoBoxRequestPerfInfo)
+		if !ok {
+			return fmt.Errorf("invalid cryptoBoxRequestPerfInfo response")
+		}
+
+		easyHTTPTime = append(easyHTTPTime, perfInfo.easyHTTPTime)
+		easyOpenHTTPTime = append(easyOpenHTTPTime, perfInfo.easyOpenHTTPTime)
+	}
+
+	printLatency("easy", easyHTTPTime, time.Millisecond)
+	printLatency("easy open", easyOpenHTTPTime, time.Millisecond)
+
+	return nil
+}
+
+// cryptoBoxStressRequest is a request of the user, the sender, to exchange a payload with the recipient.
+type cryptoBoxStressRequest struct {
+	stressRequest
+	recipientName string
+	payload       string
+}
+
+type cryptoBoxRequestPerfInfo struct {
+	easyHTTPTime     int64
+	easyOpenHTTPTime int64
+}
+
+func (r *cryptoBoxStressRequest) Invoke() (interface{}, error) {
+	sender := r.steps.users[r.userName]
+	recipient := r.steps.users[r.recipientName]
+
+	senderPub, err := r.createKey(sender)
+	if err != nil {
+		return nil, err
+	}
+
+	recipientPub, err := r.createKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// users of the stress test have no capabilities to delegate, so they exchange the keys they exported themselves
+	sender.recipientPubKeys = map[string]*publicKeyData{r.recipientName: {rawBytes: recipientPub}}
+	recipient.recipientPubKeys = map[string]*publicKeyData{r.userName: {rawBytes: senderPub}}
+
+	perfInfo := cryptoBoxRequestPerfInfo{}
+
+	startTime := time.Now()
+
+	if err = r.steps.makeEasyPayloadReq(r.userName, r.keyServerURL+easyEndpoint, r.payload,
+		r.recipientName); err != nil {
+		return nil, fmt.Errorf("easy %w", err)
+	}
+
+	perfInfo.easyHTTPTime = time.Since(startTime).Milliseconds()
+
+	startTime = time.Now()
+
+	if err = r.steps.makeEasyOpenReq(r.recipientName, r.keyServerURL+easyOpenEndpoint, "ciphertext",
+		r.userName); err != nil {
+		return nil, fmt.Errorf("easy open %w", err)
+	}
+
+	perfInfo.easyOpenHTTPTime = time.Since(startTime).Milliseconds()
+
+	if recipient.data["plaintext"] != r.payload {
+		return nil, fmt.Errorf("%s opened %q instead of the payload sealed by %s", r.recipientName,
+			recipient.data["plaintext"], r.userName)
+	}
+
+	return perfInfo, nil
+}
+
+// createKey creates a keystore with a key for the user and returns the public key.
+func (r *cryptoBoxStressRequest) createKey(u *user) ([]byte, error) {
+	if err := r.createKeystore(u, &createKeystoreReq{Controller: u.controller}); err != nil {
+		return nil, fmt.Errorf("create keystore %w", err)
+	}
+
+	if err := r.steps.makeCreateKeyReq(u.name, r.keyServerURL+keysEndpoint, r.keyType); err != nil {
+		return nil, fmt.Errorf("create key %w", err)
+	}
+
+	if err := r.steps.makeExportPubKeyReq(u.name, r.keyServerURL+exportKeyEndpoint); err != nil {
+		return nil, fmt.Errorf("export public key %w", err)
+	}
+
+	return []byte(u.data["public_key"]), nil
+}
+
+// mixedCostStressTest measures the p95 latency of signing with cheap keys, first alone and then while as many users
+// sign with expensive keys, and fails if the latency grows more than maxGrowth times. Key Server is expected to run
+// with crypto worker pools enabled; expensive requests it rejects with 429 are counted, not failed.
+func (s *Steps) mixedCostStressTest(usersNumberEnv string, signTimes int, cheapKeyType, expensiveKeyType string,
+	maxGrowth float64, concurrencyEnv string) error {
+	usersNumber, err := getUsersNumber(usersNumberEnv)
+	if err != nil {
+		return err
+	}
+
+	if usersNumber < 2 { //nolint:gomnd
+		return fmt.Errorf("at least 2 users are needed to mix key types, got %d", usersNumber)
+	}
+
+	concurrencyReq, err := getConcurrencyReq(concurrencyEnv)
+	if err != nil {
+		return err
+	}
+
+	if signTimes <= 0 {
+		return fmt.Errorf("invalid sign times: %d", signTimes)
+	}
+
+	var cheap, expensive []stressRequest
+
+	for i := 0; i < usersNumber; i++ {
+		r := stressRequest{
+			userName:     fmt.Sprintf(userNameTplt, i),
+			keyServerURL: s.bddContext.KeyServerURL,
+			keyType:      cheapKeyType,
+			steps:        s,
+			signRequests: signTimes,
+		}
+
+		if i%2 == 1 {
+			r.keyType = expensiveKeyType
+			expensive = append(expensive, r)
+		} else {
+			cheap = append(cheap, r)
+		}
+	}
+
+	// keystores and keys are created up front, so that only signing is measured
+	created, _, err := s.runMixedCostRequests(concurrencyReq, append(cheap, expensive...), true)
+	if err != nil {
+		return err
+	}
+
+	// creating keys is timed per key type, e.g. RSA key generation is much slower than that of EC keys
+	printLatency(cheapKeyType+" create key", created[cheapKeyType], time.Microsecond)
+	printLatency(expensiveKeyType+" create key", created[expensiveKeyType], time.Microsecond)
+
+	baseline, _, err := s.runMixedCostRequests(concurrencyReq, cheap, false)
+	if err != nil {
+		return err
+	}
+
+	mixed, rejected, err := s.runMixedCostRequests(concurrencyReq, append(cheap, expensive...), false)
+	if err != nil {
+		return err
+	}
+
+	baselineP95 := percentile(baseline[cheapKeyType], 0.95) //nolint:gomnd
+	mixedP95 := percentile(mixed[cheapKeyType], 0.95)       //nolint:gomnd
+
+	printLatency(cheapKeyType+" sign alone", baseline[cheapKeyType], time.Microsecond)
+	printLatency(cheapKeyType+" sign mixed", mixed[cheapKeyType], time.Microsecond)
+	printLatency(expensiveKeyType+" sign mixed", mixed[expensiveKeyType], time.Microsecond)
+
+	fmt.Printf("%s sign p95: %s alone, %s mixed; %d %s sign requests rejected with 429\n", cheapKeyType,
+		time.Duration(baselineP95)*time.Microsecond, time.Duration(mixedP95)*time.Microsecond, rejected,
+		expensiveKeyType)
+
+	if float64(mixedP95) > maxGrowth*float64(baselineP95) {
+		return fmt.Errorf("%s sign p95 grew from %s to %s under %s load, more than %.1f times", cheapKeyType,
+			time.Duration(baselineP95)*time.Microsecond, time.Duration(mixedP95)*time.Microsecond, expensiveKeyType,
+			maxGrowth)
+	}
+
+	return nil
+}
+
+// runMixedCostRequests runs the requests concurrently and returns sign latencies (in microseconds) per key type and
+// the number of sign requests rejected with 429. With setup, the requests create keystores and keys instead, and the
+// latencies are of creating keys.
+func (s *Steps) runMixedCostRequests(concurrencyReq int, requests []stressRequest,
+	setup bool) (map[string][]int64, int, error) {
+	pool := bddutil.NewWorkerPool(concurrencyReq, s.logger)
+
+	pool.Start()
+
+	for i := range requests {
+		pool.Submit(&mixedCostStressRequest{stressRequest: requests[i], setup: setup})
+	}
+
+	pool.Stop()
+
+	if len(pool.Responses()) != len(requests) {
+		return nil, 0, fmt.Errorf("expecting %d responses but got %d", len(requests), len(pool.Responses()))
+	}
+
+	latencies := make(map[string][]int64)
+
+	var rejected int
+
+	for _, resp := range pool.Responses() {
+		if resp.Err != nil {
+			return nil, 0, resp.Err
+		}
+
+		perfInfo, ok := resp.Resp.(mixedCostRequestPerfInfo)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid mixedCostRequestPerfInfo response")
+		}
+
+		latencies[perfInfo.keyType] = append(latencies[perfInfo.keyType], perfInfo.httpTime...)
+		rejected += perfInfo.rejected
+	}
+
+	return latencies, rejected, nil
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of the values using the nearest-rank method.
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(p*float64(len(sorted))+0.5) - 1 //nolint:gomnd
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+type mixedCostStressRequest struct {
+	stressRequest
+	setup bool
+}
+
+type mixedCostRequestPerfInfo struct {
+	keyType  string
+	httpTime []int64 // microseconds per sign request, or of the create key request in setup
+	rejected int     // sign requests rejected with 429
+}
+
+func (r *mixedCostStressRequest) Invoke() (interface{}, error) {
+	u := r.steps.users[r.userName]
+
+	perfInfo := mixedCostRequestPerfInfo{keyType: r.keyType}
+
+	if r.setup {
+		if err := r.createKeystore(u, &createKeystoreReq{Controller: u.controller}); err != nil {
+			return nil, fmt.Errorf("create keystore %w", err)
+		}
+
+		startTime := time.Now()
+
+		if err := r.steps.makeCreateKeyReq(r.userName, r.keyServerURL+keysEndpoint, r.keyType); err != nil {
+			return nil, fmt.Errorf("create key %w", err)
+		}
+
+		perfInfo.httpTime = append(perfInfo.httpTime, time.Since(startTime).Microseconds())
+
+		return perfInfo, nil
+	}
+
+	message := randomMessage(1024) //nolint:gomnd
+
+	for i := 0; i < r.signRequests; i++ {
+		startTime := time.Now()
+
+		var err error
+
+		// BBS+ keys sign messages, signing a single message with them isn't supported
+		if r.keyType == "BLS12381G2" {
+			err = r.steps.makeSignMessagesReq(r.userName, r.keyServerURL+signEndpoint, "10")
+		} else {
+			err = r.steps.makeSignMessageReq(r.userName, r.keyServerURL+signEndpoint, message)
+		}
+
+		if err != nil {
+			if u.response != nil && u.response.statusCode == http.StatusTooManyRequests {
+				perfInfo.rejected++
+
+				continue
+			}
+
+			return nil, fmt.Errorf("sign %w", err)
+		}
+
+		perfInfo.httpTime = append(perfInfo.httpTime, time.Since(startTime).Microseconds())
+	}
+
+	return perfInfo, nil
+}
+
+var errLoadShed = errors.New("request shed by server")
+
+// overloadRequest is a stressRequest that treats 503 responses as shed requests rather than failures.
+type overloadRequest struct {
+	stressRequest
+}
+
+func (r *overloadRequest) Invoke() (interface{}, error) {
+	resp, err := r.stressRequest.Invoke()
+	if err != nil {
+		u := r.steps.users[r.userName]
+
+		if u.response != nil && u.response.statusCode == http.StatusServiceUnavailable {
+			return nil, errLoadShed
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
 type authStressRequest struct {
 	userName string
 	steps    *Steps
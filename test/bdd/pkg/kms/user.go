@@ -8,13 +8,11 @@ package kms
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"strings"
 
@@ -23,9 +21,14 @@ import (
 	"github.com/igor-pavlenko/httpsignatures-go"
 	"github.com/trustbloc/edge-core/pkg/zcapld"
 
+	"github.com/trustbloc/kms/pkg/gziplimit"
 	zcapld2 "github.com/trustbloc/kms/pkg/zcapld"
 )
 
+// authUserHeader carries the OAuth subject of a bearer token. The proxy that introspects tokens sets it; users of
+// keystores without ZCAPs set it too, for Key Servers that are reached without the proxy (e.g. in stress tests).
+const authUserHeader = "Auth-User"
+
 type user struct {
 	name       string
 	controller string
@@ -50,6 +53,9 @@ type user struct {
 	kmsCapability *zcapld.Capability
 	disableZCAP   bool
 	accessToken   string
+
+	// session records requests of the user if set, see stress_recording.go
+	session *stressSession
 }
 
 type publicKeyData struct {
@@ -64,11 +70,20 @@ type response struct {
 }
 
 func (u *user) SetCapabilityInvocation(r *http.Request, action string) error {
+	return u.invokeCapability(r, u.kmsCapability, action)
+}
+
+// invokeCapability sets the capability invocation header for the given capability, e.g. a root capability of
+// a previously created keystore.
+func (u *user) invokeCapability(r *http.Request, capability *zcapld.Capability, action string) error {
 	if u.disableZCAP {
+		// keystores without ZCAPs are authorized by the OAuth subject that created them
+		u.setBearerToken(r)
+
 		return nil
 	}
 
-	compressed, err := zcapld2.CompressZCAP(u.kmsCapability)
+	compressed, err := zcapld2.CompressZCAP(capability)
 	if err != nil {
 		return fmt.Errorf("failed to compress zcap: %w", err)
 	}
@@ -82,6 +97,15 @@ func (u *user) SetCapabilityInvocation(r *http.Request, action string) error {
 	return nil
 }
 
+// setBearerToken sets the access token and the OAuth subject of the user.
+func (u *user) setBearerToken(r *http.Request) {
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", u.accessToken))
+
+	if u.subject != "" {
+		r.Header.Set(authUserHeader, u.subject)
+	}
+}
+
 func (u *user) Sign(r *http.Request) error {
 	if u.disableZCAP {
 		return nil
@@ -152,7 +176,8 @@ func (u *user) processResponse(parsedResp interface{}, resp *http.Response) erro
 		statusCode: resp.StatusCode,
 	}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated &&
+		resp.StatusCode != http.StatusNoContent {
 		var errResp errorResponse
 
 		respBody, er := io.ReadAll(resp.Body)
@@ -168,6 +193,14 @@ func (u *user) processResponse(parsedResp interface{}, resp *http.Response) erro
 			"errMessage": errResp.Message,
 		}
 
+		if errResp.KeyURL != "" {
+			u.data["key_url"] = errResp.KeyURL
+		}
+
+		if errResp.Code != "" {
+			u.data["code"] = errResp.Code
+		}
+
 		return fmt.Errorf("response status: %s", resp.Status)
 	}
 
@@ -184,19 +217,9 @@ func (u *user) processResponse(parsedResp interface{}, resp *http.Response) erro
 }
 
 func parseRootCapability(zcap []byte) (*zcapld.Capability, error) {
-	compressed, err := gzip.NewReader(bytes.NewReader(zcap))
-	if err != nil {
-		return nil, fmt.Errorf("failed to open gzip reader: %w", err)
-	}
-
-	uncompressed, err := ioutil.ReadAll(compressed)
-	if err != nil {
-		return nil, fmt.Errorf("failed to gunzip zcap: %w", err)
-	}
-
-	capability, err := zcapld.ParseCapability(uncompressed)
+	capability, err := zcapld2.DecompressZCAP(zcap, gziplimit.DefaultMaxSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse rootcapability: %w", err)
+		return nil, fmt.Errorf("failed to decompress root capability: %w", err)
 	}
 
 	return capability, nil
@@ -19,18 +19,36 @@ const (
 )
 
 const (
-	actionCreateKey  = "createKey"
-	actionExportKey  = "exportKey"
-	actionImportKey  = "importKey"
-	actionRotateKey  = "rotateKey"
-	actionSign       = "sign"
-	actionVerify     = "verify"
-	actionWrap       = "wrap"
-	actionUnwrap     = "unwrap"
-	actionComputeMac = "computeMAC"
-	actionVerifyMAC  = "verifyMAC"
-	actionEncrypt    = "encrypt"
-	actionDecrypt    = "decrypt"
+	actionGetKeyStore  = "getKeyStore"
+	actionGetKey       = "getKey"
+	actionCreateKey    = "createKey"
+	actionCreateKeys   = "createKeys"
+	actionExportKey    = "exportKey"
+	actionImportKey    = "importKey"
+	actionRotateKey    = "rotateKey"
+	actionUpdateKey    = "updateKey"
+	actionSetKeyState  = "setKeyState"
+	actionCreateToken  = "createToken"
+	actionInvitation   = "createInvitation"
+	actionSign         = "sign"
+	actionSignBatch    = "signBatch"
+	actionSignMultiKey = "signMultiKey"
+	actionSignJWT      = "signJWT"
+	actionVerify       = "verify"
+	actionDeriveProof  = "deriveProof"
+	actionVerifyProof  = "verifyProof"
+	actionWrap         = "wrap"
+	actionUnwrap       = "unwrap"
+	actionEncryptJWE   = "encryptJWE"
+	actionDecryptJWE   = "decryptJWE"
+	actionDeriveKey    = "deriveKey"
+	actionEasy         = "easy"
+	actionEasyOpen     = "easyOpen"
+	actionSealOpen     = "sealOpen"
+	actionComputeMac   = "computeMAC"
+	actionVerifyMAC    = "verifyMAC"
+	actionEncrypt      = "encrypt"
+	actionDecrypt      = "decrypt"
 )
 
 type signer interface {